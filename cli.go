@@ -0,0 +1,300 @@
+package main
+
+import (
+	"bufio"
+	stderr "errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"runtime/debug"
+	"strconv"
+	"strings"
+
+	"github.com/Station-Manager/server/service"
+)
+
+// version is set at build time with -ldflags "-X main.version=...". Without it the module version
+// recorded by the Go toolchain is reported.
+var version = ""
+
+// Subcommands of the server binary. serve is the default when none is given.
+const (
+	cmdServe          = "serve"
+	cmdMigrateUp      = "migrate up"
+	cmdMigrateDown    = "migrate down"
+	cmdMigrateStatus  = "migrate status"
+	cmdVersion        = "version"
+	cmdHealthcheck    = "healthcheck"
+	cmdConfigValidate = "config validate"
+	cmdUserCreate     = "user create"
+	cmdUserPassword   = "user set-password"
+	cmdUserVerify     = "user verify-email"
+	cmdApiKeyRevoke   = "apikey revoke"
+)
+
+const configFileName = "config.json"
+
+// Exit statuses of the server binary. Those of startup failures follow sysexits.h, so that systemd
+// units and scripts can tell a configuration to fix from a database to wait for.
+const (
+	exitOK       = 0
+	exitError    = 1
+	exitUsage    = 2
+	exitDatabase = 69 // EX_UNAVAILABLE
+	exitRuntime  = 70 // EX_SOFTWARE
+	exitBind     = 71 // EX_OSERR
+	exitConfig   = 78 // EX_CONFIG
+)
+
+// startupExitStatuses are the exit statuses of the server's startup failures.
+var startupExitStatuses = map[service.StartupFailure]int{
+	service.StartupFailureConfig:   exitConfig,
+	service.StartupFailureDatabase: exitDatabase,
+	service.StartupFailureBind:     exitBind,
+}
+
+const usageText = `Usage: server [command] [flags]
+
+Commands:
+  serve            start the server (default)
+  migrate up       apply the database migrations and exit (also: migrate)
+  migrate down N   revert the last N server migrations and exit
+  migrate status   list the database migrations and exit
+  version          print the version and exit
+  healthcheck      exit 0 if the server on this host is ready
+  config validate  load and check the configuration and exit
+  user create CALLSIGN -email ADDRESS
+                   add a user; the password is read from standard input
+  user set-password CALLSIGN
+                   replace a user's password with one read from standard input
+  user verify-email CALLSIGN
+                   mark a user's email address as verified
+  apikey revoke PREFIX
+                   revoke the API key with the prefix
+
+Flags:
+`
+
+// command is a parsed command line.
+type command struct {
+	name string
+	opts service.Options
+	// steps is the number of migrations migrate down reverts.
+	steps int
+	// migrateOnly makes serve apply the migrations and exit.
+	migrateOnly bool
+	// target is the callsign or API key prefix the user and apikey commands act on.
+	target string
+	// email and verified are the new user's address and whether it is already verified.
+	email    string
+	verified bool
+}
+
+// parseCommand splits the command line into a subcommand and the options given by its flags.
+func parseCommand(args []string, output io.Writer) (command, error) {
+	cmd := command{name: cmdServe}
+
+	if len(args) > 0 && len(args[0]) > 0 && args[0][0] != '-' {
+		cmd.name, args = args[0], args[1:]
+		switch cmd.name {
+		case "config":
+			if len(args) == 0 || args[0] != "validate" {
+				return command{}, fmt.Errorf("unknown command: config %s", firstArg(args))
+			}
+			cmd.name, args = cmdConfigValidate, args[1:]
+		case "migrate":
+			sub := "up"
+			if len(args) > 0 && len(args[0]) > 0 && args[0][0] != '-' {
+				sub, args = args[0], args[1:]
+			}
+			switch sub {
+			case "up":
+				cmd.name = cmdMigrateUp
+			case "status":
+				cmd.name = cmdMigrateStatus
+			case "down":
+				n, err := strconv.Atoi(firstArg(args))
+				if err != nil || n < 1 {
+					return command{}, fmt.Errorf("migrate down needs a positive number of migrations to revert")
+				}
+				cmd.name, cmd.steps, args = cmdMigrateDown, n, args[1:]
+			default:
+				return command{}, fmt.Errorf("unknown command: migrate %s", sub)
+			}
+		case "user", "apikey":
+			full := cmd.name + " " + firstArg(args)
+			switch full {
+			case cmdUserCreate, cmdUserPassword, cmdUserVerify, cmdApiKeyRevoke:
+			default:
+				return command{}, fmt.Errorf("unknown command: %s", full)
+			}
+			args = args[min(len(args), 1):]
+			if len(args) == 0 || len(args[0]) == 0 || args[0][0] == '-' {
+				if cmd.name == "apikey" {
+					return command{}, fmt.Errorf("%s needs the prefix of the API key", full)
+				}
+				return command{}, fmt.Errorf("%s needs the callsign of the user", full)
+			}
+			cmd.name, cmd.target, args = full, args[0], args[1:]
+		}
+	}
+	switch cmd.name {
+	case cmdServe, cmdMigrateUp, cmdMigrateDown, cmdMigrateStatus, cmdVersion, cmdHealthcheck, cmdConfigValidate,
+		cmdUserCreate, cmdUserPassword, cmdUserVerify, cmdApiKeyRevoke:
+	case "help":
+		args = []string{"-h"}
+	default:
+		return command{}, fmt.Errorf("unknown command: %s", cmd.name)
+	}
+
+	fs := flag.NewFlagSet("server", flag.ContinueOnError)
+	fs.SetOutput(output)
+	fs.Usage = func() {
+		_, _ = fmt.Fprint(output, usageText)
+		fs.PrintDefaults()
+	}
+	configPath := fs.String("config", emptyString, "path to config.json or the directory holding it (default: the current directory)")
+	fs.IntVar(&cmd.opts.Port, "port", 0, "listen on this port instead of the configured one")
+	fs.StringVar(&cmd.opts.Driver, "db-driver", emptyString, "use this database driver (postgres or sqlite) instead of the configured one")
+	fs.StringVar(&cmd.opts.LogLevel, "log-level", emptyString, "log at this level instead of the configured one (trace, debug, info, warn, error)")
+	fs.BoolVar(&cmd.migrateOnly, "migrate-only", false, "apply the database migrations and exit instead of serving (same as migrate up)")
+	fs.BoolVar(&cmd.opts.SkipMigrations, "skip-migrations", false, "do not migrate the database on start; refuse to serve unless it is up to date")
+	fs.StringVar(&cmd.email, "email", emptyString, "the new user's email address (user create)")
+	fs.BoolVar(&cmd.verified, "verified", false, "mark the new user's email address as verified (user create)")
+	if err := fs.Parse(args); err != nil {
+		return command{}, err
+	}
+	if fs.NArg() > 0 {
+		return command{}, fmt.Errorf("unexpected argument: %s", fs.Arg(0))
+	}
+	if cmd.migrateOnly {
+		if cmd.name != cmdServe {
+			return command{}, fmt.Errorf("-migrate-only applies only to serve")
+		}
+		if cmd.opts.SkipMigrations {
+			return command{}, fmt.Errorf("-migrate-only and -skip-migrations cannot be combined")
+		}
+		cmd.name = cmdMigrateUp
+	}
+	if cmd.name == cmdUserCreate && cmd.email == emptyString {
+		return command{}, fmt.Errorf("user create needs -email")
+	}
+	if cmd.name != cmdUserCreate && (cmd.email != emptyString || cmd.verified) {
+		return command{}, fmt.Errorf("-email and -verified apply only to user create")
+	}
+
+	dir, err := configDir(*configPath)
+	if err != nil {
+		return command{}, err
+	}
+	cmd.opts.ConfigDir = dir
+
+	return cmd, nil
+}
+
+// configDir returns the directory holding the configuration file named by path, which may be the
+// file itself or its directory.
+func configDir(path string) (string, error) {
+	if path == emptyString {
+		return emptyString, nil
+	}
+	if filepath.Base(path) == configFileName {
+		return filepath.Dir(path), nil
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return emptyString, err
+	}
+	if !info.IsDir() {
+		return emptyString, fmt.Errorf("the configuration file must be named %s: %s", configFileName, path)
+	}
+	return path, nil
+}
+
+// versionString describes the build.
+func versionString() string {
+	v := version
+	if v == emptyString {
+		v = "(devel)"
+		if info, ok := debug.ReadBuildInfo(); ok && info.Main.Version != emptyString {
+			v = info.Main.Version
+		}
+	}
+	return fmt.Sprintf("server %s (%s %s/%s)", v, runtime.Version(), runtime.GOOS, runtime.GOARCH)
+}
+
+// describeError joins the messages along an error chain, skipping repeats, so that the cause of a
+// failed subcommand is visible and not just its outermost message.
+func describeError(err error) string {
+	var messages []string
+	for ; err != nil; err = stderr.Unwrap(err) {
+		msg := err.Error()
+		if msg == emptyString || (len(messages) > 0 && strings.Contains(messages[len(messages)-1], msg)) {
+			continue
+		}
+		messages = append(messages, msg)
+	}
+	return strings.Join(messages, ": ")
+}
+
+// exitStatusOf returns the exit status of a command that failed with err: that of its startup
+// failure if the service could not start, and exitError otherwise.
+func exitStatusOf(err error) int {
+	var startup *service.StartupError
+	if stderr.As(err, &startup) {
+		if status, ok := startupExitStatuses[startup.Failure]; ok {
+			return status
+		}
+	}
+	return exitError
+}
+
+// reportStartupError writes a summary of why the server could not start, followed by its detail,
+// and returns the status to exit with. The service has already logged the error in full.
+func reportStartupError(w io.Writer, err error) int {
+	var startup *service.StartupError
+	if stderr.As(err, &startup) {
+		_, _ = fmt.Fprintf(w, "server cannot start: %s\n  %s\n", startup.Summary(), describeError(startup.Err))
+	} else {
+		_, _ = fmt.Fprintf(w, "server failed: %s\n", describeError(err))
+	}
+	return exitStatusOf(err)
+}
+
+// reportRuntimeError writes why the server stopped serving after it had started, and returns the
+// status to exit with.
+func reportRuntimeError(w io.Writer, err error) int {
+	_, _ = fmt.Fprintf(w, "server stopped: %s\n", describeError(err))
+	return exitRuntime
+}
+
+// reportShutdownError writes why the server could not shut down cleanly, and returns the status to
+// exit with.
+func reportShutdownError(w io.Writer, err error) int {
+	_, _ = fmt.Fprintf(w, "server shutdown failed: %s\n", describeError(err))
+	return exitError
+}
+
+// readPassword reads a password from the first line of r, so that it never appears in the command
+// line or the shell history.
+func readPassword(r io.Reader) (string, error) {
+	line, err := bufio.NewReader(r).ReadString('\n')
+	if err != nil && !stderr.Is(err, io.EOF) {
+		return emptyString, err
+	}
+	password := strings.TrimRight(line, "\r\n")
+	if password == emptyString {
+		return emptyString, fmt.Errorf("no password was given on standard input")
+	}
+	return password, nil
+}
+
+func firstArg(args []string) string {
+	if len(args) == 0 {
+		return emptyString
+	}
+	return args[0]
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/Station-Manager/server/service"
+)
+
+func TestParseCommand(t *testing.T) {
+	dir := t.TempDir()
+	cases := []struct {
+		args    []string
+		name    string
+		wantErr bool
+	}{
+		{nil, cmdServe, false},
+		{[]string{"-port", "4000"}, cmdServe, false},
+		{[]string{"serve", "-log-level", "debug"}, cmdServe, false},
+		{[]string{"migrate", "-config", dir}, cmdMigrateUp, false},
+		{[]string{"migrate", "up"}, cmdMigrateUp, false},
+		{[]string{"migrate", "status", "-db-driver", "sqlite"}, cmdMigrateStatus, false},
+		{[]string{"migrate", "down", "2"}, cmdMigrateDown, false},
+		{[]string{"-migrate-only"}, cmdMigrateUp, false},
+		{[]string{"serve", "-skip-migrations"}, cmdServe, false},
+		{[]string{"migrate", "down"}, emptyString, true},
+		{[]string{"migrate", "down", "0"}, emptyString, true},
+		{[]string{"migrate", "sideways"}, emptyString, true},
+		{[]string{"migrate", "status", "-migrate-only"}, emptyString, true},
+		{[]string{"-migrate-only", "-skip-migrations"}, emptyString, true},
+		{[]string{"config", "validate", "-config", filepath.Join(dir, configFileName)}, cmdConfigValidate, false},
+		{[]string{"version"}, cmdVersion, false},
+		{[]string{"healthcheck"}, cmdHealthcheck, false},
+		{[]string{"config"}, emptyString, true},
+		{[]string{"launch"}, emptyString, true},
+		{[]string{"serve", "extra"}, emptyString, true},
+		{[]string{"-port", "many"}, emptyString, true},
+		{[]string{"user", "create", "W1AW", "-email", "w1aw@example.com", "-verified"}, cmdUserCreate, false},
+		{[]string{"user", "set-password", "W1AW"}, cmdUserPassword, false},
+		{[]string{"user", "verify-email", "W1AW"}, cmdUserVerify, false},
+		{[]string{"apikey", "revoke", "abcdef0123"}, cmdApiKeyRevoke, false},
+		{[]string{"user", "create", "W1AW"}, emptyString, true},
+		{[]string{"user", "set-password", "-email", "w1aw@example.com"}, emptyString, true},
+		{[]string{"user", "verify-email", "W1AW", "-verified"}, emptyString, true},
+		{[]string{"user", "delete", "W1AW"}, emptyString, true},
+		{[]string{"apikey", "revoke"}, emptyString, true},
+		{[]string{"apikey"}, emptyString, true},
+	}
+	for _, c := range cases {
+		cmd, err := parseCommand(c.args, io.Discard)
+		if (err != nil) != c.wantErr || cmd.name != c.name {
+			t.Errorf("%v: got %q (err=%v), want %q (error=%v)", c.args, cmd.name, err, c.name, c.wantErr)
+		}
+	}
+
+	cmd, err := parseCommand([]string{"serve", "-config", filepath.Join(dir, configFileName), "-port", "4000", "-log-level", "warn", "-skip-migrations"}, io.Discard)
+	if err != nil || cmd.opts.ConfigDir != dir || cmd.opts.Port != 4000 || cmd.opts.LogLevel != "warn" || !cmd.opts.SkipMigrations {
+		t.Errorf("unexpected options %+v (err=%v)", cmd.opts, err)
+	}
+
+	if cmd, err = parseCommand([]string{"migrate", "down", "3", "-config", dir}, io.Discard); err != nil || cmd.steps != 3 || cmd.opts.ConfigDir != dir {
+		t.Errorf("unexpected migrate down command %+v (err=%v)", cmd, err)
+	}
+}
+
+func TestParseCommand_UserTarget(t *testing.T) {
+	cmd, err := parseCommand([]string{"user", "create", "W1AW", "-email", "w1aw@example.com"}, io.Discard)
+	if err != nil || cmd.target != "W1AW" || cmd.email != "w1aw@example.com" || cmd.verified {
+		t.Errorf("unexpected user create command %+v (err=%v)", cmd, err)
+	}
+}
+
+func TestReadPassword(t *testing.T) {
+	if got, err := readPassword(strings.NewReader("correct horse\r\nignored\n")); err != nil || got != "correct horse" {
+		t.Errorf("expected the first line, got %q (err=%v)", got, err)
+	}
+	if got, err := readPassword(strings.NewReader("no newline")); err != nil || got != "no newline" {
+		t.Errorf("expected input without a newline to be read, got %q (err=%v)", got, err)
+	}
+	if _, err := readPassword(strings.NewReader("\n")); err == nil {
+		t.Error("expected an empty password to be rejected")
+	}
+}
+
+func TestReportStartupError(t *testing.T) {
+	var out strings.Builder
+	err := fmt.Errorf("serve: %w", &service.StartupError{Failure: service.StartupFailureBind, Err: fmt.Errorf("listen tcp :443: bind: permission denied")})
+	if status := reportStartupError(&out, err); status != exitBind {
+		t.Errorf("expected exit status %d, got %d", exitBind, status)
+	}
+	if !strings.Contains(out.String(), "a listening address could not be opened") || !strings.Contains(out.String(), "permission denied") {
+		t.Errorf("expected a summary and the detail, got %q", out.String())
+	}
+
+	out.Reset()
+	if status := reportStartupError(&out, fmt.Errorf("connection reset")); status != exitError {
+		t.Errorf("expected other failures to exit %d, got %d", exitError, status)
+	}
+}
+
+func TestReportRuntimeAndShutdownErrors(t *testing.T) {
+	var out strings.Builder
+	if status := reportRuntimeError(&out, fmt.Errorf("accept tcp: too many open files")); status != exitRuntime {
+		t.Errorf("expected exit status %d, got %d", exitRuntime, status)
+	}
+	if !strings.Contains(out.String(), "server stopped") || !strings.Contains(out.String(), "too many open files") {
+		t.Errorf("expected the runtime failure to be reported, got %q", out.String())
+	}
+
+	out.Reset()
+	if status := reportShutdownError(&out, fmt.Errorf("drain: deadline exceeded")); status != exitError {
+		t.Errorf("expected exit status %d, got %d", exitError, status)
+	}
+	if !strings.Contains(out.String(), "deadline exceeded") {
+		t.Errorf("expected the shutdown failure to be reported, got %q", out.String())
+	}
+}
+
+func TestConfigDir(t *testing.T) {
+	dir := t.TempDir()
+	other := filepath.Join(dir, "server.json")
+	if err := os.WriteFile(other, []byte("{}"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	if got, err := configDir(dir); err != nil || got != dir {
+		t.Errorf("expected a directory to be used as is, got %q (err=%v)", got, err)
+	}
+	if _, err := configDir(other); err == nil {
+		t.Error("expected a file not named config.json to be rejected")
+	}
+	if _, err := configDir(filepath.Join(dir, "missing")); err == nil {
+		t.Error("expected a missing directory to be rejected")
+	}
+}
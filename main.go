@@ -2,33 +2,156 @@ package main
 
 import (
 	"context"
-	"github.com/Station-Manager/config"
-	"github.com/Station-Manager/errors"
+	stderr "errors"
+	"flag"
+	"fmt"
 	"github.com/Station-Manager/server/service"
+	"io"
 	"os"
 	"os/signal"
+	"path/filepath"
+	"strings"
 	"syscall"
+	"text/tabwriter"
+	"time"
 )
 
-func init() {
-	// Ensure the default database is set to PostgreSQL
-	if err := os.Setenv(config.EnvSmDefaultDB, "pg"); err != nil {
-		panic(err)
+const emptyString = ""
+
+// healthcheckTimeout bounds the healthcheck subcommand, which container runtimes run repeatedly.
+const healthcheckTimeout = 5 * time.Second
+
+func main() {
+	cmd, err := parseCommand(os.Args[1:], os.Stderr)
+	if stderr.Is(err, flag.ErrHelp) {
+		os.Exit(exitOK)
+	}
+	if err != nil {
+		_, _ = fmt.Fprintf(os.Stderr, "%v\n\n%s", err, usageText)
+		os.Exit(exitUsage)
+	}
+
+	switch cmd.name {
+	case cmdVersion:
+		fmt.Println(versionString())
+	case cmdServe:
+		if status := serve(cmd.opts); status != exitOK {
+			os.Exit(status)
+		}
+	default:
+		if err = runCommand(cmd); err != nil {
+			_, _ = fmt.Fprintf(os.Stderr, "%s: %s\n", cmd.name, describeError(err))
+			os.Exit(exitStatusOf(err))
+		}
 	}
 }
 
-func main() {
+// runCommand runs a subcommand that initializes the service but does not serve.
+func runCommand(cmd command) error {
+	if cmd.name == cmdConfigValidate {
+		// Loading a missing configuration would write a default one, which is not what was asked.
+		path := filepath.Join(cmd.opts.ConfigDir, configFileName)
+		if _, err := os.Stat(path); err != nil {
+			return err
+		}
+	}
+
+	// Read the password first, so that a missing one is reported before the service starts.
+	var password string
+	if cmd.name == cmdUserCreate || cmd.name == cmdUserPassword {
+		var err error
+		if password, err = readPassword(os.Stdin); err != nil {
+			return err
+		}
+	}
+
+	svc, err := service.NewServiceWithOptions(cmd.opts)
+	if err != nil {
+		return err
+	}
+
+	switch cmd.name {
+	case cmdMigrateUp:
+		if err = svc.Migrate(); err != nil {
+			return err
+		}
+		fmt.Println("Migrations applied")
+	case cmdMigrateDown:
+		if err = svc.MigrateDown(cmd.steps); err != nil {
+			return err
+		}
+		fmt.Printf("Reverted %d server migrations\n", cmd.steps)
+	case cmdMigrateStatus:
+		status, err := svc.MigrationStatus()
+		if err != nil {
+			return err
+		}
+		printSchemaStatus(os.Stdout, status)
+	case cmdHealthcheck:
+		ctx, cancel := context.WithTimeout(context.Background(), healthcheckTimeout)
+		defer cancel()
+		return svc.ProbeReadiness(ctx)
+	case cmdConfigValidate:
+		fmt.Println("Configuration is valid")
+	case cmdUserCreate:
+		if err = svc.CreateUser(cmd.target, cmd.email, password, cmd.verified); err != nil {
+			return err
+		}
+		fmt.Printf("User %s created\n", strings.ToUpper(cmd.target))
+	case cmdUserPassword:
+		if err = svc.SetUserPassword(cmd.target, password); err != nil {
+			return err
+		}
+		fmt.Printf("Password of %s changed\n", strings.ToUpper(cmd.target))
+	case cmdUserVerify:
+		if err = svc.VerifyUserEmail(cmd.target); err != nil {
+			return err
+		}
+		fmt.Printf("Email address of %s verified\n", strings.ToUpper(cmd.target))
+	case cmdApiKeyRevoke:
+		if err = svc.RevokeApiKey(cmd.target); err != nil {
+			return err
+		}
+		fmt.Printf("API key %s revoked\n", cmd.target)
+	}
+	return nil
+}
+
+// printSchemaStatus writes the state of each migration as a table.
+func printSchemaStatus(w io.Writer, status service.SchemaStatus) {
+	core := "not applied"
+	if status.CoreVersion > 0 {
+		core = fmt.Sprintf("version %d", status.CoreVersion)
+		if status.CoreDirty {
+			core += " (dirty)"
+		}
+	}
+	_, _ = fmt.Fprintf(w, "Driver: %s\nCore schema: %s\n\n", status.Driver, core)
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	_, _ = fmt.Fprintln(tw, "VERSION\tNAME\tSTATUS\tREVERSIBLE")
+	for _, m := range status.Server {
+		state, reversible := "pending", "no"
+		if m.Applied {
+			state = "applied"
+		}
+		if m.Reversible {
+			reversible = "yes"
+		}
+		_, _ = fmt.Fprintf(tw, "%d\t%s\t%s\t%s\n", m.Version, m.Name, state, reversible)
+	}
+	_ = tw.Flush()
+}
+
+// serve runs the server until it is interrupted or restarted, and returns the status to exit with.
+func serve(opts service.Options) int {
 	// Create context that will be canceled on SIGINT/SIGTERM
 	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
 	defer stop()
 
-	svc, err := service.NewService()
+	svc, err := service.NewServiceWithOptions(opts)
 	if err != nil {
-		dErr, ok := errors.AsDetailedError(err)
-		if !ok {
-			panic(err)
-		}
-		panic(dErr.Cause().Error())
+		return reportStartupError(os.Stderr, err)
 	}
 
 	// Start server in a goroutine
@@ -37,21 +160,45 @@ func main() {
 		errChan <- svc.Start()
 	}()
 
-	// Wait for interrupt signal or server error
-	select {
-	case <-ctx.Done():
-		// Signal received, initiate graceful shutdown
-		stop() // Stop receiving more signals
-		if err := svc.Shutdown(); err != nil {
-			//log.Printf("Shutdown failed: %v\n", err)
-			os.Exit(1)
-		}
-		// Wait for the Start() goroutine to complete after shutdown
-		<-errChan
-	case err := <-errChan:
-		// Server error occurred
-		if err != nil {
-			panic(err)
+	// A restart signal hands the listening sockets to a new process and then drains this one.
+	restart := make(chan os.Signal, 1)
+	if signals := service.RestartSignals(); len(signals) > 0 {
+		signal.Notify(restart, signals...)
+	}
+
+	// Wait for interrupt signal, restart signal or server error
+	for {
+		select {
+		case <-restart:
+			if err := svc.Restart(ctx); err != nil {
+				// The new process failed to start; keep serving.
+				continue
+			}
+			stop()
+			if err := svc.Shutdown(); err != nil {
+				return reportShutdownError(os.Stderr, err)
+			}
+			<-errChan
+			return exitOK
+		case <-ctx.Done():
+			// Signal received, initiate graceful shutdown
+			stop() // Stop receiving more signals
+			if service.IsPreforkChild() {
+				// The master passes the signal on too; a second one must not cut the drain short.
+				signal.Ignore(os.Interrupt, syscall.SIGTERM)
+			}
+			if err := svc.Shutdown(); err != nil {
+				return reportShutdownError(os.Stderr, err)
+			}
+			// Wait for the Start() goroutine to complete after shutdown
+			<-errChan
+			return exitOK
+		case err := <-errChan:
+			// The server failed to start, or stopped serving
+			if err != nil {
+				return reportStartupError(os.Stderr, err)
+			}
+			return exitOK
 		}
 	}
 }
@@ -0,0 +1,579 @@
+package service
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"database/sql"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Station-Manager/errors"
+	"github.com/Station-Manager/server/service/adif"
+	"github.com/Station-Manager/types"
+	"github.com/goccy/go-json"
+	"github.com/gofiber/fiber/v2"
+)
+
+const (
+	// envAccountDeletionGrace names the environment variable holding how long a requested account
+	// deletion waits before the account is purged, as a Go duration. Zero purges it at the next run.
+	envAccountDeletionGrace = "SM_ACCOUNT_DELETION_GRACE"
+
+	defaultAccountDeletionGrace = 30 * 24 * time.Hour
+	defaultAccountPurgeInterval = time.Hour
+)
+
+// exportedUser is the user record in an account export. The password hash is left out.
+type exportedUser struct {
+	ID             int64  `json:"id"`
+	Callsign       string `json:"callsign"`
+	Email          string `json:"email,omitempty"`
+	EmailConfirmed bool   `json:"email_confirmed"`
+	Issuer         string `json:"issuer,omitempty"`
+	Subject        string `json:"subject,omitempty"`
+}
+
+// exportedLogbook is a logbook in an account export, including logbooks in the trash.
+type exportedLogbook struct {
+	ID          int64   `json:"id"`
+	UUID        string  `json:"uuid"`
+	Name        string  `json:"name"`
+	Callsign    string  `json:"callsign"`
+	Description *string `json:"description"`
+	CreatedAt   *string `json:"created_at"`
+	ArchivedAt  *string `json:"archived_at"`
+	DeletedAt   *string `json:"deleted_at"`
+}
+
+// exportedApiKey is the metadata of an API key in an account export. The key hash is left out.
+type exportedApiKey struct {
+	ID         int64   `json:"id"`
+	LogbookID  int64   `json:"logbook_id"`
+	Name       string  `json:"name"`
+	Prefix     string  `json:"prefix"`
+	CreatedAt  *string `json:"created_at"`
+	LastUsedAt *string `json:"last_used_at"`
+	ExpiresAt  *string `json:"expires_at"`
+	RevokedAt  *string `json:"revoked_at"`
+}
+
+// accountDeletion is a scheduled account deletion.
+type accountDeletion struct {
+	RequestedAt string `json:"requested_at"`
+	PurgeAfter  string `json:"purge_after"`
+}
+
+// loadAccountDeletionGrace reads the account deletion grace period from the environment.
+func loadAccountDeletionGrace() (time.Duration, error) {
+	const op errors.Op = "server.loadAccountDeletionGrace"
+
+	value := strings.TrimSpace(os.Getenv(envAccountDeletionGrace))
+	if value == emptyString {
+		return defaultAccountDeletionGrace, nil
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil || d < 0 {
+		return 0, errors.New(op).Msg(envAccountDeletionGrace + " must be a non-negative duration")
+	}
+	return d, nil
+}
+
+// exportAccountHandler queues an export of everything the server holds about the authenticated
+// user, and answers with its job. The archive is fetched with getAccountExportHandler once the job
+// has written it; an earlier export of the user is replaced.
+func (s *Service) exportAccountHandler(c *fiber.Ctx) error {
+	const op errors.Op = "server.Service.exportAccountHandler"
+
+	reqCtx, err := getRequestContext(c)
+	if err != nil || reqCtx.User == nil {
+		err = errors.New(op).Err(err).Msg("Request context missing")
+		s.logger.ErrorWith().Err(err).Msg("getRequestContext failed")
+		return c.Status(fiber.StatusInternalServerError).JSON(jsonInternalError)
+	}
+	user := reqCtx.User
+
+	ctx := c.UserContext()
+	// The row is in place before the job is queued, so that the job always finds it.
+	if _, err = s.db.ExecContext(ctx, `INSERT INTO account_exports (user_id, created_at) VALUES ($1, $2)
+		ON CONFLICT (user_id) DO UPDATE SET job_id = NULL, archive = NULL, created_at = excluded.created_at, finished_at = NULL`,
+		user.ID, s.dbTimestamp(time.Now())); err != nil {
+		s.logger.ErrorWith().Err(errors.New(op).Err(err)).Int64("user_id", user.ID).Msg("s.db.ExecContext failed")
+		return s.dbFailure(c, err)
+	}
+	j, _, err := s.enqueueJob(ctx, newJob{Kind: jobKindAccountExport, Payload: exportedUser{
+		ID:             user.ID,
+		Callsign:       user.Callsign,
+		Email:          user.Email,
+		EmailConfirmed: user.EmailConfirmed,
+		Issuer:         user.Issuer,
+		Subject:        user.Subject,
+	}})
+	if err != nil {
+		s.logger.ErrorWith().Err(errors.New(op).Err(err)).Int64("user_id", user.ID).Msg("s.enqueueJob failed")
+		return s.dbFailure(c, err)
+	}
+	if _, err = s.db.ExecContext(ctx, `UPDATE account_exports SET job_id = $1 WHERE user_id = $2`, j.ID, user.ID); err != nil {
+		s.logger.ErrorWith().Err(errors.New(op).Err(err)).Int64("user_id", user.ID).Msg("s.db.ExecContext failed")
+		return s.dbFailure(c, err)
+	}
+	return c.Status(fiber.StatusAccepted).JSON(fiber.Map{"job": j})
+}
+
+// getAccountExportHandler returns the authenticated user's latest account export as a zip archive:
+// the user, their logbooks and API key metadata as JSON, the QSOs of each logbook as ADIF and the
+// QSOs in each logbook's trash as JSON. While the export job has not finished it answers 202 with
+// the job, and 500 with the job if it failed.
+func (s *Service) getAccountExportHandler(c *fiber.Ctx) error {
+	const op errors.Op = "server.Service.getAccountExportHandler"
+
+	reqCtx, err := getRequestContext(c)
+	if err != nil || reqCtx.User == nil {
+		err = errors.New(op).Err(err).Msg("Request context missing")
+		s.logger.ErrorWith().Err(err).Msg("getRequestContext failed")
+		return c.Status(fiber.StatusInternalServerError).JSON(jsonInternalError)
+	}
+	user := reqCtx.User
+
+	jobID, archive, found, err := s.fetchAccountExport(c.UserContext(), user.ID)
+	if err != nil {
+		s.logger.ErrorWith().Err(errors.New(op).Err(err)).Int64("user_id", user.ID).Msg("s.fetchAccountExport failed")
+		return s.dbFailure(c, err)
+	}
+	if !found {
+		return c.Status(fiber.StatusNotFound).JSON(jsonError(errCodeNotFound, "No account export has been requested"))
+	}
+	if archive != nil {
+		c.Set(fiber.HeaderContentType, "application/zip")
+		c.Attachment("station-manager-" + strings.ToLower(user.Callsign) + "-" + time.Now().UTC().Format("20060102") + ".zip")
+		return c.Send(archive)
+	}
+
+	j, found, err := s.fetchJob(c.UserContext(), jobID, 0)
+	if err != nil {
+		s.logger.ErrorWith().Err(errors.New(op).Err(err)).Int64("user_id", user.ID).Msg("s.fetchJob failed")
+		return s.dbFailure(c, err)
+	}
+	if !found {
+		return c.Status(fiber.StatusNotFound).JSON(jsonError(errCodeNotFound, "No account export has been requested"))
+	}
+	if j.Status == jobFailed {
+		body := jsonError(errCodeInternal, "The account export failed")
+		body["job"] = j
+		return c.Status(fiber.StatusInternalServerError).JSON(body)
+	}
+	return c.Status(fiber.StatusAccepted).JSON(fiber.Map{"job": j})
+}
+
+// fetchAccountExport returns the job and, once written, the archive of the user's latest account
+// export. found is false if the user has not asked for one.
+func (s *Service) fetchAccountExport(ctx context.Context, userID int64) (jobID int64, archive []byte, found bool, err error) {
+	const op errors.Op = "server.Service.fetchAccountExport"
+
+	rows, err := s.db.QueryContext(ctx, `SELECT job_id, archive FROM account_exports WHERE user_id = $1`, userID)
+	if err != nil {
+		return 0, nil, false, errors.New(op).Err(err)
+	}
+	defer func() { _ = rows.Close() }()
+
+	if !rows.Next() {
+		if err = rows.Err(); err != nil {
+			return 0, nil, false, errors.New(op).Err(err)
+		}
+		return 0, nil, false, nil
+	}
+	var id sql.NullInt64
+	if err = rows.Scan(&id, &archive); err != nil {
+		return 0, nil, false, errors.New(op).Err(err)
+	}
+	return id.Int64, archive, true, nil
+}
+
+// runAccountExportJob writes the archive of an account export and stores it for the user.
+func (s *Service) runAccountExportJob(ctx context.Context, j job) (any, error) {
+	const op errors.Op = "server.Service.runAccountExportJob"
+
+	var user exportedUser
+	if err := j.decodePayload(&user); err != nil {
+		return nil, permanentJobFailure(errors.New(op).Err(err))
+	}
+	var archive bytes.Buffer
+	if err := s.writeAccountExport(ctx, &archive, user); err != nil {
+		return nil, errors.New(op).Err(err)
+	}
+	// The handler links the job to the row only after queueing it, so the job may find it unlinked.
+	res, err := s.db.ExecContext(ctx, `UPDATE account_exports SET archive = $1, finished_at = $2 WHERE user_id = $3 AND (job_id = $4 OR job_id IS NULL)`,
+		archive.Bytes(), s.dbTimestamp(time.Now()), user.ID, j.ID)
+	if err != nil {
+		return nil, errors.New(op).Err(err)
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return nil, permanentJobFailure(errors.New(op).Msg("the export was replaced by a newer one"))
+	}
+	s.logger.InfoWith().Int64("user_id", user.ID).Int("bytes", archive.Len()).Msg("Account exported")
+	return fiber.Map{"bytes": archive.Len()}, nil
+}
+
+// deleteAccountHandler schedules the authenticated user's account for permanent deletion once the
+// grace period has passed. Asking again keeps the original schedule.
+func (s *Service) deleteAccountHandler(c *fiber.Ctx) error {
+	const op errors.Op = "server.Service.deleteAccountHandler"
+
+	reqCtx, err := getRequestContext(c)
+	if err != nil || reqCtx.User == nil {
+		err = errors.New(op).Err(err).Msg("Request context missing")
+		s.logger.ErrorWith().Err(err).Msg("getRequestContext failed")
+		return c.Status(fiber.StatusInternalServerError).JSON(jsonInternalError)
+	}
+
+	deletion, err := s.scheduleAccountDeletion(c.UserContext(), reqCtx.User.ID, time.Now().Add(s.accountDeletionGrace))
+	if err != nil {
+		s.logger.ErrorWith().Err(errors.New(op).Err(err)).Int64("user_id", reqCtx.User.ID).Msg("s.scheduleAccountDeletion failed")
+		return s.dbFailure(c, err)
+	}
+	s.logger.InfoWith().Int64("user_id", reqCtx.User.ID).Str("purge_after", deletion.PurgeAfter).Msg("Account deletion scheduled")
+
+	return c.Status(fiber.StatusAccepted).JSON(deletion)
+}
+
+// cancelAccountDeletionHandler cancels the authenticated user's scheduled account deletion.
+func (s *Service) cancelAccountDeletionHandler(c *fiber.Ctx) error {
+	const op errors.Op = "server.Service.cancelAccountDeletionHandler"
+
+	reqCtx, err := getRequestContext(c)
+	if err != nil || reqCtx.User == nil {
+		err = errors.New(op).Err(err).Msg("Request context missing")
+		s.logger.ErrorWith().Err(err).Msg("getRequestContext failed")
+		return c.Status(fiber.StatusInternalServerError).JSON(jsonInternalError)
+	}
+
+	res, err := s.db.ExecContext(c.UserContext(), `DELETE FROM account_deletions WHERE user_id = $1`, reqCtx.User.ID)
+	if err != nil {
+		s.logger.ErrorWith().Err(errors.New(op).Err(err)).Int64("user_id", reqCtx.User.ID).Msg("s.db.ExecContext failed")
+		return s.dbFailure(c, err)
+	}
+	if n, err := res.RowsAffected(); err != nil || n == 0 {
+		return c.Status(fiber.StatusNotFound).JSON(jsonError(errCodeNotFound, "No account deletion is scheduled"))
+	}
+	s.logger.InfoWith().Int64("user_id", reqCtx.User.ID).Msg("Account deletion cancelled")
+
+	return c.SendStatus(fiber.StatusNoContent)
+}
+
+// writeAccountExport writes the user's data to w as a zip archive.
+func (s *Service) writeAccountExport(ctx context.Context, w io.Writer, user exportedUser) error {
+	const op errors.Op = "server.Service.writeAccountExport"
+
+	logbooks, err := s.listAccountLogbooks(ctx, user.ID)
+	if err != nil {
+		return errors.New(op).Err(err)
+	}
+	keys, err := s.listAccountApiKeys(ctx, user.ID)
+	if err != nil {
+		return errors.New(op).Err(err)
+	}
+
+	zw := zip.NewWriter(w)
+	if err = writeZipJSON(zw, "user.json", user); err != nil {
+		return errors.New(op).Err(err)
+	}
+	if err = writeZipJSON(zw, "logbooks.json", logbooks); err != nil {
+		return errors.New(op).Err(err)
+	}
+	if err = writeZipJSON(zw, "api_keys.json", keys); err != nil {
+		return errors.New(op).Err(err)
+	}
+	for _, logbook := range logbooks {
+		prefix := "logbooks/" + strconv.FormatInt(logbook.ID, 10)
+		if err = s.writeLogbookAdif(ctx, zw, prefix+"/qsos.adi", logbook); err != nil {
+			return errors.New(op).Err(err)
+		}
+		trashed, err := s.listTrashedQsos(ctx, logbook.ID, 0)
+		if err != nil {
+			return errors.New(op).Err(err)
+		}
+		if err = writeZipJSON(zw, prefix+"/trash.json", trashed); err != nil {
+			return errors.New(op).Err(err)
+		}
+	}
+	if err = zw.Close(); err != nil {
+		return errors.New(op).Err(err)
+	}
+	return nil
+}
+
+// writeLogbookAdif writes the QSOs of the logbook that are not in the trash as an ADIF file.
+func (s *Service) writeLogbookAdif(ctx context.Context, zw *zip.Writer, name string, logbook exportedLogbook) error {
+	const op errors.Op = "server.Service.writeLogbookAdif"
+
+	ids, err := s.listLogbookQsoIDs(ctx, logbook.ID)
+	if err != nil {
+		return errors.New(op).Err(err)
+	}
+	records := make([]adif.Record, 0, len(ids))
+	for _, id := range ids {
+		qso, err := s.db.FetchQsoByIdContext(ctx, id)
+		if err != nil {
+			return errors.New(op).Err(err)
+		}
+		records = append(records, qsoAdifRecord(qso, types.Logbook{ID: logbook.ID, Callsign: logbook.Callsign}))
+	}
+
+	f, err := zw.Create(name)
+	if err != nil {
+		return errors.New(op).Err(err)
+	}
+	if err = adif.Encode(f, adifProgramID, records); err != nil {
+		return errors.New(op).Err(err)
+	}
+	return nil
+}
+
+func writeZipJSON(zw *zip.Writer, name string, v any) error {
+	f, err := zw.Create(name)
+	if err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(v, emptyString, "  ")
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(data)
+	return err
+}
+
+// listAccountLogbooks returns all of the user's logbooks, including those in the trash.
+func (s *Service) listAccountLogbooks(ctx context.Context, userID int64) ([]exportedLogbook, error) {
+	const op errors.Op = "server.Service.listAccountLogbooks"
+
+	rows, err := s.db.QueryContext(ctx, `SELECT id, name, callsign, description, `+
+		s.timestampExpr(`created_at`)+`, `+s.timestampExpr(`archived_at`)+`, `+s.timestampExpr(`deleted_at`)+`
+		FROM logbook WHERE user_id = $1 ORDER BY id`, userID)
+	if err != nil {
+		return nil, errors.New(op).Err(err)
+	}
+	defer func() { _ = rows.Close() }()
+
+	logbooks := make([]exportedLogbook, 0)
+	for rows.Next() {
+		var lb exportedLogbook
+		if err = rows.Scan(&lb.ID, &lb.Name, &lb.Callsign, &lb.Description, &lb.CreatedAt, &lb.ArchivedAt, &lb.DeletedAt); err != nil {
+			return nil, errors.New(op).Err(err)
+		}
+		logbooks = append(logbooks, lb)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, errors.New(op).Err(err)
+	}
+
+	ids := make([]int64, len(logbooks))
+	for i, lb := range logbooks {
+		ids[i] = lb.ID
+	}
+	uuids, err := s.logbookUUIDs(ctx, ids)
+	if err != nil {
+		return nil, errors.New(op).Err(err)
+	}
+	for i := range logbooks {
+		logbooks[i].UUID = uuids[logbooks[i].ID]
+	}
+	return logbooks, nil
+}
+
+// listAccountApiKeys returns the metadata of the API keys of all the user's logbooks.
+func (s *Service) listAccountApiKeys(ctx context.Context, userID int64) ([]exportedApiKey, error) {
+	const op errors.Op = "server.Service.listAccountApiKeys"
+
+	rows, err := s.db.QueryContext(ctx, `SELECT id, logbook_id, key_name, key_prefix, `+
+		s.timestampExpr(`created_at`)+`, `+s.timestampExpr(`last_used_at`)+`, `+
+		s.timestampExpr(`expires_at`)+`, `+s.timestampExpr(`revoked_at`)+`
+		FROM api_keys WHERE logbook_id IN (SELECT id FROM logbook WHERE user_id = $1) ORDER BY id`, userID)
+	if err != nil {
+		return nil, errors.New(op).Err(err)
+	}
+	defer func() { _ = rows.Close() }()
+
+	keys := make([]exportedApiKey, 0)
+	for rows.Next() {
+		var k exportedApiKey
+		if err = rows.Scan(&k.ID, &k.LogbookID, &k.Name, &k.Prefix, &k.CreatedAt, &k.LastUsedAt, &k.ExpiresAt, &k.RevokedAt); err != nil {
+			return nil, errors.New(op).Err(err)
+		}
+		keys = append(keys, k)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, errors.New(op).Err(err)
+	}
+	return keys, nil
+}
+
+// scheduleAccountDeletion records that the user's account is to be purged after purgeAfter, unless
+// a deletion is already scheduled, and returns the schedule in effect.
+func (s *Service) scheduleAccountDeletion(ctx context.Context, userID int64, purgeAfter time.Time) (accountDeletion, error) {
+	const op errors.Op = "server.Service.scheduleAccountDeletion"
+
+	if _, err := s.db.ExecContext(ctx, `INSERT INTO account_deletions (user_id, purge_after) VALUES ($1, $2)
+		ON CONFLICT (user_id) DO NOTHING`, userID, s.dbTimestamp(purgeAfter)); err != nil {
+		return accountDeletion{}, errors.New(op).Err(err)
+	}
+
+	rows, err := s.db.QueryContext(ctx, `SELECT `+s.timestampExpr(`requested_at`)+`, `+s.timestampExpr(`purge_after`)+`
+		FROM account_deletions WHERE user_id = $1`, userID)
+	if err != nil {
+		return accountDeletion{}, errors.New(op).Err(err)
+	}
+	defer func() { _ = rows.Close() }()
+
+	var deletion accountDeletion
+	if !rows.Next() {
+		if err = rows.Err(); err == nil {
+			err = sql.ErrNoRows
+		}
+		return accountDeletion{}, errors.New(op).Err(err)
+	}
+	if err = rows.Scan(&deletion.RequestedAt, &deletion.PurgeAfter); err != nil {
+		return accountDeletion{}, errors.New(op).Err(err)
+	}
+	return deletion, nil
+}
+
+// dbTimestamp converts t for comparison with a timestamp column. SQLite stores CURRENT_TIMESTAMP
+// as UTC text, which compares correctly as a string.
+func (s *Service) dbTimestamp(t time.Time) any {
+	if s.isPostgres() {
+		return t.UTC()
+	}
+	return t.UTC().Format(time.DateTime)
+}
+
+// purgeDeletedAccounts purges the accounts whose deletion grace period has passed.
+func (s *Service) purgeDeletedAccounts(ctx context.Context) error {
+	n, err := s.purgeDueAccounts(ctx, time.Now())
+	if err != nil {
+		return err
+	}
+	if n > 0 {
+		s.logger.InfoWith().Int("accounts", n).Msg("Deleted accounts purged")
+	}
+	return nil
+}
+
+// purgeDueAccounts purges every account whose deletion was due by now and returns how many were
+// purged. It stops at the first failure; the remaining accounts are tried again at the next run.
+func (s *Service) purgeDueAccounts(ctx context.Context, now time.Time) (int, error) {
+	const op errors.Op = "server.Service.purgeDueAccounts"
+
+	rows, err := s.db.QueryContext(ctx, `SELECT user_id FROM account_deletions WHERE purge_after <= $1 ORDER BY user_id`, s.dbTimestamp(now))
+	if err != nil {
+		return 0, errors.New(op).Err(err)
+	}
+	var userIDs []int64
+	for rows.Next() {
+		var id int64
+		if err = rows.Scan(&id); err != nil {
+			_ = rows.Close()
+			return 0, errors.New(op).Err(err)
+		}
+		userIDs = append(userIDs, id)
+	}
+	err = rows.Err()
+	_ = rows.Close()
+	if err != nil {
+		return 0, errors.New(op).Err(err)
+	}
+
+	for i, id := range userIDs {
+		if err = s.purgeAccount(ctx, id); err != nil {
+			return i, errors.New(op).Err(err)
+		}
+	}
+	return len(userIDs), nil
+}
+
+// purgeAccount permanently deletes the user with their logbooks, QSOs and API keys, and drops them
+// from the caches. Rows of the server's tables that reference them are removed by their foreign
+// keys.
+func (s *Service) purgeAccount(ctx context.Context, userID int64) error {
+	const op errors.Op = "server.Service.purgeAccount"
+
+	var (
+		callsign   string
+		logbookIDs []int64
+		prefixes   []string
+	)
+	err := s.withTx(ctx, func(tx *sql.Tx) error {
+		callsign, logbookIDs, prefixes = emptyString, nil, nil
+
+		if txErr := queryEach(ctx, tx, func(rows *sql.Rows) error { return rows.Scan(&callsign) },
+			`SELECT callsign FROM users WHERE id = $1`, userID); txErr != nil {
+			return txErr
+		}
+		if txErr := queryEach(ctx, tx, func(rows *sql.Rows) error {
+			var id int64
+			err := rows.Scan(&id)
+			logbookIDs = append(logbookIDs, id)
+			return err
+		}, `SELECT id FROM logbook WHERE user_id = $1`, userID); txErr != nil {
+			return txErr
+		}
+		if txErr := queryEach(ctx, tx, func(rows *sql.Rows) error {
+			var prefix string
+			err := rows.Scan(&prefix)
+			prefixes = append(prefixes, prefix)
+			return err
+		}, `SELECT key_prefix FROM api_keys WHERE logbook_id IN (SELECT id FROM logbook WHERE user_id = $1) OR user_id = $1`, userID); txErr != nil {
+			return txErr
+		}
+
+		// SQLite's qso table restricts deleting a logbook that still has QSOs, so delete bottom-up.
+		for _, query := range []string{
+			`DELETE FROM qso WHERE logbook_id IN (SELECT id FROM logbook WHERE user_id = $1)`,
+			`DELETE FROM api_keys WHERE logbook_id IN (SELECT id FROM logbook WHERE user_id = $1) OR user_id = $1`,
+			`DELETE FROM logbook WHERE user_id = $1`,
+			`DELETE FROM users WHERE id = $1`,
+		} {
+			if _, txErr := tx.ExecContext(ctx, query, userID); txErr != nil {
+				return txErr
+			}
+		}
+		sandboxPrefixes, txErr := purgeOrphanSandboxes(ctx, tx)
+		prefixes = append(prefixes, sandboxPrefixes...)
+		return txErr
+	})
+	if err != nil {
+		return errors.New(op).Err(err)
+	}
+
+	for _, id := range logbookIDs {
+		s.invalidateLogbook(ctx, id)
+	}
+	for _, prefix := range prefixes {
+		s.invalidateApiKey(ctx, prefix)
+	}
+	if callsign != emptyString {
+		s.invalidateUser(ctx, callsign)
+	}
+	s.logger.InfoWith().Int64("user_id", userID).Int("logbooks", len(logbookIDs)).Msg("Account purged")
+	s.recordAudit(ctx, auditEntry{Event: auditAccountDeleted, Actor: auditActorServer, Callsign: callsign},
+		fiber.Map{"logbooks": logbookIDs, "api_keys": len(prefixes)})
+	return nil
+}
+
+// queryEach runs the query in the transaction and calls scan for every row.
+func queryEach(ctx context.Context, tx *sql.Tx, scan func(rows *sql.Rows) error, query string, args ...any) error {
+	rows, err := tx.QueryContext(ctx, query, args...)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = rows.Close() }()
+
+	for rows.Next() {
+		if err = scan(rows); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
@@ -0,0 +1,178 @@
+package service
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"io"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Station-Manager/types"
+	"github.com/goccy/go-json"
+	"github.com/gofiber/fiber/v2"
+)
+
+// seedAccount gives logbook 1 to user 7 (W1AW), with one API key and two QSOs, one of them in the
+// trash.
+func seedAccount(t *testing.T, svc *Service) {
+	t.Helper()
+
+	ctx := context.Background()
+	for _, query := range []string{
+		`INSERT INTO users (id, callsign, pass_hash, email) VALUES (7, 'W1AW', 'secret-hash', 'w1aw@example.com'), (8, 'K1AB', 'x', NULL)`,
+		`UPDATE logbook SET user_id = 7 WHERE id = 1`,
+		`INSERT INTO api_keys (logbook_id, key_name, key_prefix, key_hash) VALUES (1, 'laptop', 'abc123', 'key-hash')`,
+	} {
+		if _, err := svc.db.ExecContext(ctx, query); err != nil {
+			t.Fatalf("setup failed: %v", err)
+		}
+	}
+	ids := []int64{
+		insertTestQso(t, svc, "JA1XX", "20m", "FT8", "20240430", "1203"),
+		insertTestQso(t, svc, "DL1AB", "40m", "CW", "20240501", "0815"),
+	}
+	if _, err := svc.setQsoDeleted(ctx, 1, ids[1], true, "user:W1AW"); err != nil {
+		t.Fatalf("setQsoDeleted failed: %v", err)
+	}
+}
+
+func withAccountUser(user types.User, handler fiber.Handler) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		c.Locals(localsRequestDataKey, &requestContext{User: &user, IsValid: true})
+		return handler(c)
+	}
+}
+
+func TestExportAccountHandler_WritesArchiveInJob(t *testing.T) {
+	svc := newTestServer(t)
+	svc.db = newLiveStore(svc.db)
+	seedAccount(t, svc)
+
+	app := fiber.New()
+	user := types.User{ID: 7, Callsign: "W1AW", PassHash: "secret-hash", Email: "w1aw@example.com"}
+	app.Post("/export", withAccountUser(user, svc.exportAccountHandler))
+	app.Get("/export", withAccountUser(user, svc.getAccountExportHandler))
+	if resp, err := app.Test(httptest.NewRequest(fiber.MethodGet, "/export", nil)); err != nil || resp.StatusCode != fiber.StatusNotFound {
+		t.Fatalf("expected no export before one is asked for, got %v (status %d)", err, resp.StatusCode)
+	}
+	resp, err := app.Test(httptest.NewRequest(fiber.MethodPost, "/export", nil))
+	if err != nil || resp.StatusCode != fiber.StatusAccepted {
+		t.Fatalf("export failed: %v (status %d)", err, resp.StatusCode)
+	}
+	var queued struct {
+		Job job `json:"job"`
+	}
+	if err = json.NewDecoder(resp.Body).Decode(&queued); err != nil || queued.Job.Kind != jobKindAccountExport {
+		t.Fatalf("expected an export job, got %+v (%v)", queued, err)
+	}
+	if resp, err = app.Test(httptest.NewRequest(fiber.MethodGet, "/export", nil)); err != nil || resp.StatusCode != fiber.StatusAccepted {
+		t.Fatalf("expected the export to be pending, got %v (status %d)", err, resp.StatusCode)
+	}
+
+	runDueJobs(t, svc)
+	if j, _, _ := svc.fetchJob(context.Background(), queued.Job.ID, 0); j.Status != jobSucceeded {
+		t.Fatalf("expected the export job to succeed, got %+v", j)
+	}
+	resp, err = app.Test(httptest.NewRequest(fiber.MethodGet, "/export", nil))
+	if err != nil || resp.StatusCode != fiber.StatusOK {
+		t.Fatalf("download failed: %v (status %d)", err, resp.StatusCode)
+	}
+	if cd := resp.Header.Get(fiber.HeaderContentDisposition); !strings.Contains(cd, "station-manager-w1aw-") {
+		t.Errorf("expected an attachment, got %q", cd)
+	}
+
+	body, _ := io.ReadAll(resp.Body)
+	zr, err := zip.NewReader(bytes.NewReader(body), int64(len(body)))
+	if err != nil {
+		t.Fatalf("zip failed: %v", err)
+	}
+	files := make(map[string]string)
+	for _, f := range zr.File {
+		r, _ := f.Open()
+		data, _ := io.ReadAll(r)
+		_ = r.Close()
+		files[f.Name] = string(data)
+	}
+
+	if user := files["user.json"]; !strings.Contains(user, "w1aw@example.com") || strings.Contains(user, "secret-hash") {
+		t.Errorf("expected the user without the password hash, got %s", user)
+	}
+	var keys []exportedApiKey
+	if err = json.Unmarshal([]byte(files["api_keys.json"]), &keys); err != nil || len(keys) != 1 || keys[0].Prefix != "abc123" || keys[0].CreatedAt == nil {
+		t.Errorf("expected the key metadata, got %+v (err=%v)", keys, err)
+	}
+	if strings.Contains(files["api_keys.json"], "key-hash") {
+		t.Errorf("expected the key hash to be left out, got %s", files["api_keys.json"])
+	}
+	if adi := files["logbooks/1/qsos.adi"]; !strings.Contains(adi, "JA1XX") || strings.Contains(adi, "DL1AB") {
+		t.Errorf("expected the live QSO in the ADIF file, got %s", adi)
+	}
+	if trash := files["logbooks/1/trash.json"]; !strings.Contains(trash, "DL1AB") {
+		t.Errorf("expected the trashed QSO in the trash file, got %s", trash)
+	}
+}
+
+func TestAccountDeletion_ScheduleCancelAndPurge(t *testing.T) {
+	svc := newTestServer(t)
+	svc.db = newLiveStore(svc.db)
+	seedAccount(t, svc)
+	svc.accountDeletionGrace = time.Hour
+	ctx := context.Background()
+
+	app := fiber.New()
+	app.Post("/delete", withAccountUser(types.User{ID: 7, Callsign: "W1AW"}, svc.deleteAccountHandler))
+	app.Post("/delete/cancel", withAccountUser(types.User{ID: 7, Callsign: "W1AW"}, svc.cancelAccountDeletionHandler))
+
+	if resp, _ := app.Test(httptest.NewRequest(fiber.MethodPost, "/delete/cancel", nil)); resp.StatusCode != fiber.StatusNotFound {
+		t.Fatalf("expected cancelling without a schedule to get 404, got %d", resp.StatusCode)
+	}
+	resp, err := app.Test(httptest.NewRequest(fiber.MethodPost, "/delete", nil))
+	if err != nil || resp.StatusCode != fiber.StatusAccepted {
+		t.Fatalf("delete failed: %v", err)
+	}
+	var first accountDeletion
+	if err = json.NewDecoder(resp.Body).Decode(&first); err != nil || first.PurgeAfter == emptyString {
+		t.Fatalf("unexpected schedule %+v (err=%v)", first, err)
+	}
+
+	// Nothing is due before the grace period has passed.
+	if n, err := svc.purgeDueAccounts(ctx, time.Now()); err != nil || n != 0 {
+		t.Fatalf("expected nothing to purge yet, got %d (err=%v)", n, err)
+	}
+	if resp, _ = app.Test(httptest.NewRequest(fiber.MethodPost, "/delete/cancel", nil)); resp.StatusCode != fiber.StatusNoContent {
+		t.Fatalf("expected cancel to succeed, got %d", resp.StatusCode)
+	}
+	if n, err := svc.purgeDueAccounts(ctx, time.Now().Add(2*time.Hour)); err != nil || n != 0 {
+		t.Fatalf("expected a cancelled deletion not to purge, got %d (err=%v)", n, err)
+	}
+
+	if _, err = app.Test(httptest.NewRequest(fiber.MethodPost, "/delete", nil)); err != nil {
+		t.Fatalf("delete failed: %v", err)
+	}
+	if _, err = svc.fetchLogbookWithCache(ctx, 1); err != nil {
+		t.Fatalf("fetchLogbookWithCache failed: %v", err)
+	}
+	if n, err := svc.purgeDueAccounts(ctx, time.Now().Add(2*time.Hour)); err != nil || n != 1 {
+		t.Fatalf("expected one account to be purged, got %d (err=%v)", n, err)
+	}
+
+	for table, want := range map[string]int{"users": 1, "logbook": 0, "api_keys": 0, "qso": 0, "qso_history": 0, "account_deletions": 0} {
+		rows, err := svc.db.QueryContext(ctx, `SELECT COUNT(*) FROM `+table)
+		if err != nil {
+			t.Fatalf("count %s failed: %v", table, err)
+		}
+		var n int
+		rows.Next()
+		_ = rows.Scan(&n)
+		_ = rows.Close()
+		if n != want {
+			t.Errorf("expected %d rows in %s, got %d", want, table, n)
+		}
+	}
+	if _, ok := svc.logbookCache.Get(1); ok {
+		t.Error("expected the purged logbook to be dropped from the cache")
+	}
+}
@@ -0,0 +1,65 @@
+package service
+
+import (
+	"os"
+	"strings"
+
+	"github.com/Station-Manager/errors"
+	"golang.org/x/crypto/acme"
+	"golang.org/x/crypto/acme/autocert"
+)
+
+// Automatic TLS is enabled by naming the hostnames to obtain certificates for. The server then
+// requests certificates from an ACME CA (Let's Encrypt by default) on first use, renews them before
+// they expire, and caches them on disk so that restarts do not trigger new orders.
+const (
+	// envAcmeHosts names the environment variable holding the comma-separated hostnames.
+	envAcmeHosts = "SM_ACME_HOSTS"
+	// envAcmeEmail names the environment variable holding the contact address given to the CA.
+	envAcmeEmail = "SM_ACME_EMAIL"
+	// envAcmeCacheDir names the environment variable holding the certificate cache directory.
+	envAcmeCacheDir = "SM_ACME_CACHE_DIR"
+	// envAcmeDirectoryURL names the environment variable that overrides the CA's directory URL, e.g.
+	// to use the Let's Encrypt staging environment while testing.
+	envAcmeDirectoryURL = "SM_ACME_DIRECTORY_URL"
+)
+
+const defaultAcmeCacheDir = "acme-cache"
+
+// loadAutocertManager builds the certificate manager from the environment, or returns nil when
+// automatic TLS is not enabled. It cannot be combined with certificate files in ServerConfig.
+func loadAutocertManager(tlsEnabled bool) (*autocert.Manager, error) {
+	const op errors.Op = "server.loadAutocertManager"
+
+	var hosts []string
+	for _, host := range strings.Split(os.Getenv(envAcmeHosts), ",") {
+		if host = strings.ToLower(strings.TrimSpace(host)); host != emptyString {
+			hosts = append(hosts, host)
+		}
+	}
+	if len(hosts) == 0 {
+		return nil, nil
+	}
+	if tlsEnabled {
+		return nil, errors.New(op).Msg(envAcmeHosts + " cannot be used together with tls_enabled; choose one source of certificates")
+	}
+
+	cacheDir := strings.TrimSpace(os.Getenv(envAcmeCacheDir))
+	if cacheDir == emptyString {
+		cacheDir = defaultAcmeCacheDir
+	}
+	if err := os.MkdirAll(cacheDir, 0o700); err != nil {
+		return nil, errors.New(op).Err(err).Msg("Failed to create the ACME certificate cache directory")
+	}
+
+	manager := &autocert.Manager{
+		Prompt:     autocert.AcceptTOS,
+		HostPolicy: autocert.HostWhitelist(hosts...),
+		Cache:      autocert.DirCache(cacheDir),
+		Email:      strings.TrimSpace(os.Getenv(envAcmeEmail)),
+	}
+	if url := strings.TrimSpace(os.Getenv(envAcmeDirectoryURL)); url != emptyString {
+		manager.Client = &acme.Client{DirectoryURL: url}
+	}
+	return manager, nil
+}
@@ -0,0 +1,36 @@
+package service
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+)
+
+func TestLoadAutocertManager(t *testing.T) {
+	t.Setenv(envAcmeHosts, "")
+	manager, err := loadAutocertManager(false)
+	if err != nil || manager != nil {
+		t.Fatalf("expected automatic TLS to be off by default, got %v (err=%v)", manager, err)
+	}
+
+	cacheDir := filepath.Join(t.TempDir(), "certs")
+	t.Setenv(envAcmeHosts, " Log.Example.org ,")
+	t.Setenv(envAcmeCacheDir, cacheDir)
+	t.Setenv(envAcmeDirectoryURL, "https://acme-staging-v02.api.letsencrypt.org/directory")
+	if manager, err = loadAutocertManager(false); err != nil || manager == nil {
+		t.Fatalf("loadAutocertManager failed: %v", err)
+	}
+	if err = manager.HostPolicy(context.Background(), "log.example.org"); err != nil {
+		t.Errorf("expected the configured host to be allowed, got %v", err)
+	}
+	if err = manager.HostPolicy(context.Background(), "other.example.org"); err == nil {
+		t.Error("expected other hosts to be refused")
+	}
+	if manager.Client == nil || manager.Client.DirectoryURL == emptyString {
+		t.Error("expected the directory URL override to be applied")
+	}
+
+	if _, err = loadAutocertManager(true); err == nil {
+		t.Error("expected automatic TLS to be refused alongside certificate files")
+	}
+}
@@ -0,0 +1,131 @@
+package service
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"github.com/Station-Manager/errors"
+)
+
+const (
+	activityBucketHour  = "hour"
+	activityBucketDay   = "day"
+	activityBucketMonth = "month"
+
+	activityGroupBand = "band"
+	activityGroupMode = "mode"
+
+	// maxActivityPoints bounds the points returned by one activity query.
+	maxActivityPoints = 10000
+)
+
+// activityBucketLayouts maps each bucket size to the layout of the key its SQL expression produces.
+var activityBucketLayouts = map[string]string{
+	activityBucketHour:  "2006010215",
+	activityBucketDay:   "20060102",
+	activityBucketMonth: "200601",
+}
+
+// activityBucketExpressions holds the SQL that truncates a QSO's start to each bucket size, per
+// driver. Both produce the same keys so that they parse with activityBucketLayouts.
+var activityBucketExpressions = map[bool]map[string]string{
+	false: {
+		activityBucketHour:  `qso_date || substr(time_on, 1, 2)`,
+		activityBucketDay:   `qso_date`,
+		activityBucketMonth: `substr(qso_date, 1, 6)`,
+	},
+	true: {
+		activityBucketHour:  `to_char(qso_date, 'YYYYMMDD') || to_char(time_on, 'HH24')`,
+		activityBucketDay:   `to_char(qso_date, 'YYYYMMDD')`,
+		activityBucketMonth: `to_char(qso_date, 'YYYYMM')`,
+	},
+}
+
+var activityGroupExpressions = map[string]string{
+	activityGroupBand: `lower(band)`,
+	activityGroupMode: `upper(mode)`,
+}
+
+// activityQuery selects the QSOs counted and how they are bucketed. From and To are inclusive ADIF
+// dates (YYYYMMDD) and may be empty; GroupBy is empty for a single series.
+type activityQuery struct {
+	Bucket  string
+	GroupBy string
+	From    string
+	To      string
+}
+
+// activityPoint is the number of QSOs started in the bucket beginning at Start.
+type activityPoint struct {
+	Start time.Time `json:"start"`
+	Group string    `json:"group,omitempty"`
+	Count int64     `json:"count"`
+}
+
+// activitySeries is the result of an activity query, ordered by bucket and then group.
+type activitySeries struct {
+	Bucket    string          `json:"bucket"`
+	GroupBy   string          `json:"group_by,omitempty"`
+	Points    []activityPoint `json:"points"`
+	Truncated bool            `json:"truncated,omitempty"`
+}
+
+// fetchActivity counts the logbook's QSOs per bucket, and per band or mode when grouped.
+func (s *Service) fetchActivity(ctx context.Context, logbookID int64, q activityQuery) (activitySeries, error) {
+	const op errors.Op = "server.Service.fetchActivity"
+
+	postgres := s.isPostgres()
+	bucketExpr := activityBucketExpressions[postgres][q.Bucket]
+	groupExpr := `''`
+	if q.GroupBy != emptyString {
+		groupExpr = activityGroupExpressions[q.GroupBy]
+	}
+
+	query := `SELECT ` + bucketExpr + ` AS bucket, ` + groupExpr + ` AS grp, COUNT(*) FROM qso WHERE logbook_id = $1 AND deleted_at IS NULL`
+	args := []interface{}{logbookID}
+	dateParam := func() string {
+		if postgres {
+			return `to_date($` + strconv.Itoa(len(args)) + `, 'YYYYMMDD')`
+		}
+		return `$` + strconv.Itoa(len(args))
+	}
+	if q.From != emptyString {
+		args = append(args, q.From)
+		query += ` AND qso_date >= ` + dateParam()
+	}
+	if q.To != emptyString {
+		args = append(args, q.To)
+		query += ` AND qso_date <= ` + dateParam()
+	}
+	query += ` GROUP BY bucket, grp ORDER BY bucket, grp LIMIT ` + strconv.Itoa(maxActivityPoints+1)
+
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return activitySeries{}, errors.New(op).Err(err)
+	}
+	defer func() { _ = rows.Close() }()
+
+	series := activitySeries{Bucket: q.Bucket, GroupBy: q.GroupBy, Points: make([]activityPoint, 0)}
+	layout := activityBucketLayouts[q.Bucket]
+	for rows.Next() {
+		if len(series.Points) == maxActivityPoints {
+			series.Truncated = true
+			break
+		}
+		var key string
+		var point activityPoint
+		if err = rows.Scan(&key, &point.Group, &point.Count); err != nil {
+			return activitySeries{}, errors.New(op).Err(err)
+		}
+		if point.Start, err = time.Parse(layout, key); err != nil {
+			return activitySeries{}, errors.New(op).Err(err)
+		}
+		series.Points = append(series.Points, point)
+	}
+	if err = rows.Err(); err != nil {
+		return activitySeries{}, errors.New(op).Err(err)
+	}
+
+	return series, nil
+}
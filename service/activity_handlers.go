@@ -0,0 +1,49 @@
+package service
+
+import (
+	"strings"
+
+	"github.com/Station-Manager/errors"
+	"github.com/gofiber/fiber/v2"
+)
+
+// activityRequest holds the query parameters of an activity request.
+type activityRequest struct {
+	Bucket  string `query:"bucket" validate:"omitempty,oneof=hour day month"`
+	GroupBy string `query:"group_by" validate:"omitempty,oneof=band mode"`
+	From    string `query:"from" validate:"omitempty,len=8,numeric"`
+	To      string `query:"to" validate:"omitempty,len=8,numeric"`
+}
+
+// activityHandler returns the authenticated logbook's QSO counts over time, for charts. Buckets
+// default to days; from and to are inclusive ADIF dates.
+func (s *Service) activityHandler(c *fiber.Ctx) error {
+	const op errors.Op = "server.Service.activityHandler"
+
+	logbook, err := authenticatedLogbook(c)
+	if err != nil {
+		s.logger.ErrorWith().Err(errors.New(op).Err(err)).Msg("authenticatedLogbook failed")
+		return c.Status(fiber.StatusInternalServerError).JSON(jsonInternalError)
+	}
+
+	var request activityRequest
+	if err = c.QueryParser(&request); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(jsonBadRequest)
+	}
+	request.Bucket = strings.ToLower(request.Bucket)
+	request.GroupBy = strings.ToLower(request.GroupBy)
+	if err = s.validate.Struct(request); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(jsonBadRequest)
+	}
+	if request.Bucket == emptyString {
+		request.Bucket = activityBucketDay
+	}
+
+	series, err := s.fetchActivity(c.UserContext(), logbook.ID, activityQuery(request))
+	if err != nil {
+		s.logger.ErrorWith().Err(errors.New(op).Err(err)).Msg("s.fetchActivity failed")
+		return s.dbFailure(c, err)
+	}
+
+	return c.JSON(series)
+}
@@ -0,0 +1,71 @@
+package service
+
+import (
+	"context"
+	"io"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/goccy/go-json"
+	"github.com/gofiber/fiber/v2"
+)
+
+func TestFetchActivity(t *testing.T) {
+	svc := newTestServer(t)
+	ctx := context.Background()
+
+	insertTestQso(t, svc, "JA1XX", "20m", "FT8", "20240430", "1200")
+	insertTestQso(t, svc, "JA2XX", "20m", "FT8", "20240430", "1215")
+	insertTestQso(t, svc, "K1AB", "40m", "CW", "20240430", "1300")
+	insertTestQso(t, svc, "K2AB", "40m", "CW", "20240502", "0100")
+
+	series, err := svc.fetchActivity(ctx, 1, activityQuery{Bucket: activityBucketHour})
+	if err != nil {
+		t.Fatalf("fetchActivity failed: %v", err)
+	}
+	if len(series.Points) != 3 || series.Points[0].Count != 2 ||
+		!series.Points[0].Start.Equal(time.Date(2024, 4, 30, 12, 0, 0, 0, time.UTC)) {
+		t.Errorf("unexpected hourly series %+v", series.Points)
+	}
+
+	series, err = svc.fetchActivity(ctx, 1, activityQuery{Bucket: activityBucketDay, GroupBy: activityGroupBand, To: "20240501"})
+	if err != nil {
+		t.Fatalf("fetchActivity failed: %v", err)
+	}
+	if len(series.Points) != 2 || series.Points[0].Group != "20m" || series.Points[0].Count != 2 || series.Points[1].Group != "40m" {
+		t.Errorf("unexpected grouped series %+v", series.Points)
+	}
+
+	series, err = svc.fetchActivity(ctx, 1, activityQuery{Bucket: activityBucketMonth, From: "20240501"})
+	if err != nil {
+		t.Fatalf("fetchActivity failed: %v", err)
+	}
+	if len(series.Points) != 1 || series.Points[0].Count != 1 || series.Points[0].Start.Month() != time.May {
+		t.Errorf("unexpected monthly series %+v", series.Points)
+	}
+}
+
+func TestActivityHandler(t *testing.T) {
+	svc := newTestServer(t)
+	insertTestQso(t, svc, "JA1XX", "20m", "FT8", "20240430", "1200")
+
+	app := fiber.New()
+	app.Get("/stats/activity", withLogbook(1, svc.activityHandler))
+
+	resp, err := app.Test(httptest.NewRequest("GET", "/stats/activity?group_by=mode", nil))
+	if err != nil || resp.StatusCode != fiber.StatusOK {
+		t.Fatalf("expected 200, got %v (err=%v)", resp.StatusCode, err)
+	}
+	var series activitySeries
+	body, _ := io.ReadAll(resp.Body)
+	if err = json.Unmarshal(body, &series); err != nil || series.Bucket != activityBucketDay || len(series.Points) != 1 || series.Points[0].Group != "FT8" {
+		t.Errorf("unexpected response %s (err=%v)", body, err)
+	}
+
+	for _, path := range []string{"/stats/activity?bucket=week", "/stats/activity?from=2024-04-30"} {
+		if resp, err = app.Test(httptest.NewRequest("GET", path, nil)); err != nil || resp.StatusCode != fiber.StatusBadRequest {
+			t.Errorf("%s: expected 400, got %v (err=%v)", path, resp.StatusCode, err)
+		}
+	}
+}
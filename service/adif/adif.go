@@ -0,0 +1,114 @@
+// Package adif reads and writes ADIF (.adi) documents such as those exchanged with LoTW, eQSL and Club Log.
+//
+// Only the tagged ADI format is supported. Field names are returned upper-cased; data type
+// indicators are ignored and all values are returned as strings.
+package adif
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Record is a single ADIF record, keyed by upper-case field name.
+type Record map[string]string
+
+// Document is a parsed ADIF document. Header is empty when the document has no header.
+type Document struct {
+	Header  Record
+	Records []Record
+}
+
+// Parse reads an ADIF document. Text outside of tags, including the free-form header preamble,
+// is ignored. A document without any <EOH> or <EOR> tags yields no records.
+func Parse(r io.Reader) (Document, error) {
+	br := bufio.NewReader(r)
+	doc := Document{Header: Record{}}
+	current := Record{}
+	sawHeader := false
+
+	for {
+		// Skip to the next tag.
+		if _, err := br.ReadString('<'); err != nil {
+			if err == io.EOF {
+				return doc, nil
+			}
+			return doc, err
+		}
+
+		spec, err := br.ReadString('>')
+		if err != nil {
+			if err == io.EOF {
+				return doc, fmt.Errorf("adif: unterminated tag <%s", spec)
+			}
+			return doc, err
+		}
+		spec = strings.TrimSuffix(spec, ">")
+
+		parts := strings.SplitN(spec, ":", 3)
+		name := strings.ToUpper(strings.TrimSpace(parts[0]))
+
+		switch {
+		case name == "EOH" && len(parts) == 1:
+			if !sawHeader {
+				doc.Header = current
+				sawHeader = true
+			}
+			current = Record{}
+			continue
+		case name == "EOR" && len(parts) == 1:
+			if len(current) > 0 {
+				doc.Records = append(doc.Records, current)
+			}
+			current = Record{}
+			continue
+		case len(parts) < 2:
+			// Not a field, e.g. a stray '<' in a comment.
+			continue
+		}
+
+		length, err := strconv.Atoi(strings.TrimSpace(parts[1]))
+		if err != nil || length < 0 {
+			return doc, fmt.Errorf("adif: invalid length in tag <%s>", spec)
+		}
+
+		value := make([]byte, length)
+		if _, err = io.ReadFull(br, value); err != nil {
+			return doc, fmt.Errorf("adif: field %s: %w", name, err)
+		}
+		current[name] = string(value)
+	}
+}
+
+// Encode writes the records as an ADIF document with a minimal header. Empty values are omitted
+// and fields are written in name order so that output is deterministic.
+func Encode(w io.Writer, programID string, records []Record) error {
+	bw := bufio.NewWriter(w)
+
+	writeField(bw, "ADIF_VER", "3.1.4")
+	writeField(bw, "PROGRAMID", programID)
+	_, _ = bw.WriteString("<EOH>\n")
+
+	for _, rec := range records {
+		names := make([]string, 0, len(rec))
+		for name, value := range rec {
+			if value != "" {
+				names = append(names, name)
+			}
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			writeField(bw, name, rec[name])
+		}
+		_, _ = bw.WriteString("<EOR>\n")
+	}
+
+	return bw.Flush()
+}
+
+func writeField(bw *bufio.Writer, name, value string) {
+	_, _ = fmt.Fprintf(bw, "<%s:%d>%s ", strings.ToUpper(name), len(value), value)
+}
@@ -0,0 +1,96 @@
+package adif
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParse_LotwReport(t *testing.T) {
+	const report = `ARRL Logbook of the World Status Report
+Generated at 2024-05-01 12:00:00
+<PROGRAMID:4>LoTW
+<APP_LoTW_LASTQSL:19>2024-04-30 18:22:01
+<eoh>
+<CALL:5>JA1XX
+<BAND:3>20M
+<MODE:3>FT8
+<QSO_DATE:8>20240430
+<TIME_ON:6>120300
+<QSL_RCVD:1>Y
+<QSLRDATE:8>20240430
+<eor>
+<call:4>K1AB <band:3>40m <mode:2>CW <qso_date:8>20240429 <time_on:4>0102 <eor>
+`
+	doc, err := Parse(strings.NewReader(report))
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+	if got := doc.Header["APP_LOTW_LASTQSL"]; got != "2024-04-30 18:22:01" {
+		t.Errorf("unexpected header value %q", got)
+	}
+	if len(doc.Records) != 2 {
+		t.Fatalf("expected 2 records, got %d", len(doc.Records))
+	}
+	if r := doc.Records[0]; r["CALL"] != "JA1XX" || r["QSL_RCVD"] != "Y" || r["TIME_ON"] != "120300" {
+		t.Errorf("unexpected first record %+v", r)
+	}
+	if r := doc.Records[1]; r["CALL"] != "K1AB" || r["BAND"] != "40m" || r["TIME_ON"] != "0102" {
+		t.Errorf("unexpected second record %+v", r)
+	}
+}
+
+func TestParse_TypeIndicatorAndMultibyteLength(t *testing.T) {
+	doc, err := Parse(strings.NewReader("<eoh><NAME:5:S>José<COMMENT:5>a<b>c<eor>"))
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+	if len(doc.Records) != 1 {
+		t.Fatalf("expected 1 record, got %d", len(doc.Records))
+	}
+	// ADIF lengths count bytes, and values may contain '<' and '>'.
+	if r := doc.Records[0]; r["NAME"] != "José" || r["COMMENT"] != "a<b>c" {
+		t.Errorf("unexpected record %+v", r)
+	}
+}
+
+func TestParse_NoHeaderOrRecords(t *testing.T) {
+	doc, err := Parse(strings.NewReader("<html><body>Username/password incorrect</body></html>"))
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+	if len(doc.Records) != 0 || len(doc.Header) != 0 {
+		t.Errorf("expected an empty document, got %+v", doc)
+	}
+}
+
+func TestParse_Truncated(t *testing.T) {
+	if _, err := Parse(strings.NewReader("<eoh><CALL:10>K1AB")); err == nil {
+		t.Error("expected an error for a truncated value")
+	}
+	if _, err := Parse(strings.NewReader("<eoh><CALL:x>K1AB<eor>")); err == nil {
+		t.Error("expected an error for an invalid length")
+	}
+}
+
+func TestEncode_RoundTrip(t *testing.T) {
+	records := []Record{{"CALL": "JA1XX", "BAND": "20m", "NAME": "José", "COMMENT": ""}}
+
+	var sb strings.Builder
+	if err := Encode(&sb, "test", records); err != nil {
+		t.Fatalf("Encode failed: %v", err)
+	}
+	if strings.Contains(sb.String(), "COMMENT") {
+		t.Error("expected empty fields to be omitted")
+	}
+
+	doc, err := Parse(strings.NewReader(sb.String()))
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+	if doc.Header["PROGRAMID"] != "test" || len(doc.Records) != 1 {
+		t.Fatalf("unexpected document %+v", doc)
+	}
+	if r := doc.Records[0]; r["CALL"] != "JA1XX" || r["BAND"] != "20m" || r["NAME"] != "José" {
+		t.Errorf("unexpected record %+v", r)
+	}
+}
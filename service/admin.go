@@ -0,0 +1,44 @@
+package service
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"os"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// envAdminToken names the environment variable holding the bearer token for the /admin routes.
+// The routes are disabled when it is not set.
+const envAdminToken = "SM_ADMIN_TOKEN"
+
+// loadAdminToken reads the admin token from the environment. Only its digest is kept, so that the
+// comparison takes the same time whatever the length of the presented token.
+func loadAdminToken() []byte {
+	token := strings.TrimSpace(os.Getenv(envAdminToken))
+	if token == emptyString {
+		return nil
+	}
+	digest := sha256.Sum256([]byte(token))
+	return digest[:]
+}
+
+// adminAuthNMiddleware admits requests bearing the admin token. When no token is configured the
+// admin routes do not exist.
+func (s *Service) adminAuthNMiddleware() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		if len(s.adminToken) == 0 {
+			return c.Status(fiber.StatusNotFound).JSON(jsonError(errCodeNotFound, "Not found"))
+		}
+
+		token, ok := strings.CutPrefix(c.Get(fiber.HeaderAuthorization), "Bearer ")
+		digest := sha256.Sum256([]byte(strings.TrimSpace(token)))
+		if !ok || subtle.ConstantTimeCompare(digest[:], s.adminToken) != 1 {
+			s.logger.InfoWith().Str("ip", c.IP()).Msg("Invalid admin token")
+			return c.Status(fiber.StatusUnauthorized).JSON(jsonUnauthorized)
+		}
+
+		return c.Next()
+	}
+}
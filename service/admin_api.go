@@ -0,0 +1,392 @@
+package service
+
+import (
+	"context"
+	"runtime"
+	"time"
+
+	"github.com/Station-Manager/errors"
+	"github.com/gofiber/fiber/v2"
+)
+
+const (
+	adminUserListDefault = 100
+	adminUserListMax     = 1000
+
+	adminCacheKeysDefault = 100
+	adminCacheKeysMax     = 1000
+)
+
+// adminRequest carries the fields of an /api/admin request alongside the request envelope. The
+// envelope's callsign and key are the admin's own credentials; OTP is the current code from their
+// authenticator app.
+type adminRequest struct {
+	OTP    string `json:"otp"`
+	User   string `json:"user"`
+	Prefix string `json:"prefix"`
+	// LogbookID selects the logbook whose cached entry /cache/flush drops.
+	LogbookID int64  `json:"logbook_id"`
+	Event     string `json:"event"`
+	AfterID   int64  `json:"after_id"`
+	BeforeID  int64  `json:"before_id"`
+	Limit     int    `json:"limit"`
+}
+
+// adminAccount is a user who may call the /api/admin routes.
+type adminAccount struct {
+	Callsign  string `json:"callsign"`
+	CreatedAt string `json:"created_at"`
+}
+
+// userSummary is a user as listed to admins.
+type userSummary struct {
+	ID             int64   `json:"id"`
+	Callsign       string  `json:"callsign"`
+	Email          string  `json:"email"`
+	EmailConfirmed bool    `json:"email_confirmed"`
+	CreatedAt      string  `json:"created_at"`
+	DisabledAt     *string `json:"disabled_at,omitempty"`
+	Admin          bool    `json:"admin"`
+	Logbooks       int64   `json:"logbooks"`
+}
+
+// cacheSummary is the state of the caches of this instance, for admins troubleshooting stale
+// records.
+type cacheSummary struct {
+	Caches map[string]any `json:"caches"`
+	// Logbooks and Users are the IDs and callsigns of the cached logbooks and users.
+	Logbooks []int64  `json:"logbooks"`
+	Users    []string `json:"users"`
+}
+
+// systemStats is an overview of the server for admins.
+type systemStats struct {
+	Users         int64          `json:"users"`
+	DisabledUsers int64          `json:"disabled_users"`
+	Logbooks      int64          `json:"logbooks"`
+	Qsos          int64          `json:"qsos"`
+	ActiveApiKeys int64          `json:"active_api_keys"`
+	Webhooks      int64          `json:"webhooks"`
+	Goroutines    int            `json:"goroutines"`
+	HeapBytes     uint64         `json:"heap_bytes"`
+	Caches        map[string]any `json:"caches"`
+	// BusiestApiKeys is today's usage of the API keys that made the most requests.
+	BusiestApiKeys []apiUsage `json:"busiest_api_keys"`
+}
+
+// adminTwoFactorMiddleware admits password-authenticated users who are admins and present a valid
+// one-time code. Each code is accepted once. Users who are not admins and admins without a valid
+// code get the same 401 as a wrong password, so that the response does not tell who is an admin.
+func (s *Service) adminTwoFactorMiddleware() fiber.Handler {
+	const op errors.Op = "server.Service.adminTwoFactorMiddleware"
+	if s == nil {
+		return serverErrorHandler()
+	}
+
+	return func(c *fiber.Ctx) error {
+		reqCtx, err := getRequestContext(c)
+		if err != nil || reqCtx.User == nil {
+			err = errors.New(op).Err(err).Msg("Request context missing")
+			s.logger.ErrorWith().Err(err).Msg("getRequestContext failed")
+			return c.Status(fiber.StatusInternalServerError).JSON(jsonInternalError)
+		}
+
+		request := reqCtx.Request.adminRequest
+
+		ctx := c.UserContext()
+		sealed, lastStep, found, err := s.fetchAdminSecret(ctx, reqCtx.User.ID)
+		if err != nil {
+			s.logger.ErrorWith().Err(errors.New(op).Err(err)).Msg("s.fetchAdminSecret failed")
+			return s.dbFailure(c, err)
+		}
+		if !found {
+			s.logger.InfoWith().Str("callsign", reqCtx.User.Callsign).Str("ip", c.IP()).Msg("Admin route called by a non-admin")
+			s.audit(c, auditEntry{Event: auditAdminAuthFailed, Actor: reqCtx.Actor, Callsign: reqCtx.User.Callsign}, fiber.Map{"reason": "not_admin", "path": c.Path()})
+			return c.Status(fiber.StatusUnauthorized).JSON(jsonUnauthorized)
+		}
+		secret, err := s.decryptCredential(sealed)
+		if err != nil {
+			s.logger.ErrorWith().Err(errors.New(op).Err(err)).Msg("s.decryptCredential failed")
+			return c.Status(fiber.StatusInternalServerError).JSON(jsonInternalError)
+		}
+
+		step, ok := verifyTotp(secret, request.OTP, time.Now(), lastStep)
+		if ok {
+			if ok, err = s.consumeAdminOtpStep(ctx, reqCtx.User.ID, step); err != nil {
+				s.logger.ErrorWith().Err(errors.New(op).Err(err)).Msg("s.consumeAdminOtpStep failed")
+				return s.dbFailure(c, err)
+			}
+		}
+		if !ok {
+			s.logger.InfoWith().Str("callsign", reqCtx.User.Callsign).Str("ip", c.IP()).Msg("Invalid admin one-time code")
+			s.audit(c, auditEntry{Event: auditAdminAuthFailed, Actor: reqCtx.Actor, Callsign: reqCtx.User.Callsign}, fiber.Map{"reason": "invalid_otp", "path": c.Path()})
+			return c.Status(fiber.StatusUnauthorized).JSON(jsonUnauthorized)
+		}
+
+		return c.Next()
+	}
+}
+
+// adminRequestOf returns the admin fields of the request envelope, which are empty for the routes
+// authenticated with the admin token.
+func adminRequestOf(c *fiber.Ctx) adminRequest {
+	if reqCtx, err := getRequestContext(c); err == nil {
+		return reqCtx.Request.adminRequest
+	}
+	return adminRequest{}
+}
+
+// fetchAdminSecret returns the admin's encrypted TOTP secret and the last time step they used. It
+// reports false when the user is not an admin.
+func (s *Service) fetchAdminSecret(ctx context.Context, userID int64) (string, int64, bool, error) {
+	const op errors.Op = "server.Service.fetchAdminSecret"
+
+	rows, err := s.db.QueryContext(ctx, `SELECT totp_secret, last_otp_step FROM admins WHERE user_id = $1`, userID)
+	if err != nil {
+		return emptyString, 0, false, errors.New(op).Err(err)
+	}
+	defer func() { _ = rows.Close() }()
+
+	var (
+		secret   string
+		lastStep int64
+	)
+	found := rows.Next()
+	if found {
+		if err = rows.Scan(&secret, &lastStep); err != nil {
+			return emptyString, 0, false, errors.New(op).Err(err)
+		}
+	}
+	if err = rows.Err(); err != nil {
+		return emptyString, 0, false, errors.New(op).Err(err)
+	}
+	return secret, lastStep, found, nil
+}
+
+// consumeAdminOtpStep records that the admin used the code of the time step. It reports false when
+// that step, or a later one, was already used, e.g. by a concurrent request with the same code.
+func (s *Service) consumeAdminOtpStep(ctx context.Context, userID, step int64) (bool, error) {
+	const op errors.Op = "server.Service.consumeAdminOtpStep"
+
+	res, err := s.db.ExecContext(ctx, `UPDATE admins SET last_otp_step = $1 WHERE user_id = $2 AND last_otp_step < $1`, step, userID)
+	if err != nil {
+		return false, errors.New(op).Err(err)
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return false, errors.New(op).Err(err)
+	}
+	return n > 0, nil
+}
+
+// saveAdmin makes the user an admin with the encrypted TOTP secret, replacing any secret they had.
+func (s *Service) saveAdmin(ctx context.Context, userID int64, sealedSecret string) error {
+	const op errors.Op = "server.Service.saveAdmin"
+
+	if _, err := s.db.ExecContext(ctx, `INSERT INTO admins (user_id, totp_secret) VALUES ($1, $2)
+		ON CONFLICT (user_id) DO UPDATE SET totp_secret = excluded.totp_secret, last_otp_step = 0`, userID, sealedSecret); err != nil {
+		return errors.New(op).Err(err)
+	}
+	return nil
+}
+
+// removeAdmin takes the admin role away from the user. It reports false when they were not an admin.
+func (s *Service) removeAdmin(ctx context.Context, userID int64) (bool, error) {
+	const op errors.Op = "server.Service.removeAdmin"
+
+	res, err := s.db.ExecContext(ctx, `DELETE FROM admins WHERE user_id = $1`, userID)
+	if err != nil {
+		return false, errors.New(op).Err(err)
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return false, errors.New(op).Err(err)
+	}
+	return n > 0, nil
+}
+
+// listAdmins returns the admins in callsign order.
+func (s *Service) listAdmins(ctx context.Context) ([]adminAccount, error) {
+	const op errors.Op = "server.Service.listAdmins"
+
+	rows, err := s.db.QueryContext(ctx, `SELECT u.callsign, `+s.timestampExpr(`a.created_at`)+`
+		FROM admins a JOIN users u ON u.id = a.user_id ORDER BY u.callsign`)
+	if err != nil {
+		return nil, errors.New(op).Err(err)
+	}
+	defer func() { _ = rows.Close() }()
+
+	admins := make([]adminAccount, 0)
+	for rows.Next() {
+		var a adminAccount
+		if err = rows.Scan(&a.Callsign, &a.CreatedAt); err != nil {
+			return nil, errors.New(op).Err(err)
+		}
+		admins = append(admins, a)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, errors.New(op).Err(err)
+	}
+	return admins, nil
+}
+
+// listUsers returns up to limit users whose ID is above afterID, in ID order.
+func (s *Service) listUsers(ctx context.Context, afterID int64, limit int) ([]userSummary, error) {
+	const op errors.Op = "server.Service.listUsers"
+
+	rows, err := s.db.QueryContext(ctx, `SELECT u.id, u.callsign, COALESCE(u.email, ''), COALESCE(u.email_confirmed, FALSE), `+
+		s.timestampExpr(`u.created_at`)+`, `+s.timestampExpr(`u.disabled_at`)+`,
+			EXISTS (SELECT 1 FROM admins a WHERE a.user_id = u.id),
+			(SELECT COUNT(*) FROM logbook l WHERE l.user_id = u.id)
+		FROM users u WHERE u.id > $1 ORDER BY u.id LIMIT $2`, afterID, limit)
+	if err != nil {
+		return nil, errors.New(op).Err(err)
+	}
+	defer func() { _ = rows.Close() }()
+
+	users := make([]userSummary, 0)
+	for rows.Next() {
+		var u userSummary
+		if err = rows.Scan(&u.ID, &u.Callsign, &u.Email, &u.EmailConfirmed, &u.CreatedAt, &u.DisabledAt, &u.Admin, &u.Logbooks); err != nil {
+			return nil, errors.New(op).Err(err)
+		}
+		users = append(users, u)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, errors.New(op).Err(err)
+	}
+	return users, nil
+}
+
+// isUserDisabled reports whether an admin has disabled the user's account.
+func (s *Service) isUserDisabled(ctx context.Context, userID int64) (bool, error) {
+	const op errors.Op = "server.Service.isUserDisabled"
+
+	rows, err := s.db.QueryContext(ctx, `SELECT COUNT(*) FROM users WHERE id = $1 AND disabled_at IS NOT NULL`, userID)
+	if err != nil {
+		return false, errors.New(op).Err(err)
+	}
+	defer func() { _ = rows.Close() }()
+
+	var n int
+	if rows.Next() {
+		if err = rows.Scan(&n); err != nil {
+			return false, errors.New(op).Err(err)
+		}
+	}
+	if err = rows.Err(); err != nil {
+		return false, errors.New(op).Err(err)
+	}
+	return n > 0, nil
+}
+
+// setUserDisabled disables or re-enables the user's account. A disabled user can neither sign in
+// nor use the API keys of their logbooks, or the keys issued to them as a member. The cached
+// records are dropped so that the change takes effect at once. It reports false when the account
+// was already in that state.
+func (s *Service) setUserDisabled(ctx context.Context, userID int64, callsign string, disabled bool) (bool, error) {
+	const op errors.Op = "server.Service.setUserDisabled"
+
+	query := `UPDATE users SET disabled_at = CURRENT_TIMESTAMP WHERE id = $1 AND disabled_at IS NULL`
+	if !disabled {
+		query = `UPDATE users SET disabled_at = NULL WHERE id = $1 AND disabled_at IS NOT NULL`
+	}
+	res, err := s.db.ExecContext(ctx, query, userID)
+	if err != nil {
+		return false, errors.New(op).Err(err)
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return false, errors.New(op).Err(err)
+	}
+
+	rows, err := s.db.QueryContext(ctx, `SELECT key_prefix FROM api_keys
+		WHERE logbook_id IN (SELECT id FROM logbook WHERE user_id = $1) OR user_id = $1`, userID)
+	if err != nil {
+		return false, errors.New(op).Err(err)
+	}
+	var prefixes []string
+	for rows.Next() {
+		var prefix string
+		if err = rows.Scan(&prefix); err != nil {
+			break
+		}
+		prefixes = append(prefixes, prefix)
+	}
+	if err == nil {
+		err = rows.Err()
+	}
+	_ = rows.Close()
+	if err != nil {
+		return false, errors.New(op).Err(err)
+	}
+
+	for _, prefix := range prefixes {
+		s.invalidateApiKey(ctx, prefix)
+	}
+	s.invalidateUser(ctx, callsign)
+
+	return n > 0, nil
+}
+
+// revokeApiKey revokes the API key with the prefix, whichever logbook it belongs to. It reports
+// false when there is no such active key.
+func (s *Service) revokeApiKey(ctx context.Context, prefix, revokedBy string) (bool, error) {
+	const op errors.Op = "server.Service.revokeApiKey"
+
+	res, err := s.db.ExecContext(ctx, `UPDATE api_keys SET revoked_at = CURRENT_TIMESTAMP, revoked_by = $2
+		WHERE key_prefix = $1 AND revoked_at IS NULL`, prefix, revokedBy)
+	if err != nil {
+		return false, errors.New(op).Err(err)
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return false, errors.New(op).Err(err)
+	}
+	if n > 0 {
+		s.invalidateApiKey(ctx, prefix)
+	}
+	return n > 0, nil
+}
+
+// fetchSystemStats counts the server's records and reports the process's resource use.
+func (s *Service) fetchSystemStats(ctx context.Context) (systemStats, error) {
+	const op errors.Op = "server.Service.fetchSystemStats"
+
+	var stats systemStats
+	var err error
+	if err = s.flushUsage(ctx); err != nil {
+		s.logger.WarnWith().Err(errors.New(op).Err(err)).Msg("s.flushUsage failed")
+	}
+	if stats.BusiestApiKeys, err = s.listBusiestKeys(ctx, adminUsageTopKeys); err != nil {
+		return systemStats{}, errors.New(op).Err(err)
+	}
+
+	rows, err := s.db.QueryContext(ctx, `SELECT
+		(SELECT COUNT(*) FROM users),
+		(SELECT COUNT(*) FROM users WHERE disabled_at IS NOT NULL),
+		(SELECT COUNT(*) FROM logbook WHERE deleted_at IS NULL),
+		(SELECT COUNT(*) FROM qso WHERE deleted_at IS NULL),
+		(SELECT COUNT(*) FROM api_keys WHERE revoked_at IS NULL),
+		(SELECT COUNT(*) FROM webhooks)`)
+	if err != nil {
+		return systemStats{}, errors.New(op).Err(err)
+	}
+	defer func() { _ = rows.Close() }()
+
+	if rows.Next() {
+		if err = rows.Scan(&stats.Users, &stats.DisabledUsers, &stats.Logbooks, &stats.Qsos, &stats.ActiveApiKeys, &stats.Webhooks); err != nil {
+			return systemStats{}, errors.New(op).Err(err)
+		}
+	}
+	if err = rows.Err(); err != nil {
+		return systemStats{}, errors.New(op).Err(err)
+	}
+
+	var mem runtime.MemStats
+	runtime.ReadMemStats(&mem)
+	stats.Goroutines = runtime.NumGoroutine()
+	stats.HeapBytes = mem.HeapAlloc
+	stats.Caches = s.cacheDetails()
+	return stats, nil
+}
@@ -0,0 +1,275 @@
+package service
+
+import (
+	"strings"
+
+	"github.com/Station-Manager/errors"
+	"github.com/gofiber/fiber/v2"
+)
+
+// enrollAdminRequest is the body of POST /admin/admins.
+type enrollAdminRequest struct {
+	Callsign string `json:"callsign" validate:"required,min=3,max=32"`
+}
+
+// enrollAdminHandler makes a user an admin and returns a new TOTP secret for their authenticator
+// app. Enrolling an admin again replaces their secret. The secret is only ever returned in this
+// response.
+func (s *Service) enrollAdminHandler(c *fiber.Ctx) error {
+	const op errors.Op = "server.Service.enrollAdminHandler"
+
+	var request enrollAdminRequest
+	if err := c.BodyParser(&request); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(jsonBadRequest)
+	}
+	request.Callsign = strings.ToUpper(strings.TrimSpace(request.Callsign))
+	if err := s.validate.Struct(request); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(jsonBadRequest)
+	}
+	if len(s.credentialsKey) == 0 {
+		return c.Status(fiber.StatusServiceUnavailable).JSON(jsonError(errCodeCredentialStorageDisabled, "Credential storage is not configured on this server"))
+	}
+
+	ctx := c.UserContext()
+	userID, found, err := s.fetchUserID(ctx, request.Callsign)
+	if err != nil {
+		s.logger.ErrorWith().Err(errors.New(op).Err(err)).Msg("s.fetchUserID failed")
+		return s.dbFailure(c, err)
+	}
+	if !found {
+		return c.Status(fiber.StatusNotFound).JSON(jsonError(errCodeUserNotFound, "User not found"))
+	}
+
+	secret, err := newTotpSecret()
+	if err != nil {
+		s.logger.ErrorWith().Err(errors.New(op).Err(err)).Msg("newTotpSecret failed")
+		return c.Status(fiber.StatusInternalServerError).JSON(jsonInternalError)
+	}
+	sealed, err := s.encryptCredential(secret)
+	if err != nil {
+		s.logger.ErrorWith().Err(errors.New(op).Err(err)).Msg("s.encryptCredential failed")
+		return c.Status(fiber.StatusInternalServerError).JSON(jsonInternalError)
+	}
+	if err = s.saveAdmin(ctx, userID, sealed); err != nil {
+		s.logger.ErrorWith().Err(errors.New(op).Err(err)).Msg("s.saveAdmin failed")
+		return s.dbFailure(c, err)
+	}
+	s.logger.WarnWith().Str("callsign", request.Callsign).Msg("Admin enrolled")
+
+	return c.Status(fiber.StatusCreated).JSON(fiber.Map{"secret": secret, "uri": totpURI(request.Callsign, secret)})
+}
+
+// listAdminsHandler returns the users who may call the /api/admin routes.
+func (s *Service) listAdminsHandler(c *fiber.Ctx) error {
+	const op errors.Op = "server.Service.listAdminsHandler"
+
+	admins, err := s.listAdmins(c.UserContext())
+	if err != nil {
+		s.logger.ErrorWith().Err(errors.New(op).Err(err)).Msg("s.listAdmins failed")
+		return s.dbFailure(c, err)
+	}
+	return c.JSON(fiber.Map{"admins": admins})
+}
+
+// removeAdminHandler takes the admin role away from the user named in the path.
+func (s *Service) removeAdminHandler(c *fiber.Ctx) error {
+	const op errors.Op = "server.Service.removeAdminHandler"
+
+	ctx := c.UserContext()
+	callsign := strings.ToUpper(strings.TrimSpace(c.Params("callsign")))
+	userID, found, err := s.fetchUserID(ctx, callsign)
+	if err != nil {
+		s.logger.ErrorWith().Err(errors.New(op).Err(err)).Msg("s.fetchUserID failed")
+		return s.dbFailure(c, err)
+	}
+	if found {
+		if found, err = s.removeAdmin(ctx, userID); err != nil {
+			s.logger.ErrorWith().Err(errors.New(op).Err(err)).Msg("s.removeAdmin failed")
+			return s.dbFailure(c, err)
+		}
+	}
+	if !found {
+		return c.Status(fiber.StatusNotFound).JSON(jsonError(errCodeUserNotFound, "The user is not an admin"))
+	}
+	s.logger.WarnWith().Str("callsign", callsign).Msg("Admin removed")
+
+	return c.SendStatus(fiber.StatusNoContent)
+}
+
+// adminListUsersHandler returns a page of users in ID order. The next page starts after the last
+// ID of this one.
+func (s *Service) adminListUsersHandler(c *fiber.Ctx) error {
+	const op errors.Op = "server.Service.adminListUsersHandler"
+
+	request := adminRequestOf(c)
+	limit := request.Limit
+	if limit <= 0 {
+		limit = adminUserListDefault
+	}
+	limit = min(limit, adminUserListMax)
+
+	users, err := s.listUsers(c.UserContext(), max(request.AfterID, 0), limit)
+	if err != nil {
+		s.logger.ErrorWith().Err(errors.New(op).Err(err)).Msg("s.listUsers failed")
+		return s.dbFailure(c, err)
+	}
+	return c.JSON(fiber.Map{"users": users})
+}
+
+// adminDisableUserHandler disables the account named in the request's user field. Admins cannot
+// disable their own account.
+func (s *Service) adminDisableUserHandler(c *fiber.Ctx) error {
+	return s.setUserDisabledHandler(c, true)
+}
+
+// adminEnableUserHandler re-enables the account named in the request's user field.
+func (s *Service) adminEnableUserHandler(c *fiber.Ctx) error {
+	return s.setUserDisabledHandler(c, false)
+}
+
+func (s *Service) setUserDisabledHandler(c *fiber.Ctx, disabled bool) error {
+	const op errors.Op = "server.Service.setUserDisabledHandler"
+
+	reqCtx, err := getRequestContext(c)
+	if err != nil || reqCtx.User == nil {
+		err = errors.New(op).Err(err).Msg("Request context missing")
+		s.logger.ErrorWith().Err(err).Msg("getRequestContext failed")
+		return c.Status(fiber.StatusInternalServerError).JSON(jsonInternalError)
+	}
+	callsign := strings.ToUpper(strings.TrimSpace(adminRequestOf(c).User))
+	if callsign == emptyString {
+		return c.Status(fiber.StatusBadRequest).JSON(jsonBadRequest)
+	}
+
+	ctx := c.UserContext()
+	userID, found, err := s.fetchUserID(ctx, callsign)
+	if err != nil {
+		s.logger.ErrorWith().Err(errors.New(op).Err(err)).Msg("s.fetchUserID failed")
+		return s.dbFailure(c, err)
+	}
+	if !found {
+		return c.Status(fiber.StatusNotFound).JSON(jsonError(errCodeUserNotFound, "User not found"))
+	}
+	if disabled && userID == reqCtx.User.ID {
+		return c.Status(fiber.StatusBadRequest).JSON(jsonError(errCodeBadRequest, "Admins cannot disable their own account"))
+	}
+
+	changed, err := s.setUserDisabled(ctx, userID, callsign, disabled)
+	if err != nil {
+		s.logger.ErrorWith().Err(errors.New(op).Err(err)).Msg("s.setUserDisabled failed")
+		return s.dbFailure(c, err)
+	}
+	if changed {
+		s.logger.WarnWith().Str("admin", reqCtx.User.Callsign).Str("callsign", callsign).Bool("disabled", disabled).Msg("Account state changed by admin")
+	}
+
+	return c.SendStatus(fiber.StatusNoContent)
+}
+
+// adminStatsHandler returns an overview of the server's records and resource use.
+func (s *Service) adminStatsHandler(c *fiber.Ctx) error {
+	const op errors.Op = "server.Service.adminStatsHandler"
+
+	stats, err := s.fetchSystemStats(c.UserContext())
+	if err != nil {
+		s.logger.ErrorWith().Err(errors.New(op).Err(err)).Msg("s.fetchSystemStats failed")
+		return s.dbFailure(c, err)
+	}
+	return c.JSON(stats)
+}
+
+// adminRevokeApiKeyHandler revokes the API key with the request's prefix, whichever logbook it
+// belongs to.
+func (s *Service) adminRevokeApiKeyHandler(c *fiber.Ctx) error {
+	const op errors.Op = "server.Service.adminRevokeApiKeyHandler"
+
+	reqCtx, err := getRequestContext(c)
+	if err != nil || reqCtx.User == nil {
+		err = errors.New(op).Err(err).Msg("Request context missing")
+		s.logger.ErrorWith().Err(err).Msg("getRequestContext failed")
+		return c.Status(fiber.StatusInternalServerError).JSON(jsonInternalError)
+	}
+	prefix := strings.TrimSpace(adminRequestOf(c).Prefix)
+	if prefix == emptyString {
+		return c.Status(fiber.StatusBadRequest).JSON(jsonBadRequest)
+	}
+
+	revoked, err := s.revokeApiKey(c.UserContext(), prefix, "admin:"+reqCtx.User.Callsign)
+	if err != nil {
+		s.logger.ErrorWith().Err(errors.New(op).Err(err)).Msg("s.revokeApiKey failed")
+		return s.dbFailure(c, err)
+	}
+	if !revoked {
+		return c.Status(fiber.StatusNotFound).JSON(jsonError(errCodeApiKeyNotFound, "No active API key has that prefix"))
+	}
+	s.logger.WarnWith().Str("admin", reqCtx.User.Callsign).Str("prefix", prefix).Msg("API key revoked by admin")
+	s.audit(c, auditEntry{Event: auditApiKeyRevoked, Actor: "admin:" + reqCtx.User.Callsign}, fiber.Map{"prefix": prefix})
+
+	return c.SendStatus(fiber.StatusNoContent)
+}
+
+// adminCacheSummaryHandler returns the statistics of this instance's caches and which logbooks and
+// users they hold, up to the request's limit of each.
+func (s *Service) adminCacheSummaryHandler(c *fiber.Ctx) error {
+	limit := adminRequestOf(c).Limit
+	if limit <= 0 {
+		limit = adminCacheKeysDefault
+	}
+	limit = min(limit, adminCacheKeysMax)
+
+	return c.JSON(cacheSummary{
+		Caches:   s.cacheDetails(),
+		Logbooks: s.logbookCache.Keys(limit),
+		Users:    s.userCache.Keys(limit),
+	})
+}
+
+// adminFlushCachesHandler drops a logbook's or a user's cached entry, or with neither given every
+// cached entry, on every server instance.
+func (s *Service) adminFlushCachesHandler(c *fiber.Ctx) error {
+	request := adminRequestOf(c)
+	callsign := strings.ToUpper(strings.TrimSpace(request.User))
+	if request.LogbookID < 0 || (request.LogbookID > 0 && callsign != emptyString) {
+		return c.Status(fiber.StatusBadRequest).JSON(jsonBadRequest)
+	}
+
+	admin := emptyString
+	if reqCtx, err := getRequestContext(c); err == nil && reqCtx.User != nil {
+		admin = reqCtx.User.Callsign
+	}
+	switch {
+	case request.LogbookID > 0:
+		s.invalidateLogbook(c.UserContext(), request.LogbookID)
+		s.logger.WarnWith().Str("admin", admin).Int64("logbook_id", request.LogbookID).Msg("Logbook cache entry flushed by admin")
+	case callsign != emptyString:
+		s.invalidateUser(c.UserContext(), callsign)
+		s.logger.WarnWith().Str("admin", admin).Str("user", callsign).Msg("User cache entry flushed by admin")
+	default:
+		s.publishInvalidation(c.UserContext(), invalidationEvent{Kind: invalidationKindAll})
+		s.apiKeyNegativeCache.Purge()
+		s.logger.WarnWith().Str("admin", admin).Msg("Caches flushed by admin")
+	}
+
+	return c.SendStatus(fiber.StatusNoContent)
+}
+
+// adminAuditLogHandler returns a page of audit entries, newest first, optionally only those of an
+// event or concerning a user. The next page starts before the last ID of this one.
+func (s *Service) adminAuditLogHandler(c *fiber.Ctx) error {
+	const op errors.Op = "server.Service.adminAuditLogHandler"
+
+	request := adminRequestOf(c)
+	limit := request.Limit
+	if limit <= 0 {
+		limit = auditListDefault
+	}
+	limit = min(limit, auditListMax)
+	callsign := strings.ToUpper(strings.TrimSpace(request.User))
+
+	entries, err := s.listAuditEntries(c.UserContext(), auditEvent(strings.TrimSpace(request.Event)), callsign, request.BeforeID, limit)
+	if err != nil {
+		s.logger.ErrorWith().Err(errors.New(op).Err(err)).Msg("s.listAuditEntries failed")
+		return s.dbFailure(c, err)
+	}
+	return c.JSON(fiber.Map{"entries": entries, "retention": s.auditRetention.String()})
+}
@@ -0,0 +1,232 @@
+package service
+
+import (
+	"context"
+	"crypto/sha256"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Station-Manager/types"
+	"github.com/go-playground/validator/v10"
+	"github.com/goccy/go-json"
+	"github.com/gofiber/fiber/v2"
+)
+
+func TestAdminApi_TwoFactorAndAccounts(t *testing.T) {
+	svc := newTestServer(t)
+	svc.validate = validator.New(validator.WithRequiredStructEnabled())
+	svc.credentialsKey = make([]byte, 32)
+	digest := sha256.Sum256([]byte("admin-token"))
+	svc.adminToken = digest[:]
+	svc.initializeCaches()
+	ctx := context.Background()
+	for _, query := range []string{
+		`INSERT INTO users (id, callsign, pass_hash) VALUES (7, 'W1AW', 'hash'), (8, 'K1AB', 'hash')`,
+		`UPDATE logbook SET user_id = 8 WHERE id = 1`,
+	} {
+		if _, err := svc.db.ExecContext(ctx, query); err != nil {
+			t.Fatalf("setup failed: %v", err)
+		}
+	}
+	users := map[string]int64{"W1AW": 7, "K1AB": 8}
+
+	app := fiber.New()
+	asUser := func(c *fiber.Ctx) error {
+		callsign := c.Get("X-Callsign")
+		c.Locals(localsRequestDataKey, &requestContext{Request: envelopeOf(c, nil), User: &types.User{ID: users[callsign], Callsign: callsign}, IsValid: true})
+		return c.Next()
+	}
+	adminApi := app.Group("/api/admin", asUser, svc.adminTwoFactorMiddleware(), svc.auditAdminMiddleware(auditAdminAction))
+	adminApi.Post("/users", svc.adminListUsersHandler)
+	adminApi.Post("/users/disable", svc.adminDisableUserHandler)
+	adminApi.Post("/users/enable", svc.adminEnableUserHandler)
+	adminApi.Post("/stats", svc.adminStatsHandler)
+	adminApi.Post("/api-keys/revoke", svc.adminRevokeApiKeyHandler)
+	adminApi.Post("/audit", svc.adminAuditLogHandler)
+	app.Post("/admin/admins", svc.adminAuthNMiddleware(), svc.enrollAdminHandler)
+
+	post := func(path, callsign, body string) (int, map[string]any) {
+		req := httptest.NewRequest(fiber.MethodPost, path, strings.NewReader(body))
+		req.Header.Set(fiber.HeaderContentType, fiber.MIMEApplicationJSON)
+		req.Header.Set(fiber.HeaderAuthorization, "Bearer admin-token")
+		req.Header.Set("X-Callsign", callsign)
+		resp, err := app.Test(req)
+		if err != nil {
+			t.Fatalf("POST %s failed: %v", path, err)
+		}
+		var out map[string]any
+		_ = json.NewDecoder(resp.Body).Decode(&out)
+		return resp.StatusCode, out
+	}
+
+	status, out := post("/admin/admins", emptyString, `{"callsign":"w1aw"}`)
+	secret, _ := out["secret"].(string)
+	if status != fiber.StatusCreated || secret == emptyString || !strings.HasPrefix(out["uri"].(string), "otpauth://totp/") {
+		t.Fatalf("expected the admin to be enrolled, got %d %v", status, out)
+	}
+	key, _ := totpEncoding.DecodeString(secret)
+	// Each code is accepted once, so forget the last used step before every request that needs one.
+	otp := func() string {
+		if _, err := svc.db.ExecContext(ctx, `UPDATE admins SET last_otp_step = 0`); err != nil {
+			t.Fatalf("resetting the last used step failed: %v", err)
+		}
+		return totpCode(key, totpStep(time.Now()))
+	}
+
+	status, nonAdmin := post("/api/admin/stats", "K1AB", `{"otp":"`+otp()+`"}`)
+	if status != fiber.StatusUnauthorized {
+		t.Errorf("expected a non-admin to get 401, got %d", status)
+	}
+	status, badCode := post("/api/admin/stats", "W1AW", `{"otp":"12345x"}`)
+	if status != fiber.StatusUnauthorized {
+		t.Errorf("expected a malformed code to get 401, got %d", status)
+	}
+	if nonAdmin["code"] != string(errCodeUnauthorized) || badCode["code"] != nonAdmin["code"] || badCode["message"] != nonAdmin["message"] {
+		t.Errorf("expected non-admins and admins without a valid code to get the same error, got %v and %v", nonAdmin, badCode)
+	}
+
+	code := otp()
+	status, out = post("/api/admin/users", "W1AW", `{"otp":"`+code+`"}`)
+	if status != fiber.StatusOK || len(out["users"].([]any)) != 2 {
+		t.Fatalf("expected two users, got %d %v", status, out)
+	}
+	if status, _ = post("/api/admin/stats", "W1AW", `{"otp":"`+code+`"}`); status != fiber.StatusUnauthorized {
+		t.Errorf("expected a used code to get 401, got %d", status)
+	}
+	status, out = post("/api/admin/stats", "W1AW", `{"otp":"`+otp()+`"}`)
+	if status != fiber.StatusOK || out["users"] != float64(2) || out["logbooks"] != float64(1) {
+		t.Errorf("expected the server's counts, got %d %v", status, out)
+	}
+
+	ownerKey, err := svc.issueMemberApiKey(ctx, 1, 8, "K1AB")
+	if err != nil {
+		t.Fatalf("issueMemberApiKey failed: %v", err)
+	}
+	if member, _ := svc.fetchApiKeyMemberWithCache(ctx, ownerKey); member.Role != logbookRoleOwner {
+		t.Fatalf("expected the owner's key to work, got %q", member.Role)
+	}
+
+	status, _ = post("/api/admin/users/disable", "W1AW", `{"otp":"`+otp()+`","user":"K1AB"}`)
+	if status != fiber.StatusNoContent {
+		t.Fatalf("expected the account to be disabled, got %d", status)
+	}
+	if disabled, _ := svc.isUserDisabled(ctx, 8); !disabled {
+		t.Error("expected K1AB to be disabled")
+	}
+	if member, _ := svc.fetchApiKeyMemberWithCache(ctx, ownerKey); member.Role != emptyString {
+		t.Errorf("expected a disabled account's key to hold no role, got %q", member.Role)
+	}
+
+	status, _ = post("/api/admin/users/disable", "W1AW", `{"otp":"`+otp()+`","user":"W1AW"}`)
+	if status != fiber.StatusBadRequest {
+		t.Errorf("expected an admin not to disable themselves, got %d", status)
+	}
+
+	status, out = post("/api/admin/audit", "W1AW", `{"otp":"`+otp()+`","user":"K1AB"}`)
+	if status != fiber.StatusOK {
+		t.Fatalf("expected the audit log, got %d", status)
+	}
+	events := map[string]int{}
+	for _, e := range out["entries"].([]any) {
+		events[e.(map[string]any)["event"].(string)]++
+	}
+	if events[string(auditAdminAuthFailed)] != 1 || events[string(auditAdminAction)] != 1 {
+		t.Errorf("expected the non-admin's attempt and the disabling of K1AB, got %v", events)
+	}
+}
+
+func TestRevokeApiKey(t *testing.T) {
+	svc := newTestServer(t)
+	svc.initializeCaches()
+	ctx := context.Background()
+	for _, query := range []string{
+		`INSERT INTO users (id, callsign, pass_hash) VALUES (8, 'K1AB', 'hash')`,
+		`UPDATE logbook SET user_id = 8 WHERE id = 1`,
+	} {
+		if _, err := svc.db.ExecContext(ctx, query); err != nil {
+			t.Fatalf("setup failed: %v", err)
+		}
+	}
+
+	key, err := svc.issueMemberApiKey(ctx, 1, 8, "K1AB")
+	if err != nil {
+		t.Fatalf("issueMemberApiKey failed: %v", err)
+	}
+	if member, _ := svc.fetchApiKeyMemberWithCache(ctx, key); member.Role != logbookRoleOwner {
+		t.Fatalf("expected the key to work, got %q", member.Role)
+	}
+
+	prefix := key[:prefixLen]
+	if revoked, err := svc.revokeApiKey(ctx, prefix, "admin:W1AW"); err != nil || !revoked {
+		t.Fatalf("expected the key to be revoked, got %v (err=%v)", revoked, err)
+	}
+	if member, _ := svc.fetchApiKeyMemberWithCache(ctx, key); member.Role != emptyString {
+		t.Errorf("expected a revoked key to hold no role, got %q", member.Role)
+	}
+	if revoked, _ := svc.revokeApiKey(ctx, prefix, "admin:W1AW"); revoked {
+		t.Error("expected a second revocation to find no active key")
+	}
+}
+
+func TestAdminCacheHandlers(t *testing.T) {
+	svc := newTestServer(t)
+	svc.initializeCaches()
+	svc.logbookCache.Set(1, types.Logbook{ID: 1}, 0)
+	svc.logbookCache.Set(2, types.Logbook{ID: 2}, 0)
+	svc.userCache.Set("K1AB", types.User{ID: 8, Callsign: "K1AB"}, 0)
+
+	app := fiber.New()
+	admin := app.Group("/api/admin", func(c *fiber.Ctx) error {
+		c.Locals(localsRequestDataKey, &requestContext{Request: envelopeOf(c, nil), User: &types.User{ID: 7, Callsign: "W1AW"}, IsValid: true})
+		return c.Next()
+	})
+	admin.Post("/cache", svc.adminCacheSummaryHandler)
+	admin.Post("/cache/flush", svc.adminFlushCachesHandler)
+	post := func(path, body string, out any) int {
+		req := httptest.NewRequest(fiber.MethodPost, path, strings.NewReader(body))
+		req.Header.Set(fiber.HeaderContentType, fiber.MIMEApplicationJSON)
+		resp, err := app.Test(req)
+		if err != nil {
+			t.Fatalf("POST %s failed: %v", path, err)
+		}
+		if out != nil {
+			_ = json.NewDecoder(resp.Body).Decode(out)
+		}
+		return resp.StatusCode
+	}
+
+	var summary cacheSummary
+	if status := post("/api/admin/cache", `{}`, &summary); status != fiber.StatusOK || len(summary.Logbooks) != 2 || len(summary.Users) != 1 {
+		t.Fatalf("expected the cached logbooks and user, got %d %+v", status, summary)
+	}
+	if _, ok := summary.Caches["logbooks"]; !ok {
+		t.Errorf("expected the logbook cache's statistics, got %v", summary.Caches)
+	}
+
+	if status := post("/api/admin/cache/flush", `{"logbook_id":1,"user":"K1AB"}`, nil); status != fiber.StatusBadRequest {
+		t.Errorf("expected a logbook and a user together to be refused, got %d", status)
+	}
+	if status := post("/api/admin/cache/flush", `{"logbook_id":1}`, nil); status != fiber.StatusNoContent {
+		t.Fatalf("expected the logbook to be flushed, got %d", status)
+	}
+	if _, ok := svc.logbookCache.Get(1); ok {
+		t.Error("expected logbook 1 to be flushed")
+	}
+	if _, ok := svc.logbookCache.Get(2); !ok {
+		t.Error("expected logbook 2 to stay cached")
+	}
+	if status := post("/api/admin/cache/flush", `{"user":"k1ab"}`, nil); status != fiber.StatusNoContent {
+		t.Fatalf("expected the user to be flushed, got %d", status)
+	}
+	if _, ok := svc.userCache.Get("K1AB"); ok {
+		t.Error("expected K1AB to be flushed")
+	}
+	if status := post("/api/admin/cache/flush", `{}`, nil); status != fiber.StatusNoContent {
+		t.Fatalf("expected every cache to be flushed, got %d", status)
+	}
+	if entries := svc.logbookCache.Stats().Entries; entries != 0 {
+		t.Errorf("expected the logbook cache to be empty, got %d entries", entries)
+	}
+}
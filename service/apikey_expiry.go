@@ -0,0 +1,110 @@
+package service
+
+import (
+	"context"
+	"database/sql"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/Station-Manager/errors"
+)
+
+// envApiKeyMaxIdle names the environment variable holding how long an API key may go unused before
+// it is revoked (e.g. "2160h"). A key never used counts from its creation. Zero, the default, keeps
+// idle keys.
+const envApiKeyMaxIdle = "SM_API_KEY_MAX_IDLE"
+
+const (
+	defaultApiKeyExpiryInterval = time.Hour
+	// apiKeyExpiredBy and apiKeyIdleBy are recorded as the revoker of expired and idle keys.
+	apiKeyExpiredBy = "expiry"
+	apiKeyIdleBy    = "idle"
+)
+
+// loadApiKeyMaxIdle reads how long an API key may go unused from the environment.
+func loadApiKeyMaxIdle() (time.Duration, error) {
+	const op errors.Op = "server.loadApiKeyMaxIdle"
+
+	value := strings.TrimSpace(os.Getenv(envApiKeyMaxIdle))
+	if value == emptyString {
+		return 0, nil
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil || d < 0 {
+		return 0, errors.New(op).Msg(envApiKeyMaxIdle + " must be a non-negative duration")
+	}
+	return d, nil
+}
+
+// expireStaleApiKeys revokes the API keys that have expired or gone unused for too long. The uses
+// not yet stored are stored first, so that a key in use is not taken for idle; if they cannot be
+// stored, idle keys are kept until the next run.
+func (s *Service) expireStaleApiKeys(ctx context.Context) error {
+	maxIdle := s.apiKeyMaxIdle
+	if err := s.flushUsage(ctx); err != nil {
+		s.logger.WarnWith().Err(err).Msg("Failed to store API usage; idle API keys are kept until the next run")
+		maxIdle = 0
+	}
+	n, err := s.expireApiKeys(ctx, time.Now(), maxIdle)
+	if err != nil {
+		return err
+	}
+	if n > 0 {
+		s.logger.InfoWith().Int("api_keys", n).Msg("Stale API keys revoked")
+	}
+	return nil
+}
+
+// expireApiKeys revokes the active API keys that expired by now, as of their expiry, and, if
+// maxIdle is positive, those idle for longer than maxIdle, as of now. A key is idle from its last
+// use as recorded by flushUsage, or from its creation if it was never used. It returns how many
+// were revoked. Revoking an expired key frees its logbook to hold a new one, and drops it from the
+// caches.
+func (s *Service) expireApiKeys(ctx context.Context, now time.Time, maxIdle time.Duration) (int, error) {
+	const op errors.Op = "server.Service.expireApiKeys"
+
+	rows, err := s.db.QueryContext(ctx, `UPDATE api_keys SET revoked_at = expires_at, revoked_by = $2
+		WHERE revoked_at IS NULL AND expires_at <= $1 RETURNING key_prefix`, s.dbTimestamp(now), apiKeyExpiredBy)
+	if err != nil {
+		return 0, errors.New(op).Err(err)
+	}
+	prefixes, err := scanApiKeyPrefixes(rows)
+	if err != nil {
+		return 0, errors.New(op).Err(err)
+	}
+
+	if maxIdle > 0 {
+		rows, err = s.db.QueryContext(ctx, `UPDATE api_keys SET revoked_at = $1, revoked_by = $3
+			WHERE revoked_at IS NULL AND COALESCE(last_used_at, created_at) <= $2 RETURNING key_prefix`,
+			s.dbTimestamp(now), s.dbTimestamp(now.Add(-maxIdle)), apiKeyIdleBy)
+		if err != nil {
+			return 0, errors.New(op).Err(err)
+		}
+		idle, err := scanApiKeyPrefixes(rows)
+		if err != nil {
+			return 0, errors.New(op).Err(err)
+		}
+		prefixes = append(prefixes, idle...)
+	}
+
+	for _, prefix := range prefixes {
+		s.invalidateApiKey(ctx, prefix)
+	}
+	return len(prefixes), nil
+}
+
+// scanApiKeyPrefixes reads and closes rows of key prefixes.
+func scanApiKeyPrefixes(rows *sql.Rows) ([]string, error) {
+	defer func() { _ = rows.Close() }()
+
+	var prefixes []string
+	for rows.Next() {
+		var prefix string
+		if err := rows.Scan(&prefix); err != nil {
+			return nil, err
+		}
+		prefixes = append(prefixes, prefix)
+	}
+	return prefixes, rows.Err()
+}
@@ -0,0 +1,205 @@
+package service
+
+import (
+	"context"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/Station-Manager/errors"
+	"github.com/goccy/go-json"
+	"github.com/gofiber/fiber/v2"
+)
+
+const (
+	// envAuditRetention names the environment variable holding how long audit log entries are
+	// kept, as a Go duration. Zero keeps them forever.
+	envAuditRetention = "SM_AUDIT_RETENTION"
+
+	defaultAuditRetention     = 365 * 24 * time.Hour
+	defaultAuditPurgeInterval = time.Hour
+
+	auditListDefault = 100
+	auditListMax     = 1000
+)
+
+// auditEvent names a kind of audit log entry.
+type auditEvent string
+
+const (
+	auditLogin            auditEvent = "login"
+	auditLoginFailed      auditEvent = "login_failed"
+	auditApiKeyFailed     auditEvent = "api_key_failed"
+	auditApiKeyCreated    auditEvent = "api_key_created"
+	auditApiKeyRevoked    auditEvent = "api_key_revoked"
+	auditLogbookDeleted   auditEvent = "logbook_deleted"
+	auditAccountDeleted   auditEvent = "account_deleted"
+	auditUserCreated      auditEvent = "user_created"
+	auditPasswordChanged  auditEvent = "password_changed"
+	auditEmailVerified    auditEvent = "email_verified"
+	auditAdminAuthFailed  auditEvent = "admin_auth_failed"
+	auditAdminAction      auditEvent = "admin_action"
+	auditAdminTokenAction auditEvent = "admin_token_action"
+)
+
+// Actors of entries that were not made by a user or an API key.
+const (
+	auditActorAdminToken = "admin_token"
+	auditActorCLI        = "cli"
+	auditActorServer     = "server"
+)
+
+// auditEntry is an entry of the audit log. Actor is who acted, in the form used by the QSO
+// history; Callsign is the user the event concerns.
+type auditEntry struct {
+	ID        int64           `json:"id"`
+	CreatedAt string          `json:"created_at"`
+	Event     auditEvent      `json:"event"`
+	Actor     string          `json:"actor,omitempty"`
+	Callsign  string          `json:"callsign,omitempty"`
+	LogbookID *int64          `json:"logbook_id,omitempty"`
+	IP        string          `json:"ip,omitempty"`
+	Details   json.RawMessage `json:"details,omitempty"`
+}
+
+// loadAuditRetention reads the audit log retention from the environment.
+func loadAuditRetention() (time.Duration, error) {
+	const op errors.Op = "server.loadAuditRetention"
+
+	value := strings.TrimSpace(os.Getenv(envAuditRetention))
+	if value == emptyString {
+		return defaultAuditRetention, nil
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil || d < 0 {
+		return 0, errors.New(op).Msg(envAuditRetention + " must be a non-negative duration")
+	}
+	return d, nil
+}
+
+// audit records an event of the request, with the client's address, in the audit log.
+func (s *Service) audit(c *fiber.Ctx, entry auditEntry, details fiber.Map) {
+	entry.IP = c.IP()
+	s.recordAudit(c.UserContext(), entry, details)
+}
+
+// recordAudit writes an entry to the audit log. A failure is logged but not returned: the action
+// being audited has already happened.
+func (s *Service) recordAudit(ctx context.Context, entry auditEntry, details fiber.Map) {
+	const op errors.Op = "server.Service.recordAudit"
+
+	var encoded []byte
+	if len(details) > 0 {
+		var err error
+		if encoded, err = json.Marshal(details); err != nil {
+			s.logger.ErrorWith().Err(errors.New(op).Err(err)).Str("event", string(entry.Event)).Msg("Failed to encode audit details")
+		}
+	}
+
+	// The entry is written even when the request has timed out or its client has gone.
+	if _, err := s.db.ExecContext(context.WithoutCancel(ctx), `INSERT INTO audit_log (event, actor, callsign, logbook_id, ip, details)
+		VALUES ($1, $2, $3, $4, $5, $6)`, string(entry.Event), entry.Actor, entry.Callsign, entry.LogbookID, entry.IP, string(encoded)); err != nil {
+		s.logger.ErrorWith().Err(errors.New(op).Err(err)).Str("event", string(entry.Event)).Msg("Failed to write audit entry")
+	}
+}
+
+// auditLoginFailure records a failed password authentication.
+func (s *Service) auditLoginFailure(c *fiber.Ctx, callsign, reason string) {
+	s.audit(c, auditEntry{Event: auditLoginFailed, Callsign: strings.ToUpper(callsign)}, fiber.Map{"reason": reason, "path": c.Path()})
+}
+
+// auditApiKeyFailure records a failed API key authentication. Only the key's prefix is recorded.
+func (s *Service) auditApiKeyFailure(c *fiber.Ctx, fullKey, reason string) {
+	s.audit(c, auditEntry{Event: auditApiKeyFailed, Actor: apiKeyActor(fullKey)}, fiber.Map{"reason": reason, "path": c.Path()})
+}
+
+// auditLogbookID returns a pointer to id for auditEntry.LogbookID.
+func auditLogbookID(id int64) *int64 {
+	return &id
+}
+
+// listAuditEntries returns up to limit audit entries older than beforeID, newest first. A zero
+// beforeID starts at the newest entry; empty filters match every entry.
+func (s *Service) listAuditEntries(ctx context.Context, event auditEvent, callsign string, beforeID int64, limit int) ([]auditEntry, error) {
+	const op errors.Op = "server.Service.listAuditEntries"
+
+	if beforeID <= 0 {
+		beforeID = 1<<63 - 1
+	}
+	rows, err := s.db.QueryContext(ctx, `SELECT id, `+s.timestampExpr(`created_at`)+`, event, actor, callsign, logbook_id, ip, details
+		FROM audit_log
+		WHERE id < $1 AND ($2 = '' OR event = $2) AND ($3 = '' OR callsign = $3)
+		ORDER BY id DESC LIMIT $4`, beforeID, string(event), callsign, limit)
+	if err != nil {
+		return nil, errors.New(op).Err(err)
+	}
+	defer func() { _ = rows.Close() }()
+
+	entries := make([]auditEntry, 0)
+	for rows.Next() {
+		var (
+			e       auditEntry
+			details string
+		)
+		if err = rows.Scan(&e.ID, &e.CreatedAt, &e.Event, &e.Actor, &e.Callsign, &e.LogbookID, &e.IP, &details); err != nil {
+			return nil, errors.New(op).Err(err)
+		}
+		if details != emptyString {
+			e.Details = json.RawMessage(details)
+		}
+		entries = append(entries, e)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, errors.New(op).Err(err)
+	}
+	return entries, nil
+}
+
+// purgeExpiredAuditLog removes the audit entries older than the retention.
+func (s *Service) purgeExpiredAuditLog(ctx context.Context) error {
+	n, err := s.purgeAuditLog(ctx, time.Now().Add(-s.auditRetention))
+	if err != nil {
+		return err
+	}
+	if n > 0 {
+		s.logger.InfoWith().Int64("entries", n).Msg("Audit log purged")
+	}
+	return nil
+}
+
+// purgeAuditLog deletes the audit entries made before the cutoff.
+func (s *Service) purgeAuditLog(ctx context.Context, before time.Time) (int64, error) {
+	const op errors.Op = "server.Service.purgeAuditLog"
+
+	res, err := s.db.ExecContext(ctx, `DELETE FROM audit_log WHERE created_at < $1`, s.dbTimestamp(before))
+	if err != nil {
+		return 0, errors.New(op).Err(err)
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return 0, errors.New(op).Err(err)
+	}
+	return n, nil
+}
+
+// auditAdminMiddleware records every request to an admin route, with its outcome, once it has
+// been handled. It runs after the admin has been authenticated.
+func (s *Service) auditAdminMiddleware(event auditEvent) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		err := c.Next()
+
+		entry := auditEntry{Event: event, Actor: auditActorAdminToken}
+		if reqCtx, ctxErr := getRequestContext(c); ctxErr == nil && reqCtx.User != nil {
+			entry.Actor = "admin:" + reqCtx.User.Callsign
+		}
+		request := adminRequestOf(c)
+		entry.Callsign = strings.ToUpper(strings.TrimSpace(request.User))
+		details := fiber.Map{"method": c.Method(), "path": c.Path(), "status": c.Response().StatusCode()}
+		if request.Prefix != emptyString {
+			details["prefix"] = request.Prefix
+		}
+		s.audit(c, entry, details)
+
+		return err
+	}
+}
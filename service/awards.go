@@ -0,0 +1,439 @@
+package service
+
+import (
+	"context"
+	"database/sql"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	pgmodels "github.com/Station-Manager/database/postgres/models"
+	sqmodels "github.com/Station-Manager/database/sqlite/models"
+	"github.com/Station-Manager/errors"
+	"github.com/Station-Manager/types"
+	"github.com/aarondl/sqlboiler/v4/queries/qm"
+)
+
+// Awards tracked per QSO. WAS is not tracked because QSOs do not record the contacted station's
+// state.
+const (
+	awardDxcc = "dxcc"
+	awardWaz  = "waz"
+	awardVucc = "vucc"
+)
+
+const (
+	awardQueueSize = 1024
+	wazZones       = 40
+	// awardRebuildBatch is how many QSOs are read, and how many credits are inserted, per
+	// statement when a logbook's award credits are rebuilt.
+	awardRebuildBatch = 500
+)
+
+// trackedAwards lists the awards in the order they are reported.
+var trackedAwards = []string{awardDxcc, awardWaz, awardVucc}
+
+// vuccBands maps the bands that count for VUCC to the number of grids the award requires.
+var vuccBands = map[string]int{
+	"6m": 100, "2m": 100, "1.25m": 50, "70cm": 50, "33cm": 25, "23cm": 25,
+	"13cm": 10, "9cm": 5, "6cm": 5, "3cm": 5, "1.25cm": 5, "6mm": 5, "4mm": 5, "2.5mm": 5, "2mm": 5, "1mm": 5,
+}
+
+var gridFieldSquare = regexp.MustCompile(`^[A-R]{2}[0-9]{2}$`)
+
+// awardTracker keeps the award_credits table in step with QSO events.
+type awardTracker struct {
+	queue chan qsoEvent
+}
+
+func newAwardTracker() *awardTracker {
+	return &awardTracker{queue: make(chan qsoEvent, awardQueueSize)}
+}
+
+// awardCredit is what one QSO counts towards one award.
+type awardCredit struct {
+	Award     string
+	Credit    string // DXCC entity number, CQ zone or grid square
+	Band      string
+	Mode      string // mode group, as returned by modeGroup
+	Confirmed bool
+}
+
+// awardCreditStatus reports one credit of an award.
+type awardCreditStatus struct {
+	Credit    string `json:"credit"`
+	Name      string `json:"name,omitempty"`
+	Confirmed bool   `json:"confirmed"`
+}
+
+// awardSummary is the headline progress towards an award.
+type awardSummary struct {
+	Award     string `json:"award"`
+	Worked    int    `json:"worked"`
+	Confirmed int    `json:"confirmed"`
+	// Total is the number of credits available, or for VUCC the number the band requires. It is
+	// zero when unknown.
+	Total int `json:"total,omitempty"`
+}
+
+// awardProgress details the progress towards an award, optionally limited to a band and mode group.
+type awardProgress struct {
+	awardSummary
+	Band    string              `json:"band,omitempty"`
+	Mode    string              `json:"mode,omitempty"`
+	Credits []awardCreditStatus `json:"credits"`
+	Missing []awardCreditStatus `json:"missing"`
+}
+
+// awardCreditsForQso returns the credits the QSO earns. A QSO is confirmed when a QSL has been
+// received by any route.
+func awardCreditsForQso(qso types.Qso) []awardCredit {
+	band := strings.ToLower(strings.TrimSpace(qso.Band))
+	if band == emptyString {
+		return nil
+	}
+	base := awardCredit{Band: band, Mode: modeGroup(qso.Mode), Confirmed: qso.QslRcvd == "Y"}
+
+	credits := make([]awardCredit, 0, len(trackedAwards))
+	if n, err := strconv.Atoi(strings.TrimSpace(qso.DXCC)); err == nil && n > 0 {
+		c := base
+		c.Award, c.Credit = awardDxcc, strconv.Itoa(n)
+		credits = append(credits, c)
+	}
+	if n, err := strconv.Atoi(strings.TrimSpace(qso.CQZ)); err == nil && n >= 1 && n <= wazZones {
+		c := base
+		c.Award, c.Credit = awardWaz, strconv.Itoa(n)
+		credits = append(credits, c)
+	}
+	if _, ok := vuccBands[band]; ok {
+		grid := strings.ToUpper(strings.TrimSpace(qso.Gridsquare))
+		if len(grid) >= 4 && gridFieldSquare.MatchString(grid[:4]) {
+			c := base
+			c.Award, c.Credit = awardVucc, grid[:4]
+			credits = append(credits, c)
+		}
+	}
+	return credits
+}
+
+// enqueueAwardUpdate queues QSO changes for the award tracker. It is registered with the QSO event
+// broker and must not block; a dropped event is corrected by rebuilding the logbook's awards.
+func (s *Service) enqueueAwardUpdate(event qsoEvent) {
+	select {
+	case s.awards.queue <- event:
+	default:
+		s.logger.WarnWith().Int64("qso_id", event.Qso.ID).Int64("logbook_id", event.LogbookID).Msg("Award queue full; awards may be stale until rebuilt")
+	}
+}
+
+// runAwardTracker applies queued QSO changes to the award credits until ctx is cancelled.
+func (s *Service) runAwardTracker(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case event := <-s.awards.queue:
+			if err := s.applyAwardEvent(ctx, event); err != nil {
+				s.logger.WarnWith().Err(err).Int64("qso_id", event.Qso.ID).Msg("Failed to update award credits")
+			}
+		}
+	}
+}
+
+// applyAwardEvent replaces the credits of an inserted or updated QSO, and removes those of a
+// deleted one.
+func (s *Service) applyAwardEvent(ctx context.Context, event qsoEvent) error {
+	const op errors.Op = "server.Service.applyAwardEvent"
+
+	var credits []awardCredit
+	if event.Type != qsoEventDeleted {
+		credits = awardCreditsForQso(event.Qso)
+	}
+	if err := s.replaceAwardCredits(ctx, event.LogbookID, event.Qso.ID, credits); err != nil {
+		return errors.New(op).Err(err)
+	}
+	return nil
+}
+
+// replaceAwardCredits stores credits as the complete set earned by the QSO.
+func (s *Service) replaceAwardCredits(ctx context.Context, logbookID, qsoID int64, credits []awardCredit) error {
+	const op errors.Op = "server.Service.replaceAwardCredits"
+
+	err := s.withTx(ctx, func(tx *sql.Tx) error {
+		if _, err := tx.ExecContext(ctx, `DELETE FROM award_credits WHERE qso_id = $1`, qsoID); err != nil {
+			return err
+		}
+		for _, c := range credits {
+			if _, err := tx.ExecContext(ctx, `INSERT INTO award_credits (qso_id, award, logbook_id, credit, band, mode, confirmed)
+				VALUES ($1, $2, $3, $4, $5, $6, $7)`, qsoID, c.Award, logbookID, c.Credit, c.Band, c.Mode, c.Confirmed); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return errors.New(op).Err(err)
+	}
+	return nil
+}
+
+// rebuildAwardCredits recomputes the award credits of every QSO in the logbook, for logbooks whose
+// QSOs predate the tracker or whose events were dropped. The credits are replaced in a single
+// transaction, so that a rebuild that fails part way leaves the previous credits in place.
+func (s *Service) rebuildAwardCredits(ctx context.Context, logbookID int64) (int, error) {
+	const op errors.Op = "server.Service.rebuildAwardCredits"
+
+	var count int
+	err := s.withTx(ctx, func(tx *sql.Tx) error {
+		count = 0
+		if _, err := tx.ExecContext(ctx, `DELETE FROM award_credits WHERE logbook_id = $1`, logbookID); err != nil {
+			return err
+		}
+		confirmed, err := s.listConfirmedQsoIDsTx(ctx, tx, logbookID)
+		if err != nil {
+			return err
+		}
+		for afterID := int64(0); ; {
+			qsos, err := s.listLogbookQsosTx(ctx, tx, logbookID, afterID, awardRebuildBatch)
+			if err != nil {
+				return err
+			}
+			if len(qsos) == 0 {
+				return nil
+			}
+			credits := make([]qsoAwardCredit, 0, len(qsos)*len(trackedAwards))
+			for _, qso := range qsos {
+				if confirmed[qso.ID] {
+					// As applyConfirmations reflects an electronic confirmation.
+					qso.QslRcvd = "Y"
+				}
+				for _, c := range awardCreditsForQso(qso) {
+					credits = append(credits, qsoAwardCredit{QsoID: qso.ID, awardCredit: c})
+				}
+			}
+			if err = insertAwardCreditsTx(ctx, tx, logbookID, credits); err != nil {
+				return err
+			}
+			count += len(qsos)
+			afterID = qsos[len(qsos)-1].ID
+		}
+	})
+	if err != nil {
+		return 0, errors.New(op).Err(err)
+	}
+	return count, nil
+}
+
+// qsoAwardCredit is a credit earned by the QSO with the ID.
+type qsoAwardCredit struct {
+	QsoID int64
+	awardCredit
+}
+
+// insertAwardCreditsTx inserts the credits with one statement per awardRebuildBatch of them.
+func insertAwardCreditsTx(ctx context.Context, tx *sql.Tx, logbookID int64, credits []qsoAwardCredit) error {
+	for len(credits) > 0 {
+		batch := credits[:min(len(credits), awardRebuildBatch)]
+		credits = credits[len(batch):]
+
+		var query strings.Builder
+		query.WriteString(`INSERT INTO award_credits (qso_id, award, logbook_id, credit, band, mode, confirmed) VALUES `)
+		args := make([]any, 0, len(batch)*7)
+		for i, c := range batch {
+			if i > 0 {
+				query.WriteString(`, `)
+			}
+			n := len(args)
+			query.WriteString(`($` + strconv.Itoa(n+1) + `, $` + strconv.Itoa(n+2) + `, $` + strconv.Itoa(n+3) + `, $` + strconv.Itoa(n+4) +
+				`, $` + strconv.Itoa(n+5) + `, $` + strconv.Itoa(n+6) + `, $` + strconv.Itoa(n+7) + `)`)
+			args = append(args, c.QsoID, c.Award, logbookID, c.Credit, c.Band, c.Mode, c.Confirmed)
+		}
+		if _, err := tx.ExecContext(ctx, query.String(), args...); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// listConfirmedQsoIDsTx returns the IDs of the logbook's QSOs with an electronic confirmation.
+func (s *Service) listConfirmedQsoIDsTx(ctx context.Context, tx *sql.Tx, logbookID int64) (map[int64]bool, error) {
+	const op errors.Op = "server.Service.listConfirmedQsoIDsTx"
+
+	rows, err := tx.QueryContext(ctx, `SELECT DISTINCT c.qso_id FROM qso_confirmations c JOIN qso q ON q.id = c.qso_id
+		WHERE q.logbook_id = $1 AND q.deleted_at IS NULL`, logbookID)
+	if err != nil {
+		return nil, errors.New(op).Err(err)
+	}
+	defer func() { _ = rows.Close() }()
+
+	confirmed := make(map[int64]bool)
+	for rows.Next() {
+		var id int64
+		if err = rows.Scan(&id); err != nil {
+			return nil, errors.New(op).Err(err)
+		}
+		confirmed[id] = true
+	}
+	if err = rows.Err(); err != nil {
+		return nil, errors.New(op).Err(err)
+	}
+	return confirmed, nil
+}
+
+// listLogbookQsosTx reads up to limit of the logbook's QSOs with an ID above afterID, in insertion
+// order, as the database module would.
+func (s *Service) listLogbookQsosTx(ctx context.Context, tx *sql.Tx, logbookID, afterID int64, limit int) ([]types.Qso, error) {
+	const op errors.Op = "server.Service.listLogbookQsosTx"
+
+	mods := []qm.QueryMod{qm.Where("logbook_id = ? AND deleted_at IS NULL AND id > ?", logbookID, afterID), qm.OrderBy("id"), qm.Limit(limit)}
+	var models []any
+	if s.isPostgres() {
+		found, err := pgmodels.Qsos(mods...).All(ctx, tx)
+		if err != nil {
+			return nil, errors.New(op).Err(err)
+		}
+		for _, m := range found {
+			models = append(models, m)
+		}
+	} else {
+		found, err := sqmodels.Qsos(mods...).All(ctx, tx)
+		if err != nil {
+			return nil, errors.New(op).Err(err)
+		}
+		for _, m := range found {
+			models = append(models, m)
+		}
+	}
+
+	adapter := s.qsoTypeAdapter()
+	qsos := make([]types.Qso, 0, len(models))
+	for _, m := range models {
+		var qso types.Qso
+		if err := adapter.Into(&qso, m); err != nil {
+			return nil, errors.New(op).Err(err)
+		}
+		qsos = append(qsos, qso)
+	}
+	return qsos, nil
+}
+
+// listAwardSummaries returns the logbook's progress towards every tracked award.
+func (s *Service) listAwardSummaries(ctx context.Context, logbookID int64) ([]awardSummary, error) {
+	const op errors.Op = "server.Service.listAwardSummaries"
+
+	summaries := make([]awardSummary, 0, len(trackedAwards))
+	for _, award := range trackedAwards {
+		progress, err := s.fetchAwardProgress(ctx, logbookID, award, emptyString, emptyString)
+		if err != nil {
+			return nil, errors.New(op).Err(err)
+		}
+		summaries = append(summaries, progress.awardSummary)
+	}
+	return summaries, nil
+}
+
+// fetchAwardProgress returns the credits worked towards the award and, where the set of credits is
+// known, those still missing. band and mode, when set, limit the credits to one band and mode group.
+func (s *Service) fetchAwardProgress(ctx context.Context, logbookID int64, award, band, mode string) (awardProgress, error) {
+	const op errors.Op = "server.Service.fetchAwardProgress"
+
+	query := `SELECT credit, MAX(CASE WHEN confirmed THEN 1 ELSE 0 END) FROM award_credits WHERE logbook_id = $1 AND award = $2`
+	args := []interface{}{logbookID, award}
+	if band != emptyString {
+		args = append(args, band)
+		query += ` AND band = $` + strconv.Itoa(len(args))
+	}
+	if mode != emptyString {
+		args = append(args, mode)
+		query += ` AND mode = $` + strconv.Itoa(len(args))
+	}
+	query += ` GROUP BY credit`
+
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return awardProgress{}, errors.New(op).Err(err)
+	}
+	defer func() { _ = rows.Close() }()
+
+	names, universe := s.awardCreditUniverse(award)
+	progress := awardProgress{
+		awardSummary: awardSummary{Award: award},
+		Band:         band,
+		Mode:         mode,
+		Credits:      make([]awardCreditStatus, 0),
+		Missing:      make([]awardCreditStatus, 0),
+	}
+	worked := make(map[string]bool)
+	for rows.Next() {
+		var status awardCreditStatus
+		var confirmed int
+		if err = rows.Scan(&status.Credit, &confirmed); err != nil {
+			return awardProgress{}, errors.New(op).Err(err)
+		}
+		status.Confirmed = confirmed == 1
+		status.Name = names[status.Credit]
+		worked[status.Credit] = true
+		progress.Credits = append(progress.Credits, status)
+		progress.Worked++
+		if status.Confirmed {
+			progress.Confirmed++
+		}
+	}
+	if err = rows.Err(); err != nil {
+		return awardProgress{}, errors.New(op).Err(err)
+	}
+
+	for _, credit := range universe {
+		if !worked[credit] {
+			progress.Missing = append(progress.Missing, awardCreditStatus{Credit: credit, Name: names[credit]})
+		}
+	}
+	progress.Total = len(universe)
+	if award == awardVucc {
+		progress.Total = vuccBands[band]
+	}
+	sortAwardCredits(progress.Credits)
+	sortAwardCredits(progress.Missing)
+
+	return progress, nil
+}
+
+// awardCreditUniverse returns the display names of the award's credits and, when the full set is
+// known, every credit. DXCC entities come from the prefix database and are unknown until it loads.
+func (s *Service) awardCreditUniverse(award string) (map[string]string, []string) {
+	names := make(map[string]string)
+	var universe []string
+	switch award {
+	case awardDxcc:
+		if s.cty == nil {
+			break
+		}
+		if db := s.cty.db.Load(); db != nil {
+			for _, entity := range db.List() {
+				credit := strconv.Itoa(entity.DXCC)
+				if _, ok := names[credit]; !ok {
+					names[credit] = entity.Name
+					universe = append(universe, credit)
+				}
+			}
+		}
+	case awardWaz:
+		for zone := 1; zone <= wazZones; zone++ {
+			universe = append(universe, strconv.Itoa(zone))
+		}
+	}
+	return names, universe
+}
+
+// sortAwardCredits orders numeric credits numerically and others, such as grid squares, as text.
+func sortAwardCredits(credits []awardCreditStatus) {
+	sort.Slice(credits, func(i, j int) bool {
+		a, errA := strconv.Atoi(credits[i].Credit)
+		b, errB := strconv.Atoi(credits[j].Credit)
+		if errA == nil && errB == nil {
+			return a < b
+		}
+		return credits[i].Credit < credits[j].Credit
+	})
+}
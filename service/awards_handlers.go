@@ -0,0 +1,91 @@
+package service
+
+import (
+	"strings"
+
+	"github.com/Station-Manager/errors"
+	"github.com/gofiber/fiber/v2"
+)
+
+// awardProgressQuery holds the optional filters of an award progress request.
+type awardProgressQuery struct {
+	Band string `query:"band" validate:"max=10"`
+	Mode string `query:"mode" validate:"omitempty,oneof=CW PHONE DATA"`
+}
+
+// listAwardsHandler returns the authenticated logbook's progress towards every tracked award.
+func (s *Service) listAwardsHandler(c *fiber.Ctx) error {
+	const op errors.Op = "server.Service.listAwardsHandler"
+
+	logbook, err := authenticatedLogbook(c)
+	if err != nil {
+		s.logger.ErrorWith().Err(errors.New(op).Err(err)).Msg("authenticatedLogbook failed")
+		return c.Status(fiber.StatusInternalServerError).JSON(jsonInternalError)
+	}
+
+	summaries, err := s.listAwardSummaries(c.UserContext(), logbook.ID)
+	if err != nil {
+		s.logger.ErrorWith().Err(errors.New(op).Err(err)).Msg("s.listAwardSummaries failed")
+		return s.dbFailure(c, err)
+	}
+
+	return c.JSON(summaries)
+}
+
+// getAwardHandler returns the credits worked and missing for one award, optionally limited to a
+// band and mode group.
+func (s *Service) getAwardHandler(c *fiber.Ctx) error {
+	const op errors.Op = "server.Service.getAwardHandler"
+
+	logbook, err := authenticatedLogbook(c)
+	if err != nil {
+		s.logger.ErrorWith().Err(errors.New(op).Err(err)).Msg("authenticatedLogbook failed")
+		return c.Status(fiber.StatusInternalServerError).JSON(jsonInternalError)
+	}
+
+	award := strings.ToLower(c.Params("award"))
+	known := false
+	for _, a := range trackedAwards {
+		known = known || a == award
+	}
+	if !known {
+		return c.Status(fiber.StatusNotFound).JSON(jsonError(errCodeUnknownAward, "Unknown award"))
+	}
+
+	var query awardProgressQuery
+	if err = c.QueryParser(&query); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(jsonBadRequest)
+	}
+	query.Band = strings.ToLower(strings.TrimSpace(query.Band))
+	query.Mode = strings.ToUpper(strings.TrimSpace(query.Mode))
+	if err = s.validate.Struct(query); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(jsonBadRequest)
+	}
+
+	progress, err := s.fetchAwardProgress(c.UserContext(), logbook.ID, award, query.Band, query.Mode)
+	if err != nil {
+		s.logger.ErrorWith().Err(errors.New(op).Err(err)).Msg("s.fetchAwardProgress failed")
+		return s.dbFailure(c, err)
+	}
+
+	return c.JSON(progress)
+}
+
+// rebuildAwardsHandler recomputes the authenticated logbook's award credits from its QSOs.
+func (s *Service) rebuildAwardsHandler(c *fiber.Ctx) error {
+	const op errors.Op = "server.Service.rebuildAwardsHandler"
+
+	logbook, err := authenticatedLogbook(c)
+	if err != nil {
+		s.logger.ErrorWith().Err(errors.New(op).Err(err)).Msg("authenticatedLogbook failed")
+		return c.Status(fiber.StatusInternalServerError).JSON(jsonInternalError)
+	}
+
+	count, err := s.rebuildAwardCredits(c.UserContext(), logbook.ID)
+	if err != nil {
+		s.logger.ErrorWith().Err(errors.New(op).Err(err)).Msg("s.rebuildAwardCredits failed")
+		return s.dbFailure(c, err)
+	}
+
+	return c.JSON(fiber.Map{"qsos": count})
+}
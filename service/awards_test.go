@@ -0,0 +1,185 @@
+package service
+
+import (
+	"context"
+	"io"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/Station-Manager/types"
+	"github.com/goccy/go-json"
+	"github.com/gofiber/fiber/v2"
+)
+
+func testAwardQso(id int64, band, mode, dxcc, cqz, grid string) types.Qso {
+	qso := types.Qso{ID: id, LogbookID: 1}
+	qso.Band = band
+	qso.Mode = mode
+	qso.DXCC = dxcc
+	qso.CQZ = cqz
+	qso.Gridsquare = grid
+	return qso
+}
+
+func TestAwardCreditsForQso(t *testing.T) {
+	credits := awardCreditsForQso(testAwardQso(1, "2M", "USB", "291", "5", "fn31pr"))
+	if len(credits) != 3 {
+		t.Fatalf("expected 3 credits, got %+v", credits)
+	}
+	if c := credits[2]; c.Award != awardVucc || c.Credit != "FN31" || c.Band != "2m" || c.Mode != "PHONE" {
+		t.Errorf("unexpected VUCC credit %+v", c)
+	}
+
+	// HF QSOs do not count for VUCC, and invalid zones are ignored.
+	credits = awardCreditsForQso(testAwardQso(1, "20m", "FT8", "291", "41", "FN31"))
+	if len(credits) != 1 || credits[0].Award != awardDxcc {
+		t.Errorf("expected only a DXCC credit, got %+v", credits)
+	}
+}
+
+func TestAwards_TracksWorkedAndConfirmed(t *testing.T) {
+	svc := newTestServer(t)
+	svc.awards = newAwardTracker()
+	ctx := context.Background()
+
+	first := insertTestQso(t, svc, "JA1XX", "20m", "CW", "20240430", "1200")
+	second := insertTestQso(t, svc, "K1AB", "40m", "FT8", "20240430", "1300")
+	third := insertTestQso(t, svc, "JA2XX", "40m", "SSB", "20240501", "1300")
+
+	events := []qsoEvent{
+		{Type: qsoEventInserted, LogbookID: 1, Qso: testAwardQso(first, "20m", "CW", "339", "25", "PM95")},
+		{Type: qsoEventInserted, LogbookID: 1, Qso: testAwardQso(second, "40m", "FT8", "291", "5", "FN31")},
+		{Type: qsoEventInserted, LogbookID: 1, Qso: testAwardQso(third, "40m", "SSB", "339", "25", "PM85")},
+	}
+	for _, event := range events {
+		if err := svc.applyAwardEvent(ctx, event); err != nil {
+			t.Fatalf("applyAwardEvent failed: %v", err)
+		}
+	}
+
+	// A confirmation arrives for the first QSO.
+	confirmed := events[0]
+	confirmed.Type = qsoEventUpdated
+	confirmed.Qso.QslRcvd = "Y"
+	if err := svc.applyAwardEvent(ctx, confirmed); err != nil {
+		t.Fatalf("applyAwardEvent failed: %v", err)
+	}
+
+	progress, err := svc.fetchAwardProgress(ctx, 1, awardWaz, emptyString, emptyString)
+	if err != nil {
+		t.Fatalf("fetchAwardProgress failed: %v", err)
+	}
+	if progress.Worked != 2 || progress.Confirmed != 1 || progress.Total != wazZones || len(progress.Missing) != wazZones-2 {
+		t.Errorf("unexpected WAZ progress %+v", progress.awardSummary)
+	}
+	if progress.Credits[0].Credit != "5" || progress.Credits[1].Credit != "25" || !progress.Credits[1].Confirmed {
+		t.Errorf("unexpected WAZ credits %+v", progress.Credits)
+	}
+
+	progress, err = svc.fetchAwardProgress(ctx, 1, awardDxcc, "40m", "PHONE")
+	if err != nil {
+		t.Fatalf("fetchAwardProgress failed: %v", err)
+	}
+	if progress.Worked != 1 || progress.Confirmed != 0 || progress.Credits[0].Credit != "339" {
+		t.Errorf("unexpected filtered DXCC progress %+v", progress)
+	}
+
+	// Deleting the only 20m QSO removes its credits.
+	deleted := events[0]
+	deleted.Type = qsoEventDeleted
+	if err = svc.applyAwardEvent(ctx, deleted); err != nil {
+		t.Fatalf("applyAwardEvent failed: %v", err)
+	}
+	if progress, err = svc.fetchAwardProgress(ctx, 1, awardDxcc, "20m", emptyString); err != nil || progress.Worked != 0 {
+		t.Errorf("expected no 20m credits after delete, got %+v (err=%v)", progress.awardSummary, err)
+	}
+}
+
+func TestAwardHandlers(t *testing.T) {
+	svc := newTestServer(t)
+	svc.awards = newAwardTracker()
+	id := insertTestQso(t, svc, "JA1XX", "20m", "CW", "20240430", "1200")
+	if err := svc.applyAwardEvent(context.Background(), qsoEvent{Type: qsoEventInserted, LogbookID: 1,
+		Qso: testAwardQso(id, "20m", "CW", "339", "25", "PM95")}); err != nil {
+		t.Fatalf("applyAwardEvent failed: %v", err)
+	}
+
+	app := fiber.New()
+	app.Get("/awards", withLogbook(1, svc.listAwardsHandler))
+	app.Get("/awards/:award", withLogbook(1, svc.getAwardHandler))
+
+	resp, err := app.Test(httptest.NewRequest("GET", "/awards", nil))
+	if err != nil || resp.StatusCode != fiber.StatusOK {
+		t.Fatalf("expected 200, got %v (err=%v)", resp.StatusCode, err)
+	}
+	var summaries []awardSummary
+	body, _ := io.ReadAll(resp.Body)
+	if err = json.Unmarshal(body, &summaries); err != nil || len(summaries) != len(trackedAwards) {
+		t.Fatalf("unexpected summaries %s (err=%v)", body, err)
+	}
+	if summaries[0].Award != awardDxcc || summaries[0].Worked != 1 {
+		t.Errorf("unexpected DXCC summary %+v", summaries[0])
+	}
+
+	for path, want := range map[string]int{
+		"/awards/waz?band=20m&mode=cw": fiber.StatusOK,
+		"/awards/was":                  fiber.StatusNotFound,
+		"/awards/waz?mode=SSB":         fiber.StatusBadRequest,
+	} {
+		resp, err = app.Test(httptest.NewRequest("GET", path, nil))
+		if err != nil || resp.StatusCode != want {
+			t.Errorf("%s: expected %d, got %v (err=%v)", path, want, resp.StatusCode, err)
+		}
+	}
+}
+
+func TestRebuildAwardCredits(t *testing.T) {
+	svc := newTestServer(t)
+	ctx := context.Background()
+	if _, err := svc.db.ExecContext(ctx, `INSERT OR IGNORE INTO session (id) VALUES (1)`); err != nil {
+		t.Fatalf("insert session failed: %v", err)
+	}
+
+	var ids []int64
+	for _, call := range []string{"JA1XX", "K1AB", "W1AW"} {
+		qso := testPreparedQso(call)
+		qso.DXCC, qso.CQZ = "291", "5"
+		if call == "JA1XX" {
+			qso.DXCC, qso.CQZ = "339", "25"
+		}
+		inserted, err := svc.db.InsertQsoContext(ctx, qso)
+		if err != nil {
+			t.Fatalf("InsertQsoContext failed: %v", err)
+		}
+		ids = append(ids, inserted.ID)
+	}
+	if _, err := svc.upsertQsoConfirmation(ctx, ids[0], qsoConfirmation{Source: confirmationSourceLotw, ReceivedDate: "20240502"}); err != nil {
+		t.Fatalf("upsertQsoConfirmation failed: %v", err)
+	}
+	// A stale credit of a QSO that no longer earns it.
+	if err := svc.replaceAwardCredits(ctx, 1, ids[2], []awardCredit{{Award: awardDxcc, Credit: "1", Band: "20m", Mode: "DATA"}}); err != nil {
+		t.Fatalf("replaceAwardCredits failed: %v", err)
+	}
+
+	count, err := svc.rebuildAwardCredits(ctx, 1)
+	if err != nil || count != len(ids) {
+		t.Fatalf("expected %d QSOs rebuilt, got %d (%v)", len(ids), count, err)
+	}
+	progress, err := svc.fetchAwardProgress(ctx, 1, awardDxcc, emptyString, emptyString)
+	if err != nil {
+		t.Fatalf("fetchAwardProgress failed: %v", err)
+	}
+	if progress.Worked != 2 || progress.Confirmed != 1 {
+		t.Errorf("expected 2 entities worked and 1 confirmed, got %+v", progress.awardSummary)
+	}
+
+	// A rebuild that fails leaves the credits as they were.
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+	if _, err = svc.rebuildAwardCredits(cancelled, 1); err == nil {
+		t.Fatal("expected the rebuild to fail")
+	}
+	if progress, err = svc.fetchAwardProgress(ctx, 1, awardDxcc, emptyString, emptyString); err != nil || progress.Worked != 2 {
+		t.Errorf("expected the credits to be kept, got %+v (%v)", progress.awardSummary, err)
+	}
+}
@@ -0,0 +1,118 @@
+package service
+
+import (
+	"context"
+	"time"
+)
+
+// startBackgroundTasks creates the context that bounds all background goroutines and launches
+// the long-running tasks owned by the service. It is called from Start.
+func (s *Service) startBackgroundTasks() {
+	s.bgCtx, s.bgCancel = context.WithCancel(context.Background())
+	s.bgWorkCtx, s.bgWorkCancel = context.WithCancel(context.Background())
+
+	if s.jobs != nil {
+		for i := 0; i < s.jobs.workers; i++ {
+			s.runInBackground("job_worker", s.runJobWorker)
+		}
+	}
+	if s.scheduler != nil {
+		s.startScheduledTasks()
+	}
+	s.runInBackground("outbox_dispatcher", s.runOutboxDispatcher)
+	if s.invalidationBus != nil {
+		s.runInBackground("cache_invalidation", func(ctx context.Context) {
+			s.invalidationBus.Run(ctx, s.applyInvalidation)
+		})
+	}
+	if s.webhooks != nil {
+		for i := 0; i < s.webhooks.workers; i++ {
+			s.runInBackground("webhook_worker", s.runWebhookWorker)
+		}
+	}
+	if s.deliveries != nil {
+		for i := 0; i < s.deliveries.workers; i++ {
+			s.runInBackground("delivery_worker", s.runDeliveryWorker)
+		}
+	}
+	if s.insertQueue != nil {
+		for i := 0; i < s.insertQueue.workers; i++ {
+			s.runInBackground("insert_worker", s.runInsertWorker)
+		}
+	}
+	if s.awards != nil {
+		s.runInBackground("award_tracker", s.runAwardTracker)
+	}
+	if s.usage != nil {
+		s.runInBackground("usage_flusher", s.runUsageFlusher)
+	}
+	if s.prepared != nil && s.poolWaitThreshold > 0 {
+		s.runInBackground("pool_wait_monitor", s.runPoolWaitMonitor)
+	}
+	if s.pskReporter != nil {
+		s.runInBackground("pskreporter", s.runPskReporter)
+	}
+	if s.dxCluster.enabled() {
+		s.runInBackground("dx_cluster", s.runDxCluster)
+	}
+	if s.clublog.enabled() && len(s.credentialsKey) > 0 {
+		s.runInBackground("clublog_uploader", s.runClubLogUploader)
+	}
+}
+
+// stopBackgroundTasks cancels the background context and waits for every task to return. Work in
+// hand may carry on until ctx expires; it is then interrupted, and the tasks have
+// shutdownForceGrace to return. It returns the number of tasks that did not. It is safe to call
+// even if startBackgroundTasks was never called.
+func (s *Service) stopBackgroundTasks(ctx context.Context) int32 {
+	if s.bgCancel != nil {
+		s.bgCancel()
+	}
+	if s.bgWorkCancel != nil {
+		defer s.bgWorkCancel()
+	}
+
+	done := make(chan struct{})
+	go func() {
+		s.bgWG.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return 0
+	case <-ctx.Done():
+	}
+
+	if s.bgWorkCancel != nil {
+		s.bgWorkCancel()
+	}
+	select {
+	case <-done:
+		return 0
+	case <-time.After(shutdownForceGrace):
+		return s.bgRunning.Load()
+	}
+}
+
+// jobContext returns the context a background job taken from the queue runs under: ctx, which
+// shutdown cancels at once, or the work context when running jobs may finish.
+func (s *Service) jobContext(ctx context.Context) context.Context {
+	if s.shutdownConfig.finishJobs && s.bgWorkCtx != nil {
+		return s.bgWorkCtx
+	}
+	return ctx
+}
+
+// runInBackground runs fn in a goroutine tied to the service lifecycle. fn must return promptly
+// once ctx is cancelled.
+func (s *Service) runInBackground(name string, fn func(ctx context.Context)) {
+	s.bgWG.Add(1)
+	s.bgRunning.Add(1)
+	go func() {
+		defer s.bgWG.Done()
+		defer s.bgRunning.Add(-1)
+		s.logger.DebugWith().Str("task", name).Msg("Background task started")
+		fn(s.bgCtx)
+		s.logger.DebugWith().Str("task", name).Msg("Background task stopped")
+	}()
+}
@@ -0,0 +1,518 @@
+package service
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	stderr "errors"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/Station-Manager/errors"
+	"github.com/Station-Manager/types"
+	"github.com/gofiber/fiber/v2"
+)
+
+// Environment variables configuring backups. Backups are disabled unless a directory or an S3
+// location is set.
+const (
+	// envBackupDir names a local directory that receives the backups.
+	envBackupDir = "SM_BACKUP_DIR"
+	// envBackupS3URL names an S3-compatible location of the form https://host/bucket[/prefix].
+	envBackupS3URL          = "SM_BACKUP_S3_URL"
+	envBackupS3Region       = "SM_BACKUP_S3_REGION"
+	envBackupS3AccessKeyID  = "SM_BACKUP_S3_ACCESS_KEY_ID"
+	envBackupS3SecretKey    = "SM_BACKUP_S3_SECRET_ACCESS_KEY"
+	envBackupInterval       = "SM_BACKUP_INTERVAL" // Go duration; zero takes backups on demand only
+	envBackupKeep           = "SM_BACKUP_KEEP"     // number of backups kept in each location
+	envBackupPgDump         = "SM_BACKUP_PG_DUMP"  // path of the pg_dump binary
+	defaultBackupInterval   = 24 * time.Hour
+	defaultBackupKeep       = 7
+	defaultBackupPgDump     = "pg_dump"
+	backupNamePrefix        = "station-manager-"
+	backupTimeLayout        = "20060102T150405Z"
+	backupMaxCommandOutput  = 4 << 10
+	backupPostgresExtension = ".dump"
+	backupSqliteExtension   = ".sqlite.gz"
+)
+
+// errBackupInProgress is returned when a backup is requested while another is running.
+var errBackupInProgress = stderr.New("a backup is already running")
+
+// backupRecord describes a backup file.
+type backupRecord struct {
+	Name      string    `json:"name"`
+	Size      int64     `json:"size"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// backupManager takes logical backups of the database, keeps the most recent ones in a local
+// directory and/or an S3 bucket, and remembers the outcome of the last attempt.
+type backupManager struct {
+	dir      string
+	s3       *s3Client
+	interval time.Duration
+	keep     int
+	pgDump   string
+	now      func() time.Time
+
+	running atomic.Bool
+
+	mu          sync.Mutex
+	last        *backupRecord // the last successful backup
+	lastError   string
+	lastErrorAt time.Time
+}
+
+// loadBackupManager reads the backup configuration from the environment. It returns nil when
+// backups are not configured.
+func loadBackupManager() (*backupManager, error) {
+	const op errors.Op = "server.loadBackupManager"
+
+	m := &backupManager{
+		dir:      strings.TrimSpace(os.Getenv(envBackupDir)),
+		interval: defaultBackupInterval,
+		keep:     defaultBackupKeep,
+		pgDump:   defaultBackupPgDump,
+		now:      time.Now,
+	}
+	location := strings.TrimSpace(os.Getenv(envBackupS3URL))
+	if m.dir == emptyString && location == emptyString {
+		return nil, nil
+	}
+
+	if location != emptyString {
+		accessKey := strings.TrimSpace(os.Getenv(envBackupS3AccessKeyID))
+		secretKey := strings.TrimSpace(os.Getenv(envBackupS3SecretKey))
+		if accessKey == emptyString || secretKey == emptyString {
+			return nil, errors.New(op).Msg(envBackupS3AccessKeyID + " and " + envBackupS3SecretKey + " are required with " + envBackupS3URL)
+		}
+		client, err := newS3Client(location, strings.TrimSpace(os.Getenv(envBackupS3Region)), accessKey, secretKey)
+		if err != nil {
+			return nil, errors.New(op).Err(err)
+		}
+		m.s3 = client
+	}
+	if value := strings.TrimSpace(os.Getenv(envBackupInterval)); value != emptyString {
+		d, err := time.ParseDuration(value)
+		if err != nil || d < 0 {
+			return nil, errors.New(op).Msg(envBackupInterval + " must be a non-negative duration")
+		}
+		m.interval = d
+	}
+	if value := strings.TrimSpace(os.Getenv(envBackupKeep)); value != emptyString {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 1 {
+			return nil, errors.New(op).Msg(envBackupKeep + " must be a positive number")
+		}
+		m.keep = n
+	}
+	if value := strings.TrimSpace(os.Getenv(envBackupPgDump)); value != emptyString {
+		m.pgDump = value
+	}
+	return m, nil
+}
+
+// status returns the last successful backup and the error of the last attempt, if it failed.
+func (m *backupManager) status() (*backupRecord, string, time.Time) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.last, m.lastError, m.lastErrorAt
+}
+
+func (m *backupManager) recordSuccess(record backupRecord) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.last = &record
+	m.lastError = emptyString
+	m.lastErrorAt = time.Time{}
+}
+
+func (m *backupManager) recordFailure(err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.lastError = errors.Root(err).Error()
+	m.lastErrorAt = m.now().UTC()
+}
+
+// lastBackupTime records the newest existing backup as the last success and reports when it was
+// taken, so that restarts neither delay nor repeat scheduled backups.
+func (s *Service) lastBackupTime(ctx context.Context) (time.Time, bool) {
+	records, err := s.listBackups(ctx)
+	if err != nil {
+		s.logger.WarnWith().Err(err).Msg("Failed to list existing backups")
+		return time.Time{}, false
+	}
+	if len(records) == 0 {
+		return time.Time{}, false
+	}
+	s.backups.recordSuccess(records[0])
+	return records[0].CreatedAt, true
+}
+
+// queueScheduledBackup queues a backup job. A failed backup is tried again at the next scheduled
+// run rather than immediately.
+func (s *Service) queueScheduledBackup(ctx context.Context) error {
+	const op errors.Op = "server.Service.queueScheduledBackup"
+
+	if _, _, err := s.enqueueJob(ctx, newJob{Kind: jobKindBackup}); err != nil {
+		return errors.New(op).Err(err)
+	}
+	return nil
+}
+
+// runBackup takes a backup, stores it in every configured location and removes the backups
+// beyond the number kept.
+func (s *Service) runBackup(ctx context.Context) (backupRecord, error) {
+	const op errors.Op = "server.Service.runBackup"
+
+	m := s.backups
+	if !m.running.CompareAndSwap(false, true) {
+		return backupRecord{}, errBackupInProgress
+	}
+	defer m.running.Store(false)
+
+	start := m.now().UTC()
+	record, err := s.takeBackup(ctx, start)
+	if err != nil {
+		err = errors.New(op).Err(err)
+		m.recordFailure(err)
+		return backupRecord{}, err
+	}
+	m.recordSuccess(record)
+	s.logger.InfoWith().Str("name", record.Name).Int64("size", record.Size).Dur("duration", m.now().Sub(start)).Msg("Backup completed")
+
+	if err = s.rotateBackups(ctx); err != nil {
+		// The backup itself succeeded; old backups are removed after the next one.
+		s.logger.WarnWith().Err(err).Msg("Failed to remove old backups")
+	}
+	return record, nil
+}
+
+func (s *Service) takeBackup(ctx context.Context, at time.Time) (backupRecord, error) {
+	m := s.backups
+
+	ext := backupSqliteExtension
+	if s.isPostgres() {
+		ext = backupPostgresExtension
+	}
+	record := backupRecord{Name: backupNamePrefix + at.Format(backupTimeLayout) + ext, CreatedAt: at}
+
+	dir := m.dir
+	if dir == emptyString {
+		tmp, err := os.MkdirTemp(emptyString, "sm-backup-")
+		if err != nil {
+			return record, err
+		}
+		defer func() { _ = os.RemoveAll(tmp) }()
+		dir = tmp
+	} else if err := os.MkdirAll(dir, 0o750); err != nil {
+		return record, err
+	}
+
+	// Write under a temporary name so that a failed backup never looks like a complete one.
+	path := filepath.Join(dir, record.Name)
+	partial := path + ".partial"
+	var err error
+	if s.isPostgres() {
+		err = m.dumpPostgres(ctx, s.dbConfig, partial)
+	} else {
+		err = s.dumpSqlite(ctx, partial)
+	}
+	if err != nil {
+		_ = os.Remove(partial)
+		return record, err
+	}
+	if err = os.Rename(partial, path); err != nil {
+		_ = os.Remove(partial)
+		return record, err
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return record, err
+	}
+	record.Size = info.Size()
+
+	if m.s3 != nil {
+		if err = m.s3.putFile(ctx, record.Name, path); err != nil {
+			return record, err
+		}
+	}
+	return record, nil
+}
+
+// dumpPostgres runs pg_dump in its compressed custom format, which pg_restore reads. The password
+// is passed in the environment rather than on the command line.
+func (m *backupManager) dumpPostgres(ctx context.Context, cfg *types.DatastoreConfig, path string) error {
+	if cfg == nil {
+		return errors.New("server.backupManager.dumpPostgres").Msg("The datastore configuration is missing")
+	}
+
+	cmd := exec.CommandContext(ctx, m.pgDump, "--format=custom", "--no-password", "--file="+path,
+		"--host="+cfg.Host, "--port="+strconv.Itoa(cfg.Port), "--username="+cfg.User, "--dbname="+cfg.Database)
+	cmd.Env = append(os.Environ(), "PGPASSWORD="+cfg.Password, "PGSSLMODE="+cfg.SSLMode)
+	var output commandOutput
+	cmd.Stdout, cmd.Stderr = &output, &output
+	if err := cmd.Run(); err != nil {
+		if msg := strings.TrimSpace(output.String()); msg != emptyString {
+			return errors.New("server.backupManager.dumpPostgres").Err(err).Msg(msg)
+		}
+		return err
+	}
+	return nil
+}
+
+// dumpSqlite writes a consistent, gzip-compressed copy of the database with VACUUM INTO, which
+// does not block writers for the duration of the copy.
+func (s *Service) dumpSqlite(ctx context.Context, path string) error {
+	snapshot := path + ".db"
+	defer func() { _ = os.Remove(snapshot) }()
+	if _, err := s.db.ExecContext(ctx, `VACUUM INTO $1`, snapshot); err != nil {
+		return err
+	}
+
+	in, err := os.Open(snapshot)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = in.Close() }()
+	out, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o640)
+	if err != nil {
+		return err
+	}
+	zw := gzip.NewWriter(out)
+	if _, err = io.Copy(zw, in); err != nil {
+		_ = out.Close()
+		return err
+	}
+	if err = zw.Close(); err != nil {
+		_ = out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+// listBackups returns the backups in the local directory, or in the bucket when there is none,
+// newest first.
+func (s *Service) listBackups(ctx context.Context) ([]backupRecord, error) {
+	const op errors.Op = "server.Service.listBackups"
+
+	m := s.backups
+	var records []backupRecord
+	if m.dir != emptyString {
+		entries, err := os.ReadDir(m.dir)
+		if err != nil && !os.IsNotExist(err) {
+			return nil, errors.New(op).Err(err)
+		}
+		for _, entry := range entries {
+			info, err := entry.Info()
+			if err != nil || !entry.Type().IsRegular() {
+				continue
+			}
+			if record, ok := parseBackupName(entry.Name(), info.Size()); ok {
+				records = append(records, record)
+			}
+		}
+	} else {
+		objects, err := m.s3.list(ctx, backupNamePrefix)
+		if err != nil {
+			return nil, errors.New(op).Err(err)
+		}
+		for _, o := range objects {
+			if record, ok := parseBackupName(o.Key, o.Size); ok {
+				records = append(records, record)
+			}
+		}
+	}
+
+	sort.Slice(records, func(i, j int) bool { return records[i].CreatedAt.After(records[j].CreatedAt) })
+	return records, nil
+}
+
+// parseBackupName recognizes the names given by takeBackup.
+func parseBackupName(name string, size int64) (backupRecord, bool) {
+	stamp, ok := strings.CutPrefix(name, backupNamePrefix)
+	if !ok {
+		return backupRecord{}, false
+	}
+	for _, ext := range []string{backupPostgresExtension, backupSqliteExtension} {
+		if base, found := strings.CutSuffix(stamp, ext); found {
+			at, err := time.Parse(backupTimeLayout, base)
+			if err != nil {
+				return backupRecord{}, false
+			}
+			return backupRecord{Name: name, Size: size, CreatedAt: at}, true
+		}
+	}
+	return backupRecord{}, false
+}
+
+// rotateBackups removes all but the newest backups from every configured location.
+func (s *Service) rotateBackups(ctx context.Context) error {
+	const op errors.Op = "server.Service.rotateBackups"
+
+	m := s.backups
+	if m.dir != emptyString {
+		entries, err := os.ReadDir(m.dir)
+		if err != nil {
+			return errors.New(op).Err(err)
+		}
+		var names []string
+		for _, entry := range entries {
+			if _, ok := parseBackupName(entry.Name(), 0); ok && entry.Type().IsRegular() {
+				names = append(names, entry.Name())
+			}
+		}
+		for _, name := range expiredBackups(names, m.keep) {
+			if err = os.Remove(filepath.Join(m.dir, name)); err != nil {
+				return errors.New(op).Err(err)
+			}
+		}
+	}
+
+	if m.s3 != nil {
+		objects, err := m.s3.list(ctx, backupNamePrefix)
+		if err != nil {
+			return errors.New(op).Err(err)
+		}
+		var names []string
+		for _, o := range objects {
+			if _, ok := parseBackupName(o.Key, 0); ok {
+				names = append(names, o.Key)
+			}
+		}
+		for _, name := range expiredBackups(names, m.keep) {
+			if err = m.s3.remove(ctx, name); err != nil {
+				return errors.New(op).Err(err)
+			}
+		}
+	}
+	return nil
+}
+
+// expiredBackups returns the backup names beyond the newest keep. The timestamp in the names makes
+// them sort chronologically.
+func expiredBackups(names []string, keep int) []string {
+	if len(names) <= keep {
+		return nil
+	}
+	sorted := append([]string(nil), names...)
+	sort.Slice(sorted, func(i, j int) bool {
+		a, _ := parseBackupName(sorted[i], 0)
+		b, _ := parseBackupName(sorted[j], 0)
+		return a.CreatedAt.After(b.CreatedAt)
+	})
+	return sorted[keep:]
+}
+
+// checkBackupHealth reports the last successful backup. Backups do not affect serving requests,
+// so a failure only degrades the report.
+func (s *Service) checkBackupHealth() healthComponent {
+	component := healthComponent{Status: healthStatusUp}
+	last, lastError, lastErrorAt := s.backups.status()
+
+	component.Details = map[string]any{}
+	if last != nil {
+		component.Details["last_success"] = last.CreatedAt
+		component.Details["last_backup"] = last.Name
+	}
+	if lastError != emptyString {
+		component.Status = healthStatusDegraded
+		component.Message = lastError
+		component.Details["last_failure"] = lastErrorAt
+		return component
+	}
+	// A scheduled backup is overdue when a whole extra interval has passed without one.
+	if s.backups.interval > 0 && (last == nil || s.backups.now().Sub(last.CreatedAt) > 2*s.backups.interval) {
+		component.Status = healthStatusDegraded
+		component.Message = "no recent backup"
+	}
+	return component
+}
+
+// getBackupsHandler reports the backup configuration, the outcome of the last attempt and the
+// backups kept.
+func (s *Service) getBackupsHandler(c *fiber.Ctx) error {
+	const op errors.Op = "server.Service.getBackupsHandler"
+	if s.backups == nil {
+		return c.Status(fiber.StatusNotFound).JSON(jsonError(errCodeBackupsDisabled, "Backups are not configured"))
+	}
+
+	records, err := s.listBackups(c.UserContext())
+	if err != nil {
+		s.logger.ErrorWith().Err(errors.New(op).Err(err)).Msg("s.listBackups failed")
+		return s.dbFailure(c, err)
+	}
+	if records == nil {
+		records = make([]backupRecord, 0)
+	}
+
+	response := fiber.Map{
+		"running":  s.backups.running.Load(),
+		"interval": s.backups.interval.String(),
+		"keep":     s.backups.keep,
+		"backups":  records,
+	}
+	if s.backups.dir != emptyString {
+		response["dir"] = s.backups.dir
+	}
+	if s.backups.s3 != nil {
+		response["s3"] = s.backups.s3.String()
+	}
+	if last, lastError, lastErrorAt := s.backups.status(); lastError != emptyString {
+		response["last_error"] = lastError
+		response["last_failure"] = lastErrorAt
+	} else if last != nil {
+		response["last_success"] = last.CreatedAt
+	}
+	return c.JSON(response)
+}
+
+// runBackupJob takes a backup as a background job.
+func (s *Service) runBackupJob(ctx context.Context, _ job) (any, error) {
+	if s.backups == nil {
+		return nil, permanentJobFailure(stderr.New("backups are not configured"))
+	}
+	return s.runBackup(ctx)
+}
+
+// postBackupHandler queues a backup and answers with its job. A backup already waiting or running
+// is answered with 409 and its job.
+func (s *Service) postBackupHandler(c *fiber.Ctx) error {
+	const op errors.Op = "server.Service.postBackupHandler"
+	if s.backups == nil {
+		return c.Status(fiber.StatusNotFound).JSON(jsonError(errCodeBackupsDisabled, "Backups are not configured"))
+	}
+
+	j, created, err := s.enqueueJob(c.UserContext(), newJob{Kind: jobKindBackup})
+	if err != nil {
+		s.logger.ErrorWith().Err(errors.New(op).Err(err)).Msg("s.enqueueJob failed")
+		return s.dbFailure(c, err)
+	}
+	if !created {
+		body := jsonError(errCodeBackupInProgress, "A backup is already running")
+		body["job"] = j
+		return c.Status(fiber.StatusConflict).JSON(body)
+	}
+	return c.Status(fiber.StatusAccepted).JSON(fiber.Map{"job": j})
+}
+
+// commandOutput keeps the first bytes of a command's output for error messages.
+type commandOutput struct {
+	bytes.Buffer
+}
+
+func (o *commandOutput) Write(p []byte) (int, error) {
+	if room := backupMaxCommandOutput - o.Len(); room > 0 {
+		o.Buffer.Write(p[:min(len(p), room)])
+	}
+	return len(p), nil
+}
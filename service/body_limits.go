@@ -0,0 +1,193 @@
+package service
+
+import (
+	"bytes"
+	stderr "errors"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/Station-Manager/errors"
+	"github.com/gofiber/fiber/v2"
+)
+
+// envBodyLimits names the environment variable holding per-route overrides of the body size limit,
+// as comma-separated path=size pairs (e.g. "/api/qso/insert=16KB"). Sizes are in bytes, or in KB,
+// MB or GB of 1024 units.
+const envBodyLimits = "SM_BODY_LIMITS"
+
+// envUploadLimit names the environment variable holding the largest upload, such as an ADIF import,
+// the server accepts (e.g. "512MB"). Uploads are streamed rather than held in memory, so the limit
+// may be far larger than the body limit.
+const envUploadLimit = "SM_UPLOAD_LIMIT"
+
+const defaultUploadLimit = 256 << 20
+
+// uploadRoutes are the routes whose bodies are uploads. Their handlers read the body with
+// uploadBody, within the upload limit, instead of with c.Body().
+var uploadRoutes = map[string]bool{
+	"/qsos/import": true,
+}
+
+// isUploadRoute reports whether the path is an upload route: one of uploadRoutes, or a QSO's QSL
+// card images.
+func isUploadRoute(path string) bool {
+	if uploadRoutes[path] {
+		return true
+	}
+	id, ok := strings.CutPrefix(path, "/qsos/")
+	if !ok {
+		return false
+	}
+	id, ok = strings.CutSuffix(id, "/qsl-images")
+	return ok && id != emptyString && !strings.Contains(id, "/")
+}
+
+// errUploadTooLarge is returned by the reader of uploadBody once the upload limit is exceeded.
+var errUploadTooLarge = stderr.New("the upload exceeds the size limit")
+
+// bodyLimits holds the largest request body allowed for each route.
+type bodyLimits struct {
+	fallback int
+	routes   map[string]int
+	upload   int
+}
+
+// forPath returns the body limit for the request path.
+func (l *bodyLimits) forPath(path string) int {
+	if isUploadRoute(path) {
+		return l.upload
+	}
+	if limit, ok := l.routes[path]; ok {
+		return limit
+	}
+	return l.fallback
+}
+
+// buffered returns the size up to which a body is read into memory before the handler runs. The
+// rest of a larger body is left in the request's stream, which only the upload routes read.
+func (l *bodyLimits) buffered() int {
+	limit := l.fallback
+	for _, routeLimit := range l.routes {
+		limit = max(limit, routeLimit)
+	}
+	return limit
+}
+
+// loadBodyLimits reads the body limits from the environment; fallback is the server's configured
+// body limit, zero for Fiber's default. Invalid values are an error so that a typo does not
+// silently leave a route unbounded.
+func loadBodyLimits(fallback int) (*bodyLimits, error) {
+	const op errors.Op = "server.loadBodyLimits"
+
+	if fallback <= 0 {
+		fallback = fiber.DefaultBodyLimit
+	}
+	limits := &bodyLimits{fallback: fallback, routes: make(map[string]int), upload: defaultUploadLimit}
+
+	if value := strings.TrimSpace(os.Getenv(envUploadLimit)); value != emptyString {
+		size, err := parseByteSize(value)
+		if err != nil || size <= 0 {
+			return nil, errors.New(op).Msg(envUploadLimit + " must be a positive size")
+		}
+		limits.upload = size
+	}
+
+	for _, pair := range strings.Split(os.Getenv(envBodyLimits), ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == emptyString {
+			continue
+		}
+		path, value, ok := strings.Cut(pair, "=")
+		size, err := parseByteSize(strings.TrimSpace(value))
+		if !ok || !strings.HasPrefix(path, "/") || err != nil || size <= 0 {
+			return nil, errors.New(op).Msg(envBodyLimits + " must be a list of path=size pairs")
+		}
+		limits.routes[strings.TrimSpace(path)] = size
+	}
+
+	return limits, nil
+}
+
+// parseByteSize parses a size in bytes, optionally suffixed with KB, MB or GB.
+func parseByteSize(value string) (int, error) {
+	multiplier := 1
+	upper := strings.ToUpper(value)
+	for suffix, m := range map[string]int{"KB": 1 << 10, "MB": 1 << 20, "GB": 1 << 30} {
+		if number, ok := strings.CutSuffix(upper, suffix); ok {
+			upper, multiplier = strings.TrimSpace(number), m
+			break
+		}
+	}
+	size, err := strconv.Atoi(upper)
+	if err != nil {
+		return 0, err
+	}
+	return size * multiplier, nil
+}
+
+// bodyLimitMiddleware rejects a request whose body exceeds its route's limit with a 413, before a
+// handler reads it. The body of an upload route is left to the handler, which reads it as a stream.
+func (s *Service) bodyLimitMiddleware() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		if s.bodyLimits == nil {
+			return c.Next()
+		}
+		limit := s.bodyLimits.forPath(c.Path())
+		length := c.Request().Header.ContentLength()
+		tooLarge := length > limit
+		if length < 0 && !isUploadRoute(c.Path()) && c.Request().IsBodyStream() {
+			// A body sent without a length is read here, but no further than the limit.
+			body, err := io.ReadAll(io.LimitReader(c.Request().BodyStream(), int64(limit)+1))
+			if err != nil {
+				return c.Status(fiber.StatusBadRequest).JSON(jsonBadRequest)
+			}
+			if tooLarge = len(body) > limit; !tooLarge {
+				c.Request().SetBody(body)
+			}
+		}
+		if tooLarge {
+			// The rest of the body is never read, so the connection cannot carry another request.
+			c.Context().SetConnectionClose()
+			return c.Status(fiber.StatusRequestEntityTooLarge).JSON(jsonError(errCodeBodyTooLarge, "The body may be at most "+strconv.Itoa(limit)+" bytes"))
+		}
+		return c.Next()
+	}
+}
+
+// uploadBody returns a reader of the request body of an upload route. Reading past the upload
+// limit fails with errUploadTooLarge.
+func (s *Service) uploadBody(c *fiber.Ctx) io.Reader {
+	var body io.Reader
+	if c.Request().IsBodyStream() {
+		body = c.Request().BodyStream()
+	} else {
+		body = bytes.NewReader(c.Body())
+	}
+	if s.bodyLimits == nil {
+		return body
+	}
+	return &limitedReader{r: body, remaining: int64(s.bodyLimits.upload)}
+}
+
+// limitedReader reads from r until remaining bytes have been read, and fails after that.
+type limitedReader struct {
+	r         io.Reader
+	remaining int64
+}
+
+func (l *limitedReader) Read(p []byte) (int, error) {
+	if l.remaining < 0 {
+		return 0, errUploadTooLarge
+	}
+	if int64(len(p)) > l.remaining+1 {
+		p = p[:l.remaining+1]
+	}
+	n, err := l.r.Read(p)
+	l.remaining -= int64(n)
+	if l.remaining < 0 {
+		return n, errUploadTooLarge
+	}
+	return n, err
+}
@@ -0,0 +1,93 @@
+package service
+
+import (
+	stderr "errors"
+	"io"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+func TestLoadBodyLimits(t *testing.T) {
+	t.Setenv(envBodyLimits, "/api/qso/insert=16KB, /stats=2048")
+	t.Setenv(envUploadLimit, "1GB")
+
+	limits, err := loadBodyLimits(0)
+	if err != nil {
+		t.Fatalf("loadBodyLimits failed: %v", err)
+	}
+	cases := map[string]int{
+		"/api/qso/insert": 16 << 10,
+		"/stats":          2048,
+		"/qsos/import":    1 << 30,
+		"/api/logbook":    fiber.DefaultBodyLimit,
+
+		"/qsos/42/qsl-images":   1 << 30,
+		"/qsos/42/qsl-images/7": fiber.DefaultBodyLimit,
+		"/qsos/qsl-images":      fiber.DefaultBodyLimit,
+	}
+	for path, want := range cases {
+		if got := limits.forPath(path); got != want {
+			t.Errorf("forPath(%q): expected %d, got %d", path, want, got)
+		}
+	}
+	if limits.buffered() != fiber.DefaultBodyLimit {
+		t.Errorf("expected the default limit to be the largest buffered, got %d", limits.buffered())
+	}
+
+	for _, value := range []string{"insert=1KB", "/x=lots", "/x=0"} {
+		t.Setenv(envBodyLimits, value)
+		if _, err = loadBodyLimits(0); err == nil {
+			t.Errorf("expected %q to be rejected", value)
+		}
+	}
+}
+
+func TestBodyLimitMiddleware(t *testing.T) {
+	svc := &Service{bodyLimits: &bodyLimits{fallback: 64, routes: map[string]int{"/small": 8}, upload: 128}}
+	app := fiber.New(fiber.Config{BodyLimit: svc.bodyLimits.buffered(), StreamRequestBody: true})
+	app.Use(svc.bodyLimitMiddleware())
+	app.Post("/small", func(c *fiber.Ctx) error { return c.SendStatus(fiber.StatusNoContent) })
+	app.Post("/large", func(c *fiber.Ctx) error { return c.SendStatus(fiber.StatusNoContent) })
+	app.Post("/qsos/import", func(c *fiber.Ctx) error {
+		data, err := io.ReadAll(svc.uploadBody(c))
+		if stderr.Is(err, errUploadTooLarge) {
+			return c.SendStatus(fiber.StatusRequestEntityTooLarge)
+		}
+		return c.SendString(string(data))
+	})
+
+	cases := []struct {
+		path string
+		size int
+		want int
+	}{
+		{"/small", 8, fiber.StatusNoContent},
+		{"/small", 9, fiber.StatusRequestEntityTooLarge},
+		{"/large", 9, fiber.StatusNoContent},
+		{"/large", 65, fiber.StatusRequestEntityTooLarge},
+		{"/qsos/import", 100, fiber.StatusOK},
+		{"/qsos/import", 129, fiber.StatusRequestEntityTooLarge},
+	}
+	for _, tc := range cases {
+		resp, err := app.Test(httptest.NewRequest(fiber.MethodPost, tc.path, strings.NewReader(strings.Repeat("x", tc.size))))
+		if err != nil {
+			t.Fatalf("request failed: %v", err)
+		}
+		if resp.StatusCode != tc.want {
+			t.Errorf("%s with %d bytes: expected %d, got %d", tc.path, tc.size, tc.want, resp.StatusCode)
+		}
+	}
+}
+
+func TestLimitedReader(t *testing.T) {
+	data, err := io.ReadAll(&limitedReader{r: strings.NewReader("abcdef"), remaining: 6})
+	if err != nil || string(data) != "abcdef" {
+		t.Errorf("expected a body at the limit to be read, got %q %v", data, err)
+	}
+	if _, err = io.ReadAll(&limitedReader{r: strings.NewReader("abcdefg"), remaining: 6}); !stderr.Is(err, errUploadTooLarge) {
+		t.Errorf("expected errUploadTooLarge, got %v", err)
+	}
+}
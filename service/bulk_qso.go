@@ -0,0 +1,469 @@
+package service
+
+import (
+	"context"
+	"database/sql"
+	stderr "errors"
+	"reflect"
+	"strconv"
+	"strings"
+
+	pgmodels "github.com/Station-Manager/database/postgres/models"
+	sqmodels "github.com/Station-Manager/database/sqlite/models"
+	"github.com/Station-Manager/errors"
+	"github.com/Station-Manager/types"
+	"github.com/aarondl/sqlboiler/v4/queries/qm"
+	"github.com/goccy/go-json"
+	"github.com/gofiber/fiber/v2"
+)
+
+// A bulk operation updates or deletes every QSO of the logbook its filter selects, such as setting
+// MY_GRIDSQUARE on the QSOs of a portable operation. The client previews the filter first and
+// passes the count it was shown as expected_count; the operation recounts in its transaction and
+// changes nothing unless the count is unchanged, so that QSOs logged since the preview are never
+// swept up unseen. Either every QSO selected is changed or, if the change would make one of them
+// invalid, none is. Each QSO's history records its change, and the logbook's event stream
+// announces it.
+
+// maxBulkQsos is the most QSOs a single bulk operation may change.
+const maxBulkQsos = 10000
+
+// bulkQsoFetchBatch is the most QSOs a bulk operation reads in one query, well under SQLite's
+// limit on the parameters of a statement.
+const bulkQsoFetchBatch = 500
+
+// bulkUnsettableFields are the QSO fields a bulk update may not set, besides those the history
+// ignores: the callsigns, on which the QSO's logbook depends, and the fields from which the
+// single-QSO update derives something a bulk update does not rederive. The DXCC entity derives from
+// the call; award credits and contest dupes and scores from the band and mode; and the keyset order
+// of the QSO lists from the date and time.
+var bulkUnsettableFields = map[string]struct{}{
+	"call":             {},
+	"station_callsign": {},
+	"dxcc":             {},
+	"country":          {},
+	"cont":             {},
+	"cqz":              {},
+	"ituz":             {},
+	"band":             {},
+	"band_rx":          {},
+	"freq":             {},
+	"freq_rx":          {},
+	"mode":             {},
+	"submode":          {},
+	"qso_date":         {},
+	"time_on":          {},
+	"qso_date_off":     {},
+	"time_off":         {},
+}
+
+// bulkSettableFields are the QSO's text fields by their JSON names, less those that may not be set.
+var bulkSettableFields = func() map[string]struct{} {
+	fields := make(map[string]struct{})
+	for name, t := range jsonFieldTypes(reflect.TypeOf(types.Qso{})) {
+		_, ignored := qsoHistoryIgnoredFields[name]
+		_, unsettable := bulkUnsettableFields[name]
+		if t.Kind() == reflect.String && !ignored && !unsettable && !strings.HasPrefix(name, "Sm") {
+			fields[name] = struct{}{}
+		}
+	}
+	return fields
+}()
+
+// errBulkCountChanged fails a bulk operation whose filter no longer selects the expected count.
+var errBulkCountChanged = stderr.New("the filter selects another number of QSOs than expected")
+
+// qsoFilter selects QSOs of a logbook. Each condition given narrows the selection; at least one
+// must be given.
+type qsoFilter struct {
+	Band string `json:"band" validate:"omitempty,max=10"`
+	Mode string `json:"mode" validate:"omitempty,max=10"`
+	Call string `json:"call" validate:"omitempty,max=30"`
+	// From and To are the first and last QSO dates selected, as YYYYMMDD.
+	From string `json:"from" validate:"omitempty,datetime=20060102"`
+	To   string `json:"to" validate:"omitempty,datetime=20060102"`
+	// ImportBatch selects the QSOs stored by an import; see import_batches.go.
+	ImportBatch int64 `json:"import_batch" validate:"omitempty,gt=0"`
+}
+
+// empty reports whether the filter would select every QSO.
+func (f qsoFilter) empty() bool {
+	return f == qsoFilter{}
+}
+
+// qsoBulkRequest is the body of a bulk preview, update or delete.
+type qsoBulkRequest struct {
+	Filter qsoFilter `json:"filter"`
+	// Set maps the JSON names of QSO fields to their new values; an empty value clears the field.
+	Set map[string]string `json:"set"`
+	// ExpectedCount is the count of the preview; updates and deletes require it.
+	ExpectedCount *int64 `json:"expected_count"`
+}
+
+// bulkQsoError fails a bulk update that would make a QSO invalid.
+type bulkQsoError struct {
+	QsoID int64
+	Err   error
+}
+
+func (e *bulkQsoError) Error() string {
+	return "QSO " + strconv.FormatInt(e.QsoID, 10) + ": " + e.Err.Error()
+}
+
+func (e *bulkQsoError) Unwrap() error {
+	return e.Err
+}
+
+// bulkQsoQuery returns the query selecting the expression for the live QSOs of the logbook the
+// filter selects, and its arguments.
+func (s *Service) bulkQsoQuery(logbookID int64, filter qsoFilter, selection string) (string, []any) {
+	postgres := s.isPostgres()
+	query := `SELECT ` + selection + ` FROM qso WHERE logbook_id = $1 AND deleted_at IS NULL`
+	args := []any{logbookID}
+	param := func(value any) string {
+		args = append(args, value)
+		return `$` + strconv.Itoa(len(args))
+	}
+	dateParam := func(value string) string {
+		if postgres {
+			return `to_date(` + param(value) + `, 'YYYYMMDD')`
+		}
+		return param(value)
+	}
+	if filter.Band != emptyString {
+		query += ` AND ` + activityGroupExpressions[activityGroupBand] + ` = ` + param(strings.ToLower(filter.Band))
+	}
+	if filter.Mode != emptyString {
+		query += ` AND ` + activityGroupExpressions[activityGroupMode] + ` = ` + param(strings.ToUpper(filter.Mode))
+	}
+	if filter.Call != emptyString {
+		query += ` AND UPPER(call) = ` + param(strings.ToUpper(filter.Call))
+	}
+	if filter.From != emptyString {
+		query += ` AND qso_date >= ` + dateParam(filter.From)
+	}
+	if filter.To != emptyString {
+		query += ` AND qso_date <= ` + dateParam(filter.To)
+	}
+	if filter.ImportBatch != 0 {
+		query += ` AND import_batch_id = ` + param(filter.ImportBatch)
+	}
+	return query, args
+}
+
+// previewBulkQsos counts the logbook's QSOs the filter selects.
+func (s *Service) previewBulkQsos(ctx context.Context, logbookID int64, filter qsoFilter) (int64, error) {
+	const op errors.Op = "server.Service.previewBulkQsos"
+
+	query, args := s.bulkQsoQuery(logbookID, filter, `COUNT(*)`)
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return 0, errors.New(op).Err(err)
+	}
+	defer func() { _ = rows.Close() }()
+
+	var count int64
+	if rows.Next() {
+		if err = rows.Scan(&count); err != nil {
+			return 0, errors.New(op).Err(err)
+		}
+	}
+	if err = rows.Err(); err != nil {
+		return 0, errors.New(op).Err(err)
+	}
+	return count, nil
+}
+
+// selectBulkQsos reads within tx the QSOs the filter selects, failing with errBulkCountChanged
+// unless there are as many as expected. A negative expected count reads them however many there
+// are.
+func (s *Service) selectBulkQsos(ctx context.Context, tx *sql.Tx, logbookID int64, filter qsoFilter, expected int64) ([]types.Qso, error) {
+	query, args := s.bulkQsoQuery(logbookID, filter, `id`)
+	var ids []int64
+	if err := queryEach(ctx, tx, func(rows *sql.Rows) error {
+		var id int64
+		err := rows.Scan(&id)
+		ids = append(ids, id)
+		return err
+	}, query+` ORDER BY id`, args...); err != nil {
+		return nil, err
+	}
+	if expected >= 0 && int64(len(ids)) != expected {
+		return nil, errBulkCountChanged
+	}
+
+	qsos := make([]types.Qso, 0, len(ids))
+	for start := 0; start < len(ids); start += bulkQsoFetchBatch {
+		batch, err := s.fetchQsosTx(ctx, tx, ids[start:min(start+bulkQsoFetchBatch, len(ids))])
+		if err != nil {
+			return nil, err
+		}
+		qsos = append(qsos, batch...)
+	}
+	return qsos, nil
+}
+
+// fetchQsosTx reads the QSOs with the IDs within tx as the database module would, in ID order,
+// whether or not they are in the trash.
+func (s *Service) fetchQsosTx(ctx context.Context, tx *sql.Tx, ids []int64) ([]types.Qso, error) {
+	const op errors.Op = "server.Service.fetchQsosTx"
+
+	args := make([]any, len(ids))
+	for i, id := range ids {
+		args[i] = id
+	}
+	mods := []qm.QueryMod{qm.WhereIn("id IN ?", args...), qm.OrderBy("id")}
+	var models []any
+	if s.isPostgres() {
+		found, err := pgmodels.Qsos(mods...).All(ctx, tx)
+		if err != nil {
+			return nil, errors.New(op).Err(err)
+		}
+		for _, m := range found {
+			models = append(models, m)
+		}
+	} else {
+		found, err := sqmodels.Qsos(mods...).All(ctx, tx)
+		if err != nil {
+			return nil, errors.New(op).Err(err)
+		}
+		for _, m := range found {
+			models = append(models, m)
+		}
+	}
+
+	adapter := s.qsoTypeAdapter()
+	qsos := make([]types.Qso, 0, len(models))
+	for _, m := range models {
+		var qso types.Qso
+		if err := adapter.Into(&qso, m); err != nil {
+			return nil, errors.New(op).Err(err)
+		}
+		qsos = append(qsos, qso)
+	}
+	return qsos, nil
+}
+
+// applyQsoSet returns the QSO with the fields set to their values.
+func applyQsoSet(qso types.Qso, set map[string]string) (types.Qso, error) {
+	data, err := json.Marshal(qso)
+	if err != nil {
+		return qso, err
+	}
+	var fields map[string]any
+	if err = json.Unmarshal(data, &fields); err != nil {
+		return qso, err
+	}
+	for name, value := range set {
+		fields[name] = value
+	}
+	if data, err = json.Marshal(fields); err != nil {
+		return qso, err
+	}
+	result := qso
+	err = json.Unmarshal(data, &result)
+	return result, err
+}
+
+// bulkUpdateQsos sets the fields on each of the logbook's QSOs the filter selects, provided it
+// selects the expected count, and returns the number of QSOs changed. A QSO that the update would
+// make invalid fails the whole update with a *bulkQsoError.
+func (s *Service) bulkUpdateQsos(ctx context.Context, logbookID int64, filter qsoFilter, set map[string]string, expected int64, actor string) (int, error) {
+	const op errors.Op = "server.Service.bulkUpdateQsos"
+
+	var updated []int64
+	err := s.withTx(ctx, func(tx *sql.Tx) error {
+		updated = nil
+
+		qsos, txErr := s.selectBulkQsos(ctx, tx, logbookID, filter, expected)
+		if txErr != nil {
+			return txErr
+		}
+		for _, before := range qsos {
+			after, txErr := applyQsoSet(before, set)
+			if txErr != nil {
+				return &bulkQsoError{QsoID: before.ID, Err: txErr}
+			}
+			normalizeQsoMode(&after)
+			// QSOs stored before a rule was tightened may not pass it; only a QSO the update
+			// breaks is refused.
+			if txErr = s.validate.StructExcept(after, "SessionID"); txErr != nil && s.validate.StructExcept(before, "SessionID") == nil {
+				return &bulkQsoError{QsoID: before.ID, Err: txErr}
+			}
+			changes, txErr := qsoChanges(before, after)
+			if txErr != nil {
+				return txErr
+			}
+			if len(changes) == 0 {
+				continue
+			}
+			if txErr = s.updateQsoTx(ctx, tx, after); txErr != nil {
+				return txErr
+			}
+			if txErr = recordQsoHistory(ctx, tx, qsoHistoryEntry{QsoID: before.ID, LogbookID: logbookID, Action: qsoHistoryUpdate,
+				Actor: actor, Changes: changes}); txErr != nil {
+				return txErr
+			}
+			updated = append(updated, before.ID)
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, errors.New(op).Err(err)
+	}
+
+	for _, id := range updated {
+		s.publishQsoUpdated(ctx, id)
+	}
+	return len(updated), nil
+}
+
+// bulkDeleteQsos moves each of the logbook's QSOs the filter selects to the trash, provided it
+// selects the expected count, and returns the number of QSOs deleted.
+func (s *Service) bulkDeleteQsos(ctx context.Context, logbookID int64, filter qsoFilter, expected int64, actor string) (int, error) {
+	const op errors.Op = "server.Service.bulkDeleteQsos"
+
+	var deleted []types.Qso
+	err := s.withTx(ctx, func(tx *sql.Tx) error {
+		var txErr error
+		if deleted, txErr = s.selectBulkQsos(ctx, tx, logbookID, filter, expected); txErr != nil {
+			return txErr
+		}
+		return trashQsosTx(ctx, tx, logbookID, deleted, actor)
+	})
+	if err != nil {
+		return 0, errors.New(op).Err(err)
+	}
+
+	for _, qso := range deleted {
+		s.publishQsoEvent(ctx, qsoEventDeleted, qso)
+	}
+	return len(deleted), nil
+}
+
+// trashQsosTx moves the QSOs of the logbook to the trash within tx, recording the deletion in the
+// history of each.
+func trashQsosTx(ctx context.Context, tx *sql.Tx, logbookID int64, qsos []types.Qso, actor string) error {
+	for _, qso := range qsos {
+		if _, err := tx.ExecContext(ctx, `UPDATE qso SET deleted_at = CURRENT_TIMESTAMP WHERE id = $1 AND logbook_id = $2 AND deleted_at IS NULL`,
+			qso.ID, logbookID); err != nil {
+			return err
+		}
+		if err := recordQsoHistory(ctx, tx, qsoHistoryEntry{QsoID: qso.ID, LogbookID: logbookID, Action: qsoHistoryDelete, Actor: actor}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// parseBulkQsoRequest reads and checks the body of a bulk request, writing the response itself
+// when it returns false. Updates and deletes must give the expected count.
+func (s *Service) parseBulkQsoRequest(c *fiber.Ctx, execute bool) (qsoBulkRequest, bool, error) {
+	var request qsoBulkRequest
+	if err := c.BodyParser(&request); err != nil {
+		return request, false, c.Status(fiber.StatusBadRequest).JSON(jsonBadRequest)
+	}
+	if err := s.validate.Struct(request.Filter); err != nil {
+		return request, false, c.Status(fiber.StatusBadRequest).JSON(jsonBadRequest)
+	}
+	if request.Filter.empty() {
+		return request, false, c.Status(fiber.StatusBadRequest).JSON(jsonError(errCodeBadRequest, "The filter must select by at least one field"))
+	}
+	if execute && (request.ExpectedCount == nil || *request.ExpectedCount < 0) {
+		return request, false, c.Status(fiber.StatusBadRequest).JSON(jsonError(errCodeBadRequest, "expected_count must be the count of a preview"))
+	}
+	if execute && *request.ExpectedCount > maxBulkQsos {
+		return request, false, c.Status(fiber.StatusBadRequest).JSON(jsonError(errCodeBadRequest,
+			"A bulk operation may change at most "+strconv.Itoa(maxBulkQsos)+" QSOs; narrow the filter"))
+	}
+	return request, true, nil
+}
+
+// bulkQsoFailure writes the response to a bulk update or delete that failed with err.
+func (s *Service) bulkQsoFailure(c *fiber.Ctx, err error) error {
+	const op errors.Op = "server.Service.bulkQsoFailure"
+
+	var invalid *bulkQsoError
+	switch {
+	case stderr.Is(err, errBulkCountChanged):
+		return c.Status(fiber.StatusConflict).JSON(jsonError(errCodeBulkCountChanged, "The filter no longer selects the previewed number of QSOs"))
+	case stderr.As(err, &invalid):
+		status, body := s.insertQsoFailure(invalid.Err)
+		body["qso_id"] = invalid.QsoID
+		return c.Status(status).JSON(body)
+	}
+	s.logger.ErrorWith().Err(errors.New(op).Err(err)).Msg("Bulk operation failed")
+	return s.dbFailure(c, err)
+}
+
+// previewBulkQsosHandler counts the authenticated logbook's QSOs the filter selects.
+func (s *Service) previewBulkQsosHandler(c *fiber.Ctx) error {
+	const op errors.Op = "server.Service.previewBulkQsosHandler"
+
+	logbook, err := authenticatedLogbook(c)
+	if err != nil {
+		s.logger.ErrorWith().Err(errors.New(op).Err(err)).Msg("authenticatedLogbook failed")
+		return c.Status(fiber.StatusInternalServerError).JSON(jsonInternalError)
+	}
+	request, ok, err := s.parseBulkQsoRequest(c, false)
+	if !ok {
+		return err
+	}
+
+	count, err := s.previewBulkQsos(c.UserContext(), logbook.ID, request.Filter)
+	if err != nil {
+		s.logger.ErrorWith().Err(errors.New(op).Err(err)).Msg("s.previewBulkQsos failed")
+		return s.dbFailure(c, err)
+	}
+	return c.JSON(fiber.Map{"count": count, "limit": maxBulkQsos})
+}
+
+// bulkUpdateQsosHandler sets fields on the authenticated logbook's QSOs the filter selects.
+func (s *Service) bulkUpdateQsosHandler(c *fiber.Ctx) error {
+	const op errors.Op = "server.Service.bulkUpdateQsosHandler"
+
+	logbook, err := authenticatedLogbook(c)
+	if err != nil {
+		s.logger.ErrorWith().Err(errors.New(op).Err(err)).Msg("authenticatedLogbook failed")
+		return c.Status(fiber.StatusInternalServerError).JSON(jsonInternalError)
+	}
+	request, ok, err := s.parseBulkQsoRequest(c, true)
+	if !ok {
+		return err
+	}
+	if len(request.Set) == 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(jsonError(errCodeBadRequest, "set must name at least one field"))
+	}
+	for name := range request.Set {
+		if _, settable := bulkSettableFields[name]; !settable {
+			return c.Status(fiber.StatusBadRequest).JSON(jsonError(errCodeBadRequest, name+" cannot be set in bulk"))
+		}
+	}
+
+	updated, err := s.bulkUpdateQsos(c.UserContext(), logbook.ID, request.Filter, request.Set, *request.ExpectedCount, actorOf(c))
+	if err != nil {
+		return s.bulkQsoFailure(c, err)
+	}
+	return c.JSON(fiber.Map{"count": *request.ExpectedCount, "updated": updated})
+}
+
+// bulkDeleteQsosHandler moves the authenticated logbook's QSOs the filter selects to the trash.
+func (s *Service) bulkDeleteQsosHandler(c *fiber.Ctx) error {
+	const op errors.Op = "server.Service.bulkDeleteQsosHandler"
+
+	logbook, err := authenticatedLogbook(c)
+	if err != nil {
+		s.logger.ErrorWith().Err(errors.New(op).Err(err)).Msg("authenticatedLogbook failed")
+		return c.Status(fiber.StatusInternalServerError).JSON(jsonInternalError)
+	}
+	request, ok, err := s.parseBulkQsoRequest(c, true)
+	if !ok {
+		return err
+	}
+
+	deleted, err := s.bulkDeleteQsos(c.UserContext(), logbook.ID, request.Filter, *request.ExpectedCount, actorOf(c))
+	if err != nil {
+		return s.bulkQsoFailure(c, err)
+	}
+	return c.JSON(fiber.Map{"deleted": deleted})
+}
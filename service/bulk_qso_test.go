@@ -0,0 +1,109 @@
+package service
+
+import (
+	"context"
+	"database/sql"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/goccy/go-json"
+	"github.com/gofiber/fiber/v2"
+)
+
+func TestBulkQsoHandlers(t *testing.T) {
+	svc := newTestServer(t)
+	svc.db = newLiveStore(svc.db)
+	ctx := context.Background()
+	first := insertTestQso(t, svc, "JA1XX", "20m", "CW", "20240501", "1200")
+	second := insertTestQso(t, svc, "JA2XX", "40m", "CW", "20240502", "1300")
+	other := insertTestQso(t, svc, "JA3XX", "20m", "CW", "20240601", "1400")
+
+	app := fiber.New()
+	app.Post("/bulk/preview", withLogbook(1, svc.previewBulkQsosHandler))
+	app.Post("/bulk/update", withLogbook(1, svc.bulkUpdateQsosHandler))
+	app.Post("/bulk/delete", withLogbook(1, svc.bulkDeleteQsosHandler))
+	send := func(path, body string) (int, map[string]any) {
+		req := httptest.NewRequest(fiber.MethodPost, path, strings.NewReader(body))
+		req.Header.Set(fiber.HeaderContentType, fiber.MIMEApplicationJSON)
+		resp, err := app.Test(req)
+		if err != nil {
+			t.Fatalf("request failed: %v", err)
+		}
+		var out map[string]any
+		_ = json.NewDecoder(resp.Body).Decode(&out)
+		return resp.StatusCode, out
+	}
+
+	portable := `"filter":{"from":"20240501","to":"20240531"}`
+	if status, out := send("/bulk/preview", `{"filter":{}}`); status != fiber.StatusBadRequest {
+		t.Errorf("expected an empty filter to be refused, got %d %v", status, out)
+	}
+	if status, out := send("/bulk/preview", `{`+portable+`}`); status != fiber.StatusOK || out["count"] != float64(2) {
+		t.Fatalf("expected the preview to count 2 QSOs, got %d %v", status, out)
+	}
+	if status, out := send("/bulk/update", `{`+portable+`,"set":{"my_gridsquare":"PM95"}}`); status != fiber.StatusBadRequest {
+		t.Errorf("expected an update without expected_count to be refused, got %d %v", status, out)
+	}
+	for _, field := range []string{"station_callsign", "dxcc", "band", "mode", "qso_date", "time_on"} {
+		if status, out := send("/bulk/update", `{`+portable+`,"set":{"`+field+`":"x"},"expected_count":2}`); status != fiber.StatusBadRequest {
+			t.Errorf("expected %s to be refused, got %d %v", field, status, out)
+		}
+	}
+	if status, out := send("/bulk/update", `{`+portable+`,"set":{"my_gridsquare":"PM95"},"expected_count":3}`); status != fiber.StatusConflict || out["code"] != string(errCodeBulkCountChanged) {
+		t.Errorf("expected a changed count to be refused, got %d %v", status, out)
+	}
+	if status, out := send("/bulk/update", `{`+portable+`,"set":{"my_gridsquare":"PM95"},"expected_count":2}`); status != fiber.StatusOK || out["updated"] != float64(2) {
+		t.Fatalf("expected 2 QSOs to be updated, got %d %v", status, out)
+	}
+
+	for id, grid := range map[int64]string{first: "PM95", second: "PM95", other: emptyString} {
+		qso, err := svc.db.FetchQsoByIdContext(ctx, id)
+		if err != nil || qso.MyGridsquare != grid {
+			t.Errorf("expected QSO %d's my_gridsquare to be %q, got %q (err=%v)", id, grid, qso.MyGridsquare, err)
+		}
+	}
+	entries, err := svc.listQsoHistory(ctx, 1, first)
+	if err != nil || len(entries) == 0 || entries[len(entries)-1].Action != qsoHistoryUpdate {
+		t.Errorf("expected the update in the QSO's history, got %+v (err=%v)", entries, err)
+	}
+
+	if status, out := send("/bulk/delete", `{"filter":{"band":"20m"},"expected_count":2}`); status != fiber.StatusOK || out["deleted"] != float64(2) {
+		t.Fatalf("expected 2 QSOs to be deleted, got %d %v", status, out)
+	}
+	for id, live := range map[int64]bool{first: false, second: true, other: false} {
+		if _, err = svc.db.FetchQsoByIdContext(ctx, id); (err == nil) != live {
+			t.Errorf("expected QSO %d live=%v, got err=%v", id, live, err)
+		}
+	}
+	if status, out := send("/bulk/preview", `{"filter":{"band":"20m"}}`); status != fiber.StatusOK || out["count"] != float64(0) {
+		t.Errorf("expected the trashed QSOs not to be counted, got %d %v", status, out)
+	}
+}
+
+func TestSelectBulkQsos_ReadsEveryBatch(t *testing.T) {
+	svc := newTestServer(t)
+	ctx := context.Background()
+	for i := 0; i <= bulkQsoFetchBatch; i++ {
+		insertTestQso(t, svc, "JA1XX", "20m", "CW", "20240501", "1200")
+	}
+
+	err := svc.withTx(ctx, func(tx *sql.Tx) error {
+		qsos, err := svc.selectBulkQsos(ctx, tx, 1, qsoFilter{Band: "20m"}, bulkQsoFetchBatch+1)
+		if err != nil {
+			return err
+		}
+		if len(qsos) != bulkQsoFetchBatch+1 {
+			t.Fatalf("expected %d QSOs, got %d", bulkQsoFetchBatch+1, len(qsos))
+		}
+		for i := 1; i < len(qsos); i++ {
+			if qsos[i].ID <= qsos[i-1].ID || qsos[i].Call != "JA1XX" {
+				t.Fatalf("expected the QSOs in ID order, got %d after %d", qsos[i].ID, qsos[i-1].ID)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("selectBulkQsos failed: %v", err)
+	}
+}
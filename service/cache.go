@@ -2,229 +2,275 @@ package service
 
 import (
 	"context"
-	"sync"
+	"crypto/sha256"
+	"encoding/hex"
+	"os"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/Station-Manager/errors"
+	"github.com/Station-Manager/server/service/cache"
 	"github.com/Station-Manager/types"
 )
 
-type logbookCache interface {
-	Get(id int64) (types.Logbook, bool)
-	Set(id int64, lb types.Logbook, ttl time.Duration)
-	Invalidate(id int64)
-}
-
-type logbookCacheEntry struct {
-	value     types.Logbook
-	expiresAt time.Time
-	prev      *lruNode
-	next      *lruNode
-}
-
-type lruNode struct {
-	key  int64
-	prev *lruNode
-	next *lruNode
-}
-
-type inMemoryLogbookCache struct {
-	mu         sync.RWMutex
-	entries    map[int64]*logbookCacheEntry
-	maxEntries int
-	// LRU doubly-linked list
-	head *lruNode // most recently used
-	tail *lruNode // least recently used
-}
-
 const (
 	defaultLogbookCacheTTL        = 5 * time.Minute //TODO: make configurable
 	defaultLogbookCacheMaxEntries = 1024            //TODO: make configurable
+
+	defaultUserCacheTTL        = 5 * time.Minute
+	defaultUserCacheMaxEntries = 1024
+
+	// API key records are kept briefly so that a revoked key stops working quickly.
+	defaultApiKeyCacheTTL        = 1 * time.Minute
+	defaultApiKeyCacheMaxEntries = 4096
+
+	// Rejected API keys are remembered for a short period so that a client retrying a bad key does
+	// not translate into a database query per attempt.
+	defaultApiKeyNegativeCacheTTL        = 30 * time.Second
+	defaultApiKeyNegativeCacheMaxEntries = 8192
 )
 
-// newInMemoryLogbookCache initializes and returns a new in-memory logbook cache with default settings.
-func newInMemoryLogbookCache() *inMemoryLogbookCache {
-	return &inMemoryLogbookCache{
-		entries:    make(map[int64]*logbookCacheEntry),
-		maxEntries: defaultLogbookCacheMaxEntries,
-		head:       nil,
-		tail:       nil,
-	}
-}
+// defaultCacheSweepInterval is how often expired entries are swept from the in-memory caches.
+const defaultCacheSweepInterval = 1 * time.Minute
 
-func (c *inMemoryLogbookCache) Get(id int64) (types.Logbook, bool) {
-	var empty types.Logbook
+// envLogbookCacheStale names the environment variable holding how long after it expires a cached
+// logbook is still served while it is refreshed in the background (e.g. "1m"). Unset or zero
+// refreshes an expired logbook before answering.
+const envLogbookCacheStale = "SM_LOGBOOK_CACHE_STALE"
 
-	c.mu.Lock()
-	defer c.mu.Unlock()
+// envCacheTTLJitter names the environment variable holding the largest share of a TTL, from 0 to
+// 1, that is randomly taken off each cached entry's, so that entries cached together (at startup
+// or the start of a contest) do not all expire and hit the database together.
+const envCacheTTLJitter = "SM_CACHE_TTL_JITTER"
 
-	entry, ok := c.entries[id]
-	if !ok {
-		return empty, false
-	}
+// defaultCacheTTLJitter applies when SM_CACHE_TTL_JITTER is unset.
+const defaultCacheTTLJitter = 0.1
 
-	if time.Now().After(entry.expiresAt) {
-		// expired; treat as miss and remove
-		c.removeLocked(id)
-		return empty, false
-	}
+// logbookRevalidateTimeout bounds the background refresh of a stale logbook.
+const logbookRevalidateTimeout = 10 * time.Second
 
-	// Move to the front (most recently used)
-	c.moveToFrontLocked(entry)
+const (
+	negativeCachePrefixTag = "prefix:"
+	negativeCacheKeyTag    = "key:"
+)
 
-	return entry.value, true
+// initializeCaches creates the in-memory caches that sit in front of the database.
+func (s *Service) initializeCaches() {
+	s.logbookCache = cache.New[int64, types.Logbook](defaultLogbookCacheMaxEntries, defaultLogbookCacheTTL)
+	s.logbookCache.SetStaleWindow(s.logbookCacheStale)
+	s.userCache = cache.New[string, types.User](defaultUserCacheMaxEntries, defaultUserCacheTTL)
+	s.apiKeyCache = cache.New[string, types.ApiKey](defaultApiKeyCacheMaxEntries, defaultApiKeyCacheTTL)
+	s.apiKeyNegativeCache = cache.New[string, struct{}](defaultApiKeyNegativeCacheMaxEntries, defaultApiKeyNegativeCacheTTL)
+	s.apiKeyMemberCache = cache.New[string, apiKeyMember](defaultApiKeyCacheMaxEntries, defaultApiKeyCacheTTL)
+	s.signingSecretCache = cache.New[string, signingSecret](defaultApiKeyCacheMaxEntries, defaultApiKeyCacheTTL)
+	s.signatureReplays = newReplayGuard()
+
+	s.logbookCache.SetJitter(s.cacheTTLJitter)
+	s.userCache.SetJitter(s.cacheTTLJitter)
+	s.apiKeyCache.SetJitter(s.cacheTTLJitter)
+	s.apiKeyNegativeCache.SetJitter(s.cacheTTLJitter)
+	s.apiKeyMemberCache.SetJitter(s.cacheTTLJitter)
+	s.signingSecretCache.SetJitter(s.cacheTTLJitter)
 }
 
-func (c *inMemoryLogbookCache) Set(id int64, lb types.Logbook, ttl time.Duration) {
-	if c == nil {
-		return
+// loadLogbookCacheStale reads the stale window of the logbook cache from the environment.
+func loadLogbookCacheStale() (time.Duration, error) {
+	const op errors.Op = "server.loadLogbookCacheStale"
+
+	value := strings.TrimSpace(os.Getenv(envLogbookCacheStale))
+	if value == emptyString {
+		return 0, nil
 	}
-	if ttl <= 0 {
-		ttl = defaultLogbookCacheTTL
+	d, err := time.ParseDuration(value)
+	if err != nil || d < 0 {
+		return 0, errors.New(op).Msg(envLogbookCacheStale + " must be a non-negative duration")
 	}
+	return d, nil
+}
 
-	c.mu.Lock()
-	defer c.mu.Unlock()
+// loadCacheTTLJitter reads the TTL jitter of the caches from the environment.
+func loadCacheTTLJitter() (float64, error) {
+	const op errors.Op = "server.loadCacheTTLJitter"
 
-	if c.entries == nil {
-		c.entries = make(map[int64]*logbookCacheEntry)
+	value := strings.TrimSpace(os.Getenv(envCacheTTLJitter))
+	if value == emptyString {
+		return defaultCacheTTLJitter, nil
 	}
-
-	// Update existing entry
-	if entry, exists := c.entries[id]; exists {
-		entry.value = lb
-		entry.expiresAt = time.Now().Add(ttl)
-		c.moveToFrontLocked(entry)
-		return
+	jitter, err := strconv.ParseFloat(value, 64)
+	if err != nil || jitter < 0 || jitter > 1 {
+		return 0, errors.New(op).Msg(envCacheTTLJitter + " must be a number from 0 to 1")
 	}
+	return jitter, nil
+}
 
-	// Evict LRU entry if at capacity
-	if c.maxEntries > 0 && len(c.entries) >= c.maxEntries {
-		if c.tail != nil {
-			c.removeLocked(c.tail.key)
-		}
+// sweepCaches removes expired entries from every cache and reports the counts. Without it, entries
+// that are never read again would hold memory until pushed out by LRU pressure.
+func (s *Service) sweepCaches() {
+	logbooks := s.logbookCache.RemoveExpired()
+	users := s.userCache.RemoveExpired()
+	apiKeys := s.apiKeyCache.RemoveExpired() + s.apiKeyMemberCache.RemoveExpired() + s.signingSecretCache.RemoveExpired()
+	rejected := s.apiKeyNegativeCache.RemoveExpired()
+	lookups := 0
+	if s.lookup != nil {
+		lookups = s.lookup.cache.RemoveExpired()
 	}
 
-	// Create a new entry and node
-	node := &lruNode{key: id}
-	entry := &logbookCacheEntry{
-		value:     lb,
-		expiresAt: time.Now().Add(ttl),
-		prev:      node,
-		next:      node,
+	if logbooks+users+apiKeys+rejected+lookups == 0 {
+		return
 	}
-
-	c.entries[id] = entry
-	c.addToFrontLocked(node)
+	s.logger.DebugWith().Str("component", "cache").Str("metric", "expired").Int("logbooks", logbooks).Int("users", users).Int("api_keys", apiKeys).Int("rejected_api_keys", rejected).Int("callsign_lookups", lookups).Msg("cache sweep")
 }
 
-func (c *inMemoryLogbookCache) Invalidate(id int64) {
-	if c == nil {
-		return
-	}
+// fetchLogbookWithCache retrieves a logbook by ID using an in-memory cache backed by the database service.
+// It assumes that the provided Service has a non-nil db; a nil logbookCache disables caching. With a
+// stale window, a logbook that has expired within it is returned at once and refreshed in the
+// background, so that a hot logbook never waits on the database.
+func (s *Service) fetchLogbookWithCache(ctx context.Context, logbookID int64) (types.Logbook, error) {
+	const op errors.Op = "server.Service.fetchLogbookWithCache"
+	var emptyRetVal types.Logbook
 
-	c.mu.Lock()
-	defer c.mu.Unlock()
-	c.removeLocked(id)
-}
+	if s == nil {
+		return emptyRetVal, errors.New(op).Msg(errMsgNilService)
+	}
+	if logbookID == 0 {
+		return emptyRetVal, errors.New(op).Msg("logbookID is zero")
+	}
 
-// removeLocked removes an entry from the cache. Must be called with lock held.
-func (c *inMemoryLogbookCache) removeLocked(id int64) {
-	entry, ok := c.entries[id]
-	if !ok {
-		return
+	// 1. Try cache first.
+	if s.logbookCacheStale > 0 {
+		if lb, stale, ok := s.logbookCache.GetStale(logbookID); ok {
+			if stale {
+				s.revalidateLogbook(logbookID)
+			}
+			return lb, nil
+		}
+	} else if lb, ok := s.logbookCache.Get(logbookID); ok {
+		return lb, nil
 	}
 
-	// Remove from LRU list
-	node := entry.prev
-	if node != nil {
-		c.removeNodeLocked(node)
+	// 2. Fallback to database - logbooks table
+	logbook, err := s.db.FetchLogbookByIDContext(ctx, logbookID)
+	if err != nil {
+		return emptyRetVal, errors.New(op).Err(err)
 	}
+	s.logbookCache.Set(logbookID, logbook, defaultLogbookCacheTTL)
 
-	delete(c.entries, id)
+	return logbook, nil
 }
 
-// addToFrontLocked adds a node to the front (most recently used position). Must be called with lock held.
-func (c *inMemoryLogbookCache) addToFrontLocked(node *lruNode) {
-	if node == nil {
+// revalidateLogbook refreshes the cached logbook from the database in the background, unless it is
+// being refreshed already. On failure the stale entry is left to serve until its window ends.
+func (s *Service) revalidateLogbook(logbookID int64) {
+	if _, busy := s.logbookRevalidations.LoadOrStore(logbookID, struct{}{}); busy {
 		return
 	}
+	go func() {
+		defer s.logbookRevalidations.Delete(logbookID)
+
+		ctx, cancel := context.WithTimeout(context.Background(), logbookRevalidateTimeout)
+		defer cancel()
+		logbook, err := s.db.FetchLogbookByIDContext(ctx, logbookID)
+		if err != nil {
+			s.logger.WarnWith().Err(err).Int64("logbook_id", logbookID).Msg("Failed to refresh stale logbook")
+			return
+		}
+		s.logbookCache.Set(logbookID, logbook, defaultLogbookCacheTTL)
+	}()
+}
 
-	node.next = c.head
-	node.prev = nil
+// fetchUserWithCache retrieves a verified user by callsign using an in-memory cache backed by fetchUser.
+func (s *Service) fetchUserWithCache(ctx context.Context, callsign string) (types.User, error) {
+	const op errors.Op = "server.Service.fetchUserWithCache"
+	var emptyRetVal types.User
 
-	if c.head != nil {
-		c.head.prev = node
+	if s == nil {
+		return emptyRetVal, errors.New(op).Msg(errMsgNilService)
 	}
-	c.head = node
-
-	if c.tail == nil {
-		c.tail = node
+	if callsign == emptyString {
+		return emptyRetVal, errors.New(op).Msg("Callsign is empty")
 	}
-}
 
-// removeNodeLocked removes a node from the LRU list. Must be called with lock held.
-func (c *inMemoryLogbookCache) removeNodeLocked(node *lruNode) {
-	if node == nil {
-		return
+	if user, ok := s.userCache.Get(callsign); ok {
+		return user, nil
 	}
 
-	if node.prev != nil {
-		node.prev.next = node.next
-	} else {
-		c.head = node.next
+	user, err := s.fetchUser(ctx, callsign)
+	if err != nil {
+		return emptyRetVal, errors.New(op).Err(err)
 	}
+	s.userCache.Set(callsign, user, defaultUserCacheTTL)
 
-	if node.next != nil {
-		node.next.prev = node.prev
-	} else {
-		c.tail = node.prev
-	}
+	return user, nil
 }
 
-// moveToFrontLocked moves an entry to the front of the LRU list. Must be called with lock held.
-func (c *inMemoryLogbookCache) moveToFrontLocked(entry *logbookCacheEntry) {
-	if entry == nil {
-		return
+// refreshUser drops any cached copy of the user and reloads it from the database.
+// It is used when a cached record may be stale, e.g. after a failed password check.
+func (s *Service) refreshUser(ctx context.Context, callsign string) (types.User, error) {
+	const op errors.Op = "server.Service.refreshUser"
+	if s == nil {
+		return types.User{}, errors.New(op).Msg(errMsgNilService)
 	}
 
-	node := entry.prev
-	if node == nil || node == c.head {
-		return // already at the front or not in the list
-	}
+	s.invalidateUser(ctx, callsign)
 
-	c.removeNodeLocked(node)
-	c.addToFrontLocked(node)
+	user, err := s.fetchUserWithCache(ctx, callsign)
+	if err != nil {
+		return types.User{}, errors.New(op).Err(err)
+	}
+	return user, nil
 }
 
-// fetchLogbookWithCache retrieves a logbook by ID using an in-memory cache backed by the database service.
-// It assumes that the provided Service has a non-nil db and logbookCache.
-func (s *Service) fetchLogbookWithCache(ctx context.Context, logbookID int64) (types.Logbook, error) {
-	const op errors.Op = "server.Service.fetchLogbookWithCache"
-	var emptyRetVal types.Logbook
+// fetchApiKeyWithCache retrieves the stored API key record for a key prefix using an in-memory cache
+// backed by the database service. Only the hashed key is cached; validation is still performed per request.
+func (s *Service) fetchApiKeyWithCache(ctx context.Context, prefix string) (types.ApiKey, error) {
+	const op errors.Op = "server.Service.fetchApiKeyWithCache"
+	var emptyRetVal types.ApiKey
 
 	if s == nil {
 		return emptyRetVal, errors.New(op).Msg(errMsgNilService)
 	}
-	if logbookID == 0 {
-		return emptyRetVal, errors.New(op).Msg("logbookID is zero")
+	if prefix == emptyString {
+		return emptyRetVal, errors.New(op).Msg("API key prefix is empty")
 	}
 
-	// 1. Try cache first.
-	if s.logbookCache != nil {
-		if lb, ok := s.logbookCache.Get(logbookID); ok {
-			return lb, nil
-		}
+	if key, ok := s.apiKeyCache.Get(prefix); ok {
+		return key, nil
 	}
 
-	// 2. Fallback to database - logbooks table
-	logbook, err := s.db.FetchLogbookByIDContext(ctx, logbookID)
+	// Database call to the api_keys table
+	key, err := s.db.FetchAPIKeyByPrefixContext(ctx, prefix)
 	if err != nil {
 		return emptyRetVal, errors.New(op).Err(err)
 	}
-	if s.logbookCache != nil {
-		s.logbookCache.Set(logbookID, logbook, defaultLogbookCacheTTL)
+	s.apiKeyCache.Set(prefix, key, defaultApiKeyCacheTTL)
+
+	return key, nil
+}
+
+// isRejectedApiKey reports whether the API key, or its prefix, failed authentication recently.
+func (s *Service) isRejectedApiKey(prefix, fullKey string) bool {
+	if _, ok := s.apiKeyNegativeCache.Get(negativeCachePrefixTag + prefix); ok {
+		return true
 	}
+	_, ok := s.apiKeyNegativeCache.Get(negativeCacheKeyTag + hashApiKeyForCache(fullKey))
+	return ok
+}
 
-	return logbook, nil
+// rejectApiKeyPrefix remembers a prefix that has no matching API key record.
+func (s *Service) rejectApiKeyPrefix(prefix string) {
+	s.apiKeyNegativeCache.Set(negativeCachePrefixTag+prefix, struct{}{}, defaultApiKeyNegativeCacheTTL)
+}
+
+// rejectApiKey remembers a full API key that failed validation. Only the prefix's owner can hold the
+// matching secret, so the rejection is keyed on the full key rather than the prefix; otherwise a bad
+// guess would lock the legitimate client out.
+func (s *Service) rejectApiKey(fullKey string) {
+	s.apiKeyNegativeCache.Set(negativeCacheKeyTag+hashApiKeyForCache(fullKey), struct{}{}, defaultApiKeyNegativeCacheTTL)
+}
+
+// hashApiKeyForCache digests the full key so that rejected secrets are never held in memory.
+func hashApiKeyForCache(fullKey string) string {
+	sum := sha256.Sum256([]byte(fullKey))
+	return hex.EncodeToString(sum[:])
 }
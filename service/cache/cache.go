@@ -0,0 +1,516 @@
+// Package cache provides a generic, concurrency-safe in-memory cache with TTL expiry and LRU eviction.
+package cache
+
+import (
+	"hash/maphash"
+	"math/rand/v2"
+	"sync"
+	"time"
+)
+
+// DefaultTTL is applied when neither the caller nor the cache specifies a positive TTL.
+const DefaultTTL = 5 * time.Minute
+
+const (
+	// maxShards is the number of shards of an unbounded or large cache. It is a power of two, so
+	// that a shard is picked by masking the key's hash.
+	maxShards = 16
+	// minShardEntries is the fewest entries a shard of a bounded cache holds. Smaller caches have
+	// fewer shards, down to one, whose LRU order is then exact.
+	minShardEntries = 64
+)
+
+// entry combines the cached value and its LRU list node into a single structure.
+// This keeps it to one allocation per entry and avoids pointer indirection on lookups.
+type entry[K comparable, V any] struct {
+	key       K
+	value     V
+	expiresAt time.Time
+	prev      *entry[K, V]
+	next      *entry[K, V]
+}
+
+// Cache is a fixed-capacity LRU cache whose entries also expire after a TTL.
+// Expired entries are treated as misses and removed lazily on access. A cache with a stale window
+// keeps expired entries for that long, for GetStale to serve while the caller refreshes them.
+//
+// The keys are spread by hash over independently locked shards, each an LRU list holding its share
+// of the capacity, so that concurrent callers working on different keys do not wait for each
+// other. Eviction is therefore least recently used within a shard rather than across the cache.
+// The zero value is not usable; create instances with New.
+type Cache[K comparable, V any] struct {
+	shards     []*shard[K, V]
+	mask       uint64 // len(shards) - 1
+	seed       maphash.Seed
+	maxEntries int // 0 means unbounded
+}
+
+// shard is one of the LRU lists of a cache and its lock.
+type shard[K comparable, V any] struct {
+	mu          sync.RWMutex
+	entries     map[K]*entry[K, V]
+	maxEntries  int           // 0 means unbounded
+	defaultTTL  time.Duration // applied when Set is called with ttl <= 0
+	staleWindow time.Duration // how long expired entries are kept for GetStale
+	jitter      float64       // largest share of a TTL randomly taken off it
+	head        *entry[K, V]  // most recently used
+	tail        *entry[K, V]  // least recently used
+
+	hits        uint64 // lookups that found an entry, stale or not
+	misses      uint64 // lookups that found none
+	evictions   uint64 // entries removed to make room for new ones
+	expirations uint64 // entries removed because their TTL elapsed
+}
+
+// Stats is a point-in-time snapshot of the cache occupancy and of its counters since it was
+// created.
+type Stats struct {
+	Entries     int
+	MaxEntries  int
+	Hits        uint64
+	Misses      uint64
+	Evictions   uint64
+	Expirations uint64
+}
+
+// HitRate returns the share of lookups that found an entry, or zero before the first lookup.
+func (s Stats) HitRate() float64 {
+	if s.Hits+s.Misses == 0 {
+		return 0
+	}
+	return float64(s.Hits) / float64(s.Hits+s.Misses)
+}
+
+// New creates a cache holding at most maxEntries items (0 for no limit) that expire after
+// defaultTTL unless a TTL is given explicitly. A non-positive defaultTTL falls back to DefaultTTL.
+func New[K comparable, V any](maxEntries int, defaultTTL time.Duration) *Cache[K, V] {
+	if maxEntries < 0 {
+		maxEntries = 0
+	}
+	if defaultTTL <= 0 {
+		defaultTTL = DefaultTTL
+	}
+
+	n := shardCount(maxEntries)
+	c := &Cache[K, V]{
+		shards:     make([]*shard[K, V], n),
+		mask:       uint64(n - 1),
+		seed:       maphash.MakeSeed(),
+		maxEntries: maxEntries,
+	}
+	// Round the shares up, so that the shards together hold at least maxEntries.
+	shardMax := (maxEntries + n - 1) / n
+	for i := range c.shards {
+		c.shards[i] = newShard[K, V](shardMax, defaultTTL)
+	}
+	return c
+}
+
+// shardCount returns the number of shards of a cache holding at most maxEntries items.
+func shardCount(maxEntries int) int {
+	n := maxShards
+	if maxEntries > 0 {
+		for n > 1 && maxEntries/n < minShardEntries {
+			n /= 2
+		}
+	}
+	return n
+}
+
+func newShard[K comparable, V any](maxEntries int, defaultTTL time.Duration) *shard[K, V] {
+	return &shard[K, V]{
+		// Pre-allocate map with expected capacity to reduce allocations
+		entries:    make(map[K]*entry[K, V], maxEntries),
+		maxEntries: maxEntries,
+		defaultTTL: defaultTTL,
+	}
+}
+
+// shardFor returns the shard holding key.
+func (c *Cache[K, V]) shardFor(key K) *shard[K, V] {
+	if c.mask == 0 {
+		return c.shards[0]
+	}
+	return c.shards[maphash.Comparable(c.seed, key)&c.mask]
+}
+
+// Get returns the value stored under key and promotes it to most recently used.
+func (c *Cache[K, V]) Get(key K) (V, bool) {
+	if c == nil {
+		var empty V
+		return empty, false
+	}
+	return c.shardFor(key).get(key)
+}
+
+// GetStale is Get for a cache with a stale window: an entry that expired no longer ago than the
+// window is returned too, with stale set, and promoted, so that the caller can serve it while it
+// refreshes the entry.
+func (c *Cache[K, V]) GetStale(key K) (value V, stale bool, ok bool) {
+	if c == nil {
+		var empty V
+		return empty, false, false
+	}
+	return c.shardFor(key).getStale(key)
+}
+
+// SetStaleWindow sets how long expired entries are kept for GetStale. Zero, the default, removes
+// them as soon as they expire.
+func (c *Cache[K, V]) SetStaleWindow(window time.Duration) {
+	if c == nil {
+		return
+	}
+	for _, s := range c.shards {
+		s.setStaleWindow(window)
+	}
+}
+
+// SetJitter makes Set shorten each TTL by a random share of it, up to fraction (at most 1), so that
+// entries stored together do not all expire together. Zero, the default, keeps TTLs exact.
+func (c *Cache[K, V]) SetJitter(fraction float64) {
+	if c == nil {
+		return
+	}
+	for _, s := range c.shards {
+		s.setJitter(fraction)
+	}
+}
+
+// Set stores value under key for ttl, evicting the least recently used entry of the key's shard if
+// the shard is full. A non-positive ttl uses the cache's default TTL.
+func (c *Cache[K, V]) Set(key K, value V, ttl time.Duration) {
+	if c == nil {
+		return
+	}
+	c.shardFor(key).set(key, value, ttl)
+}
+
+// HasRoom reports whether key can be stored without evicting another entry: it is stored already,
+// or its shard is below its share of the capacity. Filling a cache only while it has room keeps a
+// bulk load, such as a warmup, from evicting its own earlier entries from a crowded shard.
+func (c *Cache[K, V]) HasRoom(key K) bool {
+	if c == nil {
+		return false
+	}
+	return c.shardFor(key).hasRoom(key)
+}
+
+// Invalidate removes key from the cache if present.
+func (c *Cache[K, V]) Invalidate(key K) {
+	if c == nil {
+		return
+	}
+	c.shardFor(key).invalidate(key)
+}
+
+// Purge removes every entry from the cache.
+func (c *Cache[K, V]) Purge() {
+	if c == nil {
+		return
+	}
+	for _, s := range c.shards {
+		s.purge()
+	}
+}
+
+// Stats returns the current cache occupancy and counters, summed over the shards.
+func (c *Cache[K, V]) Stats() Stats {
+	if c == nil {
+		return Stats{}
+	}
+
+	stats := Stats{MaxEntries: c.maxEntries}
+	for _, s := range c.shards {
+		s.addStats(&stats)
+	}
+	return stats
+}
+
+// Keys returns the keys of up to limit unexpired entries (all of them for a non-positive limit),
+// most recently used first within each shard but in no particular order across shards.
+func (c *Cache[K, V]) Keys(limit int) []K {
+	if c == nil {
+		return nil
+	}
+
+	keys := make([]K, 0)
+	now := time.Now()
+	for _, s := range c.shards {
+		if keys = s.appendKeys(keys, limit, now); limit > 0 && len(keys) >= limit {
+			break
+		}
+	}
+	return keys
+}
+
+// RemoveExpired removes every entry whose TTL and stale window have elapsed and returns the number
+// removed.
+// Expired entries are otherwise only removed lazily on Get, so this is intended to be called
+// periodically to release memory held by entries that are no longer read.
+func (c *Cache[K, V]) RemoveExpired() int {
+	if c == nil {
+		return 0
+	}
+
+	removed := 0
+	for _, s := range c.shards {
+		removed += s.removeExpired()
+	}
+	return removed
+}
+
+// get returns the value stored under key and promotes it to most recently used.
+func (c *shard[K, V]) get(key K) (V, bool) {
+	var empty V
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	e, ok := c.entries[key]
+	if !ok {
+		c.misses++
+		return empty, false
+	}
+
+	now := time.Now()
+	if now.After(e.expiresAt) {
+		// expired; treat as miss and remove once past the stale window
+		c.removeIfGoneLocked(e, now)
+		c.misses++
+		return empty, false
+	}
+
+	c.moveToFrontLocked(e)
+	c.hits++
+
+	return e.value, true
+}
+
+// getStale is get that also returns an entry within the stale window, with stale set.
+func (c *shard[K, V]) getStale(key K) (value V, stale bool, ok bool) {
+	var empty V
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	e, ok := c.entries[key]
+	if !ok {
+		c.misses++
+		return empty, false, false
+	}
+
+	now := time.Now()
+	if c.removeIfGoneLocked(e, now) {
+		c.misses++
+		return empty, false, false
+	}
+
+	c.moveToFrontLocked(e)
+	c.hits++
+
+	return e.value, now.After(e.expiresAt), true
+}
+
+// setStaleWindow sets how long expired entries are kept for getStale.
+func (c *shard[K, V]) setStaleWindow(window time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.staleWindow = max(window, 0)
+}
+
+// setJitter sets the largest share of a TTL that set randomly takes off it.
+func (c *shard[K, V]) setJitter(fraction float64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.jitter = min(max(fraction, 0), 1)
+}
+
+// set stores value under key for ttl, evicting the least recently used entry if the shard is full.
+func (c *shard[K, V]) set(key K, value V, ttl time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if ttl <= 0 {
+		ttl = c.defaultTTL
+	}
+	if ttl <= 0 {
+		ttl = DefaultTTL
+	}
+	if c.jitter > 0 {
+		ttl -= time.Duration(rand.Float64() * c.jitter * float64(ttl))
+	}
+	if c.entries == nil {
+		c.entries = make(map[K]*entry[K, V], c.maxEntries)
+	}
+
+	// Update existing entry
+	if e, exists := c.entries[key]; exists {
+		e.value = value
+		e.expiresAt = time.Now().Add(ttl)
+		c.moveToFrontLocked(e)
+		return
+	}
+
+	// Evict LRU entry if at capacity
+	if c.maxEntries > 0 && len(c.entries) >= c.maxEntries && c.tail != nil {
+		c.removeLocked(c.tail)
+		c.evictions++
+	}
+
+	e := &entry[K, V]{
+		key:       key,
+		value:     value,
+		expiresAt: time.Now().Add(ttl),
+	}
+	c.entries[key] = e
+	c.addToFrontLocked(e)
+}
+
+// hasRoom reports whether key is in the shard or the shard is below its capacity.
+func (c *shard[K, V]) hasRoom(key K) bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	if _, ok := c.entries[key]; ok {
+		return true
+	}
+	return c.maxEntries <= 0 || len(c.entries) < c.maxEntries
+}
+
+// invalidate removes key from the shard if present.
+func (c *shard[K, V]) invalidate(key K) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if e, ok := c.entries[key]; ok {
+		c.removeLocked(e)
+	}
+}
+
+// purge removes every entry from the shard.
+func (c *shard[K, V]) purge() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.entries = make(map[K]*entry[K, V], c.maxEntries)
+	c.head = nil
+	c.tail = nil
+}
+
+// addStats adds the shard's occupancy and counters to stats.
+func (c *shard[K, V]) addStats(stats *Stats) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	stats.Entries += len(c.entries)
+	stats.Hits += c.hits
+	stats.Misses += c.misses
+	stats.Evictions += c.evictions
+	stats.Expirations += c.expirations
+}
+
+// appendKeys appends the keys of the shard's unexpired entries to keys, until it holds limit keys.
+func (c *shard[K, V]) appendKeys(keys []K, limit int, now time.Time) []K {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	for e := c.head; e != nil && (limit <= 0 || len(keys) < limit); e = e.next {
+		if !now.After(e.expiresAt) {
+			keys = append(keys, e.key)
+		}
+	}
+	return keys
+}
+
+// removeExpired removes every entry whose TTL and stale window have elapsed and returns the number
+// removed.
+func (c *shard[K, V]) removeExpired() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now()
+	removed := 0
+	for e := c.tail; e != nil; {
+		prev := e.prev
+		if c.removeIfGoneLocked(e, now) {
+			removed++
+		}
+		e = prev
+	}
+
+	return removed
+}
+
+// removeIfGoneLocked removes the entry, counting an expiration, if it expired longer ago than the
+// stale window, and reports whether it did. Must be called with lock held.
+func (c *shard[K, V]) removeIfGoneLocked(e *entry[K, V], now time.Time) bool {
+	if !now.After(e.expiresAt.Add(c.staleWindow)) {
+		return false
+	}
+	c.removeLocked(e)
+	c.expirations++
+	return true
+}
+
+// removeLocked removes an entry from both the map and the LRU list. Must be called with lock held.
+func (c *shard[K, V]) removeLocked(e *entry[K, V]) {
+	if e == nil {
+		return
+	}
+
+	c.unlinkLocked(e)
+	delete(c.entries, e.key)
+}
+
+// addToFrontLocked adds an entry to the front (most recently used position). Must be called with lock held.
+func (c *shard[K, V]) addToFrontLocked(e *entry[K, V]) {
+	if e == nil {
+		return
+	}
+
+	e.next = c.head
+	e.prev = nil
+
+	if c.head != nil {
+		c.head.prev = e
+	}
+	c.head = e
+
+	if c.tail == nil {
+		c.tail = e
+	}
+}
+
+// unlinkLocked detaches an entry from the LRU list. Must be called with lock held.
+func (c *shard[K, V]) unlinkLocked(e *entry[K, V]) {
+	if e == nil {
+		return
+	}
+
+	if e.prev != nil {
+		e.prev.next = e.next
+	} else {
+		c.head = e.next
+	}
+
+	if e.next != nil {
+		e.next.prev = e.prev
+	} else {
+		c.tail = e.prev
+	}
+
+	e.prev = nil
+	e.next = nil
+}
+
+// moveToFrontLocked moves an entry to the front of the LRU list. Must be called with lock held.
+func (c *shard[K, V]) moveToFrontLocked(e *entry[K, V]) {
+	if e == nil || e == c.head {
+		return // already at the front
+	}
+
+	c.unlinkLocked(e)
+	c.addToFrontLocked(e)
+}
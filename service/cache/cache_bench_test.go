@@ -1,4 +1,4 @@
-package service
+package cache
 
 import (
 	"math/rand"
@@ -11,22 +11,22 @@ import (
 // Benchmark cache operations for performance profiling
 
 func BenchmarkCache_Set(b *testing.B) {
-	cache := newInMemoryLogbookCache()
+	cache := newLogbookCache()
 	lb := types.Logbook{ID: 1, Callsign: "W1AW", UserID: 100}
 
 	b.ResetTimer()
 	for i := 0; i < b.N; i++ {
-		cache.Set(int64(i), lb, defaultLogbookCacheTTL)
+		cache.Set(int64(i), lb, DefaultTTL)
 	}
 }
 
 func BenchmarkCache_Get_Hit(b *testing.B) {
-	cache := newInMemoryLogbookCache()
+	cache := newLogbookCache()
 	lb := types.Logbook{ID: 1, Callsign: "W1AW", UserID: 100}
 
 	// Pre-populate cache
 	for i := 0; i < 1000; i++ {
-		cache.Set(int64(i), lb, defaultLogbookCacheTTL)
+		cache.Set(int64(i), lb, DefaultTTL)
 	}
 
 	b.ResetTimer()
@@ -36,7 +36,7 @@ func BenchmarkCache_Get_Hit(b *testing.B) {
 }
 
 func BenchmarkCache_Get_Miss(b *testing.B) {
-	cache := newInMemoryLogbookCache()
+	cache := newLogbookCache()
 
 	b.ResetTimer()
 	for i := 0; i < b.N; i++ {
@@ -45,50 +45,47 @@ func BenchmarkCache_Get_Miss(b *testing.B) {
 }
 
 func BenchmarkCache_SetUpdate(b *testing.B) {
-	cache := newInMemoryLogbookCache()
+	cache := newLogbookCache()
 	lb := types.Logbook{ID: 1, Callsign: "W1AW", UserID: 100}
 
 	// Pre-populate with one entry
-	cache.Set(1, lb, defaultLogbookCacheTTL)
+	cache.Set(1, lb, DefaultTTL)
 
 	b.ResetTimer()
 	for i := 0; i < b.N; i++ {
 		lb.UserID = int64(i)
-		cache.Set(1, lb, defaultLogbookCacheTTL)
+		cache.Set(1, lb, DefaultTTL)
 	}
 }
 
 func BenchmarkCache_Invalidate(b *testing.B) {
-	cache := newInMemoryLogbookCache()
+	cache := newLogbookCache()
 	lb := types.Logbook{ID: 1, Callsign: "W1AW", UserID: 100}
 
 	b.ResetTimer()
 	for i := 0; i < b.N; i++ {
-		cache.Set(int64(i), lb, defaultLogbookCacheTTL)
+		cache.Set(int64(i), lb, DefaultTTL)
 		cache.Invalidate(int64(i))
 	}
 }
 
 func BenchmarkCache_LRU_Eviction(b *testing.B) {
-	cache := &inMemoryLogbookCache{
-		entries:    make(map[int64]*logbookCacheEntry),
-		maxEntries: 1000,
-	}
+	cache := New[int64, types.Logbook](1000, DefaultTTL)
 	lb := types.Logbook{ID: 1, Callsign: "W1AW", UserID: 100}
 
 	b.ResetTimer()
 	for i := 0; i < b.N; i++ {
-		cache.Set(int64(i), lb, defaultLogbookCacheTTL)
+		cache.Set(int64(i), lb, DefaultTTL)
 	}
 }
 
 func BenchmarkCache_MixedWorkload(b *testing.B) {
-	cache := newInMemoryLogbookCache()
+	cache := newLogbookCache()
 	lb := types.Logbook{ID: 1, Callsign: "W1AW", UserID: 100}
 
 	// Pre-populate
 	for i := 0; i < 500; i++ {
-		cache.Set(int64(i), lb, defaultLogbookCacheTTL)
+		cache.Set(int64(i), lb, DefaultTTL)
 	}
 
 	b.ResetTimer()
@@ -100,7 +97,7 @@ func BenchmarkCache_MixedWorkload(b *testing.B) {
 		case op < 7: // 70% reads
 			cache.Get(id)
 		case op < 9: // 20% writes
-			cache.Set(id, lb, defaultLogbookCacheTTL)
+			cache.Set(id, lb, DefaultTTL)
 		default: // 10% invalidations
 			cache.Invalidate(id)
 		}
@@ -108,12 +105,12 @@ func BenchmarkCache_MixedWorkload(b *testing.B) {
 }
 
 func BenchmarkCache_Parallel_Get(b *testing.B) {
-	cache := newInMemoryLogbookCache()
+	cache := newLogbookCache()
 	lb := types.Logbook{ID: 1, Callsign: "W1AW", UserID: 100}
 
 	// Pre-populate
 	for i := 0; i < 1000; i++ {
-		cache.Set(int64(i), lb, defaultLogbookCacheTTL)
+		cache.Set(int64(i), lb, DefaultTTL)
 	}
 
 	b.ResetTimer()
@@ -127,26 +124,26 @@ func BenchmarkCache_Parallel_Get(b *testing.B) {
 }
 
 func BenchmarkCache_Parallel_Set(b *testing.B) {
-	cache := newInMemoryLogbookCache()
+	cache := newLogbookCache()
 	lb := types.Logbook{ID: 1, Callsign: "W1AW", UserID: 100}
 
 	b.ResetTimer()
 	b.RunParallel(func(pb *testing.PB) {
 		i := int64(0)
 		for pb.Next() {
-			cache.Set(i%1000, lb, defaultLogbookCacheTTL)
+			cache.Set(i%1000, lb, DefaultTTL)
 			i++
 		}
 	})
 }
 
 func BenchmarkCache_Parallel_MixedWorkload(b *testing.B) {
-	cache := newInMemoryLogbookCache()
+	cache := newLogbookCache()
 	lb := types.Logbook{ID: 1, Callsign: "W1AW", UserID: 100}
 
 	// Pre-populate
 	for i := 0; i < 500; i++ {
-		cache.Set(int64(i), lb, defaultLogbookCacheTTL)
+		cache.Set(int64(i), lb, DefaultTTL)
 	}
 
 	b.ResetTimer()
@@ -160,7 +157,7 @@ func BenchmarkCache_Parallel_MixedWorkload(b *testing.B) {
 			case op < 7: // 70% reads
 				cache.Get(id)
 			case op < 9: // 20% writes
-				cache.Set(id, lb, defaultLogbookCacheTTL)
+				cache.Set(id, lb, DefaultTTL)
 			default: // 10% invalidations
 				cache.Invalidate(id)
 			}
@@ -170,7 +167,7 @@ func BenchmarkCache_Parallel_MixedWorkload(b *testing.B) {
 }
 
 func BenchmarkCache_Expiration_Check(b *testing.B) {
-	cache := newInMemoryLogbookCache()
+	cache := newLogbookCache()
 	lb := types.Logbook{ID: 1, Callsign: "W1AW", UserID: 100}
 
 	// Pre-populate with expired entries
@@ -188,12 +185,12 @@ func BenchmarkCache_Expiration_Check(b *testing.B) {
 }
 
 func BenchmarkCache_RandomAccess(b *testing.B) {
-	cache := newInMemoryLogbookCache()
+	cache := newLogbookCache()
 	lb := types.Logbook{ID: 1, Callsign: "W1AW", UserID: 100}
 
 	// Pre-populate
 	for i := 0; i < 10000; i++ {
-		cache.Set(int64(i), lb, defaultLogbookCacheTTL)
+		cache.Set(int64(i), lb, DefaultTTL)
 	}
 
 	// Create random access pattern
@@ -210,31 +207,28 @@ func BenchmarkCache_RandomAccess(b *testing.B) {
 }
 
 func BenchmarkCache_LRU_ThrashingWorstCase(b *testing.B) {
-	cache := &inMemoryLogbookCache{
-		entries:    make(map[int64]*logbookCacheEntry),
-		maxEntries: 100,
-	}
+	cache := New[int64, types.Logbook](100, DefaultTTL)
 	lb := types.Logbook{ID: 1, Callsign: "W1AW", UserID: 100}
 
 	// Fill cache to capacity
 	for i := 0; i < 100; i++ {
-		cache.Set(int64(i), lb, defaultLogbookCacheTTL)
+		cache.Set(int64(i), lb, DefaultTTL)
 	}
 
 	b.ResetTimer()
 	// Keep adding entries that will cause evictions
 	for i := 0; i < b.N; i++ {
-		cache.Set(int64(100+i), lb, defaultLogbookCacheTTL)
+		cache.Set(int64(100+i), lb, DefaultTTL)
 	}
 }
 
 func BenchmarkCache_SequentialAccess(b *testing.B) {
-	cache := newInMemoryLogbookCache()
+	cache := newLogbookCache()
 	lb := types.Logbook{ID: 1, Callsign: "W1AW", UserID: 100}
 
 	// Pre-populate
 	for i := 0; i < 10000; i++ {
-		cache.Set(int64(i), lb, defaultLogbookCacheTTL)
+		cache.Set(int64(i), lb, DefaultTTL)
 	}
 
 	b.ResetTimer()
@@ -244,12 +238,12 @@ func BenchmarkCache_SequentialAccess(b *testing.B) {
 }
 
 func BenchmarkCache_HotKey(b *testing.B) {
-	cache := newInMemoryLogbookCache()
+	cache := newLogbookCache()
 	lb := types.Logbook{ID: 1, Callsign: "W1AW", UserID: 100}
 
 	// Pre-populate
 	for i := 0; i < 1000; i++ {
-		cache.Set(int64(i), lb, defaultLogbookCacheTTL)
+		cache.Set(int64(i), lb, DefaultTTL)
 	}
 
 	b.ResetTimer()
@@ -260,12 +254,12 @@ func BenchmarkCache_HotKey(b *testing.B) {
 }
 
 func BenchmarkCache_ColdKey(b *testing.B) {
-	cache := newInMemoryLogbookCache()
+	cache := newLogbookCache()
 	lb := types.Logbook{ID: 1, Callsign: "W1AW", UserID: 100}
 
 	// Pre-populate
 	for i := 0; i < 1000; i++ {
-		cache.Set(int64(i), lb, defaultLogbookCacheTTL)
+		cache.Set(int64(i), lb, DefaultTTL)
 	}
 
 	b.ResetTimer()
@@ -273,9 +267,9 @@ func BenchmarkCache_ColdKey(b *testing.B) {
 	for i := 0; i < b.N; i++ {
 		cache.Get(0) // First entry added, should be at tail
 		// Re-add to push it back to tail for next iteration
-		cache.Set(0, lb, defaultLogbookCacheTTL)
+		cache.Set(0, lb, DefaultTTL)
 		for j := 1; j < 1000; j++ {
-			cache.Set(int64(j), lb, defaultLogbookCacheTTL)
+			cache.Set(int64(j), lb, DefaultTTL)
 		}
 	}
 }
@@ -0,0 +1,738 @@
+package cache
+
+import (
+	"testing"
+	"time"
+
+	"github.com/Station-Manager/types"
+)
+
+const testMaxEntries = 1024
+
+func newLogbookCache() *Cache[int64, types.Logbook] {
+	return New[int64, types.Logbook](testMaxEntries, DefaultTTL)
+}
+
+func TestNew(t *testing.T) {
+	cache := newLogbookCache()
+	if cache == nil {
+		t.Fatal("expected non-nil cache")
+	}
+	if cache.maxEntries != testMaxEntries {
+		t.Errorf("expected maxEntries=%d, got %d", testMaxEntries, cache.maxEntries)
+	}
+	if len(cache.shards) != maxShards {
+		t.Errorf("expected %d shards, got %d", maxShards, len(cache.shards))
+	}
+	for _, s := range cache.shards {
+		if s.entries == nil {
+			t.Error("expected initialized entries map")
+		}
+		if s.maxEntries != testMaxEntries/maxShards {
+			t.Errorf("expected shard maxEntries=%d, got %d", testMaxEntries/maxShards, s.maxEntries)
+		}
+		if s.head != nil || s.tail != nil {
+			t.Error("expected nil head and tail")
+		}
+	}
+}
+
+func TestCache_SetAndGet(t *testing.T) {
+	cache := newLogbookCache()
+
+	lb := types.Logbook{
+		ID:       1,
+		Callsign: "W1AW",
+		UserID:   100,
+	}
+
+	// Set a logbook
+	cache.Set(1, lb, 5*time.Minute)
+
+	// Get it back
+	retrieved, ok := cache.Get(1)
+	if !ok {
+		t.Fatal("expected cache hit")
+	}
+	if retrieved.ID != lb.ID {
+		t.Errorf("expected ID=%d, got %d", lb.ID, retrieved.ID)
+	}
+	if retrieved.Callsign != lb.Callsign {
+		t.Errorf("expected Callsign=%s, got %s", lb.Callsign, retrieved.Callsign)
+	}
+}
+
+func TestCache_GetNonExistent(t *testing.T) {
+	cache := newLogbookCache()
+
+	_, ok := cache.Get(999)
+	if ok {
+		t.Error("expected cache miss for non-existent key")
+	}
+}
+
+func TestCache_GetExpired(t *testing.T) {
+	cache := newLogbookCache()
+
+	lb := types.Logbook{ID: 1, Callsign: "W1AW"}
+	cache.Set(1, lb, 1*time.Millisecond)
+
+	// Wait for expiration
+	time.Sleep(10 * time.Millisecond)
+
+	_, ok := cache.Get(1)
+	if ok {
+		t.Error("expected cache miss for expired entry")
+	}
+
+	// Verify entry was removed
+	if _, exists := cache.shardFor(1).entries[1]; exists {
+		t.Error("expected expired entry to be removed from cache")
+	}
+}
+
+func TestCache_UpdateExisting(t *testing.T) {
+	cache := newLogbookCache()
+
+	lb1 := types.Logbook{ID: 1, Callsign: "W1AW"}
+	cache.Set(1, lb1, 5*time.Minute)
+
+	lb2 := types.Logbook{ID: 1, Callsign: "W2XYZ"}
+	cache.Set(1, lb2, 5*time.Minute)
+
+	retrieved, ok := cache.Get(1)
+	if !ok {
+		t.Fatal("expected cache hit")
+	}
+	if retrieved.Callsign != "W2XYZ" {
+		t.Errorf("expected updated Callsign=W2XYZ, got %s", retrieved.Callsign)
+	}
+
+	// Verify only one entry exists
+	if entries := cache.Stats().Entries; entries != 1 {
+		t.Errorf("expected 1 entry, got %d", entries)
+	}
+}
+
+func TestCache_LRUEviction(t *testing.T) {
+	cache := New[int64, types.Logbook](3, DefaultTTL)
+
+	// Fill cache to capacity
+	cache.Set(1, types.Logbook{ID: 1, Callsign: "W1AW"}, 5*time.Minute)
+	cache.Set(2, types.Logbook{ID: 2, Callsign: "W2AW"}, 5*time.Minute)
+	cache.Set(3, types.Logbook{ID: 3, Callsign: "W3AW"}, 5*time.Minute)
+
+	if len(cache.shards[0].entries) != 3 {
+		t.Fatalf("expected 3 entries, got %d", len(cache.shards[0].entries))
+	}
+
+	// Access entry 1 to make it recently used
+	_, ok := cache.Get(1)
+	if !ok {
+		t.Fatal("expected cache hit for entry 1")
+	}
+
+	// Add a 4th entry, should evict entry 2 (oldest unused)
+	cache.Set(4, types.Logbook{ID: 4, Callsign: "W4AW"}, 5*time.Minute)
+
+	if len(cache.shards[0].entries) != 3 {
+		t.Errorf("expected 3 entries after eviction, got %d", len(cache.shards[0].entries))
+	}
+
+	// Entry 2 should be evicted
+	_, ok = cache.Get(2)
+	if ok {
+		t.Error("expected entry 2 to be evicted")
+	}
+
+	// Entries 1, 3, 4 should still exist
+	for _, id := range []int64{1, 3, 4} {
+		if _, ok := cache.Get(id); !ok {
+			t.Errorf("expected entry %d to still exist", id)
+		}
+	}
+}
+
+func TestCache_LRUOrderAfterAccess(t *testing.T) {
+	cache := New[int64, types.Logbook](3, DefaultTTL)
+
+	// Add 3 entries
+	cache.Set(1, types.Logbook{ID: 1, Callsign: "W1AW"}, 5*time.Minute)
+	cache.Set(2, types.Logbook{ID: 2, Callsign: "W2AW"}, 5*time.Minute)
+	cache.Set(3, types.Logbook{ID: 3, Callsign: "W3AW"}, 5*time.Minute)
+
+	// Access entries in order: 1, 2, 3
+	cache.Get(1)
+	cache.Get(2)
+	cache.Get(3)
+
+	// Now 3 is most recent, 1 is least recent
+	// Adding entry 4 should evict entry 1
+	cache.Set(4, types.Logbook{ID: 4, Callsign: "W4AW"}, 5*time.Minute)
+
+	_, ok := cache.Get(1)
+	if ok {
+		t.Error("expected entry 1 (LRU) to be evicted")
+	}
+
+	for _, id := range []int64{2, 3, 4} {
+		if _, ok := cache.Get(id); !ok {
+			t.Errorf("expected entry %d to still exist", id)
+		}
+	}
+}
+
+func TestCache_Invalidate(t *testing.T) {
+	cache := newLogbookCache()
+
+	lb := types.Logbook{ID: 1, Callsign: "W1AW"}
+	cache.Set(1, lb, 5*time.Minute)
+
+	// Verify it exists
+	_, ok := cache.Get(1)
+	if !ok {
+		t.Fatal("expected cache hit before invalidation")
+	}
+
+	// Invalidate
+	cache.Invalidate(1)
+
+	// Verify it's gone
+	_, ok = cache.Get(1)
+	if ok {
+		t.Error("expected cache miss after invalidation")
+	}
+
+	if _, exists := cache.shardFor(1).entries[1]; exists {
+		t.Error("expected entry to be removed from map")
+	}
+}
+
+func TestCache_InvalidateNonExistent(t *testing.T) {
+	cache := newLogbookCache()
+
+	// Should not panic
+	cache.Invalidate(999)
+}
+
+func TestCache_SetWithDefaultTTL(t *testing.T) {
+	cache := newLogbookCache()
+
+	lb := types.Logbook{ID: 1, Callsign: "W1AW"}
+	cache.Set(1, lb, 0) // 0 or negative should use default
+
+	entry, ok := cache.shardFor(1).entries[1]
+	if !ok {
+		t.Fatal("expected entry to exist")
+	}
+
+	expectedExpiry := time.Now().Add(DefaultTTL)
+	diff := entry.expiresAt.Sub(expectedExpiry).Abs()
+	if diff > time.Second {
+		t.Errorf("expected expiry close to default TTL, diff=%v", diff)
+	}
+}
+
+func TestCache_NilReceiver(t *testing.T) {
+	var cache *Cache[int64, types.Logbook]
+
+	// All methods should handle nil receiver gracefully
+	_, ok := cache.Get(1)
+	if ok {
+		t.Error("expected false from Get on nil cache")
+	}
+
+	cache.Set(1, types.Logbook{}, 5*time.Minute) // Should not panic
+	cache.Invalidate(1)                          // Should not panic
+}
+
+func TestCache_LRUListIntegrity(t *testing.T) {
+	cache := New[int64, types.Logbook](5, DefaultTTL)
+
+	// Add several entries
+	for i := int64(1); i <= 5; i++ {
+		cache.Set(i, types.Logbook{ID: i, Callsign: "TEST"}, 5*time.Minute)
+	}
+
+	// Verify list integrity: head should be most recent (5), tail should be oldest (1)
+	if cache.shards[0].head == nil || cache.shards[0].head.key != 5 {
+		t.Errorf("expected head.key=5, got %v", cache.shards[0].head)
+	}
+	if cache.shards[0].tail == nil || cache.shards[0].tail.key != 1 {
+		t.Errorf("expected tail.key=1, got %v", cache.shards[0].tail)
+	}
+
+	// Walk from head to tail
+	node := cache.shards[0].head
+	count := 0
+	for node != nil {
+		count++
+		node = node.next
+	}
+	if count != 5 {
+		t.Errorf("expected 5 nodes walking head->tail, got %d", count)
+	}
+
+	// Walk from tail to head
+	node = cache.shards[0].tail
+	count = 0
+	for node != nil {
+		count++
+		node = node.prev
+	}
+	if count != 5 {
+		t.Errorf("expected 5 nodes walking tail->head, got %d", count)
+	}
+}
+
+func TestCache_AccessPromotesToFront(t *testing.T) {
+	cache := New[int64, types.Logbook](5, DefaultTTL)
+
+	// Add 3 entries
+	cache.Set(1, types.Logbook{ID: 1}, 5*time.Minute)
+	cache.Set(2, types.Logbook{ID: 2}, 5*time.Minute)
+	cache.Set(3, types.Logbook{ID: 3}, 5*time.Minute)
+
+	// Head should be 3 (most recent set)
+	if cache.shards[0].head.key != 3 {
+		t.Errorf("expected head.key=3, got %d", cache.shards[0].head.key)
+	}
+
+	// Access entry 1 (currently at tail)
+	cache.Get(1)
+
+	// Now head should be 1
+	if cache.shards[0].head.key != 1 {
+		t.Errorf("expected head.key=1 after access, got %d", cache.shards[0].head.key)
+	}
+}
+
+func TestCache_RemoveMiddleNode(t *testing.T) {
+	cache := New[int64, types.Logbook](5, DefaultTTL)
+
+	// Add 3 entries
+	cache.Set(1, types.Logbook{ID: 1}, 5*time.Minute)
+	cache.Set(2, types.Logbook{ID: 2}, 5*time.Minute)
+	cache.Set(3, types.Logbook{ID: 3}, 5*time.Minute)
+
+	// Remove middle entry
+	cache.Invalidate(2)
+
+	// Verify list integrity
+	if cache.shards[0].head.key != 3 {
+		t.Errorf("expected head.key=3, got %d", cache.shards[0].head.key)
+	}
+	if cache.shards[0].tail.key != 1 {
+		t.Errorf("expected tail.key=1, got %d", cache.shards[0].tail.key)
+	}
+
+	// Walk the list
+	node := cache.shards[0].head
+	count := 0
+	for node != nil {
+		count++
+		if node.key == 2 {
+			t.Error("entry 2 should not be in list")
+		}
+		node = node.next
+	}
+	if count != 2 {
+		t.Errorf("expected 2 nodes after removal, got %d", count)
+	}
+}
+
+func TestCache_RemoveHeadNode(t *testing.T) {
+	cache := New[int64, types.Logbook](5, DefaultTTL)
+
+	cache.Set(1, types.Logbook{ID: 1}, 5*time.Minute)
+	cache.Set(2, types.Logbook{ID: 2}, 5*time.Minute)
+
+	// Remove head
+	cache.Invalidate(2)
+
+	if cache.shards[0].head.key != 1 {
+		t.Errorf("expected head.key=1, got %d", cache.shards[0].head.key)
+	}
+	if cache.shards[0].tail.key != 1 {
+		t.Errorf("expected tail.key=1, got %d", cache.shards[0].tail.key)
+	}
+}
+
+func TestCache_RemoveTailNode(t *testing.T) {
+	cache := New[int64, types.Logbook](5, DefaultTTL)
+
+	cache.Set(1, types.Logbook{ID: 1}, 5*time.Minute)
+	cache.Set(2, types.Logbook{ID: 2}, 5*time.Minute)
+
+	// Remove tail
+	cache.Invalidate(1)
+
+	if cache.shards[0].head.key != 2 {
+		t.Errorf("expected head.key=2, got %d", cache.shards[0].head.key)
+	}
+	if cache.shards[0].tail.key != 2 {
+		t.Errorf("expected tail.key=2, got %d", cache.shards[0].tail.key)
+	}
+}
+
+func TestCache_RemoveOnlyNode(t *testing.T) {
+	cache := New[int64, types.Logbook](5, DefaultTTL)
+
+	cache.Set(1, types.Logbook{ID: 1}, 5*time.Minute)
+	cache.Invalidate(1)
+
+	if cache.shards[0].head != nil {
+		t.Error("expected nil head after removing only node")
+	}
+	if cache.shards[0].tail != nil {
+		t.Error("expected nil tail after removing only node")
+	}
+	if len(cache.shards[0].entries) != 0 {
+		t.Errorf("expected 0 entries, got %d", len(cache.shards[0].entries))
+	}
+}
+
+func TestCache_ConcurrentAccess(t *testing.T) {
+	cache := newLogbookCache()
+
+	// Add some initial data
+	for i := int64(1); i <= 10; i++ {
+		cache.Set(i, types.Logbook{ID: i, Callsign: "TEST"}, 5*time.Minute)
+	}
+
+	// Spawn multiple goroutines doing reads and writes
+	done := make(chan bool)
+	for g := 0; g < 10; g++ {
+		go func(id int64) {
+			for i := 0; i < 100; i++ {
+				cache.Set(id, types.Logbook{ID: id}, 5*time.Minute)
+				cache.Get(id)
+				if i%10 == 0 {
+					cache.Invalidate(id)
+				}
+			}
+			done <- true
+		}(int64(g + 1))
+	}
+
+	// Wait for all goroutines
+	for g := 0; g < 10; g++ {
+		<-done
+	}
+}
+
+func TestCache_SetWithNegativeTTL(t *testing.T) {
+	cache := newLogbookCache()
+
+	lb := types.Logbook{ID: 1, Callsign: "W1AW"}
+	cache.Set(1, lb, -5*time.Minute) // Negative should use default
+
+	entry, ok := cache.shardFor(1).entries[1]
+	if !ok {
+		t.Fatal("expected entry to exist")
+	}
+
+	expectedExpiry := time.Now().Add(DefaultTTL)
+	diff := entry.expiresAt.Sub(expectedExpiry).Abs()
+	if diff > time.Second {
+		t.Errorf("expected expiry close to default TTL, diff=%v", diff)
+	}
+}
+
+func TestCache_AccessAlreadyAtFront(t *testing.T) {
+	cache := New[int64, types.Logbook](5, DefaultTTL)
+
+	cache.Set(1, types.Logbook{ID: 1}, 5*time.Minute)
+	cache.Set(2, types.Logbook{ID: 2}, 5*time.Minute)
+
+	// 2 is already at front (head)
+	if cache.shards[0].head.key != 2 {
+		t.Fatalf("expected head.key=2, got %d", cache.shards[0].head.key)
+	}
+
+	// Access it again - should still be at front
+	cache.Get(2)
+
+	if cache.shards[0].head.key != 2 {
+		t.Errorf("expected head.key=2 after accessing front node, got %d", cache.shards[0].head.key)
+	}
+
+	// Verify list integrity
+	if cache.shards[0].tail.key != 1 {
+		t.Errorf("expected tail.key=1, got %d", cache.shards[0].tail.key)
+	}
+}
+
+func TestCache_EvictWhenEmpty(t *testing.T) {
+	cache := New[int64, types.Logbook](0, DefaultTTL)
+
+	// Should not panic or evict when maxEntries is 0
+	for i := int64(1); i <= 10; i++ {
+		cache.Set(i, types.Logbook{ID: i}, 5*time.Minute)
+	}
+
+	if entries := cache.Stats().Entries; entries != 10 {
+		t.Errorf("expected 10 entries, got %d", entries)
+	}
+}
+
+func TestCache_MultipleEvictions(t *testing.T) {
+	cache := New[int64, types.Logbook](2, DefaultTTL)
+
+	// Fill and overflow multiple times
+	for i := int64(1); i <= 10; i++ {
+		cache.Set(i, types.Logbook{ID: i, Callsign: "TEST"}, 5*time.Minute)
+
+		// Should never exceed maxEntries
+		if len(cache.shards[0].entries) > cache.maxEntries {
+			t.Errorf("cache size %d exceeds maxEntries %d", len(cache.shards[0].entries), cache.maxEntries)
+		}
+	}
+
+	// Should only have last 2 entries
+	if len(cache.shards[0].entries) != 2 {
+		t.Errorf("expected 2 entries, got %d", len(cache.shards[0].entries))
+	}
+
+	// Should have entries 9 and 10
+	for _, id := range []int64{9, 10} {
+		if _, ok := cache.Get(id); !ok {
+			t.Errorf("expected entry %d to exist", id)
+		}
+	}
+}
+
+func TestCache_UpdateExistingPromotesToFront(t *testing.T) {
+	cache := New[int64, types.Logbook](5, DefaultTTL)
+
+	cache.Set(1, types.Logbook{ID: 1, Callsign: "OLD"}, 5*time.Minute)
+	cache.Set(2, types.Logbook{ID: 2, Callsign: "TEST"}, 5*time.Minute)
+	cache.Set(3, types.Logbook{ID: 3, Callsign: "TEST"}, 5*time.Minute)
+
+	// Update entry 1 (currently at tail)
+	cache.Set(1, types.Logbook{ID: 1, Callsign: "NEW"}, 5*time.Minute)
+
+	// Should now be at front
+	if cache.shards[0].head.key != 1 {
+		t.Errorf("expected head.key=1 after update, got %d", cache.shards[0].head.key)
+	}
+
+	// Verify value was updated
+	lb, ok := cache.Get(1)
+	if !ok {
+		t.Fatal("expected to find entry 1")
+	}
+	if lb.Callsign != "NEW" {
+		t.Errorf("expected Callsign=NEW, got %s", lb.Callsign)
+	}
+}
+
+func TestCache_SetWithNilEntries(t *testing.T) {
+	cache := &shard[int64, types.Logbook]{
+		entries:    nil, // nil map
+		maxEntries: 5,
+	}
+
+	// Should initialize the map and not panic
+	cache.set(1, types.Logbook{ID: 1}, 5*time.Minute)
+
+	if cache.entries == nil {
+		t.Fatal("expected entries to be initialized")
+	}
+
+	if len(cache.entries) != 1 {
+		t.Errorf("expected 1 entry, got %d", len(cache.entries))
+	}
+}
+
+func TestCache_HelperMethodsWithNil(t *testing.T) {
+	cache := New[int64, types.Logbook](5, DefaultTTL)
+
+	// Test helper methods with nil parameters - should not panic
+	cache.shards[0].addToFrontLocked(nil)
+	cache.shards[0].unlinkLocked(nil)
+	cache.shards[0].moveToFrontLocked(nil)
+
+	// Cache should remain empty
+	if len(cache.shards[0].entries) != 0 {
+		t.Errorf("expected 0 entries, got %d", len(cache.shards[0].entries))
+	}
+}
+
+func TestCache_RemoveExpired(t *testing.T) {
+	cache := New[int64, types.Logbook](5, DefaultTTL)
+
+	cache.Set(1, types.Logbook{ID: 1}, 1*time.Millisecond)
+	cache.Set(2, types.Logbook{ID: 2}, 5*time.Minute)
+	cache.Set(3, types.Logbook{ID: 3}, 1*time.Millisecond)
+
+	time.Sleep(10 * time.Millisecond)
+
+	if removed := cache.RemoveExpired(); removed != 2 {
+		t.Fatalf("expected 2 expired entries removed, got %d", removed)
+	}
+	if len(cache.shards[0].entries) != 1 {
+		t.Errorf("expected 1 entry remaining, got %d", len(cache.shards[0].entries))
+	}
+	if cache.shards[0].head == nil || cache.shards[0].head != cache.shards[0].tail || cache.shards[0].head.key != 2 {
+		t.Error("expected entry 2 to be the only node in the LRU list")
+	}
+	if got := cache.Stats().Expirations; got != 2 {
+		t.Errorf("expected 2 expirations, got %d", got)
+	}
+}
+
+func TestCache_StatsCountsEvictions(t *testing.T) {
+	cache := New[int64, types.Logbook](2, DefaultTTL)
+
+	for i := int64(1); i <= 5; i++ {
+		cache.Set(i, types.Logbook{ID: i}, 5*time.Minute)
+	}
+
+	stats := cache.Stats()
+	if stats.Evictions != 3 {
+		t.Errorf("expected 3 evictions, got %d", stats.Evictions)
+	}
+	if stats.Entries != 2 {
+		t.Errorf("expected 2 entries, got %d", stats.Entries)
+	}
+}
+
+func TestCache_GetStale(t *testing.T) {
+	cache := New[int64, types.Logbook](5, DefaultTTL)
+	cache.SetStaleWindow(time.Hour)
+
+	cache.Set(1, types.Logbook{ID: 1}, 1*time.Millisecond)
+	cache.Set(2, types.Logbook{ID: 2}, 5*time.Minute)
+	time.Sleep(10 * time.Millisecond)
+
+	if _, ok := cache.Get(1); ok {
+		t.Error("expected Get to miss an expired entry")
+	}
+	if lb, stale, ok := cache.GetStale(1); !ok || !stale || lb.ID != 1 {
+		t.Errorf("expected the expired entry to be served stale, got %+v stale=%v ok=%v", lb, stale, ok)
+	}
+	if _, stale, ok := cache.GetStale(2); !ok || stale {
+		t.Errorf("expected a fresh entry, got stale=%v ok=%v", stale, ok)
+	}
+	if removed := cache.RemoveExpired(); removed != 0 {
+		t.Errorf("expected entries within the stale window to be kept, got %d removed", removed)
+	}
+
+	cache.SetStaleWindow(0)
+	if _, _, ok := cache.GetStale(1); ok {
+		t.Error("expected the entry to be gone once past the stale window")
+	}
+	if _, exists := cache.shardFor(1).entries[1]; exists {
+		t.Error("expected the entry past the stale window to be removed")
+	}
+}
+
+func TestCache_StatsCountsHitsAndMisses(t *testing.T) {
+	cache := New[int64, types.Logbook](2, DefaultTTL)
+	cache.Set(1, types.Logbook{ID: 1}, 5*time.Minute)
+
+	cache.Get(1)
+	cache.Get(1)
+	cache.Get(2)
+	cache.GetStale(3)
+
+	stats := cache.Stats()
+	if stats.Hits != 2 || stats.Misses != 2 {
+		t.Errorf("expected 2 hits and 2 misses, got %d and %d", stats.Hits, stats.Misses)
+	}
+	if rate := stats.HitRate(); rate != 0.5 {
+		t.Errorf("expected a hit rate of 0.5, got %v", rate)
+	}
+	if rate := (Stats{}).HitRate(); rate != 0 {
+		t.Errorf("expected no hit rate before any lookup, got %v", rate)
+	}
+}
+
+func TestCache_ShardsByCapacity(t *testing.T) {
+	for maxEntries, want := range map[int]int{0: maxShards, 5: 1, 127: 1, 128: 2, 1024: 16, 8192: maxShards} {
+		if got := len(New[int64, types.Logbook](maxEntries, DefaultTTL).shards); got != want {
+			t.Errorf("expected %d shards for maxEntries=%d, got %d", want, maxEntries, got)
+		}
+	}
+}
+
+func TestCache_ShardedCapacity(t *testing.T) {
+	cache := newLogbookCache()
+
+	for i := int64(0); i < 4*testMaxEntries; i++ {
+		cache.Set(i, types.Logbook{ID: i}, 5*time.Minute)
+	}
+
+	stats := cache.Stats()
+	if stats.Entries > testMaxEntries {
+		t.Errorf("expected at most %d entries, got %d", testMaxEntries, stats.Entries)
+	}
+	if stats.Entries+int(stats.Evictions) != 4*testMaxEntries {
+		t.Errorf("expected every entry to be held or evicted, got %+v", stats)
+	}
+	// The most recent entry is never the one evicted from its shard.
+	if _, ok := cache.Get(4*testMaxEntries - 1); !ok {
+		t.Error("expected the last entry to be cached")
+	}
+}
+
+func TestCache_HasRoom(t *testing.T) {
+	cache := New[int64, types.Logbook](2, DefaultTTL)
+	cache.Set(1, types.Logbook{ID: 1}, 5*time.Minute)
+	if !cache.HasRoom(2) {
+		t.Error("expected room below the capacity")
+	}
+	cache.Set(2, types.Logbook{ID: 2}, 5*time.Minute)
+	if cache.HasRoom(3) {
+		t.Error("expected no room in a full shard")
+	}
+	if !cache.HasRoom(1) {
+		t.Error("expected room for a key already stored")
+	}
+	if !New[int64, types.Logbook](0, DefaultTTL).HasRoom(1) {
+		t.Error("expected room in an unbounded cache")
+	}
+	var nilCache *Cache[int64, types.Logbook]
+	if nilCache.HasRoom(1) {
+		t.Error("expected no room in a nil cache")
+	}
+}
+
+func TestCache_Keys(t *testing.T) {
+	cache := New[int64, types.Logbook](5, DefaultTTL)
+	cache.Set(1, types.Logbook{ID: 1}, 5*time.Minute)
+	cache.Set(2, types.Logbook{ID: 2}, 5*time.Minute)
+	cache.Set(3, types.Logbook{ID: 3}, time.Millisecond)
+	time.Sleep(5 * time.Millisecond)
+
+	if keys := cache.Keys(0); len(keys) != 2 || keys[0] != 2 || keys[1] != 1 {
+		t.Errorf("expected the unexpired keys [2 1], got %v", keys)
+	}
+	if keys := cache.Keys(1); len(keys) != 1 {
+		t.Errorf("expected the keys to be limited to 1, got %v", keys)
+	}
+	var nilCache *Cache[int64, types.Logbook]
+	if keys := nilCache.Keys(0); keys != nil {
+		t.Errorf("expected no keys from a nil cache, got %v", keys)
+	}
+}
+
+func TestCache_SetJitter(t *testing.T) {
+	cache := New[int64, types.Logbook](testMaxEntries, DefaultTTL)
+	cache.SetJitter(0.5)
+
+	before := time.Now()
+	expiries := make(map[time.Time]bool)
+	for i := int64(0); i < 100; i++ {
+		cache.Set(i, types.Logbook{ID: i}, time.Hour)
+		e := cache.shardFor(i).entries[i]
+		if e.expiresAt.Before(before.Add(30*time.Minute)) || e.expiresAt.After(time.Now().Add(time.Hour)) {
+			t.Fatalf("expected an expiry within the jittered hour, got %v", e.expiresAt.Sub(before))
+		}
+		expiries[e.expiresAt] = true
+	}
+	if len(expiries) < 50 {
+		t.Errorf("expected the expiries to be spread, got %d distinct", len(expiries))
+	}
+}
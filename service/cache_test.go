@@ -1,588 +1,178 @@
 package service
 
 import (
+	"context"
+	"fmt"
 	"testing"
 	"time"
 
+	"github.com/Station-Manager/errors"
 	"github.com/Station-Manager/types"
 )
 
-func TestNewInMemoryLogbookCache(t *testing.T) {
-	cache := newInMemoryLogbookCache()
-	if cache == nil {
-		t.Fatal("expected non-nil cache")
-	}
-	if cache.entries == nil {
-		t.Error("expected initialized entries map")
-	}
-	if cache.maxEntries != defaultLogbookCacheMaxEntries {
-		t.Errorf("expected maxEntries=%d, got %d", defaultLogbookCacheMaxEntries, cache.maxEntries)
-	}
-	if cache.head != nil {
-		t.Error("expected nil head")
-	}
-	if cache.tail != nil {
-		t.Error("expected nil tail")
-	}
-}
-
-func TestInMemoryLogbookCache_SetAndGet(t *testing.T) {
-	cache := newInMemoryLogbookCache()
-
-	lb := types.Logbook{
-		ID:       1,
-		Callsign: "W1AW",
-		UserID:   100,
-	}
-
-	// Set a logbook
-	cache.Set(1, lb, 5*time.Minute)
-
-	// Get it back
-	retrieved, ok := cache.Get(1)
-	if !ok {
-		t.Fatal("expected cache hit")
-	}
-	if retrieved.ID != lb.ID {
-		t.Errorf("expected ID=%d, got %d", lb.ID, retrieved.ID)
-	}
-	if retrieved.Callsign != lb.Callsign {
-		t.Errorf("expected Callsign=%s, got %s", lb.Callsign, retrieved.Callsign)
-	}
-}
-
-func TestInMemoryLogbookCache_GetNonExistent(t *testing.T) {
-	cache := newInMemoryLogbookCache()
-
-	_, ok := cache.Get(999)
-	if ok {
-		t.Error("expected cache miss for non-existent key")
-	}
-}
-
-func TestInMemoryLogbookCache_GetExpired(t *testing.T) {
-	cache := newInMemoryLogbookCache()
-
-	lb := types.Logbook{ID: 1, Callsign: "W1AW"}
-	cache.Set(1, lb, 1*time.Millisecond)
-
-	// Wait for expiration
-	time.Sleep(10 * time.Millisecond)
-
-	_, ok := cache.Get(1)
-	if ok {
-		t.Error("expected cache miss for expired entry")
-	}
-
-	// Verify entry was removed
-	if _, exists := cache.entries[1]; exists {
-		t.Error("expected expired entry to be removed from cache")
-	}
-}
-
-func TestInMemoryLogbookCache_UpdateExisting(t *testing.T) {
-	cache := newInMemoryLogbookCache()
-
-	lb1 := types.Logbook{ID: 1, Callsign: "W1AW"}
-	cache.Set(1, lb1, 5*time.Minute)
-
-	lb2 := types.Logbook{ID: 1, Callsign: "W2XYZ"}
-	cache.Set(1, lb2, 5*time.Minute)
-
-	retrieved, ok := cache.Get(1)
-	if !ok {
-		t.Fatal("expected cache hit")
-	}
-	if retrieved.Callsign != "W2XYZ" {
-		t.Errorf("expected updated Callsign=W2XYZ, got %s", retrieved.Callsign)
-	}
-
-	// Verify only one entry exists
-	if len(cache.entries) != 1 {
-		t.Errorf("expected 1 entry, got %d", len(cache.entries))
-	}
-}
-
-func TestInMemoryLogbookCache_LRUEviction(t *testing.T) {
-	cache := &inMemoryLogbookCache{
-		entries:    make(map[int64]*logbookCacheEntry),
-		maxEntries: 3,
-	}
-
-	// Fill cache to capacity
-	cache.Set(1, types.Logbook{ID: 1, Callsign: "W1AW"}, 5*time.Minute)
-	cache.Set(2, types.Logbook{ID: 2, Callsign: "W2AW"}, 5*time.Minute)
-	cache.Set(3, types.Logbook{ID: 3, Callsign: "W3AW"}, 5*time.Minute)
-
-	if len(cache.entries) != 3 {
-		t.Fatalf("expected 3 entries, got %d", len(cache.entries))
-	}
-
-	// Access entry 1 to make it recently used
-	_, ok := cache.Get(1)
-	if !ok {
-		t.Fatal("expected cache hit for entry 1")
-	}
-
-	// Add a 4th entry, should evict entry 2 (oldest unused)
-	cache.Set(4, types.Logbook{ID: 4, Callsign: "W4AW"}, 5*time.Minute)
-
-	if len(cache.entries) != 3 {
-		t.Errorf("expected 3 entries after eviction, got %d", len(cache.entries))
-	}
-
-	// Entry 2 should be evicted
-	_, ok = cache.Get(2)
-	if ok {
-		t.Error("expected entry 2 to be evicted")
-	}
-
-	// Entries 1, 3, 4 should still exist
-	for _, id := range []int64{1, 3, 4} {
-		if _, ok := cache.Get(id); !ok {
-			t.Errorf("expected entry %d to still exist", id)
-		}
-	}
-}
-
-func TestInMemoryLogbookCache_LRUOrderAfterAccess(t *testing.T) {
-	cache := &inMemoryLogbookCache{
-		entries:    make(map[int64]*logbookCacheEntry),
-		maxEntries: 3,
-	}
-
-	// Add 3 entries
-	cache.Set(1, types.Logbook{ID: 1, Callsign: "W1AW"}, 5*time.Minute)
-	cache.Set(2, types.Logbook{ID: 2, Callsign: "W2AW"}, 5*time.Minute)
-	cache.Set(3, types.Logbook{ID: 3, Callsign: "W3AW"}, 5*time.Minute)
-
-	// Access entries in order: 1, 2, 3
-	cache.Get(1)
-	cache.Get(2)
-	cache.Get(3)
-
-	// Now 3 is most recent, 1 is least recent
-	// Adding entry 4 should evict entry 1
-	cache.Set(4, types.Logbook{ID: 4, Callsign: "W4AW"}, 5*time.Minute)
-
-	_, ok := cache.Get(1)
-	if ok {
-		t.Error("expected entry 1 (LRU) to be evicted")
-	}
-
-	for _, id := range []int64{2, 3, 4} {
-		if _, ok := cache.Get(id); !ok {
-			t.Errorf("expected entry %d to still exist", id)
-		}
-	}
-}
-
-func TestInMemoryLogbookCache_Invalidate(t *testing.T) {
-	cache := newInMemoryLogbookCache()
-
-	lb := types.Logbook{ID: 1, Callsign: "W1AW"}
-	cache.Set(1, lb, 5*time.Minute)
-
-	// Verify it exists
-	_, ok := cache.Get(1)
-	if !ok {
-		t.Fatal("expected cache hit before invalidation")
-	}
-
-	// Invalidate
-	cache.Invalidate(1)
-
-	// Verify it's gone
-	_, ok = cache.Get(1)
-	if ok {
-		t.Error("expected cache miss after invalidation")
-	}
-
-	if _, exists := cache.entries[1]; exists {
-		t.Error("expected entry to be removed from map")
-	}
-}
-
-func TestInMemoryLogbookCache_InvalidateNonExistent(t *testing.T) {
-	cache := newInMemoryLogbookCache()
-
-	// Should not panic
-	cache.Invalidate(999)
-}
-
-func TestInMemoryLogbookCache_SetWithDefaultTTL(t *testing.T) {
-	cache := newInMemoryLogbookCache()
-
-	lb := types.Logbook{ID: 1, Callsign: "W1AW"}
-	cache.Set(1, lb, 0) // 0 or negative should use default
-
-	entry, ok := cache.entries[1]
-	if !ok {
-		t.Fatal("expected entry to exist")
-	}
-
-	expectedExpiry := time.Now().Add(defaultLogbookCacheTTL)
-	diff := entry.expiresAt.Sub(expectedExpiry).Abs()
-	if diff > time.Second {
-		t.Errorf("expected expiry close to default TTL, diff=%v", diff)
-	}
-}
-
-func TestInMemoryLogbookCache_NilReceiver(t *testing.T) {
-	var cache *inMemoryLogbookCache
-
-	// All methods should handle nil receiver gracefully
-	_, ok := cache.Get(1)
-	if ok {
-		t.Error("expected false from Get on nil cache")
-	}
-
-	cache.Set(1, types.Logbook{}, 5*time.Minute) // Should not panic
-	cache.Invalidate(1)                          // Should not panic
-}
-
-func TestInMemoryLogbookCache_LRUListIntegrity(t *testing.T) {
-	cache := &inMemoryLogbookCache{
-		entries:    make(map[int64]*logbookCacheEntry),
-		maxEntries: 5,
-	}
+func TestApiKeyNegativeCache(t *testing.T) {
+	svc := &Service{}
+	svc.initializeCaches()
 
-	// Add several entries
-	for i := int64(1); i <= 5; i++ {
-		cache.Set(i, types.Logbook{ID: i, Callsign: "TEST"}, 5*time.Minute)
-	}
+	const prefix = "ABCDEFGHIJ"
+	const fullKey = prefix + ".secret"
 
-	// Verify list integrity: head should be most recent (5), tail should be oldest (1)
-	if cache.head == nil || cache.head.key != 5 {
-		t.Errorf("expected head.key=5, got %v", cache.head)
-	}
-	if cache.tail == nil || cache.tail.key != 1 {
-		t.Errorf("expected tail.key=1, got %v", cache.tail)
+	if svc.isRejectedApiKey(prefix, fullKey) {
+		t.Fatal("expected key not to be rejected before any failure")
 	}
 
-	// Walk from head to tail
-	node := cache.head
-	count := 0
-	for node != nil {
-		count++
-		node = node.next
+	svc.rejectApiKey(fullKey)
+	if !svc.isRejectedApiKey(prefix, fullKey) {
+		t.Error("expected rejected key to be remembered")
 	}
-	if count != 5 {
-		t.Errorf("expected 5 nodes walking head->tail, got %d", count)
+	if svc.isRejectedApiKey(prefix, prefix+".other") {
+		t.Error("expected a different key with the same prefix not to be rejected")
 	}
 
-	// Walk from tail to head
-	node = cache.tail
-	count = 0
-	for node != nil {
-		count++
-		node = node.prev
-	}
-	if count != 5 {
-		t.Errorf("expected 5 nodes walking tail->head, got %d", count)
+	svc.rejectApiKeyPrefix("KLMNOPQRST")
+	if !svc.isRejectedApiKey("KLMNOPQRST", "KLMNOPQRST.anything") {
+		t.Error("expected any key with a rejected prefix to be rejected")
 	}
 }
 
-func TestInMemoryLogbookCache_AccessPromotesToFront(t *testing.T) {
-	cache := &inMemoryLogbookCache{
-		entries:    make(map[int64]*logbookCacheEntry),
-		maxEntries: 5,
-	}
-
-	// Add 3 entries
-	cache.Set(1, types.Logbook{ID: 1}, 5*time.Minute)
-	cache.Set(2, types.Logbook{ID: 2}, 5*time.Minute)
-	cache.Set(3, types.Logbook{ID: 3}, 5*time.Minute)
-
-	// Head should be 3 (most recent set)
-	if cache.head.key != 3 {
-		t.Errorf("expected head.key=3, got %d", cache.head.key)
-	}
+func TestApiKeyNegativeCache_DoesNotStoreSecret(t *testing.T) {
+	svc := &Service{}
+	svc.initializeCaches()
 
-	// Access entry 1 (currently at tail)
-	cache.Get(1)
+	const fullKey = "ABCDEFGHIJ.secret"
+	svc.rejectApiKey(fullKey)
 
-	// Now head should be 1
-	if cache.head.key != 1 {
-		t.Errorf("expected head.key=1 after access, got %d", cache.head.key)
+	if _, ok := svc.apiKeyNegativeCache.Get(negativeCacheKeyTag + fullKey); ok {
+		t.Error("expected the raw key not to be used as a cache key")
 	}
 }
 
-func TestInMemoryLogbookCache_RemoveMiddleNode(t *testing.T) {
-	cache := &inMemoryLogbookCache{
-		entries:    make(map[int64]*logbookCacheEntry),
-		maxEntries: 5,
-	}
-
-	// Add 3 entries
-	cache.Set(1, types.Logbook{ID: 1}, 5*time.Minute)
-	cache.Set(2, types.Logbook{ID: 2}, 5*time.Minute)
-	cache.Set(3, types.Logbook{ID: 3}, 5*time.Minute)
-
-	// Remove middle entry
-	cache.Invalidate(2)
-
-	// Verify list integrity
-	if cache.head.key != 3 {
-		t.Errorf("expected head.key=3, got %d", cache.head.key)
-	}
-	if cache.tail.key != 1 {
-		t.Errorf("expected tail.key=1, got %d", cache.tail.key)
+func TestIsApiKeyNotFound(t *testing.T) {
+	notFound := errors.New("test").Err(errors.New("db").Errorf("prefix not found: %s", "ABCDEFGHIJ"))
+	if !isApiKeyNotFound(notFound) {
+		t.Error("expected prefix not found error to be detected")
 	}
-
-	// Walk the list
-	node := cache.head
-	count := 0
-	for node != nil {
-		count++
-		if node.key == 2 {
-			t.Error("entry 2 should not be in list")
-		}
-		node = node.next
+	if isApiKeyNotFound(errors.New("test").Err(fmt.Errorf("connection refused"))) {
+		t.Error("expected other errors not to be treated as not found")
 	}
-	if count != 2 {
-		t.Errorf("expected 2 nodes after removal, got %d", count)
+	if isApiKeyNotFound(context.DeadlineExceeded) {
+		t.Error("expected deadline errors not to be treated as not found")
 	}
 }
 
-func TestInMemoryLogbookCache_RemoveHeadNode(t *testing.T) {
-	cache := &inMemoryLogbookCache{
-		entries:    make(map[int64]*logbookCacheEntry),
-		maxEntries: 5,
-	}
-
-	cache.Set(1, types.Logbook{ID: 1}, 5*time.Minute)
-	cache.Set(2, types.Logbook{ID: 2}, 5*time.Minute)
+func TestInvalidateUser(t *testing.T) {
+	svc := &Service{}
+	svc.initializeCaches()
 
-	// Remove head
-	cache.Invalidate(2)
+	svc.userCache.Set("W1AW", types.User{ID: 1, Callsign: "W1AW", PassHash: "old"}, defaultUserCacheTTL)
 
-	if cache.head.key != 1 {
-		t.Errorf("expected head.key=1, got %d", cache.head.key)
-	}
-	if cache.tail.key != 1 {
-		t.Errorf("expected tail.key=1, got %d", cache.tail.key)
+	svc.invalidateUser(context.Background(), "W1AW")
+	if _, ok := svc.userCache.Get("W1AW"); ok {
+		t.Error("expected user to be removed from the cache")
 	}
 }
 
-func TestInMemoryLogbookCache_RemoveTailNode(t *testing.T) {
-	cache := &inMemoryLogbookCache{
-		entries:    make(map[int64]*logbookCacheEntry),
-		maxEntries: 5,
-	}
-
-	cache.Set(1, types.Logbook{ID: 1}, 5*time.Minute)
-	cache.Set(2, types.Logbook{ID: 2}, 5*time.Minute)
+func TestUpdateUser_InvalidatesCache(t *testing.T) {
+	dbSvc := newTestDatabaseService(t)
+	defer func() { _ = dbSvc.Close() }()
 
-	// Remove tail
-	cache.Invalidate(1)
+	svc := &Service{db: dbSvc, logger: dbSvc.Logger}
+	svc.initializeCaches()
+	svc.userCache.Set("W1AW", types.User{ID: 1, Callsign: "W1AW", PassHash: "old"}, defaultUserCacheTTL)
 
-	if cache.head.key != 2 {
-		t.Errorf("expected head.key=2, got %d", cache.head.key)
-	}
-	if cache.tail.key != 2 {
-		t.Errorf("expected tail.key=2, got %d", cache.tail.key)
-	}
-}
-
-func TestInMemoryLogbookCache_RemoveOnlyNode(t *testing.T) {
-	cache := &inMemoryLogbookCache{
-		entries:    make(map[int64]*logbookCacheEntry),
-		maxEntries: 5,
-	}
+	// The sqlite schema has no users table, so the update itself fails; the cached
+	// copy must still be dropped because the stored state is no longer known.
+	_ = svc.updateUser(context.Background(), types.User{ID: 1, Callsign: "W1AW", PassHash: "new"})
 
-	cache.Set(1, types.Logbook{ID: 1}, 5*time.Minute)
-	cache.Invalidate(1)
-
-	if cache.head != nil {
-		t.Error("expected nil head after removing only node")
-	}
-	if cache.tail != nil {
-		t.Error("expected nil tail after removing only node")
-	}
-	if len(cache.entries) != 0 {
-		t.Errorf("expected 0 entries, got %d", len(cache.entries))
+	if _, ok := svc.userCache.Get("W1AW"); ok {
+		t.Error("expected user to be removed from the cache after an update")
 	}
 }
 
-func TestInMemoryLogbookCache_ConcurrentAccess(t *testing.T) {
-	cache := newInMemoryLogbookCache()
-
-	// Add some initial data
-	for i := int64(1); i <= 10; i++ {
-		cache.Set(i, types.Logbook{ID: i, Callsign: "TEST"}, 5*time.Minute)
-	}
-
-	// Spawn multiple goroutines doing reads and writes
-	done := make(chan bool)
-	for g := 0; g < 10; g++ {
-		go func(id int64) {
-			for i := 0; i < 100; i++ {
-				cache.Set(id, types.Logbook{ID: id}, 5*time.Minute)
-				cache.Get(id)
-				if i%10 == 0 {
-					cache.Invalidate(id)
-				}
-			}
-			done <- true
-		}(int64(g + 1))
-	}
+func TestSweepCaches_RemovesExpiredEntries(t *testing.T) {
+	dbSvc := newTestDatabaseService(t)
+	defer func() { _ = dbSvc.Close() }()
 
-	// Wait for all goroutines
-	for g := 0; g < 10; g++ {
-		<-done
-	}
-}
+	svc := &Service{db: dbSvc, logger: dbSvc.Logger}
+	svc.initializeCaches()
 
-func TestInMemoryLogbookCache_SetWithNegativeTTL(t *testing.T) {
-	cache := newInMemoryLogbookCache()
+	svc.logbookCache.Set(1, types.Logbook{ID: 1}, time.Millisecond)
+	svc.userCache.Set("W1AW", types.User{ID: 1}, time.Millisecond)
+	time.Sleep(10 * time.Millisecond)
 
-	lb := types.Logbook{ID: 1, Callsign: "W1AW"}
-	cache.Set(1, lb, -5*time.Minute) // Negative should use default
+	svc.sweepCaches()
 
-	entry, ok := cache.entries[1]
-	if !ok {
-		t.Fatal("expected entry to exist")
+	if got := svc.logbookCache.Stats().Entries; got != 0 {
+		t.Errorf("expected logbook cache to be empty, got %d entries", got)
 	}
-
-	expectedExpiry := time.Now().Add(defaultLogbookCacheTTL)
-	diff := entry.expiresAt.Sub(expectedExpiry).Abs()
-	if diff > time.Second {
-		t.Errorf("expected expiry close to default TTL, diff=%v", diff)
+	if got := svc.userCache.Stats().Expirations; got != 1 {
+		t.Errorf("expected 1 user cache expiration, got %d", got)
 	}
 }
 
-func TestInMemoryLogbookCache_AccessAlreadyAtFront(t *testing.T) {
-	cache := &inMemoryLogbookCache{
-		entries:    make(map[int64]*logbookCacheEntry),
-		maxEntries: 5,
-	}
+func TestBackgroundTasks_StopOnShutdown(t *testing.T) {
+	dbSvc := newTestDatabaseService(t)
+	defer func() { _ = dbSvc.Close() }()
 
-	cache.Set(1, types.Logbook{ID: 1}, 5*time.Minute)
-	cache.Set(2, types.Logbook{ID: 2}, 5*time.Minute)
+	svc := &Service{db: dbSvc, logger: dbSvc.Logger}
+	svc.initializeCaches()
 
-	// 2 is already at front (head)
-	if cache.head.key != 2 {
-		t.Fatalf("expected head.key=2, got %d", cache.head.key)
-	}
+	svc.startBackgroundTasks()
 
-	// Access it again - should still be at front
-	cache.Get(2)
-
-	if cache.head.key != 2 {
-		t.Errorf("expected head.key=2 after accessing front node, got %d", cache.head.key)
-	}
+	done := make(chan struct{})
+	go func() {
+		svc.stopBackgroundTasks(context.Background())
+		close(done)
+	}()
 
-	// Verify list integrity
-	if cache.tail.key != 1 {
-		t.Errorf("expected tail.key=1, got %d", cache.tail.key)
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("background tasks did not stop after cancellation")
 	}
 }
 
-func TestInMemoryLogbookCache_EvictWhenEmpty(t *testing.T) {
-	cache := &inMemoryLogbookCache{
-		entries:    make(map[int64]*logbookCacheEntry),
-		maxEntries: 0, // No limit
-	}
-
-	// Should not panic or evict when maxEntries is 0
-	for i := int64(1); i <= 10; i++ {
-		cache.Set(i, types.Logbook{ID: i}, 5*time.Minute)
-	}
+func TestFetchLogbookWithCache_ServesStaleWhileRefreshing(t *testing.T) {
+	svc := newTestServer(t)
+	svc.logbookCacheStale = time.Hour
+	svc.logbookCache.SetStaleWindow(svc.logbookCacheStale)
+	ctx := context.Background()
 
-	if len(cache.entries) != 10 {
-		t.Errorf("expected 10 entries, got %d", len(cache.entries))
+	stored, err := svc.db.FetchLogbookByIDContext(ctx, 1)
+	if err != nil {
+		t.Fatalf("FetchLogbookByIDContext failed: %v", err)
 	}
-}
+	stale := stored
+	stale.Name = "stale copy"
+	svc.logbookCache.Set(1, stale, time.Millisecond)
+	time.Sleep(10 * time.Millisecond)
 
-func TestInMemoryLogbookCache_MultipleEvictions(t *testing.T) {
-	cache := &inMemoryLogbookCache{
-		entries:    make(map[int64]*logbookCacheEntry),
-		maxEntries: 2,
+	logbook, err := svc.fetchLogbookWithCache(ctx, 1)
+	if err != nil || logbook.Name != stale.Name {
+		t.Fatalf("expected the stale logbook to be served, got %+v (err=%v)", logbook, err)
 	}
-
-	// Fill and overflow multiple times
-	for i := int64(1); i <= 10; i++ {
-		cache.Set(i, types.Logbook{ID: i, Callsign: "TEST"}, 5*time.Minute)
-
-		// Should never exceed maxEntries
-		if len(cache.entries) > cache.maxEntries {
-			t.Errorf("cache size %d exceeds maxEntries %d", len(cache.entries), cache.maxEntries)
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		if cached, ok := svc.logbookCache.Get(1); ok && cached.Name == stored.Name {
+			break
 		}
-	}
-
-	// Should only have last 2 entries
-	if len(cache.entries) != 2 {
-		t.Errorf("expected 2 entries, got %d", len(cache.entries))
-	}
-
-	// Should have entries 9 and 10
-	for _, id := range []int64{9, 10} {
-		if _, ok := cache.Get(id); !ok {
-			t.Errorf("expected entry %d to exist", id)
+		if time.Now().After(deadline) {
+			t.Fatal("expected the logbook to be refreshed in the background")
 		}
+		time.Sleep(10 * time.Millisecond)
 	}
 }
 
-func TestInMemoryLogbookCache_UpdateExistingPromotesToFront(t *testing.T) {
-	cache := &inMemoryLogbookCache{
-		entries:    make(map[int64]*logbookCacheEntry),
-		maxEntries: 5,
-	}
-
-	cache.Set(1, types.Logbook{ID: 1, Callsign: "OLD"}, 5*time.Minute)
-	cache.Set(2, types.Logbook{ID: 2, Callsign: "TEST"}, 5*time.Minute)
-	cache.Set(3, types.Logbook{ID: 3, Callsign: "TEST"}, 5*time.Minute)
-
-	// Update entry 1 (currently at tail)
-	cache.Set(1, types.Logbook{ID: 1, Callsign: "NEW"}, 5*time.Minute)
-
-	// Should now be at front
-	if cache.head.key != 1 {
-		t.Errorf("expected head.key=1 after update, got %d", cache.head.key)
-	}
-
-	// Verify value was updated
-	lb, ok := cache.Get(1)
-	if !ok {
-		t.Fatal("expected to find entry 1")
-	}
-	if lb.Callsign != "NEW" {
-		t.Errorf("expected Callsign=NEW, got %s", lb.Callsign)
-	}
-}
-
-func TestInMemoryLogbookCache_SetWithNilEntries(t *testing.T) {
-	cache := &inMemoryLogbookCache{
-		entries:    nil, // nil map
-		maxEntries: 5,
-	}
-
-	// Should initialize the map and not panic
-	cache.Set(1, types.Logbook{ID: 1}, 5*time.Minute)
-
-	if cache.entries == nil {
-		t.Fatal("expected entries to be initialized")
-	}
-
-	if len(cache.entries) != 1 {
-		t.Errorf("expected 1 entry, got %d", len(cache.entries))
+func TestLoadCacheTTLJitter(t *testing.T) {
+	if jitter, err := loadCacheTTLJitter(); err != nil || jitter != defaultCacheTTLJitter {
+		t.Fatalf("expected the default jitter, got %v %v", jitter, err)
 	}
-}
-
-func TestInMemoryLogbookCache_HelperMethodsWithNil(t *testing.T) {
-	cache := &inMemoryLogbookCache{
-		entries:    make(map[int64]*logbookCacheEntry),
-		maxEntries: 5,
+	t.Setenv(envCacheTTLJitter, "0")
+	if jitter, err := loadCacheTTLJitter(); err != nil || jitter != 0 {
+		t.Errorf("expected jitter to be disabled, got %v %v", jitter, err)
 	}
-
-	// Test helper methods with nil parameters - should not panic
-	cache.addToFrontLocked(nil)
-	cache.removeNodeLocked(nil)
-	cache.moveToFrontLocked(nil)
-
-	// Cache should remain empty
-	if len(cache.entries) != 0 {
-		t.Errorf("expected 0 entries, got %d", len(cache.entries))
+	t.Setenv(envCacheTTLJitter, "1.5")
+	if _, err := loadCacheTTLJitter(); err == nil {
+		t.Error("expected a jitter above 1 to be rejected")
 	}
 }
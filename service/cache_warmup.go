@@ -0,0 +1,131 @@
+package service
+
+import (
+	"context"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Station-Manager/errors"
+)
+
+// envCacheWarmup names the environment variable holding how many of the logbooks with the most
+// recent API usage Start loads into the caches, with their live API keys, before it accepts
+// traffic, so that the first burst after a deploy does not find the caches cold. Unset or zero
+// starts cold.
+const envCacheWarmup = "SM_CACHE_WARMUP"
+
+const (
+	// cacheWarmupTimeout bounds the warmup, so that a slow database delays startup by no more.
+	cacheWarmupTimeout = 30 * time.Second
+	// cacheWarmupUsageDays is how many days of API usage the warmup looks back over.
+	cacheWarmupUsageDays = 7
+)
+
+// activeLogbook is a logbook to warm the caches with, and the prefixes of its live API keys.
+type activeLogbook struct {
+	id       int64
+	prefixes []string
+}
+
+// loadCacheWarmup reads the number of logbooks to warm the caches with from the environment. It is
+// capped at the capacity of the logbook cache; warmCaches also stops at each shard's share of it.
+func loadCacheWarmup() (int, error) {
+	const op errors.Op = "server.loadCacheWarmup"
+
+	value := strings.TrimSpace(os.Getenv(envCacheWarmup))
+	if value == emptyString {
+		return 0, nil
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil || n < 0 {
+		return 0, errors.New(op).Msg(envCacheWarmup + " must be a non-negative number")
+	}
+	return min(n, defaultLogbookCacheMaxEntries), nil
+}
+
+// warmCaches loads the logbooks with the most recent API usage, and their keys, into the caches,
+// most active first. An entry whose cache shard is already full is skipped rather than evicting an
+// entry warmed before it. Warming is best effort: a failure is logged and the caches fill on demand
+// instead.
+func (s *Service) warmCaches() {
+	const op errors.Op = "server.Service.warmCaches"
+	if s.cacheWarmup <= 0 {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), cacheWarmupTimeout)
+	defer cancel()
+	start := time.Now()
+
+	active, err := s.recentlyActiveLogbooks(ctx, s.cacheWarmup, start)
+	if err != nil {
+		s.logger.WarnWith().Err(errors.New(op).Err(err)).Msg("s.recentlyActiveLogbooks failed; starting with cold caches")
+		return
+	}
+
+	logbooks, keys, skipped := 0, 0, 0
+	for _, lb := range active {
+		if !s.logbookCache.HasRoom(lb.id) {
+			skipped++
+			continue
+		}
+		if _, err = s.fetchLogbookWithCache(ctx, lb.id); err != nil {
+			s.logger.WarnWith().Err(errors.New(op).Err(err)).Int64("logbook_id", lb.id).Msg("s.fetchLogbookWithCache failed")
+			continue
+		}
+		logbooks++
+		for _, prefix := range lb.prefixes {
+			if !s.apiKeyCache.HasRoom(prefix) || !s.apiKeyMemberCache.HasRoom(prefix) {
+				skipped++
+				continue
+			}
+			if _, err = s.fetchApiKeyWithCache(ctx, prefix); err != nil {
+				continue
+			}
+			if _, err = s.fetchApiKeyPrefixMemberWithCache(ctx, prefix); err != nil {
+				continue
+			}
+			keys++
+		}
+	}
+	s.logger.InfoWith().Int("logbooks", logbooks).Int("api_keys", keys).Int("skipped", skipped).Dur("elapsed", time.Since(start)).Msg("Caches warmed")
+}
+
+// recentlyActiveLogbooks returns up to limit logbooks with API usage in the last
+// cacheWarmupUsageDays days, with the prefixes of their live API keys, the latest used and then
+// busiest first. The usage is read from the api_usage table, which flushUsage keeps.
+func (s *Service) recentlyActiveLogbooks(ctx context.Context, limit int, now time.Time) ([]activeLogbook, error) {
+	const op errors.Op = "server.Service.recentlyActiveLogbooks"
+
+	since := now.UTC().AddDate(0, 0, -cacheWarmupUsageDays).Format(usageDayLayout)
+	rows, err := s.db.QueryContext(ctx, `SELECT k.logbook_id, k.key_prefix FROM api_keys k
+		JOIN (SELECT logbook_id, MAX(day) AS last_day, SUM(requests) AS requests FROM api_usage
+			WHERE day >= $2 GROUP BY logbook_id ORDER BY MAX(day) DESC, SUM(requests) DESC LIMIT $1) u ON u.logbook_id = k.logbook_id
+		WHERE k.revoked_at IS NULL
+		ORDER BY u.last_day DESC, u.requests DESC, k.logbook_id, k.key_prefix`, limit, since)
+	if err != nil {
+		return nil, errors.New(op).Err(err)
+	}
+	defer func() { _ = rows.Close() }()
+
+	var active []activeLogbook
+	for rows.Next() {
+		var (
+			logbookID int64
+			prefix    string
+		)
+		if err = rows.Scan(&logbookID, &prefix); err != nil {
+			return nil, errors.New(op).Err(err)
+		}
+		if n := len(active); n == 0 || active[n-1].id != logbookID {
+			active = append(active, activeLogbook{id: logbookID})
+		}
+		active[len(active)-1].prefixes = append(active[len(active)-1].prefixes, prefix)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, errors.New(op).Err(err)
+	}
+	return active, nil
+}
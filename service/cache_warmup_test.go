@@ -0,0 +1,104 @@
+package service
+
+import (
+	"context"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/Station-Manager/server/service/cache"
+	"github.com/Station-Manager/types"
+)
+
+func TestWarmCaches(t *testing.T) {
+	svc := newTestServer(t)
+	svc.initializeCaches()
+	ctx := context.Background()
+	for _, query := range []string{
+		`INSERT INTO users (id, callsign, pass_hash) VALUES (8, 'K1AB', 'hash')`,
+		`UPDATE logbook SET user_id = 8 WHERE id = 1`,
+	} {
+		if _, err := svc.db.ExecContext(ctx, query); err != nil {
+			t.Fatalf("setup failed: %v", err)
+		}
+	}
+	key, err := svc.issueMemberApiKey(ctx, 1, 8, "K1AB")
+	if err != nil {
+		t.Fatalf("issueMemberApiKey failed: %v", err)
+	}
+	prefix := key[:prefixLen]
+	svc.usage = newUsageTracker()
+	svc.usage.record(1, apiKeyActorPrefix+prefix, usageCounts{Requests: 1})
+	if err = svc.flushUsage(ctx); err != nil {
+		t.Fatalf("flushUsage failed: %v", err)
+	}
+	svc.initializeCaches()
+
+	svc.warmCaches()
+	if _, ok := svc.logbookCache.Get(1); ok {
+		t.Fatal("expected no warmup unless configured")
+	}
+
+	t.Setenv(envCacheWarmup, "10")
+	if svc.cacheWarmup, err = loadCacheWarmup(); err != nil || svc.cacheWarmup != 10 {
+		t.Fatalf("expected a warmup of 10 logbooks, got %d %v", svc.cacheWarmup, err)
+	}
+	svc.warmCaches()
+	if _, ok := svc.logbookCache.Get(1); !ok {
+		t.Error("expected the logbook to be cached")
+	}
+	if _, ok := svc.apiKeyCache.Get(prefix); !ok {
+		t.Error("expected the API key to be cached")
+	}
+	if member, ok := svc.apiKeyMemberCache.Get(prefix); !ok || member.Role != logbookRoleOwner {
+		t.Errorf("expected the key's member to be cached, got %+v", member)
+	}
+
+	t.Setenv(envCacheWarmup, "-1")
+	if _, err = loadCacheWarmup(); err == nil {
+		t.Error("expected a negative warmup to be rejected")
+	}
+}
+
+func TestWarmCaches_StopsAtFullShards(t *testing.T) {
+	svc := newTestServer(t)
+	svc.initializeCaches()
+	ctx := context.Background()
+	today := time.Now().UTC().Format(usageDayLayout)
+	for id := int64(2); id <= 4; id++ {
+		if _, err := svc.db.ExecContext(ctx, `INSERT INTO logbook (id, name, callsign) VALUES ($1, $2, 'W1AW')`, id, "Logbook "+strconv.FormatInt(id, 10)); err != nil {
+			t.Fatalf("setup failed: %v", err)
+		}
+	}
+	for id := int64(1); id <= 4; id++ {
+		prefix := "key" + strconv.FormatInt(id, 10)
+		if _, err := svc.db.ExecContext(ctx, `INSERT INTO api_keys (logbook_id, key_name, key_prefix, key_hash) VALUES ($1, $2, $2, 'hash')`, id, prefix); err != nil {
+			t.Fatalf("setup failed: %v", err)
+		}
+		if _, err := svc.db.ExecContext(ctx, `INSERT INTO api_usage (logbook_id, key_prefix, day, requests) VALUES ($1, $2, $3, $4)`, id, prefix, today, 10*id); err != nil {
+			t.Fatalf("setup failed: %v", err)
+		}
+	}
+	// Usage from before the warmup's window does not count.
+	if _, err := svc.db.ExecContext(ctx, `UPDATE api_usage SET day = '2000-01-01' WHERE logbook_id = 1`); err != nil {
+		t.Fatalf("setup failed: %v", err)
+	}
+
+	active, err := svc.recentlyActiveLogbooks(ctx, 10, time.Now())
+	if err != nil || len(active) != 3 || active[0].id != 4 || active[2].id != 2 || len(active[0].prefixes) != 1 {
+		t.Fatalf("expected logbooks 4, 3 and 2 by usage, got %+v (%v)", active, err)
+	}
+
+	// A logbook cache of one shard holding two entries takes the two busiest logbooks only.
+	svc.logbookCache = cache.New[int64, types.Logbook](2, defaultLogbookCacheTTL)
+	svc.cacheWarmup = 10
+	svc.warmCaches()
+	for id, want := range map[int64]bool{4: true, 3: true, 2: false} {
+		if _, ok := svc.logbookCache.Get(id); ok != want {
+			t.Errorf("logbook %d: expected cached=%v", id, want)
+		}
+	}
+	if stats := svc.logbookCache.Stats(); stats.Evictions != 0 {
+		t.Errorf("expected the warmup not to evict, got %+v", stats)
+	}
+}
@@ -0,0 +1,73 @@
+package service
+
+import (
+	"regexp"
+	"strings"
+
+	"github.com/Station-Manager/types"
+	"github.com/gofiber/fiber/v2"
+)
+
+// callsignPattern matches a callsign: a base call of a prefix, the digit that ends it and a suffix
+// ending in a letter, optionally behind the prefix of the country operated from (DL/K1AB) and
+// followed by a portable or other designator (K1AB/P, K1AB/VE3).
+var callsignPattern = regexp.MustCompile(`^(?:([A-Z0-9]{1,4})/)?([A-Z0-9]{1,3}[0-9][A-Z0-9]{0,3}[A-Z])(?:/[A-Z0-9]{1,4})?$`)
+
+// unallocatedPrefixes are the leading characters of callsign series the ITU allocates to no
+// country: Q is kept for the Q code, and no series begins with 0 or 1.
+var unallocatedPrefixes = []string{"0", "1", "Q"}
+
+// unofficialPrefixes are series outside the ITU's allocations that DXCC entities nonetheless use.
+var unofficialPrefixes = []string{"1A", "1S"}
+
+// callsignError reports a callsign field of a QSO that holds no callsign.
+type callsignError struct {
+	Field string
+}
+
+func (e *callsignError) Error() string {
+	return e.Field + " is not a callsign"
+}
+
+// validCallsign reports whether the text is a callsign, in upper or lower case, whose prefix is in
+// a series allocated for amateur use.
+func validCallsign(call string) bool {
+	match := callsignPattern.FindStringSubmatch(strings.ToUpper(call))
+	if match == nil {
+		return false
+	}
+	prefix := match[1]
+	if prefix == emptyString {
+		prefix = match[2]
+	}
+	for _, unofficial := range unofficialPrefixes {
+		if strings.HasPrefix(prefix, unofficial) {
+			return true
+		}
+	}
+	for _, unallocated := range unallocatedPrefixes {
+		if strings.HasPrefix(prefix, unallocated) {
+			return false
+		}
+	}
+	return true
+}
+
+// checkQsoCallsigns fails with a *callsignError if the QSO's call or station_callsign holds no
+// callsign.
+func checkQsoCallsigns(qso types.Qso) error {
+	if !validCallsign(qso.StationCallsign) {
+		return &callsignError{Field: "station_callsign"}
+	}
+	if !validCallsign(qso.Call) {
+		return &callsignError{Field: "call"}
+	}
+	return nil
+}
+
+// invalidCallsign returns the body of the response to a request whose field holds no callsign.
+func invalidCallsign(field string) fiber.Map {
+	body := jsonError(errCodeInvalidCallsign, "The "+field+" is not a callsign")
+	body["field"] = field
+	return body
+}
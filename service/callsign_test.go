@@ -0,0 +1,45 @@
+package service
+
+import (
+	"context"
+	"testing"
+
+	"github.com/go-playground/validator/v10"
+)
+
+func TestValidCallsign(t *testing.T) {
+	for call, want := range map[string]bool{
+		"K1AB":        true,
+		"w1aw":        true,
+		"9A1AA":       true,
+		"3DA0RS":      true,
+		"GB13COL":     true,
+		"1A0KM":       true,
+		"DL/K1AB":     true,
+		"K1AB/P":      true,
+		"VE3/K1AB/MM": true,
+		"HELLO WORLD": false,
+		"HELLO":       false,
+		"TEST1":       false,
+		"Q1AB":        false,
+		"0A1AB":       false,
+		"K1AB/":       false,
+		"K1AB/P/M":    false,
+		"":            false,
+	} {
+		if got := validCallsign(call); got != want {
+			t.Errorf("validCallsign(%q) = %v, want %v", call, got, want)
+		}
+	}
+}
+
+func TestInsertQso_InvalidCallsign(t *testing.T) {
+	svc, logbook := newContestTestServer(t)
+	svc.validate = validator.New(validator.WithRequiredStructEnabled())
+
+	_, err := svc.insertQso(context.Background(), logbook, nil, "test", testContestQso("HELLO WORLD", "20m", "CW"), emptyString, emptyString, false)
+	status, body := svc.insertQsoFailure(err)
+	if status != 400 || body["code"] != errCodeInvalidCallsign || body["field"] != "call" {
+		t.Errorf("expected the call to be refused, got %d %v", status, body)
+	}
+}
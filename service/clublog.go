@@ -0,0 +1,463 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	stderr "errors"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Station-Manager/errors"
+	"github.com/Station-Manager/server/service/adif"
+)
+
+// envClubLogAPIKey names the environment variable holding the application API key issued by Club
+// Log. The integration is unavailable when it is not set.
+const envClubLogAPIKey = "SM_CLUBLOG_API_KEY"
+
+const (
+	defaultClubLogRealtimeURL    = "https://clublog.org/realtime.php"
+	defaultClubLogPutlogsURL     = "https://clublog.org/putlogs.php"
+	defaultClubLogRetryInterval  = 15 * time.Minute
+	defaultClubLogRequestTimeout = 30 * time.Second
+	// Full uploads can be large and Club Log processes them before answering.
+	defaultClubLogFullUploadTimeout = 10 * time.Minute
+	clubLogUploadQueueSize          = 1024
+	clubLogMaxResponseBytes         = 64 << 10
+
+	uploadServiceClubLog = "clublog"
+)
+
+// clubLogAccount holds a logbook's Club Log credentials. Password is encrypted.
+type clubLogAccount struct {
+	LogbookID    int64
+	Email        string
+	Password     string
+	Callsign     string // the Club Log log to upload to
+	LastUploadAt sql.NullTime
+	LastError    string
+}
+
+// clubLogClient uploads QSOs to Club Log, one at a time as they are logged or as a whole log.
+type clubLogClient struct {
+	apiKey        string
+	realtimeURL   string
+	putlogsURL    string
+	client        *http.Client
+	fullClient    *http.Client
+	retryInterval time.Duration
+	queue         chan qsoEvent
+
+	mu          sync.Mutex
+	fullUploads map[int64]bool // logbooks with a full upload in progress
+}
+
+func newClubLogClient() *clubLogClient {
+	return &clubLogClient{
+		apiKey:        strings.TrimSpace(os.Getenv(envClubLogAPIKey)),
+		realtimeURL:   defaultClubLogRealtimeURL,
+		putlogsURL:    defaultClubLogPutlogsURL,
+		client:        &http.Client{Timeout: defaultClubLogRequestTimeout},
+		fullClient:    &http.Client{Timeout: defaultClubLogFullUploadTimeout},
+		retryInterval: defaultClubLogRetryInterval,
+		queue:         make(chan qsoEvent, clubLogUploadQueueSize),
+		fullUploads:   make(map[int64]bool),
+	}
+}
+
+// enabled reports whether the server has a Club Log API key.
+func (c *clubLogClient) enabled() bool {
+	return c != nil && c.apiKey != emptyString
+}
+
+// uploadQso sends a single QSO through the real-time interface. Club Log answers 400 for QSOs it
+// refuses, which are reported as rejected so that they are not retried.
+func (c *clubLogClient) uploadQso(ctx context.Context, email, password, callsign string, record adif.Record) error {
+	const op errors.Op = "server.clubLogClient.uploadQso"
+
+	var doc bytes.Buffer
+	if err := adif.Encode(&doc, adifProgramID, []adif.Record{record}); err != nil {
+		return errors.New(op).Err(err)
+	}
+	form := url.Values{}
+	form.Set("email", email)
+	form.Set("password", password)
+	form.Set("callsign", callsign)
+	form.Set("api", c.apiKey)
+	form.Set("adif", doc.String())
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.realtimeURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return errors.New(op).Err(err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	status, body, err := c.do(c.client, req)
+	if err != nil {
+		return errors.New(op).Err(err)
+	}
+	switch status {
+	case http.StatusOK:
+		// "QSO OK" or "QSO Dupe".
+		return nil
+	case http.StatusBadRequest:
+		return errors.New(op).Err(&uploadRejectedError{msg: clubLogError(body)})
+	default:
+		return errors.New(op).Msg(clubLogError(body))
+	}
+}
+
+// uploadLog sends a whole ADIF log, optionally replacing everything Club Log holds for callsign.
+func (c *clubLogClient) uploadLog(ctx context.Context, email, password, callsign string, log []byte, replace bool) error {
+	const op errors.Op = "server.clubLogClient.uploadLog"
+
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	clearLog := "0"
+	if replace {
+		clearLog = "1"
+	}
+	for _, field := range [][2]string{{"email", email}, {"password", password}, {"callsign", callsign}, {"api", c.apiKey}, {"clear", clearLog}} {
+		if err := mw.WriteField(field[0], field[1]); err != nil {
+			return errors.New(op).Err(err)
+		}
+	}
+	fw, err := mw.CreateFormFile("file", strings.ToLower(callsign)+".adi")
+	if err != nil {
+		return errors.New(op).Err(err)
+	}
+	if _, err = fw.Write(log); err != nil {
+		return errors.New(op).Err(err)
+	}
+	if err = mw.Close(); err != nil {
+		return errors.New(op).Err(err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.putlogsURL, &body)
+	if err != nil {
+		return errors.New(op).Err(err)
+	}
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+
+	status, resp, err := c.do(c.fullClient, req)
+	if err != nil {
+		return errors.New(op).Err(err)
+	}
+	if status != http.StatusOK {
+		return errors.New(op).Msg(clubLogError(resp))
+	}
+	return nil
+}
+
+// do performs the request and returns the status and (truncated) body.
+func (c *clubLogClient) do(client *http.Client, req *http.Request) (int, []byte, error) {
+	const op errors.Op = "server.clubLogClient.do"
+
+	resp, err := client.Do(req)
+	if err != nil {
+		var urlErr *url.Error
+		if stderr.As(err, &urlErr) {
+			err = urlErr.Err
+		}
+		return 0, nil, errors.New(op).Err(err).Msg("Club Log request failed")
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, clubLogMaxResponseBytes))
+	if err != nil {
+		return 0, nil, errors.New(op).Err(err)
+	}
+	return resp.StatusCode, body, nil
+}
+
+// clubLogError turns a Club Log error response, which is plain text, into a message.
+func clubLogError(body []byte) string {
+	msg := strings.TrimSpace(string(body))
+	if msg == emptyString {
+		return "Club Log request failed"
+	}
+	if len(msg) > 200 {
+		msg = msg[:200]
+	}
+	return "Club Log: " + msg
+}
+
+// enqueueClubLogUpload queues newly inserted QSOs for upload. It is registered with the QSO event
+// broker and must not block; if the queue is full the QSO is not uploaded and this is logged.
+func (s *Service) enqueueClubLogUpload(event qsoEvent) {
+	if event.Type != qsoEventInserted {
+		return
+	}
+	select {
+	case s.clublog.queue <- event:
+	default:
+		s.logger.ErrorWith().Int64("qso_id", event.Qso.ID).Msg("Club Log upload queue full; QSO not uploaded")
+	}
+}
+
+// runClubLogUploader uploads queued QSOs for logbooks with a Club Log account until ctx is cancelled.
+func (s *Service) runClubLogUploader(ctx context.Context) {
+	const op errors.Op = "server.Service.runClubLogUploader"
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case event := <-s.clublog.queue:
+			account, found, err := s.fetchClubLogAccount(ctx, event.LogbookID)
+			if err != nil {
+				s.logger.ErrorWith().Err(errors.New(op).Err(err)).Int64("qso_id", event.Qso.ID).Msg("Failed to fetch Club Log account")
+				continue
+			}
+			if found {
+				_ = s.uploadQsoToClubLog(ctx, account, event.Qso.ID)
+			}
+		}
+	}
+}
+
+// retryAllClubLogUploads retries the failed real-time uploads of every Club Log account.
+func (s *Service) retryAllClubLogUploads(ctx context.Context) error {
+	const op errors.Op = "server.Service.retryAllClubLogUploads"
+
+	accounts, err := s.listClubLogAccounts(ctx)
+	if err != nil {
+		return errors.New(op).Err(err)
+	}
+	for _, account := range accounts {
+		if ctx.Err() != nil {
+			return nil
+		}
+		s.retryClubLogUploads(ctx, account)
+	}
+	return nil
+}
+
+// retryClubLogUploads retries the logbook's failed uploads and returns how many were attempted.
+func (s *Service) retryClubLogUploads(ctx context.Context, account clubLogAccount) int {
+	const op errors.Op = "server.Service.retryClubLogUploads"
+
+	ids, err := s.listRetryableUploads(ctx, uploadServiceClubLog, account.LogbookID)
+	if err != nil {
+		s.logger.ErrorWith().Err(errors.New(op).Err(err)).Int64("logbook_id", account.LogbookID).Msg("Failed to list Club Log retries")
+		return 0
+	}
+	for _, id := range ids {
+		_ = s.uploadQsoToClubLog(ctx, account, id)
+	}
+	return len(ids)
+}
+
+// uploadQsoToClubLog uploads one QSO and records the outcome in qso_uploads.
+func (s *Service) uploadQsoToClubLog(ctx context.Context, account clubLogAccount, qsoID int64) error {
+	const op errors.Op = "server.Service.uploadQsoToClubLog"
+
+	err := s.doClubLogUpload(ctx, account, qsoID)
+	if err != nil {
+		err = errors.New(op).Err(err)
+		s.logger.WarnWith().Err(err).Int64("qso_id", qsoID).Msg("Club Log upload failed")
+	}
+	if recErr := s.recordQsoUpload(ctx, qsoID, uploadServiceClubLog, err); recErr != nil {
+		s.logger.ErrorWith().Err(recErr).Int64("qso_id", qsoID).Msg("Failed to record Club Log upload")
+	}
+	return err
+}
+
+func (s *Service) doClubLogUpload(ctx context.Context, account clubLogAccount, qsoID int64) error {
+	const op errors.Op = "server.Service.doClubLogUpload"
+
+	password, err := s.decryptCredential(account.Password)
+	if err != nil {
+		return errors.New(op).Err(err)
+	}
+	logbook, err := s.fetchLogbookWithCache(ctx, account.LogbookID)
+	if err != nil {
+		return errors.New(op).Err(err)
+	}
+	qso, err := s.db.FetchQsoByIdContext(ctx, qsoID)
+	if err != nil {
+		return errors.New(op).Err(err)
+	}
+
+	if err = s.clublog.uploadQso(ctx, account.Email, password, account.Callsign, qsoAdifRecord(qso, logbook)); err != nil {
+		return errors.New(op).Err(err)
+	}
+	return nil
+}
+
+// startFullClubLogUpload uploads the whole logbook in the background. It returns false if a full
+// upload for the logbook is already running.
+func (s *Service) startFullClubLogUpload(account clubLogAccount, replace bool) bool {
+	s.clublog.mu.Lock()
+	if s.clublog.fullUploads[account.LogbookID] {
+		s.clublog.mu.Unlock()
+		return false
+	}
+	s.clublog.fullUploads[account.LogbookID] = true
+	s.clublog.mu.Unlock()
+
+	s.runInBackground("clublog_full_upload", func(ctx context.Context) {
+		defer func() {
+			s.clublog.mu.Lock()
+			delete(s.clublog.fullUploads, account.LogbookID)
+			s.clublog.mu.Unlock()
+		}()
+		if err := s.fullClubLogUpload(ctx, account, replace); err != nil {
+			s.logger.WarnWith().Err(err).Int64("logbook_id", account.LogbookID).Msg("Club Log full upload failed")
+		}
+	})
+	return true
+}
+
+// fullClubLogUpload uploads every QSO in the logbook, replacing the Club Log log if replace is set,
+// and records the outcome on the account. Failed real-time uploads are resolved by a successful
+// full upload.
+func (s *Service) fullClubLogUpload(ctx context.Context, account clubLogAccount, replace bool) error {
+	const op errors.Op = "server.Service.fullClubLogUpload"
+
+	count, err := s.doFullClubLogUpload(ctx, account, replace)
+	if err != nil {
+		err = errors.New(op).Err(err)
+		if saveErr := s.saveClubLogUploadState(ctx, account.LogbookID, errors.Root(err).Error()); saveErr != nil {
+			s.logger.ErrorWith().Err(saveErr).Int64("logbook_id", account.LogbookID).Msg("Failed to save Club Log upload state")
+		}
+		return err
+	}
+
+	if err = s.saveClubLogUploadState(ctx, account.LogbookID, emptyString); err != nil {
+		return errors.New(op).Err(err)
+	}
+	if err = s.resolveFailedUploads(ctx, uploadServiceClubLog, account.LogbookID); err != nil {
+		return errors.New(op).Err(err)
+	}
+	s.logger.InfoWith().Int64("logbook_id", account.LogbookID).Int("qsos", count).Msg("Club Log full upload completed")
+
+	return nil
+}
+
+func (s *Service) doFullClubLogUpload(ctx context.Context, account clubLogAccount, replace bool) (int, error) {
+	const op errors.Op = "server.Service.doFullClubLogUpload"
+
+	password, err := s.decryptCredential(account.Password)
+	if err != nil {
+		return 0, errors.New(op).Err(err)
+	}
+	logbook, err := s.fetchLogbookWithCache(ctx, account.LogbookID)
+	if err != nil {
+		return 0, errors.New(op).Err(err)
+	}
+	ids, err := s.listLogbookQsoIDs(ctx, account.LogbookID)
+	if err != nil {
+		return 0, errors.New(op).Err(err)
+	}
+
+	records := make([]adif.Record, 0, len(ids))
+	for _, id := range ids {
+		qso, err := s.db.FetchQsoByIdContext(ctx, id)
+		if err != nil {
+			return 0, errors.New(op).Err(err)
+		}
+		records = append(records, qsoAdifRecord(qso, logbook))
+	}
+
+	var doc bytes.Buffer
+	if err = adif.Encode(&doc, adifProgramID, records); err != nil {
+		return 0, errors.New(op).Err(err)
+	}
+	if err = s.clublog.uploadLog(ctx, account.Email, password, account.Callsign, doc.Bytes(), replace); err != nil {
+		return 0, errors.New(op).Err(err)
+	}
+	return len(records), nil
+}
+
+// fetchClubLogAccount returns the logbook's Club Log account, if one is configured.
+func (s *Service) fetchClubLogAccount(ctx context.Context, logbookID int64) (clubLogAccount, bool, error) {
+	const op errors.Op = "server.Service.fetchClubLogAccount"
+
+	accounts, err := s.queryClubLogAccounts(ctx, `WHERE logbook_id = $1`, logbookID)
+	if err != nil {
+		return clubLogAccount{}, false, errors.New(op).Err(err)
+	}
+	if len(accounts) == 0 {
+		return clubLogAccount{}, false, nil
+	}
+	return accounts[0], true, nil
+}
+
+func (s *Service) listClubLogAccounts(ctx context.Context) ([]clubLogAccount, error) {
+	return s.queryClubLogAccounts(ctx, `WHERE logbook_id IN `+liveLogbookIDs+` ORDER BY logbook_id`)
+}
+
+func (s *Service) queryClubLogAccounts(ctx context.Context, clause string, args ...interface{}) ([]clubLogAccount, error) {
+	const op errors.Op = "server.Service.queryClubLogAccounts"
+
+	rows, err := s.db.QueryContext(ctx, `SELECT logbook_id, email, password, callsign, last_upload_at, last_error
+		FROM clublog_accounts `+clause, args...)
+	if err != nil {
+		return nil, errors.New(op).Err(err)
+	}
+	defer func() { _ = rows.Close() }()
+
+	accounts := make([]clubLogAccount, 0)
+	for rows.Next() {
+		var a clubLogAccount
+		if err = rows.Scan(&a.LogbookID, &a.Email, &a.Password, &a.Callsign, &a.LastUploadAt, &a.LastError); err != nil {
+			return nil, errors.New(op).Err(err)
+		}
+		accounts = append(accounts, a)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, errors.New(op).Err(err)
+	}
+	return accounts, nil
+}
+
+// saveClubLogAccount stores the logbook's Club Log credentials and target callsign.
+func (s *Service) saveClubLogAccount(ctx context.Context, logbookID int64, email, password, callsign string) error {
+	const op errors.Op = "server.Service.saveClubLogAccount"
+
+	encrypted, err := s.encryptCredential(password)
+	if err != nil {
+		return errors.New(op).Err(err)
+	}
+
+	if _, err = s.db.ExecContext(ctx, `INSERT INTO clublog_accounts (logbook_id, email, password, callsign) VALUES ($1, $2, $3, $4)
+		ON CONFLICT (logbook_id) DO UPDATE SET
+			email = excluded.email, password = excluded.password, callsign = excluded.callsign, last_error = ''`,
+		logbookID, email, encrypted, callsign); err != nil {
+		return errors.New(op).Err(err)
+	}
+	return nil
+}
+
+func (s *Service) saveClubLogUploadState(ctx context.Context, logbookID int64, lastError string) error {
+	const op errors.Op = "server.Service.saveClubLogUploadState"
+
+	if _, err := s.db.ExecContext(ctx, `UPDATE clublog_accounts SET last_error = $2, last_upload_at = CURRENT_TIMESTAMP
+		WHERE logbook_id = $1`, logbookID, lastError); err != nil {
+		return errors.New(op).Err(err)
+	}
+	return nil
+}
+
+// deleteClubLogAccount removes the logbook's Club Log account. QSOs already uploaded stay on Club Log.
+func (s *Service) deleteClubLogAccount(ctx context.Context, logbookID int64) (bool, error) {
+	const op errors.Op = "server.Service.deleteClubLogAccount"
+
+	res, err := s.db.ExecContext(ctx, `DELETE FROM clublog_accounts WHERE logbook_id = $1`, logbookID)
+	if err != nil {
+		return false, errors.New(op).Err(err)
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return false, errors.New(op).Err(err)
+	}
+	return n > 0, nil
+}
@@ -0,0 +1,129 @@
+package service
+
+import (
+	"strings"
+
+	"github.com/Station-Manager/errors"
+	"github.com/gofiber/fiber/v2"
+)
+
+// clubLogAccountRequest is the body of a request to configure Club Log for a logbook. Callsign
+// selects the Club Log log and defaults to the logbook's callsign.
+type clubLogAccountRequest struct {
+	Email    string `json:"email" validate:"required,email,max=254"`
+	Password string `json:"password" validate:"required,max=256"`
+	Callsign string `json:"callsign" validate:"max=32"`
+}
+
+// getClubLogAccountHandler reports whether Club Log is configured for the authenticated logbook
+// and how the last full upload went. The password is never returned.
+func (s *Service) getClubLogAccountHandler(c *fiber.Ctx) error {
+	const op errors.Op = "server.Service.getClubLogAccountHandler"
+
+	logbook, err := authenticatedLogbook(c)
+	if err != nil {
+		s.logger.ErrorWith().Err(errors.New(op).Err(err)).Msg("authenticatedLogbook failed")
+		return c.Status(fiber.StatusInternalServerError).JSON(jsonInternalError)
+	}
+
+	account, found, err := s.fetchClubLogAccount(c.UserContext(), logbook.ID)
+	if err != nil {
+		s.logger.ErrorWith().Err(errors.New(op).Err(err)).Msg("s.fetchClubLogAccount failed")
+		return s.dbFailure(c, err)
+	}
+	if !found {
+		return c.JSON(fiber.Map{"configured": false})
+	}
+
+	status := fiber.Map{"configured": true, "email": account.Email, "callsign": account.Callsign, "last_error": account.LastError}
+	if account.LastUploadAt.Valid {
+		status["last_upload_at"] = account.LastUploadAt.Time
+	}
+	return c.JSON(status)
+}
+
+// putClubLogAccountHandler stores Club Log credentials for the authenticated logbook. QSOs
+// inserted from then on are uploaded in real time; use a full upload for earlier QSOs.
+func (s *Service) putClubLogAccountHandler(c *fiber.Ctx) error {
+	const op errors.Op = "server.Service.putClubLogAccountHandler"
+
+	logbook, err := authenticatedLogbook(c)
+	if err != nil {
+		s.logger.ErrorWith().Err(errors.New(op).Err(err)).Msg("authenticatedLogbook failed")
+		return c.Status(fiber.StatusInternalServerError).JSON(jsonInternalError)
+	}
+	if len(s.credentialsKey) == 0 || !s.clublog.enabled() {
+		return c.Status(fiber.StatusServiceUnavailable).JSON(jsonError(errCodeIntegrationUnavailable, "Club Log is not configured on this server"))
+	}
+
+	var request clubLogAccountRequest
+	if err = c.BodyParser(&request); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(jsonBadRequest)
+	}
+	if err = s.validate.Struct(request); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(jsonBadRequest)
+	}
+	callsign := strings.ToUpper(strings.TrimSpace(request.Callsign))
+	if callsign == emptyString {
+		callsign = logbook.Callsign
+	}
+
+	if err = s.saveClubLogAccount(c.UserContext(), logbook.ID, request.Email, request.Password, callsign); err != nil {
+		s.logger.ErrorWith().Err(errors.New(op).Err(err)).Msg("s.saveClubLogAccount failed")
+		return s.dbFailure(c, err)
+	}
+
+	return c.SendStatus(fiber.StatusNoContent)
+}
+
+// deleteClubLogAccountHandler removes the authenticated logbook's Club Log credentials.
+func (s *Service) deleteClubLogAccountHandler(c *fiber.Ctx) error {
+	const op errors.Op = "server.Service.deleteClubLogAccountHandler"
+
+	logbook, err := authenticatedLogbook(c)
+	if err != nil {
+		s.logger.ErrorWith().Err(errors.New(op).Err(err)).Msg("authenticatedLogbook failed")
+		return c.Status(fiber.StatusInternalServerError).JSON(jsonInternalError)
+	}
+
+	found, err := s.deleteClubLogAccount(c.UserContext(), logbook.ID)
+	if err != nil {
+		s.logger.ErrorWith().Err(errors.New(op).Err(err)).Msg("s.deleteClubLogAccount failed")
+		return s.dbFailure(c, err)
+	}
+	if !found {
+		return c.Status(fiber.StatusNotFound).JSON(jsonError(errCodeIntegrationNotConfigured, "Club Log is not configured"))
+	}
+
+	return c.SendStatus(fiber.StatusNoContent)
+}
+
+// uploadClubLogHandler starts a full upload of the authenticated logbook to Club Log. With
+// ?replace=true the Club Log log is cleared first. The upload runs in the background; its outcome
+// is reported by getClubLogAccountHandler.
+func (s *Service) uploadClubLogHandler(c *fiber.Ctx) error {
+	const op errors.Op = "server.Service.uploadClubLogHandler"
+
+	logbook, err := authenticatedLogbook(c)
+	if err != nil {
+		s.logger.ErrorWith().Err(errors.New(op).Err(err)).Msg("authenticatedLogbook failed")
+		return c.Status(fiber.StatusInternalServerError).JSON(jsonInternalError)
+	}
+	if !s.clublog.enabled() {
+		return c.Status(fiber.StatusServiceUnavailable).JSON(jsonError(errCodeIntegrationUnavailable, "Club Log is not configured on this server"))
+	}
+
+	account, found, err := s.fetchClubLogAccount(c.UserContext(), logbook.ID)
+	if err != nil {
+		s.logger.ErrorWith().Err(errors.New(op).Err(err)).Msg("s.fetchClubLogAccount failed")
+		return s.dbFailure(c, err)
+	}
+	if !found {
+		return c.Status(fiber.StatusNotFound).JSON(jsonError(errCodeIntegrationNotConfigured, "Club Log is not configured"))
+	}
+
+	if !s.startFullClubLogUpload(account, c.QueryBool("replace")) {
+		return c.Status(fiber.StatusConflict).JSON(jsonError(errCodeUploadInProgress, "A Club Log upload is already in progress"))
+	}
+	return c.Status(fiber.StatusAccepted).JSON(fiber.Map{"message": "Club Log upload started"})
+}
@@ -0,0 +1,158 @@
+package service
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/Station-Manager/server/service/adif"
+)
+
+// fakeClubLog stands in for Club Log. Real-time uploads of calls listed in reject get a 400.
+type fakeClubLog struct {
+	mu       sync.Mutex
+	realtime []url.Values
+	putlogs  []url.Values
+	logs     []adif.Document
+	reject   map[string]bool
+}
+
+func newFakeClubLog(t *testing.T, svc *Service) *fakeClubLog {
+	t.Helper()
+
+	svc.credentialsKey = make([]byte, 32)
+	svc.clublog = newClubLogClient()
+	svc.clublog.apiKey = "test-api-key"
+
+	fake := &fakeClubLog{reject: map[string]bool{}}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/realtime.php", func(w http.ResponseWriter, r *http.Request) {
+		_ = r.ParseForm()
+		fake.mu.Lock()
+		defer fake.mu.Unlock()
+		fake.realtime = append(fake.realtime, r.PostForm)
+		doc, _ := adif.Parse(strings.NewReader(r.PostForm.Get("adif")))
+		if len(doc.Records) == 1 && fake.reject[doc.Records[0]["CALL"]] {
+			w.WriteHeader(http.StatusBadRequest)
+			_, _ = io.WriteString(w, "QSO Rejected due to invalid callsign")
+			return
+		}
+		_, _ = io.WriteString(w, "QSO OK")
+	})
+	mux.HandleFunc("/putlogs.php", func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseMultipartForm(1 << 20); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		file, _, err := r.FormFile("file")
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		doc, _ := adif.Parse(file)
+		fake.mu.Lock()
+		defer fake.mu.Unlock()
+		fake.putlogs = append(fake.putlogs, url.Values(r.MultipartForm.Value))
+		fake.logs = append(fake.logs, doc)
+		_, _ = io.WriteString(w, "OK")
+	})
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+	svc.clublog.realtimeURL = srv.URL + "/realtime.php"
+	svc.clublog.putlogsURL = srv.URL + "/putlogs.php"
+
+	return fake
+}
+
+func TestUploadQsoToClubLog_RecordsOutcome(t *testing.T) {
+	svc := newTestServer(t)
+	fake := newFakeClubLog(t, svc)
+	ctx := context.Background()
+	ok := insertTestQso(t, svc, "JA1XX", "20m", "FT8", "20240430", "1203")
+	bad := insertTestQso(t, svc, "XX0XX", "20m", "FT8", "20240430", "1210")
+	fake.reject["XX0XX"] = true
+
+	if err := svc.saveClubLogAccount(ctx, 1, "op@example.com", "hunter2", "7Q5MLV"); err != nil {
+		t.Fatalf("saveClubLogAccount failed: %v", err)
+	}
+	account, _, _ := svc.fetchClubLogAccount(ctx, 1)
+
+	if err := svc.uploadQsoToClubLog(ctx, account, ok); err != nil {
+		t.Fatalf("uploadQsoToClubLog failed: %v", err)
+	}
+	if form := fake.realtime[0]; form.Get("email") != "op@example.com" || form.Get("password") != "hunter2" ||
+		form.Get("callsign") != "7Q5MLV" || form.Get("api") != "test-api-key" || !strings.Contains(form.Get("adif"), "JA1XX") {
+		t.Errorf("unexpected real-time upload %v", form)
+	}
+
+	// Rejected QSOs are recorded but not retried.
+	if err := svc.uploadQsoToClubLog(ctx, account, bad); err == nil {
+		t.Fatal("expected the upload to be rejected")
+	}
+	rows, err := svc.db.QueryContext(ctx, `SELECT status, last_error FROM qso_uploads WHERE qso_id = $1 AND service = $2`, bad, uploadServiceClubLog)
+	if err != nil {
+		t.Fatalf("query qso_uploads failed: %v", err)
+	}
+	var status, lastError string
+	if rows.Next() {
+		err = rows.Scan(&status, &lastError)
+	}
+	_ = rows.Close()
+	if err != nil {
+		t.Fatalf("scan failed: %v", err)
+	}
+	if status != uploadStatusRejected || !strings.Contains(lastError, "invalid callsign") {
+		t.Errorf("unexpected upload state %q %q", status, lastError)
+	}
+	if n := svc.retryClubLogUploads(ctx, account); n != 0 {
+		t.Errorf("expected no retries, got %d", n)
+	}
+}
+
+func TestFullClubLogUpload(t *testing.T) {
+	svc := newTestServer(t)
+	fake := newFakeClubLog(t, svc)
+	ctx := context.Background()
+	insertTestQso(t, svc, "JA1XX", "20m", "FT8", "20240430", "1203")
+	failed := insertTestQso(t, svc, "K1AB", "40m", "CW", "20240430", "1300")
+
+	if err := svc.saveClubLogAccount(ctx, 1, "op@example.com", "hunter2", "7Q5MLV"); err != nil {
+		t.Fatalf("saveClubLogAccount failed: %v", err)
+	}
+	if err := svc.recordQsoUpload(ctx, failed, uploadServiceClubLog, io.ErrUnexpectedEOF); err != nil {
+		t.Fatalf("recordQsoUpload failed: %v", err)
+	}
+	account, _, _ := svc.fetchClubLogAccount(ctx, 1)
+
+	if err := svc.fullClubLogUpload(ctx, account, true); err != nil {
+		t.Fatalf("fullClubLogUpload failed: %v", err)
+	}
+	if len(fake.logs) != 1 || len(fake.logs[0].Records) != 2 {
+		t.Fatalf("expected one log with 2 QSOs, got %+v", fake.logs)
+	}
+	if form := fake.putlogs[0]; form.Get("clear") != "1" || form.Get("callsign") != "7Q5MLV" {
+		t.Errorf("unexpected full upload form %v", form)
+	}
+	if ids, _ := svc.listRetryableUploads(ctx, uploadServiceClubLog, 1); len(ids) != 0 {
+		t.Errorf("expected the failed upload to be resolved, got %v", ids)
+	}
+	account, _, _ = svc.fetchClubLogAccount(ctx, 1)
+	if !account.LastUploadAt.Valid || account.LastError != emptyString {
+		t.Errorf("unexpected account state %+v", account)
+	}
+}
+
+func TestStartFullClubLogUpload_OnePerLogbook(t *testing.T) {
+	svc := newTestServer(t)
+	newFakeClubLog(t, svc)
+
+	svc.clublog.fullUploads[1] = true
+	if svc.startFullClubLogUpload(clubLogAccount{LogbookID: 1}, false) {
+		t.Error("expected a second full upload to be refused")
+	}
+}
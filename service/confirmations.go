@@ -0,0 +1,164 @@
+package service
+
+import (
+	"context"
+	"strconv"
+	"strings"
+
+	"github.com/Station-Manager/errors"
+)
+
+const (
+	confirmationSourceLotw = "lotw"
+	confirmationSourceEqsl = "eqsl"
+
+	// qsoMatchWindowMinutes is how far apart the logged and reported start times may be, as
+	// allowed by LoTW itself.
+	qsoMatchWindowMinutes = 30
+)
+
+// qsoConfirmation records that a QSO has been confirmed by a QSL service.
+type qsoConfirmation struct {
+	Source        string `json:"source"`
+	ReceivedDate  string `json:"received_date"` // ADIF date, YYYYMMDD
+	CreditGranted string `json:"credit_granted,omitempty"`
+}
+
+// qsoKey identifies a contact the way QSL services report it.
+type qsoKey struct {
+	Call    string
+	Band    string
+	Mode    string
+	QsoDate string // YYYYMMDD
+	TimeOn  string // HHMM or HHMMSS
+}
+
+// modeGroup returns the ADIF mode group (CW, PHONE or DATA) used when matching QSOs. QSL services
+// match on the group so that, e.g., a QSO logged as USB is confirmed by a report of SSB.
+func modeGroup(mode string) string {
+	switch strings.ToUpper(strings.TrimSpace(mode)) {
+	case "CW":
+		return "CW"
+	case "SSB", "USB", "LSB", "AM", "FM", "DV", "DIGITALVOICE", "C4FM", "DSTAR", "FREEDV", "M17":
+		return "PHONE"
+	default:
+		return "DATA"
+	}
+}
+
+// adifMinutes converts an ADIF HHMM or HHMMSS time to minutes past midnight.
+func adifMinutes(t string) (int, bool) {
+	if len(t) < 4 {
+		return 0, false
+	}
+	h, err := strconv.Atoi(t[0:2])
+	if err != nil || h > 23 {
+		return 0, false
+	}
+	m, err := strconv.Atoi(t[2:4])
+	if err != nil || m > 59 {
+		return 0, false
+	}
+	return h*60 + m, true
+}
+
+// findMatchingQso returns the ID of the logbook's QSO that the key refers to: the same call, band
+// and date, a mode in the same group, and the closest start time within qsoMatchWindowMinutes.
+func (s *Service) findMatchingQso(ctx context.Context, logbookID int64, key qsoKey) (int64, bool, error) {
+	const op errors.Op = "server.Service.findMatchingQso"
+
+	want, ok := adifMinutes(key.TimeOn)
+	if !ok {
+		return 0, false, nil
+	}
+
+	query := `SELECT id, mode, time_on FROM qso
+		WHERE logbook_id = $1 AND upper(call) = $2 AND lower(band) = $3 AND qso_date = $4 AND deleted_at IS NULL`
+	if s.isPostgres() {
+		query = `SELECT id, mode, to_char(time_on, 'HH24MI') FROM qso
+		WHERE logbook_id = $1 AND upper(call) = $2 AND lower(band) = $3 AND qso_date = to_date($4, 'YYYYMMDD') AND deleted_at IS NULL`
+	}
+
+	rows, err := s.db.QueryContext(ctx, query, logbookID, strings.ToUpper(key.Call), strings.ToLower(key.Band), key.QsoDate)
+	if err != nil {
+		return 0, false, errors.New(op).Err(err)
+	}
+	defer func() { _ = rows.Close() }()
+
+	group := modeGroup(key.Mode)
+	var bestID int64
+	bestDiff := qsoMatchWindowMinutes + 1
+	for rows.Next() {
+		var id int64
+		var mode, timeOn string
+		if err = rows.Scan(&id, &mode, &timeOn); err != nil {
+			return 0, false, errors.New(op).Err(err)
+		}
+		if modeGroup(mode) != group {
+			continue
+		}
+		got, ok := adifMinutes(timeOn)
+		if !ok {
+			continue
+		}
+		diff := got - want
+		if diff < 0 {
+			diff = -diff
+		}
+		if diff < bestDiff {
+			bestID, bestDiff = id, diff
+		}
+	}
+	if err = rows.Err(); err != nil {
+		return 0, false, errors.New(op).Err(err)
+	}
+
+	return bestID, bestID != 0, nil
+}
+
+// upsertQsoConfirmation records the confirmation and reports whether the QSO was not already
+// confirmed by that source.
+func (s *Service) upsertQsoConfirmation(ctx context.Context, qsoID int64, c qsoConfirmation) (bool, error) {
+	const op errors.Op = "server.Service.upsertQsoConfirmation"
+
+	res, err := s.db.ExecContext(ctx, `INSERT INTO qso_confirmations (qso_id, source, received_date, credit_granted)
+		VALUES ($1, $2, $3, $4) ON CONFLICT (qso_id, source) DO NOTHING`, qsoID, c.Source, c.ReceivedDate, c.CreditGranted)
+	if err != nil {
+		return false, errors.New(op).Err(err)
+	}
+	if n, err := res.RowsAffected(); err == nil && n > 0 {
+		return true, nil
+	}
+
+	if _, err = s.db.ExecContext(ctx, `UPDATE qso_confirmations SET received_date = $3, credit_granted = $4, updated_at = CURRENT_TIMESTAMP
+		WHERE qso_id = $1 AND source = $2`, qsoID, c.Source, c.ReceivedDate, c.CreditGranted); err != nil {
+		return false, errors.New(op).Err(err)
+	}
+	return false, nil
+}
+
+// listQsoConfirmations returns the QSO's confirmations, ordered by source.
+func (s *Service) listQsoConfirmations(ctx context.Context, qsoID int64) ([]qsoConfirmation, error) {
+	const op errors.Op = "server.Service.listQsoConfirmations"
+
+	rows, err := s.db.QueryContext(ctx, `SELECT source, received_date, credit_granted FROM qso_confirmations
+		WHERE qso_id = $1 ORDER BY source`, qsoID)
+	if err != nil {
+		return nil, errors.New(op).Err(err)
+	}
+	defer func() { _ = rows.Close() }()
+
+	confirmations := make([]qsoConfirmation, 0)
+	for rows.Next() {
+		var c qsoConfirmation
+		if err = rows.Scan(&c.Source, &c.ReceivedDate, &c.CreditGranted); err != nil {
+			return nil, errors.New(op).Err(err)
+		}
+		confirmations = append(confirmations, c)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, errors.New(op).Err(err)
+	}
+
+	return confirmations, nil
+}
@@ -0,0 +1,400 @@
+package service
+
+import (
+	"cmp"
+	"context"
+	"database/sql"
+	stderr "errors"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Station-Manager/errors"
+	"github.com/Station-Manager/types"
+	"github.com/goccy/go-json"
+)
+
+// Dupe rules: a station may be worked again on another band and mode, on another band, or not at
+// all.
+const (
+	contestDupesBandMode = "band_mode"
+	contestDupesBand     = "band"
+	contestDupesOnce     = "once"
+)
+
+// contestEventScore reports a running contest's score on its logbook's feed after each QSO.
+const contestEventScore qsoEventType = "contest.score"
+
+// errContestDupe is returned by insertQso when the running contest's dupe rule rejects the QSO.
+var errContestDupe = stderr.New("QSO is a dupe in the running contest")
+
+// contestPoints are what a QSO scores. A QSO scores SameEntity points when the station is in the
+// logbook's own DXCC entity, SameContinent points when it is on the same continent, and
+// OtherContinent points otherwise, for the rules that are set; any other QSO scores Qso points,
+// one unless set.
+type contestPoints struct {
+	Qso            int  `json:"qso" validate:"min=0,max=100"`
+	SameEntity     *int `json:"same_entity,omitempty" validate:"omitempty,min=0,max=100"`
+	SameContinent  *int `json:"same_continent,omitempty" validate:"omitempty,min=0,max=100"`
+	OtherContinent *int `json:"other_continent,omitempty" validate:"omitempty,min=0,max=100"`
+}
+
+// contestRules are the rules of a contest: its dupe rule, whether QSOs are given serial numbers,
+// what each QSO scores and what counts as a multiplier. The score is the sum of the points times
+// the number of multipliers, or the points alone when there are no multipliers.
+type contestRules struct {
+	Dupes              string        `json:"dupes" validate:"omitempty,oneof=band_mode band once"`
+	Serials            bool          `json:"serials"`
+	Points             contestPoints `json:"points"`
+	Multipliers        []string      `json:"multipliers" validate:"max=3,unique,dive,oneof=dxcc cqz ituz"`
+	MultipliersPerBand bool          `json:"multipliers_per_band"`
+}
+
+// contest is the contest a logbook is running.
+type contest struct {
+	ContestID  string       `json:"contest_id"`
+	Rules      contestRules `json:"rules"`
+	NextSerial int          `json:"next_serial"`
+	StartedAt  time.Time    `json:"started_at"`
+}
+
+// contestRequest is the body of a request to start a contest.
+type contestRequest struct {
+	// ContestID is the ADIF CONTEST_ID given to the contest's QSOs, such as CQ-WW-CW.
+	ContestID   string       `json:"contest_id" validate:"required,max=64,printascii"`
+	Rules       contestRules `json:"rules"`
+	FirstSerial int          `json:"first_serial" validate:"omitempty,min=1,max=999999"`
+}
+
+// contestBandScore is the part of a contest's score made on one band.
+type contestBandScore struct {
+	Band        string `json:"band"`
+	Qsos        int    `json:"qsos"`
+	Points      int    `json:"points"`
+	Multipliers int    `json:"multipliers"`
+}
+
+// contestScore is a contest's running score.
+type contestScore struct {
+	ContestID   string             `json:"contest_id"`
+	Qsos        int                `json:"qsos"`
+	Points      int                `json:"points"`
+	Multipliers int                `json:"multipliers"`
+	Score       int                `json:"score"`
+	Bands       []contestBandScore `json:"bands"`
+}
+
+// withDefaults returns the rules with the defaults filled in.
+func (r contestRules) withDefaults() contestRules {
+	if r.Dupes == emptyString {
+		r.Dupes = contestDupesBandMode
+	}
+	if r.Points.Qso == 0 {
+		r.Points.Qso = 1
+	}
+	if r.Multipliers == nil {
+		r.Multipliers = make([]string, 0)
+	}
+	return r
+}
+
+// fetchContest returns the contest the logbook is running, if any.
+func (s *Service) fetchContest(ctx context.Context, logbookID int64) (contest, bool, error) {
+	const op errors.Op = "server.Service.fetchContest"
+
+	rows, err := s.db.QueryContext(ctx, `SELECT contest_id, rules, next_serial, started_at FROM contests WHERE logbook_id = $1`, logbookID)
+	if err != nil {
+		return contest{}, false, errors.New(op).Err(err)
+	}
+	defer func() { _ = rows.Close() }()
+
+	if !rows.Next() {
+		if err = rows.Err(); err != nil {
+			return contest{}, false, errors.New(op).Err(err)
+		}
+		return contest{}, false, nil
+	}
+	var c contest
+	var rules string
+	if err = rows.Scan(&c.ContestID, &rules, &c.NextSerial, &c.StartedAt); err != nil {
+		return contest{}, false, errors.New(op).Err(err)
+	}
+	if err = json.Unmarshal([]byte(rules), &c.Rules); err != nil {
+		return contest{}, false, errors.New(op).Err(err)
+	}
+	c.Rules = c.Rules.withDefaults()
+	return c, true, nil
+}
+
+// startContest starts a contest in the logbook, ending any it was running.
+func (s *Service) startContest(ctx context.Context, logbookID int64, request contestRequest) (contest, error) {
+	const op errors.Op = "server.Service.startContest"
+
+	c := contest{
+		ContestID:  strings.ToUpper(strings.TrimSpace(request.ContestID)),
+		Rules:      request.Rules.withDefaults(),
+		NextSerial: max(request.FirstSerial, 1),
+		StartedAt:  time.Now().UTC().Truncate(time.Second),
+	}
+	rules, err := json.Marshal(c.Rules)
+	if err != nil {
+		return c, errors.New(op).Err(err)
+	}
+	err = s.withTx(ctx, func(tx *sql.Tx) error {
+		for _, query := range []string{`DELETE FROM contest_qsos WHERE logbook_id = $1`, `DELETE FROM contests WHERE logbook_id = $1`} {
+			if _, err := tx.ExecContext(ctx, query, logbookID); err != nil {
+				return err
+			}
+		}
+		_, err := tx.ExecContext(ctx, `INSERT INTO contests (logbook_id, contest_id, rules, next_serial, started_at) VALUES ($1, $2, $3, $4, $5)`,
+			logbookID, c.ContestID, string(rules), c.NextSerial, s.dbTimestamp(c.StartedAt))
+		return err
+	})
+	if err != nil {
+		return c, errors.New(op).Err(err)
+	}
+	return c, nil
+}
+
+// endContest ends the contest the logbook is running. Its QSOs keep their contest fields.
+func (s *Service) endContest(ctx context.Context, logbookID int64) (bool, error) {
+	const op errors.Op = "server.Service.endContest"
+
+	var found bool
+	err := s.withTx(ctx, func(tx *sql.Tx) error {
+		if _, err := tx.ExecContext(ctx, `DELETE FROM contest_qsos WHERE logbook_id = $1`, logbookID); err != nil {
+			return err
+		}
+		res, err := tx.ExecContext(ctx, `DELETE FROM contests WHERE logbook_id = $1`, logbookID)
+		if err != nil {
+			return err
+		}
+		n, err := res.RowsAffected()
+		found = n > 0
+		return err
+	})
+	if err != nil {
+		return false, errors.New(op).Err(err)
+	}
+	return found, nil
+}
+
+// prepareContestQso readies a QSO for the running contest within the transaction that inserts it:
+// it is given the contest's ID and, if the contest numbers its QSOs and the client did not, the
+// next serial number, and is checked against the dupe rule. It reports false when the contest
+// ended since it was read, in which case the QSO is logged outside it.
+func (s *Service) prepareContestQso(ctx context.Context, tx *sql.Tx, c contest, qso *types.Qso) (bool, error) {
+	const op errors.Op = "server.Service.prepareContestQso"
+
+	// Taking the serial number, or touching the row when there is none to take, also serialises
+	// the contest's inserts, so that two copies of a QSO cannot both pass the dupe check.
+	increment := 0
+	if c.Rules.Serials && strings.TrimSpace(qso.STX) == emptyString {
+		increment = 1
+	}
+	rows, err := tx.QueryContext(ctx, `UPDATE contests SET next_serial = next_serial + $2 WHERE logbook_id = $1 RETURNING next_serial - $2`, qso.LogbookID, increment)
+	if err != nil {
+		return false, errors.New(op).Err(err)
+	}
+	var serial int
+	running := rows.Next()
+	if running {
+		err = rows.Scan(&serial)
+	}
+	_ = rows.Close()
+	if err != nil {
+		return false, errors.New(op).Err(err)
+	}
+	if !running {
+		return false, nil
+	}
+
+	call, band, mode := contestQsoKey(*qso)
+	query := `SELECT cq.qso_id FROM contest_qsos cq JOIN qso q ON q.id = cq.qso_id
+		WHERE cq.logbook_id = $1 AND cq.call = $2 AND q.deleted_at IS NULL`
+	args := []any{qso.LogbookID, call}
+	switch c.Rules.Dupes {
+	case contestDupesBandMode:
+		query += ` AND cq.band = $3 AND cq.mode = $4`
+		args = append(args, band, mode)
+	case contestDupesBand:
+		query += ` AND cq.band = $3`
+		args = append(args, band)
+	}
+	dupes, err := tx.QueryContext(ctx, query+` LIMIT 1`, args...)
+	if err != nil {
+		return false, errors.New(op).Err(err)
+	}
+	dupe := dupes.Next()
+	_ = dupes.Close()
+	if dupe {
+		return false, errContestDupe
+	}
+
+	if qso.ContestId == emptyString {
+		qso.ContestId = c.ContestID
+	}
+	if increment > 0 {
+		qso.STX = strconv.Itoa(serial)
+	}
+	return true, nil
+}
+
+// recordContestQso records what the inserted QSO scores in the running contest, within the
+// transaction that inserts it.
+func (s *Service) recordContestQso(ctx context.Context, tx *sql.Tx, c contest, logbookCallsign string, qso types.Qso) error {
+	const op errors.Op = "server.Service.recordContestQso"
+
+	call, band, mode := contestQsoKey(qso)
+	if _, err := tx.ExecContext(ctx, `INSERT INTO contest_qsos (qso_id, logbook_id, call, band, mode, points, multipliers) VALUES ($1, $2, $3, $4, $5, $6, $7)`,
+		qso.ID, qso.LogbookID, call, band, mode, s.contestQsoPoints(c.Rules, logbookCallsign, qso), strings.Join(contestQsoMultipliers(c.Rules, qso), ",")); err != nil {
+		return errors.New(op).Err(err)
+	}
+	return nil
+}
+
+// contestQsoKey returns the callsign, band and mode group that the dupe rules compare.
+func contestQsoKey(qso types.Qso) (string, string, string) {
+	return strings.ToUpper(strings.TrimSpace(qso.Call)), strings.ToLower(strings.TrimSpace(qso.Band)), modeGroup(qso.Mode)
+}
+
+// contestQsoPoints returns what the QSO scores under the rules, placing the logbook's station by
+// its callsign.
+func (s *Service) contestQsoPoints(rules contestRules, logbookCallsign string, qso types.Qso) int {
+	points := rules.Points
+	if points.SameEntity == nil && points.SameContinent == nil && points.OtherContinent == nil {
+		return points.Qso
+	}
+	if s.cty == nil {
+		return points.Qso
+	}
+	db := s.cty.db.Load()
+	if db == nil {
+		return points.Qso
+	}
+	home, ok := db.Lookup(logbookCallsign)
+	if !ok || qso.DXCC == emptyString || qso.Cont == emptyString {
+		return points.Qso
+	}
+	switch {
+	case points.SameEntity != nil && qso.DXCC == strconv.Itoa(home.DXCC):
+		return *points.SameEntity
+	case points.SameContinent != nil && strings.EqualFold(qso.Cont, home.Continent):
+		return *points.SameContinent
+	case points.OtherContinent != nil && !strings.EqualFold(qso.Cont, home.Continent):
+		return *points.OtherContinent
+	}
+	return points.Qso
+}
+
+// contestQsoMultipliers returns the multipliers the QSO counts towards under the rules, as
+// "kind:value", prefixed with the band when multipliers count per band.
+func contestQsoMultipliers(rules contestRules, qso types.Qso) []string {
+	multipliers := make([]string, 0, len(rules.Multipliers))
+	for _, kind := range rules.Multipliers {
+		var value string
+		switch kind {
+		case "dxcc":
+			value = qso.DXCC
+		case "cqz":
+			value = qso.CQZ
+		case "ituz":
+			value = qso.ITUZ
+		}
+		value = strings.TrimSpace(value)
+		if value == emptyString || value == "0" {
+			continue
+		}
+		multiplier := kind + ":" + value
+		if rules.MultipliersPerBand {
+			multiplier = strings.ToLower(strings.TrimSpace(qso.Band)) + "/" + multiplier
+		}
+		multipliers = append(multipliers, multiplier)
+	}
+	return multipliers
+}
+
+// fetchContestScore returns the running score of the contest the logbook is running. QSOs deleted
+// since they were logged do not count.
+func (s *Service) fetchContestScore(ctx context.Context, logbookID int64, c contest) (contestScore, error) {
+	const op errors.Op = "server.Service.fetchContestScore"
+
+	rows, err := s.db.QueryContext(ctx, `SELECT cq.band, cq.points, cq.multipliers FROM contest_qsos cq JOIN qso q ON q.id = cq.qso_id
+		WHERE cq.logbook_id = $1 AND q.deleted_at IS NULL`, logbookID)
+	if err != nil {
+		return contestScore{}, errors.New(op).Err(err)
+	}
+	defer func() { _ = rows.Close() }()
+
+	score := contestScore{ContestID: c.ContestID, Bands: make([]contestBandScore, 0)}
+	multipliers := make(map[string]bool)
+	bands := make(map[string]*contestBandScore)
+	bandMultipliers := make(map[string]map[string]bool)
+	for rows.Next() {
+		var band, mults string
+		var points int
+		if err = rows.Scan(&band, &points, &mults); err != nil {
+			return contestScore{}, errors.New(op).Err(err)
+		}
+		if bands[band] == nil {
+			bands[band] = &contestBandScore{Band: band}
+			bandMultipliers[band] = make(map[string]bool)
+		}
+		score.Qsos++
+		score.Points += points
+		bands[band].Qsos++
+		bands[band].Points += points
+		for _, m := range strings.Split(mults, ",") {
+			if m != emptyString {
+				multipliers[m] = true
+				bandMultipliers[band][m] = true
+			}
+		}
+	}
+	if err = rows.Err(); err != nil {
+		return contestScore{}, errors.New(op).Err(err)
+	}
+
+	score.Multipliers = len(multipliers)
+	score.Score = score.Points
+	if len(c.Rules.Multipliers) > 0 {
+		score.Score = score.Points * score.Multipliers
+	}
+	for band, b := range bands {
+		b.Multipliers = len(bandMultipliers[band])
+		score.Bands = append(score.Bands, *b)
+	}
+	slices.SortFunc(score.Bands, func(a, b contestBandScore) int {
+		return cmp.Or(cmp.Compare(bandMeters(b.Band), bandMeters(a.Band)), strings.Compare(a.Band, b.Band))
+	})
+	return score, nil
+}
+
+// announceContestScore reports the logbook's contest score on its feed.
+func (s *Service) announceContestScore(ctx context.Context, logbookID int64, c contest) {
+	score, err := s.fetchContestScore(ctx, logbookID, c)
+	if err != nil {
+		s.logger.WarnWith().Err(err).Int64("logbook_id", logbookID).Msg("Failed to read contest score for event")
+		return
+	}
+	s.qsoEvents.Announce(qsoEvent{Type: contestEventScore, LogbookID: logbookID, Contest: &score})
+}
+
+// bandMeters returns the wavelength of an ADIF band such as 20m or 70cm in metres, or zero.
+func bandMeters(band string) float64 {
+	units := []struct {
+		suffix  string
+		divisor float64
+	}{{"mm", 1000}, {"cm", 100}, {"m", 1}}
+	for _, unit := range units {
+		if n, ok := strings.CutSuffix(band, unit.suffix); ok {
+			value, err := strconv.ParseFloat(n, 64)
+			if err != nil {
+				return 0
+			}
+			return value / unit.divisor
+		}
+	}
+	return 0
+}
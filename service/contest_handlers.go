@@ -0,0 +1,80 @@
+package service
+
+import (
+	"github.com/Station-Manager/errors"
+	"github.com/gofiber/fiber/v2"
+)
+
+// getContestHandler returns the contest the authenticated logbook is running, with its score.
+func (s *Service) getContestHandler(c *fiber.Ctx) error {
+	const op errors.Op = "server.Service.getContestHandler"
+
+	logbook, err := authenticatedLogbook(c)
+	if err != nil {
+		s.logger.ErrorWith().Err(errors.New(op).Err(err)).Msg("authenticatedLogbook failed")
+		return c.Status(fiber.StatusInternalServerError).JSON(jsonInternalError)
+	}
+
+	running, found, err := s.fetchContest(c.UserContext(), logbook.ID)
+	if err != nil {
+		s.logger.ErrorWith().Err(errors.New(op).Err(err)).Msg("s.fetchContest failed")
+		return s.dbFailure(c, err)
+	}
+	if !found {
+		return c.Status(fiber.StatusNotFound).JSON(jsonError(errCodeContestNotRunning, "No contest is running"))
+	}
+	score, err := s.fetchContestScore(c.UserContext(), logbook.ID, running)
+	if err != nil {
+		s.logger.ErrorWith().Err(errors.New(op).Err(err)).Msg("s.fetchContestScore failed")
+		return s.dbFailure(c, err)
+	}
+	return c.JSON(fiber.Map{"contest": running, "score": score})
+}
+
+// startContestHandler puts the authenticated logbook into contest mode under the rules given,
+// ending the contest it was running, if any.
+func (s *Service) startContestHandler(c *fiber.Ctx) error {
+	const op errors.Op = "server.Service.startContestHandler"
+
+	logbook, err := authenticatedLogbook(c)
+	if err != nil {
+		s.logger.ErrorWith().Err(errors.New(op).Err(err)).Msg("authenticatedLogbook failed")
+		return c.Status(fiber.StatusInternalServerError).JSON(jsonInternalError)
+	}
+
+	var request contestRequest
+	if err = c.BodyParser(&request); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(jsonBadRequest)
+	}
+	if err = s.validate.Struct(request); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(jsonBadRequest)
+	}
+
+	running, err := s.startContest(c.UserContext(), logbook.ID, request)
+	if err != nil {
+		s.logger.ErrorWith().Err(errors.New(op).Err(err)).Msg("s.startContest failed")
+		return s.dbFailure(c, err)
+	}
+	return c.Status(fiber.StatusCreated).JSON(fiber.Map{"contest": running})
+}
+
+// endContestHandler takes the authenticated logbook out of contest mode.
+func (s *Service) endContestHandler(c *fiber.Ctx) error {
+	const op errors.Op = "server.Service.endContestHandler"
+
+	logbook, err := authenticatedLogbook(c)
+	if err != nil {
+		s.logger.ErrorWith().Err(errors.New(op).Err(err)).Msg("authenticatedLogbook failed")
+		return c.Status(fiber.StatusInternalServerError).JSON(jsonInternalError)
+	}
+
+	found, err := s.endContest(c.UserContext(), logbook.ID)
+	if err != nil {
+		s.logger.ErrorWith().Err(errors.New(op).Err(err)).Msg("s.endContest failed")
+		return s.dbFailure(c, err)
+	}
+	if !found {
+		return c.Status(fiber.StatusNotFound).JSON(jsonError(errCodeContestNotRunning, "No contest is running"))
+	}
+	return c.SendStatus(fiber.StatusNoContent)
+}
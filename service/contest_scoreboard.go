@@ -0,0 +1,184 @@
+package service
+
+import (
+	"context"
+	"time"
+
+	"github.com/Station-Manager/errors"
+	"github.com/gofiber/contrib/websocket"
+	"github.com/gofiber/fiber/v2"
+)
+
+// scoreboardRefreshInterval is how often a scoreboard stream is sent the scoreboard when nothing
+// is logged, so that the rates fall as time passes and a contest started since shows up.
+const scoreboardRefreshInterval = time.Minute
+
+// contestHour is the number of QSOs logged in a clock hour of a contest.
+type contestHour struct {
+	Hour time.Time `json:"hour"`
+	Qsos int       `json:"qsos"`
+}
+
+// contestScoreboard is what a contest scoreboard shows: the score with its band breakdown, the
+// rates and the QSOs of each hour. Without a running contest only Running and UpdatedAt are set.
+type contestScoreboard struct {
+	Running   bool          `json:"running"`
+	ContestID string        `json:"contest_id,omitempty"`
+	StartedAt *time.Time    `json:"started_at,omitempty"`
+	Score     *contestScore `json:"score,omitempty"`
+	// Rate60 is the number of QSOs logged in the last hour; Rate10 is the number logged in the
+	// last ten minutes, as an hourly rate.
+	Rate60    int           `json:"rate_60"`
+	Rate10    int           `json:"rate_10"`
+	Hours     []contestHour `json:"hours,omitempty"`
+	UpdatedAt time.Time     `json:"updated_at"`
+}
+
+// fetchContestScoreboard returns the scoreboard of the contest the logbook is running, as of now.
+// QSOs deleted since they were logged do not count.
+func (s *Service) fetchContestScoreboard(ctx context.Context, logbookID int64, now time.Time) (contestScoreboard, error) {
+	const op errors.Op = "server.Service.fetchContestScoreboard"
+
+	now = now.UTC()
+	board := contestScoreboard{UpdatedAt: now}
+	running, found, err := s.fetchContest(ctx, logbookID)
+	if err != nil {
+		return board, errors.New(op).Err(err)
+	}
+	if !found {
+		return board, nil
+	}
+	score, err := s.fetchContestScore(ctx, logbookID, running)
+	if err != nil {
+		return board, errors.New(op).Err(err)
+	}
+	board.Running, board.ContestID, board.StartedAt, board.Score = true, running.ContestID, &running.StartedAt, &score
+
+	rows, err := s.db.QueryContext(ctx, `SELECT cq.created_at FROM contest_qsos cq JOIN qso q ON q.id = cq.qso_id
+		WHERE cq.logbook_id = $1 AND q.deleted_at IS NULL ORDER BY cq.created_at`, logbookID)
+	if err != nil {
+		return board, errors.New(op).Err(err)
+	}
+	defer func() { _ = rows.Close() }()
+
+	board.Hours = make([]contestHour, 0)
+	for rows.Next() {
+		var loggedAt time.Time
+		if err = rows.Scan(&loggedAt); err != nil {
+			return board, errors.New(op).Err(err)
+		}
+		if age := now.Sub(loggedAt); age >= 0 && age < time.Hour {
+			board.Rate60++
+			if age < 10*time.Minute {
+				board.Rate10 += 6
+			}
+		}
+		hour := loggedAt.UTC().Truncate(time.Hour)
+		if n := len(board.Hours); n == 0 || !board.Hours[n-1].Hour.Equal(hour) {
+			board.Hours = append(board.Hours, contestHour{Hour: hour})
+		}
+		board.Hours[len(board.Hours)-1].Qsos++
+	}
+	if err = rows.Err(); err != nil {
+		return board, errors.New(op).Err(err)
+	}
+	return board, nil
+}
+
+// contestScoreboardHandler returns the scoreboard of the logbook's running contest.
+func (s *Service) contestScoreboardHandler(c *fiber.Ctx) error {
+	const op errors.Op = "server.Service.contestScoreboardHandler"
+
+	logbook, err := authenticatedLogbook(c)
+	if err != nil {
+		s.logger.ErrorWith().Err(errors.New(op).Err(err)).Msg("authenticatedLogbook failed")
+		return c.Status(fiber.StatusInternalServerError).JSON(jsonInternalError)
+	}
+
+	board, err := s.fetchContestScoreboard(c.UserContext(), logbook.ID, time.Now())
+	if err != nil {
+		s.logger.ErrorWith().Err(errors.New(op).Err(err)).Msg("s.fetchContestScoreboard failed")
+		return s.dbFailure(c, err)
+	}
+	if !board.Running {
+		return c.Status(fiber.StatusNotFound).JSON(jsonError(errCodeContestNotRunning, "No contest is running"))
+	}
+	c.Set(fiber.HeaderCacheControl, "no-store")
+	return c.JSON(board)
+}
+
+// contestScoreboardWebSocketHandler streams the scoreboard of the logbook's contest as JSON text
+// messages: once on connecting, after every contest QSO, and every scoreboardRefreshInterval.
+// Messages from the client are ignored; reading only serves to detect that the client has gone
+// away.
+func (s *Service) contestScoreboardWebSocketHandler() fiber.Handler {
+	const op errors.Op = "server.Service.contestScoreboardWebSocketHandler"
+
+	return websocket.New(func(conn *websocket.Conn) {
+		reqCtx, err := requestContextOf(conn.Locals(localsRequestDataKey))
+		if err != nil || reqCtx.Logbook == nil {
+			err = errors.New(op).Err(err).Msg("Request context missing")
+			s.logger.ErrorWith().Err(err).Msg("WebSocket request context missing")
+			_ = conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseInternalServerErr, emptyString), time.Now().Add(streamWriteTimeout))
+			return
+		}
+		logbookID := reqCtx.Logbook.ID
+
+		sub := s.qsoEvents.Subscribe(logbookID)
+		defer s.qsoEvents.Unsubscribe(sub)
+
+		clientGone := make(chan struct{})
+		go func() {
+			defer close(clientGone)
+			for {
+				if _, _, err := conn.ReadMessage(); err != nil {
+					return
+				}
+			}
+		}()
+
+		send := func() bool {
+			ctx, cancel := context.WithTimeout(context.Background(), streamWriteTimeout)
+			defer cancel()
+			board, err := s.fetchContestScoreboard(ctx, logbookID, time.Now())
+			if err != nil {
+				s.logger.ErrorWith().Err(errors.New(op).Err(err)).Int64("logbook_id", logbookID).Msg("s.fetchContestScoreboard failed")
+				return true
+			}
+			_ = conn.SetWriteDeadline(time.Now().Add(streamWriteTimeout))
+			return conn.WriteJSON(board) == nil
+		}
+		if !send() {
+			return
+		}
+
+		refresh := time.NewTicker(scoreboardRefreshInterval)
+		defer refresh.Stop()
+		ping := time.NewTicker(streamPingInterval)
+		defer ping.Stop()
+
+		for {
+			select {
+			case <-clientGone:
+				return
+			case event, open := <-sub.Events():
+				if !open {
+					// Dropped for falling behind, or the server is shutting down.
+					_ = conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseGoingAway, emptyString), time.Now().Add(streamWriteTimeout))
+					return
+				}
+				if event.Type == contestEventScore && !send() {
+					return
+				}
+			case <-refresh.C:
+				if !send() {
+					return
+				}
+			case <-ping.C:
+				if err := conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(streamWriteTimeout)); err != nil {
+					return
+				}
+			}
+		}
+	})
+}
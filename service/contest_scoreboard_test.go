@@ -0,0 +1,87 @@
+package service
+
+import (
+	"context"
+	"io"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/goccy/go-json"
+	"github.com/gofiber/fiber/v2"
+)
+
+func TestFetchContestScoreboard(t *testing.T) {
+	svc, logbook := newContestTestServer(t)
+	ctx := context.Background()
+	now := time.Date(2024, 11, 23, 12, 30, 0, 0, time.UTC)
+
+	board, err := svc.fetchContestScoreboard(ctx, logbook.ID, now)
+	if err != nil || board.Running || board.Score != nil {
+		t.Fatalf("expected no contest, got %+v %v", board, err)
+	}
+
+	if _, err = svc.startContest(ctx, logbook.ID, contestRequest{ContestID: "TEST", Rules: contestRules{Multipliers: []string{"dxcc"}}}); err != nil {
+		t.Fatalf("startContest failed: %v", err)
+	}
+	// Two QSOs in the last ten minutes, one earlier in the hour and one the hour before.
+	for call, loggedAt := range map[string]time.Time{
+		"JA1AA": now.Add(-2 * time.Minute),
+		"JA1BB": now.Add(-5 * time.Minute),
+		"W1AW":  now.Add(-25 * time.Minute),
+		"JA1CC": now.Add(-90 * time.Minute),
+	} {
+		qso, err := svc.insertQso(ctx, logbook, nil, "test", testContestQso(call, "20m", "CW"), emptyString, emptyString, false)
+		if err != nil {
+			t.Fatalf("insertQso failed: %v", err)
+		}
+		if _, err = svc.db.ExecContext(ctx, `UPDATE contest_qsos SET created_at = $1 WHERE qso_id = $2`, svc.dbTimestamp(loggedAt), qso.ID); err != nil {
+			t.Fatalf("update failed: %v", err)
+		}
+	}
+
+	board, err = svc.fetchContestScoreboard(ctx, logbook.ID, now)
+	if err != nil {
+		t.Fatalf("fetchContestScoreboard failed: %v", err)
+	}
+	if !board.Running || board.ContestID != "TEST" || board.Score == nil || board.Score.Qsos != 4 || board.Score.Multipliers != 2 {
+		t.Fatalf("unexpected scoreboard %+v", board)
+	}
+	if board.Rate60 != 3 || board.Rate10 != 12 {
+		t.Errorf("expected rates of 3 and 12 an hour, got %d and %d", board.Rate60, board.Rate10)
+	}
+	if len(board.Hours) != 2 || !board.Hours[0].Hour.Equal(time.Date(2024, 11, 23, 11, 0, 0, 0, time.UTC)) || board.Hours[0].Qsos != 1 || board.Hours[1].Qsos != 3 {
+		t.Errorf("unexpected hours %+v", board.Hours)
+	}
+}
+
+func TestContestScoreboardHandler(t *testing.T) {
+	svc, logbook := newContestTestServer(t)
+	app := fiber.New()
+	app.Get("/contest/scoreboard", withLogbook(logbook.ID, svc.contestScoreboardHandler))
+	app.Get("/contest/scoreboard/ws", requireWebSocketUpgrade, withLogbook(logbook.ID, svc.contestScoreboardWebSocketHandler()))
+	get := func(path string) (int, []byte) {
+		t.Helper()
+		resp, err := app.Test(httptest.NewRequest(fiber.MethodGet, path, nil))
+		if err != nil {
+			t.Fatalf("GET %s failed: %v", path, err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		return resp.StatusCode, body
+	}
+
+	if status, _ := get("/contest/scoreboard"); status != fiber.StatusNotFound {
+		t.Errorf("expected 404 without a contest, got %d", status)
+	}
+	if _, err := svc.startContest(context.Background(), logbook.ID, contestRequest{ContestID: "TEST"}); err != nil {
+		t.Fatalf("startContest failed: %v", err)
+	}
+	status, body := get("/contest/scoreboard")
+	var board contestScoreboard
+	if err := json.Unmarshal(body, &board); err != nil || status != fiber.StatusOK || !board.Running || board.ContestID != "TEST" {
+		t.Errorf("expected the scoreboard, got %d %s", status, body)
+	}
+	if status, _ = get("/contest/scoreboard/ws"); status != fiber.StatusUpgradeRequired {
+		t.Errorf("expected 426 without a WebSocket upgrade, got %d", status)
+	}
+}
@@ -0,0 +1,164 @@
+package service
+
+import (
+	"context"
+	stderr "errors"
+	"strings"
+	"testing"
+
+	"github.com/Station-Manager/server/service/cty"
+	"github.com/Station-Manager/types"
+)
+
+// newContestTestServer returns a server whose logbook 1 is K1AB, with the test prefix database.
+func newContestTestServer(t *testing.T) (*Service, types.Logbook) {
+	t.Helper()
+	svc := newTestServer(t)
+	for _, query := range []string{
+		`UPDATE logbook SET callsign = 'K1AB' WHERE id = 1`,
+		`INSERT INTO session (id) VALUES (1)`,
+	} {
+		if _, err := svc.db.ExecContext(context.Background(), query); err != nil {
+			t.Fatalf("setup failed: %v", err)
+		}
+	}
+	db, err := cty.Parse(strings.NewReader(testCtyDatabase))
+	if err != nil {
+		t.Fatalf("cty.Parse failed: %v", err)
+	}
+	svc.cty = newCtyResolver()
+	svc.cty.db.Store(db)
+	return svc, types.Logbook{ID: 1, Callsign: "K1AB"}
+}
+
+// testContestQso returns a QSO with the station on the band and mode.
+func testContestQso(call, band, mode string) types.Qso {
+	var qso types.Qso
+	qso.Call, qso.Band, qso.Mode, qso.Freq = call, band, mode, "14.025"
+	qso.QsoDate, qso.TimeOn, qso.TimeOff = "20241123", "0000", "0001"
+	qso.RstSent, qso.RstRcvd, qso.StationCallsign, qso.SessionID = "599", "599", "K1AB", 1
+	return qso
+}
+
+func TestContestMode_SerialsDupesAndScore(t *testing.T) {
+	svc, logbook := newContestTestServer(t)
+	ctx := context.Background()
+
+	var scores []contestScore
+	sub := svc.qsoEvents.Subscribe(logbook.ID)
+	defer svc.qsoEvents.Unsubscribe(sub)
+	drain := func() {
+		for {
+			select {
+			case event := <-sub.Events():
+				if event.Type == contestEventScore {
+					scores = append(scores, *event.Contest)
+				}
+			default:
+				return
+			}
+		}
+	}
+
+	three, zero := 3, 0
+	if _, err := svc.startContest(ctx, logbook.ID, contestRequest{
+		ContestID: "cq-ww-cw",
+		Rules: contestRules{
+			Serials:     true,
+			Points:      contestPoints{SameEntity: &zero, OtherContinent: &three},
+			Multipliers: []string{"dxcc", "cqz"},
+		},
+	}); err != nil {
+		t.Fatalf("startContest failed: %v", err)
+	}
+
+	first, err := svc.insertQso(ctx, logbook, nil, "test", testContestQso("JA1XX", "20m", "CW"), emptyString, emptyString, false)
+	if err != nil {
+		t.Fatalf("insertQso failed: %v", err)
+	}
+	if first.STX != "1" || first.ContestId != "CQ-WW-CW" {
+		t.Errorf("expected serial 1 in CQ-WW-CW, got %q %q", first.STX, first.ContestId)
+	}
+
+	// The same station on the same band and mode is a dupe and takes no serial number.
+	if _, err = svc.insertQso(ctx, logbook, nil, "test", testContestQso("ja1xx", "20m", "CW"), emptyString, emptyString, false); !stderr.Is(err, errContestDupe) {
+		t.Fatalf("expected a dupe, got %v", err)
+	}
+	second, err := svc.insertQso(ctx, logbook, nil, "test", testContestQso("JA1XX", "40m", "CW"), emptyString, emptyString, false)
+	if err != nil || second.STX != "2" {
+		t.Fatalf("expected another band to be allowed with serial 2, got %q %v", second.STX, err)
+	}
+	// The client's own serial number is kept.
+	own := testContestQso("W1AW", "20m", "CW")
+	own.STX = "77"
+	if own, err = svc.insertQso(ctx, logbook, nil, "test", own, emptyString, emptyString, false); err != nil || own.STX != "77" {
+		t.Fatalf("expected the client's serial to be kept, got %q %v", own.STX, err)
+	}
+
+	drain()
+	if len(scores) != 3 {
+		t.Fatalf("expected a score event per contest QSO, got %d", len(scores))
+	}
+	// JA1XX twice at 3 points, W1AW in the logbook's own entity at 0; DXCC 339 and 291 and zones
+	// 25 and 5 are the multipliers.
+	score := scores[2]
+	if score.Qsos != 3 || score.Points != 6 || score.Multipliers != 4 || score.Score != 24 {
+		t.Errorf("unexpected score %+v", score)
+	}
+	if len(score.Bands) != 2 || score.Bands[0].Band != "40m" || score.Bands[1].Band != "20m" || score.Bands[1].Qsos != 2 {
+		t.Errorf("expected the bands from the longest wavelength, got %+v", score.Bands)
+	}
+
+	// A deleted QSO no longer counts, nor makes a dupe.
+	if _, err = svc.db.ExecContext(ctx, `UPDATE qso SET deleted_at = CURRENT_TIMESTAMP WHERE id = $1`, first.ID); err != nil {
+		t.Fatalf("delete failed: %v", err)
+	}
+	if _, err = svc.insertQso(ctx, logbook, nil, "test", testContestQso("JA1XX", "20m", "CW"), emptyString, emptyString, false); err != nil {
+		t.Errorf("expected a deleted QSO not to make a dupe, got %v", err)
+	}
+
+	// Once the contest ends QSOs are logged as usual.
+	if found, err := svc.endContest(ctx, logbook.ID); err != nil || !found {
+		t.Fatalf("endContest failed: %v %v", found, err)
+	}
+	after, err := svc.insertQso(ctx, logbook, nil, "test", testContestQso("JA1XX", "20m", "CW"), emptyString, emptyString, false)
+	if err != nil || after.STX != emptyString || after.ContestId != emptyString {
+		t.Errorf("expected a plain QSO after the contest, got %q %q %v", after.STX, after.ContestId, err)
+	}
+}
+
+func TestContestDupeRules(t *testing.T) {
+	for _, tc := range []struct {
+		dupes       string
+		band, mode  string
+		wantAllowed bool
+	}{
+		{contestDupesBandMode, "20m", "SSB", true},
+		{contestDupesBand, "20m", "SSB", false},
+		{contestDupesBand, "40m", "CW", true},
+		{contestDupesOnce, "40m", "SSB", false},
+	} {
+		t.Run(tc.dupes+"/"+tc.band+"/"+tc.mode, func(t *testing.T) {
+			svc, logbook := newContestTestServer(t)
+			ctx := context.Background()
+			if _, err := svc.startContest(ctx, logbook.ID, contestRequest{ContestID: "TEST", Rules: contestRules{Dupes: tc.dupes}}); err != nil {
+				t.Fatalf("startContest failed: %v", err)
+			}
+			if _, err := svc.insertQso(ctx, logbook, nil, "test", testContestQso("JA1XX", "20m", "CW"), emptyString, emptyString, false); err != nil {
+				t.Fatalf("insertQso failed: %v", err)
+			}
+			_, err := svc.insertQso(ctx, logbook, nil, "test", testContestQso("JA1XX", tc.band, tc.mode), emptyString, emptyString, false)
+			if allowed := err == nil; allowed != tc.wantAllowed {
+				t.Errorf("expected allowed=%v, got %v", tc.wantAllowed, err)
+			}
+		})
+	}
+}
+
+func TestBandMeters(t *testing.T) {
+	for band, want := range map[string]float64{"160m": 160, "20m": 20, "70cm": 0.7, "1.25m": 1.25, "6mm": 0.006, "bogus": 0} {
+		if got := bandMeters(band); got != want {
+			t.Errorf("bandMeters(%q) = %v, want %v", band, got, want)
+		}
+	}
+}
@@ -0,0 +1,98 @@
+package service
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"os"
+	"strings"
+
+	"github.com/Station-Manager/errors"
+)
+
+// envCredentialsKey names the environment variable holding the base64-encoded 32-byte key used to
+// encrypt third-party credentials (LoTW, eQSL, ...) at rest. Integrations that store credentials
+// are unavailable when it is not set.
+const envCredentialsKey = "SM_CREDENTIALS_KEY"
+
+// credentialCipherPrefix versions the stored format so the algorithm or key can be rotated later.
+const credentialCipherPrefix = "v1:"
+
+const errMsgNoCredentialsKey = "Credential encryption key is not configured."
+
+// loadCredentialsKey reads the credentials key from the environment. A missing key is not an
+// error; an invalid one is.
+func loadCredentialsKey() ([]byte, error) {
+	const op errors.Op = "server.loadCredentialsKey"
+
+	encoded := strings.TrimSpace(os.Getenv(envCredentialsKey))
+	if encoded == emptyString {
+		return nil, nil
+	}
+	key, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, errors.New(op).Err(err).Msg(envCredentialsKey + " is not valid base64")
+	}
+	if len(key) != 32 {
+		return nil, errors.New(op).Msg(envCredentialsKey + " must decode to 32 bytes")
+	}
+	return key, nil
+}
+
+func (s *Service) credentialsAEAD() (cipher.AEAD, error) {
+	const op errors.Op = "server.Service.credentialsAEAD"
+	if len(s.credentialsKey) == 0 {
+		return nil, errors.New(op).Msg(errMsgNoCredentialsKey)
+	}
+	block, err := aes.NewCipher(s.credentialsKey)
+	if err != nil {
+		return nil, errors.New(op).Err(err)
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, errors.New(op).Err(err)
+	}
+	return aead, nil
+}
+
+// encryptCredential seals plaintext with AES-256-GCM. The result is safe to store in a text column.
+func (s *Service) encryptCredential(plaintext string) (string, error) {
+	const op errors.Op = "server.Service.encryptCredential"
+
+	aead, err := s.credentialsAEAD()
+	if err != nil {
+		return emptyString, errors.New(op).Err(err)
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err = rand.Read(nonce); err != nil {
+		return emptyString, errors.New(op).Err(err)
+	}
+	sealed := aead.Seal(nonce, nonce, []byte(plaintext), nil)
+	return credentialCipherPrefix + base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+// decryptCredential reverses encryptCredential.
+func (s *Service) decryptCredential(ciphertext string) (string, error) {
+	const op errors.Op = "server.Service.decryptCredential"
+
+	aead, err := s.credentialsAEAD()
+	if err != nil {
+		return emptyString, errors.New(op).Err(err)
+	}
+	if !strings.HasPrefix(ciphertext, credentialCipherPrefix) {
+		return emptyString, errors.New(op).Msg("Unknown credential format")
+	}
+	sealed, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(ciphertext, credentialCipherPrefix))
+	if err != nil {
+		return emptyString, errors.New(op).Err(err)
+	}
+	if len(sealed) < aead.NonceSize() {
+		return emptyString, errors.New(op).Msg("Credential is truncated")
+	}
+	plaintext, err := aead.Open(nil, sealed[:aead.NonceSize()], sealed[aead.NonceSize():], nil)
+	if err != nil {
+		return emptyString, errors.New(op).Err(err)
+	}
+	return string(plaintext), nil
+}
@@ -0,0 +1,57 @@
+package service
+
+import (
+	"encoding/base64"
+	"strings"
+	"testing"
+)
+
+func TestCredentialEncryption_RoundTrip(t *testing.T) {
+	svc := &Service{credentialsKey: make([]byte, 32)}
+
+	sealed, err := svc.encryptCredential("hunter2")
+	if err != nil {
+		t.Fatalf("encryptCredential failed: %v", err)
+	}
+	if strings.Contains(sealed, "hunter2") || !strings.HasPrefix(sealed, credentialCipherPrefix) {
+		t.Fatalf("unexpected ciphertext %q", sealed)
+	}
+	again, _ := svc.encryptCredential("hunter2")
+	if again == sealed {
+		t.Error("expected a fresh nonce for each encryption")
+	}
+
+	plain, err := svc.decryptCredential(sealed)
+	if err != nil || plain != "hunter2" {
+		t.Fatalf("decryptCredential: got %q, %v", plain, err)
+	}
+
+	other := &Service{credentialsKey: []byte(strings.Repeat("k", 32))}
+	if _, err = other.decryptCredential(sealed); err == nil {
+		t.Error("expected decryption with a different key to fail")
+	}
+}
+
+func TestCredentialEncryption_RequiresKey(t *testing.T) {
+	svc := &Service{}
+	if _, err := svc.encryptCredential("hunter2"); err == nil {
+		t.Error("expected encryption without a key to fail")
+	}
+}
+
+func TestLoadCredentialsKey(t *testing.T) {
+	t.Setenv(envCredentialsKey, emptyString)
+	if key, err := loadCredentialsKey(); key != nil || err != nil {
+		t.Errorf("expected no key and no error when unset, got %v, %v", key, err)
+	}
+
+	t.Setenv(envCredentialsKey, base64.StdEncoding.EncodeToString(make([]byte, 16)))
+	if _, err := loadCredentialsKey(); err == nil {
+		t.Error("expected a short key to be rejected")
+	}
+
+	t.Setenv(envCredentialsKey, base64.StdEncoding.EncodeToString(make([]byte, 32)))
+	if key, err := loadCredentialsKey(); err != nil || len(key) != 32 {
+		t.Errorf("expected a 32-byte key, got %d bytes, %v", len(key), err)
+	}
+}
@@ -0,0 +1,221 @@
+// Package cty resolves callsigns to DXCC entities using the country files published by AD1C at
+// https://www.country-files.com in their CSV form (cty.csv).
+//
+// Each line describes one entity: primary prefix, name, ADIF entity number, continent, CQ zone,
+// ITU zone, latitude, longitude, UTC offset and a space-separated list of prefixes ending in ';'.
+// Prefixes use the cty.dat syntax: a leading '=' marks a full callsign, and (cq), [itu], {cont},
+// <lat/lon> and ~tz~ suffixes override the entity's values for that prefix. Entities whose primary
+// prefix starts with '*' are WAE-only and are ignored; their calls resolve to the parent entity.
+package cty
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// Entity is the result of resolving a callsign.
+type Entity struct {
+	Name      string
+	Prefix    string // primary prefix of the entity
+	DXCC      int    // ADIF entity number
+	Continent string
+	CQZone    int
+	ITUZone   int
+}
+
+// Database is an immutable set of prefixes and full callsigns. It is safe for concurrent use.
+type Database struct {
+	exact     map[string]Entity
+	prefixes  map[string]Entity
+	maxPrefix int
+	entities  []Entity
+}
+
+// Entities returns the number of entities loaded.
+func (d *Database) Entities() int {
+	return len(d.entities)
+}
+
+// List returns the entities in the order they appear in the file.
+func (d *Database) List() []Entity {
+	return append([]Entity(nil), d.entities...)
+}
+
+// Parse reads a cty.csv file.
+func Parse(r io.Reader) (*Database, error) {
+	db := &Database{exact: make(map[string]Entity), prefixes: make(map[string]Entity)}
+
+	sc := bufio.NewScanner(r)
+	sc.Buffer(make([]byte, 0, 64*1024), 1<<20)
+	line := 0
+	for sc.Scan() {
+		line++
+		text := strings.TrimSpace(sc.Text())
+		if text == "" {
+			continue
+		}
+		cols := strings.SplitN(text, ",", 10)
+		if len(cols) != 10 {
+			return nil, fmt.Errorf("cty: line %d: expected 10 columns, got %d", line, len(cols))
+		}
+		if strings.HasPrefix(cols[0], "*") {
+			continue
+		}
+
+		entity := Entity{Name: cols[1], Prefix: cols[0], Continent: cols[3]}
+		var err error
+		if entity.DXCC, err = strconv.Atoi(cols[2]); err != nil {
+			return nil, fmt.Errorf("cty: line %d: invalid entity number %q", line, cols[2])
+		}
+		if entity.CQZone, err = strconv.Atoi(cols[4]); err != nil {
+			return nil, fmt.Errorf("cty: line %d: invalid CQ zone %q", line, cols[4])
+		}
+		if entity.ITUZone, err = strconv.Atoi(cols[5]); err != nil {
+			return nil, fmt.Errorf("cty: line %d: invalid ITU zone %q", line, cols[5])
+		}
+
+		for _, token := range strings.Fields(strings.TrimSuffix(strings.TrimSpace(cols[9]), ";")) {
+			if err = db.add(entity, token); err != nil {
+				return nil, fmt.Errorf("cty: line %d: %w", line, err)
+			}
+		}
+		db.entities = append(db.entities, entity)
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+	if len(db.entities) == 0 {
+		return nil, fmt.Errorf("cty: no entities found")
+	}
+	return db, nil
+}
+
+// overrideClosers maps the opening character of each prefix override to its closing one.
+var overrideClosers = map[byte]byte{'(': ')', '[': ']', '<': '>', '{': '}', '~': '~'}
+
+// add registers one prefix token, applying its overrides to a copy of entity.
+func (d *Database) add(entity Entity, token string) error {
+	exact := strings.HasPrefix(token, "=")
+	token = strings.TrimPrefix(token, "=")
+
+	end := strings.IndexAny(token, "([<{~")
+	if end < 0 {
+		end = len(token)
+	}
+	key := strings.ToUpper(token[:end])
+	if key == "" {
+		return fmt.Errorf("empty prefix in %q", token)
+	}
+
+	for rest := token[end:]; rest != ""; {
+		closer := overrideClosers[rest[0]]
+		stop := strings.IndexByte(rest[1:], closer)
+		if closer == 0 || stop < 0 {
+			return fmt.Errorf("malformed override in %q", token)
+		}
+		value := rest[1 : stop+1]
+		switch rest[0] {
+		case '(':
+			n, err := strconv.Atoi(value)
+			if err != nil {
+				return fmt.Errorf("invalid CQ zone in %q", token)
+			}
+			entity.CQZone = n
+		case '[':
+			n, err := strconv.Atoi(value)
+			if err != nil {
+				return fmt.Errorf("invalid ITU zone in %q", token)
+			}
+			entity.ITUZone = n
+		case '{':
+			entity.Continent = value
+		}
+		rest = rest[stop+2:]
+	}
+
+	if exact {
+		d.exact[key] = entity
+		return nil
+	}
+	d.prefixes[key] = entity
+	if len(key) > d.maxPrefix {
+		d.maxPrefix = len(key)
+	}
+	return nil
+}
+
+// suffixes that describe how a station operates rather than where.
+var operatingSuffixes = map[string]bool{"P": true, "M": true, "QRP": true, "A": true, "B": true, "LH": true, "R": true}
+
+// Lookup resolves a callsign. Maritime and aeronautical mobile stations (/MM, /AM) have no entity.
+func (d *Database) Lookup(call string) (Entity, bool) {
+	call = strings.ToUpper(strings.TrimSpace(call))
+	if call == "" {
+		return Entity{}, false
+	}
+	if e, ok := d.exact[call]; ok {
+		return e, true
+	}
+
+	base := call
+	if strings.Contains(call, "/") {
+		var ok bool
+		if base, ok = d.locationPart(call); !ok {
+			return Entity{}, false
+		}
+		if e, ok := d.exact[base]; ok {
+			return e, true
+		}
+	}
+
+	for n := min(len(base), d.maxPrefix); n > 0; n-- {
+		if e, ok := d.prefixes[base[:n]]; ok {
+			return e, true
+		}
+	}
+	return Entity{}, false
+}
+
+// locationPart picks the part of a compound callsign that identifies where the station is, e.g.
+// VP2E in VP2E/K1AB or K1AB/VP2E, and K1AB in K1AB/P.
+func (d *Database) locationPart(call string) (string, bool) {
+	var parts []string
+	for _, p := range strings.Split(call, "/") {
+		switch {
+		case p == "":
+		case p == "MM" || p == "AM":
+			return "", false
+		case operatingSuffixes[p], isDigits(p):
+			// A lone call-area digit does not change the entity in the common cases.
+		default:
+			parts = append(parts, p)
+		}
+	}
+	switch len(parts) {
+	case 0:
+		return "", false
+	case 1:
+		return parts[0], true
+	}
+	// The shorter part is the location prefix; on a tie, one that is a prefix in its own right.
+	best := parts[0]
+	for _, p := range parts[1:] {
+		_, known := d.prefixes[p]
+		if len(p) < len(best) || (len(p) == len(best) && known) {
+			best = p
+		}
+	}
+	return best, true
+}
+
+func isDigits(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] < '0' || s[i] > '9' {
+			return false
+		}
+	}
+	return s != ""
+}
@@ -0,0 +1,60 @@
+package cty
+
+import (
+	"strings"
+	"testing"
+)
+
+const testCty = `1A,Sov Mil Order of Malta,246,EU,15,28,41.90,-12.43,-1.0,1A;
+K,United States,291,NA,5,8,37.53,91.67,5.0,AA AB K N W =W1AW(5)[8] KH6(31)[61]{OC} KL7(1)[1];
+KH6,Hawaii,110,OC,31,61,21.12,157.48,10.0,AH6 KH6 NH6 WH6 =K1HI;
+VP2E,Anguilla,12,NA,8,11,18.23,63.00,4.0,VP2E;
+*TA1,European Turkey,390,EU,20,39,41.02,-28.97,-2.0,TA1 =TA1ZZ;
+TA,Turkey,390,AS,20,39,39.18,-35.65,-2.0,TA TC YM;
+`
+
+func TestLookup(t *testing.T) {
+	db, err := Parse(strings.NewReader(testCty))
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+	if db.Entities() != 5 {
+		t.Errorf("expected 5 entities, got %d", db.Entities())
+	}
+
+	cases := []struct {
+		call     string
+		dxcc, cq int
+		cont     string
+	}{
+		{"k1ab", 291, 5, "NA"},
+		{"W6XYZ", 291, 5, "NA"},
+		{"KH6ABC", 110, 31, "OC"}, // the longer prefix wins over K
+		{"K1HI", 110, 31, "OC"},   // exact callsign
+		{"VP2E/K1AB", 12, 8, "NA"},
+		{"K1AB/VP2E", 12, 8, "NA"},
+		{"K1AB/P", 291, 5, "NA"},
+		{"TA1ZZ", 390, 20, "AS"}, // WAE entities resolve to the DXCC entity
+		{"KL7XX", 291, 1, "NA"},  // prefix override
+	}
+	for _, c := range cases {
+		e, ok := db.Lookup(c.call)
+		if !ok || e.DXCC != c.dxcc || e.CQZone != c.cq || e.Continent != c.cont {
+			t.Errorf("Lookup(%q): unexpected %+v (ok=%v)", c.call, e, ok)
+		}
+	}
+
+	for _, call := range []string{"K1AB/MM", "", "QQ1X"} {
+		if e, ok := db.Lookup(call); ok {
+			t.Errorf("Lookup(%q): expected no entity, got %+v", call, e)
+		}
+	}
+}
+
+func TestParse_Errors(t *testing.T) {
+	for _, input := range []string{"", "K,United States,291,NA,5,8;", "K,United States,x,NA,5,8,0,0,0,K;", "K,United States,291,NA,5,8,0,0,0,K(5;"} {
+		if _, err := Parse(strings.NewReader(input)); err == nil {
+			t.Errorf("expected an error for %q", input)
+		}
+	}
+}
@@ -0,0 +1,120 @@
+package service
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/Station-Manager/config"
+	"github.com/Station-Manager/database"
+	"github.com/Station-Manager/errors"
+	"github.com/Station-Manager/types"
+	"github.com/Station-Manager/utils"
+	"github.com/goccy/go-json"
+)
+
+const (
+	configFileName = "config.json"
+
+	// defaultDriver is written into a newly generated config.json when neither Options.Driver nor
+	// SM_DEFAULT_DB chooses one. An existing config.json is never rewritten.
+	defaultDriver = database.PostgresDriver
+
+	// defaultSqlitePath is where the SQLite database lives when the configuration names none,
+	// relative to the configuration directory.
+	defaultSqlitePath = "db/server.db"
+)
+
+// normalizeDriver maps a driver name, or one of its common aliases, to the name the database
+// module uses.
+func normalizeDriver(name string) (string, error) {
+	const op errors.Op = "server.normalizeDriver"
+
+	switch strings.ToLower(strings.TrimSpace(name)) {
+	case "postgres", "postgresql", "pg":
+		return database.PostgresDriver, nil
+	case "sqlite", "sqlite3":
+		return database.SqliteDriver, nil
+	default:
+		return emptyString, errors.New(op).Msgf("Unsupported database driver %q (want postgres or sqlite)", name)
+	}
+}
+
+// prepareConfigFile writes a default server configuration into workingDir if it has none, using
+// driver, SM_DEFAULT_DB or defaultDriver, in that order, to choose the datastore.
+func prepareConfigFile(workingDir, driver string) error {
+	const op errors.Op = "server.prepareConfigFile"
+
+	path := filepath.Join(workingDir, configFileName)
+	exists, err := utils.PathExists(path)
+	if err != nil {
+		return errors.New(op).Err(err)
+	}
+	if exists {
+		return nil
+	}
+
+	if driver == emptyString {
+		driver = os.Getenv(config.EnvSmDefaultDB)
+	}
+	if driver == emptyString {
+		driver = defaultDriver
+	}
+	if driver, err = normalizeDriver(driver); err != nil {
+		return errors.New(op).Err(err)
+	}
+
+	// Only the config module's PostgreSQL template includes a server section, so it is always the
+	// starting point; the datastore is swapped afterwards when SQLite was chosen.
+	previous, hadPrevious := os.LookupEnv(config.EnvSmDefaultDB)
+	_ = os.Setenv(config.EnvSmDefaultDB, database.PostgresDriver)
+	generated := &config.Service{WorkingDir: workingDir}
+	err = generated.Initialize()
+	if hadPrevious {
+		_ = os.Setenv(config.EnvSmDefaultDB, previous)
+	} else {
+		_ = os.Unsetenv(config.EnvSmDefaultDB)
+	}
+	if err != nil {
+		return errors.New(op).Err(err)
+	}
+	if driver == database.PostgresDriver {
+		return nil
+	}
+
+	useDriver(&generated.AppConfig.DatastoreConfig, driver)
+	data, err := json.MarshalIndent(generated.AppConfig, emptyString, "  ")
+	if err != nil {
+		return errors.New(op).Err(err)
+	}
+	if err = os.WriteFile(path, data, 0o600); err != nil {
+		return errors.New(op).Err(err)
+	}
+	return nil
+}
+
+// useDriver switches the datastore to driver, filling in the SQLite settings a PostgreSQL
+// configuration lacks.
+func useDriver(cfg *types.DatastoreConfig, driver string) {
+	cfg.Driver = driver
+	if driver != database.SqliteDriver {
+		return
+	}
+	if cfg.Path == emptyString {
+		cfg.Path = defaultSqlitePath
+	}
+	if cfg.Options == nil {
+		cfg.Options = map[string]string{"mode": "rwc", "_foreign_keys": "on", "_journal_mode": "WAL", "_busy_timeout": "5000"}
+	}
+}
+
+// resolveSqlitePath makes a relative SQLite path relative to the configuration directory rather
+// than to wherever the process was started.
+func resolveSqlitePath(cfg *types.DatastoreConfig, workingDir string) {
+	if cfg.Driver != database.SqliteDriver || cfg.Path == emptyString || cfg.Path == ":memory:" || strings.HasPrefix(cfg.Path, "file:") {
+		return
+	}
+	if !filepath.IsAbs(cfg.Path) {
+		cfg.Path = filepath.Join(workingDir, cfg.Path)
+	}
+}
@@ -0,0 +1,97 @@
+package service
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/Station-Manager/config"
+	"github.com/Station-Manager/database"
+	"github.com/Station-Manager/types"
+)
+
+func TestNormalizeDriver(t *testing.T) {
+	for name, want := range map[string]string{"pg": database.PostgresDriver, "PostgreSQL": database.PostgresDriver, "sqlite3": database.SqliteDriver} {
+		if got, err := normalizeDriver(name); err != nil || got != want {
+			t.Errorf("%s: expected %s, got %q (err=%v)", name, want, got, err)
+		}
+	}
+	if _, err := normalizeDriver("mysql"); err == nil {
+		t.Error("expected an unsupported driver to be rejected")
+	}
+}
+
+func TestPrepareConfigFile(t *testing.T) {
+	t.Setenv(config.EnvSmDefaultDB, "")
+
+	dir := t.TempDir()
+	if err := prepareConfigFile(dir, "sqlite"); err != nil {
+		t.Fatalf("prepareConfigFile failed: %v", err)
+	}
+	cfg := &config.Service{WorkingDir: dir}
+	if err := cfg.Initialize(); err != nil {
+		t.Fatalf("the generated configuration does not load: %v", err)
+	}
+	if got := cfg.AppConfig.DatastoreConfig; got.Driver != database.SqliteDriver || got.Path != defaultSqlitePath {
+		t.Errorf("expected a SQLite datastore, got %+v", got)
+	}
+	if cfg.AppConfig.ServerConfig == nil {
+		t.Error("expected the generated configuration to have a server section")
+	}
+	if os.Getenv(config.EnvSmDefaultDB) != "" {
+		t.Error("expected SM_DEFAULT_DB to be restored")
+	}
+
+	// An existing file is left alone whatever driver is asked for.
+	before, _ := os.ReadFile(filepath.Join(dir, configFileName))
+	if err := prepareConfigFile(dir, "postgres"); err != nil {
+		t.Fatalf("prepareConfigFile failed: %v", err)
+	}
+	if after, _ := os.ReadFile(filepath.Join(dir, configFileName)); string(after) != string(before) {
+		t.Error("expected the existing configuration to be kept")
+	}
+
+	t.Setenv(config.EnvSmDefaultDB, "sqlite")
+	dir = t.TempDir()
+	if err := prepareConfigFile(dir, ""); err != nil {
+		t.Fatalf("prepareConfigFile failed: %v", err)
+	}
+	cfg = &config.Service{WorkingDir: dir}
+	if err := cfg.Initialize(); err != nil || cfg.AppConfig.DatastoreConfig.Driver != database.SqliteDriver {
+		t.Errorf("expected SM_DEFAULT_DB to choose the driver, got %q (err=%v)", cfg.AppConfig.DatastoreConfig.Driver, err)
+	}
+}
+
+func TestResolveSqlitePath(t *testing.T) {
+	cases := map[string]string{
+		"db/server.db":     "/etc/sm/db/server.db",
+		"/var/lib/sm.db":   "/var/lib/sm.db",
+		":memory:":         ":memory:",
+		"file:x.db?mode=m": "file:x.db?mode=m",
+	}
+	for path, want := range cases {
+		cfg := types.DatastoreConfig{Driver: database.SqliteDriver, Path: path}
+		resolveSqlitePath(&cfg, "/etc/sm")
+		if cfg.Path != want {
+			t.Errorf("%s: expected %s, got %s", path, want, cfg.Path)
+		}
+	}
+}
+
+func TestSqliteUsersSchema(t *testing.T) {
+	svc := newTestServer(t)
+	ctx := context.Background()
+
+	user, err := svc.db.InsertUserContext(ctx, types.User{Callsign: "W1AW", PassHash: "hash"})
+	if err != nil {
+		t.Fatalf("InsertUserContext failed: %v", err)
+	}
+	fetched, err := svc.db.FetchUserByCallsignContext(ctx, "W1AW")
+	if err != nil || fetched.ID != user.ID || fetched.PassHash != "hash" {
+		t.Fatalf("expected the user back, got %+v (err=%v)", fetched, err)
+	}
+	if _, err = svc.db.ExecContext(ctx, `UPDATE logbook SET user_id = $1 WHERE id = 1`, user.ID); err != nil {
+		t.Errorf("expected logbooks to record their owner: %v", err)
+	}
+}
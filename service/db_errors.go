@@ -0,0 +1,98 @@
+package service
+
+import (
+	"context"
+	stderr "errors"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/lib/pq"
+)
+
+// dbErrorKind is the kind of a failed database operation, as far as the client is concerned.
+type dbErrorKind int
+
+const (
+	// dbErrorOther: the server failed.
+	dbErrorOther dbErrorKind = iota
+	// dbErrorUnique: the write would duplicate the key of another row.
+	dbErrorUnique
+	// dbErrorForeignKey: the write refers to a row that does not exist, or removes one that is
+	// still referred to.
+	dbErrorForeignKey
+	// dbErrorUnavailable: the database timed out, was busy, or could not be reached; the request
+	// may be retried.
+	dbErrorUnavailable
+)
+
+// dbErrorResponses are the responses to requests that failed with each kind of database error
+// other than dbErrorOther.
+var dbErrorResponses = map[dbErrorKind]struct {
+	status  int
+	code    errorCode
+	message string
+}{
+	dbErrorUnique:      {fiber.StatusConflict, errCodeConflict, "The request conflicts with an existing record"},
+	dbErrorForeignKey:  {fiber.StatusUnprocessableEntity, errCodeInvalidReference, "The request refers to a record that does not exist or is still in use"},
+	dbErrorUnavailable: {fiber.StatusServiceUnavailable, errCodeTimeout, "The database did not answer in time"},
+}
+
+// classifyDBError returns the kind of the error a database operation failed with, in either
+// dialect.
+func classifyDBError(err error) dbErrorKind {
+	if err == nil {
+		return dbErrorOther
+	}
+
+	var pqErr *pq.Error
+	if stderr.As(err, &pqErr) {
+		switch {
+		case pqErr.Code == "23505": // unique_violation
+			return dbErrorUnique
+		case pqErr.Code == "23503": // foreign_key_violation
+			return dbErrorForeignKey
+		case pqErr.Code == "57014", pqErr.Code == "55P03": // query_canceled by statement_timeout, lock_not_available
+			return dbErrorUnavailable
+		}
+	}
+	if stderr.Is(err, context.DeadlineExceeded) || isTransientDBError(err) {
+		return dbErrorUnavailable
+	}
+
+	// The SQLite driver's errors are matched by message, as isTxConflict does.
+	for ; err != nil; err = stderr.Unwrap(err) {
+		msg := err.Error()
+		switch {
+		case strings.Contains(msg, "UNIQUE constraint failed"):
+			return dbErrorUnique
+		case strings.Contains(msg, "FOREIGN KEY constraint failed"):
+			return dbErrorForeignKey
+		}
+	}
+	return dbErrorOther
+}
+
+// dbErrorResponse returns the status and body of the response to a request that failed with the
+// database error, and false if the error is the server's own fault.
+func dbErrorResponse(err error) (int, fiber.Map, bool) {
+	response, ok := dbErrorResponses[classifyDBError(err)]
+	if !ok {
+		return 0, nil, false
+	}
+	return response.status, jsonError(response.code, response.message), true
+}
+
+// dbFailure answers a request whose database operation failed with err: 409 for a unique
+// violation, 422 for a foreign key violation, 503 when the database timed out or was unavailable,
+// and 500 otherwise. Handlers log the failure before calling it.
+func (s *Service) dbFailure(c *fiber.Ctx, err error) error {
+	if status, body, ok := dbErrorResponse(err); ok {
+		return c.Status(status).JSON(body)
+	}
+	return c.Status(fiber.StatusInternalServerError).JSON(jsonInternalError)
+}
+
+// isUniqueViolation reports whether err is a unique constraint violation in either dialect.
+func isUniqueViolation(err error) bool {
+	return classifyDBError(err) == dbErrorUnique
+}
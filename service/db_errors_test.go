@@ -0,0 +1,53 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/Station-Manager/errors"
+	"github.com/gofiber/fiber/v2"
+	"github.com/lib/pq"
+)
+
+func TestClassifyDBError(t *testing.T) {
+	svc := newTestServer(t)
+	ctx := context.Background()
+
+	_, unique := svc.db.ExecContext(ctx, `INSERT INTO logbook (id, name, callsign) VALUES (1, 'Again', 'K1AB')`)
+	_, foreignKey := svc.db.ExecContext(ctx, `INSERT INTO webhooks (logbook_id, url, secret, events) VALUES (99, 'https://example.com', 's', 'qso.created')`)
+
+	for _, tc := range []struct {
+		description string
+		err         error
+		want        dbErrorKind
+	}{
+		{"a SQLite unique violation", unique, dbErrorUnique},
+		{"a SQLite foreign key violation", foreignKey, dbErrorForeignKey},
+		{"a PostgreSQL unique violation", errors.New("test").Err(&pq.Error{Code: "23505"}), dbErrorUnique},
+		{"a PostgreSQL foreign key violation", &pq.Error{Code: "23503"}, dbErrorForeignKey},
+		{"a statement timeout", &pq.Error{Code: "57014"}, dbErrorUnavailable},
+		{"a request deadline", fmt.Errorf("query: %w", context.DeadlineExceeded), dbErrorUnavailable},
+		{"a busy SQLite database", fmt.Errorf("database is locked (5) (SQLITE_BUSY)"), dbErrorUnavailable},
+		{"a syntax error", &pq.Error{Code: "42601"}, dbErrorOther},
+		{"no error", nil, dbErrorOther},
+	} {
+		if got := classifyDBError(tc.err); got != tc.want {
+			t.Errorf("%s: expected kind %d, got %d (err=%v)", tc.description, tc.want, got, tc.err)
+		}
+	}
+
+	app := fiber.New(fiber.Config{ErrorHandler: svc.jsonErrorHandler})
+	app.Get("/unique", func(c *fiber.Ctx) error { return unique })
+	app.Get("/fk", func(c *fiber.Ctx) error { return foreignKey })
+	for path, want := range map[string]int{"/unique": fiber.StatusConflict, "/fk": fiber.StatusUnprocessableEntity} {
+		resp, err := app.Test(httptest.NewRequest(fiber.MethodGet, path, nil))
+		if err != nil {
+			t.Fatalf("GET %s failed: %v", path, err)
+		}
+		if resp.StatusCode != want {
+			t.Errorf("GET %s: expected %d, got %d", path, want, resp.StatusCode)
+		}
+	}
+}
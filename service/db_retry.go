@@ -0,0 +1,156 @@
+package service
+
+import (
+	"context"
+	"database/sql/driver"
+	stderr "errors"
+	"io"
+	"math/rand/v2"
+	"net"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/Station-Manager/types"
+	"github.com/lib/pq"
+)
+
+const (
+	defaultDBRetryAttempts  = 3
+	defaultDBRetryBaseDelay = 50 * time.Millisecond
+	defaultDBRetryMaxDelay  = time.Second
+)
+
+// retryPolicy retries database operations that failed for a transient reason. The zero value
+// makes a single attempt.
+type retryPolicy struct {
+	attempts  int
+	baseDelay time.Duration
+	maxDelay  time.Duration
+}
+
+var defaultDBRetryPolicy = retryPolicy{
+	attempts:  defaultDBRetryAttempts,
+	baseDelay: defaultDBRetryBaseDelay,
+	maxDelay:  defaultDBRetryMaxDelay,
+}
+
+// do calls fn until it succeeds, fails with an error retryable rejects, the attempts run out or
+// ctx ends, and returns its last error.
+func (p retryPolicy) do(ctx context.Context, retryable func(error) bool, fn func() error) error {
+	for attempt := 1; ; attempt++ {
+		err := fn()
+		if err == nil || attempt >= p.attempts || !retryable(err) {
+			return err
+		}
+
+		timer := time.NewTimer(p.delay(attempt))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return err
+		case <-timer.C:
+		}
+	}
+}
+
+// delay returns a random wait before the next attempt, up to an exponentially growing bound, so
+// that clients failing together do not retry together.
+func (p retryPolicy) delay(attempt int) time.Duration {
+	bound := p.baseDelay
+	for i := 1; i < attempt && bound < p.maxDelay; i++ {
+		bound *= 2
+	}
+	if bound > p.maxDelay {
+		bound = p.maxDelay
+	}
+	if bound <= 0 {
+		return 0
+	}
+	return rand.N(bound) + 1
+}
+
+// retryValue is do for operations returning a value.
+func retryValue[T any](ctx context.Context, p retryPolicy, fn func() (T, error)) (T, error) {
+	var value T
+	err := p.do(ctx, isTransientDBError, func() error {
+		var err error
+		value, err = fn()
+		return err
+	})
+	return value, err
+}
+
+// isTransientDBError reports whether an operation that failed with err may succeed if tried
+// again: connection failures, server restarts and failovers, lock conflicts and busy databases.
+func isTransientDBError(err error) bool {
+	if err == nil || stderr.Is(err, context.Canceled) || stderr.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+	if isTxConflict(err) {
+		return true
+	}
+
+	var pqErr *pq.Error
+	if stderr.As(err, &pqErr) {
+		switch {
+		case pqErr.Code.Class() == "08": // connection_exception
+			return true
+		case pqErr.Code == "57P01", pqErr.Code == "57P02", pqErr.Code == "57P03": // shutdowns, cannot_connect_now
+			return true
+		case pqErr.Code == "25006": // read_only_sql_transaction, seen during a failover
+			return true
+		}
+		return false
+	}
+
+	var netErr net.Error
+	if stderr.Is(err, driver.ErrBadConn) || stderr.Is(err, io.EOF) || stderr.Is(err, io.ErrUnexpectedEOF) ||
+		stderr.Is(err, syscall.ECONNRESET) || stderr.Is(err, syscall.ECONNREFUSED) || stderr.As(err, &netErr) {
+		return true
+	}
+	return false
+}
+
+// isTxConflict reports whether a transaction failed because it conflicted with another one, in
+// which case nothing it did was committed and it can safely be run again.
+func isTxConflict(err error) bool {
+	var pqErr *pq.Error
+	if stderr.As(err, &pqErr) {
+		return pqErr.Code == "40001" || pqErr.Code == "40P01" // serialization_failure, deadlock_detected
+	}
+	for ; err != nil; err = stderr.Unwrap(err) {
+		msg := err.Error()
+		if strings.Contains(msg, "SQLITE_BUSY") || strings.Contains(msg, "database is locked") {
+			return true
+		}
+	}
+	return false
+}
+
+// retryingStore retries the read-only store operations when they fail transiently. Writes are
+// passed through: whether a failed write took effect is unknown, so retrying it is not safe.
+type retryingStore struct {
+	store
+	policy retryPolicy
+}
+
+func newRetryingStore(db store, policy retryPolicy) *retryingStore {
+	return &retryingStore{store: db, policy: policy}
+}
+
+func (r *retryingStore) FetchUserByCallsignContext(ctx context.Context, callsign string) (types.User, error) {
+	return retryValue(ctx, r.policy, func() (types.User, error) { return r.store.FetchUserByCallsignContext(ctx, callsign) })
+}
+
+func (r *retryingStore) FetchAPIKeyByPrefixContext(ctx context.Context, prefix string) (types.ApiKey, error) {
+	return retryValue(ctx, r.policy, func() (types.ApiKey, error) { return r.store.FetchAPIKeyByPrefixContext(ctx, prefix) })
+}
+
+func (r *retryingStore) FetchLogbookByIDContext(ctx context.Context, id int64) (types.Logbook, error) {
+	return retryValue(ctx, r.policy, func() (types.Logbook, error) { return r.store.FetchLogbookByIDContext(ctx, id) })
+}
+
+func (r *retryingStore) FetchQsoByIdContext(ctx context.Context, id int64) (types.Qso, error) {
+	return retryValue(ctx, r.policy, func() (types.Qso, error) { return r.store.FetchQsoByIdContext(ctx, id) })
+}
@@ -0,0 +1,113 @@
+package service
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	stderr "errors"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/Station-Manager/errors"
+	"github.com/Station-Manager/types"
+	"github.com/lib/pq"
+)
+
+var testRetryPolicy = retryPolicy{attempts: 3, baseDelay: time.Millisecond, maxDelay: 2 * time.Millisecond}
+
+func TestIsTransientDBError(t *testing.T) {
+	cases := []struct {
+		err  error
+		want bool
+	}{
+		{&pq.Error{Code: "40001"}, true},
+		{&pq.Error{Code: "08006"}, true},
+		{&pq.Error{Code: "57P01"}, true},
+		{&pq.Error{Code: "23505"}, false},
+		{errors.New("test").Err(driver.ErrBadConn), true},
+		{errors.New("test").Err(syscall.ECONNRESET), true},
+		{errors.New("test").Err(stderr.New("database is locked (5) (SQLITE_BUSY)")), true},
+		{errors.New("test").Err(sql.ErrNoRows), false},
+		{context.DeadlineExceeded, false},
+	}
+	for _, c := range cases {
+		if got := isTransientDBError(c.err); got != c.want {
+			t.Errorf("%v: got %v, want %v", c.err, got, c.want)
+		}
+	}
+	if isTxConflict(&pq.Error{Code: "08006"}) {
+		t.Error("expected a connection failure not to count as a transaction conflict")
+	}
+}
+
+func TestRetryPolicy_Delay(t *testing.T) {
+	p := retryPolicy{attempts: 5, baseDelay: 10 * time.Millisecond, maxDelay: 30 * time.Millisecond}
+	for attempt, bound := range map[int]time.Duration{1: 10 * time.Millisecond, 2: 20 * time.Millisecond, 4: 30 * time.Millisecond} {
+		for i := 0; i < 20; i++ {
+			if d := p.delay(attempt); d <= 0 || d > bound {
+				t.Fatalf("attempt %d: delay %v outside (0, %v]", attempt, d, bound)
+			}
+		}
+	}
+}
+
+// flakyStore fails a number of fetches before succeeding.
+type flakyStore struct {
+	store
+	failures int
+	err      error
+	calls    int
+}
+
+func (f *flakyStore) FetchLogbookByIDContext(_ context.Context, id int64) (types.Logbook, error) {
+	f.calls++
+	if f.calls <= f.failures {
+		return types.Logbook{}, f.err
+	}
+	return types.Logbook{ID: id}, nil
+}
+
+func TestRetryingStore(t *testing.T) {
+	flaky := &flakyStore{failures: 2, err: driver.ErrBadConn}
+	lb, err := newRetryingStore(flaky, testRetryPolicy).FetchLogbookByIDContext(context.Background(), 3)
+	if err != nil || lb.ID != 3 || flaky.calls != 3 {
+		t.Errorf("expected success on the third attempt, got %+v (err=%v, calls=%d)", lb, err, flaky.calls)
+	}
+
+	flaky = &flakyStore{failures: 5, err: driver.ErrBadConn}
+	if _, err = newRetryingStore(flaky, testRetryPolicy).FetchLogbookByIDContext(context.Background(), 3); err == nil || flaky.calls != 3 {
+		t.Errorf("expected to give up after 3 attempts, got err=%v after %d calls", err, flaky.calls)
+	}
+
+	flaky = &flakyStore{failures: 1, err: sql.ErrNoRows}
+	if _, err = newRetryingStore(flaky, testRetryPolicy).FetchLogbookByIDContext(context.Background(), 3); err == nil || flaky.calls != 1 {
+		t.Errorf("expected a permanent error not to be retried, got err=%v after %d calls", err, flaky.calls)
+	}
+}
+
+func TestWithTx_RetriesConflicts(t *testing.T) {
+	svc := newTestServer(t)
+	svc.dbRetry = testRetryPolicy
+
+	attempts := 0
+	err := svc.withTx(context.Background(), func(tx *sql.Tx) error {
+		attempts++
+		if attempts == 1 {
+			return &pq.Error{Code: "40001"}
+		}
+		return nil
+	})
+	if err != nil || attempts != 2 {
+		t.Errorf("expected a conflicting transaction to be run again, got err=%v after %d attempts", err, attempts)
+	}
+
+	attempts = 0
+	_ = svc.withTx(context.Background(), func(tx *sql.Tx) error {
+		attempts++
+		return &pq.Error{Code: "23505"}
+	})
+	if attempts != 1 {
+		t.Errorf("expected a constraint violation not to be retried, got %d attempts", attempts)
+	}
+}
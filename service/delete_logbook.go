@@ -0,0 +1,319 @@
+package service
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Station-Manager/errors"
+	"github.com/Station-Manager/types"
+	"github.com/gofiber/fiber/v2"
+)
+
+// deleteLogbookAction is the action of the logbook delete request. The types module predates it.
+const deleteLogbookAction types.RequestAction = "delete_logbook"
+
+// logbookDeleteMode is what deleting a logbook does with it.
+type logbookDeleteMode string
+
+const (
+	// logbookDeleteTrash moves the logbook to the trash, from which it can be restored.
+	logbookDeleteTrash logbookDeleteMode = "trash"
+	// logbookDeleteArchive keeps the logbook but makes it read-only.
+	logbookDeleteArchive logbookDeleteMode = "archive"
+	// logbookDeletePurge permanently deletes the logbook with its QSOs and API keys.
+	logbookDeletePurge logbookDeleteMode = "purge"
+)
+
+// logbookDeleteConfirmTTL is how long a confirmation token for archiving or purging a logbook stays
+// valid.
+const logbookDeleteConfirmTTL = 5 * time.Minute
+
+// logbookDeleteOptions are the fields of the delete request that the request envelope lacks.
+type logbookDeleteOptions struct {
+	Mode         logbookDeleteMode `json:"mode"`
+	Confirmation string            `json:"confirmation"`
+}
+
+// deleteLogbookHandler deletes one of the authenticated user's logbooks. By default the logbook is
+// moved to the trash; its API keys stop working until it is restored.
+//
+// Archiving and purging cannot be undone from the trash, so they take two requests, both
+// authenticated with the password: the first returns a confirmation token bound to the user, the
+// logbook and the mode, and the second carries it back to perform the action.
+func (s *Service) deleteLogbookHandler(c *fiber.Ctx) error {
+	const op errors.Op = "server.Service.deleteLogbookHandler"
+
+	reqCtx, err := getRequestContext(c)
+	if err != nil || reqCtx.User == nil {
+		err = errors.New(op).Err(err).Msg("Request context missing")
+		s.logger.ErrorWith().Err(err).Msg("getRequestContext failed")
+		return c.Status(fiber.StatusInternalServerError).JSON(jsonInternalError)
+	}
+	options := reqCtx.Request.logbookDeleteOptions
+	switch options.Mode {
+	case emptyString, logbookDeleteTrash:
+		return s.setLogbookDeletedHandler(c, true)
+	case logbookDeleteArchive, logbookDeletePurge:
+	default:
+		return c.Status(fiber.StatusBadRequest).JSON(jsonBadRequest)
+	}
+
+	if reqCtx.Request.Logbook == nil || reqCtx.Request.Logbook.ID <= 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(jsonBadRequest)
+	}
+	user, logbookID := *reqCtx.User, reqCtx.Request.Logbook.ID
+	ctx := c.UserContext()
+
+	qsos, found, err := s.countOwnedLogbookQsos(ctx, user.ID, logbookID)
+	if err != nil {
+		s.logger.ErrorWith().Err(errors.New(op).Err(err)).Int64("logbook_id", logbookID).Msg("s.countOwnedLogbookQsos failed")
+		return s.dbFailure(c, err)
+	}
+	if !found {
+		return c.Status(fiber.StatusNotFound).JSON(jsonError(errCodeLogbookNotFound, "Logbook not found"))
+	}
+
+	now := time.Now()
+	if options.Confirmation == emptyString {
+		expires := now.Add(logbookDeleteConfirmTTL).Truncate(time.Second)
+		return c.Status(fiber.StatusAccepted).JSON(fiber.Map{
+			"action":       string(deleteLogbookAction),
+			"mode":         options.Mode,
+			"qsos":         qsos,
+			"confirmation": logbookDeleteToken(user, logbookID, options.Mode, expires),
+			"expires_at":   expires.UTC().Format(time.RFC3339),
+		})
+	}
+	if !validLogbookDeleteToken(options.Confirmation, user, logbookID, options.Mode, now) {
+		return c.Status(fiber.StatusBadRequest).JSON(jsonError(errCodeInvalidConfirmation, "The confirmation token is invalid or has expired"))
+	}
+
+	switch options.Mode {
+	case logbookDeleteArchive:
+		found, err = s.setLogbookArchived(ctx, user.ID, logbookID, true)
+	case logbookDeletePurge:
+		found, err = s.purgeLogbook(ctx, user.ID, logbookID)
+	}
+	if err != nil {
+		s.logger.ErrorWith().Err(errors.New(op).Err(err)).Int64("logbook_id", logbookID).Str("mode", string(options.Mode)).Msg("Failed to delete logbook")
+		return c.Status(fiber.StatusInternalServerError).JSON(jsonInternalError)
+	}
+	if !found {
+		return c.Status(fiber.StatusNotFound).JSON(jsonError(errCodeLogbookNotFound, "Logbook not found"))
+	}
+
+	s.invalidateLogbook(ctx, logbookID)
+	s.logger.InfoWith().Int64("logbook_id", logbookID).Str("mode", string(options.Mode)).Int64("qsos", qsos).Msg("Logbook deleted")
+	s.audit(c, auditEntry{Event: auditLogbookDeleted, Actor: reqCtx.Actor, Callsign: user.Callsign, LogbookID: auditLogbookID(logbookID)},
+		fiber.Map{"mode": options.Mode, "qsos": qsos})
+
+	return c.SendStatus(fiber.StatusNoContent)
+}
+
+// unarchiveLogbookHandler makes one of the authenticated user's archived logbooks writable again.
+func (s *Service) unarchiveLogbookHandler(c *fiber.Ctx) error {
+	const op errors.Op = "server.Service.unarchiveLogbookHandler"
+
+	reqCtx, err := getRequestContext(c)
+	if err != nil || reqCtx.User == nil {
+		err = errors.New(op).Err(err).Msg("Request context missing")
+		s.logger.ErrorWith().Err(err).Msg("getRequestContext failed")
+		return c.Status(fiber.StatusInternalServerError).JSON(jsonInternalError)
+	}
+	if reqCtx.Request.Logbook == nil || reqCtx.Request.Logbook.ID <= 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(jsonBadRequest)
+	}
+	logbookID := reqCtx.Request.Logbook.ID
+
+	found, err := s.setLogbookArchived(c.UserContext(), reqCtx.User.ID, logbookID, false)
+	if err != nil {
+		s.logger.ErrorWith().Err(errors.New(op).Err(err)).Int64("logbook_id", logbookID).Msg("s.setLogbookArchived failed")
+		return s.dbFailure(c, err)
+	}
+	if !found {
+		return c.Status(fiber.StatusNotFound).JSON(jsonError(errCodeLogbookNotFound, "Archived logbook not found"))
+	}
+	s.logger.InfoWith().Int64("logbook_id", logbookID).Msg("Logbook unarchived")
+
+	return c.SendStatus(fiber.StatusNoContent)
+}
+
+// writableLogbookMiddleware rejects requests that would change an archived logbook. It must run
+// after one of the API key middlewares.
+func (s *Service) writableLogbookMiddleware() fiber.Handler {
+	const op errors.Op = "server.Service.writableLogbookMiddleware"
+	if s == nil {
+		return serverErrorHandler()
+	}
+
+	return func(c *fiber.Ctx) error {
+		logbook, err := authenticatedLogbook(c)
+		if err != nil {
+			s.logger.ErrorWith().Err(errors.New(op).Err(err)).Msg("authenticatedLogbook failed")
+			return c.Status(fiber.StatusInternalServerError).JSON(jsonInternalError)
+		}
+		archived, err := s.isLogbookArchived(c.UserContext(), logbook.ID)
+		if err != nil {
+			s.logger.ErrorWith().Err(errors.New(op).Err(err)).Int64("logbook_id", logbook.ID).Msg("s.isLogbookArchived failed")
+			return s.dbFailure(c, err)
+		}
+		if archived {
+			return c.Status(fiber.StatusForbidden).JSON(jsonError(errCodeLogbookArchived, "The logbook is archived and read-only"))
+		}
+		return c.Next()
+	}
+}
+
+// logbookDeleteToken returns a confirmation token for deleting the logbook in the given mode. The
+// token is signed with the user's password hash, so it needs no server-side state, works on every
+// instance and stops working when the password changes.
+func logbookDeleteToken(user types.User, logbookID int64, mode logbookDeleteMode, expires time.Time) string {
+	exp := strconv.FormatInt(expires.Unix(), 10)
+	mac := hmac.New(sha256.New, []byte(user.PassHash))
+	mac.Write([]byte(string(deleteLogbookAction) + ":" + strconv.FormatInt(user.ID, 10) + ":" +
+		strconv.FormatInt(logbookID, 10) + ":" + string(mode) + ":" + exp))
+	return exp + "." + hex.EncodeToString(mac.Sum(nil))
+}
+
+// validLogbookDeleteToken reports whether token confirms deleting the logbook in the given mode and
+// has not expired.
+func validLogbookDeleteToken(token string, user types.User, logbookID int64, mode logbookDeleteMode, now time.Time) bool {
+	exp, _, ok := strings.Cut(token, ".")
+	if !ok {
+		return false
+	}
+	unix, err := strconv.ParseInt(exp, 10, 64)
+	if err != nil || now.After(time.Unix(unix, 0)) {
+		return false
+	}
+	want := logbookDeleteToken(user, logbookID, mode, time.Unix(unix, 0))
+	return hmac.Equal([]byte(token), []byte(want))
+}
+
+// countOwnedLogbookQsos returns the number of QSOs, including those in the trash, of a logbook
+// owned by the user. It reports false when the logbook is not the user's.
+func (s *Service) countOwnedLogbookQsos(ctx context.Context, userID, logbookID int64) (int64, bool, error) {
+	const op errors.Op = "server.Service.countOwnedLogbookQsos"
+
+	rows, err := s.db.QueryContext(ctx, `SELECT (SELECT COUNT(*) FROM qso WHERE logbook_id = l.id)
+		FROM logbook l WHERE l.id = $1 AND l.user_id = $2`, logbookID, userID)
+	if err != nil {
+		return 0, false, errors.New(op).Err(err)
+	}
+	defer func() { _ = rows.Close() }()
+
+	var n int64
+	found := rows.Next()
+	if found {
+		if err = rows.Scan(&n); err != nil {
+			return 0, false, errors.New(op).Err(err)
+		}
+	}
+	if err = rows.Err(); err != nil {
+		return 0, false, errors.New(op).Err(err)
+	}
+	return n, found, nil
+}
+
+// setLogbookArchived archives or unarchives a logbook owned by the user. Logbooks in the trash
+// cannot be archived. It reports false when the logbook is not the user's or not in the expected
+// state.
+func (s *Service) setLogbookArchived(ctx context.Context, userID, logbookID int64, archived bool) (bool, error) {
+	const op errors.Op = "server.Service.setLogbookArchived"
+
+	query := `UPDATE logbook SET archived_at = CURRENT_TIMESTAMP WHERE id = $1 AND user_id = $2 AND archived_at IS NULL AND deleted_at IS NULL`
+	if !archived {
+		query = `UPDATE logbook SET archived_at = NULL WHERE id = $1 AND user_id = $2 AND archived_at IS NOT NULL`
+	}
+	res, err := s.db.ExecContext(ctx, query, logbookID, userID)
+	if err != nil {
+		return false, errors.New(op).Err(err)
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return false, errors.New(op).Err(err)
+	}
+	return n > 0, nil
+}
+
+// isLogbookArchived reports whether the logbook is archived.
+func (s *Service) isLogbookArchived(ctx context.Context, logbookID int64) (bool, error) {
+	const op errors.Op = "server.Service.isLogbookArchived"
+
+	rows, err := s.db.QueryContext(ctx, `SELECT COUNT(*) FROM logbook WHERE id = $1 AND archived_at IS NOT NULL`, logbookID)
+	if err != nil {
+		return false, errors.New(op).Err(err)
+	}
+	defer func() { _ = rows.Close() }()
+
+	var n int
+	if rows.Next() {
+		if err = rows.Scan(&n); err != nil {
+			return false, errors.New(op).Err(err)
+		}
+	}
+	if err = rows.Err(); err != nil {
+		return false, errors.New(op).Err(err)
+	}
+	return n > 0, nil
+}
+
+// purgeLogbook permanently deletes a logbook owned by the user, with its QSOs and API keys, in one
+// transaction and drops its API keys from the cache. It reports false when the logbook is not the
+// user's.
+func (s *Service) purgeLogbook(ctx context.Context, userID, logbookID int64) (bool, error) {
+	const op errors.Op = "server.Service.purgeLogbook"
+
+	var (
+		found    bool
+		prefixes []string
+	)
+	err := s.withTx(ctx, func(tx *sql.Tx) error {
+		found, prefixes = false, nil
+
+		if txErr := queryEach(ctx, tx, func(rows *sql.Rows) error {
+			var prefix string
+			err := rows.Scan(&prefix)
+			prefixes = append(prefixes, prefix)
+			return err
+		}, `SELECT key_prefix FROM api_keys WHERE logbook_id IN (SELECT id FROM logbook WHERE id = $1 AND user_id = $2)`, logbookID, userID); txErr != nil {
+			return txErr
+		}
+
+		// SQLite's qso table restricts deleting a logbook that still has QSOs, so delete bottom-up.
+		for _, query := range []string{
+			`DELETE FROM qso WHERE logbook_id IN (SELECT id FROM logbook WHERE id = $1 AND user_id = $2)`,
+			`DELETE FROM api_keys WHERE logbook_id IN (SELECT id FROM logbook WHERE id = $1 AND user_id = $2)`,
+		} {
+			if _, txErr := tx.ExecContext(ctx, query, logbookID, userID); txErr != nil {
+				return txErr
+			}
+		}
+		res, txErr := tx.ExecContext(ctx, `DELETE FROM logbook WHERE id = $1 AND user_id = $2`, logbookID, userID)
+		if txErr != nil {
+			return txErr
+		}
+		n, txErr := res.RowsAffected()
+		if txErr != nil {
+			return txErr
+		}
+		found = n > 0
+		sandboxPrefixes, txErr := purgeOrphanSandboxes(ctx, tx)
+		prefixes = append(prefixes, sandboxPrefixes...)
+		return txErr
+	})
+	if err != nil {
+		return false, errors.New(op).Err(err)
+	}
+
+	for _, prefix := range prefixes {
+		s.invalidateApiKey(ctx, prefix)
+	}
+	return found, nil
+}
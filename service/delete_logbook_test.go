@@ -0,0 +1,120 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/Station-Manager/types"
+	"github.com/goccy/go-json"
+	"github.com/gofiber/fiber/v2"
+)
+
+func TestLogbookDeleteToken(t *testing.T) {
+	user := types.User{ID: 7, PassHash: "hash"}
+	now := time.Date(2024, 4, 30, 12, 0, 0, 0, time.UTC)
+	token := logbookDeleteToken(user, 1, logbookDeletePurge, now.Add(time.Minute))
+
+	if !validLogbookDeleteToken(token, user, 1, logbookDeletePurge, now) {
+		t.Fatal("expected the token to be valid")
+	}
+	if validLogbookDeleteToken(token, user, 1, logbookDeletePurge, now.Add(2*time.Minute)) {
+		t.Error("expected an expired token to be rejected")
+	}
+	if validLogbookDeleteToken(token, user, 1, logbookDeleteArchive, now) || validLogbookDeleteToken(token, user, 2, logbookDeletePurge, now) {
+		t.Error("expected the token to be bound to the logbook and mode")
+	}
+	if validLogbookDeleteToken(token, types.User{ID: 7, PassHash: "changed"}, 1, logbookDeletePurge, now) {
+		t.Error("expected a password change to invalidate the token")
+	}
+}
+
+func TestDeleteLogbookHandler_ArchiveAndPurge(t *testing.T) {
+	svc := newTestServer(t)
+	svc.db = newLiveStore(svc.db)
+	ctx := context.Background()
+	for _, query := range []string{
+		`INSERT INTO users (id, callsign, pass_hash) VALUES (7, 'W1AW', 'hash')`,
+		`UPDATE logbook SET user_id = 7 WHERE id = 1`,
+		`INSERT INTO api_keys (logbook_id, key_name, key_prefix, key_hash) VALUES (1, 'laptop', 'abc123', 'key-hash')`,
+	} {
+		if _, err := svc.db.ExecContext(ctx, query); err != nil {
+			t.Fatalf("setup failed: %v", err)
+		}
+	}
+	qsoID := insertTestQso(t, svc, "JA1XX", "20m", "FT8", "20240430", "1203")
+
+	app := fiber.New()
+	app.Post("/delete", func(c *fiber.Ctx) error {
+		c.Locals(localsRequestDataKey, &requestContext{
+			Request: envelopeOf(c, &types.Logbook{ID: 1}),
+			User:    &types.User{ID: 7, Callsign: "W1AW", PassHash: "hash"},
+		})
+		return svc.deleteLogbookHandler(c)
+	})
+	app.Delete("/qsos/:id", withLogbook(1, svc.writableLogbookMiddleware()), svc.deleteQsoHandler)
+
+	post := func(options logbookDeleteOptions) (int, map[string]any) {
+		body, _ := json.Marshal(options)
+		req := httptest.NewRequest(fiber.MethodPost, "/delete", bytes.NewReader(body))
+		req.Header.Set(fiber.HeaderContentType, fiber.MIMEApplicationJSON)
+		resp, err := app.Test(req)
+		if err != nil {
+			t.Fatalf("delete request failed: %v", err)
+		}
+		var out map[string]any
+		_ = json.NewDecoder(resp.Body).Decode(&out)
+		return resp.StatusCode, out
+	}
+
+	if status, _ := post(logbookDeleteOptions{Mode: "shred"}); status != fiber.StatusBadRequest {
+		t.Errorf("expected an unknown mode to get 400, got %d", status)
+	}
+	if status, _ := post(logbookDeleteOptions{Mode: logbookDeleteArchive, Confirmation: "1.bad"}); status != fiber.StatusBadRequest {
+		t.Errorf("expected a bad token to get 400, got %d", status)
+	}
+
+	status, out := post(logbookDeleteOptions{Mode: logbookDeleteArchive})
+	if status != fiber.StatusAccepted || out["qsos"] != float64(1) {
+		t.Fatalf("expected a confirmation for 1 QSO, got %d %v", status, out)
+	}
+	if status, _ = post(logbookDeleteOptions{Mode: logbookDeletePurge, Confirmation: out["confirmation"].(string)}); status != fiber.StatusBadRequest {
+		t.Errorf("expected an archive token not to confirm a purge, got %d", status)
+	}
+	if status, _ = post(logbookDeleteOptions{Mode: logbookDeleteArchive, Confirmation: out["confirmation"].(string)}); status != fiber.StatusNoContent {
+		t.Fatalf("expected the archive to succeed, got %d", status)
+	}
+
+	path := "/qsos/" + strconv.FormatInt(qsoID, 10)
+	if resp, _ := app.Test(httptest.NewRequest(fiber.MethodDelete, path, nil)); resp.StatusCode != fiber.StatusForbidden {
+		t.Errorf("expected a write to an archived logbook to get 403, got %d", resp.StatusCode)
+	}
+
+	_, out = post(logbookDeleteOptions{Mode: logbookDeletePurge})
+	if status, _ = post(logbookDeleteOptions{Mode: logbookDeletePurge, Confirmation: out["confirmation"].(string)}); status != fiber.StatusNoContent {
+		t.Fatalf("expected the purge to succeed, got %d", status)
+	}
+	for _, query := range []string{
+		`SELECT COUNT(*) FROM logbook WHERE id = 1`,
+		`SELECT COUNT(*) FROM api_keys WHERE logbook_id = 1`,
+		`SELECT COUNT(*) FROM qso WHERE logbook_id = 1`,
+	} {
+		rows, err := svc.db.QueryContext(ctx, query)
+		if err != nil {
+			t.Fatalf("%s failed: %v", query, err)
+		}
+		var n int
+		rows.Next()
+		_ = rows.Scan(&n)
+		_ = rows.Close()
+		if n != 0 {
+			t.Errorf("expected %s to find nothing, got %d", query, n)
+		}
+	}
+	if status, _ = post(logbookDeleteOptions{Mode: logbookDeletePurge}); status != fiber.StatusNotFound {
+		t.Errorf("expected a purged logbook to get 404, got %d", status)
+	}
+}
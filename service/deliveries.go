@@ -0,0 +1,455 @@
+package service
+
+import (
+	"context"
+	stderr "errors"
+	"net/url"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Station-Manager/errors"
+)
+
+// Requests to third-party services (webhook receivers, eQSL.cc, the callsign lookup providers)
+// run on a bounded pool of workers shared by all of them. Each destination has its own queue, a
+// minimum interval between its requests and a limit on how many of its requests run at once, so
+// that a slow or unreachable destination holds a few workers and fills its own queue while the
+// others carry on. Failed deliveries are retried with backoff from the destination's queue.
+//
+// The queues are held in memory. Deliveries still queued when the server stops are handed back
+// to their owners, which keep them where they survive a restart.
+
+// envDeliveryWorkers names the environment variable holding how many deliveries to third-party
+// services run at once on this server (e.g. "8").
+const envDeliveryWorkers = "SM_DELIVERY_WORKERS"
+
+const (
+	defaultDeliveryWorkers = 8
+	// defaultDeliveryInFlight is how many deliveries to one destination run at once.
+	defaultDeliveryInFlight = 2
+	// defaultDeliveryQueueSize bounds the deliveries waiting for one destination, retries included.
+	defaultDeliveryQueueSize = 256
+	// defaultDeliveryMinInterval spaces out the requests to destinations without an interval of
+	// their own, such as webhook receivers.
+	defaultDeliveryMinInterval = 100 * time.Millisecond
+	defaultDeliveryMaxAttempts = 3
+	defaultDeliveryRetryDelay  = 5 * time.Second
+	defaultDeliveryRetryMax    = 5 * time.Minute
+	// deliveryHandBackTimeout bounds handing back a delivery still queued at shutdown.
+	deliveryHandBackTimeout = 10 * time.Second
+	// defaultDeliveryMaxDestinations bounds the destinations held at once. Idle destinations are
+	// forgotten, so it is only reached by that many webhook hosts with deliveries queued.
+	defaultDeliveryMaxDestinations = 1024
+)
+
+// Destinations of deliveries with a fixed name. Webhook receivers are keyed by host. The callsign
+// lookup destination carries the lookups of every provider; see callsignLookupTask.
+const (
+	deliveryDestinationEqsl    = "eqsl"
+	deliveryDestinationLotw    = "lotw"
+	deliveryDestinationLookup  = "callsign_lookup"
+	deliveryDestinationWebhook = "webhook:"
+)
+
+// deliveryTask is a request to a third-party service queued on the delivery pool.
+type deliveryTask struct {
+	destination string
+	// run makes one attempt (1 for the first). A failure wrapped with permanentJobFailure is not
+	// retried.
+	run func(ctx context.Context, attempt int) error
+	// attempts counts the attempts made so far, including those made before the task was handed
+	// back and queued again.
+	attempts int
+	// maxAttempts is the number of attempts before the task is abandoned; zero for the default.
+	maxAttempts int
+	// retryDelay returns the delay before the given retry (1 for the first retry); nil for the
+	// pool's exponential backoff.
+	retryDelay func(retry int) time.Duration
+	// abandoned, if set, is called once the task has failed for the last time.
+	abandoned func(attempts int, err error)
+	// handBack, if set, is called with the tasks still queued when the pool stops.
+	handBack func(ctx context.Context, attempts int)
+
+	// due is when a retry may run.
+	due time.Time
+}
+
+// deliveryDestination holds the queued tasks of a destination.
+type deliveryDestination struct {
+	limiter *rateLimiter
+	queue   []*deliveryTask
+	// retries are the failed tasks waiting for their backoff, in no particular order.
+	retries  []*deliveryTask
+	inFlight int
+}
+
+// queued returns the number of tasks waiting, retries included.
+func (d *deliveryDestination) queued() int {
+	return len(d.queue) + len(d.retries)
+}
+
+// deliveryPool queues deliveries per destination and hands them to the delivery workers.
+type deliveryPool struct {
+	workers         int
+	inFlight        int
+	queueSize       int
+	maxDestinations int
+	// wake tells an idle worker that a task may have become runnable.
+	wake chan struct{}
+
+	mu           sync.Mutex
+	intervals    map[string]time.Duration
+	destinations map[string]*deliveryDestination
+	stopped      bool
+}
+
+func newDeliveryPool() *deliveryPool {
+	return &deliveryPool{
+		workers:         defaultDeliveryWorkers,
+		inFlight:        defaultDeliveryInFlight,
+		queueSize:       defaultDeliveryQueueSize,
+		maxDestinations: defaultDeliveryMaxDestinations,
+		wake:            make(chan struct{}, 1),
+		intervals:       make(map[string]time.Duration),
+		destinations:    make(map[string]*deliveryDestination),
+	}
+}
+
+// loadDeliveryPool creates the delivery pool with the number of workers set in the environment.
+func loadDeliveryPool() (*deliveryPool, error) {
+	const op errors.Op = "server.loadDeliveryPool"
+
+	p := newDeliveryPool()
+	if value := strings.TrimSpace(os.Getenv(envDeliveryWorkers)); value != emptyString {
+		workers, err := strconv.Atoi(value)
+		if err != nil || workers <= 0 {
+			return nil, errors.New(op).Msg(envDeliveryWorkers + " must be a positive number")
+		}
+		p.workers = workers
+	}
+	return p, nil
+}
+
+// webhookDestination returns the destination of deliveries to the webhook URL.
+func webhookDestination(rawURL string) string {
+	if u, err := url.Parse(rawURL); err == nil && u.Host != emptyString {
+		return deliveryDestinationWebhook + strings.ToLower(u.Host)
+	}
+	return deliveryDestinationWebhook + rawURL
+}
+
+// setInterval sets the minimum interval between requests to the destination. It is called before
+// the workers start.
+func (p *deliveryPool) setInterval(destination string, interval time.Duration) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.intervals[destination] = interval
+}
+
+// destination returns the destination's queues, creating them on first use. p.mu must be held.
+func (p *deliveryPool) destination(name string) *deliveryDestination {
+	d, ok := p.destinations[name]
+	if !ok {
+		interval, ok := p.intervals[name]
+		if !ok {
+			interval = defaultDeliveryMinInterval
+		}
+		d = &deliveryDestination{limiter: &rateLimiter{interval: interval}}
+		p.destinations[name] = d
+	}
+	return d
+}
+
+// forgetIfIdle removes the destination once nothing is queued or running for it and its interval
+// has passed, so that the webhook hosts seen do not accumulate. If only the interval is left, it
+// returns when the interval passes. p.mu must be held.
+func (p *deliveryPool) forgetIfIdle(name string, d *deliveryDestination, now time.Time) time.Time {
+	if d.inFlight > 0 || d.queued() > 0 {
+		return time.Time{}
+	}
+	if at := d.limiter.idleAt(); at.After(now) {
+		return at
+	}
+	delete(p.destinations, name)
+	return time.Time{}
+}
+
+// submit queues the task. It does not block, and reports false, leaving the task to the caller,
+// if there is no pool, it has stopped, the destination's queue is full or the task's destination
+// is new and the pool holds as many as it may.
+func (p *deliveryPool) submit(t *deliveryTask) bool {
+	if p == nil {
+		return false
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.stopped {
+		return false
+	}
+	if _, ok := p.destinations[t.destination]; !ok && len(p.destinations) >= p.maxDestinations {
+		now := time.Now()
+		for name, d := range p.destinations {
+			p.forgetIfIdle(name, d, now)
+		}
+		if len(p.destinations) >= p.maxDestinations {
+			return false
+		}
+	}
+	d := p.destination(t.destination)
+	if d.queued() >= p.queueSize {
+		return false
+	}
+	d.queue = append(d.queue, t)
+	p.notify()
+	return true
+}
+
+// wait blocks until a request outside the pool, such as one made by a sync job, may be made to
+// the destination, or ctx is done. It shares the destination's interval with the queued tasks.
+func (p *deliveryPool) wait(ctx context.Context, destination string) error {
+	if p == nil {
+		return nil
+	}
+	p.mu.Lock()
+	limiter := p.destination(destination).limiter
+	p.mu.Unlock()
+	return limiter.wait(ctx)
+}
+
+// notify wakes an idle worker, if one is not already being woken.
+func (p *deliveryPool) notify() {
+	select {
+	case p.wake <- struct{}{}:
+	default:
+	}
+}
+
+// next takes the next task that may run at now, preferring retries that have come due, and
+// marks it in flight, and forgets idle destinations. Destinations are tried in no particular
+// order. When no task may run it returns the time one will, or an idle destination can be
+// forgotten, or zero if only a submit or a finished task can change that.
+func (p *deliveryPool) next(now time.Time) (*deliveryTask, time.Time) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	var task *deliveryTask
+	var earliest time.Time
+	later := func(at time.Time) {
+		if earliest.IsZero() || at.Before(earliest) {
+			earliest = at
+		}
+	}
+	for name, d := range p.destinations {
+		if d.inFlight >= p.inFlight {
+			continue
+		}
+		retry := -1
+		for i, t := range d.retries {
+			if !t.due.After(now) {
+				retry = i
+				break
+			}
+			later(t.due)
+		}
+		if retry < 0 && len(d.queue) == 0 {
+			if at := p.forgetIfIdle(name, d, now); !at.IsZero() {
+				later(at)
+			}
+			continue
+		}
+		if task != nil {
+			// Another task is runnable; wake a second worker for it.
+			p.notify()
+			break
+		}
+		if at, ok := d.limiter.reserve(now); !ok {
+			later(at)
+			continue
+		}
+		if retry >= 0 {
+			task = d.retries[retry]
+			d.retries = append(d.retries[:retry], d.retries[retry+1:]...)
+		} else {
+			task = d.queue[0]
+			d.queue[0] = nil
+			d.queue = d.queue[1:]
+		}
+		d.inFlight++
+		if d.queued() > 0 && d.inFlight < p.inFlight {
+			p.notify()
+		}
+	}
+	return task, earliest
+}
+
+// finish records the outcome of the task's attempt and reports whether it is to be retried. A
+// task to be retried is queued for its retry, and queued is true, unless the pool has stopped,
+// which leaves the task to the caller to hand back.
+func (p *deliveryPool) finish(t *deliveryTask, err error, now time.Time) (retry, queued bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	defer p.notify()
+
+	d := p.destination(t.destination)
+	d.inFlight--
+	maxAttempts := t.maxAttempts
+	if maxAttempts <= 0 {
+		maxAttempts = defaultDeliveryMaxAttempts
+	}
+	var permanent *permanentJobError
+	if err == nil || t.attempts >= maxAttempts || stderr.As(err, &permanent) {
+		p.forgetIfIdle(t.destination, d, now)
+		return false, false
+	}
+	if p.stopped {
+		return true, false
+	}
+	t.due = now.Add(t.retryAfter(t.attempts))
+	d.retries = append(d.retries, t)
+	return true, true
+}
+
+// retryAfter returns the delay before the given retry (1 for the first retry).
+func (t *deliveryTask) retryAfter(retry int) time.Duration {
+	if t.retryDelay != nil {
+		return t.retryDelay(retry)
+	}
+	delay := defaultDeliveryRetryDelay
+	for i := 1; i < retry && delay < defaultDeliveryRetryMax; i++ {
+		delay *= 2
+	}
+	return min(delay, defaultDeliveryRetryMax)
+}
+
+// stop stops the pool and returns the tasks still queued. Only the first call returns them.
+func (p *deliveryPool) stop() []*deliveryTask {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.stopped = true
+	var tasks []*deliveryTask
+	for name, d := range p.destinations {
+		tasks = append(tasks, d.queue...)
+		tasks = append(tasks, d.retries...)
+		d.queue, d.retries = nil, nil
+		if d.inFlight == 0 {
+			delete(p.destinations, name)
+		}
+	}
+	return tasks
+}
+
+// queued returns the number of tasks waiting for the destination, retries included.
+func (p *deliveryPool) queued(destination string) int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if d, ok := p.destinations[destination]; ok {
+		return d.queued()
+	}
+	return 0
+}
+
+// deliveryStats are the queued and running tasks of a destination.
+type deliveryStats struct {
+	Destination string
+	Queued      int
+	InFlight    int
+}
+
+// stats returns the queued and running tasks of each destination with any.
+func (p *deliveryPool) stats() []deliveryStats {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	stats := make([]deliveryStats, 0, len(p.destinations))
+	for name, d := range p.destinations {
+		stats = append(stats, deliveryStats{Destination: name, Queued: d.queued(), InFlight: d.inFlight})
+	}
+	slices.SortFunc(stats, func(a, b deliveryStats) int { return strings.Compare(a.Destination, b.Destination) })
+	return stats
+}
+
+// runDeliveryWorker runs queued deliveries until ctx is cancelled. A delivery in hand carries on
+// under the work context; those still queued are then handed back to their owners.
+func (s *Service) runDeliveryWorker(ctx context.Context) {
+	workCtx := s.bgWorkCtx
+	if workCtx == nil {
+		workCtx = context.WithoutCancel(ctx)
+	}
+	p := s.deliveries
+
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+	for {
+		if ctx.Err() != nil {
+			for _, t := range p.stop() {
+				s.handBackDelivery(t)
+			}
+			return
+		}
+		t, at := p.next(time.Now())
+		if t != nil {
+			s.runDelivery(workCtx, t)
+			continue
+		}
+
+		timer.Stop()
+		var due <-chan time.Time
+		if !at.IsZero() {
+			timer.Reset(time.Until(at))
+			due = timer.C
+		}
+		select {
+		case <-ctx.Done():
+		case <-p.wake:
+		case <-due:
+		}
+	}
+}
+
+// runDelivery makes one attempt at the task, then queues its retry, abandons it, or hands it back
+// if the pool stopped meanwhile.
+func (s *Service) runDelivery(ctx context.Context, t *deliveryTask) {
+	t.attempts++
+	err := t.run(ctx, t.attempts)
+	retry, queued := s.deliveries.finish(t, err, time.Now())
+	switch {
+	case queued:
+	case retry:
+		s.handBackDelivery(t)
+	case err != nil && t.abandoned != nil:
+		t.abandoned(t.attempts, err)
+	}
+}
+
+// handBackDelivery hands a task the pool will not run to its owner, or logs that it is dropped.
+// It does not use the worker's context, which shutdown has cancelled.
+func (s *Service) handBackDelivery(t *deliveryTask) {
+	if t.handBack == nil {
+		s.logger.WarnWith().Str("destination", t.destination).Int("attempts", t.attempts).Msg("Delivery dropped at shutdown")
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), deliveryHandBackTimeout)
+	defer cancel()
+	t.handBack(ctx, t.attempts)
+}
+
+// deliveryMetrics returns the metrics of the delivery queues, labelled with their destinations.
+func (s *Service) deliveryMetrics() []metricFamily {
+	families := []metricFamily{
+		{Name: "station_manager_delivery_queued", Help: "Deliveries to third-party services waiting to run, retries included.", Type: metricTypeGauge},
+		{Name: "station_manager_delivery_in_flight", Help: "Deliveries to third-party services running.", Type: metricTypeGauge},
+	}
+	if s.deliveries == nil {
+		return families
+	}
+	for _, d := range s.deliveries.stats() {
+		labels := []string{"destination", d.Destination}
+		families[0].Samples = append(families[0].Samples, metricSample{Labels: labels, Value: float64(d.Queued)})
+		families[1].Samples = append(families[1].Samples, metricSample{Labels: labels, Value: float64(d.InFlight)})
+	}
+	return families
+}
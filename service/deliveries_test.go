@@ -0,0 +1,304 @@
+package service
+
+import (
+	"context"
+	stderr "errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// startDeliveryWorkers runs the service's delivery workers until the test ends.
+func startDeliveryWorkers(t *testing.T, svc *Service) {
+	t.Helper()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	var wg sync.WaitGroup
+	for i := 0; i < svc.deliveries.workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			svc.runDeliveryWorker(ctx)
+		}()
+	}
+	t.Cleanup(func() {
+		cancel()
+		wg.Wait()
+	})
+}
+
+// waitFor polls cond until it holds, failing the test after a few seconds.
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestLoadDeliveryPool(t *testing.T) {
+	t.Setenv(envDeliveryWorkers, "")
+	if p, err := loadDeliveryPool(); err != nil || p.workers != defaultDeliveryWorkers {
+		t.Errorf("expected the default workers, got %+v (%v)", p, err)
+	}
+	t.Setenv(envDeliveryWorkers, "3")
+	if p, err := loadDeliveryPool(); err != nil || p.workers != 3 {
+		t.Errorf("expected 3 workers, got %+v (%v)", p, err)
+	}
+	for _, value := range []string{"0", "many"} {
+		t.Setenv(envDeliveryWorkers, value)
+		if _, err := loadDeliveryPool(); err == nil {
+			t.Errorf("expected %q to be rejected", value)
+		}
+	}
+}
+
+func TestWebhookDestination(t *testing.T) {
+	for raw, want := range map[string]string{
+		"https://Hooks.Example.com/a?x=1": "webhook:hooks.example.com",
+		"https://hooks.example.com:8443/": "webhook:hooks.example.com:8443",
+		"not a url":                       "webhook:not a url",
+	} {
+		if got := webhookDestination(raw); got != want {
+			t.Errorf("%q: expected %q, got %q", raw, want, got)
+		}
+	}
+}
+
+func TestDeliveryPool_SlowDestinationDoesNotHoldUpOthers(t *testing.T) {
+	svc := newTestServer(t)
+	svc.deliveries = newDeliveryPool()
+	svc.deliveries.workers, svc.deliveries.inFlight, svc.deliveries.queueSize = 3, 1, 2
+	svc.deliveries.setInterval("slow", 0)
+	svc.deliveries.setInterval("fast", 0)
+	startDeliveryWorkers(t, svc)
+
+	release := make(chan struct{})
+	defer close(release)
+	var slowRuns, fastRuns atomic.Int32
+	slow := func() *deliveryTask {
+		return &deliveryTask{destination: "slow", run: func(ctx context.Context, _ int) error {
+			slowRuns.Add(1)
+			<-release
+			return nil
+		}}
+	}
+	for i := 0; i < 3; i++ {
+		if !svc.deliveries.submit(slow()) {
+			t.Fatalf("expected slow task %d to be queued", i)
+		}
+		waitFor(t, "the slow task to start", func() bool { return slowRuns.Load() == 1 })
+	}
+	if svc.deliveries.submit(slow()) {
+		t.Error("expected the full queue to turn the task away")
+	}
+
+	for i := 0; i < 10; i++ {
+		if !svc.deliveries.submit(&deliveryTask{destination: "fast", run: func(context.Context, int) error {
+			fastRuns.Add(1)
+			return nil
+		}}) {
+			t.Fatalf("expected fast task %d to be queued", i)
+		}
+		waitFor(t, "the fast task to run", func() bool { return fastRuns.Load() == int32(i+1) })
+	}
+	if n := slowRuns.Load(); n != 1 {
+		t.Errorf("expected one slow task to run at a time, got %d", n)
+	}
+	if n := svc.deliveries.queued("slow"); n != 2 {
+		t.Errorf("expected 2 slow tasks queued, got %d", n)
+	}
+}
+
+func TestDeliveryPool_RetriesAndAbandons(t *testing.T) {
+	svc := newTestServer(t)
+	svc.deliveries = newDeliveryPool()
+	startDeliveryWorkers(t, svc)
+
+	type outcome struct {
+		attempts int
+		err      error
+	}
+	abandoned := make(chan outcome, 2)
+	var runs atomic.Int32
+	failing := func(err error) *deliveryTask {
+		return &deliveryTask{
+			destination: "flaky",
+			maxAttempts: 3,
+			retryDelay:  func(int) time.Duration { return time.Millisecond },
+			run: func(context.Context, int) error {
+				runs.Add(1)
+				return err
+			},
+			abandoned: func(attempts int, err error) { abandoned <- outcome{attempts, err} },
+		}
+	}
+
+	svc.deliveries.submit(failing(stderr.New("unavailable")))
+	if got := <-abandoned; got.attempts != 3 {
+		t.Errorf("expected a retryable failure to be abandoned after 3 attempts, got %d", got.attempts)
+	}
+	svc.deliveries.submit(failing(permanentJobFailure(stderr.New("gone"))))
+	if got := <-abandoned; got.attempts != 1 {
+		t.Errorf("expected a permanent failure to be abandoned at once, got %d attempts", got.attempts)
+	}
+	if n := runs.Load(); n != 4 {
+		t.Errorf("expected 4 attempts, got %d", n)
+	}
+}
+
+func TestDeliveryPool_SpacesOutRequests(t *testing.T) {
+	svc := newTestServer(t)
+	svc.deliveries = newDeliveryPool()
+	svc.deliveries.setInterval("eqsl", 30*time.Millisecond)
+	startDeliveryWorkers(t, svc)
+
+	var mu sync.Mutex
+	var starts []time.Time
+	for i := 0; i < 3; i++ {
+		svc.deliveries.submit(&deliveryTask{destination: "eqsl", run: func(context.Context, int) error {
+			mu.Lock()
+			defer mu.Unlock()
+			starts = append(starts, time.Now())
+			return nil
+		}})
+	}
+	waitFor(t, "the tasks to run", func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return len(starts) == 3
+	})
+	for i := 1; i < len(starts); i++ {
+		if gap := starts[i].Sub(starts[i-1]); gap < 25*time.Millisecond {
+			t.Errorf("expected the requests to be spaced out, got %v between %d and %d", gap, i-1, i)
+		}
+	}
+}
+
+func TestDeliveryPool_HandsBackQueuedTasksAtShutdown(t *testing.T) {
+	svc := newTestServer(t)
+	svc.deliveries = newDeliveryPool()
+	svc.deliveries.workers = 1
+
+	var handedBack []int
+	for i := 0; i < 2; i++ {
+		svc.deliveries.submit(&deliveryTask{
+			destination: "queued",
+			attempts:    i,
+			run:         func(context.Context, int) error { return nil },
+			handBack:    func(_ context.Context, attempts int) { handedBack = append(handedBack, attempts) },
+		})
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	svc.runDeliveryWorker(ctx)
+
+	if len(handedBack) != 2 {
+		t.Errorf("expected both tasks to be handed back, got %v", handedBack)
+	}
+	if svc.deliveries.submit(&deliveryTask{destination: "queued"}) {
+		t.Error("expected a stopped pool to turn tasks away")
+	}
+}
+
+func TestDeliveryPool_ForgetsIdleDestinations(t *testing.T) {
+	svc := newTestServer(t)
+	svc.deliveries = newDeliveryPool()
+	svc.deliveries.setInterval("paced", 30*time.Millisecond)
+	startDeliveryWorkers(t, svc)
+
+	var runs atomic.Int32
+	for _, destination := range []string{"webhook:a.example.com", "webhook:b.example.com", "paced"} {
+		if !svc.deliveries.submit(&deliveryTask{destination: destination, run: func(context.Context, int) error {
+			runs.Add(1)
+			return nil
+		}}) {
+			t.Fatalf("expected the task for %s to be queued", destination)
+		}
+	}
+	waitFor(t, "the tasks to run", func() bool { return runs.Load() == 3 })
+	// Each destination is forgotten once its interval has passed, without another task to wake
+	// the workers.
+	waitFor(t, "the destinations to be forgotten", func() bool { return len(svc.deliveries.stats()) == 0 })
+}
+
+func TestDeliveryPool_BoundsDestinations(t *testing.T) {
+	p := newDeliveryPool()
+	p.maxDestinations = 2
+	task := func(destination string) *deliveryTask {
+		return &deliveryTask{destination: destination, run: func(context.Context, int) error { return nil }}
+	}
+	for _, destination := range []string{"webhook:a", "webhook:b", "webhook:c"} {
+		p.setInterval(destination, 0)
+	}
+
+	if !p.submit(task("webhook:a")) || !p.submit(task("webhook:b")) {
+		t.Fatal("expected the first destinations to be taken")
+	}
+	if p.submit(task("webhook:c")) {
+		t.Error("expected a new destination to be turned away while the pool holds the most")
+	}
+
+	// A destination with nothing left to do makes room for a new one.
+	now := time.Now()
+	done, _ := p.next(now)
+	if done == nil {
+		t.Fatal("expected a task to run")
+	}
+	p.finish(done, nil, now)
+	if !p.submit(task("webhook:c")) {
+		t.Fatal("expected the new destination to be taken once another was idle")
+	}
+	if !p.submit(task("webhook:c")) {
+		t.Error("expected a known destination to be taken while the pool holds the most")
+	}
+}
+
+func TestDispatchWebhookEvent_ThroughDeliveryPool(t *testing.T) {
+	svc := newTestServer(t)
+	svc.deliveries = newDeliveryPool()
+	ctx := context.Background()
+
+	var calls atomic.Int32
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if calls.Add(1) == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer receiver.Close()
+	if _, err := svc.insertWebhook(ctx, webhook{LogbookID: 1, URL: receiver.URL, Secret: "0123456789abcdef"}); err != nil {
+		t.Fatalf("insertWebhook failed: %v", err)
+	}
+
+	// While the webhook's queue is full, the delivery is queued as a job instead.
+	svc.deliveries.queueSize = 0
+	svc.dispatchWebhookEvent(ctx, qsoEvent{ID: 1, Type: qsoEventInserted, LogbookID: 1})
+	jobs, err := svc.listJobs(ctx, 1, emptyString, 10)
+	if err != nil || len(jobs) != 1 || jobs[0].Kind != jobKindWebhookDelivery {
+		t.Fatalf("expected a delivery job, got %+v (%v)", jobs, err)
+	}
+
+	// The job hands the delivery back to the pool once it has room, which retries the failure.
+	svc.deliveries.queueSize = defaultDeliveryQueueSize
+	runDueJobs(t, svc)
+	startDeliveryWorkers(t, svc)
+	waitFor(t, "the delivery to be retried", func() bool { return calls.Load() == 2 })
+
+	svc.dispatchWebhookEvent(ctx, qsoEvent{ID: 2, Type: qsoEventInserted, LogbookID: 1})
+	waitFor(t, "the second delivery", func() bool { return calls.Load() == 3 })
+	if jobs, err = svc.listJobs(ctx, 1, emptyString, 10); err != nil || len(jobs) != 1 {
+		t.Errorf("expected no further jobs, got %+v (%v)", jobs, err)
+	}
+	letters, err := svc.listWebhookDeadLetters(ctx, 1, 10)
+	if err != nil || len(letters) != 0 {
+		t.Errorf("expected no dead letters, got %+v (%v)", letters, err)
+	}
+}
@@ -0,0 +1,59 @@
+package service
+
+import (
+	stderr "errors"
+	"fmt"
+	"sort"
+
+	"github.com/Station-Manager/errors"
+	"github.com/Station-Manager/types"
+)
+
+// A dry run of an insert or an import goes through every check of the real thing, in the same
+// transaction, so that the logbook's quotas, unique QSOs and running contest are all consulted,
+// and then rolls the transaction back. The client is shown the QSOs as they would have been
+// stored, with warnings where that differs from what it sent. Nothing of a dry run is published
+// or counted in the logbook's usage.
+
+// errDryRun rolls back the transaction of a dry run once every write in it has succeeded.
+var errDryRun = stderr.New("dry run")
+
+// qsoInsertOptions are the fields of the insert request that the request envelope lacks.
+type qsoInsertOptions struct {
+	DryRun bool `json:"dry_run"`
+}
+
+// qsoWarning is a difference between a QSO sent by a client and the QSO as it is stored.
+type qsoWarning struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// qsoWarnings lists the fields the client sent that are stored with another value, and the
+// callsign if the prefix database is loaded but knows no DXCC entity for it. Fields the server
+// fills in are not warned about; they are visible in the stored QSO.
+func (s *Service) qsoWarnings(sent, stored types.Qso) ([]qsoWarning, error) {
+	const op errors.Op = "server.Service.qsoWarnings"
+
+	changes, err := qsoChanges(sent, stored)
+	if err != nil {
+		return nil, errors.New(op).Err(err)
+	}
+	warnings := make([]qsoWarning, 0, len(changes))
+	for field, change := range changes {
+		if change.Old == nil {
+			continue
+		}
+		message := fmt.Sprintf("%v is stored as %v", change.Old, change.New)
+		if change.New == nil {
+			message = fmt.Sprintf("%v is not stored", change.Old)
+		}
+		warnings = append(warnings, qsoWarning{Field: field, Message: message})
+	}
+	sort.Slice(warnings, func(i, j int) bool { return warnings[i].Field < warnings[j].Field })
+
+	if s.cty != nil && s.cty.db.Load() != nil && stored.DXCC == emptyString {
+		warnings = append(warnings, qsoWarning{Field: "call", Message: "No DXCC entity is known for the callsign"})
+	}
+	return warnings, nil
+}
@@ -0,0 +1,165 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/Station-Manager/errors"
+	"github.com/Station-Manager/server/service/cty"
+	"github.com/Station-Manager/types"
+)
+
+// envCtyURL names the environment variable that overrides where the cty.csv prefix database is
+// downloaded from.
+const envCtyURL = "SM_CTY_URL"
+
+const (
+	defaultCtyURL             = "https://www.country-files.com/cty/cty.csv"
+	defaultCtyRefreshInterval = 24 * time.Hour
+	defaultCtyRequestTimeout  = time.Minute
+	ctyMaxBytes               = 8 << 20
+)
+
+// ctyResolver resolves callsigns to DXCC entities. The database is replaced atomically on refresh
+// and is nil until one has been loaded, in which case QSOs are stored without resolution.
+type ctyResolver struct {
+	url             string
+	client          *http.Client
+	refreshInterval time.Duration
+	db              atomic.Pointer[cty.Database]
+}
+
+func newCtyResolver() *ctyResolver {
+	url := strings.TrimSpace(os.Getenv(envCtyURL))
+	if url == emptyString {
+		url = defaultCtyURL
+	}
+	return &ctyResolver{
+		url:             url,
+		client:          &http.Client{Timeout: defaultCtyRequestTimeout},
+		refreshInterval: defaultCtyRefreshInterval,
+	}
+}
+
+// resolveQsoEntity fills the QSO's empty DXCC, zone, continent and country fields from the
+// callsign. Values supplied by the client are kept.
+func (s *Service) resolveQsoEntity(qso *types.Qso) {
+	if s.cty == nil {
+		return
+	}
+	db := s.cty.db.Load()
+	if db == nil {
+		return
+	}
+	entity, ok := db.Lookup(qso.Call)
+	if !ok {
+		return
+	}
+
+	fill := func(field *string, value string) {
+		if *field == emptyString {
+			*field = value
+		}
+	}
+	fill(&qso.DXCC, strconv.Itoa(entity.DXCC))
+	fill(&qso.CQZ, strconv.Itoa(entity.CQZone))
+	fill(&qso.ITUZ, strconv.Itoa(entity.ITUZone))
+	fill(&qso.Cont, entity.Continent)
+	fill(&qso.Country, entity.Name)
+}
+
+// loadStoredCtyRefresh activates the stored prefix database and reports when it was downloaded,
+// so that the refresh is due at once when it is missing or stale.
+func (s *Service) loadStoredCtyRefresh(ctx context.Context) (time.Time, bool) {
+	fetchedAt, err := s.loadStoredCtyDatabase(ctx)
+	if err != nil {
+		s.logger.WarnWith().Err(err).Msg("Failed to load the stored prefix database")
+	}
+	return fetchedAt, true
+}
+
+// loadStoredCtyDatabase activates the prefix database saved by the last refresh and returns when
+// it was downloaded; the zero time means there is none.
+func (s *Service) loadStoredCtyDatabase(ctx context.Context) (time.Time, error) {
+	const op errors.Op = "server.Service.loadStoredCtyDatabase"
+
+	rows, err := s.db.QueryContext(ctx, `SELECT content, fetched_at FROM cty_database WHERE id = 1`)
+	if err != nil {
+		return time.Time{}, errors.New(op).Err(err)
+	}
+	defer func() { _ = rows.Close() }()
+
+	if !rows.Next() {
+		if err = rows.Err(); err != nil {
+			return time.Time{}, errors.New(op).Err(err)
+		}
+		return time.Time{}, nil
+	}
+	var content string
+	var fetchedAt time.Time
+	if err = rows.Scan(&content, &fetchedAt); err != nil {
+		return time.Time{}, errors.New(op).Err(err)
+	}
+
+	db, err := cty.Parse(strings.NewReader(content))
+	if err != nil {
+		return time.Time{}, errors.New(op).Err(err)
+	}
+	s.cty.db.Store(db)
+	return fetchedAt, nil
+}
+
+// refreshCtyDatabase downloads the prefix database, activates it and saves it so that it survives
+// restarts. A download that does not parse leaves the current database in place.
+func (s *Service) refreshCtyDatabase(ctx context.Context) error {
+	const op errors.Op = "server.Service.refreshCtyDatabase"
+
+	content, err := s.downloadCtyDatabase(ctx)
+	if err != nil {
+		return errors.New(op).Err(err)
+	}
+	db, err := cty.Parse(bytes.NewReader(content))
+	if err != nil {
+		return errors.New(op).Err(err)
+	}
+
+	if _, err = s.db.ExecContext(ctx, `INSERT INTO cty_database (id, source, content, fetched_at) VALUES (1, $1, $2, CURRENT_TIMESTAMP)
+		ON CONFLICT (id) DO UPDATE SET source = excluded.source, content = excluded.content, fetched_at = excluded.fetched_at`,
+		s.cty.url, string(content)); err != nil {
+		return errors.New(op).Err(err)
+	}
+	s.cty.db.Store(db)
+	s.logger.InfoWith().Int("entities", db.Entities()).Str("source", s.cty.url).Msg("Prefix database refreshed")
+
+	return nil
+}
+
+func (s *Service) downloadCtyDatabase(ctx context.Context) ([]byte, error) {
+	const op errors.Op = "server.Service.downloadCtyDatabase"
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, s.cty.url, nil)
+	if err != nil {
+		return nil, errors.New(op).Err(err)
+	}
+	resp, err := s.cty.client.Do(req)
+	if err != nil {
+		return nil, errors.New(op).Err(err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, errors.New(op).Errorf("Prefix database download returned status %d", resp.StatusCode)
+	}
+	content, err := io.ReadAll(io.LimitReader(resp.Body, ctyMaxBytes))
+	if err != nil {
+		return nil, errors.New(op).Err(err)
+	}
+	return content, nil
+}
@@ -0,0 +1,89 @@
+package service
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/Station-Manager/types"
+)
+
+const testCtyDatabase = `K,United States,291,NA,5,8,37.53,91.67,5.0,AA AB K N W;
+JA,Japan,339,AS,25,45,36.40,-138.38,-9.0,JA JE JR;
+`
+
+func TestCtyDatabase_RefreshPersistsAndResolves(t *testing.T) {
+	svc := newTestServer(t)
+	ctx := context.Background()
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(testCtyDatabase))
+	}))
+	defer srv.Close()
+
+	svc.cty = newCtyResolver()
+	svc.cty.url = srv.URL
+
+	// Without a database QSOs are left untouched.
+	var qso types.Qso
+	qso.Call = "JA1XX"
+	svc.resolveQsoEntity(&qso)
+	if qso.DXCC != emptyString {
+		t.Fatalf("expected no resolution without a database, got %q", qso.DXCC)
+	}
+
+	if err := svc.refreshCtyDatabase(ctx); err != nil {
+		t.Fatalf("refreshCtyDatabase failed: %v", err)
+	}
+	svc.resolveQsoEntity(&qso)
+	if qso.DXCC != "339" || qso.CQZ != "25" || qso.ITUZ != "45" || qso.Cont != "AS" || qso.Country != "Japan" {
+		t.Errorf("unexpected resolution %+v", qso.ContactedStation)
+	}
+
+	// Values supplied by the client are kept.
+	qso = types.Qso{}
+	qso.Call = "K1AB"
+	qso.CQZ = "4"
+	svc.resolveQsoEntity(&qso)
+	if qso.DXCC != "291" || qso.CQZ != "4" {
+		t.Errorf("expected only empty fields to be filled, got DXCC=%q CQZ=%q", qso.DXCC, qso.CQZ)
+	}
+
+	// A fresh resolver picks up the stored copy without downloading.
+	svc.cty = newCtyResolver()
+	svc.cty.url = "http://127.0.0.1:1/unreachable"
+	fetchedAt, err := svc.loadStoredCtyDatabase(ctx)
+	if err != nil || fetchedAt.IsZero() {
+		t.Fatalf("loadStoredCtyDatabase failed: %v (fetched_at=%v)", err, fetchedAt)
+	}
+	if db := svc.cty.db.Load(); db == nil || db.Entities() != 2 {
+		t.Fatal("expected the stored database to be loaded")
+	}
+}
+
+func TestCtyDatabase_BadDownloadKeepsCurrent(t *testing.T) {
+	svc := newTestServer(t)
+	ctx := context.Background()
+
+	body := testCtyDatabase
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(body))
+	}))
+	defer srv.Close()
+
+	svc.cty = newCtyResolver()
+	svc.cty.url = srv.URL
+	if err := svc.refreshCtyDatabase(ctx); err != nil {
+		t.Fatalf("refreshCtyDatabase failed: %v", err)
+	}
+	current := svc.cty.db.Load()
+
+	body = "<html>not a prefix database</html>"
+	if err := svc.refreshCtyDatabase(ctx); err == nil {
+		t.Fatal("expected an unparseable download to fail")
+	}
+	if svc.cty.db.Load() != current {
+		t.Error("expected the current database to be kept")
+	}
+}
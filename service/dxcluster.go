@@ -0,0 +1,189 @@
+package service
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Station-Manager/errors"
+	"github.com/Station-Manager/server/service/dxcluster"
+)
+
+// Environment variables configuring the DX cluster whose spots are checked against the logbooks'
+// needed entities. Spot alerts are unavailable when SM_DX_CLUSTER_ADDR is not set.
+const (
+	envDxClusterAddr     = "SM_DX_CLUSTER_ADDR" // host:port of the cluster's telnet interface
+	envDxClusterCallsign = "SM_DX_CLUSTER_CALLSIGN"
+)
+
+const (
+	defaultDxClusterReconnectMin = 5 * time.Second
+	defaultDxClusterReconnectMax = 5 * time.Minute
+	dxClusterDialTimeout         = 30 * time.Second
+	// dxClusterRepeatWindow is how long further spots of a station on the same band are ignored;
+	// clusters relay every spotter's report of the same signal.
+	dxClusterRepeatWindow = 10 * time.Minute
+	// spotAlertRepeatWindow is how long a logbook is not alerted again to a station on the same
+	// band and mode.
+	spotAlertRepeatWindow = time.Hour
+)
+
+// dxClusterClient follows a DX cluster's spots. recent and alerted suppress repeats; both are
+// pruned as they are written.
+type dxClusterClient struct {
+	addr         string
+	callsign     string
+	reconnectMin time.Duration
+	reconnectMax time.Duration
+
+	mu        sync.Mutex
+	recent    map[string]time.Time
+	alerted   map[string]time.Time
+	lastPrune time.Time
+}
+
+func newDxClusterClient(addr, callsign string) *dxClusterClient {
+	return &dxClusterClient{
+		addr:         addr,
+		callsign:     callsign,
+		reconnectMin: defaultDxClusterReconnectMin,
+		reconnectMax: defaultDxClusterReconnectMax,
+		recent:       make(map[string]time.Time),
+		alerted:      make(map[string]time.Time),
+	}
+}
+
+// loadDxClusterClient returns the client for the cluster configured in the environment, or nil when
+// none is.
+func loadDxClusterClient() (*dxClusterClient, error) {
+	const op errors.Op = "server.loadDxClusterClient"
+
+	addr := strings.TrimSpace(os.Getenv(envDxClusterAddr))
+	if addr == emptyString {
+		return nil, nil
+	}
+	if _, _, err := net.SplitHostPort(addr); err != nil {
+		return nil, errors.New(op).Msg(envDxClusterAddr + " must be a host:port")
+	}
+	callsign := strings.ToUpper(strings.TrimSpace(os.Getenv(envDxClusterCallsign)))
+	if callsign == emptyString {
+		return nil, errors.New(op).Msg(envDxClusterCallsign + " must be set with " + envDxClusterAddr)
+	}
+	return newDxClusterClient(addr, callsign), nil
+}
+
+// enabled reports whether the server follows a DX cluster.
+func (d *dxClusterClient) enabled() bool {
+	return d != nil
+}
+
+// firstSpot reports whether the spot is the first of its station on its band within
+// dxClusterRepeatWindow.
+func (d *dxClusterClient) firstSpot(call, band string, now time.Time) bool {
+	return d.first(d.recent, call+"|"+band, now, dxClusterRepeatWindow)
+}
+
+// firstAlert reports whether the logbook has not been alerted to the station on the band and mode
+// within spotAlertRepeatWindow.
+func (d *dxClusterClient) firstAlert(logbookID int64, call, band, mode string, now time.Time) bool {
+	return d.first(d.alerted, strings.Join([]string{strconv.FormatInt(logbookID, 10), call, band, mode}, "|"), now, spotAlertRepeatWindow)
+}
+
+// first records key as seen now, reporting whether it had not been seen within window.
+func (d *dxClusterClient) first(seen map[string]time.Time, key string, now time.Time, window time.Duration) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if now.Sub(d.lastPrune) >= dxClusterRepeatWindow {
+		for k, at := range d.recent {
+			if now.Sub(at) >= dxClusterRepeatWindow {
+				delete(d.recent, k)
+			}
+		}
+		for k, at := range d.alerted {
+			if now.Sub(at) >= spotAlertRepeatWindow {
+				delete(d.alerted, k)
+			}
+		}
+		d.lastPrune = now
+	}
+	if at, ok := seen[key]; ok && now.Sub(at) < window {
+		return false
+	}
+	seen[key] = now
+	return true
+}
+
+// runDxCluster follows the cluster until ctx is cancelled, reconnecting with a growing delay while
+// it cannot be reached.
+func (s *Service) runDxCluster(ctx context.Context) {
+	d := s.dxCluster
+	delay := d.reconnectMin
+	for {
+		spots, err := s.followDxCluster(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+		if spots > 0 {
+			delay = d.reconnectMin
+		}
+		s.logger.WarnWith().Err(err).Str("cluster", d.addr).Dur("retry_in", delay).Msg("DX cluster connection lost")
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(delay):
+		}
+		delay = min(delay*2, d.reconnectMax)
+	}
+}
+
+// followDxCluster logs in to the cluster and handles its spots until the connection ends. It
+// returns the number of spots read.
+func (s *Service) followDxCluster(ctx context.Context) (int, error) {
+	const op errors.Op = "server.Service.followDxCluster"
+
+	d := s.dxCluster
+	dialer := net.Dialer{Timeout: dxClusterDialTimeout}
+	conn, err := dialer.DialContext(ctx, "tcp", d.addr)
+	if err != nil {
+		return 0, errors.New(op).Err(err)
+	}
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+		case <-done:
+		}
+		_ = conn.Close()
+	}()
+
+	// Clusters prompt for a callsign and take whatever is sent first as the answer.
+	if _, err = conn.Write([]byte(d.callsign + "\r\n")); err != nil {
+		return 0, errors.New(op).Err(err)
+	}
+	s.logger.InfoWith().Str("cluster", d.addr).Msg("Connected to DX cluster")
+
+	spots := 0
+	scanner := bufio.NewScanner(conn)
+	for scanner.Scan() {
+		spot, ok := dxcluster.Parse(scanner.Text(), time.Now())
+		if !ok {
+			continue
+		}
+		spots++
+		if err = s.handleDxSpot(ctx, spot); err != nil {
+			s.logger.WarnWith().Err(err).Str("call", spot.Call).Msg("Failed to check DX spot")
+		}
+	}
+	if err = scanner.Err(); err != nil {
+		return spots, errors.New(op).Err(err)
+	}
+	return spots, errors.New(op).Msg("connection closed by the cluster")
+}
@@ -0,0 +1,173 @@
+// Package dxcluster reads spots from a DX cluster's telnet feed, as sent by DXSpider, AR-Cluster
+// and CC Cluster nodes:
+//
+//	DX de W3LPL:     14025.0  JA1XX        CW 599 up 1                 1234Z
+//
+// and works out the band and mode of each spot from its frequency and comment.
+package dxcluster
+
+import (
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Spot is a station reported on the air by another.
+type Spot struct {
+	Spotter      string
+	Call         string
+	FrequencyKHz float64
+	Comment      string
+	Time         time.Time
+}
+
+// Parse reads a spot line. The line gives only the hour and minute of the spot, which are taken to
+// be the latest such time not after now; lines without a time are stamped with now. Lines that are
+// not spots, such as announcements and the login prompt, are reported as not ok.
+func Parse(line string, now time.Time) (Spot, bool) {
+	line = strings.TrimSpace(line)
+	if !strings.HasPrefix(line, "DX de ") {
+		return Spot{}, false
+	}
+	rest := line[len("DX de "):]
+	colon := strings.IndexByte(rest, ':')
+	if colon <= 0 {
+		return Spot{}, false
+	}
+	spotter := strings.ToUpper(strings.TrimSpace(rest[:colon]))
+	fields := strings.Fields(rest[colon+1:])
+	if spotter == "" || len(fields) < 2 {
+		return Spot{}, false
+	}
+	kHz, err := strconv.ParseFloat(fields[0], 64)
+	if err != nil || kHz <= 0 {
+		return Spot{}, false
+	}
+	spot := Spot{
+		Spotter:      spotter,
+		Call:         strings.ToUpper(fields[1]),
+		FrequencyKHz: kHz,
+		Time:         now.UTC().Truncate(time.Minute),
+	}
+
+	comment := fields[2:]
+	if n := len(comment); n > 0 {
+		if at, ok := spotTime(comment[n-1], now); ok {
+			spot.Time = at
+			comment = comment[:n-1]
+		}
+	}
+	spot.Comment = strings.Join(comment, " ")
+	return spot, true
+}
+
+// spotTime reads a HHMMZ time as the latest such time not after now.
+func spotTime(field string, now time.Time) (time.Time, bool) {
+	if len(field) != 5 || field[4] != 'Z' {
+		return time.Time{}, false
+	}
+	hhmm, err := strconv.Atoi(field[:4])
+	if err != nil || hhmm/100 > 23 || hhmm%100 > 59 {
+		return time.Time{}, false
+	}
+	now = now.UTC()
+	at := time.Date(now.Year(), now.Month(), now.Day(), hhmm/100, hhmm%100, 0, 0, time.UTC)
+	if at.After(now) {
+		at = at.AddDate(0, 0, -1)
+	}
+	return at, true
+}
+
+// band is an amateur band with the top of its CW segment, below which spots without a mode in
+// their comment are taken to be CW, and its FT8 and FT4 dial frequencies. Frequencies a band does
+// not have are zero.
+type band struct {
+	name       string
+	low, high  float64 // kHz
+	cwTopKHz   float64
+	ft8DialKHz float64
+	ft4DialKHz float64
+}
+
+// bands is the IARU band plan, widened to cover all three regions.
+var bands = []band{
+	{name: "2190m", low: 135.7, high: 137.8, cwTopKHz: 137.8},
+	{name: "630m", low: 472, high: 479, cwTopKHz: 479},
+	{name: "160m", low: 1800, high: 2000, cwTopKHz: 1838, ft8DialKHz: 1840},
+	{name: "80m", low: 3500, high: 4000, cwTopKHz: 3570, ft8DialKHz: 3573, ft4DialKHz: 3575},
+	{name: "60m", low: 5060, high: 5450, ft8DialKHz: 5357},
+	{name: "40m", low: 7000, high: 7300, cwTopKHz: 7040, ft8DialKHz: 7074, ft4DialKHz: 7047.5},
+	{name: "30m", low: 10100, high: 10150, cwTopKHz: 10130, ft8DialKHz: 10136, ft4DialKHz: 10140},
+	{name: "20m", low: 14000, high: 14350, cwTopKHz: 14070, ft8DialKHz: 14074, ft4DialKHz: 14080},
+	{name: "17m", low: 18068, high: 18168, cwTopKHz: 18095, ft8DialKHz: 18100, ft4DialKHz: 18104},
+	{name: "15m", low: 21000, high: 21450, cwTopKHz: 21070, ft8DialKHz: 21074, ft4DialKHz: 21140},
+	{name: "12m", low: 24890, high: 24990, cwTopKHz: 24915, ft8DialKHz: 24915, ft4DialKHz: 24919},
+	{name: "10m", low: 28000, high: 29700, cwTopKHz: 28070, ft8DialKHz: 28074, ft4DialKHz: 28180},
+	{name: "6m", low: 50000, high: 54000, cwTopKHz: 50100, ft8DialKHz: 50313, ft4DialKHz: 50318},
+	{name: "4m", low: 70000, high: 71000, cwTopKHz: 70100, ft8DialKHz: 70154},
+	{name: "2m", low: 144000, high: 148000, cwTopKHz: 144150, ft8DialKHz: 144174, ft4DialKHz: 144170},
+	{name: "1.25m", low: 222000, high: 225000, cwTopKHz: 222150},
+	{name: "70cm", low: 420000, high: 450000, cwTopKHz: 432100, ft8DialKHz: 432174},
+	{name: "33cm", low: 902000, high: 928000},
+	{name: "23cm", low: 1240000, high: 1300000, cwTopKHz: 1296150},
+	{name: "13cm", low: 2300000, high: 2450000},
+	{name: "9cm", low: 3300000, high: 3500000},
+	{name: "6cm", low: 5650000, high: 5925000},
+	{name: "3cm", low: 10000000, high: 10500000},
+}
+
+// digitalWindowKHz is how far above a dial frequency FT8 and FT4 signals are found.
+const digitalWindowKHz = 3
+
+// Band returns the ADIF band of a frequency in kHz, or "" outside the amateur bands.
+func Band(kHz float64) string {
+	if b, ok := findBand(kHz); ok {
+		return b.name
+	}
+	return ""
+}
+
+func findBand(kHz float64) (band, bool) {
+	for _, b := range bands {
+		if kHz >= b.low && kHz <= b.high {
+			return b, true
+		}
+	}
+	return band{}, false
+}
+
+// commentModes maps the words spotters use in comments to the ADIF mode.
+var commentModes = map[string]string{
+	"CW": "CW", "SSB": "SSB", "USB": "SSB", "LSB": "SSB", "AM": "AM", "FM": "FM",
+	"FT8": "FT8", "FT4": "FT4", "JT65": "JT65", "JT9": "JT9", "Q65": "MFSK", "MSK144": "MSK144",
+	"RTTY": "RTTY", "PSK": "PSK", "PSK31": "PSK", "PSK63": "PSK", "BPSK": "PSK", "OLIVIA": "OLIVIA",
+	"JS8": "MFSK", "SSTV": "SSTV",
+}
+
+// Mode returns the ADIF mode of a spot: the one named in its comment if any, and otherwise the
+// mode usually found at its frequency: FT8 and FT4 just above their dial frequencies, CW in the CW
+// segment and SSB above it. It returns "" for frequencies outside the amateur bands.
+func Mode(kHz float64, comment string) string {
+	for _, word := range strings.FieldsFunc(strings.ToUpper(comment), func(r rune) bool {
+		return !(r >= 'A' && r <= 'Z' || r >= '0' && r <= '9')
+	}) {
+		if mode, ok := commentModes[word]; ok {
+			return mode
+		}
+	}
+
+	b, ok := findBand(kHz)
+	if !ok {
+		return ""
+	}
+	switch {
+	case b.ft8DialKHz > 0 && kHz >= b.ft8DialKHz && kHz <= b.ft8DialKHz+digitalWindowKHz:
+		return "FT8"
+	case b.ft4DialKHz > 0 && kHz >= b.ft4DialKHz && kHz <= b.ft4DialKHz+digitalWindowKHz:
+		return "FT4"
+	case kHz < b.cwTopKHz:
+		return "CW"
+	default:
+		return "SSB"
+	}
+}
@@ -0,0 +1,61 @@
+package dxcluster
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParse(t *testing.T) {
+	now := time.Date(2024, 11, 23, 0, 10, 0, 0, time.UTC)
+
+	spot, ok := Parse("DX de W3LPL:     14025.0  ja1xx        CW 599 up 1                 2358Z\r\n", now)
+	if !ok {
+		t.Fatal("expected a spot")
+	}
+	want := Spot{Spotter: "W3LPL", Call: "JA1XX", FrequencyKHz: 14025, Comment: "CW 599 up 1", Time: time.Date(2024, 11, 22, 23, 58, 0, 0, time.UTC)}
+	if spot != want {
+		t.Errorf("got %+v, want %+v", spot, want)
+	}
+
+	spot, ok = Parse("DX de DL1ABC-#: 7074.0 VK2XYZ", now)
+	if !ok || spot.Spotter != "DL1ABC-#" || spot.Comment != "" || !spot.Time.Equal(now) {
+		t.Errorf("expected a spot without comment or time, got %+v %v", spot, ok)
+	}
+
+	for _, line := range []string{
+		"login: ",
+		"WWV de W0MU <18>:   SFI=148, A=5, K=1, No Storms -> No Storms",
+		"DX de W3LPL: abc JA1XX",
+		"DX de W3LPL: 14025.0",
+	} {
+		if _, ok = Parse(line, now); ok {
+			t.Errorf("expected %q not to be a spot", line)
+		}
+	}
+}
+
+func TestBandAndMode(t *testing.T) {
+	for _, tc := range []struct {
+		kHz     float64
+		comment string
+		band    string
+		mode    string
+	}{
+		{14025, "", "20m", "CW"},
+		{14074.5, "", "20m", "FT8"},
+		{14080.2, "", "20m", "FT4"},
+		{14195, "", "20m", "SSB"},
+		{14085, "rtty test", "20m", "RTTY"},
+		{7020, "tnx FT8!", "40m", "FT8"},
+		{144300, "", "2m", "SSB"},
+		{5357, "", "60m", "FT8"},
+		{13000, "", "", ""},
+	} {
+		if got := Band(tc.kHz); got != tc.band {
+			t.Errorf("Band(%v) = %q, want %q", tc.kHz, got, tc.band)
+		}
+		if got := Mode(tc.kHz, tc.comment); got != tc.mode {
+			t.Errorf("Mode(%v, %q) = %q, want %q", tc.kHz, tc.comment, got, tc.mode)
+		}
+	}
+}
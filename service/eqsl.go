@@ -0,0 +1,488 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	stderr "errors"
+	"io"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/Station-Manager/errors"
+	"github.com/Station-Manager/server/service/adif"
+)
+
+const (
+	defaultEqslUploadURL      = "https://www.eqsl.cc/qslcard/ImportADIF.cfm"
+	defaultEqslInboxURL       = "https://www.eqsl.cc/qslcard/DownloadInBox.cfm"
+	defaultEqslSyncInterval   = time.Hour
+	defaultEqslRequestTimeout = time.Minute
+	// defaultEqslMinInterval spaces out the requests to eQSL, uploads and syncs alike.
+	defaultEqslMinInterval = time.Second
+	eqslMaxResponseBytes   = 64 << 20
+	// eqslRcvdSinceLayout is the layout of eQSL's RcvdSince parameter (UTC).
+	eqslRcvdSinceLayout = "200601021504"
+
+	uploadServiceEqsl = "eqsl"
+)
+
+var (
+	eqslErrorPattern   = regexp.MustCompile(`Error:\s*([^<\r\n]+)`)
+	eqslAdiLinkPattern = regexp.MustCompile(`(?i)href="([^"]+\.adi)"`)
+)
+
+// eqslAccount holds a logbook's eQSL.cc credentials and inbox position. Password is encrypted.
+type eqslAccount struct {
+	LogbookID   int64
+	Username    string
+	Password    string
+	QthNickname string // selects one of the account's QTH profiles; empty for the default
+	RcvdSince   string // eqslRcvdSinceLayout; empty before the first inbox download
+	LastSyncAt  sql.NullTime
+	LastError   string
+}
+
+// eqslSyncResult summarises a single sync.
+type eqslSyncResult struct {
+	Retried  int `json:"retried"`  // failed uploads that were retried
+	Received int `json:"received"` // eQSLs in the inbox download
+	Matched  int `json:"matched"`  // eQSLs matched to a QSO in the logbook
+	New      int `json:"new"`      // matched QSOs that were not confirmed by eQSL before
+}
+
+// eqslClient uploads QSOs to, and downloads received eQSLs from, eQSL.cc.
+type eqslClient struct {
+	uploadURL    string
+	inboxURL     string
+	client       *http.Client
+	syncInterval time.Duration
+}
+
+func newEqslClient() *eqslClient {
+	return &eqslClient{
+		uploadURL:    defaultEqslUploadURL,
+		inboxURL:     defaultEqslInboxURL,
+		client:       &http.Client{Timeout: defaultEqslRequestTimeout},
+		syncInterval: defaultEqslSyncInterval,
+	}
+}
+
+// upload sends a single ADIF record. eQSL reports a duplicate of an earlier upload as a warning;
+// that counts as success.
+func (e *eqslClient) upload(ctx context.Context, username, password string, record adif.Record) error {
+	const op errors.Op = "server.eqslClient.upload"
+
+	var doc bytes.Buffer
+	if err := adif.Encode(&doc, adifProgramID, []adif.Record{record}); err != nil {
+		return errors.New(op).Err(err)
+	}
+	form := url.Values{}
+	form.Set("EQSL_USER", username)
+	form.Set("EQSL_PSWD", password)
+	form.Set("ADIFData", doc.String())
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, e.uploadURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return errors.New(op).Err(err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	body, err := e.do(req)
+	if err != nil {
+		return errors.New(op).Err(err)
+	}
+
+	switch {
+	case bytes.Contains(body, []byte("Result: 1 out of 1 records added")):
+		return nil
+	case bytes.Contains(bytes.ToLower(body), []byte("duplicate")):
+		return nil
+	case eqslErrorPattern.Match(body):
+		return errors.New(op).Msg(eqslError(body))
+	default:
+		return errors.New(op).Msg("eQSL did not accept the QSO")
+	}
+}
+
+// fetchInbox downloads the eQSLs received since rcvdSince, or all of them when it is empty.
+// eQSL answers with a page linking to a generated ADIF file, which is then downloaded.
+func (e *eqslClient) fetchInbox(ctx context.Context, username, password, qthNickname, rcvdSince string) (adif.Document, error) {
+	const op errors.Op = "server.eqslClient.fetchInbox"
+
+	params := url.Values{}
+	params.Set("UserName", username)
+	params.Set("Password", password)
+	if qthNickname != emptyString {
+		params.Set("QTHNickname", qthNickname)
+	}
+	if rcvdSince != emptyString {
+		params.Set("RcvdSince", rcvdSince)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, e.inboxURL+"?"+params.Encode(), nil)
+	if err != nil {
+		return adif.Document{}, errors.New(op).Err(err)
+	}
+	page, err := e.do(req)
+	if err != nil {
+		return adif.Document{}, errors.New(op).Err(err)
+	}
+
+	if eqslErrorPattern.Match(page) {
+		return adif.Document{}, errors.New(op).Msg(eqslError(page))
+	}
+	if bytes.Contains(page, []byte("You have no log entries")) {
+		return adif.Document{}, nil
+	}
+	link := eqslAdiLinkPattern.FindSubmatch(page)
+	if link == nil {
+		return adif.Document{}, errors.New(op).Msg("eQSL did not return an inbox file")
+	}
+
+	base, err := url.Parse(e.inboxURL)
+	if err != nil {
+		return adif.Document{}, errors.New(op).Err(err)
+	}
+	ref, err := url.Parse(string(link[1]))
+	if err != nil {
+		return adif.Document{}, errors.New(op).Err(err)
+	}
+	if req, err = http.NewRequestWithContext(ctx, http.MethodGet, base.ResolveReference(ref).String(), nil); err != nil {
+		return adif.Document{}, errors.New(op).Err(err)
+	}
+	body, err := e.do(req)
+	if err != nil {
+		return adif.Document{}, errors.New(op).Err(err)
+	}
+	if !bytes.Contains(bytes.ToLower(body), []byte("<eoh>")) {
+		return adif.Document{}, errors.New(op).Msg("eQSL inbox file is not ADIF")
+	}
+
+	doc, err := adif.Parse(bytes.NewReader(body))
+	if err != nil {
+		return adif.Document{}, errors.New(op).Err(err)
+	}
+	return doc, nil
+}
+
+// do performs the request and returns the body of a 200 response.
+func (e *eqslClient) do(req *http.Request) ([]byte, error) {
+	const op errors.Op = "server.eqslClient.do"
+
+	resp, err := e.client.Do(req)
+	if err != nil {
+		// The URL may carry the password; never let it reach the logs.
+		var urlErr *url.Error
+		if stderr.As(err, &urlErr) {
+			err = urlErr.Err
+		}
+		return nil, errors.New(op).Err(err).Msg("eQSL request failed")
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, errors.New(op).Errorf("eQSL returned status %d", resp.StatusCode)
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, eqslMaxResponseBytes))
+	if err != nil {
+		return nil, errors.New(op).Err(err)
+	}
+	return body, nil
+}
+
+// eqslError extracts the message from an eQSL "Error: ..." page.
+func eqslError(page []byte) string {
+	if m := eqslErrorPattern.FindSubmatch(page); m != nil {
+		return "eQSL: " + strings.TrimSpace(string(m[1]))
+	}
+	return "eQSL request failed"
+}
+
+// enqueueEqslUpload queues newly inserted QSOs for upload on the delivery pool. It is registered
+// with the QSO event broker and must not block; if the queue is full the QSO is not uploaded and
+// this is logged.
+func (s *Service) enqueueEqslUpload(event qsoEvent) {
+	if event.Type != qsoEventInserted {
+		return
+	}
+	if !s.deliveries.submit(s.eqslUploadTask(event.LogbookID, event.Qso.ID)) {
+		s.logger.ErrorWith().Int64("qso_id", event.Qso.ID).Msg("eQSL upload queue full; QSO not uploaded")
+	}
+}
+
+// eqslUploadTask returns the upload of a QSO, for a logbook with an eQSL account, as a task of
+// the delivery pool. The task makes a single attempt: failed uploads are recorded in qso_uploads,
+// from where the periodic sync retries them, and so is an upload still queued at shutdown.
+func (s *Service) eqslUploadTask(logbookID, qsoID int64) *deliveryTask {
+	const op errors.Op = "server.Service.eqslUploadTask"
+
+	return &deliveryTask{
+		destination: deliveryDestinationEqsl,
+		maxAttempts: 1,
+		run: func(ctx context.Context, _ int) error {
+			account, found, err := s.fetchEqslAccount(ctx, logbookID)
+			if err != nil {
+				err = errors.New(op).Err(err)
+				s.logger.ErrorWith().Err(err).Int64("qso_id", qsoID).Msg("Failed to fetch eQSL account")
+				return err
+			}
+			if !found {
+				return nil
+			}
+			return s.uploadQsoToEqsl(ctx, account, qsoID)
+		},
+		handBack: func(ctx context.Context, _ int) {
+			if err := s.recordQsoUpload(ctx, qsoID, uploadServiceEqsl, errors.New(op).Msg("Server stopped before the upload")); err != nil {
+				s.logger.ErrorWith().Err(err).Int64("qso_id", qsoID).Msg("Failed to record eQSL upload")
+			}
+		},
+	}
+}
+
+// uploadQsoToEqsl uploads one QSO and records the outcome in qso_uploads, from where failed
+// uploads are retried by the periodic sync.
+func (s *Service) uploadQsoToEqsl(ctx context.Context, account eqslAccount, qsoID int64) error {
+	const op errors.Op = "server.Service.uploadQsoToEqsl"
+
+	err := s.doEqslUpload(ctx, account, qsoID)
+	if err != nil {
+		err = errors.New(op).Err(err)
+		s.logger.WarnWith().Err(err).Int64("qso_id", qsoID).Msg("eQSL upload failed")
+	}
+	if recErr := s.recordQsoUpload(ctx, qsoID, uploadServiceEqsl, err); recErr != nil {
+		s.logger.ErrorWith().Err(recErr).Int64("qso_id", qsoID).Msg("Failed to record eQSL upload")
+	}
+	return err
+}
+
+func (s *Service) doEqslUpload(ctx context.Context, account eqslAccount, qsoID int64) error {
+	const op errors.Op = "server.Service.doEqslUpload"
+
+	password, err := s.decryptCredential(account.Password)
+	if err != nil {
+		return errors.New(op).Err(err)
+	}
+	logbook, err := s.fetchLogbookWithCache(ctx, account.LogbookID)
+	if err != nil {
+		return errors.New(op).Err(err)
+	}
+	qso, err := s.db.FetchQsoByIdContext(ctx, qsoID)
+	if err != nil {
+		return errors.New(op).Err(err)
+	}
+
+	record := qsoAdifRecord(qso, logbook)
+	if account.QthNickname != emptyString {
+		record["APP_EQSL_QTH_NICKNAME"] = account.QthNickname
+	}
+	if err = s.eqsl.upload(ctx, account.Username, password, record); err != nil {
+		return errors.New(op).Err(err)
+	}
+	return nil
+}
+
+// queueEqslSyncs queues a sync of every configured eQSL account.
+func (s *Service) queueEqslSyncs(ctx context.Context) error {
+	const op errors.Op = "server.Service.queueEqslSyncs"
+
+	accounts, err := s.listEqslAccounts(ctx)
+	if err != nil {
+		return errors.New(op).Err(err)
+	}
+	for _, account := range accounts {
+		if _, _, err = s.enqueueJob(ctx, newJob{Kind: jobKindEqslSync, LogbookID: account.LogbookID}); err != nil {
+			s.logger.ErrorWith().Err(errors.New(op).Err(err)).Int64("logbook_id", account.LogbookID).Msg("Failed to queue eQSL sync")
+		}
+	}
+	return nil
+}
+
+// runEqslSyncJob syncs the eQSL account of the job's logbook.
+func (s *Service) runEqslSyncJob(ctx context.Context, j job) (any, error) {
+	const op errors.Op = "server.Service.runEqslSyncJob"
+
+	account, found, err := s.fetchEqslAccount(ctx, j.LogbookID)
+	if err != nil {
+		return nil, errors.New(op).Err(err)
+	}
+	if !found {
+		return nil, permanentJobFailure(errors.New(op).Msg("eQSL is not configured"))
+	}
+	return s.syncEqslAccount(ctx, account)
+}
+
+// syncEqslAccount retries failed uploads, then downloads newly received eQSLs and records those
+// that match a logged QSO. The outcome is saved on the account either way.
+func (s *Service) syncEqslAccount(ctx context.Context, account eqslAccount) (eqslSyncResult, error) {
+	const op errors.Op = "server.Service.syncEqslAccount"
+
+	startedAt := time.Now().UTC()
+	result, err := s.doEqslSync(ctx, account)
+	if err != nil {
+		err = errors.New(op).Err(err)
+		if saveErr := s.saveEqslSyncState(ctx, account.LogbookID, account.RcvdSince, errors.Root(err).Error()); saveErr != nil {
+			s.logger.ErrorWith().Err(saveErr).Int64("logbook_id", account.LogbookID).Msg("Failed to save eQSL sync state")
+		}
+		return result, err
+	}
+
+	if err = s.saveEqslSyncState(ctx, account.LogbookID, startedAt.Format(eqslRcvdSinceLayout), emptyString); err != nil {
+		return result, errors.New(op).Err(err)
+	}
+	s.logger.InfoWith().Int64("logbook_id", account.LogbookID).Int("retried", result.Retried).Int("received", result.Received).
+		Int("matched", result.Matched).Int("new", result.New).Msg("eQSL sync completed")
+
+	return result, nil
+}
+
+func (s *Service) doEqslSync(ctx context.Context, account eqslAccount) (eqslSyncResult, error) {
+	const op errors.Op = "server.Service.doEqslSync"
+	var result eqslSyncResult
+
+	retry, err := s.listRetryableUploads(ctx, uploadServiceEqsl, account.LogbookID)
+	if err != nil {
+		return result, errors.New(op).Err(err)
+	}
+	for _, qsoID := range retry {
+		if err = s.deliveries.wait(ctx, deliveryDestinationEqsl); err != nil {
+			return result, errors.New(op).Err(err)
+		}
+		// Failures are recorded per QSO and do not stop the inbox download.
+		_ = s.uploadQsoToEqsl(ctx, account, qsoID)
+		result.Retried++
+	}
+
+	password, err := s.decryptCredential(account.Password)
+	if err != nil {
+		return result, errors.New(op).Err(err)
+	}
+	if err = s.deliveries.wait(ctx, deliveryDestinationEqsl); err != nil {
+		return result, errors.New(op).Err(err)
+	}
+	doc, err := s.eqsl.fetchInbox(ctx, account.Username, password, account.QthNickname, account.RcvdSince)
+	if err != nil {
+		return result, errors.New(op).Err(err)
+	}
+
+	today := time.Now().UTC().Format("20060102")
+	for _, rec := range doc.Records {
+		result.Received++
+
+		key := qsoKey{Call: rec["CALL"], Band: rec["BAND"], Mode: rec["MODE"], QsoDate: rec["QSO_DATE"], TimeOn: rec["TIME_ON"]}
+		qsoID, found, err := s.findMatchingQso(ctx, account.LogbookID, key)
+		if err != nil {
+			return result, errors.New(op).Err(err)
+		}
+		if !found {
+			continue
+		}
+		result.Matched++
+
+		received := rec["QSLRDATE"]
+		if received == emptyString {
+			received = today
+		}
+		isNew, err := s.upsertQsoConfirmation(ctx, qsoID, qsoConfirmation{Source: confirmationSourceEqsl, ReceivedDate: received})
+		if err != nil {
+			return result, errors.New(op).Err(err)
+		}
+		if isNew {
+			result.New++
+			s.publishQsoUpdated(ctx, qsoID)
+		}
+	}
+
+	return result, nil
+}
+
+// fetchEqslAccount returns the logbook's eQSL account, if one is configured.
+func (s *Service) fetchEqslAccount(ctx context.Context, logbookID int64) (eqslAccount, bool, error) {
+	const op errors.Op = "server.Service.fetchEqslAccount"
+
+	accounts, err := s.queryEqslAccounts(ctx, `WHERE logbook_id = $1`, logbookID)
+	if err != nil {
+		return eqslAccount{}, false, errors.New(op).Err(err)
+	}
+	if len(accounts) == 0 {
+		return eqslAccount{}, false, nil
+	}
+	return accounts[0], true, nil
+}
+
+func (s *Service) listEqslAccounts(ctx context.Context) ([]eqslAccount, error) {
+	return s.queryEqslAccounts(ctx, `WHERE logbook_id IN `+liveLogbookIDs+` ORDER BY logbook_id`)
+}
+
+func (s *Service) queryEqslAccounts(ctx context.Context, clause string, args ...interface{}) ([]eqslAccount, error) {
+	const op errors.Op = "server.Service.queryEqslAccounts"
+
+	rows, err := s.db.QueryContext(ctx, `SELECT logbook_id, username, password, qth_nickname, rcvd_since, last_sync_at, last_error
+		FROM eqsl_accounts `+clause, args...)
+	if err != nil {
+		return nil, errors.New(op).Err(err)
+	}
+	defer func() { _ = rows.Close() }()
+
+	accounts := make([]eqslAccount, 0)
+	for rows.Next() {
+		var a eqslAccount
+		if err = rows.Scan(&a.LogbookID, &a.Username, &a.Password, &a.QthNickname, &a.RcvdSince, &a.LastSyncAt, &a.LastError); err != nil {
+			return nil, errors.New(op).Err(err)
+		}
+		accounts = append(accounts, a)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, errors.New(op).Err(err)
+	}
+	return accounts, nil
+}
+
+// saveEqslAccount stores the logbook's eQSL credentials. The inbox position is kept unless the
+// username or QTH nickname changes, in which case the next sync downloads the whole inbox.
+func (s *Service) saveEqslAccount(ctx context.Context, logbookID int64, username, password, qthNickname string) error {
+	const op errors.Op = "server.Service.saveEqslAccount"
+
+	encrypted, err := s.encryptCredential(password)
+	if err != nil {
+		return errors.New(op).Err(err)
+	}
+
+	if _, err = s.db.ExecContext(ctx, `INSERT INTO eqsl_accounts (logbook_id, username, password, qth_nickname) VALUES ($1, $2, $3, $4)
+		ON CONFLICT (logbook_id) DO UPDATE SET
+			rcvd_since = CASE WHEN eqsl_accounts.username = excluded.username AND eqsl_accounts.qth_nickname = excluded.qth_nickname
+				THEN eqsl_accounts.rcvd_since ELSE '' END,
+			username = excluded.username, password = excluded.password, qth_nickname = excluded.qth_nickname, last_error = ''`,
+		logbookID, username, encrypted, qthNickname); err != nil {
+		return errors.New(op).Err(err)
+	}
+	return nil
+}
+
+func (s *Service) saveEqslSyncState(ctx context.Context, logbookID int64, rcvdSince, lastError string) error {
+	const op errors.Op = "server.Service.saveEqslSyncState"
+
+	if _, err := s.db.ExecContext(ctx, `UPDATE eqsl_accounts SET rcvd_since = $2, last_error = $3, last_sync_at = CURRENT_TIMESTAMP
+		WHERE logbook_id = $1`, logbookID, rcvdSince, lastError); err != nil {
+		return errors.New(op).Err(err)
+	}
+	return nil
+}
+
+// deleteEqslAccount removes the logbook's eQSL account. Confirmations already recorded are kept.
+func (s *Service) deleteEqslAccount(ctx context.Context, logbookID int64) (bool, error) {
+	const op errors.Op = "server.Service.deleteEqslAccount"
+
+	res, err := s.db.ExecContext(ctx, `DELETE FROM eqsl_accounts WHERE logbook_id = $1`, logbookID)
+	if err != nil {
+		return false, errors.New(op).Err(err)
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return false, errors.New(op).Err(err)
+	}
+	return n > 0, nil
+}
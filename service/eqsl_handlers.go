@@ -0,0 +1,126 @@
+package service
+
+import (
+	"github.com/Station-Manager/errors"
+	"github.com/gofiber/fiber/v2"
+)
+
+// eqslAccountRequest is the body of a request to configure eQSL for a logbook.
+type eqslAccountRequest struct {
+	Username    string `json:"username" validate:"required,max=64"`
+	Password    string `json:"password" validate:"required,max=256"`
+	QthNickname string `json:"qth_nickname" validate:"max=64"`
+}
+
+// getEqslAccountHandler reports whether eQSL is configured for the authenticated logbook and how
+// the last sync went. The password is never returned.
+func (s *Service) getEqslAccountHandler(c *fiber.Ctx) error {
+	const op errors.Op = "server.Service.getEqslAccountHandler"
+
+	logbook, err := authenticatedLogbook(c)
+	if err != nil {
+		s.logger.ErrorWith().Err(errors.New(op).Err(err)).Msg("authenticatedLogbook failed")
+		return c.Status(fiber.StatusInternalServerError).JSON(jsonInternalError)
+	}
+
+	account, found, err := s.fetchEqslAccount(c.UserContext(), logbook.ID)
+	if err != nil {
+		s.logger.ErrorWith().Err(errors.New(op).Err(err)).Msg("s.fetchEqslAccount failed")
+		return s.dbFailure(c, err)
+	}
+	if !found {
+		return c.JSON(fiber.Map{"configured": false})
+	}
+
+	status := fiber.Map{
+		"configured":   true,
+		"username":     account.Username,
+		"qth_nickname": account.QthNickname,
+		"last_error":   account.LastError,
+	}
+	if account.LastSyncAt.Valid {
+		status["last_sync_at"] = account.LastSyncAt.Time
+	}
+	return c.JSON(status)
+}
+
+// putEqslAccountHandler stores eQSL credentials for the authenticated logbook. QSOs inserted from
+// then on are uploaded to eQSL; earlier QSOs are not.
+func (s *Service) putEqslAccountHandler(c *fiber.Ctx) error {
+	const op errors.Op = "server.Service.putEqslAccountHandler"
+
+	logbook, err := authenticatedLogbook(c)
+	if err != nil {
+		s.logger.ErrorWith().Err(errors.New(op).Err(err)).Msg("authenticatedLogbook failed")
+		return c.Status(fiber.StatusInternalServerError).JSON(jsonInternalError)
+	}
+	if len(s.credentialsKey) == 0 {
+		return c.Status(fiber.StatusServiceUnavailable).JSON(jsonError(errCodeCredentialStorageDisabled, "Credential storage is not configured on this server"))
+	}
+
+	var request eqslAccountRequest
+	if err = c.BodyParser(&request); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(jsonBadRequest)
+	}
+	if err = s.validate.Struct(request); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(jsonBadRequest)
+	}
+
+	if err = s.saveEqslAccount(c.UserContext(), logbook.ID, request.Username, request.Password, request.QthNickname); err != nil {
+		s.logger.ErrorWith().Err(errors.New(op).Err(err)).Msg("s.saveEqslAccount failed")
+		return s.dbFailure(c, err)
+	}
+
+	return c.SendStatus(fiber.StatusNoContent)
+}
+
+// deleteEqslAccountHandler removes the authenticated logbook's eQSL credentials.
+func (s *Service) deleteEqslAccountHandler(c *fiber.Ctx) error {
+	const op errors.Op = "server.Service.deleteEqslAccountHandler"
+
+	logbook, err := authenticatedLogbook(c)
+	if err != nil {
+		s.logger.ErrorWith().Err(errors.New(op).Err(err)).Msg("authenticatedLogbook failed")
+		return c.Status(fiber.StatusInternalServerError).JSON(jsonInternalError)
+	}
+
+	found, err := s.deleteEqslAccount(c.UserContext(), logbook.ID)
+	if err != nil {
+		s.logger.ErrorWith().Err(errors.New(op).Err(err)).Msg("s.deleteEqslAccount failed")
+		return s.dbFailure(c, err)
+	}
+	if !found {
+		return c.Status(fiber.StatusNotFound).JSON(jsonError(errCodeIntegrationNotConfigured, "eQSL is not configured"))
+	}
+
+	return c.SendStatus(fiber.StatusNoContent)
+}
+
+// syncEqslHandler queues an eQSL sync of the authenticated logbook and answers with its job. A sync
+// already waiting or running is answered instead of queueing another.
+func (s *Service) syncEqslHandler(c *fiber.Ctx) error {
+	const op errors.Op = "server.Service.syncEqslHandler"
+
+	logbook, err := authenticatedLogbook(c)
+	if err != nil {
+		s.logger.ErrorWith().Err(errors.New(op).Err(err)).Msg("authenticatedLogbook failed")
+		return c.Status(fiber.StatusInternalServerError).JSON(jsonInternalError)
+	}
+
+	account, found, err := s.fetchEqslAccount(c.UserContext(), logbook.ID)
+	if err != nil {
+		s.logger.ErrorWith().Err(errors.New(op).Err(err)).Msg("s.fetchEqslAccount failed")
+		return s.dbFailure(c, err)
+	}
+	if !found {
+		return c.Status(fiber.StatusNotFound).JSON(jsonError(errCodeIntegrationNotConfigured, "eQSL is not configured"))
+	}
+
+	j, _, err := s.enqueueJob(c.UserContext(), newJob{Kind: jobKindEqslSync, LogbookID: account.LogbookID})
+	if err != nil {
+		s.logger.ErrorWith().Err(errors.New(op).Err(err)).Msg("s.enqueueJob failed")
+		return s.dbFailure(c, err)
+	}
+
+	return c.Status(fiber.StatusAccepted).JSON(fiber.Map{"job": j})
+}
@@ -0,0 +1,181 @@
+package service
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/Station-Manager/server/service/adif"
+)
+
+const testEqslInbox = `<eoh>
+<CALL:5>JA1XX <BAND:3>20M <MODE:3>FT8 <QSO_DATE:8>20240430 <TIME_ON:4>1205 <QSLRDATE:8>20240502 <eor>
+<CALL:4>W1AW <BAND:3>20M <MODE:3>FT8 <QSO_DATE:8>20240430 <TIME_ON:4>1205 <eor>
+`
+
+// fakeEqsl records requests to a stand-in for eQSL.cc and answers uploads with uploadReply.
+type fakeEqsl struct {
+	mu          sync.Mutex
+	uploads     []url.Values
+	inbox       []url.Values
+	uploadReply string
+	inboxReply  string
+}
+
+func newFakeEqsl(t *testing.T, svc *Service) *fakeEqsl {
+	t.Helper()
+
+	svc.credentialsKey = make([]byte, 32)
+	svc.eqsl = newEqslClient()
+
+	fake := &fakeEqsl{
+		uploadReply: "Result: 1 out of 1 records added<BR>",
+		inboxReply:  `<HTML><BODY>Your ADIF log file has been built. <A HREF="../downloadedfiles/7q5mlv.adi">.ADI file</A></BODY></HTML>`,
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/qslcard/ImportADIF.cfm", func(w http.ResponseWriter, r *http.Request) {
+		_ = r.ParseForm()
+		fake.mu.Lock()
+		defer fake.mu.Unlock()
+		fake.uploads = append(fake.uploads, r.PostForm)
+		_, _ = io.WriteString(w, fake.uploadReply)
+	})
+	mux.HandleFunc("/qslcard/DownloadInBox.cfm", func(w http.ResponseWriter, r *http.Request) {
+		fake.mu.Lock()
+		defer fake.mu.Unlock()
+		fake.inbox = append(fake.inbox, r.URL.Query())
+		_, _ = io.WriteString(w, fake.inboxReply)
+	})
+	mux.HandleFunc("/downloadedfiles/7q5mlv.adi", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.WriteString(w, testEqslInbox)
+	})
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+	svc.eqsl.uploadURL = srv.URL + "/qslcard/ImportADIF.cfm"
+	svc.eqsl.inboxURL = srv.URL + "/qslcard/DownloadInBox.cfm"
+
+	return fake
+}
+
+func TestUploadQsoToEqsl_RecordsAndRetriesFailures(t *testing.T) {
+	svc := newTestServer(t)
+	fake := newFakeEqsl(t, svc)
+	ctx := context.Background()
+	qsoID := insertTestQso(t, svc, "JA1XX", "20m", "FT8", "20240430", "1203")
+
+	if err := svc.saveEqslAccount(ctx, 1, "7Q5MLV", "hunter2", "HOME"); err != nil {
+		t.Fatalf("saveEqslAccount failed: %v", err)
+	}
+	account, found, err := svc.fetchEqslAccount(ctx, 1)
+	if err != nil || !found {
+		t.Fatalf("fetchEqslAccount failed: %v (found=%v)", err, found)
+	}
+
+	fake.uploadReply = "Error: No match on eQSL_User/eQSL_Pswd"
+	if err = svc.uploadQsoToEqsl(ctx, account, qsoID); err == nil {
+		t.Fatal("expected the upload to fail")
+	}
+	retry, err := svc.listRetryableUploads(ctx, uploadServiceEqsl, 1)
+	if err != nil || len(retry) != 1 || retry[0] != qsoID {
+		t.Fatalf("expected QSO %d to be retryable, got %v (%v)", qsoID, retry, err)
+	}
+
+	form := fake.uploads[0]
+	if form.Get("EQSL_USER") != "7Q5MLV" || form.Get("EQSL_PSWD") != "hunter2" {
+		t.Errorf("unexpected upload credentials %v", form)
+	}
+	doc, err := adif.Parse(strings.NewReader(form.Get("ADIFData")))
+	if err != nil || len(doc.Records) != 1 {
+		t.Fatalf("unexpected ADIF upload %q (%v)", form.Get("ADIFData"), err)
+	}
+	if r := doc.Records[0]; r["CALL"] != "JA1XX" || r["STATION_CALLSIGN"] != "7Q5MLV" || r["APP_EQSL_QTH_NICKNAME"] != "HOME" {
+		t.Errorf("unexpected uploaded record %+v", r)
+	}
+
+	// The sync retries the failed upload, then downloads the inbox.
+	fake.uploadReply = "Result: 1 out of 1 records added<BR>"
+	sub := svc.qsoEvents.Subscribe(1)
+	defer svc.qsoEvents.Unsubscribe(sub)
+
+	result, err := svc.syncEqslAccount(ctx, account)
+	if err != nil {
+		t.Fatalf("syncEqslAccount failed: %v", err)
+	}
+	if result != (eqslSyncResult{Retried: 1, Received: 2, Matched: 1, New: 1}) {
+		t.Errorf("unexpected result %+v", result)
+	}
+	if retry, _ = svc.listRetryableUploads(ctx, uploadServiceEqsl, 1); len(retry) != 0 {
+		t.Errorf("expected no retryable uploads, got %v", retry)
+	}
+	if q := fake.inbox[0]; q.Get("UserName") != "7Q5MLV" || q.Get("QTHNickname") != "HOME" || q.Has("RcvdSince") {
+		t.Errorf("unexpected first inbox query %v", q)
+	}
+
+	confirmations, err := svc.listQsoConfirmations(ctx, qsoID)
+	if err != nil || len(confirmations) != 1 || confirmations[0].Source != confirmationSourceEqsl {
+		t.Errorf("expected an eQSL confirmation, got %+v (%v)", confirmations, err)
+	}
+	select {
+	case event := <-sub.Events():
+		if event.Type != qsoEventUpdated || event.Qso.ID != qsoID {
+			t.Errorf("unexpected event %+v", event)
+		}
+	default:
+		t.Error("expected a qso.updated event for the new confirmation")
+	}
+
+	// The next inbox download resumes from the previous sync.
+	account, _, _ = svc.fetchEqslAccount(ctx, 1)
+	if len(account.RcvdSince) != len(eqslRcvdSinceLayout) || !account.LastSyncAt.Valid {
+		t.Errorf("unexpected sync state %+v", account)
+	}
+	if result, err = svc.syncEqslAccount(ctx, account); err != nil || result.New != 0 {
+		t.Errorf("expected no new confirmations on resync, got %+v (%v)", result, err)
+	}
+	if q := fake.inbox[1]; q.Get("RcvdSince") != account.RcvdSince {
+		t.Errorf("expected the second inbox query to resume, got %v", q)
+	}
+}
+
+func TestSyncEqslAccount_InboxResponses(t *testing.T) {
+	svc := newTestServer(t)
+	fake := newFakeEqsl(t, svc)
+	ctx := context.Background()
+
+	if err := svc.saveEqslAccount(ctx, 1, "7Q5MLV", "wrong", emptyString); err != nil {
+		t.Fatalf("saveEqslAccount failed: %v", err)
+	}
+	account, _, _ := svc.fetchEqslAccount(ctx, 1)
+
+	fake.inboxReply = "<HTML><BODY>You have no log entries</BODY></HTML>"
+	if result, err := svc.syncEqslAccount(ctx, account); err != nil || result.Received != 0 {
+		t.Errorf("expected an empty inbox, got %+v (%v)", result, err)
+	}
+
+	fake.inboxReply = "<HTML><BODY>Error: No such Username/Password found</BODY></HTML>"
+	if _, err := svc.syncEqslAccount(ctx, account); err == nil {
+		t.Fatal("expected the sync to fail")
+	}
+	account, _, _ = svc.fetchEqslAccount(ctx, 1)
+	if account.LastError != "eQSL: No such Username/Password found" {
+		t.Errorf("expected the failure to be recorded, got %q", account.LastError)
+	}
+}
+
+func TestEnqueueEqslUpload_OnlyInsertedQsos(t *testing.T) {
+	svc := newTestServer(t)
+	newFakeEqsl(t, svc)
+	svc.deliveries = newDeliveryPool()
+
+	svc.enqueueEqslUpload(qsoEvent{Type: qsoEventUpdated, LogbookID: 1})
+	svc.enqueueEqslUpload(qsoEvent{Type: qsoEventInserted, LogbookID: 1})
+
+	if n := svc.deliveries.queued(deliveryDestinationEqsl); n != 1 {
+		t.Errorf("expected 1 queued upload, got %d", n)
+	}
+}
@@ -3,3 +3,140 @@ package service
 const (
 	errMsgNilContext = "Context is nil."
 )
+
+// errorCode is a stable identifier attached to every JSON error response as "code", so that clients
+// can branch on the kind of failure without parsing the English "message". Codes are never renamed
+// or reused once released; new failures get new codes.
+type errorCode string
+
+const (
+	// errCodeInternal: the server failed; the request may be retried later.
+	errCodeInternal errorCode = "ERR_INTERNAL"
+	// errCodeBadRequest: the request body or parameters are malformed or fail validation.
+	errCodeBadRequest errorCode = "ERR_BAD_REQUEST"
+	// errCodeInvalidFields: strict decoding rejected fields of the body; "fields" lists them.
+	errCodeInvalidFields errorCode = "ERR_INVALID_FIELDS"
+	// errCodeUnauthorized: the credentials or API key are missing or invalid.
+	errCodeUnauthorized errorCode = "ERR_UNAUTHORIZED"
+	// errCodeNotFound: the route or resource does not exist.
+	errCodeNotFound errorCode = "ERR_NOT_FOUND"
+	// errCodeMethodNotAllowed: the route exists but not for this method.
+	errCodeMethodNotAllowed errorCode = "ERR_METHOD_NOT_ALLOWED"
+	// errCodeBodyTooLarge: the request body exceeds the server's limit.
+	errCodeBodyTooLarge errorCode = "ERR_BODY_TOO_LARGE"
+	// errCodeInsertQueueFull: too many QSOs are waiting to be stored; retry after the Retry-After delay.
+	errCodeInsertQueueFull errorCode = "ERR_INSERT_QUEUE_FULL"
+	// errCodeTimeout: the server did not finish the request in time; it may be retried.
+	errCodeTimeout errorCode = "ERR_TIMEOUT"
+	// errCodeConflict: the request conflicts with a record that already exists.
+	errCodeConflict errorCode = "ERR_CONFLICT"
+	// errCodeInvalidReference: the request refers to a record that does not exist, or would remove
+	// one that others still refer to.
+	errCodeInvalidReference errorCode = "ERR_INVALID_REFERENCE"
+	// errCodeMaintenance: the server is in maintenance mode; retry after the Retry-After delay.
+	errCodeMaintenance errorCode = "ERR_MAINTENANCE"
+
+	// errCodeDuplicateQso: the logbook already holds a QSO with the same date, time and details.
+	errCodeDuplicateQso errorCode = "ERR_DUPLICATE_QSO"
+	// errCodeCallsignMismatch: the QSO's station_callsign differs from the logbook's callsign.
+	errCodeCallsignMismatch errorCode = "ERR_CALLSIGN_MISMATCH"
+	// errCodeQsoNotFound: the QSO does not exist in the authenticated logbook.
+	errCodeQsoNotFound errorCode = "ERR_QSO_NOT_FOUND"
+	// errCodeInsertNotFound: the queued insert does not exist in the logbook, or finished long ago.
+	errCodeInsertNotFound errorCode = "ERR_INSERT_NOT_FOUND"
+	// errCodeInvalidAdif: the imported document is not ADIF or holds no records.
+	errCodeInvalidAdif errorCode = "ERR_INVALID_ADIF"
+	// errCodeLogbookNotFound: the logbook does not exist or belongs to another user.
+	errCodeLogbookNotFound errorCode = "ERR_LOGBOOK_NOT_FOUND"
+	// errCodeLogbookNameTaken: another logbook already has the requested name.
+	errCodeLogbookNameTaken errorCode = "ERR_LOGBOOK_NAME_TAKEN"
+	// errCodeLogbookArchived: the logbook is archived, so its QSOs cannot be changed.
+	errCodeLogbookArchived errorCode = "ERR_LOGBOOK_ARCHIVED"
+	// errCodeInvalidConfirmation: the confirmation token is missing, invalid or expired.
+	errCodeInvalidConfirmation errorCode = "ERR_INVALID_CONFIRMATION"
+	// errCodeInsufficientRole: the caller's role on the logbook does not allow the request.
+	errCodeInsufficientRole errorCode = "ERR_INSUFFICIENT_ROLE"
+	// errCodeUserNotFound: no user has the given callsign.
+	errCodeUserNotFound errorCode = "ERR_USER_NOT_FOUND"
+	// errCodeAccountDisabled: an admin has disabled the account.
+	errCodeAccountDisabled errorCode = "ERR_ACCOUNT_DISABLED"
+	// errCodeApiKeyNotFound: no active API key has the given prefix.
+	errCodeApiKeyNotFound errorCode = "ERR_API_KEY_NOT_FOUND"
+
+	// errCodeWebhookNotFound: the webhook does not exist in the authenticated logbook.
+	errCodeWebhookNotFound errorCode = "ERR_WEBHOOK_NOT_FOUND"
+	// errCodeWebhookLimit: the logbook already has the maximum number of webhooks.
+	errCodeWebhookLimit errorCode = "ERR_WEBHOOK_LIMIT"
+	// errCodeInvalidWebhookURL: the webhook URL is not an absolute http or https URL.
+	errCodeInvalidWebhookURL errorCode = "ERR_INVALID_WEBHOOK_URL"
+
+	// errCodeShareNotFound: the share link does not exist, or is revoked or expired.
+	errCodeShareNotFound errorCode = "ERR_SHARE_NOT_FOUND"
+	// errCodeShareLinkLimit: the logbook already has the maximum number of active share links.
+	errCodeShareLinkLimit errorCode = "ERR_SHARE_LINK_LIMIT"
+
+	// errCodeWebSocketRequired: the stream endpoint was called without a WebSocket upgrade.
+	errCodeWebSocketRequired errorCode = "ERR_WEBSOCKET_REQUIRED"
+	// errCodeInvalidEventID: the Last-Event-ID header is not a valid event ID.
+	errCodeInvalidEventID errorCode = "ERR_INVALID_EVENT_ID"
+
+	// errCodeCredentialStorageDisabled: the server has no key to encrypt third-party credentials.
+	errCodeCredentialStorageDisabled errorCode = "ERR_CREDENTIAL_STORAGE_DISABLED"
+	// errCodeIntegrationUnavailable: the integration is not enabled on this server.
+	errCodeIntegrationUnavailable errorCode = "ERR_INTEGRATION_UNAVAILABLE"
+	// errCodeIntegrationNotConfigured: the integration has not been set up for the logbook.
+	errCodeIntegrationNotConfigured errorCode = "ERR_INTEGRATION_NOT_CONFIGURED"
+	// errCodeUpstreamFailed: a third-party service rejected or failed the request.
+	errCodeUpstreamFailed errorCode = "ERR_UPSTREAM_FAILED"
+	// errCodeUploadInProgress: an upload of the logbook is already running.
+	errCodeUploadInProgress errorCode = "ERR_UPLOAD_IN_PROGRESS"
+
+	// errCodeUnknownAward: the award is not one the server tracks.
+	errCodeUnknownAward errorCode = "ERR_UNKNOWN_AWARD"
+
+	// errCodeBackupsDisabled: no backup location is configured on this server.
+	errCodeBackupsDisabled errorCode = "ERR_BACKUPS_DISABLED"
+	// errCodeBackupInProgress: a backup is already running; retry when it has finished.
+	errCodeBackupInProgress errorCode = "ERR_BACKUP_IN_PROGRESS"
+	// errCodeJobNotFound: the background job does not exist, belongs to another logbook or was purged.
+	errCodeJobNotFound errorCode = "ERR_JOB_NOT_FOUND"
+	// errCodeInvalidSyncToken: the sync token was not issued by this server; pull again without one.
+	errCodeInvalidSyncToken errorCode = "ERR_INVALID_SYNC_TOKEN"
+
+	// errCodeQslImagesDisabled: no QSL image location is configured on this server.
+	errCodeQslImagesDisabled errorCode = "ERR_QSL_IMAGES_DISABLED"
+	// errCodeQslImageNotFound: the image does not exist or is attached to another QSO.
+	errCodeQslImageNotFound errorCode = "ERR_QSL_IMAGE_NOT_FOUND"
+	// errCodeQslImageLimit: the QSO already has the maximum number of QSL images.
+	errCodeQslImageLimit errorCode = "ERR_QSL_IMAGE_LIMIT"
+	// errCodeUnsupportedImage: the upload is not a JPEG, PNG or GIF image the server can read.
+	errCodeUnsupportedImage errorCode = "ERR_UNSUPPORTED_IMAGE"
+	// errCodeContestDupe: the logbook's running contest has the station worked already, under its dupe rule.
+	errCodeContestDupe errorCode = "ERR_CONTEST_DUPE"
+	// errCodeContestNotRunning: the logbook is not running a contest.
+	errCodeContestNotRunning errorCode = "ERR_CONTEST_NOT_RUNNING"
+	// errCodeEmailDisabled: no SMTP server is configured on this server, so nothing can be emailed.
+	errCodeEmailDisabled errorCode = "ERR_EMAIL_DISABLED"
+	// errCodeStationProfileNotFound: the logbook has no station profile of that ID or name.
+	errCodeStationProfileNotFound errorCode = "ERR_STATION_PROFILE_NOT_FOUND"
+	// errCodeStationProfileExists: the logbook already has a station profile of that name.
+	errCodeStationProfileExists errorCode = "ERR_STATION_PROFILE_EXISTS"
+	// errCodeInvalidQsoTime: a QSO's date or time cannot be read, is ambiguous, or is in the future;
+	// "field" names it.
+	errCodeInvalidQsoTime errorCode = "ERR_INVALID_QSO_TIME"
+	// errCodeInvalidCallsign: a callsign is not of a callsign's form or has an unallocated prefix;
+	// "field" names it.
+	errCodeInvalidCallsign errorCode = "ERR_INVALID_CALLSIGN"
+	// errCodeQuotaExceeded: the request would take the account past one of its quotas; "quota"
+	// names it and "limit" gives its limit.
+	errCodeQuotaExceeded errorCode = "ERR_QUOTA_EXCEEDED"
+	// errCodeSandboxKey: sandbox keys may not use the route, or may not be used to issue sandbox keys.
+	errCodeSandboxKey errorCode = "ERR_SANDBOX_KEY"
+	// errCodeBulkCountChanged: the bulk operation's filter no longer selects the number of QSOs
+	// previewed; preview it again.
+	errCodeBulkCountChanged errorCode = "ERR_BULK_COUNT_CHANGED"
+	// errCodeImportBatchNotFound: the logbook has no import batch of that ID.
+	errCodeImportBatchNotFound errorCode = "ERR_IMPORT_BATCH_NOT_FOUND"
+	// errCodeImportUndone: the import batch has already been undone.
+	errCodeImportUndone errorCode = "ERR_IMPORT_UNDONE"
+)
@@ -0,0 +1,46 @@
+package service
+
+import (
+	"io"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/goccy/go-json"
+	"github.com/gofiber/fiber/v2"
+)
+
+func TestErrorResponses_CarryCodes(t *testing.T) {
+	svc := newTestServer(t)
+
+	app := fiber.New(fiber.Config{ErrorHandler: svc.jsonErrorHandler})
+	app.Delete("/webhooks/:id", withLogbook(1, svc.deleteWebhookHandler))
+	app.Post("/echo", func(c *fiber.Ctx) error { return c.SendStatus(fiber.StatusNoContent) })
+
+	cases := []struct {
+		method, path string
+		status       int
+		code         errorCode
+	}{
+		{"DELETE", "/webhooks/42", fiber.StatusNotFound, errCodeWebhookNotFound},
+		{"DELETE", "/webhooks/x", fiber.StatusBadRequest, errCodeBadRequest},
+		{"GET", "/nowhere", fiber.StatusNotFound, errCodeNotFound},
+		{"GET", "/echo", fiber.StatusMethodNotAllowed, errCodeMethodNotAllowed},
+	}
+	for _, c := range cases {
+		resp, err := app.Test(httptest.NewRequest(c.method, c.path, nil))
+		if err != nil {
+			t.Fatalf("%s %s: request failed: %v", c.method, c.path, err)
+		}
+		var body struct {
+			Code    errorCode `json:"code"`
+			Message string    `json:"message"`
+		}
+		raw, _ := io.ReadAll(resp.Body)
+		if err = json.Unmarshal(raw, &body); err != nil {
+			t.Fatalf("%s %s: expected a JSON body, got %q", c.method, c.path, raw)
+		}
+		if resp.StatusCode != c.status || body.Code != c.code || body.Message == emptyString {
+			t.Errorf("%s %s: expected %d %s, got %d %+v", c.method, c.path, c.status, c.code, resp.StatusCode, body)
+		}
+	}
+}
@@ -0,0 +1,290 @@
+package service
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/Station-Manager/types"
+	"github.com/goccy/go-json"
+)
+
+type qsoEventType string
+
+const (
+	qsoEventInserted qsoEventType = "qso.inserted"
+	qsoEventUpdated  qsoEventType = "qso.updated"
+	qsoEventDeleted  qsoEventType = "qso.deleted"
+	qsoEventRestored qsoEventType = "qso.restored"
+
+	// jobEventProgress and jobEventFinished report the progress and outcome of a job, such as an
+	// import, on its logbook's feed.
+	jobEventProgress qsoEventType = "job.progress"
+	jobEventFinished qsoEventType = "job.finished"
+)
+
+const (
+	// qsoSubscriptionBuffer is the number of events a subscriber may fall behind before it is dropped.
+	qsoSubscriptionBuffer = 64
+	// qsoEventHistorySize is the number of recent events retained, across all logbooks, so that
+	// reconnecting stream clients can resume where they left off.
+	qsoEventHistorySize = 1024
+)
+
+// qsoEvent describes a change to a QSO in a logbook, or, with Job set, the progress of one of the
+// logbook's jobs, with Contest set, the score of the logbook's contest, or, with Spot set, a DX
+// spot of a station the logbook needs.
+type qsoEvent struct {
+	ID        uint64        `json:"id"` // increases monotonically for the lifetime of the process
+	Type      qsoEventType  `json:"type"`
+	LogbookID int64         `json:"logbook_id"`
+	Time      time.Time     `json:"time"`
+	Qso       types.Qso     `json:"qso"`
+	QsoUUID   string        `json:"qso_uuid,omitempty"`
+	Job       *job          `json:"job,omitempty"`
+	Contest   *contestScore `json:"contest,omitempty"`
+	Spot      *neededSpot   `json:"spot,omitempty"`
+}
+
+// MarshalJSON leaves the QSO out of job, contest and spot events.
+func (e qsoEvent) MarshalJSON() ([]byte, error) {
+	type plainEvent qsoEvent
+	if e.Job == nil && e.Contest == nil && e.Spot == nil {
+		return json.Marshal(plainEvent(e))
+	}
+	return json.Marshal(struct {
+		ID        uint64        `json:"id"`
+		Type      qsoEventType  `json:"type"`
+		LogbookID int64         `json:"logbook_id"`
+		Time      time.Time     `json:"time"`
+		Job       *job          `json:"job,omitempty"`
+		Contest   *contestScore `json:"contest,omitempty"`
+		Spot      *neededSpot   `json:"spot,omitempty"`
+	}{e.ID, e.Type, e.LogbookID, e.Time, e.Job, e.Contest, e.Spot})
+}
+
+// qsoSubscription receives the events for a single logbook. Events is closed when the
+// subscriber falls too far behind or the broker is closed.
+type qsoSubscription struct {
+	logbookID int64
+	events    chan qsoEvent
+}
+
+// Events returns the channel on which events are delivered.
+func (sub *qsoSubscription) Events() <-chan qsoEvent {
+	return sub.events
+}
+
+// qsoEventBroker fans QSO events out to in-process subscribers, per logbook.
+// Publishing never blocks: a subscriber whose buffer is full is dropped so that one slow
+// client cannot stall QSO inserts.
+type qsoEventBroker struct {
+	mu          sync.Mutex
+	nextID      uint64
+	closed      bool
+	subscribers map[int64]map[*qsoSubscription]struct{}
+	history     []qsoEvent // ring buffer of the most recent events
+	historyNext int        // index in history that the next event is written to
+	listeners   []func(qsoEvent)
+}
+
+func newQsoEventBroker() *qsoEventBroker {
+	return &qsoEventBroker{
+		subscribers: make(map[int64]map[*qsoSubscription]struct{}),
+		history:     make([]qsoEvent, 0, qsoEventHistorySize),
+	}
+}
+
+// Publish assigns the event an ID and timestamp and delivers it to the logbook's subscribers.
+// The published event is returned.
+func (b *qsoEventBroker) Publish(event qsoEvent) qsoEvent {
+	if b == nil {
+		return event
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	event = b.stampLocked(event)
+	b.recordLocked(event)
+
+	for _, fn := range b.listeners {
+		fn(event)
+	}
+	b.deliverLocked(event)
+
+	return event
+}
+
+// Announce delivers an event that is not a QSO change, such as a job's progress, to the logbook's
+// subscribers only. The OnPublish listeners do not see it, and it is not retained for replay, so
+// that frequent progress events do not push QSO changes out of the history.
+func (b *qsoEventBroker) Announce(event qsoEvent) qsoEvent {
+	if b == nil {
+		return event
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	event = b.stampLocked(event)
+	b.deliverLocked(event)
+	return event
+}
+
+// OnPublish registers fn to be called with every published event, for all logbooks, in
+// publication order. fn is called with the broker locked and must not block.
+func (b *qsoEventBroker) OnPublish(fn func(qsoEvent)) {
+	if b == nil || fn == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.listeners = append(b.listeners, fn)
+}
+
+// Subscribe registers a subscriber for the logbook's events. The caller must call
+// Unsubscribe once it is no longer reading.
+func (b *qsoEventBroker) Subscribe(logbookID int64) *qsoSubscription {
+	sub, _ := b.SubscribeFrom(logbookID, 0)
+	return sub
+}
+
+// SubscribeFrom registers a subscriber like Subscribe and also returns the retained events for the
+// logbook with an ID greater than lastID. Both happen under one lock, so no event is missed or
+// delivered twice between the replay and the live stream. A lastID of zero replays nothing.
+//
+// Event IDs restart with the process; a lastID beyond the newest event is treated as coming
+// from a previous process and everything retained is replayed.
+func (b *qsoEventBroker) SubscribeFrom(logbookID int64, lastID uint64) (*qsoSubscription, []qsoEvent) {
+	sub := &qsoSubscription{logbookID: logbookID, events: make(chan qsoEvent, qsoSubscriptionBuffer)}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.closed {
+		close(sub.events)
+		return sub, nil
+	}
+
+	var replay []qsoEvent
+	if lastID > 0 {
+		if lastID > b.nextID {
+			lastID = 0
+		}
+		replay = b.sinceLocked(logbookID, lastID)
+	}
+
+	subs, ok := b.subscribers[logbookID]
+	if !ok {
+		subs = make(map[*qsoSubscription]struct{})
+		b.subscribers[logbookID] = subs
+	}
+	subs[sub] = struct{}{}
+
+	return sub, replay
+}
+
+// Unsubscribe removes the subscriber and closes its channel. It is safe to call more than once.
+func (b *qsoEventBroker) Unsubscribe(sub *qsoSubscription) {
+	if b == nil || sub == nil {
+		return
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.removeLocked(sub)
+}
+
+// Close drops every subscriber and rejects new ones. It is called during shutdown so that
+// long-lived stream handlers return.
+func (b *qsoEventBroker) Close() {
+	if b == nil {
+		return
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.closed = true
+	for _, subs := range b.subscribers {
+		for sub := range subs {
+			b.removeLocked(sub)
+		}
+	}
+}
+
+// stampLocked assigns the event the next ID and, if it has none, the current time. Must be called
+// with lock held.
+func (b *qsoEventBroker) stampLocked(event qsoEvent) qsoEvent {
+	b.nextID++
+	event.ID = b.nextID
+	if event.Time.IsZero() {
+		event.Time = time.Now().UTC()
+	}
+	return event
+}
+
+// deliverLocked sends the event to the logbook's subscribers, dropping those that have fallen
+// behind. Must be called with lock held.
+func (b *qsoEventBroker) deliverLocked(event qsoEvent) {
+	for sub := range b.subscribers[event.LogbookID] {
+		select {
+		case sub.events <- event:
+		default:
+			b.removeLocked(sub)
+		}
+	}
+}
+
+// recordLocked appends the event to the history ring. Must be called with lock held.
+func (b *qsoEventBroker) recordLocked(event qsoEvent) {
+	if len(b.history) < qsoEventHistorySize {
+		b.history = append(b.history, event)
+		return
+	}
+	b.history[b.historyNext] = event
+	b.historyNext = (b.historyNext + 1) % qsoEventHistorySize
+}
+
+// sinceLocked returns the retained events for the logbook with an ID greater than lastID, oldest
+// first. Must be called with lock held.
+func (b *qsoEventBroker) sinceLocked(logbookID int64, lastID uint64) []qsoEvent {
+	var events []qsoEvent
+	for i := 0; i < len(b.history); i++ {
+		event := b.history[(b.historyNext+i)%len(b.history)]
+		if event.LogbookID == logbookID && event.ID > lastID {
+			events = append(events, event)
+		}
+	}
+	return events
+}
+
+// removeLocked removes the subscriber and closes its channel. Must be called with lock held.
+func (b *qsoEventBroker) removeLocked(sub *qsoSubscription) {
+	subs, ok := b.subscribers[sub.logbookID]
+	if !ok {
+		return
+	}
+	if _, ok = subs[sub]; !ok {
+		return
+	}
+
+	delete(subs, sub)
+	close(sub.events)
+	if len(subs) == 0 {
+		delete(b.subscribers, sub.logbookID)
+	}
+}
+
+// publishQsoEvent publishes a change to a QSO together with the QSO's UUID. The event is published
+// without a UUID if it cannot be read.
+func (s *Service) publishQsoEvent(ctx context.Context, eventType qsoEventType, qso types.Qso) {
+	event := qsoEvent{Type: eventType, LogbookID: qso.LogbookID, Qso: qso}
+	if uuids, err := s.qsoUUIDs(ctx, qso.LogbookID, []int64{qso.ID}); err != nil {
+		s.logger.WarnWith().Err(err).Int64("qso_id", qso.ID).Msg("Failed to read QSO UUID for event")
+	} else {
+		event.QsoUUID = uuids[qso.ID]
+	}
+	s.qsoEvents.Publish(event)
+}
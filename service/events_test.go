@@ -0,0 +1,139 @@
+package service
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/Station-Manager/types"
+)
+
+func TestQsoEventBroker_DeliversToLogbookSubscribers(t *testing.T) {
+	broker := newQsoEventBroker()
+
+	sub := broker.Subscribe(1)
+	other := broker.Subscribe(2)
+	defer broker.Unsubscribe(sub)
+	defer broker.Unsubscribe(other)
+
+	published := broker.Publish(qsoEvent{Type: qsoEventInserted, LogbookID: 1, Qso: types.Qso{ID: 10}})
+	if published.ID != 1 {
+		t.Errorf("expected first event ID to be 1, got %d", published.ID)
+	}
+	if published.Time.IsZero() {
+		t.Error("expected event time to be set")
+	}
+
+	select {
+	case event := <-sub.Events():
+		if event.Qso.ID != 10 || event.ID != published.ID {
+			t.Errorf("unexpected event %+v", event)
+		}
+	default:
+		t.Fatal("expected subscriber to receive the event")
+	}
+
+	select {
+	case event := <-other.Events():
+		t.Errorf("expected no event for another logbook, got %+v", event)
+	default:
+	}
+}
+
+func TestQsoEventBroker_DropsSlowSubscriber(t *testing.T) {
+	broker := newQsoEventBroker()
+	sub := broker.Subscribe(1)
+
+	for i := 0; i <= qsoSubscriptionBuffer; i++ {
+		broker.Publish(qsoEvent{Type: qsoEventInserted, LogbookID: 1})
+	}
+
+	received := 0
+	for range sub.Events() {
+		received++
+	}
+	if received != qsoSubscriptionBuffer {
+		t.Errorf("expected %d buffered events before the channel closed, got %d", qsoSubscriptionBuffer, received)
+	}
+
+	// Unsubscribing a dropped subscriber must be harmless.
+	broker.Unsubscribe(sub)
+}
+
+func TestQsoEventBroker_Close(t *testing.T) {
+	broker := newQsoEventBroker()
+	sub := broker.Subscribe(1)
+
+	broker.Close()
+
+	if _, open := <-sub.Events(); open {
+		t.Error("expected subscription to be closed")
+	}
+	if _, open := <-broker.Subscribe(1).Events(); open {
+		t.Error("expected subscriptions after Close to be closed immediately")
+	}
+}
+
+func TestQsoEventBroker_SubscribeFromReplaysMissedEvents(t *testing.T) {
+	broker := newQsoEventBroker()
+
+	for i := 0; i < 3; i++ {
+		broker.Publish(qsoEvent{Type: qsoEventInserted, LogbookID: 1})
+		broker.Publish(qsoEvent{Type: qsoEventInserted, LogbookID: 2})
+	}
+
+	// Event IDs are 1..6; logbook 1 owns the odd ones.
+	sub, replay := broker.SubscribeFrom(1, 1)
+	defer broker.Unsubscribe(sub)
+
+	if len(replay) != 2 || replay[0].ID != 3 || replay[1].ID != 5 {
+		t.Fatalf("expected events 3 and 5 to be replayed, got %+v", replay)
+	}
+
+	if _, replay = broker.SubscribeFrom(1, 0); replay != nil {
+		t.Errorf("expected no replay without a last event ID, got %d events", len(replay))
+	}
+	// An ID from before a restart is beyond anything this process has issued.
+	if _, replay = broker.SubscribeFrom(1, 100); len(replay) != 3 {
+		t.Errorf("expected all 3 retained events to be replayed, got %d", len(replay))
+	}
+}
+
+func TestQsoEventBroker_AnnounceSkipsListenersAndHistory(t *testing.T) {
+	broker := newQsoEventBroker()
+	heard := 0
+	broker.OnPublish(func(qsoEvent) { heard++ })
+	sub := broker.Subscribe(1)
+	defer broker.Unsubscribe(sub)
+
+	announced := broker.Announce(qsoEvent{Type: jobEventProgress, LogbookID: 1, Job: &job{ID: 7}})
+	if event := <-sub.Events(); event.ID != announced.ID || event.Job == nil || event.Job.ID != 7 {
+		t.Errorf("expected the subscriber to receive the job event, got %+v", event)
+	}
+	if heard != 0 {
+		t.Error("expected listeners not to see an announcement")
+	}
+	if _, replay := broker.SubscribeFrom(1, 100); len(replay) != 0 {
+		t.Errorf("expected announcements not to be replayed, got %+v", replay)
+	}
+
+	data, err := announced.MarshalJSON()
+	if err != nil || strings.Contains(string(data), `"qso"`) || !strings.Contains(string(data), `"job":{"id":7`) {
+		t.Errorf("expected a job event without a QSO, got %s %v", data, err)
+	}
+}
+
+func TestQsoEventBroker_HistoryIsBounded(t *testing.T) {
+	broker := newQsoEventBroker()
+
+	for i := 0; i < qsoEventHistorySize+10; i++ {
+		broker.Publish(qsoEvent{Type: qsoEventInserted, LogbookID: 1})
+	}
+
+	_, replay := broker.SubscribeFrom(1, 1)
+	if len(replay) != qsoEventHistorySize {
+		t.Fatalf("expected %d retained events, got %d", qsoEventHistorySize, len(replay))
+	}
+	if replay[0].ID != 11 || replay[len(replay)-1].ID != qsoEventHistorySize+10 {
+		t.Errorf("expected the oldest events to be discarded, got IDs %d..%d", replay[0].ID, replay[len(replay)-1].ID)
+	}
+}
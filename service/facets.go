@@ -0,0 +1,177 @@
+package service
+
+import (
+	"context"
+	"strconv"
+	"strings"
+
+	"github.com/Station-Manager/errors"
+	"github.com/gofiber/fiber/v2"
+)
+
+// yearExpressions holds the SQL that gives a QSO's year (YYYY), per driver.
+var yearExpressions = map[bool]string{
+	false: `substr(qso_date, 1, 4)`,
+	true:  `to_char(qso_date, 'YYYY')`,
+}
+
+// qsoCountRequest holds the query parameters of a count request. Each one given narrows the QSOs
+// counted.
+type qsoCountRequest struct {
+	Band string `query:"band" validate:"omitempty,max=10"`
+	Mode string `query:"mode" validate:"omitempty,max=10"`
+	Year string `query:"year" validate:"omitempty,len=4,numeric"`
+}
+
+// facetValue is a value used in a logbook and the number of its QSOs with it.
+type facetValue struct {
+	Value string `json:"value"`
+	Count int64  `json:"count"`
+}
+
+// qsoFacets are the values a logbook's QSOs can be filtered on: bands and modes most used first,
+// and years newest first.
+type qsoFacets struct {
+	Qsos  int64        `json:"qsos"`
+	Bands []facetValue `json:"bands"`
+	Modes []facetValue `json:"modes"`
+	Years []facetValue `json:"years"`
+}
+
+// countQsos counts the logbook's QSOs matching the request.
+func (s *Service) countQsos(ctx context.Context, logbookID int64, request qsoCountRequest) (int64, error) {
+	const op errors.Op = "server.Service.countQsos"
+
+	postgres := s.isPostgres()
+	query := `SELECT COUNT(*) FROM qso WHERE logbook_id = $1 AND deleted_at IS NULL`
+	args := []any{logbookID}
+	param := func(value any) string {
+		args = append(args, value)
+		return `$` + strconv.Itoa(len(args))
+	}
+	dateParam := func(value string) string {
+		if postgres {
+			return `to_date(` + param(value) + `, 'YYYYMMDD')`
+		}
+		return param(value)
+	}
+	if request.Band != emptyString {
+		query += ` AND ` + activityGroupExpressions[activityGroupBand] + ` = ` + param(strings.ToLower(request.Band))
+	}
+	if request.Mode != emptyString {
+		query += ` AND ` + activityGroupExpressions[activityGroupMode] + ` = ` + param(strings.ToUpper(request.Mode))
+	}
+	if request.Year != emptyString {
+		// A range on qso_date rather than its year, so that the logbook's date index is used.
+		query += ` AND qso_date >= ` + dateParam(request.Year+"0101") + ` AND qso_date <= ` + dateParam(request.Year+"1231")
+	}
+
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return 0, errors.New(op).Err(err)
+	}
+	defer func() { _ = rows.Close() }()
+
+	var count int64
+	if rows.Next() {
+		if err = rows.Scan(&count); err != nil {
+			return 0, errors.New(op).Err(err)
+		}
+	}
+	if err = rows.Err(); err != nil {
+		return 0, errors.New(op).Err(err)
+	}
+	return count, nil
+}
+
+// fetchQsoFacets returns the bands, modes and years used in the logbook, with their QSO counts.
+func (s *Service) fetchQsoFacets(ctx context.Context, logbookID int64) (qsoFacets, error) {
+	const op errors.Op = "server.Service.fetchQsoFacets"
+
+	var facets qsoFacets
+	var err error
+	if facets.Bands, err = s.fetchFacetValues(ctx, logbookID, activityGroupExpressions[activityGroupBand], `COUNT(*) DESC, value`); err != nil {
+		return qsoFacets{}, errors.New(op).Err(err)
+	}
+	if facets.Modes, err = s.fetchFacetValues(ctx, logbookID, activityGroupExpressions[activityGroupMode], `COUNT(*) DESC, value`); err != nil {
+		return qsoFacets{}, errors.New(op).Err(err)
+	}
+	if facets.Years, err = s.fetchFacetValues(ctx, logbookID, yearExpressions[s.isPostgres()], `value DESC`); err != nil {
+		return qsoFacets{}, errors.New(op).Err(err)
+	}
+	for _, year := range facets.Years {
+		facets.Qsos += year.Count
+	}
+	return facets, nil
+}
+
+// fetchFacetValues counts the logbook's QSOs per value of the expression, in the given order.
+func (s *Service) fetchFacetValues(ctx context.Context, logbookID int64, expr, order string) ([]facetValue, error) {
+	const op errors.Op = "server.Service.fetchFacetValues"
+
+	rows, err := s.db.QueryContext(ctx, `SELECT `+expr+` AS value, COUNT(*) FROM qso WHERE logbook_id = $1 AND deleted_at IS NULL
+		GROUP BY value ORDER BY `+order, logbookID)
+	if err != nil {
+		return nil, errors.New(op).Err(err)
+	}
+	defer func() { _ = rows.Close() }()
+
+	values := make([]facetValue, 0)
+	for rows.Next() {
+		var v facetValue
+		if err = rows.Scan(&v.Value, &v.Count); err != nil {
+			return nil, errors.New(op).Err(err)
+		}
+		values = append(values, v)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, errors.New(op).Err(err)
+	}
+	return values, nil
+}
+
+// qsoCountHandler returns the number of the authenticated logbook's QSOs, narrowed by the band,
+// mode and year query parameters, so that a filter can show how many QSOs it matches.
+func (s *Service) qsoCountHandler(c *fiber.Ctx) error {
+	const op errors.Op = "server.Service.qsoCountHandler"
+
+	logbook, err := authenticatedLogbook(c)
+	if err != nil {
+		s.logger.ErrorWith().Err(errors.New(op).Err(err)).Msg("authenticatedLogbook failed")
+		return c.Status(fiber.StatusInternalServerError).JSON(jsonInternalError)
+	}
+
+	var request qsoCountRequest
+	if err = c.QueryParser(&request); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(jsonBadRequest)
+	}
+	if err = s.validate.Struct(request); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(jsonBadRequest)
+	}
+
+	count, err := s.countQsos(c.UserContext(), logbook.ID, request)
+	if err != nil {
+		s.logger.ErrorWith().Err(errors.New(op).Err(err)).Msg("s.countQsos failed")
+		return s.dbFailure(c, err)
+	}
+	return c.JSON(fiber.Map{"count": count})
+}
+
+// qsoFacetsHandler returns the bands, modes and years used in the authenticated logbook, for the
+// choices of filter dropdowns.
+func (s *Service) qsoFacetsHandler(c *fiber.Ctx) error {
+	const op errors.Op = "server.Service.qsoFacetsHandler"
+
+	logbook, err := authenticatedLogbook(c)
+	if err != nil {
+		s.logger.ErrorWith().Err(errors.New(op).Err(err)).Msg("authenticatedLogbook failed")
+		return c.Status(fiber.StatusInternalServerError).JSON(jsonInternalError)
+	}
+
+	facets, err := s.fetchQsoFacets(c.UserContext(), logbook.ID)
+	if err != nil {
+		s.logger.ErrorWith().Err(errors.New(op).Err(err)).Msg("s.fetchQsoFacets failed")
+		return s.dbFailure(c, err)
+	}
+	return c.JSON(facets)
+}
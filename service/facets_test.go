@@ -0,0 +1,72 @@
+package service
+
+import (
+	"context"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/goccy/go-json"
+	"github.com/gofiber/fiber/v2"
+)
+
+func TestFetchQsoFacets(t *testing.T) {
+	svc := newTestServer(t)
+	ctx := context.Background()
+
+	insertTestQso(t, svc, "JA1XX", "20m", "FT8", "20230430", "1200")
+	insertTestQso(t, svc, "JA2XX", "20M", "ft8", "20240430", "1215")
+	insertTestQso(t, svc, "K1AB", "40m", "CW", "20240430", "1300")
+	deleted := insertTestQso(t, svc, "K2AB", "80m", "SSB", "20250502", "0100")
+	if _, err := svc.db.ExecContext(ctx, `UPDATE qso SET deleted_at = CURRENT_TIMESTAMP WHERE id = $1`, deleted); err != nil {
+		t.Fatalf("delete qso failed: %v", err)
+	}
+
+	facets, err := svc.fetchQsoFacets(ctx, 1)
+	if err != nil {
+		t.Fatalf("fetchQsoFacets failed: %v", err)
+	}
+	if facets.Qsos != 3 {
+		t.Errorf("expected 3 QSOs, got %d", facets.Qsos)
+	}
+	if len(facets.Bands) != 2 || facets.Bands[0] != (facetValue{"20m", 2}) || facets.Bands[1] != (facetValue{"40m", 1}) {
+		t.Errorf("unexpected bands %+v", facets.Bands)
+	}
+	if len(facets.Modes) != 2 || facets.Modes[0] != (facetValue{"FT8", 2}) {
+		t.Errorf("unexpected modes %+v", facets.Modes)
+	}
+	if len(facets.Years) != 2 || facets.Years[0] != (facetValue{"2024", 2}) || facets.Years[1] != (facetValue{"2023", 1}) {
+		t.Errorf("unexpected years %+v", facets.Years)
+	}
+}
+
+func TestQsoCountHandler(t *testing.T) {
+	svc := newTestServer(t)
+	insertTestQso(t, svc, "JA1XX", "20m", "FT8", "20231231", "1200")
+	insertTestQso(t, svc, "JA2XX", "20m", "FT8", "20240101", "1215")
+	insertTestQso(t, svc, "K1AB", "40m", "CW", "20240430", "1300")
+
+	app := fiber.New()
+	app.Get("/stats/count", withLogbook(1, svc.qsoCountHandler))
+
+	count := func(query string) (int, int64) {
+		resp, err := app.Test(httptest.NewRequest(fiber.MethodGet, "/stats/count"+query, nil))
+		if err != nil {
+			t.Fatalf("GET %s failed: %v", query, err)
+		}
+		var out struct {
+			Count int64 `json:"count"`
+		}
+		_ = json.NewDecoder(resp.Body).Decode(&out)
+		return resp.StatusCode, out.Count
+	}
+
+	cases := map[string]int64{"": 3, "?band=20M": 2, "?mode=cw": 1, "?year=2024": 2, "?year=2024&band=20m": 1, "?year=2022": 0}
+	for query, want := range cases {
+		if status, got := count(query); status != fiber.StatusOK || got != want {
+			t.Errorf("%q: expected %d, got %d %d", query, want, status, got)
+		}
+	}
+	if status, _ := count("?year=24"); status != fiber.StatusBadRequest {
+		t.Errorf("expected a short year to be rejected, got %d", status)
+	}
+}
@@ -0,0 +1,13 @@
+package frontend
+
+import (
+	_ "embed"
+)
+
+//go:embed maintenance/index.html
+var maintenancePage []byte
+
+// MaintenancePage returns the HTML page served in place of the frontend during maintenance.
+func MaintenancePage() []byte {
+	return maintenancePage
+}
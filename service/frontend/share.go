@@ -0,0 +1,13 @@
+package frontend
+
+import (
+	_ "embed"
+)
+
+//go:embed share/index.html
+var sharePage []byte
+
+// SharePage returns the HTML page that shows a logbook through a read-only share link.
+func SharePage() []byte {
+	return sharePage
+}
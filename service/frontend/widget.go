@@ -0,0 +1,18 @@
+package frontend
+
+import (
+	_ "embed"
+	"html/template"
+)
+
+//go:embed widget/index.html
+var widgetPage string
+
+var widgetTemplate = template.Must(template.New("widget").Parse(widgetPage))
+
+// WidgetTemplate returns the template of the page that lists a logbook's recent QSOs for embedding
+// in other websites. It is executed with a value holding Callsign and Qsos, each QSO having Date,
+// Time, Call, Band and Mode.
+func WidgetTemplate() *template.Template {
+	return widgetTemplate
+}
@@ -0,0 +1,73 @@
+package service
+
+import (
+	"github.com/Station-Manager/errors"
+	"github.com/Station-Manager/types"
+	"github.com/gofiber/fiber/v2"
+)
+
+// getQsoHandler returns a QSO from the authenticated logbook together with its confirmations.
+// A QSO confirmed electronically is reported with QSL_RCVD=Y and QSL_RCVD_VIA=E unless it is
+// already marked as received. Its times are also given in the reader's time zone, if they have one.
+func (s *Service) getQsoHandler(c *fiber.Ctx) error {
+	const op errors.Op = "server.Service.getQsoHandler"
+
+	reqCtx, err := getRequestContext(c)
+	if err != nil || reqCtx.Logbook == nil {
+		err = errors.New(op).Err(err).Msg("Request context missing")
+		s.logger.ErrorWith().Err(err).Msg("getRequestContext failed")
+		return c.Status(fiber.StatusInternalServerError).JSON(jsonInternalError)
+	}
+
+	location, ok, err := s.readerLocation(c)
+	if err != nil {
+		s.logger.ErrorWith().Err(errors.New(op).Err(err)).Msg("s.readerLocation failed")
+		return s.dbFailure(c, err)
+	}
+	if !ok {
+		return c.Status(fiber.StatusBadRequest).JSON(jsonError(errCodeBadRequest, "tz is not a time zone"))
+	}
+
+	id, ok, err := s.resolveQsoRef(c.UserContext(), reqCtx.Logbook.ID, c.Params("id"))
+	if err != nil {
+		s.logger.ErrorWith().Err(errors.New(op).Err(err)).Msg("s.resolveQsoRef failed")
+		return s.dbFailure(c, err)
+	}
+	if !ok {
+		return c.Status(fiber.StatusBadRequest).JSON(jsonBadRequest)
+	}
+
+	qso, err := s.db.FetchQsoByIdContext(c.UserContext(), id)
+	if err != nil || qso.LogbookID != reqCtx.Logbook.ID {
+		// Do not reveal whether the QSO exists in another logbook.
+		return c.Status(fiber.StatusNotFound).JSON(jsonError(errCodeQsoNotFound, "QSO not found"))
+	}
+
+	confirmations, err := s.listQsoConfirmations(c.UserContext(), qso.ID)
+	if err != nil {
+		err = errors.New(op).Err(err)
+		s.logger.ErrorWith().Err(err).Msg("s.listQsoConfirmations failed")
+		return s.dbFailure(c, err)
+	}
+	applyConfirmations(&qso, confirmations)
+
+	public, err := s.publicQsoOf(c.UserContext(), qso)
+	if err != nil {
+		s.logger.ErrorWith().Err(errors.New(op).Err(err)).Msg("s.publicQsoOf failed")
+		return s.dbFailure(c, err)
+	}
+	if location != nil {
+		public.Local = localTimesOf(qso, location)
+	}
+	return c.JSON(fiber.Map{"qso": public, "confirmations": confirmations})
+}
+
+// applyConfirmations reflects electronic confirmations in the QSO's ADIF QSL fields.
+func applyConfirmations(qso *types.Qso, confirmations []qsoConfirmation) {
+	if len(confirmations) == 0 || qso.QslRcvd == "Y" {
+		return
+	}
+	qso.QslRcvd = "Y"
+	qso.QslRcvdVia = "E"
+	qso.QslRDate = confirmations[0].ReceivedDate
+}
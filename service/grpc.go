@@ -0,0 +1,610 @@
+package service
+
+import (
+	"context"
+	"crypto/tls"
+	stderr "errors"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/Station-Manager/errors"
+	"github.com/Station-Manager/server/service/adif"
+	"github.com/Station-Manager/server/service/grpcapi"
+	"github.com/Station-Manager/types"
+	"github.com/go-playground/validator/v10"
+	"github.com/goccy/go-json"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+// envGrpcAddr names the environment variable holding the address of the gRPC listener (e.g.
+// ":9090"). The gRPC API is disabled when it is empty. The listener uses the server's TLS
+// settings.
+const envGrpcAddr = "SM_GRPC_ADDR"
+
+const (
+	listenerGrpc = "grpc"
+
+	// grpcErrorDomain is the domain of the ErrorInfo detail that carries an error code.
+	grpcErrorDomain = "station-manager"
+
+	// maxUploadQsos bounds the QSOs of a single UploadQsos stream.
+	maxUploadQsos = 100000
+)
+
+// grpcContextKey is the context key of the request context of an authenticated gRPC call.
+type grpcContextKey struct{}
+
+// loadGrpcAddr reads the gRPC listener address from the environment.
+func loadGrpcAddr() (string, error) {
+	const op errors.Op = "server.loadGrpcAddr"
+
+	addr := strings.TrimSpace(os.Getenv(envGrpcAddr))
+	if addr == emptyString {
+		return emptyString, nil
+	}
+	if _, _, err := net.SplitHostPort(addr); err != nil {
+		return emptyString, errors.New(op).Err(err).Msg(envGrpcAddr + " must be a host:port address")
+	}
+	return addr, nil
+}
+
+// newGrpcServer returns the gRPC server with the Logbooks service registered. Every call passes
+// through the maintenance check and API key authentication first.
+func (s *Service) newGrpcServer(opts ...grpc.ServerOption) *grpc.Server {
+	opts = append(opts,
+		grpc.ChainUnaryInterceptor(s.grpcUnaryInterceptor),
+		grpc.ChainStreamInterceptor(s.grpcStreamInterceptor),
+	)
+	server := grpc.NewServer(opts...)
+	grpcapi.RegisterLogbooksServer(server, &grpcLogbooks{s: s})
+	return server
+}
+
+// startGrpcListener binds the gRPC listener and serves it in the background. It returns once the
+// address is bound so that a port conflict fails startup.
+func (s *Service) startGrpcListener() error {
+	const op errors.Op = "server.Service.startGrpcListener"
+
+	if s.grpcAddr == emptyString {
+		return nil
+	}
+
+	var opts []grpc.ServerOption
+	switch {
+	case s.autocert != nil:
+		opts = append(opts, grpc.Creds(credentials.NewTLS(s.autocert.TLSConfig())))
+	case s.config.TLSEnabled:
+		cert, err := tls.LoadX509KeyPair(s.config.TLSCertFile, s.config.TLSKeyFile)
+		if err != nil {
+			return errors.New(op).Err(err).Msg("tls.LoadX509KeyPair")
+		}
+		opts = append(opts, grpc.Creds(credentials.NewTLS(&tls.Config{MinVersion: tls.VersionTLS12, Certificates: []tls.Certificate{cert}})))
+	}
+
+	ln, err := s.listen(listenerGrpc, s.grpcAddr)
+	if err != nil {
+		return errors.New(op).Err(err)
+	}
+	s.grpcServer = s.newGrpcServer(opts...)
+
+	go func() {
+		if err := s.grpcServer.Serve(ln); err != nil && !stderr.Is(err, grpc.ErrServerStopped) {
+			s.logger.ErrorWith().Err(err).Str("addr", s.grpcAddr).Msg("gRPC listener failed")
+		}
+	}()
+	s.logger.InfoWith().Str("addr", ln.Addr().String()).Msg("gRPC listener started")
+
+	return nil
+}
+
+// stopGrpcListener stops the gRPC listener, if running, letting calls in progress finish until ctx
+// is done. It reports whether calls were cancelled.
+func (s *Service) stopGrpcListener(ctx context.Context) bool {
+	if s.grpcServer == nil {
+		return false
+	}
+	done := make(chan struct{})
+	go func() {
+		s.grpcServer.GracefulStop()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return false
+	case <-ctx.Done():
+		s.logger.WarnWith().Msg("gRPC calls still running at shutdown were cancelled")
+		s.grpcServer.Stop()
+		return true
+	}
+}
+
+// grpcUnaryInterceptor authenticates unary calls.
+func (s *Service) grpcUnaryInterceptor(ctx context.Context, req any, _ *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+	ctx, err := s.grpcAuthenticate(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return handler(ctx, req)
+}
+
+// grpcStreamInterceptor authenticates streaming calls.
+func (s *Service) grpcStreamInterceptor(srv any, stream grpc.ServerStream, _ *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	ctx, err := s.grpcAuthenticate(stream.Context())
+	if err != nil {
+		return err
+	}
+	return handler(srv, &grpcAuthenticatedStream{ServerStream: stream, ctx: ctx})
+}
+
+// grpcAuthenticatedStream is a server stream whose context holds the request context.
+type grpcAuthenticatedStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (a *grpcAuthenticatedStream) Context() context.Context {
+	return a.ctx
+}
+
+// grpcAuthenticate checks the API key in the call's "authorization: Bearer <key>" metadata and
+// returns a context holding the request context of its logbook. Calls are refused while maintenance
+// mode is on.
+func (s *Service) grpcAuthenticate(ctx context.Context) (context.Context, error) {
+	const op errors.Op = "server.Service.grpcAuthenticate"
+
+	if state := s.maintenance.Load(); state != nil {
+		return nil, grpcError(codes.Unavailable, errCodeMaintenance, state.Message)
+	}
+
+	md, _ := metadata.FromIncomingContext(ctx)
+	var key string
+	if values := md.Get("authorization"); len(values) > 0 {
+		key = strings.TrimSpace(strings.TrimPrefix(values[0], "Bearer "))
+	}
+	if key == emptyString {
+		return nil, grpcError(codes.Unauthenticated, errCodeUnauthorized, "Unauthorized")
+	}
+
+	reqCtx, reason, err := s.authenticateApiKey(ctx, key)
+	if err != nil {
+		s.logger.ErrorWith().Err(errors.New(op).Err(err)).Msg("s.authenticateApiKey failed")
+	}
+	if reqCtx == nil {
+		if reason != emptyString {
+			entry := auditEntry{Event: auditApiKeyFailed, Actor: apiKeyActor(key)}
+			if p, ok := peer.FromContext(ctx); ok {
+				entry.IP, _, _ = net.SplitHostPort(p.Addr.String())
+			}
+			method, _ := grpc.Method(ctx)
+			s.recordAudit(ctx, entry, map[string]any{"reason": reason, "path": method})
+		}
+		return nil, grpcError(codes.Unauthenticated, errCodeUnauthorized, "Unauthorized")
+	}
+	return context.WithValue(ctx, grpcContextKey{}, reqCtx), nil
+}
+
+// grpcRequestContext returns the request context stored by grpcAuthenticate.
+func grpcRequestContext(ctx context.Context) (*requestContext, error) {
+	reqCtx, ok := ctx.Value(grpcContextKey{}).(*requestContext)
+	if !ok || reqCtx == nil || reqCtx.Logbook == nil {
+		return nil, grpcInternalError
+	}
+	return reqCtx, nil
+}
+
+// grpcError returns a status error with the error code of the HTTP API attached as an ErrorInfo
+// detail, so that clients can branch on it as they do on the "code" of a JSON error.
+func grpcError(code codes.Code, errCode errorCode, msg string) error {
+	st := status.New(code, msg)
+	if detailed, err := st.WithDetails(&errdetails.ErrorInfo{Reason: string(errCode), Domain: grpcErrorDomain}); err == nil {
+		st = detailed
+	}
+	return st.Err()
+}
+
+var grpcInternalError = grpcError(codes.Internal, errCodeInternal, "Internal Server Error")
+
+// grpcDBErrorCodes are the gRPC codes of the kinds of database error the client can act on.
+var grpcDBErrorCodes = map[dbErrorKind]codes.Code{
+	dbErrorUnique:      codes.AlreadyExists,
+	dbErrorForeignKey:  codes.FailedPrecondition,
+	dbErrorUnavailable: codes.Unavailable,
+}
+
+// grpcDBError returns the status error for a call that failed with the database error, as
+// dbErrorResponse does for the HTTP API, and false if the error is the server's own fault.
+func grpcDBError(err error) (error, bool) {
+	kind := classifyDBError(err)
+	code, ok := grpcDBErrorCodes[kind]
+	if !ok {
+		return nil, false
+	}
+	response := dbErrorResponses[kind]
+	return grpcError(code, response.code, response.message), true
+}
+
+// grpcQuotaError returns the status error for a call that would take the account past a quota.
+func grpcQuotaError(err error) (error, bool) {
+	var exceeded *quotaExceededError
+	if !stderr.As(err, &exceeded) {
+		return nil, false
+	}
+	return grpcError(codes.ResourceExhausted, errCodeQuotaExceeded, "The "+exceeded.Error()), true
+}
+
+// grpcLogbooks implements the Logbooks gRPC service on top of the service layer of the HTTP API.
+type grpcLogbooks struct {
+	grpcapi.UnimplementedLogbooksServer
+	s *Service
+}
+
+// writable checks that the call may change the logbook's QSOs: the API key must hold the operator
+// role and the logbook must not be archived.
+func (g *grpcLogbooks) writable(ctx context.Context, reqCtx *requestContext) error {
+	const op errors.Op = "server.grpcLogbooks.writable"
+
+	if !reqCtx.Role.atLeast(logbookRoleOperator) {
+		return grpcError(codes.PermissionDenied, errCodeInsufficientRole, "This requires the "+string(logbookRoleOperator)+" role on the logbook")
+	}
+	archived, err := g.s.isLogbookArchived(ctx, reqCtx.Logbook.ID)
+	if err != nil {
+		g.s.logger.ErrorWith().Err(errors.New(op).Err(err)).Int64("logbook_id", reqCtx.Logbook.ID).Msg("s.isLogbookArchived failed")
+		return grpcInternalError
+	}
+	if archived {
+		return grpcError(codes.FailedPrecondition, errCodeLogbookArchived, "The logbook is archived and read-only")
+	}
+	return nil
+}
+
+func (g *grpcLogbooks) GetLogbook(ctx context.Context, _ *grpcapi.GetLogbookRequest) (*grpcapi.Logbook, error) {
+	const op errors.Op = "server.grpcLogbooks.GetLogbook"
+
+	reqCtx, err := grpcRequestContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	logbook := reqCtx.Logbook
+	uuids, err := g.s.logbookUUIDs(ctx, []int64{logbook.ID})
+	if err != nil {
+		g.s.logger.ErrorWith().Err(errors.New(op).Err(err)).Msg("s.logbookUUIDs failed")
+		return nil, grpcInternalError
+	}
+	return &grpcapi.Logbook{Id: logbook.ID, Uuid: uuids[logbook.ID], Name: logbook.Name, Callsign: logbook.Callsign, Description: logbook.Description}, nil
+}
+
+func (g *grpcLogbooks) InsertQso(ctx context.Context, req *grpcapi.InsertQsoRequest) (*grpcapi.InsertQsoResponse, error) {
+	const op errors.Op = "server.grpcLogbooks.InsertQso"
+
+	reqCtx, err := grpcRequestContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if err = g.writable(ctx, reqCtx); err != nil {
+		return nil, err
+	}
+	if req.GetQso() == nil {
+		return nil, grpcError(codes.InvalidArgument, errCodeBadRequest, "The QSO is missing")
+	}
+	qso, err := qsoFromAdif(qsoProtoRecord(req.GetQso()))
+	if err != nil {
+		return nil, grpcError(codes.InvalidArgument, errCodeBadRequest, err.Error())
+	}
+	qso.SessionID = req.GetQso().GetSessionId()
+
+	if qso, err = g.s.insertQso(ctx, *reqCtx.Logbook, reqCtx.Member, reqCtx.Actor, qso, emptyString, emptyString, false); err != nil {
+		var invalid validator.ValidationErrors
+		var badTime *qsoTimeError
+		var badCall *callsignError
+		switch {
+		case stderr.Is(err, errQsoCallsignMismatch):
+			return nil, grpcError(codes.InvalidArgument, errCodeCallsignMismatch, "QSO callsign does not match the Logbook's callsign")
+		case stderr.As(err, &badTime):
+			return nil, grpcError(codes.InvalidArgument, errCodeInvalidQsoTime, "The QSO's "+badTime.Error())
+		case stderr.As(err, &badCall):
+			return nil, grpcError(codes.InvalidArgument, errCodeInvalidCallsign, "The QSO's "+badCall.Error())
+		case stderr.Is(err, errContestDupe):
+			return nil, grpcError(codes.AlreadyExists, errCodeContestDupe, "The QSO is a dupe in the running contest")
+		case stderr.As(err, &invalid):
+			return nil, grpcError(codes.InvalidArgument, errCodeBadRequest, invalid.Error())
+		}
+		if isUniqueViolation(err) {
+			return nil, grpcError(codes.AlreadyExists, errCodeDuplicateQso, "The logbook already holds this QSO")
+		}
+		if grpcErr, ok := grpcQuotaError(err); ok {
+			return nil, grpcErr
+		}
+		if grpcErr, ok := grpcDBError(err); ok {
+			return nil, grpcErr
+		}
+		g.s.logger.ErrorWith().Err(errors.New(op).Err(err)).Msg("InsertQso failed")
+		return nil, grpcInternalError
+	}
+	uuids, err := g.s.qsoUUIDs(ctx, qso.LogbookID, []int64{qso.ID})
+	if err != nil {
+		g.s.logger.ErrorWith().Err(errors.New(op).Err(err)).Msg("s.qsoUUIDs failed")
+		return nil, grpcInternalError
+	}
+	return &grpcapi.InsertQsoResponse{Id: qso.ID, Uuid: uuids[qso.ID]}, nil
+}
+
+func (g *grpcLogbooks) GetQso(ctx context.Context, req *grpcapi.GetQsoRequest) (*grpcapi.Qso, error) {
+	const op errors.Op = "server.grpcLogbooks.GetQso"
+
+	reqCtx, err := grpcRequestContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	id, err := g.qsoID(ctx, reqCtx.Logbook.ID, req.GetId(), req.GetUuid())
+	if err != nil {
+		return nil, err
+	}
+	qso, err := g.s.db.FetchQsoByIdContext(ctx, id)
+	if err != nil || qso.LogbookID != reqCtx.Logbook.ID {
+		// Do not reveal whether the QSO exists in another logbook.
+		return nil, grpcError(codes.NotFound, errCodeQsoNotFound, "QSO not found")
+	}
+	confirmations, err := g.s.listQsoConfirmations(ctx, qso.ID)
+	if err != nil {
+		g.s.logger.ErrorWith().Err(errors.New(op).Err(err)).Msg("s.listQsoConfirmations failed")
+		return nil, grpcInternalError
+	}
+	applyConfirmations(&qso, confirmations)
+
+	uuids, err := g.s.qsoUUIDs(ctx, qso.LogbookID, []int64{qso.ID})
+	if err != nil {
+		g.s.logger.ErrorWith().Err(errors.New(op).Err(err)).Msg("s.qsoUUIDs failed")
+		return nil, grpcInternalError
+	}
+	return qsoToProto(qso, uuids[qso.ID]), nil
+}
+
+func (g *grpcLogbooks) ListQsos(ctx context.Context, req *grpcapi.ListQsosRequest) (*grpcapi.ListQsosResponse, error) {
+	const op errors.Op = "server.grpcLogbooks.ListQsos"
+
+	reqCtx, err := grpcRequestContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	limit := int(req.GetLimit())
+	if limit <= 0 {
+		limit = v2QsoPageDefault
+	}
+	limit = min(limit, v2QsoPageMax)
+
+	qsos, err := g.s.fetchLogbookQsoPage(ctx, reqCtx.Logbook.ID, max(req.GetAfterId(), 0), limit)
+	if err != nil {
+		g.s.logger.ErrorWith().Err(errors.New(op).Err(err)).Msg("s.fetchLogbookQsoPage failed")
+		return nil, grpcInternalError
+	}
+	public, err := g.s.publicQsos(ctx, reqCtx.Logbook.ID, qsos)
+	if err != nil {
+		g.s.logger.ErrorWith().Err(errors.New(op).Err(err)).Msg("s.publicQsos failed")
+		return nil, grpcInternalError
+	}
+	response := &grpcapi.ListQsosResponse{Qsos: make([]*grpcapi.Qso, 0, len(qsos))}
+	for _, qso := range public {
+		response.Qsos = append(response.Qsos, qsoToProto(qso.Qso, qso.UUID))
+	}
+	if len(qsos) == limit {
+		response.NextAfterId = qsos[len(qsos)-1].ID
+	}
+	return response, nil
+}
+
+func (g *grpcLogbooks) DeleteQso(ctx context.Context, req *grpcapi.DeleteQsoRequest) (*grpcapi.DeleteQsoResponse, error) {
+	const op errors.Op = "server.grpcLogbooks.DeleteQso"
+
+	reqCtx, err := grpcRequestContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if err = g.writable(ctx, reqCtx); err != nil {
+		return nil, err
+	}
+	logbook := reqCtx.Logbook
+
+	id, err := g.qsoID(ctx, logbook.ID, req.GetId(), req.GetUuid())
+	if err != nil {
+		return nil, err
+	}
+	qso, err := g.s.db.FetchQsoByIdContext(ctx, id)
+	if err != nil || qso.LogbookID != logbook.ID {
+		return nil, grpcError(codes.NotFound, errCodeQsoNotFound, "QSO not found")
+	}
+	found, err := g.s.setQsoDeleted(ctx, logbook.ID, qso.ID, true, reqCtx.Actor)
+	if err != nil {
+		g.s.logger.ErrorWith().Err(errors.New(op).Err(err)).Int64("qso_id", qso.ID).Msg("s.setQsoDeleted failed")
+		return nil, grpcInternalError
+	}
+	if !found {
+		return nil, grpcError(codes.NotFound, errCodeQsoNotFound, "QSO not found")
+	}
+
+	g.s.publishQsoEvent(ctx, qsoEventDeleted, qso)
+	return &grpcapi.DeleteQsoResponse{}, nil
+}
+
+func (g *grpcLogbooks) UploadQsos(stream grpc.ClientStreamingServer[grpcapi.Qso, grpcapi.UploadQsosResponse]) error {
+	const op errors.Op = "server.grpcLogbooks.UploadQsos"
+
+	ctx := stream.Context()
+	reqCtx, err := grpcRequestContext(ctx)
+	if err != nil {
+		return err
+	}
+	if err = g.writable(ctx, reqCtx); err != nil {
+		return err
+	}
+	logbook := *reqCtx.Logbook
+
+	// QSOs uploaded with a member's API key are attributed to that member.
+	var operator string
+	if reqCtx.Member != nil {
+		operator = reqCtx.Member.Callsign
+	}
+	defaults, err := g.s.fetchQsoDefaults(ctx, logbook.ID, emptyString, reqCtx.Actor, operator)
+	if err != nil {
+		g.s.logger.ErrorWith().Err(errors.New(op).Err(err)).Msg("s.fetchQsoDefaults failed")
+		return grpcInternalError
+	}
+
+	var result importResult
+	var qsos []types.Qso
+	for {
+		msg, err := stream.Recv()
+		if stderr.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		result.Received++
+		if result.Received > maxUploadQsos {
+			return grpcError(codes.ResourceExhausted, errCodeBodyTooLarge, "An upload holds at most "+strconv.Itoa(maxUploadQsos)+" QSOs")
+		}
+		qso, err := g.s.importQso(qsoProtoRecord(msg), logbook, defaults)
+		if err != nil {
+			result.Rejected++
+			if len(result.Errors) < maxImportErrors {
+				result.Errors = append(result.Errors, importRejection{Record: result.Received, Error: err.Error()})
+			}
+			continue
+		}
+		qsos = append(qsos, qso)
+	}
+
+	if len(qsos) > 0 {
+		batch := &importBatch{Source: importSourceUpload, Actor: reqCtx.Actor, Received: result.Received, Rejected: result.Rejected}
+		if result.Imported, err = g.s.bulkInsertQsos(ctx, logbook.ID, qsos, reqCtx.Actor, batch, nil, false); err != nil {
+			if grpcErr, ok := grpcQuotaError(err); ok {
+				return grpcErr
+			}
+			if grpcErr, ok := grpcDBError(err); ok {
+				return grpcErr
+			}
+			g.s.logger.ErrorWith().Err(errors.New(op).Err(err)).Int64("logbook_id", logbook.ID).Int("qsos", len(qsos)).Msg("Bulk insert failed")
+			return grpcInternalError
+		}
+		result.Duplicates = len(qsos) - result.Imported
+		result.BatchID = batch.ID
+	}
+	g.s.logger.InfoWith().Int64("logbook_id", logbook.ID).Int64("batch_id", result.BatchID).Int("imported", result.Imported).Int("rejected", result.Rejected).Msg("QSOs uploaded")
+
+	response := &grpcapi.UploadQsosResponse{
+		Received:   int32(result.Received),
+		Imported:   int32(result.Imported),
+		Duplicates: int32(result.Duplicates),
+		Rejected:   int32(result.Rejected),
+	}
+	for _, rejection := range result.Errors {
+		response.Errors = append(response.Errors, &grpcapi.UploadRejection{Qso: int32(rejection.Record), Error: rejection.Error})
+	}
+	return stream.SendAndClose(response)
+}
+
+// qsoProtoRecord returns the ADIF fields of a QSO message. The typed fields take precedence over
+// the same fields in the map.
+func qsoProtoRecord(q *grpcapi.Qso) adif.Record {
+	rec := make(adif.Record, len(q.GetFields())+16)
+	for name, value := range q.GetFields() {
+		rec[strings.ToUpper(name)] = value
+	}
+	for name, value := range map[string]string{
+		"CALL":             q.GetCall(),
+		"BAND":             q.GetBand(),
+		"MODE":             q.GetMode(),
+		"SUBMODE":          q.GetSubmode(),
+		"FREQ":             q.GetFreq(),
+		"QSO_DATE":         q.GetQsoDate(),
+		"TIME_ON":          q.GetTimeOn(),
+		"TIME_OFF":         q.GetTimeOff(),
+		"RST_SENT":         q.GetRstSent(),
+		"RST_RCVD":         q.GetRstRcvd(),
+		"STATION_CALLSIGN": q.GetStationCallsign(),
+		"OPERATOR":         q.GetOperator(),
+		"GRIDSQUARE":       q.GetGridsquare(),
+		"NAME":             q.GetName(),
+		"COMMENT":          q.GetComment(),
+	} {
+		if value != emptyString {
+			rec[name] = value
+		}
+	}
+	return rec
+}
+
+// qsoID returns the ID of the logbook's QSO named by a request's id or, when set, its uuid.
+func (g *grpcLogbooks) qsoID(ctx context.Context, logbookID, id int64, ref string) (int64, error) {
+	const op errors.Op = "server.grpcLogbooks.qsoID"
+
+	if ref == emptyString {
+		return id, nil
+	}
+	id, ok, err := g.s.resolveQsoRef(ctx, logbookID, ref)
+	if err != nil {
+		g.s.logger.ErrorWith().Err(errors.New(op).Err(err)).Msg("s.resolveQsoRef failed")
+		return 0, grpcInternalError
+	}
+	if !ok {
+		return 0, grpcError(codes.InvalidArgument, errCodeBadRequest, "The QSO uuid is not valid")
+	}
+	return id, nil
+}
+
+// qsoToProto returns the QSO as a message. ADIF fields without a typed field are carried in the
+// message's map when they are set; fields the server assigns are left out.
+func qsoToProto(qso types.Qso, uuid string) *grpcapi.Qso {
+	q := &grpcapi.Qso{
+		Id:              qso.ID,
+		Uuid:            uuid,
+		LogbookId:       qso.LogbookID,
+		SessionId:       qso.SessionID,
+		Call:            qso.Call,
+		Band:            qso.Band,
+		Mode:            qso.Mode,
+		Submode:         qso.Submode,
+		Freq:            qso.Freq,
+		QsoDate:         qso.QsoDate,
+		TimeOn:          qso.TimeOn,
+		TimeOff:         qso.TimeOff,
+		RstSent:         qso.RstSent,
+		RstRcvd:         qso.RstRcvd,
+		StationCallsign: qso.StationCallsign,
+		Operator:        qso.Operator,
+		Gridsquare:      qso.Gridsquare,
+		Name:            qso.Name,
+		Comment:         qso.Comment,
+		Fields:          make(map[string]string),
+	}
+
+	// ADIF field names are the JSON names of the QSO fields.
+	var fields map[string]any
+	if data, err := json.Marshal(qso); err == nil && json.Unmarshal(data, &fields) == nil {
+		typed := qsoProtoRecord(q)
+		for _, name := range reservedImportFields {
+			typed[name] = emptyString
+		}
+		for name, value := range fields {
+			text, ok := value.(string)
+			// Fields without a JSON name are the server's own, not ADIF fields.
+			if !ok || text == emptyString || name != strings.ToLower(name) {
+				continue
+			}
+			name = strings.ToUpper(name)
+			if _, ok = typed[name]; !ok {
+				q.Fields[name] = text
+			}
+		}
+	}
+	return q
+}
@@ -0,0 +1,5 @@
+// Package grpcapi holds the protobuf messages and gRPC service definitions of the server's gRPC API.
+// The Go code is generated from stationmanager.proto; regenerate it after changing the definitions.
+package grpcapi
+
+//go:generate protoc --go_out=. --go_opt=paths=source_relative --go-grpc_out=. --go-grpc_opt=paths=source_relative stationmanager.proto
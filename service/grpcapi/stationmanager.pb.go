@@ -0,0 +1,962 @@
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// versions:
+// 	protoc-gen-go v1.36.9
+// 	protoc        (unknown)
+// source: stationmanager.proto
+
+package grpcapi
+
+import (
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+	protoimpl "google.golang.org/protobuf/runtime/protoimpl"
+	reflect "reflect"
+	sync "sync"
+	unsafe "unsafe"
+)
+
+const (
+	// Verify that this generated code is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(20 - protoimpl.MinVersion)
+	// Verify that runtime/protoimpl is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
+)
+
+type Logbook struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Id            int64                  `protobuf:"varint,1,opt,name=id,proto3" json:"id,omitempty"`
+	Name          string                 `protobuf:"bytes,2,opt,name=name,proto3" json:"name,omitempty"`
+	Callsign      string                 `protobuf:"bytes,3,opt,name=callsign,proto3" json:"callsign,omitempty"`
+	Description   string                 `protobuf:"bytes,4,opt,name=description,proto3" json:"description,omitempty"`
+	Uuid          string                 `protobuf:"bytes,5,opt,name=uuid,proto3" json:"uuid,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Logbook) Reset() {
+	*x = Logbook{}
+	mi := &file_stationmanager_proto_msgTypes[0]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Logbook) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Logbook) ProtoMessage() {}
+
+func (x *Logbook) ProtoReflect() protoreflect.Message {
+	mi := &file_stationmanager_proto_msgTypes[0]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Logbook.ProtoReflect.Descriptor instead.
+func (*Logbook) Descriptor() ([]byte, []int) {
+	return file_stationmanager_proto_rawDescGZIP(), []int{0}
+}
+
+func (x *Logbook) GetId() int64 {
+	if x != nil {
+		return x.Id
+	}
+	return 0
+}
+
+func (x *Logbook) GetName() string {
+	if x != nil {
+		return x.Name
+	}
+	return ""
+}
+
+func (x *Logbook) GetCallsign() string {
+	if x != nil {
+		return x.Callsign
+	}
+	return ""
+}
+
+func (x *Logbook) GetDescription() string {
+	if x != nil {
+		return x.Description
+	}
+	return ""
+}
+
+func (x *Logbook) GetUuid() string {
+	if x != nil {
+		return x.Uuid
+	}
+	return ""
+}
+
+// Qso is a QSO in ADIF terms. The most common fields are typed; any other ADIF field is carried in
+// fields, keyed by its ADIF name.
+type Qso struct {
+	state           protoimpl.MessageState `protogen:"open.v1"`
+	Id              int64                  `protobuf:"varint,1,opt,name=id,proto3" json:"id,omitempty"`
+	LogbookId       int64                  `protobuf:"varint,2,opt,name=logbook_id,json=logbookId,proto3" json:"logbook_id,omitempty"`
+	SessionId       int64                  `protobuf:"varint,3,opt,name=session_id,json=sessionId,proto3" json:"session_id,omitempty"`
+	Call            string                 `protobuf:"bytes,4,opt,name=call,proto3" json:"call,omitempty"`
+	Band            string                 `protobuf:"bytes,5,opt,name=band,proto3" json:"band,omitempty"`
+	Mode            string                 `protobuf:"bytes,6,opt,name=mode,proto3" json:"mode,omitempty"`
+	Submode         string                 `protobuf:"bytes,7,opt,name=submode,proto3" json:"submode,omitempty"`
+	Freq            string                 `protobuf:"bytes,8,opt,name=freq,proto3" json:"freq,omitempty"`
+	QsoDate         string                 `protobuf:"bytes,9,opt,name=qso_date,json=qsoDate,proto3" json:"qso_date,omitempty"`
+	TimeOn          string                 `protobuf:"bytes,10,opt,name=time_on,json=timeOn,proto3" json:"time_on,omitempty"`
+	TimeOff         string                 `protobuf:"bytes,11,opt,name=time_off,json=timeOff,proto3" json:"time_off,omitempty"`
+	RstSent         string                 `protobuf:"bytes,12,opt,name=rst_sent,json=rstSent,proto3" json:"rst_sent,omitempty"`
+	RstRcvd         string                 `protobuf:"bytes,13,opt,name=rst_rcvd,json=rstRcvd,proto3" json:"rst_rcvd,omitempty"`
+	StationCallsign string                 `protobuf:"bytes,14,opt,name=station_callsign,json=stationCallsign,proto3" json:"station_callsign,omitempty"`
+	Operator        string                 `protobuf:"bytes,15,opt,name=operator,proto3" json:"operator,omitempty"`
+	Gridsquare      string                 `protobuf:"bytes,16,opt,name=gridsquare,proto3" json:"gridsquare,omitempty"`
+	Name            string                 `protobuf:"bytes,17,opt,name=name,proto3" json:"name,omitempty"`
+	Comment         string                 `protobuf:"bytes,18,opt,name=comment,proto3" json:"comment,omitempty"`
+	Fields          map[string]string      `protobuf:"bytes,19,rep,name=fields,proto3" json:"fields,omitempty" protobuf_key:"bytes,1,opt,name=key" protobuf_val:"bytes,2,opt,name=value"`
+	Uuid            string                 `protobuf:"bytes,20,opt,name=uuid,proto3" json:"uuid,omitempty"`
+	unknownFields   protoimpl.UnknownFields
+	sizeCache       protoimpl.SizeCache
+}
+
+func (x *Qso) Reset() {
+	*x = Qso{}
+	mi := &file_stationmanager_proto_msgTypes[1]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Qso) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Qso) ProtoMessage() {}
+
+func (x *Qso) ProtoReflect() protoreflect.Message {
+	mi := &file_stationmanager_proto_msgTypes[1]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Qso.ProtoReflect.Descriptor instead.
+func (*Qso) Descriptor() ([]byte, []int) {
+	return file_stationmanager_proto_rawDescGZIP(), []int{1}
+}
+
+func (x *Qso) GetId() int64 {
+	if x != nil {
+		return x.Id
+	}
+	return 0
+}
+
+func (x *Qso) GetLogbookId() int64 {
+	if x != nil {
+		return x.LogbookId
+	}
+	return 0
+}
+
+func (x *Qso) GetSessionId() int64 {
+	if x != nil {
+		return x.SessionId
+	}
+	return 0
+}
+
+func (x *Qso) GetCall() string {
+	if x != nil {
+		return x.Call
+	}
+	return ""
+}
+
+func (x *Qso) GetBand() string {
+	if x != nil {
+		return x.Band
+	}
+	return ""
+}
+
+func (x *Qso) GetMode() string {
+	if x != nil {
+		return x.Mode
+	}
+	return ""
+}
+
+func (x *Qso) GetSubmode() string {
+	if x != nil {
+		return x.Submode
+	}
+	return ""
+}
+
+func (x *Qso) GetFreq() string {
+	if x != nil {
+		return x.Freq
+	}
+	return ""
+}
+
+func (x *Qso) GetQsoDate() string {
+	if x != nil {
+		return x.QsoDate
+	}
+	return ""
+}
+
+func (x *Qso) GetTimeOn() string {
+	if x != nil {
+		return x.TimeOn
+	}
+	return ""
+}
+
+func (x *Qso) GetTimeOff() string {
+	if x != nil {
+		return x.TimeOff
+	}
+	return ""
+}
+
+func (x *Qso) GetRstSent() string {
+	if x != nil {
+		return x.RstSent
+	}
+	return ""
+}
+
+func (x *Qso) GetRstRcvd() string {
+	if x != nil {
+		return x.RstRcvd
+	}
+	return ""
+}
+
+func (x *Qso) GetStationCallsign() string {
+	if x != nil {
+		return x.StationCallsign
+	}
+	return ""
+}
+
+func (x *Qso) GetOperator() string {
+	if x != nil {
+		return x.Operator
+	}
+	return ""
+}
+
+func (x *Qso) GetGridsquare() string {
+	if x != nil {
+		return x.Gridsquare
+	}
+	return ""
+}
+
+func (x *Qso) GetName() string {
+	if x != nil {
+		return x.Name
+	}
+	return ""
+}
+
+func (x *Qso) GetComment() string {
+	if x != nil {
+		return x.Comment
+	}
+	return ""
+}
+
+func (x *Qso) GetFields() map[string]string {
+	if x != nil {
+		return x.Fields
+	}
+	return nil
+}
+
+func (x *Qso) GetUuid() string {
+	if x != nil {
+		return x.Uuid
+	}
+	return ""
+}
+
+type GetLogbookRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *GetLogbookRequest) Reset() {
+	*x = GetLogbookRequest{}
+	mi := &file_stationmanager_proto_msgTypes[2]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *GetLogbookRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*GetLogbookRequest) ProtoMessage() {}
+
+func (x *GetLogbookRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_stationmanager_proto_msgTypes[2]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use GetLogbookRequest.ProtoReflect.Descriptor instead.
+func (*GetLogbookRequest) Descriptor() ([]byte, []int) {
+	return file_stationmanager_proto_rawDescGZIP(), []int{2}
+}
+
+type InsertQsoRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Qso           *Qso                   `protobuf:"bytes,1,opt,name=qso,proto3" json:"qso,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *InsertQsoRequest) Reset() {
+	*x = InsertQsoRequest{}
+	mi := &file_stationmanager_proto_msgTypes[3]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *InsertQsoRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*InsertQsoRequest) ProtoMessage() {}
+
+func (x *InsertQsoRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_stationmanager_proto_msgTypes[3]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use InsertQsoRequest.ProtoReflect.Descriptor instead.
+func (*InsertQsoRequest) Descriptor() ([]byte, []int) {
+	return file_stationmanager_proto_rawDescGZIP(), []int{3}
+}
+
+func (x *InsertQsoRequest) GetQso() *Qso {
+	if x != nil {
+		return x.Qso
+	}
+	return nil
+}
+
+type InsertQsoResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Id            int64                  `protobuf:"varint,1,opt,name=id,proto3" json:"id,omitempty"`
+	Uuid          string                 `protobuf:"bytes,2,opt,name=uuid,proto3" json:"uuid,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *InsertQsoResponse) Reset() {
+	*x = InsertQsoResponse{}
+	mi := &file_stationmanager_proto_msgTypes[4]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *InsertQsoResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*InsertQsoResponse) ProtoMessage() {}
+
+func (x *InsertQsoResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_stationmanager_proto_msgTypes[4]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use InsertQsoResponse.ProtoReflect.Descriptor instead.
+func (*InsertQsoResponse) Descriptor() ([]byte, []int) {
+	return file_stationmanager_proto_rawDescGZIP(), []int{4}
+}
+
+func (x *InsertQsoResponse) GetId() int64 {
+	if x != nil {
+		return x.Id
+	}
+	return 0
+}
+
+func (x *InsertQsoResponse) GetUuid() string {
+	if x != nil {
+		return x.Uuid
+	}
+	return ""
+}
+
+// GetQsoRequest names the QSO by its id or its uuid.
+type GetQsoRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Id            int64                  `protobuf:"varint,1,opt,name=id,proto3" json:"id,omitempty"`
+	Uuid          string                 `protobuf:"bytes,2,opt,name=uuid,proto3" json:"uuid,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *GetQsoRequest) Reset() {
+	*x = GetQsoRequest{}
+	mi := &file_stationmanager_proto_msgTypes[5]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *GetQsoRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*GetQsoRequest) ProtoMessage() {}
+
+func (x *GetQsoRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_stationmanager_proto_msgTypes[5]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use GetQsoRequest.ProtoReflect.Descriptor instead.
+func (*GetQsoRequest) Descriptor() ([]byte, []int) {
+	return file_stationmanager_proto_rawDescGZIP(), []int{5}
+}
+
+func (x *GetQsoRequest) GetId() int64 {
+	if x != nil {
+		return x.Id
+	}
+	return 0
+}
+
+func (x *GetQsoRequest) GetUuid() string {
+	if x != nil {
+		return x.Uuid
+	}
+	return ""
+}
+
+type ListQsosRequest struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// after_id continues from the last QSO of the previous page; zero starts at the first QSO.
+	AfterId int64 `protobuf:"varint,1,opt,name=after_id,json=afterId,proto3" json:"after_id,omitempty"`
+	// limit defaults to 50 and is capped at 100.
+	Limit         int32 `protobuf:"varint,2,opt,name=limit,proto3" json:"limit,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ListQsosRequest) Reset() {
+	*x = ListQsosRequest{}
+	mi := &file_stationmanager_proto_msgTypes[6]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ListQsosRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ListQsosRequest) ProtoMessage() {}
+
+func (x *ListQsosRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_stationmanager_proto_msgTypes[6]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ListQsosRequest.ProtoReflect.Descriptor instead.
+func (*ListQsosRequest) Descriptor() ([]byte, []int) {
+	return file_stationmanager_proto_rawDescGZIP(), []int{6}
+}
+
+func (x *ListQsosRequest) GetAfterId() int64 {
+	if x != nil {
+		return x.AfterId
+	}
+	return 0
+}
+
+func (x *ListQsosRequest) GetLimit() int32 {
+	if x != nil {
+		return x.Limit
+	}
+	return 0
+}
+
+type ListQsosResponse struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	Qsos  []*Qso                 `protobuf:"bytes,1,rep,name=qsos,proto3" json:"qsos,omitempty"`
+	// next_after_id is the after_id of the next page, or zero when this is the last page.
+	NextAfterId   int64 `protobuf:"varint,2,opt,name=next_after_id,json=nextAfterId,proto3" json:"next_after_id,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ListQsosResponse) Reset() {
+	*x = ListQsosResponse{}
+	mi := &file_stationmanager_proto_msgTypes[7]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ListQsosResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ListQsosResponse) ProtoMessage() {}
+
+func (x *ListQsosResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_stationmanager_proto_msgTypes[7]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ListQsosResponse.ProtoReflect.Descriptor instead.
+func (*ListQsosResponse) Descriptor() ([]byte, []int) {
+	return file_stationmanager_proto_rawDescGZIP(), []int{7}
+}
+
+func (x *ListQsosResponse) GetQsos() []*Qso {
+	if x != nil {
+		return x.Qsos
+	}
+	return nil
+}
+
+func (x *ListQsosResponse) GetNextAfterId() int64 {
+	if x != nil {
+		return x.NextAfterId
+	}
+	return 0
+}
+
+// DeleteQsoRequest names the QSO by its id or its uuid.
+type DeleteQsoRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Id            int64                  `protobuf:"varint,1,opt,name=id,proto3" json:"id,omitempty"`
+	Uuid          string                 `protobuf:"bytes,2,opt,name=uuid,proto3" json:"uuid,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *DeleteQsoRequest) Reset() {
+	*x = DeleteQsoRequest{}
+	mi := &file_stationmanager_proto_msgTypes[8]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *DeleteQsoRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*DeleteQsoRequest) ProtoMessage() {}
+
+func (x *DeleteQsoRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_stationmanager_proto_msgTypes[8]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use DeleteQsoRequest.ProtoReflect.Descriptor instead.
+func (*DeleteQsoRequest) Descriptor() ([]byte, []int) {
+	return file_stationmanager_proto_rawDescGZIP(), []int{8}
+}
+
+func (x *DeleteQsoRequest) GetId() int64 {
+	if x != nil {
+		return x.Id
+	}
+	return 0
+}
+
+func (x *DeleteQsoRequest) GetUuid() string {
+	if x != nil {
+		return x.Uuid
+	}
+	return ""
+}
+
+type DeleteQsoResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *DeleteQsoResponse) Reset() {
+	*x = DeleteQsoResponse{}
+	mi := &file_stationmanager_proto_msgTypes[9]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *DeleteQsoResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*DeleteQsoResponse) ProtoMessage() {}
+
+func (x *DeleteQsoResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_stationmanager_proto_msgTypes[9]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use DeleteQsoResponse.ProtoReflect.Descriptor instead.
+func (*DeleteQsoResponse) Descriptor() ([]byte, []int) {
+	return file_stationmanager_proto_rawDescGZIP(), []int{9}
+}
+
+type UploadQsosResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Received      int32                  `protobuf:"varint,1,opt,name=received,proto3" json:"received,omitempty"`
+	Imported      int32                  `protobuf:"varint,2,opt,name=imported,proto3" json:"imported,omitempty"`
+	Duplicates    int32                  `protobuf:"varint,3,opt,name=duplicates,proto3" json:"duplicates,omitempty"`
+	Rejected      int32                  `protobuf:"varint,4,opt,name=rejected,proto3" json:"rejected,omitempty"`
+	Errors        []*UploadRejection     `protobuf:"bytes,5,rep,name=errors,proto3" json:"errors,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *UploadQsosResponse) Reset() {
+	*x = UploadQsosResponse{}
+	mi := &file_stationmanager_proto_msgTypes[10]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *UploadQsosResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*UploadQsosResponse) ProtoMessage() {}
+
+func (x *UploadQsosResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_stationmanager_proto_msgTypes[10]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use UploadQsosResponse.ProtoReflect.Descriptor instead.
+func (*UploadQsosResponse) Descriptor() ([]byte, []int) {
+	return file_stationmanager_proto_rawDescGZIP(), []int{10}
+}
+
+func (x *UploadQsosResponse) GetReceived() int32 {
+	if x != nil {
+		return x.Received
+	}
+	return 0
+}
+
+func (x *UploadQsosResponse) GetImported() int32 {
+	if x != nil {
+		return x.Imported
+	}
+	return 0
+}
+
+func (x *UploadQsosResponse) GetDuplicates() int32 {
+	if x != nil {
+		return x.Duplicates
+	}
+	return 0
+}
+
+func (x *UploadQsosResponse) GetRejected() int32 {
+	if x != nil {
+		return x.Rejected
+	}
+	return 0
+}
+
+func (x *UploadQsosResponse) GetErrors() []*UploadRejection {
+	if x != nil {
+		return x.Errors
+	}
+	return nil
+}
+
+type UploadRejection struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// qso is the 1-based position of the QSO in the stream.
+	Qso           int32  `protobuf:"varint,1,opt,name=qso,proto3" json:"qso,omitempty"`
+	Error         string `protobuf:"bytes,2,opt,name=error,proto3" json:"error,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *UploadRejection) Reset() {
+	*x = UploadRejection{}
+	mi := &file_stationmanager_proto_msgTypes[11]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *UploadRejection) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*UploadRejection) ProtoMessage() {}
+
+func (x *UploadRejection) ProtoReflect() protoreflect.Message {
+	mi := &file_stationmanager_proto_msgTypes[11]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use UploadRejection.ProtoReflect.Descriptor instead.
+func (*UploadRejection) Descriptor() ([]byte, []int) {
+	return file_stationmanager_proto_rawDescGZIP(), []int{11}
+}
+
+func (x *UploadRejection) GetQso() int32 {
+	if x != nil {
+		return x.Qso
+	}
+	return 0
+}
+
+func (x *UploadRejection) GetError() string {
+	if x != nil {
+		return x.Error
+	}
+	return ""
+}
+
+var File_stationmanager_proto protoreflect.FileDescriptor
+
+const file_stationmanager_proto_rawDesc = "" +
+	"\n" +
+	"\x14stationmanager.proto\x12\x11stationmanager.v1\"\x7f\n" +
+	"\aLogbook\x12\x0e\n" +
+	"\x02id\x18\x01 \x01(\x03R\x02id\x12\x12\n" +
+	"\x04name\x18\x02 \x01(\tR\x04name\x12\x1a\n" +
+	"\bcallsign\x18\x03 \x01(\tR\bcallsign\x12 \n" +
+	"\vdescription\x18\x04 \x01(\tR\vdescription\x12\x12\n" +
+	"\x04uuid\x18\x05 \x01(\tR\x04uuid\"\xe2\x04\n" +
+	"\x03Qso\x12\x0e\n" +
+	"\x02id\x18\x01 \x01(\x03R\x02id\x12\x1d\n" +
+	"\n" +
+	"logbook_id\x18\x02 \x01(\x03R\tlogbookId\x12\x1d\n" +
+	"\n" +
+	"session_id\x18\x03 \x01(\x03R\tsessionId\x12\x12\n" +
+	"\x04call\x18\x04 \x01(\tR\x04call\x12\x12\n" +
+	"\x04band\x18\x05 \x01(\tR\x04band\x12\x12\n" +
+	"\x04mode\x18\x06 \x01(\tR\x04mode\x12\x18\n" +
+	"\asubmode\x18\a \x01(\tR\asubmode\x12\x12\n" +
+	"\x04freq\x18\b \x01(\tR\x04freq\x12\x19\n" +
+	"\bqso_date\x18\t \x01(\tR\aqsoDate\x12\x17\n" +
+	"\atime_on\x18\n" +
+	" \x01(\tR\x06timeOn\x12\x19\n" +
+	"\btime_off\x18\v \x01(\tR\atimeOff\x12\x19\n" +
+	"\brst_sent\x18\f \x01(\tR\arstSent\x12\x19\n" +
+	"\brst_rcvd\x18\r \x01(\tR\arstRcvd\x12)\n" +
+	"\x10station_callsign\x18\x0e \x01(\tR\x0fstationCallsign\x12\x1a\n" +
+	"\boperator\x18\x0f \x01(\tR\boperator\x12\x1e\n" +
+	"\n" +
+	"gridsquare\x18\x10 \x01(\tR\n" +
+	"gridsquare\x12\x12\n" +
+	"\x04name\x18\x11 \x01(\tR\x04name\x12\x18\n" +
+	"\acomment\x18\x12 \x01(\tR\acomment\x12:\n" +
+	"\x06fields\x18\x13 \x03(\v2\".stationmanager.v1.Qso.FieldsEntryR\x06fields\x12\x12\n" +
+	"\x04uuid\x18\x14 \x01(\tR\x04uuid\x1a9\n" +
+	"\vFieldsEntry\x12\x10\n" +
+	"\x03key\x18\x01 \x01(\tR\x03key\x12\x14\n" +
+	"\x05value\x18\x02 \x01(\tR\x05value:\x028\x01\"\x13\n" +
+	"\x11GetLogbookRequest\"<\n" +
+	"\x10InsertQsoRequest\x12(\n" +
+	"\x03qso\x18\x01 \x01(\v2\x16.stationmanager.v1.QsoR\x03qso\"7\n" +
+	"\x11InsertQsoResponse\x12\x0e\n" +
+	"\x02id\x18\x01 \x01(\x03R\x02id\x12\x12\n" +
+	"\x04uuid\x18\x02 \x01(\tR\x04uuid\"3\n" +
+	"\rGetQsoRequest\x12\x0e\n" +
+	"\x02id\x18\x01 \x01(\x03R\x02id\x12\x12\n" +
+	"\x04uuid\x18\x02 \x01(\tR\x04uuid\"B\n" +
+	"\x0fListQsosRequest\x12\x19\n" +
+	"\bafter_id\x18\x01 \x01(\x03R\aafterId\x12\x14\n" +
+	"\x05limit\x18\x02 \x01(\x05R\x05limit\"b\n" +
+	"\x10ListQsosResponse\x12*\n" +
+	"\x04qsos\x18\x01 \x03(\v2\x16.stationmanager.v1.QsoR\x04qsos\x12\"\n" +
+	"\rnext_after_id\x18\x02 \x01(\x03R\vnextAfterId\"6\n" +
+	"\x10DeleteQsoRequest\x12\x0e\n" +
+	"\x02id\x18\x01 \x01(\x03R\x02id\x12\x12\n" +
+	"\x04uuid\x18\x02 \x01(\tR\x04uuid\"\x13\n" +
+	"\x11DeleteQsoResponse\"\xc4\x01\n" +
+	"\x12UploadQsosResponse\x12\x1a\n" +
+	"\breceived\x18\x01 \x01(\x05R\breceived\x12\x1a\n" +
+	"\bimported\x18\x02 \x01(\x05R\bimported\x12\x1e\n" +
+	"\n" +
+	"duplicates\x18\x03 \x01(\x05R\n" +
+	"duplicates\x12\x1a\n" +
+	"\brejected\x18\x04 \x01(\x05R\brejected\x12:\n" +
+	"\x06errors\x18\x05 \x03(\v2\".stationmanager.v1.UploadRejectionR\x06errors\"9\n" +
+	"\x0fUploadRejection\x12\x10\n" +
+	"\x03qso\x18\x01 \x01(\x05R\x03qso\x12\x14\n" +
+	"\x05error\x18\x02 \x01(\tR\x05error2\xf2\x03\n" +
+	"\bLogbooks\x12N\n" +
+	"\n" +
+	"GetLogbook\x12$.stationmanager.v1.GetLogbookRequest\x1a\x1a.stationmanager.v1.Logbook\x12V\n" +
+	"\tInsertQso\x12#.stationmanager.v1.InsertQsoRequest\x1a$.stationmanager.v1.InsertQsoResponse\x12B\n" +
+	"\x06GetQso\x12 .stationmanager.v1.GetQsoRequest\x1a\x16.stationmanager.v1.Qso\x12S\n" +
+	"\bListQsos\x12\".stationmanager.v1.ListQsosRequest\x1a#.stationmanager.v1.ListQsosResponse\x12V\n" +
+	"\tDeleteQso\x12#.stationmanager.v1.DeleteQsoRequest\x1a$.stationmanager.v1.DeleteQsoResponse\x12M\n" +
+	"\n" +
+	"UploadQsos\x12\x16.stationmanager.v1.Qso\x1a%.stationmanager.v1.UploadQsosResponse(\x01B3Z1github.com/Station-Manager/server/service/grpcapib\x06proto3"
+
+var (
+	file_stationmanager_proto_rawDescOnce sync.Once
+	file_stationmanager_proto_rawDescData []byte
+)
+
+func file_stationmanager_proto_rawDescGZIP() []byte {
+	file_stationmanager_proto_rawDescOnce.Do(func() {
+		file_stationmanager_proto_rawDescData = protoimpl.X.CompressGZIP(unsafe.Slice(unsafe.StringData(file_stationmanager_proto_rawDesc), len(file_stationmanager_proto_rawDesc)))
+	})
+	return file_stationmanager_proto_rawDescData
+}
+
+var file_stationmanager_proto_msgTypes = make([]protoimpl.MessageInfo, 13)
+var file_stationmanager_proto_goTypes = []any{
+	(*Logbook)(nil),            // 0: stationmanager.v1.Logbook
+	(*Qso)(nil),                // 1: stationmanager.v1.Qso
+	(*GetLogbookRequest)(nil),  // 2: stationmanager.v1.GetLogbookRequest
+	(*InsertQsoRequest)(nil),   // 3: stationmanager.v1.InsertQsoRequest
+	(*InsertQsoResponse)(nil),  // 4: stationmanager.v1.InsertQsoResponse
+	(*GetQsoRequest)(nil),      // 5: stationmanager.v1.GetQsoRequest
+	(*ListQsosRequest)(nil),    // 6: stationmanager.v1.ListQsosRequest
+	(*ListQsosResponse)(nil),   // 7: stationmanager.v1.ListQsosResponse
+	(*DeleteQsoRequest)(nil),   // 8: stationmanager.v1.DeleteQsoRequest
+	(*DeleteQsoResponse)(nil),  // 9: stationmanager.v1.DeleteQsoResponse
+	(*UploadQsosResponse)(nil), // 10: stationmanager.v1.UploadQsosResponse
+	(*UploadRejection)(nil),    // 11: stationmanager.v1.UploadRejection
+	nil,                        // 12: stationmanager.v1.Qso.FieldsEntry
+}
+var file_stationmanager_proto_depIdxs = []int32{
+	12, // 0: stationmanager.v1.Qso.fields:type_name -> stationmanager.v1.Qso.FieldsEntry
+	1,  // 1: stationmanager.v1.InsertQsoRequest.qso:type_name -> stationmanager.v1.Qso
+	1,  // 2: stationmanager.v1.ListQsosResponse.qsos:type_name -> stationmanager.v1.Qso
+	11, // 3: stationmanager.v1.UploadQsosResponse.errors:type_name -> stationmanager.v1.UploadRejection
+	2,  // 4: stationmanager.v1.Logbooks.GetLogbook:input_type -> stationmanager.v1.GetLogbookRequest
+	3,  // 5: stationmanager.v1.Logbooks.InsertQso:input_type -> stationmanager.v1.InsertQsoRequest
+	5,  // 6: stationmanager.v1.Logbooks.GetQso:input_type -> stationmanager.v1.GetQsoRequest
+	6,  // 7: stationmanager.v1.Logbooks.ListQsos:input_type -> stationmanager.v1.ListQsosRequest
+	8,  // 8: stationmanager.v1.Logbooks.DeleteQso:input_type -> stationmanager.v1.DeleteQsoRequest
+	1,  // 9: stationmanager.v1.Logbooks.UploadQsos:input_type -> stationmanager.v1.Qso
+	0,  // 10: stationmanager.v1.Logbooks.GetLogbook:output_type -> stationmanager.v1.Logbook
+	4,  // 11: stationmanager.v1.Logbooks.InsertQso:output_type -> stationmanager.v1.InsertQsoResponse
+	1,  // 12: stationmanager.v1.Logbooks.GetQso:output_type -> stationmanager.v1.Qso
+	7,  // 13: stationmanager.v1.Logbooks.ListQsos:output_type -> stationmanager.v1.ListQsosResponse
+	9,  // 14: stationmanager.v1.Logbooks.DeleteQso:output_type -> stationmanager.v1.DeleteQsoResponse
+	10, // 15: stationmanager.v1.Logbooks.UploadQsos:output_type -> stationmanager.v1.UploadQsosResponse
+	10, // [10:16] is the sub-list for method output_type
+	4,  // [4:10] is the sub-list for method input_type
+	4,  // [4:4] is the sub-list for extension type_name
+	4,  // [4:4] is the sub-list for extension extendee
+	0,  // [0:4] is the sub-list for field type_name
+}
+
+func init() { file_stationmanager_proto_init() }
+func file_stationmanager_proto_init() {
+	if File_stationmanager_proto != nil {
+		return
+	}
+	type x struct{}
+	out := protoimpl.TypeBuilder{
+		File: protoimpl.DescBuilder{
+			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
+			RawDescriptor: unsafe.Slice(unsafe.StringData(file_stationmanager_proto_rawDesc), len(file_stationmanager_proto_rawDesc)),
+			NumEnums:      0,
+			NumMessages:   13,
+			NumExtensions: 0,
+			NumServices:   1,
+		},
+		GoTypes:           file_stationmanager_proto_goTypes,
+		DependencyIndexes: file_stationmanager_proto_depIdxs,
+		MessageInfos:      file_stationmanager_proto_msgTypes,
+	}.Build()
+	File_stationmanager_proto = out.File
+	file_stationmanager_proto_goTypes = nil
+	file_stationmanager_proto_depIdxs = nil
+}
@@ -0,0 +1,327 @@
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.5.1
+// - protoc             (unknown)
+// source: stationmanager.proto
+
+package grpcapi
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.64.0 or later.
+const _ = grpc.SupportPackageIsVersion9
+
+const (
+	Logbooks_GetLogbook_FullMethodName = "/stationmanager.v1.Logbooks/GetLogbook"
+	Logbooks_InsertQso_FullMethodName  = "/stationmanager.v1.Logbooks/InsertQso"
+	Logbooks_GetQso_FullMethodName     = "/stationmanager.v1.Logbooks/GetQso"
+	Logbooks_ListQsos_FullMethodName   = "/stationmanager.v1.Logbooks/ListQsos"
+	Logbooks_DeleteQso_FullMethodName  = "/stationmanager.v1.Logbooks/DeleteQso"
+	Logbooks_UploadQsos_FullMethodName = "/stationmanager.v1.Logbooks/UploadQsos"
+)
+
+// LogbooksClient is the client API for Logbooks service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+//
+// Logbooks exposes a logbook and its QSOs to integrators. Every call is authenticated with an API
+// key of the logbook, sent as "authorization: Bearer <key>" metadata, and is subject to the key's
+// role on the logbook as on the HTTP API.
+type LogbooksClient interface {
+	// GetLogbook returns the logbook the API key belongs to.
+	GetLogbook(ctx context.Context, in *GetLogbookRequest, opts ...grpc.CallOption) (*Logbook, error)
+	// InsertQso logs a QSO, as POST /api/qso/insert does.
+	InsertQso(ctx context.Context, in *InsertQsoRequest, opts ...grpc.CallOption) (*InsertQsoResponse, error)
+	// GetQso returns a QSO of the logbook with its electronic confirmations applied.
+	GetQso(ctx context.Context, in *GetQsoRequest, opts ...grpc.CallOption) (*Qso, error)
+	// ListQsos returns a page of the logbook's QSOs in insertion order.
+	ListQsos(ctx context.Context, in *ListQsosRequest, opts ...grpc.CallOption) (*ListQsosResponse, error)
+	// DeleteQso moves a QSO of the logbook to the trash.
+	DeleteQso(ctx context.Context, in *DeleteQsoRequest, opts ...grpc.CallOption) (*DeleteQsoResponse, error)
+	// UploadQsos stores a stream of QSOs in one transaction, as POST /qsos/import does for an ADIF
+	// document. Invalid QSOs are rejected individually and reported in the response.
+	UploadQsos(ctx context.Context, opts ...grpc.CallOption) (grpc.ClientStreamingClient[Qso, UploadQsosResponse], error)
+}
+
+type logbooksClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewLogbooksClient(cc grpc.ClientConnInterface) LogbooksClient {
+	return &logbooksClient{cc}
+}
+
+func (c *logbooksClient) GetLogbook(ctx context.Context, in *GetLogbookRequest, opts ...grpc.CallOption) (*Logbook, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(Logbook)
+	err := c.cc.Invoke(ctx, Logbooks_GetLogbook_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *logbooksClient) InsertQso(ctx context.Context, in *InsertQsoRequest, opts ...grpc.CallOption) (*InsertQsoResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(InsertQsoResponse)
+	err := c.cc.Invoke(ctx, Logbooks_InsertQso_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *logbooksClient) GetQso(ctx context.Context, in *GetQsoRequest, opts ...grpc.CallOption) (*Qso, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(Qso)
+	err := c.cc.Invoke(ctx, Logbooks_GetQso_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *logbooksClient) ListQsos(ctx context.Context, in *ListQsosRequest, opts ...grpc.CallOption) (*ListQsosResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(ListQsosResponse)
+	err := c.cc.Invoke(ctx, Logbooks_ListQsos_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *logbooksClient) DeleteQso(ctx context.Context, in *DeleteQsoRequest, opts ...grpc.CallOption) (*DeleteQsoResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(DeleteQsoResponse)
+	err := c.cc.Invoke(ctx, Logbooks_DeleteQso_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *logbooksClient) UploadQsos(ctx context.Context, opts ...grpc.CallOption) (grpc.ClientStreamingClient[Qso, UploadQsosResponse], error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	stream, err := c.cc.NewStream(ctx, &Logbooks_ServiceDesc.Streams[0], Logbooks_UploadQsos_FullMethodName, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &grpc.GenericClientStream[Qso, UploadQsosResponse]{ClientStream: stream}
+	return x, nil
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type Logbooks_UploadQsosClient = grpc.ClientStreamingClient[Qso, UploadQsosResponse]
+
+// LogbooksServer is the server API for Logbooks service.
+// All implementations must embed UnimplementedLogbooksServer
+// for forward compatibility.
+//
+// Logbooks exposes a logbook and its QSOs to integrators. Every call is authenticated with an API
+// key of the logbook, sent as "authorization: Bearer <key>" metadata, and is subject to the key's
+// role on the logbook as on the HTTP API.
+type LogbooksServer interface {
+	// GetLogbook returns the logbook the API key belongs to.
+	GetLogbook(context.Context, *GetLogbookRequest) (*Logbook, error)
+	// InsertQso logs a QSO, as POST /api/qso/insert does.
+	InsertQso(context.Context, *InsertQsoRequest) (*InsertQsoResponse, error)
+	// GetQso returns a QSO of the logbook with its electronic confirmations applied.
+	GetQso(context.Context, *GetQsoRequest) (*Qso, error)
+	// ListQsos returns a page of the logbook's QSOs in insertion order.
+	ListQsos(context.Context, *ListQsosRequest) (*ListQsosResponse, error)
+	// DeleteQso moves a QSO of the logbook to the trash.
+	DeleteQso(context.Context, *DeleteQsoRequest) (*DeleteQsoResponse, error)
+	// UploadQsos stores a stream of QSOs in one transaction, as POST /qsos/import does for an ADIF
+	// document. Invalid QSOs are rejected individually and reported in the response.
+	UploadQsos(grpc.ClientStreamingServer[Qso, UploadQsosResponse]) error
+	mustEmbedUnimplementedLogbooksServer()
+}
+
+// UnimplementedLogbooksServer must be embedded to have
+// forward compatible implementations.
+//
+// NOTE: this should be embedded by value instead of pointer to avoid a nil
+// pointer dereference when methods are called.
+type UnimplementedLogbooksServer struct{}
+
+func (UnimplementedLogbooksServer) GetLogbook(context.Context, *GetLogbookRequest) (*Logbook, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetLogbook not implemented")
+}
+func (UnimplementedLogbooksServer) InsertQso(context.Context, *InsertQsoRequest) (*InsertQsoResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method InsertQso not implemented")
+}
+func (UnimplementedLogbooksServer) GetQso(context.Context, *GetQsoRequest) (*Qso, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetQso not implemented")
+}
+func (UnimplementedLogbooksServer) ListQsos(context.Context, *ListQsosRequest) (*ListQsosResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListQsos not implemented")
+}
+func (UnimplementedLogbooksServer) DeleteQso(context.Context, *DeleteQsoRequest) (*DeleteQsoResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method DeleteQso not implemented")
+}
+func (UnimplementedLogbooksServer) UploadQsos(grpc.ClientStreamingServer[Qso, UploadQsosResponse]) error {
+	return status.Errorf(codes.Unimplemented, "method UploadQsos not implemented")
+}
+func (UnimplementedLogbooksServer) mustEmbedUnimplementedLogbooksServer() {}
+func (UnimplementedLogbooksServer) testEmbeddedByValue()                  {}
+
+// UnsafeLogbooksServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to LogbooksServer will
+// result in compilation errors.
+type UnsafeLogbooksServer interface {
+	mustEmbedUnimplementedLogbooksServer()
+}
+
+func RegisterLogbooksServer(s grpc.ServiceRegistrar, srv LogbooksServer) {
+	// If the following call pancis, it indicates UnimplementedLogbooksServer was
+	// embedded by pointer and is nil.  This will cause panics if an
+	// unimplemented method is ever invoked, so we test this at initialization
+	// time to prevent it from happening at runtime later due to I/O.
+	if t, ok := srv.(interface{ testEmbeddedByValue() }); ok {
+		t.testEmbeddedByValue()
+	}
+	s.RegisterService(&Logbooks_ServiceDesc, srv)
+}
+
+func _Logbooks_GetLogbook_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetLogbookRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(LogbooksServer).GetLogbook(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Logbooks_GetLogbook_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(LogbooksServer).GetLogbook(ctx, req.(*GetLogbookRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Logbooks_InsertQso_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(InsertQsoRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(LogbooksServer).InsertQso(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Logbooks_InsertQso_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(LogbooksServer).InsertQso(ctx, req.(*InsertQsoRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Logbooks_GetQso_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetQsoRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(LogbooksServer).GetQso(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Logbooks_GetQso_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(LogbooksServer).GetQso(ctx, req.(*GetQsoRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Logbooks_ListQsos_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListQsosRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(LogbooksServer).ListQsos(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Logbooks_ListQsos_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(LogbooksServer).ListQsos(ctx, req.(*ListQsosRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Logbooks_DeleteQso_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(DeleteQsoRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(LogbooksServer).DeleteQso(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Logbooks_DeleteQso_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(LogbooksServer).DeleteQso(ctx, req.(*DeleteQsoRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Logbooks_UploadQsos_Handler(srv interface{}, stream grpc.ServerStream) error {
+	return srv.(LogbooksServer).UploadQsos(&grpc.GenericServerStream[Qso, UploadQsosResponse]{ServerStream: stream})
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type Logbooks_UploadQsosServer = grpc.ClientStreamingServer[Qso, UploadQsosResponse]
+
+// Logbooks_ServiceDesc is the grpc.ServiceDesc for Logbooks service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var Logbooks_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "stationmanager.v1.Logbooks",
+	HandlerType: (*LogbooksServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "GetLogbook",
+			Handler:    _Logbooks_GetLogbook_Handler,
+		},
+		{
+			MethodName: "InsertQso",
+			Handler:    _Logbooks_InsertQso_Handler,
+		},
+		{
+			MethodName: "GetQso",
+			Handler:    _Logbooks_GetQso_Handler,
+		},
+		{
+			MethodName: "ListQsos",
+			Handler:    _Logbooks_ListQsos_Handler,
+		},
+		{
+			MethodName: "DeleteQso",
+			Handler:    _Logbooks_DeleteQso_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "UploadQsos",
+			Handler:       _Logbooks_UploadQsos_Handler,
+			ClientStreams: true,
+		},
+	},
+	Metadata: "stationmanager.proto",
+}
@@ -1,35 +1,71 @@
 package service
 
 import (
+	stderr "errors"
+
 	"github.com/gofiber/fiber/v2"
 )
 
+// jsonErrorHandler is the Fiber error handler. It answers errors that escape the handlers, such as
+// unknown routes and oversized bodies, in the same JSON shape as the handlers' own error responses.
+// Database errors escaping a handler get the status dbFailure gives them.
+func (s *Service) jsonErrorHandler(c *fiber.Ctx, err error) error {
+	var fe *fiber.Error
+	if !stderr.As(err, &fe) {
+		s.logger.ErrorWith().Err(err).Str("path", c.Path()).Msg("Unhandled error")
+		return s.dbFailure(c, err)
+	}
+
+	code := errCodeInternal
+	switch fe.Code {
+	case fiber.StatusBadRequest:
+		code = errCodeBadRequest
+	case fiber.StatusUnauthorized:
+		code = errCodeUnauthorized
+	case fiber.StatusNotFound:
+		code = errCodeNotFound
+	case fiber.StatusMethodNotAllowed:
+		code = errCodeMethodNotAllowed
+	case fiber.StatusRequestEntityTooLarge:
+		code = errCodeBodyTooLarge
+	}
+	return c.Status(fe.Code).JSON(jsonError(code, fe.Message))
+}
+
 func serverErrorHandler() fiber.Handler {
 	return func(c *fiber.Ctx) error {
 		return c.Status(fiber.StatusInternalServerError).JSON(jsonInternalError)
 	}
 }
 
-// healthHandler returns a simple health status.
+// healthHandler reports the overall service status along with a per-component breakdown.
+// A 503 is returned when any critical component (database, migrations) is down.
 func (s *Service) healthHandler(c *fiber.Ctx) error {
-	// Default to unhealthy; update to healthy on success
-	status := "ok"
-	dbStatus := "unknown"
-
-	if s.db != nil {
-		if err := s.db.Ping(); err != nil {
-			status = "degraded"
-			dbStatus = "unreachable"
-		} else {
-			dbStatus = "up"
-		}
-	} else {
-		status = "degraded"
-		dbStatus = "not_configured"
+	report := s.checkHealth()
+
+	code := fiber.StatusOK
+	if report.Status == healthStatusDown {
+		code = fiber.StatusServiceUnavailable
 	}
 
-	return c.Status(fiber.StatusOK).JSON(fiber.Map{
-		"status": status,
-		"db":     dbStatus,
-	})
+	return c.Status(code).JSON(report)
+}
+
+// readinessHandler answers 200 while the server can serve requests and 503 while its database is
+// unreachable or not yet migrated.
+func (s *Service) readinessHandler(c *fiber.Ctx) error {
+	report := s.checkReadiness()
+
+	code := fiber.StatusOK
+	if report.Status == healthStatusDown {
+		code = fiber.StatusServiceUnavailable
+	}
+
+	return c.Status(code).JSON(report)
+}
+
+// livenessHandler answers 204 without consulting any component, so that it fails only when the
+// server itself has stopped serving requests.
+func (s *Service) livenessHandler(c *fiber.Ctx) error {
+	return c.SendStatus(fiber.StatusNoContent)
 }
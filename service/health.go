@@ -0,0 +1,250 @@
+package service
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/Station-Manager/errors"
+	"github.com/Station-Manager/server/service/cache"
+)
+
+const (
+	healthStatusOK       = "ok"
+	healthStatusUp       = "up"
+	healthStatusDown     = "down"
+	healthStatusDegraded = "degraded"
+	healthStatusUnknown  = "unknown"
+)
+
+const (
+	healthComponentDatabase   = "database"
+	healthComponentMigrations = "migrations"
+	healthComponentCache      = "cache"
+	healthComponentLogDisk    = "log_disk"
+	healthComponentBackup     = "backup"
+)
+
+// minLogDiskFreeBytes is the free space threshold below which the log volume is reported as degraded.
+const minLogDiskFreeBytes = 100 << 20 // 100 MiB
+
+type healthComponent struct {
+	Status   string         `json:"status"`
+	Critical bool           `json:"critical"`
+	Message  string         `json:"message,omitempty"`
+	Details  map[string]any `json:"details,omitempty"`
+}
+
+type healthReport struct {
+	Status     string                     `json:"status"`
+	Components map[string]healthComponent `json:"components"`
+	// Maintenance is true while maintenance mode is on. It does not affect Status, so that load
+	// balancers keep routing to the instance and clients see the maintenance response.
+	Maintenance bool `json:"maintenance,omitempty"`
+}
+
+// checkHealth runs every component check and folds the results into a single report.
+// The overall status is "down" if a critical component is not up, "degraded" if a
+// non-critical component is not up, and "ok" otherwise.
+func (s *Service) checkHealth() healthReport {
+	report := healthReport{
+		Status: healthStatusOK,
+		Components: map[string]healthComponent{
+			healthComponentDatabase:   s.checkDatabaseHealth(),
+			healthComponentMigrations: s.checkMigrationHealth(),
+			healthComponentCache:      s.checkCacheHealth(),
+			healthComponentLogDisk:    s.checkLogDiskHealth(),
+		},
+		Maintenance: s.maintenance.Load() != nil,
+	}
+	if s.backups != nil {
+		report.Components[healthComponentBackup] = s.checkBackupHealth()
+	}
+
+	for _, component := range report.Components {
+		if component.Status == healthStatusUp {
+			continue
+		}
+		if component.Critical {
+			report.Status = healthStatusDown
+			break
+		}
+		report.Status = healthStatusDegraded
+	}
+
+	return report
+}
+
+// checkDatabaseHealth pings the database and reports the round-trip latency and the statistics of
+// the server's connection pools.
+func (s *Service) checkDatabaseHealth() healthComponent {
+	component := healthComponent{Status: healthStatusDown, Critical: true}
+	if s.db == nil {
+		component.Message = "not configured"
+		return component
+	}
+
+	start := time.Now()
+	err := s.db.Ping()
+	latency := time.Since(start)
+
+	component.Details = map[string]any{"latency_ms": latency.Milliseconds()}
+	if pools := s.poolDetails(); len(pools) > 0 {
+		component.Details["pools"] = pools
+	}
+	if err != nil {
+		component.Message = "unreachable"
+		return component
+	}
+
+	component.Status = healthStatusUp
+	return component
+}
+
+// checkMigrationHealth reports whether the database migrations completed during startup.
+func (s *Service) checkMigrationHealth() healthComponent {
+	component := healthComponent{Status: healthStatusDown, Critical: true}
+	if !s.migrated.Load() {
+		component.Message = "migrations have not been applied"
+		return component
+	}
+	component.Status = healthStatusUp
+	return component
+}
+
+// checkCacheHealth reports the occupancy of the in-memory caches. The caches are an optimization only,
+// so their absence never makes the service unhealthy.
+func (s *Service) checkCacheHealth() healthComponent {
+	component := healthComponent{Status: healthStatusUp}
+	if s.logbookCache == nil || s.userCache == nil || s.apiKeyCache == nil {
+		component.Status = healthStatusDegraded
+		component.Message = "not configured"
+		return component
+	}
+
+	component.Details = s.cacheDetails()
+	return component
+}
+
+// cacheDetails returns the statistics of every cache, keyed by its name.
+func (s *Service) cacheDetails() map[string]any {
+	details := make(map[string]any)
+	for _, c := range s.cacheStats() {
+		details[c.Name] = cacheStatsDetails(c.Stats)
+	}
+	return details
+}
+
+func cacheStatsDetails(stats cache.Stats) map[string]any {
+	return map[string]any{
+		"entries":     stats.Entries,
+		"max_entries": stats.MaxEntries,
+		"hits":        stats.Hits,
+		"misses":      stats.Misses,
+		"hit_rate":    stats.HitRate(),
+		"evictions":   stats.Evictions,
+		"expirations": stats.Expirations,
+	}
+}
+
+// checkLogDiskHealth reports the free space on the volume holding the log directory.
+func (s *Service) checkLogDiskHealth() healthComponent {
+	component := healthComponent{Status: healthStatusUnknown}
+	if s.logger == nil || s.logger.LoggingConfig == nil {
+		component.Message = "logging not configured"
+		return component
+	}
+
+	dir := filepath.Join(s.logger.WorkingDir, s.logger.LoggingConfig.RelLogFileDir)
+	free, total, err := diskUsage(dir)
+	if err != nil {
+		component.Message = err.Error()
+		return component
+	}
+
+	component.Details = map[string]any{
+		"path":        dir,
+		"free_bytes":  free,
+		"total_bytes": total,
+	}
+	if free < minLogDiskFreeBytes {
+		component.Status = healthStatusDegraded
+		component.Message = "low disk space"
+		return component
+	}
+
+	component.Status = healthStatusUp
+	return component
+}
+
+// checkReadiness reports whether the server can serve requests: its database is reachable and
+// migrated. Unlike checkHealth it runs only the critical checks, as it is polled often.
+func (s *Service) checkReadiness() healthReport {
+	report := healthReport{
+		Status: healthStatusOK,
+		Components: map[string]healthComponent{
+			healthComponentDatabase:   s.checkDatabaseHealth(),
+			healthComponentMigrations: s.checkMigrationHealth(),
+		},
+	}
+	for _, component := range report.Components {
+		if component.Status != healthStatusUp {
+			report.Status = healthStatusDown
+		}
+	}
+	return report
+}
+
+// ProbeReadiness asks the running server on the configured port whether it is ready and returns an
+// error unless it answers 200. It is meant for container and supervisor health checks run on the
+// same host, so the wildcard listen addresses are probed on loopback.
+func (s *Service) ProbeReadiness(ctx context.Context) error {
+	const op errors.Op = "server.Service.ProbeReadiness"
+	if s == nil {
+		return errors.New(op).Msg(errMsgNilService)
+	}
+
+	status, err := s.probeLocal(ctx, "/readyz")
+	if err != nil {
+		return errors.New(op).Err(err).Msg("The server did not answer")
+	}
+	if status != http.StatusOK {
+		return errors.New(op).Msgf("/readyz returned %d", status)
+	}
+	return nil
+}
+
+// probeLocal requests the path from the server on this host and returns the status it answered.
+func (s *Service) probeLocal(ctx context.Context, path string) (int, error) {
+	const op errors.Op = "server.Service.probeLocal"
+
+	host := s.config.Host
+	if ip := net.ParseIP(host); host == emptyString || (ip != nil && ip.IsUnspecified()) {
+		host = "127.0.0.1"
+	}
+	scheme := "http"
+	if s.config.TLSEnabled || s.autocert != nil {
+		scheme = "https"
+	}
+	url := scheme + "://" + net.JoinHostPort(host, strconv.Itoa(s.config.Port)) + path
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return 0, errors.New(op).Err(err)
+	}
+	client := &http.Client{Transport: &http.Transport{
+		// The probe checks that this host's server answers, not who it is; its certificate is issued
+		// for the public name rather than the loopback address.
+		TLSClientConfig: &tls.Config{InsecureSkipVerify: true}, //nolint:gosec
+	}}
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, errors.New(op).Err(err)
+	}
+	_ = resp.Body.Close()
+	return resp.StatusCode, nil
+}
@@ -0,0 +1,11 @@
+//go:build !linux && !darwin
+
+package service
+
+import "github.com/Station-Manager/errors"
+
+// diskUsage is not supported on this platform.
+func diskUsage(_ string) (uint64, uint64, error) {
+	const op errors.Op = "server.diskUsage"
+	return 0, 0, errors.New(op).Msg("Disk usage is not supported on this platform")
+}
@@ -0,0 +1,18 @@
+//go:build linux || darwin
+
+package service
+
+import (
+	"github.com/Station-Manager/errors"
+	"syscall"
+)
+
+// diskUsage returns the free (available to unprivileged users) and total bytes of the filesystem containing path.
+func diskUsage(path string) (uint64, uint64, error) {
+	const op errors.Op = "server.diskUsage"
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(path, &st); err != nil {
+		return 0, 0, errors.New(op).Err(err)
+	}
+	return st.Bavail * uint64(st.Bsize), st.Blocks * uint64(st.Bsize), nil
+}
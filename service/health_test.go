@@ -0,0 +1,134 @@
+package service
+
+import (
+	"context"
+	"net"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/goccy/go-json"
+	"github.com/gofiber/fiber/v2"
+)
+
+func doHealthRequest(t *testing.T, svc *Service) (int, healthReport) {
+	t.Helper()
+
+	svc.app.Get("/health", svc.healthHandler)
+	resp, err := svc.app.Test(httptest.NewRequest("GET", "/health", nil))
+	if err != nil {
+		t.Fatalf("fiber test request failed: %v", err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	var report healthReport
+	if err = json.NewDecoder(resp.Body).Decode(&report); err != nil {
+		t.Fatalf("failed to decode health report: %v", err)
+	}
+	return resp.StatusCode, report
+}
+
+func TestHealthHandler_NoDatabase(t *testing.T) {
+	svc := &Service{app: fiber.New()}
+	svc.initializeCaches()
+
+	code, report := doHealthRequest(t, svc)
+	if code != fiber.StatusServiceUnavailable {
+		t.Fatalf("expected status %d, got %d", fiber.StatusServiceUnavailable, code)
+	}
+	if report.Status != healthStatusDown {
+		t.Errorf("expected overall status %q, got %q", healthStatusDown, report.Status)
+	}
+	if got := report.Components[healthComponentDatabase].Status; got != healthStatusDown {
+		t.Errorf("expected database status %q, got %q", healthStatusDown, got)
+	}
+}
+
+func TestHealthHandler_Healthy(t *testing.T) {
+	dbSvc := newTestDatabaseService(t)
+	defer func() { _ = dbSvc.Close() }()
+
+	svc := &Service{
+		db:     dbSvc,
+		logger: dbSvc.Logger,
+		app:    fiber.New(),
+	}
+	svc.initializeCaches()
+	svc.migrated.Store(true)
+
+	code, report := doHealthRequest(t, svc)
+	if code != fiber.StatusOK {
+		t.Fatalf("expected status %d, got %d", fiber.StatusOK, code)
+	}
+	for _, name := range []string{healthComponentDatabase, healthComponentMigrations, healthComponentCache} {
+		if got := report.Components[name].Status; got != healthStatusUp {
+			t.Errorf("expected %s status %q, got %q", name, healthStatusUp, got)
+		}
+	}
+	if _, ok := report.Components[healthComponentDatabase].Details["latency_ms"]; !ok {
+		t.Error("expected database latency to be reported")
+	}
+}
+
+func TestHealthHandler_NotMigrated(t *testing.T) {
+	dbSvc := newTestDatabaseService(t)
+	defer func() { _ = dbSvc.Close() }()
+
+	svc := &Service{db: dbSvc, logger: dbSvc.Logger, app: fiber.New()}
+
+	code, report := doHealthRequest(t, svc)
+	if code != fiber.StatusServiceUnavailable {
+		t.Fatalf("expected status %d, got %d", fiber.StatusServiceUnavailable, code)
+	}
+	if got := report.Components[healthComponentMigrations].Status; got != healthStatusDown {
+		t.Errorf("expected migrations status %q, got %q", healthStatusDown, got)
+	}
+}
+
+func TestReadinessHandler(t *testing.T) {
+	dbSvc := newTestDatabaseService(t)
+	defer func() { _ = dbSvc.Close() }()
+
+	svc := &Service{db: dbSvc, logger: dbSvc.Logger, app: fiber.New()}
+	svc.app.Get("/readyz", svc.readinessHandler)
+
+	resp, err := svc.app.Test(httptest.NewRequest("GET", "/readyz", nil))
+	if err != nil {
+		t.Fatalf("fiber test request failed: %v", err)
+	}
+	if resp.StatusCode != fiber.StatusServiceUnavailable {
+		t.Fatalf("expected status %d before migrating, got %d", fiber.StatusServiceUnavailable, resp.StatusCode)
+	}
+
+	svc.migrated.Store(true)
+	resp, err = svc.app.Test(httptest.NewRequest("GET", "/readyz", nil))
+	if err != nil {
+		t.Fatalf("fiber test request failed: %v", err)
+	}
+	if resp.StatusCode != fiber.StatusOK {
+		t.Fatalf("expected status %d, got %d", fiber.StatusOK, resp.StatusCode)
+	}
+}
+
+func TestProbeReadiness(t *testing.T) {
+	dbSvc := newTestDatabaseService(t)
+	defer func() { _ = dbSvc.Close() }()
+
+	svc := &Service{db: dbSvc, logger: dbSvc.Logger, app: fiber.New(fiber.Config{DisableStartupMessage: true})}
+	svc.app.Get("/readyz", svc.readinessHandler)
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen failed: %v", err)
+	}
+	go func() { _ = svc.app.Listener(ln) }()
+	t.Cleanup(func() { _ = svc.app.Shutdown() })
+	svc.config.Host = "0.0.0.0"
+	svc.config.Port = ln.Addr().(*net.TCPAddr).Port
+
+	if err = svc.ProbeReadiness(context.Background()); err == nil {
+		t.Fatal("expected the probe to fail before migrating")
+	}
+	svc.migrated.Store(true)
+	if err = svc.ProbeReadiness(context.Background()); err != nil {
+		t.Fatalf("expected the probe to succeed, got %v", err)
+	}
+}
@@ -1,44 +1,89 @@
 package service
 
 import (
-	stderr "errors"
 	"github.com/Station-Manager/errors"
 	"github.com/Station-Manager/types"
 	"github.com/gofiber/fiber/v2"
-	"github.com/lib/pq"
+	"strings"
 )
 
+// requestEnvelope is the JSON body of the v1 routes: the credentials and payload of a
+// types.PostRequest, and the fields that some of the routes add to them. requestContextMiddleware
+// reads it once, into the request context, where the routes' middleware and handlers find it.
+type requestEnvelope struct {
+	types.PostRequest
+	logbookMemberRequest
+	logbookDeleteOptions
+	qsoInsertOptions
+	adminRequest
+}
+
+// requestContext is what the authentication middleware learns of a request, for the handlers
+// after it. It is the only value kept in the request's locals; see setRequestContext.
 type requestContext struct {
-	Request types.PostRequest
+	Request requestEnvelope
 	User    *types.User
 	Logbook *types.Logbook
 	IsValid bool
+	// Actor identifies who made the request in the QSO history: the API key or the user.
+	Actor string
+	// Role is the request's role on Logbook, or on the logbook named in Request for password routes.
+	Role logbookRole
+	// Member is the user the API key was issued to, or nil for the logbook's own keys.
+	Member *apiKeyMember
+	// Sandbox is set for requests made with a sandbox key, whose Logbook is the sandbox.
+	Sandbox bool
+}
+
+// setRequestContext stores the request context in the Fiber context's local storage. Only
+// middleware sets it; handlers read it with getRequestContext.
+func setRequestContext(c *fiber.Ctx, reqCtx *requestContext) {
+	c.Locals(localsRequestDataKey, reqCtx)
 }
 
 // getRequestContext retrieves the `requestContext` from the Fiber context's local storage.
 // Returns an error if the local data cannot be cast to `*requestContext` or if it is nil.
 func getRequestContext(c *fiber.Ctx) (*requestContext, error) {
+	return requestContextOf(c.Locals(localsRequestDataKey))
+}
+
+// requestContextOf returns the request context held in a local, as WebSocket connections, which
+// keep the locals of the request that opened them, have no Fiber context to read it from.
+func requestContextOf(local any) (*requestContext, error) {
 	const op errors.Op = "server.Service.getRequestContext"
-	ctx, ok := c.Locals(localsRequestDataKey).(*requestContext)
+	ctx, ok := local.(*requestContext)
 	if !ok || ctx == nil {
 		return nil, errors.New(op).Msg("Unable to cast locals to *requestContext")
 	}
 	return ctx, nil
 }
 
-func postgresError(err error) (string, bool) {
+// authenticatedLogbook returns the logbook authenticated by apikeyHeaderAuthNMiddleware.
+func authenticatedLogbook(c *fiber.Ctx) (*types.Logbook, error) {
+	const op errors.Op = "server.authenticatedLogbook"
+	reqCtx, err := getRequestContext(c)
+	if err != nil {
+		return nil, errors.New(op).Err(err)
+	}
+	if reqCtx.Logbook == nil {
+		return nil, errors.New(op).Msg("Logbook is nil in request context")
+	}
+	return reqCtx.Logbook, nil
+}
 
-	var pgErr *pq.Error
-	if stderr.As(err, &pgErr) {
-		// pgErr.Code is the SQLSTATE, e.g. "23505" for unique_violation
-		if pgErr != nil {
-			switch pgErr.Code {
-			case "23505":
-				return "Duplicate", true
-			default:
-				// handle other PG errors
-			}
-		}
+// actorOf returns the actor of the request for the QSO history, or an empty string when the
+// request context is missing.
+func actorOf(c *fiber.Ctx) string {
+	reqCtx, err := getRequestContext(c)
+	if err != nil {
+		return emptyString
 	}
-	return "", false
+	return reqCtx.Actor
+}
+
+// isApiKeyNotFound reports whether err is the database service's "prefix not found" error.
+// The database service does not preserve sql.ErrNoRows for this lookup, so the root message is matched instead.
+func isApiKeyNotFound(err error) bool {
+	root := errors.Root(err)
+	return root != nil && strings.HasPrefix(root.Error(), "prefix not found")
 }
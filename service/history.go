@@ -0,0 +1,222 @@
+package service
+
+import (
+	"context"
+	"database/sql"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/Station-Manager/apikey"
+	"github.com/Station-Manager/errors"
+	"github.com/Station-Manager/types"
+	"github.com/goccy/go-json"
+	"github.com/gofiber/fiber/v2"
+)
+
+type qsoHistoryAction string
+
+const (
+	qsoHistoryInsert  qsoHistoryAction = "insert"
+	qsoHistoryImport  qsoHistoryAction = "import" // inserted by an ADIF import; no field changes are recorded
+	qsoHistoryUpdate  qsoHistoryAction = "update"
+	qsoHistoryDelete  qsoHistoryAction = "delete"
+	qsoHistoryRestore qsoHistoryAction = "restore"
+	qsoHistoryMerge   qsoHistoryAction = "merge" // recorded on both QSOs, each naming the other as related
+)
+
+// actorLookup is the actor of changes made by the callsign lookup integration.
+const actorLookup = "system:lookup"
+
+// qsoHistoryListMax bounds the number of history entries returned for one QSO.
+const qsoHistoryListMax = 1000
+
+// qsoHistoryIgnoredFields are QSO fields that are not tracked by the history: they are assigned by
+// the server or held in columns of their own.
+var qsoHistoryIgnoredFields = map[string]struct{}{
+	"id":              {},
+	"logbook_id":      {},
+	"session_id":      {},
+	"country_details": {},
+	"contact_history": {},
+}
+
+// qsoFieldChange is the value of a QSO field before and after a change.
+type qsoFieldChange struct {
+	Old any `json:"old"`
+	New any `json:"new"`
+}
+
+// qsoHistoryEntry records one change to a QSO.
+type qsoHistoryEntry struct {
+	ID        int64                     `json:"id"`
+	QsoID     int64                     `json:"qso_id"`
+	LogbookID int64                     `json:"-"`
+	Action    qsoHistoryAction          `json:"action"`
+	Actor     string                    `json:"actor"`
+	Changes   map[string]qsoFieldChange `json:"changes"`
+	// RelatedQsoID is the other QSO of a merge.
+	RelatedQsoID *int64    `json:"related_qso_id,omitempty"`
+	CreatedAt    time.Time `json:"created_at"`
+}
+
+// execer is satisfied by both the store and a transaction.
+type execer interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+}
+
+// apiKeyActorPrefix begins the actor of an API key, before the key's public prefix.
+const apiKeyActorPrefix = "api_key:"
+
+// apiKeyActor identifies an API key by its public prefix.
+func apiKeyActor(fullKey string) string {
+	prefix, _, err := apikey.ParseApiKey(fullKey)
+	if err != nil {
+		return "api_key"
+	}
+	return apiKeyActorPrefix + prefix
+}
+
+// userActor identifies a user authenticated by password.
+func userActor(callsign string) string {
+	return "user:" + callsign
+}
+
+// qsoChanges returns the ADIF fields that differ between two versions of a QSO. A new QSO is
+// compared with the zero QSO, so that every field it sets is recorded.
+func qsoChanges(before, after types.Qso) (map[string]qsoFieldChange, error) {
+	old, err := qsoFields(before)
+	if err != nil {
+		return nil, err
+	}
+	current, err := qsoFields(after)
+	if err != nil {
+		return nil, err
+	}
+
+	changes := make(map[string]qsoFieldChange)
+	for name, value := range current {
+		if !reflect.DeepEqual(old[name], value) {
+			changes[name] = qsoFieldChange{Old: old[name], New: value}
+		}
+	}
+	for name, value := range old {
+		if _, ok := current[name]; !ok {
+			changes[name] = qsoFieldChange{Old: value}
+		}
+	}
+	return changes, nil
+}
+
+// qsoFields flattens a QSO to its tracked JSON fields, leaving out empty ones.
+func qsoFields(qso types.Qso) (map[string]any, error) {
+	data, err := json.Marshal(qso)
+	if err != nil {
+		return nil, err
+	}
+	var fields map[string]any
+	if err = json.Unmarshal(data, &fields); err != nil {
+		return nil, err
+	}
+	for name, value := range fields {
+		_, ignored := qsoHistoryIgnoredFields[name]
+		// Fields without a JSON name are the desktop application's bookkeeping.
+		if ignored || strings.HasPrefix(name, "Sm") || value == nil || value == emptyString {
+			delete(fields, name)
+		}
+	}
+	return fields, nil
+}
+
+// recordQsoHistory stores a history entry with exec, which is a transaction when the entry must
+// be written together with the change it records.
+func recordQsoHistory(ctx context.Context, exec execer, entry qsoHistoryEntry) error {
+	const op errors.Op = "server.recordQsoHistory"
+
+	changes := []byte("{}")
+	if len(entry.Changes) > 0 {
+		var err error
+		if changes, err = json.Marshal(entry.Changes); err != nil {
+			return errors.New(op).Err(err)
+		}
+	}
+	if _, err := exec.ExecContext(ctx, `INSERT INTO qso_history (qso_id, logbook_id, action, actor, changes, related_qso_id) VALUES ($1, $2, $3, $4, $5, $6)`,
+		entry.QsoID, entry.LogbookID, string(entry.Action), entry.Actor, string(changes), entry.RelatedQsoID); err != nil {
+		return errors.New(op).Err(err)
+	}
+	return nil
+}
+
+// recordQsoChange records the difference between two versions of a QSO. The change has already
+// been made, so a failure is logged rather than returned.
+func (s *Service) recordQsoChange(ctx context.Context, action qsoHistoryAction, actor string, before, after types.Qso) {
+	changes, err := qsoChanges(before, after)
+	if err == nil {
+		err = recordQsoHistory(ctx, s.db, qsoHistoryEntry{QsoID: after.ID, LogbookID: after.LogbookID, Action: action, Actor: actor, Changes: changes})
+	}
+	if err != nil {
+		s.logger.ErrorWith().Err(err).Int64("qso_id", after.ID).Str("action", string(action)).Msg("Failed to record QSO history")
+	}
+}
+
+// qsoHistoryHandler returns the changes made to a QSO of the authenticated logbook, oldest first.
+// The history of a QSO in the trash remains available.
+func (s *Service) qsoHistoryHandler(c *fiber.Ctx) error {
+	const op errors.Op = "server.Service.qsoHistoryHandler"
+
+	logbook, err := authenticatedLogbook(c)
+	if err != nil {
+		s.logger.ErrorWith().Err(errors.New(op).Err(err)).Msg("authenticatedLogbook failed")
+		return c.Status(fiber.StatusInternalServerError).JSON(jsonInternalError)
+	}
+
+	id, ok, err := s.resolveQsoRef(c.UserContext(), logbook.ID, c.Params("id"))
+	if err != nil {
+		s.logger.ErrorWith().Err(errors.New(op).Err(err)).Msg("s.resolveQsoRef failed")
+		return s.dbFailure(c, err)
+	}
+	if !ok {
+		return c.Status(fiber.StatusBadRequest).JSON(jsonBadRequest)
+	}
+
+	entries, err := s.listQsoHistory(c.UserContext(), logbook.ID, id)
+	if err != nil {
+		s.logger.ErrorWith().Err(errors.New(op).Err(err)).Int64("qso_id", id).Msg("s.listQsoHistory failed")
+		return s.dbFailure(c, err)
+	}
+	if len(entries) == 0 {
+		// QSOs inserted before the history was recorded have none; do not reveal other logbooks' QSOs.
+		return c.Status(fiber.StatusNotFound).JSON(jsonError(errCodeQsoNotFound, "No history for this QSO"))
+	}
+
+	return c.JSON(fiber.Map{"history": entries})
+}
+
+func (s *Service) listQsoHistory(ctx context.Context, logbookID, qsoID int64) ([]qsoHistoryEntry, error) {
+	const op errors.Op = "server.Service.listQsoHistory"
+
+	rows, err := s.db.QueryContext(ctx, `SELECT id, qso_id, logbook_id, action, actor, changes, related_qso_id, created_at FROM qso_history
+		WHERE qso_id = $1 AND logbook_id = $2 ORDER BY id LIMIT $3`, qsoID, logbookID, qsoHistoryListMax)
+	if err != nil {
+		return nil, errors.New(op).Err(err)
+	}
+	defer func() { _ = rows.Close() }()
+
+	entries := make([]qsoHistoryEntry, 0)
+	for rows.Next() {
+		var entry qsoHistoryEntry
+		var action, changes string
+		if err = rows.Scan(&entry.ID, &entry.QsoID, &entry.LogbookID, &action, &entry.Actor, &changes, &entry.RelatedQsoID, &entry.CreatedAt); err != nil {
+			return nil, errors.New(op).Err(err)
+		}
+		entry.Action = qsoHistoryAction(action)
+		if err = json.Unmarshal([]byte(changes), &entry.Changes); err != nil {
+			return nil, errors.New(op).Err(err)
+		}
+		entries = append(entries, entry)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, errors.New(op).Err(err)
+	}
+	return entries, nil
+}
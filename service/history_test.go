@@ -0,0 +1,102 @@
+package service
+
+import (
+	"context"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/Station-Manager/types"
+	"github.com/goccy/go-json"
+	"github.com/gofiber/fiber/v2"
+)
+
+func TestQsoChanges(t *testing.T) {
+	before := types.Qso{ID: 5, LogbookID: 1}
+	before.Call, before.Band, before.Name = "JA1XX", "20m", "Taro"
+	after := before
+	after.Band, after.Name, after.Gridsquare = "40m", emptyString, "PM95"
+
+	changes, err := qsoChanges(before, after)
+	if err != nil {
+		t.Fatalf("qsoChanges failed: %v", err)
+	}
+	if len(changes) != 3 {
+		t.Fatalf("expected 3 changes, got %+v", changes)
+	}
+	if c := changes["band"]; c.Old != "20m" || c.New != "40m" {
+		t.Errorf("unexpected band change %+v", c)
+	}
+	if c := changes["name"]; c.Old != "Taro" || c.New != nil {
+		t.Errorf("unexpected name change %+v", c)
+	}
+	if c := changes["gridsquare"]; c.Old != nil || c.New != "PM95" {
+		t.Errorf("unexpected gridsquare change %+v", c)
+	}
+
+	inserted, err := qsoChanges(types.Qso{}, before)
+	if err != nil || len(inserted) != 3 {
+		t.Errorf("expected a new QSO to record its 3 fields, got %+v (err=%v)", inserted, err)
+	}
+}
+
+func TestQsoHistoryHandler_RecordsImportDeleteAndRestore(t *testing.T) {
+	svc := newTestServer(t)
+	svc.db = newLiveStore(svc.db)
+	ctx := context.Background()
+
+	qso := types.Qso{LogbookID: 1}
+	qso.Call, qso.Band, qso.Mode, qso.Freq, qso.QsoDate, qso.TimeOn, qso.TimeOff = "JA1XX", "20m", "FT8", "14.074", "20240430", "1203", "1203"
+	qso.RstSent, qso.RstRcvd = "-10", "-12"
+	if n, err := svc.bulkInsertQsos(ctx, 1, []types.Qso{qso}, "api_key:abc", nil, nil, false); err != nil || n != 1 {
+		t.Fatalf("bulkInsertQsos failed: %v (inserted %d)", err, n)
+	}
+	ids, err := svc.listLogbookQsoIDs(ctx, 1)
+	if err != nil || len(ids) != 1 {
+		t.Fatalf("expected one QSO, got %v (err=%v)", ids, err)
+	}
+	id := ids[0]
+
+	if _, err = svc.setQsoDeleted(ctx, 1, id, true, "user:W1AW"); err != nil {
+		t.Fatalf("setQsoDeleted failed: %v", err)
+	}
+	if _, err = svc.setQsoDeleted(ctx, 1, id, false, "user:W1AW"); err != nil {
+		t.Fatalf("setQsoDeleted failed: %v", err)
+	}
+	updated := types.Qso{ID: id, LogbookID: 1}
+	updated.Name = "Taro"
+	svc.recordQsoChange(ctx, qsoHistoryUpdate, actorLookup, types.Qso{ID: id, LogbookID: 1}, updated)
+
+	app := fiber.New()
+	app.Get("/qsos/:id/history", withLogbook(1, svc.qsoHistoryHandler))
+	app.Get("/other/qsos/:id/history", withLogbook(2, svc.qsoHistoryHandler))
+
+	path := "/qsos/" + strconv.FormatInt(id, 10) + "/history"
+	if resp, _ := app.Test(httptest.NewRequest(fiber.MethodGet, "/other"+path, nil)); resp.StatusCode != fiber.StatusNotFound {
+		t.Errorf("expected another logbook to get 404, got %d", resp.StatusCode)
+	}
+
+	resp, err := app.Test(httptest.NewRequest(fiber.MethodGet, path, nil))
+	if err != nil || resp.StatusCode != fiber.StatusOK {
+		t.Fatalf("history request failed: %v", err)
+	}
+	var body struct {
+		History []qsoHistoryEntry `json:"history"`
+	}
+	if err = json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		t.Fatalf("decode failed: %v", err)
+	}
+
+	var actions []string
+	for _, entry := range body.History {
+		actions = append(actions, string(entry.Action)+"/"+entry.Actor)
+	}
+	want := "import/api_key:abc delete/user:W1AW restore/user:W1AW update/system:lookup"
+	if got := strings.Join(actions, " "); got != want {
+		t.Fatalf("expected history %q, got %q", want, got)
+	}
+	if c := body.History[3].Changes["name"]; c.New != "Taro" {
+		t.Errorf("expected the update to record the name, got %+v", body.History[3].Changes)
+	}
+}
@@ -0,0 +1,111 @@
+package service
+
+import (
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/Station-Manager/errors"
+	"github.com/gofiber/fiber/v2"
+)
+
+const (
+	// envPrefork names the environment variable that turns on Fiber's prefork mode (e.g. "true"):
+	// the server starts a child process per CPU, each accepting connections on the same port.
+	envPrefork = "SM_PREFORK"
+	// envConcurrency names the environment variable holding the most connections served at once
+	// by each process (Fiber's default is 256 * 1024).
+	envConcurrency = "SM_CONCURRENCY"
+	// envReadBufferSize names the environment variable holding the size of the buffer request
+	// headers are read into (e.g. "16KB"). Requests with larger headers are rejected.
+	envReadBufferSize = "SM_READ_BUFFER_SIZE"
+	// envDisableKeepalive names the environment variable that closes each connection after its
+	// first response (e.g. "true").
+	envDisableKeepalive = "SM_DISABLE_KEEPALIVE"
+	// envNetwork names the environment variable holding the network the HTTP server listens on:
+	// "tcp" for IPv4 and IPv6, "tcp4" or "tcp6".
+	envNetwork = "SM_NETWORK"
+)
+
+// httpTuning holds the performance settings of the HTTP server. The zero value leaves Fiber's
+// defaults in place.
+type httpTuning struct {
+	prefork          bool
+	concurrency      int
+	readBufferSize   int
+	disableKeepalive bool
+	network          string
+}
+
+// loadHTTPTuning reads the HTTP server's performance settings from the environment. Invalid
+// values are an error, as is prefork mode with SQLite: each child is a separate process with its
+// own caches, which only PostgreSQL keeps consistent between processes.
+func loadHTTPTuning(postgres bool) (httpTuning, error) {
+	const op errors.Op = "server.loadHTTPTuning"
+
+	var tuning httpTuning
+	var err error
+	if tuning.prefork, err = loadBool(envPrefork); err != nil {
+		return httpTuning{}, errors.New(op).Err(err)
+	}
+	if tuning.disableKeepalive, err = loadBool(envDisableKeepalive); err != nil {
+		return httpTuning{}, errors.New(op).Err(err)
+	}
+
+	if value := strings.TrimSpace(os.Getenv(envConcurrency)); value != emptyString {
+		if tuning.concurrency, err = strconv.Atoi(value); err != nil || tuning.concurrency <= 0 {
+			return httpTuning{}, errors.New(op).Msg(envConcurrency + " must be a positive number")
+		}
+	}
+	if value := strings.TrimSpace(os.Getenv(envReadBufferSize)); value != emptyString {
+		if tuning.readBufferSize, err = parseByteSize(value); err != nil || tuning.readBufferSize <= 0 {
+			return httpTuning{}, errors.New(op).Msg(envReadBufferSize + " must be a positive size")
+		}
+	}
+
+	tuning.network = strings.ToLower(strings.TrimSpace(os.Getenv(envNetwork)))
+	switch tuning.network {
+	case emptyString:
+		tuning.network = fiber.NetworkTCP
+		if tuning.prefork {
+			// The children share the port with SO_REUSEPORT, which needs a single address family.
+			tuning.network = fiber.NetworkTCP4
+		}
+	case fiber.NetworkTCP:
+		if tuning.prefork {
+			return httpTuning{}, errors.New(op).Msg(envNetwork + " must be tcp4 or tcp6 in prefork mode")
+		}
+	case fiber.NetworkTCP4, fiber.NetworkTCP6:
+	default:
+		return httpTuning{}, errors.New(op).Msg(envNetwork + " must be tcp, tcp4 or tcp6")
+	}
+
+	if tuning.prefork && !postgres {
+		return httpTuning{}, errors.New(op).Msg(envPrefork + " requires PostgreSQL")
+	}
+	return tuning, nil
+}
+
+// apply sets the tuning's values in the Fiber configuration.
+func (t httpTuning) apply(config *fiber.Config) {
+	config.Prefork = t.prefork
+	config.Concurrency = t.concurrency
+	config.ReadBufferSize = t.readBufferSize
+	config.DisableKeepalive = t.disableKeepalive
+	config.Network = t.network
+}
+
+// loadBool reads a true or false environment variable, false when it is unset.
+func loadBool(name string) (bool, error) {
+	const op errors.Op = "server.loadBool"
+
+	value := strings.TrimSpace(os.Getenv(name))
+	if value == emptyString {
+		return false, nil
+	}
+	b, err := strconv.ParseBool(value)
+	if err != nil {
+		return false, errors.New(op).Msg(name + " must be true or false")
+	}
+	return b, nil
+}
@@ -0,0 +1,67 @@
+package service
+
+import (
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+func TestLoadHTTPTuning_Defaults(t *testing.T) {
+	tuning, err := loadHTTPTuning(false)
+	if err != nil {
+		t.Fatalf("loadHTTPTuning failed: %v", err)
+	}
+	if tuning.prefork || tuning.concurrency != 0 || tuning.readBufferSize != 0 || tuning.disableKeepalive {
+		t.Fatalf("expected Fiber's defaults, got %+v", tuning)
+	}
+	if tuning.network != fiber.NetworkTCP {
+		t.Fatalf("expected network %q, got %q", fiber.NetworkTCP, tuning.network)
+	}
+}
+
+func TestLoadHTTPTuning_Values(t *testing.T) {
+	t.Setenv(envPrefork, "true")
+	t.Setenv(envConcurrency, "1024")
+	t.Setenv(envReadBufferSize, "16KB")
+	t.Setenv(envDisableKeepalive, "1")
+
+	tuning, err := loadHTTPTuning(true)
+	if err != nil {
+		t.Fatalf("loadHTTPTuning failed: %v", err)
+	}
+	want := httpTuning{prefork: true, concurrency: 1024, readBufferSize: 16 << 10, disableKeepalive: true, network: fiber.NetworkTCP4}
+	if tuning != want {
+		t.Fatalf("expected %+v, got %+v", want, tuning)
+	}
+
+	var config fiber.Config
+	tuning.apply(&config)
+	if !config.Prefork || config.Concurrency != 1024 || config.ReadBufferSize != 16<<10 || !config.DisableKeepalive || config.Network != fiber.NetworkTCP4 {
+		t.Fatalf("tuning not applied: %+v", config)
+	}
+}
+
+func TestLoadHTTPTuning_Invalid(t *testing.T) {
+	cases := []struct {
+		name     string
+		env      map[string]string
+		postgres bool
+	}{
+		{"prefork flag", map[string]string{envPrefork: "sometimes"}, true},
+		{"concurrency", map[string]string{envConcurrency: "0"}, true},
+		{"read buffer", map[string]string{envReadBufferSize: "big"}, true},
+		{"network", map[string]string{envNetwork: "udp"}, true},
+		{"dual-stack prefork", map[string]string{envPrefork: "true", envNetwork: "tcp"}, true},
+		{"prefork on SQLite", map[string]string{envPrefork: "true"}, false},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			for name, value := range tc.env {
+				t.Setenv(name, value)
+			}
+			if _, err := loadHTTPTuning(tc.postgres); err == nil {
+				t.Fatal("expected an error")
+			}
+		})
+	}
+}
@@ -0,0 +1,232 @@
+package service
+
+import (
+	"context"
+	"database/sql"
+	stderr "errors"
+	"strconv"
+
+	"github.com/Station-Manager/errors"
+	"github.com/Station-Manager/types"
+	"github.com/gofiber/fiber/v2"
+)
+
+// Every import, whether an ADIF document or a gRPC upload, is kept as an import batch: who ran it,
+// when, and what became of its records. Each QSO it stored is tagged with the batch, so that a bad
+// import can be undone in one transaction, moving the batch's QSOs that are not already in the
+// trash to it. QSOs edited since the import go too; they can be restored from the trash one by one.
+
+const (
+	importSourceAdif   = "adif"
+	importSourceUpload = "upload"
+
+	defaultImportBatchListLimit = 50
+	maxImportBatchListLimit     = 500
+)
+
+// errImportUndone fails the undo of an import batch that has been undone already.
+var errImportUndone = stderr.New("the import has already been undone")
+
+// importBatch is an import into a logbook and its counts.
+type importBatch struct {
+	ID         int64  `json:"id"`
+	Source     string `json:"source"`
+	Actor      string `json:"actor,omitempty"`
+	Received   int    `json:"received"`
+	Imported   int    `json:"imported"`
+	Duplicates int    `json:"duplicates"`
+	Rejected   int    `json:"rejected"`
+	// Live is the number of the batch's QSOs that are not in the trash.
+	Live      int    `json:"live"`
+	CreatedAt string `json:"created_at"`
+	UndoneAt  string `json:"undone_at,omitempty"`
+}
+
+// listImportBatchesRequest holds the query parameters of a batch listing.
+type listImportBatchesRequest struct {
+	Limit int `query:"limit" validate:"omitempty,min=1,max=500"`
+}
+
+// insertImportBatch stores the batch for the logbook within tx and sets its ID.
+func insertImportBatch(ctx context.Context, tx *sql.Tx, logbookID int64, batch *importBatch) error {
+	return tx.QueryRowContext(ctx, `INSERT INTO import_batches (logbook_id, source, actor, received, rejected)
+		VALUES ($1, $2, $3, $4, $5) RETURNING id`, logbookID, batch.Source, batch.Actor, batch.Received, batch.Rejected).Scan(&batch.ID)
+}
+
+// updateImportBatchCounts stores the counts of the insert that the batch made.
+func updateImportBatchCounts(ctx context.Context, tx *sql.Tx, batch importBatch) error {
+	_, err := tx.ExecContext(ctx, `UPDATE import_batches SET imported = $1, duplicates = $2 WHERE id = $3`,
+		batch.Imported, batch.Duplicates, batch.ID)
+	return err
+}
+
+// queryImportBatches returns the logbook's import batches the clause selects.
+func (s *Service) queryImportBatches(ctx context.Context, q queryer, logbookID int64, clause string, args ...any) ([]importBatch, error) {
+	rows, err := q.QueryContext(ctx, `SELECT b.id, b.source, b.actor, b.received, b.imported, b.duplicates, b.rejected,
+		(SELECT COUNT(*) FROM qso WHERE qso.import_batch_id = b.id AND qso.deleted_at IS NULL),
+		`+s.timestampExpr(`b.created_at`)+`, COALESCE(`+s.timestampExpr(`b.undone_at`)+`, '')
+		FROM import_batches b WHERE b.logbook_id = $1 `+clause, append([]any{logbookID}, args...)...)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = rows.Close() }()
+
+	batches := make([]importBatch, 0)
+	for rows.Next() {
+		var b importBatch
+		if err = rows.Scan(&b.ID, &b.Source, &b.Actor, &b.Received, &b.Imported, &b.Duplicates, &b.Rejected, &b.Live,
+			&b.CreatedAt, &b.UndoneAt); err != nil {
+			return nil, err
+		}
+		batches = append(batches, b)
+	}
+	return batches, rows.Err()
+}
+
+// listImportBatches returns up to limit of the logbook's import batches, newest first.
+func (s *Service) listImportBatches(ctx context.Context, logbookID int64, limit int) ([]importBatch, error) {
+	const op errors.Op = "server.Service.listImportBatches"
+
+	batches, err := s.queryImportBatches(ctx, s.db, logbookID, `ORDER BY b.id DESC LIMIT $2`, limit)
+	if err != nil {
+		return nil, errors.New(op).Err(err)
+	}
+	return batches, nil
+}
+
+// fetchImportBatch returns the logbook's import batch, and false if it has none of that ID.
+func (s *Service) fetchImportBatch(ctx context.Context, q queryer, logbookID, id int64) (importBatch, bool, error) {
+	const op errors.Op = "server.Service.fetchImportBatch"
+
+	batches, err := s.queryImportBatches(ctx, q, logbookID, `AND b.id = $2`, id)
+	if err != nil {
+		return importBatch{}, false, errors.New(op).Err(err)
+	}
+	if len(batches) == 0 {
+		return importBatch{}, false, nil
+	}
+	return batches[0], true, nil
+}
+
+// undoImportBatch moves the live QSOs of the logbook's import batch to the trash and marks the
+// batch undone. It returns the batch and the number of QSOs moved, and false if the logbook has
+// no batch of that ID.
+func (s *Service) undoImportBatch(ctx context.Context, logbookID, id int64, actor string) (importBatch, int, bool, error) {
+	const op errors.Op = "server.Service.undoImportBatch"
+
+	var (
+		batch   importBatch
+		found   bool
+		deleted []types.Qso
+	)
+	err := s.withTx(ctx, func(tx *sql.Tx) error {
+		var txErr error
+		if batch, found, txErr = s.fetchImportBatch(ctx, tx, logbookID, id); txErr != nil || !found {
+			return txErr
+		}
+		if batch.UndoneAt != emptyString {
+			return errImportUndone
+		}
+		if deleted, txErr = s.selectBulkQsos(ctx, tx, logbookID, qsoFilter{ImportBatch: id}, -1); txErr != nil {
+			return txErr
+		}
+		if txErr = trashQsosTx(ctx, tx, logbookID, deleted, actor); txErr != nil {
+			return txErr
+		}
+		if _, txErr = tx.ExecContext(ctx, `UPDATE import_batches SET undone_at = CURRENT_TIMESTAMP WHERE id = $1`, id); txErr != nil {
+			return txErr
+		}
+		batch, _, txErr = s.fetchImportBatch(ctx, tx, logbookID, id)
+		return txErr
+	})
+	if err != nil {
+		return importBatch{}, 0, false, errors.New(op).Err(err)
+	}
+
+	for _, qso := range deleted {
+		s.publishQsoEvent(ctx, qsoEventDeleted, qso)
+	}
+	return batch, len(deleted), found, nil
+}
+
+// listImportBatchesHandler lists the authenticated logbook's import batches, newest first.
+func (s *Service) listImportBatchesHandler(c *fiber.Ctx) error {
+	const op errors.Op = "server.Service.listImportBatchesHandler"
+
+	logbook, err := authenticatedLogbook(c)
+	if err != nil {
+		s.logger.ErrorWith().Err(errors.New(op).Err(err)).Msg("authenticatedLogbook failed")
+		return c.Status(fiber.StatusInternalServerError).JSON(jsonInternalError)
+	}
+	var request listImportBatchesRequest
+	if err = c.QueryParser(&request); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(jsonBadRequest)
+	}
+	if err = s.validate.Struct(request); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(jsonBadRequest)
+	}
+	if request.Limit == 0 {
+		request.Limit = defaultImportBatchListLimit
+	}
+
+	batches, err := s.listImportBatches(c.UserContext(), logbook.ID, min(request.Limit, maxImportBatchListLimit))
+	if err != nil {
+		s.logger.ErrorWith().Err(errors.New(op).Err(err)).Msg("s.listImportBatches failed")
+		return s.dbFailure(c, err)
+	}
+	return c.JSON(fiber.Map{"batches": batches})
+}
+
+// getImportBatchHandler returns one of the authenticated logbook's import batches.
+func (s *Service) getImportBatchHandler(c *fiber.Ctx) error {
+	const op errors.Op = "server.Service.getImportBatchHandler"
+
+	logbook, err := authenticatedLogbook(c)
+	if err != nil {
+		s.logger.ErrorWith().Err(errors.New(op).Err(err)).Msg("authenticatedLogbook failed")
+		return c.Status(fiber.StatusInternalServerError).JSON(jsonInternalError)
+	}
+	id, err := strconv.ParseInt(c.Params("id"), 10, 64)
+	if err != nil || id <= 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(jsonBadRequest)
+	}
+
+	batch, found, err := s.fetchImportBatch(c.UserContext(), s.db, logbook.ID, id)
+	if err != nil {
+		s.logger.ErrorWith().Err(errors.New(op).Err(err)).Msg("s.fetchImportBatch failed")
+		return s.dbFailure(c, err)
+	}
+	if !found {
+		return c.Status(fiber.StatusNotFound).JSON(jsonError(errCodeImportBatchNotFound, "Import batch not found"))
+	}
+	return c.JSON(fiber.Map{"batch": batch})
+}
+
+// undoImportBatchHandler moves the QSOs of one of the authenticated logbook's import batches to
+// the trash.
+func (s *Service) undoImportBatchHandler(c *fiber.Ctx) error {
+	const op errors.Op = "server.Service.undoImportBatchHandler"
+
+	logbook, err := authenticatedLogbook(c)
+	if err != nil {
+		s.logger.ErrorWith().Err(errors.New(op).Err(err)).Msg("authenticatedLogbook failed")
+		return c.Status(fiber.StatusInternalServerError).JSON(jsonInternalError)
+	}
+	id, err := strconv.ParseInt(c.Params("id"), 10, 64)
+	if err != nil || id <= 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(jsonBadRequest)
+	}
+
+	batch, deleted, found, err := s.undoImportBatch(c.UserContext(), logbook.ID, id, actorOf(c))
+	if stderr.Is(err, errImportUndone) {
+		return c.Status(fiber.StatusConflict).JSON(jsonError(errCodeImportUndone, "The import has already been undone"))
+	}
+	if err != nil {
+		s.logger.ErrorWith().Err(errors.New(op).Err(err)).Int64("batch_id", id).Msg("s.undoImportBatch failed")
+		return s.dbFailure(c, err)
+	}
+	if !found {
+		return c.Status(fiber.StatusNotFound).JSON(jsonError(errCodeImportBatchNotFound, "Import batch not found"))
+	}
+	return c.JSON(fiber.Map{"batch": batch, "deleted": deleted})
+}
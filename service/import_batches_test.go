@@ -0,0 +1,83 @@
+package service
+
+import (
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/Station-Manager/types"
+	"github.com/goccy/go-json"
+	"github.com/gofiber/fiber/v2"
+)
+
+func TestImportBatches(t *testing.T) {
+	svc := newTestServer(t)
+	svc.db = newLiveStore(svc.db)
+	app := fiber.New()
+	app.Use(func(c *fiber.Ctx) error {
+		c.Locals(localsRequestDataKey, &requestContext{Logbook: &types.Logbook{ID: 1, Callsign: "W1AW"}, IsValid: true})
+		return c.Next()
+	})
+	app.Post("/qsos/import", svc.importQsosHandler)
+	app.Post("/qsos/bulk/preview", svc.previewBulkQsosHandler)
+	app.Get("/imports", svc.listImportBatchesHandler)
+	app.Get("/imports/:id", svc.getImportBatchHandler)
+	app.Post("/imports/:id/undo", svc.undoImportBatchHandler)
+	send := func(method, path, body string, out any) int {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set(fiber.HeaderContentType, fiber.MIMEApplicationJSON)
+		resp, err := app.Test(req)
+		if err != nil {
+			t.Fatalf("%s %s failed: %v", method, path, err)
+		}
+		if out != nil {
+			_ = json.NewDecoder(resp.Body).Decode(out)
+		}
+		return resp.StatusCode
+	}
+
+	var result importResult
+	if status := send(fiber.MethodPost, "/qsos/import", testImportAdif, &result); status != fiber.StatusOK || result.BatchID == 0 {
+		t.Fatalf("expected the import to be given a batch, got %d %+v", status, result)
+	}
+	batchPath := "/imports/" + strconv.FormatInt(result.BatchID, 10)
+
+	var detail struct {
+		Batch importBatch `json:"batch"`
+	}
+	if status := send(fiber.MethodGet, batchPath, emptyString, &detail); status != fiber.StatusOK {
+		t.Fatalf("expected the batch, got %d", status)
+	}
+	if b := detail.Batch; b.Source != importSourceAdif || b.Received != 4 || b.Imported != 2 || b.Rejected != 2 || b.Live != 2 || b.UndoneAt != emptyString {
+		t.Errorf("unexpected batch %+v", b)
+	}
+	var preview map[string]any
+	if status := send(fiber.MethodPost, "/qsos/bulk/preview", `{"filter":{"import_batch":`+strconv.FormatInt(result.BatchID, 10)+`}}`, &preview); status != fiber.StatusOK || preview["count"] != float64(2) {
+		t.Errorf("expected the batch's QSOs to be selectable, got %d %v", status, preview)
+	}
+
+	var undone struct {
+		Batch   importBatch `json:"batch"`
+		Deleted int         `json:"deleted"`
+	}
+	if status := send(fiber.MethodPost, batchPath+"/undo", emptyString, &undone); status != fiber.StatusOK || undone.Deleted != 2 {
+		t.Fatalf("expected the undo to trash 2 QSOs, got %d %+v", status, undone)
+	}
+	if undone.Batch.Live != 0 || undone.Batch.UndoneAt == emptyString {
+		t.Errorf("expected the batch to be undone, got %+v", undone.Batch)
+	}
+	if status := send(fiber.MethodPost, batchPath+"/undo", emptyString, nil); status != fiber.StatusConflict {
+		t.Errorf("expected a second undo to conflict, got %d", status)
+	}
+	if status := send(fiber.MethodGet, "/imports/999", emptyString, nil); status != fiber.StatusNotFound {
+		t.Errorf("expected an unknown batch to get 404, got %d", status)
+	}
+
+	var list struct {
+		Batches []importBatch `json:"batches"`
+	}
+	if status := send(fiber.MethodGet, "/imports", emptyString, &list); status != fiber.StatusOK || len(list.Batches) != 1 || list.Batches[0].ID != result.BatchID {
+		t.Errorf("expected the batch to be listed, got %d %+v", status, list)
+	}
+}
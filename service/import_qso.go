@@ -0,0 +1,615 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	stderr "errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Station-Manager/adapters"
+	"github.com/Station-Manager/adapters/converters/common"
+	pgconv "github.com/Station-Manager/adapters/converters/postgres"
+	sqconv "github.com/Station-Manager/adapters/converters/sqlite"
+	pgmodels "github.com/Station-Manager/database/postgres/models"
+	sqmodels "github.com/Station-Manager/database/sqlite/models"
+	"github.com/Station-Manager/errors"
+	"github.com/Station-Manager/server/service/adif"
+	"github.com/Station-Manager/types"
+	"github.com/goccy/go-json"
+	"github.com/gofiber/fiber/v2"
+	"github.com/lib/pq"
+)
+
+// envImportAsyncRecords names the environment variable holding the number of records above which
+// an import runs as a background job unless ?async=false is given (e.g. "1000"). Zero imports
+// documents of any size in the request unless ?async=true is given.
+const envImportAsyncRecords = "SM_IMPORT_ASYNC_RECORDS"
+
+const (
+	defaultImportAsyncRecords = 1000
+	// importProgressInterval is the least time between progress reports of an import job.
+	importProgressInterval = time.Second
+	// copyProgressRows is the number of rows copied between progress reports on PostgreSQL.
+	copyProgressRows = 1000
+
+	// maxImportErrors bounds the rejected records listed in an import response.
+	maxImportErrors = 100
+
+	// sqliteImportBatchSize is the number of rows per INSERT statement on SQLite, well under its
+	// limit on bound parameters.
+	sqliteImportBatchSize = 500
+)
+
+// importColumns are the qso columns written by an import, in the order of importRow.
+var importColumns = []string{"call", "band", "mode", "freq", "qso_date", "time_on", "time_off", "rst_sent", "rst_rcvd", "country", "additional_data", "logbook_id"}
+
+// reservedImportFields are ADIF fields that would otherwise overwrite server-assigned values.
+var reservedImportFields = []string{"ID", "LOGBOOK_ID", "SESSION_ID", "CSID", "COUNTRY_DETAILS", "CONTACT_HISTORY"}
+
+type importRejection struct {
+	Record int    `json:"record"`
+	Error  string `json:"error"`
+}
+
+// importWarning is a warning about a record of a dry run; see qsoWarnings.
+type importWarning struct {
+	Record int `json:"record"`
+	qsoWarning
+}
+
+type importResult struct {
+	Received   int               `json:"received"`
+	Imported   int               `json:"imported"`
+	Duplicates int               `json:"duplicates"`
+	Rejected   int               `json:"rejected"`
+	Errors     []importRejection `json:"errors,omitempty"`
+	// BatchID is the import batch the QSOs were tagged with; see import_batches.go.
+	BatchID int64 `json:"batch_id,omitempty"`
+	// DryRun, Qsos and Warnings are set by a dry run, which lists the QSOs it would have imported.
+	DryRun   bool            `json:"dry_run,omitempty"`
+	Qsos     []types.Qso     `json:"qsos,omitempty"`
+	Warnings []importWarning `json:"warnings,omitempty"`
+}
+
+// importProgress is how far an import job has got. Processed counts the records checked and
+// written so far, including those rejected.
+type importProgress struct {
+	Received  int `json:"received"`
+	Processed int `json:"processed"`
+	Rejected  int `json:"rejected"`
+	// ETA is the estimated number of seconds left, once there is a rate to go by.
+	ETA *int `json:"eta_seconds,omitempty"`
+}
+
+// importProgressReporter passes an import's progress to report at most once per
+// importProgressInterval, and always once every record is processed. A nil reporter does nothing.
+type importProgressReporter struct {
+	report   func(importProgress)
+	received int
+	started  time.Time
+	last     time.Time
+}
+
+func newImportProgressReporter(received int, report func(importProgress)) *importProgressReporter {
+	return &importProgressReporter{report: report, received: received, started: time.Now()}
+}
+
+// update reports the records processed and rejected so far.
+func (r *importProgressReporter) update(processed, rejected int) {
+	if r == nil {
+		return
+	}
+	now := time.Now()
+	if processed < r.received && now.Sub(r.last) < importProgressInterval {
+		return
+	}
+	r.last = now
+
+	progress := importProgress{Received: r.received, Processed: processed, Rejected: rejected}
+	if processed > 0 {
+		eta := int(math.Round(now.Sub(r.started).Seconds() * float64(r.received-processed) / float64(processed)))
+		progress.ETA = &eta
+	}
+	r.report(progress)
+}
+
+// importJob is the payload of an import job.
+type importJob struct {
+	// Operator is the callsign of the member whose API key started the import, if any.
+	Operator string `json:"operator,omitempty"`
+	Actor    string `json:"actor"`
+	Adif     string `json:"adif"`
+}
+
+// loadImportAsyncRecords reads the number of records above which imports run as jobs from the
+// environment.
+func loadImportAsyncRecords() (int, error) {
+	const op errors.Op = "server.loadImportAsyncRecords"
+
+	value := strings.TrimSpace(os.Getenv(envImportAsyncRecords))
+	if value == emptyString {
+		return defaultImportAsyncRecords, nil
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil || n < 0 {
+		return 0, errors.New(op).Msg(envImportAsyncRecords + " must be a non-negative number")
+	}
+	return n, nil
+}
+
+// importQsosHandler loads an ADIF document into the authenticated logbook in one transaction.
+// Invalid records are rejected individually and reported; QSOs already in the logbook are skipped
+// on PostgreSQL. Imported QSOs are not published as events, so award credits of a large import
+// are brought up to date with POST /awards/rebuild. With ?async=true, or without ?async=false
+// for a document of more than importAsyncRecords records, the document is checked and imported by
+// a background job, and the response is the job. The job's progress is on GET /jobs/:id and the
+// logbook's event feed. With ?dry_run=true the document is checked and imported in the request,
+// and rolled back; the result lists the QSOs as they would have been stored, with warnings.
+func (s *Service) importQsosHandler(c *fiber.Ctx) error {
+	const op errors.Op = "server.Service.importQsosHandler"
+
+	reqCtx, err := getRequestContext(c)
+	if err != nil || reqCtx.Logbook == nil {
+		err = errors.New(op).Err(err).Msg("Request context missing")
+		s.logger.ErrorWith().Err(err).Msg("getRequestContext failed")
+		return c.Status(fiber.StatusInternalServerError).JSON(jsonInternalError)
+	}
+	logbook := *reqCtx.Logbook
+	async, chosen := c.QueryBool("async"), c.Query("async") != emptyString
+	dryRun := c.QueryBool("dry_run")
+	if dryRun && async {
+		return c.Status(fiber.StatusBadRequest).JSON(jsonError(errCodeBadRequest, "dry_run cannot be used with async=true"))
+	}
+	sizeDecides := !chosen && !dryRun && s.importAsyncRecords > 0
+
+	// An import run later keeps its document, so that is read in full first.
+	upload := s.uploadBody(c)
+	var document []byte
+	if async || sizeDecides {
+		if document, err = io.ReadAll(upload); err == nil {
+			upload = bytes.NewReader(document)
+		}
+	}
+	var doc adif.Document
+	if err == nil {
+		doc, err = adif.Parse(upload)
+	}
+	if stderr.Is(err, errUploadTooLarge) {
+		return c.Status(fiber.StatusRequestEntityTooLarge).JSON(jsonError(errCodeBodyTooLarge, "The upload may be at most "+strconv.Itoa(s.bodyLimits.upload)+" bytes"))
+	}
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(jsonError(errCodeInvalidAdif, err.Error()))
+	}
+	if len(doc.Records) == 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(jsonError(errCodeInvalidAdif, "The document has no records"))
+	}
+	if sizeDecides && len(doc.Records) > s.importAsyncRecords {
+		async = true
+	}
+
+	// QSOs imported with a member's API key are attributed to that member.
+	operator := emptyString
+	if reqCtx.Member != nil {
+		operator = reqCtx.Member.Callsign
+	}
+
+	if async {
+		j, _, err := s.enqueueJob(c.UserContext(), newJob{Kind: jobKindImport, LogbookID: logbook.ID,
+			Payload: importJob{Operator: operator, Actor: reqCtx.Actor, Adif: string(document)}})
+		if err != nil {
+			s.logger.ErrorWith().Err(errors.New(op).Err(err)).Msg("s.enqueueJob failed")
+			return s.dbFailure(c, err)
+		}
+		c.Location("/jobs/" + strconv.FormatInt(j.ID, 10))
+		return c.Status(fiber.StatusAccepted).JSON(fiber.Map{"job": j})
+	}
+
+	result, err := s.importAdif(c.UserContext(), logbook, operator, reqCtx.Actor, doc, nil, dryRun)
+	if body, exceeded := quotaExceededBody(err); exceeded {
+		return c.Status(fiber.StatusForbidden).JSON(body)
+	}
+	if err != nil {
+		s.logger.ErrorWith().Err(errors.New(op).Err(err)).Int64("logbook_id", logbook.ID).Msg("s.importAdif failed")
+		return s.dbFailure(c, err)
+	}
+	return c.JSON(result)
+}
+
+// runImportJob imports the job's ADIF document into its logbook, reporting its progress.
+func (s *Service) runImportJob(ctx context.Context, j job) (any, error) {
+	const op errors.Op = "server.Service.runImportJob"
+
+	var payload importJob
+	if err := j.decodePayload(&payload); err != nil {
+		return nil, permanentJobFailure(errors.New(op).Err(err))
+	}
+	doc, err := adif.Parse(strings.NewReader(payload.Adif))
+	if err != nil {
+		return nil, permanentJobFailure(errors.New(op).Err(err))
+	}
+	logbook, err := s.fetchLogbookWithCache(ctx, j.LogbookID)
+	if err != nil {
+		return nil, errors.New(op).Err(err)
+	}
+
+	progress := newImportProgressReporter(len(doc.Records), func(p importProgress) { s.reportJobProgress(j, p) })
+	result, err := s.importAdif(ctx, logbook, payload.Operator, payload.Actor, doc, progress, false)
+	if err != nil {
+		if kind := classifyDBError(err); kind == dbErrorUnique || kind == dbErrorForeignKey {
+			return nil, permanentJobFailure(errors.New(op).Err(err))
+		}
+		return nil, errors.New(op).Err(err)
+	}
+	return result, nil
+}
+
+// importAdif loads the document's records into the logbook, attributing them to operator if set,
+// and reports its progress to progress if that is not nil. A dry run rolls the import back and
+// returns the QSOs it would have imported, with the warnings about their records.
+func (s *Service) importAdif(ctx context.Context, logbook types.Logbook, operator, actor string, doc adif.Document, progress *importProgressReporter, dryRun bool) (importResult, error) {
+	const op errors.Op = "server.Service.importAdif"
+
+	defaults, err := s.fetchQsoDefaults(ctx, logbook.ID, emptyString, actor, operator)
+	if err != nil {
+		return importResult{}, errors.New(op).Err(err)
+	}
+
+	result := importResult{Received: len(doc.Records), DryRun: dryRun}
+	qsos := make([]types.Qso, 0, len(doc.Records))
+	for i, rec := range doc.Records {
+		qso, err := s.importQso(rec, logbook, defaults)
+		if err != nil {
+			result.Rejected++
+			if len(result.Errors) < maxImportErrors {
+				result.Errors = append(result.Errors, importRejection{Record: i + 1, Error: err.Error()})
+			}
+			continue
+		}
+		qsos = append(qsos, qso)
+		if dryRun {
+			if err = s.addImportWarnings(&result, i+1, rec, qso); err != nil {
+				return importResult{}, errors.New(op).Err(err)
+			}
+		}
+	}
+	progress.update(result.Rejected, result.Rejected)
+
+	if len(qsos) > 0 {
+		written := func(n int) { progress.update(result.Rejected+n, result.Rejected) }
+		batch := &importBatch{Source: importSourceAdif, Actor: actor, Received: result.Received, Rejected: result.Rejected}
+		if result.Imported, err = s.bulkInsertQsos(ctx, logbook.ID, qsos, actor, batch, written, dryRun); err != nil {
+			// A database error the client can act on is returned as is, for the caller to report.
+			if classifyDBError(err) != dbErrorOther {
+				return result, err
+			}
+			return result, errors.New(op).Err(err).Msg("Bulk insert failed")
+		}
+		result.Duplicates = len(qsos) - result.Imported
+		if !dryRun {
+			result.BatchID = batch.ID
+		}
+	}
+	progress.update(result.Received, result.Rejected)
+	if dryRun {
+		result.Qsos = qsos
+		return result, nil
+	}
+	s.recordInserts(logbook.ID, actor, result.Imported)
+
+	s.logger.InfoWith().Int64("logbook_id", logbook.ID).Int64("batch_id", result.BatchID).Int("imported", result.Imported).Int("rejected", result.Rejected).Msg("QSOs imported")
+	return result, nil
+}
+
+// importQso turns an ADIF record into a QSO of the logbook, applying the checks and defaults of
+// insertQsoHandler.
+func (s *Service) importQso(rec adif.Record, logbook types.Logbook, defaults qsoDefaults) (types.Qso, error) {
+	qso, err := qsoFromAdif(rec)
+	if err != nil {
+		return qso, err
+	}
+
+	if qso.StationCallsign == emptyString {
+		qso.StationCallsign = logbook.Callsign
+	}
+	if !strings.EqualFold(qso.StationCallsign, logbook.Callsign) {
+		return qso, fmt.Errorf("STATION_CALLSIGN %s does not match the logbook's callsign", qso.StationCallsign)
+	}
+	qso.StationCallsign = logbook.Callsign
+	if err = checkQsoCallsigns(qso); err != nil {
+		return qso, err
+	}
+	if qso.TimeOff == emptyString {
+		qso.TimeOff = qso.TimeOn
+	}
+	qso.LogbookID = logbook.ID
+	normalizeQsoMode(&qso)
+	if err = defaults.applyTo(&qso, time.Now()); err != nil {
+		return qso, err
+	}
+
+	// Sessions are a desktop concept; an import is given one on SQLite when it is stored.
+	if err = s.validate.StructExcept(qso, "SessionID"); err != nil {
+		return qso, err
+	}
+	s.resolveQsoEntity(&qso)
+	return qso, nil
+}
+
+// addImportWarnings adds the warnings about the record, imported as qso, to the result of a dry
+// run, up to maxImportErrors of them.
+func (s *Service) addImportWarnings(result *importResult, record int, rec adif.Record, qso types.Qso) error {
+	if len(result.Warnings) >= maxImportErrors {
+		return nil
+	}
+	sent, err := qsoFromAdif(rec)
+	if err != nil {
+		return err
+	}
+	warnings, err := s.qsoWarnings(sent, qso)
+	if err != nil {
+		return err
+	}
+	for _, w := range warnings[:min(len(warnings), maxImportErrors-len(result.Warnings))] {
+		result.Warnings = append(result.Warnings, importWarning{Record: record, qsoWarning: w})
+	}
+	return nil
+}
+
+// qsoFromAdif returns the QSO with the fields of an ADIF record. Fields the server assigns are
+// ignored.
+func qsoFromAdif(rec adif.Record) (types.Qso, error) {
+	fields := make(map[string]string, len(rec))
+	for name, value := range rec {
+		fields[strings.ToLower(name)] = value
+	}
+	for _, name := range reservedImportFields {
+		delete(fields, strings.ToLower(name))
+	}
+
+	// ADIF field names are the JSON names of the QSO fields.
+	var qso types.Qso
+	data, err := json.Marshal(fields)
+	if err != nil {
+		return qso, err
+	}
+	err = json.Unmarshal(data, &qso)
+	return qso, err
+}
+
+// bulkInsertQsos stores the QSOs in one transaction, with an import entry in the history of each,
+// and returns how many were inserted. If batch is set, it is stored in the same transaction with
+// the counts of the insert, given its ID, and the QSOs are tagged with it. written, if set, is told
+// how many rows have been written as the insert goes on. A dry run rolls the transaction back and
+// returns how many would have been.
+func (s *Service) bulkInsertQsos(ctx context.Context, logbookID int64, qsos []types.Qso, actor string, batch *importBatch, written func(n int), dryRun bool) (int, error) {
+	const op errors.Op = "server.Service.bulkInsertQsos"
+
+	rows, err := s.importRows(qsos)
+	if err != nil {
+		return 0, errors.New(op).Err(err)
+	}
+
+	var inserted int
+	err = s.withTx(ctx, func(tx *sql.Tx) error {
+		txErr := s.checkLogbookQuota(ctx, tx, logbookID, quotaQsos, int64(len(qsos)))
+		if txErr != nil {
+			return txErr
+		}
+		var batchID sql.NullInt64
+		if batch != nil {
+			if txErr = insertImportBatch(ctx, tx, logbookID, batch); txErr != nil {
+				return txErr
+			}
+			batchID = sql.NullInt64{Int64: batch.ID, Valid: true}
+		}
+		if s.isPostgres() {
+			inserted, txErr = copyQsos(ctx, tx, rows, batchID, actor, written)
+		} else {
+			inserted, txErr = insertQsoBatches(ctx, tx, rows, batchID, actor, written)
+		}
+		if txErr == nil && batch != nil {
+			batch.Imported, batch.Duplicates = inserted, len(qsos)-inserted
+			txErr = updateImportBatchCounts(ctx, tx, *batch)
+		}
+		if txErr == nil && dryRun {
+			return errDryRun
+		}
+		return txErr
+	})
+	if dryRun && stderr.Is(err, errDryRun) {
+		return inserted, nil
+	}
+	if err != nil {
+		return 0, errors.New(op).Err(err)
+	}
+	return inserted, nil
+}
+
+// importRows converts the QSOs to column values with the same conversions the database module
+// applies when it inserts a single QSO.
+func (s *Service) importRows(qsos []types.Qso) ([][]any, error) {
+	adapter := s.qsoModelAdapter()
+
+	rows := make([][]any, 0, len(qsos))
+	if s.isPostgres() {
+		for i := range qsos {
+			m, err := adapters.AdaptTo[pgmodels.Qso](adapter, &qsos[i])
+			if err != nil {
+				return nil, err
+			}
+			rows = append(rows, []any{m.Call, m.Band, m.Mode, m.Freq, m.QsoDate.Format("2006-01-02"), m.TimeOn.Format("15:04:05"),
+				m.TimeOff.Format("15:04:05"), m.RstSent, m.RstRcvd, m.Country.Ptr(), additionalData(m.AdditionalData), m.LogbookID})
+		}
+		return rows, nil
+	}
+
+	for i := range qsos {
+		m, err := adapters.AdaptTo[sqmodels.Qso](adapter, &qsos[i])
+		if err != nil {
+			return nil, err
+		}
+		rows = append(rows, []any{m.Call, m.Band, m.Mode, m.Freq, m.QsoDate, m.TimeOn, m.TimeOff, m.RstSent, m.RstRcvd,
+			m.Country.Ptr(), additionalData(m.AdditionalData), m.LogbookID})
+	}
+	return rows, nil
+}
+
+// qsoModelAdapter returns an adapter converting QSOs to the database models, with the converters
+// the database module registers for the driver in use.
+func (s *Service) qsoModelAdapter() *adapters.Adapter {
+	return newQsoModelAdapter(s.isPostgres())
+}
+
+// newQsoModelAdapter returns an adapter converting QSOs to the PostgreSQL or SQLite models.
+func newQsoModelAdapter(postgres bool) *adapters.Adapter {
+	adapter := adapters.New()
+	adapter.RegisterConverter("Freq", common.TypeToModelFreqConverter)
+	adapter.RegisterConverter("Country", common.TypeToModelStringConverter)
+	adapter.RegisterConverter("Description", common.TypeToModelStringConverter)
+	adapter.RegisterConverter("AdditionalData", common.TypeToModelStringConverter)
+	if postgres {
+		adapter.RegisterConverter("QsoDate", pgconv.TypeToModelDateConverter)
+		adapter.RegisterConverter("TimeOn", pgconv.TypeToModelTimeConverter)
+		adapter.RegisterConverter("TimeOff", pgconv.TypeToModelTimeConverter)
+	} else {
+		adapter.RegisterConverter("QsoDate", sqconv.TypeToModelDateConverter)
+		adapter.RegisterConverter("TimeOn", sqconv.TypeToModelTimeConverter)
+		adapter.RegisterConverter("TimeOff", sqconv.TypeToModelTimeConverter)
+	}
+	adapter.WarmMetadata(types.ContactedStation{}, sqmodels.ContactedStation{})
+	return adapter
+}
+
+// qsoTypeAdapter returns an adapter from the driver's QSO model to a QSO, converting as the
+// database module does when fetching.
+func (s *Service) qsoTypeAdapter() *adapters.Adapter {
+	return newQsoTypeAdapter(s.isPostgres())
+}
+
+// newQsoTypeAdapter returns an adapter from the PostgreSQL or SQLite models to the types.
+func newQsoTypeAdapter(postgres bool) *adapters.Adapter {
+	adapter := adapters.New()
+	adapter.RegisterConverter("Freq", common.ModelToTypeFreqConverter)
+	adapter.RegisterConverter("Country", common.ModelToTypeStringConverter)
+	adapter.RegisterConverter("Description", common.ModelToTypeStringConverter)
+	if postgres {
+		adapter.RegisterConverter("QsoDate", pgconv.ModelToTypeDateConverter)
+		adapter.RegisterConverter("TimeOn", pgconv.ModelToTypeTimeConverter)
+		adapter.RegisterConverter("TimeOff", pgconv.ModelToTypeTimeConverter)
+	} else {
+		adapter.RegisterConverter("QsoDate", sqconv.ModelToTypeDateConverter)
+		adapter.RegisterConverter("TimeOn", sqconv.ModelToTypeTimeConverter)
+		adapter.RegisterConverter("TimeOff", sqconv.ModelToTypeTimeConverter)
+	}
+	adapter.WarmMetadata(sqmodels.ContactedStation{}, types.ContactedStation{})
+	return adapter
+}
+
+func additionalData(data []byte) string {
+	if len(data) == 0 {
+		return "{}"
+	}
+	return string(data)
+}
+
+// copyQsos streams the rows into a staging table with COPY and moves them into qso, tagged with
+// the import batch, skipping rows that clash with existing QSOs or with each other.
+func copyQsos(ctx context.Context, tx *sql.Tx, rows [][]any, batchID sql.NullInt64, actor string, written func(n int)) (int, error) {
+	copyColumns := append(slices.Clone(importColumns), "import_batch_id")
+	columns := strings.Join(copyColumns, ", ")
+	if _, err := tx.ExecContext(ctx, `CREATE TEMP TABLE qso_import ON COMMIT DROP AS SELECT `+columns+` FROM qso WITH NO DATA`); err != nil {
+		return 0, err
+	}
+
+	stmt, err := tx.PrepareContext(ctx, pq.CopyIn("qso_import", copyColumns...))
+	if err != nil {
+		return 0, err
+	}
+	for i, row := range rows {
+		if _, err = stmt.ExecContext(ctx, append(row, batchID)...); err != nil {
+			_ = stmt.Close()
+			return 0, err
+		}
+		if written != nil && (i+1)%copyProgressRows == 0 {
+			written(i + 1)
+		}
+	}
+	if _, err = stmt.ExecContext(ctx); err != nil {
+		_ = stmt.Close()
+		return 0, err
+	}
+	if err = stmt.Close(); err != nil {
+		return 0, err
+	}
+
+	// The history insert affects one row per inserted QSO.
+	res, err := tx.ExecContext(ctx, `WITH inserted AS (
+			INSERT INTO qso (`+columns+`) SELECT `+columns+` FROM qso_import ON CONFLICT DO NOTHING RETURNING id, logbook_id
+		)
+		INSERT INTO qso_history (qso_id, logbook_id, action, actor) SELECT id, logbook_id, $1, $2 FROM inserted`,
+		string(qsoHistoryImport), actor)
+	if err != nil {
+		return 0, err
+	}
+	n, err := res.RowsAffected()
+	return int(n), err
+}
+
+// insertQsoBatches inserts the rows, tagged with the import batch, with multi-row INSERT
+// statements under a new session, which the SQLite schema requires of every QSO.
+func insertQsoBatches(ctx context.Context, tx *sql.Tx, rows [][]any, batchID sql.NullInt64, actor string, written func(n int)) (int, error) {
+	var sessionID int64
+	if err := tx.QueryRowContext(ctx, `INSERT INTO session (created_at) VALUES (CURRENT_TIMESTAMP) RETURNING id`).Scan(&sessionID); err != nil {
+		return 0, err
+	}
+
+	inserted := 0
+	for start := 0; start < len(rows); start += sqliteImportBatchSize {
+		batch := rows[start:min(start+sqliteImportBatchSize, len(rows))]
+
+		var query strings.Builder
+		query.WriteString(`INSERT INTO qso (` + strings.Join(importColumns, ", ") + `, session_id, import_batch_id) VALUES `)
+		args := make([]any, 0, len(batch)*(len(importColumns)+2))
+		for i, row := range batch {
+			if i > 0 {
+				query.WriteString(", ")
+			}
+			query.WriteString("(")
+			for j := 0; j < len(row)+2; j++ {
+				if j > 0 {
+					query.WriteString(", ")
+				}
+				fmt.Fprintf(&query, "$%d", len(args)+j+1)
+			}
+			query.WriteString(")")
+			args = append(append(args, row...), sessionID, batchID)
+		}
+
+		res, err := tx.ExecContext(ctx, query.String(), args...)
+		if err != nil {
+			return 0, err
+		}
+		n, err := res.RowsAffected()
+		if err != nil {
+			return 0, err
+		}
+		inserted += int(n)
+		if written != nil {
+			written(start + len(batch))
+		}
+	}
+
+	if _, err := tx.ExecContext(ctx, `INSERT INTO qso_history (qso_id, logbook_id, action, actor)
+		SELECT id, logbook_id, $1, $2 FROM qso WHERE session_id = $3`, string(qsoHistoryImport), actor, sessionID); err != nil {
+		return 0, err
+	}
+	return inserted, nil
+}
@@ -0,0 +1,222 @@
+package service
+
+import (
+	"context"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Station-Manager/types"
+	"github.com/goccy/go-json"
+	"github.com/gofiber/fiber/v2"
+)
+
+const testImportAdif = `Exported by a test <ADIF_VER:5>3.1.4 <EOH>
+<CALL:5>JA1XX <BAND:3>20m <MODE:3>FT8 <FREQ:6>14.074 <QSO_DATE:8>20240430 <TIME_ON:4>1203 <RST_SENT:3>-10 <RST_RCVD:3>-12 <NAME:4>Taro <EOR>
+<CALL:4>K1AB <BAND:3>40m <MODE:2>CW <FREQ:5>7.030 <QSO_DATE:8>20240430 <TIME_ON:4>1400 <TIME_OFF:4>1405 <STATION_CALLSIGN:4>w1aw <LOGBOOK_ID:2>99 <EOR>
+<CALL:4>K1AB <BAND:3>20m <MODE:3>SSB <QSO_DATE:8>20240430 <TIME_ON:4>1500 <EOR>
+<CALL:4>G4XX <BAND:3>20m <MODE:2>CW <FREQ:6>14.020 <QSO_DATE:8>20240430 <TIME_ON:4>1600 <STATION_CALLSIGN:4>N0CA <EOR>
+`
+
+func TestImportQsosHandler_Sqlite(t *testing.T) {
+	svc := newTestServer(t)
+	app := fiber.New()
+	app.Post("/qsos/import", func(c *fiber.Ctx) error {
+		c.Locals(localsRequestDataKey, &requestContext{Logbook: &types.Logbook{ID: 1, Callsign: "W1AW"}, IsValid: true})
+		return svc.importQsosHandler(c)
+	})
+
+	resp, err := app.Test(httptest.NewRequest(fiber.MethodPost, "/qsos/import", strings.NewReader(testImportAdif)))
+	if err != nil {
+		t.Fatalf("request failed: %v", err)
+	}
+	if resp.StatusCode != fiber.StatusOK {
+		t.Fatalf("expected 200, got %d", resp.StatusCode)
+	}
+	var result importResult
+	if err = json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		t.Fatalf("decode failed: %v", err)
+	}
+	if result.Received != 4 || result.Imported != 2 || result.Rejected != 2 || len(result.Errors) != 2 {
+		t.Fatalf("unexpected result %+v", result)
+	}
+	if result.Errors[0].Record != 3 || result.Errors[1].Record != 4 {
+		t.Errorf("expected records 3 (no FREQ) and 4 (other callsign) to be rejected, got %+v", result.Errors)
+	}
+
+	ctx := context.Background()
+	rows, err := svc.db.QueryContext(ctx, `SELECT id FROM qso WHERE logbook_id = 1 ORDER BY time_on`)
+	if err != nil {
+		t.Fatalf("query failed: %v", err)
+	}
+	var ids []int64
+	for rows.Next() {
+		var id int64
+		_ = rows.Scan(&id)
+		ids = append(ids, id)
+	}
+	_ = rows.Close()
+	if len(ids) != 2 {
+		t.Fatalf("expected 2 QSOs in logbook 1, got %d", len(ids))
+	}
+
+	qso, err := svc.db.FetchQsoByIdContext(ctx, ids[0])
+	if err != nil {
+		t.Fatalf("FetchQsoByIdContext failed: %v", err)
+	}
+	if qso.Call != "JA1XX" || qso.Name != "Taro" || qso.StationCallsign != "W1AW" || qso.TimeOff != qso.TimeOn {
+		t.Errorf("unexpected imported QSO %+v", qso)
+	}
+}
+
+func TestImportQsosHandler_DryRun(t *testing.T) {
+	svc := newTestServer(t)
+	app := fiber.New()
+	app.Post("/qsos/import", func(c *fiber.Ctx) error {
+		c.Locals(localsRequestDataKey, &requestContext{Logbook: &types.Logbook{ID: 1, Callsign: "W1AW"}, IsValid: true})
+		return svc.importQsosHandler(c)
+	})
+
+	resp, err := app.Test(httptest.NewRequest(fiber.MethodPost, "/qsos/import?dry_run=true", strings.NewReader(testImportAdif)))
+	if err != nil {
+		t.Fatalf("request failed: %v", err)
+	}
+	if resp.StatusCode != fiber.StatusOK {
+		t.Fatalf("expected 200, got %d", resp.StatusCode)
+	}
+	var result importResult
+	if err = json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		t.Fatalf("decode failed: %v", err)
+	}
+	if !result.DryRun || result.Imported != 2 || result.Rejected != 2 || len(result.Qsos) != 2 || result.Qsos[1].StationCallsign != "W1AW" {
+		t.Fatalf("unexpected result %+v", result)
+	}
+	if len(result.Warnings) != 1 || result.Warnings[0].Record != 2 || result.Warnings[0].Field != "station_callsign" {
+		t.Errorf("expected a warning about the station callsign of record 2, got %+v", result.Warnings)
+	}
+
+	rows, err := svc.db.QueryContext(context.Background(), `SELECT COUNT(*) FROM qso`)
+	if err != nil {
+		t.Fatalf("query failed: %v", err)
+	}
+	var n int
+	rows.Next()
+	_ = rows.Scan(&n)
+	_ = rows.Close()
+	if n != 0 {
+		t.Errorf("expected the dry run to import nothing, found %d QSOs", n)
+	}
+
+	resp, err = app.Test(httptest.NewRequest(fiber.MethodPost, "/qsos/import?dry_run=true&async=true", strings.NewReader(testImportAdif)))
+	if err != nil || resp.StatusCode != fiber.StatusBadRequest {
+		t.Errorf("expected a dry run to refuse async, got %v (err=%v)", resp.StatusCode, err)
+	}
+}
+
+func TestImportQsosHandler_RejectsEmptyDocument(t *testing.T) {
+	svc := newTestServer(t)
+	app := fiber.New()
+	app.Post("/qsos/import", withLogbook(1, svc.importQsosHandler))
+
+	resp, err := app.Test(httptest.NewRequest(fiber.MethodPost, "/qsos/import", strings.NewReader("not ADIF")))
+	if err != nil {
+		t.Fatalf("request failed: %v", err)
+	}
+	if resp.StatusCode != fiber.StatusBadRequest {
+		t.Errorf("expected 400, got %d", resp.StatusCode)
+	}
+}
+
+func TestImportQsosHandler_Async(t *testing.T) {
+	svc := newTestServer(t)
+	app := fiber.New()
+	app.Post("/qsos/import", func(c *fiber.Ctx) error {
+		c.Locals(localsRequestDataKey, &requestContext{Logbook: &types.Logbook{ID: 1, Callsign: "W1AW"}, IsValid: true, Actor: "key:test"})
+		return svc.importQsosHandler(c)
+	})
+	if _, err := svc.db.ExecContext(context.Background(), `UPDATE logbook SET callsign = 'W1AW' WHERE id = 1`); err != nil {
+		t.Fatalf("setup failed: %v", err)
+	}
+
+	resp, err := app.Test(httptest.NewRequest(fiber.MethodPost, "/qsos/import?async=true", strings.NewReader(testImportAdif)))
+	if err != nil || resp.StatusCode != fiber.StatusAccepted {
+		t.Fatalf("expected 202, got %v %v", resp, err)
+	}
+	var body struct {
+		Job job `json:"job"`
+	}
+	if err = json.NewDecoder(resp.Body).Decode(&body); err != nil || body.Job.Kind != jobKindImport {
+		t.Fatalf("expected an import job, got %+v %v", body, err)
+	}
+
+	sub := svc.qsoEvents.Subscribe(1)
+	defer svc.qsoEvents.Unsubscribe(sub)
+	runDueJobs(t, svc)
+	done, _, _ := svc.fetchJob(context.Background(), body.Job.ID, 1)
+	var result importResult
+	if err = json.Unmarshal(done.Result, &result); err != nil || done.Status != jobSucceeded || result.Imported != 2 || result.Rejected != 2 {
+		t.Errorf("expected the job to import 2 QSOs, got %+v %+v", done, result)
+	}
+	var progress importProgress
+	if err = json.Unmarshal(done.Progress, &progress); err != nil || progress.Received != 4 || progress.Processed != 4 || progress.Rejected != 2 || progress.ETA == nil {
+		t.Errorf("expected the job's final progress, got %s %v", done.Progress, err)
+	}
+
+	// The feed carries the job's progress and then its outcome.
+	var seen []qsoEventType
+	for len(sub.Events()) > 0 {
+		event := <-sub.Events()
+		if event.Job == nil || event.Job.ID != body.Job.ID {
+			t.Fatalf("expected events of the import job, got %+v", event)
+		}
+		seen = append(seen, event.Type)
+	}
+	if len(seen) < 2 || seen[0] != jobEventProgress || seen[len(seen)-1] != jobEventFinished {
+		t.Errorf("expected progress and then the outcome, got %v", seen)
+	}
+}
+
+func TestImportQsosHandler_LargeDocumentsRunAsJobs(t *testing.T) {
+	svc := newTestServer(t)
+	svc.importAsyncRecords = 3
+	app := fiber.New()
+	app.Post("/qsos/import", func(c *fiber.Ctx) error {
+		c.Locals(localsRequestDataKey, &requestContext{Logbook: &types.Logbook{ID: 1, Callsign: "W1AW"}, IsValid: true, Actor: "key:test"})
+		return svc.importQsosHandler(c)
+	})
+	if _, err := svc.db.ExecContext(context.Background(), `UPDATE logbook SET callsign = 'W1AW' WHERE id = 1`); err != nil {
+		t.Fatalf("setup failed: %v", err)
+	}
+
+	// The document's four records are more than the threshold.
+	resp, err := app.Test(httptest.NewRequest(fiber.MethodPost, "/qsos/import", strings.NewReader(testImportAdif)))
+	if err != nil || resp.StatusCode != fiber.StatusAccepted || !strings.HasPrefix(resp.Header.Get(fiber.HeaderLocation), "/jobs/") {
+		t.Fatalf("expected 202 with the job's location, got %v %v", resp, err)
+	}
+	if resp, err = app.Test(httptest.NewRequest(fiber.MethodPost, "/qsos/import?async=false", strings.NewReader(testImportAdif))); err != nil || resp.StatusCode != fiber.StatusOK {
+		t.Fatalf("expected ?async=false to import in the request, got %v %v", resp, err)
+	}
+}
+
+func TestImportProgressReporter(t *testing.T) {
+	var reports []importProgress
+	r := newImportProgressReporter(10, func(p importProgress) { reports = append(reports, p) })
+	r.started = time.Now().Add(-2 * time.Second)
+
+	r.update(4, 1)
+	r.update(6, 1) // within the interval of the last report
+	r.update(10, 1)
+	if len(reports) != 2 {
+		t.Fatalf("expected the second update to be skipped, got %+v", reports)
+	}
+	if reports[0].ETA == nil || *reports[0].ETA != 3 {
+		t.Errorf("expected 3s left at 4 of 10 after 2s, got %+v", reports[0])
+	}
+	if reports[1].Processed != 10 || reports[1].ETA == nil || *reports[1].ETA != 0 {
+		t.Errorf("expected a final report, got %+v", reports[1])
+	}
+
+	var none *importProgressReporter
+	none.update(1, 0)
+}
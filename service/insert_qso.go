@@ -1,11 +1,28 @@
 package service
 
 import (
+	"context"
+	"database/sql"
+	stderr "errors"
+	"time"
+
+	"github.com/Station-Manager/adapters"
+	pgmodels "github.com/Station-Manager/database/postgres/models"
+	sqmodels "github.com/Station-Manager/database/sqlite/models"
 	"github.com/Station-Manager/errors"
+	"github.com/Station-Manager/types"
+	"github.com/aarondl/sqlboiler/v4/boil"
+	"github.com/go-playground/validator/v10"
 	"github.com/gofiber/fiber/v2"
 )
 
+// errQsoCallsignMismatch is returned by insertQso when the QSO's station_callsign is not the
+// logbook's callsign.
+var errQsoCallsignMismatch = stderr.New("QSO callsign does not match the Logbook's callsign")
+
 // insertQsoHandler processes a request to insert a QSO into the database and performs necessary validation and error handling.
+// With dry_run set in the envelope or the query, the QSO is checked and prepared but not stored,
+// and the response is the QSO as it would have been stored, with warnings; see dry_run.go.
 func (s *Service) insertQsoHandler(c *fiber.Ctx) error {
 	const op errors.Op = "server.Service.insertQsoHandler"
 
@@ -27,33 +44,204 @@ func (s *Service) insertQsoHandler(c *fiber.Ctx) error {
 		return c.Status(fiber.StatusBadRequest).JSON(jsonBadRequest)
 	}
 
+	// A dry run is answered with its outcome, so it is never queued.
+	dryRun := reqCtx.Request.DryRun || c.QueryBool("dry_run")
+	if s.insertQueue != nil && !dryRun {
+		return s.queueInsertQso(c, reqCtx)
+	}
+
 	// Work on a copy so we do not mutate the original request struct.
-	qso := *reqCtx.Request.Qso
-	logbook := *reqCtx.Logbook
+	sent := *reqCtx.Request.Qso
+	qso, err := s.insertQso(c.UserContext(), *reqCtx.Logbook, reqCtx.Member, reqCtx.Actor, sent, c.Query("profile"), emptyString, dryRun)
+	if err != nil {
+		status, body := s.insertQsoFailure(err)
+		return c.Status(status).JSON(body)
+	}
+
+	if dryRun {
+		warnings, err := s.qsoWarnings(sent, qso)
+		if err != nil {
+			s.logger.ErrorWith().Err(errors.New(op).Err(err)).Msg("s.qsoWarnings failed")
+			return c.Status(fiber.StatusInternalServerError).JSON(jsonInternalError)
+		}
+		return c.JSON(fiber.Map{"dry_run": true, "qso": qso, "warnings": warnings})
+	}
+	return c.Status(fiber.StatusCreated).JSON(fiber.Map{"message": "QSO Created"})
+}
+
+// insertQsoFailure returns the status and body of the response to an insert that failed with err.
+func (s *Service) insertQsoFailure(err error) (int, fiber.Map) {
+	const op errors.Op = "server.Service.insertQsoFailure"
+
+	var invalid validator.ValidationErrors
+	var badTime *qsoTimeError
+	var badCall *callsignError
+	switch {
+	case stderr.Is(err, errQsoCallsignMismatch):
+		return fiber.StatusBadRequest, jsonError(errCodeCallsignMismatch, "QSO callsign does not match the Logbook's callsign")
+	case stderr.Is(err, errContestDupe):
+		return fiber.StatusConflict, jsonError(errCodeContestDupe, "The QSO is a dupe in the running contest")
+	case stderr.Is(err, errStationProfileNotFound):
+		return fiber.StatusBadRequest, jsonError(errCodeStationProfileNotFound, "Station profile not found")
+	case stderr.As(err, &badTime):
+		body := jsonError(errCodeInvalidQsoTime, "The QSO's "+badTime.Error())
+		body["field"] = badTime.Field
+		return fiber.StatusBadRequest, body
+	case stderr.As(err, &badCall):
+		return fiber.StatusBadRequest, invalidCallsign(badCall.Field)
+	case stderr.As(err, &invalid):
+		// TODO: structured error codes for fields?
+		s.logger.ErrorWith().Err(errors.New(op).Err(err)).Msg("Validation failed")
+		return fiber.StatusBadRequest, jsonBadRequest
+	}
+	if isUniqueViolation(err) {
+		return fiber.StatusConflict, jsonError(errCodeDuplicateQso, "The logbook already holds this QSO")
+	}
+	if body, exceeded := quotaExceededBody(err); exceeded {
+		return fiber.StatusForbidden, body
+	}
+	if status, body, ok := dbErrorResponse(err); ok {
+		return status, body
+	}
+	err = errors.New(op).Err(err)
+	s.logger.ErrorWith().Err(err).Msg("InsertQso failed")
+	return fiber.StatusInternalServerError, jsonInternalError
+}
+
+// insertQso checks the QSO against the logbook, fills in its station profile and the logbook's
+// defaults, converts its times to UTC, and stores it. The profile is the one named, if any; see
+// fetchQsoDefaults. The insertion is recorded in the QSO's history and, through the outbox,
+// published to the logbook's event stream; all three are written in one transaction, together with
+// the QSO's entry in the logbook's running contest, if any. QSOs logged with a member's API key are
+// attributed to that member. A QSO is given the UUID its client chose, if any, before anyone learns
+// of it. A dry run rolls the transaction back and returns the QSO without an ID.
+func (s *Service) insertQso(ctx context.Context, logbook types.Logbook, member *apiKeyMember, actor string, qso types.Qso, profile, publicID string, dryRun bool) (types.Qso, error) {
+	const op errors.Op = "server.Service.insertQso"
 
+	if err := checkQsoCallsigns(qso); err != nil {
+		return qso, err
+	}
 	// The `station_callsign` must be set and must match the logbook's callsign.
 	if qso.StationCallsign != logbook.Callsign {
-		err = errors.New(op).Msg("QSO callsign does not match the Logbook's callsign")
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"message": "QSO callsign does not match the Logbook's callsign"})
+		return qso, errQsoCallsignMismatch
 	}
 	qso.LogbookID = logbook.ID
+	normalizeQsoMode(&qso)
+
+	var operator string
+	if member != nil {
+		operator = member.Callsign
+	}
+	defaults, err := s.fetchQsoDefaults(ctx, logbook.ID, profile, actor, operator)
+	if err != nil {
+		if stderr.Is(err, errStationProfileNotFound) {
+			return qso, err
+		}
+		return qso, errors.New(op).Err(err)
+	}
+	if err = defaults.applyTo(&qso, time.Now()); err != nil {
+		return qso, err
+	}
 
-	// TODO: structured error codes for fields?
 	if err = s.validate.Struct(qso); err != nil {
-		err = errors.New(op).Err(err)
-		s.logger.ErrorWith().Err(err).Msg("Validation failed")
-		return c.Status(fiber.StatusBadRequest).JSON(jsonBadRequest)
+		return qso, err
 	}
 
-	if qso, err = s.db.InsertQsoContext(c.UserContext(), qso); err != nil {
-		msg, is := postgresError(err)
-		if is {
-			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"message": msg})
+	s.resolveQsoEntity(&qso)
+
+	running, inContest, err := s.fetchContest(ctx, logbook.ID)
+	if err != nil {
+		return qso, errors.New(op).Err(err)
+	}
+
+	var outboxID int64
+	err = s.withTx(ctx, func(tx *sql.Tx) error {
+		txErr := s.checkLogbookQuota(ctx, tx, logbook.ID, quotaQsos, 1)
+		if txErr != nil {
+			return txErr
 		}
-		err = errors.New(op).Err(err)
-		s.logger.ErrorWith().Err(err).Msg("InsertQso failed")
-		return c.Status(fiber.StatusInternalServerError).JSON(jsonInternalError)
+		if inContest {
+			if inContest, txErr = s.prepareContestQso(ctx, tx, running, &qso); txErr != nil {
+				return txErr
+			}
+		}
+		if qso, txErr = s.insertQsoTx(ctx, tx, qso); txErr != nil {
+			return txErr
+		}
+		if inContest {
+			if txErr = s.recordContestQso(ctx, tx, running, logbook.Callsign, qso); txErr != nil {
+				return txErr
+			}
+		}
+		// A UUID taken by a concurrent push of the same QSO rolls this copy of it back.
+		if publicID != emptyString {
+			if _, txErr = tx.ExecContext(ctx, `INSERT INTO qso_uuids (qso_id, logbook_id, uuid) VALUES ($1, $2, $3)`, qso.ID, qso.LogbookID, publicID); txErr != nil {
+				return errors.New(op).Err(txErr)
+			}
+		}
+		changes, txErr := qsoChanges(types.Qso{}, qso)
+		if txErr != nil {
+			return errors.New(op).Err(txErr)
+		}
+		if txErr = recordQsoHistory(ctx, tx, qsoHistoryEntry{QsoID: qso.ID, LogbookID: qso.LogbookID, Action: qsoHistoryInsert, Actor: actor, Changes: changes}); txErr != nil {
+			return errors.New(op).Err(txErr)
+		}
+		if outboxID, txErr = writeOutboxEvent(ctx, tx, qsoEventInserted, qso); txErr != nil {
+			return txErr
+		}
+		if dryRun {
+			return errDryRun
+		}
+		return nil
+	})
+	if dryRun && stderr.Is(err, errDryRun) {
+		qso.ID = 0
+		return qso, nil
+	}
+	if err != nil {
+		return qso, err
 	}
 
-	return c.Status(fiber.StatusCreated).JSON(fiber.Map{"message": "QSO Created"})
+	s.dispatchOutboxEvent(ctx, outboxID, qsoEventInserted, qso)
+	s.recordInserts(logbook.ID, actor, 1)
+	if inContest {
+		s.announceContestScore(ctx, logbook.ID, running)
+	}
+
+	return qso, nil
+}
+
+// insertQsoTx inserts the QSO within tx as the database module would, and returns it with its ID.
+func (s *Service) insertQsoTx(ctx context.Context, tx *sql.Tx, qso types.Qso) (types.Qso, error) {
+	const op errors.Op = "server.Service.insertQsoTx"
+
+	adapter := s.qsoModelAdapter()
+	if s.isPostgres() {
+		m, err := adapters.AdaptTo[pgmodels.Qso](adapter, &qso)
+		if err != nil {
+			return qso, errors.New(op).Err(err)
+		}
+		if err = m.Insert(ctx, tx, boil.Infer()); err != nil {
+			return qso, errors.New(op).Err(err)
+		}
+		qso.ID = m.ID
+		return qso, nil
+	}
+
+	// The SQLite schema requires every QSO to belong to a session.
+	if qso.SessionID < 1 {
+		return qso, errors.New(op).Msg("SessionID is required")
+	}
+	m, err := adapters.AdaptTo[sqmodels.Qso](adapter, &qso)
+	if err != nil {
+		return qso, errors.New(op).Err(err)
+	}
+	if len(m.AdditionalData) == 0 {
+		m.AdditionalData = []byte("{}")
+	}
+	if err = m.Insert(ctx, tx, boil.Infer()); err != nil {
+		return qso, errors.New(op).Err(err)
+	}
+	qso.ID = m.ID
+	return qso, nil
 }
@@ -1,6 +1,7 @@
 package service
 
 import (
+	"context"
 	"net/http/httptest"
 	"strings"
 	"testing"
@@ -10,6 +11,7 @@ import (
 	"github.com/Station-Manager/logging"
 	"github.com/Station-Manager/types"
 	"github.com/go-playground/validator/v10"
+	"github.com/goccy/go-json"
 	"github.com/gofiber/fiber/v2"
 )
 
@@ -39,6 +41,8 @@ func newTestServerForInsertQSO(t *testing.T) *Service {
 	if err := cfgSvc.Initialize(); err != nil {
 		t.Fatalf("config initialize failed: %v", err)
 	}
+	// Initialize loads (or generates) config.json from disk; re-apply the test datastore config.
+	cfgSvc.AppConfig.DatastoreConfig = *cfg
 	logSvc := &logging.Service{ConfigService: cfgSvc, WorkingDir: t.TempDir()}
 	if err := logSvc.Initialize(); err != nil {
 		t.Fatalf("logger initialize failed: %v", err)
@@ -61,10 +65,8 @@ func newTestServerForInsertQSO(t *testing.T) *Service {
 		validate: validator.New(),
 	}
 
-	// For insert QSO, we need middleware + dispatcher wired.
-	svc.app.Use(svc.basicChecks())
-	apiRoutes := svc.app.Group("/api/v1")
-	apiRoutes.Post("/", svc.postDispatcherHandler())
+	// For insert QSO, we need the middleware chain and routes wired.
+	svc.initializeRoutes()
 
 	return svc
 }
@@ -86,7 +88,7 @@ func TestInsertQsoAction_TypedPayload(t *testing.T) {
   "action": "insert_qso",
   "qso": {
     "call": "7Q7EB",
-    "freq": 14320000,
+    "freq": "14.320",
     "qso_date": "20251115",
     "time_on": "1200",
     "time_off": "1205",
@@ -98,7 +100,7 @@ func TestInsertQsoAction_TypedPayload(t *testing.T) {
   }
 }`
 
-	req := httptest.NewRequest("POST", "/api/v1", strings.NewReader(body))
+	req := httptest.NewRequest("POST", "/api/qso/insert", strings.NewReader(body))
 	req.Header.Set("Content-Type", "application/json")
 
 	resp, err := svc.app.Test(req)
@@ -110,3 +112,61 @@ func TestInsertQsoAction_TypedPayload(t *testing.T) {
 		t.Fatalf("expected status 401 or 500, got %d", resp.StatusCode)
 	}
 }
+
+func TestInsertQso_DryRun(t *testing.T) {
+	svc := newTestServer(t)
+	ctx := context.Background()
+	for _, query := range []string{
+		`INSERT INTO users (id, callsign, pass_hash) VALUES (8, 'K1AB', 'hash')`,
+		`UPDATE logbook SET user_id = 8, callsign = 'K1AB' WHERE id = 1`,
+		`INSERT INTO session (id) VALUES (1)`,
+	} {
+		if _, err := svc.db.ExecContext(ctx, query); err != nil {
+			t.Fatalf("setup failed: %v", err)
+		}
+	}
+	key, err := svc.issueMemberApiKey(ctx, 1, 8, "K1AB")
+	if err != nil {
+		t.Fatalf("issueMemberApiKey failed: %v", err)
+	}
+
+	body := `{"call":"JA1XX","band":"20m","mode":"phone","freq":"14.250","qso_date":"20240101","time_on":"1300","time_off":"1301","rst_sent":"59","rst_rcvd":"59","station_callsign":"K1AB","session_id":1}`
+	req := httptest.NewRequest(fiber.MethodPost, "/api/v2/logbooks/1/qsos?dry_run=true", strings.NewReader(body))
+	req.Header.Set(fiber.HeaderAuthorization, "Bearer "+key)
+	req.Header.Set(fiber.HeaderContentType, fiber.MIMEApplicationJSON)
+	resp, err := svc.app.Test(req, -1)
+	if err != nil {
+		t.Fatalf("request failed: %v", err)
+	}
+	if resp.StatusCode != fiber.StatusOK {
+		t.Fatalf("expected 200, got %d", resp.StatusCode)
+	}
+	var out struct {
+		DryRun   bool         `json:"dry_run"`
+		Qso      types.Qso    `json:"qso"`
+		Warnings []qsoWarning `json:"warnings"`
+	}
+	if err = json.NewDecoder(resp.Body).Decode(&out); err != nil {
+		t.Fatalf("decode failed: %v", err)
+	}
+	if !out.DryRun || out.Qso.ID != 0 || out.Qso.Mode != "SSB" || out.Qso.LogbookID != 1 {
+		t.Errorf("expected the QSO as it would be stored, got %+v", out)
+	}
+	if len(out.Warnings) != 1 || out.Warnings[0].Field != "mode" || out.Warnings[0].Message != "phone is stored as SSB" {
+		t.Errorf("expected a warning about the mode, got %+v", out.Warnings)
+	}
+
+	for _, query := range []string{`SELECT COUNT(*) FROM qso`, `SELECT COUNT(*) FROM qso_history`} {
+		rows, err := svc.db.QueryContext(ctx, query)
+		if err != nil {
+			t.Fatalf("%s failed: %v", query, err)
+		}
+		var n int
+		rows.Next()
+		_ = rows.Scan(&n)
+		_ = rows.Close()
+		if n != 0 {
+			t.Errorf("expected the dry run to store nothing, %s gave %d", query, n)
+		}
+	}
+}
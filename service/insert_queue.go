@@ -0,0 +1,245 @@
+package service
+
+import (
+	"context"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Station-Manager/errors"
+	"github.com/Station-Manager/types"
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+)
+
+// envInsertQueue names the environment variable holding the size of the queue of QSO inserts
+// (e.g. "1000"). With a queue, /api/qso/insert answers 202 with a status URL as soon as the QSO is
+// queued, and 429 while the queue is full. Without one, the default, it answers once the QSO is stored.
+const envInsertQueue = "SM_INSERT_QUEUE"
+
+// envInsertWorkers names the environment variable holding how many queued inserts are stored at
+// once (e.g. "4").
+const envInsertWorkers = "SM_INSERT_WORKERS"
+
+const (
+	defaultInsertWorkers = 4
+	// insertStatusRetention is how long the status of a finished insert can be fetched.
+	insertStatusRetention = 10 * time.Minute
+	// insertQueueRetryAfter is the Retry-After, in seconds, sent while the queue is full.
+	insertQueueRetryAfter = 1
+)
+
+// queuedInsertStatus is the progress of a queued insert.
+type queuedInsertStatus string
+
+const (
+	queuedInsertPending queuedInsertStatus = "queued"
+	queuedInsertCreated queuedInsertStatus = "created"
+	queuedInsertFailed  queuedInsertStatus = "failed"
+)
+
+// queuedInsert is a QSO insert in the insert queue.
+type queuedInsert struct {
+	// ID identifies the insert in its status URL, and is the UUID the QSO is given.
+	ID     string             `json:"id"`
+	Status queuedInsertStatus `json:"status"`
+	QsoID  int64              `json:"qso_id,omitempty"`
+	// Error is the body of the error response the insert would have had if made directly.
+	Error fiber.Map `json:"error,omitempty"`
+
+	logbook    types.Logbook
+	member     *apiKeyMember
+	actor      string
+	qso        types.Qso
+	profile    string
+	finishedAt time.Time
+}
+
+// insertQueue holds QSO inserts for background workers, so that a burst of inserts is answered
+// without waiting on the database. The status of each insert is kept until a while after it finishes.
+type insertQueue struct {
+	jobs    chan *queuedInsert
+	workers int
+
+	mu      sync.Mutex
+	inserts map[string]*queuedInsert
+	// finished holds the IDs of finished inserts, oldest first.
+	finished []string
+}
+
+func newInsertQueue(size, workers int) *insertQueue {
+	return &insertQueue{
+		jobs:    make(chan *queuedInsert, size),
+		workers: workers,
+		inserts: make(map[string]*queuedInsert),
+	}
+}
+
+// loadInsertQueue reads the insert queue's settings from the environment; the queue is nil unless
+// a size is set.
+func loadInsertQueue() (*insertQueue, error) {
+	const op errors.Op = "server.loadInsertQueue"
+
+	value := strings.TrimSpace(os.Getenv(envInsertQueue))
+	if value == emptyString {
+		return nil, nil
+	}
+	size, err := strconv.Atoi(value)
+	if err != nil || size < 0 {
+		return nil, errors.New(op).Msg(envInsertQueue + " must be a non-negative number")
+	}
+	if size == 0 {
+		return nil, nil
+	}
+
+	workers := defaultInsertWorkers
+	if value = strings.TrimSpace(os.Getenv(envInsertWorkers)); value != emptyString {
+		if workers, err = strconv.Atoi(value); err != nil || workers <= 0 {
+			return nil, errors.New(op).Msg(envInsertWorkers + " must be a positive number")
+		}
+	}
+	return newInsertQueue(size, workers), nil
+}
+
+// enqueue adds the insert to the queue, or reports false if the queue is full.
+func (q *insertQueue) enqueue(insert *queuedInsert) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	select {
+	case q.jobs <- insert:
+	default:
+		return false
+	}
+	q.inserts[insert.ID] = insert
+	return true
+}
+
+// finish records the outcome of an insert: the stored QSO, or the error response it failed with.
+func (q *insertQueue) finish(insert *queuedInsert, qsoID int64, failure fiber.Map) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	insert.finishedAt = time.Now()
+	if failure != nil {
+		insert.Status, insert.Error = queuedInsertFailed, failure
+	} else {
+		insert.Status, insert.QsoID = queuedInsertCreated, qsoID
+	}
+	q.finished = append(q.finished, insert.ID)
+	q.prune(insert.finishedAt)
+}
+
+// status returns a copy of the insert with the given ID, if it was queued for the logbook and is
+// not yet forgotten.
+func (q *insertQueue) status(id string, logbookID int64) (queuedInsert, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.prune(time.Now())
+	insert, ok := q.inserts[id]
+	if !ok || insert.logbook.ID != logbookID {
+		return queuedInsert{}, false
+	}
+	return *insert, true
+}
+
+// prune forgets the inserts that finished longer ago than insertStatusRetention. The caller holds q.mu.
+func (q *insertQueue) prune(now time.Time) {
+	for len(q.finished) > 0 {
+		oldest := q.inserts[q.finished[0]]
+		if oldest != nil && now.Sub(oldest.finishedAt) < insertStatusRetention {
+			return
+		}
+		delete(q.inserts, q.finished[0])
+		q.finished = q.finished[1:]
+	}
+}
+
+// queueInsertQso queues the request's QSO for insertion and answers 202 with the insert's status
+// URL, or 429 if the queue is full. A QSO for another station is rejected at once.
+func (s *Service) queueInsertQso(c *fiber.Ctx, reqCtx *requestContext) error {
+	if reqCtx.Request.Qso.StationCallsign != reqCtx.Logbook.Callsign {
+		status, body := s.insertQsoFailure(errQsoCallsignMismatch)
+		return c.Status(status).JSON(body)
+	}
+
+	insert := &queuedInsert{
+		ID:      uuid.NewString(),
+		Status:  queuedInsertPending,
+		logbook: *reqCtx.Logbook,
+		member:  reqCtx.Member,
+		actor:   reqCtx.Actor,
+		qso:     *reqCtx.Request.Qso,
+		profile: c.Query("profile"),
+	}
+	if !s.insertQueue.enqueue(insert) {
+		s.logger.InfoWith().Int64("logbook_id", reqCtx.Logbook.ID).Msg("Insert queue full; QSO refused")
+		c.Set(fiber.HeaderRetryAfter, strconv.Itoa(insertQueueRetryAfter))
+		return c.Status(fiber.StatusTooManyRequests).JSON(jsonError(errCodeInsertQueueFull, "The server is busy; retry the QSO shortly"))
+	}
+
+	statusURL := "/inserts/" + insert.ID
+	c.Location(statusURL)
+	return c.Status(fiber.StatusAccepted).JSON(fiber.Map{"message": "QSO Queued", "id": insert.ID, "status_url": statusURL})
+}
+
+// insertStatusHandler returns the status of a queued insert in the authenticated logbook.
+func (s *Service) insertStatusHandler(c *fiber.Ctx) error {
+	const op errors.Op = "server.Service.insertStatusHandler"
+
+	reqCtx, err := getRequestContext(c)
+	if err != nil {
+		err = errors.New(op).Err(err)
+		s.logger.ErrorWith().Err(err).Msg("getRequestContext failed")
+		return c.Status(fiber.StatusInternalServerError).JSON(jsonInternalError)
+	}
+
+	var insert queuedInsert
+	ok := false
+	if s.insertQueue != nil && reqCtx.Logbook != nil {
+		insert, ok = s.insertQueue.status(c.Params("id"), reqCtx.Logbook.ID)
+	}
+	if !ok {
+		return c.Status(fiber.StatusNotFound).JSON(jsonError(errCodeInsertNotFound, "Queued insert not found"))
+	}
+	return c.JSON(fiber.Map{"insert": insert})
+}
+
+// runInsertWorker stores queued QSOs until ctx is cancelled. The inserts still queued then were
+// already accepted, so they are stored before the worker returns, unless the drain deadline passes.
+func (s *Service) runInsertWorker(ctx context.Context) {
+	insertCtx := s.bgWorkCtx
+	if insertCtx == nil {
+		insertCtx = context.WithoutCancel(ctx)
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			for insertCtx.Err() == nil {
+				select {
+				case insert := <-s.insertQueue.jobs:
+					s.runQueuedInsert(insertCtx, insert)
+				default:
+					return
+				}
+			}
+			return
+		case insert := <-s.insertQueue.jobs:
+			s.runQueuedInsert(insertCtx, insert)
+		}
+	}
+}
+
+// runQueuedInsert stores a queued QSO and records the outcome in its status.
+func (s *Service) runQueuedInsert(ctx context.Context, insert *queuedInsert) {
+	qso, err := s.insertQso(ctx, insert.logbook, insert.member, insert.actor, insert.qso, insert.profile, insert.ID, false)
+	if err != nil {
+		_, body := s.insertQsoFailure(err)
+		s.insertQueue.finish(insert, 0, body)
+		return
+	}
+	s.insertQueue.finish(insert, qso.ID, nil)
+}
@@ -0,0 +1,112 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/goccy/go-json"
+	"github.com/gofiber/fiber/v2"
+)
+
+func TestLoadInsertQueue(t *testing.T) {
+	if queue, err := loadInsertQueue(); err != nil || queue != nil {
+		t.Fatalf("expected no queue by default, got %v %v", queue, err)
+	}
+	t.Setenv(envInsertQueue, "10")
+	t.Setenv(envInsertWorkers, "2")
+	queue, err := loadInsertQueue()
+	if err != nil || cap(queue.jobs) != 10 || queue.workers != 2 {
+		t.Fatalf("expected a queue of 10 with 2 workers, got %+v %v", queue, err)
+	}
+	t.Setenv(envInsertWorkers, "0")
+	if _, err = loadInsertQueue(); err == nil {
+		t.Error("expected zero workers to be rejected")
+	}
+}
+
+func TestInsertQso_Queued(t *testing.T) {
+	svc := newTestServer(t)
+	svc.insertQueue = newInsertQueue(1, 1)
+	ctx := context.Background()
+	for _, query := range []string{
+		`INSERT INTO users (id, callsign, pass_hash) VALUES (8, 'K1AB', 'hash')`,
+		`UPDATE logbook SET user_id = 8, callsign = 'K1AB' WHERE id = 1`,
+		`INSERT INTO session (id) VALUES (1)`,
+	} {
+		if _, err := svc.db.ExecContext(ctx, query); err != nil {
+			t.Fatalf("setup failed: %v", err)
+		}
+	}
+	key, err := svc.issueMemberApiKey(ctx, 1, 8, "K1AB")
+	if err != nil {
+		t.Fatalf("issueMemberApiKey failed: %v", err)
+	}
+
+	insert := func(call, mode string) (*http.Response, map[string]any) {
+		t.Helper()
+		data, _ := json.Marshal(map[string]any{"callsign": "K1AB", "key": key, "qso": map[string]any{
+			"call": call, "band": "20m", "mode": mode, "freq": "14.074", "qso_date": "20240501", "time_on": "1200",
+			"time_off": "1201", "rst_sent": "-10", "rst_rcvd": "-12", "station_callsign": "K1AB", "session_id": 1}})
+		req := httptest.NewRequest(fiber.MethodPost, "/api/qso/insert", bytes.NewReader(data))
+		req.Header.Set(fiber.HeaderContentType, fiber.MIMEApplicationJSON)
+		resp, err := svc.app.Test(req)
+		if err != nil {
+			t.Fatalf("insert failed: %v", err)
+		}
+		var body map[string]any
+		_ = json.NewDecoder(resp.Body).Decode(&body)
+		return resp, body
+	}
+	status := func(id string) (int, queuedInsert) {
+		t.Helper()
+		req := httptest.NewRequest(fiber.MethodGet, "/inserts/"+id, nil)
+		req.Header.Set(fiber.HeaderAuthorization, "Bearer "+key)
+		resp, err := svc.app.Test(req)
+		if err != nil {
+			t.Fatalf("status failed: %v", err)
+		}
+		var body struct {
+			Insert queuedInsert `json:"insert"`
+		}
+		_ = json.NewDecoder(resp.Body).Decode(&body)
+		return resp.StatusCode, body.Insert
+	}
+
+	// The first QSO is queued; the queue then holds one and refuses the next.
+	resp, body := insert("DL1ABC", "FT8")
+	if resp.StatusCode != fiber.StatusAccepted || body["status_url"] != "/inserts/"+body["id"].(string) {
+		t.Fatalf("expected 202 with a status URL, got %d %v", resp.StatusCode, body)
+	}
+	id := body["id"].(string)
+	if code, queued := status(id); code != fiber.StatusOK || queued.Status != queuedInsertPending {
+		t.Fatalf("expected the insert to be queued, got %d %+v", code, queued)
+	}
+	if resp, body = insert("JA1XX", "FT8"); resp.StatusCode != fiber.StatusTooManyRequests || resp.Header.Get(fiber.HeaderRetryAfter) == emptyString || body["code"] != string(errCodeInsertQueueFull) {
+		t.Fatalf("expected 429 with Retry-After, got %d %v", resp.StatusCode, body)
+	}
+
+	svc.runQueuedInsert(ctx, <-svc.insertQueue.jobs)
+	code, done := status(id)
+	if code != fiber.StatusOK || done.Status != queuedInsertCreated || done.QsoID == 0 {
+		t.Fatalf("expected the QSO to be created, got %d %+v", code, done)
+	}
+	if uuids, _ := svc.qsoUUIDs(ctx, 1, []int64{done.QsoID}); uuids[done.QsoID] != id {
+		t.Errorf("expected the QSO to take the insert's ID as its UUID, got %q", uuids[done.QsoID])
+	}
+
+	// An insert that fails keeps the error response it would have had.
+	if resp, body = insert("JA1XX", emptyString); resp.StatusCode != fiber.StatusAccepted {
+		t.Fatalf("expected 202, got %d %v", resp.StatusCode, body)
+	}
+	svc.runQueuedInsert(ctx, <-svc.insertQueue.jobs)
+	if code, failed := status(body["id"].(string)); code != fiber.StatusOK || failed.Status != queuedInsertFailed || failed.Error["code"] != string(errCodeBadRequest) {
+		t.Errorf("expected the insert to fail validation, got %d %+v", code, failed)
+	}
+
+	if code, _ = status("8d0f4a9e-0000-4000-8000-000000000000"); code != fiber.StatusNotFound {
+		t.Errorf("expected an unknown insert to get 404, got %d", code)
+	}
+}
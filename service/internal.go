@@ -1,6 +1,7 @@
 package service
 
 import (
+	"context"
 	"github.com/Station-Manager/config"
 	"github.com/Station-Manager/database"
 	"github.com/Station-Manager/errors"
@@ -13,6 +14,7 @@ import (
 	"github.com/goccy/go-json"
 	"github.com/gofiber/fiber/v2"
 	"github.com/gofiber/fiber/v2/middleware/cors"
+	"github.com/gofiber/fiber/v2/middleware/etag"
 	"github.com/gofiber/fiber/v2/middleware/filesystem"
 	"reflect"
 	"time"
@@ -21,20 +23,38 @@ import (
 // initializeContainer sets up the dependency injection container with required service registrations and configurations.
 // It registers key service instances and ensures the container is built successfully.
 // Returns an error if the initialization process encounters any issues.
-func (s *Service) initializeContainer() error {
+func (s *Service) initializeContainer(opts Options) error {
 	const op errors.Op = "server.Service.initializeContainer"
 
 	s.container = iocdi.New()
 
-	workingDir, err := utils.WorkingDir(".")
+	configDir := opts.ConfigDir
+	if configDir == emptyString {
+		configDir = "."
+	}
+	workingDir, err := utils.WorkingDir(configDir)
 	if err != nil {
 		return errors.New(op).Err(err)
 	}
 
+	// The configuration is loaded here rather than by the container so that the options can be
+	// applied before the services depending on it are initialized.
+	if err = prepareConfigFile(workingDir, opts.Driver); err != nil {
+		return errors.New(op).Err(err)
+	}
+	cfgSvc := &config.Service{WorkingDir: workingDir}
+	if err = cfgSvc.Initialize(); err != nil {
+		return errors.New(op).Err(err)
+	}
+	if err = applyOptions(&cfgSvc.AppConfig, opts); err != nil {
+		return errors.New(op).Err(err)
+	}
+	resolveSqlitePath(&cfgSvc.AppConfig.DatastoreConfig, workingDir)
+
 	if err = s.container.RegisterInstance("workingdir", workingDir); err != nil {
 		return errors.New(op).Err(err)
 	}
-	if err = s.container.Register(config.ServiceName, reflect.TypeOf((*config.Service)(nil))); err != nil {
+	if err = s.container.RegisterInstance(config.ServiceName, cfgSvc); err != nil {
 		return errors.New(op).Err(err)
 	}
 	if err = s.container.Register(logging.ServiceName, reflect.TypeOf((*logging.Service)(nil))); err != nil {
@@ -49,6 +69,37 @@ func (s *Service) initializeContainer() error {
 	return nil
 }
 
+// applyOptions overrides the loaded configuration with the non-zero options.
+func applyOptions(cfg *types.AppConfig, opts Options) error {
+	const op errors.Op = "server.applyOptions"
+
+	if opts.Port != 0 {
+		if opts.Port < 1 || opts.Port > 65535 {
+			return errors.New(op).Msgf("Invalid port %d", opts.Port)
+		}
+		if cfg.ServerConfig == nil {
+			return errors.New(op).Msg("The configuration has no server section")
+		}
+		cfg.ServerConfig.Port = opts.Port
+	}
+	if opts.Driver != emptyString {
+		driver, err := normalizeDriver(opts.Driver)
+		if err != nil {
+			return errors.New(op).Err(err)
+		}
+		useDriver(&cfg.DatastoreConfig, driver)
+	}
+	if opts.LogLevel != emptyString {
+		switch opts.LogLevel {
+		case "trace", "debug", "info", "warn", "error", "fatal", "panic":
+			cfg.LoggingConfig.Level = opts.LogLevel
+		default:
+			return errors.New(op).Msgf("Invalid log level %q", opts.LogLevel)
+		}
+	}
+	return nil
+}
+
 // initializeService sets up and initializes the service dependencies including database, logger, configuration, and validators.
 // It ensures that essential services are resolved and configured properly before the service becomes operational.
 // Returns an error if any component fails during initialization.
@@ -58,8 +109,20 @@ func (s *Service) initializeService() error {
 		return errors.New(op).Msg(errMsgNilService)
 	}
 
-	var err error
-	if s.db, err = s.resolveAndSetDatabaseService(); err != nil {
+	dbSvc, err := s.resolveAndSetDatabaseService()
+	if err != nil {
+		return errors.New(op).Err(err)
+	}
+	prepared, err := loadPreparedStatements()
+	if err != nil {
+		return errors.New(op).Err(err)
+	}
+	s.prepared = newPreparedStore(dbSvc, dbSvc.DatabaseConfig, prepared)
+	if s.prepared.conns, err = loadPreparedConns(); err != nil {
+		return errors.New(op).Err(err)
+	}
+	s.db, s.dbConfig, s.dbRetry = newRetryingStore(newLiveStore(newTimedStore(s.prepared)), defaultDBRetryPolicy), dbSvc.DatabaseConfig, defaultDBRetryPolicy
+	if s.poolWaitThreshold, err = loadPoolWaitThreshold(); err != nil {
 		return errors.New(op).Err(err)
 	}
 
@@ -73,8 +136,132 @@ func (s *Service) initializeService() error {
 
 	s.validate = validator.New(validator.WithRequiredStructEnabled())
 
-	// Initialize the in-memory logbook cache with default settings.
-	s.logbookCache = newInMemoryLogbookCache()
+	if s.requestTimeouts, err = loadRequestTimeouts(); err != nil {
+		return errors.New(op).Err(err)
+	}
+	if s.slowLog, err = loadSlowLog(); err != nil {
+		return errors.New(op).Err(err)
+	}
+	if s.strictJSON, err = loadStrictJSON(); err != nil {
+		return errors.New(op).Err(err)
+	}
+	if s.bodyLimits, err = loadBodyLimits(s.config.BodyLimit); err != nil {
+		return errors.New(op).Err(err)
+	}
+	if s.inherited, err = inheritedListeners(); err != nil {
+		return errors.New(op).Err(err)
+	}
+	if s.autocert, err = loadAutocertManager(s.config.TLSEnabled); err != nil {
+		return errors.New(op).Err(err)
+	}
+	if s.shutdownConfig, err = loadShutdownConfig(); err != nil {
+		return errors.New(op).Err(err)
+	}
+	if s.httpTuning, err = loadHTTPTuning(s.isPostgres()); err != nil {
+		return errors.New(op).Err(err)
+	}
+	if s.httpTuning.prefork && s.autocert != nil {
+		return errors.New(op).Msg(envPrefork + " cannot be used with " + envAcmeHosts)
+	}
+	if s.redirect, err = loadRedirectConfig(s.config.TLSEnabled || s.autocert != nil, s.config.Port); err != nil {
+		return errors.New(op).Err(err)
+	}
+	if s.grpcAddr, err = loadGrpcAddr(); err != nil {
+		return errors.New(op).Err(err)
+	}
+	s.adminToken = loadAdminToken()
+	if s.trashRetention, err = loadTrashRetention(); err != nil {
+		return errors.New(op).Err(err)
+	}
+	if s.auditRetention, err = loadAuditRetention(); err != nil {
+		return errors.New(op).Err(err)
+	}
+	if s.accountDeletionGrace, err = loadAccountDeletionGrace(); err != nil {
+		return errors.New(op).Err(err)
+	}
+	if s.apiKeyMaxIdle, err = loadApiKeyMaxIdle(); err != nil {
+		return errors.New(op).Err(err)
+	}
+	if s.backups, err = loadBackupManager(); err != nil {
+		return errors.New(op).Err(err)
+	}
+	if s.qslImages, err = loadQslImageStore(); err != nil {
+		return errors.New(op).Err(err)
+	}
+	if s.quotas, err = loadQuotas(); err != nil {
+		return errors.New(op).Err(err)
+	}
+	if s.importAsyncRecords, err = loadImportAsyncRecords(); err != nil {
+		return errors.New(op).Err(err)
+	}
+	if s.insertQueue, err = loadInsertQueue(); err != nil {
+		return errors.New(op).Err(err)
+	}
+	if s.jobs, err = loadJobRunner(); err != nil {
+		return errors.New(op).Err(err)
+	}
+	if s.deliveries, err = loadDeliveryPool(); err != nil {
+		return errors.New(op).Err(err)
+	}
+	if s.scheduler, err = loadScheduler(); err != nil {
+		return errors.New(op).Err(err)
+	}
+	if s.dxCluster, err = loadDxClusterClient(); err != nil {
+		return errors.New(op).Err(err)
+	}
+	if s.mailer, err = loadMailer(); err != nil {
+		return errors.New(op).Err(err)
+	}
+
+	if s.logbookCacheStale, err = loadLogbookCacheStale(); err != nil {
+		return errors.New(op).Err(err)
+	}
+	if s.cacheTTLJitter, err = loadCacheTTLJitter(); err != nil {
+		return errors.New(op).Err(err)
+	}
+	if s.cacheWarmup, err = loadCacheWarmup(); err != nil {
+		return errors.New(op).Err(err)
+	}
+
+	// Initialize the in-memory caches with default settings.
+	s.initializeCaches()
+
+	if err = s.initializeInvalidationBus(); err != nil {
+		return errors.New(op).Err(err)
+	}
+
+	s.cty = newCtyResolver()
+	s.solar = newSolarTracker()
+
+	s.qsoEvents = newQsoEventBroker()
+	s.webhooks = newWebhookDispatcher()
+	s.qsoEvents.OnPublish(s.enqueueWebhookEvent)
+	s.pskReporter = newPskReporterClient()
+	s.qsoEvents.OnPublish(s.enqueuePskReport)
+	s.awards = newAwardTracker()
+	s.usage = newUsageTracker()
+	s.qsoEvents.OnPublish(s.enqueueAwardUpdate)
+	s.registerJobKinds()
+
+	if s.credentialsKey, err = loadCredentialsKey(); err != nil {
+		return errors.New(op).Err(err)
+	}
+	s.deliveries.setInterval(deliveryDestinationEqsl, defaultEqslMinInterval)
+	s.deliveries.setInterval(deliveryDestinationLotw, defaultLotwMinInterval)
+	s.deliveries.setInterval(deliveryDestinationLookup, 0)
+	s.lotw = newLotwClient()
+	s.eqsl = newEqslClient()
+	s.lookup = newLookupClient()
+	s.lookup.cache.SetJitter(s.cacheTTLJitter)
+	s.clublog = newClubLogClient()
+	if len(s.credentialsKey) > 0 {
+		// Without a key no account can be configured, so there is nothing to upload or look up.
+		s.qsoEvents.OnPublish(s.enqueueEqslUpload)
+		s.qsoEvents.OnPublish(s.enqueueCallsignLookup)
+		if s.clublog.enabled() {
+			s.qsoEvents.OnPublish(s.enqueueClubLogUpload)
+		}
+	}
 
 	return nil
 }
@@ -87,20 +274,27 @@ func (s *Service) initializeGoFiber() error {
 		return errors.New(op).Msg(errMsgNilService)
 	}
 
-	s.app = fiber.New(fiber.Config{
+	config := fiber.Config{
 		AppName:      s.config.Name,
 		JSONDecoder:  json.Unmarshal,
 		JSONEncoder:  json.Marshal,
 		ReadTimeout:  time.Duration(s.config.ReadTimeout) * time.Second,
 		WriteTimeout: time.Duration(s.config.WriteTimeout) * time.Second,
 		IdleTimeout:  time.Duration(s.config.IdleTimeout) * time.Second,
-		BodyLimit:    s.config.BodyLimit,
-	})
+		// Bodies up to the largest route limit are buffered; larger ones reach the upload routes
+		// as streams, and bodyLimitMiddleware rejects them on every other route.
+		BodyLimit:         s.bodyLimits.buffered(),
+		StreamRequestBody: true,
+		ErrorHandler:      s.jsonErrorHandler,
+	}
+	s.httpTuning.apply(&config)
+	s.app = fiber.New(config)
+	s.requestsCtx, s.cancelRequests = context.WithCancel(context.Background())
 
 	s.app.Use(cors.New(cors.Config{
 		AllowOrigins: "*",
 		AllowHeaders: "*",
-		AllowMethods: "GET,POST",
+		AllowMethods: "GET,POST,PUT,DELETE",
 	}))
 
 	s.initializeRoutes()
@@ -110,6 +304,14 @@ func (s *Service) initializeGoFiber() error {
 
 // initializeRoutes configures API route groups and handlers for the service with associated middleware.
 func (s *Service) initializeRoutes() {
+	s.app.Use(s.shutdownMiddleware())
+	s.app.Use(s.slowRequestMiddleware())
+	s.app.Use(s.requestTimeoutMiddleware())
+	s.app.Use(s.usageMiddleware())
+	s.app.Use(s.bodyLimitMiddleware())
+	s.app.Use(s.msgpackMiddleware())
+	s.app.Use(s.maintenanceMiddleware())
+
 	s.app.Get("/", filesystem.New(filesystem.Config{
 		Root:         frontend.FileSystem(),
 		Index:        "index.html",
@@ -118,18 +320,252 @@ func (s *Service) initializeRoutes() {
 
 	// Health check endpoint - lightweight liveness/readiness probe
 	s.app.Get("/health", s.healthHandler)
-
-	// The base API group with common middleware applied to all routes.
-	api := s.app.Group("/api", s.requestContextMiddleware())
+	// Prometheus metrics: cache hit rates and occupancy, slow requests and queries
+	s.app.Get("/metrics", s.metricsHandler)
+	// Liveness only: answers while the server serves requests, whatever the state of its components
+	s.app.Get("/livez", s.livenessHandler)
+	// Readiness: answers 200 once the database is reachable and migrated, for container health checks
+	s.app.Get("/readyz", s.readinessHandler)
+
+	// The base API group with common middleware applied to all routes. The v1 groups read the
+	// JSON request envelope; v2 authenticates with headers instead.
+	api := s.app.Group("/api")
+	envelope := s.requestContextMiddleware()
+
+	// The first API's single route dispatched on an action named in the body; it is kept for old
+	// clients by sending each action on to its route.
+	api.Post("/v1", s.legacyDispatchHandler)
 
 	// The logbook routes require password authentication as a minimum because
 	// API keys are per-logbook and not shared across users.
-	logbookRoutes := api.Group("/logbook", s.passwordAuthNMiddleware())
+	logbookRoutes := api.Group("/logbook", envelope, s.passwordAuthNMiddleware())
 	logbookRoutes.Post("/register", s.registerLogbookHandler)
-
-	// The QSO routes require an API key authentication.
-	qsoRoutes := api.Group("/qso", s.apikeyAuthNMiddleware())
-	qsoRoutes.Post("/insert", s.insertQsoHandler)
+	logbookRoutes.Post("/update", s.updateLogbookHandler)
+	logbookRoutes.Post("/delete", s.deleteLogbookHandler)
+	logbookRoutes.Post("/restore", s.restoreLogbookHandler)
+	logbookRoutes.Post("/unarchive", s.unarchiveLogbookHandler)
+	logbookRoutes.Post("/trash", s.listTrashedLogbooksHandler)
+	logbookRoutes.Post("/memberships", s.listMembershipsHandler)
+
+	// Club logbooks are shared between users, each with a role on the logbook named in the request.
+	memberRoutes := logbookRoutes.Group("/members", s.userLogbookRoleMiddleware())
+	memberRoutes.Post("/", s.listLogbookMembersHandler)
+	memberRoutes.Post("/grant", s.requireLogbookRole(logbookRoleOwner), s.grantLogbookMemberHandler)
+	memberRoutes.Post("/revoke", s.requireLogbookRole(logbookRoleOwner), s.revokeLogbookMemberHandler)
+	memberRoutes.Post("/key", s.issueMemberApiKeyHandler)
+
+	// The account routes act on everything the user owns, so they also require the password.
+	accountRoutes := api.Group("/account", envelope, s.passwordAuthNMiddleware())
+	accountRoutes.Post("/export", s.exportAccountHandler)
+	accountRoutes.Get("/export", s.getAccountExportHandler)
+	accountRoutes.Post("/delete", s.deleteAccountHandler)
+	accountRoutes.Post("/delete/cancel", s.cancelAccountDeletionHandler)
+
+	// The admin API operates on the whole server. Admins are users enrolled through the /admin
+	// routes, and every request needs their password and a one-time code from their authenticator.
+	adminApiRoutes := api.Group("/admin", envelope, s.passwordAuthNMiddleware(), s.adminTwoFactorMiddleware(), s.auditAdminMiddleware(auditAdminAction))
+	adminApiRoutes.Post("/users", s.adminListUsersHandler)
+	adminApiRoutes.Post("/users/disable", s.adminDisableUserHandler)
+	adminApiRoutes.Post("/users/enable", s.adminEnableUserHandler)
+	adminApiRoutes.Post("/stats", s.adminStatsHandler)
+	adminApiRoutes.Post("/api-keys/revoke", s.adminRevokeApiKeyHandler)
+	adminApiRoutes.Post("/cache", s.adminCacheSummaryHandler)
+	adminApiRoutes.Post("/cache/flush", s.adminFlushCachesHandler)
+	adminApiRoutes.Post("/audit", s.adminAuditLogHandler)
+
+	// The QSO routes require an API key authentication. Every API key carries a role on its
+	// logbook: QSO writes need the operator role and the logbook's settings need the owner role.
+	qsoRoutes := api.Group("/qso", envelope, s.apikeyAuthNMiddleware())
+	qsoRoutes.Post("/insert", s.requireLogbookRole(logbookRoleOperator), s.writableLogbookMiddleware(), s.insertQsoHandler)
+
+	// Queued inserts are polled with the logbook's API key header, as the envelope is POST-only.
+	s.app.Get("/inserts/:id", s.apikeyHeaderAuthNMiddleware(), s.insertStatusHandler)
+
+	// The recent QSOs widget for websites that fetch it server-side, keeping the API key private.
+	s.app.Get("/widget", s.apikeyHeaderAuthNMiddleware(), etag.New(), s.qsoWidgetHandler(false))
+
+	// The v2 API has the same operations as resources. Logbooks are managed with the account's
+	// credentials, and their QSOs with the logbook's API key.
+	v2 := api.Group("/v2")
+	v2Logbooks := v2.Group("/logbooks")
+	basicAuth, passwordAuth := s.basicAuthContextMiddleware(), s.passwordAuthNMiddleware()
+	v2Logbooks.Get("/", basicAuth, passwordAuth, s.v2ListLogbooksHandler)
+	v2Logbooks.Post("/", basicAuth, passwordAuth, s.v2CreateLogbookHandler)
+	v2Logbooks.Delete("/:id", basicAuth, passwordAuth, s.tenantLogbookMiddleware(), s.v2DeleteLogbookHandler)
+	v2Logbooks.Get("/:id/qsos", s.apikeyHeaderAuthNMiddleware(), s.pathLogbookMiddleware(), s.v2ListQsosHandler)
+	v2Logbooks.Get("/:id/qsos/search", s.apikeyHeaderAuthNMiddleware(), s.pathLogbookMiddleware(), s.v2SearchQsosHandler)
+	v2Logbooks.Post("/:id/qsos", s.apikeyHeaderAuthNMiddleware(), s.pathLogbookMiddleware(),
+		s.requireLogbookRole(logbookRoleOperator), s.writableLogbookMiddleware(), s.v2InsertQsoHandler)
+	v2.Get("/account/preferences", basicAuth, passwordAuth, s.getPreferencesHandler)
+	v2.Put("/account/preferences", basicAuth, passwordAuth, s.putPreferencesHandler)
+	v2.Get("/account/quotas", basicAuth, passwordAuth, s.accountQuotasHandler)
+	v2Qsos := v2.Group("/qsos", s.apikeyHeaderAuthNMiddleware())
+	// Routes naming a QSO in the path check it against the key's logbook before their handler does.
+	tenantQso := s.tenantQsoMiddleware()
+	v2Qsos.Get("/:id", tenantQso, s.getQsoHandler)
+	v2Qsos.Delete("/:id", tenantQso, s.requireLogbookRole(logbookRoleOperator), s.writableLogbookMiddleware(), s.deleteQsoHandler)
+
+	// Event streams are long-lived GET requests, so they authenticate with an API key
+	// header (or query parameter) rather than the JSON request envelope.
+	streamRoutes := s.app.Group("/stream", s.apikeyHeaderAuthNMiddleware())
+	streamRoutes.Get("/qso/ws", requireWebSocketUpgrade, s.qsoWebSocketHandler())
+	streamRoutes.Get("/qso/sse", s.qsoSSEHandler)
+
+	// Webhooks belong to a logbook, so they are managed with that logbook's API key.
+	webhookRoutes := s.app.Group("/webhooks", s.apikeyHeaderAuthNMiddleware(), s.requireLogbookRole(logbookRoleOwner))
+	webhookRoutes.Get("/", s.listWebhooksHandler)
+	webhookRoutes.Post("/", s.createWebhookHandler)
+	webhookRoutes.Get("/dead-letters", s.listWebhookDeadLettersHandler)
+	webhookRoutes.Delete("/:id", s.deleteWebhookHandler)
+
+	// Default station fields belong to a logbook, so they are managed with that logbook's API key.
+	defaultsRoutes := s.app.Group("/defaults", s.apikeyHeaderAuthNMiddleware())
+	defaultsRoutes.Get("/", s.getLogbookDefaultsHandler)
+	defaultsRoutes.Put("/", s.requireLogbookRole(logbookRoleOwner), s.putLogbookDefaultsHandler)
+	defaultsRoutes.Delete("/", s.requireLogbookRole(logbookRoleOwner), s.deleteLogbookDefaultsHandler)
+
+	// Station profiles belong to a logbook; QSOs choose one with ?profile=<name> on insert.
+	profileRoutes := s.app.Group("/profiles", s.apikeyHeaderAuthNMiddleware())
+	profileRoutes.Get("/", s.listStationProfilesHandler)
+	profileRoutes.Post("/", s.requireLogbookRole(logbookRoleOwner), s.createStationProfileHandler)
+	profileRoutes.Get("/:id", s.getStationProfileHandler)
+	profileRoutes.Put("/:id", s.requireLogbookRole(logbookRoleOwner), s.putStationProfileHandler)
+	profileRoutes.Delete("/:id", s.requireLogbookRole(logbookRoleOwner), s.deleteStationProfileHandler)
+	profileRoutes.Put("/:id/keys/:prefix", s.requireLogbookRole(logbookRoleOwner), s.putStationProfileKeyHandler)
+	profileRoutes.Delete("/:id/keys/:prefix", s.requireLogbookRole(logbookRoleOwner), s.deleteStationProfileKeyHandler)
+
+	// Share links are managed with the logbook's API key; the shared views need only the token.
+	shareRoutes := s.app.Group("/shares", s.apikeyHeaderAuthNMiddleware(), s.requireLogbookRole(logbookRoleOwner))
+	shareRoutes.Get("/", s.listShareLinksHandler)
+	shareRoutes.Post("/", s.createShareLinkHandler)
+	shareRoutes.Delete("/:id", s.revokeShareLinkHandler)
+
+	sharedRoutes := s.app.Group("/share/:token", s.shareTokenMiddleware())
+	sharedRoutes.Get("/", s.sharePageHandler)
+	sharedRoutes.Get("/logbook", s.sharedLogbookHandler)
+	sharedRoutes.Get("/qsos", s.sharedQsosHandler)
+	// The widget is meant for iframes on the operator's own website, so the share link is what they embed.
+	sharedRoutes.Get("/widget", etag.New(), s.qsoWidgetHandler(true))
+	// A share link is enough for a scoreboard on a screen in the shack.
+	sharedRoutes.Get("/scoreboard", s.contestScoreboardHandler)
+	sharedRoutes.Get("/scoreboard/ws", requireWebSocketUpgrade, s.contestScoreboardWebSocketHandler())
+
+	qsoReadRoutes := s.app.Group("/qsos", s.apikeyHeaderAuthNMiddleware())
+	qsoReadRoutes.Get("/trash", s.listTrashedQsosHandler)
+	qsoReadRoutes.Post("/bulk/preview", s.previewBulkQsosHandler)
+	qsoReadRoutes.Post("/bulk/update", s.requireLogbookRole(logbookRoleOperator), s.writableLogbookMiddleware(), s.bulkUpdateQsosHandler)
+	qsoReadRoutes.Post("/bulk/delete", s.requireLogbookRole(logbookRoleOperator), s.writableLogbookMiddleware(), s.bulkDeleteQsosHandler)
+	qsoReadRoutes.Get("/:id", tenantQso, s.getQsoHandler)
+	qsoReadRoutes.Delete("/:id", tenantQso, s.requireLogbookRole(logbookRoleOperator), s.writableLogbookMiddleware(), s.deleteQsoHandler)
+	qsoReadRoutes.Post("/:id/restore", tenantQso, s.requireLogbookRole(logbookRoleOperator), s.writableLogbookMiddleware(), s.restoreQsoHandler)
+	qsoReadRoutes.Post("/:id/merge", tenantQso, s.requireLogbookRole(logbookRoleOperator), s.writableLogbookMiddleware(), s.mergeQsoHandler)
+	qsoReadRoutes.Get("/:id/history", tenantQso, s.qsoHistoryHandler)
+	qsoReadRoutes.Get("/:id/qsl-images", tenantQso, s.listQslImagesHandler)
+	qsoReadRoutes.Post("/:id/qsl-images", tenantQso, s.requireLogbookRole(logbookRoleOperator), s.writableLogbookMiddleware(), s.uploadQslImageHandler)
+	qsoReadRoutes.Get("/:id/qsl-images/:image", tenantQso, s.getQslImageHandler)
+	qsoReadRoutes.Get("/:id/qsl-images/:image/thumbnail", tenantQso, s.getQslImageHandler)
+	qsoReadRoutes.Delete("/:id/qsl-images/:image", tenantQso, s.requireLogbookRole(logbookRoleOperator), s.writableLogbookMiddleware(), s.deleteQslImageHandler)
+	qsoReadRoutes.Post("/import", s.requireLogbookRole(logbookRoleOperator), s.writableLogbookMiddleware(), s.importQsosHandler)
+
+	// Contest mode numbers, dupe-checks and scores the QSOs inserted while it is on.
+	contestRoutes := s.app.Group("/contest", s.apikeyHeaderAuthNMiddleware())
+	contestRoutes.Get("/", s.getContestHandler)
+	contestRoutes.Get("/scoreboard", s.contestScoreboardHandler)
+	contestRoutes.Get("/scoreboard/ws", requireWebSocketUpgrade, s.contestScoreboardWebSocketHandler())
+	contestRoutes.Put("/", s.requireLogbookRole(logbookRoleOperator), s.writableLogbookMiddleware(), s.startContestHandler)
+	contestRoutes.Delete("/", s.requireLogbookRole(logbookRoleOperator), s.writableLogbookMiddleware(), s.endContestHandler)
+
+	// Paper QSL cards are tracked in the QSOs' QSL fields.
+	qslRoutes := s.app.Group("/qsl", s.apikeyHeaderAuthNMiddleware())
+	qslRoutes.Get("/print", s.qslCardsToPrintHandler)
+	qslRoutes.Post("/labels", s.qslLabelsHandler)
+	qslRoutes.Post("/queue", s.requireLogbookRole(logbookRoleOperator), s.writableLogbookMiddleware(), s.queuePaperQslHandler)
+	qslRoutes.Post("/sent", s.requireLogbookRole(logbookRoleOperator), s.writableLogbookMiddleware(), s.sentPaperQslHandler)
+	qslRoutes.Post("/received", s.requireLogbookRole(logbookRoleOperator), s.writableLogbookMiddleware(), s.receivedPaperQslHandler)
+
+	syncRoutes := s.app.Group("/sync", s.apikeyHeaderAuthNMiddleware())
+	syncRoutes.Get("/pull", s.syncPullHandler)
+	syncRoutes.Post("/push", s.requireLogbookRole(logbookRoleOperator), s.writableLogbookMiddleware(), s.syncPushHandler)
+
+	lotwRoutes := s.app.Group("/integrations/lotw", s.apikeyHeaderAuthNMiddleware(), s.requireLogbookRole(logbookRoleOwner), s.refuseSandboxMiddleware())
+	lotwRoutes.Get("/", s.getLotwAccountHandler)
+	lotwRoutes.Put("/", s.putLotwAccountHandler)
+	lotwRoutes.Delete("/", s.deleteLotwAccountHandler)
+	lotwRoutes.Post("/sync", s.syncLotwHandler)
+
+	eqslRoutes := s.app.Group("/integrations/eqsl", s.apikeyHeaderAuthNMiddleware(), s.requireLogbookRole(logbookRoleOwner), s.refuseSandboxMiddleware())
+	eqslRoutes.Get("/", s.getEqslAccountHandler)
+	eqslRoutes.Put("/", s.putEqslAccountHandler)
+	eqslRoutes.Delete("/", s.deleteEqslAccountHandler)
+	eqslRoutes.Post("/sync", s.syncEqslHandler)
+
+	lookupRoutes := s.app.Group("/integrations/lookup", s.apikeyHeaderAuthNMiddleware(), s.requireLogbookRole(logbookRoleOwner))
+	lookupRoutes.Get("/", s.getLookupAccountHandler)
+	lookupRoutes.Put("/", s.putLookupAccountHandler)
+	lookupRoutes.Delete("/", s.deleteLookupAccountHandler)
+
+	clubLogRoutes := s.app.Group("/integrations/clublog", s.apikeyHeaderAuthNMiddleware(), s.requireLogbookRole(logbookRoleOwner), s.refuseSandboxMiddleware())
+	clubLogRoutes.Get("/", s.getClubLogAccountHandler)
+	clubLogRoutes.Put("/", s.putClubLogAccountHandler)
+	clubLogRoutes.Delete("/", s.deleteClubLogAccountHandler)
+	clubLogRoutes.Post("/upload", s.uploadClubLogHandler)
+
+	pskReporterRoutes := s.app.Group("/integrations/pskreporter", s.apikeyHeaderAuthNMiddleware(), s.requireLogbookRole(logbookRoleOwner), s.refuseSandboxMiddleware())
+	pskReporterRoutes.Get("/", s.getPskReporterHandler)
+	pskReporterRoutes.Put("/", s.putPskReporterHandler)
+	pskReporterRoutes.Delete("/", s.deletePskReporterHandler)
+
+	spotAlertRoutes := s.app.Group("/alerts/spots", s.apikeyHeaderAuthNMiddleware(), s.requireLogbookRole(logbookRoleOwner))
+	spotAlertRoutes.Get("/", s.getSpotAlertsHandler)
+	spotAlertRoutes.Put("/", s.putSpotAlertsHandler)
+	spotAlertRoutes.Delete("/", s.deleteSpotAlertsHandler)
+
+	awardRoutes := s.app.Group("/awards", s.apikeyHeaderAuthNMiddleware())
+	awardRoutes.Get("/", s.listAwardsHandler)
+	awardRoutes.Post("/rebuild", s.requireLogbookRole(logbookRoleOperator), s.rebuildAwardsHandler)
+	awardRoutes.Get("/:award", s.getAwardHandler)
+
+	// An import is kept as a batch, so that it can be undone as a whole.
+	importRoutes := s.app.Group("/imports", s.apikeyHeaderAuthNMiddleware())
+	importRoutes.Get("/", s.listImportBatchesHandler)
+	importRoutes.Get("/:id", s.getImportBatchHandler)
+	importRoutes.Post("/:id/undo", s.requireLogbookRole(logbookRoleOperator), s.writableLogbookMiddleware(), s.undoImportBatchHandler)
+
+	// Background jobs started for a logbook, such as its imports and syncs, are followed with its API key.
+	jobRoutes := s.app.Group("/jobs", s.apikeyHeaderAuthNMiddleware())
+	jobRoutes.Get("/", s.listJobsHandler)
+	jobRoutes.Get("/:id", s.getJobHandler)
+
+	statsRoutes := s.app.Group("/stats", s.apikeyHeaderAuthNMiddleware())
+	statsRoutes.Get("/activity", s.activityHandler)
+	statsRoutes.Get("/propagation", s.propagationHandler)
+	statsRoutes.Get("/count", s.qsoCountHandler)
+	statsRoutes.Get("/facets", s.qsoFacetsHandler)
+
+	// A key's signing secret lets its holder sign requests rather than send the key with each.
+	keyRoutes := s.app.Group("/keys", s.apikeyHeaderAuthNMiddleware())
+	keyRoutes.Post("/signing-secret", s.issueSigningSecretHandler)
+	keyRoutes.Delete("/signing-secret", s.deleteSigningSecretHandler)
+	// Sandbox keys write to a separate logbook, for trying the API out; see sandbox.go.
+	keyRoutes.Post("/sandbox", s.requireLogbookRole(logbookRoleOwner), s.refuseSandboxMiddleware(), s.issueSandboxKeyHandler)
+	keyRoutes.Delete("/sandbox", s.requireLogbookRole(logbookRoleOwner), s.refuseSandboxMiddleware(), s.deleteSandboxHandler)
+
+	// A logbook's API usage is for its owner, to find a misbehaving client.
+	s.app.Get("/usage", s.apikeyHeaderAuthNMiddleware(), s.requireLogbookRole(logbookRoleOwner), s.usageHandler)
+
+	// The admin routes operate on the whole server and authenticate with SM_ADMIN_TOKEN.
+	adminRoutes := s.app.Group("/admin", s.adminAuthNMiddleware(), s.auditAdminMiddleware(auditAdminTokenAction))
+	adminRoutes.Get("/maintenance", s.getMaintenanceHandler)
+	adminRoutes.Put("/maintenance", s.putMaintenanceHandler)
+	adminRoutes.Delete("/maintenance", s.deleteMaintenanceHandler)
+	adminRoutes.Get("/backups", s.getBackupsHandler)
+	adminRoutes.Post("/backups", s.postBackupHandler)
+	adminRoutes.Get("/jobs", s.adminListJobsHandler)
+	adminRoutes.Get("/jobs/:id", s.adminGetJobHandler)
+	adminRoutes.Get("/schedule", s.adminScheduleHandler)
+	adminRoutes.Get("/admins", s.listAdminsHandler)
+	adminRoutes.Post("/admins", s.enrollAdminHandler)
+	adminRoutes.Delete("/admins/:callsign", s.removeAdminHandler)
 }
 
 func (s *Service) resolveAndSetDatabaseService() (*database.Service, error) {
@@ -188,7 +624,11 @@ func (s *Service) resolveAndSetServerConfig() (types.ServerConfig, error) {
 		return emptyRetVal, errors.New(op).Err(err).Msg("Failed to get server config")
 	}
 
+	if svrCfg == nil {
+		return emptyRetVal, errors.New(op).Msg("Server config is nil")
+	}
+
 	//TODO: Config validation
 
-	return svrCfg, nil
+	return *svrCfg, nil
 }
@@ -0,0 +1,24 @@
+package service
+
+import (
+	"testing"
+
+	"github.com/Station-Manager/types"
+)
+
+func TestApplyOptions(t *testing.T) {
+	cfg := types.AppConfig{ServerConfig: &types.ServerConfig{Port: 3000}, LoggingConfig: types.LoggingConfig{Level: "info"}}
+
+	if err := applyOptions(&cfg, Options{}); err != nil || cfg.ServerConfig.Port != 3000 || cfg.LoggingConfig.Level != "info" {
+		t.Fatalf("expected empty options to change nothing, got %+v (err=%v)", cfg, err)
+	}
+	if err := applyOptions(&cfg, Options{Port: 4000, LogLevel: "debug"}); err != nil || cfg.ServerConfig.Port != 4000 || cfg.LoggingConfig.Level != "debug" {
+		t.Errorf("expected the overrides to apply, got %+v (err=%v)", cfg, err)
+	}
+	if err := applyOptions(&cfg, Options{Port: 70000}); err == nil {
+		t.Error("expected an invalid port to be rejected")
+	}
+	if err := applyOptions(&cfg, Options{LogLevel: "loud"}); err == nil {
+		t.Error("expected an invalid log level to be rejected")
+	}
+}
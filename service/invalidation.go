@@ -0,0 +1,128 @@
+package service
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"strconv"
+
+	"github.com/Station-Manager/errors"
+)
+
+const (
+	invalidationKindLogbook = "logbook"
+	invalidationKindUser    = "user"
+	invalidationKindApiKey  = "api_key"
+	// invalidationKindAll drops every cached entry, e.g. after notifications may have been missed.
+	invalidationKindAll = "all"
+)
+
+// invalidationEvent instructs every server instance to drop a cached entry.
+type invalidationEvent struct {
+	Origin string `json:"origin"` // ID of the publishing instance
+	Kind   string `json:"kind"`
+	Key    string `json:"key,omitempty"`
+}
+
+// invalidationBus distributes cache invalidation events between server instances.
+// The publishing instance always applies an event locally before publishing it, so
+// implementations only need to deliver events published by other instances.
+type invalidationBus interface {
+	// Publish sends the event to the other instances.
+	Publish(ctx context.Context, event invalidationEvent) error
+	// Run delivers events from other instances to apply until ctx is cancelled.
+	Run(ctx context.Context, apply func(invalidationEvent))
+}
+
+// localInvalidationBus is used for a single node: there are no other instances to notify.
+type localInvalidationBus struct{}
+
+func (localInvalidationBus) Publish(context.Context, invalidationEvent) error { return nil }
+
+func (localInvalidationBus) Run(ctx context.Context, _ func(invalidationEvent)) { <-ctx.Done() }
+
+// initializeInvalidationBus selects the invalidation bus for the configured datastore. PostgreSQL
+// deployments may run several instances against one database, so LISTEN/NOTIFY is used there.
+func (s *Service) initializeInvalidationBus() error {
+	const op errors.Op = "server.Service.initializeInvalidationBus"
+
+	id, err := newInstanceID()
+	if err != nil {
+		return errors.New(op).Err(err)
+	}
+	s.instanceID = id
+
+	if s.isPostgres() {
+		s.invalidationBus = newPostgresInvalidationBus(s.db, s.dbConfig, s.logger, s.instanceID)
+		return nil
+	}
+
+	s.invalidationBus = localInvalidationBus{}
+	return nil
+}
+
+// invalidateLogbook drops the logbook from the cache on every instance. It must be called
+// whenever a logbook is updated or deleted.
+func (s *Service) invalidateLogbook(ctx context.Context, logbookID int64) {
+	s.publishInvalidation(ctx, invalidationEvent{Kind: invalidationKindLogbook, Key: strconv.FormatInt(logbookID, 10)})
+}
+
+// invalidateUser drops the cached user for callsign on every instance. It must be called
+// whenever a user's credentials or email details change.
+func (s *Service) invalidateUser(ctx context.Context, callsign string) {
+	s.publishInvalidation(ctx, invalidationEvent{Kind: invalidationKindUser, Key: callsign})
+}
+
+// invalidateApiKey drops the cached API key record for prefix on every instance. It must be
+// called whenever a key is revoked or rotated.
+func (s *Service) invalidateApiKey(ctx context.Context, prefix string) {
+	s.publishInvalidation(ctx, invalidationEvent{Kind: invalidationKindApiKey, Key: prefix})
+}
+
+// publishInvalidation applies the event locally and then publishes it to the other instances.
+// A publish failure is logged but not returned: the local cache is already consistent and the
+// remote entries will still expire after their TTL.
+func (s *Service) publishInvalidation(ctx context.Context, event invalidationEvent) {
+	event.Origin = s.instanceID
+	s.applyInvalidation(event)
+
+	if s.invalidationBus == nil {
+		return
+	}
+	if err := s.invalidationBus.Publish(ctx, event); err != nil {
+		s.logger.ErrorWith().Err(err).Str("kind", event.Kind).Msg("Failed to publish cache invalidation")
+	}
+}
+
+// applyInvalidation removes the entry identified by the event from the local caches.
+func (s *Service) applyInvalidation(event invalidationEvent) {
+	switch event.Kind {
+	case invalidationKindLogbook:
+		id, err := strconv.ParseInt(event.Key, 10, 64)
+		if err != nil {
+			return
+		}
+		s.logbookCache.Invalidate(id)
+	case invalidationKindUser:
+		s.userCache.Invalidate(event.Key)
+	case invalidationKindApiKey:
+		s.apiKeyCache.Invalidate(event.Key)
+		s.apiKeyMemberCache.Invalidate(event.Key)
+		s.signingSecretCache.Invalidate(event.Key)
+	case invalidationKindAll:
+		s.logbookCache.Purge()
+		s.userCache.Purge()
+		s.apiKeyCache.Purge()
+		s.apiKeyMemberCache.Purge()
+		s.signingSecretCache.Purge()
+	}
+}
+
+// newInstanceID returns a random identifier for this server process.
+func newInstanceID() (string, error) {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return emptyString, err
+	}
+	return hex.EncodeToString(b), nil
+}
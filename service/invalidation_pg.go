@@ -0,0 +1,119 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/url"
+	"time"
+
+	"github.com/Station-Manager/errors"
+	"github.com/Station-Manager/logging"
+	"github.com/Station-Manager/types"
+	"github.com/goccy/go-json"
+	"github.com/lib/pq"
+)
+
+const (
+	// pgInvalidationChannel is the LISTEN/NOTIFY channel shared by every server instance.
+	pgInvalidationChannel = "sm_cache_invalidation"
+
+	pgListenerMinReconnect  = 1 * time.Second
+	pgListenerMaxReconnect  = 1 * time.Minute
+	pgListenerPingInterval  = 90 * time.Second
+	pgInvalidationOpTimeout = 5 * time.Second
+)
+
+// postgresInvalidationBus distributes invalidation events with PostgreSQL LISTEN/NOTIFY.
+type postgresInvalidationBus struct {
+	db     store
+	cfg    *types.DatastoreConfig
+	logger *logging.Service
+	origin string
+}
+
+func newPostgresInvalidationBus(db store, cfg *types.DatastoreConfig, logger *logging.Service, origin string) *postgresInvalidationBus {
+	return &postgresInvalidationBus{db: db, cfg: cfg, logger: logger, origin: origin}
+}
+
+// Publish sends the event with pg_notify over the shared connection pool.
+func (b *postgresInvalidationBus) Publish(ctx context.Context, event invalidationEvent) error {
+	const op errors.Op = "server.postgresInvalidationBus.Publish"
+
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return errors.New(op).Err(err)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, pgInvalidationOpTimeout)
+	defer cancel()
+
+	if _, err = b.db.ExecContext(ctx, "SELECT pg_notify($1, $2)", pgInvalidationChannel, string(payload)); err != nil {
+		return errors.New(op).Err(err)
+	}
+	return nil
+}
+
+// Run listens on a dedicated connection and applies events published by other instances.
+// If the connection drops, notifications may have been missed, so every cache is purged on reconnect.
+func (b *postgresInvalidationBus) Run(ctx context.Context, apply func(invalidationEvent)) {
+	listener := pq.NewListener(postgresDsn(b.cfg), pgListenerMinReconnect, pgListenerMaxReconnect, func(ev pq.ListenerEventType, err error) {
+		if err != nil {
+			b.logger.ErrorWith().Err(err).Msg("Cache invalidation listener connection error")
+		}
+	})
+	defer func() { _ = listener.Close() }()
+
+	if err := listener.Listen(pgInvalidationChannel); err != nil {
+		b.logger.ErrorWith().Err(err).Msg("Failed to listen for cache invalidations")
+		return
+	}
+
+	ticker := time.NewTicker(pgListenerPingInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case n := <-listener.Notify:
+			if n == nil {
+				// The connection was re-established; events sent in between are lost.
+				apply(invalidationEvent{Kind: invalidationKindAll})
+				continue
+			}
+			var event invalidationEvent
+			if err := json.Unmarshal([]byte(n.Extra), &event); err != nil {
+				b.logger.ErrorWith().Err(err).Msg("Malformed cache invalidation payload")
+				continue
+			}
+			if event.Origin == b.origin {
+				continue // already applied locally
+			}
+			apply(event)
+		case <-ticker.C:
+			// Detect dead connections that would otherwise go unnoticed.
+			go func() { _ = listener.Ping() }()
+		}
+	}
+}
+
+// postgresDsn builds a connection string for cfg. It mirrors the DSN used by the database service,
+// which does not expose its own.
+func postgresDsn(cfg *types.DatastoreConfig) string {
+	if cfg == nil {
+		return emptyString
+	}
+	q := url.Values{}
+	if cfg.SSLMode != emptyString {
+		q.Set("sslmode", cfg.SSLMode)
+	}
+	u := &url.URL{
+		Scheme:   "postgres",
+		User:     url.UserPassword(cfg.User, cfg.Password),
+		Host:     net.JoinHostPort(cfg.Host, fmt.Sprintf("%d", cfg.Port)),
+		Path:     "/" + cfg.Database,
+		RawQuery: q.Encode(),
+	}
+	return u.String()
+}
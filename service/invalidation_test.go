@@ -0,0 +1,84 @@
+package service
+
+import (
+	"context"
+	"testing"
+
+	"github.com/Station-Manager/types"
+)
+
+type recordingInvalidationBus struct {
+	published []invalidationEvent
+}
+
+func (b *recordingInvalidationBus) Publish(_ context.Context, event invalidationEvent) error {
+	b.published = append(b.published, event)
+	return nil
+}
+
+func (b *recordingInvalidationBus) Run(ctx context.Context, _ func(invalidationEvent)) { <-ctx.Done() }
+
+func TestInitializeInvalidationBus_SqliteUsesLocalBus(t *testing.T) {
+	dbSvc := newTestDatabaseService(t)
+	defer func() { _ = dbSvc.Close() }()
+
+	svc := &Service{db: dbSvc, logger: dbSvc.Logger}
+	if err := svc.initializeInvalidationBus(); err != nil {
+		t.Fatalf("initializeInvalidationBus failed: %v", err)
+	}
+	if _, ok := svc.invalidationBus.(localInvalidationBus); !ok {
+		t.Errorf("expected local invalidation bus, got %T", svc.invalidationBus)
+	}
+	if svc.instanceID == emptyString {
+		t.Error("expected an instance ID to be assigned")
+	}
+}
+
+func TestInvalidateLogbook_AppliesLocallyAndPublishes(t *testing.T) {
+	bus := &recordingInvalidationBus{}
+	svc := &Service{instanceID: "self", invalidationBus: bus}
+	svc.initializeCaches()
+	svc.logbookCache.Set(42, types.Logbook{ID: 42}, defaultLogbookCacheTTL)
+
+	svc.invalidateLogbook(context.Background(), 42)
+
+	if _, ok := svc.logbookCache.Get(42); ok {
+		t.Error("expected logbook to be removed from the local cache")
+	}
+	if len(bus.published) != 1 {
+		t.Fatalf("expected 1 published event, got %d", len(bus.published))
+	}
+	want := invalidationEvent{Origin: "self", Kind: invalidationKindLogbook, Key: "42"}
+	if bus.published[0] != want {
+		t.Errorf("expected %+v, got %+v", want, bus.published[0])
+	}
+}
+
+func TestApplyInvalidation(t *testing.T) {
+	svc := &Service{}
+	svc.initializeCaches()
+	svc.logbookCache.Set(1, types.Logbook{ID: 1}, defaultLogbookCacheTTL)
+	svc.userCache.Set("W1AW", types.User{ID: 1}, defaultUserCacheTTL)
+	svc.apiKeyCache.Set("ABCDEFGHIJ", types.ApiKey{ID: 1}, defaultApiKeyCacheTTL)
+
+	svc.applyInvalidation(invalidationEvent{Kind: invalidationKindApiKey, Key: "ABCDEFGHIJ"})
+	if _, ok := svc.apiKeyCache.Get("ABCDEFGHIJ"); ok {
+		t.Error("expected API key to be invalidated")
+	}
+	if _, ok := svc.userCache.Get("W1AW"); !ok {
+		t.Error("expected unrelated user entry to remain cached")
+	}
+
+	svc.applyInvalidation(invalidationEvent{Kind: invalidationKindAll})
+	if svc.logbookCache.Stats().Entries+svc.userCache.Stats().Entries != 0 {
+		t.Error("expected every cache to be purged")
+	}
+}
+
+func TestPostgresDsn(t *testing.T) {
+	cfg := &types.DatastoreConfig{Host: "db", Port: 5432, User: "sm", Password: "p@ss", Database: "station_manager", SSLMode: "disable"}
+	want := "postgres://sm:p%40ss@db:5432/station_manager?sslmode=disable"
+	if got := postgresDsn(cfg); got != want {
+		t.Errorf("expected %q, got %q", want, got)
+	}
+}
@@ -0,0 +1,699 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	stderr "errors"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/Station-Manager/errors"
+	"github.com/goccy/go-json"
+)
+
+// envJobWorkers names the environment variable holding how many background jobs run at once on
+// this server (e.g. "4").
+const envJobWorkers = "SM_JOB_WORKERS"
+
+const (
+	defaultJobWorkers     = 2
+	defaultJobMaxAttempts = 3
+	// defaultJobPollInterval is how often idle workers look for jobs queued by other servers, or
+	// retries that have come due.
+	defaultJobPollInterval = 5 * time.Second
+	// defaultJobLease is how long a job may run without its server renewing its claim on it
+	// before another worker takes it over.
+	defaultJobLease          = 5 * time.Minute
+	defaultJobRetryBaseDelay = 30 * time.Second
+	defaultJobRetryMaxDelay  = 30 * time.Minute
+	defaultJobRetention      = 7 * 24 * time.Hour
+	defaultJobPurgeInterval  = time.Hour
+	defaultJobListLimit      = 50
+	maxJobListLimit          = 500
+	// jobProgressFlushInterval is how often the progress of a running job is written to the
+	// database on PostgreSQL, for the other servers to read.
+	jobProgressFlushInterval = 5 * time.Second
+	// jobRecordTimeout bounds recording the release of a job interrupted by shutdown.
+	jobRecordTimeout = 10 * time.Second
+)
+
+// jobKind names the work a job does; each kind is registered with its jobSpec.
+type jobKind string
+
+const (
+	jobKindWebhookDelivery jobKind = "webhook_delivery"
+	jobKindLotwSync        jobKind = "lotw_sync"
+	jobKindEqslSync        jobKind = "eqsl_sync"
+	jobKindBackup          jobKind = "backup"
+	jobKindImport          jobKind = "import"
+	jobKindEmail           jobKind = "email"
+	jobKindAccountExport   jobKind = "account_export"
+)
+
+// jobStatus is the progress of a job.
+type jobStatus string
+
+const (
+	jobQueued    jobStatus = "queued"
+	jobRunning   jobStatus = "running"
+	jobSucceeded jobStatus = "succeeded"
+	jobFailed    jobStatus = "failed"
+)
+
+// job is a unit of background work stored in the jobs table.
+type job struct {
+	ID          int64     `json:"id"`
+	Kind        jobKind   `json:"kind"`
+	LogbookID   int64     `json:"logbook_id,omitempty"`
+	Status      jobStatus `json:"status"`
+	Attempts    int       `json:"attempts"`
+	MaxAttempts int       `json:"max_attempts"`
+	LastError   string    `json:"last_error,omitempty"`
+	// Progress is the latest progress the job reported; see reportJobProgress.
+	Progress   json.RawMessage `json:"progress,omitempty"`
+	Result     json.RawMessage `json:"result,omitempty"`
+	CreatedAt  string          `json:"created_at"`
+	RunAt      string          `json:"run_at"`
+	FinishedAt string          `json:"finished_at,omitempty"`
+
+	payload []byte
+	// leaseToken identifies this worker's claim on the job; see claimJob.
+	leaseToken string
+}
+
+// decodePayload decodes the job's payload into v.
+func (j job) decodePayload(v any) error {
+	return json.Unmarshal(j.payload, v)
+}
+
+// jobSpec is how the runner carries out jobs of one kind.
+type jobSpec struct {
+	// run does the work. Its result is stored on the job as JSON. A failure is retried until the
+	// job's attempts are used up, unless it is wrapped with permanentJobError.
+	run func(ctx context.Context, j job) (any, error)
+	// maxAttempts is the default number of attempts for jobs of this kind.
+	maxAttempts int
+	// retryDelay returns the delay before the given retry (1 for the first retry); nil for the
+	// runner's exponential backoff.
+	retryDelay func(retry int) time.Duration
+	// abandoned, if set, is called once a job has failed for the last time.
+	abandoned func(ctx context.Context, j job, err error)
+	// unique allows one queued or running job of the kind per logbook; enqueueing another returns
+	// the existing one.
+	unique bool
+	// announce publishes the progress and outcome of the kind's jobs on their logbook's event feed.
+	announce bool
+}
+
+// newJob describes a job to enqueue.
+type newJob struct {
+	Kind jobKind
+	// LogbookID is the logbook the job works on, or zero for a job on the whole server.
+	LogbookID int64
+	Payload   any
+	// MaxAttempts overrides the kind's default number of attempts when positive.
+	MaxAttempts int
+}
+
+// permanentJobError marks a job failure that retrying cannot fix.
+type permanentJobError struct {
+	err error
+}
+
+func (e *permanentJobError) Error() string { return e.err.Error() }
+func (e *permanentJobError) Unwrap() error { return e.err }
+
+// permanentJobFailure wraps err so that the job fails without being retried.
+func permanentJobFailure(err error) error {
+	return &permanentJobError{err: err}
+}
+
+// jobRunner runs the jobs queued in the database on a pool of background workers. Every server
+// sharing the database runs its own workers; a job is claimed by one of them at a time.
+type jobRunner struct {
+	specs          map[jobKind]jobSpec
+	workers        int
+	pollInterval   time.Duration
+	lease          time.Duration
+	retryBaseDelay time.Duration
+	retryMaxDelay  time.Duration
+	retention      time.Duration
+	// wake tells an idle worker that a job was queued on this server.
+	wake chan struct{}
+
+	mu sync.Mutex
+	// progress holds the latest progress of the jobs running on this server. It is kept here
+	// rather than written as it is reported, since a job such as an import may hold a write
+	// transaction that SQLite would make the write wait for.
+	progress map[int64]json.RawMessage
+	// requeued counts the jobs interrupted by shutdown and queued again.
+	requeued atomic.Int32
+}
+
+func newJobRunner() *jobRunner {
+	return &jobRunner{
+		specs:          make(map[jobKind]jobSpec),
+		workers:        defaultJobWorkers,
+		pollInterval:   defaultJobPollInterval,
+		lease:          defaultJobLease,
+		retryBaseDelay: defaultJobRetryBaseDelay,
+		retryMaxDelay:  defaultJobRetryMaxDelay,
+		retention:      defaultJobRetention,
+		wake:           make(chan struct{}, 1),
+		progress:       make(map[int64]json.RawMessage),
+	}
+}
+
+// loadJobRunner creates the job runner with the number of workers set in the environment.
+func loadJobRunner() (*jobRunner, error) {
+	const op errors.Op = "server.loadJobRunner"
+
+	r := newJobRunner()
+	if value := strings.TrimSpace(os.Getenv(envJobWorkers)); value != emptyString {
+		workers, err := strconv.Atoi(value)
+		if err != nil || workers <= 0 {
+			return nil, errors.New(op).Msg(envJobWorkers + " must be a positive number")
+		}
+		r.workers = workers
+	}
+	return r, nil
+}
+
+// register sets how jobs of the kind are run.
+func (r *jobRunner) register(kind jobKind, spec jobSpec) {
+	if spec.maxAttempts <= 0 {
+		spec.maxAttempts = defaultJobMaxAttempts
+	}
+	r.specs[kind] = spec
+}
+
+// setProgress records the latest progress of a job running on this server.
+func (r *jobRunner) setProgress(id int64, progress json.RawMessage) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.progress[id] = progress
+}
+
+// latestProgress returns the latest progress of a job running on this server, if it reported any.
+func (r *jobRunner) latestProgress(id int64) (json.RawMessage, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	progress, ok := r.progress[id]
+	return progress, ok
+}
+
+// takeProgress returns and forgets the latest progress of a job that has stopped running here.
+func (r *jobRunner) takeProgress(id int64) (json.RawMessage, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	progress, ok := r.progress[id]
+	delete(r.progress, id)
+	return progress, ok
+}
+
+// retryDelay returns the exponential backoff before the given retry (1 for the first retry).
+func (r *jobRunner) retryDelay(retry int) time.Duration {
+	delay := r.retryBaseDelay
+	for i := 1; i < retry && delay < r.retryMaxDelay; i++ {
+		delay *= 2
+	}
+	return min(delay, r.retryMaxDelay)
+}
+
+// registerJobKinds registers the work done in background jobs.
+func (s *Service) registerJobKinds() {
+	s.jobs.register(jobKindWebhookDelivery, jobSpec{
+		run:        s.runWebhookDeliveryJob,
+		retryDelay: func(retry int) time.Duration { return s.webhooks.retryDelay(retry) },
+		abandoned:  s.abandonWebhookDeliveryJob,
+	})
+	s.jobs.register(jobKindLotwSync, jobSpec{run: s.runLotwSyncJob, unique: true})
+	s.jobs.register(jobKindEqslSync, jobSpec{run: s.runEqslSyncJob, unique: true})
+	s.jobs.register(jobKindBackup, jobSpec{run: s.runBackupJob, maxAttempts: 1, unique: true})
+	s.jobs.register(jobKindImport, jobSpec{run: s.runImportJob, maxAttempts: 1, announce: true})
+	s.jobs.register(jobKindEmail, jobSpec{run: s.runEmailJob})
+	s.jobs.register(jobKindAccountExport, jobSpec{run: s.runAccountExportJob})
+}
+
+// enqueueJob queues a job and wakes a worker to run it. For a unique kind, a queued or running job
+// of the kind for the same logbook is returned instead, and created is false.
+func (s *Service) enqueueJob(ctx context.Context, request newJob) (j job, created bool, err error) {
+	const op errors.Op = "server.Service.enqueueJob"
+
+	spec, ok := s.jobs.specs[request.Kind]
+	if !ok {
+		return job{}, false, errors.New(op).Msg("unknown job kind " + string(request.Kind))
+	}
+	if spec.unique {
+		existing, err := s.queryJobs(ctx, `WHERE kind = $1 AND COALESCE(logbook_id, 0) = $2 AND status IN ('queued', 'running') ORDER BY id LIMIT 1`,
+			string(request.Kind), request.LogbookID)
+		if err != nil {
+			return job{}, false, errors.New(op).Err(err)
+		}
+		if len(existing) > 0 {
+			return existing[0], false, nil
+		}
+	}
+
+	payload := []byte(emptyString)
+	if request.Payload != nil {
+		if payload, err = json.Marshal(request.Payload); err != nil {
+			return job{}, false, errors.New(op).Err(err)
+		}
+	}
+	maxAttempts := spec.maxAttempts
+	if request.MaxAttempts > 0 {
+		maxAttempts = request.MaxAttempts
+	}
+	var logbookID any
+	if request.LogbookID != 0 {
+		logbookID = request.LogbookID
+	}
+
+	id, err := s.insertJob(ctx, request.Kind, logbookID, payload, maxAttempts)
+	if err != nil {
+		return job{}, false, errors.New(op).Err(err)
+	}
+	select {
+	case s.jobs.wake <- struct{}{}:
+	default:
+	}
+
+	if j, _, err = s.fetchJob(ctx, id, 0); err != nil {
+		return job{}, false, errors.New(op).Err(err)
+	}
+	return j, true, nil
+}
+
+func (s *Service) insertJob(ctx context.Context, kind jobKind, logbookID any, payload []byte, maxAttempts int) (int64, error) {
+	const op errors.Op = "server.Service.insertJob"
+
+	rows, err := s.db.QueryContext(ctx, `INSERT INTO jobs (kind, logbook_id, payload, max_attempts, run_at) VALUES ($1, $2, $3, $4, $5)
+		RETURNING id`, string(kind), logbookID, string(payload), maxAttempts, s.dbTimestamp(time.Now()))
+	if err != nil {
+		return 0, errors.New(op).Err(err)
+	}
+	defer func() { _ = rows.Close() }()
+
+	if !rows.Next() {
+		if err = rows.Err(); err == nil {
+			err = errors.New(op).Msg("No row returned")
+		}
+		return 0, errors.New(op).Err(err)
+	}
+	var id int64
+	if err = rows.Scan(&id); err != nil {
+		return 0, errors.New(op).Err(err)
+	}
+	return id, nil
+}
+
+// fetchJob returns the job with the given ID. A non-zero logbookID limits the search to that
+// logbook's jobs.
+func (s *Service) fetchJob(ctx context.Context, id, logbookID int64) (job, bool, error) {
+	const op errors.Op = "server.Service.fetchJob"
+
+	clause, args := `WHERE id = $1`, []any{id}
+	if logbookID != 0 {
+		clause, args = `WHERE id = $1 AND logbook_id = $2`, []any{id, logbookID}
+	}
+	jobs, err := s.queryJobs(ctx, clause, args...)
+	if err != nil {
+		return job{}, false, errors.New(op).Err(err)
+	}
+	if len(jobs) == 0 {
+		return job{}, false, nil
+	}
+	return jobs[0], true, nil
+}
+
+// listJobs returns up to limit jobs, newest first. A non-zero logbookID limits the list to that
+// logbook's jobs, and a non-empty status to jobs with that status.
+func (s *Service) listJobs(ctx context.Context, logbookID int64, status jobStatus, limit int) ([]job, error) {
+	const op errors.Op = "server.Service.listJobs"
+
+	var conditions []string
+	var args []any
+	if logbookID != 0 {
+		args = append(args, logbookID)
+		conditions = append(conditions, `logbook_id = $`+strconv.Itoa(len(args)))
+	}
+	if status != emptyString {
+		args = append(args, string(status))
+		conditions = append(conditions, `status = $`+strconv.Itoa(len(args)))
+	}
+	clause := emptyString
+	if len(conditions) > 0 {
+		clause = `WHERE ` + strings.Join(conditions, ` AND `)
+	}
+	args = append(args, limit)
+
+	jobs, err := s.queryJobs(ctx, clause+` ORDER BY id DESC LIMIT $`+strconv.Itoa(len(args)), args...)
+	if err != nil {
+		return nil, errors.New(op).Err(err)
+	}
+	return jobs, nil
+}
+
+func (s *Service) queryJobs(ctx context.Context, clause string, args ...any) ([]job, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT id, kind, COALESCE(logbook_id, 0), status, attempts, max_attempts, last_error, progress, result,
+		`+s.timestampExpr(`created_at`)+`, `+s.timestampExpr(`run_at`)+`, COALESCE(`+s.timestampExpr(`finished_at`)+`, ''), payload
+		FROM jobs `+clause, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = rows.Close() }()
+
+	var jobs []job
+	for rows.Next() {
+		var j job
+		var progress, result, payload string
+		if err = rows.Scan(&j.ID, &j.Kind, &j.LogbookID, &j.Status, &j.Attempts, &j.MaxAttempts, &j.LastError, &progress, &result,
+			&j.CreatedAt, &j.RunAt, &j.FinishedAt, &payload); err != nil {
+			return nil, err
+		}
+		if progress != emptyString {
+			j.Progress = json.RawMessage(progress)
+		}
+		if latest, ok := s.jobs.latestProgress(j.ID); ok && j.Status == jobRunning {
+			j.Progress = latest
+		}
+		if result != emptyString {
+			j.Result = json.RawMessage(result)
+		}
+		j.payload = []byte(payload)
+		jobs = append(jobs, j)
+	}
+	return jobs, rows.Err()
+}
+
+// claimJob marks the next due job as running on this server and returns it. A running job whose
+// lease has run out is due again. The claim is stamped with a new lease token, which every later
+// write of the run must match, so that a worker that lost the job cannot record over its new one.
+// ok is false if no job is due.
+func (s *Service) claimJob(ctx context.Context, now time.Time) (j job, ok bool, err error) {
+	const op errors.Op = "server.Service.claimJob"
+
+	token, err := newJobLeaseToken()
+	if err != nil {
+		return job{}, false, errors.New(op).Err(err)
+	}
+	lock := emptyString
+	if s.isPostgres() {
+		lock = ` FOR UPDATE SKIP LOCKED`
+	}
+	due := `(status = 'queued' AND run_at <= $2) OR (status = 'running' AND lease_until < $2)`
+	rows, err := s.db.QueryContext(ctx, `UPDATE jobs SET status = 'running', attempts = attempts + 1, lease_until = $1, lease_token = $3
+		WHERE id = (SELECT id FROM jobs WHERE `+due+` ORDER BY run_at, id LIMIT 1`+lock+`) AND (`+due+`)
+		RETURNING id`, s.dbTimestamp(now.Add(s.jobs.lease)), s.dbTimestamp(now), token)
+	if err != nil {
+		return job{}, false, errors.New(op).Err(err)
+	}
+	var id int64
+	found := rows.Next()
+	if found {
+		err = rows.Scan(&id)
+	} else {
+		err = rows.Err()
+	}
+	if closeErr := rows.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return job{}, false, errors.New(op).Err(err)
+	}
+	if !found {
+		return job{}, false, nil
+	}
+
+	if j, ok, err = s.fetchJob(ctx, id, 0); err != nil {
+		return job{}, false, errors.New(op).Err(err)
+	}
+	j.leaseToken = token
+	return j, ok, nil
+}
+
+// newJobLeaseToken returns a random token for a claim on a job.
+func newJobLeaseToken() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return emptyString, err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// leaseHeld reports whether an update of a claimed job matched it, which it does not once the
+// job's lease has run out and another worker has claimed it.
+func leaseHeld(res sql.Result, err error) (bool, error) {
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return n > 0, nil
+}
+
+// runJobWorker runs due jobs until ctx is cancelled, waiting between them for a job to be queued
+// on this server or for the poll interval to pass.
+func (s *Service) runJobWorker(ctx context.Context) {
+	ticker := time.NewTicker(s.jobs.pollInterval)
+	defer ticker.Stop()
+
+	for {
+		for ctx.Err() == nil && s.runNextJob(ctx) {
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-s.jobs.wake:
+		case <-ticker.C:
+		}
+	}
+}
+
+// runNextJob claims and runs the next due job, and reports whether there was one.
+func (s *Service) runNextJob(ctx context.Context) bool {
+	j, ok, err := s.claimJob(ctx, time.Now())
+	if err != nil {
+		if ctx.Err() == nil {
+			s.logger.ErrorWith().Err(err).Msg("Failed to claim a job")
+		}
+		return false
+	}
+	if !ok {
+		return false
+	}
+	s.runJob(s.jobContext(ctx), j)
+	return true
+}
+
+// runJob runs a claimed job, renewing its lease meanwhile, and records the outcome. A job
+// interrupted by shutdown is queued again without using up an attempt.
+func (s *Service) runJob(ctx context.Context, j job) {
+	const op errors.Op = "server.Service.runJob"
+
+	spec, ok := s.jobs.specs[j.Kind]
+	if !ok {
+		s.finishJob(ctx, j, nil, permanentJobFailure(errors.New(op).Msg("unknown job kind "+string(j.Kind))))
+		return
+	}
+
+	runCtx, cancel := context.WithCancel(ctx)
+	renewed := make(chan struct{})
+	go func() {
+		defer close(renewed)
+		s.renewJobLease(runCtx, j)
+	}()
+	result, err := spec.run(runCtx, j)
+	cancel()
+	<-renewed
+
+	if err != nil && ctx.Err() != nil {
+		s.releaseJob(j)
+		return
+	}
+	s.finishJob(ctx, j, result, err)
+}
+
+// renewJobLease extends the lease of a running job until ctx is cancelled. On PostgreSQL it also
+// writes the job's latest progress, which the database takes without waiting on the job.
+func (s *Service) renewJobLease(ctx context.Context, j job) {
+	id := j.ID
+	ticker := time.NewTicker(s.jobs.lease / 3)
+	defer ticker.Stop()
+	var flush <-chan time.Time
+	if s.isPostgres() {
+		flushTicker := time.NewTicker(jobProgressFlushInterval)
+		defer flushTicker.Stop()
+		flush = flushTicker.C
+	}
+
+	var flushed json.RawMessage
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-flush:
+			progress, ok := s.jobs.latestProgress(id)
+			if !ok || bytes.Equal(progress, flushed) {
+				continue
+			}
+			if _, err := s.db.ExecContext(ctx, `UPDATE jobs SET progress = $1 WHERE id = $2 AND status = 'running' AND lease_token = $3`,
+				string(progress), id, j.leaseToken); err != nil {
+				if ctx.Err() == nil {
+					s.logger.WarnWith().Err(err).Int64("job_id", id).Msg("Failed to record job progress")
+				}
+				continue
+			}
+			flushed = progress
+		case now := <-ticker.C:
+			held, err := leaseHeld(s.db.ExecContext(ctx, `UPDATE jobs SET lease_until = $1 WHERE id = $2 AND status = 'running' AND lease_token = $3`,
+				s.dbTimestamp(now.Add(s.jobs.lease)), id, j.leaseToken))
+			if err != nil && ctx.Err() == nil {
+				s.logger.WarnWith().Err(err).Int64("job_id", id).Msg("Failed to renew job lease")
+			} else if err == nil && !held {
+				s.logger.WarnWith().Int64("job_id", id).Msg("Lost the lease on a running job")
+			}
+		}
+	}
+}
+
+// releaseJob queues a job interrupted by shutdown again, so that the next server to start runs it.
+func (s *Service) releaseJob(j job) {
+	ctx, cancel := context.WithTimeout(context.Background(), jobRecordTimeout)
+	defer cancel()
+
+	s.jobs.requeued.Add(1)
+	s.jobs.takeProgress(j.ID)
+	held, err := leaseHeld(s.db.ExecContext(ctx, `UPDATE jobs SET status = 'queued', attempts = attempts - 1, lease_until = NULL, lease_token = ''
+		WHERE id = $1 AND status = 'running' AND lease_token = $2`, j.ID, j.leaseToken))
+	if err != nil {
+		s.logger.ErrorWith().Err(err).Int64("job_id", j.ID).Msg("Failed to release interrupted job")
+	} else if !held {
+		s.logger.WarnWith().Int64("job_id", j.ID).Msg("Interrupted job was already taken over by another worker")
+	}
+}
+
+// finishJob records the outcome of a job run: its result, a retry or its failure. Nothing is
+// recorded if the job's lease ran out and another worker claimed it meanwhile; that run's outcome
+// stands instead.
+func (s *Service) finishJob(ctx context.Context, j job, result any, runErr error) {
+	const op errors.Op = "server.Service.finishJob"
+
+	// The outcome is recorded even if shutdown began while the job ran.
+	ctx = context.WithoutCancel(ctx)
+	now := time.Now()
+	var err error
+	if progress, ok := s.jobs.takeProgress(j.ID); ok {
+		if _, err = s.db.ExecContext(ctx, `UPDATE jobs SET progress = $1 WHERE id = $2 AND status = 'running' AND lease_token = $3`,
+			string(progress), j.ID, j.leaseToken); err != nil {
+			s.logger.WarnWith().Err(errors.New(op).Err(err)).Int64("job_id", j.ID).Msg("Failed to record job progress")
+		}
+	}
+
+	var held, abandoned bool
+	if runErr == nil {
+		data := []byte(emptyString)
+		if result != nil {
+			if data, err = json.Marshal(result); err != nil {
+				s.logger.ErrorWith().Err(errors.New(op).Err(err)).Int64("job_id", j.ID).Msg("Failed to encode job result")
+				data = []byte(emptyString)
+			}
+		}
+		// The payload is no longer needed, and an import's can be large.
+		held, err = leaseHeld(s.db.ExecContext(ctx, `UPDATE jobs SET status = 'succeeded', result = $1, payload = '', last_error = '', lease_until = NULL, finished_at = $2
+			WHERE id = $3 AND status = 'running' AND lease_token = $4`, string(data), s.dbTimestamp(now), j.ID, j.leaseToken))
+		if held {
+			s.logger.DebugWith().Int64("job_id", j.ID).Str("kind", string(j.Kind)).Msg("Job succeeded")
+		}
+	} else {
+		lastError := errors.Root(runErr).Error()
+		var permanent *permanentJobError
+		if stderr.As(runErr, &permanent) || j.Attempts >= j.MaxAttempts {
+			held, err = leaseHeld(s.db.ExecContext(ctx, `UPDATE jobs SET status = 'failed', last_error = $1, lease_until = NULL, finished_at = $2
+				WHERE id = $3 AND status = 'running' AND lease_token = $4`, lastError, s.dbTimestamp(now), j.ID, j.leaseToken))
+			if held {
+				s.logger.WarnWith().Err(runErr).Int64("job_id", j.ID).Str("kind", string(j.Kind)).Int("attempts", j.Attempts).Msg("Job failed")
+				abandoned = true
+			}
+		} else {
+			delay := s.jobs.retryDelay(j.Attempts)
+			if spec, ok := s.jobs.specs[j.Kind]; ok && spec.retryDelay != nil {
+				delay = spec.retryDelay(j.Attempts)
+			}
+			held, err = leaseHeld(s.db.ExecContext(ctx, `UPDATE jobs SET status = 'queued', last_error = $1, lease_until = NULL, run_at = $2
+				WHERE id = $3 AND status = 'running' AND lease_token = $4`, lastError, s.dbTimestamp(now.Add(delay)), j.ID, j.leaseToken))
+			if held {
+				s.logger.InfoWith().Err(runErr).Int64("job_id", j.ID).Str("kind", string(j.Kind)).Dur("retry_in", delay).Msg("Job will be retried")
+			}
+		}
+	}
+	if err != nil {
+		s.logger.ErrorWith().Err(errors.New(op).Err(err)).Int64("job_id", j.ID).Msg("Failed to record job outcome")
+		return
+	}
+	if !held {
+		s.logger.WarnWith().Int64("job_id", j.ID).Str("kind", string(j.Kind)).Msg("Job outcome not recorded: its lease ran out and another worker claimed it")
+		return
+	}
+	spec, ok := s.jobs.specs[j.Kind]
+	if ok && abandoned && spec.abandoned != nil {
+		spec.abandoned(ctx, j, runErr)
+	}
+	if ok && spec.announce {
+		s.announceJob(ctx, j.ID, jobEventFinished)
+	}
+}
+
+// reportJobProgress records how far a running job has got, for its status and, if its kind is
+// announced, its logbook's event feed. The progress is stored with the job once it stops running.
+func (s *Service) reportJobProgress(j job, progress any) {
+	const op errors.Op = "server.Service.reportJobProgress"
+
+	data, err := json.Marshal(progress)
+	if err != nil {
+		s.logger.WarnWith().Err(errors.New(op).Err(err)).Int64("job_id", j.ID).Msg("Failed to encode job progress")
+		return
+	}
+	s.jobs.setProgress(j.ID, data)
+	if spec, ok := s.jobs.specs[j.Kind]; ok && spec.announce && j.LogbookID != 0 {
+		j.Status, j.Progress = jobRunning, data
+		s.qsoEvents.Announce(qsoEvent{Type: jobEventProgress, LogbookID: j.LogbookID, Job: &j})
+	}
+}
+
+// announceJob publishes the job's current state on its logbook's event feed.
+func (s *Service) announceJob(ctx context.Context, id int64, eventType qsoEventType) {
+	j, found, err := s.fetchJob(ctx, id, 0)
+	if err != nil {
+		s.logger.WarnWith().Err(err).Int64("job_id", id).Msg("Failed to read job for event")
+		return
+	}
+	if found && j.LogbookID != 0 {
+		s.qsoEvents.Announce(qsoEvent{Type: eventType, LogbookID: j.LogbookID, Job: &j})
+	}
+}
+
+// purgeFinishedJobs deletes the jobs that finished longer ago than the retention period, and the
+// account export archives written as long ago.
+func (s *Service) purgeFinishedJobs(ctx context.Context) error {
+	const op errors.Op = "server.Service.purgeFinishedJobs"
+
+	before := s.dbTimestamp(time.Now().Add(-s.jobs.retention))
+	if _, err := s.db.ExecContext(ctx, `DELETE FROM jobs WHERE finished_at < $1`, before); err != nil {
+		return errors.New(op).Err(err)
+	}
+	if _, err := s.db.ExecContext(ctx, `DELETE FROM account_exports WHERE finished_at < $1`, before); err != nil {
+		return errors.New(op).Err(err)
+	}
+	return nil
+}
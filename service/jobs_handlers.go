@@ -0,0 +1,95 @@
+package service
+
+import (
+	"strconv"
+
+	"github.com/Station-Manager/errors"
+	"github.com/gofiber/fiber/v2"
+)
+
+// listJobsRequest holds the query parameters of the job lists.
+type listJobsRequest struct {
+	Status jobStatus `query:"status" validate:"omitempty,oneof=queued running succeeded failed"`
+	Limit  int       `query:"limit" validate:"omitempty,min=1,max=500"`
+}
+
+// listJobsHandler lists the authenticated logbook's jobs, newest first.
+func (s *Service) listJobsHandler(c *fiber.Ctx) error {
+	const op errors.Op = "server.Service.listJobsHandler"
+
+	logbook, err := authenticatedLogbook(c)
+	if err != nil {
+		s.logger.ErrorWith().Err(errors.New(op).Err(err)).Msg("authenticatedLogbook failed")
+		return c.Status(fiber.StatusInternalServerError).JSON(jsonInternalError)
+	}
+	return s.respondWithJobs(c, logbook.ID)
+}
+
+// getJobHandler returns one of the authenticated logbook's jobs.
+func (s *Service) getJobHandler(c *fiber.Ctx) error {
+	const op errors.Op = "server.Service.getJobHandler"
+
+	logbook, err := authenticatedLogbook(c)
+	if err != nil {
+		s.logger.ErrorWith().Err(errors.New(op).Err(err)).Msg("authenticatedLogbook failed")
+		return c.Status(fiber.StatusInternalServerError).JSON(jsonInternalError)
+	}
+	return s.respondWithJob(c, logbook.ID)
+}
+
+// adminListJobsHandler lists the jobs of every logbook and of the server, newest first.
+func (s *Service) adminListJobsHandler(c *fiber.Ctx) error {
+	return s.respondWithJobs(c, 0)
+}
+
+// adminGetJobHandler returns any job.
+func (s *Service) adminGetJobHandler(c *fiber.Ctx) error {
+	return s.respondWithJob(c, 0)
+}
+
+// respondWithJobs answers with the jobs of the logbook, or of all logbooks and the server if
+// logbookID is zero.
+func (s *Service) respondWithJobs(c *fiber.Ctx, logbookID int64) error {
+	const op errors.Op = "server.Service.respondWithJobs"
+
+	var request listJobsRequest
+	if err := c.QueryParser(&request); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(jsonBadRequest)
+	}
+	if err := s.validate.Struct(request); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(jsonBadRequest)
+	}
+	if request.Limit == 0 {
+		request.Limit = defaultJobListLimit
+	}
+
+	jobs, err := s.listJobs(c.UserContext(), logbookID, request.Status, min(request.Limit, maxJobListLimit))
+	if err != nil {
+		s.logger.ErrorWith().Err(errors.New(op).Err(err)).Msg("s.listJobs failed")
+		return s.dbFailure(c, err)
+	}
+	if jobs == nil {
+		jobs = make([]job, 0)
+	}
+	return c.JSON(fiber.Map{"jobs": jobs})
+}
+
+// respondWithJob answers with the job named in the path, if it belongs to the logbook or
+// logbookID is zero.
+func (s *Service) respondWithJob(c *fiber.Ctx, logbookID int64) error {
+	const op errors.Op = "server.Service.respondWithJob"
+
+	id, err := strconv.ParseInt(c.Params("id"), 10, 64)
+	if err != nil || id <= 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(jsonBadRequest)
+	}
+	j, found, err := s.fetchJob(c.UserContext(), id, logbookID)
+	if err != nil {
+		s.logger.ErrorWith().Err(errors.New(op).Err(err)).Msg("s.fetchJob failed")
+		return s.dbFailure(c, err)
+	}
+	if !found {
+		return c.Status(fiber.StatusNotFound).JSON(jsonError(errCodeJobNotFound, "Job not found"))
+	}
+	return c.JSON(fiber.Map{"job": j})
+}
@@ -0,0 +1,202 @@
+package service
+
+import (
+	"context"
+	stderr "errors"
+	"io"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/goccy/go-json"
+	"github.com/gofiber/fiber/v2"
+)
+
+// runDueJobs runs queued jobs until none is due. Retries come due at once, as the tests' retry
+// delays are shorter than the precision of the stored times.
+func runDueJobs(t *testing.T, svc *Service) {
+	t.Helper()
+	for i := 0; svc.runNextJob(context.Background()); i++ {
+		if i > 100 {
+			t.Fatal("jobs kept coming due")
+		}
+	}
+}
+
+func TestLoadJobRunner(t *testing.T) {
+	t.Setenv(envJobWorkers, "6")
+	runner, err := loadJobRunner()
+	if err != nil || runner.workers != 6 {
+		t.Fatalf("expected 6 workers, got %+v %v", runner, err)
+	}
+	t.Setenv(envJobWorkers, "none")
+	if _, err = loadJobRunner(); err == nil {
+		t.Error("expected an invalid worker count to be rejected")
+	}
+}
+
+func TestJobRunner_RetriesAndFails(t *testing.T) {
+	svc := newTestServer(t)
+	svc.jobs.retryBaseDelay, svc.jobs.retryMaxDelay = time.Millisecond, time.Millisecond
+	ctx := context.Background()
+
+	var runs int
+	var abandonedAfter int
+	svc.jobs.register("flaky", jobSpec{
+		run: func(_ context.Context, j job) (any, error) {
+			runs++
+			var payload struct {
+				SucceedOn int `json:"succeed_on"`
+			}
+			if err := j.decodePayload(&payload); err != nil {
+				return nil, err
+			}
+			if j.Attempts == payload.SucceedOn {
+				return map[string]int{"attempts": j.Attempts}, nil
+			}
+			if payload.SucceedOn < 0 {
+				return nil, permanentJobFailure(stderr.New("never"))
+			}
+			return nil, stderr.New("not yet")
+		},
+		abandoned: func(_ context.Context, j job, _ error) { abandonedAfter = j.Attempts },
+	})
+
+	succeeds, _, err := svc.enqueueJob(ctx, newJob{Kind: "flaky", LogbookID: 1, Payload: map[string]int{"succeed_on": 2}})
+	if err != nil || succeeds.Status != jobQueued || succeeds.MaxAttempts != defaultJobMaxAttempts {
+		t.Fatalf("expected a queued job, got %+v %v", succeeds, err)
+	}
+	runDueJobs(t, svc)
+	if succeeds, _, _ = svc.fetchJob(ctx, succeeds.ID, 1); succeeds.Status != jobSucceeded || succeeds.Attempts != 2 || string(succeeds.Result) != `{"attempts":2}` {
+		t.Errorf("expected the job to succeed on its second attempt, got %+v", succeeds)
+	}
+
+	exhausted, _, _ := svc.enqueueJob(ctx, newJob{Kind: "flaky", Payload: map[string]int{"succeed_on": 9}, MaxAttempts: 2})
+	permanent, _, _ := svc.enqueueJob(ctx, newJob{Kind: "flaky", Payload: map[string]int{"succeed_on": -1}})
+	runs = 0
+	runDueJobs(t, svc)
+	if runs != 3 {
+		t.Errorf("expected two attempts and one permanent failure, got %d runs", runs)
+	}
+	if exhausted, _, _ = svc.fetchJob(ctx, exhausted.ID, 0); exhausted.Status != jobFailed || exhausted.LastError != "not yet" || exhausted.FinishedAt == emptyString {
+		t.Errorf("expected the job to fail after its attempts, got %+v", exhausted)
+	}
+	if permanent, _, _ = svc.fetchJob(ctx, permanent.ID, 0); permanent.Status != jobFailed || permanent.Attempts != 1 || abandonedAfter != 1 {
+		t.Errorf("expected a permanent failure not to be retried, got %+v", permanent)
+	}
+	if _, found, _ := svc.fetchJob(ctx, permanent.ID, 1); found {
+		t.Error("expected a server job not to be found in a logbook")
+	}
+}
+
+func TestClaimJob_LeasesAndUniqueKinds(t *testing.T) {
+	svc := newTestServer(t)
+	ctx := context.Background()
+	now := time.Now()
+
+	first, created, err := svc.enqueueJob(ctx, newJob{Kind: jobKindLotwSync, LogbookID: 1})
+	if err != nil || !created {
+		t.Fatalf("enqueueJob failed: %v", err)
+	}
+	if again, created, _ := svc.enqueueJob(ctx, newJob{Kind: jobKindLotwSync, LogbookID: 1}); created || again.ID != first.ID {
+		t.Errorf("expected the queued sync to be returned, got %+v %v", again, created)
+	}
+
+	claimed, ok, err := svc.claimJob(ctx, now)
+	if err != nil || !ok || claimed.ID != first.ID || claimed.Status != jobRunning || claimed.Attempts != 1 {
+		t.Fatalf("expected to claim the job, got %+v %v %v", claimed, ok, err)
+	}
+	if _, ok, _ = svc.claimJob(ctx, now); ok {
+		t.Error("expected a leased job not to be claimed again")
+	}
+	if again, created, _ := svc.enqueueJob(ctx, newJob{Kind: jobKindLotwSync, LogbookID: 1}); created || again.ID != first.ID {
+		t.Errorf("expected the running sync to be returned, got %+v %v", again, created)
+	}
+	// A server that stops without finishing the job leaves its lease to run out.
+	if claimed, ok, _ = svc.claimJob(ctx, now.Add(svc.jobs.lease+time.Minute)); !ok || claimed.Attempts != 2 {
+		t.Errorf("expected the job to be claimed again once its lease ran out, got %+v %v", claimed, ok)
+	}
+}
+
+func TestFinishJob_LostLeaseRecordsNothing(t *testing.T) {
+	svc := newTestServer(t)
+	ctx := context.Background()
+	now := time.Now()
+
+	var abandoned atomic.Int32
+	svc.jobs.register("flaky", jobSpec{
+		run:       func(context.Context, job) (any, error) { return nil, nil },
+		abandoned: func(context.Context, job, error) { abandoned.Add(1) },
+	})
+	if _, _, err := svc.enqueueJob(ctx, newJob{Kind: "flaky", MaxAttempts: 1}); err != nil {
+		t.Fatalf("enqueueJob failed: %v", err)
+	}
+	stale, ok, err := svc.claimJob(ctx, now)
+	if err != nil || !ok {
+		t.Fatalf("expected to claim the job, got %v %v", ok, err)
+	}
+	// The first worker stalls past its lease, and a second one takes the job over.
+	current, ok, err := svc.claimJob(ctx, now.Add(svc.jobs.lease+time.Minute))
+	if err != nil || !ok || current.ID != stale.ID || current.leaseToken == stale.leaseToken {
+		t.Fatalf("expected the job to be claimed again, got %+v %v %v", current, ok, err)
+	}
+
+	svc.finishJob(ctx, stale, nil, stderr.New("stalled"))
+	svc.releaseJob(stale)
+	if j, _, _ := svc.fetchJob(ctx, stale.ID, 0); j.Status != jobRunning || j.LastError != emptyString {
+		t.Errorf("expected the stale worker's outcome to be dropped, got %+v", j)
+	}
+	if n := abandoned.Load(); n != 0 {
+		t.Errorf("expected the stale failure not to abandon the job, got %d", n)
+	}
+
+	svc.finishJob(ctx, current, "done", nil)
+	if j, _, _ := svc.fetchJob(ctx, current.ID, 0); j.Status != jobSucceeded || string(j.Result) != `"done"` {
+		t.Errorf("expected the current worker's outcome to be recorded, got %+v", j)
+	}
+}
+
+func TestJobHandlers_ScopedToLogbook(t *testing.T) {
+	svc := newTestServer(t)
+	ctx := context.Background()
+	own, _, _ := svc.enqueueJob(ctx, newJob{Kind: jobKindLotwSync, LogbookID: 1})
+	server, _, _ := svc.enqueueJob(ctx, newJob{Kind: jobKindBackup})
+
+	app := fiber.New()
+	app.Get("/jobs", withLogbook(1, svc.listJobsHandler))
+	app.Get("/jobs/:id", withLogbook(1, svc.getJobHandler))
+	app.Get("/admin/jobs", svc.adminListJobsHandler)
+
+	get := func(target string) (int, string) {
+		t.Helper()
+		resp, err := app.Test(httptest.NewRequest(fiber.MethodGet, target, nil))
+		if err != nil {
+			t.Fatalf("GET %s failed: %v", target, err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		return resp.StatusCode, string(body)
+	}
+
+	var list struct {
+		Jobs []job `json:"jobs"`
+	}
+	code, body := get("/jobs")
+	if err := json.Unmarshal([]byte(body), &list); err != nil || code != fiber.StatusOK || len(list.Jobs) != 1 || list.Jobs[0].ID != own.ID {
+		t.Errorf("expected the logbook's job only, got %d %s", code, body)
+	}
+	if code, body = get("/jobs/" + strconv.FormatInt(own.ID, 10)); code != fiber.StatusOK || !strings.Contains(body, `"kind":"lotw_sync"`) {
+		t.Errorf("expected the logbook's job, got %d %s", code, body)
+	}
+	if code, _ = get("/jobs/" + strconv.FormatInt(server.ID, 10)); code != fiber.StatusNotFound {
+		t.Errorf("expected a server job to be hidden from the logbook, got %d", code)
+	}
+	if code, _ = get("/jobs?status=bogus"); code != fiber.StatusBadRequest {
+		t.Errorf("expected an unknown status to be rejected, got %d", code)
+	}
+	if code, body = get("/admin/jobs?status=queued"); code != fiber.StatusOK || !strings.Contains(body, `"kind":"backup"`) || !strings.Contains(body, `"kind":"lotw_sync"`) {
+		t.Errorf("expected every job for the admin, got %d %s", code, body)
+	}
+}
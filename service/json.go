@@ -3,7 +3,12 @@ package service
 import "github.com/gofiber/fiber/v2"
 
 var (
-	jsonUnauthorized  = fiber.Map{"message": "Unauthorized"}
-	jsonInternalError = fiber.Map{"message": "Internal error"}
-	jsonBadRequest    = fiber.Map{"message": "Bad request"}
+	jsonUnauthorized  = jsonError(errCodeUnauthorized, "Unauthorized")
+	jsonInternalError = jsonError(errCodeInternal, "Internal error")
+	jsonBadRequest    = jsonError(errCodeBadRequest, "Bad request")
 )
+
+// jsonError is the body of an error response.
+func jsonError(code errorCode, message string) fiber.Map {
+	return fiber.Map{"code": code, "message": message}
+}
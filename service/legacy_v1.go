@@ -0,0 +1,60 @@
+package service
+
+import (
+	"github.com/Station-Manager/types"
+	"github.com/goccy/go-json"
+	"github.com/gofiber/fiber/v2"
+)
+
+// legacyRequest is the body of POST /api/v1, the single route of the server's first API. It named
+// the action to take and carried the action's payload as a JSON document in a string.
+type legacyRequest struct {
+	Action   types.RequestAction `json:"action"`
+	Callsign string              `json:"callsign"`
+	Key      string              `json:"key"`
+	Data     string              `json:"data"`
+}
+
+// legacyRoute is the route that now serves a legacy action, and the envelope field its payload
+// goes in.
+type legacyRoute struct {
+	path  string
+	field string
+}
+
+// legacyRoutes are the routes of the actions POST /api/v1 accepted.
+var legacyRoutes = map[types.RequestAction]legacyRoute{
+	types.RegisterLogbookAction: {path: "/api/logbook/register", field: "logbook"},
+	types.InsertQsoAction:       {path: "/api/qso/insert", field: "qso"},
+	deleteLogbookAction:         {path: "/api/logbook/delete", field: "logbook"},
+}
+
+// legacyDispatchHandler keeps clients of POST /api/v1 working. It rewrites the request into the
+// envelope of the action's route and routes it again, so that the request passes through the
+// same middleware and handler as one made to that route. Responses carry a Deprecation header.
+func (s *Service) legacyDispatchHandler(c *fiber.Ctx) error {
+	var request legacyRequest
+	if err := json.Unmarshal(c.Body(), &request); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(jsonBadRequest)
+	}
+	route, ok := legacyRoutes[request.Action]
+	if !ok || !json.Valid([]byte(request.Data)) {
+		s.logger.InfoWith().Str("action", request.Action.String()).Msg("Unknown legacy action")
+		return c.Status(fiber.StatusBadRequest).JSON(jsonBadRequest)
+	}
+
+	body, err := json.Marshal(map[string]any{
+		"callsign":  request.Callsign,
+		"key":       request.Key,
+		route.field: json.RawMessage(request.Data),
+	})
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(jsonBadRequest)
+	}
+
+	c.Set("Deprecation", "true")
+	c.Request().Header.SetContentType(fiber.MIMEApplicationJSON)
+	c.Request().SetBody(body)
+	c.Path(route.path)
+	return c.RestartRouting()
+}
@@ -0,0 +1,48 @@
+package service
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+func TestLegacyDispatchHandler(t *testing.T) {
+	svc := newTestServer(t)
+	app := fiber.New()
+	app.Post("/api/v1", svc.legacyDispatchHandler)
+
+	var envelope requestEnvelope
+	app.Post("/api/qso/insert", svc.requestContextMiddleware(), func(c *fiber.Ctx) error {
+		if reqCtx, err := getRequestContext(c); err == nil {
+			envelope = reqCtx.Request
+		}
+		return c.SendStatus(fiber.StatusCreated)
+	})
+
+	post := func(body string) (int, string) {
+		req := httptest.NewRequest(fiber.MethodPost, "/api/v1/", strings.NewReader(body))
+		req.Header.Set(fiber.HeaderContentType, fiber.MIMEApplicationJSON)
+		resp, err := app.Test(req)
+		if err != nil {
+			t.Fatalf("request failed: %v", err)
+		}
+		return resp.StatusCode, resp.Header.Get("Deprecation")
+	}
+
+	status, deprecation := post(`{"action":"insert_qso","callsign":"K1AB","key":"k","data":"{\"call\":\"JA1XX\",\"band\":\"20m\"}"}`)
+	if status != fiber.StatusCreated || deprecation != "true" {
+		t.Fatalf("expected the insert to reach its route, got %d (Deprecation %q)", status, deprecation)
+	}
+	if envelope.Callsign != "K1AB" || envelope.Key != "k" || envelope.Qso == nil || envelope.Qso.Call != "JA1XX" {
+		t.Errorf("expected the payload in the envelope, got %+v", envelope)
+	}
+
+	if status, _ = post(`{"action":"shred_logbook","callsign":"K1AB","key":"k","data":"{}"}`); status != fiber.StatusBadRequest {
+		t.Errorf("expected an unknown action to get 400, got %d", status)
+	}
+	if status, _ = post(`{"action":"insert_qso","callsign":"K1AB","key":"k","data":"{"}`); status != fiber.StatusBadRequest {
+		t.Errorf("expected a payload that is not JSON to get 400, got %d", status)
+	}
+}
@@ -0,0 +1,123 @@
+package service
+
+import (
+	"context"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/Station-Manager/errors"
+)
+
+// A restarting server passes its listening sockets to the new process so that no connection is
+// refused during the handover. envListenFDs lists the names of the inherited sockets, which are
+// file descriptors 3, 4, ... in that order; envReadyFD is the descriptor the new process writes
+// to once it is serving.
+const (
+	envListenFDs = "SM_LISTEN_FDS"
+	envReadyFD   = "SM_READY_FD"
+
+	listenerMain     = "main"
+	listenerRedirect = "redirect"
+
+	// firstInheritedFD is the descriptor of the first entry in exec.Cmd.ExtraFiles.
+	firstInheritedFD = 3
+)
+
+// inheritedListeners returns the sockets passed by a restarting parent, keyed by name. The
+// environment variable is cleared so that it does not leak into a later restart.
+func inheritedListeners() (map[string]net.Listener, error) {
+	const op errors.Op = "server.inheritedListeners"
+
+	listeners := make(map[string]net.Listener)
+	names := strings.TrimSpace(os.Getenv(envListenFDs))
+	if names == emptyString {
+		return listeners, nil
+	}
+	_ = os.Unsetenv(envListenFDs)
+
+	for i, name := range strings.Split(names, ",") {
+		f := os.NewFile(uintptr(firstInheritedFD+i), name)
+		ln, err := net.FileListener(f)
+		_ = f.Close()
+		if err != nil {
+			return nil, errors.New(op).Err(err).Msg("Inherited socket " + name + " is not a listener")
+		}
+		listeners[name] = ln
+	}
+	return listeners, nil
+}
+
+// listen returns the named listener, reusing the socket inherited from a restarting parent when
+// there is one. The listener is remembered so that it can be handed to the next process in turn.
+func (s *Service) listen(name, addr string) (net.Listener, error) {
+	const op errors.Op = "server.Service.listen"
+
+	s.listenersMu.Lock()
+	defer s.listenersMu.Unlock()
+
+	if s.listeners == nil {
+		s.listeners = make(map[string]net.Listener)
+	}
+	if ln, ok := s.inherited[name]; ok {
+		delete(s.inherited, name)
+		s.listeners[name] = ln
+		return ln, nil
+	}
+	network := "tcp"
+	if name == listenerMain && s.httpTuning.network != emptyString {
+		network = s.httpTuning.network
+	}
+	ln, err := net.Listen(network, addr)
+	if err != nil {
+		return nil, errors.New(op).Err(err)
+	}
+	s.listeners[name] = ln
+	return ln, nil
+}
+
+// Serving reports whether the server has started serving. An error that Start returns afterwards is
+// a runtime failure, not a failure to start.
+func (s *Service) Serving() bool {
+	return s != nil && s.serving.Load()
+}
+
+// notifyReady tells systemd that this process is serving, as the service's main process, and starts
+// the watchdog keep-alives. It then tells a restarting parent, so that it may drain and exit.
+func (s *Service) notifyReady() {
+	s.serving.Store(true)
+	s.notifySystemd("READY=1\nMAINPID=" + strconv.Itoa(os.Getpid()))
+	if interval := watchdogInterval(); interval > 0 {
+		s.runInBackground("systemd_watchdog", func(ctx context.Context) { s.runWatchdog(ctx, interval) })
+	}
+
+	value := strings.TrimSpace(os.Getenv(envReadyFD))
+	if value == emptyString {
+		return
+	}
+	_ = os.Unsetenv(envReadyFD)
+
+	fd, err := strconv.Atoi(value)
+	if err != nil {
+		s.logger.WarnWith().Str("fd", value).Msg("Invalid " + envReadyFD)
+		return
+	}
+	f := os.NewFile(uintptr(fd), "ready")
+	if _, err = f.Write([]byte{1}); err != nil {
+		s.logger.WarnWith().Err(err).Msg("Failed to notify the previous process")
+	}
+	_ = f.Close()
+}
+
+// closeUnclaimedListeners closes inherited sockets that this process's configuration does not use,
+// e.g. a redirect listener that has since been disabled.
+func (s *Service) closeUnclaimedListeners() {
+	s.listenersMu.Lock()
+	defer s.listenersMu.Unlock()
+
+	for name, ln := range s.inherited {
+		_ = ln.Close()
+		delete(s.inherited, name)
+	}
+}
@@ -0,0 +1,179 @@
+package service
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/Station-Manager/errors"
+	"github.com/Station-Manager/types"
+	"github.com/gofiber/fiber/v2"
+)
+
+// logbookDefaults are station details that a logbook applies to the QSOs inserted into it when the
+// client leaves them out, so that lightweight clients do not have to repeat static data. TimeZone
+// names the zone of clients that log local times without a UTC offset, and AmbiguousTime how
+// those clients' times in the hour the clocks repeat are read; see qsoClock.
+type logbookDefaults struct {
+	MyGridsquare  string `json:"my_gridsquare" validate:"omitempty,min=4,max=10,alphanum"`
+	TxPwr         string `json:"tx_pwr" validate:"omitempty,numeric,max=16"`
+	MyRig         string `json:"my_rig" validate:"max=255"`
+	Operator      string `json:"operator" validate:"omitempty,min=3,max=30"`
+	TimeZone      string `json:"time_zone" validate:"omitempty,timezone"`
+	AmbiguousTime string `json:"ambiguous_time" validate:"omitempty,oneof=reject earlier later"`
+}
+
+// stationFields returns the defaults as station fields.
+func (d logbookDefaults) stationFields() stationFields {
+	return stationFields{MyGridsquare: d.MyGridsquare, TxPwr: d.TxPwr, MyRig: d.MyRig, Operator: d.Operator}
+}
+
+// clock returns how the logbook reads QSO times.
+func (d logbookDefaults) clock() qsoClock {
+	clock := qsoClock{ambiguous: d.AmbiguousTime}
+	if d.TimeZone != emptyString {
+		// The zone was checked when it was saved; one this server no longer knows leaves times as UTC.
+		clock.location, _ = time.LoadLocation(d.TimeZone)
+	}
+	return clock
+}
+
+// qsoDefaults complete the QSOs logged into a logbook: station fills their empty station fields,
+// and clock reads their times.
+type qsoDefaults struct {
+	station stationFields
+	clock   qsoClock
+}
+
+// applyTo completes the QSO, failing with a *qsoTimeError if its times cannot be stored.
+func (d qsoDefaults) applyTo(qso *types.Qso, now time.Time) error {
+	d.station.applyTo(qso)
+	return d.clock.normalize(qso, now)
+}
+
+// fetchQsoDefaults returns what completes the QSOs logged into the logbook. The station fields are
+// those of the station profile named, or else the one chosen for the actor's API key, or else the
+// logbook's default profile, completed by the logbook's defaults. A non-empty operator, the member
+// logging the QSO, is the operator whatever the profile says.
+func (s *Service) fetchQsoDefaults(ctx context.Context, logbookID int64, profile, actor, operator string) (qsoDefaults, error) {
+	const op errors.Op = "server.Service.fetchQsoDefaults"
+
+	station, err := s.selectStationProfile(ctx, logbookID, profile, actor)
+	if err != nil {
+		return qsoDefaults{}, err
+	}
+	defaults, err := s.fetchLogbookDefaults(ctx, logbookID)
+	if err != nil {
+		return qsoDefaults{}, errors.New(op).Err(err)
+	}
+	station.fillFrom(defaults.stationFields())
+	if operator != emptyString {
+		station.Operator = operator
+	}
+	return qsoDefaults{station: station, clock: defaults.clock()}, nil
+}
+
+// getLogbookDefaultsHandler returns the authenticated logbook's default station fields. A logbook
+// without defaults returns empty fields.
+func (s *Service) getLogbookDefaultsHandler(c *fiber.Ctx) error {
+	const op errors.Op = "server.Service.getLogbookDefaultsHandler"
+
+	logbook, err := authenticatedLogbook(c)
+	if err != nil {
+		s.logger.ErrorWith().Err(errors.New(op).Err(err)).Msg("authenticatedLogbook failed")
+		return c.Status(fiber.StatusInternalServerError).JSON(jsonInternalError)
+	}
+
+	defaults, err := s.fetchLogbookDefaults(c.UserContext(), logbook.ID)
+	if err != nil {
+		s.logger.ErrorWith().Err(errors.New(op).Err(err)).Msg("s.fetchLogbookDefaults failed")
+		return s.dbFailure(c, err)
+	}
+	return c.JSON(defaults)
+}
+
+// putLogbookDefaultsHandler replaces the authenticated logbook's default station fields.
+func (s *Service) putLogbookDefaultsHandler(c *fiber.Ctx) error {
+	const op errors.Op = "server.Service.putLogbookDefaultsHandler"
+
+	logbook, err := authenticatedLogbook(c)
+	if err != nil {
+		s.logger.ErrorWith().Err(errors.New(op).Err(err)).Msg("authenticatedLogbook failed")
+		return c.Status(fiber.StatusInternalServerError).JSON(jsonInternalError)
+	}
+
+	var defaults logbookDefaults
+	if err = c.BodyParser(&defaults); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(jsonBadRequest)
+	}
+	defaults.MyGridsquare = strings.ToUpper(strings.TrimSpace(defaults.MyGridsquare))
+	defaults.TxPwr = strings.TrimSpace(defaults.TxPwr)
+	defaults.MyRig = strings.TrimSpace(defaults.MyRig)
+	defaults.Operator = strings.ToUpper(strings.TrimSpace(defaults.Operator))
+	defaults.TimeZone = strings.TrimSpace(defaults.TimeZone)
+	defaults.AmbiguousTime = strings.ToLower(strings.TrimSpace(defaults.AmbiguousTime))
+	if err = s.validate.Struct(defaults); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(jsonBadRequest)
+	}
+
+	if err = s.saveLogbookDefaults(c.UserContext(), logbook.ID, defaults); err != nil {
+		s.logger.ErrorWith().Err(errors.New(op).Err(err)).Msg("s.saveLogbookDefaults failed")
+		return s.dbFailure(c, err)
+	}
+	return c.JSON(defaults)
+}
+
+// deleteLogbookDefaultsHandler removes the authenticated logbook's default station fields.
+func (s *Service) deleteLogbookDefaultsHandler(c *fiber.Ctx) error {
+	const op errors.Op = "server.Service.deleteLogbookDefaultsHandler"
+
+	logbook, err := authenticatedLogbook(c)
+	if err != nil {
+		s.logger.ErrorWith().Err(errors.New(op).Err(err)).Msg("authenticatedLogbook failed")
+		return c.Status(fiber.StatusInternalServerError).JSON(jsonInternalError)
+	}
+
+	if _, err = s.db.ExecContext(c.UserContext(), `DELETE FROM logbook_defaults WHERE logbook_id = $1`, logbook.ID); err != nil {
+		s.logger.ErrorWith().Err(errors.New(op).Err(err)).Msg("s.db.ExecContext failed")
+		return s.dbFailure(c, err)
+	}
+	return c.SendStatus(fiber.StatusNoContent)
+}
+
+// fetchLogbookDefaults returns the logbook's default station fields, which are empty when none are
+// set.
+func (s *Service) fetchLogbookDefaults(ctx context.Context, logbookID int64) (logbookDefaults, error) {
+	const op errors.Op = "server.Service.fetchLogbookDefaults"
+
+	rows, err := s.db.QueryContext(ctx, `SELECT my_gridsquare, tx_pwr, my_rig, operator, time_zone, ambiguous_time FROM logbook_defaults
+		WHERE logbook_id = $1`, logbookID)
+	if err != nil {
+		return logbookDefaults{}, errors.New(op).Err(err)
+	}
+	defer func() { _ = rows.Close() }()
+
+	var defaults logbookDefaults
+	if rows.Next() {
+		if err = rows.Scan(&defaults.MyGridsquare, &defaults.TxPwr, &defaults.MyRig, &defaults.Operator, &defaults.TimeZone, &defaults.AmbiguousTime); err != nil {
+			return logbookDefaults{}, errors.New(op).Err(err)
+		}
+	}
+	if err = rows.Err(); err != nil {
+		return logbookDefaults{}, errors.New(op).Err(err)
+	}
+	return defaults, nil
+}
+
+// saveLogbookDefaults sets the logbook's default station fields.
+func (s *Service) saveLogbookDefaults(ctx context.Context, logbookID int64, defaults logbookDefaults) error {
+	const op errors.Op = "server.Service.saveLogbookDefaults"
+
+	if _, err := s.db.ExecContext(ctx, `INSERT INTO logbook_defaults (logbook_id, my_gridsquare, tx_pwr, my_rig, operator, time_zone, ambiguous_time)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		ON CONFLICT (logbook_id) DO UPDATE SET my_gridsquare = excluded.my_gridsquare, tx_pwr = excluded.tx_pwr,
+			my_rig = excluded.my_rig, operator = excluded.operator, time_zone = excluded.time_zone, ambiguous_time = excluded.ambiguous_time`,
+		logbookID, defaults.MyGridsquare, defaults.TxPwr, defaults.MyRig, defaults.Operator, defaults.TimeZone, defaults.AmbiguousTime); err != nil {
+		return errors.New(op).Err(err)
+	}
+	return nil
+}
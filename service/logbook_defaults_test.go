@@ -0,0 +1,63 @@
+package service
+
+import (
+	"context"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/Station-Manager/types"
+	"github.com/go-playground/validator/v10"
+	"github.com/gofiber/fiber/v2"
+)
+
+func TestLogbookDefaults_AppliedToImportedQsos(t *testing.T) {
+	svc := newTestServer(t)
+	svc.validate = validator.New(validator.WithRequiredStructEnabled())
+
+	app := fiber.New()
+	app.Put("/defaults", withLogbook(1, svc.putLogbookDefaultsHandler))
+	app.Post("/qsos/import", func(c *fiber.Ctx) error {
+		c.Locals(localsRequestDataKey, &requestContext{Logbook: &types.Logbook{ID: 1, Callsign: "W1AW"}, IsValid: true})
+		return svc.importQsosHandler(c)
+	})
+
+	put := func(body string) int {
+		req := httptest.NewRequest(fiber.MethodPut, "/defaults", strings.NewReader(body))
+		req.Header.Set(fiber.HeaderContentType, fiber.MIMEApplicationJSON)
+		resp, err := app.Test(req)
+		if err != nil {
+			t.Fatalf("put failed: %v", err)
+		}
+		return resp.StatusCode
+	}
+	if got := put(`{"tx_pwr":"lots"}`); got != fiber.StatusBadRequest {
+		t.Errorf("expected a non-numeric power to get 400, got %d", got)
+	}
+	if got := put(`{"my_gridsquare":"fn31pr","tx_pwr":"100","my_rig":"IC-7300","operator":"k1ab"}`); got != fiber.StatusOK {
+		t.Fatalf("expected the defaults to be saved, got %d", got)
+	}
+
+	const doc = `<EOH>
+<CALL:5>JA1XX <BAND:3>20m <MODE:3>FT8 <FREQ:6>14.074 <QSO_DATE:8>20240430 <TIME_ON:4>1203 <RST_SENT:3>-10 <RST_RCVD:3>-12 <TX_PWR:2>10 <EOR>
+`
+	if resp, err := app.Test(httptest.NewRequest(fiber.MethodPost, "/qsos/import", strings.NewReader(doc))); err != nil || resp.StatusCode != fiber.StatusOK {
+		t.Fatalf("import failed: %v", err)
+	}
+
+	ctx := context.Background()
+	ids, err := svc.listLogbookQsoIDs(ctx, 1)
+	if err != nil || len(ids) != 1 {
+		t.Fatalf("expected one QSO, got %v (err=%v)", ids, err)
+	}
+	qso, err := svc.db.FetchQsoByIdContext(ctx, ids[0])
+	if err != nil {
+		t.Fatalf("FetchQsoByIdContext failed: %v", err)
+	}
+	if qso.MyGridsquare != "FN31PR" || qso.MyRig != "IC-7300" || qso.Operator != "K1AB" {
+		t.Errorf("expected the defaults to be applied, got grid=%q rig=%q operator=%q", qso.MyGridsquare, qso.MyRig, qso.Operator)
+	}
+	if qso.TxPwr != "10" {
+		t.Errorf("expected the QSO's own power to be kept, got %q", qso.TxPwr)
+	}
+}
@@ -0,0 +1,441 @@
+package service
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/Station-Manager/apikey"
+	"github.com/Station-Manager/errors"
+	"github.com/gofiber/fiber/v2"
+)
+
+// logbookRole is what a user may do with a logbook. A logbook's creator is always its owner; other
+// users are granted a role as members, so that a club station does not need a shared login.
+type logbookRole string
+
+const (
+	// logbookRoleViewer may read the logbook's QSOs and statistics.
+	logbookRoleViewer logbookRole = "viewer"
+	// logbookRoleOperator may also log, change and delete QSOs.
+	logbookRoleOperator logbookRole = "operator"
+	// logbookRoleOwner may also change the logbook's settings and members.
+	logbookRoleOwner logbookRole = "owner"
+)
+
+var logbookRoleRanks = map[logbookRole]int{
+	logbookRoleViewer:   1,
+	logbookRoleOperator: 2,
+	logbookRoleOwner:    3,
+}
+
+// atLeast reports whether the role grants everything min grants. An unknown role grants nothing.
+func (r logbookRole) atLeast(min logbookRole) bool {
+	rank, ok := logbookRoleRanks[r]
+	return ok && rank >= logbookRoleRanks[min]
+}
+
+// logbookMember is a user and their role on a logbook.
+type logbookMember struct {
+	UserID    int64       `json:"-"`
+	Callsign  string      `json:"callsign"`
+	Role      logbookRole `json:"role"`
+	Creator   bool        `json:"creator,omitempty"`
+	CreatedAt *time.Time  `json:"created_at,omitempty"`
+}
+
+// membership is a logbook that a user holds a role on without having created it.
+type membership struct {
+	LogbookID int64       `json:"logbook_id"`
+	Name      string      `json:"name"`
+	Callsign  string      `json:"callsign"`
+	Role      logbookRole `json:"role"`
+}
+
+// apiKeyMember is the user an API key was issued to, with their current role on the key's logbook.
+// Keys issued when a logbook is registered belong to the logbook rather than a user and carry the
+// owner role, so UserID is zero for them. Sandbox is set for the sandbox keys of a logbook, whose
+// key's logbook is the sandbox.
+type apiKeyMember struct {
+	UserID   int64
+	Callsign string
+	Role     logbookRole
+	Sandbox  bool
+}
+
+// requireLogbookRole rejects requests whose role on the authenticated logbook is below min.
+func (s *Service) requireLogbookRole(min logbookRole) fiber.Handler {
+	const op errors.Op = "server.Service.requireLogbookRole"
+	if s == nil {
+		return serverErrorHandler()
+	}
+
+	return func(c *fiber.Ctx) error {
+		reqCtx, err := getRequestContext(c)
+		if err != nil {
+			s.logger.ErrorWith().Err(errors.New(op).Err(err)).Msg("getRequestContext failed")
+			return c.Status(fiber.StatusInternalServerError).JSON(jsonInternalError)
+		}
+		if !reqCtx.Role.atLeast(min) {
+			return c.Status(fiber.StatusForbidden).JSON(jsonError(errCodeInsufficientRole, "This requires the "+string(min)+" role on the logbook"))
+		}
+		return c.Next()
+	}
+}
+
+// userLogbookRoleMiddleware resolves the password-authenticated user's role on the logbook named
+// in the request, so that requireLogbookRole can check it. Users with no role on the logbook get a
+// 404, as if it did not exist.
+func (s *Service) userLogbookRoleMiddleware() fiber.Handler {
+	const op errors.Op = "server.Service.userLogbookRoleMiddleware"
+	if s == nil {
+		return serverErrorHandler()
+	}
+
+	return func(c *fiber.Ctx) error {
+		reqCtx, err := getRequestContext(c)
+		if err != nil || reqCtx.User == nil {
+			err = errors.New(op).Err(err).Msg("Request context missing")
+			s.logger.ErrorWith().Err(err).Msg("getRequestContext failed")
+			return c.Status(fiber.StatusInternalServerError).JSON(jsonInternalError)
+		}
+		if reqCtx.Request.Logbook == nil || reqCtx.Request.Logbook.ID <= 0 {
+			return c.Status(fiber.StatusBadRequest).JSON(jsonBadRequest)
+		}
+
+		role, err := s.userLogbookRole(c.UserContext(), reqCtx.User.ID, reqCtx.Request.Logbook.ID)
+		if err != nil {
+			s.logger.ErrorWith().Err(errors.New(op).Err(err)).Msg("s.userLogbookRole failed")
+			return s.dbFailure(c, err)
+		}
+		if role == emptyString {
+			return c.Status(fiber.StatusNotFound).JSON(jsonError(errCodeLogbookNotFound, "Logbook not found"))
+		}
+
+		reqCtx.Role = role
+		return c.Next()
+	}
+}
+
+// userLogbookRole returns the user's role on a logbook that is not in the trash, or an empty role
+// when they have none.
+func (s *Service) userLogbookRole(ctx context.Context, userID, logbookID int64) (logbookRole, error) {
+	const op errors.Op = "server.Service.userLogbookRole"
+
+	rows, err := s.db.QueryContext(ctx, `SELECT CASE WHEN l.user_id = $2 THEN 'owner' ELSE COALESCE(m.role, '') END
+		FROM logbook l LEFT JOIN logbook_members m ON m.logbook_id = l.id AND m.user_id = $2
+		WHERE l.id = $1 AND l.deleted_at IS NULL`, logbookID, userID)
+	if err != nil {
+		return emptyString, errors.New(op).Err(err)
+	}
+	defer func() { _ = rows.Close() }()
+
+	var role logbookRole
+	if rows.Next() {
+		if err = rows.Scan(&role); err != nil {
+			return emptyString, errors.New(op).Err(err)
+		}
+	}
+	if err = rows.Err(); err != nil {
+		return emptyString, errors.New(op).Err(err)
+	}
+	return role, nil
+}
+
+// fetchApiKeyMemberWithCache returns who the API key was issued to and their role, using an
+// in-memory cache that is invalidated together with the API key record. The role is empty when the
+// key is revoked or an admin has disabled its holder's account.
+func (s *Service) fetchApiKeyMemberWithCache(ctx context.Context, fullKey string) (apiKeyMember, error) {
+	const op errors.Op = "server.Service.fetchApiKeyMemberWithCache"
+
+	prefix, _, err := apikey.ParseApiKey(fullKey)
+	if err != nil {
+		return apiKeyMember{}, errors.New(op).Err(err)
+	}
+	member, err := s.fetchApiKeyPrefixMemberWithCache(ctx, prefix)
+	if err != nil {
+		return apiKeyMember{}, errors.New(op).Err(err)
+	}
+	return member, nil
+}
+
+// fetchApiKeyPrefixMemberWithCache is fetchApiKeyMemberWithCache for a key known by its prefix
+// alone, as that of a signed request is.
+func (s *Service) fetchApiKeyPrefixMemberWithCache(ctx context.Context, prefix string) (apiKeyMember, error) {
+	const op errors.Op = "server.Service.fetchApiKeyPrefixMemberWithCache"
+
+	if member, ok := s.apiKeyMemberCache.Get(prefix); ok {
+		return member, nil
+	}
+
+	// Revoked keys, and keys of disabled accounts, carry no role.
+	rows, err := s.db.QueryContext(ctx, `SELECT COALESCE(k.user_id, 0), COALESCE(u.callsign, ''),
+			CASE
+				WHEN k.revoked_at IS NOT NULL OR o.disabled_at IS NOT NULL OR u.disabled_at IS NOT NULL THEN ''
+				WHEN k.user_id IS NULL OR l.user_id = k.user_id THEN 'owner'
+				ELSE COALESCE(m.role, '')
+			END,
+			sb.logbook_id IS NOT NULL
+		FROM api_keys k
+			JOIN logbook l ON l.id = k.logbook_id
+			LEFT JOIN users o ON o.id = l.user_id
+			LEFT JOIN users u ON u.id = k.user_id
+			LEFT JOIN logbook_members m ON m.logbook_id = k.logbook_id AND m.user_id = k.user_id
+			LEFT JOIN sandbox_logbooks sb ON sb.logbook_id = k.logbook_id
+		WHERE k.key_prefix = $1`, prefix)
+	if err != nil {
+		return apiKeyMember{}, errors.New(op).Err(err)
+	}
+	defer func() { _ = rows.Close() }()
+
+	if !rows.Next() {
+		if err = rows.Err(); err == nil {
+			err = errors.New(op).Msg("API key not found")
+		}
+		return apiKeyMember{}, errors.New(op).Err(err)
+	}
+	var member apiKeyMember
+	if err = rows.Scan(&member.UserID, &member.Callsign, &member.Role, &member.Sandbox); err != nil {
+		return apiKeyMember{}, errors.New(op).Err(err)
+	}
+	s.apiKeyMemberCache.Set(prefix, member, defaultApiKeyCacheTTL)
+	return member, nil
+}
+
+// listLogbookMembers returns the logbook's creator followed by its members in callsign order.
+func (s *Service) listLogbookMembers(ctx context.Context, logbookID int64) ([]logbookMember, error) {
+	const op errors.Op = "server.Service.listLogbookMembers"
+
+	members := make([]logbookMember, 0)
+	rows, err := s.db.QueryContext(ctx, `SELECT u.id, u.callsign FROM logbook l JOIN users u ON u.id = l.user_id WHERE l.id = $1`, logbookID)
+	if err != nil {
+		return nil, errors.New(op).Err(err)
+	}
+	for rows.Next() {
+		creator := logbookMember{Role: logbookRoleOwner, Creator: true}
+		if err = rows.Scan(&creator.UserID, &creator.Callsign); err != nil {
+			break
+		}
+		members = append(members, creator)
+	}
+	if err == nil {
+		err = rows.Err()
+	}
+	_ = rows.Close()
+	if err != nil {
+		return nil, errors.New(op).Err(err)
+	}
+
+	rows, err = s.db.QueryContext(ctx, `SELECT u.id, u.callsign, m.role, m.created_at FROM logbook_members m JOIN users u ON u.id = m.user_id
+		WHERE m.logbook_id = $1 ORDER BY u.callsign`, logbookID)
+	if err != nil {
+		return nil, errors.New(op).Err(err)
+	}
+	defer func() { _ = rows.Close() }()
+
+	for rows.Next() {
+		var (
+			member    logbookMember
+			createdAt time.Time
+		)
+		if err = rows.Scan(&member.UserID, &member.Callsign, &member.Role, &createdAt); err != nil {
+			return nil, errors.New(op).Err(err)
+		}
+		member.CreatedAt = &createdAt
+		members = append(members, member)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, errors.New(op).Err(err)
+	}
+	return members, nil
+}
+
+// listMemberships returns the logbooks the user holds a role on as a member.
+func (s *Service) listMemberships(ctx context.Context, userID int64) ([]membership, error) {
+	const op errors.Op = "server.Service.listMemberships"
+
+	rows, err := s.db.QueryContext(ctx, `SELECT l.id, l.name, l.callsign, m.role FROM logbook_members m JOIN logbook l ON l.id = m.logbook_id
+		WHERE m.user_id = $1 AND l.deleted_at IS NULL ORDER BY l.id`, userID)
+	if err != nil {
+		return nil, errors.New(op).Err(err)
+	}
+	defer func() { _ = rows.Close() }()
+
+	memberships := make([]membership, 0)
+	for rows.Next() {
+		var m membership
+		if err = rows.Scan(&m.LogbookID, &m.Name, &m.Callsign, &m.Role); err != nil {
+			return nil, errors.New(op).Err(err)
+		}
+		memberships = append(memberships, m)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, errors.New(op).Err(err)
+	}
+	return memberships, nil
+}
+
+// fetchUserID returns the ID of the user with the callsign, and false when there is none.
+func (s *Service) fetchUserID(ctx context.Context, callsign string) (int64, bool, error) {
+	const op errors.Op = "server.Service.fetchUserID"
+
+	rows, err := s.db.QueryContext(ctx, `SELECT id FROM users WHERE callsign = $1`, callsign)
+	if err != nil {
+		return 0, false, errors.New(op).Err(err)
+	}
+	defer func() { _ = rows.Close() }()
+
+	var id int64
+	found := rows.Next()
+	if found {
+		if err = rows.Scan(&id); err != nil {
+			return 0, false, errors.New(op).Err(err)
+		}
+	}
+	if err = rows.Err(); err != nil {
+		return 0, false, errors.New(op).Err(err)
+	}
+	return id, found, nil
+}
+
+// saveLogbookMember grants the user a role on the logbook, replacing any role they had, and drops
+// the cached roles of their API keys.
+func (s *Service) saveLogbookMember(ctx context.Context, logbookID, userID int64, role logbookRole) error {
+	const op errors.Op = "server.Service.saveLogbookMember"
+
+	if _, err := s.db.ExecContext(ctx, `INSERT INTO logbook_members (logbook_id, user_id, role) VALUES ($1, $2, $3)
+		ON CONFLICT (logbook_id, user_id) DO UPDATE SET role = excluded.role`, logbookID, userID, role); err != nil {
+		return errors.New(op).Err(err)
+	}
+
+	prefixes, err := s.memberApiKeyPrefixes(ctx, logbookID, userID)
+	if err != nil {
+		return errors.New(op).Err(err)
+	}
+	for _, prefix := range prefixes {
+		s.invalidateApiKey(ctx, prefix)
+	}
+	return nil
+}
+
+// removeLogbookMember takes the user's role on the logbook away and deletes the API keys issued to
+// them for it. It reports false when the user was not a member.
+func (s *Service) removeLogbookMember(ctx context.Context, logbookID, userID int64) (bool, error) {
+	const op errors.Op = "server.Service.removeLogbookMember"
+
+	var (
+		found    bool
+		prefixes []string
+	)
+	err := s.withTx(ctx, func(tx *sql.Tx) error {
+		found, prefixes = false, nil
+
+		if txErr := queryEach(ctx, tx, func(rows *sql.Rows) error {
+			var prefix string
+			err := rows.Scan(&prefix)
+			prefixes = append(prefixes, prefix)
+			return err
+		}, `SELECT key_prefix FROM api_keys WHERE logbook_id = $1 AND user_id = $2`, logbookID, userID); txErr != nil {
+			return txErr
+		}
+		if _, txErr := tx.ExecContext(ctx, `DELETE FROM api_keys WHERE logbook_id = $1 AND user_id = $2`, logbookID, userID); txErr != nil {
+			return txErr
+		}
+		if txErr := s.checkLogbookQuota(ctx, tx, logbookID, quotaApiKeys, 1); txErr != nil {
+			return txErr
+		}
+		res, txErr := tx.ExecContext(ctx, `DELETE FROM logbook_members WHERE logbook_id = $1 AND user_id = $2`, logbookID, userID)
+		if txErr != nil {
+			return txErr
+		}
+		n, txErr := res.RowsAffected()
+		found = n > 0
+		return txErr
+	})
+	if err != nil {
+		return false, errors.New(op).Err(err)
+	}
+
+	for _, prefix := range prefixes {
+		s.invalidateApiKey(ctx, prefix)
+	}
+	return found, nil
+}
+
+// memberApiKeyPrefixes returns the prefixes of the API keys issued to the user for the logbook.
+func (s *Service) memberApiKeyPrefixes(ctx context.Context, logbookID, userID int64) ([]string, error) {
+	const op errors.Op = "server.Service.memberApiKeyPrefixes"
+
+	rows, err := s.db.QueryContext(ctx, `SELECT key_prefix FROM api_keys WHERE logbook_id = $1 AND user_id = $2`, logbookID, userID)
+	if err != nil {
+		return nil, errors.New(op).Err(err)
+	}
+	defer func() { _ = rows.Close() }()
+
+	var prefixes []string
+	for rows.Next() {
+		var prefix string
+		if err = rows.Scan(&prefix); err != nil {
+			return nil, errors.New(op).Err(err)
+		}
+		prefixes = append(prefixes, prefix)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, errors.New(op).Err(err)
+	}
+	return prefixes, nil
+}
+
+// issueMemberApiKey creates an API key for the logbook that is bound to the user, so that QSOs
+// logged with it are attributed to them. A member holds one key per logbook, so any key issued to
+// them before is replaced.
+func (s *Service) issueMemberApiKey(ctx context.Context, logbookID, userID int64, callsign string) (string, error) {
+	const op errors.Op = "server.Service.issueMemberApiKey"
+
+	var (
+		fullKey  string
+		replaced []string
+	)
+	err := s.withTx(ctx, func(tx *sql.Tx) error {
+		fullKey, replaced = emptyString, nil
+
+		if txErr := queryEach(ctx, tx, func(rows *sql.Rows) error {
+			var prefix string
+			err := rows.Scan(&prefix)
+			replaced = append(replaced, prefix)
+			return err
+		}, `SELECT key_prefix FROM api_keys WHERE logbook_id = $1 AND user_id = $2`, logbookID, userID); txErr != nil {
+			return txErr
+		}
+		if _, txErr := tx.ExecContext(ctx, `DELETE FROM api_keys WHERE logbook_id = $1 AND user_id = $2`, logbookID, userID); txErr != nil {
+			return txErr
+		}
+
+		var (
+			prefix, hash string
+			txErr        error
+		)
+		if fullKey, prefix, hash, txErr = apikey.GenerateApiKey(prefixLen); txErr != nil {
+			return txErr
+		}
+		if txErr = s.db.InsertAPIKeyWithTxContext(ctx, tx, memberApiKeyName(callsign), prefix, hash, logbookID); txErr != nil {
+			return txErr
+		}
+		_, txErr = tx.ExecContext(ctx, `UPDATE api_keys SET user_id = $1 WHERE key_prefix = $2`, userID, prefix)
+		return txErr
+	})
+	if err != nil {
+		return emptyString, errors.New(op).Err(err)
+	}
+
+	for _, prefix := range replaced {
+		s.invalidateApiKey(ctx, prefix)
+	}
+	return fullKey, nil
+}
+
+// memberApiKeyName names a member's API key apart from the logbook's own key, which is named after
+// the logbook's callsign.
+func memberApiKeyName(callsign string) string {
+	return "member:" + callsign
+}
@@ -0,0 +1,180 @@
+package service
+
+import (
+	"strings"
+
+	"github.com/Station-Manager/errors"
+	"github.com/gofiber/fiber/v2"
+)
+
+// logbookMemberRequest carries the member fields of a membership request alongside the request
+// envelope.
+type logbookMemberRequest struct {
+	Member string      `json:"member"`
+	Role   logbookRole `json:"role"`
+}
+
+// parseLogbookMemberRequest checks the member's callsign in the request envelope, and the role when
+// it is required.
+func parseLogbookMemberRequest(reqCtx *requestContext, withRole bool) (logbookMemberRequest, bool) {
+	request := reqCtx.Request.logbookMemberRequest
+	request.Member = strings.ToUpper(strings.TrimSpace(request.Member))
+	if l := len(request.Member); l < 3 || l > 32 {
+		return logbookMemberRequest{}, false
+	}
+	if _, known := logbookRoleRanks[request.Role]; withRole && !known {
+		return logbookMemberRequest{}, false
+	}
+	return request, true
+}
+
+// listLogbookMembersHandler returns the users who hold a role on the logbook named in the request.
+func (s *Service) listLogbookMembersHandler(c *fiber.Ctx) error {
+	const op errors.Op = "server.Service.listLogbookMembersHandler"
+
+	reqCtx, err := getRequestContext(c)
+	if err != nil {
+		s.logger.ErrorWith().Err(errors.New(op).Err(err)).Msg("getRequestContext failed")
+		return c.Status(fiber.StatusInternalServerError).JSON(jsonInternalError)
+	}
+
+	members, err := s.listLogbookMembers(c.UserContext(), reqCtx.Request.Logbook.ID)
+	if err != nil {
+		s.logger.ErrorWith().Err(errors.New(op).Err(err)).Msg("s.listLogbookMembers failed")
+		return s.dbFailure(c, err)
+	}
+
+	return c.JSON(fiber.Map{"members": members, "role": reqCtx.Role})
+}
+
+// grantLogbookMemberHandler grants a user a role on the logbook named in the request, or changes
+// the role they have. The logbook's creator is always its owner and cannot be given another role.
+func (s *Service) grantLogbookMemberHandler(c *fiber.Ctx) error {
+	const op errors.Op = "server.Service.grantLogbookMemberHandler"
+
+	reqCtx, err := getRequestContext(c)
+	if err != nil {
+		s.logger.ErrorWith().Err(errors.New(op).Err(err)).Msg("getRequestContext failed")
+		return c.Status(fiber.StatusInternalServerError).JSON(jsonInternalError)
+	}
+	request, ok := parseLogbookMemberRequest(reqCtx, true)
+	if !ok {
+		return c.Status(fiber.StatusBadRequest).JSON(jsonBadRequest)
+	}
+
+	ctx := c.UserContext()
+	logbookID := reqCtx.Request.Logbook.ID
+	userID, found, err := s.fetchUserID(ctx, request.Member)
+	if err != nil {
+		s.logger.ErrorWith().Err(errors.New(op).Err(err)).Msg("s.fetchUserID failed")
+		return s.dbFailure(c, err)
+	}
+	if !found {
+		return c.Status(fiber.StatusNotFound).JSON(jsonError(errCodeUserNotFound, "User not found"))
+	}
+
+	members, err := s.listLogbookMembers(ctx, logbookID)
+	if err != nil {
+		s.logger.ErrorWith().Err(errors.New(op).Err(err)).Msg("s.listLogbookMembers failed")
+		return s.dbFailure(c, err)
+	}
+	for _, m := range members {
+		if m.Creator && m.UserID == userID {
+			return c.Status(fiber.StatusBadRequest).JSON(jsonError(errCodeBadRequest, "The logbook's creator is always its owner"))
+		}
+	}
+
+	if err = s.saveLogbookMember(ctx, logbookID, userID, request.Role); err != nil {
+		s.logger.ErrorWith().Err(errors.New(op).Err(err)).Msg("s.saveLogbookMember failed")
+		return s.dbFailure(c, err)
+	}
+	s.logger.InfoWith().Int64("logbook_id", logbookID).Str("member", request.Member).Str("role", string(request.Role)).Msg("Logbook member role granted")
+
+	return c.JSON(fiber.Map{"member": logbookMember{Callsign: request.Member, Role: request.Role}})
+}
+
+// revokeLogbookMemberHandler takes a member's role on the logbook named in the request away and
+// deletes the API keys issued to them for it.
+func (s *Service) revokeLogbookMemberHandler(c *fiber.Ctx) error {
+	const op errors.Op = "server.Service.revokeLogbookMemberHandler"
+
+	reqCtx, err := getRequestContext(c)
+	if err != nil {
+		s.logger.ErrorWith().Err(errors.New(op).Err(err)).Msg("getRequestContext failed")
+		return c.Status(fiber.StatusInternalServerError).JSON(jsonInternalError)
+	}
+	request, ok := parseLogbookMemberRequest(reqCtx, false)
+	if !ok {
+		return c.Status(fiber.StatusBadRequest).JSON(jsonBadRequest)
+	}
+
+	ctx := c.UserContext()
+	logbookID := reqCtx.Request.Logbook.ID
+	userID, found, err := s.fetchUserID(ctx, request.Member)
+	if err != nil {
+		s.logger.ErrorWith().Err(errors.New(op).Err(err)).Msg("s.fetchUserID failed")
+		return s.dbFailure(c, err)
+	}
+	if found {
+		found, err = s.removeLogbookMember(ctx, logbookID, userID)
+		if err != nil {
+			s.logger.ErrorWith().Err(errors.New(op).Err(err)).Msg("s.removeLogbookMember failed")
+			return s.dbFailure(c, err)
+		}
+	}
+	if !found {
+		return c.Status(fiber.StatusNotFound).JSON(jsonError(errCodeUserNotFound, "The user is not a member of the logbook"))
+	}
+	s.logger.InfoWith().Int64("logbook_id", logbookID).Str("member", request.Member).Msg("Logbook member removed")
+
+	return c.SendStatus(fiber.StatusNoContent)
+}
+
+// issueMemberApiKeyHandler creates an API key for the logbook named in the request that is bound
+// to the authenticated user. Requests made with it carry the user's role, and the QSOs logged with
+// it name the user as operator. The key is only ever returned in this response.
+func (s *Service) issueMemberApiKeyHandler(c *fiber.Ctx) error {
+	const op errors.Op = "server.Service.issueMemberApiKeyHandler"
+
+	reqCtx, err := getRequestContext(c)
+	if err != nil || reqCtx.User == nil {
+		err = errors.New(op).Err(err).Msg("Request context missing")
+		s.logger.ErrorWith().Err(err).Msg("getRequestContext failed")
+		return c.Status(fiber.StatusInternalServerError).JSON(jsonInternalError)
+	}
+
+	logbookID := reqCtx.Request.Logbook.ID
+	fullKey, err := s.issueMemberApiKey(c.UserContext(), logbookID, reqCtx.User.ID, reqCtx.User.Callsign)
+	if body, exceeded := quotaExceededBody(err); exceeded {
+		return c.Status(fiber.StatusForbidden).JSON(body)
+	}
+	if err != nil {
+		s.logger.ErrorWith().Err(errors.New(op).Err(err)).Msg("s.issueMemberApiKey failed")
+		return s.dbFailure(c, err)
+	}
+	s.logger.InfoWith().Int64("logbook_id", logbookID).Str("member", reqCtx.User.Callsign).Msg("Member API key issued")
+	s.audit(c, auditEntry{Event: auditApiKeyCreated, Actor: reqCtx.Actor, Callsign: reqCtx.User.Callsign, LogbookID: auditLogbookID(logbookID)},
+		fiber.Map{"prefix": fullKey[:prefixLen], "role": reqCtx.Role})
+
+	return c.Status(fiber.StatusCreated).JSON(fiber.Map{"message": fullKey, "role": reqCtx.Role})
+}
+
+// listMembershipsHandler returns the logbooks the authenticated user holds a role on as a member.
+func (s *Service) listMembershipsHandler(c *fiber.Ctx) error {
+	const op errors.Op = "server.Service.listMembershipsHandler"
+
+	reqCtx, err := getRequestContext(c)
+	if err != nil || reqCtx.User == nil {
+		err = errors.New(op).Err(err).Msg("Request context missing")
+		s.logger.ErrorWith().Err(err).Msg("getRequestContext failed")
+		return c.Status(fiber.StatusInternalServerError).JSON(jsonInternalError)
+	}
+
+	memberships, err := s.listMemberships(c.UserContext(), reqCtx.User.ID)
+	if err != nil {
+		s.logger.ErrorWith().Err(errors.New(op).Err(err)).Msg("s.listMemberships failed")
+		return s.dbFailure(c, err)
+	}
+
+	return c.JSON(fiber.Map{"memberships": memberships})
+}
@@ -0,0 +1,143 @@
+package service
+
+import (
+	"context"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/Station-Manager/types"
+	"github.com/go-playground/validator/v10"
+	"github.com/goccy/go-json"
+	"github.com/gofiber/fiber/v2"
+)
+
+func TestLogbookRole_AtLeast(t *testing.T) {
+	if !logbookRoleOwner.atLeast(logbookRoleOperator) || !logbookRoleOperator.atLeast(logbookRoleOperator) {
+		t.Error("expected higher and equal roles to pass")
+	}
+	if logbookRoleViewer.atLeast(logbookRoleOperator) {
+		t.Error("expected a viewer not to pass as an operator")
+	}
+	if logbookRole("").atLeast(logbookRoleViewer) || logbookRole("admin").atLeast(logbookRoleViewer) {
+		t.Error("expected unknown roles to grant nothing")
+	}
+}
+
+func TestLogbookMembers_RolesAndAttribution(t *testing.T) {
+	svc := newTestServer(t)
+	svc.validate = validator.New(validator.WithRequiredStructEnabled())
+	svc.initializeCaches()
+	ctx := context.Background()
+	for _, query := range []string{
+		`INSERT INTO users (id, callsign, pass_hash) VALUES (7, 'W1AW', 'hash'), (8, 'K1AB', 'hash'), (9, 'N1XY', 'hash')`,
+		`UPDATE logbook SET user_id = 7 WHERE id = 1`,
+	} {
+		if _, err := svc.db.ExecContext(ctx, query); err != nil {
+			t.Fatalf("setup failed: %v", err)
+		}
+	}
+	users := map[string]int64{"W1AW": 7, "K1AB": 8, "N1XY": 9}
+
+	app := fiber.New()
+	asUser := func(c *fiber.Ctx) error {
+		callsign := c.Get("X-Callsign")
+		c.Locals(localsRequestDataKey, &requestContext{
+			Request: envelopeOf(c, &types.Logbook{ID: 1}),
+			User:    &types.User{ID: users[callsign], Callsign: callsign},
+			IsValid: true,
+		})
+		return c.Next()
+	}
+	members := app.Group("/members", asUser, svc.userLogbookRoleMiddleware())
+	members.Post("/", svc.listLogbookMembersHandler)
+	members.Post("/grant", svc.requireLogbookRole(logbookRoleOwner), svc.grantLogbookMemberHandler)
+	members.Post("/revoke", svc.requireLogbookRole(logbookRoleOwner), svc.revokeLogbookMemberHandler)
+	members.Post("/key", svc.issueMemberApiKeyHandler)
+	app.Post("/qsos/import", svc.apikeyHeaderAuthNMiddleware(), svc.requireLogbookRole(logbookRoleOperator), svc.importQsosHandler)
+
+	post := func(path, callsign, body string) (int, map[string]any) {
+		req := httptest.NewRequest(fiber.MethodPost, path, strings.NewReader(body))
+		req.Header.Set(fiber.HeaderContentType, fiber.MIMEApplicationJSON)
+		req.Header.Set("X-Callsign", callsign)
+		resp, err := app.Test(req)
+		if err != nil {
+			t.Fatalf("POST %s failed: %v", path, err)
+		}
+		var out map[string]any
+		_ = json.NewDecoder(resp.Body).Decode(&out)
+		return resp.StatusCode, out
+	}
+	importQsos := func(key string) int {
+		const doc = `<EOH>
+<CALL:5>JA1XX <BAND:3>20m <MODE:3>FT8 <FREQ:6>14.074 <QSO_DATE:8>20240430 <TIME_ON:4>1203 <RST_SENT:3>-10 <RST_RCVD:3>-12 <EOR>
+`
+		req := httptest.NewRequest(fiber.MethodPost, "/qsos/import", strings.NewReader(doc))
+		req.Header.Set(fiber.HeaderAuthorization, "Bearer "+key)
+		resp, err := app.Test(req)
+		if err != nil {
+			t.Fatalf("import failed: %v", err)
+		}
+		return resp.StatusCode
+	}
+
+	if status, _ := post("/members", "N1XY", `{}`); status != fiber.StatusNotFound {
+		t.Errorf("expected a non-member to get 404, got %d", status)
+	}
+	if status, _ := post("/members/grant", "W1AW", `{"member":"K1AB","role":"admin"}`); status != fiber.StatusBadRequest {
+		t.Errorf("expected an unknown role to get 400, got %d", status)
+	}
+	if status, _ := post("/members/grant", "W1AW", `{"member":"W1AW","role":"viewer"}`); status != fiber.StatusBadRequest {
+		t.Errorf("expected the creator's role to be fixed, got %d", status)
+	}
+	if status, _ := post("/members/grant", "W1AW", `{"member":"K1AB","role":"operator"}`); status != fiber.StatusOK {
+		t.Fatalf("expected the operator to be granted, got %d", status)
+	}
+	if status, _ := post("/members/grant", "K1AB", `{"member":"N1XY","role":"viewer"}`); status != fiber.StatusForbidden {
+		t.Errorf("expected an operator not to manage members, got %d", status)
+	}
+	if status, _ := post("/members/grant", "W1AW", `{"member":"n1xy","role":"viewer"}`); status != fiber.StatusOK {
+		t.Fatalf("expected the viewer to be granted, got %d", status)
+	}
+
+	status, out := post("/members", "N1XY", `{}`)
+	if status != fiber.StatusOK || len(out["members"].([]any)) != 3 || out["role"] != "viewer" {
+		t.Errorf("expected the creator and two members, got %d %v", status, out)
+	}
+
+	_, out = post("/members/key", "K1AB", `{}`)
+	operatorKey, _ := out["message"].(string)
+	_, out = post("/members/key", "N1XY", `{}`)
+	viewerKey, _ := out["message"].(string)
+	if operatorKey == emptyString || viewerKey == emptyString {
+		t.Fatal("expected member API keys to be issued")
+	}
+
+	if got := importQsos(viewerKey); got != fiber.StatusForbidden {
+		t.Errorf("expected a viewer's import to get 403, got %d", got)
+	}
+	if got := importQsos(operatorKey); got != fiber.StatusOK {
+		t.Fatalf("expected an operator's import to succeed, got %d", got)
+	}
+	ids, err := svc.listLogbookQsoIDs(ctx, 1)
+	if err != nil || len(ids) != 1 {
+		t.Fatalf("expected one QSO, got %v (err=%v)", ids, err)
+	}
+	qso, err := svc.db.FetchQsoByIdContext(ctx, ids[0])
+	if err != nil {
+		t.Fatalf("FetchQsoByIdContext failed: %v", err)
+	}
+	if qso.Operator != "K1AB" {
+		t.Errorf("expected the QSO to be attributed to K1AB, got %q", qso.Operator)
+	}
+
+	if status, _ = post("/members/revoke", "W1AW", `{"member":"K1AB"}`); status != fiber.StatusNoContent {
+		t.Fatalf("expected the member to be removed, got %d", status)
+	}
+	if got := importQsos(operatorKey); got != fiber.StatusUnauthorized {
+		t.Errorf("expected a removed member's key to stop working, got %d", got)
+	}
+	if status, _ = post("/members/revoke", "W1AW", `{"member":"K1AB"}`); status != fiber.StatusNotFound {
+		t.Errorf("expected a second removal to get 404, got %d", status)
+	}
+}
@@ -0,0 +1,543 @@
+package service
+
+import (
+	"context"
+	"encoding/xml"
+	stderr "errors"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Station-Manager/errors"
+	"github.com/Station-Manager/server/service/cache"
+	"github.com/Station-Manager/types"
+)
+
+const (
+	lookupProviderQrz    = "qrz"
+	lookupProviderHamqth = "hamqth"
+
+	defaultQrzURL               = "https://xmldata.qrz.com/xml/current/"
+	defaultHamqthURL            = "https://www.hamqth.com/xml.php"
+	defaultLookupRequestTimeout = 10 * time.Second
+	// defaultLookupMinInterval spaces out requests to each provider; both ask clients not to
+	// issue bursts of queries.
+	defaultLookupMinInterval = 500 * time.Millisecond
+	lookupMaxResponseBytes   = 1 << 20
+
+	// Callsign data rarely changes, so lookups (including misses) are kept for a day.
+	defaultLookupCacheTTL        = 24 * time.Hour
+	defaultLookupCacheMaxEntries = 8192
+)
+
+// callsignInfo is the subset of a callsign lookup that is copied into QSOs. Found is false for
+// callsigns the provider does not know, which are cached as well.
+type callsignInfo struct {
+	Found      bool
+	Name       string
+	QTH        string
+	Country    string
+	Gridsquare string
+	DXCC       string
+	CQZ        string
+	ITUZ       string
+	Cont       string
+	Email      string
+}
+
+// lookupAccount holds the callsign lookup provider and credentials of a logbook. Password is encrypted.
+type lookupAccount struct {
+	LogbookID int64
+	Provider  string
+	Username  string
+	Password  string
+	LastError string
+}
+
+// errLookupSessionExpired is returned by a provider query when the session key is no longer valid.
+var errLookupSessionExpired = stderr.New("lookup session expired")
+
+// rateLimiter enforces a minimum interval between requests.
+type rateLimiter struct {
+	mu       sync.Mutex
+	interval time.Duration
+	next     time.Time
+}
+
+// wait blocks until the caller may issue its request, or ctx is done.
+func (r *rateLimiter) wait(ctx context.Context) error {
+	r.mu.Lock()
+	now := time.Now()
+	at := r.next
+	if at.Before(now) {
+		at = now
+	}
+	r.next = at.Add(r.interval)
+	r.mu.Unlock()
+
+	if delay := time.Until(at); delay > 0 {
+		timer := time.NewTimer(delay)
+		defer timer.Stop()
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-timer.C:
+		}
+	}
+	return nil
+}
+
+// reserve takes the next request slot if it has come at now, without waiting. Otherwise it
+// reports when the slot comes.
+func (r *rateLimiter) reserve(now time.Time) (time.Time, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.next.After(now) {
+		return r.next, false
+	}
+	r.next = now.Add(r.interval)
+	return now, true
+}
+
+// idleAt returns when a request can next be made without waiting.
+func (r *rateLimiter) idleAt() time.Time {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.next
+}
+
+// lookupClient queries QRZ.com and HamQTH for callsign data, caching the results and keeping one
+// session per provider account.
+type lookupClient struct {
+	urls     map[string]string
+	client   *http.Client
+	limiters map[string]*rateLimiter
+	cache    *cache.Cache[string, callsignInfo]
+
+	mu       sync.Mutex
+	sessions map[string]string // provider + "|" + username -> session key
+}
+
+func newLookupClient() *lookupClient {
+	return &lookupClient{
+		urls:   map[string]string{lookupProviderQrz: defaultQrzURL, lookupProviderHamqth: defaultHamqthURL},
+		client: &http.Client{Timeout: defaultLookupRequestTimeout},
+		limiters: map[string]*rateLimiter{
+			lookupProviderQrz:    {interval: defaultLookupMinInterval},
+			lookupProviderHamqth: {interval: defaultLookupMinInterval},
+		},
+		cache:    cache.New[string, callsignInfo](defaultLookupCacheMaxEntries, defaultLookupCacheTTL),
+		sessions: make(map[string]string),
+	}
+}
+
+// lookup returns the provider's data for call, logging in when there is no session yet or the
+// current one has expired.
+func (l *lookupClient) lookup(ctx context.Context, provider, username, password, call string) (callsignInfo, error) {
+	const op errors.Op = "server.lookupClient.lookup"
+
+	call = strings.ToUpper(strings.TrimSpace(call))
+	cacheKey := provider + ":" + call
+	if info, ok := l.cache.Get(cacheKey); ok {
+		return info, nil
+	}
+
+	sessionKey := provider + "|" + username
+	l.mu.Lock()
+	session := l.sessions[sessionKey]
+	l.mu.Unlock()
+
+	var info callsignInfo
+	var err error
+	for attempt := 0; attempt < 2; attempt++ {
+		if session == emptyString {
+			if session, err = l.login(ctx, provider, username, password); err != nil {
+				return callsignInfo{}, errors.New(op).Err(err)
+			}
+			l.mu.Lock()
+			l.sessions[sessionKey] = session
+			l.mu.Unlock()
+		}
+
+		if info, err = l.query(ctx, provider, session, call); !stderr.Is(err, errLookupSessionExpired) {
+			break
+		}
+		session = emptyString
+	}
+	if err != nil {
+		return callsignInfo{}, errors.New(op).Err(err)
+	}
+
+	l.cache.Set(cacheKey, info, 0)
+	return info, nil
+}
+
+func (l *lookupClient) login(ctx context.Context, provider, username, password string) (string, error) {
+	const op errors.Op = "server.lookupClient.login"
+
+	switch provider {
+	case lookupProviderQrz:
+		var resp qrzResponse
+		if err := l.get(ctx, provider, url.Values{"username": {username}, "password": {password}, "agent": {adifProgramID}}, &resp); err != nil {
+			return emptyString, errors.New(op).Err(err)
+		}
+		if resp.Session.Key == emptyString {
+			return emptyString, errors.New(op).Msg(lookupError("QRZ", resp.Session.Error))
+		}
+		return resp.Session.Key, nil
+	case lookupProviderHamqth:
+		var resp hamqthResponse
+		if err := l.get(ctx, provider, url.Values{"u": {username}, "p": {password}}, &resp); err != nil {
+			return emptyString, errors.New(op).Err(err)
+		}
+		if resp.Session.ID == emptyString {
+			return emptyString, errors.New(op).Msg(lookupError("HamQTH", resp.Session.Error))
+		}
+		return resp.Session.ID, nil
+	default:
+		return emptyString, errors.New(op).Errorf("Unsupported lookup provider: %s", provider)
+	}
+}
+
+func (l *lookupClient) query(ctx context.Context, provider, session, call string) (callsignInfo, error) {
+	const op errors.Op = "server.lookupClient.query"
+
+	switch provider {
+	case lookupProviderQrz:
+		var resp qrzResponse
+		if err := l.get(ctx, provider, url.Values{"s": {session}, "callsign": {call}}, &resp); err != nil {
+			return callsignInfo{}, errors.New(op).Err(err)
+		}
+		if resp.Callsign != nil {
+			return resp.Callsign.info(), nil
+		}
+		switch msg := resp.Session.Error; {
+		case strings.HasPrefix(msg, "Not found"):
+			return callsignInfo{}, nil
+		case resp.Session.Key == emptyString || strings.Contains(msg, "Session Timeout") || strings.Contains(msg, "Invalid session key"):
+			return callsignInfo{}, errLookupSessionExpired
+		default:
+			return callsignInfo{}, errors.New(op).Msg(lookupError("QRZ", msg))
+		}
+	case lookupProviderHamqth:
+		var resp hamqthResponse
+		if err := l.get(ctx, provider, url.Values{"id": {session}, "callsign": {call}, "prg": {adifProgramID}}, &resp); err != nil {
+			return callsignInfo{}, errors.New(op).Err(err)
+		}
+		if resp.Search != nil {
+			return resp.Search.info(), nil
+		}
+		switch msg := resp.Session.Error; {
+		case strings.Contains(msg, "not found"):
+			return callsignInfo{}, nil
+		case strings.Contains(msg, "Session does not exist or expired"):
+			return callsignInfo{}, errLookupSessionExpired
+		default:
+			return callsignInfo{}, errors.New(op).Msg(lookupError("HamQTH", msg))
+		}
+	default:
+		return callsignInfo{}, errors.New(op).Errorf("Unsupported lookup provider: %s", provider)
+	}
+}
+
+// get issues a rate-limited GET to the provider and decodes the XML response into v.
+func (l *lookupClient) get(ctx context.Context, provider string, params url.Values, v interface{}) error {
+	const op errors.Op = "server.lookupClient.get"
+
+	if err := l.limiters[provider].wait(ctx); err != nil {
+		return errors.New(op).Err(err)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, l.urls[provider]+"?"+params.Encode(), nil)
+	if err != nil {
+		return errors.New(op).Err(err)
+	}
+
+	resp, err := l.client.Do(req)
+	if err != nil {
+		// The URL may carry the password; never let it reach the logs.
+		var urlErr *url.Error
+		if stderr.As(err, &urlErr) {
+			err = urlErr.Err
+		}
+		return errors.New(op).Err(err).Msg("Callsign lookup failed")
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	if resp.StatusCode != http.StatusOK {
+		return errors.New(op).Errorf("Callsign lookup returned status %d", resp.StatusCode)
+	}
+	if err = xml.NewDecoder(io.LimitReader(resp.Body, lookupMaxResponseBytes)).Decode(v); err != nil {
+		return errors.New(op).Err(err).Msg("Callsign lookup returned an invalid response")
+	}
+	return nil
+}
+
+func lookupError(provider, msg string) string {
+	if msg == emptyString {
+		return provider + " lookup failed"
+	}
+	return provider + ": " + msg
+}
+
+// qrzResponse is the QRZ XML data service response.
+type qrzResponse struct {
+	Callsign *qrzCallsign `xml:"Callsign"`
+	Session  struct {
+		Key   string `xml:"Key"`
+		Error string `xml:"Error"`
+	} `xml:"Session"`
+}
+
+type qrzCallsign struct {
+	FirstName string `xml:"fname"`
+	Name      string `xml:"name"`
+	City      string `xml:"addr2"`
+	Land      string `xml:"land"` // DXCC entity name; Country is the mailing address country
+	Country   string `xml:"country"`
+	Grid      string `xml:"grid"`
+	DXCC      string `xml:"dxcc"`
+	CQZone    string `xml:"cqzone"`
+	ITUZone   string `xml:"ituzone"`
+	Email     string `xml:"email"`
+}
+
+func (c *qrzCallsign) info() callsignInfo {
+	country := c.Land
+	if country == emptyString {
+		country = c.Country
+	}
+	return callsignInfo{
+		Found:      true,
+		Name:       strings.TrimSpace(c.FirstName + " " + c.Name),
+		QTH:        c.City,
+		Country:    country,
+		Gridsquare: c.Grid,
+		DXCC:       c.DXCC,
+		CQZ:        c.CQZone,
+		ITUZ:       c.ITUZone,
+		Email:      c.Email,
+	}
+}
+
+// hamqthResponse is the HamQTH XML interface response.
+type hamqthResponse struct {
+	Session struct {
+		ID    string `xml:"session_id"`
+		Error string `xml:"error"`
+	} `xml:"session"`
+	Search *hamqthSearch `xml:"search"`
+}
+
+type hamqthSearch struct {
+	Nick      string `xml:"nick"`
+	Name      string `xml:"adr_name"`
+	QTH       string `xml:"qth"`
+	Country   string `xml:"country"`
+	DXCC      string `xml:"adif"`
+	ITUZone   string `xml:"itu"`
+	CQZone    string `xml:"cq"`
+	Grid      string `xml:"grid"`
+	Continent string `xml:"continent"`
+	Email     string `xml:"email"`
+}
+
+func (h *hamqthSearch) info() callsignInfo {
+	name := h.Name
+	if name == emptyString {
+		name = h.Nick
+	}
+	return callsignInfo{
+		Found:      true,
+		Name:       name,
+		QTH:        h.QTH,
+		Country:    h.Country,
+		Gridsquare: h.Grid,
+		DXCC:       h.DXCC,
+		CQZ:        h.CQZone,
+		ITUZ:       h.ITUZone,
+		Cont:       h.Continent,
+		Email:      h.Email,
+	}
+}
+
+// fillFromLookup copies the lookup data into the QSO's empty fields; values that were logged are
+// never overwritten. It reports whether anything changed.
+func fillFromLookup(qso *types.Qso, info callsignInfo) bool {
+	changed := false
+	fill := func(field *string, value string) {
+		if *field == emptyString && value != emptyString {
+			*field = value
+			changed = true
+		}
+	}
+	fill(&qso.Name, info.Name)
+	fill(&qso.QTH, info.QTH)
+	fill(&qso.Country, info.Country)
+	fill(&qso.Gridsquare, info.Gridsquare)
+	fill(&qso.DXCC, info.DXCC)
+	fill(&qso.CQZ, info.CQZ)
+	fill(&qso.ITUZ, info.ITUZ)
+	fill(&qso.Cont, info.Cont)
+	fill(&qso.Email, info.Email)
+	return changed
+}
+
+// enqueueCallsignLookup queues newly inserted QSOs for enrichment on the delivery pool. It is
+// registered with the QSO event broker and must not block; if the queue is full the QSO is not
+// enriched and this is logged.
+func (s *Service) enqueueCallsignLookup(event qsoEvent) {
+	if event.Type != qsoEventInserted {
+		return
+	}
+	if !s.deliveries.submit(s.callsignLookupTask(event.LogbookID, event.Qso.ID, event.Qso.Call)) {
+		s.logger.WarnWith().Int64("qso_id", event.Qso.ID).Msg("Callsign lookup queue full; QSO not enriched")
+	}
+}
+
+// callsignLookupTask returns the enrichment of a QSO as a task of the delivery pool, retried with
+// the pool's backoff. This is the only path to the lookup providers: QRZ.com and HamQTH lookups
+// alike run under the callsign_lookup destination, whose in-flight limit bounds the workers they
+// hold. The provider is only known once the task has read the logbook's account, so the requests
+// are spaced out by lookupClient's limiter of each provider, and the destination has no interval.
+func (s *Service) callsignLookupTask(logbookID, qsoID int64, call string) *deliveryTask {
+	return &deliveryTask{
+		destination: deliveryDestinationLookup,
+		run: func(ctx context.Context, attempt int) error {
+			err := s.enrichQso(ctx, logbookID, qsoID, call)
+			if err != nil {
+				s.logger.WarnWith().Err(err).Int64("qso_id", qsoID).Int("attempt", attempt).Msg("QSO enrichment failed")
+			}
+			return err
+		},
+	}
+}
+
+// enrichQso fills the QSO's missing contacted-station fields from the logbook's lookup provider
+// and announces the change. Lookup failures are recorded on the account.
+func (s *Service) enrichQso(ctx context.Context, logbookID, qsoID int64, call string) error {
+	const op errors.Op = "server.Service.enrichQso"
+
+	account, found, err := s.fetchLookupAccount(ctx, logbookID)
+	if err != nil {
+		return errors.New(op).Err(err)
+	}
+	if !found {
+		return nil
+	}
+
+	info, err := s.lookupCallsign(ctx, account, call)
+	lastError := emptyString
+	if err != nil {
+		lastError = errors.Root(err).Error()
+	}
+	if lastError != account.LastError {
+		if saveErr := s.saveLookupError(ctx, logbookID, lastError); saveErr != nil {
+			s.logger.ErrorWith().Err(saveErr).Int64("logbook_id", logbookID).Msg("Failed to save lookup state")
+		}
+	}
+	if err != nil {
+		return errors.New(op).Err(err)
+	}
+	if !info.Found {
+		return nil
+	}
+
+	qso, err := s.db.FetchQsoByIdContext(ctx, qsoID)
+	if err != nil {
+		return errors.New(op).Err(err)
+	}
+	before := qso
+	if !fillFromLookup(&qso, info) {
+		return nil
+	}
+	if err = s.db.UpdateQsoContext(ctx, qso); err != nil {
+		return errors.New(op).Err(err)
+	}
+	s.recordQsoChange(ctx, qsoHistoryUpdate, actorLookup, before, qso)
+
+	s.publishQsoUpdated(ctx, qsoID)
+	return nil
+}
+
+func (s *Service) lookupCallsign(ctx context.Context, account lookupAccount, call string) (callsignInfo, error) {
+	const op errors.Op = "server.Service.lookupCallsign"
+
+	password, err := s.decryptCredential(account.Password)
+	if err != nil {
+		return callsignInfo{}, errors.New(op).Err(err)
+	}
+	info, err := s.lookup.lookup(ctx, account.Provider, account.Username, password, call)
+	if err != nil {
+		return callsignInfo{}, errors.New(op).Err(err)
+	}
+	return info, nil
+}
+
+// fetchLookupAccount returns the logbook's lookup account, if one is configured.
+func (s *Service) fetchLookupAccount(ctx context.Context, logbookID int64) (lookupAccount, bool, error) {
+	const op errors.Op = "server.Service.fetchLookupAccount"
+
+	rows, err := s.db.QueryContext(ctx, `SELECT logbook_id, provider, username, password, last_error
+		FROM lookup_accounts WHERE logbook_id = $1`, logbookID)
+	if err != nil {
+		return lookupAccount{}, false, errors.New(op).Err(err)
+	}
+	defer func() { _ = rows.Close() }()
+
+	if !rows.Next() {
+		if err = rows.Err(); err != nil {
+			return lookupAccount{}, false, errors.New(op).Err(err)
+		}
+		return lookupAccount{}, false, nil
+	}
+	var a lookupAccount
+	if err = rows.Scan(&a.LogbookID, &a.Provider, &a.Username, &a.Password, &a.LastError); err != nil {
+		return lookupAccount{}, false, errors.New(op).Err(err)
+	}
+	return a, true, nil
+}
+
+// saveLookupAccount stores the logbook's lookup provider and credentials.
+func (s *Service) saveLookupAccount(ctx context.Context, logbookID int64, provider, username, password string) error {
+	const op errors.Op = "server.Service.saveLookupAccount"
+
+	encrypted, err := s.encryptCredential(password)
+	if err != nil {
+		return errors.New(op).Err(err)
+	}
+
+	if _, err = s.db.ExecContext(ctx, `INSERT INTO lookup_accounts (logbook_id, provider, username, password) VALUES ($1, $2, $3, $4)
+		ON CONFLICT (logbook_id) DO UPDATE SET
+			provider = excluded.provider, username = excluded.username, password = excluded.password, last_error = ''`,
+		logbookID, provider, username, encrypted); err != nil {
+		return errors.New(op).Err(err)
+	}
+	return nil
+}
+
+func (s *Service) saveLookupError(ctx context.Context, logbookID int64, lastError string) error {
+	const op errors.Op = "server.Service.saveLookupError"
+
+	if _, err := s.db.ExecContext(ctx, `UPDATE lookup_accounts SET last_error = $2 WHERE logbook_id = $1`, logbookID, lastError); err != nil {
+		return errors.New(op).Err(err)
+	}
+	return nil
+}
+
+// deleteLookupAccount removes the logbook's lookup account. QSOs already enriched are unchanged.
+func (s *Service) deleteLookupAccount(ctx context.Context, logbookID int64) (bool, error) {
+	const op errors.Op = "server.Service.deleteLookupAccount"
+
+	res, err := s.db.ExecContext(ctx, `DELETE FROM lookup_accounts WHERE logbook_id = $1`, logbookID)
+	if err != nil {
+		return false, errors.New(op).Err(err)
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return false, errors.New(op).Err(err)
+	}
+	return n > 0, nil
+}
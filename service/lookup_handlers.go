@@ -0,0 +1,93 @@
+package service
+
+import (
+	"github.com/Station-Manager/errors"
+	"github.com/gofiber/fiber/v2"
+)
+
+// lookupAccountRequest is the body of a request to configure callsign lookups for a logbook.
+type lookupAccountRequest struct {
+	Provider string `json:"provider" validate:"required,oneof=qrz hamqth"`
+	Username string `json:"username" validate:"required,max=64"`
+	Password string `json:"password" validate:"required,max=256"`
+}
+
+// getLookupAccountHandler reports which lookup provider is configured for the authenticated
+// logbook and the last lookup error, if any. The password is never returned.
+func (s *Service) getLookupAccountHandler(c *fiber.Ctx) error {
+	const op errors.Op = "server.Service.getLookupAccountHandler"
+
+	logbook, err := authenticatedLogbook(c)
+	if err != nil {
+		s.logger.ErrorWith().Err(errors.New(op).Err(err)).Msg("authenticatedLogbook failed")
+		return c.Status(fiber.StatusInternalServerError).JSON(jsonInternalError)
+	}
+
+	account, found, err := s.fetchLookupAccount(c.UserContext(), logbook.ID)
+	if err != nil {
+		s.logger.ErrorWith().Err(errors.New(op).Err(err)).Msg("s.fetchLookupAccount failed")
+		return s.dbFailure(c, err)
+	}
+	if !found {
+		return c.JSON(fiber.Map{"configured": false})
+	}
+
+	return c.JSON(fiber.Map{
+		"configured": true,
+		"provider":   account.Provider,
+		"username":   account.Username,
+		"last_error": account.LastError,
+	})
+}
+
+// putLookupAccountHandler stores lookup credentials for the authenticated logbook. QSOs inserted
+// from then on have their missing contacted-station fields filled in; earlier QSOs are not.
+func (s *Service) putLookupAccountHandler(c *fiber.Ctx) error {
+	const op errors.Op = "server.Service.putLookupAccountHandler"
+
+	logbook, err := authenticatedLogbook(c)
+	if err != nil {
+		s.logger.ErrorWith().Err(errors.New(op).Err(err)).Msg("authenticatedLogbook failed")
+		return c.Status(fiber.StatusInternalServerError).JSON(jsonInternalError)
+	}
+	if len(s.credentialsKey) == 0 {
+		return c.Status(fiber.StatusServiceUnavailable).JSON(jsonError(errCodeCredentialStorageDisabled, "Credential storage is not configured on this server"))
+	}
+
+	var request lookupAccountRequest
+	if err = c.BodyParser(&request); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(jsonBadRequest)
+	}
+	if err = s.validate.Struct(request); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(jsonBadRequest)
+	}
+
+	if err = s.saveLookupAccount(c.UserContext(), logbook.ID, request.Provider, request.Username, request.Password); err != nil {
+		s.logger.ErrorWith().Err(errors.New(op).Err(err)).Msg("s.saveLookupAccount failed")
+		return s.dbFailure(c, err)
+	}
+
+	return c.SendStatus(fiber.StatusNoContent)
+}
+
+// deleteLookupAccountHandler removes the authenticated logbook's lookup credentials.
+func (s *Service) deleteLookupAccountHandler(c *fiber.Ctx) error {
+	const op errors.Op = "server.Service.deleteLookupAccountHandler"
+
+	logbook, err := authenticatedLogbook(c)
+	if err != nil {
+		s.logger.ErrorWith().Err(errors.New(op).Err(err)).Msg("authenticatedLogbook failed")
+		return c.Status(fiber.StatusInternalServerError).JSON(jsonInternalError)
+	}
+
+	found, err := s.deleteLookupAccount(c.UserContext(), logbook.ID)
+	if err != nil {
+		s.logger.ErrorWith().Err(errors.New(op).Err(err)).Msg("s.deleteLookupAccount failed")
+		return s.dbFailure(c, err)
+	}
+	if !found {
+		return c.Status(fiber.StatusNotFound).JSON(jsonError(errCodeIntegrationNotConfigured, "Callsign lookup is not configured"))
+	}
+
+	return c.SendStatus(fiber.StatusNoContent)
+}
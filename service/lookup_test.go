@@ -0,0 +1,171 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// newFakeQrz points svc's lookup client at a fake QRZ server and returns its login count. The
+// fake expires the first session after one query so that re-login is exercised.
+func newFakeQrz(t *testing.T, svc *Service) *atomic.Int32 {
+	t.Helper()
+
+	svc.credentialsKey = make([]byte, 32)
+	svc.lookup = newLookupClient()
+	for _, l := range svc.lookup.limiters {
+		l.interval = 0
+	}
+
+	var logins, queries atomic.Int32
+	qrz := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		if q.Get("username") != "" {
+			if q.Get("password") != "hunter2" {
+				_, _ = fmt.Fprint(w, `<QRZDatabase><Session><Error>Username/password incorrect</Error></Session></QRZDatabase>`)
+				return
+			}
+			n := logins.Add(1)
+			_, _ = fmt.Fprintf(w, `<QRZDatabase xmlns="http://xmldata.qrz.com"><Session><Key>key%d</Key></Session></QRZDatabase>`, n)
+			return
+		}
+		if queries.Add(1) == 2 && q.Get("s") == "key1" {
+			_, _ = fmt.Fprint(w, `<QRZDatabase><Session><Error>Session Timeout</Error></Session></QRZDatabase>`)
+			return
+		}
+		switch q.Get("callsign") {
+		case "JA1XX", "K1AB":
+			_, _ = fmt.Fprintf(w, `<QRZDatabase xmlns="http://xmldata.qrz.com"><Callsign><call>%s</call><fname>Taro</fname><name>Yamada</name>
+				<addr2>Tokyo</addr2><land>Japan</land><country>Japan</country><grid>PM95</grid><dxcc>339</dxcc><cqzone>25</cqzone>
+				<ituzone>45</ituzone></Callsign><Session><Key>%s</Key></Session></QRZDatabase>`, q.Get("callsign"), q.Get("s"))
+		default:
+			_, _ = fmt.Fprintf(w, `<QRZDatabase><Session><Key>%s</Key><Error>Not found: %s</Error></Session></QRZDatabase>`, q.Get("s"), q.Get("callsign"))
+		}
+	}))
+	t.Cleanup(qrz.Close)
+	svc.lookup.urls[lookupProviderQrz] = qrz.URL
+
+	return &logins
+}
+
+func TestEnrichQso_FillsMissingFields(t *testing.T) {
+	svc := newTestServer(t)
+	logins := newFakeQrz(t, svc)
+	ctx := context.Background()
+	qsoID := insertTestQso(t, svc, "JA1XX", "20m", "FT8", "20240430", "1203")
+
+	qso, err := svc.db.FetchQsoByIdContext(ctx, qsoID)
+	if err != nil {
+		t.Fatalf("FetchQsoByIdContext failed: %v", err)
+	}
+	qso.Gridsquare = "PM96"
+	if err = svc.db.UpdateQsoContext(ctx, qso); err != nil {
+		t.Fatalf("UpdateQsoContext failed: %v", err)
+	}
+
+	// Without an account nothing happens.
+	if err = svc.enrichQso(ctx, 1, qsoID, "JA1XX"); err != nil || logins.Load() != 0 {
+		t.Fatalf("expected no lookup without an account, got %v (logins=%d)", err, logins.Load())
+	}
+
+	if err = svc.saveLookupAccount(ctx, 1, lookupProviderQrz, "7Q5MLV", "hunter2"); err != nil {
+		t.Fatalf("saveLookupAccount failed: %v", err)
+	}
+	sub := svc.qsoEvents.Subscribe(1)
+	defer svc.qsoEvents.Unsubscribe(sub)
+
+	if err = svc.enrichQso(ctx, 1, qsoID, "JA1XX"); err != nil {
+		t.Fatalf("enrichQso failed: %v", err)
+	}
+	if qso, err = svc.db.FetchQsoByIdContext(ctx, qsoID); err != nil {
+		t.Fatalf("FetchQsoByIdContext failed: %v", err)
+	}
+	if qso.Name != "Taro Yamada" || qso.QTH != "Tokyo" || qso.Country != "Japan" || qso.CQZ != "25" {
+		t.Errorf("expected the QSO to be enriched, got %+v", qso.ContactedStation)
+	}
+	if qso.Gridsquare != "PM96" {
+		t.Errorf("expected the logged grid to be kept, got %q", qso.Gridsquare)
+	}
+	select {
+	case event := <-sub.Events():
+		if event.Type != qsoEventUpdated || event.Qso.Name != "Taro Yamada" {
+			t.Errorf("unexpected event %+v", event)
+		}
+	default:
+		t.Error("expected a qso.updated event")
+	}
+}
+
+func TestLookupClient_CachesAndRenewsSession(t *testing.T) {
+	svc := newTestServer(t)
+	logins := newFakeQrz(t, svc)
+	ctx := context.Background()
+
+	info, err := svc.lookup.lookup(ctx, lookupProviderQrz, "7Q5MLV", "hunter2", "ja1xx")
+	if err != nil || !info.Found || info.DXCC != "339" {
+		t.Fatalf("unexpected lookup %+v (%v)", info, err)
+	}
+	// Served from the cache.
+	if _, err = svc.lookup.lookup(ctx, lookupProviderQrz, "7Q5MLV", "hunter2", "JA1XX"); err != nil || logins.Load() != 1 {
+		t.Fatalf("expected a cached result, got %v (logins=%d)", err, logins.Load())
+	}
+	// The fake expires the first session on the second query.
+	if info, err = svc.lookup.lookup(ctx, lookupProviderQrz, "7Q5MLV", "hunter2", "K1AB"); err != nil || !info.Found {
+		t.Fatalf("expected the lookup to succeed after re-login, got %+v (%v)", info, err)
+	}
+	if logins.Load() != 2 {
+		t.Errorf("expected 2 logins, got %d", logins.Load())
+	}
+	if info, err = svc.lookup.lookup(ctx, lookupProviderQrz, "7Q5MLV", "hunter2", "N0CALL"); err != nil || info.Found {
+		t.Errorf("expected an unknown callsign, got %+v (%v)", info, err)
+	}
+}
+
+func TestEnrichQso_RecordsLoginFailure(t *testing.T) {
+	svc := newTestServer(t)
+	newFakeQrz(t, svc)
+	ctx := context.Background()
+	qsoID := insertTestQso(t, svc, "JA1XX", "20m", "FT8", "20240430", "1203")
+
+	if err := svc.saveLookupAccount(ctx, 1, lookupProviderQrz, "7Q5MLV", "wrong"); err != nil {
+		t.Fatalf("saveLookupAccount failed: %v", err)
+	}
+	if err := svc.enrichQso(ctx, 1, qsoID, "JA1XX"); err == nil {
+		t.Fatal("expected enrichQso to fail")
+	}
+	account, _, _ := svc.fetchLookupAccount(ctx, 1)
+	if account.LastError != "QRZ: Username/password incorrect" {
+		t.Errorf("expected the failure to be recorded, got %q", account.LastError)
+	}
+}
+
+func TestHamqthSearchInfo(t *testing.T) {
+	info := (&hamqthSearch{Nick: "Petr", QTH: "Prague", Country: "Czech Republic", DXCC: "503", Continent: "EU"}).info()
+	if !info.Found || info.Name != "Petr" || info.Cont != "EU" || info.DXCC != "503" {
+		t.Errorf("unexpected info %+v", info)
+	}
+}
+
+func TestRateLimiter_SpacesRequests(t *testing.T) {
+	r := &rateLimiter{interval: 20 * time.Millisecond}
+	start := time.Now()
+	for i := 0; i < 3; i++ {
+		if err := r.wait(context.Background()); err != nil {
+			t.Fatalf("wait failed: %v", err)
+		}
+	}
+	if elapsed := time.Since(start); elapsed < 40*time.Millisecond {
+		t.Errorf("expected requests to be spaced out, took %v", elapsed)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	r.next = time.Now().Add(time.Hour)
+	if err := r.wait(ctx); err == nil {
+		t.Error("expected wait to honour a cancelled context")
+	}
+}
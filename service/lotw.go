@@ -0,0 +1,327 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	stderr "errors"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/Station-Manager/errors"
+	"github.com/Station-Manager/server/service/adif"
+)
+
+const (
+	defaultLotwReportURL    = "https://lotw.arrl.org/lotwuser/lotwreport.adi"
+	defaultLotwSyncInterval = 6 * time.Hour
+	// LoTW builds reports on demand and large ones take a while.
+	defaultLotwRequestTimeout = 2 * time.Minute
+	// defaultLotwMinInterval spaces out the report downloads of the accounts synced at once; each
+	// one has LoTW build a report.
+	defaultLotwMinInterval = 2 * time.Second
+	lotwMaxReportBytes     = 64 << 20
+)
+
+// lotwAccount holds a logbook's LoTW credentials and sync progress. Password is encrypted.
+type lotwAccount struct {
+	LogbookID  int64
+	Username   string
+	Password   string
+	QslSince   string // LoTW's APP_LoTW_LASTQSL from the previous report
+	LastSyncAt sql.NullTime
+	LastError  string
+}
+
+// lotwSyncResult summarises a single sync.
+type lotwSyncResult struct {
+	Confirmations int `json:"confirmations"` // QSL records in the report
+	Matched       int `json:"matched"`       // records matched to a QSO in the logbook
+	New           int `json:"new"`           // matched QSOs that were not confirmed before
+}
+
+// lotwClient downloads confirmation reports from LoTW.
+type lotwClient struct {
+	reportURL    string
+	client       *http.Client
+	syncInterval time.Duration
+}
+
+func newLotwClient() *lotwClient {
+	return &lotwClient{
+		reportURL:    defaultLotwReportURL,
+		client:       &http.Client{Timeout: defaultLotwRequestTimeout},
+		syncInterval: defaultLotwSyncInterval,
+	}
+}
+
+// fetchReport downloads the QSL report for ownCall, limited to confirmations received since
+// qslSince when it is set.
+func (l *lotwClient) fetchReport(ctx context.Context, username, password, ownCall, qslSince string) (adif.Document, error) {
+	const op errors.Op = "server.lotwClient.fetchReport"
+
+	params := url.Values{}
+	params.Set("login", username)
+	params.Set("password", password)
+	params.Set("qso_query", "1")
+	params.Set("qso_qsl", "yes")
+	params.Set("qso_qsldetail", "yes")
+	params.Set("qso_owncall", ownCall)
+	if qslSince != emptyString {
+		params.Set("qso_qslsince", qslSince)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, l.reportURL+"?"+params.Encode(), nil)
+	if err != nil {
+		return adif.Document{}, errors.New(op).Err(err)
+	}
+	resp, err := l.client.Do(req)
+	if err != nil {
+		// The URL carries the password; never let it reach the logs.
+		var urlErr *url.Error
+		if stderr.As(err, &urlErr) {
+			err = urlErr.Err
+		}
+		return adif.Document{}, errors.New(op).Err(err).Msg("LoTW request failed")
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	if resp.StatusCode != http.StatusOK {
+		return adif.Document{}, errors.New(op).Errorf("LoTW returned status %d", resp.StatusCode)
+	}
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, lotwMaxReportBytes))
+	if err != nil {
+		return adif.Document{}, errors.New(op).Err(err)
+	}
+	// LoTW answers a failed login with an HTML page rather than an error status.
+	if !bytes.Contains(bytes.ToLower(body), []byte("<eoh>")) {
+		return adif.Document{}, errors.New(op).Msg("LoTW did not return a report; check the username and password")
+	}
+
+	doc, err := adif.Parse(bytes.NewReader(body))
+	if err != nil {
+		return adif.Document{}, errors.New(op).Err(err)
+	}
+	return doc, nil
+}
+
+// queueLotwSyncs queues a sync of every configured LoTW account.
+func (s *Service) queueLotwSyncs(ctx context.Context) error {
+	const op errors.Op = "server.Service.queueLotwSyncs"
+
+	accounts, err := s.listLotwAccounts(ctx)
+	if err != nil {
+		return errors.New(op).Err(err)
+	}
+	for _, account := range accounts {
+		if _, _, err = s.enqueueJob(ctx, newJob{Kind: jobKindLotwSync, LogbookID: account.LogbookID}); err != nil {
+			s.logger.ErrorWith().Err(errors.New(op).Err(err)).Int64("logbook_id", account.LogbookID).Msg("Failed to queue LoTW sync")
+		}
+	}
+	return nil
+}
+
+// runLotwSyncJob syncs the LoTW account of the job's logbook.
+func (s *Service) runLotwSyncJob(ctx context.Context, j job) (any, error) {
+	const op errors.Op = "server.Service.runLotwSyncJob"
+
+	account, found, err := s.fetchLotwAccount(ctx, j.LogbookID)
+	if err != nil {
+		return nil, errors.New(op).Err(err)
+	}
+	if !found {
+		return nil, permanentJobFailure(errors.New(op).Msg("LoTW is not configured"))
+	}
+	return s.syncLotwAccount(ctx, account)
+}
+
+// syncLotwAccount downloads new confirmations for the account's logbook and records those that
+// match a logged QSO. The outcome is saved on the account either way.
+func (s *Service) syncLotwAccount(ctx context.Context, account lotwAccount) (lotwSyncResult, error) {
+	const op errors.Op = "server.Service.syncLotwAccount"
+
+	result, qslSince, err := s.doLotwSync(ctx, account)
+	if err != nil {
+		err = errors.New(op).Err(err)
+		lastError := errors.Root(err).Error()
+		if saveErr := s.saveLotwSyncState(ctx, account.LogbookID, account.QslSince, lastError); saveErr != nil {
+			s.logger.ErrorWith().Err(saveErr).Int64("logbook_id", account.LogbookID).Msg("Failed to save LoTW sync state")
+		}
+		return result, err
+	}
+
+	if err = s.saveLotwSyncState(ctx, account.LogbookID, qslSince, emptyString); err != nil {
+		return result, errors.New(op).Err(err)
+	}
+	s.logger.InfoWith().Int64("logbook_id", account.LogbookID).Int("confirmations", result.Confirmations).
+		Int("matched", result.Matched).Int("new", result.New).Msg("LoTW sync completed")
+
+	return result, nil
+}
+
+func (s *Service) doLotwSync(ctx context.Context, account lotwAccount) (lotwSyncResult, string, error) {
+	const op errors.Op = "server.Service.doLotwSync"
+	var result lotwSyncResult
+
+	password, err := s.decryptCredential(account.Password)
+	if err != nil {
+		return result, emptyString, errors.New(op).Err(err)
+	}
+	logbook, err := s.fetchLogbookWithCache(ctx, account.LogbookID)
+	if err != nil {
+		return result, emptyString, errors.New(op).Err(err)
+	}
+
+	if err = s.deliveries.wait(ctx, deliveryDestinationLotw); err != nil {
+		return result, emptyString, errors.New(op).Err(err)
+	}
+	doc, err := s.lotw.fetchReport(ctx, account.Username, password, logbook.Callsign, account.QslSince)
+	if err != nil {
+		return result, emptyString, errors.New(op).Err(err)
+	}
+
+	for _, rec := range doc.Records {
+		if !strings.EqualFold(rec["QSL_RCVD"], "Y") {
+			continue
+		}
+		result.Confirmations++
+
+		key := qsoKey{Call: rec["CALL"], Band: rec["BAND"], Mode: rec["MODE"], QsoDate: rec["QSO_DATE"], TimeOn: rec["TIME_ON"]}
+		qsoID, found, err := s.findMatchingQso(ctx, logbook.ID, key)
+		if err != nil {
+			return result, emptyString, errors.New(op).Err(err)
+		}
+		if !found {
+			continue
+		}
+		result.Matched++
+
+		credit := rec["CREDIT_GRANTED"]
+		if credit == emptyString {
+			credit = rec["APP_LOTW_CREDIT_GRANTED"]
+		}
+		isNew, err := s.upsertQsoConfirmation(ctx, qsoID, qsoConfirmation{
+			Source:        confirmationSourceLotw,
+			ReceivedDate:  rec["QSLRDATE"],
+			CreditGranted: credit,
+		})
+		if err != nil {
+			return result, emptyString, errors.New(op).Err(err)
+		}
+		if isNew {
+			result.New++
+			s.publishQsoUpdated(ctx, qsoID)
+		}
+	}
+
+	qslSince := doc.Header["APP_LOTW_LASTQSL"]
+	if qslSince == emptyString {
+		qslSince = account.QslSince
+	}
+	return result, qslSince, nil
+}
+
+// publishQsoUpdated notifies stream and webhook subscribers that a QSO has changed, e.g. because
+// it has been confirmed or enriched.
+func (s *Service) publishQsoUpdated(ctx context.Context, qsoID int64) {
+	qso, err := s.db.FetchQsoByIdContext(ctx, qsoID)
+	if err != nil {
+		s.logger.WarnWith().Err(err).Int64("qso_id", qsoID).Msg("Failed to fetch confirmed QSO")
+		return
+	}
+	if confirmations, err := s.listQsoConfirmations(ctx, qsoID); err == nil {
+		applyConfirmations(&qso, confirmations)
+	}
+	s.publishQsoEvent(ctx, qsoEventUpdated, qso)
+}
+
+// fetchLotwAccount returns the logbook's LoTW account, if one is configured.
+func (s *Service) fetchLotwAccount(ctx context.Context, logbookID int64) (lotwAccount, bool, error) {
+	const op errors.Op = "server.Service.fetchLotwAccount"
+
+	accounts, err := s.queryLotwAccounts(ctx, `WHERE logbook_id = $1`, logbookID)
+	if err != nil {
+		return lotwAccount{}, false, errors.New(op).Err(err)
+	}
+	if len(accounts) == 0 {
+		return lotwAccount{}, false, nil
+	}
+	return accounts[0], true, nil
+}
+
+func (s *Service) listLotwAccounts(ctx context.Context) ([]lotwAccount, error) {
+	return s.queryLotwAccounts(ctx, `WHERE logbook_id IN `+liveLogbookIDs+` ORDER BY logbook_id`)
+}
+
+func (s *Service) queryLotwAccounts(ctx context.Context, clause string, args ...interface{}) ([]lotwAccount, error) {
+	const op errors.Op = "server.Service.queryLotwAccounts"
+
+	rows, err := s.db.QueryContext(ctx, `SELECT logbook_id, username, password, qsl_since, last_sync_at, last_error
+		FROM lotw_accounts `+clause, args...)
+	if err != nil {
+		return nil, errors.New(op).Err(err)
+	}
+	defer func() { _ = rows.Close() }()
+
+	accounts := make([]lotwAccount, 0)
+	for rows.Next() {
+		var a lotwAccount
+		if err = rows.Scan(&a.LogbookID, &a.Username, &a.Password, &a.QslSince, &a.LastSyncAt, &a.LastError); err != nil {
+			return nil, errors.New(op).Err(err)
+		}
+		accounts = append(accounts, a)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, errors.New(op).Err(err)
+	}
+	return accounts, nil
+}
+
+// saveLotwAccount stores the logbook's LoTW credentials. The sync position is kept unless the
+// username changes, in which case the next sync starts from the beginning.
+func (s *Service) saveLotwAccount(ctx context.Context, logbookID int64, username, password string) error {
+	const op errors.Op = "server.Service.saveLotwAccount"
+
+	encrypted, err := s.encryptCredential(password)
+	if err != nil {
+		return errors.New(op).Err(err)
+	}
+
+	if _, err = s.db.ExecContext(ctx, `INSERT INTO lotw_accounts (logbook_id, username, password) VALUES ($1, $2, $3)
+		ON CONFLICT (logbook_id) DO UPDATE SET
+			qsl_since = CASE WHEN lotw_accounts.username = excluded.username THEN lotw_accounts.qsl_since ELSE '' END,
+			username = excluded.username, password = excluded.password, last_error = ''`,
+		logbookID, username, encrypted); err != nil {
+		return errors.New(op).Err(err)
+	}
+	return nil
+}
+
+func (s *Service) saveLotwSyncState(ctx context.Context, logbookID int64, qslSince, lastError string) error {
+	const op errors.Op = "server.Service.saveLotwSyncState"
+
+	if _, err := s.db.ExecContext(ctx, `UPDATE lotw_accounts SET qsl_since = $2, last_error = $3, last_sync_at = CURRENT_TIMESTAMP
+		WHERE logbook_id = $1`, logbookID, qslSince, lastError); err != nil {
+		return errors.New(op).Err(err)
+	}
+	return nil
+}
+
+// deleteLotwAccount removes the logbook's LoTW account. Confirmations already recorded are kept.
+func (s *Service) deleteLotwAccount(ctx context.Context, logbookID int64) (bool, error) {
+	const op errors.Op = "server.Service.deleteLotwAccount"
+
+	res, err := s.db.ExecContext(ctx, `DELETE FROM lotw_accounts WHERE logbook_id = $1`, logbookID)
+	if err != nil {
+		return false, errors.New(op).Err(err)
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return false, errors.New(op).Err(err)
+	}
+	return n > 0, nil
+}
@@ -0,0 +1,119 @@
+package service
+
+import (
+	"github.com/Station-Manager/errors"
+	"github.com/gofiber/fiber/v2"
+)
+
+// lotwAccountRequest is the body of a request to configure LoTW for a logbook.
+type lotwAccountRequest struct {
+	Username string `json:"username" validate:"required,max=64"`
+	Password string `json:"password" validate:"required,max=256"`
+}
+
+// getLotwAccountHandler reports whether LoTW is configured for the authenticated logbook and how
+// the last sync went. The password is never returned.
+func (s *Service) getLotwAccountHandler(c *fiber.Ctx) error {
+	const op errors.Op = "server.Service.getLotwAccountHandler"
+
+	logbook, err := authenticatedLogbook(c)
+	if err != nil {
+		s.logger.ErrorWith().Err(errors.New(op).Err(err)).Msg("authenticatedLogbook failed")
+		return c.Status(fiber.StatusInternalServerError).JSON(jsonInternalError)
+	}
+
+	account, found, err := s.fetchLotwAccount(c.UserContext(), logbook.ID)
+	if err != nil {
+		s.logger.ErrorWith().Err(errors.New(op).Err(err)).Msg("s.fetchLotwAccount failed")
+		return s.dbFailure(c, err)
+	}
+	if !found {
+		return c.JSON(fiber.Map{"configured": false})
+	}
+
+	status := fiber.Map{"configured": true, "username": account.Username, "last_error": account.LastError}
+	if account.LastSyncAt.Valid {
+		status["last_sync_at"] = account.LastSyncAt.Time
+	}
+	return c.JSON(status)
+}
+
+// putLotwAccountHandler stores LoTW credentials for the authenticated logbook.
+func (s *Service) putLotwAccountHandler(c *fiber.Ctx) error {
+	const op errors.Op = "server.Service.putLotwAccountHandler"
+
+	logbook, err := authenticatedLogbook(c)
+	if err != nil {
+		s.logger.ErrorWith().Err(errors.New(op).Err(err)).Msg("authenticatedLogbook failed")
+		return c.Status(fiber.StatusInternalServerError).JSON(jsonInternalError)
+	}
+	if len(s.credentialsKey) == 0 {
+		return c.Status(fiber.StatusServiceUnavailable).JSON(jsonError(errCodeCredentialStorageDisabled, "Credential storage is not configured on this server"))
+	}
+
+	var request lotwAccountRequest
+	if err = c.BodyParser(&request); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(jsonBadRequest)
+	}
+	if err = s.validate.Struct(request); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(jsonBadRequest)
+	}
+
+	if err = s.saveLotwAccount(c.UserContext(), logbook.ID, request.Username, request.Password); err != nil {
+		s.logger.ErrorWith().Err(errors.New(op).Err(err)).Msg("s.saveLotwAccount failed")
+		return s.dbFailure(c, err)
+	}
+
+	return c.SendStatus(fiber.StatusNoContent)
+}
+
+// deleteLotwAccountHandler removes the authenticated logbook's LoTW credentials.
+func (s *Service) deleteLotwAccountHandler(c *fiber.Ctx) error {
+	const op errors.Op = "server.Service.deleteLotwAccountHandler"
+
+	logbook, err := authenticatedLogbook(c)
+	if err != nil {
+		s.logger.ErrorWith().Err(errors.New(op).Err(err)).Msg("authenticatedLogbook failed")
+		return c.Status(fiber.StatusInternalServerError).JSON(jsonInternalError)
+	}
+
+	found, err := s.deleteLotwAccount(c.UserContext(), logbook.ID)
+	if err != nil {
+		s.logger.ErrorWith().Err(errors.New(op).Err(err)).Msg("s.deleteLotwAccount failed")
+		return s.dbFailure(c, err)
+	}
+	if !found {
+		return c.Status(fiber.StatusNotFound).JSON(jsonError(errCodeIntegrationNotConfigured, "LoTW is not configured"))
+	}
+
+	return c.SendStatus(fiber.StatusNoContent)
+}
+
+// syncLotwHandler queues a LoTW sync of the authenticated logbook and answers with its job. A sync
+// already waiting or running is answered instead of queueing another.
+func (s *Service) syncLotwHandler(c *fiber.Ctx) error {
+	const op errors.Op = "server.Service.syncLotwHandler"
+
+	logbook, err := authenticatedLogbook(c)
+	if err != nil {
+		s.logger.ErrorWith().Err(errors.New(op).Err(err)).Msg("authenticatedLogbook failed")
+		return c.Status(fiber.StatusInternalServerError).JSON(jsonInternalError)
+	}
+
+	account, found, err := s.fetchLotwAccount(c.UserContext(), logbook.ID)
+	if err != nil {
+		s.logger.ErrorWith().Err(errors.New(op).Err(err)).Msg("s.fetchLotwAccount failed")
+		return s.dbFailure(c, err)
+	}
+	if !found {
+		return c.Status(fiber.StatusNotFound).JSON(jsonError(errCodeIntegrationNotConfigured, "LoTW is not configured"))
+	}
+
+	j, _, err := s.enqueueJob(c.UserContext(), newJob{Kind: jobKindLotwSync, LogbookID: account.LogbookID})
+	if err != nil {
+		s.logger.ErrorWith().Err(errors.New(op).Err(err)).Msg("s.enqueueJob failed")
+		return s.dbFailure(c, err)
+	}
+
+	return c.Status(fiber.StatusAccepted).JSON(fiber.Map{"job": j})
+}
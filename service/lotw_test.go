@@ -0,0 +1,204 @@
+package service
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+const testLotwReport = `ARRL Logbook of the World Status Report
+<APP_LoTW_LASTQSL:19>2024-05-01 09:00:00
+<eoh>
+<CALL:5>JA1XX <BAND:3>20M <MODE:4>MFSK <QSO_DATE:8>20240430 <TIME_ON:6>121000 <QSL_RCVD:1>Y <QSLRDATE:8>20240501 <eor>
+<CALL:4>K1AB <BAND:3>40M <MODE:2>CW <QSO_DATE:8>20240430 <TIME_ON:6>130000 <QSL_RCVD:1>Y <QSLRDATE:8>20240501 <eor>
+<CALL:4>K1AB <BAND:3>20M <MODE:3>SSB <QSO_DATE:8>20240430 <TIME_ON:6>140000 <QSL_RCVD:1>N <eor>
+`
+
+func newFakeLotw(t *testing.T, svc *Service, report string) *[]string {
+	t.Helper()
+
+	svc.credentialsKey = make([]byte, 32)
+	svc.lotw = newLotwClient()
+
+	var queries []string
+	lotw := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		queries = append(queries, r.URL.RawQuery)
+		_, _ = io.WriteString(w, report)
+	}))
+	t.Cleanup(lotw.Close)
+	svc.lotw.reportURL = lotw.URL
+
+	return &queries
+}
+
+func TestModeGroup(t *testing.T) {
+	cases := map[string]string{"CW": "CW", "usb": "PHONE", "SSB": "PHONE", "FT8": "DATA", "MFSK": "DATA", "RTTY": "DATA"}
+	for mode, want := range cases {
+		if got := modeGroup(mode); got != want {
+			t.Errorf("modeGroup(%q): expected %q, got %q", mode, want, got)
+		}
+	}
+}
+
+func TestSyncLotwAccount_MatchesConfirmations(t *testing.T) {
+	svc := newTestServer(t)
+	queries := newFakeLotw(t, svc, testLotwReport)
+	ctx := context.Background()
+
+	ft8 := insertTestQso(t, svc, "JA1XX", "20m", "FT8", "20240430", "1203")
+	// Same call, but outside the matching window.
+	far := insertTestQso(t, svc, "K1AB", "40m", "CW", "20240430", "1400")
+	// Not confirmed in the report.
+	ssb := insertTestQso(t, svc, "K1AB", "20m", "USB", "20240430", "1400")
+
+	sub := svc.qsoEvents.Subscribe(1)
+	defer svc.qsoEvents.Unsubscribe(sub)
+
+	if err := svc.saveLotwAccount(ctx, 1, "7Q5MLV", "hunter2"); err != nil {
+		t.Fatalf("saveLotwAccount failed: %v", err)
+	}
+	account, found, err := svc.fetchLotwAccount(ctx, 1)
+	if err != nil || !found {
+		t.Fatalf("fetchLotwAccount failed: %v (found=%v)", err, found)
+	}
+	if account.Password == "hunter2" {
+		t.Fatal("expected the password to be stored encrypted")
+	}
+
+	result, err := svc.syncLotwAccount(ctx, account)
+	if err != nil {
+		t.Fatalf("syncLotwAccount failed: %v", err)
+	}
+	if result != (lotwSyncResult{Confirmations: 2, Matched: 1, New: 1}) {
+		t.Errorf("unexpected result %+v", result)
+	}
+	if q := (*queries)[0]; !strings.Contains(q, "password=hunter2") || !strings.Contains(q, "qso_owncall=7Q5MLV") || strings.Contains(q, "qso_qslsince") {
+		t.Errorf("unexpected first report query %q", q)
+	}
+
+	for id, want := range map[int64]int{ft8: 1, far: 0, ssb: 0} {
+		confirmations, err := svc.listQsoConfirmations(ctx, id)
+		if err != nil {
+			t.Fatalf("listQsoConfirmations failed: %v", err)
+		}
+		if len(confirmations) != want {
+			t.Errorf("QSO %d: expected %d confirmations, got %+v", id, want, confirmations)
+		}
+	}
+
+	select {
+	case event := <-sub.Events():
+		if event.Type != qsoEventUpdated || event.Qso.ID != ft8 || event.Qso.QslRcvd != "Y" {
+			t.Errorf("unexpected event %+v", event)
+		}
+	default:
+		t.Error("expected a qso.updated event for the new confirmation")
+	}
+
+	// The next sync resumes from LoTW's last QSL time and does not re-announce confirmations.
+	account, _, _ = svc.fetchLotwAccount(ctx, 1)
+	if account.QslSince != "2024-05-01 09:00:00" || !account.LastSyncAt.Valid {
+		t.Errorf("unexpected sync state %+v", account)
+	}
+	if result, err = svc.syncLotwAccount(ctx, account); err != nil || result.New != 0 {
+		t.Errorf("expected no new confirmations on resync, got %+v (%v)", result, err)
+	}
+	if q := (*queries)[1]; !strings.Contains(q, "qso_qslsince=2024-05-01+09%3A00%3A00") {
+		t.Errorf("expected the second query to resume, got %q", q)
+	}
+}
+
+func TestSyncLotwAccount_RecordsLoginFailure(t *testing.T) {
+	svc := newTestServer(t)
+	newFakeLotw(t, svc, "<html><body>Username/password incorrect</body></html>")
+	ctx := context.Background()
+
+	if err := svc.saveLotwAccount(ctx, 1, "7Q5MLV", "wrong"); err != nil {
+		t.Fatalf("saveLotwAccount failed: %v", err)
+	}
+	account, _, _ := svc.fetchLotwAccount(ctx, 1)
+
+	if _, err := svc.syncLotwAccount(ctx, account); err == nil {
+		t.Fatal("expected the sync to fail")
+	}
+	account, _, _ = svc.fetchLotwAccount(ctx, 1)
+	if !strings.Contains(account.LastError, "username and password") {
+		t.Errorf("expected the failure to be recorded, got %q", account.LastError)
+	}
+}
+
+func TestLotwHandlers(t *testing.T) {
+	svc := newTestServer(t)
+	newFakeLotw(t, svc, testLotwReport)
+	qsoID := insertTestQso(t, svc, "JA1XX", "20m", "FT8", "20240430", "1210")
+
+	app := fiber.New()
+	app.Get("/lotw", withLogbook(1, svc.getLotwAccountHandler))
+	app.Put("/lotw", withLogbook(1, svc.putLotwAccountHandler))
+	app.Post("/lotw/sync", withLogbook(1, svc.syncLotwHandler))
+	app.Get("/qsos/:id", withLogbook(1, svc.getQsoHandler))
+	app.Get("/other/qsos/:id", withLogbook(2, svc.getQsoHandler))
+
+	do := func(method, target, body string) (int, string) {
+		t.Helper()
+		req := httptest.NewRequest(method, target, strings.NewReader(body))
+		req.Header.Set(fiber.HeaderContentType, fiber.MIMEApplicationJSON)
+		resp, err := app.Test(req)
+		if err != nil {
+			t.Fatalf("fiber test request failed: %v", err)
+		}
+		b, _ := io.ReadAll(resp.Body)
+		return resp.StatusCode, string(b)
+	}
+
+	if code, _ := do("POST", "/lotw/sync", emptyString); code != fiber.StatusNotFound {
+		t.Errorf("expected sync without an account to return %d, got %d", fiber.StatusNotFound, code)
+	}
+	if code, _ := do("PUT", "/lotw", `{"username":"7Q5MLV","password":"hunter2"}`); code != fiber.StatusNoContent {
+		t.Fatalf("expected status %d, got %d", fiber.StatusNoContent, code)
+	}
+	if code, body := do("GET", "/lotw", emptyString); code != fiber.StatusOK || strings.Contains(body, "hunter2") || !strings.Contains(body, `"configured":true`) {
+		t.Errorf("unexpected status response %d: %s", code, body)
+	}
+	if code, body := do("POST", "/lotw/sync", emptyString); code != fiber.StatusAccepted || !strings.Contains(body, `"kind":"lotw_sync"`) {
+		t.Errorf("unexpected sync response %d: %s", code, body)
+	}
+	runDueJobs(t, svc)
+	if jobs, _ := svc.listJobs(context.Background(), 1, jobSucceeded, 1); len(jobs) != 1 || !strings.Contains(string(jobs[0].Result), `"new":1`) {
+		t.Errorf("expected the sync job to succeed with one new confirmation, got %+v", jobs)
+	}
+
+	id := strconv.FormatInt(qsoID, 10)
+	code, body := do("GET", "/qsos/"+id, emptyString)
+	if code != fiber.StatusOK || !strings.Contains(body, `"source":"lotw"`) || !strings.Contains(body, `"qsl_rcvd":"Y"`) {
+		t.Errorf("unexpected QSO response %d: %s", code, body)
+	}
+	if code, _ = do("GET", "/other/qsos/"+id, emptyString); code != fiber.StatusNotFound {
+		t.Errorf("expected another logbook's QSO to be hidden, got %d", code)
+	}
+}
+
+func TestLotwHandlers_RequireCredentialsKey(t *testing.T) {
+	svc := newTestServer(t)
+	newFakeLotw(t, svc, testLotwReport)
+	svc.credentialsKey = nil
+
+	app := fiber.New()
+	app.Put("/lotw", withLogbook(1, svc.putLotwAccountHandler))
+
+	req := httptest.NewRequest("PUT", "/lotw", strings.NewReader(`{"username":"7Q5MLV","password":"hunter2"}`))
+	req.Header.Set(fiber.HeaderContentType, fiber.MIMEApplicationJSON)
+	resp, err := app.Test(req)
+	if err != nil {
+		t.Fatalf("fiber test request failed: %v", err)
+	}
+	if resp.StatusCode != fiber.StatusServiceUnavailable {
+		t.Errorf("expected status %d, got %d", fiber.StatusServiceUnavailable, resp.StatusCode)
+	}
+}
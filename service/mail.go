@@ -0,0 +1,131 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"mime"
+	"net"
+	"net/smtp"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/Station-Manager/errors"
+)
+
+// Environment variables configuring the SMTP server the server sends email through. Email is
+// unavailable when SM_SMTP_ADDR is not set; the username and password are only needed when the
+// SMTP server asks for them.
+const (
+	envSmtpAddr     = "SM_SMTP_ADDR" // host:port
+	envSmtpFrom     = "SM_SMTP_FROM"
+	envSmtpUsername = "SM_SMTP_USERNAME"
+	envSmtpPassword = "SM_SMTP_PASSWORD"
+)
+
+// mailer sends plain-text email through an SMTP server.
+type mailer struct {
+	addr     string
+	from     string
+	username string
+	password string
+	// sendMail is smtp.SendMail, replaced in tests.
+	sendMail func(addr string, auth smtp.Auth, from string, to []string, msg []byte) error
+}
+
+// loadMailer returns the mailer configured in the environment, or nil when none is.
+func loadMailer() (*mailer, error) {
+	const op errors.Op = "server.loadMailer"
+
+	addr := strings.TrimSpace(os.Getenv(envSmtpAddr))
+	if addr == emptyString {
+		return nil, nil
+	}
+	if _, _, err := net.SplitHostPort(addr); err != nil {
+		return nil, errors.New(op).Msg(envSmtpAddr + " must be a host:port")
+	}
+	from := strings.TrimSpace(os.Getenv(envSmtpFrom))
+	if from == emptyString {
+		return nil, errors.New(op).Msg(envSmtpFrom + " must be set with " + envSmtpAddr)
+	}
+	return &mailer{
+		addr:     addr,
+		from:     from,
+		username: strings.TrimSpace(os.Getenv(envSmtpUsername)),
+		password: os.Getenv(envSmtpPassword),
+		sendMail: smtp.SendMail,
+	}, nil
+}
+
+// enabled reports whether the server can send email.
+func (m *mailer) enabled() bool {
+	return m != nil
+}
+
+// email is the payload of an email job.
+type email struct {
+	To      string `json:"to"`
+	Subject string `json:"subject"`
+	Body    string `json:"body"`
+}
+
+// send delivers the message. net/smtp only authenticates over TLS or to localhost, so credentials
+// are never sent in the clear.
+func (m *mailer) send(message email) error {
+	const op errors.Op = "server.mailer.send"
+
+	var auth smtp.Auth
+	if m.username != emptyString {
+		host, _, _ := net.SplitHostPort(m.addr)
+		auth = smtp.PlainAuth(emptyString, m.username, m.password, host)
+	}
+
+	// The recipient is validated as an address when it is saved; stripping line breaks from the
+	// headers keeps a subject built from a spot from adding headers of its own.
+	header := strings.NewReplacer("\r", emptyString, "\n", " ")
+	var msg bytes.Buffer
+	msg.WriteString("From: " + header.Replace(m.from) + "\r\n")
+	msg.WriteString("To: " + header.Replace(message.To) + "\r\n")
+	msg.WriteString("Subject: " + mime.QEncoding.Encode("utf-8", header.Replace(message.Subject)) + "\r\n")
+	msg.WriteString("Date: " + time.Now().UTC().Format(time.RFC1123Z) + "\r\n")
+	msg.WriteString("MIME-Version: 1.0\r\n")
+	msg.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	msg.WriteString("\r\n")
+	msg.WriteString(strings.ReplaceAll(strings.ReplaceAll(message.Body, "\r\n", "\n"), "\n", "\r\n"))
+
+	if err := m.sendMail(m.addr, auth, m.from, []string{message.To}, msg.Bytes()); err != nil {
+		return errors.New(op).Err(err)
+	}
+	return nil
+}
+
+// enqueueEmail queues an email to be sent by a background job, which retries it if the SMTP
+// server cannot be reached.
+func (s *Service) enqueueEmail(ctx context.Context, logbookID int64, message email) error {
+	const op errors.Op = "server.Service.enqueueEmail"
+
+	if !s.mailer.enabled() {
+		return errors.New(op).Msg("email is not configured")
+	}
+	if _, _, err := s.enqueueJob(ctx, newJob{Kind: jobKindEmail, LogbookID: logbookID, Payload: message}); err != nil {
+		return errors.New(op).Err(err)
+	}
+	return nil
+}
+
+// runEmailJob sends the email of an email job.
+func (s *Service) runEmailJob(_ context.Context, j job) (any, error) {
+	const op errors.Op = "server.Service.runEmailJob"
+
+	var message email
+	if err := j.decodePayload(&message); err != nil {
+		return nil, permanentJobFailure(errors.New(op).Err(err))
+	}
+	if !s.mailer.enabled() {
+		return nil, permanentJobFailure(errors.New(op).Msg("email is not configured"))
+	}
+	if err := s.mailer.send(message); err != nil {
+		return nil, errors.New(op).Err(err)
+	}
+	return nil, nil
+}
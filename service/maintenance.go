@@ -0,0 +1,100 @@
+package service
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Station-Manager/errors"
+	"github.com/Station-Manager/server/service/frontend"
+	"github.com/gofiber/fiber/v2"
+)
+
+const (
+	defaultMaintenanceRetryAfter = 300 // seconds
+	defaultMaintenanceMessage    = "The server is down for maintenance"
+)
+
+// maintenanceState describes an active maintenance window.
+type maintenanceState struct {
+	Message    string    `json:"message"`
+	RetryAfter int       `json:"retry_after"` // seconds, sent as the Retry-After header
+	Since      time.Time `json:"since"`
+}
+
+// maintenanceRequest is the body of a request to enter maintenance mode.
+type maintenanceRequest struct {
+	Message    string `json:"message" validate:"max=256"`
+	RetryAfter int    `json:"retry_after" validate:"min=0,max=86400"`
+}
+
+// maintenanceMiddleware answers every request with 503 while maintenance mode is on, so that
+// migrations and backups can run without clients writing. The health checks and admin routes stay
+// available; browsers get a maintenance page instead of the frontend.
+func (s *Service) maintenanceMiddleware() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		state := s.maintenance.Load()
+		if state == nil {
+			return c.Next()
+		}
+		path := c.Path()
+		if path == "/health" || path == "/livez" || path == "/readyz" || path == "/admin" || strings.HasPrefix(path, "/admin/") {
+			return c.Next()
+		}
+
+		c.Set(fiber.HeaderRetryAfter, strconv.Itoa(state.RetryAfter))
+		c.Status(fiber.StatusServiceUnavailable)
+		if c.Method() == fiber.MethodGet && c.Accepts(fiber.MIMETextHTML, fiber.MIMEApplicationJSON) == fiber.MIMETextHTML {
+			c.Set(fiber.HeaderContentType, fiber.MIMETextHTMLCharsetUTF8)
+			return c.Send(frontend.MaintenancePage())
+		}
+		return c.JSON(jsonError(errCodeMaintenance, state.Message))
+	}
+}
+
+// getMaintenanceHandler reports whether maintenance mode is on.
+func (s *Service) getMaintenanceHandler(c *fiber.Ctx) error {
+	state := s.maintenance.Load()
+	if state == nil {
+		return c.JSON(fiber.Map{"enabled": false})
+	}
+	return c.JSON(fiber.Map{"enabled": true, "message": state.Message, "retry_after": state.RetryAfter, "since": state.Since})
+}
+
+// putMaintenanceHandler turns maintenance mode on, or updates its message.
+func (s *Service) putMaintenanceHandler(c *fiber.Ctx) error {
+	const op errors.Op = "server.Service.putMaintenanceHandler"
+
+	var request maintenanceRequest
+	if len(c.Body()) > 0 {
+		if err := c.BodyParser(&request); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(jsonBadRequest)
+		}
+	}
+	if err := s.validate.Struct(request); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(jsonBadRequest)
+	}
+
+	state := &maintenanceState{Message: strings.TrimSpace(request.Message), RetryAfter: request.RetryAfter, Since: time.Now().UTC()}
+	if state.Message == emptyString {
+		state.Message = defaultMaintenanceMessage
+	}
+	if state.RetryAfter == 0 {
+		state.RetryAfter = defaultMaintenanceRetryAfter
+	}
+	if previous := s.maintenance.Load(); previous != nil {
+		state.Since = previous.Since
+	}
+	s.maintenance.Store(state)
+	s.logger.WarnWith().Str("op", string(op)).Str("message", state.Message).Msg("Maintenance mode on")
+
+	return c.SendStatus(fiber.StatusNoContent)
+}
+
+// deleteMaintenanceHandler turns maintenance mode off.
+func (s *Service) deleteMaintenanceHandler(c *fiber.Ctx) error {
+	if s.maintenance.Swap(nil) != nil {
+		s.logger.WarnWith().Msg("Maintenance mode off")
+	}
+	return c.SendStatus(fiber.StatusNoContent)
+}
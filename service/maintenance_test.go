@@ -0,0 +1,103 @@
+package service
+
+import (
+	"io"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/goccy/go-json"
+	"github.com/gofiber/fiber/v2"
+)
+
+func TestMaintenanceMode(t *testing.T) {
+	t.Setenv(envAdminToken, "s3cret")
+	svc := newTestServer(t)
+	svc.adminToken = loadAdminToken()
+
+	app := fiber.New()
+	app.Use(svc.maintenanceMiddleware())
+	app.Get("/health", func(c *fiber.Ctx) error { return c.SendStatus(fiber.StatusOK) })
+	app.Get("/", func(c *fiber.Ctx) error { return c.SendString("frontend") })
+	app.Post("/api/qso/insert", func(c *fiber.Ctx) error { return c.SendStatus(fiber.StatusCreated) })
+	adminRoutes := app.Group("/admin", svc.adminAuthNMiddleware())
+	adminRoutes.Get("/maintenance", svc.getMaintenanceHandler)
+	adminRoutes.Put("/maintenance", svc.putMaintenanceHandler)
+	adminRoutes.Delete("/maintenance", svc.deleteMaintenanceHandler)
+
+	do := func(method, path, accept, token, body string) (int, string, string) {
+		t.Helper()
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		if accept != emptyString {
+			req.Header.Set(fiber.HeaderAccept, accept)
+		}
+		if token != emptyString {
+			req.Header.Set(fiber.HeaderAuthorization, "Bearer "+token)
+		}
+		if body != emptyString {
+			req.Header.Set(fiber.HeaderContentType, fiber.MIMEApplicationJSON)
+		}
+		resp, err := app.Test(req)
+		if err != nil {
+			t.Fatalf("%s %s failed: %v", method, path, err)
+		}
+		data, _ := io.ReadAll(resp.Body)
+		return resp.StatusCode, resp.Header.Get(fiber.HeaderRetryAfter), string(data)
+	}
+
+	if status, _, _ := do("POST", "/api/qso/insert", "", "", ""); status != fiber.StatusCreated {
+		t.Fatalf("expected requests to pass outside maintenance, got %d", status)
+	}
+	if status, _, _ := do("PUT", "/admin/maintenance", "", "wrong", ""); status != fiber.StatusUnauthorized {
+		t.Fatalf("expected a wrong admin token to be rejected, got %d", status)
+	}
+	if status, _, _ := do("PUT", "/admin/maintenance", "", "s3cret", `{"message":"Upgrading","retry_after":120}`); status != fiber.StatusNoContent {
+		t.Fatalf("expected maintenance mode to be turned on, got %d", status)
+	}
+
+	status, retryAfter, body := do("POST", "/api/qso/insert", "", "", "")
+	if status != fiber.StatusServiceUnavailable || retryAfter != "120" {
+		t.Fatalf("expected 503 with Retry-After 120, got %d %q", status, retryAfter)
+	}
+	var payload map[string]any
+	if err := json.Unmarshal([]byte(body), &payload); err != nil || payload["code"] != string(errCodeMaintenance) || payload["message"] != "Upgrading" {
+		t.Errorf("unexpected maintenance error body %s", body)
+	}
+
+	status, retryAfter, body = do("GET", "/", "text/html,application/xhtml+xml", "", "")
+	if status != fiber.StatusServiceUnavailable || retryAfter != "120" || !strings.Contains(body, "maintenance") {
+		t.Errorf("expected the maintenance page, got %d %q %.40s", status, retryAfter, body)
+	}
+	if status, _, _ = do("GET", "/health", "", "", ""); status != fiber.StatusOK {
+		t.Errorf("expected the health check to stay available, got %d", status)
+	}
+	if status, _, body = do("GET", "/admin/maintenance", "", "s3cret", ""); status != fiber.StatusOK || !strings.Contains(body, `"enabled":true`) {
+		t.Errorf("expected the admin routes to stay available, got %d %s", status, body)
+	}
+
+	if status, _, _ = do("DELETE", "/admin/maintenance", "", "s3cret", ""); status != fiber.StatusNoContent {
+		t.Fatalf("expected maintenance mode to be turned off, got %d", status)
+	}
+	if status, _, _ = do("POST", "/api/qso/insert", "", "", ""); status != fiber.StatusCreated {
+		t.Errorf("expected requests to pass after maintenance, got %d", status)
+	}
+}
+
+func TestAdminRoutesDisabledWithoutToken(t *testing.T) {
+	t.Setenv(envAdminToken, "")
+	svc := newTestServer(t)
+	svc.adminToken = loadAdminToken()
+
+	app := fiber.New()
+	app.Get("/admin/maintenance", svc.adminAuthNMiddleware(), svc.getMaintenanceHandler)
+
+	req := httptest.NewRequest("GET", "/admin/maintenance", nil)
+	req.Header.Set(fiber.HeaderAuthorization, "Bearer ")
+	resp, err := app.Test(req)
+	if err != nil {
+		t.Fatalf("request failed: %v", err)
+	}
+	if resp.StatusCode != fiber.StatusNotFound {
+		t.Errorf("expected the admin routes to be disabled, got %d", resp.StatusCode)
+	}
+}
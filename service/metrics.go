@@ -0,0 +1,109 @@
+package service
+
+import (
+	"bufio"
+	"strconv"
+	"strings"
+
+	"github.com/Station-Manager/server/service/cache"
+	"github.com/gofiber/fiber/v2"
+)
+
+// GET /metrics exposes the server's metrics in the Prometheus text format. Like /health, it is
+// answered without authentication and holds only counts.
+
+const metricsContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+const (
+	metricTypeCounter = "counter"
+	metricTypeGauge   = "gauge"
+)
+
+// metricFamily is a metric and its samples, one per combination of label values.
+type metricFamily struct {
+	Name    string
+	Help    string
+	Type    string
+	Samples []metricSample
+}
+
+// metricSample is a value of a metric, with its labels as name and value pairs.
+type metricSample struct {
+	Labels []string
+	Value  float64
+}
+
+// namedCache is one of the service's caches and the name it is reported under.
+type namedCache struct {
+	Name  string
+	Stats cache.Stats
+}
+
+// cacheStats returns the statistics of every cache of the service that has been created.
+func (s *Service) cacheStats() []namedCache {
+	caches := []namedCache{
+		{Name: "logbooks", Stats: s.logbookCache.Stats()},
+		{Name: "users", Stats: s.userCache.Stats()},
+		{Name: "api_keys", Stats: s.apiKeyCache.Stats()},
+		{Name: "rejected_api_keys", Stats: s.apiKeyNegativeCache.Stats()},
+		{Name: "api_key_members", Stats: s.apiKeyMemberCache.Stats()},
+		{Name: "signing_secrets", Stats: s.signingSecretCache.Stats()},
+	}
+	if s.lookup != nil {
+		caches = append(caches, namedCache{Name: "callsign_lookups", Stats: s.lookup.cache.Stats()})
+	}
+	return caches
+}
+
+// cacheMetrics returns the metrics of the caches, labelled with their names.
+func (s *Service) cacheMetrics() []metricFamily {
+	families := []metricFamily{
+		{Name: "station_manager_cache_entries", Help: "Entries held by the cache.", Type: metricTypeGauge},
+		{Name: "station_manager_cache_max_entries", Help: "Entries the cache may hold; zero for no limit.", Type: metricTypeGauge},
+		{Name: "station_manager_cache_hits_total", Help: "Lookups that found an entry.", Type: metricTypeCounter},
+		{Name: "station_manager_cache_misses_total", Help: "Lookups that found no entry.", Type: metricTypeCounter},
+		{Name: "station_manager_cache_evictions_total", Help: "Entries removed to make room for others.", Type: metricTypeCounter},
+		{Name: "station_manager_cache_expirations_total", Help: "Entries removed because they expired.", Type: metricTypeCounter},
+	}
+	for _, c := range s.cacheStats() {
+		labels := []string{"cache", c.Name}
+		for i, value := range []float64{float64(c.Stats.Entries), float64(c.Stats.MaxEntries), float64(c.Stats.Hits),
+			float64(c.Stats.Misses), float64(c.Stats.Evictions), float64(c.Stats.Expirations)} {
+			families[i].Samples = append(families[i].Samples, metricSample{Labels: labels, Value: value})
+		}
+	}
+	return families
+}
+
+// metrics returns every metric of the service.
+func (s *Service) metrics() []metricFamily {
+	families := append(append(s.cacheMetrics(), s.slowLog.metrics()...), s.poolMetrics()...)
+	return append(families, s.deliveryMetrics()...)
+}
+
+// writeMetrics writes the metric families in the Prometheus text format.
+func writeMetrics(w *bufio.Writer, families []metricFamily) error {
+	for _, family := range families {
+		_, _ = w.WriteString("# HELP " + family.Name + " " + family.Help + "\n")
+		_, _ = w.WriteString("# TYPE " + family.Name + " " + family.Type + "\n")
+		for _, sample := range family.Samples {
+			_, _ = w.WriteString(family.Name)
+			if len(sample.Labels) > 0 {
+				pairs := make([]string, 0, len(sample.Labels)/2)
+				for i := 0; i+1 < len(sample.Labels); i += 2 {
+					pairs = append(pairs, sample.Labels[i]+"="+strconv.Quote(sample.Labels[i+1]))
+				}
+				_, _ = w.WriteString("{" + strings.Join(pairs, ",") + "}")
+			}
+			_, _ = w.WriteString(" " + strconv.FormatFloat(sample.Value, 'g', -1, 64) + "\n")
+		}
+	}
+	return w.Flush()
+}
+
+// metricsHandler answers with the service's metrics for Prometheus to scrape.
+func (s *Service) metricsHandler(c *fiber.Ctx) error {
+	c.Set(fiber.HeaderContentType, metricsContentType)
+	w := bufio.NewWriter(c.Response().BodyWriter())
+	return writeMetrics(w, s.metrics())
+}
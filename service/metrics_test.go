@@ -0,0 +1,46 @@
+package service
+
+import (
+	"io"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/Station-Manager/types"
+	"github.com/gofiber/fiber/v2"
+)
+
+func TestMetricsHandler_ReportsCaches(t *testing.T) {
+	svc := &Service{app: fiber.New()}
+	svc.initializeCaches()
+	svc.logbookCache.Set(1, types.Logbook{ID: 1}, 0)
+	svc.logbookCache.Get(1)
+	svc.logbookCache.Get(2)
+
+	svc.app.Get("/metrics", svc.metricsHandler)
+	resp, err := svc.app.Test(httptest.NewRequest(fiber.MethodGet, "/metrics", nil))
+	if err != nil {
+		t.Fatalf("fiber test request failed: %v", err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+	if got := resp.Header.Get(fiber.HeaderContentType); got != metricsContentType {
+		t.Errorf("expected content type %q, got %q", metricsContentType, got)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	for _, line := range []string{
+		"# TYPE station_manager_cache_hits_total counter",
+		`station_manager_cache_entries{cache="logbooks"} 1`,
+		`station_manager_cache_hits_total{cache="logbooks"} 1`,
+		`station_manager_cache_misses_total{cache="logbooks"} 1`,
+		`station_manager_cache_evictions_total{cache="signing_secrets"} 0`,
+	} {
+		if !strings.Contains(string(body), line+"\n") {
+			t.Errorf("expected %q in the metrics, got:\n%s", line, body)
+		}
+	}
+
+	details := svc.cacheDetails()["logbooks"].(map[string]any)
+	if details["hit_rate"] != 0.5 {
+		t.Errorf("expected a hit rate of 0.5 in the health details, got %v", details["hit_rate"])
+	}
+}
@@ -2,6 +2,9 @@ package service
 
 import (
 	"context"
+	stderr "errors"
+	"sync"
+
 	"github.com/Station-Manager/adapters"
 	"github.com/Station-Manager/adapters/converters/common"
 	"github.com/Station-Manager/apikey"
@@ -45,21 +48,6 @@ func (s *Service) fetchUser(ctx context.Context, callsign string) (types.User, e
 	return user, nil
 }
 
-// isValidAction checks the Action field and returns the corresponding action enum value.
-// If the action is missing or invalid, an error is returned.
-func (s *Service) isValidAction(action types.RequestAction) (bool, error) {
-	const op errors.Op = "server.Service.checkActionHeader"
-
-	switch action {
-	case types.RegisterLogbookAction:
-		return true, nil
-	case types.InsertQsoAction:
-		return true, nil
-	default:
-		return false, errors.New(op).Errorf("Unknown action: %s", action)
-	}
-}
-
 // isValidApiKey validates an API key by checking its prefix and hashed value against the stored database records.
 // Returns the logbook ID if the key is valid.
 func (s *Service) isValidApiKey(ctx context.Context, fullKey string) (bool, int64, error) {
@@ -74,9 +62,16 @@ func (s *Service) isValidApiKey(ctx context.Context, fullKey string) (bool, int6
 		return false, 0, errors.New(op).Err(err)
 	}
 
-	// Database call to the api_keys table
-	model, err := s.db.FetchAPIKeyByPrefixContext(ctx, prefix)
+	// Fail fast on keys that were rejected recently, without touching the database.
+	if s.isRejectedApiKey(prefix, fullKey) {
+		return false, 0, nil
+	}
+
+	model, err := s.fetchApiKeyWithCache(ctx, prefix)
 	if err != nil {
+		if isApiKeyNotFound(err) {
+			s.rejectApiKeyPrefix(prefix)
+		}
 		return false, 0, errors.New(op).Err(err)
 	}
 
@@ -91,11 +86,28 @@ func (s *Service) isValidApiKey(ctx context.Context, fullKey string) (bool, int6
 	}
 
 	if !valid {
+		s.rejectApiKey(fullKey)
 		return false, 0, nil
 	}
 	return valid, model.LogbookID, nil
 }
 
+// dummyPasswordHash is verified against the password given for a callsign with no account, so
+// that rejecting it takes as long as rejecting a wrong password and does not reveal which
+// callsigns have accounts.
+var dummyPasswordHash = sync.OnceValues(func() (string, error) {
+	return apikey.HashPassword("station-manager-dummy-password")
+})
+
+// spendPasswordCheck verifies the password against dummyPasswordHash and discards the result.
+func (s *Service) spendPasswordCheck(pass string) {
+	hash, err := dummyPasswordHash()
+	if err != nil || pass == emptyString {
+		return
+	}
+	_, _ = s.isValidPassword(hash, pass)
+}
+
 // isValidPassword checks if a password matches the hashed value stored in the database.
 func (s *Service) isValidPassword(hash, pass string) (bool, error) {
 	const op errors.Op = "server.Service.isValidPassword"
@@ -119,8 +131,19 @@ func (s *Service) requestContextMiddleware() fiber.Handler {
 
 	return func(c *fiber.Ctx) error {
 		// 1. Parse request body. All valid requests have the same structure.
-		var request types.PostRequest
-		if err := c.BodyParser(&request); err != nil {
+		var request requestEnvelope
+		if s.strictJSON && c.Is("json") {
+			if err := decodeStrictJSON(c.Body(), &request); err != nil {
+				var invalid *strictJSONError
+				if stderr.As(err, &invalid) {
+					body := jsonError(errCodeInvalidFields, "The body has unknown fields or values of the wrong type")
+					body["fields"] = invalid.Fields
+					return c.Status(fiber.StatusBadRequest).JSON(body)
+				}
+				s.logger.InfoWith().Err(err).Msg("decodeStrictJSON failed")
+				return c.Status(fiber.StatusBadRequest).JSON(jsonBadRequest)
+			}
+		} else if err := c.BodyParser(&request); err != nil {
 			err = errors.New(op).Err(err)
 			s.logger.ErrorWith().Err(err).Msg("c.BodyParser")
 			return c.Status(fiber.StatusBadRequest).JSON(jsonBadRequest)
@@ -143,7 +166,7 @@ func (s *Service) requestContextMiddleware() fiber.Handler {
 		}
 
 		// 3. Store the unified request context in locals for downstream handlers.
-		c.Locals(localsRequestDataKey, reqCtx)
+		setRequestContext(c, reqCtx)
 
 		return c.Next()
 	}
@@ -171,11 +194,13 @@ func (s *Service) apikeyAuthNMiddleware() fiber.Handler {
 		if err != nil {
 			err = errors.New(op).Err(err)
 			s.logger.ErrorWith().Err(err).Msg("s.isValidApiKey failed")
+			s.auditApiKeyFailure(c, reqCtx.Request.Key, "invalid_key")
 			return c.Status(fiber.StatusUnauthorized).JSON(jsonUnauthorized)
 		}
 
 		if !validApiKey {
 			s.logger.InfoWith().Str("callsign", reqCtx.Request.Callsign).Msg("Invalid API key")
+			s.auditApiKeyFailure(c, reqCtx.Request.Key, "invalid_key")
 			return c.Status(fiber.StatusUnauthorized).JSON(jsonUnauthorized)
 		}
 
@@ -188,7 +213,25 @@ func (s *Service) apikeyAuthNMiddleware() fiber.Handler {
 			return c.Status(fiber.StatusUnauthorized).JSON(jsonUnauthorized)
 		}
 
+		member, err := s.fetchApiKeyMemberWithCache(c.UserContext(), reqCtx.Request.Key)
+		if err != nil {
+			err = errors.New(op).Err(err)
+			s.logger.ErrorWith().Err(err).Msg("s.fetchApiKeyMemberWithCache failed")
+			return c.Status(fiber.StatusUnauthorized).JSON(jsonUnauthorized)
+		}
+		if member.Role == emptyString {
+			s.logger.InfoWith().Str("callsign", reqCtx.Request.Callsign).Msg("API key is revoked or holds no role on the logbook")
+			s.auditApiKeyFailure(c, reqCtx.Request.Key, "no_role")
+			return c.Status(fiber.StatusUnauthorized).JSON(jsonUnauthorized)
+		}
+
 		reqCtx.Logbook = &logbook
+		reqCtx.Actor = apiKeyActor(reqCtx.Request.Key)
+		reqCtx.Role = member.Role
+		reqCtx.Sandbox = member.Sandbox
+		if member.UserID != 0 {
+			reqCtx.Member = &member
+		}
 
 		// The API key is no longer needed after a successful authn.
 		// This prevents accidental leakage further down-stream.
@@ -213,28 +256,57 @@ func (s *Service) passwordAuthNMiddleware() fiber.Handler {
 			return c.Status(fiber.StatusInternalServerError).JSON(jsonInternalError)
 		}
 
-		// 2. Fetch the user by callsign.
-		user, err := s.fetchUser(c.UserContext(), reqCtx.Request.Callsign)
+		// 2. Fetch the user by callsign. An unknown callsign is rejected only after a password
+		// check, like a wrong password, so that both take as long and answer the same.
+		user, err := s.fetchUserWithCache(c.UserContext(), reqCtx.Request.Callsign)
 		if err != nil {
 			err = errors.New(op).Err(err)
-			s.logger.ErrorWith().Err(err).Msg("s.fetchUser failed")
+			s.logger.ErrorWith().Err(err).Msg("s.fetchUserWithCache failed")
+			s.spendPasswordCheck(reqCtx.Request.Key)
+			s.auditLoginFailure(c, reqCtx.Request.Callsign, "unknown_user")
 			return c.Status(fiber.StatusUnauthorized).JSON(jsonUnauthorized)
 		}
 
 		validPass, err := s.isValidPassword(user.PassHash, reqCtx.Request.Key)
+		if err == nil && !validPass {
+			// The cached user may predate a password change made elsewhere, so re-check
+			// against the database before rejecting the request. The password is checked
+			// again only if it has changed, so that a wrong password costs one check.
+			cachedHash := user.PassHash
+			if user, err = s.refreshUser(c.UserContext(), reqCtx.Request.Callsign); err == nil && user.PassHash != cachedHash {
+				validPass, err = s.isValidPassword(user.PassHash, reqCtx.Request.Key)
+			}
+		}
 		if err != nil {
 			err = errors.New(op).Err(err)
 			s.logger.ErrorWith().Err(err).Msg("s.isValidPassword failed")
+			s.auditLoginFailure(c, reqCtx.Request.Callsign, "invalid_password")
 			return c.Status(fiber.StatusUnauthorized).JSON(jsonUnauthorized)
 		}
 
 		if !validPass {
 			s.logger.InfoWith().Str("callsign", reqCtx.Request.Callsign).Msg("Invalid password")
+			s.auditLoginFailure(c, reqCtx.Request.Callsign, "invalid_password")
 			return c.Status(fiber.StatusUnauthorized).JSON(jsonUnauthorized)
 		}
 
+		// The cached user does not record whether the account is disabled, so ask the database.
+		disabled, err := s.isUserDisabled(c.UserContext(), user.ID)
+		if err != nil {
+			err = errors.New(op).Err(err)
+			s.logger.ErrorWith().Err(err).Msg("s.isUserDisabled failed")
+			return s.dbFailure(c, err)
+		}
+		if disabled {
+			s.logger.InfoWith().Str("callsign", user.Callsign).Msg("Sign-in to a disabled account")
+			s.auditLoginFailure(c, user.Callsign, "account_disabled")
+			return c.Status(fiber.StatusForbidden).JSON(jsonError(errCodeAccountDisabled, "The account has been disabled"))
+		}
+
 		reqCtx.IsValid = validPass
 		reqCtx.User = &user
+		reqCtx.Actor = userActor(user.Callsign)
+		s.audit(c, auditEntry{Event: auditLogin, Actor: reqCtx.Actor, Callsign: user.Callsign}, fiber.Map{"path": c.Path()})
 
 		// The user's password is no longer needed after successful authn.
 		// This prevents accidental leakage further down-stream.
@@ -0,0 +1,244 @@
+package service
+
+import (
+	"context"
+	"database/sql"
+	"sort"
+
+	"github.com/Station-Manager/errors"
+)
+
+// MigrationStatus describes one server migration.
+type MigrationStatus struct {
+	Version    int
+	Name       string
+	Applied    bool
+	Reversible bool
+}
+
+// SchemaStatus describes the state of the database schema. The core schema is migrated by the
+// database module, which reports only the version reached; the server migrations are listed one
+// by one.
+type SchemaStatus struct {
+	Driver      string
+	CoreVersion int
+	CoreDirty   bool
+	Server      []MigrationStatus
+}
+
+// Pending returns the number of server migrations not yet applied.
+func (st SchemaStatus) Pending() int {
+	pending := 0
+	for _, m := range st.Server {
+		if !m.Applied {
+			pending++
+		}
+	}
+	return pending
+}
+
+// MigrationStatus opens the database, reports the state of its schema without changing it, and
+// closes the database.
+func (s *Service) MigrationStatus() (SchemaStatus, error) {
+	const op errors.Op = "server.Service.MigrationStatus"
+	if s == nil {
+		return SchemaStatus{}, errors.New(op).Msg(errMsgNilService)
+	}
+
+	if err := s.db.Open(); err != nil {
+		return SchemaStatus{}, errors.New(op).Err(err).Msg("s.db.Open")
+	}
+	defer func() { _ = s.db.Close() }()
+
+	status, err := s.schemaStatus(context.Background())
+	if err != nil {
+		return SchemaStatus{}, errors.New(op).Err(err)
+	}
+	return status, nil
+}
+
+// MigrateDown opens the database, reverts the last steps server migrations, newest first, and
+// closes the database. Nothing is reverted if any of them cannot be. The core schema is never
+// reverted.
+func (s *Service) MigrateDown(steps int) error {
+	const op errors.Op = "server.Service.MigrateDown"
+	if s == nil {
+		return errors.New(op).Msg(errMsgNilService)
+	}
+	if steps < 1 {
+		return errors.New(op).Msgf("Invalid number of migrations to revert: %d", steps)
+	}
+
+	if err := s.db.Open(); err != nil {
+		return errors.New(op).Err(err).Msg("s.db.Open")
+	}
+	defer func() { _ = s.db.Close() }()
+
+	ctx := context.Background()
+	status, err := s.schemaStatus(ctx)
+	if err != nil {
+		return errors.New(op).Err(err)
+	}
+
+	var revert []schemaMigration
+	for i := len(status.Server) - 1; i >= 0 && len(revert) < steps; i-- {
+		m := status.Server[i]
+		if !m.Applied {
+			continue
+		}
+		if !m.Reversible {
+			return errors.New(op).Msgf("Server migration %d (%s) cannot be reverted", m.Version, m.Name)
+		}
+		revert = append(revert, serverMigrationByVersion(m.Version))
+	}
+	if len(revert) < steps {
+		return errors.New(op).Msgf("Only %d server migrations are applied", len(revert))
+	}
+
+	for _, m := range revert {
+		if err = s.revertServerMigration(ctx, m); err != nil {
+			return errors.New(op).Err(err).Msg("Failed to revert server migration " + m.name)
+		}
+		s.logger.InfoWith().Int("version", m.version).Str("name", m.name).Msg("Reverted server migration")
+	}
+	return nil
+}
+
+// verifySchema fails unless the schema is fully migrated. It stands in for the migrations when
+// they are run separately from serving.
+func (s *Service) verifySchema(ctx context.Context) error {
+	const op errors.Op = "server.Service.verifySchema"
+
+	status, err := s.schemaStatus(ctx)
+	if err != nil {
+		return errors.New(op).Err(err)
+	}
+	if status.CoreVersion == 0 || status.CoreDirty {
+		return errors.New(op).Msg("The core schema is missing or dirty; run `server migrate up`")
+	}
+	if pending := status.Pending(); pending > 0 {
+		return errors.New(op).Msgf("%d server migrations are pending; run `server migrate up`", pending)
+	}
+	return nil
+}
+
+// schemaStatus reads the applied migrations. Missing bookkeeping tables mean nothing is applied.
+func (s *Service) schemaStatus(ctx context.Context) (SchemaStatus, error) {
+	const op errors.Op = "server.Service.schemaStatus"
+
+	status := SchemaStatus{}
+	if s.dbConfig != nil {
+		status.Driver = s.dbConfig.Driver
+	}
+
+	exists, err := s.tableExists(ctx, "schema_migrations")
+	if err != nil {
+		return status, errors.New(op).Err(err)
+	}
+	if exists {
+		rows, err := s.db.QueryContext(ctx, `SELECT version, dirty FROM schema_migrations LIMIT 1`)
+		if err != nil {
+			return status, errors.New(op).Err(err)
+		}
+		if rows.Next() {
+			err = rows.Scan(&status.CoreVersion, &status.CoreDirty)
+		}
+		_ = rows.Close()
+		if err != nil {
+			return status, errors.New(op).Err(err)
+		}
+	}
+
+	applied := make(map[int]bool)
+	if exists, err = s.tableExists(ctx, "server_schema_migrations"); err != nil {
+		return status, errors.New(op).Err(err)
+	}
+	if exists {
+		rows, err := s.db.QueryContext(ctx, `SELECT version FROM server_schema_migrations`)
+		if err != nil {
+			return status, errors.New(op).Err(err)
+		}
+		for rows.Next() {
+			var version int
+			if err = rows.Scan(&version); err != nil {
+				break
+			}
+			applied[version] = true
+		}
+		if err == nil {
+			err = rows.Err()
+		}
+		_ = rows.Close()
+		if err != nil {
+			return status, errors.New(op).Err(err)
+		}
+	}
+
+	for _, m := range serverMigrations {
+		down := m.sqliteDown
+		if s.isPostgres() {
+			down = m.postgresDown
+		}
+		status.Server = append(status.Server, MigrationStatus{Version: m.version, Name: m.name, Applied: applied[m.version], Reversible: down != nil})
+	}
+	sort.Slice(status.Server, func(i, j int) bool { return status.Server[i].Version < status.Server[j].Version })
+
+	return status, nil
+}
+
+// tableExists reports whether the named table exists.
+func (s *Service) tableExists(ctx context.Context, name string) (bool, error) {
+	const op errors.Op = "server.Service.tableExists"
+
+	query := `SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = $1`
+	if s.isPostgres() {
+		query = `SELECT COUNT(*) FROM information_schema.tables WHERE table_schema = current_schema() AND table_name = $1`
+	}
+	rows, err := s.db.QueryContext(ctx, query, name)
+	if err != nil {
+		return false, errors.New(op).Err(err)
+	}
+	defer func() { _ = rows.Close() }()
+
+	var count int
+	if rows.Next() {
+		if err = rows.Scan(&count); err != nil {
+			return false, errors.New(op).Err(err)
+		}
+	}
+	return count > 0, rows.Err()
+}
+
+// revertServerMigration runs a migration's down statements together with the removal of its
+// bookkeeping row.
+func (s *Service) revertServerMigration(ctx context.Context, m schemaMigration) error {
+	const op errors.Op = "server.Service.revertServerMigration"
+
+	statements := m.sqliteDown
+	if s.isPostgres() {
+		statements = m.postgresDown
+	}
+
+	err := s.withTx(ctx, func(tx *sql.Tx) error {
+		for _, stmt := range statements {
+			if _, err := tx.ExecContext(ctx, stmt); err != nil {
+				return err
+			}
+		}
+		_, err := tx.ExecContext(ctx, `DELETE FROM server_schema_migrations WHERE version = $1`, m.version)
+		return err
+	})
+	if err != nil {
+		return errors.New(op).Err(err)
+	}
+	return nil
+}
+
+func serverMigrationByVersion(version int) schemaMigration {
+	for _, m := range serverMigrations {
+		if m.version == version {
+			return m
+		}
+	}
+	return schemaMigration{}
+}
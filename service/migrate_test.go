@@ -0,0 +1,64 @@
+package service
+
+import (
+	"context"
+	"testing"
+)
+
+func TestSchemaStatus_ReportsAppliedMigrations(t *testing.T) {
+	svc := newTestServer(t)
+	ctx := context.Background()
+
+	status, err := svc.schemaStatus(ctx)
+	if err != nil {
+		t.Fatalf("schemaStatus failed: %v", err)
+	}
+	if status.CoreVersion == 0 || status.CoreDirty {
+		t.Errorf("expected a clean core schema, got version %d (dirty=%v)", status.CoreVersion, status.CoreDirty)
+	}
+	if len(status.Server) != len(serverMigrations) || status.Pending() != 0 {
+		t.Fatalf("expected %d applied server migrations, got %+v", len(serverMigrations), status.Server)
+	}
+	if err = svc.verifySchema(ctx); err != nil {
+		t.Errorf("expected a migrated schema to verify, got %v", err)
+	}
+
+	// The SQLite users migration adds a column to logbook, which cannot be dropped in place.
+	for _, m := range status.Server {
+		if m.Name == "sqlite_users" && m.Reversible {
+			t.Error("expected sqlite_users to be irreversible on SQLite")
+		}
+	}
+}
+
+func TestRevertServerMigration_AllowsReapplying(t *testing.T) {
+	svc := newTestServer(t)
+	ctx := context.Background()
+
+	// Revert, newest first, back to and including the migration that creates api_keys.
+	reverted := 0
+	for i := len(serverMigrations) - 1; serverMigrations[i].version >= 11; i-- {
+		if err := svc.revertServerMigration(ctx, serverMigrations[i]); err != nil {
+			t.Fatalf("revertServerMigration(%d) failed: %v", serverMigrations[i].version, err)
+		}
+		reverted++
+	}
+	exists, err := svc.tableExists(ctx, "api_keys")
+	if err != nil || exists {
+		t.Fatalf("expected api_keys to be dropped (exists=%v, err=%v)", exists, err)
+	}
+	status, err := svc.schemaStatus(ctx)
+	if err != nil || status.Pending() != reverted {
+		t.Fatalf("expected %d pending migrations, got %d (err=%v)", reverted, status.Pending(), err)
+	}
+	if err = svc.verifySchema(ctx); err == nil {
+		t.Error("expected a pending migration to fail verification")
+	}
+
+	if err = svc.migrateServerSchema(ctx); err != nil {
+		t.Fatalf("migrateServerSchema failed: %v", err)
+	}
+	if exists, err = svc.tableExists(ctx, "api_keys"); err != nil || !exists {
+		t.Errorf("expected api_keys to be recreated (exists=%v, err=%v)", exists, err)
+	}
+}
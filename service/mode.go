@@ -0,0 +1,88 @@
+package service
+
+import (
+	"strings"
+
+	"github.com/Station-Manager/types"
+)
+
+// adifModes lists the ADIF MODE values that are also commonly sent as a submode, so that e.g.
+// MODE=MFSK SUBMODE=FT8 is stored as MODE=FT8.
+var adifModes = map[string]bool{
+	"FT8": true, "JT4": true, "JT9": true, "JT65": true, "MSK144": true, "Q15": true, "QRA64": true, "WSPR": true,
+	"CW": true, "RTTY": true, "SSB": true, "AM": true, "FM": true, "SSTV": true, "PSK2K": true, "ISCAT": true,
+}
+
+// adifSubmodeParents maps ADIF submodes to their MODE. The DIGITALVOICE submodes (C4FM, DMR, DSTAR,
+// FREEDV, M17) are absent because the qso.mode column holds at most ten characters.
+var adifSubmodeParents = map[string]string{
+	"USB": "SSB", "LSB": "SSB",
+	"ASCI": "RTTY",
+	"FT4":  "MFSK", "FST4": "MFSK", "FST4W": "MFSK", "JS8": "MFSK", "Q65": "MFSK", "FSQCALL": "MFSK", "JTMS": "MFSK",
+	"MFSK4": "MFSK", "MFSK8": "MFSK", "MFSK11": "MFSK", "MFSK16": "MFSK", "MFSK22": "MFSK", "MFSK31": "MFSK",
+	"MFSK32": "MFSK", "MFSK64": "MFSK", "MFSK64L": "MFSK", "MFSK128": "MFSK", "MFSK128L": "MFSK",
+	"PSK31": "PSK", "PSK63": "PSK", "PSK63F": "PSK", "PSK125": "PSK", "PSK250": "PSK", "PSK500": "PSK", "PSK1000": "PSK",
+	"BPSK31": "PSK", "BPSK63": "PSK", "BPSK125": "PSK", "BPSK250": "PSK", "BPSK500": "PSK", "BPSK1000": "PSK",
+	"QPSK31": "PSK", "QPSK63": "PSK", "QPSK125": "PSK", "QPSK250": "PSK", "QPSK500": "PSK",
+	"8PSK125": "PSK", "8PSK250": "PSK", "8PSK500": "PSK", "8PSK1000": "PSK", "PSK10": "PSK", "PSKAM10": "PSK",
+	"PSKAM31": "PSK", "PSKAM50": "PSK", "PSKFEC31": "PSK", "SIM31": "PSK",
+	"JT4A": "JT4", "JT4B": "JT4", "JT4C": "JT4", "JT4D": "JT4", "JT4E": "JT4", "JT4F": "JT4", "JT4G": "JT4",
+	"JT65A": "JT65", "JT65B": "JT65", "JT65B2": "JT65", "JT65C": "JT65", "JT65C2": "JT65",
+	"JT9-1": "JT9", "JT9-2": "JT9", "JT9-5": "JT9", "JT9-10": "JT9", "JT9-30": "JT9",
+	"DOMINOEX": "DOMINO", "DOMINOF": "DOMINO", "DOM-M": "DOMINO", "DOM4": "DOMINO", "DOM5": "DOMINO", "DOM8": "DOMINO",
+	"DOM11": "DOMINO", "DOM16": "DOMINO", "DOM22": "DOMINO", "DOM44": "DOMINO", "DOM88": "DOMINO",
+	"FMHELL": "HELL", "FSKHELL": "HELL", "HELL80": "HELL", "HELLX5": "HELL", "HELLX9": "HELL", "HFSK": "HELL",
+	"PSKHELL": "HELL", "SLOWHELL": "HELL",
+	"THOR4": "THOR", "THOR5": "THOR", "THOR8": "THOR", "THOR11": "THOR", "THOR16": "THOR", "THOR22": "THOR",
+	"THOR25X4": "THOR", "THOR50X1": "THOR", "THOR50X2": "THOR", "THOR100": "THOR",
+	"OLIVIA 4/125": "OLIVIA", "OLIVIA 4/250": "OLIVIA", "OLIVIA 8/250": "OLIVIA", "OLIVIA 8/500": "OLIVIA",
+	"OLIVIA 16/500": "OLIVIA", "OLIVIA 16/1000": "OLIVIA", "OLIVIA 32/1000": "OLIVIA",
+	"CHIP64": "CHIP", "CHIP128": "CHIP",
+	"PAC2": "PAC", "PAC3": "PAC", "PAC4": "PAC",
+	"AMTORFEC": "TOR", "GTOR": "TOR",
+	"VARA HF": "DYNAMIC", "VARA SATELLITE": "DYNAMIC", "VARA FM 1200": "DYNAMIC", "VARA FM 9600": "DYNAMIC",
+}
+
+// modeAliases maps common non-ADIF spellings sent by logging software to the ADIF value.
+var modeAliases = map[string]string{
+	"FT-8": "FT8", "FT-4": "FT4", "JS8CALL": "JS8", "PSK-31": "PSK31", "PSK-63": "PSK63", "BPSK": "PSK31",
+	"RTTY45": "RTTY", "RTTY-45": "RTTY", "PHONE": "SSB", "A1A": "CW",
+}
+
+// normalizeMode returns the ADIF MODE and SUBMODE for a mode and submode as sent by a client, so that
+// statistics and awards do not fragment across spellings. Unknown values are returned upper-cased.
+func normalizeMode(mode, submode string) (string, string) {
+	canonical := func(v string) string {
+		v = strings.ToUpper(strings.Join(strings.Fields(v), " "))
+		if alias, ok := modeAliases[v]; ok {
+			return alias
+		}
+		return v
+	}
+	mode, submode = canonical(mode), canonical(submode)
+
+	// A submode sent as the mode, e.g. MODE=FT4 or MODE=USB.
+	if parent, ok := adifSubmodeParents[mode]; ok {
+		if submode == emptyString {
+			submode = mode
+		}
+		mode = parent
+	}
+	// A mode sent as a submode, e.g. MODE=MFSK SUBMODE=FT8.
+	if adifModes[submode] {
+		return submode, emptyString
+	}
+	// A known submode determines its mode, e.g. MODE=DATA SUBMODE=FT4.
+	if parent, ok := adifSubmodeParents[submode]; ok {
+		mode = parent
+	}
+	if submode == mode {
+		submode = emptyString
+	}
+	return mode, submode
+}
+
+// normalizeQsoMode applies normalizeMode to the QSO.
+func normalizeQsoMode(qso *types.Qso) {
+	qso.Mode, qso.Submode = normalizeMode(qso.Mode, qso.Submode)
+}
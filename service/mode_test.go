@@ -0,0 +1,31 @@
+package service
+
+import "testing"
+
+func TestNormalizeMode(t *testing.T) {
+	cases := []struct {
+		mode, submode         string
+		wantMode, wantSubmode string
+	}{
+		{"FT8", "", "FT8", ""},
+		{"mfsk", "ft8", "FT8", ""},
+		{"FT-8", "", "FT8", ""},
+		{"FT4", "", "MFSK", "FT4"},
+		{"DATA", "FT4", "MFSK", "FT4"},
+		{"JS8Call", "", "MFSK", "JS8"},
+		{"usb", "", "SSB", "USB"},
+		{"SSB", "LSB", "SSB", "LSB"},
+		{"PSK31", "", "PSK", "PSK31"},
+		{"psk", "bpsk31", "PSK", "BPSK31"},
+		{"Olivia  8/250", "", "OLIVIA", "OLIVIA 8/250"},
+		{"JT65A", "", "JT65", "JT65A"},
+		{"CW", "CW", "CW", ""},
+		{"C4FM", "", "C4FM", ""},
+	}
+	for _, c := range cases {
+		mode, submode := normalizeMode(c.mode, c.submode)
+		if mode != c.wantMode || submode != c.wantSubmode {
+			t.Errorf("normalizeMode(%q, %q): expected %q/%q, got %q/%q", c.mode, c.submode, c.wantMode, c.wantSubmode, mode, submode)
+		}
+	}
+}
@@ -0,0 +1,117 @@
+package service
+
+import (
+	"bytes"
+	"strings"
+
+	"github.com/Station-Manager/errors"
+	"github.com/goccy/go-json"
+	"github.com/gofiber/fiber/v2"
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+// MessagePack media types. Requests may use either; responses are sent as mimeMsgpack.
+const (
+	mimeMsgpack  = "application/msgpack"
+	mimeXMsgpack = "application/x-msgpack"
+)
+
+// msgpackMiddleware lets clients such as embedded logging devices speak MessagePack instead of
+// JSON. A MessagePack request body is converted to JSON before the handlers see it, and a JSON
+// response is converted to MessagePack when the Accept header prefers it. The handlers themselves
+// only ever deal with JSON.
+func (s *Service) msgpackMiddleware() fiber.Handler {
+	const op errors.Op = "server.Service.msgpackMiddleware"
+
+	return func(c *fiber.Ctx) error {
+		c.Vary(fiber.HeaderAccept)
+		accepted := c.Accepts(fiber.MIMEApplicationJSON, mimeMsgpack, mimeXMsgpack)
+		wantsMsgpack := accepted == mimeMsgpack || accepted == mimeXMsgpack
+
+		var err error
+		if isMsgpack(string(c.Request().Header.ContentType())) && len(c.Body()) > 0 {
+			var body []byte
+			if body, err = msgpackToJSON(c.Body()); err != nil {
+				err = c.Status(fiber.StatusBadRequest).JSON(jsonError(errCodeBadRequest, "The body is not valid MessagePack"))
+			} else {
+				c.Request().SetBody(body)
+				c.Request().Header.SetContentType(fiber.MIMEApplicationJSON)
+				err = c.Next()
+			}
+		} else {
+			err = c.Next()
+		}
+		if !wantsMsgpack {
+			return err
+		}
+
+		// Errors are answered here rather than by the app's error handler so that their body is
+		// converted too.
+		if err != nil {
+			if err = c.App().ErrorHandler(c, err); err != nil {
+				return err
+			}
+		}
+		if !strings.HasPrefix(string(c.Response().Header.ContentType()), fiber.MIMEApplicationJSON) {
+			return nil
+		}
+		body, err := jsonToMsgpack(c.Response().Body())
+		if err != nil {
+			s.logger.ErrorWith().Err(errors.New(op).Err(err)).Str("path", c.Path()).Msg("Failed to convert the response to MessagePack")
+			return nil
+		}
+		c.Response().SetBodyRaw(body)
+		c.Response().Header.SetContentType(mimeMsgpack)
+		return nil
+	}
+}
+
+// isMsgpack reports whether a Content-Type header names MessagePack.
+func isMsgpack(contentType string) bool {
+	mediaType, _, _ := strings.Cut(contentType, ";")
+	mediaType = strings.ToLower(strings.TrimSpace(mediaType))
+	return mediaType == mimeMsgpack || mediaType == mimeXMsgpack
+}
+
+// msgpackToJSON converts a MessagePack document to JSON.
+func msgpackToJSON(data []byte) ([]byte, error) {
+	var value any
+	if err := msgpack.Unmarshal(data, &value); err != nil {
+		return nil, err
+	}
+	return json.Marshal(value)
+}
+
+// jsonToMsgpack converts a JSON document to MessagePack. Integers stay integers rather than
+// becoming floats, so that IDs survive the conversion exactly.
+func jsonToMsgpack(data []byte) ([]byte, error) {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	var value any
+	if err := decoder.Decode(&value); err != nil {
+		return nil, err
+	}
+	return msgpack.Marshal(convertJSONNumbers(value))
+}
+
+// convertJSONNumbers replaces the json.Number values of a decoded JSON document with int64 or
+// float64 values.
+func convertJSONNumbers(value any) any {
+	switch v := value.(type) {
+	case json.Number:
+		if i, err := v.Int64(); err == nil {
+			return i
+		}
+		f, _ := v.Float64()
+		return f
+	case map[string]any:
+		for key, item := range v {
+			v[key] = convertJSONNumbers(item)
+		}
+	case []any:
+		for i, item := range v {
+			v[i] = convertJSONNumbers(item)
+		}
+	}
+	return value
+}
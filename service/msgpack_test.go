@@ -0,0 +1,60 @@
+package service
+
+import (
+	"bytes"
+	"io"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+func TestJsonToMsgpack_KeepsIntegers(t *testing.T) {
+	data, err := jsonToMsgpack([]byte(`{"id":9007199254740993,"freq":14.074,"calls":["K1ABC"]}`))
+	if err != nil {
+		t.Fatalf("jsonToMsgpack failed: %v", err)
+	}
+	var out map[string]any
+	if err = msgpack.Unmarshal(data, &out); err != nil {
+		t.Fatalf("msgpack.Unmarshal failed: %v", err)
+	}
+	if out["id"] != int64(9007199254740993) || out["freq"] != 14.074 || out["calls"].([]any)[0] != "K1ABC" {
+		t.Errorf("expected the values to survive the conversion, got %v", out)
+	}
+}
+
+func TestMsgpackMiddleware(t *testing.T) {
+	svc := newTestServer(t)
+
+	post := func(contentType, accept string, body []byte) (int, string, []byte) {
+		req := httptest.NewRequest(fiber.MethodPost, "/api/logbook/register", bytes.NewReader(body))
+		req.Header.Set(fiber.HeaderContentType, contentType)
+		req.Header.Set(fiber.HeaderAccept, accept)
+		resp, err := svc.app.Test(req)
+		if err != nil {
+			t.Fatalf("request failed: %v", err)
+		}
+		data, _ := io.ReadAll(resp.Body)
+		return resp.StatusCode, resp.Header.Get(fiber.HeaderContentType), data
+	}
+
+	// The envelope lacks a key, so the request is rejected after its body has been read.
+	body, _ := msgpack.Marshal(map[string]any{"callsign": "K1ABC"})
+	status, contentType, data := post(mimeMsgpack, mimeMsgpack, body)
+	var out map[string]any
+	if status != fiber.StatusBadRequest || contentType != mimeMsgpack || msgpack.Unmarshal(data, &out) != nil || out["code"] != string(errCodeBadRequest) {
+		t.Errorf("expected a MessagePack bad request, got %d %q %v", status, contentType, out)
+	}
+
+	status, contentType, _ = post(mimeMsgpack, fiber.MIMEApplicationJSON, body)
+	if status != fiber.StatusBadRequest || contentType != fiber.MIMEApplicationJSON {
+		t.Errorf("expected a JSON response when JSON is accepted, got %d %q", status, contentType)
+	}
+
+	status, contentType, data = post(mimeXMsgpack, mimeMsgpack, []byte{0xc1})
+	out = nil
+	if status != fiber.StatusBadRequest || contentType != mimeMsgpack || msgpack.Unmarshal(data, &out) != nil || out["message"] != "The body is not valid MessagePack" {
+		t.Errorf("expected invalid MessagePack to be rejected, got %d %q %v", status, contentType, out)
+	}
+}
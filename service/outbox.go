@@ -0,0 +1,134 @@
+package service
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/Station-Manager/errors"
+	"github.com/Station-Manager/types"
+)
+
+// The outbox holds the events the integrations (webhooks, awards, PSK Reporter, eQSL, Club Log and
+// callsign lookups) act on. An event is written in the transaction that stores its QSO and is
+// marked dispatched when it is handed to them, normally just after the commit. An event left
+// behind, because the server stopped first, is dispatched by the outbox dispatcher once it is
+// older than outboxGrace.
+const (
+	outboxDispatchInterval = 10 * time.Second
+	// outboxGrace leaves a new event to the request that wrote it before the dispatcher takes it.
+	outboxGrace     = 30 * time.Second
+	outboxBatchSize = 100
+	// outboxRetention is how long dispatched events are kept before they are purged.
+	outboxRetention            = 7 * 24 * time.Hour
+	defaultOutboxPurgeInterval = time.Hour
+)
+
+// writeOutboxEvent records the event for the QSO within tx and returns its ID.
+func writeOutboxEvent(ctx context.Context, tx *sql.Tx, eventType qsoEventType, qso types.Qso) (int64, error) {
+	const op errors.Op = "server.writeOutboxEvent"
+
+	var id int64
+	if err := tx.QueryRowContext(ctx, `INSERT INTO outbox (logbook_id, qso_id, event_type) VALUES ($1, $2, $3) RETURNING id`,
+		qso.LogbookID, qso.ID, string(eventType)).Scan(&id); err != nil {
+		return 0, errors.New(op).Err(err)
+	}
+	return id, nil
+}
+
+// dispatchOutboxEvent publishes the event just written to the outbox, unless the dispatcher got
+// to it first. An event that cannot be marked is left to the dispatcher.
+func (s *Service) dispatchOutboxEvent(ctx context.Context, id int64, eventType qsoEventType, qso types.Qso) {
+	const op errors.Op = "server.Service.dispatchOutboxEvent"
+
+	res, err := s.db.ExecContext(ctx, `UPDATE outbox SET dispatched_at = $1 WHERE id = $2 AND dispatched_at IS NULL`, s.dbTimestamp(time.Now()), id)
+	if err != nil {
+		s.logger.WarnWith().Err(errors.New(op).Err(err)).Int64("outbox_id", id).Msg("Failed to mark outbox event; leaving it to the dispatcher")
+		return
+	}
+	if n, err := res.RowsAffected(); err != nil || n == 0 {
+		return
+	}
+	s.publishQsoEvent(ctx, eventType, qso)
+}
+
+// runOutboxDispatcher dispatches the events left in the outbox, at once and then every
+// outboxDispatchInterval, until ctx is cancelled.
+func (s *Service) runOutboxDispatcher(ctx context.Context) {
+	ticker := time.NewTicker(outboxDispatchInterval)
+	defer ticker.Stop()
+
+	for {
+		n, err := s.dispatchPendingOutbox(ctx, time.Now().Add(-outboxGrace))
+		if err != nil {
+			s.logger.ErrorWith().Err(err).Msg("Outbox dispatch failed")
+		} else if n > 0 {
+			s.logger.InfoWith().Int("events", n).Msg("Dispatched events left in the outbox")
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// dispatchPendingOutbox claims the undispatched events written before the cutoff, in batches, and
+// publishes them with their QSOs as they are now. It returns how many were published. An event
+// whose QSO has since been deleted is dropped.
+func (s *Service) dispatchPendingOutbox(ctx context.Context, before time.Time) (int, error) {
+	const op errors.Op = "server.Service.dispatchPendingOutbox"
+
+	type pending struct {
+		id        int64
+		qsoID     int64
+		eventType qsoEventType
+	}
+
+	published := 0
+	for {
+		rows, err := s.db.QueryContext(ctx, `UPDATE outbox SET dispatched_at = $1
+			WHERE id IN (SELECT id FROM outbox WHERE dispatched_at IS NULL AND created_at <= $2 ORDER BY id LIMIT $3)
+			RETURNING id, qso_id, event_type`, s.dbTimestamp(time.Now()), s.dbTimestamp(before), outboxBatchSize)
+		if err != nil {
+			return published, errors.New(op).Err(err)
+		}
+		var batch []pending
+		for rows.Next() {
+			var p pending
+			if err = rows.Scan(&p.id, &p.qsoID, &p.eventType); err != nil {
+				_ = rows.Close()
+				return published, errors.New(op).Err(err)
+			}
+			batch = append(batch, p)
+		}
+		_ = rows.Close()
+		if err = rows.Err(); err != nil {
+			return published, errors.New(op).Err(err)
+		}
+
+		for _, p := range batch {
+			qso, err := s.db.FetchQsoByIdContext(ctx, p.qsoID)
+			if err != nil {
+				s.logger.WarnWith().Err(errors.New(op).Err(err)).Int64("outbox_id", p.id).Int64("qso_id", p.qsoID).Msg("Dropping outbox event for a QSO that cannot be read")
+				continue
+			}
+			s.publishQsoEvent(ctx, p.eventType, qso)
+			published++
+		}
+		if len(batch) < outboxBatchSize {
+			return published, nil
+		}
+	}
+}
+
+// purgeDispatchedOutbox deletes the events dispatched more than outboxRetention ago.
+func (s *Service) purgeDispatchedOutbox(ctx context.Context) error {
+	const op errors.Op = "server.Service.purgeDispatchedOutbox"
+
+	if _, err := s.db.ExecContext(ctx, `DELETE FROM outbox WHERE dispatched_at < $1`, s.dbTimestamp(time.Now().Add(-outboxRetention))); err != nil {
+		return errors.New(op).Err(err)
+	}
+	return nil
+}
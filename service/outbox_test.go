@@ -0,0 +1,116 @@
+package service
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/Station-Manager/types"
+)
+
+func TestInsertQso_WritesAndDispatchesOutboxEvent(t *testing.T) {
+	svc := newTestServer(t)
+	ctx := context.Background()
+	for _, query := range []string{
+		`UPDATE logbook SET callsign = 'K1AB' WHERE id = 1`,
+		`INSERT INTO session (id) VALUES (1)`,
+	} {
+		if _, err := svc.db.ExecContext(ctx, query); err != nil {
+			t.Fatalf("setup failed: %v", err)
+		}
+	}
+
+	// Listeners are called as the event is published.
+	var published []qsoEvent
+	svc.qsoEvents.OnPublish(func(event qsoEvent) { published = append(published, event) })
+	count := func(query string) int {
+		t.Helper()
+		rows, err := svc.db.QueryContext(ctx, query)
+		if err != nil {
+			t.Fatalf("query failed: %v", err)
+		}
+		defer func() { _ = rows.Close() }()
+		var n int
+		if rows.Next() {
+			_ = rows.Scan(&n)
+		}
+		return n
+	}
+
+	var qso types.Qso
+	qso.Call, qso.Band, qso.Mode, qso.Freq = "DL1ABC", "20m", "FT8", "14.074"
+	qso.QsoDate, qso.TimeOn, qso.TimeOff = "20240501", "1200", "1201"
+	qso.RstSent, qso.RstRcvd, qso.StationCallsign, qso.SessionID = "-10", "-12", "K1AB", 1
+	logbook := types.Logbook{ID: 1, Callsign: "K1AB"}
+	const uuid = "8d0f4a9e-0000-4000-8000-000000000001"
+
+	inserted, err := svc.insertQso(ctx, logbook, nil, "test", qso, emptyString, uuid, false)
+	if err != nil {
+		t.Fatalf("insertQso failed: %v", err)
+	}
+	if len(published) != 1 || published[0].Type != qsoEventInserted || published[0].Qso.ID != inserted.ID || published[0].QsoUUID != uuid {
+		t.Fatalf("expected the insert to be published, got %+v", published)
+	}
+	if n := count(`SELECT COUNT(*) FROM outbox WHERE dispatched_at IS NOT NULL`); n != 1 {
+		t.Errorf("expected the outbox event to be marked dispatched, got %d", n)
+	}
+
+	// A second copy under the same UUID leaves neither a QSO, a history entry nor an event behind.
+	if _, err = svc.insertQso(ctx, logbook, nil, "test", qso, emptyString, uuid, false); err == nil {
+		t.Fatal("expected a taken UUID to fail the insert")
+	}
+	if q, h, o := count(`SELECT COUNT(*) FROM qso`), count(`SELECT COUNT(*) FROM qso_history`), count(`SELECT COUNT(*) FROM outbox`); q != 1 || h != 1 || o != 1 {
+		t.Errorf("expected the failed insert to be rolled back, got %d QSOs, %d history entries and %d events", q, h, o)
+	}
+	if len(published) != 1 {
+		t.Errorf("expected nothing more to be published, got %+v", published)
+	}
+}
+
+func TestDispatchPendingOutbox(t *testing.T) {
+	svc := newTestServer(t)
+	ctx := context.Background()
+
+	// Listeners are called as the event is published.
+	var published []qsoEvent
+	svc.qsoEvents.OnPublish(func(event qsoEvent) { published = append(published, event) })
+
+	if _, err := svc.db.ExecContext(ctx, `INSERT OR IGNORE INTO session (id) VALUES (1)`); err != nil {
+		t.Fatalf("insert session failed: %v", err)
+	}
+	res, err := svc.db.ExecContext(ctx, `INSERT INTO qso (call, band, mode, freq, qso_date, time_on, time_off, rst_sent, rst_rcvd, logbook_id, session_id)
+		VALUES ('JA1XX', '20m', 'FT8', 14074, '20240501', '1200', '1201', '-10', '-12', 1, 1)`)
+	if err != nil {
+		t.Fatalf("insert QSO failed: %v", err)
+	}
+	qsoID, _ := res.LastInsertId()
+
+	// Left behind by a server that stopped: one for the QSO, one for a QSO since deleted, and one
+	// still in the hands of the request that wrote it.
+	longAgo := svc.dbTimestamp(time.Now().Add(-time.Hour))
+	for _, row := range []struct {
+		qsoID   int64
+		created any
+	}{{qsoID, longAgo}, {qsoID + 1000, longAgo}, {qsoID, svc.dbTimestamp(time.Now())}} {
+		if _, err = svc.db.ExecContext(ctx, `INSERT INTO outbox (logbook_id, qso_id, event_type, created_at) VALUES (1, $1, $2, $3)`,
+			row.qsoID, string(qsoEventInserted), row.created); err != nil {
+			t.Fatalf("insert outbox event failed: %v", err)
+		}
+	}
+
+	n, err := svc.dispatchPendingOutbox(ctx, time.Now().Add(-outboxGrace))
+	if err != nil || n != 1 {
+		t.Fatalf("expected one event to be dispatched, got %d %v", n, err)
+	}
+	if len(published) != 1 || published[0].Type != qsoEventInserted || published[0].Qso.ID != qsoID || published[0].Qso.Call != "JA1XX" {
+		t.Fatalf("expected the QSO's insert to be published, got %+v", published)
+	}
+	if n, err = svc.dispatchPendingOutbox(ctx, time.Now().Add(-outboxGrace)); err != nil || n != 0 {
+		t.Errorf("expected an event to be dispatched only once, got %d %v", n, err)
+	}
+
+	// Once the grace has passed the last event is taken as well.
+	if n, err = svc.dispatchPendingOutbox(ctx, time.Now().Add(time.Second)); err != nil || n != 1 {
+		t.Errorf("expected the recent event to be dispatched, got %d %v", n, err)
+	}
+}
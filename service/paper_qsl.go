@@ -0,0 +1,251 @@
+package service
+
+import (
+	"cmp"
+	"context"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/Station-Manager/errors"
+	"github.com/Station-Manager/server/service/cty"
+	"github.com/Station-Manager/types"
+)
+
+// The paper QSL workflow is kept in the QSO's ADIF QSL fields, so that exports carry it: a card to
+// be sent is queued (QSL_SENT "Q"), then sent (QSL_SENT "Y") by the bureau or direct
+// (QSL_SENT_VIA "B" or "D") on QSLSDATE; a card received sets QSL_RCVD, QSL_RCVD_VIA and QSLRDATE.
+const (
+	qslStatusQueued = "Q"
+	qslStatusYes    = "Y"
+
+	paperQslViaBureau = "bureau"
+	paperQslViaDirect = "direct"
+
+	// paperQslPageSize is the number of QSO IDs read at a time when listing the cards to print.
+	paperQslPageSize = 500
+)
+
+// paperQslVias maps the routes of a paper card to their ADIF QSL_SENT_VIA and QSL_RCVD_VIA codes.
+var paperQslVias = map[string]string{
+	paperQslViaBureau: "B",
+	paperQslViaDirect: "D",
+}
+
+// paperQslRequest is the body of a request to queue, send or receive the cards of QSOs.
+type paperQslRequest struct {
+	QsoIDs []int64 `json:"qso_ids" validate:"required,min=1,max=500,dive,gt=0"`
+	Via    string  `json:"via" validate:"omitempty,oneof=bureau direct"`
+	// Date is when the card was sent or received, as YYYYMMDD; today (UTC) when omitted.
+	Date string `json:"date" validate:"omitempty,datetime=20060102"`
+}
+
+// paperQslResult reports which QSOs a request changed.
+type paperQslResult struct {
+	Updated  []int64 `json:"updated"`
+	NotFound []int64 `json:"not_found"`
+}
+
+// qslCard is a card to print, addressed to the QSO's QSL manager when it has one.
+type qslCard struct {
+	QsoID   int64  `json:"qso_id"`
+	Call    string `json:"call"`
+	QslVia  string `json:"qsl_via,omitempty"`
+	To      string `json:"to"`
+	QsoDate string `json:"qso_date"`
+	TimeOn  string `json:"time_on"`
+	Band    string `json:"band"`
+	Mode    string `json:"mode"`
+	Freq    string `json:"freq"`
+	RstSent string `json:"rst_sent"`
+	Via     string `json:"via,omitempty"`
+	QslRcvd bool   `json:"qsl_rcvd"`
+
+	sortCall string
+}
+
+// qslCardGroup holds the cards for one bureau, that of the DXCC entity they are addressed to.
+type qslCardGroup struct {
+	Prefix string    `json:"prefix"`
+	Entity string    `json:"entity"`
+	DXCC   int       `json:"dxcc,omitempty"`
+	Cards  []qslCard `json:"cards"`
+}
+
+// markPaperQsls applies change, which touches only QSL fields, to each of the logbook's QSOs
+// named, recording the change in its history and announcing it. QSOs that are not the logbook's are reported as not found.
+func (s *Service) markPaperQsls(ctx context.Context, logbookID int64, actor string, ids []int64, change func(qso *types.Qso)) (paperQslResult, error) {
+	const op errors.Op = "server.Service.markPaperQsls"
+
+	result := paperQslResult{Updated: make([]int64, 0, len(ids)), NotFound: make([]int64, 0)}
+	for _, id := range ids {
+		qso, err := s.db.FetchQsoByIdContext(ctx, id)
+		if err != nil || qso.LogbookID != logbookID {
+			result.NotFound = append(result.NotFound, id)
+			continue
+		}
+		before := qso
+		change(&qso)
+		if qso.Qsl == before.Qsl {
+			result.Updated = append(result.Updated, id)
+			continue
+		}
+		if err = s.db.UpdateQsoContext(ctx, qso); err != nil {
+			return result, errors.New(op).Err(err)
+		}
+		s.recordQsoChange(ctx, qsoHistoryUpdate, actor, before, qso)
+		s.publishQsoUpdated(ctx, id)
+		result.Updated = append(result.Updated, id)
+	}
+	return result, nil
+}
+
+// queuePaperQsl marks the QSO's card as waiting to be sent, by the route given if any.
+func queuePaperQsl(via string) func(qso *types.Qso) {
+	return func(qso *types.Qso) {
+		qso.QslSent = qslStatusQueued
+		if via != emptyString {
+			qso.QslSendVia = paperQslVias[via]
+		}
+	}
+}
+
+// sendPaperQsl marks the QSO's card as sent by the route on the date.
+func sendPaperQsl(via, date string) func(qso *types.Qso) {
+	return func(qso *types.Qso) {
+		qso.QslSent, qso.QslSendVia, qso.QslSDate = qslStatusYes, paperQslVias[via], date
+	}
+}
+
+// receivePaperQsl marks the QSO's card as received by the route on the date.
+func receivePaperQsl(via, date string) func(qso *types.Qso) {
+	return func(qso *types.Qso) {
+		qso.QslRcvd, qso.QslRcvdVia, qso.QslRDate = qslStatusYes, paperQslVias[via], date
+	}
+}
+
+// paperQslDate returns the date of the request, or today's.
+func paperQslDate(date string) string {
+	if date != emptyString {
+		return date
+	}
+	return time.Now().UTC().Format("20060102")
+}
+
+// listQueuedQslCards returns the logbook's cards waiting to be sent, grouped by bureau.
+func (s *Service) listQueuedQslCards(ctx context.Context, logbookID int64) ([]qslCardGroup, error) {
+	const op errors.Op = "server.Service.listQueuedQslCards"
+
+	qsos := make([]types.Qso, 0)
+	for afterID := int64(0); ; {
+		ids, err := s.listLogbookQsoIDPage(ctx, logbookID, afterID, paperQslPageSize)
+		if err != nil {
+			return nil, errors.New(op).Err(err)
+		}
+		for _, id := range ids {
+			qso, err := s.db.FetchQsoByIdContext(ctx, id)
+			if err != nil {
+				return nil, errors.New(op).Err(err)
+			}
+			if qso.QslSent == qslStatusQueued {
+				qsos = append(qsos, qso)
+			}
+		}
+		if len(ids) < paperQslPageSize {
+			break
+		}
+		afterID = ids[len(ids)-1]
+	}
+	return s.groupQslCards(qsos), nil
+}
+
+// groupQslCards returns the cards for the QSOs grouped by the bureau of the entity each is
+// addressed to. Groups are in order of the entities' prefixes, as bureaus sort their cards, with
+// cards to unknown entities last; cards are in order of callsign, then date.
+func (s *Service) groupQslCards(qsos []types.Qso) []qslCardGroup {
+	var db *cty.Database
+	if s.cty != nil {
+		db = s.cty.db.Load()
+	}
+
+	groups := make(map[string]*qslCardGroup)
+	for _, qso := range qsos {
+		card := newQslCard(qso)
+		group := qslCardGroup{Entity: qso.Country}
+		if db != nil {
+			if entity, ok := db.Lookup(card.To); ok {
+				group = qslCardGroup{Prefix: entity.Prefix, Entity: entity.Name, DXCC: entity.DXCC}
+			} else {
+				group = qslCardGroup{}
+			}
+		} else if card.QslVia != emptyString {
+			// Without the prefix database a manager's entity is unknown.
+			group = qslCardGroup{}
+		}
+		key := group.Prefix + "\x00" + group.Entity
+		if groups[key] == nil {
+			group.Cards = make([]qslCard, 0)
+			groups[key] = &group
+		}
+		groups[key].Cards = append(groups[key].Cards, card)
+	}
+
+	sorted := make([]qslCardGroup, 0, len(groups))
+	for _, group := range groups {
+		slices.SortFunc(group.Cards, func(a, b qslCard) int {
+			return cmp.Or(strings.Compare(a.sortCall, b.sortCall), strings.Compare(a.To, b.To), strings.Compare(a.Call, b.Call),
+				strings.Compare(a.QsoDate, b.QsoDate), strings.Compare(a.TimeOn, b.TimeOn), cmp.Compare(a.QsoID, b.QsoID))
+		})
+		sorted = append(sorted, *group)
+	}
+	slices.SortFunc(sorted, func(a, b qslCardGroup) int {
+		// Cards to unknown entities go last.
+		if (a.Prefix == emptyString) != (b.Prefix == emptyString) {
+			if a.Prefix == emptyString {
+				return 1
+			}
+			return -1
+		}
+		return cmp.Or(strings.Compare(a.Prefix, b.Prefix), strings.Compare(a.Entity, b.Entity))
+	})
+	return sorted
+}
+
+// newQslCard returns the card for the QSO, addressed to its QSL manager when it has one.
+func newQslCard(qso types.Qso) qslCard {
+	card := qslCard{
+		QsoID:   qso.ID,
+		Call:    qso.Call,
+		QslVia:  strings.ToUpper(strings.TrimSpace(qso.QslVia)),
+		QsoDate: qso.QsoDate,
+		TimeOn:  qso.TimeOn,
+		Band:    qso.Band,
+		Mode:    qso.Mode,
+		Freq:    qso.Freq,
+		RstSent: qso.RstSent,
+		QslRcvd: qso.QslRcvd == qslStatusYes,
+	}
+	for via, code := range paperQslVias {
+		if qso.QslSendVia == code {
+			card.Via = via
+		}
+	}
+	card.To = card.QslVia
+	if card.To == emptyString {
+		card.To = strings.ToUpper(qso.Call)
+	}
+	card.sortCall = qslSortCall(card.To)
+	return card
+}
+
+// qslSortCall returns the part of a compound callsign a bureau sorts by: the station's home call,
+// taken as the longest part and, of parts as long, the later, as in K1AB of VP2E/K1AB/P.
+func qslSortCall(call string) string {
+	best := emptyString
+	for _, part := range strings.Split(call, "/") {
+		if len(part) >= len(best) {
+			best = part
+		}
+	}
+	return best
+}
@@ -0,0 +1,127 @@
+package service
+
+import (
+	"bytes"
+	"time"
+
+	"github.com/Station-Manager/errors"
+	"github.com/Station-Manager/types"
+	"github.com/gofiber/fiber/v2"
+)
+
+// queuePaperQslHandler queues cards to be sent for QSOs of the authenticated logbook.
+func (s *Service) queuePaperQslHandler(c *fiber.Ctx) error {
+	return s.markPaperQslsHandler(c, false, func(request paperQslRequest) func(qso *types.Qso) {
+		return queuePaperQsl(request.Via)
+	})
+}
+
+// sentPaperQslHandler records cards sent for QSOs of the authenticated logbook, by the bureau or
+// direct.
+func (s *Service) sentPaperQslHandler(c *fiber.Ctx) error {
+	return s.markPaperQslsHandler(c, true, func(request paperQslRequest) func(qso *types.Qso) {
+		return sendPaperQsl(request.Via, paperQslDate(request.Date))
+	})
+}
+
+// receivedPaperQslHandler records cards received for QSOs of the authenticated logbook, by the
+// bureau or direct.
+func (s *Service) receivedPaperQslHandler(c *fiber.Ctx) error {
+	return s.markPaperQslsHandler(c, true, func(request paperQslRequest) func(qso *types.Qso) {
+		return receivePaperQsl(request.Via, paperQslDate(request.Date))
+	})
+}
+
+// markPaperQslsHandler applies the change built from the request body to the QSOs it names. The
+// route of the card must be given when needsVia is set.
+func (s *Service) markPaperQslsHandler(c *fiber.Ctx, needsVia bool, change func(request paperQslRequest) func(qso *types.Qso)) error {
+	const op errors.Op = "server.Service.markPaperQslsHandler"
+
+	logbook, err := authenticatedLogbook(c)
+	if err != nil {
+		s.logger.ErrorWith().Err(errors.New(op).Err(err)).Msg("authenticatedLogbook failed")
+		return c.Status(fiber.StatusInternalServerError).JSON(jsonInternalError)
+	}
+
+	var request paperQslRequest
+	if err = c.BodyParser(&request); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(jsonBadRequest)
+	}
+	if err = s.validate.Struct(request); err != nil || (needsVia && request.Via == emptyString) {
+		return c.Status(fiber.StatusBadRequest).JSON(jsonBadRequest)
+	}
+
+	result, err := s.markPaperQsls(c.UserContext(), logbook.ID, actorOf(c), request.QsoIDs, change(request))
+	if err != nil {
+		s.logger.ErrorWith().Err(errors.New(op).Err(err)).Msg("s.markPaperQsls failed")
+		return s.dbFailure(c, err)
+	}
+	return c.JSON(result)
+}
+
+// qslCardsToPrintHandler returns the authenticated logbook's queued cards, grouped and ordered
+// for sorting into the bureau.
+func (s *Service) qslCardsToPrintHandler(c *fiber.Ctx) error {
+	const op errors.Op = "server.Service.qslCardsToPrintHandler"
+
+	logbook, err := authenticatedLogbook(c)
+	if err != nil {
+		s.logger.ErrorWith().Err(errors.New(op).Err(err)).Msg("authenticatedLogbook failed")
+		return c.Status(fiber.StatusInternalServerError).JSON(jsonInternalError)
+	}
+
+	groups, err := s.listQueuedQslCards(c.UserContext(), logbook.ID)
+	if err != nil {
+		s.logger.ErrorWith().Err(errors.New(op).Err(err)).Msg("s.listQueuedQslCards failed")
+		return s.dbFailure(c, err)
+	}
+	cards := 0
+	for _, group := range groups {
+		cards += len(group.Cards)
+	}
+	return c.JSON(fiber.Map{"groups": groups, "cards": cards})
+}
+
+// qslLabelsHandler returns labels for the cards of the QSOs named in the request body, or of the
+// authenticated logbook's queued cards when none are, in bureau order: as JSON, or as a PDF of
+// label sheets when the format is "pdf".
+func (s *Service) qslLabelsHandler(c *fiber.Ctx) error {
+	const op errors.Op = "server.Service.qslLabelsHandler"
+
+	logbook, err := authenticatedLogbook(c)
+	if err != nil {
+		s.logger.ErrorWith().Err(errors.New(op).Err(err)).Msg("authenticatedLogbook failed")
+		return c.Status(fiber.StatusInternalServerError).JSON(jsonInternalError)
+	}
+
+	var request qslLabelsRequest
+	if len(c.Body()) > 0 {
+		if err = c.BodyParser(&request); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(jsonBadRequest)
+		}
+	}
+	if err = s.validate.Struct(request); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(jsonBadRequest)
+	}
+
+	var groups []qslCardGroup
+	if len(request.QsoIDs) > 0 {
+		groups = s.listQslCardsByID(c.UserContext(), logbook.ID, request.QsoIDs)
+	} else if groups, err = s.listQueuedQslCards(c.UserContext(), logbook.ID); err != nil {
+		s.logger.ErrorWith().Err(errors.New(op).Err(err)).Msg("s.listQueuedQslCards failed")
+		return s.dbFailure(c, err)
+	}
+	labels := qslLabels(groups)
+
+	if request.Format != qslLabelFormatPDF {
+		return c.JSON(fiber.Map{"labels": labels, "count": len(labels)})
+	}
+	var pdf bytes.Buffer
+	if err = writeQslLabelsPDF(&pdf, labels); err != nil {
+		s.logger.ErrorWith().Err(errors.New(op).Err(err)).Msg("writeQslLabelsPDF failed")
+		return c.Status(fiber.StatusInternalServerError).JSON(jsonInternalError)
+	}
+	c.Set(fiber.HeaderContentType, "application/pdf")
+	c.Attachment("qsl-labels-" + time.Now().UTC().Format("20060102") + ".pdf")
+	return c.Send(pdf.Bytes())
+}
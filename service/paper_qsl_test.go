@@ -0,0 +1,140 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/Station-Manager/server/service/cty"
+	"github.com/goccy/go-json"
+	"github.com/gofiber/fiber/v2"
+)
+
+func TestPaperQsl_QueueSendAndReceive(t *testing.T) {
+	svc := newTestServer(t)
+	ctx := context.Background()
+	first := insertTestQso(t, svc, "JA1XX", "20m", "FT8", "20240501", "1200")
+	second := insertTestQso(t, svc, "K1AB", "40m", "CW", "20240502", "0800")
+
+	app := fiber.New()
+	app.Post("/qsl/queue", withLogbook(1, svc.queuePaperQslHandler))
+	app.Post("/qsl/sent", withLogbook(1, svc.sentPaperQslHandler))
+	app.Post("/qsl/received", withLogbook(1, svc.receivedPaperQslHandler))
+	post := func(path, body string) (int, paperQslResult) {
+		t.Helper()
+		req := httptest.NewRequest(fiber.MethodPost, path, strings.NewReader(body))
+		req.Header.Set(fiber.HeaderContentType, fiber.MIMEApplicationJSON)
+		resp, err := app.Test(req)
+		if err != nil {
+			t.Fatalf("POST %s failed: %v", path, err)
+		}
+		var result paperQslResult
+		data, _ := io.ReadAll(resp.Body)
+		_ = json.Unmarshal(data, &result)
+		return resp.StatusCode, result
+	}
+	ids := `[` + strconv.FormatInt(first, 10) + `,` + strconv.FormatInt(second, 10) + `,999999]`
+
+	status, result := post("/qsl/queue", `{"qso_ids":`+ids+`,"via":"bureau"}`)
+	if status != fiber.StatusOK || len(result.Updated) != 2 || len(result.NotFound) != 1 || result.NotFound[0] != 999999 {
+		t.Fatalf("expected two QSOs queued and one not found, got %d %+v", status, result)
+	}
+	qso, _ := svc.db.FetchQsoByIdContext(ctx, first)
+	if qso.QslSent != "Q" || qso.QslSendVia != "B" {
+		t.Fatalf("expected the card to be queued for the bureau, got %+v", qso.Qsl)
+	}
+
+	// Sending and receiving need the card's route.
+	if status, _ = post("/qsl/sent", `{"qso_ids":[`+strconv.FormatInt(first, 10)+`]}`); status != fiber.StatusBadRequest {
+		t.Errorf("expected 400 without a route, got %d", status)
+	}
+	if status, _ = post("/qsl/sent", `{"qso_ids":[`+strconv.FormatInt(first, 10)+`],"via":"direct","date":"2024-06-01"}`); status != fiber.StatusBadRequest {
+		t.Errorf("expected 400 for a date not in ADIF form, got %d", status)
+	}
+	if status, _ = post("/qsl/sent", `{"qso_ids":[`+strconv.FormatInt(first, 10)+`],"via":"direct","date":"20240601"}`); status != fiber.StatusOK {
+		t.Fatalf("expected the card to be marked sent, got %d", status)
+	}
+	if status, _ = post("/qsl/received", `{"qso_ids":[`+strconv.FormatInt(first, 10)+`],"via":"bureau"}`); status != fiber.StatusOK {
+		t.Fatalf("expected the card to be marked received, got %d", status)
+	}
+	qso, _ = svc.db.FetchQsoByIdContext(ctx, first)
+	if qso.QslSent != "Y" || qso.QslSendVia != "D" || qso.QslSDate != "20240601" || qso.QslRcvd != "Y" || qso.QslRcvdVia != "B" || len(qso.QslRDate) != 8 {
+		t.Errorf("unexpected QSL fields %+v", qso.Qsl)
+	}
+
+	history, err := svc.listQsoHistory(ctx, 1, first)
+	if err != nil || len(history) < 3 {
+		t.Errorf("expected each change to be recorded, got %d entries %v", len(history), err)
+	}
+}
+
+func TestListQueuedQslCards_GroupsByBureau(t *testing.T) {
+	svc := newTestServer(t)
+	ctx := context.Background()
+	db, err := cty.Parse(strings.NewReader(testCtyDatabase))
+	if err != nil {
+		t.Fatalf("cty.Parse failed: %v", err)
+	}
+	svc.cty = newCtyResolver()
+	svc.cty.db.Store(db)
+
+	queue := func(call, date, via string) int64 {
+		t.Helper()
+		id := insertTestQso(t, svc, call, "20m", "SSB", date, "1200")
+		qso, err := svc.db.FetchQsoByIdContext(ctx, id)
+		if err != nil {
+			t.Fatalf("FetchQsoByIdContext failed: %v", err)
+		}
+		qso.QslSent, qso.QslVia = qslStatusQueued, via
+		if err = svc.db.UpdateQsoContext(ctx, qso); err != nil {
+			t.Fatalf("UpdateQsoContext failed: %v", err)
+		}
+		return id
+	}
+	queue("W1XYZ", "20240501", emptyString)
+	queue("JR2ABC", "20240502", emptyString)
+	queue("JA1AAA", "20240503", emptyString)
+	managed := queue("VP2EAA", "20240504", "K1ABC")
+	queue("ZZ9ZZ", "20240505", emptyString)
+	insertTestQso(t, svc, "JA1BBB", "20m", "SSB", "20240506", "1200") // not queued
+
+	groups, err := svc.listQueuedQslCards(ctx, 1)
+	if err != nil {
+		t.Fatalf("listQueuedQslCards failed: %v", err)
+	}
+	if len(groups) != 3 || groups[0].Prefix != "JA" || groups[1].Prefix != "K" || groups[2].Prefix != emptyString {
+		t.Fatalf("expected Japan, the United States and unknown entities in that order, got %+v", groups)
+	}
+	if cards := groups[0].Cards; len(cards) != 2 || cards[0].Call != "JA1AAA" || cards[1].Call != "JR2ABC" {
+		t.Errorf("expected Japan's cards in callsign order, got %+v", cards)
+	}
+	if cards := groups[1].Cards; len(cards) != 2 || cards[0].QsoID != managed || cards[0].To != "K1ABC" || cards[1].To != "W1XYZ" {
+		t.Errorf("expected the managed card to go to its manager's bureau, got %+v", cards)
+	}
+	if groups[1].DXCC != 291 || groups[1].Entity != "United States" {
+		t.Errorf("unexpected entity %+v", groups[1])
+	}
+
+	app := fiber.New()
+	app.Get("/qsl/print", withLogbook(1, svc.qslCardsToPrintHandler))
+	resp, err := app.Test(httptest.NewRequest(fiber.MethodGet, "/qsl/print", nil))
+	if err != nil || resp.StatusCode != fiber.StatusOK {
+		t.Fatalf("GET /qsl/print failed: %v", err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	if !bytes.Contains(body, []byte(`"cards":5`)) {
+		t.Errorf("expected five cards to print, got %s", body)
+	}
+}
+
+func TestQslSortCall(t *testing.T) {
+	for call, want := range map[string]string{"K1AB": "K1AB", "VP2E/K1AB": "K1AB", "K1AB/P": "K1AB", "VP2E/K1AB/P": "K1AB"} {
+		if got := qslSortCall(call); got != want {
+			t.Errorf("qslSortCall(%q) = %q, want %q", call, got, want)
+		}
+	}
+}
@@ -0,0 +1,164 @@
+package service
+
+import (
+	"context"
+	"database/sql"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/Station-Manager/errors"
+)
+
+// The connection pools the server holds are reported in /metrics and the health report, and a
+// warning is logged when requests spend too long waiting for a connection, which means a pool is
+// too small for the load. The database service keeps its own pool, the main one, to itself, so only
+// the pool the prepared statements run on (see prepared.go) is reported; the main pool is listed as
+// unavailable, so that its absence is not taken for a pool without load.
+
+// envPoolWaitThreshold names the environment variable holding how long, in total, requests may
+// wait for connections of a pool within a sampling interval before a warning is logged, as a Go
+// duration (e.g. "500ms"); "0" disables the warning.
+const envPoolWaitThreshold = "SM_DB_POOL_WAIT_THRESHOLD"
+
+const (
+	defaultPoolWaitThreshold = 1 * time.Second
+	// poolSampleInterval is how often the pools' wait time is checked against the threshold.
+	poolSampleInterval = 30 * time.Second
+)
+
+const (
+	// preparedPoolName is the name the prepared statements' pool is reported under.
+	preparedPoolName = "prepared"
+	// mainPoolName is the name the database service's pool is listed under.
+	mainPoolName = "main"
+	// mainPoolUnavailable says why the main pool's statistics are missing.
+	mainPoolUnavailable = "statistics unavailable: the database module does not expose its connection pool"
+)
+
+// dbPool is a connection pool of the server and its statistics.
+type dbPool struct {
+	Name  string
+	Stats sql.DBStats
+}
+
+// loadPoolWaitThreshold reads the pool wait threshold from the environment.
+func loadPoolWaitThreshold() (time.Duration, error) {
+	const op errors.Op = "server.loadPoolWaitThreshold"
+
+	value := strings.TrimSpace(os.Getenv(envPoolWaitThreshold))
+	if value == emptyString {
+		return defaultPoolWaitThreshold, nil
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil || d < 0 {
+		return 0, errors.New(op).Msg(envPoolWaitThreshold + " must be a non-negative duration")
+	}
+	return d, nil
+}
+
+// poolStats returns the statistics of the prepared statements' pool, and false while it is closed.
+func (p *preparedStore) poolStats() (sql.DBStats, bool) {
+	ex := p.executor()
+	if ex == nil {
+		return sql.DBStats{}, false
+	}
+	return ex.db.Stats(), true
+}
+
+// dbPools returns the statistics of every open connection pool of the server.
+func (s *Service) dbPools() []dbPool {
+	var pools []dbPool
+	if s.prepared != nil {
+		if stats, ok := s.prepared.poolStats(); ok {
+			pools = append(pools, dbPool{Name: preparedPoolName, Stats: stats})
+		}
+	}
+	return pools
+}
+
+// poolDetails returns the statistics of the pools for the health report, keyed by their names,
+// with the main pool listed as unavailable.
+func (s *Service) poolDetails() map[string]any {
+	details := map[string]any{
+		mainPoolName: map[string]any{"available": false, "message": mainPoolUnavailable},
+	}
+	for _, p := range s.dbPools() {
+		details[p.Name] = map[string]any{
+			"available":  true,
+			"max_open":   p.Stats.MaxOpenConnections,
+			"open":       p.Stats.OpenConnections,
+			"in_use":     p.Stats.InUse,
+			"idle":       p.Stats.Idle,
+			"wait_count": p.Stats.WaitCount,
+			"wait_ms":    p.Stats.WaitDuration.Milliseconds(),
+		}
+	}
+	return details
+}
+
+// poolMetrics returns the metrics of the pools, labelled with their names. The main pool has no
+// statistics, only a zero station_manager_db_pool_stats_available sample.
+func (s *Service) poolMetrics() []metricFamily {
+	const only = " Reported for the prepared statements' pool only; the main pool's statistics are not exposed by the database module."
+	families := []metricFamily{
+		{Name: "station_manager_db_pool_max_open_connections", Help: "Connections the pool may open; zero for no limit." + only, Type: metricTypeGauge},
+		{Name: "station_manager_db_pool_open_connections", Help: "Connections open, in use or idle." + only, Type: metricTypeGauge},
+		{Name: "station_manager_db_pool_in_use_connections", Help: "Connections in use." + only, Type: metricTypeGauge},
+		{Name: "station_manager_db_pool_idle_connections", Help: "Connections idle." + only, Type: metricTypeGauge},
+		{Name: "station_manager_db_pool_wait_count_total", Help: "Connections waited for." + only, Type: metricTypeCounter},
+		{Name: "station_manager_db_pool_wait_seconds_total", Help: "Time spent waiting for connections." + only, Type: metricTypeCounter},
+	}
+	available := metricFamily{
+		Name:    "station_manager_db_pool_stats_available",
+		Help:    "Whether the pool's statistics are reported: 1 for an open prepared statements' pool, 0 for the main pool, whose statistics the database module does not expose.",
+		Type:    metricTypeGauge,
+		Samples: []metricSample{{Labels: []string{"pool", mainPoolName}, Value: 0}},
+	}
+	for _, p := range s.dbPools() {
+		labels := []string{"pool", p.Name}
+		for i, value := range []float64{float64(p.Stats.MaxOpenConnections), float64(p.Stats.OpenConnections), float64(p.Stats.InUse),
+			float64(p.Stats.Idle), float64(p.Stats.WaitCount), p.Stats.WaitDuration.Seconds()} {
+			families[i].Samples = append(families[i].Samples, metricSample{Labels: labels, Value: value})
+		}
+		available.Samples = append(available.Samples, metricSample{Labels: labels, Value: 1})
+	}
+	return append(families, available)
+}
+
+// runPoolWaitMonitor logs a warning for each pool whose connections were waited for longer than
+// the threshold, in total, since the last sample.
+func (s *Service) runPoolWaitMonitor(ctx context.Context) {
+	ticker := time.NewTicker(poolSampleInterval)
+	defer ticker.Stop()
+
+	last := make(map[string]sql.DBStats)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			s.checkPoolWaits(last)
+		}
+	}
+}
+
+// checkPoolWaits compares the pools' wait time with that of the previous sample in last, warns of
+// those that waited too long, and records the sample in last.
+func (s *Service) checkPoolWaits(last map[string]sql.DBStats) {
+	for _, p := range s.dbPools() {
+		previous, seen := last[p.Name]
+		last[p.Name] = p.Stats
+		if !seen || p.Stats.WaitDuration < previous.WaitDuration {
+			// The first sample, or one of a pool that has been reopened since.
+			continue
+		}
+		waited := p.Stats.WaitDuration - previous.WaitDuration
+		if waited < s.poolWaitThreshold {
+			continue
+		}
+		s.logger.WarnWith().Str("pool", p.Name).Int64("wait_ms", waited.Milliseconds()).Int64("waits", p.Stats.WaitCount-previous.WaitCount).
+			Int("max_open", p.Stats.MaxOpenConnections).Int("in_use", p.Stats.InUse).Int("idle", p.Stats.Idle).
+			Msg("Database connections were waited for")
+	}
+}
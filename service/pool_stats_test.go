@@ -0,0 +1,79 @@
+package service
+
+import (
+	"context"
+	"database/sql"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestLoadPoolWaitThreshold(t *testing.T) {
+	t.Setenv(envPoolWaitThreshold, "")
+	if d, err := loadPoolWaitThreshold(); err != nil || d != defaultPoolWaitThreshold {
+		t.Errorf("expected the default, got %v (%v)", d, err)
+	}
+	t.Setenv(envPoolWaitThreshold, "250ms")
+	if d, err := loadPoolWaitThreshold(); err != nil || d != 250*time.Millisecond {
+		t.Errorf("expected 250ms, got %v (%v)", d, err)
+	}
+	for _, value := range []string{"-1s", "soon"} {
+		t.Setenv(envPoolWaitThreshold, value)
+		if _, err := loadPoolWaitThreshold(); err == nil {
+			t.Errorf("expected %q to be rejected", value)
+		}
+	}
+}
+
+func TestPoolStats(t *testing.T) {
+	p, dbSvc := newTestPreparedStore(t)
+	svc := &Service{db: dbSvc, logger: dbSvc.Logger, prepared: p, poolWaitThreshold: time.Nanosecond}
+	ctx := context.Background()
+
+	// Hold the pool's only connection so that a query has to wait for it.
+	conn, err := p.executor().db.Conn(ctx)
+	if err != nil {
+		t.Fatalf("Conn failed: %v", err)
+	}
+	last := make(map[string]sql.DBStats)
+	svc.checkPoolWaits(last)
+	done := make(chan error)
+	go func() {
+		_, err := p.FetchLogbookByIDContext(ctx, 1)
+		done <- err
+	}()
+	time.Sleep(20 * time.Millisecond)
+	_ = conn.Close()
+	if err = <-done; err != nil {
+		t.Fatalf("FetchLogbookByIDContext failed: %v", err)
+	}
+	svc.checkPoolWaits(last)
+	if stats := last[preparedPoolName]; stats.WaitCount != 1 || stats.WaitDuration <= 0 {
+		t.Errorf("expected the wait to be sampled, got %+v", stats)
+	}
+
+	families := svc.poolMetrics()
+	i := slices.IndexFunc(families, func(f metricFamily) bool { return f.Name == "station_manager_db_pool_wait_count_total" })
+	if i < 0 || len(families[i].Samples) != 1 || families[i].Samples[0].Labels[1] != preparedPoolName || families[i].Samples[0].Value != 1 {
+		t.Errorf("expected the wait to be counted in the metrics, got %+v", families)
+	}
+	i = slices.IndexFunc(families, func(f metricFamily) bool { return f.Name == "station_manager_db_pool_stats_available" })
+	if i < 0 || len(families[i].Samples) != 2 || families[i].Samples[0].Labels[1] != mainPoolName || families[i].Samples[0].Value != 0 ||
+		families[i].Samples[1].Value != 1 {
+		t.Errorf("expected the main pool to be listed as unavailable, got %+v", families)
+	}
+	pools := svc.checkDatabaseHealth().Details["pools"].(map[string]any)
+	details := pools[preparedPoolName].(map[string]any)
+	if details["available"] != true || details["max_open"] != 1 || details["wait_count"] != int64(1) {
+		t.Errorf("expected the pool in the health details, got %+v", details)
+	}
+	if main := pools[mainPoolName].(map[string]any); main["available"] != false || main["message"] != mainPoolUnavailable {
+		t.Errorf("expected the main pool to be listed as unavailable, got %+v", main)
+	}
+
+	// Closed pools are not reported.
+	_ = p.setStmts(nil).close()
+	if pools := svc.dbPools(); len(pools) != 0 {
+		t.Errorf("expected no pools once closed, got %+v", pools)
+	}
+}
@@ -0,0 +1,137 @@
+package service
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/Station-Manager/errors"
+	"github.com/gofiber/fiber/v2"
+)
+
+// userPreferences are how a user wants the API to present data to them. TimeZone is the zone QSO
+// times are also given in, alongside the UTC that ADIF has them in.
+type userPreferences struct {
+	TimeZone string `json:"time_zone" validate:"omitempty,timezone"`
+}
+
+// getPreferencesHandler returns the authenticated user's preferences.
+func (s *Service) getPreferencesHandler(c *fiber.Ctx) error {
+	const op errors.Op = "server.Service.getPreferencesHandler"
+
+	reqCtx, err := getRequestContext(c)
+	if err != nil || reqCtx.User == nil {
+		err = errors.New(op).Err(err).Msg("Request context missing")
+		s.logger.ErrorWith().Err(err).Msg("getRequestContext failed")
+		return c.Status(fiber.StatusInternalServerError).JSON(jsonInternalError)
+	}
+
+	preferences, err := s.fetchUserPreferences(c.UserContext(), reqCtx.User.ID)
+	if err != nil {
+		s.logger.ErrorWith().Err(errors.New(op).Err(err)).Msg("s.fetchUserPreferences failed")
+		return s.dbFailure(c, err)
+	}
+	return c.JSON(preferences)
+}
+
+// putPreferencesHandler replaces the authenticated user's preferences.
+func (s *Service) putPreferencesHandler(c *fiber.Ctx) error {
+	const op errors.Op = "server.Service.putPreferencesHandler"
+
+	reqCtx, err := getRequestContext(c)
+	if err != nil || reqCtx.User == nil {
+		err = errors.New(op).Err(err).Msg("Request context missing")
+		s.logger.ErrorWith().Err(err).Msg("getRequestContext failed")
+		return c.Status(fiber.StatusInternalServerError).JSON(jsonInternalError)
+	}
+
+	var preferences userPreferences
+	if err = c.BodyParser(&preferences); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(jsonBadRequest)
+	}
+	preferences.TimeZone = strings.TrimSpace(preferences.TimeZone)
+	if err = s.validate.Struct(preferences); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(jsonBadRequest)
+	}
+
+	if err = s.saveUserPreferences(c.UserContext(), reqCtx.User.ID, preferences); err != nil {
+		s.logger.ErrorWith().Err(errors.New(op).Err(err)).Msg("s.saveUserPreferences failed")
+		return s.dbFailure(c, err)
+	}
+	return c.JSON(preferences)
+}
+
+// readerLocation returns the time zone the request's reader wants QSO times in: that of the tz
+// query parameter, or else the preference of the user the API key was issued to, or of the
+// logbook's owner for the logbook's own keys. It is nil when neither is set, and ok is false when
+// the parameter names no time zone.
+func (s *Service) readerLocation(c *fiber.Ctx) (*time.Location, bool, error) {
+	const op errors.Op = "server.Service.readerLocation"
+
+	if tz := c.Query("tz"); tz != emptyString {
+		location, err := time.LoadLocation(tz)
+		return location, err == nil, nil
+	}
+
+	reqCtx, err := getRequestContext(c)
+	if err != nil {
+		return nil, true, errors.New(op).Err(err)
+	}
+	var userID int64
+	switch {
+	case reqCtx.Member != nil && reqCtx.Member.UserID != 0:
+		userID = reqCtx.Member.UserID
+	case reqCtx.Logbook != nil:
+		userID = reqCtx.Logbook.UserID
+	}
+	if userID == 0 {
+		return nil, true, nil
+	}
+
+	preferences, err := s.fetchUserPreferences(c.UserContext(), userID)
+	if err != nil {
+		return nil, true, errors.New(op).Err(err)
+	}
+	if preferences.TimeZone == emptyString {
+		return nil, true, nil
+	}
+	// The zone was checked when it was saved; one this server no longer knows is ignored.
+	location, err := time.LoadLocation(preferences.TimeZone)
+	if err != nil {
+		return nil, true, nil
+	}
+	return location, true, nil
+}
+
+// fetchUserPreferences returns the user's preferences, which are empty when none are set.
+func (s *Service) fetchUserPreferences(ctx context.Context, userID int64) (userPreferences, error) {
+	const op errors.Op = "server.Service.fetchUserPreferences"
+
+	rows, err := s.db.QueryContext(ctx, `SELECT time_zone FROM user_preferences WHERE user_id = $1`, userID)
+	if err != nil {
+		return userPreferences{}, errors.New(op).Err(err)
+	}
+	defer func() { _ = rows.Close() }()
+
+	var preferences userPreferences
+	if rows.Next() {
+		if err = rows.Scan(&preferences.TimeZone); err != nil {
+			return userPreferences{}, errors.New(op).Err(err)
+		}
+	}
+	if err = rows.Err(); err != nil {
+		return userPreferences{}, errors.New(op).Err(err)
+	}
+	return preferences, nil
+}
+
+// saveUserPreferences sets the user's preferences.
+func (s *Service) saveUserPreferences(ctx context.Context, userID int64, preferences userPreferences) error {
+	const op errors.Op = "server.Service.saveUserPreferences"
+
+	if _, err := s.db.ExecContext(ctx, `INSERT INTO user_preferences (user_id, time_zone) VALUES ($1, $2)
+		ON CONFLICT (user_id) DO UPDATE SET time_zone = excluded.time_zone`, userID, preferences.TimeZone); err != nil {
+		return errors.New(op).Err(err)
+	}
+	return nil
+}
@@ -0,0 +1,103 @@
+package service
+
+import (
+	"context"
+	"os"
+	"slices"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/Station-Manager/errors"
+	"github.com/gofiber/fiber/v2"
+)
+
+// preforkPollInterval is how often Shutdown checks whether the prefork children have exited.
+const preforkPollInterval = 100 * time.Millisecond
+
+// IsPreforkChild reports whether this process is one of the children started by a server in
+// prefork mode. A child serves HTTP only: the master migrates the schema and runs the redirect and
+// gRPC listeners.
+func IsPreforkChild() bool {
+	return fiber.IsChild()
+}
+
+// preforkChildren holds the process IDs of the children a prefork master has started.
+type preforkChildren struct {
+	mu   sync.Mutex
+	pids []int
+}
+
+func (p *preforkChildren) add(pid int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.pids = append(p.pids, pid)
+}
+
+func (p *preforkChildren) list() []int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return slices.Clone(p.pids)
+}
+
+// servePrefork serves HTTP in prefork mode. In the master it starts a child per CPU and returns
+// when one of them exits; it reports ready once the children have been started. In a child it
+// serves connections accepted on the shared port.
+func (s *Service) servePrefork(addr string) error {
+	const op errors.Op = "server.Service.servePrefork"
+
+	child := IsPreforkChild()
+	if !child {
+		s.app.Hooks().OnFork(func(pid int) error {
+			s.prefork.add(pid)
+			return nil
+		})
+		s.app.Hooks().OnListen(func(fiber.ListenData) error {
+			s.notifyReady()
+			return nil
+		})
+	}
+
+	var err error
+	if s.config.TLSEnabled {
+		err = s.app.ListenTLS(addr, s.config.TLSCertFile, s.config.TLSKeyFile)
+	} else {
+		err = s.app.Listen(addr)
+	}
+	if err != nil {
+		if child {
+			return s.startupError(StartupFailureBind, errors.New(op).Err(err))
+		}
+		return errors.New(op).Err(err).Msg("A prefork child exited")
+	}
+	return nil
+}
+
+// stopPreforkChildren asks the prefork children to shut down and waits until they have exited or
+// the context expires, returning the number still running. Fiber stops the remaining children once
+// the first has exited, so they are signalled together to drain side by side.
+func (s *Service) stopPreforkChildren(ctx context.Context) int {
+	var running []*os.Process
+	for _, pid := range s.prefork.list() {
+		process, err := os.FindProcess(pid)
+		if err != nil {
+			continue
+		}
+		if err = process.Signal(syscall.SIGTERM); err != nil {
+			// It has already exited.
+			continue
+		}
+		running = append(running, process)
+	}
+
+	for i, process := range running {
+		for process.Signal(syscall.Signal(0)) == nil {
+			select {
+			case <-ctx.Done():
+				return len(running) - i
+			case <-time.After(preforkPollInterval):
+			}
+		}
+	}
+	return 0
+}
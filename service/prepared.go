@@ -0,0 +1,411 @@
+package service
+
+import (
+	"context"
+	"database/sql"
+	stderr "errors"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Station-Manager/adapters"
+	"github.com/Station-Manager/database"
+	pgmodels "github.com/Station-Manager/database/postgres/models"
+	sqmodels "github.com/Station-Manager/database/sqlite/models"
+	"github.com/Station-Manager/errors"
+	"github.com/Station-Manager/types"
+	"github.com/aarondl/sqlboiler/v4/boil"
+)
+
+// The hottest store operations, the API key and logbook lookups behind authentication and the QSO
+// insert, are run as prepared statements that are reused across requests, so that PostgreSQL
+// parses and plans them once per connection rather than on every call, and lib/pq saves a round
+// trip per call. The database service does not expose its connection pool, so the statements are
+// prepared on a small pool of the server's own, opened and closed with the database. Its
+// connections are taken out of the configured MaxOpenConns, which the database service's pool is
+// opened with less them, so that the server holds no more connections than configured.
+//
+// SQLite is left to the database service: its single connection is what serializes writes, and
+// preparing a statement there costs no round trip.
+
+// envPreparedStatements names the environment variable that turns the prepared statements off
+// when set to false.
+const envPreparedStatements = "SM_DB_PREPARED_STATEMENTS"
+
+// envPreparedConns names the environment variable holding how many of the configured connections
+// the prepared statements' pool takes (e.g. "4").
+const envPreparedConns = "SM_DB_PREPARED_CONNS"
+
+const (
+	defaultPreparedConns = 4
+	// minMainPoolConns is the fewest connections left to the database service's pool, the least it
+	// accepts for PostgreSQL. With fewer configured, the prepared statements are not used.
+	minMainPoolConns = 5
+)
+
+// maxPreparedStatements bounds the statements kept prepared. The operations build a handful of
+// distinct queries; the bound only guards against a model whose queries vary with their values.
+const maxPreparedStatements = 64
+
+// loadPreparedStatements reads whether the prepared statements are enabled from the environment.
+func loadPreparedStatements() (bool, error) {
+	const op errors.Op = "server.loadPreparedStatements"
+
+	value := strings.TrimSpace(os.Getenv(envPreparedStatements))
+	if value == emptyString {
+		return true, nil
+	}
+	enabled, err := strconv.ParseBool(value)
+	if err != nil {
+		return false, errors.New(op).Msg(envPreparedStatements + " must be true or false")
+	}
+	return enabled, nil
+}
+
+// loadPreparedConns reads how many connections the prepared statements' pool takes from the
+// environment.
+func loadPreparedConns() (int, error) {
+	const op errors.Op = "server.loadPreparedConns"
+
+	value := strings.TrimSpace(os.Getenv(envPreparedConns))
+	if value == emptyString {
+		return defaultPreparedConns, nil
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil || n < 1 {
+		return 0, errors.New(op).Msg(envPreparedConns + " must be a positive number")
+	}
+	return n, nil
+}
+
+// splitPoolConns divides maxOpen connections between the database service's pool and the prepared
+// statements' pool, which asks for conns. The main pool keeps at least minMainPoolConns; prepared
+// is zero if that leaves none over. A non-positive maxOpen is unlimited and is left so.
+func splitPoolConns(maxOpen, conns int) (main, prepared int) {
+	if maxOpen <= 0 {
+		return maxOpen, conns
+	}
+	prepared = max(min(conns, maxOpen-minMainPoolConns), 0)
+	return maxOpen - prepared, prepared
+}
+
+// stmtCache is an executor that prepares each query it is given once and reuses the statement,
+// which database/sql prepares again on each connection of the pool it is used on.
+type stmtCache struct {
+	db *sql.DB
+
+	mu    sync.RWMutex
+	stmts map[string]*sql.Stmt
+}
+
+func newStmtCache(db *sql.DB) *stmtCache {
+	return &stmtCache{db: db, stmts: make(map[string]*sql.Stmt)}
+}
+
+// prepare returns the statement for query, preparing it if it is new. It returns nil, to run the
+// query unprepared, when the cache is full.
+func (c *stmtCache) prepare(ctx context.Context, query string) (*sql.Stmt, error) {
+	c.mu.RLock()
+	stmt, ok := c.stmts[query]
+	full := len(c.stmts) >= maxPreparedStatements
+	c.mu.RUnlock()
+	if ok || full {
+		return stmt, nil
+	}
+
+	stmt, err := c.db.PrepareContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if existing, ok := c.stmts[query]; ok {
+		// Prepared concurrently by another call.
+		_ = stmt.Close()
+		return existing, nil
+	}
+	c.stmts[query] = stmt
+	return stmt, nil
+}
+
+func (c *stmtCache) ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error) {
+	stmt, err := c.prepare(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	if stmt == nil {
+		return c.db.ExecContext(ctx, query, args...)
+	}
+	return stmt.ExecContext(ctx, args...)
+}
+
+func (c *stmtCache) QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error) {
+	stmt, err := c.prepare(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	if stmt == nil {
+		return c.db.QueryContext(ctx, query, args...)
+	}
+	return stmt.QueryContext(ctx, args...)
+}
+
+func (c *stmtCache) QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row {
+	stmt, err := c.prepare(ctx, query)
+	if err != nil || stmt == nil {
+		// The row carries the preparation error, if any, to Scan.
+		return c.db.QueryRowContext(ctx, query, args...)
+	}
+	return stmt.QueryRowContext(ctx, args...)
+}
+
+func (c *stmtCache) Exec(query string, args ...any) (sql.Result, error) {
+	return c.ExecContext(context.Background(), query, args...)
+}
+
+func (c *stmtCache) Query(query string, args ...any) (*sql.Rows, error) {
+	return c.QueryContext(context.Background(), query, args...)
+}
+
+func (c *stmtCache) QueryRow(query string, args ...any) *sql.Row {
+	return c.QueryRowContext(context.Background(), query, args...)
+}
+
+// len returns the number of statements prepared.
+func (c *stmtCache) len() int {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return len(c.stmts)
+}
+
+// close closes the statements and the pool.
+func (c *stmtCache) close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for query, stmt := range c.stmts {
+		_ = stmt.Close()
+		delete(c.stmts, query)
+	}
+	return c.db.Close()
+}
+
+// preparedStore runs the API key and logbook lookups and the QSO insert with prepared statements,
+// as the database service would run them, while its pool is open. The other operations, and all
+// of them while it is closed, are passed through.
+type preparedStore struct {
+	store
+	cfg     *types.DatastoreConfig
+	enabled bool
+	// conns is how many of the configured connections the statements' pool takes.
+	conns int
+	// maxOpen and maxIdle are the pool limits as configured, before the split.
+	maxOpen, maxIdle int
+
+	// toModel and fromModel convert as the database service does when inserting and fetching.
+	toModel   *adapters.Adapter
+	fromModel *adapters.Adapter
+
+	mu    sync.RWMutex
+	stmts *stmtCache
+}
+
+func newPreparedStore(db store, cfg *types.DatastoreConfig, enabled bool) *preparedStore {
+	isPostgres := cfg != nil && cfg.Driver == database.PostgresDriver
+	p := &preparedStore{
+		store:     db,
+		cfg:       cfg,
+		enabled:   enabled && isPostgres,
+		conns:     defaultPreparedConns,
+		toModel:   newQsoModelAdapter(isPostgres),
+		fromModel: newQsoTypeAdapter(isPostgres),
+	}
+	if cfg != nil {
+		p.maxOpen, p.maxIdle = cfg.MaxOpenConns, cfg.MaxIdleConns
+	}
+	return p
+}
+
+// Open takes the statements' connections out of the configured limit, opens the database with the
+// rest, then opens the pool the statements are prepared on.
+func (p *preparedStore) Open() error {
+	const op errors.Op = "server.preparedStore.Open"
+
+	conns := 0
+	if p.enabled {
+		var mainConns int
+		mainConns, conns = splitPoolConns(p.maxOpen, p.conns)
+		p.cfg.MaxOpenConns = mainConns
+		if mainConns > 0 {
+			p.cfg.MaxIdleConns = min(p.maxIdle, mainConns)
+		}
+	}
+	if err := p.store.Open(); err != nil {
+		return err
+	}
+	if conns == 0 {
+		return nil
+	}
+
+	db, err := sql.Open(database.PostgresDriver, postgresDsn(p.cfg))
+	if err != nil {
+		_ = p.store.Close()
+		return errors.New(op).Err(err)
+	}
+	db.SetMaxOpenConns(conns)
+	db.SetMaxIdleConns(min(p.maxIdle, conns))
+	db.SetConnMaxLifetime(time.Duration(p.cfg.ConnMaxLifetime) * time.Minute)
+	db.SetConnMaxIdleTime(time.Duration(p.cfg.ConnMaxIdleTime) * time.Minute)
+
+	ctx, cancel := p.withDefaultTimeout(context.Background())
+	defer cancel()
+	if err = db.PingContext(ctx); err != nil {
+		_ = db.Close()
+		_ = p.store.Close()
+		return errors.New(op).Err(err)
+	}
+
+	p.setStmts(newStmtCache(db))
+	return nil
+}
+
+// Close closes the statements and their pool, then the database.
+func (p *preparedStore) Close() error {
+	if stmts := p.setStmts(nil); stmts != nil {
+		_ = stmts.close()
+	}
+	return p.store.Close()
+}
+
+// setStmts replaces the statement cache and returns the one it replaced.
+func (p *preparedStore) setStmts(stmts *stmtCache) *stmtCache {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	previous := p.stmts
+	p.stmts = stmts
+	return previous
+}
+
+// executor returns the statement cache, or nil while the pool is closed.
+func (p *preparedStore) executor() *stmtCache {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return p.stmts
+}
+
+// isPostgres reports whether the statements are run with the PostgreSQL models.
+func (p *preparedStore) isPostgres() bool {
+	return p.cfg != nil && p.cfg.Driver == database.PostgresDriver
+}
+
+// withDefaultTimeout bounds ctx by the configured timeout, as the database service does, unless it
+// has a deadline already.
+func (p *preparedStore) withDefaultTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	if _, ok := ctx.Deadline(); ok || p.cfg == nil || p.cfg.ContextTimeout <= 0 {
+		return ctx, func() {}
+	}
+	return context.WithTimeout(ctx, time.Duration(p.cfg.ContextTimeout)*time.Second)
+}
+
+func (p *preparedStore) FetchAPIKeyByPrefixContext(ctx context.Context, prefix string) (types.ApiKey, error) {
+	const op errors.Op = "server.preparedStore.FetchAPIKeyByPrefixContext"
+
+	ex := p.executor()
+	if ex == nil {
+		return p.store.FetchAPIKeyByPrefixContext(ctx, prefix)
+	}
+	ctx, cancel := p.withDefaultTimeout(ctx)
+	defer cancel()
+
+	// The database service reads API keys with the PostgreSQL model whatever the driver.
+	model, err := pgmodels.APIKeys(pgmodels.APIKeyWhere.KeyPrefix.EQ(prefix)).One(ctx, ex)
+	if err != nil && !stderr.Is(err, sql.ErrNoRows) {
+		return types.ApiKey{}, errors.New(op).Err(err)
+	}
+	if model == nil || err != nil {
+		// Worded as the database service words it, for isApiKeyNotFound.
+		return types.ApiKey{}, errors.New(op).Err(err).Errorf("prefix not found: %s", prefix)
+	}
+	var key types.ApiKey
+	if err = adapters.New().Into(&key, model); err != nil {
+		return types.ApiKey{}, errors.New(op).Err(err)
+	}
+	return key, nil
+}
+
+func (p *preparedStore) FetchLogbookByIDContext(ctx context.Context, id int64) (types.Logbook, error) {
+	const op errors.Op = "server.preparedStore.FetchLogbookByIDContext"
+
+	ex := p.executor()
+	if ex == nil {
+		return p.store.FetchLogbookByIDContext(ctx, id)
+	}
+	ctx, cancel := p.withDefaultTimeout(ctx)
+	defer cancel()
+
+	var (
+		model any
+		err   error
+	)
+	if p.isPostgres() {
+		model, err = pgmodels.FindLogbook(ctx, ex, id)
+	} else {
+		model, err = sqmodels.FindLogbook(ctx, ex, id)
+	}
+	if stderr.Is(err, sql.ErrNoRows) {
+		return types.Logbook{}, errors.New(op).Err(err).Errorf("logbook not found: %d", id)
+	}
+	if err != nil {
+		return types.Logbook{}, errors.New(op).Err(err)
+	}
+	var logbook types.Logbook
+	if err = p.fromModel.Into(&logbook, model); err != nil {
+		return types.Logbook{}, errors.New(op).Err(err)
+	}
+	return logbook, nil
+}
+
+func (p *preparedStore) InsertQsoContext(ctx context.Context, qso types.Qso) (types.Qso, error) {
+	const op errors.Op = "server.preparedStore.InsertQsoContext"
+
+	ex := p.executor()
+	if ex == nil {
+		return p.store.InsertQsoContext(ctx, qso)
+	}
+	if qso.LogbookID < 1 {
+		return qso, errors.New(op).Msg("LogbookID is required")
+	}
+	ctx, cancel := p.withDefaultTimeout(ctx)
+	defer cancel()
+
+	if p.isPostgres() {
+		m, err := adapters.AdaptTo[pgmodels.Qso](p.toModel, &qso)
+		if err != nil {
+			return qso, errors.New(op).Err(err)
+		}
+		if err = m.Insert(ctx, ex, boil.Infer()); err != nil {
+			return qso, errors.New(op).Err(err)
+		}
+		qso.ID = m.ID
+		return qso, nil
+	}
+
+	// The SQLite schema requires every QSO to belong to a session.
+	if qso.SessionID < 1 {
+		return qso, errors.New(op).Msg("SessionID is required")
+	}
+	m, err := adapters.AdaptTo[sqmodels.Qso](p.toModel, &qso)
+	if err != nil {
+		return qso, errors.New(op).Err(err)
+	}
+	if len(m.AdditionalData) == 0 {
+		m.AdditionalData = []byte("{}")
+	}
+	if err = m.Insert(ctx, ex, boil.Infer()); err != nil {
+		return qso, errors.New(op).Err(err)
+	}
+	qso.ID = m.ID
+	return qso, nil
+}
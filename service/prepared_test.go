@@ -0,0 +1,201 @@
+package service
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+
+	"github.com/Station-Manager/database"
+	"github.com/Station-Manager/types"
+)
+
+// newTestPreparedStore returns a prepared store over a sqlite-backed database service, with its
+// statements prepared on a second connection to the database file. Outside tests the statements
+// are only prepared with PostgreSQL.
+func newTestPreparedStore(tb testing.TB) (*preparedStore, *database.Service) {
+	tb.Helper()
+
+	dbSvc := newTestDatabaseService(tb)
+	tb.Cleanup(func() { _ = dbSvc.Close() })
+	svc := &Service{db: dbSvc, logger: dbSvc.Logger}
+	if err := svc.migrateServerSchema(context.Background()); err != nil {
+		tb.Fatalf("migrateServerSchema failed: %v", err)
+	}
+
+	db, err := sql.Open(database.SqliteDriver, dbSvc.DatabaseConfig.Path)
+	if err != nil {
+		tb.Fatalf("sql.Open failed: %v", err)
+	}
+	db.SetMaxOpenConns(1)
+	if _, err = db.Exec(`PRAGMA busy_timeout=5000`); err != nil {
+		tb.Fatalf("PRAGMA failed: %v", err)
+	}
+	if _, err = db.Exec(`INSERT OR IGNORE INTO session (id) VALUES (1)`); err != nil {
+		tb.Fatalf("insert session failed: %v", err)
+	}
+
+	p := newPreparedStore(dbSvc, dbSvc.DatabaseConfig, true)
+	p.setStmts(newStmtCache(db))
+	tb.Cleanup(func() {
+		if stmts := p.setStmts(nil); stmts != nil {
+			_ = stmts.close()
+		}
+	})
+	return p, dbSvc
+}
+
+func testPreparedQso(call string) types.Qso {
+	qso := types.Qso{LogbookID: 1, SessionID: 1}
+	qso.Call, qso.Band, qso.Mode, qso.Freq = call, "20m", "FT8", "14.074"
+	qso.QsoDate, qso.TimeOn, qso.TimeOff, qso.RstSent, qso.RstRcvd = "20240430", "1203", "1204", "-10", "-12"
+	return qso
+}
+
+func TestLoadPreparedStatements(t *testing.T) {
+	for value, want := range map[string]bool{"": true, "true": true, "false": false, "0": false} {
+		t.Setenv(envPreparedStatements, value)
+		got, err := loadPreparedStatements()
+		if err != nil || got != want {
+			t.Errorf("%q: expected %v, got %v (%v)", value, want, got, err)
+		}
+	}
+	t.Setenv(envPreparedStatements, "sometimes")
+	if _, err := loadPreparedStatements(); err == nil {
+		t.Error("expected an invalid value to be rejected")
+	}
+}
+
+func TestLoadPreparedConns(t *testing.T) {
+	for value, want := range map[string]int{"": defaultPreparedConns, "2": 2} {
+		t.Setenv(envPreparedConns, value)
+		got, err := loadPreparedConns()
+		if err != nil || got != want {
+			t.Errorf("%q: expected %d, got %d (%v)", value, want, got, err)
+		}
+	}
+	for _, value := range []string{"0", "few"} {
+		t.Setenv(envPreparedConns, value)
+		if _, err := loadPreparedConns(); err == nil {
+			t.Errorf("expected %q to be rejected", value)
+		}
+	}
+}
+
+func TestSplitPoolConns(t *testing.T) {
+	for _, tc := range []struct{ maxOpen, conns, main, prepared int }{
+		{maxOpen: 25, conns: 4, main: 21, prepared: 4},
+		{maxOpen: 7, conns: 4, main: minMainPoolConns, prepared: 2},
+		{maxOpen: minMainPoolConns, conns: 4, main: minMainPoolConns, prepared: 0},
+		{maxOpen: 0, conns: 4, main: 0, prepared: 4},
+	} {
+		main, prepared := splitPoolConns(tc.maxOpen, tc.conns)
+		if main != tc.main || prepared != tc.prepared {
+			t.Errorf("%d split for %d: expected %d+%d, got %d+%d", tc.maxOpen, tc.conns, tc.main, tc.prepared, main, prepared)
+		}
+		if tc.maxOpen > 0 && main+prepared != tc.maxOpen {
+			t.Errorf("%d split for %d: expected the configured total, got %d", tc.maxOpen, tc.conns, main+prepared)
+		}
+	}
+}
+
+func TestPreparedStore_OnlyWithPostgres(t *testing.T) {
+	sqlite := &types.DatastoreConfig{Driver: database.SqliteDriver}
+	if newPreparedStore(nil, sqlite, true).enabled {
+		t.Error("expected the prepared statements to be left to the database service with SQLite")
+	}
+	postgres := &types.DatastoreConfig{Driver: database.PostgresDriver}
+	if !newPreparedStore(nil, postgres, true).enabled || newPreparedStore(nil, postgres, false).enabled {
+		t.Error("expected the prepared statements to follow the setting with PostgreSQL")
+	}
+}
+
+func TestPreparedStore(t *testing.T) {
+	p, dbSvc := newTestPreparedStore(t)
+	ctx := context.Background()
+
+	var ids []int64
+	for _, call := range []string{"JA1XX", "K1AB"} {
+		qso, err := p.InsertQsoContext(ctx, testPreparedQso(call))
+		if err != nil {
+			t.Fatalf("InsertQsoContext failed: %v", err)
+		}
+		ids = append(ids, qso.ID)
+	}
+	if ids[0] == 0 || ids[1] == ids[0] {
+		t.Fatalf("expected distinct IDs, got %v", ids)
+	}
+	viaDatabase, err := dbSvc.InsertQsoContext(ctx, testPreparedQso("K1AB"))
+	if err != nil {
+		t.Fatalf("InsertQsoContext failed: %v", err)
+	}
+	want, _ := dbSvc.FetchQsoByIdContext(ctx, viaDatabase.ID)
+	got, err := dbSvc.FetchQsoByIdContext(ctx, ids[1])
+	if err != nil || got.Call != "K1AB" || got.QsoDetails != want.QsoDetails {
+		t.Errorf("expected the QSO to be stored as the database service stores it, got %+v (%v)", got.QsoDetails, err)
+	}
+	if n := p.executor().len(); n != 1 {
+		t.Errorf("expected the insert to be prepared once, got %d statements", n)
+	}
+
+	wantLogbook, err := dbSvc.FetchLogbookByIDContext(ctx, 1)
+	if err != nil {
+		t.Fatalf("FetchLogbookByIDContext failed: %v", err)
+	}
+	if logbook, err := p.FetchLogbookByIDContext(ctx, 1); err != nil || logbook != wantLogbook {
+		t.Errorf("expected %+v, got %+v (%v)", wantLogbook, logbook, err)
+	}
+	if _, err = p.FetchLogbookByIDContext(ctx, 999); err == nil {
+		t.Error("expected an unknown logbook to fail")
+	}
+
+	if _, err = dbSvc.ExecContext(ctx, `INSERT INTO api_keys (logbook_id, key_name, key_prefix, key_hash) VALUES (1, 'laptop', 'abc123', 'key-hash')`); err != nil {
+		t.Fatalf("insert API key failed: %v", err)
+	}
+	wantKey, err := dbSvc.FetchAPIKeyByPrefixContext(ctx, "abc123")
+	if err != nil {
+		t.Fatalf("FetchAPIKeyByPrefixContext failed: %v", err)
+	}
+	if key, err := p.FetchAPIKeyByPrefixContext(ctx, "abc123"); err != nil || key != wantKey {
+		t.Errorf("expected %+v, got %+v (%v)", wantKey, key, err)
+	}
+	if _, err = p.FetchAPIKeyByPrefixContext(ctx, "unknown0"); !isApiKeyNotFound(err) {
+		t.Errorf("expected an unknown prefix to be reported as not found, got %v", err)
+	}
+
+	if n := p.executor().len(); n != 3 {
+		t.Errorf("expected one statement per query, got %d", n)
+	}
+}
+
+func TestPreparedStore_PassesThroughWhenClosed(t *testing.T) {
+	dbSvc := newTestDatabaseService(t)
+	defer func() { _ = dbSvc.Close() }()
+
+	p := newPreparedStore(dbSvc, dbSvc.DatabaseConfig, true)
+	if _, err := p.FetchLogbookByIDContext(context.Background(), 1); err != nil {
+		t.Errorf("expected the lookup to be passed to the database service, got %v", err)
+	}
+}
+
+// BenchmarkInsertQso compares inserting a QSO through the database service, which prepares the
+// insert on every call, with inserting it through the prepared store.
+func BenchmarkInsertQso(b *testing.B) {
+	p, dbSvc := newTestPreparedStore(b)
+	ctx := context.Background()
+	qso := testPreparedQso("JA1XX")
+
+	b.Run("database", func(b *testing.B) {
+		for b.Loop() {
+			if _, err := dbSvc.InsertQsoContext(ctx, qso); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	b.Run("prepared", func(b *testing.B) {
+		for b.Loop() {
+			if _, err := p.InsertQsoContext(ctx, qso); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
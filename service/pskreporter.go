@@ -0,0 +1,245 @@
+package service
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"math"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Station-Manager/errors"
+	"github.com/Station-Manager/server/service/pskreporter"
+	"github.com/Station-Manager/types"
+)
+
+const (
+	defaultPskReporterAddr = "report.pskreporter.info:4739"
+	// PSK Reporter asks clients to send reports no more often than every five minutes.
+	defaultPskReporterFlushInterval = 5 * time.Minute
+	pskReporterQueueSize            = 1024
+	// pskReporterMaxPending bounds the spots held between flushes; further spots are dropped.
+	pskReporterMaxPending = 10000
+)
+
+// pskReporterSettings records that a logbook reports its digital QSOs to PSK Reporter. Locator
+// and Antenna describe the receiving station; Locator is used when a QSO has no MY_GRIDSQUARE.
+type pskReporterSettings struct {
+	LogbookID int64
+	Locator   string
+	Antenna   string
+}
+
+// pskReporterClient batches spots derived from digital-mode QSOs and sends them to PSK Reporter
+// over UDP. pending is only touched by the runPskReporter goroutine.
+type pskReporterClient struct {
+	addr          string
+	flushInterval time.Duration
+	queue         chan qsoEvent
+	encoder       *pskreporter.Encoder
+	pending       map[pskreporter.Receiver][]pskreporter.Spot
+	pendingCount  int
+}
+
+func newPskReporterClient() *pskReporterClient {
+	var domain [4]byte
+	_, _ = rand.Read(domain[:])
+	return &pskReporterClient{
+		addr:          defaultPskReporterAddr,
+		flushInterval: defaultPskReporterFlushInterval,
+		queue:         make(chan qsoEvent, pskReporterQueueSize),
+		encoder:       pskreporter.NewEncoder(binary.BigEndian.Uint32(domain[:])),
+		pending:       make(map[pskreporter.Receiver][]pskreporter.Spot),
+	}
+}
+
+// enqueuePskReport queues newly inserted digital-mode QSOs. It is registered with the QSO event
+// broker and must not block; reports are best effort, so a full queue only drops the spot.
+func (s *Service) enqueuePskReport(event qsoEvent) {
+	if event.Type != qsoEventInserted || modeGroup(event.Qso.Mode) != "DATA" {
+		return
+	}
+	select {
+	case s.pskReporter.queue <- event:
+	default:
+		s.logger.DebugWith().Int64("qso_id", event.Qso.ID).Msg("PSK Reporter queue full; spot dropped")
+	}
+}
+
+// runPskReporter collects spots for opted-in logbooks and flushes them periodically until ctx is
+// cancelled, when anything still pending is sent.
+func (s *Service) runPskReporter(ctx context.Context) {
+	ticker := time.NewTicker(s.pskReporter.flushInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			s.flushPskReports()
+			return
+		case event := <-s.pskReporter.queue:
+			if err := s.collectPskSpot(ctx, event); err != nil {
+				s.logger.WarnWith().Err(err).Int64("qso_id", event.Qso.ID).Msg("Failed to collect PSK Reporter spot")
+			}
+		case <-ticker.C:
+			s.flushPskReports()
+		}
+	}
+}
+
+// collectPskSpot adds the QSO to the pending reports if its logbook has opted in.
+func (s *Service) collectPskSpot(ctx context.Context, event qsoEvent) error {
+	const op errors.Op = "server.Service.collectPskSpot"
+
+	settings, found, err := s.fetchPskReporterSettings(ctx, event.LogbookID)
+	if err != nil {
+		return errors.New(op).Err(err)
+	}
+	if !found {
+		return nil
+	}
+	spot, ok := pskSpotFromQso(event.Qso)
+	if !ok {
+		return nil
+	}
+	logbook, err := s.fetchLogbookWithCache(ctx, event.LogbookID)
+	if err != nil {
+		return errors.New(op).Err(err)
+	}
+
+	receiver := pskreporter.Receiver{
+		Callsign: event.Qso.StationCallsign,
+		Locator:  event.Qso.MyGridsquare,
+		Software: adifProgramID,
+		Antenna:  settings.Antenna,
+	}
+	if receiver.Callsign == emptyString {
+		receiver.Callsign = logbook.Callsign
+	}
+	if receiver.Locator == emptyString {
+		receiver.Locator = settings.Locator
+	}
+
+	p := s.pskReporter
+	if p.pendingCount >= pskReporterMaxPending {
+		s.logger.WarnWith().Int64("qso_id", event.Qso.ID).Msg("Too many pending PSK Reporter spots; spot dropped")
+		return nil
+	}
+	p.pending[receiver] = append(p.pending[receiver], spot)
+	p.pendingCount++
+	return nil
+}
+
+// flushPskReports sends the pending spots. Reports are best effort: spots that cannot be sent are
+// dropped rather than retried.
+func (s *Service) flushPskReports() {
+	const op errors.Op = "server.Service.flushPskReports"
+
+	p := s.pskReporter
+	if p.pendingCount == 0 {
+		return
+	}
+	defer func() {
+		p.pending = make(map[pskreporter.Receiver][]pskreporter.Spot)
+		p.pendingCount = 0
+	}()
+
+	conn, err := net.Dial("udp", p.addr)
+	if err != nil {
+		s.logger.WarnWith().Err(errors.New(op).Err(err)).Int("spots", p.pendingCount).Msg("Failed to reach PSK Reporter")
+		return
+	}
+	defer func() { _ = conn.Close() }()
+
+	now := time.Now()
+	for receiver, spots := range p.pending {
+		for _, packet := range p.encoder.Encode(now, receiver, spots) {
+			if _, err = conn.Write(packet); err != nil {
+				s.logger.WarnWith().Err(errors.New(op).Err(err)).Str("receiver", receiver.Callsign).Msg("Failed to send PSK Reporter report")
+				break
+			}
+		}
+	}
+	s.logger.DebugWith().Int("spots", p.pendingCount).Msg("PSK Reporter reports sent")
+}
+
+// pskSpotFromQso converts a QSO to a spot. QSOs without a usable frequency or start time cannot be
+// reported.
+func pskSpotFromQso(qso types.Qso) (pskreporter.Spot, bool) {
+	mhz, err := strconv.ParseFloat(strings.TrimSpace(qso.Freq), 64)
+	if err != nil || mhz <= 0 || mhz*1e6 > math.MaxUint32 {
+		return pskreporter.Spot{}, false
+	}
+	timeOn := qso.TimeOn
+	if len(timeOn) == 4 {
+		timeOn += "00"
+	}
+	at, err := time.Parse("20060102150405", qso.QsoDate+timeOn)
+	if err != nil {
+		return pskreporter.Spot{}, false
+	}
+
+	mode := qso.Mode
+	if qso.Submode != emptyString {
+		mode = qso.Submode
+	}
+	return pskreporter.Spot{
+		Callsign:    strings.ToUpper(qso.Call),
+		Locator:     qso.Gridsquare,
+		FrequencyHz: uint32(math.Round(mhz * 1e6)),
+		Mode:        strings.ToUpper(mode),
+		Time:        at,
+	}, true
+}
+
+// fetchPskReporterSettings returns the logbook's PSK Reporter settings, if it has opted in.
+func (s *Service) fetchPskReporterSettings(ctx context.Context, logbookID int64) (pskReporterSettings, bool, error) {
+	const op errors.Op = "server.Service.fetchPskReporterSettings"
+
+	rows, err := s.db.QueryContext(ctx, `SELECT logbook_id, locator, antenna FROM pskreporter_logbooks WHERE logbook_id = $1`, logbookID)
+	if err != nil {
+		return pskReporterSettings{}, false, errors.New(op).Err(err)
+	}
+	defer func() { _ = rows.Close() }()
+
+	if !rows.Next() {
+		if err = rows.Err(); err != nil {
+			return pskReporterSettings{}, false, errors.New(op).Err(err)
+		}
+		return pskReporterSettings{}, false, nil
+	}
+	var settings pskReporterSettings
+	if err = rows.Scan(&settings.LogbookID, &settings.Locator, &settings.Antenna); err != nil {
+		return pskReporterSettings{}, false, errors.New(op).Err(err)
+	}
+	return settings, true, nil
+}
+
+// savePskReporterSettings opts the logbook in to PSK Reporter, or updates its settings.
+func (s *Service) savePskReporterSettings(ctx context.Context, settings pskReporterSettings) error {
+	const op errors.Op = "server.Service.savePskReporterSettings"
+
+	if _, err := s.db.ExecContext(ctx, `INSERT INTO pskreporter_logbooks (logbook_id, locator, antenna) VALUES ($1, $2, $3)
+		ON CONFLICT (logbook_id) DO UPDATE SET locator = excluded.locator, antenna = excluded.antenna`,
+		settings.LogbookID, settings.Locator, settings.Antenna); err != nil {
+		return errors.New(op).Err(err)
+	}
+	return nil
+}
+
+// deletePskReporterSettings opts the logbook out of PSK Reporter.
+func (s *Service) deletePskReporterSettings(ctx context.Context, logbookID int64) (bool, error) {
+	const op errors.Op = "server.Service.deletePskReporterSettings"
+
+	res, err := s.db.ExecContext(ctx, `DELETE FROM pskreporter_logbooks WHERE logbook_id = $1`, logbookID)
+	if err != nil {
+		return false, errors.New(op).Err(err)
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return false, errors.New(op).Err(err)
+	}
+	return n > 0, nil
+}
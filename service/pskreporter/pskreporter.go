@@ -0,0 +1,182 @@
+// Package pskreporter encodes reception reports in the IPFIX format accepted by PSK Reporter
+// (https://pskreporter.info/pskdev.html).
+//
+// Every packet carries the receiver and sender templates followed by one receiver record and as
+// many sender records as fit, so packets can be sent over UDP independently of each other.
+package pskreporter
+
+import (
+	"encoding/binary"
+	"time"
+)
+
+// MaxPacketSize keeps packets below the usual path MTU.
+const MaxPacketSize = 1400
+
+const (
+	ipfixVersion = 10
+
+	enterpriseNumber = 30351
+
+	receiverTemplateID = 0x9992
+	senderTemplateID   = 0x9993
+
+	templateSetID        = 2
+	optionsTemplateSetID = 3
+
+	// variableLength marks a field whose length precedes its value.
+	variableLength = 0xFFFF
+
+	// InformationSourceLog marks reports derived from a logged QSO rather than a decoder.
+	InformationSourceLog = 2
+)
+
+// Field identifiers in the PSK Reporter enterprise space.
+const (
+	fieldSenderCallsign    = 1
+	fieldReceiverCallsign  = 2
+	fieldSenderLocator     = 3
+	fieldReceiverLocator   = 4
+	fieldFrequency         = 5
+	fieldDecodingSoftware  = 8
+	fieldAntenna           = 9
+	fieldMode              = 10
+	fieldInformationSource = 11
+
+	// flowStartSeconds is an IANA element and carries no enterprise number.
+	fieldFlowStartSeconds = 150
+)
+
+// Receiver describes the station that heard (or worked) the senders.
+type Receiver struct {
+	Callsign string
+	Locator  string
+	Software string
+	Antenna  string
+}
+
+// Spot is a single reception of a sender.
+type Spot struct {
+	Callsign    string
+	Locator     string
+	FrequencyHz uint32
+	Mode        string
+	Time        time.Time
+}
+
+// Encoder builds IPFIX packets. It tracks the sequence number, which counts the data records
+// sent before each packet, so one Encoder should be used per observation domain.
+type Encoder struct {
+	domain   uint32
+	sequence uint32
+}
+
+// NewEncoder returns an encoder for the observation domain, which should be random and stable for
+// the lifetime of the process.
+func NewEncoder(domain uint32) *Encoder {
+	return &Encoder{domain: domain}
+}
+
+// Encode returns the packets reporting spots for receiver. It returns nil when there are no spots.
+func (e *Encoder) Encode(now time.Time, receiver Receiver, spots []Spot) [][]byte {
+	var packets [][]byte
+	for len(spots) > 0 {
+		packet, n := e.encodePacket(now, receiver, spots)
+		packets = append(packets, packet)
+		spots = spots[n:]
+	}
+	return packets
+}
+
+// encodePacket encodes as many spots as fit into one packet and returns how many it used. At least
+// one spot is always included.
+func (e *Encoder) encodePacket(now time.Time, receiver Receiver, spots []Spot) ([]byte, int) {
+	buf := make([]byte, 16, MaxPacketSize)
+	buf = appendTemplates(buf)
+	buf = appendSet(buf, receiverTemplateID, appendReceiver(nil, receiver))
+
+	var records []byte
+	n := 0
+	for _, spot := range spots {
+		record := appendSpot(nil, spot)
+		// 4 bytes of set header and up to 3 bytes of padding.
+		if n > 0 && len(buf)+4+len(records)+len(record)+3 > MaxPacketSize {
+			break
+		}
+		records = append(records, record...)
+		n++
+	}
+	buf = appendSet(buf, senderTemplateID, records)
+
+	binary.BigEndian.PutUint16(buf[0:], ipfixVersion)
+	binary.BigEndian.PutUint16(buf[2:], uint16(len(buf)))
+	binary.BigEndian.PutUint32(buf[4:], uint32(now.Unix()))
+	binary.BigEndian.PutUint32(buf[8:], e.sequence)
+	binary.BigEndian.PutUint32(buf[12:], e.domain)
+
+	e.sequence += uint32(n) + 1 // the receiver record counts too
+	return buf, n
+}
+
+func appendTemplates(buf []byte) []byte {
+	// Options template: the receiver callsign is the scope field.
+	receiver := binary.BigEndian.AppendUint16(nil, receiverTemplateID)
+	receiver = binary.BigEndian.AppendUint16(receiver, 4) // field count
+	receiver = binary.BigEndian.AppendUint16(receiver, 1) // scope field count
+	for _, id := range []uint16{fieldReceiverCallsign, fieldReceiverLocator, fieldDecodingSoftware, fieldAntenna} {
+		receiver = appendEnterpriseField(receiver, id, variableLength)
+	}
+	buf = appendSet(buf, optionsTemplateSetID, receiver)
+
+	sender := binary.BigEndian.AppendUint16(nil, senderTemplateID)
+	sender = binary.BigEndian.AppendUint16(sender, 6) // field count
+	sender = appendEnterpriseField(sender, fieldSenderCallsign, variableLength)
+	sender = appendEnterpriseField(sender, fieldFrequency, 4)
+	sender = appendEnterpriseField(sender, fieldMode, variableLength)
+	sender = appendEnterpriseField(sender, fieldSenderLocator, variableLength)
+	sender = appendEnterpriseField(sender, fieldInformationSource, 1)
+	sender = binary.BigEndian.AppendUint16(sender, fieldFlowStartSeconds)
+	sender = binary.BigEndian.AppendUint16(sender, 4)
+	return appendSet(buf, templateSetID, sender)
+}
+
+func appendEnterpriseField(buf []byte, id, length uint16) []byte {
+	buf = binary.BigEndian.AppendUint16(buf, 0x8000|id)
+	buf = binary.BigEndian.AppendUint16(buf, length)
+	return binary.BigEndian.AppendUint32(buf, enterpriseNumber)
+}
+
+func appendReceiver(buf []byte, r Receiver) []byte {
+	buf = appendString(buf, r.Callsign)
+	buf = appendString(buf, r.Locator)
+	buf = appendString(buf, r.Software)
+	return appendString(buf, r.Antenna)
+}
+
+func appendSpot(buf []byte, s Spot) []byte {
+	buf = appendString(buf, s.Callsign)
+	buf = binary.BigEndian.AppendUint32(buf, s.FrequencyHz)
+	buf = appendString(buf, s.Mode)
+	buf = appendString(buf, s.Locator)
+	buf = append(buf, InformationSourceLog)
+	return binary.BigEndian.AppendUint32(buf, uint32(s.Time.Unix()))
+}
+
+// appendString writes a variable-length field. Values are short; anything over 254 bytes is
+// truncated so that the single-byte length form can be used.
+func appendString(buf []byte, s string) []byte {
+	if len(s) > 254 {
+		s = s[:254]
+	}
+	buf = append(buf, byte(len(s)))
+	return append(buf, s...)
+}
+
+// appendSet writes a set with its header, padded to a multiple of four bytes.
+func appendSet(buf []byte, id uint16, body []byte) []byte {
+	padding := (4 - len(body)%4) % 4
+	buf = binary.BigEndian.AppendUint16(buf, id)
+	buf = binary.BigEndian.AppendUint16(buf, uint16(4+len(body)+padding))
+	buf = append(buf, body...)
+	return append(buf, make([]byte, padding)...)
+}
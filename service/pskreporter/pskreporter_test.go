@@ -0,0 +1,98 @@
+package pskreporter
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"testing"
+	"time"
+)
+
+// readSets splits a packet into its sets, keyed by set ID in order of appearance.
+func readSets(t *testing.T, packet []byte) ([]uint16, [][]byte) {
+	t.Helper()
+	var ids []uint16
+	var bodies [][]byte
+	for rest := packet[16:]; len(rest) > 0; {
+		id, length := binary.BigEndian.Uint16(rest), int(binary.BigEndian.Uint16(rest[2:]))
+		if length < 4 || length > len(rest) || length%4 != 0 {
+			t.Fatalf("bad set length %d", length)
+		}
+		ids = append(ids, id)
+		bodies = append(bodies, rest[4:length])
+		rest = rest[length:]
+	}
+	return ids, bodies
+}
+
+func TestEncode_Packet(t *testing.T) {
+	now := time.Unix(1714478400, 0)
+	e := NewEncoder(0xDEADBEEF)
+	receiver := Receiver{Callsign: "7Q5MLV", Locator: "KH74", Software: "Station-Manager"}
+	spot := Spot{Callsign: "JA1XX", Locator: "PM95", FrequencyHz: 14074000, Mode: "FT8", Time: now.Add(-time.Minute)}
+
+	packets := e.Encode(now, receiver, []Spot{spot})
+	if len(packets) != 1 {
+		t.Fatalf("expected 1 packet, got %d", len(packets))
+	}
+	p := packets[0]
+	if binary.BigEndian.Uint16(p) != 10 || int(binary.BigEndian.Uint16(p[2:])) != len(p) ||
+		binary.BigEndian.Uint32(p[4:]) != uint32(now.Unix()) || binary.BigEndian.Uint32(p[12:]) != 0xDEADBEEF {
+		t.Fatalf("unexpected header % x", p[:16])
+	}
+
+	ids, bodies := readSets(t, p)
+	if fmt.Sprint(ids) != fmt.Sprint([]uint16{3, 2, 0x9992, 0x9993}) {
+		t.Fatalf("unexpected sets %x", ids)
+	}
+	// The receiver options template matches the example in the PSK Reporter documentation.
+	if len(bodies[0]) != 40 || !bytes.Equal(bodies[0][:10], []byte{0x99, 0x92, 0, 4, 0, 1, 0x80, 0x02, 0xFF, 0xFF}) {
+		t.Errorf("unexpected receiver template % x", bodies[0])
+	}
+
+	want := []byte{6}
+	want = append(want, "7Q5MLV"...)
+	want = append(want, 4)
+	want = append(want, "KH74"...)
+	want = append(want, 15)
+	want = append(want, "Station-Manager"...)
+	want = append(want, 0)
+	if !bytes.HasPrefix(bodies[2], want) {
+		t.Errorf("unexpected receiver record % x", bodies[2])
+	}
+
+	sender := bodies[3]
+	if sender[0] != 5 || string(sender[1:6]) != "JA1XX" || binary.BigEndian.Uint32(sender[6:]) != 14074000 ||
+		string(sender[11:14]) != "FT8" || string(sender[15:19]) != "PM95" || sender[19] != InformationSourceLog ||
+		binary.BigEndian.Uint32(sender[20:]) != uint32(spot.Time.Unix()) {
+		t.Errorf("unexpected sender record % x", sender)
+	}
+}
+
+func TestEncode_SplitsLargeBatches(t *testing.T) {
+	e := NewEncoder(1)
+	spots := make([]Spot, 200)
+	for i := range spots {
+		spots[i] = Spot{Callsign: fmt.Sprintf("K%03d", i), Locator: "FN31pr", FrequencyHz: 7074000, Mode: "FT8", Time: time.Now()}
+	}
+
+	packets := e.Encode(time.Now(), Receiver{Callsign: "7Q5MLV"}, spots)
+	if len(packets) < 2 {
+		t.Fatalf("expected the spots to be split, got %d packet(s)", len(packets))
+	}
+	var sequence uint32
+	for i, p := range packets {
+		if len(p) > MaxPacketSize {
+			t.Errorf("packet %d is %d bytes", i, len(p))
+		}
+		if got := binary.BigEndian.Uint32(p[8:]); got != sequence || (i == 0 && got != 0) {
+			t.Errorf("packet %d: expected sequence %d, got %d", i, sequence, got)
+		}
+		_, bodies := readSets(t, p)
+		// Each sender record here is 25 bytes; count them from the set body.
+		sequence += uint32(len(bodies[3])/25) + 1
+	}
+	if e.Encode(time.Now(), Receiver{}, nil) != nil {
+		t.Error("expected no packets without spots")
+	}
+}
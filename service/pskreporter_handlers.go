@@ -0,0 +1,85 @@
+package service
+
+import (
+	"strings"
+
+	"github.com/Station-Manager/errors"
+	"github.com/gofiber/fiber/v2"
+)
+
+// pskReporterRequest is the body of a request to opt a logbook in to PSK Reporter.
+type pskReporterRequest struct {
+	Locator string `json:"locator" validate:"omitempty,min=4,max=10,alphanum"`
+	Antenna string `json:"antenna" validate:"max=64"`
+}
+
+// getPskReporterHandler reports whether the authenticated logbook sends its digital QSOs to PSK Reporter.
+func (s *Service) getPskReporterHandler(c *fiber.Ctx) error {
+	const op errors.Op = "server.Service.getPskReporterHandler"
+
+	logbook, err := authenticatedLogbook(c)
+	if err != nil {
+		s.logger.ErrorWith().Err(errors.New(op).Err(err)).Msg("authenticatedLogbook failed")
+		return c.Status(fiber.StatusInternalServerError).JSON(jsonInternalError)
+	}
+
+	settings, found, err := s.fetchPskReporterSettings(c.UserContext(), logbook.ID)
+	if err != nil {
+		s.logger.ErrorWith().Err(errors.New(op).Err(err)).Msg("s.fetchPskReporterSettings failed")
+		return s.dbFailure(c, err)
+	}
+	if !found {
+		return c.JSON(fiber.Map{"enabled": false})
+	}
+	return c.JSON(fiber.Map{"enabled": true, "locator": settings.Locator, "antenna": settings.Antenna})
+}
+
+// putPskReporterHandler opts the authenticated logbook in to PSK Reporter. Digital-mode QSOs
+// inserted from then on are reported.
+func (s *Service) putPskReporterHandler(c *fiber.Ctx) error {
+	const op errors.Op = "server.Service.putPskReporterHandler"
+
+	logbook, err := authenticatedLogbook(c)
+	if err != nil {
+		s.logger.ErrorWith().Err(errors.New(op).Err(err)).Msg("authenticatedLogbook failed")
+		return c.Status(fiber.StatusInternalServerError).JSON(jsonInternalError)
+	}
+
+	var request pskReporterRequest
+	if err = c.BodyParser(&request); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(jsonBadRequest)
+	}
+	if err = s.validate.Struct(request); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(jsonBadRequest)
+	}
+
+	settings := pskReporterSettings{LogbookID: logbook.ID, Locator: strings.ToUpper(request.Locator), Antenna: request.Antenna}
+	if err = s.savePskReporterSettings(c.UserContext(), settings); err != nil {
+		s.logger.ErrorWith().Err(errors.New(op).Err(err)).Msg("s.savePskReporterSettings failed")
+		return s.dbFailure(c, err)
+	}
+
+	return c.SendStatus(fiber.StatusNoContent)
+}
+
+// deletePskReporterHandler opts the authenticated logbook out of PSK Reporter.
+func (s *Service) deletePskReporterHandler(c *fiber.Ctx) error {
+	const op errors.Op = "server.Service.deletePskReporterHandler"
+
+	logbook, err := authenticatedLogbook(c)
+	if err != nil {
+		s.logger.ErrorWith().Err(errors.New(op).Err(err)).Msg("authenticatedLogbook failed")
+		return c.Status(fiber.StatusInternalServerError).JSON(jsonInternalError)
+	}
+
+	found, err := s.deletePskReporterSettings(c.UserContext(), logbook.ID)
+	if err != nil {
+		s.logger.ErrorWith().Err(errors.New(op).Err(err)).Msg("s.deletePskReporterSettings failed")
+		return s.dbFailure(c, err)
+	}
+	if !found {
+		return c.Status(fiber.StatusNotFound).JSON(jsonError(errCodeIntegrationNotConfigured, "PSK Reporter is not enabled"))
+	}
+
+	return c.SendStatus(fiber.StatusNoContent)
+}
@@ -0,0 +1,86 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/Station-Manager/types"
+)
+
+func testDigitalQso(call, mode, freq string) types.Qso {
+	qso := types.Qso{ID: 1}
+	qso.Call = call
+	qso.Mode = mode
+	qso.Freq = freq
+	qso.QsoDate = "20240430"
+	qso.TimeOn = "1203"
+	qso.Gridsquare = "PM95"
+	return qso
+}
+
+func TestPskSpotFromQso(t *testing.T) {
+	spot, ok := pskSpotFromQso(testDigitalQso("ja1xx", "FT8", "14.074"))
+	if !ok || spot.Callsign != "JA1XX" || spot.FrequencyHz != 14074000 || spot.Mode != "FT8" ||
+		!spot.Time.Equal(time.Date(2024, 4, 30, 12, 3, 0, 0, time.UTC)) {
+		t.Errorf("unexpected spot %+v (ok=%v)", spot, ok)
+	}
+
+	qso := testDigitalQso("JA1XX", "MFSK", "7.0475")
+	qso.Submode = "FT4"
+	if spot, ok = pskSpotFromQso(qso); !ok || spot.Mode != "FT4" || spot.FrequencyHz != 7047500 {
+		t.Errorf("expected the submode to be reported, got %+v", spot)
+	}
+	if _, ok = pskSpotFromQso(testDigitalQso("JA1XX", "FT8", "")); ok {
+		t.Error("expected a QSO without a frequency to be skipped")
+	}
+}
+
+func TestPskReporter_ReportsOptedInLogbooks(t *testing.T) {
+	svc := newTestServer(t)
+	svc.pskReporter = newPskReporterClient()
+	ctx := context.Background()
+
+	listener, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("ListenPacket failed: %v", err)
+	}
+	defer func() { _ = listener.Close() }()
+	svc.pskReporter.addr = listener.LocalAddr().String()
+
+	// Phone QSOs are never queued.
+	svc.enqueuePskReport(qsoEvent{Type: qsoEventInserted, LogbookID: 1, Qso: testDigitalQso("K1AB", "SSB", "14.250")})
+	if n := len(svc.pskReporter.queue); n != 0 {
+		t.Errorf("expected no queued spots, got %d", n)
+	}
+
+	event := qsoEvent{Type: qsoEventInserted, LogbookID: 1, Qso: testDigitalQso("JA1XX", "FT8", "14.074")}
+	if err = svc.collectPskSpot(ctx, event); err != nil || svc.pskReporter.pendingCount != 0 {
+		t.Fatalf("expected no spot before opting in, got %v (pending=%d)", err, svc.pskReporter.pendingCount)
+	}
+
+	if err = svc.savePskReporterSettings(ctx, pskReporterSettings{LogbookID: 1, Locator: "KH74", Antenna: "Dipole"}); err != nil {
+		t.Fatalf("savePskReporterSettings failed: %v", err)
+	}
+	if err = svc.collectPskSpot(ctx, event); err != nil || svc.pskReporter.pendingCount != 1 {
+		t.Fatalf("collectPskSpot failed: %v (pending=%d)", err, svc.pskReporter.pendingCount)
+	}
+	svc.flushPskReports()
+	if svc.pskReporter.pendingCount != 0 {
+		t.Error("expected the pending spots to be cleared")
+	}
+
+	buf := make([]byte, 2048)
+	_ = listener.SetReadDeadline(time.Now().Add(2 * time.Second))
+	n, _, err := listener.ReadFrom(buf)
+	if err != nil {
+		t.Fatalf("expected a report, got %v", err)
+	}
+	for _, want := range []string{"7Q5MLV", "KH74", "Dipole", "JA1XX", "PM95", "FT8"} {
+		if !bytes.Contains(buf[:n], []byte(want)) {
+			t.Errorf("expected the report to contain %q", want)
+		}
+	}
+}
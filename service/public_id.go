@@ -0,0 +1,218 @@
+package service
+
+import (
+	"context"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Station-Manager/errors"
+	"github.com/Station-Manager/types"
+	"github.com/google/uuid"
+)
+
+// QSOs and logbooks have a UUID besides their serial ID. The serial IDs are allocated by the
+// database and differ between servers, so clients that keep their own copy of a logbook should
+// hold on to the UUIDs instead. UUIDs are assigned the first time a QSO or logbook is returned,
+// and every route that names one in its path accepts either.
+
+// uuidBatchSize is the number of IDs whose UUIDs are read with one query.
+const uuidBatchSize = 500
+
+// publicQso is a QSO as the API returns it. Local gives its times in the reader's time zone, if
+// they have one; see localizeQsos.
+type publicQso struct {
+	types.Qso
+	UUID  string         `json:"uuid"`
+	Local *localQsoTimes `json:"local,omitempty"`
+}
+
+// localizeQsos gives the QSOs' times in the time zone as well, unless it is nil.
+func localizeQsos(public []publicQso, location *time.Location) {
+	if location == nil {
+		return
+	}
+	for i := range public {
+		public[i].Local = localTimesOf(public[i].Qso, location)
+	}
+}
+
+// publicQsos adds their UUIDs to the logbook's QSOs.
+func (s *Service) publicQsos(ctx context.Context, logbookID int64, qsos []types.Qso) ([]publicQso, error) {
+	const op errors.Op = "server.Service.publicQsos"
+
+	ids := make([]int64, len(qsos))
+	for i, qso := range qsos {
+		ids[i] = qso.ID
+	}
+	uuids, err := s.qsoUUIDs(ctx, logbookID, ids)
+	if err != nil {
+		return nil, errors.New(op).Err(err)
+	}
+	public := make([]publicQso, len(qsos))
+	for i, qso := range qsos {
+		public[i] = publicQso{Qso: qso, UUID: uuids[qso.ID]}
+	}
+	return public, nil
+}
+
+// publicQsoOf adds its UUID to a QSO.
+func (s *Service) publicQsoOf(ctx context.Context, qso types.Qso) (publicQso, error) {
+	const op errors.Op = "server.Service.publicQsoOf"
+
+	public, err := s.publicQsos(ctx, qso.LogbookID, []types.Qso{qso})
+	if err != nil {
+		return publicQso{Qso: qso}, errors.New(op).Err(err)
+	}
+	return public[0], nil
+}
+
+// qsoUUIDs returns the UUIDs of the logbook's QSOs by ID, assigning UUIDs to those without one.
+func (s *Service) qsoUUIDs(ctx context.Context, logbookID int64, ids []int64) (map[int64]string, error) {
+	const op errors.Op = "server.Service.qsoUUIDs"
+
+	uuids, err := s.ensureUUIDs(ctx, `qso_uuids`, `qso_id`, ids, func(id int64, value string) (string, []any) {
+		return `INSERT INTO qso_uuids (qso_id, logbook_id, uuid) VALUES ($1, $2, $3) ON CONFLICT DO NOTHING`, []any{id, logbookID, value}
+	})
+	if err != nil {
+		return nil, errors.New(op).Err(err)
+	}
+	return uuids, nil
+}
+
+// logbookUUIDs returns the UUIDs of logbooks by ID, assigning UUIDs to those without one.
+func (s *Service) logbookUUIDs(ctx context.Context, ids []int64) (map[int64]string, error) {
+	const op errors.Op = "server.Service.logbookUUIDs"
+
+	uuids, err := s.ensureUUIDs(ctx, `logbook_uuids`, `logbook_id`, ids, func(id int64, value string) (string, []any) {
+		return `INSERT INTO logbook_uuids (logbook_id, uuid) VALUES ($1, $2) ON CONFLICT DO NOTHING`, []any{id, value}
+	})
+	if err != nil {
+		return nil, errors.New(op).Err(err)
+	}
+	return uuids, nil
+}
+
+// ensureUUIDs reads the UUIDs of the rows of table keyed by column, and inserts a random UUID
+// for those missing. Concurrent requests may race to assign one; the first insert wins and the
+// UUID is read back.
+func (s *Service) ensureUUIDs(ctx context.Context, table, column string, ids []int64, insert func(id int64, value string) (string, []any)) (map[int64]string, error) {
+	const op errors.Op = "server.Service.ensureUUIDs"
+
+	uuids, err := s.fetchUUIDs(ctx, table, column, ids)
+	if err != nil {
+		return nil, errors.New(op).Err(err)
+	}
+	var missing []int64
+	for _, id := range ids {
+		if _, ok := uuids[id]; !ok {
+			missing = append(missing, id)
+		}
+	}
+	if len(missing) == 0 {
+		return uuids, nil
+	}
+
+	for _, id := range missing {
+		query, args := insert(id, uuid.NewString())
+		if _, err = s.db.ExecContext(ctx, query, args...); err != nil {
+			return nil, errors.New(op).Err(err)
+		}
+	}
+	assigned, err := s.fetchUUIDs(ctx, table, column, missing)
+	if err != nil {
+		return nil, errors.New(op).Err(err)
+	}
+	for id, value := range assigned {
+		uuids[id] = value
+	}
+	return uuids, nil
+}
+
+// fetchUUIDs reads the UUIDs of the rows of table keyed by column, a batch of IDs per query to
+// stay within the databases' limits on parameters.
+func (s *Service) fetchUUIDs(ctx context.Context, table, column string, ids []int64) (map[int64]string, error) {
+	const op errors.Op = "server.Service.fetchUUIDs"
+
+	uuids := make(map[int64]string, len(ids))
+	for start := 0; start < len(ids); start += uuidBatchSize {
+		batch := ids[start:min(start+uuidBatchSize, len(ids))]
+		placeholders := make([]string, len(batch))
+		args := make([]any, len(batch))
+		for i, id := range batch {
+			placeholders[i] = "$" + strconv.Itoa(i+1)
+			args[i] = id
+		}
+		if err := s.scanUUIDs(ctx, uuids, `SELECT `+column+`, uuid FROM `+table+` WHERE `+column+` IN (`+strings.Join(placeholders, ", ")+`)`, args); err != nil {
+			return nil, errors.New(op).Err(err)
+		}
+	}
+	return uuids, nil
+}
+
+func (s *Service) scanUUIDs(ctx context.Context, uuids map[int64]string, query string, args []any) error {
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = rows.Close() }()
+
+	for rows.Next() {
+		var id int64
+		var value string
+		if err = rows.Scan(&id, &value); err != nil {
+			return err
+		}
+		uuids[id] = value
+	}
+	return rows.Err()
+}
+
+// resolveQsoRef returns the ID of the logbook's QSO named by ref, a serial ID or a UUID. It reports
+// false when ref is neither. A UUID of no QSO of the logbook resolves to ID zero, which names no QSO.
+func (s *Service) resolveQsoRef(ctx context.Context, logbookID int64, ref string) (int64, bool, error) {
+	const op errors.Op = "server.Service.resolveQsoRef"
+
+	if id, err := strconv.ParseInt(ref, 10, 64); err == nil {
+		return id, id > 0, nil
+	}
+	parsed, err := uuid.Parse(ref)
+	if err != nil {
+		return 0, false, nil
+	}
+	id, _, err := s.fetchQsoIDByUUID(ctx, logbookID, parsed.String())
+	if err != nil {
+		return 0, false, errors.New(op).Err(err)
+	}
+	return id, true, nil
+}
+
+// resolveLogbookRef returns the ID of the logbook named by ref, a serial ID or a UUID. It reports
+// false when ref is neither. An unknown UUID resolves to ID zero, which names no logbook.
+func (s *Service) resolveLogbookRef(ctx context.Context, ref string) (int64, bool, error) {
+	const op errors.Op = "server.Service.resolveLogbookRef"
+
+	if id, err := strconv.ParseInt(ref, 10, 64); err == nil {
+		return id, id > 0, nil
+	}
+	parsed, err := uuid.Parse(ref)
+	if err != nil {
+		return 0, false, nil
+	}
+	rows, err := s.db.QueryContext(ctx, `SELECT logbook_id FROM logbook_uuids WHERE uuid = $1`, parsed.String())
+	if err != nil {
+		return 0, false, errors.New(op).Err(err)
+	}
+	defer func() { _ = rows.Close() }()
+
+	var id int64
+	if rows.Next() {
+		if err = rows.Scan(&id); err != nil {
+			return 0, false, errors.New(op).Err(err)
+		}
+	}
+	if err = rows.Err(); err != nil {
+		return 0, false, errors.New(op).Err(err)
+	}
+	return id, true, nil
+}
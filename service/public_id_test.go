@@ -0,0 +1,85 @@
+package service
+
+import (
+	"context"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"github.com/goccy/go-json"
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+)
+
+func TestQsoUUIDs_AssignedOnceAndResolved(t *testing.T) {
+	svc := newTestServer(t)
+	ctx := context.Background()
+	first := insertTestQso(t, svc, "JA1XX", "20m", "FT8", "20240430", "1203")
+	second := insertTestQso(t, svc, "DL1ABC", "40m", "CW", "20240430", "1300")
+
+	uuids, err := svc.qsoUUIDs(ctx, 1, []int64{first, second})
+	if err != nil || len(uuids) != 2 || uuids[first] == uuids[second] {
+		t.Fatalf("expected two distinct UUIDs, got %v %v", uuids, err)
+	}
+	if _, err = uuid.Parse(uuids[first]); err != nil {
+		t.Errorf("expected a UUID, got %q", uuids[first])
+	}
+	again, err := svc.qsoUUIDs(ctx, 1, []int64{first})
+	if err != nil || again[first] != uuids[first] {
+		t.Errorf("expected the UUID to be stable, got %q %v", again[first], err)
+	}
+
+	cases := []struct {
+		ref    string
+		wantID int64
+		wantOK bool
+	}{
+		{strconv.FormatInt(second, 10), second, true},
+		{uuids[second], second, true},
+		{uuid.NewString(), 0, true},
+		{"0", 0, false},
+		{"not-an-id", 0, false},
+	}
+	for _, tc := range cases {
+		id, ok, err := svc.resolveQsoRef(ctx, 1, tc.ref)
+		if err != nil || id != tc.wantID || ok != tc.wantOK {
+			t.Errorf("resolveQsoRef(%q): expected %d %v, got %d %v %v", tc.ref, tc.wantID, tc.wantOK, id, ok, err)
+		}
+	}
+	if id, _, _ := svc.resolveQsoRef(ctx, 2, uuids[second]); id != 0 {
+		t.Errorf("expected another logbook's UUID not to resolve, got %d", id)
+	}
+}
+
+func TestGetQso_ByUUID(t *testing.T) {
+	svc := newTestServer(t)
+	id := insertTestQso(t, svc, "JA1XX", "20m", "FT8", "20240430", "1203")
+	uuids, err := svc.qsoUUIDs(context.Background(), 1, []int64{id})
+	if err != nil {
+		t.Fatalf("qsoUUIDs failed: %v", err)
+	}
+
+	app := fiber.New()
+	app.Get("/qsos/:id", withLogbook(1, svc.getQsoHandler))
+
+	for _, ref := range []string{strconv.FormatInt(id, 10), uuids[id]} {
+		resp, err := app.Test(httptest.NewRequest(fiber.MethodGet, "/qsos/"+ref, nil))
+		if err != nil || resp.StatusCode != fiber.StatusOK {
+			t.Fatalf("GET /qsos/%s: expected 200, got %v %v", ref, resp, err)
+		}
+		var body struct {
+			Qso map[string]any `json:"qso"`
+		}
+		if err = json.NewDecoder(resp.Body).Decode(&body); err != nil {
+			t.Fatalf("decode failed: %v", err)
+		}
+		if body.Qso["uuid"] != uuids[id] || body.Qso["call"] != "JA1XX" {
+			t.Errorf("expected the QSO with its UUID, got %v", body.Qso)
+		}
+	}
+
+	resp, _ := app.Test(httptest.NewRequest(fiber.MethodGet, "/qsos/"+uuid.NewString(), nil))
+	if resp.StatusCode != fiber.StatusNotFound {
+		t.Errorf("expected an unknown UUID to get 404, got %d", resp.StatusCode)
+	}
+}
@@ -0,0 +1,387 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	stderr "errors"
+	"fmt"
+	"image"
+	"image/color"
+	_ "image/gif"
+	"image/jpeg"
+	_ "image/png"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/Station-Manager/errors"
+	"github.com/google/uuid"
+)
+
+// Environment variables configuring QSL card images. Images are disabled unless a directory or an
+// S3-compatible location is configured; only one of the two may be.
+const (
+	envQslImageDir = "SM_QSL_IMAGE_DIR"
+	// envQslImageS3URL names an S3-compatible location of the form https://host/bucket[/prefix].
+	envQslImageS3URL         = "SM_QSL_IMAGE_S3_URL"
+	envQslImageS3Region      = "SM_QSL_IMAGE_S3_REGION"
+	envQslImageS3AccessKeyID = "SM_QSL_IMAGE_S3_ACCESS_KEY_ID"
+	envQslImageS3SecretKey   = "SM_QSL_IMAGE_S3_SECRET_ACCESS_KEY"
+	// envQslImageMaxSize names the environment variable holding the largest image accepted (e.g. "20MB").
+	envQslImageMaxSize = "SM_QSL_IMAGE_MAX_SIZE"
+)
+
+const (
+	defaultQslImageMaxSize = 10 << 20
+	// qslImageMaxPixels bounds the decoded size of an image, so that a small file cannot claim
+	// gigabytes of memory.
+	qslImageMaxPixels = 50_000_000
+	// qslThumbnailSize is the longest side of a thumbnail, in pixels.
+	qslThumbnailSize    = 320
+	qslThumbnailQuality = 80
+	maxQslImagesPerQso  = 10
+	// defaultQslImagePurgeInterval is how often the images of purged QSOs are removed.
+	defaultQslImagePurgeInterval = 6 * time.Hour
+
+	qslImageSideFront = "front"
+	qslImageSideBack  = "back"
+)
+
+// qslImageTypes maps the content types accepted for QSL card images to their file extensions.
+var qslImageTypes = map[string]string{
+	"image/jpeg": ".jpg",
+	"image/png":  ".png",
+	"image/gif":  ".gif",
+}
+
+// errUnsupportedImage is returned by newQslImage when the data is not an image it can read.
+var errUnsupportedImage = stderr.New("the file is not a JPEG, PNG or GIF image")
+
+// objectStore stores named blobs. Names are slash-separated paths chosen by the server.
+type objectStore interface {
+	put(ctx context.Context, name string, data []byte, contentType string) error
+	get(ctx context.Context, name string) ([]byte, error)
+	remove(ctx context.Context, name string) error
+}
+
+var (
+	_ objectStore = (*s3Client)(nil)
+	_ objectStore = diskStore("")
+)
+
+// diskStore stores objects as files under a local directory.
+type diskStore string
+
+func (d diskStore) path(name string) string {
+	return filepath.Join(string(d), filepath.FromSlash(name))
+}
+
+// put writes the object to a temporary file and renames it into place, so that a reader never
+// sees part of it.
+func (d diskStore) put(_ context.Context, name string, data []byte, _ string) error {
+	const op errors.Op = "server.diskStore.put"
+
+	path := d.path(name)
+	if err := os.MkdirAll(filepath.Dir(path), 0o750); err != nil {
+		return errors.New(op).Err(err)
+	}
+	f, err := os.CreateTemp(filepath.Dir(path), ".upload-*")
+	if err != nil {
+		return errors.New(op).Err(err)
+	}
+	_, err = f.Write(data)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), path)
+	}
+	if err != nil {
+		_ = os.Remove(f.Name())
+		return errors.New(op).Err(err)
+	}
+	return nil
+}
+
+func (d diskStore) get(_ context.Context, name string) ([]byte, error) {
+	const op errors.Op = "server.diskStore.get"
+
+	data, err := os.ReadFile(d.path(name))
+	if err != nil {
+		return nil, errors.New(op).Err(err)
+	}
+	return data, nil
+}
+
+// remove deletes the object; removing one that does not exist is not an error.
+func (d diskStore) remove(_ context.Context, name string) error {
+	const op errors.Op = "server.diskStore.remove"
+
+	if err := os.Remove(d.path(name)); err != nil && !stderr.Is(err, os.ErrNotExist) {
+		return errors.New(op).Err(err)
+	}
+	return nil
+}
+
+// qslImageStore keeps QSL card images and their thumbnails in an object store.
+type qslImageStore struct {
+	objects objectStore
+	maxSize int
+}
+
+// loadQslImageStore reads the QSL image configuration from the environment. It returns nil when
+// images are not configured.
+func loadQslImageStore() (*qslImageStore, error) {
+	const op errors.Op = "server.loadQslImageStore"
+
+	dir := strings.TrimSpace(os.Getenv(envQslImageDir))
+	location := strings.TrimSpace(os.Getenv(envQslImageS3URL))
+	if dir == emptyString && location == emptyString {
+		return nil, nil
+	}
+	if dir != emptyString && location != emptyString {
+		return nil, errors.New(op).Msg("Only one of " + envQslImageDir + " and " + envQslImageS3URL + " may be set")
+	}
+
+	store := &qslImageStore{objects: diskStore(dir), maxSize: defaultQslImageMaxSize}
+	if location != emptyString {
+		accessKey := strings.TrimSpace(os.Getenv(envQslImageS3AccessKeyID))
+		secretKey := strings.TrimSpace(os.Getenv(envQslImageS3SecretKey))
+		if accessKey == emptyString || secretKey == emptyString {
+			return nil, errors.New(op).Msg(envQslImageS3AccessKeyID + " and " + envQslImageS3SecretKey + " are required with " + envQslImageS3URL)
+		}
+		client, err := newS3Client(location, strings.TrimSpace(os.Getenv(envQslImageS3Region)), accessKey, secretKey)
+		if err != nil {
+			return nil, errors.New(op).Err(err)
+		}
+		store.objects = client
+	}
+	if value := strings.TrimSpace(os.Getenv(envQslImageMaxSize)); value != emptyString {
+		size, err := parseByteSize(value)
+		if err != nil || size <= 0 {
+			return nil, errors.New(op).Msg(envQslImageMaxSize + " must be a positive size")
+		}
+		store.maxSize = size
+	}
+	return store, nil
+}
+
+// qslImage describes an image of a QSL card attached to a QSO.
+type qslImage struct {
+	ID          int64     `json:"id"`
+	QsoID       int64     `json:"qso_id"`
+	Side        string    `json:"side"`
+	ContentType string    `json:"content_type"`
+	Size        int64     `json:"size"`
+	Width       int       `json:"width"`
+	Height      int       `json:"height"`
+	CreatedAt   time.Time `json:"created_at"`
+
+	logbookID     int64
+	objectName    string
+	thumbnailName string
+}
+
+// newQslImage checks that data is an image of a type it accepts, within qslImageMaxPixels, and
+// returns a description of it with a JPEG thumbnail.
+func newQslImage(data []byte) (qslImage, []byte, error) {
+	const op errors.Op = "server.newQslImage"
+
+	contentType := http.DetectContentType(data)
+	if _, ok := qslImageTypes[contentType]; !ok {
+		return qslImage{}, nil, errUnsupportedImage
+	}
+	config, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil || config.Width <= 0 || config.Height <= 0 {
+		return qslImage{}, nil, errUnsupportedImage
+	}
+	if config.Width*config.Height > qslImageMaxPixels {
+		return qslImage{}, nil, errUnsupportedImage
+	}
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return qslImage{}, nil, errUnsupportedImage
+	}
+
+	var thumbnail bytes.Buffer
+	if err = jpeg.Encode(&thumbnail, scaleImage(img, qslThumbnailSize), &jpeg.Options{Quality: qslThumbnailQuality}); err != nil {
+		return qslImage{}, nil, errors.New(op).Err(err)
+	}
+	return qslImage{ContentType: contentType, Size: int64(len(data)), Width: config.Width, Height: config.Height}, thumbnail.Bytes(), nil
+}
+
+// scaleImage returns the image scaled down to fit within size by size pixels, keeping its aspect
+// ratio. Each pixel averages a grid of samples from the area it covers; a smaller image is only
+// copied.
+func scaleImage(src image.Image, size int) *image.RGBA {
+	bounds := src.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+	scale := max(float64(width)/float64(size), float64(height)/float64(size), 1)
+	dstWidth, dstHeight := max(1, int(float64(width)/scale)), max(1, int(float64(height)/scale))
+
+	// Up to four samples a side are enough to smooth a scan without reading every pixel.
+	samples := min(4, max(1, int(scale)))
+	dst := image.NewRGBA(image.Rect(0, 0, dstWidth, dstHeight))
+	for y := 0; y < dstHeight; y++ {
+		for x := 0; x < dstWidth; x++ {
+			var r, g, b, a uint32
+			for sy := 0; sy < samples; sy++ {
+				for sx := 0; sx < samples; sx++ {
+					px := bounds.Min.X + int((float64(x)+(float64(sx)+0.5)/float64(samples))*scale)
+					py := bounds.Min.Y + int((float64(y)+(float64(sy)+0.5)/float64(samples))*scale)
+					cr, cg, cb, ca := src.At(min(px, bounds.Max.X-1), min(py, bounds.Max.Y-1)).RGBA()
+					r, g, b, a = r+cr, g+cg, b+cb, a+ca
+				}
+			}
+			n := uint32(samples * samples)
+			dst.Set(x, y, color.RGBA64{R: uint16(r / n), G: uint16(g / n), B: uint16(b / n), A: uint16(a / n)})
+		}
+	}
+	return dst
+}
+
+// qslImageNames returns the object names of a new image of the QSO and of its thumbnail.
+func qslImageNames(logbookID, qsoID int64, contentType string) (string, string) {
+	base := fmt.Sprintf("qsl/%d/%d/%s", logbookID, qsoID, uuid.NewString())
+	return base + qslImageTypes[contentType], base + "-thumb.jpg"
+}
+
+// attachQslImage stores the image and its thumbnail and records them against the QSO. The objects
+// are removed again if the record cannot be written.
+func (s *Service) attachQslImage(ctx context.Context, logbookID, qsoID int64, side string, data []byte) (qslImage, error) {
+	const op errors.Op = "server.Service.attachQslImage"
+
+	img, thumbnail, err := newQslImage(data)
+	if err != nil {
+		return img, err
+	}
+	img.logbookID, img.QsoID, img.Side = logbookID, qsoID, side
+	if err = s.checkLogbookQuota(ctx, s.db, logbookID, quotaQslImageBytes, img.Size); err != nil {
+		return img, err
+	}
+	img.objectName, img.thumbnailName = qslImageNames(logbookID, qsoID, img.ContentType)
+
+	if err = s.qslImages.objects.put(ctx, img.objectName, data, img.ContentType); err != nil {
+		return img, errors.New(op).Err(err)
+	}
+	if err = s.qslImages.objects.put(ctx, img.thumbnailName, thumbnail, "image/jpeg"); err != nil {
+		s.removeQslImageObjects(ctx, img)
+		return img, errors.New(op).Err(err)
+	}
+
+	rows, err := s.db.QueryContext(ctx, `INSERT INTO qsl_images (logbook_id, qso_id, side, content_type, size, width, height, object_name, thumbnail_name)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9) RETURNING id, created_at`,
+		logbookID, qsoID, side, img.ContentType, img.Size, img.Width, img.Height, img.objectName, img.thumbnailName)
+	if err == nil {
+		defer func() { _ = rows.Close() }()
+		if !rows.Next() {
+			err = rows.Err()
+			if err == nil {
+				err = stderr.New("no row returned")
+			}
+		} else {
+			err = rows.Scan(&img.ID, &img.CreatedAt)
+		}
+	}
+	if err != nil {
+		s.removeQslImageObjects(ctx, img)
+		return img, errors.New(op).Err(err)
+	}
+	return img, nil
+}
+
+// removeQslImageObjects removes the image's objects, logging rather than returning a failure:
+// an object left behind only takes up space.
+func (s *Service) removeQslImageObjects(ctx context.Context, img qslImage) {
+	for _, name := range []string{img.objectName, img.thumbnailName} {
+		if err := s.qslImages.objects.remove(ctx, name); err != nil {
+			s.logger.WarnWith().Err(err).Str("object", name).Msg("Failed to remove QSL image object")
+		}
+	}
+}
+
+// listQslImages returns the images attached to the logbook's QSO, oldest first.
+func (s *Service) listQslImages(ctx context.Context, logbookID, qsoID int64) ([]qslImage, error) {
+	const op errors.Op = "server.Service.listQslImages"
+
+	images, err := s.queryQslImages(ctx, `WHERE logbook_id = $1 AND qso_id = $2 ORDER BY id`, logbookID, qsoID)
+	if err != nil {
+		return nil, errors.New(op).Err(err)
+	}
+	return images, nil
+}
+
+// fetchQslImage returns one of the images attached to the logbook's QSO, and false if there is
+// no such image.
+func (s *Service) fetchQslImage(ctx context.Context, logbookID, qsoID, id int64) (qslImage, bool, error) {
+	const op errors.Op = "server.Service.fetchQslImage"
+
+	images, err := s.queryQslImages(ctx, `WHERE id = $1 AND logbook_id = $2 AND qso_id = $3`, id, logbookID, qsoID)
+	if err != nil {
+		return qslImage{}, false, errors.New(op).Err(err)
+	}
+	if len(images) == 0 {
+		return qslImage{}, false, nil
+	}
+	return images[0], true, nil
+}
+
+// deleteQslImage removes one of the images attached to the logbook's QSO and reports whether it
+// existed.
+func (s *Service) deleteQslImage(ctx context.Context, logbookID, qsoID, id int64) (bool, error) {
+	const op errors.Op = "server.Service.deleteQslImage"
+
+	img, found, err := s.fetchQslImage(ctx, logbookID, qsoID, id)
+	if err != nil || !found {
+		return false, err
+	}
+	if _, err = s.db.ExecContext(ctx, `DELETE FROM qsl_images WHERE id = $1`, img.ID); err != nil {
+		return false, errors.New(op).Err(err)
+	}
+	s.removeQslImageObjects(ctx, img)
+	return true, nil
+}
+
+// purgeOrphanedQslImages removes the images of QSOs that no longer exist, because they were
+// purged from the trash or their logbook was deleted.
+func (s *Service) purgeOrphanedQslImages(ctx context.Context) error {
+	const op errors.Op = "server.Service.purgeOrphanedQslImages"
+
+	images, err := s.queryQslImages(ctx, `WHERE NOT EXISTS (SELECT 1 FROM qso q WHERE q.id = qsl_images.qso_id AND q.logbook_id = qsl_images.logbook_id)`)
+	if err != nil {
+		return errors.New(op).Err(err)
+	}
+	for _, img := range images {
+		if _, err = s.db.ExecContext(ctx, `DELETE FROM qsl_images WHERE id = $1`, img.ID); err != nil {
+			return errors.New(op).Err(err)
+		}
+		s.removeQslImageObjects(ctx, img)
+	}
+	if len(images) > 0 {
+		s.logger.InfoWith().Int("images", len(images)).Msg("Purged QSL images of deleted QSOs")
+	}
+	return nil
+}
+
+// queryQslImages returns the images selected by the condition.
+func (s *Service) queryQslImages(ctx context.Context, condition string, args ...any) ([]qslImage, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT id, logbook_id, qso_id, side, content_type, size, width, height, object_name, thumbnail_name, created_at
+		FROM qsl_images `+condition, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = rows.Close() }()
+
+	images := make([]qslImage, 0)
+	for rows.Next() {
+		var img qslImage
+		if err = rows.Scan(&img.ID, &img.logbookID, &img.QsoID, &img.Side, &img.ContentType, &img.Size, &img.Width, &img.Height,
+			&img.objectName, &img.thumbnailName, &img.CreatedAt); err != nil {
+			return nil, err
+		}
+		images = append(images, img)
+	}
+	return images, rows.Err()
+}
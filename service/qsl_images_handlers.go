@@ -0,0 +1,169 @@
+package service
+
+import (
+	stderr "errors"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/Station-Manager/errors"
+	"github.com/gofiber/fiber/v2"
+)
+
+// qslImageQso returns the authenticated logbook's ID and the ID of its QSO named in the path. A
+// non-zero status is the response to send instead, with its body.
+func (s *Service) qslImageQso(c *fiber.Ctx) (int64, int64, int, fiber.Map) {
+	const op errors.Op = "server.Service.qslImageQso"
+
+	if s.qslImages == nil {
+		return 0, 0, fiber.StatusNotFound, jsonError(errCodeQslImagesDisabled, "QSL images are not configured")
+	}
+	logbook, err := authenticatedLogbook(c)
+	if err != nil {
+		s.logger.ErrorWith().Err(errors.New(op).Err(err)).Msg("authenticatedLogbook failed")
+		return 0, 0, fiber.StatusInternalServerError, jsonInternalError
+	}
+
+	id, ok, err := s.resolveQsoRef(c.UserContext(), logbook.ID, c.Params("id"))
+	if err != nil {
+		s.logger.ErrorWith().Err(errors.New(op).Err(err)).Msg("s.resolveQsoRef failed")
+		return 0, 0, fiber.StatusInternalServerError, jsonInternalError
+	}
+	if !ok {
+		return 0, 0, fiber.StatusBadRequest, jsonBadRequest
+	}
+	qso, err := s.db.FetchQsoByIdContext(c.UserContext(), id)
+	if err != nil || qso.LogbookID != logbook.ID {
+		// Do not reveal whether the QSO exists in another logbook.
+		return 0, 0, fiber.StatusNotFound, jsonError(errCodeQsoNotFound, "QSO not found")
+	}
+	return logbook.ID, qso.ID, 0, nil
+}
+
+// listQslImagesHandler returns the QSL card images attached to a QSO of the authenticated logbook.
+func (s *Service) listQslImagesHandler(c *fiber.Ctx) error {
+	const op errors.Op = "server.Service.listQslImagesHandler"
+
+	logbookID, qsoID, status, body := s.qslImageQso(c)
+	if status != 0 {
+		return c.Status(status).JSON(body)
+	}
+
+	images, err := s.listQslImages(c.UserContext(), logbookID, qsoID)
+	if err != nil {
+		s.logger.ErrorWith().Err(errors.New(op).Err(err)).Msg("s.listQslImages failed")
+		return s.dbFailure(c, err)
+	}
+	return c.JSON(fiber.Map{"images": images})
+}
+
+// uploadQslImageHandler attaches the JPEG, PNG or GIF image in the request body to a QSO of the
+// authenticated logbook, as the side of the card given by the "side" query parameter: "front",
+// the default, or "back".
+func (s *Service) uploadQslImageHandler(c *fiber.Ctx) error {
+	const op errors.Op = "server.Service.uploadQslImageHandler"
+
+	logbookID, qsoID, status, body := s.qslImageQso(c)
+	if status != 0 {
+		return c.Status(status).JSON(body)
+	}
+	side := strings.ToLower(c.Query("side", qslImageSideFront))
+	if side != qslImageSideFront && side != qslImageSideBack {
+		return c.Status(fiber.StatusBadRequest).JSON(jsonBadRequest)
+	}
+
+	limit := s.qslImages.maxSize
+	data, err := io.ReadAll(io.LimitReader(s.uploadBody(c), int64(limit)+1))
+	if stderr.Is(err, errUploadTooLarge) || len(data) > limit {
+		c.Context().SetConnectionClose()
+		return c.Status(fiber.StatusRequestEntityTooLarge).JSON(jsonError(errCodeBodyTooLarge, "The image may be at most "+strconv.Itoa(limit)+" bytes"))
+	}
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(jsonBadRequest)
+	}
+
+	existing, err := s.listQslImages(c.UserContext(), logbookID, qsoID)
+	if err != nil {
+		s.logger.ErrorWith().Err(errors.New(op).Err(err)).Msg("s.listQslImages failed")
+		return s.dbFailure(c, err)
+	}
+	if len(existing) >= maxQslImagesPerQso {
+		return c.Status(fiber.StatusConflict).JSON(jsonError(errCodeQslImageLimit, "QSL image limit reached"))
+	}
+
+	img, err := s.attachQslImage(c.UserContext(), logbookID, qsoID, side, data)
+	if stderr.Is(err, errUnsupportedImage) {
+		return c.Status(fiber.StatusUnsupportedMediaType).JSON(jsonError(errCodeUnsupportedImage, "The image must be a JPEG, PNG or GIF"))
+	}
+	if body, exceeded := quotaExceededBody(err); exceeded {
+		return c.Status(fiber.StatusForbidden).JSON(body)
+	}
+	if err != nil {
+		s.logger.ErrorWith().Err(errors.New(op).Err(err)).Msg("s.attachQslImage failed")
+		return s.dbFailure(c, err)
+	}
+	return c.Status(fiber.StatusCreated).JSON(fiber.Map{"image": img})
+}
+
+// getQslImageHandler returns a QSL card image attached to a QSO of the authenticated logbook, or
+// its thumbnail when the path ends in /thumbnail.
+func (s *Service) getQslImageHandler(c *fiber.Ctx) error {
+	const op errors.Op = "server.Service.getQslImageHandler"
+
+	logbookID, qsoID, status, body := s.qslImageQso(c)
+	if status != 0 {
+		return c.Status(status).JSON(body)
+	}
+	id, err := strconv.ParseInt(c.Params("image"), 10, 64)
+	if err != nil || id <= 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(jsonBadRequest)
+	}
+
+	img, found, err := s.fetchQslImage(c.UserContext(), logbookID, qsoID, id)
+	if err != nil {
+		s.logger.ErrorWith().Err(errors.New(op).Err(err)).Msg("s.fetchQslImage failed")
+		return s.dbFailure(c, err)
+	}
+	if !found {
+		return c.Status(fiber.StatusNotFound).JSON(jsonError(errCodeQslImageNotFound, "QSL image not found"))
+	}
+
+	name, contentType := img.objectName, img.ContentType
+	if strings.HasSuffix(c.Path(), "/thumbnail") {
+		name, contentType = img.thumbnailName, "image/jpeg"
+	}
+	data, err := s.qslImages.objects.get(c.UserContext(), name)
+	if err != nil {
+		s.logger.ErrorWith().Err(errors.New(op).Err(err)).Int64("image_id", img.ID).Msg("Failed to read QSL image")
+		return c.Status(fiber.StatusInternalServerError).JSON(jsonInternalError)
+	}
+
+	// An image never changes once stored; a new upload gets a new ID.
+	c.Set(fiber.HeaderCacheControl, "private, max-age=86400, immutable")
+	c.Set(fiber.HeaderContentType, contentType)
+	return c.Send(data)
+}
+
+// deleteQslImageHandler removes a QSL card image from a QSO of the authenticated logbook.
+func (s *Service) deleteQslImageHandler(c *fiber.Ctx) error {
+	const op errors.Op = "server.Service.deleteQslImageHandler"
+
+	logbookID, qsoID, status, body := s.qslImageQso(c)
+	if status != 0 {
+		return c.Status(status).JSON(body)
+	}
+	id, err := strconv.ParseInt(c.Params("image"), 10, 64)
+	if err != nil || id <= 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(jsonBadRequest)
+	}
+
+	found, err := s.deleteQslImage(c.UserContext(), logbookID, qsoID, id)
+	if err != nil {
+		s.logger.ErrorWith().Err(errors.New(op).Err(err)).Msg("s.deleteQslImage failed")
+		return s.dbFailure(c, err)
+	}
+	if !found {
+		return c.Status(fiber.StatusNotFound).JSON(jsonError(errCodeQslImageNotFound, "QSL image not found"))
+	}
+	return c.SendStatus(fiber.StatusNoContent)
+}
@@ -0,0 +1,194 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/goccy/go-json"
+	"github.com/gofiber/fiber/v2"
+)
+
+// testQslCard returns a PNG of the given size.
+func testQslCard(t *testing.T, width, height int) []byte {
+	t.Helper()
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			img.Set(x, y, color.RGBA{R: uint8(x), G: uint8(y), B: 128, A: 255})
+		}
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestLoadQslImageStore(t *testing.T) {
+	if store, err := loadQslImageStore(); err != nil || store != nil {
+		t.Fatalf("expected no store by default, got %v %v", store, err)
+	}
+	dir := t.TempDir()
+	t.Setenv(envQslImageDir, dir)
+	t.Setenv(envQslImageMaxSize, "2MB")
+	store, err := loadQslImageStore()
+	if err != nil || store.objects != diskStore(dir) || store.maxSize != 2<<20 {
+		t.Fatalf("expected a disk store with a 2MB limit, got %+v %v", store, err)
+	}
+
+	t.Setenv(envQslImageS3URL, "https://s3.example.com/qsl")
+	if _, err = loadQslImageStore(); err == nil {
+		t.Error("expected a directory and a bucket together to be rejected")
+	}
+	t.Setenv(envQslImageDir, emptyString)
+	if _, err = loadQslImageStore(); err == nil {
+		t.Error("expected a bucket without credentials to be rejected")
+	}
+	t.Setenv(envQslImageS3AccessKeyID, "AKID")
+	t.Setenv(envQslImageS3SecretKey, "secret")
+	if store, err = loadQslImageStore(); err != nil {
+		t.Fatalf("loadQslImageStore failed: %v", err)
+	}
+	if _, ok := store.objects.(*s3Client); !ok {
+		t.Errorf("expected an S3 store, got %T", store.objects)
+	}
+}
+
+func TestQslImages_UploadFetchAndDelete(t *testing.T) {
+	svc := newTestServer(t)
+	dir := t.TempDir()
+	svc.qslImages = &qslImageStore{objects: diskStore(dir), maxSize: 1 << 20}
+	ctx := context.Background()
+	qsoID := insertTestQso(t, svc, "JA1XX", "20m", "FT8", "20240501", "1200")
+
+	app := fiber.New()
+	app.Get("/qsos/:id/qsl-images", withLogbook(1, svc.listQslImagesHandler))
+	app.Post("/qsos/:id/qsl-images", withLogbook(1, svc.uploadQslImageHandler))
+	app.Get("/qsos/:id/qsl-images/:image", withLogbook(1, svc.getQslImageHandler))
+	app.Get("/qsos/:id/qsl-images/:image/thumbnail", withLogbook(1, svc.getQslImageHandler))
+	app.Delete("/qsos/:id/qsl-images/:image", withLogbook(2, svc.deleteQslImageHandler))
+	do := func(method, path string, body []byte) (*http.Response, []byte) {
+		t.Helper()
+		resp, err := app.Test(httptest.NewRequest(method, path, bytes.NewReader(body)))
+		if err != nil {
+			t.Fatalf("%s %s failed: %v", method, path, err)
+		}
+		data, _ := io.ReadAll(resp.Body)
+		return resp, data
+	}
+	base := "/qsos/" + strconv.FormatInt(qsoID, 10) + "/qsl-images"
+
+	card := testQslCard(t, 800, 500)
+	resp, body := do(fiber.MethodPost, base+"?side=back", card)
+	if resp.StatusCode != fiber.StatusCreated {
+		t.Fatalf("expected 201, got %d %s", resp.StatusCode, body)
+	}
+	var created struct {
+		Image qslImage `json:"image"`
+	}
+	_ = json.Unmarshal(body, &created)
+	img := created.Image
+	if img.ID == 0 || img.Side != qslImageSideBack || img.ContentType != "image/png" || img.Width != 800 || img.Height != 500 || img.Size != int64(len(card)) {
+		t.Fatalf("unexpected image %+v", img)
+	}
+	imagePath := base + "/" + strconv.FormatInt(img.ID, 10)
+
+	if resp, body = do(fiber.MethodGet, imagePath, nil); resp.StatusCode != fiber.StatusOK || !bytes.Equal(body, card) || resp.Header.Get(fiber.HeaderContentType) != "image/png" {
+		t.Fatalf("expected the card back, got %d %s", resp.StatusCode, resp.Header.Get(fiber.HeaderContentType))
+	}
+	resp, body = do(fiber.MethodGet, imagePath+"/thumbnail", nil)
+	thumbnail, err := jpeg.Decode(bytes.NewReader(body))
+	if resp.StatusCode != fiber.StatusOK || err != nil {
+		t.Fatalf("expected a JPEG thumbnail, got %d %v", resp.StatusCode, err)
+	}
+	if b := thumbnail.Bounds(); b.Dx() != qslThumbnailSize || b.Dy() != 200 {
+		t.Errorf("expected a 320x200 thumbnail, got %v", b)
+	}
+	if resp, body = do(fiber.MethodGet, base, nil); resp.StatusCode != fiber.StatusOK || !strings.Contains(string(body), `"side":"back"`) {
+		t.Errorf("expected the image to be listed, got %d %s", resp.StatusCode, body)
+	}
+
+	if resp, _ = do(fiber.MethodPost, base, []byte("not an image")); resp.StatusCode != fiber.StatusUnsupportedMediaType {
+		t.Errorf("expected 415 for a non-image, got %d", resp.StatusCode)
+	}
+	if resp, _ = do(fiber.MethodPost, base, make([]byte, 2<<20)); resp.StatusCode != fiber.StatusRequestEntityTooLarge {
+		t.Errorf("expected 413 for an image over the limit, got %d", resp.StatusCode)
+	}
+	if resp, _ = do(fiber.MethodPost, base+"?side=edge", card); resp.StatusCode != fiber.StatusBadRequest {
+		t.Errorf("expected 400 for an unknown side, got %d", resp.StatusCode)
+	}
+
+	// Another logbook can neither see nor delete the image.
+	if resp, _ = do(fiber.MethodDelete, imagePath, nil); resp.StatusCode != fiber.StatusNotFound {
+		t.Errorf("expected 404 from another logbook, got %d", resp.StatusCode)
+	}
+	if found, err := svc.deleteQslImage(ctx, 1, qsoID, img.ID); err != nil || !found {
+		t.Fatalf("deleteQslImage failed: %v %v", found, err)
+	}
+	if files, _ := filepath.Glob(filepath.Join(dir, "qsl", "1", strconv.FormatInt(qsoID, 10), "*")); len(files) != 0 {
+		t.Errorf("expected the image files to be removed, got %v", files)
+	}
+}
+
+func TestPurgeOrphanedQslImages(t *testing.T) {
+	svc := newTestServer(t)
+	dir := t.TempDir()
+	svc.qslImages = &qslImageStore{objects: diskStore(dir), maxSize: 1 << 20}
+	ctx := context.Background()
+	qsoID := insertTestQso(t, svc, "JA1XX", "20m", "FT8", "20240501", "1200")
+
+	kept, err := svc.attachQslImage(ctx, 1, qsoID, qslImageSideFront, testQslCard(t, 10, 10))
+	if err != nil {
+		t.Fatalf("attachQslImage failed: %v", err)
+	}
+	orphan, err := svc.attachQslImage(ctx, 1, qsoID+1, qslImageSideFront, testQslCard(t, 10, 10))
+	if err != nil {
+		t.Fatalf("attachQslImage failed: %v", err)
+	}
+
+	if err = svc.purgeOrphanedQslImages(ctx); err != nil {
+		t.Fatalf("purgeOrphanedQslImages failed: %v", err)
+	}
+	if _, found, _ := svc.fetchQslImage(ctx, 1, qsoID, kept.ID); !found {
+		t.Error("expected the image of an existing QSO to be kept")
+	}
+	if _, found, _ := svc.fetchQslImage(ctx, 1, qsoID+1, orphan.ID); found {
+		t.Error("expected the image of a missing QSO to be purged")
+	}
+	if _, err = os.Stat(filepath.Join(dir, filepath.FromSlash(orphan.objectName))); !os.IsNotExist(err) {
+		t.Errorf("expected the purged image's file to be removed, got %v", err)
+	}
+}
+
+func TestS3Client_PutAndGet(t *testing.T) {
+	bucket := &fakeS3{objects: make(map[string][]byte)}
+	server := httptest.NewServer(bucket)
+	defer server.Close()
+
+	client, err := newS3Client(server.URL+"/cards", emptyString, "AKID", "secret")
+	if err != nil {
+		t.Fatalf("newS3Client failed: %v", err)
+	}
+	ctx := context.Background()
+	if err = client.put(ctx, "qsl/1/2/card.png", []byte("card"), "image/png"); err != nil {
+		t.Fatalf("put failed: %v (auth=%q)", err, bucket.authErr)
+	}
+	if data, err := client.get(ctx, "qsl/1/2/card.png"); err != nil || string(data) != "card" {
+		t.Errorf("expected the object back, got %q %v", data, err)
+	}
+	if _, err = client.get(ctx, "qsl/1/2/missing.png"); err == nil {
+		t.Error("expected a missing object to be an error")
+	}
+}
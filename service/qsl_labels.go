@@ -0,0 +1,204 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/Station-Manager/errors"
+	"github.com/Station-Manager/types"
+)
+
+const (
+	qslLabelFormatPDF = "pdf"
+
+	// qslLabelRows is the number of QSOs printed on one label; more QSOs with the same station
+	// continue on the next label.
+	qslLabelRows = 5
+)
+
+// Label sheets are A4 with three columns of eight 70 x 37 mm labels and no margins, as Avery 3474.
+const (
+	qslSheetWidth   = 595.28
+	qslSheetHeight  = 841.89
+	qslSheetColumns = 3
+	qslSheetRows    = 8
+	qslLabelInset   = 10.0
+)
+
+// qslLabelsRequest is the body of a request for QSL labels. Without QSO IDs the labels are for
+// the logbook's queued cards.
+type qslLabelsRequest struct {
+	QsoIDs []int64 `json:"qso_ids" validate:"max=500,dive,gt=0"`
+	Format string  `json:"format" validate:"omitempty,oneof=json pdf"`
+}
+
+// qslLabel is one label: the station the card is for, with the QSOs it confirms.
+type qslLabel struct {
+	Prefix string    `json:"prefix"`
+	Entity string    `json:"entity"`
+	To     string    `json:"to"`
+	Call   string    `json:"call"`
+	QslVia string    `json:"qsl_via,omitempty"`
+	Qsos   []qslCard `json:"qsos"`
+}
+
+// listQslCardsByID returns the cards for the logbook's QSOs named, grouped by bureau. QSOs that
+// are not the logbook's are left out.
+func (s *Service) listQslCardsByID(ctx context.Context, logbookID int64, ids []int64) []qslCardGroup {
+	qsos := make([]types.Qso, 0, len(ids))
+	seen := make(map[int64]bool, len(ids))
+	for _, id := range ids {
+		if seen[id] {
+			continue
+		}
+		seen[id] = true
+		qso, err := s.db.FetchQsoByIdContext(ctx, id)
+		if err != nil || qso.LogbookID != logbookID {
+			continue
+		}
+		qsos = append(qsos, qso)
+	}
+	return s.groupQslCards(qsos)
+}
+
+// qslLabels returns the labels for the cards, in the order of their groups. A station's QSOs
+// share a label, qslLabelRows at a time.
+func qslLabels(groups []qslCardGroup) []qslLabel {
+	labels := make([]qslLabel, 0)
+	for _, group := range groups {
+		for _, card := range group.Cards {
+			if n := len(labels); n > 0 {
+				last := &labels[n-1]
+				if last.Prefix == group.Prefix && last.Entity == group.Entity && last.To == card.To && last.Call == card.Call && len(last.Qsos) < qslLabelRows {
+					last.Qsos = append(last.Qsos, card)
+					continue
+				}
+			}
+			labels = append(labels, qslLabel{
+				Prefix: group.Prefix,
+				Entity: group.Entity,
+				To:     card.To,
+				Call:   card.Call,
+				QslVia: card.QslVia,
+				Qsos:   []qslCard{card},
+			})
+		}
+	}
+	return labels
+}
+
+// writeQslLabelsPDF writes the labels as a PDF of A4 label sheets.
+func writeQslLabelsPDF(w io.Writer, labels []qslLabel) error {
+	const op errors.Op = "server.writeQslLabelsPDF"
+
+	perSheet := qslSheetColumns * qslSheetRows
+	var pages []string
+	for start := 0; start < len(labels) || start == 0; start += perSheet {
+		end := min(start+perSheet, len(labels))
+		var content strings.Builder
+		for i, label := range labels[start:end] {
+			column, row := i%qslSheetColumns, i/qslSheetColumns
+			x := float64(column)*qslSheetWidth/qslSheetColumns + qslLabelInset
+			y := qslSheetHeight - float64(row)*qslSheetHeight/qslSheetRows - qslLabelInset
+			writeQslLabelText(&content, label, x, y)
+		}
+		pages = append(pages, content.String())
+	}
+
+	// Objects 1 and 2 are the catalog and page tree, 3 and 4 the fonts; each page then takes two
+	// objects, the page and its content stream.
+	objects := []string{
+		"<< /Type /Catalog /Pages 2 0 R >>",
+		emptyString,
+		"<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica /Encoding /WinAnsiEncoding >>",
+		"<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica-Bold /Encoding /WinAnsiEncoding >>",
+	}
+	kids := make([]string, 0, len(pages))
+	for _, content := range pages {
+		page := len(objects) + 1
+		kids = append(kids, strconv.Itoa(page)+" 0 R")
+		objects = append(objects,
+			fmt.Sprintf("<< /Type /Page /Parent 2 0 R /MediaBox [0 0 %.2f %.2f] /Resources << /Font << /F1 3 0 R /F2 4 0 R >> >> /Contents %d 0 R >>",
+				qslSheetWidth, qslSheetHeight, page+1),
+			fmt.Sprintf("<< /Length %d >>\nstream\n%sendstream", len(content), content))
+	}
+	objects[1] = fmt.Sprintf("<< /Type /Pages /Kids [%s] /Count %d >>", strings.Join(kids, " "), len(kids))
+
+	var buf bytes.Buffer
+	buf.WriteString("%PDF-1.4\n")
+	offsets := make([]int, len(objects))
+	for i, object := range objects {
+		offsets[i] = buf.Len()
+		fmt.Fprintf(&buf, "%d 0 obj\n%s\nendobj\n", i+1, object)
+	}
+	xref := buf.Len()
+	fmt.Fprintf(&buf, "xref\n0 %d\n0000000000 65535 f \n", len(objects)+1)
+	for _, offset := range offsets {
+		fmt.Fprintf(&buf, "%010d 00000 n \n", offset)
+	}
+	fmt.Fprintf(&buf, "trailer\n<< /Size %d /Root 1 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(objects)+1, xref)
+
+	if _, err := w.Write(buf.Bytes()); err != nil {
+		return errors.New(op).Err(err)
+	}
+	return nil
+}
+
+// qslLabelColumns are the offsets of the columns of a label's QSO table from its left edge.
+var qslLabelColumns = []float64{0, 46, 70, 96, 130, 152}
+
+// writeQslLabelText writes the drawing of a label with its top left corner at x, y.
+func writeQslLabelText(w *strings.Builder, label qslLabel, x, y float64) {
+	row := func(font string, size, dy float64, cells ...string) {
+		y -= dy
+		for i, cell := range cells {
+			fmt.Fprintf(w, "BT /%s %.0f Tf %.2f %.2f Td (%s) Tj ET\n", font, size, x+qslLabelColumns[i], y, pdfString(cell))
+		}
+	}
+
+	to := "To Radio " + label.Call
+	if label.QslVia != emptyString {
+		to += " via " + label.QslVia
+	}
+	row("F2", 10, 10, to)
+	row("F1", 7, 14, "Date", "UTC", "Band", "Mode", "RST", "QSL")
+	for _, qso := range label.Qsos {
+		qsl := "PSE"
+		if qso.QslRcvd {
+			qsl = "TNX"
+		}
+		row("F1", 8, 10, qslLabelDate(qso.QsoDate), qso.TimeOn, qso.Band, qso.Mode, qso.RstSent, qsl)
+	}
+}
+
+// qslLabelDate returns an ADIF date as YYYY-MM-DD.
+func qslLabelDate(date string) string {
+	if len(date) != 8 {
+		return date
+	}
+	return date[:4] + "-" + date[4:6] + "-" + date[6:]
+}
+
+// pdfString escapes text for a PDF string literal. The standard fonts cover only Latin-1 here,
+// so other characters are replaced.
+func pdfString(text string) string {
+	var b strings.Builder
+	for _, r := range text {
+		switch {
+		case r == '(' || r == ')' || r == '\\':
+			b.WriteByte('\\')
+			b.WriteRune(r)
+		case r < 0x20 || r > 0xff:
+			b.WriteByte('?')
+		case r > 0x7e:
+			fmt.Fprintf(&b, "\\%03o", r)
+		default:
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,113 @@
+package service
+
+import (
+	"bytes"
+	"io"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/goccy/go-json"
+	"github.com/gofiber/fiber/v2"
+)
+
+func TestQslLabels_SharesLabelsPerStation(t *testing.T) {
+	cards := make([]qslCard, 0)
+	for i := range 7 {
+		cards = append(cards, qslCard{QsoID: int64(i + 1), Call: "JA1XX", To: "JA1XX", QsoDate: "2024050" + strconv.Itoa(i+1)})
+	}
+	cards = append(cards, qslCard{QsoID: 8, Call: "JA1YY", QslVia: "JA1XX", To: "JA1XX"})
+	groups := []qslCardGroup{
+		{Prefix: "JA", Entity: "Japan", Cards: cards},
+		{Prefix: "K", Entity: "United States", Cards: []qslCard{{QsoID: 9, Call: "K1AB", To: "K1AB"}}},
+	}
+
+	labels := qslLabels(groups)
+	if len(labels) != 4 {
+		t.Fatalf("expected 4 labels, got %+v", labels)
+	}
+	if len(labels[0].Qsos) != qslLabelRows || len(labels[1].Qsos) != 2 || labels[1].Call != "JA1XX" {
+		t.Errorf("expected a station's QSOs to continue on a second label, got %+v", labels[:2])
+	}
+	if labels[2].Call != "JA1YY" || labels[2].QslVia != "JA1XX" || labels[3].Prefix != "K" {
+		t.Errorf("expected a managed station on its own label, got %+v", labels[2:])
+	}
+}
+
+func TestWriteQslLabelsPDF(t *testing.T) {
+	labels := make([]qslLabel, 0)
+	for i := range 25 {
+		labels = append(labels, qslLabel{Call: "JA" + strconv.Itoa(i) + "XX", Qsos: []qslCard{{QsoDate: "20240501", TimeOn: "1200", Band: "20m", Mode: "SSB", RstSent: "59"}}})
+	}
+	labels[0].QslVia = "K1(AB)"
+
+	var pdf bytes.Buffer
+	if err := writeQslLabelsPDF(&pdf, labels); err != nil {
+		t.Fatalf("writeQslLabelsPDF failed: %v", err)
+	}
+	out := pdf.String()
+	if !strings.HasPrefix(out, "%PDF-1.4\n") || !strings.HasSuffix(out, "%%EOF\n") {
+		t.Fatalf("expected a PDF document, got %q", out[:min(len(out), 40)])
+	}
+	if !strings.Contains(out, "/Count 2") {
+		t.Error("expected 25 labels to take two sheets")
+	}
+	if !strings.Contains(out, `(To Radio JA0XX via K1\(AB\))`) || !strings.Contains(out, "(2024-05-01)") {
+		t.Error("expected the label text, escaped")
+	}
+
+	// The cross-reference table must point at the objects.
+	xref := out[strings.LastIndex(out, "startxref\n")+len("startxref\n"):]
+	offset, _ := strconv.Atoi(xref[:strings.IndexByte(xref, '\n')])
+	entries := strings.Split(out[offset:], "\n")[3:]
+	for i, entry := range entries[:6] {
+		at, _ := strconv.Atoi(entry[:10])
+		if want := strconv.Itoa(i+1) + " 0 obj"; !strings.HasPrefix(out[at:], want) {
+			t.Errorf("xref entry %d points at %q, want %q", i+1, out[at:at+10], want)
+		}
+	}
+}
+
+func TestQslLabelsHandler(t *testing.T) {
+	svc := newTestServer(t)
+	first := insertTestQso(t, svc, "JA1XX", "20m", "FT8", "20240501", "1200")
+	second := insertTestQso(t, svc, "JA1XX", "40m", "FT8", "20240502", "1200")
+
+	app := fiber.New()
+	app.Post("/qsl/labels", withLogbook(1, svc.qslLabelsHandler))
+	do := func(body string) (int, string, []byte) {
+		t.Helper()
+		req := httptest.NewRequest(fiber.MethodPost, "/qsl/labels", strings.NewReader(body))
+		req.Header.Set(fiber.HeaderContentType, fiber.MIMEApplicationJSON)
+		resp, err := app.Test(req)
+		if err != nil {
+			t.Fatalf("POST /qsl/labels failed: %v", err)
+		}
+		data, _ := io.ReadAll(resp.Body)
+		return resp.StatusCode, resp.Header.Get(fiber.HeaderContentType), data
+	}
+
+	// Nothing is queued yet.
+	if status, _, body := do(emptyString); status != fiber.StatusOK || !bytes.Contains(body, []byte(`"count":0`)) {
+		t.Errorf("expected no labels, got %d %s", status, body)
+	}
+
+	ids := `[` + strconv.FormatInt(first, 10) + `,` + strconv.FormatInt(second, 10) + `,999999]`
+	status, _, body := do(`{"qso_ids":` + ids + `}`)
+	var result struct {
+		Labels []qslLabel `json:"labels"`
+	}
+	_ = json.Unmarshal(body, &result)
+	if status != fiber.StatusOK || len(result.Labels) != 1 || len(result.Labels[0].Qsos) != 2 {
+		t.Errorf("expected one label with both QSOs, got %d %s", status, body)
+	}
+
+	status, contentType, body := do(`{"qso_ids":` + ids + `,"format":"pdf"}`)
+	if status != fiber.StatusOK || contentType != "application/pdf" || !bytes.HasPrefix(body, []byte("%PDF-")) {
+		t.Errorf("expected a PDF, got %d %s", status, contentType)
+	}
+	if status, _, _ = do(`{"format":"docx"}`); status != fiber.StatusBadRequest {
+		t.Errorf("expected 400 for an unknown format, got %d", status)
+	}
+}
@@ -0,0 +1,48 @@
+package service
+
+import (
+	"github.com/Station-Manager/server/service/adif"
+	"github.com/Station-Manager/types"
+)
+
+// adifProgramID identifies the server in ADIF files it generates.
+const adifProgramID = "Station-Manager"
+
+// qsoAdifRecord returns the ADIF fields of the QSO that are shared with QSL and log services.
+// STATION_CALLSIGN falls back to the logbook's callsign.
+func qsoAdifRecord(qso types.Qso, logbook types.Logbook) adif.Record {
+	station := qso.StationCallsign
+	if station == emptyString {
+		station = logbook.Callsign
+	}
+	return adif.Record{
+		"CALL":             qso.Call,
+		"BAND":             qso.Band,
+		"BAND_RX":          qso.BandRx,
+		"MODE":             qso.Mode,
+		"SUBMODE":          qso.Submode,
+		"FREQ":             qso.Freq,
+		"FREQ_RX":          qso.FreqRx,
+		"QSO_DATE":         qso.QsoDate,
+		"QSO_DATE_OFF":     qso.QsoDateOff,
+		"TIME_ON":          qso.TimeOn,
+		"TIME_OFF":         qso.TimeOff,
+		"RST_SENT":         qso.RstSent,
+		"RST_RCVD":         qso.RstRcvd,
+		"TX_PWR":           qso.TxPwr,
+		"GRIDSQUARE":       qso.Gridsquare,
+		"NAME":             qso.Name,
+		"QTH":              qso.QTH,
+		"DXCC":             qso.DXCC,
+		"CQZ":              qso.CQZ,
+		"ITUZ":             qso.ITUZ,
+		"CONT":             qso.Cont,
+		"COUNTRY":          qso.Country,
+		"IOTA":             qso.Iota,
+		"COMMENT":          qso.Comment,
+		"QSLMSG":           qso.QslMsg,
+		"STATION_CALLSIGN": station,
+		"OPERATOR":         qso.Operator,
+		"MY_GRIDSQUARE":    qso.MyGridsquare,
+	}
+}
@@ -0,0 +1,132 @@
+package service
+
+import (
+	"context"
+	"encoding/base64"
+	"strconv"
+	"time"
+
+	"github.com/Station-Manager/errors"
+	"github.com/Station-Manager/types"
+	"github.com/goccy/go-json"
+)
+
+const (
+	// qsoSortTime and qsoSortTimeDescending are the values of the sort query parameter that page
+	// through a logbook's QSOs by time, oldest or newest first.
+	qsoSortTime           = "time"
+	qsoSortTimeDescending = "-time"
+)
+
+// qsoCursor is the position after the last QSO of a page of QSOs ordered by time. The date and
+// time are the database's own text for them, so that the next page's query compares them as
+// stored. It is given to clients as an opaque string.
+type qsoCursor struct {
+	Descending bool   `json:"desc,omitempty"`
+	QsoDate    string `json:"d"`
+	TimeOn     string `json:"t"`
+	ID         int64  `json:"i"`
+}
+
+// encode returns the cursor as an opaque, URL-safe string.
+func (c qsoCursor) encode() string {
+	data, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// The text forms of a QSO's date and time: as stored on SQLite (ADIF's), and as PostgreSQL gives
+// its DATE and TIME columns.
+var (
+	qsoCursorDateLayouts = []string{"20060102", "2006-01-02"}
+	qsoCursorTimeLayouts = []string{"1504", "150405", "15:04", "15:04:05"}
+)
+
+// decodeQsoCursor parses a cursor returned by encode. A cursor whose date or time is not in one of
+// the stored text forms is rejected, so that a tampered one cannot fail the query's casts.
+func decodeQsoCursor(value string) (qsoCursor, bool) {
+	data, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return qsoCursor{}, false
+	}
+	var cursor qsoCursor
+	if err = json.Unmarshal(data, &cursor); err != nil || cursor.ID <= 0 ||
+		!parsesAs(cursor.QsoDate, qsoCursorDateLayouts) || !parsesAs(cursor.TimeOn, qsoCursorTimeLayouts) {
+		return qsoCursor{}, false
+	}
+	return cursor, true
+}
+
+// parsesAs reports whether value is a time in one of the layouts.
+func parsesAs(value string, layouts []string) bool {
+	for _, layout := range layouts {
+		if _, err := time.Parse(layout, value); err == nil {
+			return true
+		}
+	}
+	return false
+}
+
+// listLogbookQsoKeysetPage returns the IDs of up to limit QSOs of the logbook ordered by
+// (qso_date, time_on, id), starting after the cursor if there is one, and the cursor after the
+// last of them. Seeking on the qso_logbook_keyset_idx index keeps every page as cheap as the first.
+func (s *Service) listLogbookQsoKeysetPage(ctx context.Context, logbookID int64, after *qsoCursor, descending bool, limit int) ([]int64, qsoCursor, error) {
+	const op errors.Op = "server.Service.listLogbookQsoKeysetPage"
+
+	columns, seek := `id, qso_date, time_on`, `($2, $3, $4)`
+	if s.isPostgres() {
+		columns, seek = `id, qso_date::text, time_on::text`, `(CAST($2 AS DATE), CAST($3 AS TIME), $4)`
+	}
+	comparison, direction := `>`, `ASC`
+	if descending {
+		comparison, direction = `<`, `DESC`
+	}
+
+	query := `SELECT ` + columns + ` FROM qso WHERE logbook_id = $1 AND deleted_at IS NULL`
+	args := []any{logbookID}
+	if after != nil {
+		query += ` AND (qso_date, time_on, id) ` + comparison + ` ` + seek
+		args = append(args, after.QsoDate, after.TimeOn, after.ID)
+	}
+	query += ` ORDER BY qso_date ` + direction + `, time_on ` + direction + `, id ` + direction +
+		` LIMIT $` + strconv.Itoa(len(args)+1)
+	args = append(args, limit)
+
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, qsoCursor{}, errors.New(op).Err(err)
+	}
+	defer func() { _ = rows.Close() }()
+
+	ids := make([]int64, 0, limit)
+	last := qsoCursor{Descending: descending}
+	for rows.Next() {
+		if err = rows.Scan(&last.ID, &last.QsoDate, &last.TimeOn); err != nil {
+			return nil, qsoCursor{}, errors.New(op).Err(err)
+		}
+		ids = append(ids, last.ID)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, qsoCursor{}, errors.New(op).Err(err)
+	}
+	return ids, last, nil
+}
+
+// fetchLogbookQsoKeysetPage returns up to limit QSOs of the logbook in time order, starting after
+// the cursor if there is one, and the cursor after the last of them.
+func (s *Service) fetchLogbookQsoKeysetPage(ctx context.Context, logbookID int64, after *qsoCursor, descending bool, limit int) ([]types.Qso, qsoCursor, error) {
+	const op errors.Op = "server.Service.fetchLogbookQsoKeysetPage"
+
+	ids, last, err := s.listLogbookQsoKeysetPage(ctx, logbookID, after, descending, limit)
+	if err != nil {
+		return nil, qsoCursor{}, errors.New(op).Err(err)
+	}
+	qsos := make([]types.Qso, 0, len(ids))
+	for _, id := range ids {
+		qso, err := s.db.FetchQsoByIdContext(ctx, id)
+		if err != nil {
+			return nil, qsoCursor{}, errors.New(op).Err(err).Msgf("Failed to fetch QSO %d", id)
+		}
+		qsos = append(qsos, qso)
+	}
+	return qsos, last, nil
+}
@@ -0,0 +1,101 @@
+package service
+
+import (
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/Station-Manager/types"
+	"github.com/goccy/go-json"
+	"github.com/gofiber/fiber/v2"
+)
+
+func TestQsoCursor_RoundTrip(t *testing.T) {
+	cursor := qsoCursor{Descending: true, QsoDate: "20240101", TimeOn: "1200", ID: 7}
+	got, ok := decodeQsoCursor(cursor.encode())
+	if !ok || got != cursor {
+		t.Fatalf("expected %+v back, got %+v %v", cursor, got, ok)
+	}
+	if _, ok = decodeQsoCursor(qsoCursor{QsoDate: "2024-01-01", TimeOn: "12:00:00", ID: 7}.encode()); !ok {
+		t.Error("expected PostgreSQL's text forms to be accepted")
+	}
+	for _, value := range []string{"", "not a cursor", qsoCursor{QsoDate: "20240101", TimeOn: "1200"}.encode(),
+		qsoCursor{QsoDate: "x", TimeOn: "1200", ID: 1}.encode(), qsoCursor{QsoDate: "20240101", TimeOn: "y", ID: 1}.encode(),
+		qsoCursor{QsoDate: "20241301", TimeOn: "1200", ID: 1}.encode()} {
+		if _, ok = decodeQsoCursor(value); ok {
+			t.Errorf("expected %q to be rejected", value)
+		}
+	}
+}
+
+func TestV2_ListQsosByTime(t *testing.T) {
+	svc := newTestServer(t)
+	// Logged out of order, so that insertion order and time order differ.
+	insertTestQso(t, svc, "K3ABC", "20m", "FT8", "20240102", "0900")
+	insertTestQso(t, svc, "K1ABC", "20m", "FT8", "20240101", "1200")
+	insertTestQso(t, svc, "K4ABC", "20m", "FT8", "20240102", "0900")
+	insertTestQso(t, svc, "K2ABC", "20m", "FT8", "20240101", "1300")
+
+	app := fiber.New()
+	withKey := func(c *fiber.Ctx) error {
+		c.Locals(localsRequestDataKey, &requestContext{Logbook: &types.Logbook{ID: 1}, IsValid: true})
+		return c.Next()
+	}
+	app.Get("/api/v2/logbooks/:id/qsos", withKey, svc.pathLogbookMiddleware(), svc.v2ListQsosHandler)
+
+	get := func(query string) (int, map[string]any) {
+		resp, err := app.Test(httptest.NewRequest(fiber.MethodGet, "/api/v2/logbooks/1/qsos?"+query, nil))
+		if err != nil {
+			t.Fatalf("GET %s failed: %v", query, err)
+		}
+		var out map[string]any
+		_ = json.NewDecoder(resp.Body).Decode(&out)
+		return resp.StatusCode, out
+	}
+	pages := func(sort string) []string {
+		var calls []string
+		query := "limit=3&sort=" + sort
+		for range 3 {
+			status, out := get(query)
+			if status != fiber.StatusOK {
+				t.Fatalf("expected a page for %q, got %d %v", query, status, out)
+			}
+			qsos, _ := out["qsos"].([]any)
+			for _, qso := range qsos {
+				calls = append(calls, qso.(map[string]any)["call"].(string))
+			}
+			next, _ := out["next_cursor"].(string)
+			if next == "" {
+				return calls
+			}
+			query = "limit=3&cursor=" + url.QueryEscape(next)
+		}
+		t.Fatalf("expected paging by %q to end", sort)
+		return nil
+	}
+
+	if got := pages(qsoSortTime); len(got) != 4 || got[0] != "K1ABC" || got[1] != "K2ABC" || got[2] != "K3ABC" || got[3] != "K4ABC" {
+		t.Errorf("expected the QSOs oldest first, got %v", got)
+	}
+	if got := pages(qsoSortTimeDescending); len(got) != 4 || got[0] != "K4ABC" || got[1] != "K3ABC" || got[2] != "K2ABC" || got[3] != "K1ABC" {
+		t.Errorf("expected the QSOs newest first, got %v", got)
+	}
+
+	if status, _ := get("sort=call"); status != fiber.StatusBadRequest {
+		t.Errorf("expected an unknown sort to be rejected, got %d", status)
+	}
+	if status, _ := get("cursor=bogus"); status != fiber.StatusBadRequest {
+		t.Errorf("expected an invalid cursor to be rejected, got %d", status)
+	}
+	// A well-formed cursor whose date the database cannot read is the client's error.
+	if status, _ := get("cursor=" + qsoCursor{QsoDate: "x", TimeOn: "y", ID: 1}.encode()); status != fiber.StatusBadRequest {
+		t.Errorf("expected a cursor with a bad date to be rejected, got %d", status)
+	}
+	descending := qsoCursor{Descending: true, QsoDate: "20240101", TimeOn: "1200", ID: 2}.encode()
+	if status, _ := get("sort=time&cursor=" + descending); status != fiber.StatusBadRequest {
+		t.Errorf("expected a cursor for the other order to be rejected, got %d", status)
+	}
+	if status, _ := get("sort=time&after_id=1"); status != fiber.StatusBadRequest {
+		t.Errorf("expected after_id with sort to be rejected, got %d", status)
+	}
+}
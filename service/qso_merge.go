@@ -0,0 +1,258 @@
+package service
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/Station-Manager/adapters"
+	pgmodels "github.com/Station-Manager/database/postgres/models"
+	sqmodels "github.com/Station-Manager/database/sqlite/models"
+	"github.com/Station-Manager/errors"
+	"github.com/Station-Manager/types"
+	"github.com/aarondl/sqlboiler/v4/boil"
+	"github.com/goccy/go-json"
+	"github.com/gofiber/fiber/v2"
+)
+
+// Merging folds a duplicate QSO, such as one logged by WSJT-X and again by hand, into the QSO it
+// duplicates. The kept QSO takes every field set on either; where both set a field differently,
+// the value of the QSO with more fields set wins. QSL received or sent on either stays so, and the
+// duplicate's electronic confirmations and QSL card images move to the kept QSO. The duplicate is
+// moved to the trash, and both QSOs' histories record the merge, each naming the other.
+
+// qslStatusFields are the paper QSL fields that are merged together: the status, and the route
+// and date that go with it.
+var qslStatusFields = [][]string{
+	{"qsl_rcvd", "qsl_rcvd_via", "qslrdate"},
+	{"qsl_sent", "qsl_sent_via", "qslsdate"},
+}
+
+// qsoMergeRequest names the QSO to merge into the one in the path.
+type qsoMergeRequest struct {
+	DuplicateID int64 `json:"duplicate_id"`
+}
+
+// mergeQsoFields returns the kept QSO with the fields of the duplicate merged in.
+func mergeQsoFields(kept, duplicate types.Qso) (types.Qso, error) {
+	keptFields, err := qsoFields(kept)
+	if err != nil {
+		return kept, err
+	}
+	duplicateFields, err := qsoFields(duplicate)
+	if err != nil {
+		return kept, err
+	}
+
+	richer, poorer := keptFields, duplicateFields
+	if len(duplicateFields) > len(keptFields) {
+		richer, poorer = duplicateFields, keptFields
+	}
+	merged := make(map[string]any, len(richer)+len(poorer))
+	for name, value := range poorer {
+		merged[name] = value
+	}
+	for name, value := range richer {
+		merged[name] = value
+	}
+	for _, group := range qslStatusFields {
+		if merged[group[0]] == "Y" {
+			continue
+		}
+		for _, fields := range []map[string]any{keptFields, duplicateFields} {
+			if fields[group[0]] != "Y" {
+				continue
+			}
+			for _, name := range group {
+				merged[name] = fields[name]
+			}
+			break
+		}
+	}
+
+	data, err := json.Marshal(merged)
+	if err != nil {
+		return kept, err
+	}
+	result := kept
+	err = json.Unmarshal(data, &result)
+	return result, err
+}
+
+// mergeQsos merges the duplicate into the kept QSO, both of the logbook and out of the trash, and
+// returns the merged QSO. It reports false if either is not found.
+func (s *Service) mergeQsos(ctx context.Context, logbookID, keptID, duplicateID int64, actor string) (types.Qso, bool, error) {
+	const op errors.Op = "server.Service.mergeQsos"
+
+	kept, err := s.db.FetchQsoByIdContext(ctx, keptID)
+	if err != nil || kept.LogbookID != logbookID {
+		return types.Qso{}, false, nil
+	}
+	duplicate, err := s.db.FetchQsoByIdContext(ctx, duplicateID)
+	if err != nil || duplicate.LogbookID != logbookID {
+		return types.Qso{}, false, nil
+	}
+	merged, err := mergeQsoFields(kept, duplicate)
+	if err != nil {
+		return types.Qso{}, false, errors.New(op).Err(err)
+	}
+	changes, err := qsoChanges(kept, merged)
+	if err != nil {
+		return types.Qso{}, false, errors.New(op).Err(err)
+	}
+
+	found := false
+	err = s.withTx(ctx, func(tx *sql.Tx) error {
+		// The duplicate leaves the live QSOs first, so that the kept QSO may take its place.
+		res, txErr := tx.ExecContext(ctx, `UPDATE qso SET deleted_at = CURRENT_TIMESTAMP WHERE id = $1 AND logbook_id = $2 AND deleted_at IS NULL`, duplicateID, logbookID)
+		if txErr != nil {
+			return txErr
+		}
+		n, txErr := res.RowsAffected()
+		if txErr != nil {
+			return txErr
+		}
+		if found = n > 0; !found {
+			return nil
+		}
+		if txErr = s.updateQsoTx(ctx, tx, merged); txErr != nil {
+			return txErr
+		}
+
+		// A source that confirmed both QSOs keeps its confirmation of the kept one.
+		for _, query := range []string{
+			`UPDATE qso_confirmations SET qso_id = $1 WHERE qso_id = $2
+				AND source NOT IN (SELECT source FROM qso_confirmations WHERE qso_id = $1)`,
+			`UPDATE qsl_images SET qso_id = $1 WHERE qso_id = $2`,
+		} {
+			if _, txErr = tx.ExecContext(ctx, query, keptID, duplicateID); txErr != nil {
+				return txErr
+			}
+		}
+
+		if txErr = recordQsoHistory(ctx, tx, qsoHistoryEntry{QsoID: keptID, LogbookID: logbookID, Action: qsoHistoryMerge, Actor: actor,
+			Changes: changes, RelatedQsoID: &duplicateID}); txErr != nil {
+			return txErr
+		}
+		return recordQsoHistory(ctx, tx, qsoHistoryEntry{QsoID: duplicateID, LogbookID: logbookID, Action: qsoHistoryMerge, Actor: actor,
+			RelatedQsoID: &keptID})
+	})
+	if err != nil {
+		return types.Qso{}, false, errors.New(op).Err(err)
+	}
+	if !found {
+		return types.Qso{}, false, nil
+	}
+
+	s.publishQsoEvent(ctx, qsoEventDeleted, duplicate)
+	s.publishQsoUpdated(ctx, keptID)
+	return merged, true, nil
+}
+
+// updateQsoTx updates the QSO within tx as the database module would.
+func (s *Service) updateQsoTx(ctx context.Context, tx *sql.Tx, qso types.Qso) error {
+	const op errors.Op = "server.Service.updateQsoTx"
+
+	adapter := s.qsoModelAdapter()
+	var n int64
+	if s.isPostgres() {
+		m, err := adapters.AdaptTo[pgmodels.Qso](adapter, &qso)
+		if err != nil {
+			return errors.New(op).Err(err)
+		}
+		m.ID = qso.ID
+		if n, err = m.Update(ctx, tx, boil.Infer()); err != nil {
+			return errors.New(op).Err(err)
+		}
+	} else {
+		m, err := adapters.AdaptTo[sqmodels.Qso](adapter, &qso)
+		if err != nil {
+			return errors.New(op).Err(err)
+		}
+		m.ID = qso.ID
+		if len(m.AdditionalData) == 0 {
+			m.AdditionalData = []byte("{}")
+		}
+		if n, err = m.Update(ctx, tx, boil.Infer()); err != nil {
+			return errors.New(op).Err(err)
+		}
+	}
+	if n == 0 {
+		return errors.New(op).Err(sql.ErrNoRows)
+	}
+	return nil
+}
+
+// fetchQsoTx reads the QSO within tx as the database module would, whether or not it is in the
+// trash.
+func (s *Service) fetchQsoTx(ctx context.Context, tx *sql.Tx, id int64) (types.Qso, error) {
+	const op errors.Op = "server.Service.fetchQsoTx"
+
+	var (
+		model any
+		err   error
+	)
+	if s.isPostgres() {
+		model, err = pgmodels.FindQso(ctx, tx, id)
+	} else {
+		model, err = sqmodels.FindQso(ctx, tx, id)
+	}
+	if err != nil {
+		return types.Qso{}, errors.New(op).Err(err)
+	}
+	var qso types.Qso
+	if err = s.qsoTypeAdapter().Into(&qso, model); err != nil {
+		return types.Qso{}, errors.New(op).Err(err)
+	}
+	return qso, nil
+}
+
+// mergeQsoHandler merges the QSO named by duplicate_id in the body into the QSO in the path, both
+// of the authenticated logbook, and returns the merged QSO.
+func (s *Service) mergeQsoHandler(c *fiber.Ctx) error {
+	const op errors.Op = "server.Service.mergeQsoHandler"
+
+	logbook, err := authenticatedLogbook(c)
+	if err != nil {
+		s.logger.ErrorWith().Err(errors.New(op).Err(err)).Msg("authenticatedLogbook failed")
+		return c.Status(fiber.StatusInternalServerError).JSON(jsonInternalError)
+	}
+
+	id, ok, err := s.resolveQsoRef(c.UserContext(), logbook.ID, c.Params("id"))
+	if err != nil {
+		s.logger.ErrorWith().Err(errors.New(op).Err(err)).Msg("s.resolveQsoRef failed")
+		return s.dbFailure(c, err)
+	}
+	if !ok {
+		return c.Status(fiber.StatusBadRequest).JSON(jsonBadRequest)
+	}
+	var request qsoMergeRequest
+	if err = c.BodyParser(&request); err != nil || request.DuplicateID <= 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(jsonError(errCodeBadRequest, "duplicate_id must name a QSO"))
+	}
+	if request.DuplicateID == id {
+		return c.Status(fiber.StatusBadRequest).JSON(jsonError(errCodeBadRequest, "A QSO cannot be merged into itself"))
+	}
+
+	ctx := c.UserContext()
+	merged, found, err := s.mergeQsos(ctx, logbook.ID, id, request.DuplicateID, actorOf(c))
+	if err != nil {
+		s.logger.ErrorWith().Err(errors.New(op).Err(err)).Int64("qso_id", id).Msg("s.mergeQsos failed")
+		return s.dbFailure(c, err)
+	}
+	if !found {
+		return c.Status(fiber.StatusNotFound).JSON(jsonError(errCodeQsoNotFound, "QSO not found"))
+	}
+
+	confirmations, err := s.listQsoConfirmations(ctx, merged.ID)
+	if err != nil {
+		s.logger.ErrorWith().Err(errors.New(op).Err(err)).Msg("s.listQsoConfirmations failed")
+		return s.dbFailure(c, err)
+	}
+	applyConfirmations(&merged, confirmations)
+	public, err := s.publicQsoOf(ctx, merged)
+	if err != nil {
+		s.logger.ErrorWith().Err(errors.New(op).Err(err)).Msg("s.publicQsoOf failed")
+		return s.dbFailure(c, err)
+	}
+	return c.JSON(fiber.Map{"qso": public, "confirmations": confirmations, "merged_qso_id": request.DuplicateID})
+}
@@ -0,0 +1,111 @@
+package service
+
+import (
+	"context"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/Station-Manager/types"
+	"github.com/gofiber/fiber/v2"
+)
+
+func TestMergeQsoFields(t *testing.T) {
+	var kept, duplicate types.Qso
+	kept.ID, kept.Call, kept.Band, kept.TimeOn, kept.Comment = 1, "JA1XX", "20m", "1203", "typed in"
+	kept.QslSent, kept.QslSendVia = "Y", "B"
+	duplicate.ID, duplicate.Call, duplicate.Band, duplicate.TimeOn, duplicate.Name, duplicate.QTH = 2, "JA1XX", "20m", "120315", "Taro", "Tokyo"
+	duplicate.QslSent, duplicate.QslRcvd, duplicate.QslRcvdVia = "N", "Y", "E"
+
+	merged, err := mergeQsoFields(kept, duplicate)
+	if err != nil {
+		t.Fatalf("mergeQsoFields failed: %v", err)
+	}
+	if merged.ID != 1 || merged.Comment != "typed in" || merged.Name != "Taro" || merged.QTH != "Tokyo" {
+		t.Errorf("expected the fields of both QSOs, got %+v", merged)
+	}
+	if merged.TimeOn != "120315" {
+		t.Errorf("expected the richer QSO's time to win, got %q", merged.TimeOn)
+	}
+	if merged.QslSent != "Y" || merged.QslSendVia != "B" || merged.QslRcvd != "Y" || merged.QslRcvdVia != "E" {
+		t.Errorf("expected the QSLs of both QSOs to be kept, got %+v", merged.Qsl)
+	}
+}
+
+func TestMergeQsoHandler(t *testing.T) {
+	svc := newTestServer(t)
+	svc.db = newLiveStore(svc.db)
+	ctx := context.Background()
+	keptID := insertTestQso(t, svc, "JA1XX", "20m", "FT8", "20240430", "1203")
+	duplicateID := insertTestQso(t, svc, "JA1XX", "20m", "FT8", "20240430", "1204")
+
+	duplicate, err := svc.db.FetchQsoByIdContext(ctx, duplicateID)
+	if err != nil {
+		t.Fatalf("FetchQsoByIdContext failed: %v", err)
+	}
+	duplicate.Name, duplicate.QslRcvd, duplicate.QslRcvdVia = "Taro", "Y", "B"
+	if err = svc.db.UpdateQsoContext(ctx, duplicate); err != nil {
+		t.Fatalf("UpdateQsoContext failed: %v", err)
+	}
+	for _, query := range []string{
+		`INSERT INTO qso_confirmations (qso_id, source, received_date) VALUES ($1, 'lotw', '20240501')`,
+		`INSERT INTO qsl_images (logbook_id, qso_id, side, content_type, size, width, height, object_name, thumbnail_name) VALUES (1, $1, 'front', 'image/png', 1, 1, 1, 'a', 'b')`,
+	} {
+		if _, err = svc.db.ExecContext(ctx, query, duplicateID); err != nil {
+			t.Fatalf("setup failed: %v", err)
+		}
+	}
+
+	app := fiber.New()
+	app.Post("/qsos/:id/merge", withLogbook(1, svc.mergeQsoHandler))
+	app.Post("/other/qsos/:id/merge", withLogbook(2, svc.mergeQsoHandler))
+	merge := func(prefix string, id, duplicateID int64) int {
+		body := `{"duplicate_id":` + strconv.FormatInt(duplicateID, 10) + `}`
+		req := httptest.NewRequest(fiber.MethodPost, prefix+"/qsos/"+strconv.FormatInt(id, 10)+"/merge", strings.NewReader(body))
+		req.Header.Set(fiber.HeaderContentType, fiber.MIMEApplicationJSON)
+		resp, err := app.Test(req)
+		if err != nil {
+			t.Fatalf("request failed: %v", err)
+		}
+		return resp.StatusCode
+	}
+
+	if status := merge("/other", keptID, duplicateID); status != fiber.StatusNotFound {
+		t.Errorf("expected another logbook's merge to get 404, got %d", status)
+	}
+	if status := merge(emptyString, keptID, keptID); status != fiber.StatusBadRequest {
+		t.Errorf("expected merging a QSO into itself to get 400, got %d", status)
+	}
+	if status := merge(emptyString, keptID, duplicateID); status != fiber.StatusOK {
+		t.Fatalf("expected the merge to succeed, got %d", status)
+	}
+	if status := merge(emptyString, keptID, duplicateID); status != fiber.StatusNotFound {
+		t.Errorf("expected a merged duplicate to be gone, got %d", status)
+	}
+
+	kept, err := svc.db.FetchQsoByIdContext(ctx, keptID)
+	if err != nil || kept.Name != "Taro" || kept.QslRcvd != "Y" || kept.QslRcvdVia != "B" {
+		t.Errorf("expected the duplicate's fields on the kept QSO, got %+v (err=%v)", kept, err)
+	}
+	if _, err = svc.db.FetchQsoByIdContext(ctx, duplicateID); err == nil {
+		t.Errorf("expected the duplicate to be in the trash")
+	}
+	if confirmations, err := svc.listQsoConfirmations(ctx, keptID); err != nil || len(confirmations) != 1 {
+		t.Errorf("expected the duplicate's confirmation to move, got %+v (err=%v)", confirmations, err)
+	}
+	if images, err := svc.listQslImages(ctx, 1, keptID); err != nil || len(images) != 1 {
+		t.Errorf("expected the duplicate's QSL image to move, got %+v (err=%v)", images, err)
+	}
+
+	for qsoID, related := range map[int64]int64{keptID: duplicateID, duplicateID: keptID} {
+		entries, err := svc.listQsoHistory(ctx, 1, qsoID)
+		if err != nil || len(entries) == 0 {
+			t.Fatalf("listQsoHistory failed: %v", err)
+		}
+		last := entries[len(entries)-1]
+		if last.Action != qsoHistoryMerge || last.RelatedQsoID == nil || *last.RelatedQsoID != related {
+			t.Errorf("expected QSO %d's history to record the merge with %d, got %+v", qsoID, related, last)
+		}
+	}
+}
@@ -0,0 +1,131 @@
+package service
+
+import (
+	"context"
+	"strings"
+
+	"github.com/Station-Manager/errors"
+	"github.com/Station-Manager/types"
+	"github.com/gofiber/fiber/v2"
+)
+
+// QSOs are searched on their comment, notes and the contacted station's name and QTH. PostgreSQL
+// keeps the words in the qso table's search_vector column and SQLite in the qso_fts FTS5 table,
+// which triggers keep in step with the qso table. Every word searched for must be present.
+
+// rankedQso is a QSO found by a search. The higher its rank, the better it matches; ranks only
+// compare the QSOs of one search.
+type rankedQso struct {
+	publicQso
+	Rank float64 `json:"rank"`
+}
+
+// qsoMatch is a QSO found by a search before it is fetched.
+type qsoMatch struct {
+	id   int64
+	rank float64
+}
+
+// ftsQuery returns the words of a search as an FTS5 query that matches QSOs holding all of them.
+// Each word is quoted, so that the query syntax is never taken from what the reader typed.
+func ftsQuery(search string) string {
+	words := strings.Fields(search)
+	for i, word := range words {
+		words[i] = `"` + strings.ReplaceAll(word, `"`, `""`) + `"`
+	}
+	return strings.Join(words, " ")
+}
+
+// searchLogbookQsos returns up to limit of the logbook's QSOs matching the search, best first.
+func (s *Service) searchLogbookQsos(ctx context.Context, logbookID int64, search string, limit int) ([]qsoMatch, error) {
+	const op errors.Op = "server.Service.searchLogbookQsos"
+
+	query := `SELECT q.id, -bm25(qso_fts) FROM qso_fts JOIN qso q ON q.id = qso_fts.rowid
+		WHERE qso_fts MATCH $2 AND q.logbook_id = $1 AND q.deleted_at IS NULL
+		ORDER BY bm25(qso_fts), q.id DESC LIMIT $3`
+	arg := ftsQuery(search)
+	if s.isPostgres() {
+		query = `SELECT id, ts_rank(search_vector, query) FROM qso, plainto_tsquery('simple', $2) query
+			WHERE search_vector @@ query AND logbook_id = $1 AND deleted_at IS NULL
+			ORDER BY 2 DESC, id DESC LIMIT $3`
+		arg = search
+	}
+
+	rows, err := s.db.QueryContext(ctx, query, logbookID, arg, limit)
+	if err != nil {
+		return nil, errors.New(op).Err(err)
+	}
+	defer func() { _ = rows.Close() }()
+
+	var matches []qsoMatch
+	for rows.Next() {
+		var match qsoMatch
+		if err = rows.Scan(&match.id, &match.rank); err != nil {
+			return nil, errors.New(op).Err(err)
+		}
+		matches = append(matches, match)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, errors.New(op).Err(err)
+	}
+	return matches, nil
+}
+
+// v2SearchQsosHandler returns the logbook's QSOs whose comment, notes, name or QTH hold every word
+// of the q query parameter, best matches first. Times are also given in the reader's time zone, if
+// they have one.
+func (s *Service) v2SearchQsosHandler(c *fiber.Ctx) error {
+	const op errors.Op = "server.Service.v2SearchQsosHandler"
+
+	logbook, err := authenticatedLogbook(c)
+	if err != nil {
+		s.logger.ErrorWith().Err(errors.New(op).Err(err)).Msg("authenticatedLogbook failed")
+		return c.Status(fiber.StatusInternalServerError).JSON(jsonInternalError)
+	}
+	search := strings.TrimSpace(c.Query("q"))
+	if search == emptyString {
+		return c.Status(fiber.StatusBadRequest).JSON(jsonError(errCodeBadRequest, "q is required"))
+	}
+	limit := c.QueryInt("limit", v2QsoPageDefault)
+	if limit <= 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(jsonBadRequest)
+	}
+	limit = min(limit, v2QsoPageMax)
+
+	location, ok, err := s.readerLocation(c)
+	if err != nil {
+		s.logger.ErrorWith().Err(errors.New(op).Err(err)).Msg("s.readerLocation failed")
+		return s.dbFailure(c, err)
+	}
+	if !ok {
+		return c.Status(fiber.StatusBadRequest).JSON(jsonError(errCodeBadRequest, "tz is not a time zone"))
+	}
+
+	ctx := c.UserContext()
+	matches, err := s.searchLogbookQsos(ctx, logbook.ID, search, limit)
+	if err != nil {
+		s.logger.ErrorWith().Err(errors.New(op).Err(err)).Msg("s.searchLogbookQsos failed")
+		return s.dbFailure(c, err)
+	}
+	qsos := make([]types.Qso, 0, len(matches))
+	for _, match := range matches {
+		qso, err := s.db.FetchQsoByIdContext(ctx, match.id)
+		if err != nil {
+			s.logger.ErrorWith().Err(errors.New(op).Err(err)).Int64("qso_id", match.id).Msg("s.db.FetchQsoByIdContext failed")
+			return s.dbFailure(c, err)
+		}
+		qsos = append(qsos, qso)
+	}
+	public, err := s.publicQsos(ctx, logbook.ID, qsos)
+	if err != nil {
+		s.logger.ErrorWith().Err(errors.New(op).Err(err)).Msg("s.publicQsos failed")
+		return s.dbFailure(c, err)
+	}
+	localizeQsos(public, location)
+
+	ranked := make([]rankedQso, len(public))
+	for i, qso := range public {
+		ranked[i] = rankedQso{publicQso: qso, Rank: matches[i].rank}
+	}
+	return c.JSON(fiber.Map{"qsos": ranked})
+}
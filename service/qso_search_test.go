@@ -0,0 +1,82 @@
+package service
+
+import (
+	"context"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/Station-Manager/types"
+	"github.com/goccy/go-json"
+	"github.com/gofiber/fiber/v2"
+)
+
+func TestFtsQuery(t *testing.T) {
+	if got := ftsQuery(` nice  "chat" OR*`); got != `"nice" """chat""" "OR*"` {
+		t.Errorf("expected every word quoted, got %s", got)
+	}
+}
+
+func TestV2_SearchQsos(t *testing.T) {
+	svc := newTestServer(t)
+	ctx := context.Background()
+	setDetails := func(id int64, details string) {
+		if _, err := svc.db.ExecContext(ctx, `UPDATE qso SET additional_data = $1 WHERE id = $2`, details, id); err != nil {
+			t.Fatalf("update qso failed: %v", err)
+		}
+	}
+	bob := insertTestQso(t, svc, "K1ABC", "20m", "FT8", "20240101", "1200")
+	setDetails(bob, `{"Name":"Bob","QTH":"Boston","Comment":"Nice chat about antennas"}`)
+	alice := insertTestQso(t, svc, "K2ABC", "20m", "FT8", "20240101", "1300")
+	setDetails(alice, `{"Name":"Alice","Notes":"antennas antennas antennas"}`)
+	deleted := insertTestQso(t, svc, "K3ABC", "20m", "FT8", "20240101", "1400")
+	setDetails(deleted, `{"Comment":"antennas"}`)
+	if _, err := svc.db.ExecContext(ctx, `UPDATE qso SET deleted_at = CURRENT_TIMESTAMP WHERE id = $1`, deleted); err != nil {
+		t.Fatalf("delete qso failed: %v", err)
+	}
+
+	app := fiber.New()
+	withKey := func(c *fiber.Ctx) error {
+		c.Locals(localsRequestDataKey, &requestContext{Logbook: &types.Logbook{ID: 1}, IsValid: true})
+		return c.Next()
+	}
+	app.Get("/api/v2/logbooks/:id/qsos/search", withKey, svc.pathLogbookMiddleware(), svc.v2SearchQsosHandler)
+
+	search := func(q string) (int, []string) {
+		resp, err := app.Test(httptest.NewRequest(fiber.MethodGet, "/api/v2/logbooks/1/qsos/search?q="+url.QueryEscape(q), nil))
+		if err != nil {
+			t.Fatalf("search %q failed: %v", q, err)
+		}
+		var out struct {
+			Qsos []struct {
+				Call string  `json:"call"`
+				Rank float64 `json:"rank"`
+			} `json:"qsos"`
+		}
+		_ = json.NewDecoder(resp.Body).Decode(&out)
+		var calls []string
+		for _, qso := range out.Qsos {
+			calls = append(calls, qso.Call)
+		}
+		return resp.StatusCode, calls
+	}
+
+	if status, calls := search("antennas"); status != fiber.StatusOK || len(calls) != 2 || calls[0] != "K2ABC" || calls[1] != "K1ABC" {
+		t.Errorf("expected the live QSOs mentioning antennas, best first, got %d %v", status, calls)
+	}
+	if _, calls := search("boston CHAT"); len(calls) != 1 || calls[0] != "K1ABC" {
+		t.Errorf("expected every word to match across fields, got %v", calls)
+	}
+	if _, calls := search(`bob "alice`); len(calls) != 0 {
+		t.Errorf("expected no QSO to hold both words, got %v", calls)
+	}
+	if status, _ := search(" "); status != fiber.StatusBadRequest {
+		t.Errorf("expected an empty search to be rejected, got %d", status)
+	}
+
+	// Edits are searchable at once.
+	setDetails(bob, `{"Name":"Robert"}`)
+	if _, calls := search("robert"); len(calls) != 1 || calls[0] != "K1ABC" {
+		t.Errorf("expected the edited QSO to be found, got %v", calls)
+	}
+}
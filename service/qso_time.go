@@ -0,0 +1,266 @@
+package service
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+	// Time zones are named by logbooks and users, so the server carries its own copy of the
+	// database rather than relying on the host's.
+	_ "time/tzdata"
+
+	"github.com/Station-Manager/types"
+)
+
+const (
+	// qsoTimeSkew is how far ahead of the server's clock a QSO may be, for clients whose clocks run
+	// a little fast.
+	qsoTimeSkew = 10 * time.Minute
+
+	qsoDateLayout      = "20060102"
+	qsoTimeLayout      = "1504"
+	qsoTimeLayoutExact = "150405"
+)
+
+// earliestQsoDate is the earliest QSO_DATE that ADIF allows.
+var earliestQsoDate = time.Date(1930, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// How a logbook reads local QSO times that fall in the hour repeated when the clocks go back.
+const (
+	ambiguousTimeReject  = "reject"
+	ambiguousTimeEarlier = "earlier"
+	ambiguousTimeLater   = "later"
+)
+
+// qsoTimeError reports a QSO date or time that cannot be stored.
+type qsoTimeError struct {
+	Field  string
+	Reason string
+}
+
+func (e *qsoTimeError) Error() string {
+	return e.Field + " " + e.Reason
+}
+
+// qsoClock is how a logbook reads the dates and times of the QSOs logged into it. Times with a UTC
+// offset are converted to UTC; times without one are UTC, as ADIF has them, unless the logbook
+// names the time zone its clients log in local time. Local times in the hour repeated when the
+// clocks go back are refused unless the logbook says which of the two it means.
+type qsoClock struct {
+	location  *time.Location
+	ambiguous string
+}
+
+// normalize converts the QSO's start and end to UTC in ADIF's format, keeping the seconds if they
+// were given. Dates may also be given as YYYY-MM-DD, and times as HH:MM[:SS]; a time may end in Z or
+// a UTC offset, and qso_date may be an RFC 3339 timestamp that leaves time_on empty. An end without
+// its own date that is earlier than the start is taken to be on the following day. QSOs dated
+// before 1930, or after now, are refused.
+func (c qsoClock) normalize(qso *types.Qso, now time.Time) error {
+	dateOn := qso.QsoDate
+	on, exact, err := c.parse("qso_date", "time_on", qso.QsoDate, qso.TimeOn)
+	if err != nil {
+		return err
+	}
+	if err = checkQsoTime("qso_date", on, now); err != nil {
+		return err
+	}
+	if qso.TimeOn == emptyString && !strings.Contains(qso.QsoDate, "T") {
+		qso.QsoDate = on.Format(qsoDateLayout)
+		return nil
+	}
+	qso.QsoDate, qso.TimeOn = formatQsoTime(on, exact)
+
+	if qso.TimeOff == emptyString {
+		return nil
+	}
+	dateOff := qso.QsoDateOff
+	if dateOff == emptyString {
+		dateOff, _, _ = strings.Cut(dateOn, "T")
+	}
+	off, exact, err := c.parse("qso_date_off", "time_off", dateOff, qso.TimeOff)
+	if err != nil {
+		return err
+	}
+	if qso.QsoDateOff == emptyString && off.Before(on) {
+		off = off.AddDate(0, 0, 1)
+	}
+	if err = checkQsoTime("time_off", off, now); err != nil {
+		return err
+	}
+	dateOff, qso.TimeOff = formatQsoTime(off, exact)
+	if qso.QsoDateOff != emptyString || dateOff != qso.QsoDate {
+		qso.QsoDateOff = dateOff
+	}
+	return nil
+}
+
+// parse reads a date and a time into an instant, reporting whether the time had seconds.
+func (c qsoClock) parse(dateField, timeField, date, clock string) (time.Time, bool, error) {
+	if strings.Contains(date, "T") {
+		if clock != emptyString {
+			return time.Time{}, false, &qsoTimeError{Field: timeField, Reason: "must be empty when " + dateField + " is a timestamp"}
+		}
+		t, err := time.Parse(time.RFC3339, date)
+		if err != nil {
+			return time.Time{}, false, &qsoTimeError{Field: dateField, Reason: "is not an RFC 3339 timestamp"}
+		}
+		return t.UTC(), t.Second() != 0, nil
+	}
+
+	day, err := parseQsoDate(date)
+	if err != nil {
+		return time.Time{}, false, &qsoTimeError{Field: dateField, Reason: "is not a date"}
+	}
+	if clock == emptyString {
+		return day, false, nil
+	}
+
+	clock, offset, hasOffset, err := splitUTCOffset(clock)
+	if err != nil {
+		return time.Time{}, false, &qsoTimeError{Field: timeField, Reason: "has a bad UTC offset"}
+	}
+	clock = strings.ReplaceAll(clock, ":", emptyString)
+	layout := qsoTimeLayout
+	if len(clock) == len(qsoTimeLayoutExact) {
+		layout = qsoTimeLayoutExact
+	}
+	tod, err := time.Parse(layout, clock)
+	if err != nil {
+		return time.Time{}, false, &qsoTimeError{Field: timeField, Reason: "is not a time"}
+	}
+	wall := day.Add(time.Duration(tod.Hour())*time.Hour + time.Duration(tod.Minute())*time.Minute + time.Duration(tod.Second())*time.Second)
+	exact := layout == qsoTimeLayoutExact
+
+	switch {
+	case hasOffset:
+		return wall.Add(-offset), exact, nil
+	case c.location == nil:
+		return wall, exact, nil
+	}
+	t, err := c.local(wall)
+	if err != nil {
+		return time.Time{}, false, &qsoTimeError{Field: timeField, Reason: err.Error()}
+	}
+	return t, exact, nil
+}
+
+// local returns the instant at which the clocks of the time zone showed the wall time, given as if
+// it were UTC.
+func (c qsoClock) local(wall time.Time) (time.Time, error) {
+	// Offsets change at most once in a day, so the offsets half a day either side are the only ones
+	// the wall time can have.
+	var instants []time.Time
+	for _, probe := range []time.Time{wall.Add(-12 * time.Hour), wall.Add(12 * time.Hour)} {
+		_, offset := probe.In(c.location).Zone()
+		t := wall.Add(-time.Duration(offset) * time.Second)
+		if _, actual := t.In(c.location).Zone(); actual == offset && !slices.ContainsFunc(instants, t.Equal) {
+			instants = append(instants, t)
+		}
+	}
+	slices.SortFunc(instants, func(a, b time.Time) int { return a.Compare(b) })
+
+	switch {
+	case len(instants) == 0:
+		return time.Time{}, fmt.Errorf("does not exist in %s, whose clocks skip it", c.location)
+	case len(instants) == 1:
+		return instants[0], nil
+	case c.ambiguous == ambiguousTimeEarlier:
+		return instants[0], nil
+	case c.ambiguous == ambiguousTimeLater:
+		return instants[1], nil
+	}
+	return time.Time{}, fmt.Errorf("is ambiguous in %s, whose clocks repeat it", c.location)
+}
+
+// parseQsoDate reads a date as YYYYMMDD or YYYY-MM-DD.
+func parseQsoDate(date string) (time.Time, error) {
+	if strings.Contains(date, "-") {
+		return time.Parse(time.DateOnly, date)
+	}
+	return time.Parse(qsoDateLayout, date)
+}
+
+// splitUTCOffset splits a trailing Z or UTC offset (±HH, ±HHMM or ±HH:MM) from a time.
+func splitUTCOffset(clock string) (string, time.Duration, bool, error) {
+	if rest, ok := strings.CutSuffix(strings.ToUpper(clock), "Z"); ok {
+		return rest, 0, true, nil
+	}
+	i := strings.LastIndexAny(clock, "+-")
+	if i <= 0 {
+		return clock, 0, false, nil
+	}
+	digits := strings.ReplaceAll(clock[i+1:], ":", emptyString)
+	if len(digits) == 2 {
+		digits += "00"
+	}
+	if len(digits) != 4 {
+		return emptyString, 0, false, strconv.ErrSyntax
+	}
+	hours, err := strconv.Atoi(digits[:2])
+	if err != nil {
+		return emptyString, 0, false, err
+	}
+	minutes, err := strconv.Atoi(digits[2:])
+	if err != nil || hours > 14 || minutes > 59 {
+		return emptyString, 0, false, strconv.ErrSyntax
+	}
+	offset := time.Duration(hours)*time.Hour + time.Duration(minutes)*time.Minute
+	if clock[i] == '-' {
+		offset = -offset
+	}
+	return clock[:i], offset, true, nil
+}
+
+// checkQsoTime refuses instants before ADIF's earliest date or ahead of the server's clock.
+func checkQsoTime(field string, t, now time.Time) error {
+	if t.Before(earliestQsoDate) {
+		return &qsoTimeError{Field: field, Reason: "is before 1930"}
+	}
+	if t.After(now.Add(qsoTimeSkew)) {
+		return &qsoTimeError{Field: field, Reason: "is in the future"}
+	}
+	return nil
+}
+
+// formatQsoTime returns the ADIF date and time of an instant, with seconds if exact.
+func formatQsoTime(t time.Time, exact bool) (string, string) {
+	t = t.UTC()
+	if exact {
+		return t.Format(qsoDateLayout), t.Format(qsoTimeLayoutExact)
+	}
+	return t.Format(qsoDateLayout), t.Format(qsoTimeLayout)
+}
+
+// localQsoTimes are a QSO's start and end as RFC 3339 timestamps in the reader's time zone.
+type localQsoTimes struct {
+	TimeZone string `json:"time_zone"`
+	Start    string `json:"start"`
+	End      string `json:"end,omitempty"`
+}
+
+// localTimesOf returns the stored QSO's start and end in the time zone, or nil if its start cannot
+// be read.
+func localTimesOf(qso types.Qso, location *time.Location) *localQsoTimes {
+	// PostgreSQL returns DATE columns as timestamps at midnight.
+	date, _, _ := strings.Cut(qso.QsoDate, "T")
+	start, _, err := qsoClock{}.parse("qso_date", "time_on", date, qso.TimeOn)
+	if err != nil {
+		return nil
+	}
+	local := &localQsoTimes{TimeZone: location.String(), Start: start.In(location).Format(time.RFC3339)}
+	if qso.TimeOff != emptyString {
+		dateOff, _, _ := strings.Cut(qso.QsoDateOff, "T")
+		if dateOff == emptyString {
+			dateOff = date
+		}
+		if end, _, err := (qsoClock{}).parse("qso_date_off", "time_off", dateOff, qso.TimeOff); err == nil {
+			if qso.QsoDateOff == emptyString && end.Before(start) {
+				end = end.AddDate(0, 0, 1)
+			}
+			local.End = end.In(location).Format(time.RFC3339)
+		}
+	}
+	return local
+}
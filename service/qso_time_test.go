@@ -0,0 +1,99 @@
+package service
+
+import (
+	"context"
+	stderr "errors"
+	"testing"
+	"time"
+
+	"github.com/Station-Manager/types"
+	"github.com/go-playground/validator/v10"
+)
+
+func TestQsoClock_Normalize(t *testing.T) {
+	berlin, err := time.LoadLocation("Europe/Berlin")
+	if err != nil {
+		t.Fatalf("LoadLocation failed: %v", err)
+	}
+	now := time.Date(2024, 11, 23, 12, 0, 0, 0, time.UTC)
+
+	for _, tc := range []struct {
+		description                 string
+		clock                       qsoClock
+		date, on, dateOff, off      string
+		wantDate, wantOn            string
+		wantDateOff, wantOff, field string
+	}{
+		{"ADIF UTC is kept", qsoClock{}, "20241123", "1130", "", "1135", "20241123", "1130", "", "1135", ""},
+		{"seconds are kept", qsoClock{location: berlin}, "2024-11-23", "11:30:15Z", "", "", "20241123", "113015", "", "", ""},
+		{"offsets are converted", qsoClock{}, "20241123", "0030+02:00", "", "0045+0200", "20241122", "2230", "", "2245", ""},
+		{"local times are converted", qsoClock{location: berlin}, "20240701", "0130", "", "0200", "20240630", "2330", "20240701", "0000", ""},
+		{"an end before the start is the next day", qsoClock{}, "20241122", "2355", "", "0005", "20241122", "2355", "20241123", "0005", ""},
+		{"a timestamp sets the time", qsoClock{}, "2024-11-23T06:30:00-05:00", "", "", "", "20241123", "1130", "", "", ""},
+		{"a skipped local time", qsoClock{location: berlin}, "20240331", "0230", "", "", "", "", "", "", "time_on"},
+		{"a repeated local time", qsoClock{location: berlin}, "20241027", "0230", "", "", "", "", "", "", "time_on"},
+		{"the earlier repeated time", qsoClock{location: berlin, ambiguous: ambiguousTimeEarlier}, "20241027", "0230", "", "", "20241027", "0030", "", "", ""},
+		{"the later repeated time", qsoClock{location: berlin, ambiguous: ambiguousTimeLater}, "20241027", "0230", "", "", "20241027", "0130", "", "", ""},
+		{"a future QSO", qsoClock{}, "20241123", "1300", "", "", "", "", "", "", "qso_date"},
+		{"a QSO before 1930", qsoClock{}, "19291231", "1200", "", "", "", "", "", "", "qso_date"},
+		{"a bad time", qsoClock{}, "20241123", "25:00", "", "", "", "", "", "", "time_on"},
+		{"a bad date", qsoClock{}, "2024/11/23", "1200", "", "", "", "", "", "", "qso_date"},
+	} {
+		var qso types.Qso
+		qso.QsoDate, qso.TimeOn, qso.QsoDateOff, qso.TimeOff = tc.date, tc.on, tc.dateOff, tc.off
+		err := tc.clock.normalize(&qso, now)
+		if tc.field != emptyString {
+			var badTime *qsoTimeError
+			if !stderr.As(err, &badTime) || badTime.Field != tc.field {
+				t.Errorf("%s: expected %s to be refused, got %v", tc.description, tc.field, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: normalize failed: %v", tc.description, err)
+			continue
+		}
+		if qso.QsoDate != tc.wantDate || qso.TimeOn != tc.wantOn || qso.QsoDateOff != tc.wantDateOff || qso.TimeOff != tc.wantOff {
+			t.Errorf("%s: got %s %s - %s %s", tc.description, qso.QsoDate, qso.TimeOn, qso.QsoDateOff, qso.TimeOff)
+		}
+	}
+}
+
+func TestLocalTimesOf(t *testing.T) {
+	tokyo, err := time.LoadLocation("Asia/Tokyo")
+	if err != nil {
+		t.Fatalf("LoadLocation failed: %v", err)
+	}
+	var qso types.Qso
+	qso.QsoDate, qso.TimeOn, qso.TimeOff = "2024-11-23T00:00:00Z", "2355", "0005"
+	local := localTimesOf(qso, tokyo)
+	if local == nil || local.TimeZone != "Asia/Tokyo" || local.Start != "2024-11-24T08:55:00+09:00" || local.End != "2024-11-24T09:05:00+09:00" {
+		t.Errorf("unexpected local times %+v", local)
+	}
+}
+
+func TestInsertQso_LocalTimes(t *testing.T) {
+	svc, logbook := newContestTestServer(t)
+	svc.validate = validator.New(validator.WithRequiredStructEnabled())
+	ctx := context.Background()
+
+	if err := svc.saveLogbookDefaults(ctx, logbook.ID, logbookDefaults{TimeZone: "America/New_York"}); err != nil {
+		t.Fatalf("saveLogbookDefaults failed: %v", err)
+	}
+	qso := testContestQso("JA1XX", "20m", "CW")
+	qso.QsoDate, qso.TimeOn, qso.TimeOff = "20241123", "2030", "2040"
+	inserted, err := svc.insertQso(ctx, logbook, nil, "test", qso, emptyString, emptyString, false)
+	if err != nil {
+		t.Fatalf("insertQso failed: %v", err)
+	}
+	if inserted.QsoDate != "20241124" || inserted.TimeOn != "0130" || inserted.TimeOff != "0140" {
+		t.Errorf("expected the local times to be stored as UTC, got %s %s %s", inserted.QsoDate, inserted.TimeOn, inserted.TimeOff)
+	}
+
+	qso.QsoDate, qso.TimeOn, qso.TimeOff = "20241103", "0130", "0135"
+	_, err = svc.insertQso(ctx, logbook, nil, "test", qso, emptyString, emptyString, false)
+	status, body := svc.insertQsoFailure(err)
+	if status != 400 || body["code"] != errCodeInvalidQsoTime || body["field"] != "time_on" {
+		t.Errorf("expected an ambiguous time to be refused, got %d %v", status, body)
+	}
+}
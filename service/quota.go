@@ -0,0 +1,228 @@
+package service
+
+import (
+	"context"
+	"database/sql"
+	stderr "errors"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/Station-Manager/errors"
+	"github.com/gofiber/fiber/v2"
+)
+
+// Quotas bound what each user may hold, for servers hosting the logbooks of many users. A user's
+// logbooks, and the QSOs, API keys and QSL images of the logbooks they own, count against their
+// quotas, whoever added them. Each quota is set by an environment variable; unset or zero leaves it
+// unlimited. Quotas are checked before each addition, so an addition racing another may overshoot
+// a quota slightly.
+const (
+	envQuotaLogbooks      = "SM_QUOTA_LOGBOOKS"
+	envQuotaQsos          = "SM_QUOTA_QSOS"
+	envQuotaApiKeys       = "SM_QUOTA_API_KEYS"
+	envQuotaQslImageBytes = "SM_QUOTA_QSL_IMAGE_BYTES"
+)
+
+// quotaResource names a quota in responses.
+type quotaResource string
+
+const (
+	quotaLogbooks      quotaResource = "logbooks"
+	quotaQsos          quotaResource = "qsos"
+	quotaApiKeys       quotaResource = "api_keys"
+	quotaQslImageBytes quotaResource = "qsl_image_bytes"
+)
+
+// quotaResources lists the quotas in the order they are reported.
+var quotaResources = []quotaResource{quotaLogbooks, quotaQsos, quotaApiKeys, quotaQslImageBytes}
+
+// quotaUsageQueries holds the SQL that measures a user's use of each quota; $1 is the user.
+var quotaUsageQueries = map[quotaResource]string{
+	quotaLogbooks: `SELECT COUNT(*) FROM logbook WHERE user_id = $1 AND deleted_at IS NULL`,
+	quotaQsos: `SELECT COUNT(*) FROM qso q JOIN logbook l ON l.id = q.logbook_id
+		WHERE l.user_id = $1 AND q.deleted_at IS NULL`,
+	quotaApiKeys: `SELECT COUNT(*) FROM api_keys k JOIN logbook l ON l.id = k.logbook_id
+		WHERE l.user_id = $1 AND k.revoked_at IS NULL`,
+	quotaQslImageBytes: `SELECT COALESCE(SUM(i.size), 0) FROM qsl_images i JOIN logbook l ON l.id = i.logbook_id
+		WHERE l.user_id = $1`,
+}
+
+// quotas holds the limit of each quota; a missing or zero limit is no limit.
+type quotas map[quotaResource]int64
+
+// loadQuotas reads the quotas from the environment.
+func loadQuotas() (quotas, error) {
+	const op errors.Op = "server.loadQuotas"
+
+	names := map[quotaResource]string{
+		quotaLogbooks:      envQuotaLogbooks,
+		quotaQsos:          envQuotaQsos,
+		quotaApiKeys:       envQuotaApiKeys,
+		quotaQslImageBytes: envQuotaQslImageBytes,
+	}
+	limits := make(quotas)
+	for resource, name := range names {
+		value := strings.TrimSpace(os.Getenv(name))
+		if value == emptyString {
+			continue
+		}
+		var limit int64
+		var err error
+		if resource == quotaQslImageBytes {
+			var size int
+			size, err = parseByteSize(value)
+			limit = int64(size)
+		} else {
+			limit, err = strconv.ParseInt(value, 10, 64)
+		}
+		if err != nil || limit < 0 {
+			return nil, errors.New(op).Msg(name + " must be a non-negative number")
+		}
+		if limit > 0 {
+			limits[resource] = limit
+		}
+	}
+	return limits, nil
+}
+
+// quotaExceededError is returned when an addition would take a user past a quota.
+type quotaExceededError struct {
+	Resource quotaResource
+	Limit    int64
+}
+
+func (e *quotaExceededError) Error() string {
+	return "the account's " + strings.ReplaceAll(string(e.Resource), "_", " ") + " quota of " +
+		strconv.FormatInt(e.Limit, 10) + " is reached"
+}
+
+// quotaExceededBody returns the body of the 403 response to a request that failed with err, if
+// err is a quotaExceededError.
+func quotaExceededBody(err error) (fiber.Map, bool) {
+	var exceeded *quotaExceededError
+	if !stderr.As(err, &exceeded) {
+		return nil, false
+	}
+	body := jsonError(errCodeQuotaExceeded, "The "+exceeded.Error())
+	body["quota"] = exceeded.Resource
+	body["limit"] = exceeded.Limit
+	return body, true
+}
+
+// queryer is satisfied by both the store and a transaction.
+type queryer interface {
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+}
+
+// quotaUsage returns how much of the quota the user uses.
+func quotaUsage(ctx context.Context, q queryer, userID int64, resource quotaResource) (int64, error) {
+	const op errors.Op = "server.quotaUsage"
+
+	rows, err := q.QueryContext(ctx, quotaUsageQueries[resource], userID)
+	if err != nil {
+		return 0, errors.New(op).Err(err)
+	}
+	defer func() { _ = rows.Close() }()
+
+	var used int64
+	if rows.Next() {
+		if err = rows.Scan(&used); err != nil {
+			return 0, errors.New(op).Err(err)
+		}
+	}
+	if err = rows.Err(); err != nil {
+		return 0, errors.New(op).Err(err)
+	}
+	return used, nil
+}
+
+// checkUserQuota returns a quotaExceededError if adding n to the user's use of the quota would
+// take it past its limit.
+func (s *Service) checkUserQuota(ctx context.Context, q queryer, userID int64, resource quotaResource, n int64) error {
+	const op errors.Op = "server.Service.checkUserQuota"
+
+	limit := s.quotas[resource]
+	if limit <= 0 || userID <= 0 {
+		return nil
+	}
+	used, err := quotaUsage(ctx, q, userID, resource)
+	if err != nil {
+		return errors.New(op).Err(err)
+	}
+	if used+n > limit {
+		return &quotaExceededError{Resource: resource, Limit: limit}
+	}
+	return nil
+}
+
+// checkLogbookQuota is checkUserQuota for the owner of the logbook. Logbooks without an owner are
+// not bound by quotas.
+func (s *Service) checkLogbookQuota(ctx context.Context, q queryer, logbookID int64, resource quotaResource, n int64) error {
+	const op errors.Op = "server.Service.checkLogbookQuota"
+
+	if s.quotas[resource] <= 0 {
+		return nil
+	}
+	owner, err := logbookOwner(ctx, q, logbookID)
+	if err != nil {
+		return errors.New(op).Err(err)
+	}
+	return s.checkUserQuota(ctx, q, owner, resource, n)
+}
+
+// logbookOwner returns the ID of the user owning the logbook, or zero if it has none. The owner of
+// a sandbox is the owner of its parent.
+func logbookOwner(ctx context.Context, q queryer, logbookID int64) (int64, error) {
+	const op errors.Op = "server.logbookOwner"
+
+	rows, err := q.QueryContext(ctx, `SELECT COALESCE(l.user_id, p.user_id, 0) FROM logbook l
+			LEFT JOIN sandbox_logbooks sb ON sb.logbook_id = l.id
+			LEFT JOIN logbook p ON p.id = sb.parent_id
+		WHERE l.id = $1`, logbookID)
+	if err != nil {
+		return 0, errors.New(op).Err(err)
+	}
+	defer func() { _ = rows.Close() }()
+
+	var owner int64
+	if rows.Next() {
+		if err = rows.Scan(&owner); err != nil {
+			return 0, errors.New(op).Err(err)
+		}
+	}
+	if err = rows.Err(); err != nil {
+		return 0, errors.New(op).Err(err)
+	}
+	return owner, nil
+}
+
+// accountQuota is a quota as the account's owner sees it. A zero limit is no limit.
+type accountQuota struct {
+	Resource quotaResource `json:"quota"`
+	Used     int64         `json:"used"`
+	Limit    int64         `json:"limit"`
+}
+
+// accountQuotasHandler returns the authenticated user's use of each quota and its limit.
+func (s *Service) accountQuotasHandler(c *fiber.Ctx) error {
+	const op errors.Op = "server.Service.accountQuotasHandler"
+
+	reqCtx, err := getRequestContext(c)
+	if err != nil || reqCtx.User == nil {
+		err = errors.New(op).Err(err).Msg("Request context missing")
+		s.logger.ErrorWith().Err(err).Msg("getRequestContext failed")
+		return c.Status(fiber.StatusInternalServerError).JSON(jsonInternalError)
+	}
+
+	usage := make([]accountQuota, 0, len(quotaResources))
+	for _, resource := range quotaResources {
+		used, err := quotaUsage(c.UserContext(), s.db, reqCtx.User.ID, resource)
+		if err != nil {
+			s.logger.ErrorWith().Err(errors.New(op).Err(err)).Msg("quotaUsage failed")
+			return s.dbFailure(c, err)
+		}
+		usage = append(usage, accountQuota{Resource: resource, Used: used, Limit: s.quotas[resource]})
+	}
+	return c.JSON(fiber.Map{"quotas": usage})
+}
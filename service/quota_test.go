@@ -0,0 +1,60 @@
+package service
+
+import (
+	"context"
+	stderr "errors"
+	"testing"
+)
+
+func TestLoadQuotas(t *testing.T) {
+	t.Setenv(envQuotaLogbooks, "3")
+	t.Setenv(envQuotaQsos, "0")
+	t.Setenv(envQuotaApiKeys, emptyString)
+	t.Setenv(envQuotaQslImageBytes, "2MB")
+
+	limits, err := loadQuotas()
+	if err != nil {
+		t.Fatalf("loadQuotas failed: %v", err)
+	}
+	if len(limits) != 2 || limits[quotaLogbooks] != 3 || limits[quotaQslImageBytes] != 2<<20 {
+		t.Errorf("expected the logbook and image quotas only, got %v", limits)
+	}
+
+	for _, value := range []string{"-1", "many"} {
+		t.Setenv(envQuotaQsos, value)
+		if _, err = loadQuotas(); err == nil {
+			t.Errorf("expected %q to be rejected", value)
+		}
+	}
+}
+
+func TestQuotas_QsoInsertRefused(t *testing.T) {
+	svc, logbook := newContestTestServer(t)
+	ctx := context.Background()
+	for _, query := range []string{
+		`INSERT INTO users (id, callsign, pass_hash) VALUES (8, 'K1AB', 'hash')`,
+		`UPDATE logbook SET user_id = 8 WHERE id = 1`,
+	} {
+		if _, err := svc.db.ExecContext(ctx, query); err != nil {
+			t.Fatalf("setup failed: %v", err)
+		}
+	}
+	svc.quotas = quotas{quotaQsos: 1}
+
+	if _, err := svc.insertQso(ctx, logbook, nil, "test", testContestQso("JA1XX", "20m", "CW"), emptyString, emptyString, false); err != nil {
+		t.Fatalf("expected the first QSO to fit the quota, got %v", err)
+	}
+	_, err := svc.insertQso(ctx, logbook, nil, "test", testContestQso("JA2XX", "20m", "CW"), emptyString, emptyString, false)
+	var exceeded *quotaExceededError
+	if !stderr.As(err, &exceeded) || exceeded.Resource != quotaQsos {
+		t.Fatalf("expected the QSO quota to be reached, got %v", err)
+	}
+	status, body := svc.insertQsoFailure(err)
+	if status != 403 || body["code"] != errCodeQuotaExceeded || body["limit"] != int64(1) {
+		t.Errorf("expected a 403 naming the quota, got %d %v", status, body)
+	}
+
+	if err = svc.checkUserQuota(ctx, svc.db, 8, quotaLogbooks, 1); err != nil {
+		t.Errorf("expected an unset quota to be unlimited, got %v", err)
+	}
+}
@@ -0,0 +1,115 @@
+package service
+
+import (
+	"context"
+	stderr "errors"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Station-Manager/errors"
+)
+
+// envHTTPRedirectAddr names the environment variable holding the address of a plain-HTTP listener
+// that redirects to HTTPS (e.g. ":80"). It is only allowed when TLS is enabled.
+const envHTTPRedirectAddr = "SM_HTTP_REDIRECT_ADDR"
+
+// envHTTPSPublicPort names the environment variable holding the HTTPS port clients are redirected
+// to, when it differs from the configured port, e.g. behind port forwarding from 443.
+const envHTTPSPublicPort = "SM_HTTPS_PUBLIC_PORT"
+
+const redirectReadHeaderTimeout = 10 * time.Second
+
+// redirectConfig describes the HTTP to HTTPS redirect listener.
+type redirectConfig struct {
+	addr      string
+	httpsPort int
+}
+
+// loadRedirectConfig reads the redirect listener settings from the environment, or returns nil when
+// no redirect listener is wanted.
+func loadRedirectConfig(tlsActive bool, port int) (*redirectConfig, error) {
+	const op errors.Op = "server.loadRedirectConfig"
+
+	addr := strings.TrimSpace(os.Getenv(envHTTPRedirectAddr))
+	if addr == emptyString {
+		return nil, nil
+	}
+	if !tlsActive {
+		return nil, errors.New(op).Msg(envHTTPRedirectAddr + " requires TLS to be enabled")
+	}
+	if _, _, err := net.SplitHostPort(addr); err != nil {
+		return nil, errors.New(op).Err(err).Msg(envHTTPRedirectAddr + " must be a host:port address")
+	}
+
+	config := &redirectConfig{addr: addr, httpsPort: port}
+	if value := strings.TrimSpace(os.Getenv(envHTTPSPublicPort)); value != emptyString {
+		p, err := strconv.Atoi(value)
+		if err != nil || p < 1 || p > 65535 {
+			return nil, errors.New(op).Msg(envHTTPSPublicPort + " must be a port number")
+		}
+		config.httpsPort = p
+	}
+	return config, nil
+}
+
+// httpsRedirectHandler permanently redirects every request to the same host and path over HTTPS.
+func (r *redirectConfig) httpsRedirectHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		host := req.Host
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+		if r.httpsPort != 443 {
+			host = net.JoinHostPort(host, strconv.Itoa(r.httpsPort))
+		}
+		target := "https://" + host + req.URL.RequestURI()
+		http.Redirect(w, req, target, http.StatusMovedPermanently)
+	})
+}
+
+// startRedirectListener binds the redirect listener and serves it in the background. With
+// automatic TLS it also answers ACME HTTP-01 challenges. It returns once the address is bound so
+// that a port conflict fails startup.
+func (s *Service) startRedirectListener() error {
+	const op errors.Op = "server.Service.startRedirectListener"
+
+	if s.redirect == nil {
+		return nil
+	}
+
+	handler := s.redirect.httpsRedirectHandler()
+	if s.autocert != nil {
+		handler = s.autocert.HTTPHandler(handler)
+	}
+
+	ln, err := s.listen(listenerRedirect, s.redirect.addr)
+	if err != nil {
+		return errors.New(op).Err(err)
+	}
+	s.redirectServer = &http.Server{Handler: handler, ReadHeaderTimeout: redirectReadHeaderTimeout}
+
+	go func() {
+		if err := s.redirectServer.Serve(ln); err != nil && !stderr.Is(err, http.ErrServerClosed) {
+			s.logger.ErrorWith().Err(err).Str("addr", s.redirect.addr).Msg("HTTP redirect listener failed")
+		}
+	}()
+	s.logger.InfoWith().Str("addr", ln.Addr().String()).Msg("HTTP redirect listener started")
+
+	return nil
+}
+
+// stopRedirectListener stops the redirect listener, if running, closing the connections still
+// open once ctx is done.
+func (s *Service) stopRedirectListener(ctx context.Context) {
+	if s.redirectServer == nil {
+		return
+	}
+	if err := s.redirectServer.Shutdown(ctx); err != nil {
+		s.logger.WarnWith().Err(err).Msg("Failed to shut down the HTTP redirect listener")
+		_ = s.redirectServer.Close()
+	}
+}
@@ -0,0 +1,52 @@
+package service
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestLoadRedirectConfig(t *testing.T) {
+	t.Setenv(envHTTPRedirectAddr, "")
+	if config, err := loadRedirectConfig(true, 8443); err != nil || config != nil {
+		t.Fatalf("expected no redirect listener by default, got %+v (err=%v)", config, err)
+	}
+
+	t.Setenv(envHTTPRedirectAddr, ":8080")
+	if _, err := loadRedirectConfig(false, 8443); err == nil {
+		t.Error("expected the redirect listener to require TLS")
+	}
+	config, err := loadRedirectConfig(true, 8443)
+	if err != nil || config.addr != ":8080" || config.httpsPort != 8443 {
+		t.Fatalf("unexpected config %+v (err=%v)", config, err)
+	}
+
+	t.Setenv(envHTTPSPublicPort, "443")
+	if config, err = loadRedirectConfig(true, 8443); err != nil || config.httpsPort != 443 {
+		t.Errorf("expected the public port override, got %+v (err=%v)", config, err)
+	}
+	t.Setenv(envHTTPRedirectAddr, "80")
+	if _, err = loadRedirectConfig(true, 8443); err == nil {
+		t.Error("expected an address without a port to be rejected")
+	}
+}
+
+func TestHTTPSRedirectHandler(t *testing.T) {
+	cases := []struct {
+		port       int
+		host, path string
+		want       string
+	}{
+		{443, "log.example.org", "/qsos/1?x=y", "https://log.example.org/qsos/1?x=y"},
+		{443, "log.example.org:80", "/", "https://log.example.org/"},
+		{8443, "log.example.org:8080", "/health", "https://log.example.org:8443/health"},
+	}
+	for _, c := range cases {
+		req := httptest.NewRequest("GET", "http://"+c.host+c.path, nil)
+		rec := httptest.NewRecorder()
+		(&redirectConfig{httpsPort: c.port}).httpsRedirectHandler().ServeHTTP(rec, req)
+		if rec.Code != http.StatusMovedPermanently || rec.Header().Get("Location") != c.want {
+			t.Errorf("%s%s: expected 301 to %s, got %d %s", c.host, c.path, c.want, rec.Code, rec.Header().Get("Location"))
+		}
+	}
+}
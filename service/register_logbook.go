@@ -1,6 +1,9 @@
 package service
 
 import (
+	"database/sql"
+	"strings"
+
 	"github.com/Station-Manager/apikey"
 	"github.com/Station-Manager/errors"
 	"github.com/gofiber/fiber/v2"
@@ -9,6 +12,9 @@ import (
 // registerLogbookHandler handles registration of a new logbook, including validation, persistence, and API key generation.
 func (s *Service) registerLogbookHandler(c *fiber.Ctx) error {
 	const op errors.Op = "server.Service.registerLogbookAction"
+	if c == nil {
+		return errors.New(op).Msg(errMsgNilContext)
+	}
 
 	// 1. Extract the unified request context from the fiber context.
 	reqCtx, err := getRequestContext(c)
@@ -37,6 +43,11 @@ func (s *Service) registerLogbookHandler(c *fiber.Ctx) error {
 		return c.Status(fiber.StatusBadRequest).JSON(jsonBadRequest)
 	}
 
+	logbook.Callsign = strings.ToUpper(strings.TrimSpace(logbook.Callsign))
+	if !validCallsign(logbook.Callsign) {
+		return c.Status(fiber.StatusBadRequest).JSON(invalidCallsign("callsign"))
+	}
+
 	// Sanity check: the user should always be set.
 	if reqCtx.User == nil {
 		wrapped := errors.New(op).Msg("User is nil in request context")
@@ -53,71 +64,63 @@ func (s *Service) registerLogbookHandler(c *fiber.Ctx) error {
 
 	ctx := c.UserContext()
 
-	// 4. Begin the transaction for atomic logbook + API key creation.
-	tx, txCancel, err := s.db.BeginTxContext(ctx)
-	if err != nil {
-		wrapped := errors.New(op).Err(err)
-		s.logger.ErrorWith().Err(wrapped).Msg("s.db.BeginTxContext")
-		return c.Status(fiber.StatusInternalServerError).JSON(jsonInternalError)
-	}
-	defer txCancel()
-
-	// Assocated the user with this logbook.
+	// Assocated the user with this logbook. The ID is the store's to assign: one in the payload
+	// would name another tenant's logbook.
 	logbook.UserID = reqCtx.User.ID
-
-	// 4a. Insert a logbook inside the transaction.
-	logbook, err = s.db.InsertLogbookWithTxContext(ctx, tx, logbook)
-	if err != nil {
-		wrapped := errors.New(op).Err(err)
-		s.logger.ErrorWith().Err(wrapped).Msg("s.db.InsertLogbookWithTxContext failed")
-		if rbErr := tx.Rollback(); rbErr != nil {
-			s.logger.ErrorWith().Err(rbErr).Msg("Failed to rollback transaction after InsertLogbookWithTxContext error")
+	logbook.ID = 0
+
+	// 4. Create the logbook and its API key atomically. The transaction may be run again after a
+	// conflict, so each attempt starts from the request's logbook.
+	var fullKey string
+	request := logbook
+	err = s.withTx(ctx, func(tx *sql.Tx) error {
+		// 4a. Insert a logbook inside the transaction, within the user's quotas.
+		var txErr error
+		if txErr = s.checkUserQuota(ctx, tx, request.UserID, quotaLogbooks, 1); txErr != nil {
+			return txErr
 		}
-
-		return c.Status(fiber.StatusInternalServerError).JSON(jsonInternalError)
-	}
-
-	// Sanity check: the logbook ID should always be set if the above succeeded.
-	if logbook.ID == 0 {
-		wrapped := errors.New(op).Msg("Logbook ID was not set")
-		s.logger.ErrorWith().Err(wrapped).Msg("Logbook ID was not set")
-		if rbErr := tx.Rollback(); rbErr != nil {
-			s.logger.ErrorWith().Err(rbErr).Msg("Failed to rollback transaction after logbook ID check")
+		if txErr = s.checkUserQuota(ctx, tx, request.UserID, quotaApiKeys, 1); txErr != nil {
+			return txErr
+		}
+		if logbook, txErr = s.db.InsertLogbookWithTxContext(ctx, tx, request); txErr != nil {
+			return errors.New(op).Err(txErr).Msg("s.db.InsertLogbookWithTxContext failed")
 		}
 
-		return c.Status(fiber.StatusInternalServerError).JSON(jsonInternalError)
-	}
-
-	// 4b. Generate an API key for the logbook.
-	fullKey, prefix, hash, err := apikey.GenerateApiKey(prefixLen)
-	if err != nil {
-		wrapped := errors.New(op).Err(err)
-		s.logger.ErrorWith().Err(wrapped).Msg("apikey.GenerateApiKey failed")
-		if rbErr := tx.Rollback(); rbErr != nil {
-			s.logger.ErrorWith().Err(rbErr).Msg("Failed to rollback transaction after GenerateApiKey error")
+		// Sanity check: the logbook ID should always be set if the above succeeded.
+		if logbook.ID == 0 {
+			return errors.New(op).Msg("Logbook ID was not set")
 		}
 
-		return c.Status(fiber.StatusInternalServerError).JSON(jsonInternalError)
-	}
+		// The SQLite logbook model has no owner column, so the owner is recorded separately.
+		if !s.isPostgres() {
+			if _, txErr = tx.ExecContext(ctx, `UPDATE logbook SET user_id = $1 WHERE id = $2`, logbook.UserID, logbook.ID); txErr != nil {
+				return errors.New(op).Err(txErr).Msg("Failed to set the logbook owner")
+			}
+		}
 
-	// 4c. Insert API key within same transaction.
-	if err = s.db.InsertAPIKeyWithTxContext(ctx, tx, logbook.Callsign, prefix, hash, logbook.ID); err != nil {
-		wrapped := errors.New(op).Err(err)
-		s.logger.ErrorWith().Err(wrapped).Msg("s.db.InsertAPIKeyWithTxContext")
-		if rbErr := tx.Rollback(); rbErr != nil {
-			s.logger.ErrorWith().Err(rbErr).Msg("Failed to rollback transaction after InsertAPIKeyWithTxContext error")
+		// 4b. Generate an API key for the logbook.
+		var prefix, hash string
+		if fullKey, prefix, hash, txErr = apikey.GenerateApiKey(prefixLen); txErr != nil {
+			return errors.New(op).Err(txErr).Msg("apikey.GenerateApiKey failed")
 		}
 
-		return c.Status(fiber.StatusInternalServerError).JSON(jsonInternalError)
+		// 4c. Insert API key within same transaction.
+		if txErr = s.db.InsertAPIKeyWithTxContext(ctx, tx, logbook.Callsign, prefix, hash, logbook.ID); txErr != nil {
+			return errors.New(op).Err(txErr).Msg("s.db.InsertAPIKeyWithTxContext")
+		}
+		return nil
+	})
+	if body, exceeded := quotaExceededBody(err); exceeded {
+		return c.Status(fiber.StatusForbidden).JSON(body)
 	}
-
-	// 5. Commit transaction. No need to rollback if the commit fails.
-	if err = tx.Commit(); err != nil {
-		wrapped := errors.New(op).Err(err)
-		s.logger.ErrorWith().Err(wrapped).Msg("tx.Commit")
+	if err != nil {
+		s.logger.ErrorWith().Err(err).Msg("Failed to register logbook")
 		return c.Status(fiber.StatusInternalServerError).JSON(jsonInternalError)
 	}
 
+	s.audit(c, auditEntry{Event: auditApiKeyCreated, Actor: reqCtx.Actor, Callsign: reqCtx.User.Callsign, LogbookID: auditLogbookID(logbook.ID)},
+		fiber.Map{"prefix": fullKey[:prefixLen]})
+
 	// Return the full API key associated with the logbook back to the caller.
 	return c.Status(fiber.StatusCreated).JSON(fiber.Map{"message": fullKey})
 }
@@ -10,12 +10,13 @@ import (
 	"github.com/Station-Manager/logging"
 	"github.com/Station-Manager/types"
 	"github.com/go-playground/validator/v10"
+	"github.com/goccy/go-json"
 	"github.com/gofiber/fiber/v2"
 	"strings"
 )
 
 // newTestDatabaseService creates a sqlite-backed database service suitable for tests.
-func newTestDatabaseService(t *testing.T) *database.Service {
+func newTestDatabaseService(t testing.TB) *database.Service {
 	cfg := &types.DatastoreConfig{
 		Driver:                    database.SqliteDriver,
 		Path:                      t.TempDir() + "/test.db",
@@ -38,6 +39,8 @@ func newTestDatabaseService(t *testing.T) *database.Service {
 	if err := cfgSvc.Initialize(); err != nil {
 		t.Fatalf("config initialize failed: %v", err)
 	}
+	// Initialize loads (or generates) config.json from disk; re-apply the test datastore config.
+	cfgSvc.AppConfig.DatastoreConfig = *cfg
 	logSvc := &logging.Service{ConfigService: cfgSvc, WorkingDir: t.TempDir()}
 	if err := logSvc.Initialize(); err != nil {
 		t.Fatalf("logger initialize failed: %v", err)
@@ -56,8 +59,9 @@ func newTestDatabaseService(t *testing.T) *database.Service {
 }
 
 // TestRegisterLogbookRollbackOnApiKeyFailure ensures rollback semantics.
-// NOTE: For SQLite migrations currently do not create api_keys table, so API key insertion
-// will fail, triggering rollback of the inserted logbook.
+// NOTE: The test database has only the database module's SQLite schema, without the server
+// migrations that add logbook owners and api_keys, so the registration fails after the logbook
+// insert, triggering its rollback.
 func TestRegisterLogbookRollbackOnApiKeyFailure(t *testing.T) {
 	dbSvc := newTestDatabaseService(t)
 	defer func() { _ = dbSvc.Close() }()
@@ -75,16 +79,15 @@ func TestRegisterLogbookRollbackOnApiKeyFailure(t *testing.T) {
 
 	// Create request context with logbook payload.
 	rc := &requestContext{
-		Request: types.PostRequest{
-			Action:   types.RegisterLogbookAction,
+		Request: requestEnvelope{PostRequest: types.PostRequest{
 			Key:      "test-key",
-			Callsign: "TEST1",
+			Callsign: "K1TST",
 			Logbook: &types.Logbook{
 				Name:        logbookName,
-				Callsign:    "TEST1",
+				Callsign:    "K1TST",
 				Description: "rollback scenario",
 			},
-		},
+		}},
 		User:    &types.User{ID: 1},
 		IsValid: true,
 	}
@@ -92,7 +95,7 @@ func TestRegisterLogbookRollbackOnApiKeyFailure(t *testing.T) {
 	// Route that primes locals and invokes the action directly.
 	svc.app.Post("/register", func(c *fiber.Ctx) error {
 		c.Locals(localsRequestDataKey, rc)
-		return svc.registerLogbookAction(c)
+		return svc.registerLogbookHandler(c)
 	})
 
 	req := httptest.NewRequest("POST", "/register", nil)
@@ -108,7 +111,7 @@ func TestRegisterLogbookRollbackOnApiKeyFailure(t *testing.T) {
 
 	// Now attempt to insert the same logbook name outside of the transaction directly via the DB API.
 	// If the previous partial insert had committed, this should violate UNIQUE(name) and fail.
-	second, secondErr := dbSvc.InsertLogbookContext(context.Background(), types.Logbook{Name: logbookName, Callsign: "TEST1", Description: "after rollback"})
+	second, secondErr := dbSvc.InsertLogbookContext(context.Background(), types.Logbook{Name: logbookName, Callsign: "K1TST", Description: "after rollback"})
 	if secondErr != nil {
 		// Failure indicates the original insert remained (rollback failed).
 		t.Fatalf("expected rollback to remove original row; second insert failed: %v", secondErr)
@@ -118,10 +121,71 @@ func TestRegisterLogbookRollbackOnApiKeyFailure(t *testing.T) {
 	}
 }
 
+// TestRegisterLogbook_Sqlite registers a logbook against the full SQLite schema and uses the
+// issued API key.
+func TestRegisterLogbook_Sqlite(t *testing.T) {
+	svc := newTestServer(t)
+	ctx := context.Background()
+
+	user, err := svc.db.InsertUserContext(ctx, types.User{Callsign: "K1TST"})
+	if err != nil {
+		t.Fatalf("InsertUserContext failed: %v", err)
+	}
+	rc := &requestContext{
+		Request: requestEnvelope{PostRequest: types.PostRequest{Logbook: &types.Logbook{Name: "sqlite_logbook_test", Callsign: "K1TST"}}},
+		User:    &user,
+		IsValid: true,
+	}
+	app := fiber.New()
+	app.Post("/register", func(c *fiber.Ctx) error {
+		c.Locals(localsRequestDataKey, rc)
+		return svc.registerLogbookHandler(c)
+	})
+
+	resp, err := app.Test(httptest.NewRequest("POST", "/register", nil))
+	if err != nil {
+		t.Fatalf("fiber test request failed: %v", err)
+	}
+	if resp.StatusCode != fiber.StatusCreated {
+		t.Fatalf("expected status %d got %d", fiber.StatusCreated, resp.StatusCode)
+	}
+	var body struct {
+		Message string `json:"message"`
+	}
+	if err = json.NewDecoder(resp.Body).Decode(&body); err != nil || body.Message == "" {
+		t.Fatalf("expected the API key in the response (err=%v)", err)
+	}
+
+	rows, err := svc.db.QueryContext(ctx, `SELECT id, user_id FROM logbook WHERE name = $1`, "sqlite_logbook_test")
+	if err != nil {
+		t.Fatalf("QueryContext failed: %v", err)
+	}
+	var logbookID, owner int64
+	if !rows.Next() || rows.Scan(&logbookID, &owner) != nil || owner != user.ID {
+		t.Errorf("expected the logbook to be owned by user %d, got %d", user.ID, owner)
+	}
+	_ = rows.Close()
+
+	// The key authenticates: an unknown QSO is not found rather than unauthorized.
+	req := httptest.NewRequest("GET", "/qsos/999999", nil)
+	req.Header.Set(fiber.HeaderAuthorization, "Bearer "+body.Message)
+	if resp, err = svc.app.Test(req); err != nil {
+		t.Fatalf("fiber test request failed: %v", err)
+	}
+	if resp.StatusCode != fiber.StatusNotFound {
+		t.Errorf("expected the issued API key to authenticate, got %d", resp.StatusCode)
+	}
+
+	// As on PostgreSQL, only one key may be active per logbook.
+	if err = svc.db.InsertAPIKeyContext(ctx, "second", "abcdef012345", "hash", logbookID); err == nil {
+		t.Error("expected a second active API key for the logbook to be rejected")
+	}
+}
+
 // Sanity test: ensure handler returns error when context is nil.
 func TestRegisterLogbookNilContext(t *testing.T) {
 	svc := &Service{}
-	err := svc.registerLogbookAction(nil)
+	err := svc.registerLogbookHandler(nil)
 	if err == nil || !strings.Contains(err.Error(), errMsgNilContext) {
 		t.Fatalf("expected error containing %q; got %v", errMsgNilContext, err)
 	}
@@ -40,6 +40,8 @@ func newTestServerForRegisterLogbook(t *testing.T) *Service {
 	if err := cfgSvc.Initialize(); err != nil {
 		t.Fatalf("config initialize failed: %v", err)
 	}
+	// Initialize loads (or generates) config.json from disk; re-apply the test datastore config.
+	cfgSvc.AppConfig.DatastoreConfig = *cfg
 	logSvc := &logging.Service{ConfigService: cfgSvc, WorkingDir: t.TempDir()}
 	if err := logSvc.Initialize(); err != nil {
 		t.Fatalf("logger initialize failed: %v", err)
@@ -62,18 +64,15 @@ func newTestServerForRegisterLogbook(t *testing.T) *Service {
 		validate: validator.New(),
 	}
 
-	svc.app.Use(svc.basicChecks())
-	apiRoutes := svc.app.Group("/api/v1")
-	apiRoutes.Post("/", svc.postDispatcherHandler())
+	svc.initializeRoutes()
 
 	return svc
 }
 
 // TestRegisterLogbook_TypedPayload verifies that the new typed PostRequest format
 // for register_logbook is accepted at the middleware layer and reaches the handler.
-// Because SQLite migrations do not currently include the api_keys table, we expect
-// an internal server error due to API key insertion failure, which implicitly
-// confirms the transaction path was executed.
+// The test database lacks the server migrations, so the registration itself fails with an
+// internal server error, which implicitly confirms the transaction path was executed.
 func TestRegisterLogbook_TypedPayload(t *testing.T) {
 	svc := newTestServerForRegisterLogbook(t)
 	defer func() { _ = svc.db.Close() }()
@@ -89,7 +88,7 @@ func TestRegisterLogbook_TypedPayload(t *testing.T) {
   }
 }`
 
-	req := httptest.NewRequest("POST", "/api/v1", strings.NewReader(body))
+	req := httptest.NewRequest("POST", "/api/logbook/register", strings.NewReader(body))
 	req.Header.Set("Content-Type", "application/json")
 
 	resp, err := svc.app.Test(req)
@@ -0,0 +1,22 @@
+//go:build !linux && !darwin
+
+package service
+
+import (
+	"context"
+	"os"
+
+	"github.com/Station-Manager/errors"
+)
+
+// RestartSignals returns the signals that request a zero-downtime restart; there are none on this
+// platform.
+func RestartSignals() []os.Signal {
+	return nil
+}
+
+// Restart is not supported on this platform.
+func (s *Service) Restart(ctx context.Context) error {
+	const op errors.Op = "server.Service.Restart"
+	return errors.New(op).Msg("Zero-downtime restart is not supported on this platform")
+}
@@ -0,0 +1,150 @@
+//go:build linux || darwin
+
+package service
+
+import (
+	"context"
+	"io"
+	"net"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/Station-Manager/errors"
+)
+
+// restartReadyTimeout bounds how long the new process may take to start serving, migrations
+// included, before the restart is abandoned.
+const restartReadyTimeout = 2 * time.Minute
+
+// RestartSignals returns the signals that request a zero-downtime restart.
+func RestartSignals() []os.Signal {
+	return []os.Signal{syscall.SIGUSR2}
+}
+
+// Restart starts a new process from the current executable and hands it the listening sockets.
+// It returns once the new process is serving; the caller then calls Shutdown, which drains this
+// process's connections while the new one accepts. If the new process fails to start, it is
+// stopped and this process carries on serving.
+func (s *Service) Restart(ctx context.Context) error {
+	const op errors.Op = "server.Service.Restart"
+	if s == nil {
+		return errors.New(op).Msg(errMsgNilService)
+	}
+	if s.httpTuning.prefork {
+		return errors.New(op).Msg("Zero-downtime restart is not supported in prefork mode")
+	}
+
+	executable, err := os.Executable()
+	if err != nil {
+		return errors.New(op).Err(err)
+	}
+
+	files, names, err := s.listenerFiles()
+	if err != nil {
+		return errors.New(op).Err(err)
+	}
+	defer func() {
+		for _, f := range files {
+			_ = f.Close()
+		}
+	}()
+
+	ready, readyW, err := os.Pipe()
+	if err != nil {
+		return errors.New(op).Err(err)
+	}
+	defer func() { _ = ready.Close() }()
+
+	cmd := exec.Command(executable, os.Args[1:]...)
+	cmd.Stdout, cmd.Stderr = os.Stdout, os.Stderr
+	cmd.ExtraFiles = append(files, readyW)
+	cmd.Env = append(restartEnviron(),
+		envListenFDs+"="+strings.Join(names, ","),
+		envReadyFD+"="+strconv.Itoa(firstInheritedFD+len(files)),
+	)
+	err = cmd.Start()
+	_ = readyW.Close()
+	if err != nil {
+		return errors.New(op).Err(err)
+	}
+	s.logger.InfoWith().Int("pid", cmd.Process.Pid).Msg("Started new process; waiting for it to serve")
+
+	readyErr := make(chan error, 1)
+	go func() {
+		_, err := io.ReadFull(ready, make([]byte, 1))
+		readyErr <- err
+	}()
+
+	ctx, cancel := context.WithTimeout(ctx, restartReadyTimeout)
+	defer cancel()
+
+	select {
+	case err = <-readyErr:
+		if err == nil {
+			s.logger.InfoWith().Int("pid", cmd.Process.Pid).Msg("New process is serving; draining")
+			s.handedOver.Store(true)
+			go func() { _ = cmd.Wait() }()
+			return nil
+		}
+		// The pipe closed without a byte: the new process exited before serving.
+		err = errors.New(op).Msg("New process exited before it was ready")
+	case <-ctx.Done():
+		err = errors.New(op).Err(ctx.Err()).Msg("New process did not become ready")
+	}
+	_ = cmd.Process.Kill()
+	_ = cmd.Wait()
+	s.logger.ErrorWith().Err(err).Msg("Restart abandoned; this process keeps serving")
+	return err
+}
+
+// listenerFiles duplicates the listening sockets for the new process, in a stable order.
+func (s *Service) listenerFiles() ([]*os.File, []string, error) {
+	const op errors.Op = "server.Service.listenerFiles"
+
+	s.listenersMu.Lock()
+	defer s.listenersMu.Unlock()
+
+	var files []*os.File
+	var names []string
+	for _, name := range []string{listenerMain, listenerRedirect, listenerGrpc} {
+		ln, ok := s.listeners[name]
+		if !ok {
+			continue
+		}
+		tcp, ok := ln.(*net.TCPListener)
+		if !ok {
+			return nil, nil, errors.New(op).Msg("Listener " + name + " is not a TCP listener")
+		}
+		f, err := tcp.File()
+		if err != nil {
+			for _, opened := range files {
+				_ = opened.Close()
+			}
+			return nil, nil, errors.New(op).Err(err)
+		}
+		files = append(files, f)
+		names = append(names, name)
+	}
+	if len(files) == 0 {
+		return nil, nil, errors.New(op).Msg("The server is not listening")
+	}
+	return files, names, nil
+}
+
+// restartEnviron returns the environment without any handover variables of our own, nor the
+// watchdog's process, which the new process takes over.
+func restartEnviron() []string {
+	env := make([]string, 0, len(os.Environ()))
+	for _, kv := range os.Environ() {
+		if strings.HasPrefix(kv, envListenFDs+"=") || strings.HasPrefix(kv, envReadyFD+"=") ||
+			strings.HasPrefix(kv, envWatchdogPID+"=") {
+			continue
+		}
+		env = append(env, kv)
+	}
+	return env
+}
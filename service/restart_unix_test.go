@@ -0,0 +1,100 @@
+//go:build linux || darwin
+
+package service
+
+import (
+	"io"
+	"net"
+	"os"
+	"os/exec"
+	"strconv"
+	"testing"
+	"time"
+)
+
+const envRestartHelper = "SM_TEST_RESTART_HELPER"
+
+// TestRestartHelper stands in for the new process: it claims the inherited listener, reports that
+// it is ready and answers one connection.
+func TestRestartHelper(t *testing.T) {
+	if os.Getenv(envRestartHelper) != "1" {
+		t.Skip("only run as a child of TestListenerHandover")
+	}
+	inherited, err := inheritedListeners()
+	if err != nil {
+		t.Fatalf("inheritedListeners failed: %v", err)
+	}
+	svc := &Service{inherited: inherited}
+	ln, err := svc.listen(listenerMain, "127.0.0.1:1")
+	if err != nil {
+		t.Fatalf("listen failed: %v", err)
+	}
+	svc.notifyReady()
+
+	conn, err := ln.Accept()
+	if err != nil {
+		t.Fatalf("Accept failed: %v", err)
+	}
+	_, _ = conn.Write([]byte("child"))
+	_ = conn.Close()
+}
+
+func TestListenerHandover(t *testing.T) {
+	svc := &Service{}
+	ln, err := svc.listen(listenerMain, "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen failed: %v", err)
+	}
+	defer func() { _ = ln.Close() }()
+
+	files, names, err := svc.listenerFiles()
+	if err != nil || len(files) != 1 || names[0] != listenerMain {
+		t.Fatalf("listenerFiles failed: %v (names=%v)", err, names)
+	}
+	ready, readyW, err := os.Pipe()
+	if err != nil {
+		t.Fatalf("Pipe failed: %v", err)
+	}
+
+	cmd := exec.Command(os.Args[0], "-test.run=^TestRestartHelper$")
+	cmd.ExtraFiles = append(files, readyW)
+	cmd.Env = append(restartEnviron(), envRestartHelper+"=1",
+		envListenFDs+"="+listenerMain, envReadyFD+"="+strconv.Itoa(firstInheritedFD+len(files)))
+	if err = cmd.Start(); err != nil {
+		t.Fatalf("Start failed: %v", err)
+	}
+	_ = readyW.Close()
+	_ = files[0].Close()
+	defer func() { _ = cmd.Wait() }()
+
+	_ = ready.SetReadDeadline(time.Now().Add(30 * time.Second))
+	if _, err = io.ReadFull(ready, make([]byte, 1)); err != nil {
+		_ = cmd.Process.Kill()
+		t.Fatalf("child did not report ready: %v", err)
+	}
+
+	// Stop accepting here so that the connection can only be served by the child.
+	_ = ln.Close()
+	conn, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+	defer func() { _ = conn.Close() }()
+	_ = conn.SetReadDeadline(time.Now().Add(10 * time.Second))
+	reply, _ := io.ReadAll(conn)
+	if string(reply) != "child" {
+		t.Errorf("expected the child to serve the connection, got %q", reply)
+	}
+}
+
+func TestServing_SetOnceReady(t *testing.T) {
+	t.Setenv(envReadyFD, emptyString)
+	svc := &Service{}
+	if svc.Serving() {
+		t.Fatal("expected a server that has not reported ready not to be serving")
+	}
+	svc.notifyReady()
+	if !svc.Serving() {
+		t.Error("expected the server to be serving once it has reported ready")
+	}
+}
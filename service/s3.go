@@ -0,0 +1,314 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/Station-Manager/errors"
+)
+
+const (
+	defaultS3Region         = "us-east-1"
+	defaultS3RequestTimeout = 30 * time.Minute
+	s3MaxErrorBytes         = 4 << 10
+)
+
+// s3Object is an object listed in a bucket.
+type s3Object struct {
+	Key          string    `xml:"Key"`
+	Size         int64     `xml:"Size"`
+	LastModified time.Time `xml:"LastModified"`
+}
+
+// s3Client stores objects under a prefix of an S3-compatible bucket, addressed path-style so that
+// it works with MinIO and other self-hosted stores. Requests are signed with AWS Signature V4.
+type s3Client struct {
+	endpoint  *url.URL // scheme and host
+	bucket    string
+	prefix    string // ends with "/" unless empty
+	region    string
+	accessKey string
+	secretKey string
+	client    *http.Client
+	now       func() time.Time
+}
+
+// newS3Client parses a location of the form https://host/bucket[/prefix].
+func newS3Client(location, region, accessKey, secretKey string) (*s3Client, error) {
+	const op errors.Op = "server.newS3Client"
+
+	u, err := url.Parse(location)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == emptyString {
+		return nil, errors.New(op).Msg("The S3 location must be an http or https URL")
+	}
+	bucket, prefix, _ := strings.Cut(strings.Trim(u.Path, "/"), "/")
+	if bucket == emptyString {
+		return nil, errors.New(op).Msg("The S3 location must name a bucket")
+	}
+	if prefix != emptyString {
+		prefix += "/"
+	}
+	if region == emptyString {
+		region = defaultS3Region
+	}
+	return &s3Client{
+		endpoint:  &url.URL{Scheme: u.Scheme, Host: u.Host},
+		bucket:    bucket,
+		prefix:    prefix,
+		region:    region,
+		accessKey: accessKey,
+		secretKey: secretKey,
+		client:    &http.Client{Timeout: defaultS3RequestTimeout},
+		now:       time.Now,
+	}, nil
+}
+
+// String returns the location without credentials, for logs and status reports.
+func (c *s3Client) String() string {
+	return c.endpoint.String() + "/" + c.bucket + "/" + c.prefix
+}
+
+// putFile uploads a local file as the object name under the prefix.
+func (c *s3Client) putFile(ctx context.Context, name, path string) error {
+	const op errors.Op = "server.s3Client.putFile"
+
+	f, err := os.Open(path)
+	if err != nil {
+		return errors.New(op).Err(err)
+	}
+	defer func() { _ = f.Close() }()
+
+	// The payload hash is part of the signature, so the file is read twice.
+	h := sha256.New()
+	size, err := io.Copy(h, f)
+	if err != nil {
+		return errors.New(op).Err(err)
+	}
+	if _, err = f.Seek(0, io.SeekStart); err != nil {
+		return errors.New(op).Err(err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, c.objectURL(name), f)
+	if err != nil {
+		return errors.New(op).Err(err)
+	}
+	req.ContentLength = size
+	req.Header.Set("Content-Type", "application/octet-stream")
+	if err = c.do(req, hex.EncodeToString(h.Sum(nil)), nil); err != nil {
+		return errors.New(op).Err(err)
+	}
+	return nil
+}
+
+// put uploads data as the object name under the prefix.
+func (c *s3Client) put(ctx context.Context, name string, data []byte, contentType string) error {
+	const op errors.Op = "server.s3Client.put"
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, c.objectURL(name), bytes.NewReader(data))
+	if err != nil {
+		return errors.New(op).Err(err)
+	}
+	req.Header.Set("Content-Type", contentType)
+	sum := sha256.Sum256(data)
+	if err = c.do(req, hex.EncodeToString(sum[:]), nil); err != nil {
+		return errors.New(op).Err(err)
+	}
+	return nil
+}
+
+// get downloads the object name under the prefix.
+func (c *s3Client) get(ctx context.Context, name string) ([]byte, error) {
+	const op errors.Op = "server.s3Client.get"
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.objectURL(name), nil)
+	if err != nil {
+		return nil, errors.New(op).Err(err)
+	}
+	resp, err := c.send(req, emptyPayloadHash)
+	if err != nil {
+		return nil, errors.New(op).Err(err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, errors.New(op).Err(err)
+	}
+	return data, nil
+}
+
+// list returns the objects under the prefix whose names start with namePrefix. Keys are returned
+// without the client's prefix.
+func (c *s3Client) list(ctx context.Context, namePrefix string) ([]s3Object, error) {
+	const op errors.Op = "server.s3Client.list"
+
+	var objects []s3Object
+	token := emptyString
+	for {
+		query := url.Values{"list-type": {"2"}, "prefix": {c.prefix + namePrefix}}
+		if token != emptyString {
+			query.Set("continuation-token", token)
+		}
+		u := c.bucketURL()
+		u.RawQuery = canonicalQuery(query)
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+		if err != nil {
+			return nil, errors.New(op).Err(err)
+		}
+
+		var result struct {
+			Contents              []s3Object `xml:"Contents"`
+			IsTruncated           bool       `xml:"IsTruncated"`
+			NextContinuationToken string     `xml:"NextContinuationToken"`
+		}
+		if err = c.do(req, emptyPayloadHash, &result); err != nil {
+			return nil, errors.New(op).Err(err)
+		}
+		for _, o := range result.Contents {
+			o.Key = strings.TrimPrefix(o.Key, c.prefix)
+			objects = append(objects, o)
+		}
+		if !result.IsTruncated || result.NextContinuationToken == emptyString {
+			return objects, nil
+		}
+		token = result.NextContinuationToken
+	}
+}
+
+// remove deletes the object name under the prefix.
+func (c *s3Client) remove(ctx context.Context, name string) error {
+	const op errors.Op = "server.s3Client.remove"
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodDelete, c.objectURL(name), nil)
+	if err != nil {
+		return errors.New(op).Err(err)
+	}
+	if err = c.do(req, emptyPayloadHash, nil); err != nil {
+		return errors.New(op).Err(err)
+	}
+	return nil
+}
+
+func (c *s3Client) bucketURL() *url.URL {
+	u := *c.endpoint
+	u.Path = "/" + c.bucket
+	return &u
+}
+
+func (c *s3Client) objectURL(name string) string {
+	u := c.bucketURL()
+	u.Path += "/" + c.prefix + name
+	return u.String()
+}
+
+// emptyPayloadHash is the SHA-256 of an empty body.
+const emptyPayloadHash = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+
+// do signs and sends the request, decoding an XML response into out when it is not nil.
+func (c *s3Client) do(req *http.Request, payloadHash string, out any) error {
+	resp, err := c.send(req, payloadHash)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	if out == nil {
+		return nil
+	}
+	return xml.NewDecoder(resp.Body).Decode(out)
+}
+
+// send signs and sends the request, and returns the response if it succeeded. The caller closes
+// its body.
+func (c *s3Client) send(req *http.Request, payloadHash string) (*http.Response, error) {
+	c.sign(req, payloadHash)
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		defer func() { _ = resp.Body.Close() }()
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, s3MaxErrorBytes))
+		var s3Err struct {
+			Code    string `xml:"Code"`
+			Message string `xml:"Message"`
+		}
+		if xml.Unmarshal(body, &s3Err) == nil && s3Err.Code != emptyString {
+			return nil, errors.New("server.s3Client.send").Msgf("%s: %s (%s)", resp.Status, s3Err.Code, s3Err.Message)
+		}
+		return nil, errors.New("server.s3Client.send").Msg(resp.Status)
+	}
+	return resp, nil
+}
+
+// sign adds an AWS Signature V4 Authorization header to the request.
+func (c *s3Client) sign(req *http.Request, payloadHash string) {
+	now := c.now().UTC()
+	amzDate := now.Format("20060102T150405Z")
+	date := now.Format("20060102")
+
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+
+	const signedHeaders = "host;x-amz-content-sha256;x-amz-date"
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		req.URL.RawQuery,
+		"host:" + req.URL.Host + "\n" + "x-amz-content-sha256:" + payloadHash + "\n" + "x-amz-date:" + amzDate + "\n",
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+
+	scope := date + "/" + c.region + "/s3/aws4_request"
+	digest := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(digest[:])
+
+	key := hmacSHA256([]byte("AWS4"+c.secretKey), date)
+	key = hmacSHA256(key, c.region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", "AWS4-HMAC-SHA256 Credential="+c.accessKey+"/"+scope+
+		", SignedHeaders="+signedHeaders+", Signature="+signature)
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+// canonicalQuery encodes the query with sorted keys and %20 for spaces, as Signature V4 requires.
+func canonicalQuery(query url.Values) string {
+	keys := make([]string, 0, len(query))
+	for k := range query {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	parts := make([]string, 0, len(keys))
+	for _, k := range keys {
+		for _, v := range query[k] {
+			parts = append(parts, s3Escape(k)+"="+s3Escape(v))
+		}
+	}
+	return strings.Join(parts, "&")
+}
+
+func s3Escape(s string) string {
+	return strings.ReplaceAll(url.QueryEscape(s), "+", "%20")
+}
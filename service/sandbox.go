@@ -0,0 +1,222 @@
+package service
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/Station-Manager/apikey"
+	"github.com/Station-Manager/errors"
+	"github.com/Station-Manager/types"
+	"github.com/gofiber/fiber/v2"
+)
+
+// A logbook's sandbox keys let client developers try the API against it without touching its
+// QSOs: a sandbox key authenticates to the logbook's sandbox, a logbook of its own that nobody owns
+// and that is not listed with the account's logbooks. Reads and writes made with the key act on
+// the sandbox, which can be emptied by deleting it. Sandbox keys cannot reach the integrations that
+// would publish the sandbox's QSOs, and additions to the sandbox are bound by the quotas of the
+// parent's owner.
+
+// sandboxOf returns the ID of the logbook's sandbox, and false if it has none.
+func sandboxOf(ctx context.Context, q queryer, parentID int64) (int64, bool, error) {
+	const op errors.Op = "server.sandboxOf"
+
+	rows, err := q.QueryContext(ctx, `SELECT logbook_id FROM sandbox_logbooks WHERE parent_id = $1`, parentID)
+	if err != nil {
+		return 0, false, errors.New(op).Err(err)
+	}
+	defer func() { _ = rows.Close() }()
+
+	var id int64
+	found := rows.Next()
+	if found {
+		if err = rows.Scan(&id); err != nil {
+			return 0, false, errors.New(op).Err(err)
+		}
+	}
+	if err = rows.Err(); err != nil {
+		return 0, false, errors.New(op).Err(err)
+	}
+	return id, found, nil
+}
+
+// issueSandboxKey creates the logbook's sandbox unless it has one, and a key for it, replacing the
+// sandbox's previous key. It returns the key and the sandbox's ID.
+func (s *Service) issueSandboxKey(ctx context.Context, parent types.Logbook) (string, int64, error) {
+	const op errors.Op = "server.Service.issueSandboxKey"
+
+	var (
+		fullKey   string
+		sandboxID int64
+		replaced  []string
+	)
+	err := s.withTx(ctx, func(tx *sql.Tx) error {
+		fullKey, replaced = emptyString, nil
+
+		id, found, txErr := sandboxOf(ctx, tx, parent.ID)
+		if txErr != nil {
+			return txErr
+		}
+		if !found {
+			if txErr = tx.QueryRowContext(ctx, `INSERT INTO logbook (name, callsign, description) VALUES ($1, $2, '') RETURNING id`,
+				parent.Name+" (sandbox)", parent.Callsign).Scan(&id); txErr != nil {
+				return txErr
+			}
+			if _, txErr = tx.ExecContext(ctx, `INSERT INTO sandbox_logbooks (logbook_id, parent_id) VALUES ($1, $2)`, id, parent.ID); txErr != nil {
+				return txErr
+			}
+		}
+		sandboxID = id
+
+		if txErr = queryEach(ctx, tx, func(rows *sql.Rows) error {
+			var prefix string
+			err := rows.Scan(&prefix)
+			replaced = append(replaced, prefix)
+			return err
+		}, `SELECT key_prefix FROM api_keys WHERE logbook_id = $1`, sandboxID); txErr != nil {
+			return txErr
+		}
+		if _, txErr = tx.ExecContext(ctx, `DELETE FROM api_keys WHERE logbook_id = $1`, sandboxID); txErr != nil {
+			return txErr
+		}
+
+		var prefix, hash string
+		if fullKey, prefix, hash, txErr = apikey.GenerateApiKey(prefixLen); txErr != nil {
+			return txErr
+		}
+		return s.db.InsertAPIKeyWithTxContext(ctx, tx, parent.Callsign, prefix, hash, sandboxID)
+	})
+	if err != nil {
+		return emptyString, 0, errors.New(op).Err(err)
+	}
+
+	for _, prefix := range replaced {
+		s.invalidateApiKey(ctx, prefix)
+	}
+	return fullKey, sandboxID, nil
+}
+
+// purgeSandboxes permanently deletes the sandbox logbooks selected by the query, with their QSOs
+// and keys, and returns the prefixes of the keys.
+func purgeSandboxes(ctx context.Context, tx *sql.Tx, selection string, args ...any) ([]string, error) {
+	const op errors.Op = "server.purgeSandboxes"
+
+	var prefixes []string
+	if err := queryEach(ctx, tx, func(rows *sql.Rows) error {
+		var prefix string
+		err := rows.Scan(&prefix)
+		prefixes = append(prefixes, prefix)
+		return err
+	}, `SELECT key_prefix FROM api_keys WHERE logbook_id IN (`+selection+`)`, args...); err != nil {
+		return nil, errors.New(op).Err(err)
+	}
+
+	// SQLite's qso table restricts deleting a logbook that still has QSOs, so delete bottom-up.
+	for _, query := range []string{
+		`DELETE FROM qso WHERE logbook_id IN (` + selection + `)`,
+		`DELETE FROM api_keys WHERE logbook_id IN (` + selection + `)`,
+		`DELETE FROM logbook WHERE id IN (` + selection + `)`,
+	} {
+		if _, err := tx.ExecContext(ctx, query, args...); err != nil {
+			return nil, errors.New(op).Err(err)
+		}
+	}
+	return prefixes, nil
+}
+
+// purgeOrphanSandboxes deletes the sandboxes of logbooks that no longer exist. Each transaction
+// deleting logbooks calls it before committing.
+func purgeOrphanSandboxes(ctx context.Context, tx *sql.Tx) ([]string, error) {
+	return purgeSandboxes(ctx, tx, `SELECT sb.logbook_id FROM sandbox_logbooks sb
+		WHERE NOT EXISTS (SELECT 1 FROM logbook p WHERE p.id = sb.parent_id)`)
+}
+
+// deleteSandbox deletes the logbook's sandbox and its keys. It reports false if there is none.
+func (s *Service) deleteSandbox(ctx context.Context, parentID int64) (bool, error) {
+	const op errors.Op = "server.Service.deleteSandbox"
+
+	var (
+		sandboxID int64
+		found     bool
+		prefixes  []string
+	)
+	err := s.withTx(ctx, func(tx *sql.Tx) error {
+		var txErr error
+		if sandboxID, found, txErr = sandboxOf(ctx, tx, parentID); txErr != nil || !found {
+			return txErr
+		}
+		prefixes, txErr = purgeSandboxes(ctx, tx, `SELECT logbook_id FROM sandbox_logbooks WHERE parent_id = $1`, parentID)
+		return txErr
+	})
+	if err != nil {
+		return false, errors.New(op).Err(err)
+	}
+
+	for _, prefix := range prefixes {
+		s.invalidateApiKey(ctx, prefix)
+	}
+	if found {
+		s.invalidateLogbook(ctx, sandboxID)
+	}
+	return found, nil
+}
+
+// refuseSandboxMiddleware rejects requests made with a sandbox key.
+func (s *Service) refuseSandboxMiddleware() fiber.Handler {
+	const op errors.Op = "server.Service.refuseSandboxMiddleware"
+
+	return func(c *fiber.Ctx) error {
+		reqCtx, err := getRequestContext(c)
+		if err != nil {
+			s.logger.ErrorWith().Err(errors.New(op).Err(err)).Msg("getRequestContext failed")
+			return c.Status(fiber.StatusInternalServerError).JSON(jsonInternalError)
+		}
+		if reqCtx.Sandbox {
+			return c.Status(fiber.StatusForbidden).JSON(jsonError(errCodeSandboxKey, "Sandbox keys cannot use this route"))
+		}
+		return c.Next()
+	}
+}
+
+// issueSandboxKeyHandler issues a sandbox key for the authenticated logbook, creating its sandbox
+// if needed. The sandbox's previous key stops working.
+func (s *Service) issueSandboxKeyHandler(c *fiber.Ctx) error {
+	const op errors.Op = "server.Service.issueSandboxKeyHandler"
+
+	logbook, err := authenticatedLogbook(c)
+	if err != nil {
+		s.logger.ErrorWith().Err(errors.New(op).Err(err)).Msg("authenticatedLogbook failed")
+		return c.Status(fiber.StatusInternalServerError).JSON(jsonInternalError)
+	}
+
+	key, sandboxID, err := s.issueSandboxKey(c.UserContext(), *logbook)
+	if err != nil {
+		s.logger.ErrorWith().Err(errors.New(op).Err(err)).Msg("s.issueSandboxKey failed")
+		return s.dbFailure(c, err)
+	}
+	s.audit(c, auditEntry{Event: auditApiKeyCreated, Actor: actorOf(c), LogbookID: auditLogbookID(logbook.ID)},
+		fiber.Map{"prefix": key[:prefixLen], "sandbox": sandboxID})
+
+	return c.Status(fiber.StatusCreated).JSON(fiber.Map{"key": key, "sandbox_logbook_id": sandboxID})
+}
+
+// deleteSandboxHandler deletes the authenticated logbook's sandbox, with its QSOs and key.
+func (s *Service) deleteSandboxHandler(c *fiber.Ctx) error {
+	const op errors.Op = "server.Service.deleteSandboxHandler"
+
+	logbook, err := authenticatedLogbook(c)
+	if err != nil {
+		s.logger.ErrorWith().Err(errors.New(op).Err(err)).Msg("authenticatedLogbook failed")
+		return c.Status(fiber.StatusInternalServerError).JSON(jsonInternalError)
+	}
+
+	found, err := s.deleteSandbox(c.UserContext(), logbook.ID)
+	if err != nil {
+		s.logger.ErrorWith().Err(errors.New(op).Err(err)).Msg("s.deleteSandbox failed")
+		return s.dbFailure(c, err)
+	}
+	if !found {
+		return c.Status(fiber.StatusNotFound).JSON(jsonError(errCodeNotFound, "The logbook has no sandbox"))
+	}
+	return c.SendStatus(fiber.StatusNoContent)
+}
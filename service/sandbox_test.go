@@ -0,0 +1,115 @@
+package service
+
+import (
+	"context"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/goccy/go-json"
+	"github.com/gofiber/fiber/v2"
+)
+
+func TestSandboxKeys(t *testing.T) {
+	svc := newTestServer(t)
+	ctx := context.Background()
+	for _, query := range []string{
+		`INSERT INTO users (id, callsign, pass_hash) VALUES (8, 'K1AB', 'hash')`,
+		`UPDATE logbook SET user_id = 8, callsign = 'K1AB' WHERE id = 1`,
+	} {
+		if _, err := svc.db.ExecContext(ctx, query); err != nil {
+			t.Fatalf("setup failed: %v", err)
+		}
+	}
+	ownerKey, err := svc.issueMemberApiKey(ctx, 1, 8, "K1AB")
+	if err != nil {
+		t.Fatalf("issueMemberApiKey failed: %v", err)
+	}
+	insertTestQso(t, svc, "JA1XX", "20m", "CW", "20240101", "1200")
+
+	send := func(method, path, key, body string) (int, map[string]any) {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set(fiber.HeaderAuthorization, "Bearer "+key)
+		req.Header.Set(fiber.HeaderContentType, fiber.MIMEApplicationJSON)
+		resp, err := svc.app.Test(req, -1)
+		if err != nil {
+			t.Fatalf("%s %s failed: %v", method, path, err)
+		}
+		var out map[string]any
+		_ = json.NewDecoder(resp.Body).Decode(&out)
+		return resp.StatusCode, out
+	}
+	issue := func() (string, int64) {
+		status, out := send(fiber.MethodPost, "/keys/sandbox", ownerKey, emptyString)
+		key, _ := out["key"].(string)
+		id, _ := out["sandbox_logbook_id"].(float64)
+		if status != fiber.StatusCreated || key == emptyString || int64(id) <= 1 {
+			t.Fatalf("expected a sandbox key, got %d %v", status, out)
+		}
+		return key, int64(id)
+	}
+	count := func(key string) float64 {
+		status, out := send(fiber.MethodGet, "/stats/count", key, emptyString)
+		if status != fiber.StatusOK {
+			t.Fatalf("expected the count, got %d %v", status, out)
+		}
+		n, _ := out["count"].(float64)
+		return n
+	}
+
+	sandboxKey, sandboxID := issue()
+	if n := count(sandboxKey); n != 0 {
+		t.Errorf("expected the sandbox to start empty, got %v QSOs", n)
+	}
+	qso := `{"call":"JA2XX","band":"20m","mode":"CW","freq":"14.025","qso_date":"20240101","time_on":"1300","time_off":"1301","rst_sent":"599","rst_rcvd":"599","station_callsign":"K1AB","session_id":1}`
+	if status, out := send(fiber.MethodPost, "/api/v2/logbooks/"+strconv.FormatInt(sandboxID, 10)+"/qsos", sandboxKey, qso); status != fiber.StatusCreated {
+		t.Fatalf("expected the sandbox to take the QSO, got %d %v", status, out)
+	}
+	if sandbox, real := count(sandboxKey), count(ownerKey); sandbox != 1 || real != 1 {
+		t.Errorf("expected the QSO in the sandbox only, got %v in the sandbox and %v in the logbook", sandbox, real)
+	}
+
+	for _, path := range []string{"/integrations/lotw", "/keys/sandbox"} {
+		if status, out := send(fiber.MethodPost, path, sandboxKey, emptyString); status != fiber.StatusForbidden || out["code"] != string(errCodeSandboxKey) {
+			t.Errorf("POST %s: expected sandbox keys to be refused, got %d %v", path, status, out)
+		}
+	}
+
+	reissued, sameID := issue()
+	if sameID != sandboxID {
+		t.Errorf("expected the sandbox to be kept, got %d then %d", sandboxID, sameID)
+	}
+	if status, _ := send(fiber.MethodGet, "/stats/count", sandboxKey, emptyString); status != fiber.StatusUnauthorized {
+		t.Errorf("expected the replaced sandbox key to stop working, got %d", status)
+	}
+
+	if status, _ := send(fiber.MethodDelete, "/keys/sandbox", ownerKey, emptyString); status != fiber.StatusNoContent {
+		t.Fatalf("expected the sandbox to be deleted, got %d", status)
+	}
+	if status, _ := send(fiber.MethodGet, "/stats/count", reissued, emptyString); status != fiber.StatusUnauthorized {
+		t.Errorf("expected the sandbox key to stop working with its sandbox, got %d", status)
+	}
+	if status, _ := send(fiber.MethodDelete, "/keys/sandbox", ownerKey, emptyString); status != fiber.StatusNotFound {
+		t.Errorf("expected no sandbox to be left, got %d", status)
+	}
+
+	_, sandboxID = issue()
+	if _, err = svc.purgeLogbook(ctx, 8, 1); err != nil {
+		t.Fatalf("purgeLogbook failed: %v", err)
+	}
+	if _, found, err := logbookRef(ctx, svc, sandboxID); err != nil || found {
+		t.Errorf("expected the sandbox to be purged with its logbook, got found=%v err=%v", found, err)
+	}
+}
+
+// logbookRef reports whether the logbook exists.
+func logbookRef(ctx context.Context, svc *Service, id int64) (int64, bool, error) {
+	rows, err := svc.db.QueryContext(ctx, `SELECT id FROM logbook WHERE id = $1`, id)
+	if err != nil {
+		return 0, false, err
+	}
+	defer func() { _ = rows.Close() }()
+	found := rows.Next()
+	return id, found, rows.Err()
+}
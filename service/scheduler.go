@@ -0,0 +1,451 @@
+package service
+
+import (
+	"context"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Station-Manager/errors"
+	"github.com/gofiber/fiber/v2"
+)
+
+// envSchedule names the environment variable overriding when the server's recurring tasks run, as
+// semicolon-separated "task=schedule" pairs (e.g. "backup=0 3 * * *;lotw_sync=@every 2h;cache_sweep=off").
+// A schedule is a Go duration or "@every <duration>", one of @hourly, @daily, @weekly and @monthly,
+// a five-field cron expression evaluated in UTC, or "off". Tasks not named keep their defaults.
+const envSchedule = "SM_SCHEDULE"
+
+// The names of the recurring tasks, as used in SM_SCHEDULE and the admin schedule.
+const (
+	scheduledCacheSweep   = "cache_sweep"
+	scheduledCtyRefresh   = "cty_refresh"
+	scheduledSolarRefresh = "solar_refresh"
+	scheduledLotwSync     = "lotw_sync"
+	scheduledEqslSync     = "eqsl_sync"
+	scheduledClubLogRetry = "clublog_retry"
+	scheduledBackup       = "backup"
+	scheduledTrashPurge   = "trash_purge"
+	scheduledAuditPurge   = "audit_purge"
+	scheduledAccountPurge = "account_purge"
+	scheduledJobPurge     = "job_purge"
+	scheduledApiKeyExpiry = "api_key_expiry"
+	scheduledOutboxPurge  = "outbox_purge"
+	scheduledQslPurge     = "qsl_image_purge"
+)
+
+// scheduledTaskNames lists every recurring task, in the order the admin schedule shows them.
+var scheduledTaskNames = []string{
+	scheduledCacheSweep, scheduledCtyRefresh, scheduledSolarRefresh, scheduledLotwSync, scheduledEqslSync, scheduledClubLogRetry,
+	scheduledBackup, scheduledTrashPurge, scheduledAuditPurge, scheduledAccountPurge, scheduledJobPurge,
+	scheduledApiKeyExpiry, scheduledOutboxPurge, scheduledQslPurge,
+}
+
+// schedule decides when a recurring task runs.
+type schedule interface {
+	// next returns the first time after t that the task is due, or the zero time if it never is.
+	next(t time.Time) time.Time
+	String() string
+}
+
+// intervalSchedule runs a task a fixed time after its previous run.
+type intervalSchedule time.Duration
+
+func (d intervalSchedule) next(t time.Time) time.Time {
+	return t.Add(time.Duration(d))
+}
+
+func (d intervalSchedule) String() string {
+	return "@every " + time.Duration(d).String()
+}
+
+// cronSchedule runs a task at the minutes matching a five-field cron expression, in UTC. Each
+// field is a bit set of the values it matches.
+type cronSchedule struct {
+	spec                          string
+	minute, hour, dom, month, dow uint64
+	domRestricted, dowRestricted  bool
+}
+
+// cronDescriptors are the named schedules accepted in place of a cron expression.
+var cronDescriptors = map[string]string{
+	"@hourly":   "0 * * * *",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@weekly":   "0 0 * * 0",
+	"@monthly":  "0 0 1 * *",
+}
+
+// cronSearchYears bounds the search for the next matching minute, so that expressions such as
+// "0 0 30 2 *" are rejected rather than searched forever.
+const cronSearchYears = 5
+
+// parseSchedule parses one schedule of SM_SCHEDULE. "off" parses as a nil schedule.
+func parseSchedule(value string) (schedule, error) {
+	const op errors.Op = "server.parseSchedule"
+
+	value = strings.TrimSpace(value)
+	if value == "off" {
+		return nil, nil
+	}
+	if every, ok := strings.CutPrefix(value, "@every "); ok {
+		value = strings.TrimSpace(every)
+	}
+	if d, err := time.ParseDuration(value); err == nil {
+		if d < time.Second {
+			return nil, errors.New(op).Msg("interval " + value + " is shorter than a second")
+		}
+		return intervalSchedule(d), nil
+	}
+	return parseCronSchedule(value)
+}
+
+// parseCronSchedule parses a five-field cron expression (minute, hour, day of month, month, day
+// of week) or one of the cronDescriptors. Fields take "*", values, ranges, lists and steps; Sunday
+// is 0 or 7.
+func parseCronSchedule(spec string) (*cronSchedule, error) {
+	const op errors.Op = "server.parseCronSchedule"
+
+	expr := spec
+	if descriptor, ok := cronDescriptors[spec]; ok {
+		expr = descriptor
+	}
+	fields := strings.Fields(expr)
+	if len(fields) != 5 {
+		return nil, errors.New(op).Msg("schedule " + strconv.Quote(spec) + " is neither a duration nor a five-field cron expression")
+	}
+
+	c := &cronSchedule{spec: spec}
+	bounds := [5][2]int{{0, 59}, {0, 23}, {1, 31}, {1, 12}, {0, 7}}
+	sets := [5]*uint64{&c.minute, &c.hour, &c.dom, &c.month, &c.dow}
+	for i, field := range fields {
+		set, err := parseCronField(field, bounds[i][0], bounds[i][1])
+		if err != nil {
+			return nil, errors.New(op).Msg("schedule " + strconv.Quote(spec) + ": " + err.Error())
+		}
+		*sets[i] = set
+	}
+	if c.dow&(1<<7) != 0 {
+		c.dow |= 1
+	}
+	c.domRestricted = !strings.HasPrefix(fields[2], "*")
+	c.dowRestricted = !strings.HasPrefix(fields[4], "*")
+
+	if c.next(time.Date(2000, time.January, 1, 0, 0, 0, 0, time.UTC)).IsZero() {
+		return nil, errors.New(op).Msg("schedule " + strconv.Quote(spec) + " never runs")
+	}
+	return c, nil
+}
+
+// parseCronField returns the bit set of the values between lo and hi that the field matches.
+func parseCronField(field string, lo, hi int) (uint64, error) {
+	const op errors.Op = "server.parseCronField"
+
+	var set uint64
+	for _, part := range strings.Split(field, ",") {
+		rangePart, stepPart, hasStep := strings.Cut(part, "/")
+		step := 1
+		if hasStep {
+			var err error
+			if step, err = strconv.Atoi(stepPart); err != nil || step <= 0 {
+				return 0, errors.New(op).Msg("invalid step in " + strconv.Quote(part))
+			}
+		}
+
+		first, last := lo, hi
+		if rangePart != "*" {
+			from, to, isRange := strings.Cut(rangePart, "-")
+			var err error
+			if first, err = strconv.Atoi(from); err != nil {
+				return 0, errors.New(op).Msg("invalid value in " + strconv.Quote(part))
+			}
+			last = first
+			if isRange {
+				if last, err = strconv.Atoi(to); err != nil {
+					return 0, errors.New(op).Msg("invalid range in " + strconv.Quote(part))
+				}
+			} else if hasStep {
+				last = hi
+			}
+		}
+		if first < lo || last > hi || first > last {
+			return 0, errors.New(op).Msg(strconv.Quote(part) + " is out of range")
+		}
+		for v := first; v <= last; v += step {
+			set |= 1 << v
+		}
+	}
+	return set, nil
+}
+
+func (c *cronSchedule) next(t time.Time) time.Time {
+	t = t.UTC().Truncate(time.Minute).Add(time.Minute)
+	limit := t.AddDate(cronSearchYears, 0, 0)
+	for t.Before(limit) {
+		switch {
+		case c.month&(1<<int(t.Month())) == 0:
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, time.UTC)
+		case !c.matchesDay(t):
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, time.UTC)
+		case c.hour&(1<<t.Hour()) == 0:
+			t = t.Truncate(time.Hour).Add(time.Hour)
+		case c.minute&(1<<t.Minute()) == 0:
+			t = t.Add(time.Minute)
+		default:
+			return t
+		}
+	}
+	return time.Time{}
+}
+
+// matchesDay reports whether the schedule runs on t's day. As in cron, when both the day of the
+// month and the day of the week are restricted, a day matching either runs.
+func (c *cronSchedule) matchesDay(t time.Time) bool {
+	dom := c.dom&(1<<t.Day()) != 0
+	dow := c.dow&(1<<int(t.Weekday())) != 0
+	if c.domRestricted && c.dowRestricted {
+		return dom || dow
+	}
+	return dom && dow
+}
+
+func (c *cronSchedule) String() string {
+	return c.spec
+}
+
+// scheduledTask is a recurring task and the outcome of its latest run.
+type scheduledTask struct {
+	Name     string     `json:"name"`
+	Schedule string     `json:"schedule"`
+	Running  bool       `json:"running"`
+	NextRun  *time.Time `json:"next_run,omitempty"`
+	LastRun  *time.Time `json:"last_run,omitempty"`
+	// LastDuration is how long the latest run took, in milliseconds.
+	LastDuration int64  `json:"last_duration_ms,omitempty"`
+	LastError    string `json:"last_error,omitempty"`
+	Runs         int64  `json:"runs"`
+	Failures     int64  `json:"failures"`
+
+	schedule schedule
+	// lastRun, if set, reports when the task last ran before the server started, so that
+	// restarts neither delay nor repeat it. A zero time means it never ran and is due at once;
+	// false means it is unknown and the schedule starts from now.
+	lastRun func(ctx context.Context) (time.Time, bool)
+	run     func(ctx context.Context) error
+}
+
+// scheduler runs the server's recurring tasks, each on its own schedule, and keeps their status
+// for the admin schedule.
+type scheduler struct {
+	// overrides holds the schedules set in SM_SCHEDULE; a nil schedule turns a task off.
+	overrides map[string]schedule
+
+	mu    sync.Mutex
+	tasks []*scheduledTask
+}
+
+func newScheduler(overrides map[string]schedule) *scheduler {
+	if overrides == nil {
+		overrides = make(map[string]schedule)
+	}
+	return &scheduler{overrides: overrides}
+}
+
+// loadScheduler reads the schedule overrides from the environment.
+func loadScheduler() (*scheduler, error) {
+	const op errors.Op = "server.loadScheduler"
+
+	overrides := make(map[string]schedule)
+	for _, entry := range strings.Split(os.Getenv(envSchedule), ";") {
+		if strings.TrimSpace(entry) == emptyString {
+			continue
+		}
+		name, value, ok := strings.Cut(entry, "=")
+		name = strings.TrimSpace(name)
+		if !ok {
+			return nil, errors.New(op).Msg(envSchedule + " entries must be task=schedule pairs")
+		}
+		known := false
+		for _, n := range scheduledTaskNames {
+			known = known || n == name
+		}
+		if !known {
+			return nil, errors.New(op).Msg(envSchedule + " names unknown task " + strconv.Quote(name))
+		}
+		sched, err := parseSchedule(value)
+		if err != nil {
+			return nil, errors.New(op).Err(err).Msg(envSchedule + " has an invalid schedule for " + name)
+		}
+		overrides[name] = sched
+	}
+	return newScheduler(overrides), nil
+}
+
+// add registers the task, with its schedule replaced by any override, and reports false if the
+// task is off.
+func (sc *scheduler) add(task *scheduledTask) bool {
+	if override, ok := sc.overrides[task.Name]; ok {
+		task.schedule = override
+	}
+	if task.schedule == nil {
+		return false
+	}
+	task.Schedule = task.schedule.String()
+
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	sc.tasks = append(sc.tasks, task)
+	return true
+}
+
+// snapshot returns copies of the registered tasks.
+func (sc *scheduler) snapshot() []scheduledTask {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+
+	tasks := make([]scheduledTask, 0, len(sc.tasks))
+	for _, task := range sc.tasks {
+		tasks = append(tasks, *task)
+	}
+	return tasks
+}
+
+func (sc *scheduler) setNextRun(task *scheduledTask, next time.Time) {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	task.NextRun = &next
+}
+
+func (sc *scheduler) started(task *scheduledTask, at time.Time) {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	task.Running, task.LastRun, task.NextRun = true, &at, nil
+}
+
+func (sc *scheduler) finished(task *scheduledTask, took time.Duration, err error) {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	task.Running, task.LastDuration, task.LastError = false, took.Milliseconds(), emptyString
+	task.Runs++
+	if err != nil {
+		task.Failures++
+		task.LastError = errors.Root(err).Error()
+	}
+}
+
+// startScheduledTasks registers the recurring tasks that apply to the server's configuration and
+// runs each in the background.
+func (s *Service) startScheduledTasks() {
+	for _, task := range s.scheduledTasks() {
+		if s.scheduler.add(task) {
+			s.runInBackground(task.Name, func(ctx context.Context) { s.runScheduledTask(ctx, task) })
+		}
+	}
+}
+
+// scheduledTasks returns the recurring tasks with their default schedules. A task with no
+// default runs only if SM_SCHEDULE sets one.
+func (s *Service) scheduledTasks() []*scheduledTask {
+	tasks := []*scheduledTask{{
+		Name:     scheduledCacheSweep,
+		schedule: intervalSchedule(defaultCacheSweepInterval),
+		run:      func(context.Context) error { s.sweepCaches(); return nil },
+	}}
+	if s.cty != nil {
+		tasks = append(tasks, &scheduledTask{
+			Name:     scheduledCtyRefresh,
+			schedule: intervalSchedule(s.cty.refreshInterval),
+			lastRun:  s.loadStoredCtyRefresh,
+			run:      s.refreshCtyDatabase,
+		})
+	}
+	if s.solar != nil {
+		tasks = append(tasks, &scheduledTask{
+			Name:     scheduledSolarRefresh,
+			schedule: intervalSchedule(s.solar.refreshInterval),
+			lastRun:  s.loadStoredSolarRefresh,
+			run:      s.refreshSolarReport,
+		})
+	}
+	if len(s.credentialsKey) > 0 {
+		// Without a key no account can be configured, so there is nothing to sync.
+		if s.lotw != nil {
+			tasks = append(tasks, &scheduledTask{Name: scheduledLotwSync, schedule: intervalSchedule(s.lotw.syncInterval), run: s.queueLotwSyncs})
+		}
+		if s.eqsl != nil {
+			tasks = append(tasks, &scheduledTask{Name: scheduledEqslSync, schedule: intervalSchedule(s.eqsl.syncInterval), run: s.queueEqslSyncs})
+		}
+		if s.clublog.enabled() {
+			tasks = append(tasks, &scheduledTask{Name: scheduledClubLogRetry, schedule: intervalSchedule(s.clublog.retryInterval), run: s.retryAllClubLogUploads})
+		}
+	}
+	if s.backups != nil && s.jobs != nil {
+		task := &scheduledTask{Name: scheduledBackup, lastRun: s.lastBackupTime, run: s.queueScheduledBackup}
+		if s.backups.interval > 0 {
+			task.schedule = intervalSchedule(s.backups.interval)
+		}
+		tasks = append(tasks, task)
+	}
+	if s.trashRetention > 0 {
+		tasks = append(tasks, &scheduledTask{Name: scheduledTrashPurge, schedule: intervalSchedule(defaultTrashPurgeInterval), run: s.purgeExpiredTrash})
+	}
+	if s.auditRetention > 0 {
+		tasks = append(tasks, &scheduledTask{Name: scheduledAuditPurge, schedule: intervalSchedule(defaultAuditPurgeInterval), run: s.purgeExpiredAuditLog})
+	}
+	tasks = append(tasks, &scheduledTask{Name: scheduledAccountPurge, schedule: intervalSchedule(defaultAccountPurgeInterval), run: s.purgeDeletedAccounts})
+	if s.jobs != nil {
+		tasks = append(tasks, &scheduledTask{Name: scheduledJobPurge, schedule: intervalSchedule(defaultJobPurgeInterval), run: s.purgeFinishedJobs})
+	}
+	tasks = append(tasks, &scheduledTask{Name: scheduledApiKeyExpiry, schedule: intervalSchedule(defaultApiKeyExpiryInterval), run: s.expireStaleApiKeys})
+	tasks = append(tasks, &scheduledTask{Name: scheduledOutboxPurge, schedule: intervalSchedule(defaultOutboxPurgeInterval), run: s.purgeDispatchedOutbox})
+	if s.qslImages != nil {
+		tasks = append(tasks, &scheduledTask{Name: scheduledQslPurge, schedule: intervalSchedule(defaultQslImagePurgeInterval), run: s.purgeOrphanedQslImages})
+	}
+	return tasks
+}
+
+// runScheduledTask runs the task whenever it is due, until ctx is cancelled. A run is never
+// overlapped: the next is scheduled from when the previous one finished.
+func (s *Service) runScheduledTask(ctx context.Context, task *scheduledTask) {
+	next := time.Time{}
+	if task.lastRun != nil {
+		if last, ok := task.lastRun(ctx); ok {
+			next = task.schedule.next(last)
+		}
+	}
+	if next.IsZero() {
+		next = task.schedule.next(time.Now())
+	}
+
+	for !next.IsZero() {
+		s.scheduler.setNextRun(task, next)
+		timer := time.NewTimer(max(0, time.Until(next)))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+
+		startedAt := time.Now()
+		s.scheduler.started(task, startedAt)
+		err := task.run(ctx)
+		s.scheduler.finished(task, time.Since(startedAt), err)
+		if err != nil && ctx.Err() == nil {
+			s.logger.ErrorWith().Err(err).Str("task", task.Name).Msg("Scheduled task failed")
+		}
+		next = task.schedule.next(time.Now())
+	}
+}
+
+// adminScheduleHandler lists the recurring tasks with their schedules, next runs and the outcome
+// of their latest runs.
+func (s *Service) adminScheduleHandler(c *fiber.Ctx) error {
+	tasks := make([]scheduledTask, 0)
+	if s.scheduler != nil {
+		tasks = s.scheduler.snapshot()
+	}
+	return c.JSON(fiber.Map{"tasks": tasks})
+}
@@ -0,0 +1,215 @@
+package service
+
+import (
+	"context"
+	stderr "errors"
+	"io"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+func TestParseSchedule(t *testing.T) {
+	for value, want := range map[string]string{
+		"6h":                "@every 6h0m0s",
+		"@every 90s":        "@every 1m30s",
+		"@daily":            "@daily",
+		"0 3 * * *":         "0 3 * * *",
+		"*/15 0-6 * *  1-5": "*/15 0-6 * *  1-5",
+	} {
+		sched, err := parseSchedule(value)
+		if err != nil || sched == nil || sched.String() != want {
+			t.Errorf("parseSchedule(%q) = %v %v, want %s", value, sched, err, want)
+		}
+	}
+	if sched, err := parseSchedule("off"); err != nil || sched != nil {
+		t.Errorf("expected off to parse as no schedule, got %v %v", sched, err)
+	}
+	for _, value := range []string{"10ms", "0 3 * *", "60 * * * *", "0 0 30 2 *", "*/0 * * * *", "5-1 * * * *", "@yearly"} {
+		if _, err := parseSchedule(value); err == nil {
+			t.Errorf("expected %q to be rejected", value)
+		}
+	}
+}
+
+func TestCronSchedule_Next(t *testing.T) {
+	at := func(value string) time.Time {
+		parsed, err := time.Parse(time.DateTime, value)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return parsed
+	}
+	for _, tc := range []struct{ spec, after, want string }{
+		{"*/15 * * * *", "2024-05-01 10:07:30", "2024-05-01 10:15:00"},
+		{"0 3 * * *", "2024-05-01 03:00:00", "2024-05-02 03:00:00"},
+		{"0 3 * * *", "2024-12-31 23:59:00", "2025-01-01 03:00:00"},
+		// 1 June 2024 is a Saturday; 3 June is a Monday.
+		{"0 0 * * 1", "2024-06-01 12:00:00", "2024-06-03 00:00:00"},
+		{"30 2 * * 7", "2024-06-01 12:00:00", "2024-06-02 02:30:00"},
+		// With both days restricted, either matches.
+		{"0 0 15 * 1", "2024-06-01 12:00:00", "2024-06-03 00:00:00"},
+		{"0 0 29 2 *", "2024-03-01 00:00:00", "2028-02-29 00:00:00"},
+	} {
+		sched, err := parseCronSchedule(tc.spec)
+		if err != nil {
+			t.Fatalf("parseCronSchedule(%q) failed: %v", tc.spec, err)
+		}
+		if got := sched.next(at(tc.after)); !got.Equal(at(tc.want)) {
+			t.Errorf("%q after %s: got %s, want %s", tc.spec, tc.after, got, tc.want)
+		}
+	}
+}
+
+func TestLoadScheduler(t *testing.T) {
+	t.Setenv(envSchedule, "backup=0 3 * * *; cache_sweep=off ;lotw_sync=@every 2h;")
+	sc, err := loadScheduler()
+	if err != nil {
+		t.Fatalf("loadScheduler failed: %v", err)
+	}
+	if sched, ok := sc.overrides[scheduledCacheSweep]; !ok || sched != nil {
+		t.Errorf("expected the cache sweep to be off, got %v", sched)
+	}
+	if sched := sc.overrides[scheduledBackup]; sched == nil || sched.String() != "0 3 * * *" {
+		t.Errorf("expected a nightly backup, got %v", sched)
+	}
+	if sc.add(&scheduledTask{Name: scheduledCacheSweep, schedule: intervalSchedule(time.Minute)}) {
+		t.Error("expected a task turned off not to be added")
+	}
+	lotw := &scheduledTask{Name: scheduledLotwSync, schedule: intervalSchedule(time.Hour)}
+	if !sc.add(lotw) || lotw.Schedule != "@every 2h0m0s" {
+		t.Errorf("expected the override to replace the default, got %q", lotw.Schedule)
+	}
+
+	for _, value := range []string{"nightly_thing=@daily", "backup", "backup=sometimes"} {
+		t.Setenv(envSchedule, value)
+		if _, err = loadScheduler(); err == nil {
+			t.Errorf("expected %q to be rejected", value)
+		}
+	}
+}
+
+func TestRunScheduledTask_RecordsRunsAndNextRun(t *testing.T) {
+	svc := newTestServer(t)
+	svc.scheduler = newScheduler(nil)
+
+	ran := make(chan struct{}, 1)
+	task := &scheduledTask{
+		Name:     scheduledCtyRefresh,
+		schedule: intervalSchedule(time.Hour),
+		// Never having run, the task is due at once.
+		lastRun: func(context.Context) (time.Time, bool) { return time.Time{}, true },
+		run: func(context.Context) error {
+			ran <- struct{}{}
+			return stderr.New("download failed")
+		},
+	}
+	svc.scheduler.add(task)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		svc.runScheduledTask(ctx, task)
+		close(done)
+	}()
+	select {
+	case <-ran:
+	case <-time.After(time.Second):
+		t.Fatal("expected the task to run at once")
+	}
+
+	var status scheduledTask
+	for deadline := time.Now().Add(time.Second); time.Now().Before(deadline); time.Sleep(time.Millisecond) {
+		if status = svc.scheduler.snapshot()[0]; status.NextRun != nil {
+			break
+		}
+	}
+	cancel()
+	<-done
+	if status.Runs != 1 || status.Failures != 1 || status.LastError != "download failed" || status.LastRun == nil {
+		t.Errorf("expected one failed run, got %+v", status)
+	}
+	if status.NextRun == nil || status.NextRun.Sub(*status.LastRun) < time.Hour {
+		t.Errorf("expected the next run an hour after the last, got %+v", status)
+	}
+
+	app := fiber.New()
+	app.Get("/admin/schedule", svc.adminScheduleHandler)
+	resp, err := app.Test(httptest.NewRequest(fiber.MethodGet, "/admin/schedule", nil))
+	if err != nil {
+		t.Fatalf("GET /admin/schedule failed: %v", err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	if !strings.Contains(string(body), `"name":"cty_refresh","schedule":"@every 1h0m0s"`) || !strings.Contains(string(body), `"next_run":`) {
+		t.Errorf("expected the task with its next run, got %s", body)
+	}
+}
+
+func TestExpireApiKeys(t *testing.T) {
+	svc := newTestServer(t)
+	ctx := context.Background()
+	now := time.Now()
+
+	for _, query := range []string{
+		`INSERT INTO logbook (id, name, callsign) VALUES (2, 'Portable', 'W1AW/P')`,
+		`INSERT INTO logbook (id, name, callsign) VALUES (3, 'Contest', 'W1AW/3')`,
+	} {
+		if _, err := svc.db.ExecContext(ctx, query); err != nil {
+			t.Fatalf("setup failed: %v", err)
+		}
+	}
+	insertKey := func(logbookID int64, prefix string, created time.Time, lastUsed, expires any) {
+		t.Helper()
+		if _, err := svc.db.ExecContext(ctx, `INSERT INTO api_keys (logbook_id, key_name, key_prefix, key_hash, created_at, last_used_at, expires_at)
+			VALUES ($1, $2, $2, 'hash', $3, $4, $5)`, logbookID, prefix, svc.dbTimestamp(created), lastUsed, expires); err != nil {
+			t.Fatalf("insert %s failed: %v", prefix, err)
+		}
+	}
+	longAgo := now.Add(-100 * 24 * time.Hour)
+	insertKey(1, "expired", now.Add(-time.Hour), nil, svc.dbTimestamp(now.Add(-time.Minute)))
+	insertKey(2, "idle", longAgo, nil, nil)
+	insertKey(3, "used", longAgo, svc.dbTimestamp(now.Add(-24*time.Hour)), svc.dbTimestamp(now.Add(time.Hour)))
+
+	revokedBy := func(prefix string) string {
+		t.Helper()
+		rows, err := svc.db.QueryContext(ctx, `SELECT COALESCE(revoked_by, '') FROM api_keys WHERE key_prefix = $1`, prefix)
+		if err != nil {
+			t.Fatalf("query failed: %v", err)
+		}
+		defer func() { _ = rows.Close() }()
+		var by string
+		if rows.Next() {
+			_ = rows.Scan(&by)
+		}
+		return by
+	}
+
+	if n, err := svc.expireApiKeys(ctx, now, svc.apiKeyMaxIdle); err != nil || n != 1 || revokedBy("expired") != apiKeyExpiredBy {
+		t.Fatalf("expected the expired key to be revoked, got %d %v", n, err)
+	}
+	if revokedBy("idle") != emptyString {
+		t.Error("expected idle keys to be kept by default")
+	}
+
+	svc.apiKeyMaxIdle = 90 * 24 * time.Hour
+	if n, err := svc.expireApiKeys(ctx, now, svc.apiKeyMaxIdle); err != nil || n != 1 || revokedBy("idle") != apiKeyIdleBy {
+		t.Fatalf("expected the idle key to be revoked, got %d %v", n, err)
+	}
+	if revokedBy("used") != emptyString {
+		t.Error("expected a recently used key to be kept")
+	}
+
+	// A key created before the idle window, whose only use is still counted in memory, is in use.
+	insertKey(1, "active", longAgo, nil, nil)
+	svc.usage = newUsageTracker()
+	svc.usage.record(1, apiKeyActorPrefix+"active", usageCounts{Requests: 1})
+	if err := svc.expireStaleApiKeys(ctx); err != nil {
+		t.Fatalf("expireStaleApiKeys failed: %v", err)
+	}
+	if revokedBy("active") != emptyString {
+		t.Error("expected a key used since its creation to be kept")
+	}
+}
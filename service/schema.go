@@ -0,0 +1,1530 @@
+package service
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/Station-Manager/database"
+	"github.com/Station-Manager/errors"
+)
+
+// schemaMigration is a change to the tables owned by the server itself. The core tables (users,
+// logbook, api_keys, qso, ...) are migrated by the database module; tables that only the server
+// uses are migrated here, after the core migrations have run, together with the core tables the
+// module's SQLite schema lacks.
+//
+// Migrations are applied in version order and must never be edited once released. Both drivers
+// accept $n placeholders, so only the DDL differs between them.
+//
+// The down statements revert a migration for `migrate down`; a nil list means it cannot be
+// reverted on that driver.
+type schemaMigration struct {
+	version      int
+	name         string
+	postgres     []string
+	sqlite       []string
+	postgresDown []string
+	sqliteDown   []string
+}
+
+var serverMigrations = []schemaMigration{
+	{
+		version: 1,
+		name:    "webhooks",
+		postgres: []string{
+			`CREATE TABLE IF NOT EXISTS webhooks
+			(
+				id         BIGSERIAL PRIMARY KEY,
+				created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+				logbook_id BIGINT      NOT NULL REFERENCES logbook (id) ON DELETE CASCADE,
+				url        TEXT        NOT NULL,
+				secret     TEXT        NOT NULL,
+				events     TEXT        NOT NULL DEFAULT ''
+			)`,
+			`CREATE INDEX IF NOT EXISTS webhooks_logbook_id_idx ON webhooks (logbook_id)`,
+			`CREATE TABLE IF NOT EXISTS webhook_dead_letters
+			(
+				id          BIGSERIAL PRIMARY KEY,
+				created_at  TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+				webhook_id  BIGINT      NOT NULL REFERENCES webhooks (id) ON DELETE CASCADE,
+				delivery_id TEXT        NOT NULL,
+				event_type  TEXT        NOT NULL,
+				payload     TEXT        NOT NULL,
+				attempts    INTEGER     NOT NULL,
+				last_error  TEXT        NOT NULL
+			)`,
+			`CREATE INDEX IF NOT EXISTS webhook_dead_letters_webhook_id_idx ON webhook_dead_letters (webhook_id)`,
+		},
+		sqlite: []string{
+			`CREATE TABLE IF NOT EXISTS webhooks
+			(
+				id         INTEGER PRIMARY KEY AUTOINCREMENT,
+				created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+				logbook_id INTEGER   NOT NULL REFERENCES logbook (id) ON DELETE CASCADE,
+				url        TEXT      NOT NULL,
+				secret     TEXT      NOT NULL,
+				events     TEXT      NOT NULL DEFAULT ''
+			)`,
+			`CREATE INDEX IF NOT EXISTS webhooks_logbook_id_idx ON webhooks (logbook_id)`,
+			`CREATE TABLE IF NOT EXISTS webhook_dead_letters
+			(
+				id          INTEGER PRIMARY KEY AUTOINCREMENT,
+				created_at  TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+				webhook_id  INTEGER   NOT NULL REFERENCES webhooks (id) ON DELETE CASCADE,
+				delivery_id TEXT      NOT NULL,
+				event_type  TEXT      NOT NULL,
+				payload     TEXT      NOT NULL,
+				attempts    INTEGER   NOT NULL,
+				last_error  TEXT      NOT NULL
+			)`,
+			`CREATE INDEX IF NOT EXISTS webhook_dead_letters_webhook_id_idx ON webhook_dead_letters (webhook_id)`,
+		},
+		postgresDown: []string{
+			`DROP TABLE IF EXISTS webhook_dead_letters`,
+			`DROP TABLE IF EXISTS webhooks`,
+		},
+		sqliteDown: []string{
+			`DROP TABLE IF EXISTS webhook_dead_letters`,
+			`DROP TABLE IF EXISTS webhooks`,
+		},
+	},
+	{
+		version: 2,
+		name:    "lotw",
+		postgres: []string{
+			`CREATE TABLE IF NOT EXISTS qso_confirmations
+			(
+				qso_id         BIGINT      NOT NULL REFERENCES qso (id) ON DELETE CASCADE,
+				source         TEXT        NOT NULL,
+				received_date  TEXT        NOT NULL DEFAULT '',
+				credit_granted TEXT        NOT NULL DEFAULT '',
+				updated_at     TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+				PRIMARY KEY (qso_id, source)
+			)`,
+			`CREATE TABLE IF NOT EXISTS lotw_accounts
+			(
+				logbook_id   BIGINT PRIMARY KEY REFERENCES logbook (id) ON DELETE CASCADE,
+				username     TEXT NOT NULL,
+				password     TEXT NOT NULL,
+				qsl_since    TEXT NOT NULL DEFAULT '',
+				last_sync_at TIMESTAMPTZ,
+				last_error   TEXT NOT NULL DEFAULT ''
+			)`,
+		},
+		sqlite: []string{
+			`CREATE TABLE IF NOT EXISTS qso_confirmations
+			(
+				qso_id         INTEGER   NOT NULL REFERENCES qso (id) ON DELETE CASCADE,
+				source         TEXT      NOT NULL,
+				received_date  TEXT      NOT NULL DEFAULT '',
+				credit_granted TEXT      NOT NULL DEFAULT '',
+				updated_at     TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+				PRIMARY KEY (qso_id, source)
+			)`,
+			`CREATE TABLE IF NOT EXISTS lotw_accounts
+			(
+				logbook_id   INTEGER PRIMARY KEY REFERENCES logbook (id) ON DELETE CASCADE,
+				username     TEXT NOT NULL,
+				password     TEXT NOT NULL,
+				qsl_since    TEXT NOT NULL DEFAULT '',
+				last_sync_at TIMESTAMP,
+				last_error   TEXT NOT NULL DEFAULT ''
+			)`,
+		},
+		postgresDown: []string{
+			`DROP TABLE IF EXISTS lotw_accounts`,
+			`DROP TABLE IF EXISTS qso_confirmations`,
+		},
+		sqliteDown: []string{
+			`DROP TABLE IF EXISTS lotw_accounts`,
+			`DROP TABLE IF EXISTS qso_confirmations`,
+		},
+	},
+	{
+		version: 3,
+		name:    "eqsl",
+		postgres: []string{
+			`CREATE TABLE IF NOT EXISTS eqsl_accounts
+			(
+				logbook_id   BIGINT PRIMARY KEY REFERENCES logbook (id) ON DELETE CASCADE,
+				username     TEXT NOT NULL,
+				password     TEXT NOT NULL,
+				qth_nickname TEXT NOT NULL DEFAULT '',
+				rcvd_since   TEXT NOT NULL DEFAULT '',
+				last_sync_at TIMESTAMPTZ,
+				last_error   TEXT NOT NULL DEFAULT ''
+			)`,
+			`CREATE TABLE IF NOT EXISTS qso_uploads
+			(
+				qso_id     BIGINT      NOT NULL REFERENCES qso (id) ON DELETE CASCADE,
+				service    TEXT        NOT NULL,
+				status     TEXT        NOT NULL,
+				attempts   INTEGER     NOT NULL DEFAULT 0,
+				last_error TEXT        NOT NULL DEFAULT '',
+				updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+				PRIMARY KEY (qso_id, service)
+			)`,
+			`CREATE INDEX IF NOT EXISTS qso_uploads_service_status_idx ON qso_uploads (service, status)`,
+		},
+		sqlite: []string{
+			`CREATE TABLE IF NOT EXISTS eqsl_accounts
+			(
+				logbook_id   INTEGER PRIMARY KEY REFERENCES logbook (id) ON DELETE CASCADE,
+				username     TEXT NOT NULL,
+				password     TEXT NOT NULL,
+				qth_nickname TEXT NOT NULL DEFAULT '',
+				rcvd_since   TEXT NOT NULL DEFAULT '',
+				last_sync_at TIMESTAMP,
+				last_error   TEXT NOT NULL DEFAULT ''
+			)`,
+			`CREATE TABLE IF NOT EXISTS qso_uploads
+			(
+				qso_id     INTEGER   NOT NULL REFERENCES qso (id) ON DELETE CASCADE,
+				service    TEXT      NOT NULL,
+				status     TEXT      NOT NULL,
+				attempts   INTEGER   NOT NULL DEFAULT 0,
+				last_error TEXT      NOT NULL DEFAULT '',
+				updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+				PRIMARY KEY (qso_id, service)
+			)`,
+			`CREATE INDEX IF NOT EXISTS qso_uploads_service_status_idx ON qso_uploads (service, status)`,
+		},
+		postgresDown: []string{
+			`DROP TABLE IF EXISTS qso_uploads`,
+			`DROP TABLE IF EXISTS eqsl_accounts`,
+		},
+		sqliteDown: []string{
+			`DROP TABLE IF EXISTS qso_uploads`,
+			`DROP TABLE IF EXISTS eqsl_accounts`,
+		},
+	},
+	{
+		version: 4,
+		name:    "callsign_lookup",
+		postgres: []string{
+			`CREATE TABLE IF NOT EXISTS lookup_accounts
+			(
+				logbook_id BIGINT PRIMARY KEY REFERENCES logbook (id) ON DELETE CASCADE,
+				provider   TEXT NOT NULL,
+				username   TEXT NOT NULL,
+				password   TEXT NOT NULL,
+				last_error TEXT NOT NULL DEFAULT ''
+			)`,
+		},
+		sqlite: []string{
+			`CREATE TABLE IF NOT EXISTS lookup_accounts
+			(
+				logbook_id INTEGER PRIMARY KEY REFERENCES logbook (id) ON DELETE CASCADE,
+				provider   TEXT NOT NULL,
+				username   TEXT NOT NULL,
+				password   TEXT NOT NULL,
+				last_error TEXT NOT NULL DEFAULT ''
+			)`,
+		},
+		postgresDown: []string{
+			`DROP TABLE IF EXISTS lookup_accounts`,
+		},
+		sqliteDown: []string{
+			`DROP TABLE IF EXISTS lookup_accounts`,
+		},
+	},
+	{
+		version: 5,
+		name:    "clublog",
+		postgres: []string{
+			`CREATE TABLE IF NOT EXISTS clublog_accounts
+			(
+				logbook_id     BIGINT PRIMARY KEY REFERENCES logbook (id) ON DELETE CASCADE,
+				email          TEXT NOT NULL,
+				password       TEXT NOT NULL,
+				callsign       TEXT NOT NULL,
+				last_upload_at TIMESTAMPTZ,
+				last_error     TEXT NOT NULL DEFAULT ''
+			)`,
+		},
+		sqlite: []string{
+			`CREATE TABLE IF NOT EXISTS clublog_accounts
+			(
+				logbook_id     INTEGER PRIMARY KEY REFERENCES logbook (id) ON DELETE CASCADE,
+				email          TEXT NOT NULL,
+				password       TEXT NOT NULL,
+				callsign       TEXT NOT NULL,
+				last_upload_at TIMESTAMP,
+				last_error     TEXT NOT NULL DEFAULT ''
+			)`,
+		},
+		postgresDown: []string{
+			`DROP TABLE IF EXISTS clublog_accounts`,
+		},
+		sqliteDown: []string{
+			`DROP TABLE IF EXISTS clublog_accounts`,
+		},
+	},
+	{
+		version: 6,
+		name:    "pskreporter",
+		postgres: []string{
+			`CREATE TABLE IF NOT EXISTS pskreporter_logbooks
+			(
+				logbook_id BIGINT PRIMARY KEY REFERENCES logbook (id) ON DELETE CASCADE,
+				locator    TEXT NOT NULL DEFAULT '',
+				antenna    TEXT NOT NULL DEFAULT ''
+			)`,
+		},
+		sqlite: []string{
+			`CREATE TABLE IF NOT EXISTS pskreporter_logbooks
+			(
+				logbook_id INTEGER PRIMARY KEY REFERENCES logbook (id) ON DELETE CASCADE,
+				locator    TEXT NOT NULL DEFAULT '',
+				antenna    TEXT NOT NULL DEFAULT ''
+			)`,
+		},
+		postgresDown: []string{
+			`DROP TABLE IF EXISTS pskreporter_logbooks`,
+		},
+		sqliteDown: []string{
+			`DROP TABLE IF EXISTS pskreporter_logbooks`,
+		},
+	},
+	{
+		version: 7,
+		name:    "cty",
+		postgres: []string{
+			`CREATE TABLE IF NOT EXISTS cty_database
+			(
+				id         INTEGER PRIMARY KEY CHECK (id = 1),
+				source     TEXT        NOT NULL,
+				content    TEXT        NOT NULL,
+				fetched_at TIMESTAMPTZ NOT NULL
+			)`,
+		},
+		sqlite: []string{
+			`CREATE TABLE IF NOT EXISTS cty_database
+			(
+				id         INTEGER PRIMARY KEY CHECK (id = 1),
+				source     TEXT      NOT NULL,
+				content    TEXT      NOT NULL,
+				fetched_at TIMESTAMP NOT NULL
+			)`,
+		},
+		postgresDown: []string{
+			`DROP TABLE IF EXISTS cty_database`,
+		},
+		sqliteDown: []string{
+			`DROP TABLE IF EXISTS cty_database`,
+		},
+	},
+	{
+		version: 8,
+		name:    "awards",
+		postgres: []string{
+			`CREATE TABLE IF NOT EXISTS award_credits
+			(
+				qso_id     BIGINT  NOT NULL REFERENCES qso (id) ON DELETE CASCADE,
+				award      TEXT    NOT NULL,
+				logbook_id BIGINT  NOT NULL REFERENCES logbook (id) ON DELETE CASCADE,
+				credit     TEXT    NOT NULL,
+				band       TEXT    NOT NULL,
+				mode       TEXT    NOT NULL,
+				confirmed  BOOLEAN NOT NULL DEFAULT FALSE,
+				PRIMARY KEY (qso_id, award)
+			)`,
+			`CREATE INDEX IF NOT EXISTS award_credits_logbook_award_idx ON award_credits (logbook_id, award, credit)`,
+		},
+		sqlite: []string{
+			`CREATE TABLE IF NOT EXISTS award_credits
+			(
+				qso_id     INTEGER NOT NULL REFERENCES qso (id) ON DELETE CASCADE,
+				award      TEXT    NOT NULL,
+				logbook_id INTEGER NOT NULL REFERENCES logbook (id) ON DELETE CASCADE,
+				credit     TEXT    NOT NULL,
+				band       TEXT    NOT NULL,
+				mode       TEXT    NOT NULL,
+				confirmed  BOOLEAN NOT NULL DEFAULT FALSE,
+				PRIMARY KEY (qso_id, award)
+			)`,
+			`CREATE INDEX IF NOT EXISTS award_credits_logbook_award_idx ON award_credits (logbook_id, award, credit)`,
+		},
+		postgresDown: []string{
+			`DROP TABLE IF EXISTS award_credits`,
+		},
+		sqliteDown: []string{
+			`DROP TABLE IF EXISTS award_credits`,
+		},
+	},
+	{
+		version: 9,
+		name:    "activity_index",
+		postgres: []string{
+			`CREATE INDEX IF NOT EXISTS qso_logbook_date_time_idx ON qso (logbook_id, qso_date, time_on)`,
+		},
+		sqlite: []string{
+			`CREATE INDEX IF NOT EXISTS qso_logbook_date_time_idx ON qso (logbook_id, qso_date, time_on) WHERE deleted_at IS NULL`,
+		},
+		postgresDown: []string{
+			`DROP INDEX IF EXISTS qso_logbook_date_time_idx`,
+		},
+		sqliteDown: []string{
+			`DROP INDEX IF EXISTS qso_logbook_date_time_idx`,
+		},
+	},
+	{
+		// The database module's SQLite schema is the desktop one, which has no accounts. PostgreSQL
+		// already has these from the core migrations.
+		version:  10,
+		name:     "sqlite_users",
+		postgres: []string{},
+		sqlite: []string{
+			`CREATE TABLE IF NOT EXISTS users
+			(
+				id              INTEGER   NOT NULL PRIMARY KEY AUTOINCREMENT,
+				created_at      TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+				modified_at     TIMESTAMP,
+				callsign        TEXT      NOT NULL UNIQUE CHECK (length(callsign) <= 32),
+				pass_hash       TEXT CHECK (length(pass_hash) <= 255),
+				issuer          TEXT,
+				subject         TEXT,
+				email           TEXT CHECK (length(email) <= 256),
+				email_confirmed BOOLEAN            DEFAULT FALSE,
+				CONSTRAINT users_issuer_subject_pair CHECK (
+					(issuer IS NULL AND subject IS NULL) OR
+					(issuer IS NOT NULL AND subject IS NOT NULL)
+				),
+				CONSTRAINT users_external_identity_unique UNIQUE (issuer, subject)
+			)`,
+			`CREATE INDEX IF NOT EXISTS idx_users_issuer_subject ON users (issuer, subject)`,
+			`ALTER TABLE logbook ADD COLUMN user_id INTEGER REFERENCES users (id) ON DELETE CASCADE`,
+			`CREATE INDEX IF NOT EXISTS idx_logbook_user_id ON logbook (user_id)`,
+		},
+		postgresDown: []string{},
+		// Dropping logbook.user_id would need the logbook table rebuilt, which the database
+		// module owns.
+		sqliteDown: nil,
+	},
+	{
+		// Mirrors the PostgreSQL api_keys table. SQLite has no array types, so scopes and
+		// allowed_ips hold the PostgreSQL array literal text that the models read and write.
+		version:  11,
+		name:     "sqlite_api_keys",
+		postgres: []string{},
+		sqlite: []string{
+			`CREATE TABLE IF NOT EXISTS api_keys
+			(
+				id           INTEGER   NOT NULL PRIMARY KEY AUTOINCREMENT,
+				logbook_id   INTEGER   NOT NULL,
+				key_name     TEXT      NOT NULL CHECK (length(key_name) <= 255),
+				key_hash     TEXT      NOT NULL CHECK (length(key_hash) <= 128),
+				key_prefix   TEXT      NOT NULL CHECK (length(key_prefix) <= 16),
+				scopes       TEXT               DEFAULT '{}',
+				allowed_ips  TEXT,
+				created_at   TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+				last_used_at TIMESTAMP,
+				expires_at   TIMESTAMP,
+				revoked_at   TIMESTAMP,
+				created_by   TEXT CHECK (length(created_by) <= 255),
+				revoked_by   TEXT CHECK (length(revoked_by) <= 255),
+				use_count    INTEGER            DEFAULT 0,
+				CONSTRAINT api_keys_revoked_before_or_at_expires
+					CHECK (revoked_at IS NULL OR expires_at IS NULL OR revoked_at <= expires_at),
+				CONSTRAINT api_keys_name_per_logbook UNIQUE (logbook_id, key_name),
+				CONSTRAINT api_keys_logbook_fk FOREIGN KEY (logbook_id) REFERENCES logbook (id) ON DELETE CASCADE
+			)`,
+			`CREATE INDEX IF NOT EXISTS idx_api_keys_logbook_prefix ON api_keys (logbook_id, key_prefix)`,
+			`CREATE INDEX IF NOT EXISTS idx_api_keys_active ON api_keys (revoked_at) WHERE revoked_at IS NULL`,
+			`CREATE INDEX IF NOT EXISTS idx_api_keys_expires_at ON api_keys (expires_at) WHERE expires_at IS NOT NULL`,
+			`CREATE UNIQUE INDEX IF NOT EXISTS idx_api_keys_one_active_per_logbook ON api_keys (logbook_id) WHERE revoked_at IS NULL`,
+		},
+		postgresDown: []string{},
+		sqliteDown: []string{
+			`DROP TABLE IF EXISTS api_keys`,
+		},
+	},
+	{
+		// The SQLite schema already has deleted_at on both tables.
+		version: 12,
+		name:    "soft_delete",
+		postgres: []string{
+			`ALTER TABLE qso ADD COLUMN IF NOT EXISTS deleted_at TIMESTAMPTZ`,
+			`ALTER TABLE logbook ADD COLUMN IF NOT EXISTS deleted_at TIMESTAMPTZ`,
+			`CREATE INDEX IF NOT EXISTS idx_qso_deleted_at ON qso (deleted_at) WHERE deleted_at IS NOT NULL`,
+			`CREATE INDEX IF NOT EXISTS idx_logbook_deleted_at ON logbook (deleted_at) WHERE deleted_at IS NOT NULL`,
+		},
+		sqlite: []string{
+			`CREATE INDEX IF NOT EXISTS idx_qso_deleted_at ON qso (deleted_at) WHERE deleted_at IS NOT NULL`,
+			`CREATE INDEX IF NOT EXISTS idx_logbook_deleted_at ON logbook (deleted_at) WHERE deleted_at IS NOT NULL`,
+		},
+		postgresDown: []string{
+			`DROP INDEX IF EXISTS idx_logbook_deleted_at`,
+			`DROP INDEX IF EXISTS idx_qso_deleted_at`,
+			`ALTER TABLE logbook DROP COLUMN IF EXISTS deleted_at`,
+			`ALTER TABLE qso DROP COLUMN IF EXISTS deleted_at`,
+		},
+		sqliteDown: []string{
+			`DROP INDEX IF EXISTS idx_logbook_deleted_at`,
+			`DROP INDEX IF EXISTS idx_qso_deleted_at`,
+		},
+	},
+	{
+		version: 13,
+		name:    "qso_history",
+		postgres: []string{
+			`CREATE TABLE IF NOT EXISTS qso_history
+			(
+				id         BIGSERIAL PRIMARY KEY,
+				qso_id     BIGINT      NOT NULL REFERENCES qso (id) ON DELETE CASCADE,
+				logbook_id BIGINT      NOT NULL,
+				action     TEXT        NOT NULL,
+				actor      TEXT        NOT NULL DEFAULT '',
+				changes    TEXT        NOT NULL DEFAULT '{}',
+				created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+			)`,
+			`CREATE INDEX IF NOT EXISTS idx_qso_history_qso ON qso_history (qso_id, id)`,
+		},
+		sqlite: []string{
+			`CREATE TABLE IF NOT EXISTS qso_history
+			(
+				id         INTEGER   NOT NULL PRIMARY KEY AUTOINCREMENT,
+				qso_id     INTEGER   NOT NULL REFERENCES qso (id) ON DELETE CASCADE,
+				logbook_id INTEGER   NOT NULL,
+				action     TEXT      NOT NULL,
+				actor      TEXT      NOT NULL DEFAULT '',
+				changes    TEXT      NOT NULL DEFAULT '{}',
+				created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+			)`,
+			`CREATE INDEX IF NOT EXISTS idx_qso_history_qso ON qso_history (qso_id, id)`,
+		},
+		postgresDown: []string{
+			`DROP TABLE IF EXISTS qso_history`,
+		},
+		sqliteDown: []string{
+			`DROP TABLE IF EXISTS qso_history`,
+		},
+	},
+	{
+		version: 14,
+		name:    "account_deletions",
+		postgres: []string{
+			`CREATE TABLE IF NOT EXISTS account_deletions
+			(
+				user_id      BIGINT      NOT NULL PRIMARY KEY REFERENCES users (id) ON DELETE CASCADE,
+				requested_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+				purge_after  TIMESTAMPTZ NOT NULL
+			)`,
+			`CREATE INDEX IF NOT EXISTS idx_account_deletions_purge_after ON account_deletions (purge_after)`,
+		},
+		sqlite: []string{
+			`CREATE TABLE IF NOT EXISTS account_deletions
+			(
+				user_id      INTEGER   NOT NULL PRIMARY KEY REFERENCES users (id) ON DELETE CASCADE,
+				requested_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+				purge_after  TIMESTAMP NOT NULL
+			)`,
+			`CREATE INDEX IF NOT EXISTS idx_account_deletions_purge_after ON account_deletions (purge_after)`,
+		},
+		postgresDown: []string{
+			`DROP TABLE IF EXISTS account_deletions`,
+		},
+		sqliteDown: []string{
+			`DROP TABLE IF EXISTS account_deletions`,
+		},
+	},
+	{
+		// An archived logbook is read-only: its QSOs can be read and exported but not changed.
+		version: 15,
+		name:    "logbook_archive",
+		postgres: []string{
+			`ALTER TABLE logbook ADD COLUMN IF NOT EXISTS archived_at TIMESTAMPTZ`,
+		},
+		sqlite: []string{
+			`ALTER TABLE logbook ADD COLUMN archived_at TIMESTAMP`,
+		},
+		postgresDown: []string{
+			`ALTER TABLE logbook DROP COLUMN IF EXISTS archived_at`,
+		},
+		sqliteDown: []string{
+			`ALTER TABLE logbook DROP COLUMN archived_at`,
+		},
+	},
+	{
+		version: 16,
+		name:    "logbook_defaults",
+		postgres: []string{
+			`CREATE TABLE IF NOT EXISTS logbook_defaults
+			(
+				logbook_id    BIGINT PRIMARY KEY REFERENCES logbook (id) ON DELETE CASCADE,
+				my_gridsquare TEXT NOT NULL DEFAULT '',
+				tx_pwr        TEXT NOT NULL DEFAULT '',
+				my_rig        TEXT NOT NULL DEFAULT '',
+				operator      TEXT NOT NULL DEFAULT ''
+			)`,
+		},
+		sqlite: []string{
+			`CREATE TABLE IF NOT EXISTS logbook_defaults
+			(
+				logbook_id    INTEGER PRIMARY KEY REFERENCES logbook (id) ON DELETE CASCADE,
+				my_gridsquare TEXT NOT NULL DEFAULT '',
+				tx_pwr        TEXT NOT NULL DEFAULT '',
+				my_rig        TEXT NOT NULL DEFAULT '',
+				operator      TEXT NOT NULL DEFAULT ''
+			)`,
+		},
+		postgresDown: []string{
+			`DROP TABLE IF EXISTS logbook_defaults`,
+		},
+		sqliteDown: []string{
+			`DROP TABLE IF EXISTS logbook_defaults`,
+		},
+	},
+	{
+		version: 17,
+		name:    "share_links",
+		postgres: []string{
+			`CREATE TABLE IF NOT EXISTS share_links
+			(
+				id         BIGSERIAL PRIMARY KEY,
+				logbook_id BIGINT      NOT NULL REFERENCES logbook (id) ON DELETE CASCADE,
+				token_hash TEXT        NOT NULL UNIQUE,
+				label      TEXT        NOT NULL DEFAULT '',
+				created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+				expires_at TIMESTAMPTZ,
+				revoked_at TIMESTAMPTZ
+			)`,
+			`CREATE INDEX IF NOT EXISTS idx_share_links_logbook ON share_links (logbook_id)`,
+		},
+		sqlite: []string{
+			`CREATE TABLE IF NOT EXISTS share_links
+			(
+				id         INTEGER   NOT NULL PRIMARY KEY AUTOINCREMENT,
+				logbook_id INTEGER   NOT NULL REFERENCES logbook (id) ON DELETE CASCADE,
+				token_hash TEXT      NOT NULL UNIQUE,
+				label      TEXT      NOT NULL DEFAULT '',
+				created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+				expires_at TIMESTAMP,
+				revoked_at TIMESTAMP
+			)`,
+			`CREATE INDEX IF NOT EXISTS idx_share_links_logbook ON share_links (logbook_id)`,
+		},
+		postgresDown: []string{
+			`DROP TABLE IF EXISTS share_links`,
+		},
+		sqliteDown: []string{
+			`DROP TABLE IF EXISTS share_links`,
+		},
+	},
+	{
+		// A logbook's creator owns it through logbook.user_id; members are the other users who
+		// hold a role on it. An API key issued to a member records the member in user_id, and the
+		// one-active-key-per-logbook index only covers the logbook's own keys, so that each member
+		// can hold one active key as well.
+		version: 18,
+		name:    "logbook_members",
+		postgres: []string{
+			`CREATE TABLE IF NOT EXISTS logbook_members
+			(
+				logbook_id BIGINT      NOT NULL REFERENCES logbook (id) ON DELETE CASCADE,
+				user_id    BIGINT      NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+				role       TEXT        NOT NULL CHECK (role IN ('owner', 'operator', 'viewer')),
+				created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+				PRIMARY KEY (logbook_id, user_id)
+			)`,
+			`CREATE INDEX IF NOT EXISTS idx_logbook_members_user ON logbook_members (user_id)`,
+			`ALTER TABLE api_keys ADD COLUMN IF NOT EXISTS user_id BIGINT REFERENCES users (id) ON DELETE CASCADE`,
+			`DROP INDEX IF EXISTS idx_api_keys_one_active_per_logbook`,
+			`CREATE UNIQUE INDEX IF NOT EXISTS idx_api_keys_one_active_per_logbook ON api_keys (logbook_id) WHERE revoked_at IS NULL AND user_id IS NULL`,
+			`CREATE UNIQUE INDEX IF NOT EXISTS idx_api_keys_one_active_per_member ON api_keys (logbook_id, user_id) WHERE revoked_at IS NULL AND user_id IS NOT NULL`,
+		},
+		sqlite: []string{
+			`CREATE TABLE IF NOT EXISTS logbook_members
+			(
+				logbook_id INTEGER   NOT NULL REFERENCES logbook (id) ON DELETE CASCADE,
+				user_id    INTEGER   NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+				role       TEXT      NOT NULL CHECK (role IN ('owner', 'operator', 'viewer')),
+				created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+				PRIMARY KEY (logbook_id, user_id)
+			)`,
+			`CREATE INDEX IF NOT EXISTS idx_logbook_members_user ON logbook_members (user_id)`,
+			`ALTER TABLE api_keys ADD COLUMN user_id INTEGER REFERENCES users (id) ON DELETE CASCADE`,
+			`DROP INDEX IF EXISTS idx_api_keys_one_active_per_logbook`,
+			`CREATE UNIQUE INDEX IF NOT EXISTS idx_api_keys_one_active_per_logbook ON api_keys (logbook_id) WHERE revoked_at IS NULL AND user_id IS NULL`,
+			`CREATE UNIQUE INDEX IF NOT EXISTS idx_api_keys_one_active_per_member ON api_keys (logbook_id, user_id) WHERE revoked_at IS NULL AND user_id IS NOT NULL`,
+		},
+		postgresDown: []string{
+			`DELETE FROM api_keys WHERE user_id IS NOT NULL`,
+			`DROP INDEX IF EXISTS idx_api_keys_one_active_per_member`,
+			`DROP INDEX IF EXISTS idx_api_keys_one_active_per_logbook`,
+			`CREATE UNIQUE INDEX IF NOT EXISTS idx_api_keys_one_active_per_logbook ON api_keys (logbook_id) WHERE revoked_at IS NULL`,
+			`ALTER TABLE api_keys DROP COLUMN IF EXISTS user_id`,
+			`DROP TABLE IF EXISTS logbook_members`,
+		},
+		sqliteDown: []string{
+			`DELETE FROM api_keys WHERE user_id IS NOT NULL`,
+			`DROP INDEX IF EXISTS idx_api_keys_one_active_per_member`,
+			`DROP INDEX IF EXISTS idx_api_keys_one_active_per_logbook`,
+			`CREATE UNIQUE INDEX IF NOT EXISTS idx_api_keys_one_active_per_logbook ON api_keys (logbook_id) WHERE revoked_at IS NULL`,
+			`ALTER TABLE api_keys DROP COLUMN user_id`,
+			`DROP TABLE IF EXISTS logbook_members`,
+		},
+	},
+	{
+		// Admins are users who may call the /api/admin routes; each has a TOTP secret, encrypted
+		// with the credentials key, for the second factor. last_otp_step stops a code being
+		// replayed within its validity window.
+		version: 19,
+		name:    "admins",
+		postgres: []string{
+			`CREATE TABLE IF NOT EXISTS admins
+			(
+				user_id       BIGINT      NOT NULL PRIMARY KEY REFERENCES users (id) ON DELETE CASCADE,
+				totp_secret   TEXT        NOT NULL,
+				last_otp_step BIGINT      NOT NULL DEFAULT 0,
+				created_at    TIMESTAMPTZ NOT NULL DEFAULT NOW()
+			)`,
+			`ALTER TABLE users ADD COLUMN IF NOT EXISTS disabled_at TIMESTAMPTZ`,
+		},
+		sqlite: []string{
+			`CREATE TABLE IF NOT EXISTS admins
+			(
+				user_id       INTEGER   NOT NULL PRIMARY KEY REFERENCES users (id) ON DELETE CASCADE,
+				totp_secret   TEXT      NOT NULL,
+				last_otp_step INTEGER   NOT NULL DEFAULT 0,
+				created_at    TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+			)`,
+			`ALTER TABLE users ADD COLUMN disabled_at TIMESTAMP`,
+		},
+		postgresDown: []string{
+			`ALTER TABLE users DROP COLUMN IF EXISTS disabled_at`,
+			`DROP TABLE IF EXISTS admins`,
+		},
+		sqliteDown: []string{
+			`ALTER TABLE users DROP COLUMN disabled_at`,
+			`DROP TABLE IF EXISTS admins`,
+		},
+	},
+	{
+		// Security-relevant events. Entries name users and logbooks by value rather than by
+		// reference, so that they outlive what they describe.
+		version: 20,
+		name:    "audit_log",
+		postgres: []string{
+			`CREATE TABLE IF NOT EXISTS audit_log
+			(
+				id         BIGSERIAL PRIMARY KEY,
+				created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+				event      TEXT        NOT NULL,
+				actor      TEXT        NOT NULL DEFAULT '',
+				callsign   TEXT        NOT NULL DEFAULT '',
+				logbook_id BIGINT,
+				ip         TEXT        NOT NULL DEFAULT '',
+				details    TEXT        NOT NULL DEFAULT ''
+			)`,
+			`CREATE INDEX IF NOT EXISTS audit_log_created_at_idx ON audit_log (created_at)`,
+			`CREATE INDEX IF NOT EXISTS audit_log_callsign_idx ON audit_log (callsign, id)`,
+		},
+		sqlite: []string{
+			`CREATE TABLE IF NOT EXISTS audit_log
+			(
+				id         INTEGER PRIMARY KEY AUTOINCREMENT,
+				created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+				event      TEXT      NOT NULL,
+				actor      TEXT      NOT NULL DEFAULT '',
+				callsign   TEXT      NOT NULL DEFAULT '',
+				logbook_id INTEGER,
+				ip         TEXT      NOT NULL DEFAULT '',
+				details    TEXT      NOT NULL DEFAULT ''
+			)`,
+			`CREATE INDEX IF NOT EXISTS audit_log_created_at_idx ON audit_log (created_at)`,
+			`CREATE INDEX IF NOT EXISTS audit_log_callsign_idx ON audit_log (callsign, id)`,
+		},
+		postgresDown: []string{
+			`DROP TABLE IF EXISTS audit_log`,
+		},
+		sqliteDown: []string{
+			`DROP TABLE IF EXISTS audit_log`,
+		},
+	},
+	{
+		// Offline clients name QSOs by UUIDs they generate themselves, and pull the changes made
+		// since their last sync from the QSO history.
+		version: 21,
+		name:    "qso_sync",
+		postgres: []string{
+			`CREATE TABLE IF NOT EXISTS qso_uuids
+			(
+				qso_id     BIGINT NOT NULL PRIMARY KEY REFERENCES qso (id) ON DELETE CASCADE,
+				logbook_id BIGINT NOT NULL,
+				uuid       TEXT   NOT NULL
+			)`,
+			`CREATE UNIQUE INDEX IF NOT EXISTS idx_qso_uuids_uuid ON qso_uuids (logbook_id, uuid)`,
+			`CREATE INDEX IF NOT EXISTS idx_qso_history_logbook ON qso_history (logbook_id, id)`,
+		},
+		sqlite: []string{
+			`CREATE TABLE IF NOT EXISTS qso_uuids
+			(
+				qso_id     INTEGER NOT NULL PRIMARY KEY REFERENCES qso (id) ON DELETE CASCADE,
+				logbook_id INTEGER NOT NULL,
+				uuid       TEXT    NOT NULL
+			)`,
+			`CREATE UNIQUE INDEX IF NOT EXISTS idx_qso_uuids_uuid ON qso_uuids (logbook_id, uuid)`,
+			`CREATE INDEX IF NOT EXISTS idx_qso_history_logbook ON qso_history (logbook_id, id)`,
+		},
+		postgresDown: []string{
+			`DROP INDEX IF EXISTS idx_qso_history_logbook`,
+			`DROP TABLE IF EXISTS qso_uuids`,
+		},
+		sqliteDown: []string{
+			`DROP INDEX IF EXISTS idx_qso_history_logbook`,
+			`DROP TABLE IF EXISTS qso_uuids`,
+		},
+	},
+	{
+		version: 22,
+		name:    "logbook_uuids",
+		postgres: []string{
+			`CREATE TABLE IF NOT EXISTS logbook_uuids
+			(
+				logbook_id BIGINT NOT NULL PRIMARY KEY REFERENCES logbook (id) ON DELETE CASCADE,
+				uuid       TEXT   NOT NULL UNIQUE
+			)`,
+		},
+		sqlite: []string{
+			`CREATE TABLE IF NOT EXISTS logbook_uuids
+			(
+				logbook_id INTEGER NOT NULL PRIMARY KEY REFERENCES logbook (id) ON DELETE CASCADE,
+				uuid       TEXT    NOT NULL UNIQUE
+			)`,
+		},
+		postgresDown: []string{
+			`DROP TABLE IF EXISTS logbook_uuids`,
+		},
+		sqliteDown: []string{
+			`DROP TABLE IF EXISTS logbook_uuids`,
+		},
+	},
+	{
+		// Background jobs. A job is claimed by setting it running with a lease; a job whose lease
+		// ran out, because its server stopped, is claimed again.
+		version: 23,
+		name:    "jobs",
+		postgres: []string{
+			`CREATE TABLE IF NOT EXISTS jobs
+			(
+				id           BIGSERIAL PRIMARY KEY,
+				kind         TEXT        NOT NULL,
+				logbook_id   BIGINT REFERENCES logbook (id) ON DELETE CASCADE,
+				payload      TEXT        NOT NULL DEFAULT '',
+				status       TEXT        NOT NULL DEFAULT 'queued',
+				attempts     INTEGER     NOT NULL DEFAULT 0,
+				max_attempts INTEGER     NOT NULL,
+				last_error   TEXT        NOT NULL DEFAULT '',
+				result       TEXT        NOT NULL DEFAULT '',
+				created_at   TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+				run_at       TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+				lease_until  TIMESTAMPTZ,
+				finished_at  TIMESTAMPTZ
+			)`,
+			`CREATE INDEX IF NOT EXISTS idx_jobs_due ON jobs (status, run_at)`,
+			`CREATE INDEX IF NOT EXISTS idx_jobs_logbook ON jobs (logbook_id, id)`,
+		},
+		sqlite: []string{
+			`CREATE TABLE IF NOT EXISTS jobs
+			(
+				id           INTEGER PRIMARY KEY AUTOINCREMENT,
+				kind         TEXT      NOT NULL,
+				logbook_id   INTEGER REFERENCES logbook (id) ON DELETE CASCADE,
+				payload      TEXT      NOT NULL DEFAULT '',
+				status       TEXT      NOT NULL DEFAULT 'queued',
+				attempts     INTEGER   NOT NULL DEFAULT 0,
+				max_attempts INTEGER   NOT NULL,
+				last_error   TEXT      NOT NULL DEFAULT '',
+				result       TEXT      NOT NULL DEFAULT '',
+				created_at   TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+				run_at       TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+				lease_until  TIMESTAMP,
+				finished_at  TIMESTAMP
+			)`,
+			`CREATE INDEX IF NOT EXISTS idx_jobs_due ON jobs (status, run_at)`,
+			`CREATE INDEX IF NOT EXISTS idx_jobs_logbook ON jobs (logbook_id, id)`,
+		},
+		postgresDown: []string{
+			`DROP TABLE IF EXISTS jobs`,
+		},
+		sqliteDown: []string{
+			`DROP TABLE IF EXISTS jobs`,
+		},
+	},
+	{
+		// How far a running job has got, as reported by the job; kept once it finishes.
+		version: 24,
+		name:    "job_progress",
+		postgres: []string{
+			`ALTER TABLE jobs ADD COLUMN IF NOT EXISTS progress TEXT NOT NULL DEFAULT ''`,
+		},
+		sqlite: []string{
+			`ALTER TABLE jobs ADD COLUMN progress TEXT NOT NULL DEFAULT ''`,
+		},
+		postgresDown: []string{
+			`ALTER TABLE jobs DROP COLUMN IF EXISTS progress`,
+		},
+		sqliteDown: []string{
+			`ALTER TABLE jobs DROP COLUMN progress`,
+		},
+	},
+	{
+		// Events for the integrations, written in the transaction that stores the QSO and marked
+		// once handed over, so that none is lost when the server stops in between.
+		version: 25,
+		name:    "outbox",
+		postgres: []string{
+			`CREATE TABLE IF NOT EXISTS outbox
+			(
+				id            BIGSERIAL PRIMARY KEY,
+				logbook_id    BIGINT      NOT NULL REFERENCES logbook (id) ON DELETE CASCADE,
+				qso_id        BIGINT      NOT NULL,
+				event_type    TEXT        NOT NULL,
+				created_at    TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+				dispatched_at TIMESTAMPTZ
+			)`,
+			`CREATE INDEX IF NOT EXISTS idx_outbox_pending ON outbox (id) WHERE dispatched_at IS NULL`,
+		},
+		sqlite: []string{
+			`CREATE TABLE IF NOT EXISTS outbox
+			(
+				id            INTEGER PRIMARY KEY AUTOINCREMENT,
+				logbook_id    INTEGER   NOT NULL REFERENCES logbook (id) ON DELETE CASCADE,
+				qso_id        INTEGER   NOT NULL,
+				event_type    TEXT      NOT NULL,
+				created_at    TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+				dispatched_at TIMESTAMP
+			)`,
+			`CREATE INDEX IF NOT EXISTS idx_outbox_pending ON outbox (id) WHERE dispatched_at IS NULL`,
+		},
+		postgresDown: []string{
+			`DROP TABLE IF EXISTS outbox`,
+		},
+		sqliteDown: []string{
+			`DROP TABLE IF EXISTS outbox`,
+		},
+	},
+	{
+		// Scanned QSL cards attached to QSOs. The images are kept in object storage; the rows
+		// outlive their QSOs until the purge removes both, so there are no foreign keys.
+		version: 26,
+		name:    "qsl_images",
+		postgres: []string{
+			`CREATE TABLE IF NOT EXISTS qsl_images
+			(
+				id             BIGSERIAL PRIMARY KEY,
+				logbook_id     BIGINT      NOT NULL,
+				qso_id         BIGINT      NOT NULL,
+				side           TEXT        NOT NULL,
+				content_type   TEXT        NOT NULL,
+				size           BIGINT      NOT NULL,
+				width          INTEGER     NOT NULL,
+				height         INTEGER     NOT NULL,
+				object_name    TEXT        NOT NULL,
+				thumbnail_name TEXT        NOT NULL,
+				created_at     TIMESTAMPTZ NOT NULL DEFAULT NOW()
+			)`,
+			`CREATE INDEX IF NOT EXISTS idx_qsl_images_qso ON qsl_images (qso_id, id)`,
+		},
+		sqlite: []string{
+			`CREATE TABLE IF NOT EXISTS qsl_images
+			(
+				id             INTEGER PRIMARY KEY AUTOINCREMENT,
+				logbook_id     INTEGER   NOT NULL,
+				qso_id         INTEGER   NOT NULL,
+				side           TEXT      NOT NULL,
+				content_type   TEXT      NOT NULL,
+				size           INTEGER   NOT NULL,
+				width          INTEGER   NOT NULL,
+				height         INTEGER   NOT NULL,
+				object_name    TEXT      NOT NULL,
+				thumbnail_name TEXT      NOT NULL,
+				created_at     TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+			)`,
+			`CREATE INDEX IF NOT EXISTS idx_qsl_images_qso ON qsl_images (qso_id, id)`,
+		},
+		postgresDown: []string{
+			`DROP TABLE IF EXISTS qsl_images`,
+		},
+		sqliteDown: []string{
+			`DROP TABLE IF EXISTS qsl_images`,
+		},
+	},
+	{
+		// The contest a logbook is running, if any, and the QSOs logged in it with what each
+		// scores. A QSO's row is dropped with the contest, not with the QSO, so scoring joins qso.
+		version: 27,
+		name:    "contest_mode",
+		postgres: []string{
+			`CREATE TABLE IF NOT EXISTS contests
+			(
+				logbook_id  BIGINT PRIMARY KEY REFERENCES logbook (id) ON DELETE CASCADE,
+				contest_id  TEXT        NOT NULL,
+				rules       TEXT        NOT NULL,
+				next_serial INTEGER     NOT NULL DEFAULT 1,
+				started_at  TIMESTAMPTZ NOT NULL DEFAULT NOW()
+			)`,
+			`CREATE TABLE IF NOT EXISTS contest_qsos
+			(
+				qso_id      BIGINT PRIMARY KEY,
+				logbook_id  BIGINT      NOT NULL REFERENCES logbook (id) ON DELETE CASCADE,
+				call        TEXT        NOT NULL,
+				band        TEXT        NOT NULL,
+				mode        TEXT        NOT NULL,
+				points      INTEGER     NOT NULL,
+				multipliers TEXT        NOT NULL DEFAULT '',
+				created_at  TIMESTAMPTZ NOT NULL DEFAULT NOW()
+			)`,
+			`CREATE INDEX IF NOT EXISTS idx_contest_qsos_call ON contest_qsos (logbook_id, call)`,
+		},
+		sqlite: []string{
+			`CREATE TABLE IF NOT EXISTS contests
+			(
+				logbook_id  INTEGER PRIMARY KEY REFERENCES logbook (id) ON DELETE CASCADE,
+				contest_id  TEXT      NOT NULL,
+				rules       TEXT      NOT NULL,
+				next_serial INTEGER   NOT NULL DEFAULT 1,
+				started_at  TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+			)`,
+			`CREATE TABLE IF NOT EXISTS contest_qsos
+			(
+				qso_id      INTEGER PRIMARY KEY,
+				logbook_id  INTEGER   NOT NULL REFERENCES logbook (id) ON DELETE CASCADE,
+				call        TEXT      NOT NULL,
+				band        TEXT      NOT NULL,
+				mode        TEXT      NOT NULL,
+				points      INTEGER   NOT NULL,
+				multipliers TEXT      NOT NULL DEFAULT '',
+				created_at  TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+			)`,
+			`CREATE INDEX IF NOT EXISTS idx_contest_qsos_call ON contest_qsos (logbook_id, call)`,
+		},
+		postgresDown: []string{
+			`DROP TABLE IF EXISTS contest_qsos`,
+			`DROP TABLE IF EXISTS contests`,
+		},
+		sqliteDown: []string{
+			`DROP TABLE IF EXISTS contest_qsos`,
+			`DROP TABLE IF EXISTS contests`,
+		},
+	},
+	{
+		version: 28,
+		name:    "spot_alerts",
+		postgres: []string{
+			`CREATE TABLE IF NOT EXISTS spot_alerts
+			(
+				logbook_id BIGINT PRIMARY KEY REFERENCES logbook (id) ON DELETE CASCADE,
+				awards     TEXT        NOT NULL,
+				need       TEXT        NOT NULL,
+				bands      TEXT        NOT NULL DEFAULT '',
+				modes      TEXT        NOT NULL DEFAULT '',
+				webhook    BOOLEAN     NOT NULL DEFAULT FALSE,
+				email      TEXT        NOT NULL DEFAULT '',
+				created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+			)`,
+		},
+		sqlite: []string{
+			`CREATE TABLE IF NOT EXISTS spot_alerts
+			(
+				logbook_id INTEGER PRIMARY KEY REFERENCES logbook (id) ON DELETE CASCADE,
+				awards     TEXT      NOT NULL,
+				need       TEXT      NOT NULL,
+				bands      TEXT      NOT NULL DEFAULT '',
+				modes      TEXT      NOT NULL DEFAULT '',
+				webhook    BOOLEAN   NOT NULL DEFAULT FALSE,
+				email      TEXT      NOT NULL DEFAULT '',
+				created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+			)`,
+		},
+		postgresDown: []string{
+			`DROP TABLE IF EXISTS spot_alerts`,
+		},
+		sqliteDown: []string{
+			`DROP TABLE IF EXISTS spot_alerts`,
+		},
+	},
+	{
+		version: 29,
+		name:    "solar_reports",
+		postgres: []string{
+			`CREATE TABLE IF NOT EXISTS solar_reports
+			(
+				updated_at TIMESTAMPTZ PRIMARY KEY,
+				solar_flux INTEGER     NOT NULL,
+				a_index    INTEGER     NOT NULL,
+				k_index    INTEGER     NOT NULL,
+				sunspots   INTEGER     NOT NULL,
+				report     TEXT        NOT NULL,
+				fetched_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+			)`,
+		},
+		sqlite: []string{
+			`CREATE TABLE IF NOT EXISTS solar_reports
+			(
+				updated_at TIMESTAMP PRIMARY KEY,
+				solar_flux INTEGER   NOT NULL,
+				a_index    INTEGER   NOT NULL,
+				k_index    INTEGER   NOT NULL,
+				sunspots   INTEGER   NOT NULL,
+				report     TEXT      NOT NULL,
+				fetched_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+			)`,
+		},
+		postgresDown: []string{
+			`DROP TABLE IF EXISTS solar_reports`,
+		},
+		sqliteDown: []string{
+			`DROP TABLE IF EXISTS solar_reports`,
+		},
+	},
+	{
+		version: 30,
+		name:    "station_profiles",
+		postgres: []string{
+			`CREATE TABLE IF NOT EXISTS station_profiles
+			(
+				id            BIGSERIAL PRIMARY KEY,
+				logbook_id    BIGINT      NOT NULL REFERENCES logbook (id) ON DELETE CASCADE,
+				name          TEXT        NOT NULL,
+				is_default    BOOLEAN     NOT NULL DEFAULT FALSE,
+				my_gridsquare TEXT        NOT NULL DEFAULT '',
+				my_lat        TEXT        NOT NULL DEFAULT '',
+				my_lon        TEXT        NOT NULL DEFAULT '',
+				my_altitude   TEXT        NOT NULL DEFAULT '',
+				my_city       TEXT        NOT NULL DEFAULT '',
+				my_country    TEXT        NOT NULL DEFAULT '',
+				my_dxcc       TEXT        NOT NULL DEFAULT '',
+				my_cq_zone    TEXT        NOT NULL DEFAULT '',
+				my_itu_zone   TEXT        NOT NULL DEFAULT '',
+				my_iota       TEXT        NOT NULL DEFAULT '',
+				my_sig        TEXT        NOT NULL DEFAULT '',
+				my_sig_info   TEXT        NOT NULL DEFAULT '',
+				my_wwff_ref   TEXT        NOT NULL DEFAULT '',
+				my_rig        TEXT        NOT NULL DEFAULT '',
+				my_antenna    TEXT        NOT NULL DEFAULT '',
+				tx_pwr        TEXT        NOT NULL DEFAULT '',
+				operator      TEXT        NOT NULL DEFAULT '',
+				created_at    TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+				CONSTRAINT station_profiles_name_per_logbook UNIQUE (logbook_id, name)
+			)`,
+			`CREATE UNIQUE INDEX IF NOT EXISTS idx_station_profiles_one_default ON station_profiles (logbook_id) WHERE is_default`,
+			`CREATE TABLE IF NOT EXISTS station_profile_keys
+			(
+				key_prefix TEXT   PRIMARY KEY,
+				profile_id BIGINT NOT NULL REFERENCES station_profiles (id) ON DELETE CASCADE
+			)`,
+		},
+		sqlite: []string{
+			`CREATE TABLE IF NOT EXISTS station_profiles
+			(
+				id            INTEGER   NOT NULL PRIMARY KEY AUTOINCREMENT,
+				logbook_id    INTEGER   NOT NULL REFERENCES logbook (id) ON DELETE CASCADE,
+				name          TEXT      NOT NULL,
+				is_default    BOOLEAN   NOT NULL DEFAULT FALSE,
+				my_gridsquare TEXT      NOT NULL DEFAULT '',
+				my_lat        TEXT      NOT NULL DEFAULT '',
+				my_lon        TEXT      NOT NULL DEFAULT '',
+				my_altitude   TEXT      NOT NULL DEFAULT '',
+				my_city       TEXT      NOT NULL DEFAULT '',
+				my_country    TEXT      NOT NULL DEFAULT '',
+				my_dxcc       TEXT      NOT NULL DEFAULT '',
+				my_cq_zone    TEXT      NOT NULL DEFAULT '',
+				my_itu_zone   TEXT      NOT NULL DEFAULT '',
+				my_iota       TEXT      NOT NULL DEFAULT '',
+				my_sig        TEXT      NOT NULL DEFAULT '',
+				my_sig_info   TEXT      NOT NULL DEFAULT '',
+				my_wwff_ref   TEXT      NOT NULL DEFAULT '',
+				my_rig        TEXT      NOT NULL DEFAULT '',
+				my_antenna    TEXT      NOT NULL DEFAULT '',
+				tx_pwr        TEXT      NOT NULL DEFAULT '',
+				operator      TEXT      NOT NULL DEFAULT '',
+				created_at    TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+				CONSTRAINT station_profiles_name_per_logbook UNIQUE (logbook_id, name)
+			)`,
+			`CREATE UNIQUE INDEX IF NOT EXISTS idx_station_profiles_one_default ON station_profiles (logbook_id) WHERE is_default`,
+			`CREATE TABLE IF NOT EXISTS station_profile_keys
+			(
+				key_prefix TEXT    PRIMARY KEY,
+				profile_id INTEGER NOT NULL REFERENCES station_profiles (id) ON DELETE CASCADE
+			)`,
+		},
+		postgresDown: []string{
+			`DROP TABLE IF EXISTS station_profile_keys`,
+			`DROP TABLE IF EXISTS station_profiles`,
+		},
+		sqliteDown: []string{
+			`DROP TABLE IF EXISTS station_profile_keys`,
+			`DROP TABLE IF EXISTS station_profiles`,
+		},
+	},
+	{
+		version: 31,
+		name:    "qso_time_zones",
+		postgres: []string{
+			`ALTER TABLE logbook_defaults ADD COLUMN IF NOT EXISTS time_zone TEXT NOT NULL DEFAULT ''`,
+			`ALTER TABLE logbook_defaults ADD COLUMN IF NOT EXISTS ambiguous_time TEXT NOT NULL DEFAULT ''`,
+			`CREATE TABLE IF NOT EXISTS user_preferences
+			(
+				user_id   BIGINT PRIMARY KEY REFERENCES users (id) ON DELETE CASCADE,
+				time_zone TEXT   NOT NULL DEFAULT ''
+			)`,
+		},
+		sqlite: []string{
+			`ALTER TABLE logbook_defaults ADD COLUMN time_zone TEXT NOT NULL DEFAULT ''`,
+			`ALTER TABLE logbook_defaults ADD COLUMN ambiguous_time TEXT NOT NULL DEFAULT ''`,
+			`CREATE TABLE IF NOT EXISTS user_preferences
+			(
+				user_id   INTEGER PRIMARY KEY REFERENCES users (id) ON DELETE CASCADE,
+				time_zone TEXT    NOT NULL DEFAULT ''
+			)`,
+		},
+		postgresDown: []string{
+			`DROP TABLE IF EXISTS user_preferences`,
+			`ALTER TABLE logbook_defaults DROP COLUMN IF EXISTS ambiguous_time`,
+			`ALTER TABLE logbook_defaults DROP COLUMN IF EXISTS time_zone`,
+		},
+		sqliteDown: []string{
+			`DROP TABLE IF EXISTS user_preferences`,
+			`ALTER TABLE logbook_defaults DROP COLUMN ambiguous_time`,
+			`ALTER TABLE logbook_defaults DROP COLUMN time_zone`,
+		},
+	},
+	{
+		// Pages of a logbook's QSOs in time order seek on (qso_date, time_on, id).
+		version: 32,
+		name:    "qso_keyset_index",
+		postgres: []string{
+			`CREATE INDEX IF NOT EXISTS qso_logbook_keyset_idx ON qso (logbook_id, qso_date, time_on, id) WHERE deleted_at IS NULL`,
+		},
+		sqlite: []string{
+			`CREATE INDEX IF NOT EXISTS qso_logbook_keyset_idx ON qso (logbook_id, qso_date, time_on, id) WHERE deleted_at IS NULL`,
+		},
+		postgresDown: []string{
+			`DROP INDEX IF EXISTS qso_logbook_keyset_idx`,
+		},
+		sqliteDown: []string{
+			`DROP INDEX IF EXISTS qso_logbook_keyset_idx`,
+		},
+	},
+	{
+		// The adapter stores the free-text fields in additional_data under their Go field names.
+		version: 33,
+		name:    "qso_full_text_search",
+		postgres: []string{
+			`ALTER TABLE qso ADD COLUMN IF NOT EXISTS search_vector tsvector GENERATED ALWAYS AS (to_tsvector('simple',
+				coalesce(additional_data->>'Comment', '') || ' ' || coalesce(additional_data->>'Notes', '') || ' ' ||
+				coalesce(additional_data->>'Name', '') || ' ' || coalesce(additional_data->>'QTH', ''))) STORED`,
+			`CREATE INDEX IF NOT EXISTS qso_search_vector_idx ON qso USING gin (search_vector)`,
+		},
+		sqlite: []string{
+			`CREATE VIRTUAL TABLE IF NOT EXISTS qso_fts USING fts5(comment, notes, name, qth, tokenize = 'unicode61 remove_diacritics 2')`,
+			`INSERT INTO qso_fts (rowid, comment, notes, name, qth)
+				SELECT id, coalesce(json_extract(additional_data, '$.Comment'), ''), coalesce(json_extract(additional_data, '$.Notes'), ''), coalesce(json_extract(additional_data, '$.Name'), ''), coalesce(json_extract(additional_data, '$.QTH'), '') FROM qso`,
+			`CREATE TRIGGER IF NOT EXISTS qso_fts_insert AFTER INSERT ON qso BEGIN
+				INSERT INTO qso_fts (rowid, comment, notes, name, qth) VALUES (new.id, coalesce(json_extract(new.additional_data, '$.Comment'), ''), coalesce(json_extract(new.additional_data, '$.Notes'), ''), coalesce(json_extract(new.additional_data, '$.Name'), ''), coalesce(json_extract(new.additional_data, '$.QTH'), ''));
+			END`,
+			`CREATE TRIGGER IF NOT EXISTS qso_fts_update AFTER UPDATE OF additional_data ON qso BEGIN
+				DELETE FROM qso_fts WHERE rowid = old.id;
+				INSERT INTO qso_fts (rowid, comment, notes, name, qth) VALUES (new.id, coalesce(json_extract(new.additional_data, '$.Comment'), ''), coalesce(json_extract(new.additional_data, '$.Notes'), ''), coalesce(json_extract(new.additional_data, '$.Name'), ''), coalesce(json_extract(new.additional_data, '$.QTH'), ''));
+			END`,
+			`CREATE TRIGGER IF NOT EXISTS qso_fts_delete AFTER DELETE ON qso BEGIN
+				DELETE FROM qso_fts WHERE rowid = old.id;
+			END`,
+		},
+		postgresDown: []string{
+			`DROP INDEX IF EXISTS qso_search_vector_idx`,
+			`ALTER TABLE qso DROP COLUMN IF EXISTS search_vector`,
+		},
+		sqliteDown: []string{
+			`DROP TRIGGER IF EXISTS qso_fts_delete`,
+			`DROP TRIGGER IF EXISTS qso_fts_update`,
+			`DROP TRIGGER IF EXISTS qso_fts_insert`,
+			`DROP TABLE IF EXISTS qso_fts`,
+		},
+	},
+	{
+		// Usage is counted per logbook, API key prefix and UTC day (YYYY-MM-DD); see usage.go.
+		version: 34,
+		name:    "api_usage",
+		postgres: []string{
+			`CREATE TABLE IF NOT EXISTS api_usage (
+				logbook_id BIGINT NOT NULL REFERENCES logbook (id) ON DELETE CASCADE,
+				key_prefix TEXT   NOT NULL,
+				day        TEXT   NOT NULL,
+				requests   BIGINT NOT NULL DEFAULT 0,
+				inserts    BIGINT NOT NULL DEFAULT 0,
+				errors     BIGINT NOT NULL DEFAULT 0,
+				PRIMARY KEY (logbook_id, key_prefix, day)
+			)`,
+			`CREATE INDEX IF NOT EXISTS api_usage_day_idx ON api_usage (day, requests)`,
+		},
+		sqlite: []string{
+			`CREATE TABLE IF NOT EXISTS api_usage (
+				logbook_id INTEGER NOT NULL REFERENCES logbook (id) ON DELETE CASCADE,
+				key_prefix TEXT    NOT NULL,
+				day        TEXT    NOT NULL,
+				requests   INTEGER NOT NULL DEFAULT 0,
+				inserts    INTEGER NOT NULL DEFAULT 0,
+				errors     INTEGER NOT NULL DEFAULT 0,
+				PRIMARY KEY (logbook_id, key_prefix, day)
+			)`,
+			`CREATE INDEX IF NOT EXISTS api_usage_day_idx ON api_usage (day, requests)`,
+		},
+		postgresDown: []string{
+			`DROP TABLE IF EXISTS api_usage`,
+		},
+		sqliteDown: []string{
+			`DROP TABLE IF EXISTS api_usage`,
+		},
+	},
+	{
+		// The secret an API key's requests are signed with, encrypted with the credentials key.
+		version: 35,
+		name:    "api_key_signing_secrets",
+		postgres: []string{
+			`CREATE TABLE IF NOT EXISTS api_key_signing_secrets
+			(
+				key_prefix TEXT PRIMARY KEY,
+				logbook_id BIGINT      NOT NULL REFERENCES logbook (id) ON DELETE CASCADE,
+				secret     TEXT        NOT NULL,
+				created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+			)`,
+		},
+		sqlite: []string{
+			`CREATE TABLE IF NOT EXISTS api_key_signing_secrets
+			(
+				key_prefix TEXT PRIMARY KEY,
+				logbook_id INTEGER   NOT NULL REFERENCES logbook (id) ON DELETE CASCADE,
+				secret     TEXT      NOT NULL,
+				created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+			)`,
+		},
+		postgresDown: []string{
+			`DROP TABLE IF EXISTS api_key_signing_secrets`,
+		},
+		sqliteDown: []string{
+			`DROP TABLE IF EXISTS api_key_signing_secrets`,
+		},
+	},
+	{
+		// Sandbox logbooks, where the sandbox keys of their parent logbook write. The parent has no
+		// foreign key, so that sandboxes outliving their parent can be found and purged.
+		version: 36,
+		name:    "sandbox_logbooks",
+		postgres: []string{
+			`CREATE TABLE IF NOT EXISTS sandbox_logbooks
+			(
+				logbook_id BIGINT PRIMARY KEY REFERENCES logbook (id) ON DELETE CASCADE,
+				parent_id  BIGINT      NOT NULL UNIQUE,
+				created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+			)`,
+		},
+		sqlite: []string{
+			`CREATE TABLE IF NOT EXISTS sandbox_logbooks
+			(
+				logbook_id INTEGER PRIMARY KEY REFERENCES logbook (id) ON DELETE CASCADE,
+				parent_id  INTEGER   NOT NULL UNIQUE,
+				created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+			)`,
+		},
+		postgresDown: []string{
+			`DROP TABLE IF EXISTS sandbox_logbooks`,
+		},
+		sqliteDown: []string{
+			`DROP TABLE IF EXISTS sandbox_logbooks`,
+		},
+	},
+	{
+		// The other QSO of a history entry that concerns two, such as a merge.
+		version: 37,
+		name:    "qso_history_related",
+		postgres: []string{
+			`ALTER TABLE qso_history ADD COLUMN IF NOT EXISTS related_qso_id BIGINT`,
+		},
+		sqlite: []string{
+			`ALTER TABLE qso_history ADD COLUMN related_qso_id INTEGER`,
+		},
+		postgresDown: []string{
+			`ALTER TABLE qso_history DROP COLUMN IF EXISTS related_qso_id`,
+		},
+		sqliteDown: []string{
+			`ALTER TABLE qso_history DROP COLUMN related_qso_id`,
+		},
+	},
+	{
+		// The imports of a logbook, and the import that stored each QSO. import_batch_id has no
+		// foreign key, so that SQLite can drop it again; a batch goes only with its logbook's QSOs.
+		version: 38,
+		name:    "import_batches",
+		postgres: []string{
+			`CREATE TABLE IF NOT EXISTS import_batches
+			(
+				id         BIGSERIAL PRIMARY KEY,
+				logbook_id BIGINT      NOT NULL REFERENCES logbook (id) ON DELETE CASCADE,
+				source     TEXT        NOT NULL,
+				actor      TEXT        NOT NULL DEFAULT '',
+				received   INTEGER     NOT NULL DEFAULT 0,
+				imported   INTEGER     NOT NULL DEFAULT 0,
+				duplicates INTEGER     NOT NULL DEFAULT 0,
+				rejected   INTEGER     NOT NULL DEFAULT 0,
+				created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+				undone_at  TIMESTAMPTZ
+			)`,
+			`CREATE INDEX IF NOT EXISTS idx_import_batches_logbook ON import_batches (logbook_id, id)`,
+			`ALTER TABLE qso ADD COLUMN IF NOT EXISTS import_batch_id BIGINT`,
+			`CREATE INDEX IF NOT EXISTS idx_qso_import_batch ON qso (import_batch_id) WHERE import_batch_id IS NOT NULL`,
+		},
+		sqlite: []string{
+			`CREATE TABLE IF NOT EXISTS import_batches
+			(
+				id         INTEGER PRIMARY KEY AUTOINCREMENT,
+				logbook_id INTEGER   NOT NULL REFERENCES logbook (id) ON DELETE CASCADE,
+				source     TEXT      NOT NULL,
+				actor      TEXT      NOT NULL DEFAULT '',
+				received   INTEGER   NOT NULL DEFAULT 0,
+				imported   INTEGER   NOT NULL DEFAULT 0,
+				duplicates INTEGER   NOT NULL DEFAULT 0,
+				rejected   INTEGER   NOT NULL DEFAULT 0,
+				created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+				undone_at  TIMESTAMP
+			)`,
+			`CREATE INDEX IF NOT EXISTS idx_import_batches_logbook ON import_batches (logbook_id, id)`,
+			`ALTER TABLE qso ADD COLUMN import_batch_id INTEGER`,
+			`CREATE INDEX IF NOT EXISTS idx_qso_import_batch ON qso (import_batch_id) WHERE import_batch_id IS NOT NULL`,
+		},
+		postgresDown: []string{
+			`DROP INDEX IF EXISTS idx_qso_import_batch`,
+			`ALTER TABLE qso DROP COLUMN IF EXISTS import_batch_id`,
+			`DROP TABLE IF EXISTS import_batches`,
+		},
+		sqliteDown: []string{
+			`DROP INDEX IF EXISTS idx_qso_import_batch`,
+			`ALTER TABLE qso DROP COLUMN import_batch_id`,
+			`DROP TABLE IF EXISTS import_batches`,
+		},
+	},
+	{
+		// lease_token is set by the worker that claims a job, so that a worker whose lease ran out
+		// cannot record an outcome over the worker that took the job over. account_exports holds
+		// the archive written by each user's latest account export job.
+		version: 39,
+		name:    "job_leases_and_account_exports",
+		postgres: []string{
+			`ALTER TABLE jobs ADD COLUMN IF NOT EXISTS lease_token TEXT NOT NULL DEFAULT ''`,
+			`CREATE TABLE IF NOT EXISTS account_exports
+			(
+				user_id     BIGINT      NOT NULL PRIMARY KEY REFERENCES users (id) ON DELETE CASCADE,
+				job_id      BIGINT,
+				archive     BYTEA,
+				created_at  TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+				finished_at TIMESTAMPTZ
+			)`,
+		},
+		sqlite: []string{
+			`ALTER TABLE jobs ADD COLUMN lease_token TEXT NOT NULL DEFAULT ''`,
+			`CREATE TABLE IF NOT EXISTS account_exports
+			(
+				user_id     INTEGER   NOT NULL PRIMARY KEY REFERENCES users (id) ON DELETE CASCADE,
+				job_id      INTEGER,
+				archive     BLOB,
+				created_at  TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+				finished_at TIMESTAMP
+			)`,
+		},
+		postgresDown: []string{
+			`DROP TABLE IF EXISTS account_exports`,
+			`ALTER TABLE jobs DROP COLUMN IF EXISTS lease_token`,
+		},
+		sqliteDown: []string{
+			`DROP TABLE IF EXISTS account_exports`,
+			`ALTER TABLE jobs DROP COLUMN lease_token`,
+		},
+	},
+}
+
+// migrateServerSchema applies any server migrations that have not been applied yet. Each
+// migration runs in its own transaction together with the bookkeeping row that records it.
+func (s *Service) migrateServerSchema(ctx context.Context) error {
+	const op errors.Op = "server.Service.migrateServerSchema"
+	if s == nil {
+		return errors.New(op).Msg(errMsgNilService)
+	}
+
+	if _, err := s.db.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS server_schema_migrations
+		(
+			version    INTEGER PRIMARY KEY,
+			name       TEXT    NOT NULL
+		)`); err != nil {
+		return errors.New(op).Err(err).Msg("Failed to create server_schema_migrations")
+	}
+
+	current, err := s.serverSchemaVersion(ctx)
+	if err != nil {
+		return errors.New(op).Err(err)
+	}
+
+	for _, m := range serverMigrations {
+		if m.version <= current {
+			continue
+		}
+		if err = s.applyServerMigration(ctx, m); err != nil {
+			return errors.New(op).Err(err).Msg("Failed to apply server migration " + m.name)
+		}
+		s.logger.InfoWith().Int("version", m.version).Str("name", m.name).Msg("Applied server migration")
+	}
+
+	return nil
+}
+
+// isPostgres reports whether the datastore is PostgreSQL, for the few queries whose SQL differs.
+func (s *Service) isPostgres() bool {
+	return s.dbConfig != nil && s.dbConfig.Driver == database.PostgresDriver
+}
+
+// serverSchemaVersion returns the highest applied server migration version, or zero.
+func (s *Service) serverSchemaVersion(ctx context.Context) (int, error) {
+	const op errors.Op = "server.Service.serverSchemaVersion"
+
+	rows, err := s.db.QueryContext(ctx, `SELECT COALESCE(MAX(version), 0) FROM server_schema_migrations`)
+	if err != nil {
+		return 0, errors.New(op).Err(err)
+	}
+	defer func() { _ = rows.Close() }()
+
+	var version int
+	if rows.Next() {
+		if err = rows.Scan(&version); err != nil {
+			return 0, errors.New(op).Err(err)
+		}
+	}
+	if err = rows.Err(); err != nil {
+		return 0, errors.New(op).Err(err)
+	}
+
+	return version, nil
+}
+
+func (s *Service) applyServerMigration(ctx context.Context, m schemaMigration) error {
+	const op errors.Op = "server.Service.applyServerMigration"
+
+	statements := m.sqlite
+	if s.isPostgres() {
+		statements = m.postgres
+	}
+
+	err := s.withTx(ctx, func(tx *sql.Tx) error {
+		for _, stmt := range statements {
+			if _, err := tx.ExecContext(ctx, stmt); err != nil {
+				return err
+			}
+		}
+		_, err := tx.ExecContext(ctx, `INSERT INTO server_schema_migrations (version, name) VALUES ($1, $2)`, m.version, m.name)
+		return err
+	})
+	if err != nil {
+		return errors.New(op).Err(err)
+	}
+	return nil
+}
@@ -2,59 +2,245 @@ package service
 
 import (
 	"context"
+	"crypto/tls"
+	stderr "errors"
 	"fmt"
-	"github.com/Station-Manager/database"
 	"github.com/Station-Manager/errors"
 	"github.com/Station-Manager/iocdi"
 	"github.com/Station-Manager/logging"
+	"github.com/Station-Manager/server/service/cache"
 	"github.com/Station-Manager/types"
 	"github.com/go-playground/validator/v10"
 	"github.com/gofiber/fiber/v2"
-	"os"
+	"golang.org/x/crypto/acme/autocert"
+	"google.golang.org/grpc"
+	"net"
+	"net/http"
+	"sync"
+	"sync/atomic"
 	"time"
 )
 
-func init() {
-	_ = os.Setenv("SM_DEFAULT_DB", "pg")
-}
-
 type Service struct {
-	container    *iocdi.Container
-	db           *database.Service
+	container *iocdi.Container
+	db        store
+	// dbConfig is the datastore configuration the database service was built from.
+	dbConfig *types.DatastoreConfig
+	// dbRetry retries transactions that conflicted with another; reads are retried by the store.
+	dbRetry      retryPolicy
 	logger       *logging.Service
 	config       types.ServerConfig
 	app          *fiber.App
 	validate     *validator.Validate
-	logbookCache logbookCache
+	logbookCache *cache.Cache[int64, types.Logbook]
+	// logbookCacheStale is how long an expired logbook is served while it is refreshed; zero
+	// refreshes it before answering.
+	logbookCacheStale time.Duration
+	// cacheTTLJitter is the largest share of a TTL randomly taken off each cached entry's.
+	cacheTTLJitter float64
+	// cacheWarmup is the number of recently active logbooks Start loads into the caches.
+	cacheWarmup int
+	// logbookRevalidations holds the IDs of the logbooks being refreshed in the background.
+	logbookRevalidations sync.Map
+	userCache            *cache.Cache[string, types.User]
+	apiKeyCache          *cache.Cache[string, types.ApiKey]
+	// apiKeyNegativeCache remembers recently rejected API keys and unknown prefixes.
+	apiKeyNegativeCache *cache.Cache[string, struct{}]
+	// apiKeyMemberCache holds who each API key was issued to and their role on its logbook.
+	apiKeyMemberCache *cache.Cache[string, apiKeyMember]
+	// signingSecretCache holds the decrypted signing secret of each API key, if it has one.
+	signingSecretCache *cache.Cache[string, signingSecret]
+	// signatureReplays remembers the request signatures accepted, so that none is accepted twice.
+	signatureReplays *replayGuard
+	// requestTimeouts bounds the time requests may take.
+	requestTimeouts *requestTimeouts
+	// slowLog holds the thresholds past which requests and database calls are logged as slow.
+	slowLog *slowLog
+	// prepared runs the hottest queries with prepared statements on a pool of the server's own.
+	prepared *preparedStore
+	// poolWaitThreshold is how long requests may wait for connections of a pool within a sampling
+	// interval before a warning is logged; zero never warns.
+	poolWaitThreshold time.Duration
+	// bodyLimits bounds the size of request bodies per route, and of uploads.
+	bodyLimits *bodyLimits
+	// strictJSON rejects request envelopes with unknown fields or values of the wrong type.
+	strictJSON bool
+	// autocert obtains and renews TLS certificates automatically; nil unless SM_ACME_HOSTS is set.
+	autocert *autocert.Manager
+	// redirect configures the plain-HTTP listener that redirects to HTTPS; nil when not wanted.
+	redirect       *redirectConfig
+	redirectServer *http.Server
+	// grpcAddr is the address of the gRPC listener; the gRPC API is disabled when it is empty.
+	grpcAddr   string
+	grpcServer *grpc.Server
+	// adminToken is the SHA-256 digest of SM_ADMIN_TOKEN; the /admin routes are disabled when empty.
+	adminToken []byte
+	// trashRetention is how long deleted QSOs and logbooks are kept before they are purged; zero
+	// keeps them until restored.
+	trashRetention time.Duration
+	// auditRetention is how long audit log entries are kept before they are purged; zero keeps
+	// them forever.
+	auditRetention time.Duration
+	// accountDeletionGrace is how long a requested account deletion waits before the account is
+	// purged, giving the user time to cancel it.
+	accountDeletionGrace time.Duration
+	// apiKeyMaxIdle is how long an API key may go unused before it is revoked; zero keeps idle keys.
+	apiKeyMaxIdle time.Duration
+	// jobs runs the background jobs queued in the database.
+	jobs *jobRunner
+	// importAsyncRecords is the number of records above which an import runs as a background job
+	// unless the request says otherwise; zero leaves it to the request.
+	importAsyncRecords int
+	// insertQueue stores QSO inserts in the background; nil unless a queue size is configured.
+	insertQueue *insertQueue
+	// scheduler runs the recurring tasks and reports their next runs.
+	scheduler *scheduler
+	// backups takes scheduled and on-demand database backups; nil unless a location is configured.
+	backups *backupManager
+	// qslImages stores scanned QSL cards attached to QSOs; nil unless a location is configured.
+	qslImages *qslImageStore
+	// maintenance is set while maintenance mode is on. It is local to this process.
+	maintenance atomic.Pointer[maintenanceState]
+
+	// inherited holds the sockets passed by a restarting parent until they are claimed; listeners
+	// holds the sockets in use, to pass on at the next restart.
+	inherited   map[string]net.Listener
+	listenersMu sync.Mutex
+	listeners   map[string]net.Listener
+	// httpTuning holds the HTTP server's performance settings; prefork tracks the children it
+	// starts in prefork mode.
+	httpTuning httpTuning
+	prefork    preforkChildren
+	// handedOver is set once a restart has handed the sockets to a new process, which then
+	// reports to systemd in this process's place.
+	handedOver atomic.Bool
+	// serving is set once the server has reported ready, so that a later failure is not taken for
+	// a failure to start.
+	serving atomic.Bool
+
+	// instanceID identifies this process to other instances sharing the database.
+	instanceID      string
+	invalidationBus invalidationBus
+
+	// qsoEvents distributes QSO changes to stream subscribers.
+	qsoEvents *qsoEventBroker
+	// webhooks delivers QSO events to the URLs registered by logbook owners.
+	webhooks *webhookDispatcher
+	// deliveries runs the requests to webhook receivers and third-party services.
+	deliveries *deliveryPool
+
+	// credentialsKey encrypts third-party credentials at rest; integrations needing it are
+	// disabled when it is empty.
+	credentialsKey []byte
+	lotw           *lotwClient
+	eqsl           *eqslClient
+	lookup         *lookupClient
+	clublog        *clubLogClient
+
+	// pskReporter reports digital-mode QSOs of opted-in logbooks to PSK Reporter.
+	pskReporter *pskReporterClient
+	// cty resolves callsigns to DXCC entities when QSOs are inserted.
+	cty *ctyResolver
+	// solar keeps the latest solar and propagation report.
+	solar *solarTracker
+	// awards keeps the award credits of each QSO up to date.
+	awards *awardTracker
+	// usage counts the requests, inserts and errors of each logbook's API keys.
+	usage *usageTracker
+	// quotas bounds the logbooks, QSOs, API keys and QSL image storage of each user.
+	quotas quotas
+	// dxCluster follows a DX cluster to alert logbooks to needed stations; nil unless configured.
+	dxCluster *dxClusterClient
+	// mailer sends email; nil unless an SMTP server is configured.
+	mailer *mailer
+
+	// skipMigrations makes Start verify the schema rather than migrate it.
+	skipMigrations bool
+	// migrated is set once the database migrations have completed successfully.
+	migrated atomic.Bool
+
+	// shutdownConfig is how Shutdown drains the server. requestsCtx is the parent of every
+	// request's context; Shutdown cancels it when requests outlast the drain deadline.
+	shutdownConfig shutdownConfig
+	requestsCtx    context.Context
+	cancelRequests context.CancelFunc
+
+	// Background tasks run under bgCtx and are stopped during Shutdown. The work they have in
+	// hand, such as queued inserts, runs under bgWorkCtx, which outlives bgCtx until the drain
+	// deadline. bgRunning counts the tasks that have not returned.
+	bgCtx        context.Context
+	bgCancel     context.CancelFunc
+	bgWorkCtx    context.Context
+	bgWorkCancel context.CancelFunc
+	bgWG         sync.WaitGroup
+	bgRunning    atomic.Int32
+}
+
+// Options overrides parts of the configuration file, typically from command-line flags. The zero
+// value changes nothing.
+type Options struct {
+	// ConfigDir is the directory holding config.json. Relative paths in the configuration, such as
+	// the log directory, are resolved against it. Defaults to the current directory.
+	ConfigDir string
+	// Port overrides the configured listening port when non-zero.
+	Port int
+	// LogLevel overrides the configured log level when set.
+	LogLevel string
+	// Driver overrides the configured datastore driver (postgres or sqlite) when set. It also
+	// chooses the datastore written into a newly generated config.json.
+	Driver string
+	// SkipMigrations makes Start check that the schema is up to date instead of migrating it, for
+	// deployments that run `server migrate up` separately.
+	SkipMigrations bool
 }
 
 // NewService creates a new server instance and initializes all its dependencies.
 func NewService() (*Service, error) {
+	return NewServiceWithOptions(Options{})
+}
+
+// NewServiceWithOptions creates a new server instance, applying opts over the configuration file.
+func NewServiceWithOptions(opts Options) (*Service, error) {
 	const op errors.Op = "server.NewService"
-	svc := &Service{}
+	svc := &Service{skipMigrations: opts.SkipMigrations}
 
-	if err := svc.initializeContainer(); err != nil {
-		return nil, errors.New(op).Err(err).Msg("Failed to initialize container")
+	// Nothing is opened before Start, so every failure here is one of configuration.
+	if err := svc.initializeContainer(opts); err != nil {
+		return nil, svc.startupError(StartupFailureConfig, errors.New(op).Err(err).Msg("Failed to initialize container"))
 	}
 
 	if err := svc.initializeService(); err != nil {
-		return nil, errors.New(op).Err(err).Msg("Failed to initialize service")
+		return nil, svc.startupError(StartupFailureConfig, errors.New(op).Err(err).Msg("Failed to initialize service"))
 	}
 
 	if err := svc.initializeGoFiber(); err != nil {
-		return nil, errors.New(op).Err(err).Msg("Failed to initialize goFiber")
+		return nil, svc.startupError(StartupFailureConfig, errors.New(op).Err(err).Msg("Failed to initialize goFiber"))
 	}
 
 	return svc, nil
 }
 
-// Start starts the server.
-func (s *Service) Start() error {
-	const op errors.Op = "server.Service.Start"
+// Migrate applies the database and server schema migrations and closes the database, without
+// serving requests.
+func (s *Service) Migrate() error {
+	const op errors.Op = "server.Service.Migrate"
 	if s == nil {
 		return errors.New(op).Msg(errMsgNilService)
 	}
 
+	if err := s.openAndMigrate(); err != nil {
+		return errors.New(op).Err(err)
+	}
+	if err := s.db.Close(); err != nil {
+		return errors.New(op).Err(err).Msg("s.db.Close")
+	}
+	return nil
+}
+
+// openAndMigrate opens the database and brings both schemas up to date.
+func (s *Service) openAndMigrate() error {
+	const op errors.Op = "server.Service.openAndMigrate"
+
 	if err := s.db.Open(); err != nil {
 		s.logger.ErrorWith().Err(err).Msg("Failed to open database")
 		return errors.New(op).Err(err).Msg("s.db.Open")
@@ -63,31 +249,140 @@ func (s *Service) Start() error {
 	if err := s.db.Migrate(); err != nil {
 		return errors.New(op).Err(err).Msg("Failed to migrate database")
 	}
+	if err := s.migrateServerSchema(context.Background()); err != nil {
+		return errors.New(op).Err(err).Msg("Failed to migrate server schema")
+	}
+	s.migrated.Store(true)
+	return nil
+}
+
+// openAndVerify opens the database and checks that both schemas are up to date, leaving them as
+// they are.
+func (s *Service) openAndVerify() error {
+	const op errors.Op = "server.Service.openAndVerify"
+
+	if err := s.db.Open(); err != nil {
+		s.logger.ErrorWith().Err(err).Msg("Failed to open database")
+		return errors.New(op).Err(err).Msg("s.db.Open")
+	}
+
+	if err := s.verifySchema(context.Background()); err != nil {
+		return errors.New(op).Err(err).Msg("The database schema is not up to date")
+	}
+	s.migrated.Store(true)
+	return nil
+}
+
+// Start starts the server.
+func (s *Service) Start() error {
+	const op errors.Op = "server.Service.Start"
+	if s == nil {
+		return errors.New(op).Msg(errMsgNilService)
+	}
+
+	// In prefork mode the master migrates the schema before it starts the children.
+	child := s.httpTuning.prefork && IsPreforkChild()
+	if s.skipMigrations || child {
+		if err := s.openAndVerify(); err != nil {
+			return s.startupError(StartupFailureDatabase, errors.New(op).Err(err))
+		}
+	} else if err := s.openAndMigrate(); err != nil {
+		return s.startupError(StartupFailureDatabase, errors.New(op).Err(err))
+	}
+
+	// The prefork master only supervises the children, which each warm their own caches.
+	if !s.httpTuning.prefork || child {
+		s.warmCaches()
+	}
+	s.startBackgroundTasks()
+
+	if !child {
+		if err := s.startRedirectListener(); err != nil {
+			return s.startupError(StartupFailureBind, errors.New(op).Err(err).Msg("Failed to start the HTTP redirect listener"))
+		}
+		if err := s.startGrpcListener(); err != nil {
+			return s.startupError(StartupFailureBind, errors.New(op).Err(err).Msg("Failed to start the gRPC listener"))
+		}
+	}
 
 	addr := fmt.Sprintf("%s:%d", s.config.Host, s.config.Port)
-	if s.config.TLSEnabled {
-		return s.app.ListenTLS(addr, s.config.TLSCertFile, s.config.TLSKeyFile)
-	} else {
-		return s.app.Listen(addr)
+	if s.httpTuning.prefork {
+		return s.servePrefork(addr)
 	}
+	ln, err := s.listen(listenerMain, addr)
+	if err != nil {
+		return s.startupError(StartupFailureBind, errors.New(op).Err(err).Msg("s.listen"))
+	}
+	switch {
+	case s.autocert != nil:
+		ln = tls.NewListener(ln, s.autocert.TLSConfig())
+	case s.config.TLSEnabled:
+		cert, err := tls.LoadX509KeyPair(s.config.TLSCertFile, s.config.TLSKeyFile)
+		if err != nil {
+			_ = ln.Close()
+			return s.startupError(StartupFailureConfig, errors.New(op).Err(err).Msg("tls.LoadX509KeyPair"))
+		}
+		ln = tls.NewListener(ln, &tls.Config{MinVersion: tls.VersionTLS12, Certificates: []tls.Certificate{cert}})
+	}
+
+	s.closeUnclaimedListeners()
+	s.notifyReady()
+	return s.app.Listener(ln)
 }
 
 // Shutdown gracefully terminates the service by shutting down the server, closing database connections, and the logger.
+// Requests and work in hand have until the drain deadline to finish; what is left unfinished is logged.
 func (s *Service) Shutdown() error {
 	const op errors.Op = "server.Service.Shutdown"
 	if s == nil {
 		return errors.New(op).Msg(errMsgNilService)
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	ctx, cancel := context.WithTimeout(context.Background(), s.shutdownConfig.drainTimeout())
 	defer cancel()
 
+	if !s.handedOver.Load() {
+		s.notifySystemd("STOPPING=1")
+	}
+
+	// Release long-lived stream handlers so that they do not hold up the shutdown
+	s.qsoEvents.Close()
+
 	// Shutdown Fiber app first to stop accepting new requests
 	// This waits for all active handlers to complete
+	var report shutdownReport
 	if err := s.app.ShutdownWithContext(ctx); err != nil {
-		s.logger.ErrorWith().Err(err).Msg("Failed to shutdown Fiber app")
-		return errors.New(op).Err(err).Msg("s.app.Shutdown")
+		report.requests = s.app.Server().GetOpenConnectionsCount()
+		if s.shutdownConfig.leaveRunning || !stderr.Is(err, context.DeadlineExceeded) {
+			report.log(s)
+			s.logger.ErrorWith().Err(err).Msg("Failed to shutdown Fiber app")
+			return errors.New(op).Err(err).Msg("s.app.Shutdown")
+		}
+		// Cancel the requests still running, so that their handlers return and the connections close
+		if s.cancelRequests != nil {
+			s.cancelRequests()
+		}
+		graceCtx, graceCancel := context.WithTimeout(context.Background(), shutdownForceGrace)
+		s.waitForConnections(graceCtx)
+		graceCancel()
+	}
+
+	report.preforkChildren = s.stopPreforkChildren(ctx)
+	s.stopRedirectListener(ctx)
+	report.grpcCalls = s.stopGrpcListener(ctx)
+
+	// Stop background tasks before the resources they use are released
+	report.backgroundTasks = s.stopBackgroundTasks(ctx)
+	if s.insertQueue != nil {
+		report.inserts = len(s.insertQueue.jobs)
+	}
+	if s.webhooks != nil {
+		report.webhookEvents = len(s.webhooks.queue)
+	}
+	if s.jobs != nil {
+		report.jobsRequeued = s.jobs.requeued.Load()
 	}
+	report.log(s)
 
 	// Close the database after all requests are done
 	if err := s.db.Close(); err != nil {
@@ -95,36 +390,8 @@ func (s *Service) Shutdown() error {
 		return errors.New(op).Err(err).Msg("s.db.Close")
 	}
 
-	// Wait for any in-flight log operations to complete with a reasonable timeout
-	// This is necessary because:
-	// 1. Fiber handler goroutines may have deferred log cleanup
-	// 2. Migrations/startup logs may still be writing to disk
-	// Instead of a fixed sleep, we poll the active operations counter
-	//waitStart := time.Now()
-	//maxWait := 2 * time.Second
-	//lastOps := s.logger.ActiveOperations()
-	//for time.Since(waitStart) < maxWait {
-	//	currentOps := s.logger.ActiveOperations()
-	//	if currentOps == 0 {
-	//		break
-	//	}
-	//	// Debug: Print if operations count changes
-	//	if currentOps != lastOps {
-	//		log.Printf("Waiting for log operations: %d active (elapsed: %v)", currentOps, time.Since(waitStart))
-	//		lastOps = currentOps
-	//	}
-	//	time.Sleep(10 * time.Millisecond)
-	//}
-	//finalOps := s.logger.ActiveOperations()
-	//if finalOps > 0 {
-	//	log.Printf("WARNING: Logger still has %d active operations after %v wait", finalOps, time.Since(waitStart))
-	//}
-
-	//	s.logger.Wait()
-	// Close logger last
-	//	if err := s.logger.Close(); err != nil {
-	//		return errors.New(op).Err(err).Msg("s.logger.Close")
-	//	}
+	// Give in-flight log writes, such as those deferred by handlers, a moment to complete
+	s.waitForLogger()
 
 	return nil
 }
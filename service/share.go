@@ -0,0 +1,274 @@
+package service
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"time"
+
+	"github.com/Station-Manager/errors"
+	"github.com/Station-Manager/types"
+)
+
+const (
+	// shareTokenBytes is the number of random bytes in a share token.
+	shareTokenBytes = 24
+
+	maxShareLinksPerLogbook = 20
+	// shareRecentQsosMax bounds the QSOs shown by a share link.
+	shareRecentQsosMax = 50
+	// shareStatsGroupsMax bounds the bands and modes listed in a shared logbook's stats.
+	shareStatsGroupsMax = 20
+)
+
+// shareLink gives read-only public access to a logbook's recent QSOs and stats. The token itself is
+// only returned when the link is created; the server keeps its SHA-256.
+type shareLink struct {
+	ID        int64      `json:"id"`
+	LogbookID int64      `json:"logbook_id"`
+	Label     string     `json:"label"`
+	CreatedAt time.Time  `json:"created_at"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+	RevokedAt *time.Time `json:"revoked_at,omitempty"`
+}
+
+// sharedQso is a QSO as shown by a share link. Details about the contacted station beyond its
+// callsign are left out.
+type sharedQso struct {
+	Call    string `json:"call"`
+	Band    string `json:"band"`
+	Mode    string `json:"mode"`
+	QsoDate string `json:"qso_date"`
+	TimeOn  string `json:"time_on"`
+}
+
+// shareCount is the number of QSOs with a band or mode.
+type shareCount struct {
+	Key   string `json:"key"`
+	Count int64  `json:"count"`
+}
+
+// sharedLogbook is the summary of a logbook shown by a share link.
+type sharedLogbook struct {
+	Name        string       `json:"name"`
+	Callsign    string       `json:"callsign"`
+	Qsos        int64        `json:"qsos"`
+	UniqueCalls int64        `json:"unique_calls"`
+	Bands       []shareCount `json:"bands"`
+	Modes       []shareCount `json:"modes"`
+}
+
+// newShareToken returns a random share token and its hash.
+func newShareToken() (string, string, error) {
+	b := make([]byte, shareTokenBytes)
+	if _, err := rand.Read(b); err != nil {
+		return emptyString, emptyString, err
+	}
+	token := base64.RawURLEncoding.EncodeToString(b)
+	return token, shareTokenHash(token), nil
+}
+
+func shareTokenHash(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// insertShareLink stores a share link for the token hash.
+func (s *Service) insertShareLink(ctx context.Context, link shareLink, tokenHash string) (shareLink, error) {
+	const op errors.Op = "server.Service.insertShareLink"
+
+	var expiresAt any
+	if link.ExpiresAt != nil {
+		expiresAt = s.dbTimestamp(*link.ExpiresAt)
+	}
+	rows, err := s.db.QueryContext(ctx, `INSERT INTO share_links (logbook_id, token_hash, label, expires_at) VALUES ($1, $2, $3, $4)
+		RETURNING id, created_at`, link.LogbookID, tokenHash, link.Label, expiresAt)
+	if err != nil {
+		return shareLink{}, errors.New(op).Err(err)
+	}
+	defer func() { _ = rows.Close() }()
+
+	if !rows.Next() {
+		if err = rows.Err(); err == nil {
+			err = errors.New(op).Msg("No row returned")
+		}
+		return shareLink{}, errors.New(op).Err(err)
+	}
+	if err = rows.Scan(&link.ID, &link.CreatedAt); err != nil {
+		return shareLink{}, errors.New(op).Err(err)
+	}
+	return link, nil
+}
+
+// listShareLinks returns the logbook's share links, newest first, including revoked and expired
+// ones.
+func (s *Service) listShareLinks(ctx context.Context, logbookID int64) ([]shareLink, error) {
+	const op errors.Op = "server.Service.listShareLinks"
+
+	rows, err := s.db.QueryContext(ctx, `SELECT id, logbook_id, label, created_at, expires_at, revoked_at FROM share_links
+		WHERE logbook_id = $1 ORDER BY id DESC`, logbookID)
+	if err != nil {
+		return nil, errors.New(op).Err(err)
+	}
+	defer func() { _ = rows.Close() }()
+
+	links := make([]shareLink, 0)
+	for rows.Next() {
+		var link shareLink
+		if err = rows.Scan(&link.ID, &link.LogbookID, &link.Label, &link.CreatedAt, &link.ExpiresAt, &link.RevokedAt); err != nil {
+			return nil, errors.New(op).Err(err)
+		}
+		links = append(links, link)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, errors.New(op).Err(err)
+	}
+	return links, nil
+}
+
+// countActiveShareLinks returns the number of the logbook's share links that still work.
+func (s *Service) countActiveShareLinks(ctx context.Context, logbookID int64) (int, error) {
+	const op errors.Op = "server.Service.countActiveShareLinks"
+
+	rows, err := s.db.QueryContext(ctx, `SELECT COUNT(*) FROM share_links
+		WHERE logbook_id = $1 AND revoked_at IS NULL AND (expires_at IS NULL OR expires_at > $2)`, logbookID, s.dbTimestamp(time.Now()))
+	if err != nil {
+		return 0, errors.New(op).Err(err)
+	}
+	defer func() { _ = rows.Close() }()
+
+	var n int
+	if rows.Next() {
+		if err = rows.Scan(&n); err != nil {
+			return 0, errors.New(op).Err(err)
+		}
+	}
+	if err = rows.Err(); err != nil {
+		return 0, errors.New(op).Err(err)
+	}
+	return n, nil
+}
+
+// revokeShareLink revokes the share link if it belongs to the logbook and reports whether it was
+// active.
+func (s *Service) revokeShareLink(ctx context.Context, logbookID, id int64) (bool, error) {
+	const op errors.Op = "server.Service.revokeShareLink"
+
+	res, err := s.db.ExecContext(ctx, `UPDATE share_links SET revoked_at = CURRENT_TIMESTAMP
+		WHERE id = $1 AND logbook_id = $2 AND revoked_at IS NULL`, id, logbookID)
+	if err != nil {
+		return false, errors.New(op).Err(err)
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return false, errors.New(op).Err(err)
+	}
+	return n > 0, nil
+}
+
+// resolveShareToken returns the logbook shared by the token. It reports false when the token is
+// unknown, revoked or expired, or the logbook is in the trash.
+func (s *Service) resolveShareToken(ctx context.Context, token string) (types.Logbook, bool, error) {
+	const op errors.Op = "server.Service.resolveShareToken"
+
+	rows, err := s.db.QueryContext(ctx, `SELECT l.id, l.name, l.callsign FROM share_links sl JOIN logbook l ON l.id = sl.logbook_id
+		WHERE sl.token_hash = $1 AND sl.revoked_at IS NULL AND (sl.expires_at IS NULL OR sl.expires_at > $2) AND l.deleted_at IS NULL`,
+		shareTokenHash(token), s.dbTimestamp(time.Now()))
+	if err != nil {
+		return types.Logbook{}, false, errors.New(op).Err(err)
+	}
+	defer func() { _ = rows.Close() }()
+
+	if !rows.Next() {
+		if err = rows.Err(); err != nil {
+			return types.Logbook{}, false, errors.New(op).Err(err)
+		}
+		return types.Logbook{}, false, nil
+	}
+	var logbook types.Logbook
+	if err = rows.Scan(&logbook.ID, &logbook.Name, &logbook.Callsign); err != nil {
+		return types.Logbook{}, false, errors.New(op).Err(err)
+	}
+	return logbook, true, nil
+}
+
+// fetchSharedLogbook returns the summary of the logbook shown by a share link.
+func (s *Service) fetchSharedLogbook(ctx context.Context, logbook types.Logbook) (sharedLogbook, error) {
+	const op errors.Op = "server.Service.fetchSharedLogbook"
+
+	summary := sharedLogbook{Name: logbook.Name, Callsign: logbook.Callsign}
+	rows, err := s.db.QueryContext(ctx, `SELECT COUNT(*), COUNT(DISTINCT upper(call)) FROM qso
+		WHERE logbook_id = $1 AND deleted_at IS NULL`, logbook.ID)
+	if err != nil {
+		return sharedLogbook{}, errors.New(op).Err(err)
+	}
+	if rows.Next() {
+		err = rows.Scan(&summary.Qsos, &summary.UniqueCalls)
+	}
+	if err == nil {
+		err = rows.Err()
+	}
+	_ = rows.Close()
+	if err != nil {
+		return sharedLogbook{}, errors.New(op).Err(err)
+	}
+
+	if summary.Bands, err = s.countSharedQsos(ctx, logbook.ID, activityGroupExpressions[activityGroupBand]); err != nil {
+		return sharedLogbook{}, errors.New(op).Err(err)
+	}
+	if summary.Modes, err = s.countSharedQsos(ctx, logbook.ID, activityGroupExpressions[activityGroupMode]); err != nil {
+		return sharedLogbook{}, errors.New(op).Err(err)
+	}
+	return summary, nil
+}
+
+// countSharedQsos counts the logbook's QSOs per value of the expression, most frequent first.
+func (s *Service) countSharedQsos(ctx context.Context, logbookID int64, expr string) ([]shareCount, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT `+expr+`, COUNT(*) FROM qso WHERE logbook_id = $1 AND deleted_at IS NULL
+		GROUP BY `+expr+` ORDER BY COUNT(*) DESC, `+expr+` LIMIT $2`, logbookID, shareStatsGroupsMax)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = rows.Close() }()
+
+	counts := make([]shareCount, 0)
+	for rows.Next() {
+		var c shareCount
+		if err = rows.Scan(&c.Key, &c.Count); err != nil {
+			return nil, err
+		}
+		counts = append(counts, c)
+	}
+	return counts, rows.Err()
+}
+
+// listRecentQsos returns the logbook's most recent QSOs, newest first.
+func (s *Service) listRecentQsos(ctx context.Context, logbookID int64, limit int) ([]sharedQso, error) {
+	const op errors.Op = "server.Service.listRecentQsos"
+
+	columns := `call, band, mode, qso_date, time_on`
+	if s.isPostgres() {
+		columns = `call, band, mode, to_char(qso_date, 'YYYYMMDD'), to_char(time_on, 'HH24MI')`
+	}
+	rows, err := s.db.QueryContext(ctx, `SELECT `+columns+` FROM qso WHERE logbook_id = $1 AND deleted_at IS NULL
+		ORDER BY qso_date DESC, time_on DESC, id DESC LIMIT $2`, logbookID, limit)
+	if err != nil {
+		return nil, errors.New(op).Err(err)
+	}
+	defer func() { _ = rows.Close() }()
+
+	qsos := make([]sharedQso, 0)
+	for rows.Next() {
+		var q sharedQso
+		if err = rows.Scan(&q.Call, &q.Band, &q.Mode, &q.QsoDate, &q.TimeOn); err != nil {
+			return nil, errors.New(op).Err(err)
+		}
+		qsos = append(qsos, q)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, errors.New(op).Err(err)
+	}
+	return qsos, nil
+}
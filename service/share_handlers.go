@@ -0,0 +1,189 @@
+package service
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Station-Manager/errors"
+	"github.com/Station-Manager/server/service/frontend"
+	"github.com/gofiber/fiber/v2"
+)
+
+// shareCacheControl lets browsers and proxies cache the public view of a shared logbook briefly.
+const shareCacheControl = "public, max-age=60"
+
+// shareLinkRequest is the body of a request to create a share link.
+type shareLinkRequest struct {
+	Label string `json:"label" validate:"max=100"`
+	// ExpiresIn is a Go duration after which the link stops working; it never expires when omitted.
+	ExpiresIn string `json:"expires_in"`
+}
+
+// listShareLinksHandler returns the authenticated logbook's share links. Tokens are never returned.
+func (s *Service) listShareLinksHandler(c *fiber.Ctx) error {
+	const op errors.Op = "server.Service.listShareLinksHandler"
+
+	logbook, err := authenticatedLogbook(c)
+	if err != nil {
+		s.logger.ErrorWith().Err(errors.New(op).Err(err)).Msg("authenticatedLogbook failed")
+		return c.Status(fiber.StatusInternalServerError).JSON(jsonInternalError)
+	}
+
+	links, err := s.listShareLinks(c.UserContext(), logbook.ID)
+	if err != nil {
+		s.logger.ErrorWith().Err(errors.New(op).Err(err)).Msg("s.listShareLinks failed")
+		return s.dbFailure(c, err)
+	}
+
+	return c.JSON(fiber.Map{"shares": links})
+}
+
+// createShareLinkHandler creates a share link for the authenticated logbook. The token is only
+// ever returned in this response.
+func (s *Service) createShareLinkHandler(c *fiber.Ctx) error {
+	const op errors.Op = "server.Service.createShareLinkHandler"
+
+	logbook, err := authenticatedLogbook(c)
+	if err != nil {
+		s.logger.ErrorWith().Err(errors.New(op).Err(err)).Msg("authenticatedLogbook failed")
+		return c.Status(fiber.StatusInternalServerError).JSON(jsonInternalError)
+	}
+
+	var request shareLinkRequest
+	if len(c.Body()) > 0 {
+		if err = c.BodyParser(&request); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(jsonBadRequest)
+		}
+	}
+	request.Label = strings.TrimSpace(request.Label)
+	if err = s.validate.Struct(request); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(jsonBadRequest)
+	}
+
+	link := shareLink{LogbookID: logbook.ID, Label: request.Label}
+	if request.ExpiresIn != emptyString {
+		d, err := time.ParseDuration(request.ExpiresIn)
+		if err != nil || d <= 0 {
+			return c.Status(fiber.StatusBadRequest).JSON(jsonError(errCodeBadRequest, "expires_in must be a positive duration"))
+		}
+		expiresAt := time.Now().UTC().Add(d).Truncate(time.Second)
+		link.ExpiresAt = &expiresAt
+	}
+
+	active, err := s.countActiveShareLinks(c.UserContext(), logbook.ID)
+	if err != nil {
+		s.logger.ErrorWith().Err(errors.New(op).Err(err)).Msg("s.countActiveShareLinks failed")
+		return s.dbFailure(c, err)
+	}
+	if active >= maxShareLinksPerLogbook {
+		return c.Status(fiber.StatusConflict).JSON(jsonError(errCodeShareLinkLimit, "Share link limit reached"))
+	}
+
+	token, tokenHash, err := newShareToken()
+	if err != nil {
+		s.logger.ErrorWith().Err(errors.New(op).Err(err)).Msg("newShareToken failed")
+		return c.Status(fiber.StatusInternalServerError).JSON(jsonInternalError)
+	}
+	if link, err = s.insertShareLink(c.UserContext(), link, tokenHash); err != nil {
+		s.logger.ErrorWith().Err(errors.New(op).Err(err)).Msg("s.insertShareLink failed")
+		return s.dbFailure(c, err)
+	}
+
+	return c.Status(fiber.StatusCreated).JSON(fiber.Map{"share": link, "token": token, "path": "/share/" + token})
+}
+
+// revokeShareLinkHandler revokes one of the authenticated logbook's share links.
+func (s *Service) revokeShareLinkHandler(c *fiber.Ctx) error {
+	const op errors.Op = "server.Service.revokeShareLinkHandler"
+
+	logbook, err := authenticatedLogbook(c)
+	if err != nil {
+		s.logger.ErrorWith().Err(errors.New(op).Err(err)).Msg("authenticatedLogbook failed")
+		return c.Status(fiber.StatusInternalServerError).JSON(jsonInternalError)
+	}
+
+	id, err := strconv.ParseInt(c.Params("id"), 10, 64)
+	if err != nil || id <= 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(jsonBadRequest)
+	}
+
+	found, err := s.revokeShareLink(c.UserContext(), logbook.ID, id)
+	if err != nil {
+		s.logger.ErrorWith().Err(errors.New(op).Err(err)).Msg("s.revokeShareLink failed")
+		return s.dbFailure(c, err)
+	}
+	if !found {
+		return c.Status(fiber.StatusNotFound).JSON(jsonError(errCodeShareNotFound, "Share link not found"))
+	}
+
+	return c.SendStatus(fiber.StatusNoContent)
+}
+
+// shareTokenMiddleware resolves the share token in the path to its logbook. Unknown, revoked and
+// expired tokens all get the same 404, so that the response does not reveal which tokens existed.
+func (s *Service) shareTokenMiddleware() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		const op errors.Op = "server.Service.shareTokenMiddleware"
+
+		logbook, found, err := s.resolveShareToken(c.UserContext(), c.Params("token"))
+		if err != nil {
+			s.logger.ErrorWith().Err(errors.New(op).Err(err)).Msg("s.resolveShareToken failed")
+			return s.dbFailure(c, err)
+		}
+		if !found {
+			return c.Status(fiber.StatusNotFound).JSON(jsonError(errCodeShareNotFound, "Share link not found"))
+		}
+
+		setRequestContext(c, &requestContext{Logbook: &logbook, IsValid: true})
+		return c.Next()
+	}
+}
+
+// sharePageHandler serves the page that shows a shared logbook. The page loads its data from the
+// JSON endpoints below it.
+func (s *Service) sharePageHandler(c *fiber.Ctx) error {
+	c.Set(fiber.HeaderContentType, fiber.MIMETextHTMLCharsetUTF8)
+	c.Set(fiber.HeaderCacheControl, shareCacheControl)
+	return c.Send(frontend.SharePage())
+}
+
+// sharedLogbookHandler returns the shared logbook's name, callsign and QSO statistics.
+func (s *Service) sharedLogbookHandler(c *fiber.Ctx) error {
+	const op errors.Op = "server.Service.sharedLogbookHandler"
+
+	logbook, err := authenticatedLogbook(c)
+	if err != nil {
+		s.logger.ErrorWith().Err(errors.New(op).Err(err)).Msg("authenticatedLogbook failed")
+		return c.Status(fiber.StatusInternalServerError).JSON(jsonInternalError)
+	}
+
+	summary, err := s.fetchSharedLogbook(c.UserContext(), *logbook)
+	if err != nil {
+		s.logger.ErrorWith().Err(errors.New(op).Err(err)).Msg("s.fetchSharedLogbook failed")
+		return s.dbFailure(c, err)
+	}
+
+	c.Set(fiber.HeaderCacheControl, shareCacheControl)
+	return c.JSON(summary)
+}
+
+// sharedQsosHandler returns the shared logbook's most recent QSOs.
+func (s *Service) sharedQsosHandler(c *fiber.Ctx) error {
+	const op errors.Op = "server.Service.sharedQsosHandler"
+
+	logbook, err := authenticatedLogbook(c)
+	if err != nil {
+		s.logger.ErrorWith().Err(errors.New(op).Err(err)).Msg("authenticatedLogbook failed")
+		return c.Status(fiber.StatusInternalServerError).JSON(jsonInternalError)
+	}
+
+	qsos, err := s.listRecentQsos(c.UserContext(), logbook.ID, shareRecentQsosMax)
+	if err != nil {
+		s.logger.ErrorWith().Err(errors.New(op).Err(err)).Msg("s.listRecentQsos failed")
+		return s.dbFailure(c, err)
+	}
+
+	c.Set(fiber.HeaderCacheControl, shareCacheControl)
+	return c.JSON(fiber.Map{"qsos": qsos})
+}
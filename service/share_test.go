@@ -0,0 +1,124 @@
+package service
+
+import (
+	"context"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/go-playground/validator/v10"
+	"github.com/goccy/go-json"
+	"github.com/gofiber/fiber/v2"
+)
+
+func TestShareLinks_PublicViewAndRevocation(t *testing.T) {
+	svc := newTestServer(t)
+	svc.validate = validator.New(validator.WithRequiredStructEnabled())
+	insertTestQso(t, svc, "JA1XX", "20m", "FT8", "20240430", "1203")
+	insertTestQso(t, svc, "ja1xx", "40m", "FT8", "20240501", "0815")
+	insertTestQso(t, svc, "DL1AB", "20m", "CW", "20240502", "2210")
+
+	app := fiber.New()
+	app.Get("/shares", withLogbook(1, svc.listShareLinksHandler))
+	app.Post("/shares", withLogbook(1, svc.createShareLinkHandler))
+	app.Delete("/shares/:id", withLogbook(1, svc.revokeShareLinkHandler))
+	shared := app.Group("/share/:token", svc.shareTokenMiddleware())
+	shared.Get("/", svc.sharePageHandler)
+	shared.Get("/logbook", svc.sharedLogbookHandler)
+	shared.Get("/qsos", svc.sharedQsosHandler)
+
+	create := func(body string) (int, map[string]any) {
+		req := httptest.NewRequest(fiber.MethodPost, "/shares", strings.NewReader(body))
+		req.Header.Set(fiber.HeaderContentType, fiber.MIMEApplicationJSON)
+		resp, err := app.Test(req)
+		if err != nil {
+			t.Fatalf("create failed: %v", err)
+		}
+		var out map[string]any
+		_ = json.NewDecoder(resp.Body).Decode(&out)
+		return resp.StatusCode, out
+	}
+	get := func(path string, out any) int {
+		resp, err := app.Test(httptest.NewRequest(fiber.MethodGet, path, nil))
+		if err != nil {
+			t.Fatalf("GET %s failed: %v", path, err)
+		}
+		if out != nil {
+			_ = json.NewDecoder(resp.Body).Decode(out)
+		}
+		return resp.StatusCode
+	}
+
+	if status, _ := create(`{"expires_in":"-1h"}`); status != fiber.StatusBadRequest {
+		t.Errorf("expected a negative expiry to get 400, got %d", status)
+	}
+	status, out := create(`{"label":" club site ","expires_in":"24h"}`)
+	if status != fiber.StatusCreated {
+		t.Fatalf("expected the link to be created, got %d %v", status, out)
+	}
+	token := out["token"].(string)
+	share := out["share"].(map[string]any)
+	if share["label"] != "club site" || share["expires_at"] == nil {
+		t.Errorf("expected a trimmed label and an expiry, got %v", share)
+	}
+
+	var summary sharedLogbook
+	if status = get("/share/"+token+"/logbook", &summary); status != fiber.StatusOK {
+		t.Fatalf("expected the shared logbook, got %d", status)
+	}
+	if summary.Qsos != 3 || summary.UniqueCalls != 2 || len(summary.Bands) != 2 || summary.Bands[0].Key != "20m" || summary.Bands[0].Count != 2 {
+		t.Errorf("unexpected summary %+v", summary)
+	}
+
+	var recent struct {
+		Qsos []sharedQso `json:"qsos"`
+	}
+	if status = get("/share/"+token+"/qsos", &recent); status != fiber.StatusOK {
+		t.Fatalf("expected the shared QSOs, got %d", status)
+	}
+	if len(recent.Qsos) != 3 || recent.Qsos[0].Call != "DL1AB" {
+		t.Errorf("expected the newest QSO first, got %+v", recent.Qsos)
+	}
+	if status = get("/share/"+token, nil); status != fiber.StatusOK {
+		t.Errorf("expected the share page, got %d", status)
+	}
+	if status = get("/share/not-a-token/logbook", nil); status != fiber.StatusNotFound {
+		t.Errorf("expected an unknown token to get 404, got %d", status)
+	}
+
+	id := strconv.FormatInt(int64(share["id"].(float64)), 10)
+	resp, err := app.Test(httptest.NewRequest(fiber.MethodDelete, "/shares/"+id, nil))
+	if err != nil || resp.StatusCode != fiber.StatusNoContent {
+		t.Fatalf("expected the link to be revoked, got %v (err=%v)", resp, err)
+	}
+	if status = get("/share/"+token+"/qsos", nil); status != fiber.StatusNotFound {
+		t.Errorf("expected a revoked token to get 404, got %d", status)
+	}
+	if resp, _ = app.Test(httptest.NewRequest(fiber.MethodDelete, "/shares/"+id, nil)); resp.StatusCode != fiber.StatusNotFound {
+		t.Errorf("expected a second revocation to get 404, got %d", resp.StatusCode)
+	}
+
+	var listed struct {
+		Shares []shareLink `json:"shares"`
+	}
+	if status = get("/shares", &listed); status != fiber.StatusOK || len(listed.Shares) != 1 || listed.Shares[0].RevokedAt == nil {
+		t.Errorf("expected the revoked link to be listed, got %d %+v", status, listed.Shares)
+	}
+}
+
+func TestResolveShareToken_Expired(t *testing.T) {
+	svc := newTestServer(t)
+	ctx := context.Background()
+
+	token, hash, err := newShareToken()
+	if err != nil {
+		t.Fatalf("newShareToken failed: %v", err)
+	}
+	if _, err = svc.db.ExecContext(ctx, `INSERT INTO share_links (logbook_id, token_hash, expires_at) VALUES (1, $1, '2000-01-01 00:00:00')`, hash); err != nil {
+		t.Fatalf("setup failed: %v", err)
+	}
+	if _, found, err := svc.resolveShareToken(ctx, token); err != nil || found {
+		t.Errorf("expected an expired token not to resolve, got found=%v err=%v", found, err)
+	}
+}
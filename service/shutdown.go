@@ -0,0 +1,173 @@
+package service
+
+import (
+	"context"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/Station-Manager/errors"
+	"github.com/gofiber/fiber/v2"
+)
+
+const (
+	// envShutdownTimeout names the environment variable holding how long a shutdown waits for
+	// requests, streams, gRPC calls and work in hand to finish (e.g. "1m").
+	envShutdownTimeout = "SM_SHUTDOWN_TIMEOUT"
+	// envShutdownForceClose names the environment variable choosing what happens when the drain
+	// deadline passes: "true", the default, cancels the requests still running and finishes
+	// shutting down; "false" leaves them running and gives up, leaving the database open.
+	envShutdownForceClose = "SM_SHUTDOWN_FORCE_CLOSE"
+	// envShutdownJobs names the environment variable choosing what happens to running background
+	// jobs: "requeue", the default, interrupts them at once and queues them again for the next
+	// server to start; "finish" lets them run until the drain deadline.
+	envShutdownJobs = "SM_SHUTDOWN_JOBS"
+	// envShutdownLogWait names the environment variable holding how long a shutdown waits for log
+	// writes in progress to complete (e.g. "2s").
+	envShutdownLogWait = "SM_SHUTDOWN_LOG_WAIT"
+)
+
+const (
+	defaultShutdownTimeout = 30 * time.Second
+	defaultShutdownLogWait = 2 * time.Second
+	// shutdownForceGrace is how long cancelled requests and interrupted work have to return once
+	// the drain deadline has passed.
+	shutdownForceGrace = 2 * time.Second
+	// shutdownPollInterval is how often a shutdown checks whether what it waits for has finished.
+	shutdownPollInterval = 10 * time.Millisecond
+)
+
+const (
+	shutdownJobsRequeue = "requeue"
+	shutdownJobsFinish  = "finish"
+)
+
+// shutdownConfig is how the server shuts down. The zero value is the default behaviour.
+type shutdownConfig struct {
+	timeout time.Duration
+	// leaveRunning gives up at the deadline instead of cancelling the requests still running.
+	leaveRunning bool
+	// finishJobs lets running jobs run until the deadline instead of queueing them again.
+	finishJobs bool
+	logWait    time.Duration
+}
+
+// loadShutdownConfig reads the shutdown settings from the environment.
+func loadShutdownConfig() (shutdownConfig, error) {
+	const op errors.Op = "server.loadShutdownConfig"
+
+	config := shutdownConfig{timeout: defaultShutdownTimeout, logWait: defaultShutdownLogWait}
+	if value := strings.TrimSpace(os.Getenv(envShutdownTimeout)); value != emptyString {
+		timeout, err := time.ParseDuration(value)
+		if err != nil || timeout <= 0 {
+			return shutdownConfig{}, errors.New(op).Msg(envShutdownTimeout + " must be a positive duration")
+		}
+		config.timeout = timeout
+	}
+	if value := strings.TrimSpace(os.Getenv(envShutdownLogWait)); value != emptyString {
+		wait, err := time.ParseDuration(value)
+		if err != nil || wait < 0 {
+			return shutdownConfig{}, errors.New(op).Msg(envShutdownLogWait + " must be a duration")
+		}
+		config.logWait = wait
+	}
+	if value := strings.TrimSpace(os.Getenv(envShutdownForceClose)); value != emptyString {
+		force, err := loadBool(envShutdownForceClose)
+		if err != nil {
+			return shutdownConfig{}, errors.New(op).Err(err)
+		}
+		config.leaveRunning = !force
+	}
+	switch strings.ToLower(strings.TrimSpace(os.Getenv(envShutdownJobs))) {
+	case emptyString, shutdownJobsRequeue:
+	case shutdownJobsFinish:
+		config.finishJobs = true
+	default:
+		return shutdownConfig{}, errors.New(op).Msg(envShutdownJobs + " must be requeue or finish")
+	}
+	return config, nil
+}
+
+// drainTimeout returns the drain deadline's distance, the default one for a zero config.
+func (c shutdownConfig) drainTimeout() time.Duration {
+	if c.timeout <= 0 {
+		return defaultShutdownTimeout
+	}
+	return c.timeout
+}
+
+// shutdownReport counts the work a shutdown left unfinished, mostly because the drain deadline
+// passed.
+type shutdownReport struct {
+	// requests is the number of connections still serving a request at the deadline.
+	requests int32
+	// grpcCalls is set if gRPC calls were still running at the deadline.
+	grpcCalls bool
+	// preforkChildren is the number of prefork children that had not exited.
+	preforkChildren int
+	// inserts is the number of queued QSO inserts that were not stored.
+	inserts int
+	// webhookEvents is the number of QSO events whose webhook deliveries were not queued.
+	webhookEvents int
+	// jobsRequeued is the number of running jobs interrupted and queued again.
+	jobsRequeued int32
+	// backgroundTasks is the number of background tasks that had not returned.
+	backgroundTasks int32
+}
+
+// dropped reports whether any work was left unfinished.
+func (r shutdownReport) dropped() bool {
+	return r != shutdownReport{}
+}
+
+// log reports the work the shutdown left unfinished, if any.
+func (r shutdownReport) log(s *Service) {
+	if !r.dropped() {
+		return
+	}
+	s.logger.WarnWith().
+		Int32("requests", r.requests).
+		Bool("grpc_calls", r.grpcCalls).
+		Int("prefork_children", r.preforkChildren).
+		Int("queued_inserts", r.inserts).
+		Int("webhook_events", r.webhookEvents).
+		Int32("jobs_requeued", r.jobsRequeued).
+		Int32("background_tasks", r.backgroundTasks).
+		Str("timeout", s.shutdownConfig.drainTimeout().String()).
+		Msg("Shutdown left work unfinished")
+}
+
+// shutdownMiddleware ties each request's context to the server's, so that requests still running
+// when the drain deadline passes can be cancelled.
+func (s *Service) shutdownMiddleware() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		if s.requestsCtx == nil {
+			return c.Next()
+		}
+		ctx, cancel := context.WithCancel(c.UserContext())
+		defer cancel()
+		stop := context.AfterFunc(s.requestsCtx, cancel)
+		defer stop()
+		c.SetUserContext(ctx)
+		return c.Next()
+	}
+}
+
+// waitForConnections waits until the HTTP server has no open connections or the context expires.
+func (s *Service) waitForConnections(ctx context.Context) {
+	for s.app.Server().GetOpenConnectionsCount() > 0 {
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(shutdownPollInterval):
+		}
+	}
+}
+
+// waitForLogger waits until no log writes are in progress, or for the configured wait at most.
+func (s *Service) waitForLogger() {
+	deadline := time.Now().Add(s.shutdownConfig.logWait)
+	for s.logger.ActiveOperations() > 0 && time.Now().Before(deadline) {
+		time.Sleep(shutdownPollInterval)
+	}
+}
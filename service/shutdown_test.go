@@ -0,0 +1,102 @@
+package service
+
+import (
+	"context"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+func TestLoadShutdownConfig(t *testing.T) {
+	config, err := loadShutdownConfig()
+	if err != nil {
+		t.Fatalf("loadShutdownConfig failed: %v", err)
+	}
+	want := shutdownConfig{timeout: defaultShutdownTimeout, logWait: defaultShutdownLogWait}
+	if config != want {
+		t.Fatalf("expected %+v, got %+v", want, config)
+	}
+
+	t.Setenv(envShutdownTimeout, "1m")
+	t.Setenv(envShutdownForceClose, "false")
+	t.Setenv(envShutdownJobs, "finish")
+	t.Setenv(envShutdownLogWait, "0s")
+	if config, err = loadShutdownConfig(); err != nil {
+		t.Fatalf("loadShutdownConfig failed: %v", err)
+	}
+	want = shutdownConfig{timeout: time.Minute, leaveRunning: true, finishJobs: true}
+	if config != want {
+		t.Fatalf("expected %+v, got %+v", want, config)
+	}
+}
+
+func TestLoadShutdownConfig_Invalid(t *testing.T) {
+	for name, value := range map[string]string{
+		envShutdownTimeout:    "0s",
+		envShutdownForceClose: "perhaps",
+		envShutdownJobs:       "drop",
+		envShutdownLogWait:    "a while",
+	} {
+		t.Run(name, func(t *testing.T) {
+			t.Setenv(name, value)
+			if _, err := loadShutdownConfig(); err == nil {
+				t.Fatal("expected an error")
+			}
+		})
+	}
+}
+
+func TestShutdownMiddleware_CancelsRequests(t *testing.T) {
+	svc := &Service{app: fiber.New()}
+	svc.requestsCtx, svc.cancelRequests = context.WithCancel(context.Background())
+	svc.app.Use(svc.shutdownMiddleware())
+	svc.app.Get("/slow", func(c *fiber.Ctx) error {
+		if c.UserContext().Err() != nil {
+			return c.SendStatus(fiber.StatusInternalServerError)
+		}
+		svc.cancelRequests()
+		select {
+		case <-c.UserContext().Done():
+			return c.SendStatus(fiber.StatusNoContent)
+		case <-time.After(time.Second):
+			return c.SendStatus(fiber.StatusInternalServerError)
+		}
+	})
+
+	resp, err := svc.app.Test(httptest.NewRequest("GET", "/slow", nil))
+	if err != nil {
+		t.Fatalf("fiber test request failed: %v", err)
+	}
+	if resp.StatusCode != fiber.StatusNoContent {
+		t.Fatalf("expected the request's context to be cancelled with the server's, got status %d", resp.StatusCode)
+	}
+}
+
+func TestStopBackgroundTasks_InterruptsWorkAtDeadline(t *testing.T) {
+	dbSvc := newTestDatabaseService(t)
+	defer func() { _ = dbSvc.Close() }()
+
+	svc := &Service{db: dbSvc, logger: dbSvc.Logger, shutdownConfig: shutdownConfig{finishJobs: true}}
+	svc.startBackgroundTasks()
+
+	interrupted := make(chan struct{})
+	svc.runInBackground("work", func(ctx context.Context) {
+		<-ctx.Done()
+		// Work in hand carries on after the task is asked to stop, until the deadline.
+		<-svc.jobContext(ctx).Done()
+		close(interrupted)
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if running := svc.stopBackgroundTasks(ctx); running != 0 {
+		t.Fatalf("expected every task to return, %d did not", running)
+	}
+	select {
+	case <-interrupted:
+	default:
+		t.Fatal("expected the work to be interrupted at the deadline")
+	}
+}
@@ -0,0 +1,308 @@
+package service
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Station-Manager/errors"
+	"github.com/gofiber/fiber/v2"
+)
+
+// A client holding an API key may ask for a signing secret for it, and then sign its requests with
+// the secret instead of sending the key. A signed request carries the key's prefix, the time it was
+// signed and its signature:
+//
+//	X-SM-Key: <prefix>
+//	X-SM-Timestamp: <unix seconds>
+//	X-SM-Signature: sha256=<hex HMAC-SHA256 of the string to sign>
+//
+// The string to sign is the timestamp, the method, the path with its query and the hex SHA-256 of
+// the body, each followed by a newline but the last. Signatures older or newer than
+// signatureMaxSkew are rejected, and each is accepted once, so that a captured request cannot be
+// replayed. Replays are remembered by each server process; behind several instances, a request
+// replayed to another instance within the window is not detected.
+
+const (
+	headerRequestKey       = "X-SM-Key"
+	headerRequestTimestamp = "X-SM-Timestamp"
+	headerRequestSignature = "X-SM-Signature"
+
+	// signatureMaxSkew is how far a request's timestamp may be from the server's clock.
+	signatureMaxSkew = 5 * time.Minute
+	// signingSecretBytes is the length of a signing secret before encoding.
+	signingSecretBytes = 32
+	// replayGuardPruneSize is the number of signatures remembered above which the expired ones are
+	// dropped.
+	replayGuardPruneSize = 1024
+)
+
+// signingSecret is the signing secret of an API key, decrypted. An empty secret records that the
+// key has none, so that unsigned keys are not looked up on every attempt.
+type signingSecret struct {
+	LogbookID int64
+	Secret    string
+}
+
+// replayGuard remembers the signatures accepted until they expire.
+type replayGuard struct {
+	mu   sync.Mutex
+	seen map[string]time.Time
+}
+
+func newReplayGuard() *replayGuard {
+	return &replayGuard{seen: make(map[string]time.Time)}
+}
+
+// firstUse records the signature, valid until expires, and reports whether it was not recorded
+// already.
+func (g *replayGuard) firstUse(signature string, expires time.Time) bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	now := time.Now()
+	if until, ok := g.seen[signature]; ok && now.Before(until) {
+		return false
+	}
+	if len(g.seen) >= replayGuardPruneSize {
+		for sig, until := range g.seen {
+			if !now.Before(until) {
+				delete(g.seen, sig)
+			}
+		}
+	}
+	g.seen[signature] = expires
+	return true
+}
+
+// signRequest returns the signature of a request, as the X-SM-Signature header carries it.
+func signRequest(secret, timestamp, method, uri string, body []byte) string {
+	digest := sha256.Sum256(body)
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp + "\n" + method + "\n" + uri + "\n"))
+	mac.Write([]byte(hex.EncodeToString(digest[:])))
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// newSigningSecret returns a random signing secret.
+func newSigningSecret() (string, error) {
+	b := make([]byte, signingSecretBytes)
+	if _, err := rand.Read(b); err != nil {
+		return emptyString, err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// saveSigningSecret stores the API key's signing secret, replacing any it had.
+func (s *Service) saveSigningSecret(ctx context.Context, logbookID int64, prefix, secret string) error {
+	const op errors.Op = "server.Service.saveSigningSecret"
+
+	sealed, err := s.encryptCredential(secret)
+	if err != nil {
+		return errors.New(op).Err(err)
+	}
+	if _, err = s.db.ExecContext(ctx, `INSERT INTO api_key_signing_secrets (key_prefix, logbook_id, secret) VALUES ($1, $2, $3)
+		ON CONFLICT (key_prefix) DO UPDATE SET logbook_id = excluded.logbook_id, secret = excluded.secret, created_at = CURRENT_TIMESTAMP`,
+		prefix, logbookID, sealed); err != nil {
+		return errors.New(op).Err(err)
+	}
+	s.invalidateApiKey(ctx, prefix)
+	return nil
+}
+
+// deleteSigningSecret removes the API key's signing secret. It reports false when the key had none.
+func (s *Service) deleteSigningSecret(ctx context.Context, logbookID int64, prefix string) (bool, error) {
+	const op errors.Op = "server.Service.deleteSigningSecret"
+
+	result, err := s.db.ExecContext(ctx, `DELETE FROM api_key_signing_secrets WHERE key_prefix = $1 AND logbook_id = $2`, prefix, logbookID)
+	if err != nil {
+		return false, errors.New(op).Err(err)
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return false, errors.New(op).Err(err)
+	}
+	s.invalidateApiKey(ctx, prefix)
+	return n > 0, nil
+}
+
+// fetchSigningSecretWithCache returns the API key's signing secret, using an in-memory cache that
+// is invalidated together with the API key record. The secret is empty when the key has none.
+func (s *Service) fetchSigningSecretWithCache(ctx context.Context, prefix string) (signingSecret, error) {
+	const op errors.Op = "server.Service.fetchSigningSecretWithCache"
+
+	if secret, ok := s.signingSecretCache.Get(prefix); ok {
+		return secret, nil
+	}
+
+	rows, err := s.db.QueryContext(ctx, `SELECT logbook_id, secret FROM api_key_signing_secrets WHERE key_prefix = $1`, prefix)
+	if err != nil {
+		return signingSecret{}, errors.New(op).Err(err)
+	}
+	defer func() { _ = rows.Close() }()
+
+	var secret signingSecret
+	if rows.Next() {
+		var sealed string
+		if err = rows.Scan(&secret.LogbookID, &sealed); err != nil {
+			return signingSecret{}, errors.New(op).Err(err)
+		}
+		if secret.Secret, err = s.decryptCredential(sealed); err != nil {
+			return signingSecret{}, errors.New(op).Err(err)
+		}
+	}
+	if err = rows.Err(); err != nil {
+		return signingSecret{}, errors.New(op).Err(err)
+	}
+	s.signingSecretCache.Set(prefix, secret, defaultApiKeyCacheTTL)
+	return secret, nil
+}
+
+// verifyRequestSignature checks the signature of a signed request and returns the request context
+// of its API key. A rejected request returns a nil context and the reason to record in the audit
+// log.
+func (s *Service) verifyRequestSignature(c *fiber.Ctx) (*requestContext, string, error) {
+	const op errors.Op = "server.Service.verifyRequestSignature"
+
+	// The prefix outlives the request as a cache key, so it is copied out of the request buffer.
+	prefix := strings.Clone(c.Get(headerRequestKey))
+	timestamp := c.Get(headerRequestTimestamp)
+	signature := c.Get(headerRequestSignature)
+	if prefix == emptyString || len(prefix) > prefixLen || timestamp == emptyString {
+		return nil, "invalid_signature", nil
+	}
+	unix, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return nil, "invalid_signature", nil
+	}
+	signedAt := time.Unix(unix, 0)
+	if now := time.Now(); signedAt.Before(now.Add(-signatureMaxSkew)) || signedAt.After(now.Add(signatureMaxSkew)) {
+		return nil, "stale_signature", nil
+	}
+
+	ctx := c.UserContext()
+	secret, err := s.fetchSigningSecretWithCache(ctx, prefix)
+	if err != nil {
+		return nil, emptyString, errors.New(op).Err(err)
+	}
+	if secret.Secret == emptyString {
+		return nil, "invalid_signature", nil
+	}
+	want := signRequest(secret.Secret, timestamp, c.Method(), c.OriginalURL(), c.Body())
+	if !hmac.Equal([]byte(want), []byte(signature)) {
+		return nil, "invalid_signature", nil
+	}
+	// Only signatures that verify are remembered, so that nobody can spend one in advance.
+	if !s.signatureReplays.firstUse(prefix+":"+signature, signedAt.Add(signatureMaxSkew)) {
+		return nil, "replayed_signature", nil
+	}
+
+	model, err := s.fetchApiKeyWithCache(ctx, prefix)
+	if err != nil {
+		return nil, "invalid_key", errors.New(op).Err(err)
+	}
+	if model.LogbookID != secret.LogbookID {
+		return nil, "invalid_key", nil
+	}
+	reqCtx, reason, err := s.apiKeyRequestContext(ctx, prefix, model.LogbookID)
+	if err != nil {
+		return nil, reason, errors.New(op).Err(err)
+	}
+	return reqCtx, reason, nil
+}
+
+// authenticateSignedRequest is the part of apikeyHeaderAuthNMiddleware that authenticates signed
+// requests.
+func (s *Service) authenticateSignedRequest(c *fiber.Ctx) error {
+	const op errors.Op = "server.Service.authenticateSignedRequest"
+
+	reqCtx, reason, err := s.verifyRequestSignature(c)
+	if err != nil {
+		s.logger.ErrorWith().Err(errors.New(op).Err(err)).Msg("s.verifyRequestSignature failed")
+	}
+	if reqCtx == nil {
+		if reason != emptyString {
+			s.audit(c, auditEntry{Event: auditApiKeyFailed, Actor: apiKeyActorPrefix + c.Get(headerRequestKey)}, fiber.Map{"reason": reason, "path": c.Path()})
+		}
+		return c.Status(fiber.StatusUnauthorized).JSON(jsonUnauthorized)
+	}
+	setRequestContext(c, reqCtx)
+
+	return c.Next()
+}
+
+// signingKeyPrefix returns the prefix of the API key that authenticated the request.
+func signingKeyPrefix(c *fiber.Ctx) (string, bool) {
+	reqCtx, err := getRequestContext(c)
+	if err != nil || reqCtx.Logbook == nil {
+		return emptyString, false
+	}
+	return strings.CutPrefix(reqCtx.Actor, apiKeyActorPrefix)
+}
+
+// issueSigningSecretHandler issues a signing secret for the API key that authenticated the request,
+// replacing any it had. The secret is returned once and cannot be read back.
+func (s *Service) issueSigningSecretHandler(c *fiber.Ctx) error {
+	const op errors.Op = "server.Service.issueSigningSecretHandler"
+
+	logbook, err := authenticatedLogbook(c)
+	if err != nil {
+		s.logger.ErrorWith().Err(errors.New(op).Err(err)).Msg("authenticatedLogbook failed")
+		return c.Status(fiber.StatusInternalServerError).JSON(jsonInternalError)
+	}
+	prefix, ok := signingKeyPrefix(c)
+	if !ok {
+		s.logger.ErrorWith().Err(errors.New(op).Msg("Request not authenticated by an API key")).Msg("signingKeyPrefix failed")
+		return c.Status(fiber.StatusInternalServerError).JSON(jsonInternalError)
+	}
+	if len(s.credentialsKey) == 0 {
+		return c.Status(fiber.StatusServiceUnavailable).JSON(jsonError(errCodeCredentialStorageDisabled, "Credential storage is not configured on this server"))
+	}
+
+	secret, err := newSigningSecret()
+	if err != nil {
+		s.logger.ErrorWith().Err(errors.New(op).Err(err)).Msg("newSigningSecret failed")
+		return c.Status(fiber.StatusInternalServerError).JSON(jsonInternalError)
+	}
+	if err = s.saveSigningSecret(c.UserContext(), logbook.ID, prefix, secret); err != nil {
+		s.logger.ErrorWith().Err(errors.New(op).Err(err)).Msg("s.saveSigningSecret failed")
+		return s.dbFailure(c, err)
+	}
+
+	return c.Status(fiber.StatusCreated).JSON(fiber.Map{"key": prefix, "secret": secret})
+}
+
+// deleteSigningSecretHandler removes the signing secret of the API key that authenticated the
+// request, after which requests signed with it are rejected.
+func (s *Service) deleteSigningSecretHandler(c *fiber.Ctx) error {
+	const op errors.Op = "server.Service.deleteSigningSecretHandler"
+
+	logbook, err := authenticatedLogbook(c)
+	if err != nil {
+		s.logger.ErrorWith().Err(errors.New(op).Err(err)).Msg("authenticatedLogbook failed")
+		return c.Status(fiber.StatusInternalServerError).JSON(jsonInternalError)
+	}
+	prefix, ok := signingKeyPrefix(c)
+	if !ok {
+		s.logger.ErrorWith().Err(errors.New(op).Msg("Request not authenticated by an API key")).Msg("signingKeyPrefix failed")
+		return c.Status(fiber.StatusInternalServerError).JSON(jsonInternalError)
+	}
+
+	found, err := s.deleteSigningSecret(c.UserContext(), logbook.ID, prefix)
+	if err != nil {
+		s.logger.ErrorWith().Err(errors.New(op).Err(err)).Msg("s.deleteSigningSecret failed")
+		return s.dbFailure(c, err)
+	}
+	if !found {
+		return c.Status(fiber.StatusNotFound).JSON(jsonError(errCodeNotFound, "The API key has no signing secret"))
+	}
+
+	return c.SendStatus(fiber.StatusNoContent)
+}
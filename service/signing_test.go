@@ -0,0 +1,117 @@
+package service
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/goccy/go-json"
+	"github.com/gofiber/fiber/v2"
+)
+
+// newSignedRequest returns a request signed with the secret of the API key with the prefix.
+func newSignedRequest(method, uri, prefix, secret string, signedAt time.Time) *http.Request {
+	timestamp := strconv.FormatInt(signedAt.Unix(), 10)
+	req := httptest.NewRequest(method, uri, nil)
+	req.Header.Set(headerRequestKey, prefix)
+	req.Header.Set(headerRequestTimestamp, timestamp)
+	req.Header.Set(headerRequestSignature, signRequest(secret, timestamp, method, uri, nil))
+	return req
+}
+
+func TestReplayGuard(t *testing.T) {
+	g := newReplayGuard()
+	if !g.firstUse("a", time.Now().Add(time.Minute)) {
+		t.Fatal("expected the first use to be accepted")
+	}
+	if g.firstUse("a", time.Now().Add(time.Minute)) {
+		t.Error("expected the second use to be rejected")
+	}
+	if !g.firstUse("b", time.Now().Add(-time.Second)) || !g.firstUse("b", time.Now().Add(time.Minute)) {
+		t.Error("expected an expired signature to be forgotten")
+	}
+}
+
+func TestSignedRequests(t *testing.T) {
+	svc := newTestServer(t)
+	ctx := context.Background()
+	for _, query := range []string{
+		`INSERT INTO users (id, callsign, pass_hash) VALUES (8, 'K1AB', 'hash')`,
+		`UPDATE logbook SET user_id = 8 WHERE id = 1`,
+	} {
+		if _, err := svc.db.ExecContext(ctx, query); err != nil {
+			t.Fatalf("setup failed: %v", err)
+		}
+	}
+	key, err := svc.issueMemberApiKey(ctx, 1, 8, "K1AB")
+	if err != nil {
+		t.Fatalf("issueMemberApiKey failed: %v", err)
+	}
+	prefix := key[:prefixLen]
+
+	issue := func() *http.Response {
+		req := httptest.NewRequest(fiber.MethodPost, "/keys/signing-secret", nil)
+		req.Header.Set(fiber.HeaderAuthorization, "Bearer "+key)
+		resp, err := svc.app.Test(req)
+		if err != nil {
+			t.Fatalf("POST /keys/signing-secret failed: %v", err)
+		}
+		return resp
+	}
+	if resp := issue(); resp.StatusCode != fiber.StatusServiceUnavailable {
+		t.Fatalf("expected 503 without a credentials key, got %d", resp.StatusCode)
+	}
+
+	svc.credentialsKey = make([]byte, 32)
+	resp := issue()
+	if resp.StatusCode != fiber.StatusCreated {
+		t.Fatalf("expected 201, got %d", resp.StatusCode)
+	}
+	var issued struct {
+		Key    string `json:"key"`
+		Secret string `json:"secret"`
+	}
+	if err = json.NewDecoder(resp.Body).Decode(&issued); err != nil || issued.Key != prefix || issued.Secret == emptyString {
+		t.Fatalf("expected the key's new secret, got %+v (err=%v)", issued, err)
+	}
+
+	status := func(req *http.Request) int {
+		resp, err := svc.app.Test(req)
+		if err != nil {
+			t.Fatalf("signed request failed: %v", err)
+		}
+		return resp.StatusCode
+	}
+	now := time.Now()
+	signed := newSignedRequest(fiber.MethodGet, "/webhooks", prefix, issued.Secret, now)
+	if got := status(signed); got != fiber.StatusOK {
+		t.Fatalf("expected a signed request to be accepted, got %d", got)
+	}
+	replayed := newSignedRequest(fiber.MethodGet, "/webhooks", prefix, issued.Secret, now)
+	if got := status(replayed); got != fiber.StatusUnauthorized {
+		t.Errorf("expected a replayed request to be rejected, got %d", got)
+	}
+	if got := status(newSignedRequest(fiber.MethodGet, "/webhooks", prefix, "wrong", now.Add(time.Second))); got != fiber.StatusUnauthorized {
+		t.Errorf("expected a wrong signature to be rejected, got %d", got)
+	}
+	if got := status(newSignedRequest(fiber.MethodGet, "/webhooks", prefix, issued.Secret, now.Add(-10*time.Minute))); got != fiber.StatusUnauthorized {
+		t.Errorf("expected a stale request to be rejected, got %d", got)
+	}
+	tampered := newSignedRequest(fiber.MethodGet, "/webhooks", prefix, issued.Secret, now.Add(2*time.Second))
+	tampered.URL.RawQuery = "limit=1"
+	tampered.RequestURI = "/webhooks?limit=1"
+	if got := status(tampered); got != fiber.StatusUnauthorized {
+		t.Errorf("expected a request changed after signing to be rejected, got %d", got)
+	}
+
+	req := newSignedRequest(fiber.MethodDelete, "/keys/signing-secret", prefix, issued.Secret, now.Add(3*time.Second))
+	if got := status(req); got != fiber.StatusNoContent {
+		t.Fatalf("expected the secret to be deleted, got %d", got)
+	}
+	if got := status(newSignedRequest(fiber.MethodGet, "/webhooks", prefix, issued.Secret, now.Add(4*time.Second))); got != fiber.StatusUnauthorized {
+		t.Errorf("expected requests signed with a deleted secret to be rejected, got %d", got)
+	}
+}
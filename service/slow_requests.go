@@ -0,0 +1,286 @@
+package service
+
+import (
+	"context"
+	"database/sql"
+	"maps"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Station-Manager/errors"
+	"github.com/Station-Manager/types"
+	"github.com/aarondl/sqlboiler/v4/boil"
+	"github.com/gofiber/fiber/v2"
+)
+
+// Requests that take longer than a threshold are logged with where their time went: the number of
+// database calls the request made and the time spent in them. Database calls that take longer than
+// a threshold of their own are logged too. Both are counted by route in the Prometheus metrics, so
+// that a regression shows up on a dashboard before users report it.
+
+// envSlowRequestThreshold names the environment variable holding how long a request may take
+// before it is logged as slow, as a Go duration (e.g. "500ms"); "0" disables the log.
+const envSlowRequestThreshold = "SM_SLOW_REQUEST_THRESHOLD"
+
+// envSlowQueryThreshold names the environment variable holding how long a database call made for
+// a request may take before it is logged as slow; "0" disables the log.
+const envSlowQueryThreshold = "SM_SLOW_QUERY_THRESHOLD"
+
+const (
+	defaultSlowRequestThreshold = 1 * time.Second
+	defaultSlowQueryThreshold   = 250 * time.Millisecond
+
+	// slowQueryStatementLen is how much of a slow statement is logged.
+	slowQueryStatementLen = 120
+)
+
+// slowLog holds the slow request and query thresholds and counts, by route, what exceeded them.
+type slowLog struct {
+	request time.Duration
+	query   time.Duration
+
+	mu       sync.Mutex
+	requests map[string]uint64
+	queries  map[string]uint64
+}
+
+// loadSlowLog reads the slow request and query thresholds from the environment.
+func loadSlowLog() (*slowLog, error) {
+	const op errors.Op = "server.loadSlowLog"
+
+	l := &slowLog{
+		request:  defaultSlowRequestThreshold,
+		query:    defaultSlowQueryThreshold,
+		requests: make(map[string]uint64),
+		queries:  make(map[string]uint64),
+	}
+	for env, threshold := range map[string]*time.Duration{envSlowRequestThreshold: &l.request, envSlowQueryThreshold: &l.query} {
+		value := strings.TrimSpace(os.Getenv(env))
+		if value == emptyString {
+			continue
+		}
+		d, err := time.ParseDuration(value)
+		if err != nil || d < 0 {
+			return nil, errors.New(op).Msg(env + " must be a non-negative duration")
+		}
+		*threshold = d
+	}
+	return l, nil
+}
+
+// count adds a slow request and n slow queries of the route.
+func (l *slowLog) count(route string, request bool, n int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if request {
+		l.requests[route]++
+	}
+	if n > 0 {
+		l.queries[route] += uint64(n)
+	}
+}
+
+// metrics returns the counts of slow requests and queries, labelled with their routes.
+func (l *slowLog) metrics() []metricFamily {
+	families := []metricFamily{
+		{Name: "station_manager_slow_requests_total", Help: "Requests that took longer than the slow request threshold.", Type: metricTypeCounter},
+		{Name: "station_manager_slow_queries_total", Help: "Database calls made for requests that took longer than the slow query threshold.", Type: metricTypeCounter},
+	}
+	if l == nil {
+		return families
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for i, counts := range []map[string]uint64{l.requests, l.queries} {
+		for _, route := range slices.Sorted(maps.Keys(counts)) {
+			families[i].Samples = append(families[i].Samples, metricSample{Labels: []string{"route", route}, Value: float64(counts[route])})
+		}
+	}
+	return families
+}
+
+// dbCall is a database call made for a request and how long it took.
+type dbCall struct {
+	Op      string
+	Elapsed time.Duration
+}
+
+// requestTiming accumulates the time a request spends in the database.
+type requestTiming struct {
+	slowQuery time.Duration
+
+	mu    sync.Mutex
+	calls int
+	total time.Duration
+	slow  []dbCall
+}
+
+type requestTimingKey struct{}
+
+// withRequestTiming returns ctx carrying timing, for the store to record the request's database
+// calls in.
+func withRequestTiming(ctx context.Context, timing *requestTiming) context.Context {
+	return context.WithValue(ctx, requestTimingKey{}, timing)
+}
+
+// recordDBCall adds the database call started at start, named by op or its SQL statement, to the
+// timing of the request ctx belongs to, if it has one.
+func recordDBCall(ctx context.Context, op string, start time.Time) {
+	timing, ok := ctx.Value(requestTimingKey{}).(*requestTiming)
+	if !ok {
+		return
+	}
+	elapsed := time.Since(start)
+
+	timing.mu.Lock()
+	defer timing.mu.Unlock()
+	timing.calls++
+	timing.total += elapsed
+	if timing.slowQuery > 0 && elapsed >= timing.slowQuery {
+		timing.slow = append(timing.slow, dbCall{Op: statementOp(op), Elapsed: elapsed})
+	}
+}
+
+// snapshot returns the number of calls, the time spent in them and the slow ones.
+func (t *requestTiming) snapshot() (int, time.Duration, []dbCall) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.calls, t.total, t.slow
+}
+
+// slowRequestMiddleware times each request and its database calls, and logs and counts those that
+// exceed their thresholds.
+func (s *Service) slowRequestMiddleware() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		if s.slowLog == nil || (s.slowLog.request <= 0 && s.slowLog.query <= 0) {
+			return c.Next()
+		}
+
+		timing := &requestTiming{slowQuery: s.slowLog.query}
+		c.SetUserContext(withRequestTiming(c.UserContext(), timing))
+		start := time.Now()
+		err := c.Next()
+		elapsed := time.Since(start)
+
+		calls, dbTime, slow := timing.snapshot()
+		slowRequest := s.slowLog.request > 0 && elapsed >= s.slowLog.request
+		if !slowRequest && len(slow) == 0 {
+			return err
+		}
+
+		route := c.Route().Path
+		callsign, logbookID := requestIdentity(c)
+		for _, call := range slow {
+			s.logger.WarnWith().Str("route", route).Str("op", call.Op).Int64("elapsed_ms", call.Elapsed.Milliseconds()).
+				Str("callsign", callsign).Int64("logbook_id", logbookID).Msg("Slow query")
+		}
+		if slowRequest {
+			s.logger.WarnWith().Str("method", c.Method()).Str("route", route).Str("path", c.Path()).Int("status", c.Response().StatusCode()).
+				Str("callsign", callsign).Int64("logbook_id", logbookID).Int64("elapsed_ms", elapsed.Milliseconds()).
+				Int("db_calls", calls).Int64("db_ms", dbTime.Milliseconds()).Int64("other_ms", (elapsed - dbTime).Milliseconds()).
+				Msg("Slow request")
+		}
+		s.slowLog.count(route, slowRequest, len(slow))
+		return err
+	}
+}
+
+// requestIdentity returns the callsign of the user or logbook the request authenticated as, and
+// the ID of its logbook, if any.
+func requestIdentity(c *fiber.Ctx) (string, int64) {
+	reqCtx, err := getRequestContext(c)
+	if err != nil || reqCtx == nil {
+		return emptyString, 0
+	}
+	callsign, logbookID := emptyString, int64(0)
+	if reqCtx.Logbook != nil {
+		callsign, logbookID = reqCtx.Logbook.Callsign, reqCtx.Logbook.ID
+	}
+	if reqCtx.User != nil {
+		callsign = reqCtx.User.Callsign
+	}
+	return callsign, logbookID
+}
+
+// statementOp shortens a SQL statement for the slow query log.
+func statementOp(query string) string {
+	op := strings.Join(strings.Fields(query), " ")
+	if len(op) > slowQueryStatementLen {
+		op = op[:slowQueryStatementLen] + "..."
+	}
+	return op
+}
+
+// timedStore records the time of each store call in the timing of the request it is made for.
+// Calls made within a transaction are timed with the transaction as a whole, by runTx.
+type timedStore struct {
+	store
+}
+
+func newTimedStore(db store) *timedStore {
+	return &timedStore{store: db}
+}
+
+func (t *timedStore) ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	defer recordDBCall(ctx, query, time.Now())
+	return t.store.ExecContext(ctx, query, args...)
+}
+
+func (t *timedStore) QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error) {
+	defer recordDBCall(ctx, query, time.Now())
+	return t.store.QueryContext(ctx, query, args...)
+}
+
+func (t *timedStore) InsertUserContext(ctx context.Context, user types.User) (types.User, error) {
+	defer recordDBCall(ctx, "InsertUser", time.Now())
+	return t.store.InsertUserContext(ctx, user)
+}
+
+func (t *timedStore) FetchUserByCallsignContext(ctx context.Context, callsign string) (types.User, error) {
+	defer recordDBCall(ctx, "FetchUserByCallsign", time.Now())
+	return t.store.FetchUserByCallsignContext(ctx, callsign)
+}
+
+func (t *timedStore) UpdateUserContext(ctx context.Context, user types.User) error {
+	defer recordDBCall(ctx, "UpdateUser", time.Now())
+	return t.store.UpdateUserContext(ctx, user)
+}
+
+func (t *timedStore) InsertAPIKeyContext(ctx context.Context, name, prefix, hash string, logbookID int64) error {
+	defer recordDBCall(ctx, "InsertAPIKey", time.Now())
+	return t.store.InsertAPIKeyContext(ctx, name, prefix, hash, logbookID)
+}
+
+func (t *timedStore) InsertAPIKeyWithTxContext(ctx context.Context, tx boil.ContextExecutor, name, prefix, hash string, logbookID int64) error {
+	return t.store.InsertAPIKeyWithTxContext(ctx, tx, name, prefix, hash, logbookID)
+}
+
+func (t *timedStore) FetchAPIKeyByPrefixContext(ctx context.Context, prefix string) (types.ApiKey, error) {
+	defer recordDBCall(ctx, "FetchAPIKeyByPrefix", time.Now())
+	return t.store.FetchAPIKeyByPrefixContext(ctx, prefix)
+}
+
+func (t *timedStore) FetchLogbookByIDContext(ctx context.Context, id int64) (types.Logbook, error) {
+	defer recordDBCall(ctx, "FetchLogbookByID", time.Now())
+	return t.store.FetchLogbookByIDContext(ctx, id)
+}
+
+func (t *timedStore) InsertQsoContext(ctx context.Context, qso types.Qso) (types.Qso, error) {
+	defer recordDBCall(ctx, "InsertQso", time.Now())
+	return t.store.InsertQsoContext(ctx, qso)
+}
+
+func (t *timedStore) FetchQsoByIdContext(ctx context.Context, id int64) (types.Qso, error) {
+	defer recordDBCall(ctx, "FetchQsoById", time.Now())
+	return t.store.FetchQsoByIdContext(ctx, id)
+}
+
+func (t *timedStore) UpdateQsoContext(ctx context.Context, qso types.Qso) error {
+	defer recordDBCall(ctx, "UpdateQso", time.Now())
+	return t.store.UpdateQsoContext(ctx, qso)
+}
@@ -0,0 +1,66 @@
+package service
+
+import (
+	"bufio"
+	"bytes"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Station-Manager/types"
+	"github.com/gofiber/fiber/v2"
+)
+
+func TestLoadSlowLog(t *testing.T) {
+	l, err := loadSlowLog()
+	if err != nil || l.request != defaultSlowRequestThreshold || l.query != defaultSlowQueryThreshold {
+		t.Fatalf("expected the default thresholds, got %+v %v", l, err)
+	}
+	t.Setenv(envSlowRequestThreshold, "0")
+	t.Setenv(envSlowQueryThreshold, "50ms")
+	if l, err = loadSlowLog(); err != nil || l.request != 0 || l.query != 50*time.Millisecond {
+		t.Errorf("expected the configured thresholds, got %+v %v", l, err)
+	}
+	t.Setenv(envSlowQueryThreshold, "soon")
+	if _, err = loadSlowLog(); err == nil {
+		t.Error("expected an invalid threshold to be rejected")
+	}
+}
+
+func TestSlowRequestMiddleware(t *testing.T) {
+	svc := newTestServer(t)
+	svc.db = newTimedStore(svc.db)
+	svc.slowLog, _ = loadSlowLog()
+	svc.slowLog.request, svc.slowLog.query = time.Nanosecond, time.Nanosecond
+
+	var timing *requestTiming
+	app := fiber.New()
+	app.Use(svc.slowRequestMiddleware())
+	app.Get("/logbooks/:id", func(c *fiber.Ctx) error {
+		c.Locals(localsRequestDataKey, &requestContext{Logbook: &types.Logbook{ID: 1, Callsign: "W1AW"}, IsValid: true})
+		timing = c.UserContext().Value(requestTimingKey{}).(*requestTiming)
+		if _, err := svc.db.FetchLogbookByIDContext(c.UserContext(), 1); err != nil {
+			return err
+		}
+		return c.SendStatus(fiber.StatusNoContent)
+	})
+	if resp, err := app.Test(httptest.NewRequest(fiber.MethodGet, "/logbooks/1", nil)); err != nil || resp.StatusCode != fiber.StatusNoContent {
+		t.Fatalf("request failed: %v %v", resp, err)
+	}
+
+	if calls, _, slow := timing.snapshot(); calls != 1 || len(slow) != 1 || slow[0].Op != "FetchLogbookByID" {
+		t.Errorf("expected the logbook fetch to be timed, got %d calls, slow %+v", calls, slow)
+	}
+	var out bytes.Buffer
+	w := bufio.NewWriter(&out)
+	_ = writeMetrics(w, svc.slowLog.metrics())
+	for _, line := range []string{
+		`station_manager_slow_requests_total{route="/logbooks/:id"} 1`,
+		`station_manager_slow_queries_total{route="/logbooks/:id"} 1`,
+	} {
+		if !strings.Contains(out.String(), line+"\n") {
+			t.Errorf("expected %q in the metrics, got:\n%s", line, out.String())
+		}
+	}
+}
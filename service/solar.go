@@ -0,0 +1,228 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/Station-Manager/errors"
+	"github.com/Station-Manager/server/service/solar"
+	"github.com/goccy/go-json"
+)
+
+// envSolarURL names the environment variable that overrides where the solar and propagation report
+// is downloaded from.
+const envSolarURL = "SM_SOLAR_URL"
+
+const (
+	defaultSolarURL = "https://www.hamqsl.com/solarxml.php"
+	// The report is recalculated every three hours; checking hourly picks each one up soon after.
+	defaultSolarRefreshInterval = time.Hour
+	defaultSolarRequestTimeout  = 30 * time.Second
+	solarMaxBytes               = 1 << 20
+	// solarRetention is how long reports are kept for comparing with past activity, counted back
+	// from the latest.
+	solarRetention = 366 * 24 * time.Hour
+
+	defaultPropagationDays = 30
+)
+
+// solarTracker keeps the latest solar and propagation report. latest is nil until a report has
+// been downloaded or loaded.
+type solarTracker struct {
+	url             string
+	client          *http.Client
+	refreshInterval time.Duration
+	latest          atomic.Pointer[solar.Report]
+}
+
+func newSolarTracker() *solarTracker {
+	url := strings.TrimSpace(os.Getenv(envSolarURL))
+	if url == emptyString {
+		url = defaultSolarURL
+	}
+	return &solarTracker{
+		url:             url,
+		client:          &http.Client{Timeout: defaultSolarRequestTimeout},
+		refreshInterval: defaultSolarRefreshInterval,
+	}
+}
+
+// propagationDay sets the solar indices of a UTC day beside the QSOs the logbook logged on it. The
+// solar flux, A index and sunspot number are the day's last report's; the K index is the day's
+// highest. Indices are -1 on days without a report.
+type propagationDay struct {
+	Date      time.Time `json:"date"`
+	SolarFlux int       `json:"solar_flux"`
+	AIndex    int       `json:"a_index"`
+	KIndex    int       `json:"k_index"`
+	Sunspots  int       `json:"sunspots"`
+	Qsos      int64     `json:"qsos"`
+}
+
+// propagationReport is the current solar and propagation report with the recent days' indices and
+// activity.
+type propagationReport struct {
+	Current *solar.Report    `json:"current"`
+	Days    []propagationDay `json:"days"`
+}
+
+// loadStoredSolarRefresh activates the latest stored report and reports when it was downloaded,
+// so that the refresh is due at once when there is none.
+func (s *Service) loadStoredSolarRefresh(ctx context.Context) (time.Time, bool) {
+	const op errors.Op = "server.Service.loadStoredSolarRefresh"
+
+	rows, err := s.db.QueryContext(ctx, `SELECT report, fetched_at FROM solar_reports ORDER BY updated_at DESC LIMIT 1`)
+	if err != nil {
+		s.logger.WarnWith().Err(errors.New(op).Err(err)).Msg("Failed to load the stored solar report")
+		return time.Time{}, true
+	}
+	defer func() { _ = rows.Close() }()
+
+	if !rows.Next() {
+		return time.Time{}, true
+	}
+	var content string
+	var fetchedAt time.Time
+	if err = rows.Scan(&content, &fetchedAt); err != nil {
+		s.logger.WarnWith().Err(errors.New(op).Err(err)).Msg("Failed to load the stored solar report")
+		return time.Time{}, true
+	}
+	var report solar.Report
+	if err = json.Unmarshal([]byte(content), &report); err != nil {
+		s.logger.WarnWith().Err(errors.New(op).Err(err)).Msg("Failed to load the stored solar report")
+		return time.Time{}, true
+	}
+	s.solar.latest.Store(&report)
+	return fetchedAt, true
+}
+
+// refreshSolarReport downloads the solar and propagation report, activates it and keeps it, purging
+// reports more than solarRetention older than it. A download that does not parse leaves the current report in
+// place.
+func (s *Service) refreshSolarReport(ctx context.Context) error {
+	const op errors.Op = "server.Service.refreshSolarReport"
+
+	content, err := s.downloadSolarReport(ctx)
+	if err != nil {
+		return errors.New(op).Err(err)
+	}
+	report, err := solar.Parse(bytes.NewReader(content))
+	if err != nil {
+		return errors.New(op).Err(err)
+	}
+	encoded, err := json.Marshal(report)
+	if err != nil {
+		return errors.New(op).Err(err)
+	}
+
+	if _, err = s.db.ExecContext(ctx, `INSERT INTO solar_reports (updated_at, solar_flux, a_index, k_index, sunspots, report) VALUES ($1, $2, $3, $4, $5, $6)
+		ON CONFLICT (updated_at) DO UPDATE SET solar_flux = excluded.solar_flux, a_index = excluded.a_index, k_index = excluded.k_index,
+			sunspots = excluded.sunspots, report = excluded.report, fetched_at = CURRENT_TIMESTAMP`,
+		s.dbTimestamp(report.Updated), report.SolarFlux, report.AIndex, report.KIndex, report.Sunspots, string(encoded)); err != nil {
+		return errors.New(op).Err(err)
+	}
+	if _, err = s.db.ExecContext(ctx, `DELETE FROM solar_reports WHERE updated_at < $1`, s.dbTimestamp(report.Updated.Add(-solarRetention))); err != nil {
+		return errors.New(op).Err(err)
+	}
+	s.solar.latest.Store(&report)
+	s.logger.DebugWith().Int("solar_flux", report.SolarFlux).Int("k_index", report.KIndex).Msg("Solar report refreshed")
+
+	return nil
+}
+
+func (s *Service) downloadSolarReport(ctx context.Context) ([]byte, error) {
+	const op errors.Op = "server.Service.downloadSolarReport"
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, s.solar.url, nil)
+	if err != nil {
+		return nil, errors.New(op).Err(err)
+	}
+	resp, err := s.solar.client.Do(req)
+	if err != nil {
+		return nil, errors.New(op).Err(err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, errors.New(op).Errorf("Solar report download returned status %d", resp.StatusCode)
+	}
+	content, err := io.ReadAll(io.LimitReader(resp.Body, solarMaxBytes))
+	if err != nil {
+		return nil, errors.New(op).Err(err)
+	}
+	return content, nil
+}
+
+// fetchPropagation returns the current report and, for each of the days up to and including now's,
+// the day's solar indices and the logbook's QSOs.
+func (s *Service) fetchPropagation(ctx context.Context, logbookID int64, days int, now time.Time) (propagationReport, error) {
+	const op errors.Op = "server.Service.fetchPropagation"
+
+	today := now.UTC().Truncate(24 * time.Hour)
+	first := today.AddDate(0, 0, 1-days)
+	report := propagationReport{Days: make([]propagationDay, days)}
+	for i := range report.Days {
+		report.Days[i] = propagationDay{Date: first.AddDate(0, 0, i), SolarFlux: -1, AIndex: -1, KIndex: -1, Sunspots: -1}
+	}
+	dayIndex := func(t time.Time) (int, bool) {
+		i := int(t.UTC().Sub(first) / (24 * time.Hour))
+		return i, !t.Before(first) && i < days
+	}
+	if s.solar != nil {
+		report.Current = s.solar.latest.Load()
+	}
+
+	rows, err := s.db.QueryContext(ctx, `SELECT updated_at, solar_flux, a_index, k_index, sunspots FROM solar_reports
+		WHERE updated_at >= $1 ORDER BY updated_at`, s.dbTimestamp(first))
+	if err != nil {
+		return propagationReport{}, errors.New(op).Err(err)
+	}
+	defer func() { _ = rows.Close() }()
+
+	for rows.Next() {
+		var updated time.Time
+		var flux, a, k, sunspots int
+		if err = rows.Scan(&updated, &flux, &a, &k, &sunspots); err != nil {
+			return propagationReport{}, errors.New(op).Err(err)
+		}
+		i, ok := dayIndex(updated)
+		if !ok {
+			continue
+		}
+		day := &report.Days[i]
+		day.SolarFlux, day.AIndex, day.Sunspots = laterIndex(day.SolarFlux, flux), laterIndex(day.AIndex, a), laterIndex(day.Sunspots, sunspots)
+		day.KIndex = max(day.KIndex, k)
+	}
+	if err = rows.Err(); err != nil {
+		return propagationReport{}, errors.New(op).Err(err)
+	}
+
+	activity, err := s.fetchActivity(ctx, logbookID, activityQuery{
+		Bucket: activityBucketDay,
+		From:   first.Format(activityBucketLayouts[activityBucketDay]),
+		To:     today.Format(activityBucketLayouts[activityBucketDay]),
+	})
+	if err != nil {
+		return propagationReport{}, errors.New(op).Err(err)
+	}
+	for _, point := range activity.Points {
+		if i, ok := dayIndex(point.Start); ok {
+			report.Days[i].Qsos = point.Count
+		}
+	}
+	return report, nil
+}
+
+// laterIndex returns a later report's index, unless that report is missing it.
+func laterIndex(earlier, later int) int {
+	if later < 0 {
+		return earlier
+	}
+	return later
+}
@@ -0,0 +1,135 @@
+// Package solar reads the solar and propagation report published by N0NBH at
+// https://www.hamqsl.com/solarxml.php: the solar flux, A and K indices and sunspot number, and the
+// HF band conditions and VHF phenomena calculated from them.
+package solar
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// BandCondition is the calculated condition of a range of HF bands by day or night.
+type BandCondition struct {
+	Bands     string `json:"bands"` // e.g. "80m-40m"
+	Time      string `json:"time"`  // day or night
+	Condition string `json:"condition"`
+}
+
+// VhfCondition is the calculated state of a VHF propagation mode in a region.
+type VhfCondition struct {
+	Phenomenon string `json:"phenomenon"` // e.g. "vhf-aurora" or "E-Skip"
+	Location   string `json:"location"`
+	Condition  string `json:"condition"`
+}
+
+// Report is a solar and propagation report. Indices the source did not report are -1.
+type Report struct {
+	Source      string          `json:"source"`
+	Updated     time.Time       `json:"updated"`
+	SolarFlux   int             `json:"solar_flux"`
+	AIndex      int             `json:"a_index"`
+	KIndex      int             `json:"k_index"`
+	Sunspots    int             `json:"sunspots"`
+	XRay        string          `json:"xray,omitempty"`
+	SolarWind   float64         `json:"solar_wind,omitempty"` // km/s
+	GeomagField string          `json:"geomag_field,omitempty"`
+	SignalNoise string          `json:"signal_noise,omitempty"`
+	Bands       []BandCondition `json:"bands"`
+	Vhf         []VhfCondition  `json:"vhf"`
+}
+
+type document struct {
+	Data struct {
+		Source      string `xml:"source"`
+		Updated     string `xml:"updated"`
+		SolarFlux   string `xml:"solarflux"`
+		AIndex      string `xml:"aindex"`
+		KIndex      string `xml:"kindex"`
+		Sunspots    string `xml:"sunspots"`
+		XRay        string `xml:"xray"`
+		SolarWind   string `xml:"solarwind"`
+		GeomagField string `xml:"geomagfield"`
+		SignalNoise string `xml:"signalnoise"`
+		Bands       []struct {
+			Name      string `xml:"name,attr"`
+			Time      string `xml:"time,attr"`
+			Condition string `xml:",chardata"`
+		} `xml:"calculatedconditions>band"`
+		Vhf []struct {
+			Name      string `xml:"name,attr"`
+			Location  string `xml:"location,attr"`
+			Condition string `xml:",chardata"`
+		} `xml:"calculatedvhfconditions>phenomenon"`
+	} `xml:"solardata"`
+}
+
+// Parse reads a report. The update time is required, since reports are kept by it; everything
+// else is optional.
+func Parse(r io.Reader) (Report, error) {
+	var doc document
+	decoder := xml.NewDecoder(r)
+	decoder.CharsetReader = charsetReader
+	if err := decoder.Decode(&doc); err != nil {
+		return Report{}, err
+	}
+	d := doc.Data
+	updated, err := time.Parse("02 Jan 2006 1504 MST", strings.Join(strings.Fields(d.Updated), " "))
+	if err != nil {
+		return Report{}, fmt.Errorf("solar: bad update time %q", strings.TrimSpace(d.Updated))
+	}
+
+	report := Report{
+		Source:      strings.TrimSpace(d.Source),
+		Updated:     updated.UTC(),
+		SolarFlux:   index(d.SolarFlux),
+		AIndex:      index(d.AIndex),
+		KIndex:      index(d.KIndex),
+		Sunspots:    index(d.Sunspots),
+		XRay:        strings.TrimSpace(d.XRay),
+		GeomagField: strings.TrimSpace(d.GeomagField),
+		SignalNoise: strings.TrimSpace(d.SignalNoise),
+		Bands:       make([]BandCondition, 0, len(d.Bands)),
+		Vhf:         make([]VhfCondition, 0, len(d.Vhf)),
+	}
+	if wind, err := strconv.ParseFloat(strings.TrimSpace(d.SolarWind), 64); err == nil {
+		report.SolarWind = wind
+	}
+	for _, b := range d.Bands {
+		report.Bands = append(report.Bands, BandCondition{Bands: b.Name, Time: b.Time, Condition: strings.TrimSpace(b.Condition)})
+	}
+	for _, v := range d.Vhf {
+		report.Vhf = append(report.Vhf, VhfCondition{Phenomenon: v.Name, Location: v.Location, Condition: strings.TrimSpace(v.Condition)})
+	}
+	return report, nil
+}
+
+// index reads a whole-number index, or -1 when it is missing or not a number.
+func index(value string) int {
+	n, err := strconv.Atoi(strings.TrimSpace(value))
+	if err != nil || n < 0 {
+		return -1
+	}
+	return n
+}
+
+// charsetReader decodes the ISO-8859-1 the report is published in.
+func charsetReader(charset string, input io.Reader) (io.Reader, error) {
+	switch strings.ToLower(charset) {
+	case "iso-8859-1", "latin1":
+		content, err := io.ReadAll(input)
+		if err != nil {
+			return nil, err
+		}
+		runes := make([]rune, len(content))
+		for i, b := range content {
+			runes[i] = rune(b)
+		}
+		return strings.NewReader(string(runes)), nil
+	default:
+		return nil, fmt.Errorf("solar: unsupported charset %q", charset)
+	}
+}
@@ -0,0 +1,68 @@
+package solar
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+const testReport = `<?xml version="1.0" encoding="ISO-8859-1"?>
+<solar>
+<solardata>
+	<source url="http://www.hamqsl.com/solar.html">N0NBH</source>
+	<updated> 23 Nov 2024 1207 GMT</updated>
+	<solarflux>148</solarflux>
+	<aindex> 5</aindex>
+	<kindex>1</kindex>
+	<kindexnt>No Report</kindexnt>
+	<xray>B5.0</xray>
+	<sunspots>120</sunspots>
+	<solarwind>350.2</solarwind>
+	<calculatedconditions>
+		<band name="80m-40m" time="day">Fair</band>
+		<band name="30m-20m" time="day">Good</band>
+		<band name="80m-40m" time="night">Good</band>
+	</calculatedconditions>
+	<calculatedvhfconditions>
+		<phenomenon name="vhf-aurora" location="northern_hemi">Band Closed</phenomenon>
+		<phenomenon name="E-Skip" location="europe">50MHz ES</phenomenon>
+	</calculatedvhfconditions>
+	<geomagfield>QUIET</geomagfield>
+	<signalnoise>S0-S1</signalnoise>
+</solardata>
+</solar>`
+
+func TestParse(t *testing.T) {
+	report, err := Parse(strings.NewReader(testReport))
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+	if !report.Updated.Equal(time.Date(2024, 11, 23, 12, 7, 0, 0, time.UTC)) || report.Source != "N0NBH" {
+		t.Errorf("unexpected source or update time %q %v", report.Source, report.Updated)
+	}
+	if report.SolarFlux != 148 || report.AIndex != 5 || report.KIndex != 1 || report.Sunspots != 120 || report.SolarWind != 350.2 {
+		t.Errorf("unexpected indices %+v", report)
+	}
+	if report.GeomagField != "QUIET" || report.SignalNoise != "S0-S1" || report.XRay != "B5.0" {
+		t.Errorf("unexpected conditions %+v", report)
+	}
+	if len(report.Bands) != 3 || report.Bands[2] != (BandCondition{Bands: "80m-40m", Time: "night", Condition: "Good"}) {
+		t.Errorf("unexpected bands %+v", report.Bands)
+	}
+	if len(report.Vhf) != 2 || report.Vhf[1] != (VhfCondition{Phenomenon: "E-Skip", Location: "europe", Condition: "50MHz ES"}) {
+		t.Errorf("unexpected VHF conditions %+v", report.Vhf)
+	}
+}
+
+func TestParse_MissingIndices(t *testing.T) {
+	report, err := Parse(strings.NewReader(`<solar><solardata><updated>01 Dec 2024 0000 GMT</updated><kindex>No Report</kindex></solardata></solar>`))
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+	if report.SolarFlux != -1 || report.KIndex != -1 || len(report.Bands) != 0 {
+		t.Errorf("expected missing indices, got %+v", report)
+	}
+	if _, err = Parse(strings.NewReader(`<solar><solardata><solarflux>100</solarflux></solardata></solar>`)); err == nil {
+		t.Error("expected a report without an update time to fail")
+	}
+}
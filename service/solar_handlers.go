@@ -0,0 +1,45 @@
+package service
+
+import (
+	"time"
+
+	"github.com/Station-Manager/errors"
+	"github.com/gofiber/fiber/v2"
+)
+
+// propagationRequest holds the query parameters of a propagation request.
+type propagationRequest struct {
+	Days int `query:"days" validate:"omitempty,min=1,max=365"`
+}
+
+// propagationHandler returns the current solar and propagation report with the solar indices and
+// the authenticated logbook's QSOs for each of the last days, for the dashboard. current is null
+// until the server has downloaded a report.
+func (s *Service) propagationHandler(c *fiber.Ctx) error {
+	const op errors.Op = "server.Service.propagationHandler"
+
+	logbook, err := authenticatedLogbook(c)
+	if err != nil {
+		s.logger.ErrorWith().Err(errors.New(op).Err(err)).Msg("authenticatedLogbook failed")
+		return c.Status(fiber.StatusInternalServerError).JSON(jsonInternalError)
+	}
+
+	var request propagationRequest
+	if err = c.QueryParser(&request); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(jsonBadRequest)
+	}
+	if err = s.validate.Struct(request); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(jsonBadRequest)
+	}
+	if request.Days == 0 {
+		request.Days = defaultPropagationDays
+	}
+
+	report, err := s.fetchPropagation(c.UserContext(), logbook.ID, request.Days, time.Now())
+	if err != nil {
+		s.logger.ErrorWith().Err(errors.New(op).Err(err)).Msg("s.fetchPropagation failed")
+		return c.Status(fiber.StatusInternalServerError).JSON(jsonInternalError)
+	}
+
+	return c.JSON(report)
+}
@@ -0,0 +1,92 @@
+package service
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testSolarReport returns a report updated at the time, with the solar flux and K index.
+func testSolarReport(updated, flux, k string) string {
+	return `<?xml version="1.0" encoding="ISO-8859-1"?><solar><solardata><source>N0NBH</source><updated>` + updated +
+		`</updated><solarflux>` + flux + `</solarflux><aindex>7</aindex><kindex>` + k + `</kindex><sunspots>120</sunspots>` +
+		`<calculatedconditions><band name="30m-20m" time="day">Good</band></calculatedconditions></solardata></solar>`
+}
+
+func TestSolarReport_RefreshAndPropagation(t *testing.T) {
+	svc := newTestServer(t)
+	ctx := context.Background()
+
+	reports := []string{
+		testSolarReport("22 Nov 2024 0900 GMT", "140", "3"),
+		testSolarReport("23 Nov 2024 0300 GMT", "150", "4"),
+		testSolarReport("23 Nov 2024 1200 GMT", "No Report", "2"),
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(reports[0]))
+		reports = reports[1:]
+	}))
+	defer srv.Close()
+	svc.solar = newSolarTracker()
+	svc.solar.url = srv.URL
+
+	for range 3 {
+		if err := svc.refreshSolarReport(ctx); err != nil {
+			t.Fatalf("refreshSolarReport failed: %v", err)
+		}
+	}
+	latest := svc.solar.latest.Load()
+	if latest == nil || latest.SolarFlux != -1 || latest.KIndex != 2 || len(latest.Bands) != 1 {
+		t.Fatalf("unexpected latest report %+v", latest)
+	}
+
+	// The stored report survives a restart.
+	svc.solar = newSolarTracker()
+	if fetchedAt, _ := svc.loadStoredSolarRefresh(ctx); fetchedAt.IsZero() || svc.solar.latest.Load() == nil || !svc.solar.latest.Load().Updated.Equal(latest.Updated) {
+		t.Fatalf("expected the stored report to load, got %v %+v", fetchedAt, svc.solar.latest.Load())
+	}
+
+	insertTestQso(t, svc, "JA1AA", "20m", "CW", "20241123", "1200")
+	insertTestQso(t, svc, "JA1BB", "20m", "CW", "20241123", "1300")
+	insertTestQso(t, svc, "JA1CC", "20m", "CW", "20241121", "1300")
+
+	report, err := svc.fetchPropagation(ctx, 1, 3, time.Date(2024, 11, 23, 18, 0, 0, 0, time.UTC))
+	if err != nil {
+		t.Fatalf("fetchPropagation failed: %v", err)
+	}
+	if report.Current == nil || len(report.Days) != 3 {
+		t.Fatalf("unexpected report %+v", report)
+	}
+	want := []propagationDay{
+		{Date: time.Date(2024, 11, 21, 0, 0, 0, 0, time.UTC), SolarFlux: -1, AIndex: -1, KIndex: -1, Sunspots: -1, Qsos: 1},
+		{Date: time.Date(2024, 11, 22, 0, 0, 0, 0, time.UTC), SolarFlux: 140, AIndex: 7, KIndex: 3, Sunspots: 120},
+		// The last report is missing the solar flux, so the earlier one's stands; the K index is the day's highest.
+		{Date: time.Date(2024, 11, 23, 0, 0, 0, 0, time.UTC), SolarFlux: 150, AIndex: 7, KIndex: 4, Sunspots: 120, Qsos: 2},
+	}
+	for i, day := range report.Days {
+		if !day.Date.Equal(want[i].Date) || day.SolarFlux != want[i].SolarFlux || day.AIndex != want[i].AIndex ||
+			day.KIndex != want[i].KIndex || day.Sunspots != want[i].Sunspots || day.Qsos != want[i].Qsos {
+			t.Errorf("day %d: got %+v, want %+v", i, day, want[i])
+		}
+	}
+}
+
+func TestSolarReport_BadDownloadKeepsCurrent(t *testing.T) {
+	svc := newTestServer(t)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(strings.Repeat("<", 10)))
+	}))
+	defer srv.Close()
+	svc.solar = newSolarTracker()
+	svc.solar.url = srv.URL
+
+	if err := svc.refreshSolarReport(context.Background()); err == nil {
+		t.Fatal("expected a report that does not parse to fail")
+	}
+	if svc.solar.latest.Load() != nil {
+		t.Error("expected no report")
+	}
+}
@@ -0,0 +1,286 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Station-Manager/errors"
+	"github.com/Station-Manager/server/service/dxcluster"
+)
+
+// spotEventNeeded reports a DX spot of a station the logbook needs for an award.
+const spotEventNeeded qsoEventType = "spot.needed"
+
+// What a spotted station must be missing from the logbook for an alert: the award credit at all,
+// on the spot's band, or on the spot's band in its mode group.
+const (
+	spotNeedEntity   = "entity"
+	spotNeedBand     = "band"
+	spotNeedBandMode = "band_mode"
+)
+
+// spotAlertAwards lists the awards a spot can be needed for, in the order they are reported. VUCC
+// is absent because spots carry no grid square.
+var spotAlertAwards = []string{awardDxcc, awardWaz}
+
+// spotAlertSettings is how a logbook is alerted to spots of stations it needs. Alerts always go to
+// the logbook's event streams; Webhook also sends them to its webhooks, and Email to an address.
+type spotAlertSettings struct {
+	LogbookID int64    `json:"-"`
+	Awards    []string `json:"awards"`
+	Need      string   `json:"need"`
+	Bands     []string `json:"bands"` // empty means every band
+	Modes     []string `json:"modes"` // mode groups; empty means every mode
+	Webhook   bool     `json:"webhook"`
+	Email     string   `json:"email,omitempty"`
+}
+
+// wants reports whether the settings cover spots on the band in the mode group.
+func (a spotAlertSettings) wants(band, mode string) bool {
+	return (len(a.Bands) == 0 || slices.Contains(a.Bands, band)) && (len(a.Modes) == 0 || slices.Contains(a.Modes, mode))
+}
+
+// neededSpot is the spot reported by a spot.needed event.
+type neededSpot struct {
+	Call         string    `json:"call"`
+	FrequencyKHz float64   `json:"frequency_khz"`
+	Band         string    `json:"band"`
+	Mode         string    `json:"mode"`
+	ModeGroup    string    `json:"mode_group"`
+	Spotter      string    `json:"spotter"`
+	Comment      string    `json:"comment,omitempty"`
+	SpottedAt    time.Time `json:"spotted_at"`
+	Entity       string    `json:"entity"`
+	DXCC         int       `json:"dxcc"`
+	CQZone       int       `json:"cq_zone"`
+	// Needed lists the awards the station counts towards that the logbook needs it for.
+	Needed []string `json:"needed"`
+	Need   string   `json:"need"`
+}
+
+// handleDxSpot alerts every logbook that needs the spotted station. Repeated spots of a station on
+// a band are ignored, as are spots outside the amateur bands and of calls that do not resolve.
+func (s *Service) handleDxSpot(ctx context.Context, spot dxcluster.Spot) error {
+	const op errors.Op = "server.Service.handleDxSpot"
+
+	band := dxcluster.Band(spot.FrequencyKHz)
+	if band == emptyString || !s.dxCluster.firstSpot(spot.Call, band, spot.Time) {
+		return nil
+	}
+	if s.cty == nil {
+		return nil
+	}
+	db := s.cty.db.Load()
+	if db == nil {
+		return nil
+	}
+	entity, ok := db.Lookup(spot.Call)
+	if !ok {
+		return nil
+	}
+
+	alerts, err := s.listSpotAlertSettings(ctx)
+	if err != nil {
+		return errors.New(op).Err(err)
+	}
+	credits := map[string]string{awardDxcc: strconv.Itoa(entity.DXCC)}
+	if entity.CQZone >= 1 && entity.CQZone <= wazZones {
+		credits[awardWaz] = strconv.Itoa(entity.CQZone)
+	}
+	mode := dxcluster.Mode(spot.FrequencyKHz, spot.Comment)
+	group := modeGroup(mode)
+	for _, alert := range alerts {
+		if !alert.wants(band, group) {
+			continue
+		}
+		var needed []string
+		for _, award := range spotAlertAwards {
+			credit, ok := credits[award]
+			if !ok || !slices.Contains(alert.Awards, award) {
+				continue
+			}
+			need, err := s.spotCreditNeeded(ctx, alert, award, credit, band, group)
+			if err != nil {
+				return errors.New(op).Err(err)
+			}
+			if need {
+				needed = append(needed, award)
+			}
+		}
+		if len(needed) == 0 || !s.dxCluster.firstAlert(alert.LogbookID, spot.Call, band, group, spot.Time) {
+			continue
+		}
+		s.sendSpotAlert(ctx, alert, neededSpot{
+			Call:         spot.Call,
+			FrequencyKHz: spot.FrequencyKHz,
+			Band:         band,
+			Mode:         mode,
+			ModeGroup:    group,
+			Spotter:      spot.Spotter,
+			Comment:      spot.Comment,
+			SpottedAt:    spot.Time,
+			Entity:       entity.Name,
+			DXCC:         entity.DXCC,
+			CQZone:       entity.CQZone,
+			Needed:       needed,
+			Need:         alert.Need,
+		})
+	}
+	return nil
+}
+
+// spotCreditNeeded reports whether the logbook has no QSO earning the award credit, limited by the
+// settings' need to the band or to the band and mode group.
+func (s *Service) spotCreditNeeded(ctx context.Context, alert spotAlertSettings, award, credit, band, mode string) (bool, error) {
+	const op errors.Op = "server.Service.spotCreditNeeded"
+
+	query := `SELECT 1 FROM award_credits WHERE logbook_id = $1 AND award = $2 AND credit = $3`
+	args := []any{alert.LogbookID, award, credit}
+	if alert.Need == spotNeedBand || alert.Need == spotNeedBandMode {
+		args = append(args, band)
+		query += ` AND band = $` + strconv.Itoa(len(args))
+	}
+	if alert.Need == spotNeedBandMode {
+		args = append(args, mode)
+		query += ` AND mode = $` + strconv.Itoa(len(args))
+	}
+
+	rows, err := s.db.QueryContext(ctx, query+` LIMIT 1`, args...)
+	if err != nil {
+		return false, errors.New(op).Err(err)
+	}
+	defer func() { _ = rows.Close() }()
+
+	worked := rows.Next()
+	if err = rows.Err(); err != nil {
+		return false, errors.New(op).Err(err)
+	}
+	return !worked, nil
+}
+
+// sendSpotAlert announces the spot on the logbook's event streams and sends it on to the
+// logbook's webhooks and email address if it asked for them. Alerts are not QSO changes, so they
+// are not published to the QSO listeners or kept for replay.
+func (s *Service) sendSpotAlert(ctx context.Context, alert spotAlertSettings, spot neededSpot) {
+	const op errors.Op = "server.Service.sendSpotAlert"
+
+	event := s.qsoEvents.Announce(qsoEvent{Type: spotEventNeeded, LogbookID: alert.LogbookID, Spot: &spot})
+	if alert.Webhook {
+		s.dispatchWebhookEvent(ctx, event)
+	}
+	if alert.Email != emptyString {
+		if err := s.enqueueEmail(ctx, alert.LogbookID, spotAlertEmail(alert.Email, spot)); err != nil {
+			s.logger.WarnWith().Err(errors.New(op).Err(err)).Int64("logbook_id", alert.LogbookID).Msg("Failed to queue spot alert email")
+		}
+	}
+}
+
+// spotAlertEmail returns the email reporting a needed spot.
+func spotAlertEmail(to string, spot neededSpot) email {
+	var body strings.Builder
+	fmt.Fprintf(&body, "%s (%s, CQ zone %d) was spotted by %s on %.1f kHz at %s UTC.\n",
+		spot.Call, spot.Entity, spot.CQZone, spot.Spotter, spot.FrequencyKHz, spot.SpottedAt.UTC().Format("15:04"))
+	if spot.Comment != emptyString {
+		fmt.Fprintf(&body, "Comment: %s\n", spot.Comment)
+	}
+	fmt.Fprintf(&body, "\nNeeded for: %s\n", strings.ToUpper(strings.Join(spot.Needed, ", ")))
+	return email{
+		To:      to,
+		Subject: fmt.Sprintf("Needed: %s (%s) on %s %s", spot.Call, spot.Entity, spot.Band, spot.Mode),
+		Body:    body.String(),
+	}
+}
+
+// listSpotAlertSettings returns the spot alert settings of every logbook that has them.
+func (s *Service) listSpotAlertSettings(ctx context.Context) ([]spotAlertSettings, error) {
+	const op errors.Op = "server.Service.listSpotAlertSettings"
+
+	alerts, err := s.querySpotAlertSettings(ctx, emptyString)
+	if err != nil {
+		return nil, errors.New(op).Err(err)
+	}
+	return alerts, nil
+}
+
+// fetchSpotAlertSettings returns the logbook's spot alert settings, if it has any.
+func (s *Service) fetchSpotAlertSettings(ctx context.Context, logbookID int64) (spotAlertSettings, bool, error) {
+	const op errors.Op = "server.Service.fetchSpotAlertSettings"
+
+	alerts, err := s.querySpotAlertSettings(ctx, ` WHERE logbook_id = $1`, logbookID)
+	if err != nil {
+		return spotAlertSettings{}, false, errors.New(op).Err(err)
+	}
+	if len(alerts) == 0 {
+		return spotAlertSettings{}, false, nil
+	}
+	return alerts[0], true, nil
+}
+
+func (s *Service) querySpotAlertSettings(ctx context.Context, clause string, args ...any) ([]spotAlertSettings, error) {
+	const op errors.Op = "server.Service.querySpotAlertSettings"
+
+	rows, err := s.db.QueryContext(ctx, `SELECT logbook_id, awards, need, bands, modes, webhook, email FROM spot_alerts`+clause, args...)
+	if err != nil {
+		return nil, errors.New(op).Err(err)
+	}
+	defer func() { _ = rows.Close() }()
+
+	var alerts []spotAlertSettings
+	for rows.Next() {
+		var alert spotAlertSettings
+		var awards, bands, modes string
+		if err = rows.Scan(&alert.LogbookID, &awards, &alert.Need, &bands, &modes, &alert.Webhook, &alert.Email); err != nil {
+			return nil, errors.New(op).Err(err)
+		}
+		alert.Awards, alert.Bands, alert.Modes = splitSpotAlertList(awards), splitSpotAlertList(bands), splitSpotAlertList(modes)
+		alerts = append(alerts, alert)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, errors.New(op).Err(err)
+	}
+	return alerts, nil
+}
+
+// splitSpotAlertList splits a comma-separated settings column, which is empty for an empty list.
+func splitSpotAlertList(value string) []string {
+	list := make([]string, 0)
+	for _, item := range strings.Split(value, ",") {
+		if item != emptyString {
+			list = append(list, item)
+		}
+	}
+	return list
+}
+
+// saveSpotAlertSettings turns on spot alerts for the logbook, or updates its settings.
+func (s *Service) saveSpotAlertSettings(ctx context.Context, alert spotAlertSettings) error {
+	const op errors.Op = "server.Service.saveSpotAlertSettings"
+
+	if _, err := s.db.ExecContext(ctx, `INSERT INTO spot_alerts (logbook_id, awards, need, bands, modes, webhook, email) VALUES ($1, $2, $3, $4, $5, $6, $7)
+		ON CONFLICT (logbook_id) DO UPDATE SET awards = excluded.awards, need = excluded.need, bands = excluded.bands,
+			modes = excluded.modes, webhook = excluded.webhook, email = excluded.email`,
+		alert.LogbookID, strings.Join(alert.Awards, ","), alert.Need, strings.Join(alert.Bands, ","), strings.Join(alert.Modes, ","),
+		alert.Webhook, alert.Email); err != nil {
+		return errors.New(op).Err(err)
+	}
+	return nil
+}
+
+// deleteSpotAlertSettings turns off spot alerts for the logbook.
+func (s *Service) deleteSpotAlertSettings(ctx context.Context, logbookID int64) (bool, error) {
+	const op errors.Op = "server.Service.deleteSpotAlertSettings"
+
+	res, err := s.db.ExecContext(ctx, `DELETE FROM spot_alerts WHERE logbook_id = $1`, logbookID)
+	if err != nil {
+		return false, errors.New(op).Err(err)
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return false, errors.New(op).Err(err)
+	}
+	return n > 0, nil
+}
@@ -0,0 +1,113 @@
+package service
+
+import (
+	"strings"
+
+	"github.com/Station-Manager/errors"
+	"github.com/gofiber/fiber/v2"
+)
+
+// spotAlertRequest is the body of a request to turn on spot alerts for a logbook. Awards defaults
+// to DXCC and Need to the entity not being worked at all.
+type spotAlertRequest struct {
+	Awards  []string `json:"awards" validate:"omitempty,max=2,dive,oneof=dxcc waz"`
+	Need    string   `json:"need" validate:"omitempty,oneof=entity band band_mode"`
+	Bands   []string `json:"bands" validate:"omitempty,max=32,dive,min=2,max=8"`
+	Modes   []string `json:"modes" validate:"omitempty,max=3,dive,oneof=CW PHONE DATA cw phone data"`
+	Webhook bool     `json:"webhook"`
+	Email   string   `json:"email" validate:"omitempty,email,max=254"`
+}
+
+// getSpotAlertsHandler returns the authenticated logbook's spot alert settings. available reports
+// whether the server follows a DX cluster, without which no alerts are sent.
+func (s *Service) getSpotAlertsHandler(c *fiber.Ctx) error {
+	const op errors.Op = "server.Service.getSpotAlertsHandler"
+
+	logbook, err := authenticatedLogbook(c)
+	if err != nil {
+		s.logger.ErrorWith().Err(errors.New(op).Err(err)).Msg("authenticatedLogbook failed")
+		return c.Status(fiber.StatusInternalServerError).JSON(jsonInternalError)
+	}
+
+	settings, found, err := s.fetchSpotAlertSettings(c.UserContext(), logbook.ID)
+	if err != nil {
+		s.logger.ErrorWith().Err(errors.New(op).Err(err)).Msg("s.fetchSpotAlertSettings failed")
+		return s.dbFailure(c, err)
+	}
+	if !found {
+		return c.JSON(fiber.Map{"enabled": false, "available": s.dxCluster.enabled()})
+	}
+	return c.JSON(fiber.Map{"enabled": true, "available": s.dxCluster.enabled(), "settings": settings})
+}
+
+// putSpotAlertsHandler turns on spot alerts for the authenticated logbook, or replaces its
+// settings. Spots are checked against the logbook's awards from then on.
+func (s *Service) putSpotAlertsHandler(c *fiber.Ctx) error {
+	const op errors.Op = "server.Service.putSpotAlertsHandler"
+
+	logbook, err := authenticatedLogbook(c)
+	if err != nil {
+		s.logger.ErrorWith().Err(errors.New(op).Err(err)).Msg("authenticatedLogbook failed")
+		return c.Status(fiber.StatusInternalServerError).JSON(jsonInternalError)
+	}
+
+	var request spotAlertRequest
+	if err = c.BodyParser(&request); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(jsonBadRequest)
+	}
+	if err = s.validate.Struct(request); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(jsonBadRequest)
+	}
+	if request.Email != emptyString && !s.mailer.enabled() {
+		return c.Status(fiber.StatusBadRequest).JSON(jsonError(errCodeEmailDisabled, "Email is not available on this server"))
+	}
+
+	settings := spotAlertSettings{
+		LogbookID: logbook.ID,
+		Awards:    request.Awards,
+		Need:      request.Need,
+		Bands:     make([]string, 0, len(request.Bands)),
+		Modes:     make([]string, 0, len(request.Modes)),
+		Webhook:   request.Webhook,
+		Email:     request.Email,
+	}
+	if len(settings.Awards) == 0 {
+		settings.Awards = []string{awardDxcc}
+	}
+	if settings.Need == emptyString {
+		settings.Need = spotNeedEntity
+	}
+	for _, band := range request.Bands {
+		settings.Bands = append(settings.Bands, strings.ToLower(band))
+	}
+	for _, mode := range request.Modes {
+		settings.Modes = append(settings.Modes, strings.ToUpper(mode))
+	}
+	if err = s.saveSpotAlertSettings(c.UserContext(), settings); err != nil {
+		s.logger.ErrorWith().Err(errors.New(op).Err(err)).Msg("s.saveSpotAlertSettings failed")
+		return s.dbFailure(c, err)
+	}
+
+	return c.JSON(fiber.Map{"enabled": true, "available": s.dxCluster.enabled(), "settings": settings})
+}
+
+// deleteSpotAlertsHandler turns off spot alerts for the authenticated logbook.
+func (s *Service) deleteSpotAlertsHandler(c *fiber.Ctx) error {
+	const op errors.Op = "server.Service.deleteSpotAlertsHandler"
+
+	logbook, err := authenticatedLogbook(c)
+	if err != nil {
+		s.logger.ErrorWith().Err(errors.New(op).Err(err)).Msg("authenticatedLogbook failed")
+		return c.Status(fiber.StatusInternalServerError).JSON(jsonInternalError)
+	}
+
+	found, err := s.deleteSpotAlertSettings(c.UserContext(), logbook.ID)
+	if err != nil {
+		s.logger.ErrorWith().Err(errors.New(op).Err(err)).Msg("s.deleteSpotAlertSettings failed")
+		return s.dbFailure(c, err)
+	}
+	if !found {
+		return c.Status(fiber.StatusNotFound).JSON(jsonError(errCodeNotFound, "Spot alerts are not enabled"))
+	}
+	return c.SendStatus(fiber.StatusNoContent)
+}
@@ -0,0 +1,119 @@
+package service
+
+import (
+	"context"
+	"net/smtp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Station-Manager/server/service/dxcluster"
+)
+
+func TestHandleDxSpot_AlertsNeededStations(t *testing.T) {
+	svc, logbook := newContestTestServer(t)
+	ctx := context.Background()
+	svc.dxCluster = newDxClusterClient("cluster.example:7300", "K1AB")
+
+	var sent []string
+	svc.mailer = &mailer{addr: "smtp.example:25", from: "alerts@example.org", sendMail: func(_ string, _ smtp.Auth, _ string, to []string, msg []byte) error {
+		sent = append(sent, to[0]+"\n"+string(msg))
+		return nil
+	}}
+
+	// JA is worked on 20m CW only.
+	id := insertTestQso(t, svc, "JA1AA", "20m", "CW", "20240501", "1200")
+	if err := svc.replaceAwardCredits(ctx, logbook.ID, id, []awardCredit{
+		{Award: awardDxcc, Credit: "339", Band: "20m", Mode: "CW"},
+		{Award: awardWaz, Credit: "25", Band: "20m", Mode: "CW"},
+	}); err != nil {
+		t.Fatalf("replaceAwardCredits failed: %v", err)
+	}
+	if err := svc.saveSpotAlertSettings(ctx, spotAlertSettings{
+		LogbookID: logbook.ID,
+		Awards:    []string{awardDxcc, awardWaz},
+		Need:      spotNeedBandMode,
+		Modes:     []string{"CW", "PHONE"},
+		Email:     "op@example.org",
+	}); err != nil {
+		t.Fatalf("saveSpotAlertSettings failed: %v", err)
+	}
+
+	sub := svc.qsoEvents.Subscribe(logbook.ID)
+	defer svc.qsoEvents.Unsubscribe(sub)
+	var alerts []neededSpot
+	drain := func() {
+		for {
+			select {
+			case event := <-sub.Events():
+				if event.Type == spotEventNeeded {
+					alerts = append(alerts, *event.Spot)
+				}
+			default:
+				return
+			}
+		}
+	}
+
+	now := time.Date(2024, 11, 23, 12, 0, 0, 0, time.UTC)
+	for i, spot := range []dxcluster.Spot{
+		{Spotter: "W3LPL", Call: "JA1XX", FrequencyKHz: 14025, Time: now},                     // worked on 20m CW
+		{Spotter: "W3LPL", Call: "JA1YY", FrequencyKHz: 14074, Time: now.Add(time.Minute)},    // FT8 is not wanted
+		{Spotter: "W3LPL", Call: "JA1ZZ", FrequencyKHz: 7025, Time: now.Add(2 * time.Minute)}, // needed on 40m CW
+		{Spotter: "DL1AB", Call: "JA1ZZ", FrequencyKHz: 7026, Time: now.Add(3 * time.Minute)}, // a repeat
+		{Spotter: "W3LPL", Call: "W1AW", FrequencyKHz: 7200, Time: now.Add(4 * time.Minute)},  // needed on 40m phone
+		{Spotter: "W3LPL", Call: "XX9XX", FrequencyKHz: 7030, Time: now.Add(5 * time.Minute)}, // unknown entity
+	} {
+		if err := svc.handleDxSpot(ctx, spot); err != nil {
+			t.Fatalf("handleDxSpot %d failed: %v", i, err)
+		}
+	}
+	drain()
+
+	if len(alerts) != 2 {
+		t.Fatalf("expected 2 alerts, got %+v", alerts)
+	}
+	if a := alerts[0]; a.Call != "JA1ZZ" || a.Band != "40m" || a.ModeGroup != "CW" || a.Entity != "Japan" || strings.Join(a.Needed, ",") != "dxcc,waz" {
+		t.Errorf("unexpected first alert %+v", a)
+	}
+	if a := alerts[1]; a.Call != "W1AW" || a.Mode != "SSB" || a.DXCC != 291 || a.CQZone != 5 {
+		t.Errorf("unexpected second alert %+v", a)
+	}
+
+	// Each alert queued an email.
+	for svc.runNextJob(ctx) {
+	}
+	if len(sent) != 2 || !strings.HasPrefix(sent[0], "op@example.org\n") || !strings.Contains(sent[0], "Subject: Needed: JA1ZZ (Japan) on 40m CW") {
+		t.Errorf("unexpected emails %q", sent)
+	}
+}
+
+func TestSpotAlertSettings_NeedEntity(t *testing.T) {
+	svc, logbook := newContestTestServer(t)
+	ctx := context.Background()
+
+	id := insertTestQso(t, svc, "JA1AA", "20m", "CW", "20240501", "1200")
+	if err := svc.replaceAwardCredits(ctx, logbook.ID, id, []awardCredit{{Award: awardDxcc, Credit: "339", Band: "20m", Mode: "CW"}}); err != nil {
+		t.Fatalf("replaceAwardCredits failed: %v", err)
+	}
+	alert := spotAlertSettings{LogbookID: logbook.ID, Awards: []string{awardDxcc}, Need: spotNeedEntity}
+	for _, tc := range []struct {
+		need        string
+		band, mode  string
+		credit      string
+		wantNeeded  bool
+		description string
+	}{
+		{spotNeedEntity, "40m", "PHONE", "339", false, "worked on another band"},
+		{spotNeedEntity, "20m", "CW", "291", true, "never worked"},
+		{spotNeedBand, "40m", "CW", "339", true, "not worked on the band"},
+		{spotNeedBand, "20m", "PHONE", "339", false, "worked on the band in another mode"},
+		{spotNeedBandMode, "20m", "PHONE", "339", true, "not worked in the mode"},
+	} {
+		alert.Need = tc.need
+		needed, err := svc.spotCreditNeeded(ctx, alert, awardDxcc, tc.credit, tc.band, tc.mode)
+		if err != nil || needed != tc.wantNeeded {
+			t.Errorf("%s: expected needed=%v, got %v %v", tc.description, tc.wantNeeded, needed, err)
+		}
+	}
+}
@@ -0,0 +1,52 @@
+package service
+
+// StartupFailure is the part of the server's start that failed.
+type StartupFailure int
+
+const (
+	// StartupFailureOther: the server failed for another reason.
+	StartupFailureOther StartupFailure = iota
+	// StartupFailureConfig: the configuration file, an environment variable or a flag is invalid.
+	StartupFailureConfig
+	// StartupFailureDatabase: the database could not be opened, migrated or verified.
+	StartupFailureDatabase
+	// StartupFailureBind: a listening socket could not be opened.
+	StartupFailureBind
+)
+
+// startupSummaries are the one-line descriptions of the failures, for the operator.
+var startupSummaries = map[StartupFailure]string{
+	StartupFailureOther:    "the server failed to start",
+	StartupFailureConfig:   "the configuration is invalid",
+	StartupFailureDatabase: "the database is unavailable or its schema is not up to date",
+	StartupFailureBind:     "a listening address could not be opened",
+}
+
+// StartupError is returned by NewServiceWithOptions and Start when the server cannot start. Its
+// Failure lets the process exit with a status that says what went wrong.
+type StartupError struct {
+	Failure StartupFailure
+	Err     error
+}
+
+func (e *StartupError) Error() string {
+	return e.Summary() + ": " + e.Err.Error()
+}
+
+func (e *StartupError) Unwrap() error {
+	return e.Err
+}
+
+// Summary describes the failure in a line, without its detail.
+func (e *StartupError) Summary() string {
+	return startupSummaries[e.Failure]
+}
+
+// startupError returns err as a *StartupError of the failure, logging its detail if the logging
+// service has been started.
+func (s *Service) startupError(failure StartupFailure, err error) error {
+	if s.logger != nil {
+		s.logger.ErrorWith().Err(err).Str("failure", startupSummaries[failure]).Msg("Server failed to start")
+	}
+	return &StartupError{Failure: failure, Err: err}
+}
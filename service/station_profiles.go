@@ -0,0 +1,337 @@
+package service
+
+import (
+	"context"
+	"database/sql"
+	stderr "errors"
+	"strings"
+	"time"
+
+	"github.com/Station-Manager/errors"
+	"github.com/Station-Manager/types"
+)
+
+// errStationProfileNotFound is returned when a QSO names a station profile its logbook does not have.
+var errStationProfileNotFound = stderr.New("station profile not found")
+
+// stationFields are the MY_* ADIF fields, with the power and operator, that describe the station a
+// QSO was made from.
+type stationFields struct {
+	MyGridsquare string `json:"my_gridsquare" validate:"omitempty,min=4,max=10,alphanum"`
+	MyLat        string `json:"my_lat" validate:"max=15"`
+	MyLon        string `json:"my_lon" validate:"max=15"`
+	MyAltitude   string `json:"my_altitude" validate:"omitempty,numeric,max=16"`
+	MyCity       string `json:"my_city" validate:"max=255"`
+	MyCountry    string `json:"my_country" validate:"max=255"`
+	MyDXCC       string `json:"my_dxcc" validate:"omitempty,numeric,max=3"`
+	MyCqZone     string `json:"my_cq_zone" validate:"omitempty,numeric,max=2"`
+	MyITUZone    string `json:"my_itu_zone" validate:"omitempty,numeric,max=2"`
+	MyIota       string `json:"my_iota" validate:"max=10"`
+	MySig        string `json:"my_sig" validate:"max=255"`
+	MySigInfo    string `json:"my_sig_info" validate:"max=255"`
+	MyWwffRef    string `json:"my_wwff_ref" validate:"max=16"`
+	MyRig        string `json:"my_rig" validate:"max=255"`
+	MyAntenna    string `json:"my_antenna" validate:"max=255"`
+	TxPwr        string `json:"tx_pwr" validate:"omitempty,numeric,max=16"`
+	Operator     string `json:"operator" validate:"omitempty,min=3,max=30"`
+}
+
+// stationFieldColumns are the columns of stationFields, in the order of values.
+const stationFieldColumns = `my_gridsquare, my_lat, my_lon, my_altitude, my_city, my_country, my_dxcc, my_cq_zone, my_itu_zone,
+	my_iota, my_sig, my_sig_info, my_wwff_ref, my_rig, my_antenna, tx_pwr, operator`
+
+func (f *stationFields) values() []*string {
+	return []*string{&f.MyGridsquare, &f.MyLat, &f.MyLon, &f.MyAltitude, &f.MyCity, &f.MyCountry, &f.MyDXCC, &f.MyCqZone,
+		&f.MyITUZone, &f.MyIota, &f.MySig, &f.MySigInfo, &f.MyWwffRef, &f.MyRig, &f.MyAntenna, &f.TxPwr, &f.Operator}
+}
+
+// qsoStationValues returns the QSO's station fields, in the order of stationFields.values.
+func qsoStationValues(qso *types.Qso) []*string {
+	return []*string{&qso.MyGridsquare, &qso.MyLat, &qso.MyLon, &qso.MyAltitude, &qso.MyCity, &qso.MyCountry, &qso.MyDXCC,
+		&qso.MyCqZone, &qso.MyITUZone, &qso.MyIota, &qso.MySig, &qso.MySigInfo, &qso.MyWwffRef, &qso.MyRig, &qso.MyAntenna,
+		&qso.TxPwr, &qso.Operator}
+}
+
+// normalize trims the fields and upper-cases those ADIF gives in capitals.
+func (f *stationFields) normalize() {
+	for _, value := range f.values() {
+		*value = strings.TrimSpace(*value)
+	}
+	f.MyGridsquare = strings.ToUpper(f.MyGridsquare)
+	f.MyIota = strings.ToUpper(f.MyIota)
+	f.MySig = strings.ToUpper(f.MySig)
+	f.MyWwffRef = strings.ToUpper(f.MyWwffRef)
+	f.Operator = strings.ToUpper(f.Operator)
+}
+
+// fillFrom fills the empty fields from other.
+func (f *stationFields) fillFrom(other stationFields) {
+	theirs := other.values()
+	for i, value := range f.values() {
+		if *value == emptyString {
+			*value = *theirs[i]
+		}
+	}
+}
+
+// applyTo fills the QSO's empty station fields.
+func (f stationFields) applyTo(qso *types.Qso) {
+	ours := f.values()
+	for i, value := range qsoStationValues(qso) {
+		if *value == emptyString {
+			*value = *ours[i]
+		}
+	}
+}
+
+// stationProfile is one of the stations a logbook logs from, such as a home QTH or a portable
+// setup. A QSO is stamped with the profile it names, or else the one chosen for the API key it is
+// logged with, or else the logbook's default profile; see fetchQsoDefaults.
+type stationProfile struct {
+	ID      int64  `json:"id"`
+	Name    string `json:"name"`
+	Default bool   `json:"default"`
+	stationFields
+	// ApiKeys are the prefixes of the API keys that log with the profile.
+	ApiKeys   []string  `json:"api_keys"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// selectStationProfile returns the fields of the station profile a QSO is logged with, which are
+// empty when the logbook has none to apply. Naming a profile the logbook does not have fails with
+// errStationProfileNotFound.
+func (s *Service) selectStationProfile(ctx context.Context, logbookID int64, name, actor string) (stationFields, error) {
+	const op errors.Op = "server.Service.selectStationProfile"
+
+	prefix, _ := strings.CutPrefix(actor, apiKeyActorPrefix)
+	rows, err := s.db.QueryContext(ctx, `SELECT name, `+stationFieldColumns+` FROM station_profiles
+		WHERE logbook_id = $1 AND (name = $2 OR is_default OR id IN (SELECT profile_id FROM station_profile_keys WHERE key_prefix = $3))
+		ORDER BY CASE WHEN name = $2 THEN 0 WHEN is_default THEN 2 ELSE 1 END LIMIT 1`, logbookID, name, prefix)
+	if err != nil {
+		return stationFields{}, errors.New(op).Err(err)
+	}
+	defer func() { _ = rows.Close() }()
+
+	var selected string
+	var fields stationFields
+	if rows.Next() {
+		dest := []any{&selected}
+		for _, value := range fields.values() {
+			dest = append(dest, value)
+		}
+		if err = rows.Scan(dest...); err != nil {
+			return stationFields{}, errors.New(op).Err(err)
+		}
+	}
+	if err = rows.Err(); err != nil {
+		return stationFields{}, errors.New(op).Err(err)
+	}
+	if name != emptyString && selected != name {
+		return stationFields{}, errStationProfileNotFound
+	}
+	return fields, nil
+}
+
+// listStationProfiles returns the logbook's station profiles in name order.
+func (s *Service) listStationProfiles(ctx context.Context, logbookID int64) ([]stationProfile, error) {
+	const op errors.Op = "server.Service.listStationProfiles"
+
+	profiles, err := s.queryStationProfiles(ctx, logbookID, ` ORDER BY name`)
+	if err != nil {
+		return nil, errors.New(op).Err(err)
+	}
+	return profiles, nil
+}
+
+// fetchStationProfile returns one of the logbook's station profiles, if it exists.
+func (s *Service) fetchStationProfile(ctx context.Context, logbookID, id int64) (stationProfile, bool, error) {
+	const op errors.Op = "server.Service.fetchStationProfile"
+
+	profiles, err := s.queryStationProfiles(ctx, logbookID, ` AND id = $2`, id)
+	if err != nil {
+		return stationProfile{}, false, errors.New(op).Err(err)
+	}
+	if len(profiles) == 0 {
+		return stationProfile{}, false, nil
+	}
+	return profiles[0], true, nil
+}
+
+func (s *Service) queryStationProfiles(ctx context.Context, logbookID int64, clause string, args ...any) ([]stationProfile, error) {
+	const op errors.Op = "server.Service.queryStationProfiles"
+
+	profiles, err := s.scanStationProfiles(ctx, `SELECT id, name, is_default, `+stationFieldColumns+`, created_at FROM station_profiles
+		WHERE logbook_id = $1`+clause, append([]any{logbookID}, args...)...)
+	if err != nil || len(profiles) == 0 {
+		return profiles, err
+	}
+
+	rows, err := s.db.QueryContext(ctx, `SELECT k.profile_id, k.key_prefix FROM station_profile_keys k
+		JOIN station_profiles p ON p.id = k.profile_id WHERE p.logbook_id = $1 ORDER BY k.key_prefix`, logbookID)
+	if err != nil {
+		return nil, errors.New(op).Err(err)
+	}
+	defer func() { _ = rows.Close() }()
+
+	for rows.Next() {
+		var id int64
+		var prefix string
+		if err = rows.Scan(&id, &prefix); err != nil {
+			return nil, errors.New(op).Err(err)
+		}
+		for i := range profiles {
+			if profiles[i].ID == id {
+				profiles[i].ApiKeys = append(profiles[i].ApiKeys, prefix)
+			}
+		}
+	}
+	if err = rows.Err(); err != nil {
+		return nil, errors.New(op).Err(err)
+	}
+	return profiles, nil
+}
+
+func (s *Service) scanStationProfiles(ctx context.Context, query string, args ...any) ([]stationProfile, error) {
+	const op errors.Op = "server.Service.scanStationProfiles"
+
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, errors.New(op).Err(err)
+	}
+	defer func() { _ = rows.Close() }()
+
+	profiles := make([]stationProfile, 0)
+	for rows.Next() {
+		profile := stationProfile{ApiKeys: make([]string, 0)}
+		dest := []any{&profile.ID, &profile.Name, &profile.Default}
+		for _, value := range profile.values() {
+			dest = append(dest, value)
+		}
+		if err = rows.Scan(append(dest, &profile.CreatedAt)...); err != nil {
+			return nil, errors.New(op).Err(err)
+		}
+		profiles = append(profiles, profile)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, errors.New(op).Err(err)
+	}
+	return profiles, nil
+}
+
+// stationProfileNameTaken reports whether the logbook has a station profile of that name other
+// than the one with the ID except.
+func (s *Service) stationProfileNameTaken(ctx context.Context, logbookID int64, name string, except int64) (bool, error) {
+	const op errors.Op = "server.Service.stationProfileNameTaken"
+
+	rows, err := s.db.QueryContext(ctx, `SELECT 1 FROM station_profiles WHERE logbook_id = $1 AND name = $2 AND id <> $3`, logbookID, name, except)
+	if err != nil {
+		return false, errors.New(op).Err(err)
+	}
+	defer func() { _ = rows.Close() }()
+
+	taken := rows.Next()
+	if err = rows.Err(); err != nil {
+		return false, errors.New(op).Err(err)
+	}
+	return taken, nil
+}
+
+// saveStationProfile creates the profile, or replaces the one with its ID, and returns it as
+// stored. Making a profile the default takes that from any other of the logbook's profiles.
+func (s *Service) saveStationProfile(ctx context.Context, logbookID int64, profile stationProfile) (stationProfile, bool, error) {
+	const op errors.Op = "server.Service.saveStationProfile"
+
+	args := []any{logbookID, profile.ID, profile.Name, profile.Default}
+	for _, value := range profile.values() {
+		args = append(args, *value)
+	}
+
+	found := true
+	err := s.withTx(ctx, func(tx *sql.Tx) error {
+		if profile.Default {
+			if _, err := tx.ExecContext(ctx, `UPDATE station_profiles SET is_default = FALSE WHERE logbook_id = $1 AND id <> $2 AND is_default`, logbookID, profile.ID); err != nil {
+				return err
+			}
+		}
+		if profile.ID == 0 {
+			return tx.QueryRowContext(ctx, `INSERT INTO station_profiles (logbook_id, name, is_default, `+stationFieldColumns+`)
+				VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17, $18, $19, $20)
+				RETURNING id`, append([]any{logbookID}, args[2:]...)...).Scan(&profile.ID)
+		}
+		result, err := tx.ExecContext(ctx, `UPDATE station_profiles SET name = $3, is_default = $4, my_gridsquare = $5, my_lat = $6,
+				my_lon = $7, my_altitude = $8, my_city = $9, my_country = $10, my_dxcc = $11, my_cq_zone = $12, my_itu_zone = $13,
+				my_iota = $14, my_sig = $15, my_sig_info = $16, my_wwff_ref = $17, my_rig = $18, my_antenna = $19, tx_pwr = $20,
+				operator = $21
+			WHERE logbook_id = $1 AND id = $2`, args...)
+		if err != nil {
+			return err
+		}
+		n, err := result.RowsAffected()
+		found = n > 0
+		return err
+	})
+	if err != nil {
+		return stationProfile{}, false, errors.New(op).Err(err)
+	}
+	if !found {
+		return stationProfile{}, false, nil
+	}
+
+	saved, found, err := s.fetchStationProfile(ctx, logbookID, profile.ID)
+	if err != nil {
+		return stationProfile{}, false, errors.New(op).Err(err)
+	}
+	return saved, found, nil
+}
+
+// deleteStationProfile deletes one of the logbook's station profiles. API keys that logged with it
+// go back to the logbook's default profile.
+func (s *Service) deleteStationProfile(ctx context.Context, logbookID, id int64) (bool, error) {
+	const op errors.Op = "server.Service.deleteStationProfile"
+
+	result, err := s.db.ExecContext(ctx, `DELETE FROM station_profiles WHERE logbook_id = $1 AND id = $2`, logbookID, id)
+	if err != nil {
+		return false, errors.New(op).Err(err)
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return false, errors.New(op).Err(err)
+	}
+	return n > 0, nil
+}
+
+// assignStationProfileKey makes the logbook's API key with the prefix log with the profile. It
+// reports false when either does not belong to the logbook.
+func (s *Service) assignStationProfileKey(ctx context.Context, logbookID, id int64, prefix string) (bool, error) {
+	const op errors.Op = "server.Service.assignStationProfileKey"
+
+	result, err := s.db.ExecContext(ctx, `INSERT INTO station_profile_keys (key_prefix, profile_id)
+		SELECT k.key_prefix, p.id FROM api_keys k JOIN station_profiles p ON p.logbook_id = k.logbook_id
+		WHERE k.logbook_id = $1 AND p.id = $2 AND k.key_prefix = $3
+		ON CONFLICT (key_prefix) DO UPDATE SET profile_id = excluded.profile_id`, logbookID, id, prefix)
+	if err != nil {
+		return false, errors.New(op).Err(err)
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return false, errors.New(op).Err(err)
+	}
+	return n > 0, nil
+}
+
+// unassignStationProfileKey returns the API key with the prefix from the profile to the logbook's
+// default profile.
+func (s *Service) unassignStationProfileKey(ctx context.Context, logbookID, id int64, prefix string) (bool, error) {
+	const op errors.Op = "server.Service.unassignStationProfileKey"
+
+	result, err := s.db.ExecContext(ctx, `DELETE FROM station_profile_keys WHERE key_prefix = $3 AND profile_id IN
+		(SELECT id FROM station_profiles WHERE logbook_id = $1 AND id = $2)`, logbookID, id, prefix)
+	if err != nil {
+		return false, errors.New(op).Err(err)
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return false, errors.New(op).Err(err)
+	}
+	return n > 0, nil
+}
@@ -0,0 +1,203 @@
+package service
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/Station-Manager/errors"
+	"github.com/gofiber/fiber/v2"
+)
+
+// stationProfileRequest is the body of a request to create or replace a station profile. Fields
+// left empty are not stamped into QSOs, which keep any the logbook's defaults give them.
+type stationProfileRequest struct {
+	Name    string `json:"name" validate:"required,max=50"`
+	Default bool   `json:"default"`
+	stationFields
+}
+
+// listStationProfilesHandler returns the authenticated logbook's station profiles.
+func (s *Service) listStationProfilesHandler(c *fiber.Ctx) error {
+	const op errors.Op = "server.Service.listStationProfilesHandler"
+
+	logbook, err := authenticatedLogbook(c)
+	if err != nil {
+		s.logger.ErrorWith().Err(errors.New(op).Err(err)).Msg("authenticatedLogbook failed")
+		return c.Status(fiber.StatusInternalServerError).JSON(jsonInternalError)
+	}
+
+	profiles, err := s.listStationProfiles(c.UserContext(), logbook.ID)
+	if err != nil {
+		s.logger.ErrorWith().Err(errors.New(op).Err(err)).Msg("s.listStationProfiles failed")
+		return s.dbFailure(c, err)
+	}
+	return c.JSON(fiber.Map{"profiles": profiles})
+}
+
+// getStationProfileHandler returns one of the authenticated logbook's station profiles.
+func (s *Service) getStationProfileHandler(c *fiber.Ctx) error {
+	const op errors.Op = "server.Service.getStationProfileHandler"
+
+	logbook, err := authenticatedLogbook(c)
+	if err != nil {
+		s.logger.ErrorWith().Err(errors.New(op).Err(err)).Msg("authenticatedLogbook failed")
+		return c.Status(fiber.StatusInternalServerError).JSON(jsonInternalError)
+	}
+
+	id, err := strconv.ParseInt(c.Params("id"), 10, 64)
+	if err != nil || id <= 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(jsonBadRequest)
+	}
+
+	profile, found, err := s.fetchStationProfile(c.UserContext(), logbook.ID, id)
+	if err != nil {
+		s.logger.ErrorWith().Err(errors.New(op).Err(err)).Msg("s.fetchStationProfile failed")
+		return s.dbFailure(c, err)
+	}
+	if !found {
+		return c.Status(fiber.StatusNotFound).JSON(jsonError(errCodeStationProfileNotFound, "Station profile not found"))
+	}
+	return c.JSON(fiber.Map{"profile": profile})
+}
+
+// createStationProfileHandler adds a station profile to the authenticated logbook.
+func (s *Service) createStationProfileHandler(c *fiber.Ctx) error {
+	return s.saveStationProfileHandler(c, 0)
+}
+
+// putStationProfileHandler replaces one of the authenticated logbook's station profiles. The API
+// keys that log with it are kept.
+func (s *Service) putStationProfileHandler(c *fiber.Ctx) error {
+	id, err := strconv.ParseInt(c.Params("id"), 10, 64)
+	if err != nil || id <= 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(jsonBadRequest)
+	}
+	return s.saveStationProfileHandler(c, id)
+}
+
+// saveStationProfileHandler creates a station profile from the request, or replaces the one with
+// the ID if that is not zero.
+func (s *Service) saveStationProfileHandler(c *fiber.Ctx, id int64) error {
+	const op errors.Op = "server.Service.saveStationProfileHandler"
+
+	logbook, err := authenticatedLogbook(c)
+	if err != nil {
+		s.logger.ErrorWith().Err(errors.New(op).Err(err)).Msg("authenticatedLogbook failed")
+		return c.Status(fiber.StatusInternalServerError).JSON(jsonInternalError)
+	}
+
+	var request stationProfileRequest
+	if err = c.BodyParser(&request); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(jsonBadRequest)
+	}
+	request.Name = strings.TrimSpace(request.Name)
+	request.normalize()
+	if err = s.validate.Struct(request); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(jsonBadRequest)
+	}
+
+	taken, err := s.stationProfileNameTaken(c.UserContext(), logbook.ID, request.Name, id)
+	if err != nil {
+		s.logger.ErrorWith().Err(errors.New(op).Err(err)).Msg("s.stationProfileNameTaken failed")
+		return s.dbFailure(c, err)
+	}
+	if taken {
+		return c.Status(fiber.StatusConflict).JSON(jsonError(errCodeStationProfileExists, "A station profile of that name already exists"))
+	}
+
+	profile := stationProfile{ID: id, Name: request.Name, Default: request.Default, stationFields: request.stationFields}
+	profile, found, err := s.saveStationProfile(c.UserContext(), logbook.ID, profile)
+	if err != nil {
+		s.logger.ErrorWith().Err(errors.New(op).Err(err)).Msg("s.saveStationProfile failed")
+		return s.dbFailure(c, err)
+	}
+	if !found {
+		return c.Status(fiber.StatusNotFound).JSON(jsonError(errCodeStationProfileNotFound, "Station profile not found"))
+	}
+
+	if id == 0 {
+		return c.Status(fiber.StatusCreated).JSON(fiber.Map{"profile": profile})
+	}
+	return c.JSON(fiber.Map{"profile": profile})
+}
+
+// deleteStationProfileHandler deletes one of the authenticated logbook's station profiles. QSOs
+// already stamped with it keep its fields.
+func (s *Service) deleteStationProfileHandler(c *fiber.Ctx) error {
+	const op errors.Op = "server.Service.deleteStationProfileHandler"
+
+	logbook, err := authenticatedLogbook(c)
+	if err != nil {
+		s.logger.ErrorWith().Err(errors.New(op).Err(err)).Msg("authenticatedLogbook failed")
+		return c.Status(fiber.StatusInternalServerError).JSON(jsonInternalError)
+	}
+
+	id, err := strconv.ParseInt(c.Params("id"), 10, 64)
+	if err != nil || id <= 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(jsonBadRequest)
+	}
+
+	found, err := s.deleteStationProfile(c.UserContext(), logbook.ID, id)
+	if err != nil {
+		s.logger.ErrorWith().Err(errors.New(op).Err(err)).Msg("s.deleteStationProfile failed")
+		return s.dbFailure(c, err)
+	}
+	if !found {
+		return c.Status(fiber.StatusNotFound).JSON(jsonError(errCodeStationProfileNotFound, "Station profile not found"))
+	}
+	return c.SendStatus(fiber.StatusNoContent)
+}
+
+// putStationProfileKeyHandler makes the authenticated logbook's API key with the prefix log with
+// the station profile, in place of any other.
+func (s *Service) putStationProfileKeyHandler(c *fiber.Ctx) error {
+	const op errors.Op = "server.Service.putStationProfileKeyHandler"
+
+	logbook, err := authenticatedLogbook(c)
+	if err != nil {
+		s.logger.ErrorWith().Err(errors.New(op).Err(err)).Msg("authenticatedLogbook failed")
+		return c.Status(fiber.StatusInternalServerError).JSON(jsonInternalError)
+	}
+
+	id, err := strconv.ParseInt(c.Params("id"), 10, 64)
+	if err != nil || id <= 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(jsonBadRequest)
+	}
+
+	found, err := s.assignStationProfileKey(c.UserContext(), logbook.ID, id, c.Params("prefix"))
+	if err != nil {
+		s.logger.ErrorWith().Err(errors.New(op).Err(err)).Msg("s.assignStationProfileKey failed")
+		return s.dbFailure(c, err)
+	}
+	if !found {
+		return c.Status(fiber.StatusNotFound).JSON(jsonError(errCodeNotFound, "Station profile or API key not found"))
+	}
+	return c.SendStatus(fiber.StatusNoContent)
+}
+
+// deleteStationProfileKeyHandler returns the authenticated logbook's API key with the prefix from
+// the station profile to the logbook's default profile.
+func (s *Service) deleteStationProfileKeyHandler(c *fiber.Ctx) error {
+	const op errors.Op = "server.Service.deleteStationProfileKeyHandler"
+
+	logbook, err := authenticatedLogbook(c)
+	if err != nil {
+		s.logger.ErrorWith().Err(errors.New(op).Err(err)).Msg("authenticatedLogbook failed")
+		return c.Status(fiber.StatusInternalServerError).JSON(jsonInternalError)
+	}
+
+	id, err := strconv.ParseInt(c.Params("id"), 10, 64)
+	if err != nil || id <= 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(jsonBadRequest)
+	}
+
+	found, err := s.unassignStationProfileKey(c.UserContext(), logbook.ID, id, c.Params("prefix"))
+	if err != nil {
+		s.logger.ErrorWith().Err(errors.New(op).Err(err)).Msg("s.unassignStationProfileKey failed")
+		return s.dbFailure(c, err)
+	}
+	if !found {
+		return c.Status(fiber.StatusNotFound).JSON(jsonError(errCodeNotFound, "The API key does not log with the station profile"))
+	}
+	return c.SendStatus(fiber.StatusNoContent)
+}
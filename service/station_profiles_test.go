@@ -0,0 +1,118 @@
+package service
+
+import (
+	"context"
+	stderr "errors"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/go-playground/validator/v10"
+	"github.com/goccy/go-json"
+	"github.com/gofiber/fiber/v2"
+)
+
+func TestStationProfiles_StampedIntoQsos(t *testing.T) {
+	svc, logbook := newContestTestServer(t)
+	svc.validate = validator.New(validator.WithRequiredStructEnabled())
+	ctx := context.Background()
+
+	if _, err := svc.db.ExecContext(ctx, `INSERT INTO api_keys (logbook_id, key_name, key_prefix, key_hash) VALUES (1, 'portable', 'abc123', 'key-hash')`); err != nil {
+		t.Fatalf("insert api key failed: %v", err)
+	}
+	if err := svc.saveLogbookDefaults(ctx, logbook.ID, logbookDefaults{TxPwr: "100", MyRig: "IC-7300"}); err != nil {
+		t.Fatalf("saveLogbookDefaults failed: %v", err)
+	}
+
+	app := fiber.New()
+	app.Post("/profiles", withLogbook(1, svc.createStationProfileHandler))
+	app.Put("/profiles/:id", withLogbook(1, svc.putStationProfileHandler))
+	app.Put("/profiles/:id/keys/:prefix", withLogbook(1, svc.putStationProfileKeyHandler))
+	app.Get("/profiles/:id", withLogbook(1, svc.getStationProfileHandler))
+	send := func(method, path, body string) (int, stationProfile) {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set(fiber.HeaderContentType, fiber.MIMEApplicationJSON)
+		resp, err := app.Test(req)
+		if err != nil {
+			t.Fatalf("%s %s failed: %v", method, path, err)
+		}
+		var out struct {
+			Profile stationProfile `json:"profile"`
+		}
+		_ = json.NewDecoder(resp.Body).Decode(&out)
+		return resp.StatusCode, out.Profile
+	}
+
+	status, home := send(fiber.MethodPost, "/profiles", `{"name":"Home","default":true,"my_gridsquare":"fn31pr","my_antenna":"Yagi","my_city":"Newington"}`)
+	if status != fiber.StatusCreated || home.ID == 0 || home.MyGridsquare != "FN31PR" {
+		t.Fatalf("expected the home profile to be created, got %d %+v", status, home)
+	}
+	status, portable := send(fiber.MethodPost, "/profiles", `{"name":"Portable","my_gridsquare":"FN42","my_sig":"pota","my_sig_info":"K-0001","tx_pwr":"10"}`)
+	if status != fiber.StatusCreated {
+		t.Fatalf("expected the portable profile to be created, got %d", status)
+	}
+	if status, _ = send(fiber.MethodPost, "/profiles", `{"name":"Home"}`); status != fiber.StatusConflict {
+		t.Errorf("expected a second profile named Home to get 409, got %d", status)
+	}
+	if status, _ = send(fiber.MethodPost, "/profiles", `{"name":"Bad","tx_pwr":"lots"}`); status != fiber.StatusBadRequest {
+		t.Errorf("expected a non-numeric power to get 400, got %d", status)
+	}
+	if status, _ = send(fiber.MethodPut, "/profiles/"+strconv.FormatInt(portable.ID, 10)+"/keys/nokey", ``); status != fiber.StatusNotFound {
+		t.Errorf("expected an unknown API key to get 404, got %d", status)
+	}
+	if status, _ = send(fiber.MethodPut, "/profiles/"+strconv.FormatInt(portable.ID, 10)+"/keys/abc123", ``); status != fiber.StatusNoContent {
+		t.Fatalf("expected the API key to be assigned, got %d", status)
+	}
+	if status, portable = send(fiber.MethodGet, "/profiles/"+strconv.FormatInt(portable.ID, 10), ``); status != fiber.StatusOK || len(portable.ApiKeys) != 1 || portable.ApiKeys[0] != "abc123" {
+		t.Errorf("expected the profile to list its API key, got %d %+v", status, portable)
+	}
+
+	insert := func(actor, profile string) (stationFields, error) {
+		qso, err := svc.insertQso(ctx, logbook, nil, actor, testContestQso("JA1XX", "20m", "CW"), profile, emptyString, false)
+		if err != nil {
+			return stationFields{}, err
+		}
+		stored, err := svc.db.FetchQsoByIdContext(ctx, qso.ID)
+		if err != nil {
+			t.Fatalf("FetchQsoByIdContext failed: %v", err)
+		}
+		var fields stationFields
+		ours := fields.values()
+		for i, value := range qsoStationValues(&stored) {
+			*ours[i] = *value
+		}
+		return fields, nil
+	}
+
+	// Another key logs with the default profile, completed by the logbook's defaults.
+	fields, err := insert("api_key:other1", emptyString)
+	if err != nil || fields.MyGridsquare != "FN31PR" || fields.MyAntenna != "Yagi" || fields.TxPwr != "100" || fields.MyRig != "IC-7300" {
+		t.Errorf("expected the default profile, got %+v (err=%v)", fields, err)
+	}
+	// The assigned key logs with its profile.
+	if fields, err = insert("api_key:abc123", emptyString); err != nil || fields.MyGridsquare != "FN42" || fields.MySig != "POTA" || fields.TxPwr != "10" || fields.MyCity != emptyString {
+		t.Errorf("expected the key's profile, got %+v (err=%v)", fields, err)
+	}
+	// A QSO naming a profile gets it whatever the key.
+	if fields, err = insert("api_key:abc123", "Home"); err != nil || fields.MyGridsquare != "FN31PR" {
+		t.Errorf("expected the named profile, got %+v (err=%v)", fields, err)
+	}
+	if _, err = insert("api_key:abc123", "Mobile"); !stderr.Is(err, errStationProfileNotFound) {
+		t.Errorf("expected an unknown profile to fail, got %v", err)
+	}
+
+	// Making the portable profile the default takes that from the home profile.
+	if status, portable = send(fiber.MethodPut, "/profiles/"+strconv.FormatInt(portable.ID, 10), `{"name":"Portable","default":true,"my_gridsquare":"FN42"}`); status != fiber.StatusOK || !portable.Default {
+		t.Fatalf("expected the profile to be replaced, got %d %+v", status, portable)
+	}
+	if _, home = send(fiber.MethodGet, "/profiles/"+strconv.FormatInt(home.ID, 10), ``); home.Default {
+		t.Error("expected the home profile to no longer be the default")
+	}
+	if found, err := svc.deleteStationProfile(ctx, logbook.ID, portable.ID); err != nil || !found {
+		t.Fatalf("deleteStationProfile failed: %v", err)
+	}
+	if fields, err = insert("api_key:abc123", emptyString); err != nil || fields.MyGridsquare != emptyString || fields.TxPwr != "100" {
+		t.Errorf("expected only the logbook's defaults once no profile applies, got %+v (err=%v)", fields, err)
+	}
+}
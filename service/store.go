@@ -0,0 +1,32 @@
+package service
+
+import (
+	"context"
+
+	"github.com/Station-Manager/database"
+	"github.com/Station-Manager/types"
+	"github.com/aarondl/sqlboiler/v4/boil"
+)
+
+// store is the part of the database service the server uses. Handlers depend on it rather than on
+// *database.Service so that they can be tested against a fake instead of a database file.
+type store interface {
+	database.Database
+
+	InsertUserContext(ctx context.Context, user types.User) (types.User, error)
+	FetchUserByCallsignContext(ctx context.Context, callsign string) (types.User, error)
+	UpdateUserContext(ctx context.Context, user types.User) error
+
+	InsertAPIKeyContext(ctx context.Context, name, prefix, hash string, logbookID int64) error
+	InsertAPIKeyWithTxContext(ctx context.Context, tx boil.ContextExecutor, name, prefix, hash string, logbookID int64) error
+	FetchAPIKeyByPrefixContext(ctx context.Context, prefix string) (types.ApiKey, error)
+
+	InsertLogbookWithTxContext(ctx context.Context, tx boil.ContextExecutor, logbook types.Logbook) (types.Logbook, error)
+	FetchLogbookByIDContext(ctx context.Context, id int64) (types.Logbook, error)
+
+	InsertQsoContext(ctx context.Context, qso types.Qso) (types.Qso, error)
+	FetchQsoByIdContext(ctx context.Context, id int64) (types.Qso, error)
+	UpdateQsoContext(ctx context.Context, qso types.Qso) error
+}
+
+var _ store = (*database.Service)(nil)
@@ -0,0 +1,74 @@
+package service
+
+import (
+	"context"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/Station-Manager/types"
+	"github.com/gofiber/fiber/v2"
+)
+
+// fakeStore serves logbooks and QSOs from memory. Methods it does not override panic through the
+// nil embedded store, so a test touching anything else fails loudly.
+type fakeStore struct {
+	store
+	logbooks       map[int64]types.Logbook
+	qsos           map[int64]types.Qso
+	logbookFetches int
+}
+
+func (f *fakeStore) FetchLogbookByIDContext(_ context.Context, id int64) (types.Logbook, error) {
+	f.logbookFetches++
+	lb, ok := f.logbooks[id]
+	if !ok {
+		return types.Logbook{}, context.Canceled
+	}
+	return lb, nil
+}
+
+func (f *fakeStore) FetchQsoByIdContext(_ context.Context, id int64) (types.Qso, error) {
+	qso, ok := f.qsos[id]
+	if !ok {
+		return types.Qso{}, context.Canceled
+	}
+	return qso, nil
+}
+
+func TestFetchLogbookWithCache_FakeStore(t *testing.T) {
+	db := &fakeStore{logbooks: map[int64]types.Logbook{7: {ID: 7, Name: "Portable"}}}
+	svc := &Service{db: db}
+	svc.initializeCaches()
+
+	for i := 0; i < 2; i++ {
+		lb, err := svc.fetchLogbookWithCache(context.Background(), 7)
+		if err != nil || lb.Name != "Portable" {
+			t.Fatalf("unexpected logbook %+v (err=%v)", lb, err)
+		}
+	}
+	if db.logbookFetches != 1 {
+		t.Errorf("expected the second fetch to be served from the cache, got %d store fetches", db.logbookFetches)
+	}
+	if _, err := svc.fetchLogbookWithCache(context.Background(), 8); err == nil {
+		t.Error("expected an unknown logbook to fail")
+	}
+}
+
+func TestGetQsoHandler_FakeStore_OtherLogbook(t *testing.T) {
+	qso := types.Qso{ID: 42}
+	qso.LogbookID = 2
+	svc := &Service{db: &fakeStore{qsos: map[int64]types.Qso{42: qso}}}
+
+	app := fiber.New()
+	app.Get("/qsos/:id", withLogbook(1, svc.getQsoHandler))
+
+	for _, path := range []string{"/qsos/42", "/qsos/43"} {
+		resp, err := app.Test(httptest.NewRequest(fiber.MethodGet, path, nil))
+		if err != nil {
+			t.Fatalf("request failed: %v", err)
+		}
+		if resp.StatusCode != fiber.StatusNotFound {
+			t.Errorf("%s: expected 404, got %d", path, resp.StatusCode)
+		}
+	}
+}
@@ -0,0 +1,274 @@
+package service
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Station-Manager/apikey"
+	"github.com/Station-Manager/errors"
+	"github.com/goccy/go-json"
+	"github.com/gofiber/contrib/websocket"
+	"github.com/gofiber/fiber/v2"
+)
+
+const (
+	streamPingInterval = 30 * time.Second
+	streamWriteTimeout = 10 * time.Second
+	// sseHeartbeatInterval is kept below the common 30-60s idle timeouts of proxies and load balancers.
+	sseHeartbeatInterval = 15 * time.Second
+	// sseRetry is the reconnection delay, in milliseconds, suggested to EventSource clients.
+	sseRetry = 5000
+)
+
+// apikeyHeaderAuthNMiddleware authenticates requests, such as event streams, that do not carry the
+// JSON request envelope. The API key is read from an "Authorization: Bearer <key>" header or, for
+// clients such as browsers that cannot set headers on a WebSocket, from the "key" query parameter.
+// Requests signed with the key's signing secret are authenticated by their signature instead.
+func (s *Service) apikeyHeaderAuthNMiddleware() fiber.Handler {
+	const op errors.Op = "server.Service.apikeyHeaderAuthNMiddleware"
+	if s == nil {
+		return serverErrorHandler()
+	}
+
+	return func(c *fiber.Ctx) error {
+		if c.Get(headerRequestSignature) != emptyString {
+			return s.authenticateSignedRequest(c)
+		}
+
+		key := strings.TrimSpace(strings.TrimPrefix(c.Get(fiber.HeaderAuthorization), "Bearer "))
+		if key == emptyString {
+			key = c.Query("key")
+		}
+		if key == emptyString {
+			return c.Status(fiber.StatusUnauthorized).JSON(jsonUnauthorized)
+		}
+		// Fiber's header and query values point into the request buffer, which is reused; the key's
+		// prefix outlives the request as a cache key.
+		key = strings.Clone(key)
+
+		reqCtx, reason, err := s.authenticateApiKey(c.UserContext(), key)
+		if err != nil {
+			s.logger.ErrorWith().Err(errors.New(op).Err(err)).Msg("s.authenticateApiKey failed")
+		}
+		if reqCtx == nil {
+			if reason != emptyString {
+				s.auditApiKeyFailure(c, key, reason)
+			}
+			return c.Status(fiber.StatusUnauthorized).JSON(jsonUnauthorized)
+		}
+		setRequestContext(c, reqCtx)
+
+		return c.Next()
+	}
+}
+
+// authenticateApiKey checks an API key that was not sent in the JSON request envelope and returns
+// the request context of its logbook. A rejected key returns a nil context and, when the rejection
+// belongs in the audit log, the reason to record.
+func (s *Service) authenticateApiKey(ctx context.Context, key string) (*requestContext, string, error) {
+	const op errors.Op = "server.Service.authenticateApiKey"
+
+	validApiKey, logbookId, err := s.isValidApiKey(ctx, key)
+	if err != nil {
+		return nil, "invalid_key", errors.New(op).Err(err)
+	}
+	if !validApiKey {
+		s.logger.InfoWith().Msg("Invalid API key")
+		return nil, "invalid_key", nil
+	}
+
+	prefix, _, err := apikey.ParseApiKey(key)
+	if err != nil {
+		return nil, "invalid_key", errors.New(op).Err(err)
+	}
+	reqCtx, reason, err := s.apiKeyRequestContext(ctx, prefix, logbookId)
+	if err != nil {
+		return nil, reason, errors.New(op).Err(err)
+	}
+	return reqCtx, reason, nil
+}
+
+// apiKeyRequestContext returns the request context of an authenticated API key, known by its
+// prefix, of the logbook. A key that is revoked or holds no role returns a nil context and the
+// reason to record in the audit log.
+func (s *Service) apiKeyRequestContext(ctx context.Context, prefix string, logbookID int64) (*requestContext, string, error) {
+	const op errors.Op = "server.Service.apiKeyRequestContext"
+
+	logbook, err := s.fetchLogbookWithCache(ctx, logbookID)
+	if err != nil {
+		return nil, emptyString, errors.New(op).Err(err)
+	}
+
+	member, err := s.fetchApiKeyPrefixMemberWithCache(ctx, prefix)
+	if err != nil {
+		return nil, emptyString, errors.New(op).Err(err)
+	}
+	if member.Role == emptyString {
+		s.logger.InfoWith().Msg("API key is revoked or holds no role on the logbook")
+		return nil, "no_role", nil
+	}
+
+	reqCtx := &requestContext{Logbook: &logbook, IsValid: true, Actor: apiKeyActorPrefix + prefix, Role: member.Role, Sandbox: member.Sandbox}
+	if member.UserID != 0 {
+		reqCtx.Member = &member
+	}
+	return reqCtx, emptyString, nil
+}
+
+// requireWebSocketUpgrade rejects requests that are not WebSocket upgrade requests.
+func requireWebSocketUpgrade(c *fiber.Ctx) error {
+	if !websocket.IsWebSocketUpgrade(c) {
+		return c.Status(fiber.StatusUpgradeRequired).JSON(jsonError(errCodeWebSocketRequired, "WebSocket upgrade required"))
+	}
+	return c.Next()
+}
+
+// qsoWebSocketHandler streams QSO events for the authenticated logbook as JSON text messages.
+// Messages from the client are ignored; reading only serves to detect that the client has gone away.
+func (s *Service) qsoWebSocketHandler() fiber.Handler {
+	const op errors.Op = "server.Service.qsoWebSocketHandler"
+
+	return websocket.New(func(conn *websocket.Conn) {
+		reqCtx, err := requestContextOf(conn.Locals(localsRequestDataKey))
+		if err != nil || reqCtx.Logbook == nil {
+			err = errors.New(op).Err(err).Msg("Request context missing")
+			s.logger.ErrorWith().Err(err).Msg("WebSocket request context missing")
+			_ = conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseInternalServerErr, emptyString), time.Now().Add(streamWriteTimeout))
+			return
+		}
+
+		sub := s.qsoEvents.Subscribe(reqCtx.Logbook.ID)
+		defer s.qsoEvents.Unsubscribe(sub)
+
+		clientGone := make(chan struct{})
+		go func() {
+			defer close(clientGone)
+			for {
+				if _, _, err := conn.ReadMessage(); err != nil {
+					return
+				}
+			}
+		}()
+
+		ticker := time.NewTicker(streamPingInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-clientGone:
+				return
+			case event, open := <-sub.Events():
+				if !open {
+					// Dropped for falling behind, or the server is shutting down.
+					_ = conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseGoingAway, emptyString), time.Now().Add(streamWriteTimeout))
+					return
+				}
+				_ = conn.SetWriteDeadline(time.Now().Add(streamWriteTimeout))
+				if err := conn.WriteJSON(event); err != nil {
+					return
+				}
+			case <-ticker.C:
+				if err := conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(streamWriteTimeout)); err != nil {
+					return
+				}
+			}
+		}
+	})
+}
+
+// qsoSSEHandler streams QSO events for the authenticated logbook as Server-Sent Events, for clients
+// that cannot use WebSockets. Each event carries its ID, so a reconnecting client that sends the
+// "Last-Event-ID" header (or the "last_event_id" query parameter) first receives the retained events
+// it missed. A comment line is written every sseHeartbeatInterval to keep idle connections open.
+func (s *Service) qsoSSEHandler(c *fiber.Ctx) error {
+	const op errors.Op = "server.Service.qsoSSEHandler"
+	if s == nil {
+		return errors.New(op).Msg(errMsgNilService)
+	}
+	if c == nil {
+		return errors.New(op).Msg(errMsgNilContext)
+	}
+
+	reqCtx, err := getRequestContext(c)
+	if err != nil || reqCtx.Logbook == nil {
+		err = errors.New(op).Err(err).Msg("Request context missing")
+		s.logger.ErrorWith().Err(err).Msg("SSE request context missing")
+		return c.Status(fiber.StatusInternalServerError).JSON(jsonInternalError)
+	}
+
+	lastEventID := c.Get("Last-Event-ID")
+	if lastEventID == emptyString {
+		lastEventID = c.Query("last_event_id")
+	}
+	var lastID uint64
+	if lastEventID != emptyString {
+		if lastID, err = strconv.ParseUint(lastEventID, 10, 64); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(jsonError(errCodeInvalidEventID, "Invalid Last-Event-ID"))
+		}
+	}
+
+	sub, replay := s.qsoEvents.SubscribeFrom(reqCtx.Logbook.ID, lastID)
+
+	c.Set(fiber.HeaderContentType, "text/event-stream")
+	c.Set(fiber.HeaderCacheControl, "no-cache")
+	c.Set(fiber.HeaderConnection, "keep-alive")
+	c.Set("X-Accel-Buffering", "no") // Stop nginx from buffering the stream.
+
+	c.Context().SetBodyStreamWriter(func(w *bufio.Writer) {
+		defer s.qsoEvents.Unsubscribe(sub)
+
+		if _, err := fmt.Fprintf(w, "retry: %d\n\n", sseRetry); err != nil {
+			return
+		}
+		for _, event := range replay {
+			if err := writeSSEEvent(w, event); err != nil {
+				return
+			}
+		}
+		if err := w.Flush(); err != nil {
+			return
+		}
+
+		ticker := time.NewTicker(sseHeartbeatInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case event, open := <-sub.Events():
+				if !open {
+					// Dropped for falling behind, or the server is shutting down; the client
+					// reconnects and resumes from the last event it received.
+					return
+				}
+				if err := writeSSEEvent(w, event); err != nil {
+					return
+				}
+			case <-ticker.C:
+				if _, err := w.WriteString(": keepalive\n\n"); err != nil {
+					return
+				}
+			}
+			// A failed flush is the only sign that the client has gone away.
+			if err := w.Flush(); err != nil {
+				return
+			}
+		}
+	})
+
+	return nil
+}
+
+// writeSSEEvent writes a single event in the text/event-stream format. The JSON encoding never
+// contains a raw newline, so the payload always fits on one data line.
+func writeSSEEvent(w *bufio.Writer, event qsoEvent) error {
+	data, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", event.ID, event.Type, data)
+	return err
+}
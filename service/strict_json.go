@@ -0,0 +1,204 @@
+package service
+
+import (
+	"bytes"
+	stderr "errors"
+	"io"
+	"os"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/Station-Manager/errors"
+	"github.com/goccy/go-json"
+)
+
+// envStrictJSON names the environment variable that turns on strict decoding of the JSON request
+// envelope (e.g. "true"). Strict decoding rejects fields the server does not know and values of the
+// wrong type, listing each offending field, rather than dropping a misspelt field's value.
+const envStrictJSON = "SM_STRICT_JSON"
+
+// loadStrictJSON reads whether request envelopes are decoded strictly.
+func loadStrictJSON() (bool, error) {
+	const op errors.Op = "server.loadStrictJSON"
+
+	value := strings.TrimSpace(os.Getenv(envStrictJSON))
+	if value == emptyString {
+		return false, nil
+	}
+	strict, err := strconv.ParseBool(value)
+	if err != nil {
+		return false, errors.New(op).Msg(envStrictJSON + " must be true or false")
+	}
+	return strict, nil
+}
+
+var jsonUnmarshalerType = reflect.TypeFor[json.Unmarshaler]()
+
+// jsonFieldError is a field of a request body that strict decoding rejected.
+type jsonFieldError struct {
+	// Field is the field's path in the body, with the names of nested objects joined by dots.
+	Field string `json:"field"`
+	Error string `json:"error"`
+}
+
+// strictJSONError lists the fields that strict decoding rejected.
+type strictJSONError struct {
+	Fields []jsonFieldError
+}
+
+func (e *strictJSONError) Error() string {
+	names := make([]string, len(e.Fields))
+	for i, f := range e.Fields {
+		names[i] = f.Field + ": " + f.Error
+	}
+	return "invalid fields: " + strings.Join(names, "; ")
+}
+
+// decodeStrictJSON decodes a JSON document into v, which must point to a struct. Unknown fields
+// and values of the wrong type are reported as a *strictJSONError; a body that is not JSON at all
+// is reported as is.
+func decodeStrictJSON(data []byte, v any) error {
+	var document any
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	if err := decoder.Decode(&document); err != nil {
+		return err
+	}
+	if _, err := decoder.Token(); err != io.EOF {
+		return stderr.New("unexpected data after the JSON document")
+	}
+	if fields := jsonFieldProblems(document, reflect.TypeOf(v), emptyString); len(fields) > 0 {
+		sort.Slice(fields, func(i, j int) bool { return fields[i].Field < fields[j].Field })
+		return &strictJSONError{Fields: fields}
+	}
+	return json.Unmarshal(data, v)
+}
+
+// jsonFieldProblems returns the fields of a decoded JSON value that have no counterpart in the Go
+// type t or hold a value of the wrong type. Names must match exactly, unlike in lenient decoding,
+// which ignores their case. Maps, interfaces and types that decode themselves accept any field.
+func jsonFieldProblems(value any, t reflect.Type, path string) []jsonFieldError {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	if value == nil || t.Kind() == reflect.Interface || reflect.PointerTo(t).Implements(jsonUnmarshalerType) {
+		return nil
+	}
+	if want := jsonTypeName(t); want != jsonTypeOf(value, t) {
+		return []jsonFieldError{{Field: path, Error: "expected " + want}}
+	}
+
+	var problems []jsonFieldError
+	switch v := value.(type) {
+	case map[string]any:
+		if t.Kind() != reflect.Struct {
+			return nil
+		}
+		known := jsonFieldTypes(t)
+		for name, item := range v {
+			itemPath := name
+			if path != emptyString {
+				itemPath = path + "." + name
+			}
+			fieldType, ok := known[name]
+			if !ok {
+				problems = append(problems, jsonFieldError{Field: itemPath, Error: "unknown field"})
+				continue
+			}
+			problems = append(problems, jsonFieldProblems(item, fieldType, itemPath)...)
+		}
+	case []any:
+		for i, item := range v {
+			problems = append(problems, jsonFieldProblems(item, t.Elem(), path+"["+strconv.Itoa(i)+"]")...)
+		}
+	}
+	return problems
+}
+
+// jsonTypeOf names the JSON type of a decoded value in the terms of jsonTypeName. A number is an
+// integer if t is an integer type and the number has no fraction.
+func jsonTypeOf(value any, t reflect.Type) string {
+	switch v := value.(type) {
+	case bool:
+		return "a boolean"
+	case string:
+		return "a string"
+	case []any:
+		return "an array"
+	case map[string]any:
+		return "an object"
+	case json.Number:
+		if jsonTypeName(t) != "an integer" {
+			return "a number"
+		}
+		if _, err := v.Int64(); err == nil {
+			return "an integer"
+		}
+		if _, err := strconv.ParseUint(v.String(), 10, 64); err == nil {
+			return "an integer"
+		}
+		return "a number"
+	}
+	return "a value"
+}
+
+// jsonFieldTypes returns the Go types of a struct's fields by their JSON names, including the
+// fields of embedded structs, as encoding/json names them.
+func jsonFieldTypes(t reflect.Type) map[string]reflect.Type {
+	fields := make(map[string]reflect.Type)
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		tag := field.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name, _, _ := strings.Cut(tag, ",")
+		if field.Anonymous && name == emptyString {
+			embedded := field.Type
+			for embedded.Kind() == reflect.Pointer {
+				embedded = embedded.Elem()
+			}
+			if embedded.Kind() == reflect.Struct {
+				for embeddedName, embeddedType := range jsonFieldTypes(embedded) {
+					if _, ok := fields[embeddedName]; !ok {
+						fields[embeddedName] = embeddedType
+					}
+				}
+				continue
+			}
+		}
+		if !field.IsExported() {
+			continue
+		}
+		if name == emptyString {
+			name = field.Name
+		}
+		fields[name] = field.Type
+	}
+	return fields
+}
+
+// jsonTypeName names the JSON type that decodes into t.
+func jsonTypeName(t reflect.Type) string {
+	if t == nil {
+		return "a value"
+	}
+	switch t.Kind() {
+	case reflect.Bool:
+		return "a boolean"
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return "an integer"
+	case reflect.Float32, reflect.Float64:
+		return "a number"
+	case reflect.String:
+		return "a string"
+	case reflect.Slice, reflect.Array:
+		return "an array"
+	case reflect.Map, reflect.Struct:
+		return "an object"
+	}
+	return "a value"
+}
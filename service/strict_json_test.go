@@ -0,0 +1,97 @@
+package service
+
+import (
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/Station-Manager/types"
+	"github.com/goccy/go-json"
+	"github.com/gofiber/fiber/v2"
+)
+
+func TestDecodeStrictJSON(t *testing.T) {
+	var request types.PostRequest
+	if err := decodeStrictJSON([]byte(`{"callsign":"K1AB","key":"k","qso":{"call":"DL1ABC","band":"20m"}}`), &request); err != nil {
+		t.Fatalf("expected a valid envelope to decode, got %v", err)
+	}
+	if request.Qso == nil || request.Qso.Band != "20m" {
+		t.Errorf("expected the QSO to be decoded, got %+v", request.Qso)
+	}
+
+	cases := map[string][]jsonFieldError{
+		`{"callsign":"K1AB","qso":{"call":"DL1ABC","bandd":"20m"},"extra":1}`: {
+			{Field: "extra", Error: "unknown field"},
+			{Field: "qso.bandd", Error: "unknown field"},
+		},
+		`{"callsign":"K1AB","logbook":{"id":"seven"}}`: {
+			{Field: "logbook.id", Error: "expected an integer"},
+		},
+	}
+	for body, want := range cases {
+		err := decodeStrictJSON([]byte(body), &types.PostRequest{})
+		invalid, ok := err.(*strictJSONError)
+		if !ok || !reflect.DeepEqual(invalid.Fields, want) {
+			t.Errorf("%s: expected %v, got %v", body, want, err)
+		}
+	}
+
+	if err := decodeStrictJSON([]byte(`{"callsign":"K1AB"} {}`), &types.PostRequest{}); err == nil {
+		t.Error("expected trailing data to be rejected")
+	}
+}
+
+func TestRequestContextMiddleware_Strict(t *testing.T) {
+	svc := newTestServer(t)
+	app := fiber.New()
+	app.Post("/", svc.requestContextMiddleware(), func(c *fiber.Ctx) error { return c.SendStatus(fiber.StatusNoContent) })
+
+	post := func() *httptest.ResponseRecorder {
+		req := httptest.NewRequest(fiber.MethodPost, "/", strings.NewReader(`{"callsign":"K1AB","key":"k","qso":{"bandd":"20m"}}`))
+		req.Header.Set(fiber.HeaderContentType, fiber.MIMEApplicationJSON)
+		resp, err := app.Test(req)
+		if err != nil {
+			t.Fatalf("request failed: %v", err)
+		}
+		rec := httptest.NewRecorder()
+		rec.Code = resp.StatusCode
+		_, _ = rec.Body.ReadFrom(resp.Body)
+		return rec
+	}
+
+	if rec := post(); rec.Code != fiber.StatusNoContent {
+		t.Fatalf("expected the unknown field to be ignored by default, got %d", rec.Code)
+	}
+
+	svc.strictJSON = true
+	rec := post()
+	var body struct {
+		Code   errorCode        `json:"code"`
+		Fields []jsonFieldError `json:"fields"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+		t.Fatalf("decode failed: %v", err)
+	}
+	if rec.Code != fiber.StatusBadRequest || body.Code != errCodeInvalidFields || len(body.Fields) != 1 || body.Fields[0].Field != "qso.bandd" {
+		t.Errorf("expected the unknown field to be listed, got %d %+v", rec.Code, body)
+	}
+
+	// The fields that routes add to the envelope are read with it, and reach the handler.
+	var envelope requestEnvelope
+	app.Post("/admin", svc.requestContextMiddleware(), func(c *fiber.Ctx) error {
+		if reqCtx, err := getRequestContext(c); err == nil {
+			envelope = reqCtx.Request
+		}
+		return c.SendStatus(fiber.StatusNoContent)
+	})
+	req := httptest.NewRequest(fiber.MethodPost, "/admin", strings.NewReader(`{"callsign":"K1AB","key":"k","otp":"123456","member":"W1AW","mode":"archive"}`))
+	req.Header.Set(fiber.HeaderContentType, fiber.MIMEApplicationJSON)
+	resp, err := app.Test(req)
+	if err != nil {
+		t.Fatalf("request failed: %v", err)
+	}
+	if resp.StatusCode != fiber.StatusNoContent || envelope.OTP != "123456" || envelope.Member != "W1AW" || envelope.Mode != logbookDeleteArchive {
+		t.Errorf("expected the route fields to be accepted, got %d %+v", resp.StatusCode, envelope)
+	}
+}
@@ -0,0 +1,560 @@
+package service
+
+import (
+	"context"
+	"database/sql"
+	"encoding/base64"
+	stderr "errors"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Station-Manager/errors"
+	"github.com/Station-Manager/types"
+	"github.com/go-playground/validator/v10"
+	"github.com/gofiber/fiber/v2"
+)
+
+// Offline clients keep a copy of a logbook and reconcile it with the server in two steps. They
+// push the changes made locally, naming each QSO by a UUID they generated, then pull the changes
+// made on the server since the sync token of their previous pull.
+//
+// Every change to a QSO is recorded in its history, and the ID of its latest history entry is the
+// QSO's version. A pushed change carries the version it was based on. When the QSO has changed on
+// the server since, the change conflicts, and the later of the two changes wins: the client's
+// modified_at against the time of the server's latest change. The server wins ties and changes
+// without a modified_at, so that every instance settles a conflict the same way.
+
+const (
+	syncPullDefault  = 100
+	syncPullMax      = 500
+	syncTokenChanges = "changes"
+	syncTokenFull    = "snapshot"
+)
+
+type syncOp string
+
+const (
+	syncOpUpsert syncOp = "upsert"
+	syncOpDelete syncOp = "delete"
+)
+
+type syncStatus string
+
+const (
+	syncApplied  syncStatus = "applied"
+	syncConflict syncStatus = "conflict"
+	syncRejected syncStatus = "rejected"
+)
+
+// syncChange is a change made by an offline client.
+type syncChange struct {
+	UUID string `json:"uuid" validate:"required,uuid"`
+	Op   syncOp `json:"op" validate:"required,oneof=upsert delete"`
+	// Version is the version of the QSO the change was based on; zero for a QSO the client created.
+	Version    int64     `json:"version" validate:"gte=0"`
+	ModifiedAt time.Time `json:"modified_at"`
+	// Qso is checked against the logbook once the change is applied, not with the request.
+	Qso *types.Qso `json:"qso,omitempty" validate:"-"`
+}
+
+// syncPushRequest is the body of POST /sync/push.
+type syncPushRequest struct {
+	Changes []syncChange `json:"changes" validate:"required,max=500,dive"`
+}
+
+// syncRecord is the server's copy of a QSO. A QSO in the trash has no body.
+type syncRecord struct {
+	UUID    string     `json:"uuid"`
+	ID      int64      `json:"id"`
+	Version int64      `json:"version"`
+	Deleted bool       `json:"deleted"`
+	Qso     *types.Qso `json:"qso,omitempty"`
+}
+
+// syncResult is the outcome of a pushed change. A conflicting change that lost carries the
+// server's copy of the QSO, which the client should keep instead of its own.
+type syncResult struct {
+	UUID    string      `json:"uuid"`
+	Status  syncStatus  `json:"status"`
+	ID      int64       `json:"id,omitempty"`
+	Version int64       `json:"version,omitempty"`
+	Error   string      `json:"error,omitempty"`
+	Server  *syncRecord `json:"server,omitempty"`
+}
+
+// qsoSyncState is the server's state of a QSO, as a pushed change is compared with it.
+type qsoSyncState struct {
+	deleted   bool
+	version   int64
+	changedAt time.Time
+}
+
+// syncPushHandler applies the changes of an offline client to the authenticated logbook, in
+// order. Each change is applied, lost to a conflicting server change, or rejected on its own.
+func (s *Service) syncPushHandler(c *fiber.Ctx) error {
+	const op errors.Op = "server.Service.syncPushHandler"
+
+	reqCtx, err := getRequestContext(c)
+	if err != nil || reqCtx.Logbook == nil {
+		err = errors.New(op).Err(err).Msg("Request context missing")
+		s.logger.ErrorWith().Err(err).Msg("getRequestContext failed")
+		return c.Status(fiber.StatusInternalServerError).JSON(jsonInternalError)
+	}
+	const invalid = "Push at most 500 changes, each with a UUID, an op and, to upsert, a QSO"
+	var request syncPushRequest
+	if err = c.BodyParser(&request); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(jsonBadRequest)
+	}
+	if err = s.validate.Struct(request); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(jsonError(errCodeBadRequest, invalid))
+	}
+	for _, change := range request.Changes {
+		if change.Op == syncOpUpsert && change.Qso == nil {
+			return c.Status(fiber.StatusBadRequest).JSON(jsonError(errCodeBadRequest, invalid))
+		}
+	}
+
+	results := make([]syncResult, 0, len(request.Changes))
+	for _, change := range request.Changes {
+		change.UUID = strings.ToLower(change.UUID)
+		result, err := s.applySyncChange(c.UserContext(), reqCtx, change)
+		if err != nil {
+			s.logger.ErrorWith().Err(errors.New(op).Err(err)).Str("uuid", change.UUID).Msg("s.applySyncChange failed")
+			return s.dbFailure(c, err)
+		}
+		results = append(results, result)
+	}
+	return c.JSON(fiber.Map{"results": results})
+}
+
+// applySyncChange applies one pushed change. Errors are returned only for failures of the server;
+// a change the server will not accept is reported in the result.
+func (s *Service) applySyncChange(ctx context.Context, reqCtx *requestContext, change syncChange) (syncResult, error) {
+	const op errors.Op = "server.Service.applySyncChange"
+
+	logbook := *reqCtx.Logbook
+	result := syncResult{UUID: change.UUID}
+
+	qsoID, found, err := s.fetchQsoIDByUUID(ctx, logbook.ID, change.UUID)
+	if err != nil {
+		return result, errors.New(op).Err(err)
+	}
+	if !found {
+		if change.Op == syncOpDelete {
+			// The QSO never reached the server, or has been purged from the trash since.
+			result.Status = syncApplied
+			return result, nil
+		}
+		return s.insertSyncQso(ctx, reqCtx, change)
+	}
+	result.ID = qsoID
+
+	state, err := s.fetchQsoSyncState(ctx, qsoID)
+	if err != nil {
+		return result, errors.New(op).Err(err)
+	}
+	if change.Version != state.version && !clientChangeWins(change.ModifiedAt, state.changedAt) {
+		record, err := s.syncRecordOf(ctx, logbook.ID, qsoID, state.version, state.deleted)
+		if err != nil {
+			return result, errors.New(op).Err(err)
+		}
+		result.Status, result.Version, result.Server = syncConflict, state.version, &record
+		return result, nil
+	}
+
+	switch change.Op {
+	case syncOpDelete:
+		if !state.deleted {
+			qso, err := s.db.FetchQsoByIdContext(ctx, qsoID)
+			if err != nil {
+				return result, errors.New(op).Err(err)
+			}
+			if _, err = s.setQsoDeleted(ctx, logbook.ID, qsoID, true, reqCtx.Actor); err != nil {
+				return result, errors.New(op).Err(err)
+			}
+			s.publishQsoEvent(ctx, qsoEventDeleted, qso)
+		}
+	case syncOpUpsert:
+		if state.deleted {
+			if _, err = s.setQsoDeleted(ctx, logbook.ID, qsoID, false, reqCtx.Actor); err != nil {
+				return result, errors.New(op).Err(err)
+			}
+		}
+		if msg, err := s.updateSyncQso(ctx, logbook, reqCtx.Actor, qsoID, *change.Qso); err != nil {
+			return result, errors.New(op).Err(err)
+		} else if msg != emptyString {
+			result.Status, result.Error = syncRejected, msg
+			return result, nil
+		}
+	}
+
+	result.Status = syncApplied
+	if result.Version, err = s.fetchQsoVersion(ctx, qsoID); err != nil {
+		return result, errors.New(op).Err(err)
+	}
+	return result, nil
+}
+
+// clientChangeWins settles a conflict: the client's change wins only when it was made after the
+// server's latest change. Times are compared to the second, the precision of the history.
+func clientChangeWins(clientModifiedAt, serverChangedAt time.Time) bool {
+	if clientModifiedAt.IsZero() {
+		return false
+	}
+	// A client clock running ahead cannot win every conflict to come.
+	if now := time.Now(); clientModifiedAt.After(now) {
+		clientModifiedAt = now
+	}
+	return clientModifiedAt.Truncate(time.Second).After(serverChangedAt.Truncate(time.Second))
+}
+
+// insertSyncQso logs a QSO created by an offline client under the client's UUID.
+func (s *Service) insertSyncQso(ctx context.Context, reqCtx *requestContext, change syncChange) (syncResult, error) {
+	const op errors.Op = "server.Service.insertSyncQso"
+
+	result := syncResult{UUID: change.UUID}
+	qso := *change.Qso
+	qso.ID = 0
+	qso, err := s.insertQso(ctx, *reqCtx.Logbook, reqCtx.Member, reqCtx.Actor, qso, emptyString, change.UUID, false)
+	if err != nil {
+		if msg, rejected := syncRejection(err); rejected {
+			result.Status, result.Error = syncRejected, msg
+			return result, nil
+		}
+		return result, errors.New(op).Err(err)
+	}
+
+	result.Status, result.ID = syncApplied, qso.ID
+	if result.Version, err = s.fetchQsoVersion(ctx, qso.ID); err != nil {
+		return result, errors.New(op).Err(err)
+	}
+	return result, nil
+}
+
+// updateSyncQso replaces the fields of a QSO with those of the client's copy. It returns why the
+// copy was rejected, if it was.
+func (s *Service) updateSyncQso(ctx context.Context, logbook types.Logbook, actor string, qsoID int64, qso types.Qso) (string, error) {
+	const op errors.Op = "server.Service.updateSyncQso"
+
+	before, err := s.db.FetchQsoByIdContext(ctx, qsoID)
+	if err != nil {
+		return emptyString, errors.New(op).Err(err)
+	}
+	if err = checkQsoCallsigns(qso); err != nil {
+		return err.Error(), nil
+	}
+	if qso.StationCallsign != logbook.Callsign {
+		return errQsoCallsignMismatch.Error(), nil
+	}
+	qso.ID, qso.LogbookID, qso.SessionID = before.ID, before.LogbookID, before.SessionID
+	normalizeQsoMode(&qso)
+	defaults, err := s.fetchLogbookDefaults(ctx, logbook.ID)
+	if err != nil {
+		return emptyString, errors.New(op).Err(err)
+	}
+	if err = defaults.clock().normalize(&qso, time.Now()); err != nil {
+		return err.Error(), nil
+	}
+	if err = s.validate.Struct(qso); err != nil {
+		return err.Error(), nil
+	}
+	s.resolveQsoEntity(&qso)
+
+	if err = s.db.UpdateQsoContext(ctx, qso); err != nil {
+		if msg, rejected := syncRejection(err); rejected {
+			return msg, nil
+		}
+		return emptyString, errors.New(op).Err(err)
+	}
+	s.recordQsoChange(ctx, qsoHistoryUpdate, actor, before, qso)
+	s.publishQsoUpdated(ctx, qsoID)
+	return emptyString, nil
+}
+
+// syncRejection returns why the server refused a QSO, when the fault lies with the QSO.
+func syncRejection(err error) (string, bool) {
+	var badTime *qsoTimeError
+	var badCall *callsignError
+	var exceeded *quotaExceededError
+	if stderr.Is(err, errQsoCallsignMismatch) || stderr.Is(err, errContestDupe) || stderr.As(err, &badTime) || stderr.As(err, &badCall) || stderr.As(err, &exceeded) {
+		return err.Error(), true
+	}
+	if kind := classifyDBError(err); kind == dbErrorUnique || kind == dbErrorForeignKey {
+		return dbErrorResponses[kind].message, true
+	}
+	var invalid validator.ValidationErrors
+	if stderr.As(err, &invalid) {
+		return invalid.Error(), true
+	}
+	return emptyString, false
+}
+
+// syncPullHandler returns the QSOs of the authenticated logbook that changed after the sync token,
+// oldest change first. Without a token it returns every QSO; the last page's token then continues
+// with the changes made since. The response's "more" is true while further pages remain.
+func (s *Service) syncPullHandler(c *fiber.Ctx) error {
+	const op errors.Op = "server.Service.syncPullHandler"
+
+	logbook, err := authenticatedLogbook(c)
+	if err != nil {
+		s.logger.ErrorWith().Err(errors.New(op).Err(err)).Msg("authenticatedLogbook failed")
+		return c.Status(fiber.StatusInternalServerError).JSON(jsonInternalError)
+	}
+	limit := c.QueryInt("limit", syncPullDefault)
+	if limit <= 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(jsonBadRequest)
+	}
+	limit = min(limit, syncPullMax)
+
+	ctx := c.UserContext()
+	token, ok := parseSyncToken(c.Query("token"))
+	if !ok {
+		return c.Status(fiber.StatusBadRequest).JSON(jsonError(errCodeInvalidSyncToken, "The sync token is not valid"))
+	}
+	if token.kind == emptyString {
+		// A first sync starts with a snapshot. Changes made while it is taken are pulled again
+		// afterwards, which is harmless.
+		if token.mark, err = s.fetchLogbookVersion(ctx, logbook.ID); err != nil {
+			s.logger.ErrorWith().Err(errors.New(op).Err(err)).Msg("s.fetchLogbookVersion failed")
+			return s.dbFailure(c, err)
+		}
+		token.kind = syncTokenFull
+	}
+
+	var records []syncRecord
+	var next syncToken
+	if token.kind == syncTokenFull {
+		records, next, err = s.pullSyncSnapshot(ctx, logbook.ID, token, limit)
+	} else {
+		records, next, err = s.pullSyncChanges(ctx, logbook.ID, token, limit)
+	}
+	if err != nil {
+		s.logger.ErrorWith().Err(errors.New(op).Err(err)).Int64("logbook_id", logbook.ID).Msg("Sync pull failed")
+		return c.Status(fiber.StatusInternalServerError).JSON(jsonInternalError)
+	}
+	return c.JSON(fiber.Map{"changes": records, "token": next.String(), "more": len(records) == limit})
+}
+
+// pullSyncSnapshot returns a page of the logbook's QSOs in ID order. Once the snapshot is
+// complete the token continues with the changes after its mark.
+func (s *Service) pullSyncSnapshot(ctx context.Context, logbookID int64, token syncToken, limit int) ([]syncRecord, syncToken, error) {
+	const op errors.Op = "server.Service.pullSyncSnapshot"
+
+	ids, err := s.listLogbookQsoIDPage(ctx, logbookID, token.after, limit)
+	if err != nil {
+		return nil, token, errors.New(op).Err(err)
+	}
+	records := make([]syncRecord, 0, len(ids))
+	for _, id := range ids {
+		version, err := s.fetchQsoVersion(ctx, id)
+		if err != nil {
+			return nil, token, errors.New(op).Err(err)
+		}
+		record, err := s.syncRecordOf(ctx, logbookID, id, version, false)
+		if err != nil {
+			return nil, token, errors.New(op).Err(err)
+		}
+		records = append(records, record)
+	}
+
+	if len(ids) < limit {
+		return records, syncToken{kind: syncTokenChanges, after: token.mark}, nil
+	}
+	return records, syncToken{kind: syncTokenFull, after: ids[len(ids)-1], mark: token.mark}, nil
+}
+
+// pullSyncChanges returns the QSOs with history entries after the token, each once, in the order
+// of their latest change.
+func (s *Service) pullSyncChanges(ctx context.Context, logbookID int64, token syncToken, limit int) ([]syncRecord, syncToken, error) {
+	const op errors.Op = "server.Service.pullSyncChanges"
+
+	type change struct{ qsoID, version int64 }
+	var changes []change
+	rows, err := s.db.QueryContext(ctx, `SELECT qso_id, MAX(id) FROM qso_history WHERE logbook_id = $1 AND id > $2
+		GROUP BY qso_id ORDER BY MAX(id) LIMIT $3`, logbookID, token.after, limit)
+	if err != nil {
+		return nil, token, errors.New(op).Err(err)
+	}
+	for rows.Next() {
+		var ch change
+		if err = rows.Scan(&ch.qsoID, &ch.version); err != nil {
+			_ = rows.Close()
+			return nil, token, errors.New(op).Err(err)
+		}
+		changes = append(changes, ch)
+	}
+	_ = rows.Close()
+	if err = rows.Err(); err != nil {
+		return nil, token, errors.New(op).Err(err)
+	}
+
+	records := make([]syncRecord, 0, len(changes))
+	next := token
+	for _, ch := range changes {
+		state, err := s.fetchQsoSyncState(ctx, ch.qsoID)
+		if err != nil {
+			return nil, token, errors.New(op).Err(err)
+		}
+		record, err := s.syncRecordOf(ctx, logbookID, ch.qsoID, ch.version, state.deleted)
+		if err != nil {
+			return nil, token, errors.New(op).Err(err)
+		}
+		records = append(records, record)
+		next.after = ch.version
+	}
+	return records, next, nil
+}
+
+// syncRecordOf returns the server's copy of a QSO, giving it a UUID if it has none yet.
+func (s *Service) syncRecordOf(ctx context.Context, logbookID, qsoID, version int64, deleted bool) (syncRecord, error) {
+	const op errors.Op = "server.Service.syncRecordOf"
+
+	uuids, err := s.qsoUUIDs(ctx, logbookID, []int64{qsoID})
+	if err != nil {
+		return syncRecord{}, errors.New(op).Err(err)
+	}
+	record := syncRecord{UUID: uuids[qsoID], ID: qsoID, Version: version, Deleted: deleted}
+	if !deleted {
+		qso, err := s.db.FetchQsoByIdContext(ctx, qsoID)
+		if err != nil {
+			return record, errors.New(op).Err(err)
+		}
+		record.Qso = &qso
+	}
+	return record, nil
+}
+
+// fetchQsoIDByUUID returns the ID of the logbook's QSO with the UUID.
+func (s *Service) fetchQsoIDByUUID(ctx context.Context, logbookID int64, id string) (int64, bool, error) {
+	const op errors.Op = "server.Service.fetchQsoIDByUUID"
+
+	rows, err := s.db.QueryContext(ctx, `SELECT qso_id FROM qso_uuids WHERE logbook_id = $1 AND uuid = $2`, logbookID, id)
+	if err != nil {
+		return 0, false, errors.New(op).Err(err)
+	}
+	defer func() { _ = rows.Close() }()
+
+	var qsoID int64
+	found := rows.Next()
+	if found {
+		if err = rows.Scan(&qsoID); err != nil {
+			return 0, false, errors.New(op).Err(err)
+		}
+	}
+	if err = rows.Err(); err != nil {
+		return 0, false, errors.New(op).Err(err)
+	}
+	return qsoID, found, nil
+}
+
+// fetchQsoSyncState returns whether a QSO is in the trash, and its version and the time of its
+// latest change. A QSO without history has version zero.
+func (s *Service) fetchQsoSyncState(ctx context.Context, qsoID int64) (qsoSyncState, error) {
+	const op errors.Op = "server.Service.fetchQsoSyncState"
+
+	var state qsoSyncState
+	rows, err := s.db.QueryContext(ctx, `SELECT q.deleted_at IS NOT NULL, COALESCE(h.id, 0), COALESCE(`+s.timestampExpr(`h.created_at`)+`, '')
+		FROM qso q LEFT JOIN qso_history h ON h.id = (SELECT MAX(id) FROM qso_history WHERE qso_id = q.id)
+		WHERE q.id = $1`, qsoID)
+	if err != nil {
+		return state, errors.New(op).Err(err)
+	}
+	defer func() { _ = rows.Close() }()
+
+	if !rows.Next() {
+		if err = rows.Err(); err == nil {
+			err = sql.ErrNoRows
+		}
+		return state, errors.New(op).Err(err)
+	}
+	var changedAt string
+	if err = rows.Scan(&state.deleted, &state.version, &changedAt); err != nil {
+		return state, errors.New(op).Err(err)
+	}
+	if changedAt != emptyString {
+		if state.changedAt, err = time.Parse(time.RFC3339, changedAt); err != nil {
+			return state, errors.New(op).Err(err)
+		}
+	}
+	return state, nil
+}
+
+// fetchQsoVersion returns the ID of the QSO's latest history entry.
+func (s *Service) fetchQsoVersion(ctx context.Context, qsoID int64) (int64, error) {
+	return s.fetchMaxHistoryID(ctx, `qso_id`, qsoID)
+}
+
+// fetchLogbookVersion returns the ID of the latest history entry of the logbook's QSOs.
+func (s *Service) fetchLogbookVersion(ctx context.Context, logbookID int64) (int64, error) {
+	return s.fetchMaxHistoryID(ctx, `logbook_id`, logbookID)
+}
+
+func (s *Service) fetchMaxHistoryID(ctx context.Context, column string, id int64) (int64, error) {
+	const op errors.Op = "server.Service.fetchMaxHistoryID"
+
+	rows, err := s.db.QueryContext(ctx, `SELECT COALESCE(MAX(id), 0) FROM qso_history WHERE `+column+` = $1`, id)
+	if err != nil {
+		return 0, errors.New(op).Err(err)
+	}
+	defer func() { _ = rows.Close() }()
+
+	var version int64
+	if rows.Next() {
+		if err = rows.Scan(&version); err != nil {
+			return 0, errors.New(op).Err(err)
+		}
+	}
+	if err = rows.Err(); err != nil {
+		return 0, errors.New(op).Err(err)
+	}
+	return version, nil
+}
+
+// syncToken is the position of a client in the logbook's changes. During the first sync it walks
+// the QSOs by ID (after) and remembers where the history stood when the walk began (mark);
+// afterwards it is the ID of the last history entry the client has seen (after).
+type syncToken struct {
+	kind  string
+	after int64
+	mark  int64
+}
+
+// String encodes the token. Clients treat it as opaque.
+func (t syncToken) String() string {
+	value := t.kind + ":" + strconv.FormatInt(t.after, 10)
+	if t.kind == syncTokenFull {
+		value += ":" + strconv.FormatInt(t.mark, 10)
+	}
+	return base64.RawURLEncoding.EncodeToString([]byte(value))
+}
+
+// parseSyncToken decodes a token made by syncToken.String. An empty token is valid and has no kind.
+func parseSyncToken(value string) (syncToken, bool) {
+	var token syncToken
+	if value == emptyString {
+		return token, true
+	}
+	decoded, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return token, false
+	}
+	parts := strings.Split(string(decoded), ":")
+	numbers := make([]int64, 0, 2)
+	for _, part := range parts[1:] {
+		n, err := strconv.ParseInt(part, 10, 64)
+		if err != nil || n < 0 {
+			return token, false
+		}
+		numbers = append(numbers, n)
+	}
+	switch {
+	case parts[0] == syncTokenChanges && len(numbers) == 1:
+		token = syncToken{kind: syncTokenChanges, after: numbers[0]}
+	case parts[0] == syncTokenFull && len(numbers) == 2:
+		token = syncToken{kind: syncTokenFull, after: numbers[0], mark: numbers[1]}
+	default:
+		return token, false
+	}
+	return token, true
+}
@@ -0,0 +1,181 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/goccy/go-json"
+	"github.com/gofiber/fiber/v2"
+)
+
+func TestSyncToken_RoundTrip(t *testing.T) {
+	for _, token := range []syncToken{
+		{kind: syncTokenFull, after: 12, mark: 40},
+		{kind: syncTokenChanges, after: 41},
+	} {
+		got, ok := parseSyncToken(token.String())
+		if !ok || got != token {
+			t.Errorf("expected %+v back, got %+v %v", token, got, ok)
+		}
+	}
+	for _, value := range []string{"not base64!", "Y2hhbmdlczp4", "c25hcHNob3Q6MQ"} {
+		if _, ok := parseSyncToken(value); ok {
+			t.Errorf("expected %q to be rejected", value)
+		}
+	}
+}
+
+func TestClientChangeWins(t *testing.T) {
+	server := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	cases := []struct {
+		client time.Time
+		want   bool
+	}{
+		{time.Time{}, false},
+		{server, false},
+		{server.Add(500 * time.Millisecond), false},
+		{server.Add(time.Second), true},
+		{server.Add(-time.Minute), false},
+	}
+	for _, tc := range cases {
+		if got := clientChangeWins(tc.client, server); got != tc.want {
+			t.Errorf("clientChangeWins(%v): expected %v, got %v", tc.client, tc.want, got)
+		}
+	}
+}
+
+func TestSync_PushAndPull(t *testing.T) {
+	svc := newTestServer(t)
+	svc.db = newLiveStore(svc.db)
+	ctx := context.Background()
+	for _, query := range []string{
+		`INSERT INTO users (id, callsign, pass_hash) VALUES (8, 'K1AB', 'hash')`,
+		`UPDATE logbook SET user_id = 8, callsign = 'K1AB' WHERE id = 1`,
+		`INSERT INTO session (id) VALUES (1)`,
+	} {
+		if _, err := svc.db.ExecContext(ctx, query); err != nil {
+			t.Fatalf("setup failed: %v", err)
+		}
+	}
+	key, err := svc.issueMemberApiKey(ctx, 1, 8, "K1AB")
+	if err != nil {
+		t.Fatalf("issueMemberApiKey failed: %v", err)
+	}
+	serverQso := insertTestQso(t, svc, "JA1XX", "20m", "FT8", "20240430", "1203")
+
+	send := func(method, path string, body any, out any) int {
+		t.Helper()
+		var reader *bytes.Reader
+		if body != nil {
+			data, _ := json.Marshal(body)
+			reader = bytes.NewReader(data)
+		} else {
+			reader = bytes.NewReader(nil)
+		}
+		req := httptest.NewRequest(method, path, reader)
+		req.Header.Set(fiber.HeaderAuthorization, "Bearer "+key)
+		req.Header.Set(fiber.HeaderContentType, fiber.MIMEApplicationJSON)
+		resp, err := svc.app.Test(req)
+		if err != nil {
+			t.Fatalf("%s %s failed: %v", method, path, err)
+		}
+		if out != nil {
+			if err = json.NewDecoder(resp.Body).Decode(out); err != nil {
+				t.Fatalf("decode failed: %v", err)
+			}
+		}
+		return resp.StatusCode
+	}
+	type pull struct {
+		Changes []syncRecord `json:"changes"`
+		Token   string       `json:"token"`
+		More    bool         `json:"more"`
+	}
+	type push struct {
+		Results []syncResult `json:"results"`
+	}
+	qso := func(call string) map[string]any {
+		return map[string]any{"call": call, "band": "20m", "mode": "FT8", "freq": "14.074", "qso_date": "20240501",
+			"time_on": "1200", "time_off": "1201", "rst_sent": "-10", "rst_rcvd": "-12", "station_callsign": "K1AB", "session_id": 1}
+	}
+
+	// A first pull takes a snapshot of the logbook, one page at a time.
+	var page pull
+	if status := send(fiber.MethodGet, "/sync/pull?limit=1", nil, &page); status != fiber.StatusOK || len(page.Changes) != 1 || !page.More {
+		t.Fatalf("expected a full first page, got %d %+v", status, page)
+	}
+	snapshot := page.Changes[0]
+	if snapshot.ID != serverQso || snapshot.UUID == emptyString || snapshot.Qso == nil || snapshot.Qso.Call != "JA1XX" {
+		t.Fatalf("expected the server's QSO with a UUID, got %+v", snapshot)
+	}
+	if status := send(fiber.MethodGet, "/sync/pull?limit=1&token="+page.Token, nil, &page); status != fiber.StatusOK || len(page.Changes) != 0 || page.More {
+		t.Fatalf("expected the snapshot to end, got %d %+v", status, page)
+	}
+	token := page.Token
+
+	// The client logs a QSO offline and pushes it, then retries over a flaky link.
+	const clientUUID = "7f1c3c0e-8a55-4a55-9d7e-1f1a2b3c4d5e"
+	change := map[string]any{"uuid": clientUUID, "op": "upsert", "qso": qso("DL1ABC")}
+	var pushed push
+	if status := send(fiber.MethodPost, "/sync/push", map[string]any{"changes": []any{change}}, &pushed); status != fiber.StatusOK {
+		t.Fatalf("expected the push to succeed, got %d", status)
+	}
+	inserted := pushed.Results[0]
+	if inserted.Status != syncApplied || inserted.ID == 0 || inserted.Version == 0 {
+		t.Fatalf("expected the QSO to be applied, got %+v", inserted)
+	}
+	// The retry finds its own insert and is handed the server's copy instead.
+	send(fiber.MethodPost, "/sync/push", map[string]any{"changes": []any{change}}, &pushed)
+	if result := pushed.Results[0]; result.Status != syncConflict || result.Server == nil || result.Server.ID != inserted.ID {
+		t.Fatalf("expected the retry to conflict with the insert, got %+v", result)
+	}
+	if ids, _ := svc.listLogbookQsoIDPage(ctx, 1, serverQso, 10); len(ids) != 1 || ids[0] != inserted.ID {
+		t.Errorf("expected a second push to update rather than duplicate, got QSOs %v", ids)
+	}
+
+	// The server's copy changed since the client last saw it, and later than the client's edit.
+	stale := map[string]any{"uuid": snapshot.UUID, "op": "upsert", "version": snapshot.Version,
+		"modified_at": time.Now().Add(-time.Hour).UTC().Format(time.RFC3339), "qso": qso("JA1XY")}
+	if _, err = svc.setQsoDeleted(ctx, 1, serverQso, true, "test"); err != nil {
+		t.Fatalf("setQsoDeleted failed: %v", err)
+	}
+	send(fiber.MethodPost, "/sync/push", map[string]any{"changes": []any{stale}}, &pushed)
+	if result := pushed.Results[0]; result.Status != syncConflict || result.Server == nil || !result.Server.Deleted {
+		t.Fatalf("expected the server's delete to win, got %+v", result)
+	}
+
+	// A change based on the current version applies, restoring the QSO.
+	stale["version"] = pushed.Results[0].Version
+	send(fiber.MethodPost, "/sync/push", map[string]any{"changes": []any{stale}}, &pushed)
+	if result := pushed.Results[0]; result.Status != syncApplied {
+		t.Fatalf("expected the change to apply, got %+v", result)
+	}
+	restored, err := svc.db.FetchQsoByIdContext(ctx, serverQso)
+	if err != nil || restored.Call != "JA1XY" {
+		t.Fatalf("expected the QSO to be restored and updated, got %+v %v", restored, err)
+	}
+
+	rejected := map[string]any{"uuid": "0b9c7d3e-1111-4222-8333-944455556666", "op": "upsert", "qso": map[string]any{"call": "G4XYZ", "station_callsign": "W1AW"}}
+	send(fiber.MethodPost, "/sync/push", map[string]any{"changes": []any{rejected}}, &pushed)
+	if result := pushed.Results[0]; result.Status != syncRejected || result.Error == emptyString {
+		t.Errorf("expected a QSO for another station to be rejected, got %+v", result)
+	}
+
+	// Pulling from the token returns each changed QSO once, latest state only.
+	if status := send(fiber.MethodGet, "/sync/pull?token="+token, nil, &page); status != fiber.StatusOK || len(page.Changes) != 2 || page.More {
+		t.Fatalf("expected the two changed QSOs, got %d %+v", status, page)
+	}
+	if page.Changes[0].UUID != clientUUID || page.Changes[1].ID != serverQso || page.Changes[1].Deleted || page.Changes[1].Qso.Call != "JA1XY" {
+		t.Errorf("unexpected changes %+v", page.Changes)
+	}
+	if send(fiber.MethodGet, "/sync/pull?token="+page.Token, nil, &page); len(page.Changes) != 0 {
+		t.Errorf("expected no further changes, got %+v", page.Changes)
+	}
+
+	if status := send(fiber.MethodGet, "/sync/pull?token=Zm9vOjE", nil, nil); status != fiber.StatusBadRequest {
+		t.Errorf("expected an invalid token to be rejected, got %d", status)
+	}
+}
@@ -0,0 +1,102 @@
+package service
+
+import (
+	"context"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Station-Manager/errors"
+)
+
+const (
+	// envNotifySocket names the socket on which systemd receives the state of a Type=notify service.
+	envNotifySocket = "NOTIFY_SOCKET"
+	// envWatchdogUsec is the watchdog timeout set by WatchdogSec=, in microseconds.
+	envWatchdogUsec = "WATCHDOG_USEC"
+	// envWatchdogPID is the process the watchdog is set for; other processes leave it alone.
+	envWatchdogPID = "WATCHDOG_PID"
+)
+
+// watchdogProbeTimeout bounds the liveness probe made before each watchdog keep-alive.
+const watchdogProbeTimeout = 5 * time.Second
+
+// sdNotify sends the state to systemd, as sd_notify(3) does. It does nothing unless the process
+// was started by systemd as a Type=notify service.
+func sdNotify(state string) error {
+	const op errors.Op = "server.sdNotify"
+
+	socket := os.Getenv(envNotifySocket)
+	if socket == emptyString {
+		return nil
+	}
+	// A leading '@' names a socket in the abstract namespace.
+	if strings.HasPrefix(socket, "@") {
+		socket = "\x00" + socket[1:]
+	}
+
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: socket, Net: "unixgram"})
+	if err != nil {
+		return errors.New(op).Err(err)
+	}
+	defer func() { _ = conn.Close() }()
+
+	if _, err = conn.Write([]byte(state)); err != nil {
+		return errors.New(op).Err(err)
+	}
+	return nil
+}
+
+// notifySystemd sends the state to systemd, logging rather than failing if it cannot be sent.
+func (s *Service) notifySystemd(state string) {
+	if err := sdNotify(state); err != nil {
+		s.logger.WarnWith().Err(err).Msg("Failed to notify systemd")
+	}
+}
+
+// watchdogInterval returns the watchdog timeout systemd set for this process, or zero when the
+// watchdog is off or set for another process.
+func watchdogInterval() time.Duration {
+	usec, err := strconv.ParseInt(os.Getenv(envWatchdogUsec), 10, 64)
+	if err != nil || usec <= 0 {
+		return 0
+	}
+	if pid := os.Getenv(envWatchdogPID); pid != emptyString && pid != strconv.Itoa(os.Getpid()) {
+		return 0
+	}
+	return time.Duration(usec) * time.Microsecond
+}
+
+// runWatchdog keeps the systemd watchdog, of the given timeout, from firing while the server
+// answers requests. Every half timeout it asks the server on this host for /livez and sends the
+// keep-alive only if it answered, so a server that stops serving is restarted by systemd. The
+// database being down does not stop the keep-alives; that is the health check's to report.
+func (s *Service) runWatchdog(ctx context.Context, interval time.Duration) {
+	probeTimeout := min(watchdogProbeTimeout, interval/2)
+
+	ticker := time.NewTicker(interval / 2)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		if s.handedOver.Load() {
+			// The new process has taken over the watchdog.
+			return
+		}
+
+		probeCtx, cancel := context.WithTimeout(ctx, probeTimeout)
+		_, err := s.probeLocal(probeCtx, "/livez")
+		cancel()
+		if err != nil {
+			s.logger.WarnWith().Err(err).Msg("Server did not answer the watchdog probe; withholding the keep-alive")
+			continue
+		}
+		s.notifySystemd("WATCHDOG=1")
+	}
+}
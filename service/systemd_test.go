@@ -0,0 +1,108 @@
+//go:build linux || darwin
+
+package service
+
+import (
+	"context"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// listenNotifySocket stands in for systemd's notification socket.
+func listenNotifySocket(t *testing.T) *net.UnixConn {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "notify.sock")
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: path, Net: "unixgram"})
+	if err != nil {
+		t.Fatalf("ListenUnixgram failed: %v", err)
+	}
+	t.Cleanup(func() { _ = conn.Close() })
+	t.Setenv(envNotifySocket, path)
+	return conn
+}
+
+func readNotification(t *testing.T, conn *net.UnixConn, timeout time.Duration) string {
+	t.Helper()
+
+	_ = conn.SetReadDeadline(time.Now().Add(timeout))
+	buf := make([]byte, 256)
+	n, err := conn.Read(buf)
+	if err != nil {
+		t.Fatalf("no notification received: %v", err)
+	}
+	return string(buf[:n])
+}
+
+func TestSdNotify(t *testing.T) {
+	conn := listenNotifySocket(t)
+
+	if err := sdNotify("READY=1"); err != nil {
+		t.Fatalf("sdNotify failed: %v", err)
+	}
+	if got := readNotification(t, conn, time.Second); got != "READY=1" {
+		t.Fatalf("expected READY=1, got %q", got)
+	}
+}
+
+func TestSdNotify_NotUnderSystemd(t *testing.T) {
+	t.Setenv(envNotifySocket, "")
+
+	if err := sdNotify("READY=1"); err != nil {
+		t.Fatalf("expected no error without a notification socket, got %v", err)
+	}
+}
+
+func TestWatchdogInterval(t *testing.T) {
+	pid := strconv.Itoa(os.Getpid())
+	cases := []struct {
+		name string
+		usec string
+		pid  string
+		want time.Duration
+	}{
+		{"off", "", "", 0},
+		{"invalid", "soon", "", 0},
+		{"this process", "30000000", pid, 30 * time.Second},
+		{"no pid", "2000000", "", 2 * time.Second},
+		{"another process", "30000000", "1", 0},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Setenv(envWatchdogUsec, tc.usec)
+			t.Setenv(envWatchdogPID, tc.pid)
+			if got := watchdogInterval(); got != tc.want {
+				t.Fatalf("expected %v, got %v", tc.want, got)
+			}
+		})
+	}
+}
+
+func TestRunWatchdog_SendsKeepAlivesWhileServing(t *testing.T) {
+	conn := listenNotifySocket(t)
+
+	svc := &Service{app: fiber.New(fiber.Config{DisableStartupMessage: true})}
+	svc.app.Get("/livez", svc.livenessHandler)
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen failed: %v", err)
+	}
+	go func() { _ = svc.app.Listener(ln) }()
+	t.Cleanup(func() { _ = svc.app.Shutdown() })
+	svc.config.Host = "127.0.0.1"
+	svc.config.Port = ln.Addr().(*net.TCPAddr).Port
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go svc.runWatchdog(ctx, 200*time.Millisecond)
+
+	if got := readNotification(t, conn, 5*time.Second); got != "WATCHDOG=1" {
+		t.Fatalf("expected WATCHDOG=1, got %q", got)
+	}
+}
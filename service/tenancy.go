@@ -0,0 +1,117 @@
+package service
+
+import (
+	"context"
+
+	"github.com/Station-Manager/errors"
+	"github.com/gofiber/fiber/v2"
+)
+
+// Each logbook is a tenant: API key routes act for the logbook the key was issued for, and
+// password routes for the logbooks the user owns. Handlers scope their queries to the
+// authenticated tenant; the middleware here also checks the logbook or QSO named in the path
+// against it before the handler runs, so that a handler missing its own check cannot reach
+// another tenant's data. Refused requests are answered as if the target did not exist, and logged.
+
+const (
+	tenantTargetLogbook = "logbook"
+	tenantTargetQso     = "qso"
+)
+
+// qsoTenant returns the ID of the logbook holding the QSO, including QSOs in the trash. It reports
+// false if there is no such QSO.
+func qsoTenant(ctx context.Context, q queryer, qsoID int64) (int64, bool, error) {
+	const op errors.Op = "server.qsoTenant"
+
+	rows, err := q.QueryContext(ctx, `SELECT logbook_id FROM qso WHERE id = $1`, qsoID)
+	if err != nil {
+		return 0, false, errors.New(op).Err(err)
+	}
+	defer func() { _ = rows.Close() }()
+
+	var logbookID int64
+	found := rows.Next()
+	if found {
+		if err = rows.Scan(&logbookID); err != nil {
+			return 0, false, errors.New(op).Err(err)
+		}
+	}
+	if err = rows.Err(); err != nil {
+		return 0, false, errors.New(op).Err(err)
+	}
+	return logbookID, found, nil
+}
+
+// refuseCrossTenant logs a request for another tenant's logbook or QSO and answers it as if the
+// target did not exist.
+func (s *Service) refuseCrossTenant(c *fiber.Ctx, target string, id int64) error {
+	s.logger.WarnWith().Str("method", c.Method()).Str("path", c.Path()).Str("actor", actorOf(c)).
+		Str("target", target).Int64("target_id", id).Msg("Cross-tenant access refused")
+	if target == tenantTargetLogbook {
+		return c.Status(fiber.StatusNotFound).JSON(jsonError(errCodeLogbookNotFound, "Logbook not found"))
+	}
+	return c.Status(fiber.StatusNotFound).JSON(jsonError(errCodeQsoNotFound, "QSO not found"))
+}
+
+// tenantQsoMiddleware refuses API key requests naming a QSO of another logbook in the "id" path
+// parameter. References to no QSO are left to the handler.
+func (s *Service) tenantQsoMiddleware() fiber.Handler {
+	const op errors.Op = "server.Service.tenantQsoMiddleware"
+
+	return func(c *fiber.Ctx) error {
+		logbook, err := authenticatedLogbook(c)
+		if err != nil {
+			s.logger.ErrorWith().Err(errors.New(op).Err(err)).Msg("authenticatedLogbook failed")
+			return c.Status(fiber.StatusInternalServerError).JSON(jsonInternalError)
+		}
+		id, ok, err := s.resolveQsoRef(c.UserContext(), logbook.ID, c.Params("id"))
+		if err != nil {
+			s.logger.ErrorWith().Err(errors.New(op).Err(err)).Msg("s.resolveQsoRef failed")
+			return s.dbFailure(c, err)
+		}
+		if !ok || id == 0 {
+			return c.Next()
+		}
+		tenant, found, err := qsoTenant(c.UserContext(), s.db, id)
+		if err != nil {
+			s.logger.ErrorWith().Err(errors.New(op).Err(err)).Msg("qsoTenant failed")
+			return s.dbFailure(c, err)
+		}
+		if found && tenant != logbook.ID {
+			return s.refuseCrossTenant(c, tenantTargetQso, id)
+		}
+		return c.Next()
+	}
+}
+
+// tenantLogbookMiddleware refuses password requests naming a logbook the user does not own in the
+// "id" path parameter. References to no logbook are left to the handler.
+func (s *Service) tenantLogbookMiddleware() fiber.Handler {
+	const op errors.Op = "server.Service.tenantLogbookMiddleware"
+
+	return func(c *fiber.Ctx) error {
+		reqCtx, err := getRequestContext(c)
+		if err != nil || reqCtx.User == nil {
+			err = errors.New(op).Err(err).Msg("Request context missing")
+			s.logger.ErrorWith().Err(err).Msg("getRequestContext failed")
+			return c.Status(fiber.StatusInternalServerError).JSON(jsonInternalError)
+		}
+		id, ok, err := s.resolveLogbookRef(c.UserContext(), c.Params("id"))
+		if err != nil {
+			s.logger.ErrorWith().Err(errors.New(op).Err(err)).Msg("s.resolveLogbookRef failed")
+			return s.dbFailure(c, err)
+		}
+		if !ok || id == 0 {
+			return c.Next()
+		}
+		owner, err := logbookOwner(c.UserContext(), s.db, id)
+		if err != nil {
+			s.logger.ErrorWith().Err(errors.New(op).Err(err)).Msg("logbookOwner failed")
+			return s.dbFailure(c, err)
+		}
+		if owner != 0 && owner != reqCtx.User.ID {
+			return s.refuseCrossTenant(c, tenantTargetLogbook, id)
+		}
+		return c.Next()
+	}
+}
@@ -0,0 +1,233 @@
+package service
+
+import (
+	"context"
+	"encoding/base64"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/Station-Manager/apikey"
+	"github.com/Station-Manager/types"
+	"github.com/gofiber/fiber/v2"
+)
+
+// tenancyFixture holds two tenants: the victim's logbook 1, owned by W1AW, with a QSO and one of
+// each resource addressed by ID, and the attacker's logbook 2, owned by K1AB.
+type tenancyFixture struct {
+	svc         *Service
+	attackerKey string
+	victimKey   string
+	victimQso   int64
+	resourceIDs map[string]int64
+}
+
+func newTenancyFixture(t *testing.T) *tenancyFixture {
+	t.Helper()
+	svc := newTestServer(t)
+	ctx := context.Background()
+	hash, err := apikey.HashPassword("secret")
+	if err != nil {
+		t.Fatalf("HashPassword failed: %v", err)
+	}
+	if _, err = svc.db.ExecContext(ctx, `INSERT INTO users (id, callsign, pass_hash, email_confirmed) VALUES (7, 'W1AW', $1, TRUE), (8, 'K1AB', $1, TRUE)`, hash); err != nil {
+		t.Fatalf("setup failed: %v", err)
+	}
+	for _, query := range []string{
+		`UPDATE logbook SET user_id = 7, callsign = 'W1AW' WHERE id = 1`,
+		`INSERT INTO logbook (id, name, callsign, user_id) VALUES (2, 'Attacker', 'K1AB', 8)`,
+	} {
+		if _, err = svc.db.ExecContext(ctx, query); err != nil {
+			t.Fatalf("setup failed: %v", err)
+		}
+	}
+
+	f := &tenancyFixture{svc: svc, resourceIDs: make(map[string]int64)}
+	if f.victimKey, err = svc.issueMemberApiKey(ctx, 1, 7, "W1AW"); err != nil {
+		t.Fatalf("issueMemberApiKey failed: %v", err)
+	}
+	if f.attackerKey, err = svc.issueMemberApiKey(ctx, 2, 8, "K1AB"); err != nil {
+		t.Fatalf("issueMemberApiKey failed: %v", err)
+	}
+	f.victimQso = insertTestQso(t, svc, "JA1VIC", "20m", "CW", "20240101", "1200")
+
+	for resource, query := range map[string]string{
+		"webhooks":   `INSERT INTO webhooks (logbook_id, url, secret) VALUES (1, 'https://example.org/hook', 'secret')`,
+		"shares":     `INSERT INTO share_links (logbook_id, token_hash) VALUES (1, 'hash')`,
+		"profiles":   `INSERT INTO station_profiles (logbook_id, name) VALUES (1, 'Home')`,
+		"jobs":       `INSERT INTO jobs (kind, logbook_id, max_attempts) VALUES ('test', 1, 1)`,
+		"qsl-images": `INSERT INTO qsl_images (logbook_id, qso_id, side, content_type, size, width, height, object_name, thumbnail_name) VALUES (1, ` + strconv.FormatInt(f.victimQso, 10) + `, 'front', 'image/png', 1, 1, 1, 'a', 'b')`,
+	} {
+		res, err := svc.db.ExecContext(ctx, query)
+		if err != nil {
+			t.Fatalf("setup of %s failed: %v", resource, err)
+		}
+		f.resourceIDs[resource], _ = res.LastInsertId()
+	}
+	return f
+}
+
+// pathFor fills the route's parameters with the victim's resources. It reports whether the path
+// names one of them.
+func (f *tenancyFixture) pathFor(route string) (string, bool) {
+	id := func(resource string) string { return strconv.FormatInt(f.resourceIDs[resource], 10) }
+	qso := strconv.FormatInt(f.victimQso, 10)
+	replacements := []struct{ prefix, param, value string }{
+		{"/api/v2/logbooks/", ":id", "1"},
+		{"/api/v2/qsos/", ":id", qso},
+		{"/qsos/", ":id", qso},
+		{"/webhooks/", ":id", id("webhooks")},
+		{"/shares/", ":id", id("shares")},
+		{"/profiles/", ":id", id("profiles")},
+		{"/jobs/", ":id", id("jobs")},
+		{"/inserts/", ":id", "1"},
+		{"/awards/", ":award", "dxcc"},
+		{emptyString, ":image", id("qsl-images")},
+		{emptyString, ":prefix", f.victimKey[:prefixLen]},
+	}
+	path, named := route, false
+	for _, r := range replacements {
+		if strings.HasPrefix(route, r.prefix) && strings.Contains(path, r.param) {
+			path = strings.Replace(path, r.param, r.value, 1)
+			named = named || (r.param != ":award" && r.prefix != "/inserts/")
+		}
+	}
+	return path, named
+}
+
+// request builds the attacker's request for the route, authenticated as the route expects and
+// naming the victim's logbook and QSO wherever a body can.
+func (f *tenancyFixture) request(method, route, path string) *http.Request {
+	envelope := `{"callsign":"K1AB","key":"secret","logbook":{"id":1,"name":"Victim","callsign":"W1AW"},"user_id":7,"callsign_member":"K1AB"}`
+	switch {
+	case strings.HasPrefix(route, "/api/qso/") || route == "/api/v1":
+		envelope = `{"callsign":"K1AB","key":"` + f.attackerKey + `","action":"insert_qso","logbook":{"id":1},"qso":{"call":"JA2XX","band":"20m","mode":"CW","freq":"14.025","qso_date":"20240101","time_on":"1300","time_off":"1301","rst_sent":"599","rst_rcvd":"599","station_callsign":"K1AB","session_id":1,"logbook_id":1}}`
+		fallthrough
+	case strings.HasPrefix(route, "/api/") && !strings.HasPrefix(route, "/api/v2/"):
+		req := httptest.NewRequest(method, path, strings.NewReader(envelope))
+		req.Header.Set(fiber.HeaderContentType, fiber.MIMEApplicationJSON)
+		return req
+	}
+
+	body := `{"qso_ids":[` + strconv.FormatInt(f.victimQso, 10) + `],"via":"bureau","logbook_id":1}`
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	req.Header.Set(fiber.HeaderContentType, fiber.MIMEApplicationJSON)
+	if strings.HasPrefix(route, "/api/v2/logbooks") && !strings.Contains(route, "/qsos") || strings.HasPrefix(route, "/api/v2/account") {
+		req.Header.Set(fiber.HeaderAuthorization, "Basic "+base64.StdEncoding.EncodeToString([]byte("K1AB:secret")))
+	} else {
+		req.Header.Set(fiber.HeaderAuthorization, "Bearer "+f.attackerKey)
+	}
+	return req
+}
+
+// assertVictimIntact fails the test if any of the victim's data was changed.
+func (f *tenancyFixture) assertVictimIntact(t *testing.T) {
+	t.Helper()
+	ctx := context.Background()
+	qso, err := f.svc.db.FetchQsoByIdContext(ctx, f.victimQso)
+	if err != nil || qso.LogbookID != 1 || qso.QslSent != emptyString || qso.QslRcvd != emptyString {
+		t.Errorf("expected the victim's QSO to be untouched, got %+v (err=%v)", qso, err)
+	}
+	for _, check := range []string{
+		`SELECT COUNT(*) FROM qso WHERE logbook_id = 1 AND deleted_at IS NULL`,
+		`SELECT COUNT(*) FROM logbook WHERE id = 1 AND deleted_at IS NULL`,
+		`SELECT COUNT(*) FROM webhooks WHERE logbook_id = 1`,
+		`SELECT COUNT(*) FROM share_links WHERE logbook_id = 1 AND revoked_at IS NULL`,
+		`SELECT COUNT(*) FROM station_profiles WHERE logbook_id = 1`,
+		`SELECT COUNT(*) FROM qsl_images WHERE logbook_id = 1`,
+		`SELECT COUNT(*) FROM api_keys WHERE logbook_id = 1 AND revoked_at IS NULL`,
+	} {
+		var count int
+		rows, err := f.svc.db.QueryContext(ctx, check)
+		if err != nil {
+			t.Fatalf("%s failed: %v", check, err)
+		}
+		if rows.Next() {
+			_ = rows.Scan(&count)
+		}
+		_ = rows.Close()
+		if count != 1 {
+			t.Errorf("expected the victim's data to be intact, %s gave %d", check, count)
+		}
+	}
+}
+
+// TestTenancy_CrossTenantAccessRefused replays every tenant route as the attacker, naming the
+// victim's logbook, QSO and resources, and checks that nothing of the victim's is revealed or
+// changed.
+func TestTenancy_CrossTenantAccessRefused(t *testing.T) {
+	f := newTenancyFixture(t)
+
+	tried := 0
+	for _, route := range f.svc.app.GetRoutes(true) {
+		switch {
+		case route.Method != fiber.MethodGet && route.Method != fiber.MethodPost && route.Method != fiber.MethodPut && route.Method != fiber.MethodDelete:
+			continue
+		case route.Path == "/" || route.Path == "/health" || route.Path == "/livez" || route.Path == "/readyz":
+			// Public.
+			continue
+		case strings.HasPrefix(route.Path, "/admin") || strings.HasPrefix(route.Path, "/share/"):
+			// Authenticated by an admin token or a share link, which name no tenant.
+			continue
+		case strings.HasSuffix(route.Path, "/ws") || strings.HasSuffix(route.Path, "/sse"):
+			// Streams never end; their authentication is covered by stream_test.go.
+			continue
+		}
+		tried++
+
+		path, named := f.pathFor(route.Path)
+		resp, err := f.svc.app.Test(f.request(route.Method, route.Path, path), -1)
+		if err != nil {
+			t.Fatalf("%s %s failed: %v", route.Method, path, err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		if named && resp.StatusCode < fiber.StatusBadRequest {
+			t.Errorf("%s %s: expected the victim's resource to be refused, got %d %s", route.Method, path, resp.StatusCode, body)
+		}
+		if strings.Contains(string(body), "JA1VIC") || strings.Contains(string(body), "W1AW") {
+			t.Errorf("%s %s: the response reveals the victim's data: %s", route.Method, path, body)
+		}
+	}
+	if tried < 50 {
+		t.Errorf("expected every tenant route to be tried, only %d were", tried)
+	}
+	f.assertVictimIntact(t)
+}
+
+// TestTenancy_MiddlewareRefusesOnItsOwn checks the tenancy layer without the handlers' own checks.
+func TestTenancy_MiddlewareRefusesOnItsOwn(t *testing.T) {
+	f := newTenancyFixture(t)
+	own := insertTestQso(t, f.svc, "JA3XX", "20m", "CW", "20240101", "1300")
+	if _, err := f.svc.db.ExecContext(context.Background(), `UPDATE qso SET logbook_id = 2 WHERE id = $1`, own); err != nil {
+		t.Fatalf("setup failed: %v", err)
+	}
+
+	app := fiber.New()
+	reached := func(c *fiber.Ctx) error { return c.SendStatus(fiber.StatusOK) }
+	asAttacker := func(c *fiber.Ctx) error {
+		setRequestContext(c, &requestContext{Logbook: &types.Logbook{ID: 2}, User: &types.User{ID: 8}, IsValid: true})
+		return c.Next()
+	}
+	app.Get("/qsos/:id", asAttacker, f.svc.tenantQsoMiddleware(), reached)
+	app.Delete("/logbooks/:id", asAttacker, f.svc.tenantLogbookMiddleware(), reached)
+
+	for path, want := range map[string]int{
+		"/qsos/" + strconv.FormatInt(f.victimQso, 10): fiber.StatusNotFound,
+		"/qsos/" + strconv.FormatInt(own, 10):         fiber.StatusOK,
+		"/qsos/999":                                   fiber.StatusOK,
+	} {
+		resp, err := app.Test(httptest.NewRequest(fiber.MethodGet, path, nil))
+		if err != nil || resp.StatusCode != want {
+			t.Errorf("GET %s: expected %d, got %v (err=%v)", path, want, resp.StatusCode, err)
+		}
+	}
+	for path, want := range map[string]int{"/logbooks/1": fiber.StatusNotFound, "/logbooks/2": fiber.StatusOK} {
+		resp, err := app.Test(httptest.NewRequest(fiber.MethodDelete, path, nil))
+		if err != nil || resp.StatusCode != want {
+			t.Errorf("DELETE %s: expected %d, got %v (err=%v)", path, want, resp.StatusCode, err)
+		}
+	}
+}
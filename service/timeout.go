@@ -0,0 +1,108 @@
+package service
+
+import (
+	"context"
+	stderr "errors"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/Station-Manager/errors"
+	"github.com/gofiber/fiber/v2"
+)
+
+// envRequestTimeout names the environment variable holding the default time a request may take, as
+// a Go duration (e.g. "10s"); "0" disables the timeout.
+const envRequestTimeout = "SM_REQUEST_TIMEOUT"
+
+// envRequestTimeouts names the environment variable holding per-route overrides of the request
+// timeout, as comma-separated path=duration pairs (e.g. "/api/logbook/register=30s").
+const envRequestTimeouts = "SM_REQUEST_TIMEOUTS"
+
+const defaultRequestTimeout = 10 * time.Second
+
+// defaultLongRequestTimeout is the time allowed for the requests that work through a whole log in
+// one go: an ADIF import, which may hold many thousands of records, rebuilding a logbook's award
+// credits and a sync push of everything a client logged offline.
+const defaultLongRequestTimeout = 2 * time.Minute
+
+// longRequestRoutes are the routes allowed defaultLongRequestTimeout unless overridden.
+var longRequestRoutes = []string{"/qsos/import", "/awards/rebuild", "/sync/push"}
+
+// requestTimeouts holds the time allowed for each route.
+type requestTimeouts struct {
+	fallback time.Duration
+	routes   map[string]time.Duration
+}
+
+// forPath returns the timeout for the request path; zero means none.
+func (t *requestTimeouts) forPath(path string) time.Duration {
+	if d, ok := t.routes[path]; ok {
+		return d
+	}
+	return t.fallback
+}
+
+// loadRequestTimeouts reads the request timeouts from the environment. Invalid values are an error
+// so that a typo does not silently leave requests unbounded.
+func loadRequestTimeouts() (*requestTimeouts, error) {
+	const op errors.Op = "server.loadRequestTimeouts"
+
+	timeouts := &requestTimeouts{fallback: defaultRequestTimeout, routes: make(map[string]time.Duration, len(longRequestRoutes))}
+	for _, path := range longRequestRoutes {
+		timeouts.routes[path] = defaultLongRequestTimeout
+	}
+
+	if value := strings.TrimSpace(os.Getenv(envRequestTimeout)); value != emptyString {
+		d, err := time.ParseDuration(value)
+		if err != nil || d < 0 {
+			return nil, errors.New(op).Msg(envRequestTimeout + " must be a non-negative duration")
+		}
+		timeouts.fallback = d
+	}
+
+	for _, pair := range strings.Split(os.Getenv(envRequestTimeouts), ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == emptyString {
+			continue
+		}
+		path, value, ok := strings.Cut(pair, "=")
+		d, err := time.ParseDuration(strings.TrimSpace(value))
+		if !ok || !strings.HasPrefix(path, "/") || err != nil || d < 0 {
+			return nil, errors.New(op).Msg(envRequestTimeouts + " must be a list of path=duration pairs")
+		}
+		timeouts.routes[strings.TrimSpace(path)] = d
+	}
+
+	return timeouts, nil
+}
+
+// requestTimeoutMiddleware bounds the time a request may take by cancelling c.UserContext() at its
+// deadline. Handlers give up when the context is cancelled, since the database and outbound calls
+// honour it, which returns their pooled connections rather than holding them for a client that
+// has stopped waiting; if that leaves the request failed, the response is replaced with a 503 so
+// the client knows it may retry. A response that completed successfully is never replaced. Event
+// streams and WebSockets are unaffected: they run after their handler has returned.
+func (s *Service) requestTimeoutMiddleware() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		timeout := time.Duration(0)
+		if s.requestTimeouts != nil {
+			timeout = s.requestTimeouts.forPath(c.Path())
+		}
+		if timeout <= 0 {
+			return c.Next()
+		}
+
+		ctx, cancel := context.WithTimeout(c.UserContext(), timeout)
+		defer cancel()
+		c.SetUserContext(ctx)
+
+		err := c.Next()
+		if !stderr.Is(ctx.Err(), context.DeadlineExceeded) || (err == nil && c.Response().StatusCode() < fiber.StatusInternalServerError) {
+			return err
+		}
+
+		s.logger.WarnWith().Str("path", c.Path()).Str("timeout", timeout.String()).Msg("Request timed out")
+		return c.Status(fiber.StatusServiceUnavailable).JSON(jsonError(errCodeTimeout, "Request timed out"))
+	}
+}
@@ -0,0 +1,112 @@
+package service
+
+import (
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+func TestLoadRequestTimeouts(t *testing.T) {
+	t.Setenv(envRequestTimeout, "2s")
+	t.Setenv(envRequestTimeouts, "/api/logbook/register=30s, /api/qso/insert=0")
+
+	timeouts, err := loadRequestTimeouts()
+	if err != nil {
+		t.Fatalf("loadRequestTimeouts failed: %v", err)
+	}
+	if got := timeouts.forPath("/api/other"); got != 2*time.Second {
+		t.Errorf("expected the default timeout, got %v", got)
+	}
+	if got := timeouts.forPath("/api/logbook/register"); got != 30*time.Second {
+		t.Errorf("expected the route override, got %v", got)
+	}
+	if got := timeouts.forPath("/api/qso/insert"); got != 0 {
+		t.Errorf("expected the timeout to be disabled, got %v", got)
+	}
+	for _, path := range []string{"/qsos/import", "/awards/rebuild", "/sync/push"} {
+		if got := timeouts.forPath(path); got != defaultLongRequestTimeout {
+			t.Errorf("%s: expected to be allowed longer, got %v", path, got)
+		}
+	}
+
+	for _, bad := range []string{"api=1s", "/api/x", "/api/x=soon"} {
+		t.Setenv(envRequestTimeouts, bad)
+		if _, err = loadRequestTimeouts(); err == nil {
+			t.Errorf("expected %q to be rejected", bad)
+		}
+	}
+}
+
+func TestRequestTimeoutMiddleware(t *testing.T) {
+	svc := newTestServer(t)
+	svc.requestTimeouts = &requestTimeouts{fallback: 20 * time.Millisecond, routes: map[string]time.Duration{"/api/late": 20 * time.Millisecond}}
+
+	app := fiber.New()
+	app.Use(svc.requestTimeoutMiddleware())
+	app.Get("/qsos/hung", func(c *fiber.Ctx) error {
+		<-c.UserContext().Done()
+		return c.Status(fiber.StatusInternalServerError).JSON(jsonInternalError)
+	})
+	api := app.Group("/api")
+	api.Get("/hung", func(c *fiber.Ctx) error {
+		<-c.UserContext().Done()
+		return c.Status(fiber.StatusInternalServerError).JSON(jsonInternalError)
+	})
+	api.Get("/late", func(c *fiber.Ctx) error {
+		<-c.UserContext().Done()
+		return c.SendStatus(fiber.StatusCreated)
+	})
+	api.Get("/fast", func(c *fiber.Ctx) error {
+		return c.SendStatus(fiber.StatusNoContent)
+	})
+
+	for path, want := range map[string]int{
+		"/api/hung":  fiber.StatusServiceUnavailable,
+		"/qsos/hung": fiber.StatusServiceUnavailable,
+		// A handler that completed is not overridden, even past the deadline.
+		"/api/late": fiber.StatusCreated,
+		"/api/fast": fiber.StatusNoContent,
+	} {
+		resp, err := app.Test(httptest.NewRequest("GET", path, nil))
+		if err != nil || resp.StatusCode != want {
+			t.Errorf("%s: expected %d, got %v (err=%v)", path, want, resp.StatusCode, err)
+		}
+	}
+}
+
+func TestRequestTimeoutMiddleware_LongRoutes(t *testing.T) {
+	svc := newTestServer(t)
+	timeouts, err := loadRequestTimeouts()
+	if err != nil {
+		t.Fatalf("loadRequestTimeouts failed: %v", err)
+	}
+	timeouts.fallback = 20 * time.Millisecond
+	svc.requestTimeouts = timeouts
+
+	app := fiber.New()
+	app.Use(svc.requestTimeoutMiddleware())
+	slow := func(c *fiber.Ctx) error {
+		select {
+		case <-c.UserContext().Done():
+			return c.Status(fiber.StatusInternalServerError).JSON(jsonInternalError)
+		case <-time.After(60 * time.Millisecond):
+			return c.SendStatus(fiber.StatusNoContent)
+		}
+	}
+	app.Post("/awards/rebuild", slow)
+	app.Post("/sync/push", slow)
+	app.Post("/stats", slow)
+
+	for path, want := range map[string]int{
+		"/awards/rebuild": fiber.StatusNoContent,
+		"/sync/push":      fiber.StatusNoContent,
+		"/stats":          fiber.StatusServiceUnavailable,
+	} {
+		resp, err := app.Test(httptest.NewRequest(fiber.MethodPost, path, nil))
+		if err != nil || resp.StatusCode != want {
+			t.Errorf("%s: expected %d, got %v (err=%v)", path, want, resp.StatusCode, err)
+		}
+	}
+}
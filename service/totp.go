@@ -0,0 +1,83 @@
+package service
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// The TOTP parameters are the RFC 6238 defaults that every authenticator app supports.
+const (
+	totpDigits      = 6
+	totpModulo      = 1_000_000 // 10^totpDigits
+	totpPeriod      = 30 * time.Second
+	totpSecretBytes = 20
+	// totpSkew is the number of periods either side of the current one whose codes are accepted,
+	// to allow for clock drift.
+	totpSkew = 1
+
+	totpIssuer = "Station Manager"
+)
+
+var totpEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// newTotpSecret returns a random base32-encoded TOTP secret.
+func newTotpSecret() (string, error) {
+	b := make([]byte, totpSecretBytes)
+	if _, err := rand.Read(b); err != nil {
+		return emptyString, err
+	}
+	return totpEncoding.EncodeToString(b), nil
+}
+
+// totpURI returns the otpauth URI that authenticator apps import, usually as a QR code.
+func totpURI(account, secret string) string {
+	label := url.PathEscape(totpIssuer + ":" + account)
+	query := url.Values{"secret": {secret}, "issuer": {totpIssuer}}
+	return "otpauth://totp/" + label + "?" + query.Encode()
+}
+
+// totpStep returns the TOTP time step that t falls in.
+func totpStep(t time.Time) int64 {
+	return t.Unix() / int64(totpPeriod/time.Second)
+}
+
+// totpCode returns the code for the key at the time step, as described in RFC 4226 section 5.3.
+func totpCode(key []byte, step int64) string {
+	var msg [8]byte
+	binary.BigEndian.PutUint64(msg[:], uint64(step))
+	mac := hmac.New(sha1.New, key)
+	mac.Write(msg[:])
+	sum := mac.Sum(nil)
+
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+	return fmt.Sprintf("%0*d", totpDigits, value%totpModulo)
+}
+
+// verifyTotp checks the code against the secret at now and returns the time step it belongs to.
+// Codes from steps up to lastStep are rejected, so that each code can only be used once.
+func verifyTotp(secret, code string, now time.Time, lastStep int64) (int64, bool) {
+	key, err := totpEncoding.DecodeString(strings.ToUpper(strings.TrimSpace(secret)))
+	if err != nil || len(code) != totpDigits {
+		return 0, false
+	}
+
+	current := totpStep(now)
+	for step := current - totpSkew; step <= current+totpSkew; step++ {
+		if step <= lastStep {
+			continue
+		}
+		if subtle.ConstantTimeCompare([]byte(totpCode(key, step)), []byte(code)) == 1 {
+			return step, true
+		}
+	}
+	return 0, false
+}
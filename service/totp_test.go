@@ -0,0 +1,26 @@
+package service
+
+import (
+	"testing"
+	"time"
+)
+
+func TestVerifyTotp_RFC6238(t *testing.T) {
+	// The SHA-1 test vector from RFC 6238 appendix B, truncated to six digits.
+	secret := totpEncoding.EncodeToString([]byte("12345678901234567890"))
+	now := time.Unix(59, 0)
+
+	step, ok := verifyTotp(secret, "287082", now, 0)
+	if !ok || step != 1 {
+		t.Fatalf("expected the test vector to verify at step 1, got %d %v", step, ok)
+	}
+	if _, ok = verifyTotp(secret, "287082", now, step); ok {
+		t.Error("expected a used code to be rejected")
+	}
+	if _, ok = verifyTotp(secret, "287083", now, 0); ok {
+		t.Error("expected a wrong code to be rejected")
+	}
+	if _, ok = verifyTotp(secret, "287082", now.Add(5*totpPeriod), 0); ok {
+		t.Error("expected an old code to be rejected")
+	}
+}
@@ -0,0 +1,475 @@
+package service
+
+import (
+	"context"
+	"database/sql"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/Station-Manager/errors"
+	"github.com/Station-Manager/types"
+	"github.com/gofiber/fiber/v2"
+)
+
+const (
+	// envTrashRetention names the environment variable holding how long deleted QSOs and logbooks
+	// stay in the trash before they are purged, as a Go duration. Zero keeps them forever.
+	envTrashRetention = "SM_TRASH_RETENTION"
+
+	defaultTrashRetention     = 30 * 24 * time.Hour
+	defaultTrashPurgeInterval = time.Hour
+
+	// trashListMax bounds the number of trashed QSOs returned by one request.
+	trashListMax = 500
+)
+
+// liveLogbookIDs selects the logbooks that are not in the trash, for filtering by logbook_id.
+const liveLogbookIDs = `(SELECT id FROM logbook WHERE deleted_at IS NULL)`
+
+// trashedQso is a QSO in the trash.
+type trashedQso struct {
+	ID        int64  `json:"id"`
+	UUID      string `json:"uuid"`
+	Call      string `json:"call"`
+	Band      string `json:"band"`
+	Mode      string `json:"mode"`
+	QsoDate   string `json:"qso_date"`
+	TimeOn    string `json:"time_on"`
+	DeletedAt string `json:"deleted_at"`
+}
+
+// trashedLogbook is a logbook in the trash.
+type trashedLogbook struct {
+	ID        int64  `json:"id"`
+	UUID      string `json:"uuid"`
+	Name      string `json:"name"`
+	Callsign  string `json:"callsign"`
+	DeletedAt string `json:"deleted_at"`
+}
+
+// loadTrashRetention reads the trash retention from the environment.
+func loadTrashRetention() (time.Duration, error) {
+	const op errors.Op = "server.loadTrashRetention"
+
+	value := strings.TrimSpace(os.Getenv(envTrashRetention))
+	if value == emptyString {
+		return defaultTrashRetention, nil
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil || d < 0 {
+		return 0, errors.New(op).Msg(envTrashRetention + " must be a non-negative duration")
+	}
+	return d, nil
+}
+
+// liveStore hides QSOs and logbooks that are in the trash from the store's lookups by ID.
+type liveStore struct {
+	store
+}
+
+func newLiveStore(db store) *liveStore {
+	return &liveStore{store: db}
+}
+
+func (l *liveStore) FetchLogbookByIDContext(ctx context.Context, id int64) (types.Logbook, error) {
+	const op errors.Op = "server.liveStore.FetchLogbookByIDContext"
+	if err := l.checkLive(ctx, `SELECT COUNT(*) FROM logbook WHERE id = $1 AND deleted_at IS NULL`, id); err != nil {
+		return types.Logbook{}, errors.New(op).Err(err)
+	}
+	return l.store.FetchLogbookByIDContext(ctx, id)
+}
+
+func (l *liveStore) FetchQsoByIdContext(ctx context.Context, id int64) (types.Qso, error) {
+	const op errors.Op = "server.liveStore.FetchQsoByIdContext"
+	if err := l.checkLive(ctx, `SELECT COUNT(*) FROM qso WHERE id = $1 AND deleted_at IS NULL`, id); err != nil {
+		return types.Qso{}, errors.New(op).Err(err)
+	}
+	return l.store.FetchQsoByIdContext(ctx, id)
+}
+
+// checkLive returns sql.ErrNoRows unless the count query finds the row.
+func (l *liveStore) checkLive(ctx context.Context, query string, id int64) error {
+	rows, err := l.store.QueryContext(ctx, query, id)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = rows.Close() }()
+
+	var n int
+	if rows.Next() {
+		if err = rows.Scan(&n); err != nil {
+			return err
+		}
+	}
+	if err = rows.Err(); err != nil {
+		return err
+	}
+	if n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// deleteQsoHandler moves a QSO of the authenticated logbook to the trash.
+func (s *Service) deleteQsoHandler(c *fiber.Ctx) error {
+	const op errors.Op = "server.Service.deleteQsoHandler"
+
+	logbook, err := authenticatedLogbook(c)
+	if err != nil {
+		s.logger.ErrorWith().Err(errors.New(op).Err(err)).Msg("authenticatedLogbook failed")
+		return c.Status(fiber.StatusInternalServerError).JSON(jsonInternalError)
+	}
+
+	id, ok, err := s.resolveQsoRef(c.UserContext(), logbook.ID, c.Params("id"))
+	if err != nil {
+		s.logger.ErrorWith().Err(errors.New(op).Err(err)).Msg("s.resolveQsoRef failed")
+		return s.dbFailure(c, err)
+	}
+	if !ok {
+		return c.Status(fiber.StatusBadRequest).JSON(jsonBadRequest)
+	}
+
+	ctx := c.UserContext()
+	qso, err := s.db.FetchQsoByIdContext(ctx, id)
+	if err != nil || qso.LogbookID != logbook.ID {
+		return c.Status(fiber.StatusNotFound).JSON(jsonError(errCodeQsoNotFound, "QSO not found"))
+	}
+
+	found, err := s.setQsoDeleted(ctx, logbook.ID, id, true, actorOf(c))
+	if err != nil {
+		s.logger.ErrorWith().Err(errors.New(op).Err(err)).Int64("qso_id", id).Msg("s.setQsoDeleted failed")
+		return s.dbFailure(c, err)
+	}
+	if !found {
+		return c.Status(fiber.StatusNotFound).JSON(jsonError(errCodeQsoNotFound, "QSO not found"))
+	}
+
+	s.publishQsoEvent(ctx, qsoEventDeleted, qso)
+	return c.SendStatus(fiber.StatusNoContent)
+}
+
+// restoreQsoHandler takes a QSO of the authenticated logbook out of the trash.
+func (s *Service) restoreQsoHandler(c *fiber.Ctx) error {
+	const op errors.Op = "server.Service.restoreQsoHandler"
+
+	logbook, err := authenticatedLogbook(c)
+	if err != nil {
+		s.logger.ErrorWith().Err(errors.New(op).Err(err)).Msg("authenticatedLogbook failed")
+		return c.Status(fiber.StatusInternalServerError).JSON(jsonInternalError)
+	}
+
+	id, ok, err := s.resolveQsoRef(c.UserContext(), logbook.ID, c.Params("id"))
+	if err != nil {
+		s.logger.ErrorWith().Err(errors.New(op).Err(err)).Msg("s.resolveQsoRef failed")
+		return s.dbFailure(c, err)
+	}
+	if !ok {
+		return c.Status(fiber.StatusBadRequest).JSON(jsonBadRequest)
+	}
+
+	ctx := c.UserContext()
+	found, err := s.setQsoDeleted(ctx, logbook.ID, id, false, actorOf(c))
+	if err != nil {
+		s.logger.ErrorWith().Err(errors.New(op).Err(err)).Int64("qso_id", id).Msg("s.setQsoDeleted failed")
+		return s.dbFailure(c, err)
+	}
+	if !found {
+		return c.Status(fiber.StatusNotFound).JSON(jsonError(errCodeQsoNotFound, "QSO not found in the trash"))
+	}
+
+	qso, err := s.db.FetchQsoByIdContext(ctx, id)
+	if err != nil {
+		s.logger.ErrorWith().Err(errors.New(op).Err(err)).Int64("qso_id", id).Msg("s.db.FetchQsoByIdContext failed")
+		return s.dbFailure(c, err)
+	}
+	s.publishQsoEvent(ctx, qsoEventRestored, qso)
+
+	public, err := s.publicQsoOf(ctx, qso)
+	if err != nil {
+		s.logger.ErrorWith().Err(errors.New(op).Err(err)).Msg("s.publicQsoOf failed")
+		return s.dbFailure(c, err)
+	}
+	return c.JSON(fiber.Map{"qso": public})
+}
+
+// listTrashedQsosHandler returns the QSOs of the authenticated logbook that are in the trash, most
+// recently deleted first.
+func (s *Service) listTrashedQsosHandler(c *fiber.Ctx) error {
+	const op errors.Op = "server.Service.listTrashedQsosHandler"
+
+	logbook, err := authenticatedLogbook(c)
+	if err != nil {
+		s.logger.ErrorWith().Err(errors.New(op).Err(err)).Msg("authenticatedLogbook failed")
+		return c.Status(fiber.StatusInternalServerError).JSON(jsonInternalError)
+	}
+
+	qsos, err := s.listTrashedQsos(c.UserContext(), logbook.ID, trashListMax)
+	if err != nil {
+		s.logger.ErrorWith().Err(errors.New(op).Err(err)).Msg("s.listTrashedQsos failed")
+		return s.dbFailure(c, err)
+	}
+	return c.JSON(fiber.Map{"qsos": qsos, "retention": s.trashRetention.String()})
+}
+
+// restoreLogbookHandler takes one of the authenticated user's logbooks out of the trash.
+func (s *Service) restoreLogbookHandler(c *fiber.Ctx) error {
+	return s.setLogbookDeletedHandler(c, false)
+}
+
+func (s *Service) setLogbookDeletedHandler(c *fiber.Ctx, deleted bool) error {
+	const op errors.Op = "server.Service.setLogbookDeletedHandler"
+
+	reqCtx, err := getRequestContext(c)
+	if err != nil || reqCtx.User == nil {
+		err = errors.New(op).Err(err).Msg("Request context missing")
+		s.logger.ErrorWith().Err(err).Msg("getRequestContext failed")
+		return c.Status(fiber.StatusInternalServerError).JSON(jsonInternalError)
+	}
+	if reqCtx.Request.Logbook == nil || reqCtx.Request.Logbook.ID <= 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(jsonBadRequest)
+	}
+	logbookID := reqCtx.Request.Logbook.ID
+
+	ctx := c.UserContext()
+	if !deleted {
+		if err = s.checkUserQuota(ctx, s.db, reqCtx.User.ID, quotaLogbooks, 1); err != nil {
+			if body, exceeded := quotaExceededBody(err); exceeded {
+				return c.Status(fiber.StatusForbidden).JSON(body)
+			}
+			s.logger.ErrorWith().Err(errors.New(op).Err(err)).Msg("s.checkUserQuota failed")
+			return s.dbFailure(c, err)
+		}
+	}
+	found, err := s.setLogbookDeleted(ctx, reqCtx.User.ID, logbookID, deleted)
+	if err != nil {
+		s.logger.ErrorWith().Err(errors.New(op).Err(err)).Int64("logbook_id", logbookID).Msg("s.setLogbookDeleted failed")
+		return s.dbFailure(c, err)
+	}
+	if !found {
+		return c.Status(fiber.StatusNotFound).JSON(jsonError(errCodeLogbookNotFound, "Logbook not found"))
+	}
+
+	// Drop the cached logbook so that its API keys are checked against the trash again.
+	s.invalidateLogbook(ctx, logbookID)
+	s.logger.InfoWith().Int64("logbook_id", logbookID).Bool("deleted", deleted).Msg("Logbook trash state changed")
+	if deleted {
+		s.audit(c, auditEntry{Event: auditLogbookDeleted, Actor: reqCtx.Actor, Callsign: reqCtx.User.Callsign, LogbookID: auditLogbookID(logbookID)},
+			fiber.Map{"mode": logbookDeleteTrash})
+	}
+
+	return c.SendStatus(fiber.StatusNoContent)
+}
+
+// listTrashedLogbooksHandler returns the authenticated user's logbooks that are in the trash.
+func (s *Service) listTrashedLogbooksHandler(c *fiber.Ctx) error {
+	const op errors.Op = "server.Service.listTrashedLogbooksHandler"
+
+	reqCtx, err := getRequestContext(c)
+	if err != nil || reqCtx.User == nil {
+		err = errors.New(op).Err(err).Msg("Request context missing")
+		s.logger.ErrorWith().Err(err).Msg("getRequestContext failed")
+		return c.Status(fiber.StatusInternalServerError).JSON(jsonInternalError)
+	}
+
+	logbooks, err := s.listTrashedLogbooks(c.UserContext(), reqCtx.User.ID)
+	if err != nil {
+		s.logger.ErrorWith().Err(errors.New(op).Err(err)).Msg("s.listTrashedLogbooks failed")
+		return s.dbFailure(c, err)
+	}
+	return c.JSON(fiber.Map{"logbooks": logbooks, "retention": s.trashRetention.String()})
+}
+
+// setQsoDeleted moves a QSO of the logbook to or from the trash and records the change in its
+// history. It reports false when the QSO is not in the expected state.
+func (s *Service) setQsoDeleted(ctx context.Context, logbookID, qsoID int64, deleted bool, actor string) (bool, error) {
+	const op errors.Op = "server.Service.setQsoDeleted"
+
+	query, action := `UPDATE qso SET deleted_at = CURRENT_TIMESTAMP WHERE id = $1 AND logbook_id = $2 AND deleted_at IS NULL`, qsoHistoryDelete
+	if !deleted {
+		query, action = `UPDATE qso SET deleted_at = NULL WHERE id = $1 AND logbook_id = $2 AND deleted_at IS NOT NULL`, qsoHistoryRestore
+	}
+
+	var found bool
+	err := s.withTx(ctx, func(tx *sql.Tx) error {
+		res, txErr := tx.ExecContext(ctx, query, qsoID, logbookID)
+		if txErr != nil {
+			return txErr
+		}
+		n, txErr := res.RowsAffected()
+		if txErr != nil {
+			return txErr
+		}
+		if found = n > 0; !found {
+			return nil
+		}
+		return recordQsoHistory(ctx, tx, qsoHistoryEntry{QsoID: qsoID, LogbookID: logbookID, Action: action, Actor: actor})
+	})
+	if err != nil {
+		return false, errors.New(op).Err(err)
+	}
+	return found, nil
+}
+
+// setLogbookDeleted moves a logbook owned by the user to or from the trash. It reports false when
+// the logbook is not the user's or not in the expected state.
+func (s *Service) setLogbookDeleted(ctx context.Context, userID, logbookID int64, deleted bool) (bool, error) {
+	const op errors.Op = "server.Service.setLogbookDeleted"
+
+	query := `UPDATE logbook SET deleted_at = CURRENT_TIMESTAMP WHERE id = $1 AND user_id = $2 AND deleted_at IS NULL`
+	if !deleted {
+		query = `UPDATE logbook SET deleted_at = NULL WHERE id = $1 AND user_id = $2 AND deleted_at IS NOT NULL`
+	}
+	res, err := s.db.ExecContext(ctx, query, logbookID, userID)
+	if err != nil {
+		return false, errors.New(op).Err(err)
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return false, errors.New(op).Err(err)
+	}
+	return n > 0, nil
+}
+
+// timestampExpr formats the timestamp column as an RFC 3339 UTC timestamp in either dialect.
+// NULL stays NULL.
+func (s *Service) timestampExpr(column string) string {
+	if s.isPostgres() {
+		return `to_char(` + column + ` AT TIME ZONE 'UTC', 'YYYY-MM-DD"T"HH24:MI:SS"Z"')`
+	}
+	return `strftime('%Y-%m-%dT%H:%M:%SZ', ` + column + `)`
+}
+
+// listTrashedQsos returns up to limit QSOs of the logbook that are in the trash, most recently
+// deleted first. A limit of zero or less returns them all.
+func (s *Service) listTrashedQsos(ctx context.Context, logbookID int64, limit int) ([]trashedQso, error) {
+	const op errors.Op = "server.Service.listTrashedQsos"
+
+	columns := `id, call, band, mode, qso_date, time_on`
+	if s.isPostgres() {
+		columns = `id, call, band, mode, to_char(qso_date, 'YYYYMMDD'), to_char(time_on, 'HH24MI')`
+	}
+	query := `SELECT ` + columns + `, ` + s.timestampExpr(`deleted_at`) + ` FROM qso
+		WHERE logbook_id = $1 AND deleted_at IS NOT NULL ORDER BY deleted_at DESC, id DESC`
+	args := []any{logbookID}
+	if limit > 0 {
+		query += ` LIMIT $2`
+		args = append(args, limit)
+	}
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, errors.New(op).Err(err)
+	}
+	defer func() { _ = rows.Close() }()
+
+	qsos := make([]trashedQso, 0)
+	for rows.Next() {
+		var q trashedQso
+		if err = rows.Scan(&q.ID, &q.Call, &q.Band, &q.Mode, &q.QsoDate, &q.TimeOn, &q.DeletedAt); err != nil {
+			return nil, errors.New(op).Err(err)
+		}
+		qsos = append(qsos, q)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, errors.New(op).Err(err)
+	}
+
+	ids := make([]int64, len(qsos))
+	for i, q := range qsos {
+		ids[i] = q.ID
+	}
+	uuids, err := s.qsoUUIDs(ctx, logbookID, ids)
+	if err != nil {
+		return nil, errors.New(op).Err(err)
+	}
+	for i := range qsos {
+		qsos[i].UUID = uuids[qsos[i].ID]
+	}
+	return qsos, nil
+}
+
+func (s *Service) listTrashedLogbooks(ctx context.Context, userID int64) ([]trashedLogbook, error) {
+	const op errors.Op = "server.Service.listTrashedLogbooks"
+
+	rows, err := s.db.QueryContext(ctx, `SELECT id, name, callsign, `+s.timestampExpr(`deleted_at`)+` FROM logbook
+		WHERE user_id = $1 AND deleted_at IS NOT NULL ORDER BY deleted_at DESC, id DESC`, userID)
+	if err != nil {
+		return nil, errors.New(op).Err(err)
+	}
+	defer func() { _ = rows.Close() }()
+
+	logbooks := make([]trashedLogbook, 0)
+	for rows.Next() {
+		var lb trashedLogbook
+		if err = rows.Scan(&lb.ID, &lb.Name, &lb.Callsign, &lb.DeletedAt); err != nil {
+			return nil, errors.New(op).Err(err)
+		}
+		logbooks = append(logbooks, lb)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, errors.New(op).Err(err)
+	}
+
+	ids := make([]int64, len(logbooks))
+	for i, lb := range logbooks {
+		ids[i] = lb.ID
+	}
+	uuids, err := s.logbookUUIDs(ctx, ids)
+	if err != nil {
+		return nil, errors.New(op).Err(err)
+	}
+	for i := range logbooks {
+		logbooks[i].UUID = uuids[logbooks[i].ID]
+	}
+	return logbooks, nil
+}
+
+// purgeExpiredTrash removes the QSOs and logbooks that have been in the trash for longer than the
+// retention.
+func (s *Service) purgeExpiredTrash(ctx context.Context) error {
+	qsos, logbooks, err := s.purgeTrash(ctx, time.Now().Add(-s.trashRetention))
+	if err != nil {
+		return err
+	}
+	if qsos+logbooks > 0 {
+		s.logger.InfoWith().Int64("qsos", qsos).Int64("logbooks", logbooks).Msg("Trash purged")
+	}
+	return nil
+}
+
+// purgeTrash permanently deletes the QSOs and logbooks moved to the trash before the cutoff,
+// including the QSOs of purged logbooks. Rows of the server's tables that reference them are
+// removed by their foreign keys.
+func (s *Service) purgeTrash(ctx context.Context, before time.Time) (qsos, logbooks int64, err error) {
+	const op errors.Op = "server.Service.purgeTrash"
+
+	cutoff := s.dbTimestamp(before)
+
+	var prefixes []string
+	err = s.withTx(ctx, func(tx *sql.Tx) error {
+		res, txErr := tx.ExecContext(ctx, `DELETE FROM qso WHERE deleted_at < $1
+			OR logbook_id IN (SELECT id FROM logbook WHERE deleted_at < $1)`, cutoff)
+		if txErr != nil {
+			return txErr
+		}
+		if qsos, txErr = res.RowsAffected(); txErr != nil {
+			return txErr
+		}
+		if res, txErr = tx.ExecContext(ctx, `DELETE FROM logbook WHERE deleted_at < $1`, cutoff); txErr != nil {
+			return txErr
+		}
+		if logbooks, txErr = res.RowsAffected(); txErr != nil {
+			return txErr
+		}
+		prefixes, txErr = purgeOrphanSandboxes(ctx, tx)
+		return txErr
+	})
+	if err != nil {
+		return 0, 0, errors.New(op).Err(err)
+	}
+
+	for _, prefix := range prefixes {
+		s.invalidateApiKey(ctx, prefix)
+	}
+	return qsos, logbooks, nil
+}
@@ -0,0 +1,178 @@
+package service
+
+import (
+	"context"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/Station-Manager/types"
+	"github.com/goccy/go-json"
+	"github.com/gofiber/fiber/v2"
+)
+
+func TestQsoTrash_DeleteListRestore(t *testing.T) {
+	svc := newTestServer(t)
+	svc.db = newLiveStore(svc.db)
+	id := insertTestQso(t, svc, "JA1XX", "20m", "FT8", "20240430", "1203")
+
+	sub := svc.qsoEvents.Subscribe(1)
+	defer svc.qsoEvents.Unsubscribe(sub)
+
+	app := fiber.New()
+	app.Get("/qsos/trash", withLogbook(1, svc.listTrashedQsosHandler))
+	app.Get("/qsos/:id", withLogbook(1, svc.getQsoHandler))
+	app.Delete("/qsos/:id", withLogbook(1, svc.deleteQsoHandler))
+	app.Delete("/other/qsos/:id", withLogbook(2, svc.deleteQsoHandler))
+	app.Post("/qsos/:id/restore", withLogbook(1, svc.restoreQsoHandler))
+
+	path := "/qsos/" + strconv.FormatInt(id, 10)
+	if resp, _ := app.Test(httptest.NewRequest(fiber.MethodDelete, "/other"+path, nil)); resp.StatusCode != fiber.StatusNotFound {
+		t.Fatalf("expected another logbook's delete to get 404, got %d", resp.StatusCode)
+	}
+	if resp, _ := app.Test(httptest.NewRequest(fiber.MethodDelete, path, nil)); resp.StatusCode != fiber.StatusNoContent {
+		t.Fatalf("expected 204, got %d", resp.StatusCode)
+	}
+	if event := <-sub.Events(); event.Type != qsoEventDeleted || event.Qso.Call != "JA1XX" {
+		t.Errorf("expected a deleted event, got %+v", event)
+	}
+	if resp, _ := app.Test(httptest.NewRequest(fiber.MethodGet, path, nil)); resp.StatusCode != fiber.StatusNotFound {
+		t.Errorf("expected a trashed QSO to be hidden, got %d", resp.StatusCode)
+	}
+	if resp, _ := app.Test(httptest.NewRequest(fiber.MethodDelete, path, nil)); resp.StatusCode != fiber.StatusNotFound {
+		t.Errorf("expected a second delete to get 404, got %d", resp.StatusCode)
+	}
+
+	resp, err := app.Test(httptest.NewRequest(fiber.MethodGet, "/qsos/trash", nil))
+	if err != nil || resp.StatusCode != fiber.StatusOK {
+		t.Fatalf("list trash failed: %v", err)
+	}
+	var trash struct {
+		Qsos []trashedQso `json:"qsos"`
+	}
+	if err = json.NewDecoder(resp.Body).Decode(&trash); err != nil {
+		t.Fatalf("decode failed: %v", err)
+	}
+	if len(trash.Qsos) != 1 || trash.Qsos[0].ID != id || trash.Qsos[0].QsoDate != "20240430" || trash.Qsos[0].DeletedAt == emptyString {
+		t.Fatalf("unexpected trash %+v", trash.Qsos)
+	}
+
+	if resp, _ = app.Test(httptest.NewRequest(fiber.MethodPost, path+"/restore", nil)); resp.StatusCode != fiber.StatusOK {
+		t.Fatalf("expected restore to succeed, got %d", resp.StatusCode)
+	}
+	if event := <-sub.Events(); event.Type != qsoEventRestored || event.Qso.ID != id {
+		t.Errorf("expected a restored event, got %+v", event)
+	}
+	if resp, _ = app.Test(httptest.NewRequest(fiber.MethodGet, path, nil)); resp.StatusCode != fiber.StatusOK {
+		t.Errorf("expected a restored QSO to be visible, got %d", resp.StatusCode)
+	}
+	if resp, _ = app.Test(httptest.NewRequest(fiber.MethodPost, path+"/restore", nil)); resp.StatusCode != fiber.StatusNotFound {
+		t.Errorf("expected restoring a live QSO to get 404, got %d", resp.StatusCode)
+	}
+}
+
+func TestLogbookTrash_HidesLogbookUntilRestored(t *testing.T) {
+	svc := newTestServer(t)
+	svc.db = newLiveStore(svc.db)
+	ctx := context.Background()
+	for _, query := range []string{
+		`INSERT INTO users (id, callsign) VALUES (7, 'W1AW'), (8, 'K1AB')`,
+		`UPDATE logbook SET user_id = 7 WHERE id = 1`,
+	} {
+		if _, err := svc.db.ExecContext(ctx, query); err != nil {
+			t.Fatalf("setup failed: %v", err)
+		}
+	}
+
+	withUser := func(userID int64, handler fiber.Handler) fiber.Handler {
+		return func(c *fiber.Ctx) error {
+			c.Locals(localsRequestDataKey, &requestContext{
+				Request: envelopeOf(c, &types.Logbook{ID: 1}),
+				User:    &types.User{ID: userID},
+			})
+			return handler(c)
+		}
+	}
+	app := fiber.New()
+	app.Post("/delete", withUser(7, svc.deleteLogbookHandler))
+	app.Post("/other/delete", withUser(8, svc.deleteLogbookHandler))
+	app.Post("/trash", withUser(7, svc.listTrashedLogbooksHandler))
+	app.Post("/restore", withUser(7, svc.restoreLogbookHandler))
+
+	if _, err := svc.fetchLogbookWithCache(ctx, 1); err != nil {
+		t.Fatalf("fetchLogbookWithCache failed: %v", err)
+	}
+	if resp, _ := app.Test(httptest.NewRequest(fiber.MethodPost, "/other/delete", nil)); resp.StatusCode != fiber.StatusNotFound {
+		t.Fatalf("expected another user's delete to get 404, got %d", resp.StatusCode)
+	}
+	if resp, _ := app.Test(httptest.NewRequest(fiber.MethodPost, "/delete", nil)); resp.StatusCode != fiber.StatusNoContent {
+		t.Fatalf("expected 204, got %d", resp.StatusCode)
+	}
+	if _, err := svc.fetchLogbookWithCache(ctx, 1); err == nil {
+		t.Error("expected a trashed logbook to be evicted from the cache and hidden")
+	}
+
+	resp, err := app.Test(httptest.NewRequest(fiber.MethodPost, "/trash", nil))
+	if err != nil || resp.StatusCode != fiber.StatusOK {
+		t.Fatalf("list trash failed: %v", err)
+	}
+	var trash struct {
+		Logbooks []trashedLogbook `json:"logbooks"`
+	}
+	if err = json.NewDecoder(resp.Body).Decode(&trash); err != nil || len(trash.Logbooks) != 1 || trash.Logbooks[0].ID != 1 {
+		t.Fatalf("unexpected trash %+v (err=%v)", trash.Logbooks, err)
+	}
+
+	if resp, _ = app.Test(httptest.NewRequest(fiber.MethodPost, "/restore", nil)); resp.StatusCode != fiber.StatusNoContent {
+		t.Fatalf("expected restore to succeed, got %d", resp.StatusCode)
+	}
+	if _, err = svc.fetchLogbookWithCache(ctx, 1); err != nil {
+		t.Errorf("expected a restored logbook to be visible, got %v", err)
+	}
+}
+
+func TestPurgeTrash_RemovesExpiredItems(t *testing.T) {
+	svc := newTestServer(t)
+	ctx := context.Background()
+	old := insertTestQso(t, svc, "JA1XX", "20m", "FT8", "20240430", "1203")
+	recent := insertTestQso(t, svc, "K1AB", "40m", "CW", "20240430", "1400")
+	live := insertTestQso(t, svc, "G4XX", "20m", "CW", "20240430", "1600")
+
+	if _, err := svc.db.ExecContext(ctx, `UPDATE qso SET deleted_at = datetime('now', '-40 days') WHERE id = $1`, old); err != nil {
+		t.Fatalf("update failed: %v", err)
+	}
+	if _, err := svc.setQsoDeleted(ctx, 1, recent, true, "test"); err != nil {
+		t.Fatalf("setQsoDeleted failed: %v", err)
+	}
+
+	qsos, logbooks, err := svc.purgeTrash(ctx, time.Now().Add(-defaultTrashRetention))
+	if err != nil {
+		t.Fatalf("purgeTrash failed: %v", err)
+	}
+	if qsos != 1 || logbooks != 0 {
+		t.Fatalf("expected one QSO to be purged, got %d QSOs and %d logbooks", qsos, logbooks)
+	}
+	ids, err := svc.listLogbookQsoIDs(ctx, 1)
+	if err != nil || len(ids) != 1 || ids[0] != live {
+		t.Errorf("expected only the live QSO to be listed, got %v (err=%v)", ids, err)
+	}
+	if trashed, _ := svc.listTrashedQsos(ctx, 1, trashListMax); len(trashed) != 1 || trashed[0].ID != recent {
+		t.Errorf("expected the recently deleted QSO to stay in the trash, got %+v", trashed)
+	}
+}
+
+func TestLoadTrashRetention(t *testing.T) {
+	t.Setenv(envTrashRetention, "")
+	if d, err := loadTrashRetention(); err != nil || d != defaultTrashRetention {
+		t.Errorf("expected the default retention, got %v (err=%v)", d, err)
+	}
+	t.Setenv(envTrashRetention, "0")
+	if d, err := loadTrashRetention(); err != nil || d != 0 {
+		t.Errorf("expected zero to disable purging, got %v (err=%v)", d, err)
+	}
+	t.Setenv(envTrashRetention, "forever")
+	if _, err := loadTrashRetention(); err == nil {
+		t.Error("expected an invalid duration to be rejected")
+	}
+}
@@ -0,0 +1,57 @@
+package service
+
+import (
+	"context"
+	"database/sql"
+	stderr "errors"
+	"time"
+
+	"github.com/Station-Manager/errors"
+)
+
+// withTx runs fn in a database transaction. The transaction is committed if fn returns nil and
+// rolled back if it returns an error or panics; a panic is re-raised after the rollback. The error
+// from fn is returned unchanged so that callers can still inspect it.
+//
+// A transaction that conflicts with another is run again, so fn must not have effects outside it.
+func (s *Service) withTx(ctx context.Context, fn func(tx *sql.Tx) error) error {
+	return s.dbRetry.do(ctx, isTxConflict, func() error { return s.runTx(ctx, fn) })
+}
+
+// runTx runs fn in a single transaction attempt. The transaction counts as one database call of
+// the request, from waiting for a connection to the commit.
+func (s *Service) runTx(ctx context.Context, fn func(tx *sql.Tx) error) error {
+	const op errors.Op = "server.Service.runTx"
+	defer recordDBCall(ctx, "transaction", time.Now())
+
+	tx, cancel, err := s.db.BeginTxContext(ctx)
+	if err != nil {
+		return errors.New(op).Err(err).Msg("s.db.BeginTxContext")
+	}
+	defer cancel()
+
+	defer func() {
+		if p := recover(); p != nil {
+			s.rollback(tx)
+			panic(p)
+		}
+	}()
+
+	if err = fn(tx); err != nil {
+		s.rollback(tx)
+		return err
+	}
+
+	// No need to roll back if the commit fails.
+	if err = tx.Commit(); err != nil {
+		return errors.New(op).Err(err).Msg("tx.Commit")
+	}
+	return nil
+}
+
+// rollback rolls tx back, logging a failure since the caller is already returning an error.
+func (s *Service) rollback(tx *sql.Tx) {
+	if err := tx.Rollback(); err != nil && !stderr.Is(err, sql.ErrTxDone) {
+		s.logger.ErrorWith().Err(err).Msg("Failed to roll back transaction")
+	}
+}
@@ -0,0 +1,68 @@
+package service
+
+import (
+	"context"
+	"database/sql"
+	stderr "errors"
+	"testing"
+)
+
+func TestWithTx(t *testing.T) {
+	svc := newTestServer(t)
+	ctx := context.Background()
+
+	insert := func(name string) func(tx *sql.Tx) error {
+		return func(tx *sql.Tx) error {
+			_, err := tx.ExecContext(ctx, `INSERT INTO logbook (name, callsign, description) VALUES ($1, 'W1AW', '')`, name)
+			return err
+		}
+	}
+	count := func(name string) int {
+		rows, err := svc.db.QueryContext(ctx, `SELECT COUNT(*) FROM logbook WHERE name = $1`, name)
+		if err != nil {
+			t.Fatalf("count failed: %v", err)
+		}
+		defer func() { _ = rows.Close() }()
+		n := 0
+		if rows.Next() {
+			_ = rows.Scan(&n)
+		}
+		return n
+	}
+
+	if err := svc.withTx(ctx, insert("committed")); err != nil {
+		t.Fatalf("withTx failed: %v", err)
+	}
+	if count("committed") != 1 {
+		t.Error("expected the insert to be committed")
+	}
+
+	boom := stderr.New("boom")
+	err := svc.withTx(ctx, func(tx *sql.Tx) error {
+		if err := insert("failed")(tx); err != nil {
+			return err
+		}
+		return boom
+	})
+	if !stderr.Is(err, boom) {
+		t.Errorf("expected the callback error to be returned, got %v", err)
+	}
+	if count("failed") != 0 {
+		t.Error("expected the insert to be rolled back after an error")
+	}
+
+	func() {
+		defer func() {
+			if recover() == nil {
+				t.Error("expected the panic to be re-raised")
+			}
+		}()
+		_ = svc.withTx(ctx, func(tx *sql.Tx) error {
+			_ = insert("panicked")(tx)
+			panic("boom")
+		})
+	}()
+	if count("panicked") != 0 {
+		t.Error("expected the insert to be rolled back after a panic")
+	}
+}
@@ -0,0 +1,92 @@
+package service
+
+import (
+	"context"
+	"strings"
+
+	"github.com/Station-Manager/errors"
+	"github.com/Station-Manager/types"
+	"github.com/gofiber/fiber/v2"
+)
+
+// logbookUpdate is the validated form of a logbook update. It replaces the logbook's details.
+type logbookUpdate struct {
+	ID          int64  `validate:"required,gt=0"`
+	Name        string `validate:"required,max=64"`
+	Callsign    string `validate:"required,min=3,max=32"`
+	Description string `validate:"max=255"`
+}
+
+// updateLogbookHandler changes the name, station callsign and description of one of the
+// authenticated user's logbooks. The request carries the logbook as it should be; the logbook's
+// cached copy is dropped on success so that its API keys see the new details.
+func (s *Service) updateLogbookHandler(c *fiber.Ctx) error {
+	const op errors.Op = "server.Service.updateLogbookHandler"
+
+	reqCtx, err := getRequestContext(c)
+	if err != nil || reqCtx.User == nil {
+		err = errors.New(op).Err(err).Msg("Request context missing")
+		s.logger.ErrorWith().Err(err).Msg("getRequestContext failed")
+		return c.Status(fiber.StatusInternalServerError).JSON(jsonInternalError)
+	}
+	if reqCtx.Request.Logbook == nil {
+		return c.Status(fiber.StatusBadRequest).JSON(jsonBadRequest)
+	}
+
+	request := reqCtx.Request.Logbook
+	update := logbookUpdate{
+		ID:          request.ID,
+		Name:        strings.TrimSpace(request.Name),
+		Callsign:    strings.ToUpper(strings.TrimSpace(request.Callsign)),
+		Description: strings.TrimSpace(request.Description),
+	}
+	if !validCallsign(update.Callsign) {
+		return c.Status(fiber.StatusBadRequest).JSON(invalidCallsign("callsign"))
+	}
+	if err = s.validate.Struct(update); err != nil {
+		s.logger.InfoWith().Err(err).Msg("Logbook update validation failed")
+		return c.Status(fiber.StatusBadRequest).JSON(jsonBadRequest)
+	}
+
+	ctx := c.UserContext()
+	found, err := s.updateLogbook(ctx, reqCtx.User.ID, update)
+	if isUniqueViolation(err) {
+		return c.Status(fiber.StatusConflict).JSON(jsonError(errCodeLogbookNameTaken, "A logbook with this name already exists"))
+	}
+	if err != nil {
+		s.logger.ErrorWith().Err(errors.New(op).Err(err)).Int64("logbook_id", update.ID).Msg("s.updateLogbook failed")
+		return s.dbFailure(c, err)
+	}
+	if !found {
+		return c.Status(fiber.StatusNotFound).JSON(jsonError(errCodeLogbookNotFound, "Logbook not found"))
+	}
+
+	s.invalidateLogbook(ctx, update.ID)
+	s.logger.InfoWith().Int64("logbook_id", update.ID).Msg("Logbook updated")
+
+	return c.JSON(fiber.Map{"logbook": types.Logbook{
+		ID:          update.ID,
+		UserID:      reqCtx.User.ID,
+		Name:        update.Name,
+		Callsign:    update.Callsign,
+		Description: update.Description,
+	}})
+}
+
+// updateLogbook replaces the details of a logbook owned by the user. It reports false when the
+// logbook is not the user's or is in the trash.
+func (s *Service) updateLogbook(ctx context.Context, userID int64, update logbookUpdate) (bool, error) {
+	const op errors.Op = "server.Service.updateLogbook"
+
+	res, err := s.db.ExecContext(ctx, `UPDATE logbook SET name = $1, callsign = $2, description = $3, modified_at = CURRENT_TIMESTAMP
+		WHERE id = $4 AND user_id = $5 AND deleted_at IS NULL`,
+		update.Name, update.Callsign, update.Description, update.ID, userID)
+	if err != nil {
+		return false, errors.New(op).Err(err)
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return false, errors.New(op).Err(err)
+	}
+	return n > 0, nil
+}
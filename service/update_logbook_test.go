@@ -0,0 +1,69 @@
+package service
+
+import (
+	"context"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/Station-Manager/types"
+	"github.com/go-playground/validator/v10"
+	"github.com/gofiber/fiber/v2"
+)
+
+func TestUpdateLogbookHandler(t *testing.T) {
+	svc := newTestServer(t)
+	svc.validate = validator.New(validator.WithRequiredStructEnabled())
+	ctx := context.Background()
+	for _, query := range []string{
+		`INSERT INTO users (id, callsign) VALUES (7, 'W1AW'), (8, 'K1AB')`,
+		`UPDATE logbook SET user_id = 7 WHERE id = 1`,
+		`INSERT INTO logbook (id, name, callsign, user_id) VALUES (2, 'Portable', 'W1AW/P', 7)`,
+	} {
+		if _, err := svc.db.ExecContext(ctx, query); err != nil {
+			t.Fatalf("setup failed: %v", err)
+		}
+	}
+	if _, err := svc.fetchLogbookWithCache(ctx, 1); err != nil {
+		t.Fatalf("fetchLogbookWithCache failed: %v", err)
+	}
+
+	update := func(userID int64, logbook types.Logbook) int {
+		app := fiber.New()
+		app.Post("/update", func(c *fiber.Ctx) error {
+			c.Locals(localsRequestDataKey, &requestContext{
+				Request: envelopeOf(c, &logbook),
+				User:    &types.User{ID: userID},
+			})
+			return svc.updateLogbookHandler(c)
+		})
+		resp, err := app.Test(httptest.NewRequest(fiber.MethodPost, "/update", nil))
+		if err != nil {
+			t.Fatalf("update request failed: %v", err)
+		}
+		return resp.StatusCode
+	}
+
+	if got := update(7, types.Logbook{ID: 1, Name: "Home"}); got != fiber.StatusBadRequest {
+		t.Errorf("expected a missing callsign to get 400, got %d", got)
+	}
+	if got := update(7, types.Logbook{ID: 1, Name: "Home", Callsign: "HELLO WORLD"}); got != fiber.StatusBadRequest {
+		t.Errorf("expected a junk callsign to get 400, got %d", got)
+	}
+	if got := update(8, types.Logbook{ID: 1, Name: "Home", Callsign: "W1AW"}); got != fiber.StatusNotFound {
+		t.Errorf("expected another user's update to get 404, got %d", got)
+	}
+	if got := update(7, types.Logbook{ID: 1, Name: "Portable", Callsign: "W1AW"}); got != fiber.StatusConflict {
+		t.Errorf("expected a duplicate name to get 409, got %d", got)
+	}
+	if got := update(7, types.Logbook{ID: 1, Name: " Home ", Callsign: "w1aw/m", Description: "Mobile rig"}); got != fiber.StatusOK {
+		t.Fatalf("expected the update to succeed, got %d", got)
+	}
+
+	logbook, err := svc.fetchLogbookWithCache(ctx, 1)
+	if err != nil {
+		t.Fatalf("fetchLogbookWithCache failed: %v", err)
+	}
+	if logbook.Name != "Home" || logbook.Callsign != "W1AW/M" || logbook.Description != "Mobile rig" {
+		t.Errorf("expected the cache to see the new details, got %+v", logbook)
+	}
+}
@@ -0,0 +1,164 @@
+package service
+
+import (
+	"context"
+	stderr "errors"
+
+	"github.com/Station-Manager/errors"
+	"github.com/Station-Manager/types"
+)
+
+const (
+	uploadStatusUploaded = "uploaded"
+	uploadStatusFailed   = "failed"
+	// uploadStatusRejected marks QSOs the service refused outright; they are not retried.
+	uploadStatusRejected = "rejected"
+
+	// maxUploadAttempts bounds how often a failed upload is retried by the periodic sync.
+	maxUploadAttempts = 5
+	// uploadRetryBatch is the number of failed uploads retried per logbook and sync.
+	uploadRetryBatch = 100
+)
+
+// uploadRejectedError reports that a service refused a QSO, e.g. because it failed validation.
+// Retrying the same QSO would fail again.
+type uploadRejectedError struct {
+	msg string
+}
+
+func (e *uploadRejectedError) Error() string {
+	return e.msg
+}
+
+// recordQsoUpload records the outcome of an attempt to upload the QSO to a service.
+func (s *Service) recordQsoUpload(ctx context.Context, qsoID int64, service string, uploadErr error) error {
+	const op errors.Op = "server.Service.recordQsoUpload"
+
+	status, lastError := uploadStatusUploaded, emptyString
+	if uploadErr != nil {
+		status, lastError = uploadStatusFailed, errors.Root(uploadErr).Error()
+		var rejected *uploadRejectedError
+		if stderr.As(uploadErr, &rejected) {
+			status = uploadStatusRejected
+		}
+	}
+
+	if _, err := s.db.ExecContext(ctx, `INSERT INTO qso_uploads (qso_id, service, status, attempts, last_error) VALUES ($1, $2, $3, 1, $4)
+		ON CONFLICT (qso_id, service) DO UPDATE SET
+			status = excluded.status, attempts = qso_uploads.attempts + 1,
+			last_error = excluded.last_error, updated_at = CURRENT_TIMESTAMP`,
+		qsoID, service, status, lastError); err != nil {
+		return errors.New(op).Err(err)
+	}
+	return nil
+}
+
+// listRetryableUploads returns the IDs of the logbook's QSOs whose upload to the service failed
+// and may be retried.
+func (s *Service) listRetryableUploads(ctx context.Context, service string, logbookID int64) ([]int64, error) {
+	const op errors.Op = "server.Service.listRetryableUploads"
+
+	rows, err := s.db.QueryContext(ctx, `SELECT u.qso_id FROM qso_uploads u JOIN qso q ON q.id = u.qso_id
+		WHERE u.service = $1 AND u.status = $2 AND u.attempts < $3 AND q.logbook_id = $4 AND q.deleted_at IS NULL
+		ORDER BY u.qso_id LIMIT $5`, service, uploadStatusFailed, maxUploadAttempts, logbookID, uploadRetryBatch)
+	if err != nil {
+		return nil, errors.New(op).Err(err)
+	}
+	defer func() { _ = rows.Close() }()
+
+	ids := make([]int64, 0)
+	for rows.Next() {
+		var id int64
+		if err = rows.Scan(&id); err != nil {
+			return nil, errors.New(op).Err(err)
+		}
+		ids = append(ids, id)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, errors.New(op).Err(err)
+	}
+	return ids, nil
+}
+
+// resolveFailedUploads marks the logbook's failed uploads to the service as done, after the whole
+// log has been uploaded by other means.
+func (s *Service) resolveFailedUploads(ctx context.Context, service string, logbookID int64) error {
+	const op errors.Op = "server.Service.resolveFailedUploads"
+
+	if _, err := s.db.ExecContext(ctx, `UPDATE qso_uploads SET status = $1, last_error = '', updated_at = CURRENT_TIMESTAMP
+		WHERE service = $2 AND status <> $1 AND qso_id IN (SELECT id FROM qso WHERE logbook_id = $3)`,
+		uploadStatusUploaded, service, logbookID); err != nil {
+		return errors.New(op).Err(err)
+	}
+	return nil
+}
+
+// listLogbookQsoIDs returns the IDs of all QSOs in the logbook in insertion order.
+func (s *Service) listLogbookQsoIDs(ctx context.Context, logbookID int64) ([]int64, error) {
+	const op errors.Op = "server.Service.listLogbookQsoIDs"
+
+	rows, err := s.db.QueryContext(ctx, `SELECT id FROM qso WHERE logbook_id = $1 AND deleted_at IS NULL ORDER BY id`, logbookID)
+	if err != nil {
+		return nil, errors.New(op).Err(err)
+	}
+	defer func() { _ = rows.Close() }()
+
+	ids := make([]int64, 0)
+	for rows.Next() {
+		var id int64
+		if err = rows.Scan(&id); err != nil {
+			return nil, errors.New(op).Err(err)
+		}
+		ids = append(ids, id)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, errors.New(op).Err(err)
+	}
+	return ids, nil
+}
+
+// listLogbookQsoIDPage returns the IDs of up to limit QSOs in the logbook with an ID above
+// afterID, in insertion order.
+func (s *Service) listLogbookQsoIDPage(ctx context.Context, logbookID, afterID int64, limit int) ([]int64, error) {
+	const op errors.Op = "server.Service.listLogbookQsoIDPage"
+
+	rows, err := s.db.QueryContext(ctx, `SELECT id FROM qso WHERE logbook_id = $1 AND deleted_at IS NULL AND id > $2
+		ORDER BY id LIMIT $3`, logbookID, afterID, limit)
+	if err != nil {
+		return nil, errors.New(op).Err(err)
+	}
+	defer func() { _ = rows.Close() }()
+
+	ids := make([]int64, 0, limit)
+	for rows.Next() {
+		var id int64
+		if err = rows.Scan(&id); err != nil {
+			return nil, errors.New(op).Err(err)
+		}
+		ids = append(ids, id)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, errors.New(op).Err(err)
+	}
+	return ids, nil
+}
+
+// fetchLogbookQsoPage returns up to limit QSOs of the logbook that follow afterID, in insertion
+// order.
+func (s *Service) fetchLogbookQsoPage(ctx context.Context, logbookID, afterID int64, limit int) ([]types.Qso, error) {
+	const op errors.Op = "server.Service.fetchLogbookQsoPage"
+
+	ids, err := s.listLogbookQsoIDPage(ctx, logbookID, afterID, limit)
+	if err != nil {
+		return nil, errors.New(op).Err(err)
+	}
+	qsos := make([]types.Qso, 0, len(ids))
+	for _, id := range ids {
+		qso, err := s.db.FetchQsoByIdContext(ctx, id)
+		if err != nil {
+			return nil, errors.New(op).Err(err).Msgf("Failed to fetch QSO %d", id)
+		}
+		qsos = append(qsos, qso)
+	}
+	return qsos, nil
+}
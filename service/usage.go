@@ -0,0 +1,298 @@
+package service
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Station-Manager/errors"
+	"github.com/gofiber/fiber/v2"
+)
+
+const (
+	// usageFlushInterval is how often the usage counted in memory is added to the api_usage table.
+	usageFlushInterval = 30 * time.Second
+	// usageFlushTimeout bounds the last flush, made as the server shuts down.
+	usageFlushTimeout = 5 * time.Second
+	// usageDayLayout is the layout of the api_usage table's day column, a UTC date.
+	usageDayLayout = "2006-01-02"
+
+	usageDaysDefault = 30
+	usageDaysMax     = 366
+	// adminUsageTopKeys is the number of the busiest API keys of the day listed in the admin stats.
+	adminUsageTopKeys = 10
+)
+
+// API usage is counted per logbook, API key and UTC day rather than stored per request. The
+// counts build up in memory and are added to the api_usage table every usageFlushInterval, so
+// that counting adds no database write to any request. Requests made through a share link or with
+// a password are counted against the logbook with an empty key prefix.
+//
+// The time each API key was last used is kept the same way and stored with the usage, so that an
+// authenticated request, the QSO insert included, never waits on the bookkeeping.
+
+// usageKey identifies a row of the api_usage table.
+type usageKey struct {
+	logbookID int64
+	keyPrefix string
+	day       string
+}
+
+// usageCounts are the requests made, QSOs inserted and requests failed.
+type usageCounts struct {
+	Requests int64 `json:"requests"`
+	Inserts  int64 `json:"inserts"`
+	Errors   int64 `json:"errors"`
+}
+
+func (c *usageCounts) add(o usageCounts) {
+	c.Requests += o.Requests
+	c.Inserts += o.Inserts
+	c.Errors += o.Errors
+}
+
+// apiUsage is a row of the api_usage table.
+type apiUsage struct {
+	LogbookID int64  `json:"logbook_id,omitempty"`
+	KeyPrefix string `json:"key_prefix"`
+	Day       string `json:"day,omitempty"`
+	usageCounts
+}
+
+// usageTracker holds the usage counted since the last flush, and when each API key was last used.
+// A nil tracker counts nothing.
+type usageTracker struct {
+	mu       sync.Mutex
+	pending  map[usageKey]usageCounts
+	lastUsed map[string]time.Time
+}
+
+func newUsageTracker() *usageTracker {
+	return &usageTracker{pending: make(map[usageKey]usageCounts), lastUsed: make(map[string]time.Time)}
+}
+
+// record adds counts to the logbook's usage by the actor today.
+func (u *usageTracker) record(logbookID int64, actor string, counts usageCounts) {
+	if u == nil || logbookID <= 0 {
+		return
+	}
+	prefix, ok := strings.CutPrefix(actor, apiKeyActorPrefix)
+	if !ok {
+		prefix = emptyString
+	}
+	now := time.Now()
+	key := usageKey{logbookID: logbookID, keyPrefix: prefix, day: now.UTC().Format(usageDayLayout)}
+
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	c := u.pending[key]
+	c.add(counts)
+	u.pending[key] = c
+	if prefix != emptyString {
+		u.lastUsed[prefix] = now
+	}
+}
+
+// take returns the usage counted and the API key uses seen so far, and starts afresh.
+func (u *usageTracker) take() (map[usageKey]usageCounts, map[string]time.Time) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	pending, lastUsed := u.pending, u.lastUsed
+	u.pending, u.lastUsed = make(map[usageKey]usageCounts), make(map[string]time.Time)
+	return pending, lastUsed
+}
+
+// putBack returns usage and API key uses that could not be stored, to be stored with the next
+// flush.
+func (u *usageTracker) putBack(usage map[usageKey]usageCounts, lastUsed map[string]time.Time) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	for key, counts := range usage {
+		c := u.pending[key]
+		c.add(counts)
+		u.pending[key] = c
+	}
+	for prefix, at := range lastUsed {
+		if at.After(u.lastUsed[prefix]) {
+			u.lastUsed[prefix] = at
+		}
+	}
+}
+
+// recordInserts counts QSOs inserted into the logbook by the actor.
+func (s *Service) recordInserts(logbookID int64, actor string, n int) {
+	if n > 0 {
+		s.usage.record(logbookID, actor, usageCounts{Inserts: int64(n)})
+	}
+}
+
+// usageMiddleware counts each request made to a logbook, and those that fail, once the request
+// has been handled.
+func (s *Service) usageMiddleware() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		err := c.Next()
+		if s.usage == nil {
+			return err
+		}
+		reqCtx, ctxErr := getRequestContext(c)
+		if ctxErr != nil || reqCtx.Logbook == nil {
+			return err
+		}
+		counts := usageCounts{Requests: 1}
+		if err != nil || c.Response().StatusCode() >= fiber.StatusBadRequest {
+			counts.Errors = 1
+		}
+		s.usage.record(reqCtx.Logbook.ID, reqCtx.Actor, counts)
+		return err
+	}
+}
+
+// runUsageFlusher adds the usage counted in memory to the api_usage table periodically, and once
+// more when ctx is cancelled.
+func (s *Service) runUsageFlusher(ctx context.Context) {
+	ticker := time.NewTicker(usageFlushInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			flushCtx, cancel := context.WithTimeout(context.Background(), usageFlushTimeout)
+			if err := s.flushUsage(flushCtx); err != nil {
+				s.logger.WarnWith().Err(err).Msg("Failed to store API usage")
+			}
+			cancel()
+			return
+		case <-ticker.C:
+			if err := s.flushUsage(ctx); err != nil {
+				s.logger.WarnWith().Err(err).Msg("Failed to store API usage")
+			}
+		}
+	}
+}
+
+// flushUsage adds the usage counted in memory to the api_usage table and records when the API
+// keys were last used. Usage that could not be stored is kept for the next flush; that of logbooks
+// purged meanwhile is dropped.
+func (s *Service) flushUsage(ctx context.Context) error {
+	const op errors.Op = "server.Service.flushUsage"
+
+	if s.usage == nil {
+		return nil
+	}
+	pending, lastUsed := s.usage.take()
+	if len(pending) == 0 && len(lastUsed) == 0 {
+		return nil
+	}
+	err := s.withTx(ctx, func(tx *sql.Tx) error {
+		for key, c := range pending {
+			if _, err := tx.ExecContext(ctx, `INSERT INTO api_usage (logbook_id, key_prefix, day, requests, inserts, errors)
+				SELECT CAST($1 AS BIGINT), CAST($2 AS TEXT), CAST($3 AS TEXT), CAST($4 AS BIGINT), CAST($5 AS BIGINT), CAST($6 AS BIGINT)
+				WHERE EXISTS (SELECT 1 FROM logbook WHERE id = $1)
+				ON CONFLICT (logbook_id, key_prefix, day) DO UPDATE SET requests = api_usage.requests + excluded.requests,
+					inserts = api_usage.inserts + excluded.inserts, errors = api_usage.errors + excluded.errors`,
+				key.logbookID, key.keyPrefix, key.day, c.Requests, c.Inserts, c.Errors); err != nil {
+				return err
+			}
+		}
+		for prefix, at := range lastUsed {
+			// A later use may have been stored by another instance.
+			if _, err := tx.ExecContext(ctx, `UPDATE api_keys SET last_used_at = $1
+				WHERE key_prefix = $2 AND (last_used_at IS NULL OR last_used_at < $1)`, s.dbTimestamp(at), prefix); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		s.usage.putBack(pending, lastUsed)
+		return errors.New(op).Err(err)
+	}
+	return nil
+}
+
+// listLogbookUsage returns the logbook's usage per API key and day over the last days days,
+// newest first.
+func (s *Service) listLogbookUsage(ctx context.Context, logbookID int64, days int) ([]apiUsage, error) {
+	const op errors.Op = "server.Service.listLogbookUsage"
+
+	since := time.Now().UTC().AddDate(0, 0, 1-days).Format(usageDayLayout)
+	rows, err := s.db.QueryContext(ctx, `SELECT key_prefix, day, requests, inserts, errors FROM api_usage
+		WHERE logbook_id = $1 AND day >= $2 ORDER BY day DESC, key_prefix`, logbookID, since)
+	if err != nil {
+		return nil, errors.New(op).Err(err)
+	}
+	defer func() { _ = rows.Close() }()
+
+	usage := make([]apiUsage, 0)
+	for rows.Next() {
+		var u apiUsage
+		if err = rows.Scan(&u.KeyPrefix, &u.Day, &u.Requests, &u.Inserts, &u.Errors); err != nil {
+			return nil, errors.New(op).Err(err)
+		}
+		usage = append(usage, u)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, errors.New(op).Err(err)
+	}
+	return usage, nil
+}
+
+// listBusiestKeys returns today's usage of the API keys that made the most requests, across all
+// logbooks.
+func (s *Service) listBusiestKeys(ctx context.Context, limit int) ([]apiUsage, error) {
+	const op errors.Op = "server.Service.listBusiestKeys"
+
+	rows, err := s.db.QueryContext(ctx, `SELECT logbook_id, key_prefix, requests, inserts, errors FROM api_usage
+		WHERE day = $1 ORDER BY requests DESC, errors DESC, logbook_id LIMIT $2`, time.Now().UTC().Format(usageDayLayout), limit)
+	if err != nil {
+		return nil, errors.New(op).Err(err)
+	}
+	defer func() { _ = rows.Close() }()
+
+	usage := make([]apiUsage, 0)
+	for rows.Next() {
+		var u apiUsage
+		if err = rows.Scan(&u.LogbookID, &u.KeyPrefix, &u.Requests, &u.Inserts, &u.Errors); err != nil {
+			return nil, errors.New(op).Err(err)
+		}
+		usage = append(usage, u)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, errors.New(op).Err(err)
+	}
+	return usage, nil
+}
+
+// usageHandler returns the authenticated logbook's usage per API key and day over the last days
+// days (30 unless the days query parameter says otherwise), with its totals, so that an owner can
+// spot a client making too many requests or failing.
+func (s *Service) usageHandler(c *fiber.Ctx) error {
+	const op errors.Op = "server.Service.usageHandler"
+
+	logbook, err := authenticatedLogbook(c)
+	if err != nil {
+		s.logger.ErrorWith().Err(errors.New(op).Err(err)).Msg("authenticatedLogbook failed")
+		return c.Status(fiber.StatusInternalServerError).JSON(jsonInternalError)
+	}
+	days := c.QueryInt("days", usageDaysDefault)
+	if days <= 0 || days > usageDaysMax {
+		return c.Status(fiber.StatusBadRequest).JSON(jsonBadRequest)
+	}
+
+	ctx := c.UserContext()
+	if err = s.flushUsage(ctx); err != nil {
+		s.logger.WarnWith().Err(errors.New(op).Err(err)).Msg("s.flushUsage failed")
+	}
+	usage, err := s.listLogbookUsage(ctx, logbook.ID, days)
+	if err != nil {
+		s.logger.ErrorWith().Err(errors.New(op).Err(err)).Msg("s.listLogbookUsage failed")
+		return s.dbFailure(c, err)
+	}
+	var totals usageCounts
+	for _, u := range usage {
+		totals.add(u.usageCounts)
+	}
+	return c.JSON(fiber.Map{"days": days, "usage": usage, "totals": totals})
+}
@@ -0,0 +1,158 @@
+package service
+
+import (
+	"context"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/Station-Manager/types"
+	"github.com/goccy/go-json"
+	"github.com/gofiber/fiber/v2"
+)
+
+func TestUsageMiddleware_CountsRequestsAndErrors(t *testing.T) {
+	svc := newTestServer(t)
+	svc.usage = newUsageTracker()
+	ctx := context.Background()
+
+	app := fiber.New()
+	app.Use(svc.usageMiddleware())
+	withKey := func(actor string) fiber.Handler {
+		return func(c *fiber.Ctx) error {
+			c.Locals(localsRequestDataKey, &requestContext{Logbook: &types.Logbook{ID: 1}, IsValid: true, Actor: actor})
+			return c.Next()
+		}
+	}
+	app.Get("/ok", withKey(apiKeyActorPrefix+"abc"), func(c *fiber.Ctx) error {
+		svc.recordInserts(1, apiKeyActorPrefix+"abc", 2)
+		return c.SendStatus(fiber.StatusCreated)
+	})
+	app.Get("/bad", withKey(apiKeyActorPrefix+"abc"), func(c *fiber.Ctx) error { return c.SendStatus(fiber.StatusBadRequest) })
+	app.Get("/shared", withKey(""), func(c *fiber.Ctx) error { return c.SendStatus(fiber.StatusOK) })
+	app.Get("/anonymous", func(c *fiber.Ctx) error { return c.SendStatus(fiber.StatusOK) })
+
+	for _, path := range []string{"/ok", "/ok", "/bad", "/shared", "/anonymous"} {
+		if _, err := app.Test(httptest.NewRequest(fiber.MethodGet, path, nil)); err != nil {
+			t.Fatalf("GET %s failed: %v", path, err)
+		}
+	}
+	if err := svc.flushUsage(ctx); err != nil {
+		t.Fatalf("flushUsage failed: %v", err)
+	}
+	// A second flush adds to the rows rather than replacing them.
+	svc.recordInserts(1, apiKeyActorPrefix+"abc", 1)
+	if err := svc.flushUsage(ctx); err != nil {
+		t.Fatalf("flushUsage failed: %v", err)
+	}
+
+	usage, err := svc.listLogbookUsage(ctx, 1, 1)
+	if err != nil {
+		t.Fatalf("listLogbookUsage failed: %v", err)
+	}
+	if len(usage) != 2 {
+		t.Fatalf("expected usage for the key and for the share link, got %+v", usage)
+	}
+	if usage[0].KeyPrefix != "" || usage[0].usageCounts != (usageCounts{Requests: 1}) {
+		t.Errorf("unexpected usage without a key %+v", usage[0])
+	}
+	if usage[1].KeyPrefix != "abc" || usage[1].usageCounts != (usageCounts{Requests: 3, Inserts: 5, Errors: 1}) {
+		t.Errorf("unexpected usage of the key %+v", usage[1])
+	}
+
+	busiest, err := svc.listBusiestKeys(ctx, 1)
+	if err != nil {
+		t.Fatalf("listBusiestKeys failed: %v", err)
+	}
+	if len(busiest) != 1 || busiest[0].LogbookID != 1 || busiest[0].KeyPrefix != "abc" {
+		t.Errorf("expected the key to be the busiest, got %+v", busiest)
+	}
+}
+
+func TestFlushUsage_DropsPurgedLogbooks(t *testing.T) {
+	svc := newTestServer(t)
+	svc.usage = newUsageTracker()
+	ctx := context.Background()
+
+	svc.usage.record(1, "", usageCounts{Requests: 1})
+	svc.usage.record(999, "", usageCounts{Requests: 1})
+	if err := svc.flushUsage(ctx); err != nil {
+		t.Fatalf("flushUsage failed: %v", err)
+	}
+	if pending, _ := svc.usage.take(); len(pending) != 0 {
+		t.Errorf("expected nothing left to flush, got %v", pending)
+	}
+	if usage, err := svc.listLogbookUsage(ctx, 999, 1); err != nil || len(usage) != 0 {
+		t.Errorf("expected no usage for a missing logbook, got %+v %v", usage, err)
+	}
+}
+
+func TestFlushUsage_RecordsApiKeyLastUsed(t *testing.T) {
+	svc := newTestServer(t)
+	svc.usage = newUsageTracker()
+	ctx := context.Background()
+	if _, err := svc.db.ExecContext(ctx, `INSERT INTO api_keys (logbook_id, key_name, key_prefix, key_hash) VALUES (1, 'laptop', 'abc123', 'key-hash')`); err != nil {
+		t.Fatalf("insert API key failed: %v", err)
+	}
+	lastUsed := func() string {
+		rows, err := svc.db.QueryContext(ctx, `SELECT COALESCE(`+svc.timestampExpr(`last_used_at`)+`, '') FROM api_keys WHERE key_prefix = 'abc123'`)
+		if err != nil {
+			t.Fatalf("query failed: %v", err)
+		}
+		defer func() { _ = rows.Close() }()
+		var at string
+		if rows.Next() {
+			_ = rows.Scan(&at)
+		}
+		return at
+	}
+
+	svc.usage.record(1, apiKeyActorPrefix+"abc123", usageCounts{Requests: 1})
+	if at := lastUsed(); at != "" {
+		t.Fatalf("expected the use not to be stored before the flush, got %q", at)
+	}
+	if err := svc.flushUsage(ctx); err != nil {
+		t.Fatalf("flushUsage failed: %v", err)
+	}
+	if at := lastUsed(); at == "" {
+		t.Error("expected the flush to store when the key was last used")
+	}
+
+	// An older use, such as one put back after a failed flush, does not move it back.
+	stored := lastUsed()
+	svc.usage.putBack(nil, map[string]time.Time{"abc123": time.Now().Add(-time.Hour)})
+	if err := svc.flushUsage(ctx); err != nil {
+		t.Fatalf("flushUsage failed: %v", err)
+	}
+	if at := lastUsed(); at != stored {
+		t.Errorf("expected %q to be kept, got %q", stored, at)
+	}
+}
+
+func TestUsageHandler(t *testing.T) {
+	svc := newTestServer(t)
+	svc.usage = newUsageTracker()
+	svc.usage.record(1, apiKeyActorPrefix+"abc", usageCounts{Requests: 4, Errors: 1})
+
+	app := fiber.New()
+	app.Get("/usage", withLogbook(1, svc.usageHandler))
+
+	resp, err := app.Test(httptest.NewRequest(fiber.MethodGet, "/usage", nil))
+	if err != nil || resp.StatusCode != fiber.StatusOK {
+		t.Fatalf("expected 200, got %v (err=%v)", resp.StatusCode, err)
+	}
+	var out struct {
+		Usage  []apiUsage  `json:"usage"`
+		Totals usageCounts `json:"totals"`
+	}
+	if err = json.NewDecoder(resp.Body).Decode(&out); err != nil {
+		t.Fatalf("decode failed: %v", err)
+	}
+	if len(out.Usage) != 1 || out.Totals != (usageCounts{Requests: 4, Errors: 1}) {
+		t.Errorf("expected the usage counted in memory to be included, got %+v", out)
+	}
+
+	if resp, _ = app.Test(httptest.NewRequest(fiber.MethodGet, "/usage?days=0", nil)); resp.StatusCode != fiber.StatusBadRequest {
+		t.Errorf("expected days=0 to be rejected, got %d", resp.StatusCode)
+	}
+}
@@ -0,0 +1,174 @@
+package service
+
+import (
+	"context"
+	"strings"
+
+	"github.com/Station-Manager/apikey"
+	"github.com/Station-Manager/errors"
+	"github.com/Station-Manager/types"
+	"github.com/gofiber/fiber/v2"
+)
+
+// updateUser persists changes to a user, such as a new password hash or email address, and
+// invalidates the cached copy so that subsequent authentications see the new details.
+func (s *Service) updateUser(ctx context.Context, user types.User) error {
+	const op errors.Op = "server.Service.updateUser"
+	if s == nil {
+		return errors.New(op).Msg(errMsgNilService)
+	}
+	if user.Callsign == emptyString {
+		return errors.New(op).Msg("Callsign is empty")
+	}
+
+	// Invalidate regardless of the outcome: a failed update leaves the stored state uncertain.
+	defer s.invalidateUser(ctx, user.Callsign)
+
+	if err := s.db.UpdateUserContext(ctx, user); err != nil {
+		return errors.New(op).Err(err)
+	}
+	return nil
+}
+
+// minPasswordLength is the shortest password the account commands accept.
+const minPasswordLength = 8
+
+// CreateUser opens the database, adds a user with the password and email address, and closes the
+// database. Users must have a verified email address to sign in; verified marks it so at once.
+func (s *Service) CreateUser(callsign, email, password string, verified bool) error {
+	const op errors.Op = "server.Service.CreateUser"
+	if s == nil {
+		return errors.New(op).Msg(errMsgNilService)
+	}
+
+	user := types.User{Callsign: strings.ToUpper(strings.TrimSpace(callsign)), Email: strings.TrimSpace(email), EmailConfirmed: verified}
+	if err := s.validate.Var(user.Email, "required,email,max=256"); err != nil {
+		return errors.New(op).Err(err).Msg("Invalid email address")
+	}
+	hash, err := hashPassword(password)
+	if err != nil {
+		return errors.New(op).Err(err)
+	}
+	user.PassHash = hash
+	if err = s.validate.Struct(user); err != nil || !validCallsign(user.Callsign) {
+		return errors.New(op).Err(err).Msg("Invalid callsign")
+	}
+
+	if err = s.openAndVerify(); err != nil {
+		return errors.New(op).Err(err)
+	}
+	defer func() { _ = s.db.Close() }()
+
+	ctx := context.Background()
+	_, found, err := s.fetchUserID(ctx, user.Callsign)
+	if err != nil {
+		return errors.New(op).Err(err)
+	}
+	if found {
+		return errors.New(op).Msgf("User %s already exists", user.Callsign)
+	}
+	if _, err = s.db.InsertUserContext(ctx, user); err != nil {
+		return errors.New(op).Err(err)
+	}
+	s.recordAudit(ctx, auditEntry{Event: auditUserCreated, Actor: auditActorCLI, Callsign: user.Callsign}, fiber.Map{"email_confirmed": verified})
+	return nil
+}
+
+// SetUserPassword opens the database, replaces the user's password, and closes the database.
+func (s *Service) SetUserPassword(callsign, password string) error {
+	const op errors.Op = "server.Service.SetUserPassword"
+	if s == nil {
+		return errors.New(op).Msg(errMsgNilService)
+	}
+
+	hash, err := hashPassword(password)
+	if err != nil {
+		return errors.New(op).Err(err)
+	}
+	if err = s.changeUser(callsign, auditPasswordChanged, func(user *types.User) { user.PassHash = hash }); err != nil {
+		return errors.New(op).Err(err)
+	}
+	return nil
+}
+
+// VerifyUserEmail opens the database, marks the user's email address as verified, and closes the
+// database.
+func (s *Service) VerifyUserEmail(callsign string) error {
+	const op errors.Op = "server.Service.VerifyUserEmail"
+	if s == nil {
+		return errors.New(op).Msg(errMsgNilService)
+	}
+
+	if err := s.changeUser(callsign, auditEmailVerified, func(user *types.User) { user.EmailConfirmed = true }); err != nil {
+		return errors.New(op).Err(err)
+	}
+	return nil
+}
+
+// RevokeApiKey opens the database, revokes the API key with the prefix, and closes the database.
+func (s *Service) RevokeApiKey(prefix string) error {
+	const op errors.Op = "server.Service.RevokeApiKey"
+	if s == nil {
+		return errors.New(op).Msg(errMsgNilService)
+	}
+
+	if err := s.openAndVerify(); err != nil {
+		return errors.New(op).Err(err)
+	}
+	defer func() { _ = s.db.Close() }()
+
+	prefix = strings.TrimSpace(prefix)
+	revoked, err := s.revokeApiKey(context.Background(), prefix, "cli")
+	if err != nil {
+		return errors.New(op).Err(err)
+	}
+	if !revoked {
+		return errors.New(op).Msgf("No active API key has the prefix %s", prefix)
+	}
+	s.recordAudit(context.Background(), auditEntry{Event: auditApiKeyRevoked, Actor: auditActorCLI}, fiber.Map{"prefix": prefix})
+	return nil
+}
+
+// changeUser opens the database, applies change to the user, saves them, records the event in the
+// audit log, and closes the database.
+func (s *Service) changeUser(callsign string, event auditEvent, change func(user *types.User)) error {
+	const op errors.Op = "server.Service.changeUser"
+
+	if err := s.openAndVerify(); err != nil {
+		return errors.New(op).Err(err)
+	}
+	defer func() { _ = s.db.Close() }()
+
+	ctx := context.Background()
+	callsign = strings.ToUpper(strings.TrimSpace(callsign))
+	_, found, err := s.fetchUserID(ctx, callsign)
+	if err != nil {
+		return errors.New(op).Err(err)
+	}
+	if !found {
+		return errors.New(op).Msgf("User %s not found", callsign)
+	}
+	user, err := s.db.FetchUserByCallsignContext(ctx, callsign)
+	if err != nil {
+		return errors.New(op).Err(err)
+	}
+	change(&user)
+	if err = s.updateUser(ctx, user); err != nil {
+		return errors.New(op).Err(err)
+	}
+	s.recordAudit(ctx, auditEntry{Event: event, Actor: auditActorCLI, Callsign: callsign}, nil)
+	return nil
+}
+
+// hashPassword checks the password's length and hashes it for storage.
+func hashPassword(password string) (string, error) {
+	const op errors.Op = "server.hashPassword"
+	if len(password) < minPasswordLength {
+		return emptyString, errors.New(op).Msgf("The password must be at least %d characters long", minPasswordLength)
+	}
+	hash, err := apikey.HashPassword(password)
+	if err != nil {
+		return emptyString, errors.New(op).Err(err)
+	}
+	return hash, nil
+}
@@ -0,0 +1,244 @@
+package service
+
+import (
+	"encoding/base64"
+	"strings"
+
+	"github.com/Station-Manager/errors"
+	"github.com/Station-Manager/types"
+	"github.com/gofiber/fiber/v2"
+)
+
+// The v2 API addresses logbooks and QSOs as resources with the usual HTTP verbs. It authenticates
+// with headers instead of the JSON request envelope of v1: HTTP basic authentication with the
+// callsign and password for the account's logbooks, and an API key bearer token for a logbook's
+// QSOs. The handlers are those of v1; the middlewares here only adapt the request to them.
+
+const (
+	v2QsoPageDefault = 50
+	v2QsoPageMax     = 100
+
+	// basicAuthChallenge is the WWW-Authenticate header of requests rejected for their basic
+	// credentials.
+	basicAuthChallenge = `Basic realm="Station Manager"`
+)
+
+// basicAuthContextMiddleware reads HTTP basic credentials into the request context, in place of
+// the envelope that requestContextMiddleware reads for v1, for passwordAuthNMiddleware to check.
+// Every rejection carries the challenge, whether the credentials were missing or wrong.
+func (s *Service) basicAuthContextMiddleware() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		callsign, password, ok := parseBasicAuth(c.Get(fiber.HeaderAuthorization))
+		if !ok {
+			c.Set(fiber.HeaderWWWAuthenticate, basicAuthChallenge)
+			return c.Status(fiber.StatusUnauthorized).JSON(jsonUnauthorized)
+		}
+		setRequestContext(c, &requestContext{Request: requestEnvelope{PostRequest: types.PostRequest{Callsign: callsign, Key: password}}})
+		err := c.Next()
+		if c.Response().StatusCode() == fiber.StatusUnauthorized {
+			c.Set(fiber.HeaderWWWAuthenticate, basicAuthChallenge)
+		}
+		return err
+	}
+}
+
+// parseBasicAuth returns the credentials of an "Authorization: Basic" header.
+func parseBasicAuth(header string) (string, string, bool) {
+	encoded, ok := strings.CutPrefix(header, "Basic ")
+	if !ok {
+		return emptyString, emptyString, false
+	}
+	decoded, err := base64.StdEncoding.DecodeString(strings.TrimSpace(encoded))
+	if err != nil {
+		return emptyString, emptyString, false
+	}
+	callsign, password, ok := strings.Cut(string(decoded), ":")
+	if !ok || callsign == emptyString || password == emptyString {
+		return emptyString, emptyString, false
+	}
+	return strings.ToUpper(callsign), password, true
+}
+
+// pathLogbookMiddleware checks that the logbook in the path is the one the API key belongs to.
+// Other logbooks are reported as not found, whether they exist or not.
+func (s *Service) pathLogbookMiddleware() fiber.Handler {
+	const op errors.Op = "server.Service.pathLogbookMiddleware"
+
+	return func(c *fiber.Ctx) error {
+		logbook, err := authenticatedLogbook(c)
+		if err != nil {
+			s.logger.ErrorWith().Err(errors.New(op).Err(err)).Msg("authenticatedLogbook failed")
+			return c.Status(fiber.StatusInternalServerError).JSON(jsonInternalError)
+		}
+		id, _, err := s.resolveLogbookRef(c.UserContext(), c.Params("id"))
+		if err != nil {
+			s.logger.ErrorWith().Err(errors.New(op).Err(err)).Msg("s.resolveLogbookRef failed")
+			return s.dbFailure(c, err)
+		}
+		if id > 0 && id != logbook.ID {
+			return s.refuseCrossTenant(c, tenantTargetLogbook, id)
+		}
+		if id != logbook.ID {
+			return c.Status(fiber.StatusNotFound).JSON(jsonError(errCodeLogbookNotFound, "Logbook not found"))
+		}
+		return c.Next()
+	}
+}
+
+// v2ListLogbooksHandler returns the authenticated user's logbooks, including archived ones and
+// those in the trash.
+func (s *Service) v2ListLogbooksHandler(c *fiber.Ctx) error {
+	const op errors.Op = "server.Service.v2ListLogbooksHandler"
+
+	reqCtx, err := getRequestContext(c)
+	if err != nil || reqCtx.User == nil {
+		err = errors.New(op).Err(err).Msg("Request context missing")
+		s.logger.ErrorWith().Err(err).Msg("getRequestContext failed")
+		return c.Status(fiber.StatusInternalServerError).JSON(jsonInternalError)
+	}
+
+	logbooks, err := s.listAccountLogbooks(c.UserContext(), reqCtx.User.ID)
+	if err != nil {
+		s.logger.ErrorWith().Err(errors.New(op).Err(err)).Msg("s.listAccountLogbooks failed")
+		return s.dbFailure(c, err)
+	}
+	return c.JSON(fiber.Map{"logbooks": logbooks})
+}
+
+// v2CreateLogbookHandler registers the logbook in the body, as POST /api/logbook/register does.
+func (s *Service) v2CreateLogbookHandler(c *fiber.Ctx) error {
+	const op errors.Op = "server.Service.v2CreateLogbookHandler"
+
+	reqCtx, err := getRequestContext(c)
+	if err != nil {
+		s.logger.ErrorWith().Err(errors.New(op).Err(err)).Msg("getRequestContext failed")
+		return c.Status(fiber.StatusInternalServerError).JSON(jsonInternalError)
+	}
+	var logbook types.Logbook
+	if err = c.BodyParser(&logbook); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(jsonBadRequest)
+	}
+	reqCtx.Request.Logbook = &logbook
+
+	return s.registerLogbookHandler(c)
+}
+
+// v2DeleteLogbookHandler moves the logbook in the path to the trash, as POST /api/logbook/delete
+// does without a mode.
+func (s *Service) v2DeleteLogbookHandler(c *fiber.Ctx) error {
+	const op errors.Op = "server.Service.v2DeleteLogbookHandler"
+
+	reqCtx, err := getRequestContext(c)
+	if err != nil {
+		s.logger.ErrorWith().Err(errors.New(op).Err(err)).Msg("getRequestContext failed")
+		return c.Status(fiber.StatusInternalServerError).JSON(jsonInternalError)
+	}
+	id, ok, err := s.resolveLogbookRef(c.UserContext(), c.Params("id"))
+	if err != nil {
+		s.logger.ErrorWith().Err(errors.New(op).Err(err)).Msg("s.resolveLogbookRef failed")
+		return s.dbFailure(c, err)
+	}
+	if !ok {
+		return c.Status(fiber.StatusBadRequest).JSON(jsonBadRequest)
+	}
+	if id == 0 {
+		return c.Status(fiber.StatusNotFound).JSON(jsonError(errCodeLogbookNotFound, "Logbook not found"))
+	}
+	reqCtx.Request.Logbook = &types.Logbook{ID: id}
+
+	return s.setLogbookDeletedHandler(c, true)
+}
+
+// v2ListQsosHandler returns a page of the logbook's QSOs in insertion order. The after_id query
+// parameter continues from the last QSO of the previous page. With sort=time (or -time, newest
+// first) the QSOs are in time order instead, and the cursor parameter continues from the
+// next_cursor of the previous page. Times are also given in the reader's time zone, if they have one.
+func (s *Service) v2ListQsosHandler(c *fiber.Ctx) error {
+	const op errors.Op = "server.Service.v2ListQsosHandler"
+
+	logbook, err := authenticatedLogbook(c)
+	if err != nil {
+		s.logger.ErrorWith().Err(errors.New(op).Err(err)).Msg("authenticatedLogbook failed")
+		return c.Status(fiber.StatusInternalServerError).JSON(jsonInternalError)
+	}
+	afterID := int64(c.QueryInt("after_id", 0))
+	limit := c.QueryInt("limit", v2QsoPageDefault)
+	if afterID < 0 || limit <= 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(jsonBadRequest)
+	}
+	limit = min(limit, v2QsoPageMax)
+
+	sort, cursorValue := c.Query("sort"), c.Query("cursor")
+	keyset := sort != emptyString || cursorValue != emptyString
+	var after *qsoCursor
+	descending := sort == qsoSortTimeDescending
+	if keyset {
+		if sort != emptyString && sort != qsoSortTime && sort != qsoSortTimeDescending {
+			return c.Status(fiber.StatusBadRequest).JSON(jsonError(errCodeBadRequest, "sort must be time or -time"))
+		}
+		if afterID != 0 {
+			return c.Status(fiber.StatusBadRequest).JSON(jsonError(errCodeBadRequest, "after_id cannot be used with sort or cursor"))
+		}
+		if cursorValue != emptyString {
+			cursor, ok := decodeQsoCursor(cursorValue)
+			if !ok || (sort != emptyString && cursor.Descending != descending) {
+				return c.Status(fiber.StatusBadRequest).JSON(jsonError(errCodeBadRequest, "cursor is not valid"))
+			}
+			after, descending = &cursor, cursor.Descending
+		}
+	}
+	location, ok, err := s.readerLocation(c)
+	if err != nil {
+		s.logger.ErrorWith().Err(errors.New(op).Err(err)).Msg("s.readerLocation failed")
+		return s.dbFailure(c, err)
+	}
+	if !ok {
+		return c.Status(fiber.StatusBadRequest).JSON(jsonError(errCodeBadRequest, "tz is not a time zone"))
+	}
+
+	var qsos []types.Qso
+	var next qsoCursor
+	if keyset {
+		qsos, next, err = s.fetchLogbookQsoKeysetPage(c.UserContext(), logbook.ID, after, descending, limit)
+	} else {
+		qsos, err = s.fetchLogbookQsoPage(c.UserContext(), logbook.ID, afterID, limit)
+	}
+	if err != nil {
+		s.logger.ErrorWith().Err(errors.New(op).Err(err)).Msg("s.fetchLogbookQsoPage failed")
+		return s.dbFailure(c, err)
+	}
+	public, err := s.publicQsos(c.UserContext(), logbook.ID, qsos)
+	if err != nil {
+		s.logger.ErrorWith().Err(errors.New(op).Err(err)).Msg("s.publicQsos failed")
+		return s.dbFailure(c, err)
+	}
+	localizeQsos(public, location)
+
+	response := fiber.Map{"qsos": public}
+	switch {
+	case len(qsos) < limit:
+	case keyset:
+		response["next_cursor"] = next.encode()
+	default:
+		response["next_after_id"] = qsos[len(qsos)-1].ID
+	}
+	return c.JSON(response)
+}
+
+// v2InsertQsoHandler logs the QSO in the body, as POST /api/qso/insert does.
+func (s *Service) v2InsertQsoHandler(c *fiber.Ctx) error {
+	const op errors.Op = "server.Service.v2InsertQsoHandler"
+
+	reqCtx, err := getRequestContext(c)
+	if err != nil {
+		s.logger.ErrorWith().Err(errors.New(op).Err(err)).Msg("getRequestContext failed")
+		return c.Status(fiber.StatusInternalServerError).JSON(jsonInternalError)
+	}
+	var qso types.Qso
+	if err = c.BodyParser(&qso); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(jsonBadRequest)
+	}
+	reqCtx.Request.Qso = &qso
+
+	return s.insertQsoHandler(c)
+}
@@ -0,0 +1,126 @@
+package service
+
+import (
+	"context"
+	"encoding/base64"
+	"io"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"github.com/Station-Manager/apikey"
+	"github.com/Station-Manager/types"
+	"github.com/goccy/go-json"
+	"github.com/gofiber/fiber/v2"
+)
+
+func TestParseBasicAuth(t *testing.T) {
+	encode := func(s string) string { return "Basic " + base64.StdEncoding.EncodeToString([]byte(s)) }
+
+	callsign, password, ok := parseBasicAuth(encode("w1aw:secret:with:colons"))
+	if !ok || callsign != "W1AW" || password != "secret:with:colons" {
+		t.Errorf("expected W1AW and the full password, got %q %q %v", callsign, password, ok)
+	}
+	for _, header := range []string{"", "Bearer abc", "Basic !!!", encode("w1aw"), encode(":secret"), encode("w1aw:")} {
+		if _, _, ok = parseBasicAuth(header); ok {
+			t.Errorf("expected %q to be rejected", header)
+		}
+	}
+}
+
+func TestV2_BasicAuthRequired(t *testing.T) {
+	svc := newTestServer(t)
+
+	app := fiber.New()
+	app.Get("/api/v2/logbooks", svc.basicAuthContextMiddleware(), svc.v2ListLogbooksHandler)
+
+	resp, err := app.Test(httptest.NewRequest(fiber.MethodGet, "/api/v2/logbooks", nil))
+	if err != nil {
+		t.Fatalf("request failed: %v", err)
+	}
+	if resp.StatusCode != fiber.StatusUnauthorized || resp.Header.Get(fiber.HeaderWWWAuthenticate) == emptyString {
+		t.Errorf("expected 401 with a challenge, got %d %q", resp.StatusCode, resp.Header.Get(fiber.HeaderWWWAuthenticate))
+	}
+}
+
+func TestV2_UnknownUserAnsweredAsWrongPassword(t *testing.T) {
+	svc := newTestServer(t)
+	hash, err := apikey.HashPassword("right")
+	if err != nil {
+		t.Fatalf("HashPassword failed: %v", err)
+	}
+	if _, err = svc.db.ExecContext(context.Background(), `INSERT INTO users (id, callsign, pass_hash, email_confirmed) VALUES (7, 'W1AW', $1, TRUE)`, hash); err != nil {
+		t.Fatalf("setup failed: %v", err)
+	}
+
+	app := fiber.New()
+	app.Get("/api/v2/logbooks", svc.basicAuthContextMiddleware(), svc.passwordAuthNMiddleware(), svc.v2ListLogbooksHandler)
+	answer := func(credentials string) (int, string, string) {
+		req := httptest.NewRequest(fiber.MethodGet, "/api/v2/logbooks", nil)
+		req.Header.Set(fiber.HeaderAuthorization, "Basic "+base64.StdEncoding.EncodeToString([]byte(credentials)))
+		resp, err := app.Test(req)
+		if err != nil {
+			t.Fatalf("request failed: %v", err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		return resp.StatusCode, resp.Header.Get(fiber.HeaderWWWAuthenticate), string(body)
+	}
+
+	wrongStatus, wrongChallenge, wrongBody := answer("w1aw:wrong")
+	unknownStatus, unknownChallenge, unknownBody := answer("k9zz:wrong")
+	if wrongStatus != fiber.StatusUnauthorized || wrongChallenge != basicAuthChallenge {
+		t.Errorf("expected 401 with a challenge for a wrong password, got %d %q", wrongStatus, wrongChallenge)
+	}
+	if unknownStatus != wrongStatus || unknownChallenge != wrongChallenge || unknownBody != wrongBody {
+		t.Errorf("expected an unknown callsign to be answered as a wrong password, got %d %q %s", unknownStatus, unknownChallenge, unknownBody)
+	}
+	if status, _, _ := answer("w1aw:right"); status != fiber.StatusOK {
+		t.Errorf("expected the right password to be accepted, got %d", status)
+	}
+}
+
+func TestV2_ListQsosPages(t *testing.T) {
+	svc := newTestServer(t)
+	first := insertTestQso(t, svc, "K1ABC", "20m", "FT8", "20240101", "1200")
+	second := insertTestQso(t, svc, "K2ABC", "20m", "FT8", "20240101", "1201")
+	third := insertTestQso(t, svc, "K3ABC", "20m", "FT8", "20240101", "1202")
+
+	app := fiber.New()
+	withKey := func(c *fiber.Ctx) error {
+		c.Locals(localsRequestDataKey, &requestContext{Logbook: &types.Logbook{ID: 1}, IsValid: true})
+		return c.Next()
+	}
+	app.Get("/api/v2/logbooks/:id/qsos", withKey, svc.pathLogbookMiddleware(), svc.v2ListQsosHandler)
+
+	get := func(path string) (int, map[string]any) {
+		resp, err := app.Test(httptest.NewRequest(fiber.MethodGet, path, nil))
+		if err != nil {
+			t.Fatalf("GET %s failed: %v", path, err)
+		}
+		var out map[string]any
+		_ = json.NewDecoder(resp.Body).Decode(&out)
+		return resp.StatusCode, out
+	}
+
+	if status, _ := get("/api/v2/logbooks/2/qsos"); status != fiber.StatusNotFound {
+		t.Errorf("expected another logbook to be not found, got %d", status)
+	}
+	if status, _ := get("/api/v2/logbooks/1/qsos?limit=0"); status != fiber.StatusBadRequest {
+		t.Errorf("expected a zero limit to be rejected, got %d", status)
+	}
+
+	status, out := get("/api/v2/logbooks/1/qsos?limit=2")
+	qsos, _ := out["qsos"].([]any)
+	if status != fiber.StatusOK || len(qsos) != 2 || out["next_after_id"] != float64(second) {
+		t.Fatalf("expected the first page of two ending at %d, got %d %v", second, status, out)
+	}
+	if qsos[0].(map[string]any)["call"] != "K1ABC" {
+		t.Errorf("expected the page to start with QSO %d, got %v", first, qsos[0])
+	}
+
+	status, out = get("/api/v2/logbooks/1/qsos?limit=2&after_id=" + strconv.FormatInt(second, 10))
+	qsos, _ = out["qsos"].([]any)
+	if status != fiber.StatusOK || len(qsos) != 1 || out["next_after_id"] != nil {
+		t.Fatalf("expected the last page to hold QSO %d only, got %d %v", third, status, out)
+	}
+}
@@ -0,0 +1,461 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Station-Manager/errors"
+	"github.com/goccy/go-json"
+)
+
+const (
+	// Webhook request headers. The signature is the hex HMAC-SHA256, keyed with the webhook's
+	// secret, of "<timestamp>.<body>"; receivers should also reject stale timestamps.
+	headerWebhookEvent     = "X-SM-Event"
+	headerWebhookDelivery  = "X-SM-Delivery"
+	headerWebhookTimestamp = "X-SM-Timestamp"
+	headerWebhookSignature = "X-SM-Signature"
+
+	webhookUserAgent = "Station-Manager-Webhook/1"
+
+	defaultWebhookQueueSize      = 1024
+	defaultWebhookWorkers        = 4
+	defaultWebhookMaxAttempts    = 6
+	defaultWebhookRetryBaseDelay = 2 * time.Second
+	defaultWebhookRetryMaxDelay  = time.Minute
+	defaultWebhookRequestTimeout = 10 * time.Second
+	// webhookMaxResponseBytes bounds how much of a failed response is kept as the error.
+	webhookMaxResponseBytes = 512
+)
+
+// webhook is a URL that receives a POST for each QSO event in a logbook.
+type webhook struct {
+	ID        int64          `json:"id"`
+	LogbookID int64          `json:"logbook_id"`
+	URL       string         `json:"url"`
+	Secret    string         `json:"-"`
+	Events    []qsoEventType `json:"events"` // empty means every event type
+	CreatedAt time.Time      `json:"created_at"`
+}
+
+// wants reports whether the webhook is subscribed to the event type.
+func (w webhook) wants(t qsoEventType) bool {
+	if len(w.Events) == 0 {
+		return true
+	}
+	for _, e := range w.Events {
+		if e == t {
+			return true
+		}
+	}
+	return false
+}
+
+// webhookDeadLetter records a delivery that was abandoned.
+type webhookDeadLetter struct {
+	ID         int64        `json:"id"`
+	WebhookID  int64        `json:"webhook_id"`
+	DeliveryID string       `json:"delivery_id"`
+	EventType  qsoEventType `json:"event_type"`
+	Payload    string       `json:"payload"`
+	Attempts   int          `json:"attempts"`
+	LastError  string       `json:"last_error"`
+	CreatedAt  time.Time    `json:"created_at"`
+}
+
+// webhookDispatcher queues QSO events for delivery to webhooks. Events are queued without
+// blocking the publisher and handed by background workers to the delivery pool, one delivery per
+// webhook, or to the job runner when the pool cannot take them.
+type webhookDispatcher struct {
+	queue          chan qsoEvent
+	client         *http.Client
+	workers        int
+	maxAttempts    int
+	retryBaseDelay time.Duration
+	retryMaxDelay  time.Duration
+}
+
+func newWebhookDispatcher() *webhookDispatcher {
+	return &webhookDispatcher{
+		queue:          make(chan qsoEvent, defaultWebhookQueueSize),
+		client:         &http.Client{Timeout: defaultWebhookRequestTimeout},
+		workers:        defaultWebhookWorkers,
+		maxAttempts:    defaultWebhookMaxAttempts,
+		retryBaseDelay: defaultWebhookRetryBaseDelay,
+		retryMaxDelay:  defaultWebhookRetryMaxDelay,
+	}
+}
+
+// retryDelay returns the exponential backoff before the given retry (1 for the first retry).
+func (d *webhookDispatcher) retryDelay(retry int) time.Duration {
+	delay := d.retryBaseDelay
+	for i := 1; i < retry && delay < d.retryMaxDelay; i++ {
+		delay *= 2
+	}
+	if delay > d.retryMaxDelay {
+		delay = d.retryMaxDelay
+	}
+	return delay
+}
+
+// enqueueWebhookEvent queues the event for delivery. It is registered with the QSO event
+// broker and must not block; if the queue is full the event is dropped and logged.
+func (s *Service) enqueueWebhookEvent(event qsoEvent) {
+	select {
+	case s.webhooks.queue <- event:
+	default:
+		s.logger.ErrorWith().Uint64("event_id", event.ID).Int64("logbook_id", event.LogbookID).Msg("Webhook queue full; event dropped")
+	}
+}
+
+// runWebhookWorker delivers queued events until ctx is cancelled.
+func (s *Service) runWebhookWorker(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case event := <-s.webhooks.queue:
+			s.dispatchWebhookEvent(ctx, event)
+		}
+	}
+}
+
+// webhookDelivery is a delivery to a webhook, and the payload of a webhook delivery job.
+type webhookDelivery struct {
+	WebhookID  int64        `json:"webhook_id"`
+	DeliveryID string       `json:"delivery_id"`
+	EventType  qsoEventType `json:"event_type"`
+	Body       string       `json:"body"`
+	// Attempts counts the attempts the delivery pool made before handing the delivery back.
+	Attempts int `json:"attempts,omitempty"`
+}
+
+// dispatchWebhookEvent queues a delivery for each of the logbook's webhooks that want the event.
+func (s *Service) dispatchWebhookEvent(ctx context.Context, event qsoEvent) {
+	const op errors.Op = "server.Service.dispatchWebhookEvent"
+
+	hooks, err := s.listWebhooks(ctx, event.LogbookID)
+	if err != nil {
+		err = errors.New(op).Err(err)
+		s.logger.ErrorWith().Err(err).Uint64("event_id", event.ID).Msg("Failed to list webhooks; event dropped")
+		return
+	}
+	if len(hooks) == 0 {
+		return
+	}
+
+	body, err := json.Marshal(event)
+	if err != nil {
+		err = errors.New(op).Err(err)
+		s.logger.ErrorWith().Err(err).Uint64("event_id", event.ID).Msg("Failed to marshal webhook payload")
+		return
+	}
+
+	deliveryID := fmt.Sprintf("%s-%d", s.instanceID, event.ID)
+	for _, hook := range hooks {
+		if !hook.wants(event.Type) {
+			continue
+		}
+		delivery := webhookDelivery{WebhookID: hook.ID, DeliveryID: deliveryID, EventType: event.Type, Body: string(body)}
+		if s.deliveries.submit(s.webhookDeliveryTask(event.LogbookID, hook, delivery)) {
+			continue
+		}
+		s.enqueueWebhookDeliveryJob(ctx, event.LogbookID, delivery)
+	}
+}
+
+// enqueueWebhookDeliveryJob queues a delivery job for a delivery the delivery pool cannot take,
+// because there is none, the webhook's queue is full or the pool has stopped.
+func (s *Service) enqueueWebhookDeliveryJob(ctx context.Context, logbookID int64, delivery webhookDelivery) {
+	const op errors.Op = "server.Service.enqueueWebhookDeliveryJob"
+
+	if _, _, err := s.enqueueJob(ctx, newJob{Kind: jobKindWebhookDelivery, LogbookID: logbookID, Payload: delivery, MaxAttempts: s.webhooks.maxAttempts}); err != nil {
+		err = errors.New(op).Err(err)
+		s.logger.ErrorWith().Err(err).Int64("webhook_id", delivery.WebhookID).Str("delivery_id", delivery.DeliveryID).Msg("Failed to queue webhook delivery; event dropped")
+	}
+}
+
+// webhookDeliveryTask returns the delivery as a task of the delivery pool. It is retried with the
+// dispatcher's backoff, dead-lettered once it has failed for the last time, and queued as a job
+// if it is still queued when the server stops.
+func (s *Service) webhookDeliveryTask(logbookID int64, hook webhook, delivery webhookDelivery) *deliveryTask {
+	body := []byte(delivery.Body)
+	return &deliveryTask{
+		destination: webhookDestination(hook.URL),
+		attempts:    delivery.Attempts,
+		maxAttempts: s.webhooks.maxAttempts,
+		retryDelay:  s.webhooks.retryDelay,
+		run: func(ctx context.Context, attempt int) error {
+			retryable, err := s.postWebhook(ctx, hook, delivery.EventType, delivery.DeliveryID, body)
+			if err != nil {
+				s.logger.WarnWith().Err(err).Int64("webhook_id", hook.ID).Str("delivery_id", delivery.DeliveryID).Int("attempt", attempt).Msg("Webhook delivery failed")
+				if !retryable {
+					return permanentJobFailure(err)
+				}
+			}
+			return err
+		},
+		abandoned: func(attempts int, err error) {
+			s.deadLetterWebhook(hook, delivery.DeliveryID, delivery.EventType, body, attempts, err)
+		},
+		handBack: func(ctx context.Context, attempts int) {
+			delivery.Attempts = attempts
+			s.enqueueWebhookDeliveryJob(ctx, logbookID, delivery)
+		},
+	}
+}
+
+// runWebhookDeliveryJob runs a webhook delivery queued as a job. With a delivery pool, the job
+// hands the delivery to the pool and fails, to be retried with the dispatcher's backoff, while the
+// webhook's queue is full. Without one, the job makes one attempt itself; the job runner retries
+// failures, and a failure that is not worth retrying fails the job at once.
+func (s *Service) runWebhookDeliveryJob(ctx context.Context, j job) (any, error) {
+	const op errors.Op = "server.Service.runWebhookDeliveryJob"
+
+	var delivery webhookDelivery
+	if err := j.decodePayload(&delivery); err != nil {
+		return nil, permanentJobFailure(errors.New(op).Err(err))
+	}
+	hooks, err := s.listWebhooks(ctx, j.LogbookID)
+	if err != nil {
+		return nil, errors.New(op).Err(err)
+	}
+	for _, hook := range hooks {
+		if hook.ID != delivery.WebhookID {
+			continue
+		}
+		if s.deliveries != nil {
+			if !s.deliveries.submit(s.webhookDeliveryTask(j.LogbookID, hook, delivery)) {
+				return nil, errors.New(op).Msg("Delivery pool cannot take the webhook delivery")
+			}
+			return nil, nil
+		}
+		retryable, err := s.postWebhook(ctx, hook, delivery.EventType, delivery.DeliveryID, []byte(delivery.Body))
+		if err != nil {
+			s.logger.WarnWith().Err(err).Int64("webhook_id", hook.ID).Str("delivery_id", delivery.DeliveryID).Int("attempt", j.Attempts).Msg("Webhook delivery failed")
+			if !retryable {
+				return nil, permanentJobFailure(err)
+			}
+			return nil, err
+		}
+		return nil, nil
+	}
+	// The webhook was deleted since the event; there is nobody to deliver to.
+	return nil, nil
+}
+
+// abandonWebhookDeliveryJob dead-letters a delivery whose job has failed for the last time.
+func (s *Service) abandonWebhookDeliveryJob(_ context.Context, j job, err error) {
+	var delivery webhookDelivery
+	if decodeErr := j.decodePayload(&delivery); decodeErr != nil {
+		s.logger.ErrorWith().Err(decodeErr).Int64("job_id", j.ID).Msg("Failed to decode abandoned webhook delivery")
+		return
+	}
+	s.deadLetterWebhook(webhook{ID: delivery.WebhookID}, delivery.DeliveryID, delivery.EventType, []byte(delivery.Body), j.Attempts, err)
+}
+
+// postWebhook makes a single delivery attempt. It reports whether a failure is worth retrying:
+// network errors, timeouts, 408, 429 and 5xx are; any other non-2xx status is not.
+func (s *Service) postWebhook(ctx context.Context, hook webhook, eventType qsoEventType, deliveryID string, body []byte) (bool, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, hook.URL, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", webhookUserAgent)
+	req.Header.Set(headerWebhookEvent, string(eventType))
+	req.Header.Set(headerWebhookDelivery, deliveryID)
+	req.Header.Set(headerWebhookTimestamp, timestamp)
+	req.Header.Set(headerWebhookSignature, signWebhookPayload(hook.Secret, timestamp, body))
+
+	resp, err := s.webhooks.client.Do(req)
+	if err != nil {
+		return true, err
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		_, _ = io.Copy(io.Discard, resp.Body)
+		return false, nil
+	}
+
+	snippet, _ := io.ReadAll(io.LimitReader(resp.Body, webhookMaxResponseBytes))
+	err = fmt.Errorf("unexpected status %d: %s", resp.StatusCode, strings.TrimSpace(string(snippet)))
+	retryable := resp.StatusCode == http.StatusRequestTimeout ||
+		resp.StatusCode == http.StatusTooManyRequests ||
+		resp.StatusCode >= 500
+	return retryable, err
+}
+
+// signWebhookPayload returns the X-SM-Signature header value for the payload.
+func signWebhookPayload(secret, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// deadLetterWebhook records an abandoned delivery. It does not use the worker's context, which
+// may already be cancelled by shutdown.
+func (s *Service) deadLetterWebhook(hook webhook, deliveryID string, eventType qsoEventType, body []byte, attempts int, cause error) {
+	const op errors.Op = "server.Service.deadLetterWebhook"
+
+	lastError := emptyString
+	if cause != nil {
+		lastError = cause.Error()
+	}
+	s.logger.ErrorWith().Int64("webhook_id", hook.ID).Str("delivery_id", deliveryID).Int("attempts", attempts).Str("last_error", lastError).Msg("Webhook delivery abandoned")
+
+	ctx, cancel := context.WithTimeout(context.Background(), defaultWebhookRequestTimeout)
+	defer cancel()
+
+	if _, err := s.db.ExecContext(ctx, `INSERT INTO webhook_dead_letters (webhook_id, delivery_id, event_type, payload, attempts, last_error)
+		VALUES ($1, $2, $3, $4, $5, $6)`, hook.ID, deliveryID, string(eventType), string(body), attempts, lastError); err != nil {
+		err = errors.New(op).Err(err)
+		s.logger.ErrorWith().Err(err).Int64("webhook_id", hook.ID).Str("delivery_id", deliveryID).Msg("Failed to record webhook dead letter")
+	}
+}
+
+// newWebhookSecret returns a random signing secret for webhooks registered without one.
+func newWebhookSecret() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return emptyString, err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// insertWebhook stores a new webhook and returns it with its ID and creation time.
+func (s *Service) insertWebhook(ctx context.Context, hook webhook) (webhook, error) {
+	const op errors.Op = "server.Service.insertWebhook"
+
+	rows, err := s.db.QueryContext(ctx, `INSERT INTO webhooks (logbook_id, url, secret, events) VALUES ($1, $2, $3, $4)
+		RETURNING id, created_at`, hook.LogbookID, hook.URL, hook.Secret, joinWebhookEvents(hook.Events))
+	if err != nil {
+		return webhook{}, errors.New(op).Err(err)
+	}
+	defer func() { _ = rows.Close() }()
+
+	if !rows.Next() {
+		if err = rows.Err(); err == nil {
+			err = errors.New(op).Msg("No row returned")
+		}
+		return webhook{}, errors.New(op).Err(err)
+	}
+	if err = rows.Scan(&hook.ID, &hook.CreatedAt); err != nil {
+		return webhook{}, errors.New(op).Err(err)
+	}
+
+	return hook, nil
+}
+
+// listWebhooks returns the logbook's webhooks, oldest first.
+func (s *Service) listWebhooks(ctx context.Context, logbookID int64) ([]webhook, error) {
+	const op errors.Op = "server.Service.listWebhooks"
+
+	rows, err := s.db.QueryContext(ctx, `SELECT id, logbook_id, url, secret, events, created_at FROM webhooks
+		WHERE logbook_id = $1 ORDER BY id`, logbookID)
+	if err != nil {
+		return nil, errors.New(op).Err(err)
+	}
+	defer func() { _ = rows.Close() }()
+
+	hooks := make([]webhook, 0)
+	for rows.Next() {
+		var hook webhook
+		var events string
+		if err = rows.Scan(&hook.ID, &hook.LogbookID, &hook.URL, &hook.Secret, &events, &hook.CreatedAt); err != nil {
+			return nil, errors.New(op).Err(err)
+		}
+		hook.Events = splitWebhookEvents(events)
+		hooks = append(hooks, hook)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, errors.New(op).Err(err)
+	}
+
+	return hooks, nil
+}
+
+// deleteWebhook removes the webhook if it belongs to the logbook and reports whether it existed.
+func (s *Service) deleteWebhook(ctx context.Context, logbookID, id int64) (bool, error) {
+	const op errors.Op = "server.Service.deleteWebhook"
+
+	// Dead letters are removed explicitly because SQLite only enforces ON DELETE CASCADE
+	// when foreign keys are switched on for the connection.
+	if _, err := s.db.ExecContext(ctx, `DELETE FROM webhook_dead_letters WHERE webhook_id IN
+		(SELECT id FROM webhooks WHERE id = $1 AND logbook_id = $2)`, id, logbookID); err != nil {
+		return false, errors.New(op).Err(err)
+	}
+
+	res, err := s.db.ExecContext(ctx, `DELETE FROM webhooks WHERE id = $1 AND logbook_id = $2`, id, logbookID)
+	if err != nil {
+		return false, errors.New(op).Err(err)
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return false, errors.New(op).Err(err)
+	}
+
+	return n > 0, nil
+}
+
+// listWebhookDeadLetters returns the most recent dead letters for the logbook's webhooks, newest first.
+func (s *Service) listWebhookDeadLetters(ctx context.Context, logbookID int64, limit int) ([]webhookDeadLetter, error) {
+	const op errors.Op = "server.Service.listWebhookDeadLetters"
+
+	rows, err := s.db.QueryContext(ctx, `SELECT d.id, d.webhook_id, d.delivery_id, d.event_type, d.payload, d.attempts, d.last_error, d.created_at
+		FROM webhook_dead_letters d JOIN webhooks w ON w.id = d.webhook_id
+		WHERE w.logbook_id = $1 ORDER BY d.id DESC LIMIT $2`, logbookID, limit)
+	if err != nil {
+		return nil, errors.New(op).Err(err)
+	}
+	defer func() { _ = rows.Close() }()
+
+	letters := make([]webhookDeadLetter, 0)
+	for rows.Next() {
+		var l webhookDeadLetter
+		var eventType string
+		if err = rows.Scan(&l.ID, &l.WebhookID, &l.DeliveryID, &eventType, &l.Payload, &l.Attempts, &l.LastError, &l.CreatedAt); err != nil {
+			return nil, errors.New(op).Err(err)
+		}
+		l.EventType = qsoEventType(eventType)
+		letters = append(letters, l)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, errors.New(op).Err(err)
+	}
+
+	return letters, nil
+}
+
+func joinWebhookEvents(events []qsoEventType) string {
+	parts := make([]string, len(events))
+	for i, e := range events {
+		parts[i] = string(e)
+	}
+	return strings.Join(parts, ",")
+}
+
+func splitWebhookEvents(events string) []qsoEventType {
+	result := make([]qsoEventType, 0)
+	for _, e := range strings.Split(events, ",") {
+		if e != emptyString {
+			result = append(result, qsoEventType(e))
+		}
+	}
+	return result
+}
@@ -0,0 +1,143 @@
+package service
+
+import (
+	"net/url"
+	"strconv"
+
+	"github.com/Station-Manager/errors"
+	"github.com/gofiber/fiber/v2"
+)
+
+const (
+	maxWebhooksPerLogbook    = 10
+	webhookDeadLetterListMax = 100
+)
+
+// webhookRequest is the body of a request to register a webhook.
+type webhookRequest struct {
+	URL string `json:"url" validate:"required,url,max=2048"`
+	// Secret signs deliveries; one is generated when it is omitted.
+	Secret string   `json:"secret" validate:"omitempty,min=16,max=256"`
+	Events []string `json:"events" validate:"omitempty,dive,oneof=qso.inserted qso.updated qso.deleted qso.restored spot.needed"`
+}
+
+// listWebhooksHandler returns the webhooks registered for the authenticated logbook. Secrets are
+// never returned.
+func (s *Service) listWebhooksHandler(c *fiber.Ctx) error {
+	const op errors.Op = "server.Service.listWebhooksHandler"
+
+	logbook, err := authenticatedLogbook(c)
+	if err != nil {
+		s.logger.ErrorWith().Err(errors.New(op).Err(err)).Msg("authenticatedLogbook failed")
+		return c.Status(fiber.StatusInternalServerError).JSON(jsonInternalError)
+	}
+
+	hooks, err := s.listWebhooks(c.UserContext(), logbook.ID)
+	if err != nil {
+		s.logger.ErrorWith().Err(errors.New(op).Err(err)).Msg("s.listWebhooks failed")
+		return s.dbFailure(c, err)
+	}
+
+	return c.JSON(fiber.Map{"webhooks": hooks})
+}
+
+// createWebhookHandler registers a webhook for the authenticated logbook. The signing secret is
+// only ever returned in this response.
+func (s *Service) createWebhookHandler(c *fiber.Ctx) error {
+	const op errors.Op = "server.Service.createWebhookHandler"
+
+	logbook, err := authenticatedLogbook(c)
+	if err != nil {
+		s.logger.ErrorWith().Err(errors.New(op).Err(err)).Msg("authenticatedLogbook failed")
+		return c.Status(fiber.StatusInternalServerError).JSON(jsonInternalError)
+	}
+
+	var request webhookRequest
+	if err = c.BodyParser(&request); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(jsonBadRequest)
+	}
+	if err = s.validate.Struct(request); err != nil {
+		s.logger.InfoWith().Err(err).Msg("Webhook validation failed")
+		return c.Status(fiber.StatusBadRequest).JSON(jsonBadRequest)
+	}
+	if u, _ := url.Parse(request.URL); u == nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == emptyString {
+		return c.Status(fiber.StatusBadRequest).JSON(jsonError(errCodeInvalidWebhookURL, "Webhook URL must be an absolute http or https URL"))
+	}
+
+	existing, err := s.listWebhooks(c.UserContext(), logbook.ID)
+	if err != nil {
+		s.logger.ErrorWith().Err(errors.New(op).Err(err)).Msg("s.listWebhooks failed")
+		return s.dbFailure(c, err)
+	}
+	if len(existing) >= maxWebhooksPerLogbook {
+		return c.Status(fiber.StatusConflict).JSON(jsonError(errCodeWebhookLimit, "Webhook limit reached"))
+	}
+
+	hook := webhook{LogbookID: logbook.ID, URL: request.URL, Secret: request.Secret}
+	if hook.Secret == emptyString {
+		if hook.Secret, err = newWebhookSecret(); err != nil {
+			s.logger.ErrorWith().Err(errors.New(op).Err(err)).Msg("newWebhookSecret failed")
+			return c.Status(fiber.StatusInternalServerError).JSON(jsonInternalError)
+		}
+	}
+	for _, e := range request.Events {
+		hook.Events = append(hook.Events, qsoEventType(e))
+	}
+
+	if hook, err = s.insertWebhook(c.UserContext(), hook); err != nil {
+		s.logger.ErrorWith().Err(errors.New(op).Err(err)).Msg("s.insertWebhook failed")
+		return s.dbFailure(c, err)
+	}
+	if hook.Events == nil {
+		hook.Events = make([]qsoEventType, 0)
+	}
+
+	return c.Status(fiber.StatusCreated).JSON(fiber.Map{"webhook": hook, "secret": hook.Secret})
+}
+
+// deleteWebhookHandler removes one of the authenticated logbook's webhooks.
+func (s *Service) deleteWebhookHandler(c *fiber.Ctx) error {
+	const op errors.Op = "server.Service.deleteWebhookHandler"
+
+	logbook, err := authenticatedLogbook(c)
+	if err != nil {
+		s.logger.ErrorWith().Err(errors.New(op).Err(err)).Msg("authenticatedLogbook failed")
+		return c.Status(fiber.StatusInternalServerError).JSON(jsonInternalError)
+	}
+
+	id, err := strconv.ParseInt(c.Params("id"), 10, 64)
+	if err != nil || id <= 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(jsonBadRequest)
+	}
+
+	found, err := s.deleteWebhook(c.UserContext(), logbook.ID, id)
+	if err != nil {
+		s.logger.ErrorWith().Err(errors.New(op).Err(err)).Msg("s.deleteWebhook failed")
+		return s.dbFailure(c, err)
+	}
+	if !found {
+		return c.Status(fiber.StatusNotFound).JSON(jsonError(errCodeWebhookNotFound, "Webhook not found"))
+	}
+
+	return c.SendStatus(fiber.StatusNoContent)
+}
+
+// listWebhookDeadLettersHandler returns the most recent abandoned deliveries for the
+// authenticated logbook's webhooks.
+func (s *Service) listWebhookDeadLettersHandler(c *fiber.Ctx) error {
+	const op errors.Op = "server.Service.listWebhookDeadLettersHandler"
+
+	logbook, err := authenticatedLogbook(c)
+	if err != nil {
+		s.logger.ErrorWith().Err(errors.New(op).Err(err)).Msg("authenticatedLogbook failed")
+		return c.Status(fiber.StatusInternalServerError).JSON(jsonInternalError)
+	}
+
+	letters, err := s.listWebhookDeadLetters(c.UserContext(), logbook.ID, webhookDeadLetterListMax)
+	if err != nil {
+		s.logger.ErrorWith().Err(errors.New(op).Err(err)).Msg("s.listWebhookDeadLetters failed")
+		return s.dbFailure(c, err)
+	}
+
+	return c.JSON(fiber.Map{"dead_letters": letters})
+}
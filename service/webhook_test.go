@@ -0,0 +1,245 @@
+package service
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/goccy/go-json"
+	"github.com/gofiber/fiber/v2"
+)
+
+func TestMigrateServerSchema_IsIdempotent(t *testing.T) {
+	svc := newTestServer(t)
+
+	if err := svc.migrateServerSchema(context.Background()); err != nil {
+		t.Fatalf("second migrateServerSchema failed: %v", err)
+	}
+	version, err := svc.serverSchemaVersion(context.Background())
+	if err != nil {
+		t.Fatalf("serverSchemaVersion failed: %v", err)
+	}
+	if want := serverMigrations[len(serverMigrations)-1].version; version != want {
+		t.Errorf("expected schema version %d, got %d", want, version)
+	}
+}
+
+func TestSignWebhookPayload(t *testing.T) {
+	// printf '1700000000.{}' | openssl dgst -sha256 -hmac secret
+	const want = "sha256=b8569b78799ff9e3cbff0fc2d63a33a2b57f3282abd07c37ae5e8e7d79a5f163"
+	if got := signWebhookPayload("secret", "1700000000", []byte("{}")); got != want {
+		t.Errorf("expected %q, got %q", want, got)
+	}
+}
+
+func TestWebhookRetryDelay(t *testing.T) {
+	d := &webhookDispatcher{retryBaseDelay: time.Second, retryMaxDelay: 5 * time.Second}
+	want := []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 5 * time.Second, 5 * time.Second}
+	for i, w := range want {
+		if got := d.retryDelay(i + 1); got != w {
+			t.Errorf("retry %d: expected %v, got %v", i+1, w, got)
+		}
+	}
+}
+
+func TestDispatchWebhookEvent_SignsAndRetries(t *testing.T) {
+	svc := newTestServer(t)
+
+	var calls atomic.Int32
+	var gotSignature, gotTimestamp, gotEvent string
+	var gotBody []byte
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if calls.Add(1) == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		gotSignature = r.Header.Get(headerWebhookSignature)
+		gotTimestamp = r.Header.Get(headerWebhookTimestamp)
+		gotEvent = r.Header.Get(headerWebhookEvent)
+		gotBody, _ = io.ReadAll(r.Body)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer receiver.Close()
+
+	hook, err := svc.insertWebhook(context.Background(), webhook{LogbookID: 1, URL: receiver.URL, Secret: "0123456789abcdef"})
+	if err != nil {
+		t.Fatalf("insertWebhook failed: %v", err)
+	}
+
+	svc.dispatchWebhookEvent(context.Background(), qsoEvent{ID: 7, Type: qsoEventInserted, LogbookID: 1})
+	runDueJobs(t, svc)
+
+	if calls.Load() != 2 {
+		t.Fatalf("expected 2 delivery attempts, got %d", calls.Load())
+	}
+	if gotEvent != string(qsoEventInserted) {
+		t.Errorf("expected event header %q, got %q", qsoEventInserted, gotEvent)
+	}
+	if want := signWebhookPayload(hook.Secret, gotTimestamp, gotBody); gotSignature != want {
+		t.Errorf("signature mismatch: got %q want %q", gotSignature, want)
+	}
+
+	letters, err := svc.listWebhookDeadLetters(context.Background(), 1, 10)
+	if err != nil {
+		t.Fatalf("listWebhookDeadLetters failed: %v", err)
+	}
+	if len(letters) != 0 {
+		t.Errorf("expected no dead letters after a successful retry, got %d", len(letters))
+	}
+}
+
+func TestDispatchWebhookEvent_DeadLetters(t *testing.T) {
+	svc := newTestServer(t)
+	svc.webhooks.maxAttempts = 3
+
+	var calls atomic.Int32
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer failing.Close()
+	rejecting := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		w.WriteHeader(http.StatusGone)
+	}))
+	defer rejecting.Close()
+
+	for _, u := range []string{failing.URL, rejecting.URL} {
+		if _, err := svc.insertWebhook(context.Background(), webhook{LogbookID: 1, URL: u, Secret: "0123456789abcdef"}); err != nil {
+			t.Fatalf("insertWebhook failed: %v", err)
+		}
+	}
+
+	svc.dispatchWebhookEvent(context.Background(), qsoEvent{ID: 1, Type: qsoEventInserted, LogbookID: 1})
+	runDueJobs(t, svc)
+
+	// Three attempts for the 502s, one for the permanent 410.
+	if calls.Load() != 4 {
+		t.Errorf("expected 4 delivery attempts, got %d", calls.Load())
+	}
+
+	letters, err := svc.listWebhookDeadLetters(context.Background(), 1, 10)
+	if err != nil {
+		t.Fatalf("listWebhookDeadLetters failed: %v", err)
+	}
+	if len(letters) != 2 {
+		t.Fatalf("expected 2 dead letters, got %d", len(letters))
+	}
+	attempts := map[int]bool{}
+	for _, l := range letters {
+		attempts[l.Attempts] = true
+		if l.DeliveryID != "test-1" || l.EventType != qsoEventInserted || l.Payload == emptyString {
+			t.Errorf("unexpected dead letter: %+v", l)
+		}
+	}
+	if !attempts[1] || !attempts[3] {
+		t.Errorf("expected dead letters after 1 and 3 attempts, got %+v", letters)
+	}
+}
+
+func TestDispatchWebhookEvent_FiltersEventTypes(t *testing.T) {
+	svc := newTestServer(t)
+
+	var calls atomic.Int32
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+	}))
+	defer receiver.Close()
+
+	if _, err := svc.insertWebhook(context.Background(), webhook{LogbookID: 1, URL: receiver.URL, Secret: "0123456789abcdef", Events: []qsoEventType{qsoEventDeleted}}); err != nil {
+		t.Fatalf("insertWebhook failed: %v", err)
+	}
+
+	svc.dispatchWebhookEvent(context.Background(), qsoEvent{ID: 1, Type: qsoEventInserted, LogbookID: 1})
+	svc.dispatchWebhookEvent(context.Background(), qsoEvent{ID: 2, Type: qsoEventInserted, LogbookID: 2})
+	runDueJobs(t, svc)
+	if calls.Load() != 0 {
+		t.Fatalf("expected no deliveries, got %d", calls.Load())
+	}
+
+	svc.dispatchWebhookEvent(context.Background(), qsoEvent{ID: 3, Type: qsoEventDeleted, LogbookID: 1})
+	runDueJobs(t, svc)
+	if calls.Load() != 1 {
+		t.Errorf("expected 1 delivery, got %d", calls.Load())
+	}
+}
+
+func TestWebhookHandlers(t *testing.T) {
+	svc := newTestServer(t)
+	app := fiber.New()
+	app.Get("/webhooks", withLogbook(1, svc.listWebhooksHandler))
+	app.Post("/webhooks", withLogbook(1, svc.createWebhookHandler))
+	app.Delete("/webhooks/:id", withLogbook(1, svc.deleteWebhookHandler))
+	app.Delete("/other/webhooks/:id", withLogbook(2, svc.deleteWebhookHandler))
+
+	do := func(method, target, body string) *http.Response {
+		t.Helper()
+		req := httptest.NewRequest(method, target, strings.NewReader(body))
+		req.Header.Set(fiber.HeaderContentType, fiber.MIMEApplicationJSON)
+		resp, err := app.Test(req)
+		if err != nil {
+			t.Fatalf("fiber test request failed: %v", err)
+		}
+		return resp
+	}
+
+	if resp := do("POST", "/webhooks", `{"url":"ftp://example.com/hook"}`); resp.StatusCode != fiber.StatusBadRequest {
+		t.Errorf("expected non-http URL to be rejected, got %d", resp.StatusCode)
+	}
+	if resp := do("POST", "/webhooks", `{"url":"https://example.com/hook","events":["qso.exploded"]}`); resp.StatusCode != fiber.StatusBadRequest {
+		t.Errorf("expected unknown event type to be rejected, got %d", resp.StatusCode)
+	}
+
+	resp := do("POST", "/webhooks", `{"url":"https://example.com/hook","events":["qso.inserted"]}`)
+	if resp.StatusCode != fiber.StatusCreated {
+		t.Fatalf("expected status %d, got %d", fiber.StatusCreated, resp.StatusCode)
+	}
+	var created struct {
+		Webhook webhook `json:"webhook"`
+		Secret  string  `json:"secret"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&created); err != nil {
+		t.Fatalf("decode failed: %v", err)
+	}
+	if created.Webhook.ID == 0 || len(created.Secret) != 64 {
+		t.Fatalf("expected an ID and a generated secret, got %+v", created)
+	}
+
+	resp = do("GET", "/webhooks", emptyString)
+	body, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != fiber.StatusOK || !strings.Contains(string(body), "https://example.com/hook") {
+		t.Fatalf("unexpected list response %d: %s", resp.StatusCode, body)
+	}
+	if strings.Contains(string(body), created.Secret) {
+		t.Error("expected the secret not to be listed")
+	}
+
+	id := "/" + strconv.FormatInt(created.Webhook.ID, 10)
+	if resp = do("DELETE", "/other/webhooks"+id, emptyString); resp.StatusCode != fiber.StatusNotFound {
+		t.Errorf("expected another logbook's webhook to be invisible, got %d", resp.StatusCode)
+	}
+	if resp = do("DELETE", "/webhooks"+id, emptyString); resp.StatusCode != fiber.StatusNoContent {
+		t.Errorf("expected status %d, got %d", fiber.StatusNoContent, resp.StatusCode)
+	}
+	if resp = do("DELETE", "/webhooks"+id, emptyString); resp.StatusCode != fiber.StatusNotFound {
+		t.Errorf("expected status %d after delete, got %d", fiber.StatusNotFound, resp.StatusCode)
+	}
+}
+
+func TestWebhookRoutes_RequireApiKey(t *testing.T) {
+	svc := newTestServer(t)
+
+	resp, err := svc.app.Test(httptest.NewRequest("GET", "/webhooks", nil))
+	if err != nil {
+		t.Fatalf("fiber test request failed: %v", err)
+	}
+	if resp.StatusCode != fiber.StatusUnauthorized {
+		t.Fatalf("expected status %d, got %d", fiber.StatusUnauthorized, resp.StatusCode)
+	}
+}
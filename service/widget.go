@@ -0,0 +1,100 @@
+package service
+
+import (
+	"bytes"
+	"strings"
+
+	"github.com/Station-Manager/errors"
+	"github.com/Station-Manager/server/service/frontend"
+	"github.com/gofiber/fiber/v2"
+)
+
+const (
+	// widgetQsosDefault is the number of QSOs a widget lists unless n says otherwise; at most
+	// shareRecentQsosMax are listed.
+	widgetQsosDefault = 10
+
+	widgetFormatHTML = "html"
+	widgetFormatJSON = "json"
+
+	// widgetPrivateCacheControl lets only the caller cache a widget fetched with an API key.
+	widgetPrivateCacheControl = "private, max-age=60"
+)
+
+// widgetQso is a QSO as a widget lists it, with its date and time ready to show.
+type widgetQso struct {
+	Date string
+	Time string
+	Call string
+	Band string
+	Mode string
+}
+
+// widgetData is what the widget's template is executed with.
+type widgetData struct {
+	Callsign string
+	Qsos     []widgetQso
+}
+
+// widgetQsoOf formats a shared QSO for the widget.
+func widgetQsoOf(q sharedQso) widgetQso {
+	w := widgetQso{Date: q.QsoDate, Time: q.TimeOn, Call: strings.ToUpper(q.Call), Band: q.Band, Mode: q.Mode}
+	if len(q.QsoDate) == 8 {
+		w.Date = q.QsoDate[:4] + "-" + q.QsoDate[4:6] + "-" + q.QsoDate[6:]
+	}
+	if len(q.TimeOn) >= 4 {
+		w.Time = q.TimeOn[:2] + ":" + q.TimeOn[2:4]
+	}
+	return w
+}
+
+// qsoWidgetHandler returns the logbook's most recent QSOs, ten unless the n query parameter says
+// otherwise, as a small HTML page to show in an iframe or, with format=json, as JSON. Widgets shown
+// through a share link may be cached by anyone; those fetched with an API key only by the caller.
+func (s *Service) qsoWidgetHandler(shared bool) fiber.Handler {
+	const op errors.Op = "server.Service.qsoWidgetHandler"
+
+	cacheControl := widgetPrivateCacheControl
+	if shared {
+		cacheControl = shareCacheControl
+	}
+
+	return func(c *fiber.Ctx) error {
+		logbook, err := authenticatedLogbook(c)
+		if err != nil {
+			s.logger.ErrorWith().Err(errors.New(op).Err(err)).Msg("authenticatedLogbook failed")
+			return c.Status(fiber.StatusInternalServerError).JSON(jsonInternalError)
+		}
+		n := c.QueryInt("n", widgetQsosDefault)
+		if n <= 0 {
+			return c.Status(fiber.StatusBadRequest).JSON(jsonBadRequest)
+		}
+		format := strings.ToLower(c.Query("format", widgetFormatHTML))
+		if format != widgetFormatHTML && format != widgetFormatJSON {
+			return c.Status(fiber.StatusBadRequest).JSON(jsonError(errCodeBadRequest, "format must be html or json"))
+		}
+
+		qsos, err := s.listRecentQsos(c.UserContext(), logbook.ID, min(n, shareRecentQsosMax))
+		if err != nil {
+			s.logger.ErrorWith().Err(errors.New(op).Err(err)).Msg("s.listRecentQsos failed")
+			return s.dbFailure(c, err)
+		}
+
+		c.Set(fiber.HeaderCacheControl, cacheControl)
+		if format == widgetFormatJSON {
+			return c.JSON(fiber.Map{"callsign": logbook.Callsign, "qsos": qsos})
+		}
+
+		data := widgetData{Callsign: logbook.Callsign, Qsos: make([]widgetQso, len(qsos))}
+		for i, q := range qsos {
+			data.Qsos[i] = widgetQsoOf(q)
+		}
+		var page bytes.Buffer
+		if err = frontend.WidgetTemplate().Execute(&page, data); err != nil {
+			s.logger.ErrorWith().Err(errors.New(op).Err(err)).Msg("WidgetTemplate.Execute failed")
+			return c.Status(fiber.StatusInternalServerError).JSON(jsonInternalError)
+		}
+		c.Set(fiber.HeaderContentType, fiber.MIMETextHTMLCharsetUTF8)
+		return c.Send(page.Bytes())
+	}
+}
@@ -0,0 +1,77 @@
+package service
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/goccy/go-json"
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/middleware/etag"
+)
+
+func TestQsoWidgetHandler(t *testing.T) {
+	svc := newTestServer(t)
+	ctx := context.Background()
+	insertTestQso(t, svc, "JA1XX", "20m", "FT8", "20240430", "1203")
+	insertTestQso(t, svc, "<i>dl1ab", "40m", "CW", "20240502", "2210")
+	insertTestQso(t, svc, "K1AB", "20m", "SSB", "20240501", "0815")
+
+	token, hash, err := newShareToken()
+	if err != nil {
+		t.Fatalf("newShareToken failed: %v", err)
+	}
+	if _, err = svc.db.ExecContext(ctx, `INSERT INTO share_links (logbook_id, token_hash) VALUES (1, $1)`, hash); err != nil {
+		t.Fatalf("setup failed: %v", err)
+	}
+
+	app := fiber.New()
+	app.Get("/share/:token/widget", svc.shareTokenMiddleware(), etag.New(), svc.qsoWidgetHandler(true))
+	get := func(query, etag string) (*http.Response, string) {
+		req := httptest.NewRequest(fiber.MethodGet, "/share/"+token+"/widget"+query, nil)
+		if etag != "" {
+			req.Header.Set(fiber.HeaderIfNoneMatch, etag)
+		}
+		resp, err := app.Test(req)
+		if err != nil {
+			t.Fatalf("GET %s failed: %v", query, err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		return resp, string(body)
+	}
+
+	resp, page := get("?n=2", "")
+	if resp.StatusCode != fiber.StatusOK || !strings.HasPrefix(resp.Header.Get(fiber.HeaderContentType), fiber.MIMETextHTML) {
+		t.Fatalf("expected an HTML page, got %d %q", resp.StatusCode, resp.Header.Get(fiber.HeaderContentType))
+	}
+	if resp.Header.Get(fiber.HeaderCacheControl) != shareCacheControl {
+		t.Errorf("expected the shared widget to be publicly cacheable, got %q", resp.Header.Get(fiber.HeaderCacheControl))
+	}
+	if !strings.Contains(page, "&lt;I&gt;DL1AB") || strings.Contains(page, "<I>") || !strings.Contains(page, "2024-05-02") || !strings.Contains(page, "22:10") {
+		t.Errorf("expected the newest QSO escaped and formatted, got %s", page)
+	}
+	if !strings.Contains(page, "K1AB") || strings.Contains(page, "JA1XX") {
+		t.Errorf("expected only the two newest QSOs, got %s", page)
+	}
+
+	if resp, _ = get("?n=2", resp.Header.Get(fiber.HeaderETag)); resp.StatusCode != fiber.StatusNotModified {
+		t.Errorf("expected an unchanged widget to be not modified, got %d", resp.StatusCode)
+	}
+
+	resp, body := get("?format=json", "")
+	var out struct {
+		Qsos []sharedQso `json:"qsos"`
+	}
+	if err = json.Unmarshal([]byte(body), &out); err != nil || resp.StatusCode != fiber.StatusOK || len(out.Qsos) != 3 {
+		t.Errorf("expected all three QSOs as JSON, got %d %s", resp.StatusCode, body)
+	}
+
+	for _, query := range []string{"?n=0", "?format=xml"} {
+		if resp, _ = get(query, ""); resp.StatusCode != fiber.StatusBadRequest {
+			t.Errorf("%s: expected 400, got %d", query, resp.StatusCode)
+		}
+	}
+}